@@ -42,4 +42,16 @@ cp -f bazel-bin/api/envoy/http/backend_auth/*/config_go_proto%/github.com/Google
 # HTTP filter backend_routing
 bazel build //api/envoy/http/backend_routing:config_go_proto
 mkdir -p src/go/proto/api/envoy/http/backend_routing
-cp -f bazel-bin/api/envoy/http/backend_routing/*/config_go_proto%/github.com/GoogleCloudPlatform/esp-v2/src/go/proto/api/envoy/http/backend_routing/* src/go/proto/api/envoy/http/backend_routing
\ No newline at end of file
+cp -f bazel-bin/api/envoy/http/backend_routing/*/config_go_proto%/github.com/GoogleCloudPlatform/esp-v2/src/go/proto/api/envoy/http/backend_routing/* src/go/proto/api/envoy/http/backend_routing
+# HTTP filter response_cache
+bazel build //api/envoy/http/response_cache:config_go_proto
+mkdir -p src/go/proto/api/envoy/http/response_cache
+cp -f bazel-bin/api/envoy/http/response_cache/*/config_go_proto%/github.com/GoogleCloudPlatform/esp-v2/src/go/proto/api/envoy/http/response_cache/* src/go/proto/api/envoy/http/response_cache
+# HTTP filter error_response
+bazel build //api/envoy/http/error_response:config_go_proto
+mkdir -p src/go/proto/api/envoy/http/error_response
+cp -f bazel-bin/api/envoy/http/error_response/*/config_go_proto%/github.com/GoogleCloudPlatform/esp-v2/src/go/proto/api/envoy/http/error_response/* src/go/proto/api/envoy/http/error_response
+# HTTP filter ip_acl
+bazel build //api/envoy/http/ip_acl:config_go_proto
+mkdir -p src/go/proto/api/envoy/http/ip_acl
+cp -f bazel-bin/api/envoy/http/ip_acl/*/config_go_proto%/github.com/GoogleCloudPlatform/esp-v2/src/go/proto/api/envoy/http/ip_acl/* src/go/proto/api/envoy/http/ip_acl
\ No newline at end of file
@@ -1219,6 +1219,7 @@ Status RequestBuilder::FillReportRequest(
         if (!status.ok()) return status;
       }
     }
+    labels->insert(info.custom_labels.begin(), info.custom_labels.end());
 
     // Not to send consumer metrics if api_key is empty.
     // api_key is empty in one of following cases:
@@ -1280,6 +1281,7 @@ Status RequestBuilder::AppendByConsumerOperations(
         if (!status.ok()) return status;
       }
     }
+    labels->insert(info.custom_labels.begin(), info.custom_labels.end());
 
     // Populate all metrics.
     for (auto it = metrics_.begin(), end = metrics_.end(); it != end; it++) {
@@ -369,6 +369,22 @@ TEST_F(RequestBuilderTest, FillReportRequestEmptyOptionalTest) {
   ASSERT_EQ(expected_text, text);
 }
 
+TEST_F(RequestBuilderTest, FillReportRequestCustomLabelsTest) {
+  ReportRequestInfo info;
+  FillOperationInfo(&info);
+  info.check_response_info.consumer_project_id = "12345";
+  info.custom_labels["tenant_id"] = "tenant-1";
+
+  gasv1::ReportRequest request;
+  ASSERT_TRUE(scp_.FillReportRequest(info, &request).ok());
+
+  // The labels are set on the operations of the producer and the consumer.
+  ASSERT_EQ(request.operations_size(), 2);
+  for (const auto& operation : request.operations()) {
+    ASSERT_EQ(operation.labels().at("tenant_id"), "tenant-1");
+  }
+}
+
 TEST_F(RequestBuilderTest, CredentailIdApiKeyTest) {
   ReportRequestInfo info;
   FillOperationInfo(&info);
@@ -18,6 +18,7 @@
 #include "google/protobuf/stubs/status.h"
 
 #include <chrono>
+#include <map>
 #include <memory>
 #include <string>
 
@@ -185,6 +186,10 @@ struct ReportRequestInfo : public OperationInfo {
   // The jwt payloads logged
   std::string jwt_payloads;
 
+  // The custom labels of the operations, from the request headers or the
+  // claims of the verified JWT.
+  std::map<std::string, std::string> custom_labels;
+
   // number of messages for a stream.
   int64_t streaming_request_message_counts;
 
@@ -14,12 +14,14 @@
 
 #include <memory>
 
+#include "absl/strings/str_join.h"
 #include "src/envoy/http/backend_auth/config_parser_impl.h"
 namespace Envoy {
 namespace Extensions {
 namespace HttpFilters {
 namespace BackendAuth {
 
+using ::google::api::envoy::http::backend_auth::BackendAuthRule;
 using ::google::api::envoy::http::backend_auth::FilterConfig;
 using ::google::api::envoy::http::common::AccessToken;
 using Token::GetTokenFunc;
@@ -29,6 +31,25 @@ using Token::UpdateTokenCallback;
 
 // TODO(kyuc): add unit tests for all possible backend rule configs.
 
+namespace {
+
+// Rules with an OAuth2 access token are keyed by its uri, which is unique per
+// backend. The other rules are keyed by the audience and the delegates, as the
+// identity tokens of the same audience differ per delegates.
+std::string ruleKey(const BackendAuthRule& rule) {
+  if (rule.token_info_case() == BackendAuthRule::kAccessTokenUri) {
+    return rule.access_token_uri().uri();
+  }
+  if (rule.delegates().empty()) {
+    return rule.jwt_audience();
+  }
+  // The audience is a uri, so it does not contain the space.
+  return absl::StrCat(rule.jwt_audience(),
+                      " delegates=", absl::StrJoin(rule.delegates(), ","));
+}
+
+}  // namespace
+
 AudienceContext::AudienceContext(
     const ::google::api::envoy::http::backend_auth::BackendAuthRule&
         proto_config,
@@ -48,6 +69,16 @@ AudienceContext::AudienceContext(
     });
   };
 
+  // The OAuth2 access token is fetched from the token agent, instead of an
+  // identity token for the audience.
+  if (proto_config.token_info_case() == BackendAuthRule::kAccessTokenUri) {
+    const std::string& uri = proto_config.access_token_uri().uri();
+    const std::string& cluster = proto_config.access_token_uri().cluster();
+    access_token_sub_ptr_ = token_subscriber_factory.createImdsTokenSubscriber(
+        TokenType::AccessToken, cluster, uri, callback);
+    return;
+  }
+
   switch (filter_config.id_token_info_case()) {
     case FilterConfig::kIamToken: {
       const std::string& uri = filter_config.iam_token().iam_uri().uri();
@@ -55,8 +86,11 @@ AudienceContext::AudienceContext(
           filter_config.iam_token().iam_uri().cluster();
       const std::string real_uri =
           absl::StrCat(uri, "?audience=", proto_config.jwt_audience());
+      // The delegates of the rule override the ones of the filter.
       const ::google::protobuf::RepeatedPtrField<std::string>& delegates =
-          filter_config.iam_token().delegates();
+          proto_config.delegates().empty()
+              ? filter_config.iam_token().delegates()
+              : proto_config.delegates();
       iam_token_sub_ptr_ = token_subscriber_factory.createIamTokenSubscriber(
           TokenType::IdentityToken, cluster, real_uri, callback, delegates,
           ::google::protobuf::RepeatedPtrField<std::string>(), access_token_fn);
@@ -107,10 +141,11 @@ FilterConfigParserImpl::FilterConfigParserImpl(
   }
 
   for (const auto& rule : config.rules()) {
-    operation_map_[rule.operation()] = rule.jwt_audience();
-    auto it = audience_map_.find(rule.jwt_audience());
+    const std::string key = ruleKey(rule);
+    operation_map_[rule.operation()] = key;
+    auto it = audience_map_.find(key);
     if (it == audience_map_.end()) {
-      audience_map_[rule.jwt_audience()] = AudienceContextPtr(
+      audience_map_[key] = AudienceContextPtr(
           new AudienceContext(rule, context, config, token_subscriber_factory,
                               [this]() { return access_token_; }));
     }
@@ -52,6 +52,7 @@ class AudienceContext {
   ThreadLocal::SlotPtr tls_;
   Token::TokenSubscriberPtr iam_token_sub_ptr_;
   Token::TokenSubscriberPtr imds_token_sub_ptr_;
+  Token::TokenSubscriberPtr access_token_sub_ptr_;
 };
 
 typedef std::unique_ptr<AudienceContext> AudienceContextPtr;
@@ -12,6 +12,7 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 #include "src/envoy/http/backend_auth/config_parser_impl.h"
+#include "absl/strings/str_join.h"
 #include "gmock/gmock.h"
 #include "google/protobuf/text_format.h"
 #include "gtest/gtest.h"
@@ -195,6 +196,132 @@ rules {
   EXPECT_EQ(*config_parser_->getJwtToken("audience-bar"), "id-token-bar");
 }
 
+TEST_F(ConfigParserImplTest, GetIdTokenByIamWithRuleDelegates) {
+  const char filter_config[] = R"(
+iam_token {
+  access_token {
+    remote_token {
+      uri: "this-is-imds-uri"
+      cluster: "this-is-imds-cluster"
+    }
+  }
+  iam_uri {
+    uri: "this-is-iam-uri"
+    cluster: "this-is-iam-cluster"
+  }
+  delegates: "filter-delegate"
+}
+rules {
+  operation: "operation-foo"
+  jwt_audience: "audience-foo"
+}
+rules {
+  operation: "operation-bar"
+  jwt_audience: "audience-foo"
+  delegates: "delegate-bar-1"
+  delegates: "delegate-bar-2"
+}
+rules {
+  operation: "operation-baz"
+  jwt_audience: "audience-foo"
+  delegates: "delegate-bar-1"
+  delegates: "delegate-bar-2"
+}
+)";
+  // The same audience with different delegates gets a separate token.
+  std::vector<std::string> got_delegates;
+  EXPECT_CALL(mock_token_subscriber_factory_,
+              createIamTokenSubscriber(_, "this-is-iam-cluster",
+                                       "this-is-iam-uri?audience=audience-foo",
+                                       _, _, _, _))
+      .Times(2)
+      .WillRepeatedly(Invoke(
+          [&got_delegates](
+              Token::TokenType, const std::string&, const std::string&,
+              Token::UpdateTokenCallback callback,
+              const ::google::protobuf::RepeatedPtrField<std::string>&
+                  delegates,
+              const ::google::protobuf::RepeatedPtrField<std::string>&,
+              Token::GetTokenFunc) -> Token::TokenSubscriberPtr {
+            const std::string joined = absl::StrJoin(delegates, ",");
+            got_delegates.push_back(joined);
+            callback(absl::StrCat("id-token-", joined));
+            return nullptr;
+          }));
+
+  setUp(filter_config);
+
+  EXPECT_EQ(got_delegates,
+            std::vector<std::string>(
+                {"filter-delegate", "delegate-bar-1,delegate-bar-2"}));
+
+  const absl::string_view foo = config_parser_->getAudience("operation-foo");
+  const absl::string_view bar = config_parser_->getAudience("operation-bar");
+  EXPECT_EQ(foo, "audience-foo");
+  EXPECT_NE(foo, bar);
+  EXPECT_EQ(config_parser_->getAudience("operation-baz"), bar);
+
+  EXPECT_EQ(*config_parser_->getJwtToken(foo), "id-token-filter-delegate");
+  EXPECT_EQ(*config_parser_->getJwtToken(bar),
+            "id-token-delegate-bar-1,delegate-bar-2");
+}
+
+TEST_F(ConfigParserImplTest, GetAccessTokenFromTokenAgent) {
+  const char filter_config[] = R"(
+imds_token {
+  uri: "this-is-uri"
+  cluster: "this-is-cluster"
+}
+rules {
+  operation: "operation-foo"
+  jwt_audience: "audience-foo"
+}
+rules {
+  operation: "operation-bar"
+  access_token_uri {
+    uri: "this-is-token-agent-uri"
+    cluster: "this-is-token-agent-cluster"
+  }
+}
+)";
+  const std::string id_token_foo("id-token-foo");
+  const std::string access_token_bar("access-token-bar");
+
+  EXPECT_CALL(mock_token_subscriber_factory_,
+              createImdsTokenSubscriber(
+                  Token::TokenType::IdentityToken, "this-is-cluster",
+                  "this-is-uri?format=standard&audience=audience-foo", _))
+      .WillOnce(Invoke([&id_token_foo](const Token::TokenType&,
+                                       const std::string&, const std::string&,
+                                       Token::UpdateTokenCallback callback)
+                           -> Token::TokenSubscriberPtr {
+        callback(id_token_foo);
+        return nullptr;
+      }));
+  EXPECT_CALL(mock_token_subscriber_factory_,
+              createImdsTokenSubscriber(Token::TokenType::AccessToken,
+                                        "this-is-token-agent-cluster",
+                                        "this-is-token-agent-uri", _))
+      .WillOnce(Invoke([&access_token_bar](const Token::TokenType&,
+                                           const std::string&,
+                                           const std::string&,
+                                           Token::UpdateTokenCallback callback)
+                           -> Token::TokenSubscriberPtr {
+        callback(access_token_bar);
+        return nullptr;
+      }));
+
+  setUp(filter_config);
+
+  EXPECT_EQ(config_parser_->getAudience("operation-foo"), "audience-foo");
+  EXPECT_EQ(config_parser_->getAudience("operation-bar"),
+            "this-is-token-agent-uri");
+
+  EXPECT_EQ(*config_parser_->getJwtToken("audience-foo"), "id-token-foo");
+  EXPECT_EQ(*config_parser_->getJwtToken("this-is-token-agent-uri"),
+            "access-token-bar");
+}
+
 }  // namespace BackendAuth
 }  // namespace HttpFilters
 }  // namespace Extensions
@@ -58,7 +58,20 @@ FilterHeadersStatus Filter::decodeHeaders(Http::RequestHeaderMap& headers,
             operation, original_path);
   std::string newPath;
 
-  if (rule->is_const_address()) {  // CONSTANT_ADDRESS
+  const RE2* regex = config_->findRegex(operation);
+  if (regex != nullptr) {  // Regex rewrite
+    const auto originalPath = std::string(original_path);
+    std::size_t queryParamPos = originalPath.find('?');
+    newPath = originalPath.substr(0, queryParamPos);
+    RE2::GlobalReplace(&newPath, *regex, rule->regex_substitution());
+    if (queryParamPos != std::string::npos) {
+      absl::StrAppend(&newPath, originalPath.substr(queryParamPos));
+    }
+    config_->stats().regex_rewrite_request_.inc();
+    ENVOY_LOG(debug,
+              "regex rewrite backend routing for operation {}, new path: {}",
+              operation, newPath);
+  } else if (rule->is_const_address()) {  // CONSTANT_ADDRESS
     absl::string_view queryParamFromPathParam =
         Utils::getStringFilterState(filter_state, Utils::kQueryParams);
     const auto originalPath = std::string(original_path);
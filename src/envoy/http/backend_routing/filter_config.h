@@ -16,7 +16,9 @@
 
 #include "api/envoy/http/backend_routing/config.pb.h"
 #include "common/common/logger.h"
+#include "common/protobuf/utility.h"
 #include "envoy/server/filter_config.h"
+#include "re2/re2.h"
 
 namespace Envoy {
 namespace Extensions {
@@ -31,6 +33,7 @@ namespace BackendRouting {
 #define ALL_BACKEND_ROUTING_FILTER_STATS(COUNTER)     \
   COUNTER(append_path_to_address_request)             \
   COUNTER(constant_address_request)                   \
+  COUNTER(regex_rewrite_request)                      \
 // clang-format on
 
 /**
@@ -51,6 +54,15 @@ class FilterConfig : public Logger::Loggable<Logger::Id::filter> {
         stats_(generateStats(stats_prefix, context.scope())) {
     for (const auto& rule : proto_config_.rules()) {
       backend_routing_map_[rule.operation()] = &rule;
+      if (rule.regex().empty()) {
+        continue;
+      }
+      auto regex = std::make_unique<RE2>(rule.regex());
+      if (!regex->ok()) {
+        throw ProtoValidationException(
+            absl::StrCat("Invalid regex: ", regex->error()), rule);
+      }
+      regex_map_[rule.operation()] = std::move(regex);
     }
   }
 
@@ -63,6 +75,16 @@ class FilterConfig : public Logger::Loggable<Logger::Id::filter> {
     return it->second;
   }
 
+  // The compiled regex of the rule of the operation, nullptr if the path is
+  // not rewritten with a regex.
+  const RE2* findRegex(absl::string_view operation) const {
+    const auto it = regex_map_.find(operation);
+    if (it == regex_map_.end()) {
+      return nullptr;
+    }
+    return it->second.get();
+  }
+
   FilterStats& stats() { return stats_; }
 
  private:
@@ -81,6 +103,8 @@ class FilterConfig : public Logger::Loggable<Logger::Id::filter> {
       std::string,
       const ::google::api::envoy::http::backend_routing::BackendRoutingRule*>
       backend_routing_map_;
+  // The map from operation to the compiled regex of its rule.
+  absl::flat_hash_map<std::string, std::unique_ptr<RE2>> regex_map_;
 };
 
 typedef std::shared_ptr<FilterConfig> FilterConfigSharedPtr;
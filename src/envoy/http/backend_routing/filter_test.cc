@@ -59,6 +59,11 @@ rules {
   is_const_address: true
   path_prefix: ""
 }
+rules {
+  operation: "regex-operation"
+  regex: "^/books/([^/]+)$"
+  regex_substitution: "/api/book/\\1"
+}
 )";
 
 /**
@@ -194,6 +199,54 @@ TEST_F(BackendRoutingFilterTest, ConstantAddressWithPathMatcherQueryParams) {
 class BackendRoutingFilterWithQueryParamsTest
     : public BackendRoutingFilterTest {};
 
+TEST_F(BackendRoutingFilterTest, RegexRewrite) {
+  Http::TestRequestHeaderMapImpl headers{{":method", "GET"},
+                                         {":path", "/books/1"}};
+  Utils::setStringFilterState(
+      *mock_decoder_callbacks_.stream_info_.filter_state_, Utils::kOperation,
+      "regex-operation");
+
+  // Call function under test
+  Envoy::Http::FilterHeadersStatus status =
+      filter_->decodeHeaders(headers, false);
+
+  // Expect the path to be rewritten with the capture group.
+  ASSERT_EQ(headers.Path()->value().getStringView(), "/api/book/1");
+  ASSERT_EQ(status, Envoy::Http::FilterHeadersStatus::Continue);
+}
+
+TEST_F(BackendRoutingFilterTest, RegexRewriteNoMatch) {
+  Http::TestRequestHeaderMapImpl headers{{":method", "GET"},
+                                         {":path", "/books/1/chapters"}};
+  Utils::setStringFilterState(
+      *mock_decoder_callbacks_.stream_info_.filter_state_, Utils::kOperation,
+      "regex-operation");
+
+  // Call function under test
+  Envoy::Http::FilterHeadersStatus status =
+      filter_->decodeHeaders(headers, false);
+
+  // Expect the path to NOT be modified.
+  ASSERT_EQ(headers.Path()->value().getStringView(), "/books/1/chapters");
+  ASSERT_EQ(status, Envoy::Http::FilterHeadersStatus::Continue);
+}
+
+TEST_F(BackendRoutingFilterTest, InvalidRegex) {
+  const char filter_config[] = R"(
+rules {
+  operation: "bad-regex-operation"
+  regex: "^/books/([^/]+$"
+}
+)";
+  google::api::envoy::http::backend_routing::FilterConfig proto_config;
+  ASSERT_TRUE(google::protobuf::TextFormat::ParseFromString(filter_config,
+                                                            &proto_config));
+
+  EXPECT_THROW_WITH_REGEX(
+      FilterConfig cfg(proto_config, "test-stats", mock_factory_context_),
+      ProtoValidationException, "Invalid regex");
+}
+
 TEST_F(BackendRoutingFilterWithQueryParamsTest, AppendPathToAddress) {
   Http::TestRequestHeaderMapImpl headers{
       {":method", "GET"}, {":path", "/books/1?view=summary&filter=deleted"}};
@@ -0,0 +1,134 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+#include "src/envoy/http/error_response/filter.h"
+
+#include "absl/strings/str_cat.h"
+#include "absl/strings/str_replace.h"
+#include "common/http/codes.h"
+#include "common/http/headers.h"
+#include "common/http/utility.h"
+#include "common/protobuf/utility.h"
+
+namespace Envoy {
+namespace Extensions {
+namespace HttpFilters {
+namespace ErrorResponse {
+
+using ::google::api::envoy::http::error_response::ErrorTemplate;
+using ErrorResponseConfig =
+    ::google::api::envoy::http::error_response::FilterConfig;
+using Http::FilterDataStatus;
+using Http::FilterHeadersStatus;
+
+namespace {
+
+const std::string kApplicationJson = "application/json";
+const std::string kApplicationProblemJson = "application/problem+json";
+
+// Returns the value as a JSON string, with its quotes.
+std::string jsonString(absl::string_view value) {
+  return MessageUtil::getJsonStringFromMessage(
+      ValueUtil::stringValue(std::string(value)));
+}
+
+// Returns the value as a JSON string, without its quotes.
+std::string escapeJson(absl::string_view value) {
+  const std::string quoted = jsonString(value);
+  return quoted.substr(1, quoted.size() - 2);
+}
+
+}  // namespace
+
+FilterHeadersStatus Filter::encodeHeaders(Http::ResponseHeaderMap& headers,
+                                          bool end_stream) {
+  if (end_stream) {
+    return FilterHeadersStatus::Continue;
+  }
+  const uint64_t status = Http::Utility::getResponseStatus(headers);
+  if (status < 400) {
+    return FilterHeadersStatus::Continue;
+  }
+
+  // The responses of the backends and the ones already formatted, like gRPC
+  // errors, are not rewritten.
+  const auto& details = encoder_callbacks_->streamInfo().responseCodeDetails();
+  if (!details.has_value() ||
+      details.value() == StreamInfo::ResponseCodeDetails::get().ViaUpstream) {
+    return FilterHeadersStatus::Continue;
+  }
+  if (headers.ContentType() == nullptr ||
+      headers.ContentType()->value().getStringView() !=
+          Http::Headers::get().ContentTypeValues.Text) {
+    return FilterHeadersStatus::Continue;
+  }
+  error_template_ = config_->findTemplate(status);
+  if (error_template_ == nullptr &&
+      config_->format() == ErrorResponseConfig::TEXT) {
+    return FilterHeadersStatus::Continue;
+  }
+
+  ENVOY_LOG(debug, "rewriting the error response with status {}: {}", status,
+            details.value());
+  headers_ = &headers;
+  status_ = status;
+  details_ = details.value();
+  return FilterHeadersStatus::StopIteration;
+}
+
+FilterDataStatus Filter::encodeData(Buffer::Instance& data, bool end_stream) {
+  if (headers_ == nullptr) {
+    return FilterDataStatus::Continue;
+  }
+  message_.append(data.toString());
+  data.drain(data.length());
+  if (!end_stream) {
+    return FilterDataStatus::StopIterationNoBuffer;
+  }
+
+  const std::string body = makeBody(error_template_, message_);
+  headers_->setContentLength(body.size());
+  data.add(body);
+  config_->stats().rewritten_.inc();
+  return FilterDataStatus::Continue;
+}
+
+std::string Filter::makeBody(const ErrorTemplate* error_template,
+                             const std::string& message) {
+  if (error_template != nullptr) {
+    headers_->setContentType(error_template->content_type().empty()
+                                 ? kApplicationJson
+                                 : error_template->content_type());
+    return absl::StrReplaceAll(error_template->body(),
+                               {{"%CODE%", std::to_string(status_)},
+                                {"%MESSAGE%", escapeJson(message)},
+                                {"%DETAILS%", escapeJson(details_)}});
+  }
+
+  if (config_->format() == ErrorResponseConfig::PROBLEM_JSON) {
+    headers_->setContentType(kApplicationProblemJson);
+    return absl::StrCat(
+        R"({"type":"about:blank","title":)",
+        jsonString(Http::CodeUtility::toString(Http::Code(status_))),
+        R"(,"status":)", status_, R"(,"detail":)", jsonString(message), "}");
+  }
+  headers_->setContentType(kApplicationJson);
+  return absl::StrCat(R"({"code":)", status_, R"(,"message":)",
+                      jsonString(message), R"(,"details":)",
+                      jsonString(details_), "}");
+}
+
+}  // namespace ErrorResponse
+}  // namespace HttpFilters
+}  // namespace Extensions
+}  // namespace Envoy
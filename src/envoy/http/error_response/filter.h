@@ -0,0 +1,65 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+#pragma once
+
+#include <string>
+
+#include "common/common/logger.h"
+#include "envoy/http/filter.h"
+#include "envoy/http/header_map.h"
+#include "extensions/filters/http/common/pass_through_filter.h"
+#include "src/envoy/http/error_response/filter_config.h"
+
+namespace Envoy {
+namespace Extensions {
+namespace HttpFilters {
+namespace ErrorResponse {
+
+// Rewrites the plain text bodies of the 4xx and 5xx responses sent by the
+// proxy, with the template of their status code or the format of the config.
+// The responses of the backends are sent as is.
+class Filter : public Http::PassThroughEncoderFilter,
+               public Logger::Loggable<Logger::Id::filter> {
+ public:
+  Filter(FilterConfigSharedPtr config) : config_(config) {}
+
+  // Http::StreamEncoderFilter
+  Http::FilterHeadersStatus encodeHeaders(Http::ResponseHeaderMap& headers,
+                                          bool end_stream) override;
+  Http::FilterDataStatus encodeData(Buffer::Instance& data,
+                                    bool end_stream) override;
+
+ private:
+  // Returns the body of the error response with the message, and sets its
+  // content type.
+  std::string makeBody(const ::google::api::envoy::http::error_response::
+                           ErrorTemplate* error_template,
+                       const std::string& message);
+
+  const FilterConfigSharedPtr config_;
+  // The headers of the error response being rewritten, nullptr if it is not.
+  Http::ResponseHeaderMap* headers_ = nullptr;
+  // The template of the error response, nullptr to use the format.
+  const ::google::api::envoy::http::error_response::ErrorTemplate*
+      error_template_ = nullptr;
+  uint64_t status_ = 0;
+  std::string details_;
+  // The original body of the error response, its message.
+  std::string message_;
+};
+
+}  // namespace ErrorResponse
+}  // namespace HttpFilters
+}  // namespace Extensions
+}  // namespace Envoy
@@ -0,0 +1,97 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+#pragma once
+
+#include "absl/container/flat_hash_map.h"
+#include "api/envoy/http/error_response/config.pb.h"
+#include "common/common/logger.h"
+#include "envoy/server/filter_config.h"
+
+namespace Envoy {
+namespace Extensions {
+namespace HttpFilters {
+namespace ErrorResponse {
+
+/**
+ * All stats for the error response filter. @see stats_macros.h
+ */
+
+// clang-format off
+#define ALL_ERROR_RESPONSE_FILTER_STATS(COUNTER)     \
+  COUNTER(rewritten)
+// clang-format on
+
+/**
+ * Wrapper struct for error response filter stats. @see stats_macros.h
+ */
+struct FilterStats {
+  ALL_ERROR_RESPONSE_FILTER_STATS(GENERATE_COUNTER_STRUCT)
+};
+
+// The Envoy filter config for ESPv2 error response filter.
+class FilterConfig : public Logger::Loggable<Logger::Id::filter> {
+ public:
+  FilterConfig(const ::google::api::envoy::http::error_response::FilterConfig&
+                   proto_config,
+               const std::string& stats_prefix,
+               Server::Configuration::FactoryContext& context)
+      : proto_config_(proto_config),
+        stats_(generateStats(stats_prefix, context.scope())) {
+    for (const auto& error_template : proto_config_.templates()) {
+      template_map_[error_template.status()] = &error_template;
+    }
+  }
+
+  // Returns the template of the error responses with the status code,
+  // nullptr if they use the format.
+  const ::google::api::envoy::http::error_response::ErrorTemplate*
+  findTemplate(uint64_t status) const {
+    const auto it = template_map_.find(status);
+    if (it == template_map_.end()) {
+      return nullptr;
+    }
+    return it->second;
+  }
+
+  ::google::api::envoy::http::error_response::FilterConfig::Format format()
+      const {
+    return proto_config_.format();
+  }
+
+  FilterStats& stats() { return stats_; }
+
+ private:
+  FilterStats generateStats(const std::string& prefix, Stats::Scope& scope) {
+    const std::string final_prefix = prefix + "error_response.";
+    return {ALL_ERROR_RESPONSE_FILTER_STATS(
+        POOL_COUNTER_PREFIX(scope, final_prefix))};
+  }
+
+  // The config proto
+  ::google::api::envoy::http::error_response::FilterConfig proto_config_;
+  // The stats
+  FilterStats stats_;
+  // The map from status code to template.
+  absl::flat_hash_map<
+      uint64_t,
+      const ::google::api::envoy::http::error_response::ErrorTemplate*>
+      template_map_;
+};
+
+typedef std::shared_ptr<FilterConfig> FilterConfigSharedPtr;
+
+}  // namespace ErrorResponse
+}  // namespace HttpFilters
+}  // namespace Extensions
+}  // namespace Envoy
@@ -0,0 +1,64 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+#include "api/envoy/http/error_response/config.pb.h"
+#include "api/envoy/http/error_response/config.pb.validate.h"
+#include "envoy/registry/registry.h"
+#include "extensions/filters/http/common/factory_base.h"
+#include "src/envoy/http/error_response/filter.h"
+#include "src/envoy/http/error_response/filter_config.h"
+
+namespace Envoy {
+namespace Extensions {
+namespace HttpFilters {
+namespace ErrorResponse {
+
+const std::string FilterName = "envoy.filters.http.error_response";
+
+/**
+ * Config registration for ESPv2 error response filter.
+ */
+class FilterFactory
+    : public Common::FactoryBase<
+          ::google::api::envoy::http::error_response::FilterConfig> {
+ public:
+  FilterFactory() : FactoryBase(FilterName) {}
+
+ private:
+  Http::FilterFactoryCb createFilterFactoryFromProtoTyped(
+      const ::google::api::envoy::http::error_response::FilterConfig&
+          proto_config,
+      const std::string& stats_prefix,
+      Server::Configuration::FactoryContext& context) override {
+    auto filter_config =
+        std::make_shared<FilterConfig>(proto_config, stats_prefix, context);
+    return
+        [filter_config](Http::FilterChainFactoryCallbacks& callbacks) -> void {
+          callbacks.addStreamEncoderFilter(
+              std::make_shared<Filter>(filter_config));
+        };
+  }
+};
+
+/**
+ * Static registration for the error response filter. @see RegisterFactory.
+ */
+static Registry::RegisterFactory<
+    FilterFactory, Server::Configuration::NamedHttpFilterConfigFactory>
+    register_;
+
+}  // namespace ErrorResponse
+}  // namespace HttpFilters
+}  // namespace Extensions
+}  // namespace Envoy
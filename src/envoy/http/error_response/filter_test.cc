@@ -0,0 +1,152 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+#include "src/envoy/http/error_response/filter.h"
+
+#include "common/buffer/buffer_impl.h"
+#include "gmock/gmock.h"
+#include "google/protobuf/text_format.h"
+#include "gtest/gtest.h"
+#include "test/mocks/http/mocks.h"
+#include "test/mocks/server/mocks.h"
+#include "test/test_common/utility.h"
+
+using ::testing::ReturnRef;
+
+namespace Envoy {
+namespace Extensions {
+namespace HttpFilters {
+namespace ErrorResponse {
+namespace {
+
+const char kFilterConfig[] = R"(
+format: JSON
+templates {
+  status: 429
+  body: "{\"error\":{\"code\":%CODE%,\"message\":\"%MESSAGE%\"}}"
+}
+)";
+
+class ErrorResponseFilterTest : public ::testing::Test {
+ protected:
+  // Sets up the filter with the config in text format.
+  void setUpFilter(const std::string& config) {
+    google::api::envoy::http::error_response::FilterConfig proto_config;
+    ASSERT_TRUE(
+        google::protobuf::TextFormat::ParseFromString(config, &proto_config));
+    config_ = std::make_shared<FilterConfig>(proto_config, "test-stats",
+                                             mock_factory_context_);
+    filter_ = std::make_unique<Filter>(config_);
+    filter_->setEncoderFilterCallbacks(encoder_callbacks_);
+    ON_CALL(encoder_callbacks_.stream_info_, responseCodeDetails())
+        .WillByDefault(ReturnRef(details_));
+  }
+
+  // Sends the response through the filter and returns its body.
+  std::string sendResponse(Http::TestResponseHeaderMapImpl& headers,
+                           const std::string& body) {
+    filter_->encodeHeaders(headers, false);
+    Buffer::OwnedImpl data(body);
+    EXPECT_EQ(filter_->encodeData(data, true),
+              Http::FilterDataStatus::Continue);
+    return data.toString();
+  }
+
+  testing::NiceMock<Envoy::Server::Configuration::MockFactoryContext>
+      mock_factory_context_;
+  testing::NiceMock<Envoy::Http::MockStreamEncoderFilterCallbacks>
+      encoder_callbacks_;
+  absl::optional<std::string> details_;
+  FilterConfigSharedPtr config_;
+  std::unique_ptr<Filter> filter_;
+};
+
+TEST_F(ErrorResponseFilterTest, JsonFormat) {
+  setUpFilter(kFilterConfig);
+  details_ = "path_not_defined";
+  Http::TestResponseHeaderMapImpl headers{
+      {":status", "404"}, {"content-type", "text/plain"}};
+
+  EXPECT_EQ(filter_->encodeHeaders(headers, false),
+            Http::FilterHeadersStatus::StopIteration);
+  Buffer::OwnedImpl data("Path \"/a\" not found");
+  EXPECT_EQ(filter_->encodeData(data, true), Http::FilterDataStatus::Continue);
+
+  const std::string want =
+      R"({"code":404,"message":"Path \"/a\" not found","details":"path_not_defined"})";
+  EXPECT_EQ(data.toString(), want);
+  EXPECT_EQ(headers.get_("content-type"), "application/json");
+  EXPECT_EQ(headers.get_("content-length"), std::to_string(want.size()));
+  EXPECT_EQ(config_->stats().rewritten_.value(), 1);
+}
+
+TEST_F(ErrorResponseFilterTest, ProblemJsonFormat) {
+  setUpFilter("format: PROBLEM_JSON");
+  details_ = "jwt_authn_access_denied";
+  Http::TestResponseHeaderMapImpl headers{
+      {":status", "401"}, {"content-type", "text/plain"}};
+
+  EXPECT_EQ(sendResponse(headers, "Jwt is missing"),
+            R"({"type":"about:blank","title":"Unauthorized","status":401,"detail":"Jwt is missing"})");
+  EXPECT_EQ(headers.get_("content-type"), "application/problem+json");
+}
+
+TEST_F(ErrorResponseFilterTest, Template) {
+  setUpFilter(kFilterConfig);
+  details_ = "request_rate_limited";
+  Http::TestResponseHeaderMapImpl headers{
+      {":status", "429"}, {"content-type", "text/plain"}};
+
+  EXPECT_EQ(sendResponse(headers, "Too \"Many\" Requests"),
+            R"({"error":{"code":429,"message":"Too \"Many\" Requests"}})");
+  EXPECT_EQ(headers.get_("content-type"), "application/json");
+}
+
+TEST_F(ErrorResponseFilterTest, ResponsesNotRewritten) {
+  setUpFilter(kFilterConfig);
+
+  // The error response of the backend.
+  details_ = "via_upstream";
+  Http::TestResponseHeaderMapImpl upstream_headers{
+      {":status", "500"}, {"content-type", "text/plain"}};
+  EXPECT_EQ(filter_->encodeHeaders(upstream_headers, false),
+            Http::FilterHeadersStatus::Continue);
+
+  // A formatted error response of the proxy.
+  details_ = "path_not_defined";
+  Http::TestResponseHeaderMapImpl json_headers{
+      {":status", "404"}, {"content-type", "application/json"}};
+  EXPECT_EQ(filter_->encodeHeaders(json_headers, false),
+            Http::FilterHeadersStatus::Continue);
+
+  // An error response without template nor format.
+  setUpFilter("");
+  Http::TestResponseHeaderMapImpl text_headers{
+      {":status", "404"}, {"content-type", "text/plain"}};
+  EXPECT_EQ(filter_->encodeHeaders(text_headers, false),
+            Http::FilterHeadersStatus::Continue);
+
+  // A successful response.
+  Http::TestResponseHeaderMapImpl ok_headers{{":status", "200"},
+                                             {"content-type", "text/plain"}};
+  EXPECT_EQ(filter_->encodeHeaders(ok_headers, false),
+            Http::FilterHeadersStatus::Continue);
+
+  EXPECT_EQ(config_->stats().rewritten_.value(), 0);
+}
+
+}  // namespace
+}  // namespace ErrorResponse
+}  // namespace HttpFilters
+}  // namespace Extensions
+}  // namespace Envoy
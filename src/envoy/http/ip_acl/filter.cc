@@ -0,0 +1,142 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+
+#include "src/envoy/http/ip_acl/filter.h"
+
+#include "absl/strings/ascii.h"
+#include "absl/strings/str_cat.h"
+#include "absl/strings/str_split.h"
+#include "common/common/empty_string.h"
+#include "common/network/utility.h"
+#include "common/protobuf/protobuf.h"
+#include "envoy/common/exception.h"
+#include "src/envoy/utils/filter_state_utils.h"
+
+namespace Envoy {
+namespace Extensions {
+namespace HttpFilters {
+namespace IpAcl {
+
+namespace {
+
+struct RcDetailsValues {
+  // The client address is not allowed by the IP rule of the operation.
+  const std::string IpDenied = "ip_acl_denied";
+};
+typedef ConstSingleton<RcDetailsValues> RcDetails;
+
+}  // namespace
+
+std::vector<Network::Address::CidrRange> parseRanges(
+    const Protobuf::RepeatedPtrField<std::string>& ranges) {
+  std::vector<Network::Address::CidrRange> parsed;
+  for (const auto& range : ranges) {
+    const auto cidr = Network::Address::CidrRange::create(range);
+    if (!cidr.isValid()) {
+      throw EnvoyException(absl::StrCat("invalid CIDR range: ", range));
+    }
+    parsed.push_back(cidr);
+  }
+  return parsed;
+}
+
+bool inRanges(const std::vector<Network::Address::CidrRange>& ranges,
+              const Network::Address::Instance& address) {
+  for (const auto& range : ranges) {
+    if (range.isInRange(address)) {
+      return true;
+    }
+  }
+  return false;
+}
+
+IpRule::IpRule(const ::google::api::envoy::http::ip_acl::IpRule& rule)
+    : allow_(parseRanges(rule.allow())), deny_(parseRanges(rule.deny())) {}
+
+bool IpRule::allows(const Network::Address::Instance& address) const {
+  if (address.type() != Network::Address::Type::Ip) {
+    return allow_.empty();
+  }
+  if (inRanges(deny_, address)) {
+    return false;
+  }
+  return allow_.empty() || inRanges(allow_, address);
+}
+
+Http::FilterHeadersStatus Filter::decodeHeaders(
+    Http::RequestHeaderMap& headers, bool) {
+  Network::Address::InstanceConstSharedPtr address =
+      decoder_callbacks_->streamInfo().downstreamRemoteAddress();
+  if (config_->hasTrustedProxies()) {
+    const auto client_address = clientAddress(headers);
+    if (client_address != nullptr) {
+      address = client_address;
+      decoder_callbacks_->streamInfo().setDownstreamRemoteAddress(address);
+    }
+  }
+
+  const absl::string_view operation = Utils::getStringFilterState(
+      *decoder_callbacks_->streamInfo().filterState(), Utils::kOperation);
+  const IpRule* rule = config_->findRule(operation);
+  if (rule == nullptr) {
+    return Http::FilterHeadersStatus::Continue;
+  }
+  if (address != nullptr && rule->allows(*address)) {
+    config_->stats().allowed_.inc();
+    return Http::FilterHeadersStatus::Continue;
+  }
+
+  ENVOY_LOG(debug, "Client address {} denied for operation {}",
+            address != nullptr ? address->asString() : EMPTY_STRING,
+            operation);
+  config_->stats().denied_.inc();
+  decoder_callbacks_->sendLocalReply(
+      Http::Code::Forbidden, "Client IP address is not allowed", nullptr,
+      absl::nullopt, RcDetails::get().IpDenied);
+  decoder_callbacks_->streamInfo().setResponseFlag(
+      StreamInfo::ResponseFlag::UnauthorizedExternalService);
+  return Http::FilterHeadersStatus::StopIteration;
+}
+
+Network::Address::InstanceConstSharedPtr Filter::clientAddress(
+    const Http::RequestHeaderMap& headers) const {
+  Network::Address::InstanceConstSharedPtr address =
+      decoder_callbacks_->streamInfo().downstreamRemoteAddress();
+  if (headers.ForwardedFor() == nullptr) {
+    return address;
+  }
+
+  // Each trusted proxy appended the address of its peer to the header.
+  const std::vector<absl::string_view> hops =
+      absl::StrSplit(headers.ForwardedFor()->value().getStringView(), ',');
+  for (auto it = hops.rbegin(); it != hops.rend(); ++it) {
+    if (address == nullptr || !config_->isTrustedProxy(*address)) {
+      break;
+    }
+    const auto hop = Network::Utility::parseInternetAddressNoThrow(
+        std::string(absl::StripAsciiWhitespace(*it)));
+    if (hop == nullptr) {
+      ENVOY_LOG(debug, "Invalid address {} in x-forwarded-for", *it);
+      break;
+    }
+    address = hop;
+  }
+  return address;
+}
+
+}  // namespace IpAcl
+}  // namespace HttpFilters
+}  // namespace Extensions
+}  // namespace Envoy
@@ -0,0 +1,58 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+
+#pragma once
+
+#include <string>
+
+#include "common/common/logger.h"
+#include "envoy/http/filter.h"
+#include "envoy/http/header_map.h"
+#include "extensions/filters/http/common/pass_through_filter.h"
+#include "src/envoy/http/ip_acl/filter_config.h"
+
+namespace Envoy {
+namespace Extensions {
+namespace HttpFilters {
+namespace IpAcl {
+
+// Rejects the requests whose client address is not allowed by the IP rule of
+// their operation. The client address is the downstream remote address, which
+// the HTTP connection manager takes from the x-forwarded-for header when it
+// is configured to trust it, unless it is replaced by the one behind the
+// trusted proxies.
+class Filter : public Http::PassThroughDecoderFilter,
+               public Logger::Loggable<Logger::Id::filter> {
+ public:
+  Filter(FilterConfigSharedPtr config) : config_(config) {}
+
+  // Http::StreamDecoderFilter
+  Http::FilterHeadersStatus decodeHeaders(Http::RequestHeaderMap& headers,
+                                          bool) override;
+
+ private:
+  // Returns the address of the client behind the trusted proxies: the
+  // downstream remote address, or the rightmost address in the
+  // x-forwarded-for header which is not appended by a trusted proxy.
+  Network::Address::InstanceConstSharedPtr clientAddress(
+      const Http::RequestHeaderMap& headers) const;
+
+  const FilterConfigSharedPtr config_;
+};
+
+}  // namespace IpAcl
+}  // namespace HttpFilters
+}  // namespace Extensions
+}  // namespace Envoy
@@ -0,0 +1,135 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+
+#pragma once
+
+#include <vector>
+
+#include "absl/container/flat_hash_map.h"
+#include "api/envoy/http/ip_acl/config.pb.h"
+#include "common/common/logger.h"
+#include "common/network/cidr_range.h"
+#include "common/protobuf/protobuf.h"
+#include "envoy/server/filter_config.h"
+
+namespace Envoy {
+namespace Extensions {
+namespace HttpFilters {
+namespace IpAcl {
+
+/**
+ * All stats for the IP ACL filter. @see stats_macros.h
+ */
+
+// clang-format off
+#define ALL_IP_ACL_FILTER_STATS(COUNTER)     \
+  COUNTER(allowed)                           \
+  COUNTER(denied)
+// clang-format on
+
+/**
+ * Wrapper struct for IP ACL filter stats. @see stats_macros.h
+ */
+struct FilterStats {
+  ALL_IP_ACL_FILTER_STATS(GENERATE_COUNTER_STRUCT)
+};
+
+// Parses the CIDR ranges, throwing an EnvoyException for an invalid one.
+std::vector<Network::Address::CidrRange> parseRanges(
+    const Protobuf::RepeatedPtrField<std::string>& ranges);
+
+// Whether the address is in one of the ranges.
+bool inRanges(const std::vector<Network::Address::CidrRange>& ranges,
+              const Network::Address::Instance& address);
+
+// The parsed CIDR ranges of an IP rule.
+class IpRule {
+ public:
+  explicit IpRule(const ::google::api::envoy::http::ip_acl::IpRule& rule);
+
+  // Whether the requests from the address are allowed: it is in none of the
+  // denied ranges and, if there are allowed ranges, in one of them. The
+  // addresses which are not IP addresses are only allowed without allowed
+  // ranges.
+  bool allows(const Network::Address::Instance& address) const;
+
+ private:
+  std::vector<Network::Address::CidrRange> allow_;
+  std::vector<Network::Address::CidrRange> deny_;
+};
+
+// The Envoy filter config for ESPv2 IP ACL filter.
+class FilterConfig : public Logger::Loggable<Logger::Id::filter> {
+ public:
+  FilterConfig(
+      const ::google::api::envoy::http::ip_acl::FilterConfig& proto_config,
+      const std::string& stats_prefix,
+      Server::Configuration::FactoryContext& context)
+      : proto_config_(proto_config),
+        stats_(generateStats(stats_prefix, context.scope())),
+        trusted_proxies_(parseRanges(proto_config_.trusted_proxies())) {
+    if (proto_config_.has_default_rule()) {
+      default_rule_ = std::make_unique<IpRule>(proto_config_.default_rule());
+    }
+    for (const auto& rule : proto_config_.operation_rules()) {
+      operation_rule_map_[rule.operation()] =
+          std::make_unique<IpRule>(rule.rule());
+    }
+  }
+
+  // The rule of the operation, or the default rule if it has none. nullptr
+  // if its requests are not checked.
+  const IpRule* findRule(absl::string_view operation) const {
+    const auto it = operation_rule_map_.find(operation);
+    if (it == operation_rule_map_.end()) {
+      return default_rule_.get();
+    }
+    return it->second.get();
+  }
+
+  // Whether the client address is taken from the x-forwarded-for header.
+  bool hasTrustedProxies() const { return !trusted_proxies_.empty(); }
+
+  bool isTrustedProxy(const Network::Address::Instance& address) const {
+    return inRanges(trusted_proxies_, address);
+  }
+
+  FilterStats& stats() { return stats_; }
+
+ private:
+  FilterStats generateStats(const std::string& prefix, Stats::Scope& scope) {
+    const std::string final_prefix = prefix + "ip_acl.";
+    return {ALL_IP_ACL_FILTER_STATS(POOL_COUNTER_PREFIX(scope, final_prefix))};
+  }
+
+  // The config proto
+  ::google::api::envoy::http::ip_acl::FilterConfig proto_config_;
+  // The stats
+  FilterStats stats_;
+  // The CIDR ranges of the trusted proxies.
+  std::vector<Network::Address::CidrRange> trusted_proxies_;
+  // The rule of the operations without their own rule.
+  std::unique_ptr<IpRule> default_rule_;
+  // The map from operation to rule.
+  absl::flat_hash_map<std::string, std::unique_ptr<IpRule>>
+      operation_rule_map_;
+};
+
+typedef std::shared_ptr<FilterConfig> FilterConfigSharedPtr;
+
+}  // namespace IpAcl
+}  // namespace HttpFilters
+}  // namespace Extensions
+}  // namespace Envoy
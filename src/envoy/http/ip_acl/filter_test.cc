@@ -0,0 +1,206 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+
+#include "src/envoy/http/ip_acl/filter.h"
+
+#include "common/network/address_impl.h"
+#include "common/network/utility.h"
+#include "gmock/gmock.h"
+#include "google/protobuf/text_format.h"
+#include "gtest/gtest.h"
+#include "src/envoy/utils/filter_state_utils.h"
+#include "test/mocks/http/mocks.h"
+#include "test/mocks/server/mocks.h"
+#include "test/test_common/utility.h"
+
+using ::testing::_;
+using ::testing::SaveArg;
+
+namespace Envoy {
+namespace Extensions {
+namespace HttpFilters {
+namespace IpAcl {
+namespace {
+
+const char kFilterConfig[] = R"(
+default_rule {
+  deny: "10.1.0.0/16"
+}
+operation_rules {
+  operation: "internal-operation"
+  rule {
+    allow: "10.0.0.0/8"
+    allow: "2001:db8::/32"
+    deny: "10.2.0.0/16"
+  }
+}
+)";
+
+class IpAclFilterTest : public ::testing::Test {
+ protected:
+  void SetUp() override {
+    google::api::envoy::http::ip_acl::FilterConfig proto_config;
+    ASSERT_TRUE(google::protobuf::TextFormat::ParseFromString(kFilterConfig,
+                                                              &proto_config));
+    config_ = std::make_shared<FilterConfig>(proto_config, "test-stats",
+                                             mock_factory_context_);
+  }
+
+  // Sends the request of the operation from the address through a new filter
+  // and returns the status of its headers.
+  Http::FilterHeadersStatus sendRequest(
+      absl::string_view operation,
+      Network::Address::InstanceConstSharedPtr address) {
+    testing::NiceMock<Envoy::Http::MockStreamDecoderFilterCallbacks>
+        decoder_callbacks;
+    Utils::setStringFilterState(*decoder_callbacks.stream_info_.filter_state_,
+                                Utils::kOperation, operation);
+    decoder_callbacks.stream_info_.downstream_remote_address_ = address;
+    Filter filter(config_);
+    filter.setDecoderFilterCallbacks(decoder_callbacks);
+
+    Http::TestRequestHeaderMapImpl headers{{":method", "GET"},
+                                           {":path", "/books"}};
+    return filter.decodeHeaders(headers, true);
+  }
+
+  Network::Address::InstanceConstSharedPtr ip(const std::string& address) {
+    return Network::Utility::parseInternetAddress(address);
+  }
+
+  testing::NiceMock<Envoy::Server::Configuration::MockFactoryContext>
+      mock_factory_context_;
+  FilterConfigSharedPtr config_;
+};
+
+TEST_F(IpAclFilterTest, DefaultRule) {
+  EXPECT_EQ(sendRequest("other-operation", ip("10.3.0.1")),
+            Http::FilterHeadersStatus::Continue);
+  EXPECT_EQ(sendRequest("other-operation", ip("10.1.0.1")),
+            Http::FilterHeadersStatus::StopIteration);
+
+  EXPECT_EQ(config_->stats().allowed_.value(), 1);
+  EXPECT_EQ(config_->stats().denied_.value(), 1);
+}
+
+TEST_F(IpAclFilterTest, OperationRule) {
+  EXPECT_EQ(sendRequest("internal-operation", ip("10.1.0.1")),
+            Http::FilterHeadersStatus::Continue);
+  EXPECT_EQ(sendRequest("internal-operation", ip("2001:db8::1")),
+            Http::FilterHeadersStatus::Continue);
+  // The denied ranges take precedence over the allowed ones.
+  EXPECT_EQ(sendRequest("internal-operation", ip("10.2.0.1")),
+            Http::FilterHeadersStatus::StopIteration);
+  // The addresses in no allowed range are rejected.
+  EXPECT_EQ(sendRequest("internal-operation", ip("192.168.0.1")),
+            Http::FilterHeadersStatus::StopIteration);
+
+  EXPECT_EQ(config_->stats().allowed_.value(), 2);
+  EXPECT_EQ(config_->stats().denied_.value(), 2);
+}
+
+TEST_F(IpAclFilterTest, NonIpAddress) {
+  auto pipe = std::make_shared<Network::Address::PipeInstance>("/tmp/socket");
+  // Only allowed by the rules without allowed ranges.
+  EXPECT_EQ(sendRequest("other-operation", pipe),
+            Http::FilterHeadersStatus::Continue);
+  EXPECT_EQ(sendRequest("internal-operation", pipe),
+            Http::FilterHeadersStatus::StopIteration);
+}
+
+TEST_F(IpAclFilterTest, RejectedWithForbidden) {
+  testing::NiceMock<Envoy::Http::MockStreamDecoderFilterCallbacks>
+      decoder_callbacks;
+  Utils::setStringFilterState(*decoder_callbacks.stream_info_.filter_state_,
+                              Utils::kOperation, "internal-operation");
+  decoder_callbacks.stream_info_.downstream_remote_address_ =
+      ip("192.168.0.1");
+  Filter filter(config_);
+  filter.setDecoderFilterCallbacks(decoder_callbacks);
+
+  EXPECT_CALL(decoder_callbacks,
+              sendLocalReply(Http::Code::Forbidden, _, _, _, "ip_acl_denied"));
+  EXPECT_CALL(decoder_callbacks.stream_info_,
+              setResponseFlag(
+                  StreamInfo::ResponseFlag::UnauthorizedExternalService));
+  Http::TestRequestHeaderMapImpl headers{{":method", "GET"},
+                                         {":path", "/books"}};
+  EXPECT_EQ(filter.decodeHeaders(headers, true),
+            Http::FilterHeadersStatus::StopIteration);
+}
+
+class TrustedProxiesTest : public IpAclFilterTest {
+ protected:
+  // Returns the client address set by the filter for the request from the
+  // peer with the x-forwarded-for header, empty if none.
+  std::string clientAddress(const std::string& peer,
+                            const std::string& forwarded_for) {
+    google::api::envoy::http::ip_acl::FilterConfig proto_config;
+    proto_config.add_trusted_proxies("10.0.0.0/8");
+    proto_config.add_trusted_proxies("192.168.0.0/16");
+    auto config = std::make_shared<FilterConfig>(proto_config, "test-stats",
+                                                 mock_factory_context_);
+
+    testing::NiceMock<Envoy::Http::MockStreamDecoderFilterCallbacks>
+        decoder_callbacks;
+    decoder_callbacks.stream_info_.downstream_remote_address_ = ip(peer);
+    Network::Address::InstanceConstSharedPtr client_address;
+    ON_CALL(decoder_callbacks.stream_info_, setDownstreamRemoteAddress(_))
+        .WillByDefault(SaveArg<0>(&client_address));
+    Filter filter(config);
+    filter.setDecoderFilterCallbacks(decoder_callbacks);
+
+    Http::TestRequestHeaderMapImpl headers{{":method", "GET"},
+                                           {":path", "/books"}};
+    if (!forwarded_for.empty()) {
+      headers.addCopy("x-forwarded-for", forwarded_for);
+    }
+    EXPECT_EQ(filter.decodeHeaders(headers, true),
+              Http::FilterHeadersStatus::Continue);
+    return client_address != nullptr ? client_address->ip()->addressAsString()
+                                     : "";
+  }
+};
+
+TEST_F(TrustedProxiesTest, ClientBehindTrustedProxies) {
+  EXPECT_EQ(clientAddress("10.0.0.1", "1.2.3.4, 192.168.1.1"), "1.2.3.4");
+  // The addresses set by the client are ignored.
+  EXPECT_EQ(clientAddress("10.0.0.1", "5.6.7.8, 1.2.3.4"), "1.2.3.4");
+  // The address of the last trusted proxy if all are trusted.
+  EXPECT_EQ(clientAddress("10.0.0.1", "192.168.1.1"), "192.168.1.1");
+  EXPECT_EQ(clientAddress("10.0.0.1", ""), "10.0.0.1");
+}
+
+TEST_F(TrustedProxiesTest, UntrustedPeer) {
+  EXPECT_EQ(clientAddress("8.8.8.8", "1.2.3.4"), "8.8.8.8");
+}
+
+TEST_F(TrustedProxiesTest, InvalidForwardedAddress) {
+  EXPECT_EQ(clientAddress("10.0.0.1", "1.2.3.4, unknown, 10.0.0.2"),
+            "10.0.0.2");
+}
+
+TEST_F(IpAclFilterTest, InvalidRange) {
+  google::api::envoy::http::ip_acl::FilterConfig proto_config;
+  proto_config.mutable_default_rule()->add_allow("10.0.0.0");
+  EXPECT_THROW(FilterConfig(proto_config, "test-stats", mock_factory_context_),
+               EnvoyException);
+}
+
+}  // namespace
+}  // namespace IpAcl
+}  // namespace HttpFilters
+}  // namespace Extensions
+}  // namespace Envoy
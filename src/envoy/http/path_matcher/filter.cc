@@ -16,11 +16,15 @@
 
 #include "src/envoy/http/path_matcher/filter.h"
 
+#include "common/buffer/buffer_impl.h"
+#include "common/http/header_map_impl.h"
+#include "common/http/headers.h"
 #include "common/http/utility.h"
 #include "src/api_proxy/path_matcher/variable_binding_utils.h"
 #include "src/envoy/utils/filter_state_utils.h"
 #include "src/envoy/utils/http_header_utils.h"
 
+using ::google::api::envoy::http::path_matcher::UnmatchedPath;
 using ::google::api_proxy::path_matcher::VariableBinding;
 using ::google::api_proxy::path_matcher::VariableBindingsToQueryParameters;
 using ::google::protobuf::util::Status;
@@ -46,9 +50,7 @@ Http::FilterHeadersStatus Filter::decodeHeaders(Http::RequestHeaderMap& headers,
   std::string path(headers.Path()->value().getStringView());
   const std::string* operation = config_->findOperation(method, path);
   if (operation == nullptr) {
-    rejectRequest(Http::Code(404),
-                  "Path does not match any requirement URI template.");
-    return Http::FilterHeadersStatus::StopIteration;
+    return handleUnmatchedPath();
   }
 
   ENVOY_LOG(debug, "matched operation: {}", *operation);
@@ -71,6 +73,55 @@ Http::FilterHeadersStatus Filter::decodeHeaders(Http::RequestHeaderMap& headers,
   return Http::FilterHeadersStatus::Continue;
 }
 
+Http::FilterHeadersStatus Filter::handleUnmatchedPath() {
+  const UnmatchedPath& unmatched_path = config_->unmatchedPath();
+  switch (unmatched_path.action_case()) {
+    case UnmatchedPath::kPassThrough:
+      if (unmatched_path.pass_through()) {
+        ENVOY_LOG(debug, "passing through the request of an unmatched path");
+        config_->stats().unmatched_passed_.inc();
+        return Http::FilterHeadersStatus::Continue;
+      }
+      break;
+    case UnmatchedPath::kRedirectUrl: {
+      config_->stats().denied_.inc();
+      const std::string& url = unmatched_path.redirect_url();
+      decoder_callbacks_->sendLocalReply(
+          Http::Code::Found, "",
+          [&url](Http::ResponseHeaderMap& headers) {
+            headers.setLocation(url);
+          },
+          absl::nullopt, RcDetails::get().PathNotDefined);
+      return Http::FilterHeadersStatus::StopIteration;
+    }
+    case UnmatchedPath::kNotFoundBody: {
+      // The response is encoded directly, as sendLocalReply() overrides the
+      // content type of the body.
+      config_->stats().denied_.inc();
+      decoder_callbacks_->streamInfo().setResponseCodeDetails(
+          RcDetails::get().PathNotDefined);
+      auto headers = std::make_unique<Http::ResponseHeaderMapImpl>();
+      headers->setStatus(enumToInt(Http::Code::NotFound));
+      headers->setContentType(unmatched_path.content_type().empty()
+                                  ? Http::Headers::get().ContentTypeValues.Text
+                                  : unmatched_path.content_type());
+      Buffer::OwnedImpl body(unmatched_path.not_found_body());
+      headers->setContentLength(body.length());
+      decoder_callbacks_->encodeHeaders(std::move(headers), body.length() == 0);
+      if (body.length() != 0) {
+        decoder_callbacks_->encodeData(body, true);
+      }
+      return Http::FilterHeadersStatus::StopIteration;
+    }
+    default:
+      break;
+  }
+
+  rejectRequest(Http::Code(404),
+                "Path does not match any requirement URI template.");
+  return Http::FilterHeadersStatus::StopIteration;
+}
+
 void Filter::rejectRequest(Http::Code code, absl::string_view error_msg) {
   config_->stats().denied_.inc();
 
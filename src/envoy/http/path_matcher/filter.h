@@ -35,6 +35,10 @@ class Filter : public Http::PassThroughDecoderFilter,
  private:
   void rejectRequest(Http::Code code, absl::string_view error_msg);
 
+  // Handles a request whose path does not match any rule, as configured by
+  // the unmatched path of the filter config.
+  Http::FilterHeadersStatus handleUnmatchedPath();
+
   const FilterConfigSharedPtr config_;
 };
 
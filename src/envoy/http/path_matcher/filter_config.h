@@ -34,7 +34,8 @@ namespace PathMatcher {
 // clang-format off
 #define ALL_BACKEND_AUTH_FILTER_STATS(COUNTER)     \
   COUNTER(allowed)                                 \
-  COUNTER(denied)
+  COUNTER(denied)                                  \
+  COUNTER(unmatched_passed)
 // clang-format on
 
 /**
@@ -72,6 +73,12 @@ class FilterConfig : public Logger::Loggable<Logger::Id::filter> {
     return operation_it != path_params_operations_.end();
   }
 
+  // Returns the handling of the requests whose path does not match any rule.
+  const ::google::api::envoy::http::path_matcher::UnmatchedPath&
+  unmatchedPath() const {
+    return proto_config_.unmatched_path();
+  }
+
   FilterStats& stats() { return stats_; }
 
   // Returns the mapp from snake-case segment name to JSON name.
@@ -28,6 +28,8 @@ namespace PathMatcher {
 namespace {
 
 using Envoy::Http::MockStreamDecoderFilterCallbacks;
+using ::testing::_;
+using ::testing::Invoke;
 using Envoy::Server::Configuration::MockFactoryContext;
 using ::google::protobuf::TextFormat;
 
@@ -54,9 +56,14 @@ segment_names {
 
 class PathMatcherFilterTest : public ::testing::Test {
  protected:
-  void SetUp() override {
+  void SetUp() override { setUpFilter(""); }
+
+  // Sets up the filter with the unmatched path in text format.
+  void setUpFilter(const std::string& unmatched_path) {
     ::google::api::envoy::http::path_matcher::FilterConfig config_pb;
     ASSERT_TRUE(TextFormat::ParseFromString(kFilterConfig, &config_pb));
+    ASSERT_TRUE(TextFormat::ParseFromString(
+        unmatched_path, config_pb.mutable_unmatched_path()));
     config_ =
         std::make_shared<FilterConfig>(config_pb, "", mock_factory_context_);
 
@@ -165,6 +172,77 @@ TEST_F(PathMatcherFilterTest, DecodeHeadersNoMatch) {
                     ->value());
 }
 
+TEST_F(PathMatcherFilterTest, UnmatchedPathPassThrough) {
+  // Test: a request no match is passed to the following filters
+  setUpFilter("pass_through: true");
+  Http::TestRequestHeaderMapImpl headers{{":method", "GET"},
+                                         {":path", "/docs"}};
+  EXPECT_CALL(mock_cb_, sendLocalReply(_, _, _, _, _)).Times(0);
+
+  EXPECT_EQ(Http::FilterHeadersStatus::Continue,
+            filter_->decodeHeaders(headers, true));
+
+  EXPECT_EQ(Utils::getStringFilterState(*mock_cb_.stream_info_.filter_state_,
+                                        Utils::kOperation),
+            "");
+  EXPECT_EQ(1L, TestUtility::findCounter(mock_factory_context_.scope_,
+                                         "path_matcher.unmatched_passed")
+                    ->value());
+  EXPECT_EQ(0L, TestUtility::findCounter(mock_factory_context_.scope_,
+                                         "path_matcher.denied")
+                    ->value());
+}
+
+TEST_F(PathMatcherFilterTest, UnmatchedPathRedirect) {
+  // Test: a request no match is redirected
+  setUpFilter(R"(redirect_url: "https://docs.example.com/")");
+  Http::TestRequestHeaderMapImpl headers{{":method", "GET"},
+                                         {":path", "/docs"}};
+  EXPECT_CALL(mock_cb_, sendLocalReply(Http::Code::Found, "", _, _, _))
+      .WillOnce(Invoke(
+          [](Http::Code, absl::string_view,
+             std::function<void(Http::ResponseHeaderMap & headers)>
+                 modify_headers,
+             const absl::optional<Grpc::Status::GrpcStatus>,
+             absl::string_view) {
+            Http::TestResponseHeaderMapImpl response_headers;
+            modify_headers(response_headers);
+            EXPECT_EQ(response_headers.get_("location"),
+                      "https://docs.example.com/");
+          }));
+
+  EXPECT_EQ(Http::FilterHeadersStatus::StopIteration,
+            filter_->decodeHeaders(headers, true));
+  EXPECT_EQ(1L, TestUtility::findCounter(mock_factory_context_.scope_,
+                                         "path_matcher.denied")
+                    ->value());
+}
+
+TEST_F(PathMatcherFilterTest, UnmatchedPathNotFoundBody) {
+  // Test: a request no match is rejected with the custom body
+  setUpFilter(R"(
+not_found_body: "{\"error\": \"not found\"}"
+content_type: "application/json")");
+  Http::TestRequestHeaderMapImpl headers{{":method", "GET"},
+                                         {":path", "/docs"}};
+  EXPECT_CALL(mock_cb_, encodeHeaders_(_, false))
+      .WillOnce(Invoke([](Http::ResponseHeaderMap& headers, bool) {
+        EXPECT_EQ(headers.Status()->value().getStringView(), "404");
+        EXPECT_EQ(headers.ContentType()->value().getStringView(),
+                  "application/json");
+      }));
+  EXPECT_CALL(mock_cb_, encodeData(_, true))
+      .WillOnce(Invoke([](Buffer::Instance& data, bool) {
+        EXPECT_EQ(data.toString(), R"({"error": "not found"})");
+      }));
+
+  EXPECT_EQ(Http::FilterHeadersStatus::StopIteration,
+            filter_->decodeHeaders(headers, true));
+  EXPECT_EQ(1L, TestUtility::findCounter(mock_factory_context_.scope_,
+                                         "path_matcher.denied")
+                    ->value());
+}
+
 }  // namespace
 
 }  // namespace PathMatcher
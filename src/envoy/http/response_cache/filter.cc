@@ -0,0 +1,221 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+#include "src/envoy/http/response_cache/filter.h"
+
+#include <string>
+
+#include "absl/strings/str_cat.h"
+#include "common/buffer/buffer_impl.h"
+#include "common/http/header_map_impl.h"
+#include "common/http/headers.h"
+#include "common/http/utility.h"
+#include "common/protobuf/utility.h"
+#include "src/envoy/utils/filter_state_utils.h"
+
+namespace Envoy {
+namespace Extensions {
+namespace HttpFilters {
+namespace ResponseCache {
+
+using Http::FilterDataStatus;
+using Http::FilterHeadersStatus;
+using Http::FilterTrailersStatus;
+
+namespace {
+
+// Separates the parts of the cache key, it is not allowed in them.
+constexpr char kKeySeparator = '\0';
+
+const Http::LowerCaseString& ageHeader() {
+  CONSTRUCT_ON_FIRST_USE(Http::LowerCaseString, "age");
+}
+
+// Returns the value of the header, empty if not set.
+absl::string_view headerValue(const Http::HeaderMap& headers,
+                              const Http::LowerCaseString& name) {
+  const Http::HeaderEntry* entry = headers.get(name);
+  if (entry == nullptr) {
+    return "";
+  }
+  return entry->value().getStringView();
+}
+
+}  // namespace
+
+Filter::Filter(FilterConfigSharedPtr config) : config_(config) {}
+
+FilterHeadersStatus Filter::decodeHeaders(Http::RequestHeaderMap& headers,
+                                          bool) {
+  if (headers.Method() == nullptr || headers.Path() == nullptr ||
+      headers.Method()->value().getStringView() !=
+          Http::Headers::get().MethodValues.Get) {
+    return FilterHeadersStatus::Continue;
+  }
+
+  const absl::string_view operation = Utils::getStringFilterState(
+      *decoder_callbacks_->streamInfo().filterState(), Utils::kOperation);
+  const auto* rule = config_->findRule(operation);
+  if (rule == nullptr) {
+    ENVOY_LOG(debug, "No response cache rule found for operation {}",
+              operation);
+    return FilterHeadersStatus::Continue;
+  }
+
+  const CacheControl cache_control = parseCacheControl(
+      headerValue(headers, Http::Headers::get().CacheControl));
+  if (cache_control.no_store) {
+    ENVOY_LOG(debug, "Response of operation {} not cached for no-store",
+              operation);
+    return FilterHeadersStatus::Continue;
+  }
+
+  key_ = absl::StrCat(operation, std::string(1, kKeySeparator),
+                      headerValue(headers, Http::Headers::get().Host),
+                      std::string(1, kKeySeparator),
+                      headers.Path()->value().getStringView());
+  for (const auto& key_header : rule->key_headers()) {
+    absl::StrAppend(&key_, std::string(1, kKeySeparator), key_header, "=",
+                    headerValue(headers, Http::LowerCaseString(key_header)));
+  }
+
+  if (!cache_control.no_cache) {
+    const CachedResponse* response = config_->cache().lookup(
+        key_, config_->timeSource().monotonicTime());
+    if (response != nullptr) {
+      ENVOY_LOG(debug, "Response of operation {} served from cache",
+                operation);
+      config_->stats().hit_.inc();
+      serveFromCache(*response);
+      return FilterHeadersStatus::StopIteration;
+    }
+  }
+  config_->stats().miss_.inc();
+  rule_ = rule;
+  // The JWT filter removes the Authorization header of the requests to the
+  // operations with a JWT requirement, so the rule tells if they are
+  // authenticated.
+  authenticated_ = rule->jwt_required() || headers.Authorization() != nullptr;
+  return FilterHeadersStatus::Continue;
+}
+
+void Filter::serveFromCache(const CachedResponse& response) {
+  serving_from_cache_ = true;
+  auto headers = std::make_unique<Http::ResponseHeaderMapImpl>();
+  for (const auto& header : response.headers) {
+    headers->addCopy(Http::LowerCaseString(header.first), header.second);
+  }
+  const auto age = std::chrono::duration_cast<std::chrono::seconds>(
+      config_->timeSource().systemTime() - response.response_time);
+  headers->setCopy(ageHeader(), std::to_string(age.count()));
+
+  Buffer::OwnedImpl body(response.body);
+  decoder_callbacks_->encodeHeaders(std::move(headers), body.length() == 0);
+  if (body.length() != 0) {
+    decoder_callbacks_->encodeData(body, true);
+  }
+}
+
+FilterHeadersStatus Filter::encodeHeaders(Http::ResponseHeaderMap& headers,
+                                          bool end_stream) {
+  if (serving_from_cache_ || rule_ == nullptr) {
+    return FilterHeadersStatus::Continue;
+  }
+  // The responses varying with the request headers are not cached, the key
+  // only has the headers of the rule.
+  if (Http::Utility::getResponseStatus(headers) != 200 ||
+      headers.get(Http::Headers::get().SetCookie) != nullptr ||
+      headers.get(Http::Headers::get().Vary) != nullptr) {
+    return FilterHeadersStatus::Continue;
+  }
+
+  const CacheControl cache_control = parseCacheControl(
+      headerValue(headers, Http::Headers::get().CacheControl));
+  if (cache_control.no_cache || cache_control.no_store ||
+      cache_control.is_private) {
+    return FilterHeadersStatus::Continue;
+  }
+  // The responses of the authenticated requests are shared by all the users,
+  // so they are only cached if they allow it, like in RFC 7234 section 3.2.
+  if (authenticated_ && !cache_control.is_public &&
+      !cache_control.has_s_maxage) {
+    ENVOY_LOG(debug,
+              "Response of an authenticated request not cached without "
+              "public or s-maxage");
+    return FilterHeadersStatus::Continue;
+  }
+  std::chrono::seconds ttl;
+  if (rule_->has_ttl()) {
+    ttl = std::chrono::seconds(
+        DurationUtil::durationToSeconds(rule_->ttl()));
+  } else if (cache_control.max_age.has_value()) {
+    ttl = cache_control.max_age.value();
+  } else {
+    return FilterHeadersStatus::Continue;
+  }
+  if (ttl.count() <= 0) {
+    return FilterHeadersStatus::Continue;
+  }
+
+  response_ = std::make_unique<CachedResponse>();
+  headers.iterate(
+      [](const Http::HeaderEntry& header,
+         void* context) -> Http::HeaderMap::Iterate {
+        static_cast<CachedResponse*>(context)->headers.emplace_back(
+            std::string(header.key().getStringView()),
+            std::string(header.value().getStringView()));
+        return Http::HeaderMap::Iterate::Continue;
+      },
+      response_.get());
+  response_->response_time = config_->timeSource().systemTime();
+  response_->expire_time = config_->timeSource().monotonicTime() + ttl;
+  if (end_stream) {
+    insertResponse();
+  }
+  return FilterHeadersStatus::Continue;
+}
+
+FilterDataStatus Filter::encodeData(Buffer::Instance& data, bool end_stream) {
+  if (response_ == nullptr) {
+    return FilterDataStatus::Continue;
+  }
+  if (response_->body.size() + data.length() > config_->maxBodyBytes()) {
+    ENVOY_LOG(debug, "Response not cached, its body is larger than {} bytes",
+              config_->maxBodyBytes());
+    response_.reset();
+    return FilterDataStatus::Continue;
+  }
+  response_->body.append(data.toString());
+  if (end_stream) {
+    insertResponse();
+  }
+  return FilterDataStatus::Continue;
+}
+
+FilterTrailersStatus Filter::encodeTrailers(Http::ResponseTrailerMap&) {
+  // The responses with trailers, like the gRPC ones, are not cached.
+  response_.reset();
+  return FilterTrailersStatus::Continue;
+}
+
+void Filter::insertResponse() {
+  config_->cache().insert(key_, std::move(*response_));
+  config_->stats().store_.inc();
+  response_.reset();
+}
+
+}  // namespace ResponseCache
+}  // namespace HttpFilters
+}  // namespace Extensions
+}  // namespace Envoy
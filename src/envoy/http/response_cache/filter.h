@@ -0,0 +1,75 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+#pragma once
+
+#include <memory>
+#include <string>
+
+#include "common/common/logger.h"
+#include "envoy/http/filter.h"
+#include "envoy/http/header_map.h"
+#include "extensions/filters/http/common/pass_through_filter.h"
+#include "src/envoy/http/response_cache/filter_config.h"
+
+namespace Envoy {
+namespace Extensions {
+namespace HttpFilters {
+namespace ResponseCache {
+
+// Serves the GET requests of the operations with a cache rule from the cache
+// of the worker thread, and caches the responses of the other ones, unless
+// prevented by the Cache-Control headers of the request or of the response.
+class Filter : public Http::PassThroughFilter,
+               public Logger::Loggable<Logger::Id::filter> {
+ public:
+  Filter(FilterConfigSharedPtr config);
+
+  // Http::StreamDecoderFilter
+  Http::FilterHeadersStatus decodeHeaders(Http::RequestHeaderMap& headers,
+                                          bool) override;
+
+  // Http::StreamEncoderFilter
+  Http::FilterHeadersStatus encodeHeaders(Http::ResponseHeaderMap& headers,
+                                          bool end_stream) override;
+  Http::FilterDataStatus encodeData(Buffer::Instance& data,
+                                    bool end_stream) override;
+  Http::FilterTrailersStatus encodeTrailers(
+      Http::ResponseTrailerMap&) override;
+
+ private:
+  // Sends the cached response to the client.
+  void serveFromCache(const CachedResponse& response);
+
+  // Caches the response being received.
+  void insertResponse();
+
+  const FilterConfigSharedPtr config_;
+  // The cache key of the request.
+  std::string key_;
+  // The cache rule of the request, nullptr if its response is not cached.
+  const ::google::api::envoy::http::response_cache::CacheRule* rule_ = nullptr;
+  // Whether the request is authenticated, so its response is only cached if
+  // it is explicitly shared.
+  bool authenticated_ = false;
+  // The response being received, to be cached at its end.
+  std::unique_ptr<CachedResponse> response_;
+  // Whether the response is sent from the cache.
+  bool serving_from_cache_ = false;
+};
+
+}  // namespace ResponseCache
+}  // namespace HttpFilters
+}  // namespace Extensions
+}  // namespace Envoy
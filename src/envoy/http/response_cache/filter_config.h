@@ -0,0 +1,124 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+#pragma once
+
+#include "absl/container/flat_hash_map.h"
+#include "api/envoy/http/response_cache/config.pb.h"
+#include "common/common/logger.h"
+#include "envoy/server/filter_config.h"
+#include "envoy/thread_local/thread_local.h"
+#include "src/envoy/http/response_cache/response_cache.h"
+
+namespace Envoy {
+namespace Extensions {
+namespace HttpFilters {
+namespace ResponseCache {
+
+/**
+ * All stats for the response cache filter. @see stats_macros.h
+ */
+
+// clang-format off
+#define ALL_RESPONSE_CACHE_FILTER_STATS(COUNTER)     \
+  COUNTER(hit)                                       \
+  COUNTER(miss)                                      \
+  COUNTER(store)
+// clang-format on
+
+/**
+ * Wrapper struct for response cache filter stats. @see stats_macros.h
+ */
+struct FilterStats {
+  ALL_RESPONSE_CACHE_FILTER_STATS(GENERATE_COUNTER_STRUCT)
+};
+
+// The cache of the responses of a worker thread.
+class ThreadLocalResponseCache : public ThreadLocal::ThreadLocalObject {
+ public:
+  explicit ThreadLocalResponseCache(uint32_t max_entries)
+      : cache_(max_entries) {}
+
+  ResponseCache& cache() { return cache_; }
+
+ private:
+  ResponseCache cache_;
+};
+
+// The Envoy filter config for ESPv2 response cache filter.
+class FilterConfig : public Logger::Loggable<Logger::Id::filter> {
+ public:
+  FilterConfig(const ::google::api::envoy::http::response_cache::FilterConfig&
+                   proto_config,
+               const std::string& stats_prefix,
+               Server::Configuration::FactoryContext& context)
+      : proto_config_(proto_config),
+        stats_(generateStats(stats_prefix, context.scope())),
+        time_source_(context.timeSource()),
+        tls_(context.threadLocal().allocateSlot()) {
+    for (const auto& rule : proto_config_.rules()) {
+      cache_rule_map_[rule.operation()] = &rule;
+    }
+    const uint32_t max_entries = proto_config_.max_entries();
+    tls_->set([max_entries](Event::Dispatcher&)
+                  -> ThreadLocal::ThreadLocalObjectSharedPtr {
+      return std::make_shared<ThreadLocalResponseCache>(max_entries);
+    });
+  }
+
+  const ::google::api::envoy::http::response_cache::CacheRule* findRule(
+      absl::string_view operation) const {
+    const auto it = cache_rule_map_.find(operation);
+    if (it == cache_rule_map_.end()) {
+      return nullptr;
+    }
+    return it->second;
+  }
+
+  // The cache of the worker thread calling it.
+  ResponseCache& cache() {
+    return tls_->getTyped<ThreadLocalResponseCache>().cache();
+  }
+
+  uint32_t maxBodyBytes() const { return proto_config_.max_body_bytes(); }
+
+  TimeSource& timeSource() { return time_source_; }
+
+  FilterStats& stats() { return stats_; }
+
+ private:
+  FilterStats generateStats(const std::string& prefix, Stats::Scope& scope) {
+    const std::string final_prefix = prefix + "response_cache.";
+    return {ALL_RESPONSE_CACHE_FILTER_STATS(
+        POOL_COUNTER_PREFIX(scope, final_prefix))};
+  }
+
+  // The config proto
+  ::google::api::envoy::http::response_cache::FilterConfig proto_config_;
+  // The stats
+  FilterStats stats_;
+  TimeSource& time_source_;
+  ThreadLocal::SlotPtr tls_;
+  // The map from operation to rule.
+  absl::flat_hash_map<
+      std::string, const ::google::api::envoy::http::response_cache::CacheRule*>
+      cache_rule_map_;
+};
+
+typedef std::shared_ptr<FilterConfig> FilterConfigSharedPtr;
+
+}  // namespace ResponseCache
+}  // namespace HttpFilters
+}  // namespace Extensions
+}  // namespace Envoy
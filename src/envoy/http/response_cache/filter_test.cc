@@ -0,0 +1,256 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+#include "src/envoy/http/response_cache/filter.h"
+
+#include "common/buffer/buffer_impl.h"
+#include "gmock/gmock.h"
+#include "google/protobuf/text_format.h"
+#include "gtest/gtest.h"
+#include "src/envoy/utils/filter_state_utils.h"
+#include "test/mocks/http/mocks.h"
+#include "test/mocks/server/mocks.h"
+#include "test/test_common/utility.h"
+
+using ::testing::_;
+using ::testing::Invoke;
+
+namespace Envoy {
+namespace Extensions {
+namespace HttpFilters {
+namespace ResponseCache {
+namespace {
+
+const char kFilterConfig[] = R"(
+rules {
+  operation: "cached-operation"
+  key_headers: "x-api-key"
+}
+rules {
+  operation: "ttl-operation"
+  ttl {
+    seconds: 60
+  }
+}
+rules {
+  operation: "jwt-operation"
+  jwt_required: true
+}
+max_entries: 10
+max_body_bytes: 8
+)";
+
+class ResponseCacheFilterTest : public ::testing::Test {
+ protected:
+  void SetUp() override {
+    google::api::envoy::http::response_cache::FilterConfig proto_config;
+    ASSERT_TRUE(google::protobuf::TextFormat::ParseFromString(kFilterConfig,
+                                                              &proto_config));
+    config_ = std::make_shared<FilterConfig>(proto_config, "test-stats",
+                                             mock_factory_context_);
+  }
+
+  // Sends the request of the operation through a new filter and returns the
+  // status of its headers. The response is sent back through the filter if
+  // it is not served from the cache.
+  Http::FilterHeadersStatus sendRequest(
+      absl::string_view operation, Http::TestRequestHeaderMapImpl request,
+      Http::TestResponseHeaderMapImpl response, const std::string& body) {
+    testing::NiceMock<Envoy::Http::MockStreamDecoderFilterCallbacks>
+        decoder_callbacks;
+    Utils::setStringFilterState(*decoder_callbacks.stream_info_.filter_state_,
+                                Utils::kOperation, operation);
+    Filter filter(config_);
+    filter.setDecoderFilterCallbacks(decoder_callbacks);
+
+    ON_CALL(decoder_callbacks, encodeHeaders_(_, _))
+        .WillByDefault(Invoke([this](Http::ResponseHeaderMap& headers, bool) {
+          served_headers_ = Http::TestResponseHeaderMapImpl(headers);
+        }));
+    ON_CALL(decoder_callbacks, encodeData(_, _))
+        .WillByDefault(Invoke([this](Buffer::Instance& data, bool) {
+          served_body_ = data.toString();
+        }));
+
+    const Http::FilterHeadersStatus status =
+        filter.decodeHeaders(request, true);
+    if (status == Http::FilterHeadersStatus::Continue) {
+      filter.encodeHeaders(response, body.empty());
+      if (!body.empty()) {
+        Buffer::OwnedImpl data(body);
+        filter.encodeData(data, true);
+      }
+    }
+    return status;
+  }
+
+  testing::NiceMock<Envoy::Server::Configuration::MockFactoryContext>
+      mock_factory_context_;
+  FilterConfigSharedPtr config_;
+  Http::TestResponseHeaderMapImpl served_headers_;
+  std::string served_body_;
+};
+
+TEST_F(ResponseCacheFilterTest, ServeFromCache) {
+  Http::TestRequestHeaderMapImpl request{
+      {":method", "GET"}, {":path", "/books?page=1"}, {"x-api-key", "key"}};
+  Http::TestResponseHeaderMapImpl response{{":status", "200"},
+                                           {"cache-control", "max-age=60"}};
+
+  EXPECT_EQ(sendRequest("cached-operation", request, response, "books"),
+            Http::FilterHeadersStatus::Continue);
+  EXPECT_EQ(sendRequest("cached-operation", request, response, "books"),
+            Http::FilterHeadersStatus::StopIteration);
+
+  EXPECT_EQ(served_headers_.get_(":status"), "200");
+  EXPECT_EQ(served_headers_.get_("cache-control"), "max-age=60");
+  EXPECT_EQ(served_headers_.get_("age"), "0");
+  EXPECT_EQ(served_body_, "books");
+  EXPECT_EQ(config_->stats().hit_.value(), 1);
+  EXPECT_EQ(config_->stats().miss_.value(), 1);
+  EXPECT_EQ(config_->stats().store_.value(), 1);
+}
+
+TEST_F(ResponseCacheFilterTest, KeyHeaders) {
+  Http::TestResponseHeaderMapImpl response{{":status", "200"},
+                                           {"cache-control", "max-age=60"}};
+
+  sendRequest("cached-operation",
+              {{":method", "GET"}, {":path", "/books"}, {"x-api-key", "key1"}},
+              response, "books");
+  // The responses of the other API keys are not shared.
+  EXPECT_EQ(
+      sendRequest(
+          "cached-operation",
+          {{":method", "GET"}, {":path", "/books"}, {"x-api-key", "key2"}},
+          response, "books"),
+      Http::FilterHeadersStatus::Continue);
+}
+
+TEST_F(ResponseCacheFilterTest, TtlOverridesCacheControl) {
+  Http::TestRequestHeaderMapImpl request{{":method", "GET"},
+                                         {":path", "/books"}};
+  // The response without max-age is cached with the TTL of the rule.
+  Http::TestResponseHeaderMapImpl response{{":status", "200"}};
+
+  sendRequest("ttl-operation", request, response, "books");
+  EXPECT_EQ(sendRequest("ttl-operation", request, response, "books"),
+            Http::FilterHeadersStatus::StopIteration);
+}
+
+TEST_F(ResponseCacheFilterTest, NotCached) {
+  Http::TestRequestHeaderMapImpl request{{":method", "GET"},
+                                         {":path", "/books"}};
+  Http::TestResponseHeaderMapImpl response{{":status", "200"},
+                                           {"cache-control", "max-age=60"}};
+
+  // Responses without max-age.
+  sendRequest("cached-operation", request, {{":status", "200"}}, "books");
+  // Responses with no-store, private or a cookie.
+  sendRequest("cached-operation", request,
+              {{":status", "200"}, {"cache-control", "max-age=60, no-store"}},
+              "books");
+  sendRequest("cached-operation", request,
+              {{":status", "200"}, {"cache-control", "private, max-age=60"}},
+              "books");
+  sendRequest("cached-operation", request,
+              {{":status", "200"},
+               {"cache-control", "max-age=60"},
+               {"set-cookie", "a"}},
+              "books");
+  // Errors.
+  sendRequest("cached-operation", request,
+              {{":status", "500"}, {"cache-control", "max-age=60"}}, "error");
+  // Bodies larger than max_body_bytes.
+  sendRequest("cached-operation", request, response, "too large body");
+  // Other methods and operations.
+  sendRequest("cached-operation", {{":method", "POST"}, {":path", "/books"}},
+              response, "books");
+  sendRequest("other-operation", request, response, "books");
+  // Responses varying with the request headers.
+  sendRequest("cached-operation", request,
+              {{":status", "200"},
+               {"cache-control", "max-age=60"},
+               {"vary", "accept-language"}},
+              "books");
+  // Requests with no-store.
+  sendRequest("cached-operation",
+              {{":method", "GET"},
+               {":path", "/books"},
+               {"cache-control", "no-store"}},
+              response, "books");
+
+  EXPECT_EQ(config_->stats().store_.value(), 0);
+  EXPECT_EQ(config_->cache().size(), 0);
+}
+
+TEST_F(ResponseCacheFilterTest, AuthenticatedRequests) {
+  Http::TestRequestHeaderMapImpl request{{":method", "GET"},
+                                         {":path", "/books"}};
+  Http::TestResponseHeaderMapImpl response{{":status", "200"},
+                                           {"cache-control", "max-age=60"}};
+
+  // The responses of the operations with a JWT requirement, and of the
+  // requests with an Authorization header, are not shared by default.
+  sendRequest("jwt-operation", request, response, "books");
+  EXPECT_EQ(sendRequest("jwt-operation", request, response, "books"),
+            Http::FilterHeadersStatus::Continue);
+  sendRequest("cached-operation",
+              {{":method", "GET"},
+               {":path", "/books"},
+               {"authorization", "Bearer token"}},
+              response, "books");
+  EXPECT_EQ(config_->stats().store_.value(), 0);
+
+  // Unless they are public or have s-maxage.
+  sendRequest("jwt-operation", request,
+              {{":status", "200"}, {"cache-control", "public, max-age=60"}},
+              "books");
+  EXPECT_EQ(sendRequest("jwt-operation", request, response, "books"),
+            Http::FilterHeadersStatus::StopIteration);
+  sendRequest("jwt-operation", {{":method", "GET"}, {":path", "/shelves"}},
+              {{":status", "200"}, {"cache-control", "s-maxage=60"}},
+              "shelves");
+  EXPECT_EQ(sendRequest("jwt-operation",
+                        {{":method", "GET"}, {":path", "/shelves"}}, response,
+                        "shelves"),
+            Http::FilterHeadersStatus::StopIteration);
+  EXPECT_EQ(config_->stats().store_.value(), 2);
+}
+
+TEST_F(ResponseCacheFilterTest, RequestNoCache) {
+  Http::TestRequestHeaderMapImpl request{{":method", "GET"},
+                                         {":path", "/books"}};
+  Http::TestResponseHeaderMapImpl response{{":status", "200"},
+                                           {"cache-control", "max-age=60"}};
+
+  sendRequest("cached-operation", request, response, "old");
+  // The request with no-cache is sent to the backend, and its response
+  // replaces the cached one.
+  EXPECT_EQ(sendRequest("cached-operation",
+                        {{":method", "GET"},
+                         {":path", "/books"},
+                         {"cache-control", "no-cache"}},
+                        response, "new"),
+            Http::FilterHeadersStatus::Continue);
+  EXPECT_EQ(sendRequest("cached-operation", request, response, ""),
+            Http::FilterHeadersStatus::StopIteration);
+  EXPECT_EQ(served_body_, "new");
+}
+
+}  // namespace
+}  // namespace ResponseCache
+}  // namespace HttpFilters
+}  // namespace Extensions
+}  // namespace Envoy
@@ -0,0 +1,96 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+#include "src/envoy/http/response_cache/response_cache.h"
+
+#include "absl/strings/ascii.h"
+#include "absl/strings/match.h"
+#include "absl/strings/numbers.h"
+#include "absl/strings/str_split.h"
+
+namespace Envoy {
+namespace Extensions {
+namespace HttpFilters {
+namespace ResponseCache {
+
+const CachedResponse* ResponseCache::lookup(const std::string& key,
+                                            MonotonicTime now) {
+  auto it = index_.find(key);
+  if (it == index_.end()) {
+    return nullptr;
+  }
+  if (it->second->second.expire_time <= now) {
+    entries_.erase(it->second);
+    index_.erase(it);
+    return nullptr;
+  }
+  // Moves the entry to the front, as the most recently used.
+  entries_.splice(entries_.begin(), entries_, it->second);
+  return &it->second->second;
+}
+
+void ResponseCache::insert(const std::string& key, CachedResponse response) {
+  auto it = index_.find(key);
+  if (it != index_.end()) {
+    entries_.erase(it->second);
+    index_.erase(it);
+  }
+  if (entries_.size() >= max_entries_) {
+    index_.erase(entries_.back().first);
+    entries_.pop_back();
+  }
+  entries_.emplace_front(key, std::move(response));
+  index_[key] = entries_.begin();
+}
+
+CacheControl parseCacheControl(absl::string_view value) {
+  CacheControl cache_control;
+  absl::optional<std::chrono::seconds> max_age;
+  absl::optional<std::chrono::seconds> s_maxage;
+  for (absl::string_view directive :
+       absl::StrSplit(value, ',', absl::SkipWhitespace())) {
+    directive = absl::StripAsciiWhitespace(directive);
+    std::pair<absl::string_view, absl::string_view> name_value =
+        absl::StrSplit(directive, absl::MaxSplits('=', 1));
+    const absl::string_view name = absl::StripAsciiWhitespace(name_value.first);
+    // The quotes of the values are optional.
+    const absl::string_view arg = absl::StripSuffix(
+        absl::StripPrefix(absl::StripAsciiWhitespace(name_value.second), "\""),
+        "\"");
+    uint64_t seconds;
+    if (absl::EqualsIgnoreCase(name, "no-cache")) {
+      cache_control.no_cache = true;
+    } else if (absl::EqualsIgnoreCase(name, "no-store")) {
+      cache_control.no_store = true;
+    } else if (absl::EqualsIgnoreCase(name, "private")) {
+      cache_control.is_private = true;
+    } else if (absl::EqualsIgnoreCase(name, "public")) {
+      cache_control.is_public = true;
+    } else if (absl::EqualsIgnoreCase(name, "max-age") &&
+               absl::SimpleAtoi(arg, &seconds)) {
+      max_age = std::chrono::seconds(seconds);
+    } else if (absl::EqualsIgnoreCase(name, "s-maxage") &&
+               absl::SimpleAtoi(arg, &seconds)) {
+      s_maxage = std::chrono::seconds(seconds);
+    }
+  }
+  cache_control.has_s_maxage = s_maxage.has_value();
+  cache_control.max_age = s_maxage.has_value() ? s_maxage : max_age;
+  return cache_control;
+}
+
+}  // namespace ResponseCache
+}  // namespace HttpFilters
+}  // namespace Extensions
+}  // namespace Envoy
@@ -0,0 +1,87 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+#pragma once
+
+#include <chrono>
+#include <list>
+#include <string>
+#include <utility>
+#include <vector>
+
+#include "absl/container/flat_hash_map.h"
+#include "absl/strings/string_view.h"
+#include "absl/types/optional.h"
+#include "envoy/common/time.h"
+
+namespace Envoy {
+namespace Extensions {
+namespace HttpFilters {
+namespace ResponseCache {
+
+// A cached response, with its headers and body.
+struct CachedResponse {
+  std::vector<std::pair<std::string, std::string>> headers;
+  std::string body;
+  // When the response was received, for its Age header.
+  SystemTime response_time;
+  // When the response expires.
+  MonotonicTime expire_time;
+};
+
+// The least recently used cache of the responses of a worker thread, keyed by
+// their request. The expired responses are removed when they are looked up.
+class ResponseCache {
+ public:
+  explicit ResponseCache(uint32_t max_entries) : max_entries_(max_entries) {}
+
+  // Returns the response of the key, nullptr if it is not cached or expired
+  // at now. The response is valid until the next insert.
+  const CachedResponse* lookup(const std::string& key, MonotonicTime now);
+
+  // Caches the response of the key, evicting the least recently used one if
+  // the cache is full.
+  void insert(const std::string& key, CachedResponse response);
+
+  size_t size() const { return entries_.size(); }
+
+ private:
+  using Entry = std::pair<std::string, CachedResponse>;
+
+  const uint32_t max_entries_;
+  // The entries, the most recently used first.
+  std::list<Entry> entries_;
+  absl::flat_hash_map<std::string, std::list<Entry>::iterator> index_;
+};
+
+// The directives of a Cache-Control header used by the cache.
+struct CacheControl {
+  bool no_cache = false;
+  bool no_store = false;
+  bool is_private = false;
+  bool is_public = false;
+  // Whether the s-maxage directive is set, for the shared caches only.
+  bool has_s_maxage = false;
+  // The s-maxage directive, or max-age if not set.
+  absl::optional<std::chrono::seconds> max_age;
+};
+
+// Parses the value of a Cache-Control header, ignoring the unknown and
+// malformed directives.
+CacheControl parseCacheControl(absl::string_view value);
+
+}  // namespace ResponseCache
+}  // namespace HttpFilters
+}  // namespace Extensions
+}  // namespace Envoy
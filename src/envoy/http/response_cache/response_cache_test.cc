@@ -0,0 +1,108 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+#include "src/envoy/http/response_cache/response_cache.h"
+
+#include "gtest/gtest.h"
+
+namespace Envoy {
+namespace Extensions {
+namespace HttpFilters {
+namespace ResponseCache {
+namespace {
+
+CachedResponse makeResponse(const std::string& body,
+                            MonotonicTime expire_time) {
+  CachedResponse response;
+  response.headers = {{":status", "200"}};
+  response.body = body;
+  response.expire_time = expire_time;
+  return response;
+}
+
+TEST(ResponseCacheTest, LookupAndExpire) {
+  const MonotonicTime now;
+  ResponseCache cache(10);
+  cache.insert("key", makeResponse("body", now + std::chrono::seconds(60)));
+
+  const CachedResponse* response = cache.lookup("key", now);
+  ASSERT_NE(response, nullptr);
+  EXPECT_EQ(response->body, "body");
+  EXPECT_EQ(cache.lookup("other-key", now), nullptr);
+
+  // The expired response is removed.
+  EXPECT_EQ(cache.lookup("key", now + std::chrono::seconds(60)), nullptr);
+  EXPECT_EQ(cache.size(), 0);
+}
+
+TEST(ResponseCacheTest, ReplaceResponse) {
+  const MonotonicTime now;
+  ResponseCache cache(10);
+  cache.insert("key", makeResponse("old", now + std::chrono::seconds(60)));
+  cache.insert("key", makeResponse("new", now + std::chrono::seconds(60)));
+
+  EXPECT_EQ(cache.size(), 1);
+  EXPECT_EQ(cache.lookup("key", now)->body, "new");
+}
+
+TEST(ResponseCacheTest, EvictLeastRecentlyUsed) {
+  const MonotonicTime now;
+  const MonotonicTime expire_time = now + std::chrono::seconds(60);
+  ResponseCache cache(2);
+  cache.insert("key1", makeResponse("body1", expire_time));
+  cache.insert("key2", makeResponse("body2", expire_time));
+  // key1 is used, so key2 is the least recently used.
+  ASSERT_NE(cache.lookup("key1", now), nullptr);
+  cache.insert("key3", makeResponse("body3", expire_time));
+
+  EXPECT_EQ(cache.size(), 2);
+  EXPECT_NE(cache.lookup("key1", now), nullptr);
+  EXPECT_EQ(cache.lookup("key2", now), nullptr);
+  EXPECT_NE(cache.lookup("key3", now), nullptr);
+}
+
+TEST(ParseCacheControlTest, Directives) {
+  CacheControl cache_control = parseCacheControl("");
+  EXPECT_FALSE(cache_control.no_cache);
+  EXPECT_FALSE(cache_control.no_store);
+  EXPECT_FALSE(cache_control.is_private);
+  EXPECT_FALSE(cache_control.is_public);
+  EXPECT_FALSE(cache_control.has_s_maxage);
+  EXPECT_FALSE(cache_control.max_age.has_value());
+
+  cache_control = parseCacheControl("No-Cache, no-store,private");
+  EXPECT_TRUE(cache_control.no_cache);
+  EXPECT_TRUE(cache_control.no_store);
+  EXPECT_TRUE(cache_control.is_private);
+
+  cache_control = parseCacheControl("public, max-age=60");
+  EXPECT_TRUE(cache_control.is_public);
+  EXPECT_FALSE(cache_control.has_s_maxage);
+  EXPECT_EQ(cache_control.max_age, std::chrono::seconds(60));
+
+  // s-maxage takes precedence over max-age.
+  cache_control = parseCacheControl("s-maxage=\"120\", max-age=60");
+  EXPECT_TRUE(cache_control.has_s_maxage);
+  EXPECT_EQ(cache_control.max_age, std::chrono::seconds(120));
+
+  // Malformed directives are ignored.
+  cache_control = parseCacheControl("max-age=abc, =, ,");
+  EXPECT_FALSE(cache_control.max_age.has_value());
+}
+
+}  // namespace
+}  // namespace ResponseCache
+}  // namespace HttpFilters
+}  // namespace Extensions
+}  // namespace Envoy
@@ -0,0 +1,111 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+#include "src/envoy/http/service_control/check_cache.h"
+
+#include <map>
+
+#include "absl/strings/str_cat.h"
+
+using ::google::api::servicecontrol::v1::CheckRequest;
+
+namespace Envoy {
+namespace Extensions {
+namespace HttpFilters {
+namespace ServiceControl {
+namespace {
+
+// Separates the fields in the cache key, it is not allowed in them.
+constexpr char kKeySeparator = '\0';
+
+}  // namespace
+
+CheckCache::CheckCache(uint32_t max_entries, uint32_t ttl_ms,
+                       uint32_t negative_ttl_ms, TimeSource& time_source)
+    : max_entries_(max_entries),
+      ttl_(ttl_ms),
+      negative_ttl_(negative_ttl_ms),
+      time_source_(time_source) {}
+
+std::string CheckCache::makeKey(const CheckRequest& request) {
+  const auto& operation = request.operation();
+  std::string key = absl::StrCat(operation.operation_name(),
+                                 std::string(1, kKeySeparator),
+                                 operation.consumer_id());
+  // The labels are sorted to make the key stable.
+  const std::map<std::string, std::string> labels(operation.labels().begin(),
+                                                  operation.labels().end());
+  for (const auto& label : labels) {
+    absl::StrAppend(&key, std::string(1, kKeySeparator), label.first, "=",
+                    label.second);
+  }
+  return key;
+}
+
+CheckCache::Entry* CheckCache::find(const std::string& key) {
+  auto it = index_.find(key);
+  if (it == index_.end()) {
+    return nullptr;
+  }
+  entries_.splice(entries_.begin(), entries_, it->second);
+  return &*it->second;
+}
+
+const CheckCache::Result* CheckCache::lookup(const std::string& key) {
+  const Entry* entry = find(key);
+  if (entry == nullptr ||
+      time_source_.monotonicTime() >= entry->expire_time) {
+    return nullptr;
+  }
+  return &entry->result;
+}
+
+const CheckCache::Result* CheckCache::lookupStale(const std::string& key) {
+  const Entry* entry = find(key);
+  return entry == nullptr ? nullptr : &entry->result;
+}
+
+void CheckCache::insert(const std::string& key, const Result& result) {
+  const auto ttl = result.status.ok() ? ttl_ : negative_ttl_;
+  if (max_entries_ == 0) {
+    return;
+  }
+  if (ttl.count() == 0) {
+    // The previous result is outdated, even to be used as a stale one.
+    auto it = index_.find(key);
+    if (it != index_.end()) {
+      entries_.erase(it->second);
+      index_.erase(it);
+    }
+    return;
+  }
+
+  Entry* entry = find(key);
+  if (entry == nullptr) {
+    if (entries_.size() >= max_entries_) {
+      index_.erase(entries_.back().key);
+      entries_.pop_back();
+    }
+    entries_.push_front(Entry{key, result, MonotonicTime()});
+    index_[key] = entries_.begin();
+    entry = &entries_.front();
+  }
+  entry->result = result;
+  entry->expire_time = time_source_.monotonicTime() + ttl;
+}
+
+}  // namespace ServiceControl
+}  // namespace HttpFilters
+}  // namespace Extensions
+}  // namespace Envoy
@@ -0,0 +1,80 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+#pragma once
+
+#include <list>
+#include <unordered_map>
+
+#include "envoy/common/time.h"
+#include "google/api/servicecontrol/v1/service_controller.pb.h"
+#include "google/protobuf/stubs/status.h"
+#include "src/api_proxy/service_control/request_info.h"
+
+namespace Envoy {
+namespace Extensions {
+namespace HttpFilters {
+namespace ServiceControl {
+
+// The LRU cache of the Check results of a worker thread. The allowed results
+// expire after ttl_ms, and the denied ones after negative_ttl_ms. The expired
+// results are kept until evicted, to be used when Service Control is
+// unavailable.
+class CheckCache {
+ public:
+  struct Result {
+    ::google::protobuf::util::Status status;
+    ::google::api_proxy::service_control::CheckResponseInfo response_info;
+  };
+
+  CheckCache(uint32_t max_entries, uint32_t ttl_ms, uint32_t negative_ttl_ms,
+             TimeSource& time_source);
+
+  // The key of the Check requests with the same result.
+  static std::string makeKey(
+      const ::google::api::servicecontrol::v1::CheckRequest& request);
+
+  // Returns the result if not expired, null otherwise.
+  const Result* lookup(const std::string& key);
+
+  // Returns the result even if expired, null if there is none.
+  const Result* lookupStale(const std::string& key);
+
+  void insert(const std::string& key, const Result& result);
+
+ private:
+  struct Entry {
+    std::string key;
+    Result result;
+    MonotonicTime expire_time;
+  };
+  using EntryList = std::list<Entry>;
+
+  // Returns the entry and marks it as the most recently used.
+  Entry* find(const std::string& key);
+
+  const uint32_t max_entries_;
+  const std::chrono::milliseconds ttl_;
+  const std::chrono::milliseconds negative_ttl_;
+  TimeSource& time_source_;
+
+  // The entries, from the most to the least recently used.
+  EntryList entries_;
+  std::unordered_map<std::string, EntryList::iterator> index_;
+};
+
+}  // namespace ServiceControl
+}  // namespace HttpFilters
+}  // namespace Extensions
+}  // namespace Envoy
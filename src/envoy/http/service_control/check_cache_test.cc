@@ -0,0 +1,127 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+#include "src/envoy/http/service_control/check_cache.h"
+
+#include "gmock/gmock.h"
+#include "gtest/gtest.h"
+#include "test/test_common/simulated_time_system.h"
+
+using ::google::api::servicecontrol::v1::CheckRequest;
+using ::google::protobuf::util::Status;
+using ::google::protobuf::util::error::Code;
+
+namespace Envoy {
+namespace Extensions {
+namespace HttpFilters {
+namespace ServiceControl {
+namespace {
+
+CheckCache::Result allowed() {
+  CheckCache::Result result;
+  result.response_info.consumer_project_id = "consumer-project";
+  return result;
+}
+
+CheckCache::Result denied() {
+  CheckCache::Result result;
+  result.status = Status(Code::PERMISSION_DENIED, "API key not valid");
+  result.response_info.is_api_key_valid = false;
+  return result;
+}
+
+TEST(CheckCacheKeyTest, KeyIgnoresOperationIdAndLabelOrder) {
+  CheckRequest request1;
+  request1.mutable_operation()->set_operation_name("ListShelves");
+  request1.mutable_operation()->set_operation_id("id-1");
+  request1.mutable_operation()->set_consumer_id("api_key:key-1");
+  (*request1.mutable_operation()->mutable_labels())["a"] = "1";
+  (*request1.mutable_operation()->mutable_labels())["b"] = "2";
+
+  CheckRequest request2;
+  request2.mutable_operation()->set_operation_name("ListShelves");
+  request2.mutable_operation()->set_operation_id("id-2");
+  request2.mutable_operation()->set_consumer_id("api_key:key-1");
+  (*request2.mutable_operation()->mutable_labels())["b"] = "2";
+  (*request2.mutable_operation()->mutable_labels())["a"] = "1";
+  EXPECT_EQ(CheckCache::makeKey(request1), CheckCache::makeKey(request2));
+
+  request2.mutable_operation()->set_consumer_id("api_key:key-2");
+  EXPECT_NE(CheckCache::makeKey(request1), CheckCache::makeKey(request2));
+}
+
+class CheckCacheTest : public testing::Test {
+ protected:
+  Event::SimulatedTimeSystem time_system_;
+};
+
+TEST_F(CheckCacheTest, ExpireAfterTtl) {
+  CheckCache cache(10, 1000, 100, time_system_);
+  cache.insert("allowed", allowed());
+  cache.insert("denied", denied());
+
+  ASSERT_NE(cache.lookup("allowed"), nullptr);
+  EXPECT_EQ(cache.lookup("allowed")->response_info.consumer_project_id,
+            "consumer-project");
+  ASSERT_NE(cache.lookup("denied"), nullptr);
+  EXPECT_EQ(cache.lookup("denied")->status.error_code(),
+            Code::PERMISSION_DENIED);
+  EXPECT_EQ(cache.lookup("unknown"), nullptr);
+
+  time_system_.sleep(std::chrono::milliseconds(100));
+  EXPECT_NE(cache.lookup("allowed"), nullptr);
+  EXPECT_EQ(cache.lookup("denied"), nullptr);
+
+  time_system_.sleep(std::chrono::milliseconds(900));
+  EXPECT_EQ(cache.lookup("allowed"), nullptr);
+
+  // The expired results are still available as stale ones.
+  EXPECT_NE(cache.lookupStale("allowed"), nullptr);
+  EXPECT_NE(cache.lookupStale("denied"), nullptr);
+}
+
+TEST_F(CheckCacheTest, NegativeCachingDisabled) {
+  CheckCache cache(10, 1000, 0, time_system_);
+  cache.insert("key", allowed());
+  EXPECT_NE(cache.lookup("key"), nullptr);
+
+  cache.insert("key", denied());
+  EXPECT_EQ(cache.lookup("key"), nullptr);
+  EXPECT_EQ(cache.lookupStale("key"), nullptr);
+}
+
+TEST_F(CheckCacheTest, EvictLeastRecentlyUsed) {
+  CheckCache cache(2, 1000, 1000, time_system_);
+  cache.insert("key1", allowed());
+  cache.insert("key2", allowed());
+  EXPECT_NE(cache.lookup("key1"), nullptr);
+
+  cache.insert("key3", allowed());
+  EXPECT_NE(cache.lookup("key1"), nullptr);
+  EXPECT_EQ(cache.lookup("key2"), nullptr);
+  EXPECT_NE(cache.lookup("key3"), nullptr);
+}
+
+TEST_F(CheckCacheTest, CacheDisabled) {
+  CheckCache cache(0, 1000, 1000, time_system_);
+  cache.insert("key", allowed());
+  EXPECT_EQ(cache.lookup("key"), nullptr);
+  EXPECT_EQ(cache.lookupStale("key"), nullptr);
+}
+
+}  // namespace
+}  // namespace ServiceControl
+}  // namespace HttpFilters
+}  // namespace Extensions
+}  // namespace Envoy
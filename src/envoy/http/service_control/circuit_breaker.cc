@@ -0,0 +1,85 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+#include "src/envoy/http/service_control/circuit_breaker.h"
+
+namespace Envoy {
+namespace Extensions {
+namespace HttpFilters {
+namespace ServiceControl {
+
+CircuitBreaker::CircuitBreaker(uint32_t failure_threshold,
+                               uint32_t open_duration_ms,
+                               TimeSource& time_source,
+                               ServiceControlFilterStats& stats,
+                               std::function<void()> on_closed)
+    : failure_threshold_(failure_threshold),
+      open_duration_(open_duration_ms),
+      time_source_(time_source),
+      stats_(stats),
+      on_closed_(on_closed),
+      state_(State::Closed),
+      consecutive_failures_(0) {}
+
+bool CircuitBreaker::allowCall() {
+  if (state_ == State::Closed) {
+    return true;
+  }
+  const MonotonicTime now = time_source_.monotonicTime();
+  if (now < open_until_) {
+    return false;
+  }
+  // The probe may be answered from the cache without calling Service Control,
+  // so another probe is sent if there is no result after open_duration_ms.
+  ENVOY_LOG(info, "Probing Service Control with the circuit breaker open");
+  state_ = State::HalfOpen;
+  open_until_ = now + open_duration_;
+  return true;
+}
+
+void CircuitBreaker::onSuccess() {
+  consecutive_failures_ = 0;
+  if (state_ == State::Closed) {
+    return;
+  }
+  ENVOY_LOG(info, "Service Control is available, closing the circuit breaker");
+  state_ = State::Closed;
+  on_closed_();
+}
+
+void CircuitBreaker::onFailure() {
+  ++consecutive_failures_;
+  if (state_ == State::HalfOpen ||
+      (state_ == State::Closed &&
+       consecutive_failures_ >= failure_threshold_)) {
+    open();
+  }
+}
+
+void CircuitBreaker::open() {
+  if (state_ == State::Closed) {
+    ENVOY_LOG(warn,
+              "Service Control calls failed {} times in a row, opening the "
+              "circuit breaker",
+              consecutive_failures_);
+    stats_.circuit_breaker_opened_.inc();
+  }
+  state_ = State::Open;
+  open_until_ = time_source_.monotonicTime() + open_duration_;
+}
+
+}  // namespace ServiceControl
+}  // namespace HttpFilters
+}  // namespace Extensions
+}  // namespace Envoy
@@ -0,0 +1,65 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+#pragma once
+
+#include "common/common/logger.h"
+#include "envoy/common/time.h"
+#include "src/envoy/http/service_control/filter_stats.h"
+
+namespace Envoy {
+namespace Extensions {
+namespace HttpFilters {
+namespace ServiceControl {
+
+// The circuit breaker of the Service Control calls of a worker thread. It
+// opens after failure_threshold consecutive failed calls. Then it lets a
+// single call through every open_duration_ms to probe Service Control, and
+// closes when a call succeeds.
+class CircuitBreaker : public Logger::Loggable<Logger::Id::filter> {
+ public:
+  CircuitBreaker(uint32_t failure_threshold, uint32_t open_duration_ms,
+                 TimeSource& time_source, ServiceControlFilterStats& stats,
+                 std::function<void()> on_closed);
+
+  // Returns whether a call can be sent. When the circuit breaker is open, the
+  // allowed call is the probe.
+  bool allowCall();
+
+  void onSuccess();
+  void onFailure();
+
+  bool isOpen() const { return state_ != State::Closed; }
+
+ private:
+  enum class State { Closed, Open, HalfOpen };
+
+  void open();
+
+  const uint32_t failure_threshold_;
+  const std::chrono::milliseconds open_duration_;
+  TimeSource& time_source_;
+  ServiceControlFilterStats& stats_;
+  // Called when the circuit breaker closes after being open.
+  std::function<void()> on_closed_;
+
+  State state_;
+  uint32_t consecutive_failures_;
+  MonotonicTime open_until_;
+};
+
+}  // namespace ServiceControl
+}  // namespace HttpFilters
+}  // namespace Extensions
+}  // namespace Envoy
@@ -0,0 +1,109 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+#include "src/envoy/http/service_control/circuit_breaker.h"
+
+#include "gmock/gmock.h"
+#include "gtest/gtest.h"
+#include "test/mocks/stats/mocks.h"
+#include "test/test_common/simulated_time_system.h"
+
+namespace Envoy {
+namespace Extensions {
+namespace HttpFilters {
+namespace ServiceControl {
+namespace {
+
+class CircuitBreakerTest : public testing::Test {
+ protected:
+  CircuitBreakerTest()
+      : stat_base_("test.", store_),
+        closed_count_(0),
+        circuit_breaker_(3, 1000, time_system_, stat_base_.stats(),
+                         [this]() { ++closed_count_; }) {}
+
+  void fail(int times) {
+    for (int i = 0; i < times; ++i) {
+      ASSERT_TRUE(circuit_breaker_.allowCall());
+      circuit_breaker_.onFailure();
+    }
+  }
+
+  Event::SimulatedTimeSystem time_system_;
+  NiceMock<Stats::MockIsolatedStatsStore> store_;
+  ServiceControlFilterStatBase stat_base_;
+  int closed_count_;
+  CircuitBreaker circuit_breaker_;
+};
+
+TEST_F(CircuitBreakerTest, OpenAfterConsecutiveFailures) {
+  fail(2);
+  circuit_breaker_.onSuccess();
+  fail(2);
+  EXPECT_FALSE(circuit_breaker_.isOpen());
+
+  fail(1);
+  EXPECT_TRUE(circuit_breaker_.isOpen());
+  EXPECT_FALSE(circuit_breaker_.allowCall());
+  EXPECT_EQ(stat_base_.stats().circuit_breaker_opened_.value(), 1);
+  EXPECT_EQ(closed_count_, 0);
+}
+
+TEST_F(CircuitBreakerTest, CloseAfterSuccessfulProbe) {
+  fail(3);
+  EXPECT_TRUE(circuit_breaker_.isOpen());
+
+  time_system_.sleep(std::chrono::milliseconds(1000));
+  EXPECT_TRUE(circuit_breaker_.allowCall());
+  // A single probe is sent.
+  EXPECT_FALSE(circuit_breaker_.allowCall());
+
+  circuit_breaker_.onSuccess();
+  EXPECT_FALSE(circuit_breaker_.isOpen());
+  EXPECT_TRUE(circuit_breaker_.allowCall());
+  EXPECT_EQ(closed_count_, 1);
+}
+
+TEST_F(CircuitBreakerTest, ReopenAfterFailedProbe) {
+  fail(3);
+
+  time_system_.sleep(std::chrono::milliseconds(1000));
+  EXPECT_TRUE(circuit_breaker_.allowCall());
+  circuit_breaker_.onFailure();
+  EXPECT_TRUE(circuit_breaker_.isOpen());
+  EXPECT_FALSE(circuit_breaker_.allowCall());
+
+  time_system_.sleep(std::chrono::milliseconds(1000));
+  EXPECT_TRUE(circuit_breaker_.allowCall());
+  EXPECT_EQ(stat_base_.stats().circuit_breaker_opened_.value(), 1);
+  EXPECT_EQ(closed_count_, 0);
+}
+
+TEST_F(CircuitBreakerTest, ProbeAgainWithoutResult) {
+  fail(3);
+
+  time_system_.sleep(std::chrono::milliseconds(1000));
+  EXPECT_TRUE(circuit_breaker_.allowCall());
+  EXPECT_FALSE(circuit_breaker_.allowCall());
+
+  // The probe was answered from the cache.
+  time_system_.sleep(std::chrono::milliseconds(1000));
+  EXPECT_TRUE(circuit_breaker_.allowCall());
+}
+
+}  // namespace
+}  // namespace ServiceControl
+}  // namespace HttpFilters
+}  // namespace Extensions
+}  // namespace Envoy
@@ -26,7 +26,6 @@ using ::google::api::servicecontrol::v1::AllocateQuotaResponse;
 using ::google::api::servicecontrol::v1::CheckRequest;
 using ::google::api::servicecontrol::v1::CheckResponse;
 using ::google::api::servicecontrol::v1::ReportRequest;
-using ::google::api::servicecontrol::v1::ReportResponse;
 using ::google::api_proxy::service_control::CheckResponseInfo;
 
 using ::google::service_control_client::CheckAggregationOptions;
@@ -41,22 +40,43 @@ namespace HttpFilters {
 namespace ServiceControl {
 namespace {
 
-// Default config for check aggregator
+// Default config for check aggregator, which is unused since the check
+// results are cached by CheckCache.
 constexpr uint32_t kCheckAggregationEntries = 10000;
-// Check doesn't support quota yet. It is safe to increase
-// the cache life of check results.
-// Cache life is 5 minutes. It will be refreshed every minute.
 constexpr uint32_t kCheckAggregationFlushIntervalMs = 60000;
 constexpr uint32_t kCheckAggregationExpirationMs = 300000;
 
+// Default config for check cache
+constexpr uint32_t kCheckCacheDefaultMaxEntries = 10000;
+constexpr uint32_t kCheckCacheDefaultTtlMs = 60000;
+
 // Default config for quota aggregator
 constexpr uint32_t kQuotaAggregationEntries = 10000;
 constexpr uint32_t kQuotaAggregationFlushIntervalMs = 1000;
 
-// Default config for report aggregator
+// Default config for quota buckets
+constexpr uint32_t kQuotaBucketMaxEntries = 10000;
+constexpr uint32_t kQuotaBucketDefaultRefillIntervalMs = 1000;
+
+// Default config for report aggregator, which is unused since the reports are
+// batched by ReportBatcher.
 constexpr uint32_t kReportAggregationEntries = 10000;
 constexpr uint32_t kReportAggregationFlushIntervalMs = 1000;
 
+// Default config for report batcher
+constexpr uint32_t kReportBatchDefaultMaxSize = 1000;
+constexpr uint32_t kReportBatchDefaultMaxDelayMs = 1000;
+constexpr uint32_t kReportBatchDefaultMaxBytes = 10 * 1024 * 1024;
+
+// Default config for circuit breaker
+constexpr uint32_t kCircuitBreakerDefaultOpenDurationMs = 30000;
+constexpr uint32_t kReportQueueDefaultMaxBytes = 10 * 1024 * 1024;
+constexpr uint32_t kReportSpillDefaultMaxBytes = 100 * 1024 * 1024;
+// The queued reports are sent in chunks, to not stall the worker thread and
+// flood Service Control after an outage.
+constexpr uint32_t kReportDrainChunkSize = 100;
+constexpr std::chrono::milliseconds kReportDrainInterval(100);
+
 // The default connection timeout for check requests.
 constexpr uint32_t kCheckDefaultTimeoutInMs = 1000;
 // The default connection timeout for allocate quota requests.
@@ -131,6 +151,9 @@ void ClientCache::InitHttpRequestSetting(const FilterConfig& filter_config) {
     check_retries_ = kCheckDefaultNumberOfRetries;
     quota_retries_ = kAllocateQuotaDefaultNumberOfRetries;
     report_retries_ = kReportDefaultNumberOfRetries;
+    report_batch_max_size_ = kReportBatchDefaultMaxSize;
+    report_batch_max_delay_ms_ = kReportBatchDefaultMaxDelayMs;
+    report_batch_max_bytes_ = kReportBatchDefaultMaxBytes;
     return;
   }
   const auto& sc_calling_config = filter_config.sc_calling_config();
@@ -156,6 +179,19 @@ void ClientCache::InitHttpRequestSetting(const FilterConfig& filter_config) {
   report_retries_ = sc_calling_config.has_report_retries()
                         ? sc_calling_config.report_retries().value()
                         : kReportDefaultNumberOfRetries;
+
+  report_batch_max_size_ =
+      sc_calling_config.has_report_batch_max_size()
+          ? sc_calling_config.report_batch_max_size().value()
+          : kReportBatchDefaultMaxSize;
+  report_batch_max_delay_ms_ =
+      sc_calling_config.has_report_batch_max_delay_ms()
+          ? sc_calling_config.report_batch_max_delay_ms().value()
+          : kReportBatchDefaultMaxDelayMs;
+  report_batch_max_bytes_ =
+      sc_calling_config.has_report_batch_max_bytes()
+          ? sc_calling_config.report_batch_max_bytes().value()
+          : kReportBatchDefaultMaxBytes;
 }
 
 ClientCache::ClientCache(
@@ -163,50 +199,97 @@ ClientCache::ClientCache(
     const FilterConfig& filter_config, Upstream::ClusterManager& cm,
     Envoy::TimeSource& time_source, Event::Dispatcher& dispatcher,
     std::function<const std::string&()> sc_token_fn,
-    std::function<const std::string&()> quota_token_fn)
-    : config_(config), time_source_(time_source) {
+    std::function<const std::string&()> quota_token_fn,
+    ServiceControlFilterStats& stats,
+    std::shared_ptr<std::atomic<uint32_t>> in_flight_reports)
+    : config_(config),
+      stats_(stats),
+      in_flight_reports_(in_flight_reports),
+      time_source_(time_source) {
   ServiceControlClientOptions options(getCheckAggregationOptions(),
                                       getQuotaAggregationOptions(),
                                       getReportAggregationOptions());
 
   InitHttpRequestSetting(filter_config);
+  const auto& transport = filter_config.sc_transport();
+  const WireFormatSharedPtr wire_format = createWireFormat(transport);
+  const CallCredentialsSharedPtr sc_credentials =
+      createCallCredentials(transport, sc_token_fn);
   check_call_factory_ = std::make_unique<HttpCallFactory>(
       cm, dispatcher, filter_config.service_control_uri(),
-      config_.service_name() + ":check", sc_token_fn, check_timeout_ms_,
-      check_retries_, time_source, "Service Control remote call: Check");
+      wire_format->suffixUrl(CallKind::Check, config_.service_name()),
+      wire_format, sc_credentials, check_timeout_ms_, check_retries_,
+      time_source, "Service Control remote call: Check");
   quota_call_factory_ = std::make_unique<HttpCallFactory>(
       cm, dispatcher, filter_config.service_control_uri(),
-      config_.service_name() + ":allocateQuota", quota_token_fn,
+      wire_format->suffixUrl(CallKind::Quota, config_.service_name()),
+      wire_format, createCallCredentials(transport, quota_token_fn),
       quota_timeout_ms_, quota_retries_, time_source,
       "Service Control remote call: Allocate Quota");
   report_call_factory_ = std::make_unique<HttpCallFactory>(
       cm, dispatcher, filter_config.service_control_uri(),
-      config_.service_name() + ":report", sc_token_fn, report_timeout_ms_,
-      report_retries_, time_source, "Service Control remote call: Report");
-
-  options.check_transport = [this](const CheckRequest& request,
-                                   CheckResponse* response,
-                                   TransportDoneFunc on_done) {
-    // Don't support tracing on this transport
-    auto& null_span = Envoy::Tracing::NullSpan::instance();
-    auto* call = check_call_factory_->createHttpCall(
-        request, null_span,
-        [response, on_done](const Status& status, const std::string& body) {
-          if (status.ok()) {
-            // Handle 200 response
-            if (!response->ParseFromString(body)) {
-              on_done(Status(Code::INVALID_ARGUMENT,
-                             std::string("Invalid response")));
-              return;
-            }
-          } else {
-            ENVOY_LOG(error, "Failed to call check, error: {}, str body: {}",
-                      status.ToString(), body);
-          }
-          on_done(status);
-        });
-    call->call();
-  };
+      wire_format->suffixUrl(CallKind::Report, config_.service_name()),
+      wire_format, sc_credentials, report_timeout_ms_, report_retries_,
+      time_source, "Service Control remote call: Report");
+
+  const auto& cache_config = filter_config.sc_calling_config().check_cache();
+  const uint32_t check_cache_ttl_ms = cache_config.has_ttl_ms()
+                                          ? cache_config.ttl_ms().value()
+                                          : kCheckCacheDefaultTtlMs;
+  check_cache_ = std::make_unique<CheckCache>(
+      cache_config.has_max_entries() ? cache_config.max_entries().value()
+                                     : kCheckCacheDefaultMaxEntries,
+      check_cache_ttl_ms,
+      cache_config.has_negative_ttl_ms() ? cache_config.negative_ttl_ms().value()
+                                         : check_cache_ttl_ms,
+      time_source);
+  serve_stale_check_results_ = cache_config.serve_stale_on_failure();
+
+  if (filter_config.sc_calling_config().has_circuit_breaker()) {
+    const auto& cb_config = filter_config.sc_calling_config().circuit_breaker();
+    circuit_breaker_fail_open_ = cb_config.has_fail_open()
+                                     ? cb_config.fail_open().value()
+                                     : network_fail_open_;
+    report_queue_ = std::make_unique<ReportQueue>(
+        cb_config.has_report_queue_max_bytes()
+            ? cb_config.report_queue_max_bytes().value()
+            : kReportQueueDefaultMaxBytes,
+        cb_config.report_spill_dir(),
+        cb_config.has_report_spill_max_bytes()
+            ? cb_config.report_spill_max_bytes().value()
+            : kReportSpillDefaultMaxBytes,
+        stats);
+    circuit_breaker_ = std::make_unique<CircuitBreaker>(
+        cb_config.failure_threshold(),
+        cb_config.has_open_duration_ms()
+            ? cb_config.open_duration_ms().value()
+            : kCircuitBreakerDefaultOpenDurationMs,
+        time_source, stats, [this]() { drainReportQueue(); });
+    report_drain_timer_ =
+        dispatcher.createTimer([this]() { drainReportQueue(); });
+  }
+  if (filter_config.sc_calling_config().has_quota_bucket()) {
+    const auto& bucket_config =
+        filter_config.sc_calling_config().quota_bucket();
+    quota_bucket_cache_ = std::make_unique<QuotaBucketCache>(
+        bucket_config.burst(), kQuotaBucketMaxEntries);
+    quota_refill_interval_ = std::chrono::milliseconds(
+        bucket_config.has_refill_interval_ms()
+            ? bucket_config.refill_interval_ms().value()
+            : kQuotaBucketDefaultRefillIntervalMs);
+    quota_refill_timer_ = dispatcher.createTimer([this]() {
+      quota_bucket_cache_->refill(
+          [this](const AllocateQuotaRequest& request, QuotaDoneFunc on_done) {
+            refillQuotaBucket(request, on_done);
+          });
+      quota_refill_timer_->enableTimer(quota_refill_interval_);
+    });
+    quota_refill_timer_->enableTimer(quota_refill_interval_);
+  }
+  report_batcher_ = std::make_unique<ReportBatcher>(
+      report_batch_max_size_, report_batch_max_delay_ms_,
+      report_batch_max_bytes_, dispatcher, stats,
+      [this](const ReportRequest& request) { sendReport(request); });
 
   options.quota_transport = [this](const AllocateQuotaRequest& request,
                                    AllocateQuotaResponse* response,
@@ -215,7 +298,9 @@ ClientCache::ClientCache(
     auto& null_span = Envoy::Tracing::NullSpan::instance();
     auto* call = quota_call_factory_->createHttpCall(
         request, null_span,
-        [response, on_done](const Status& status, const std::string& body) {
+        [this, response, on_done](const Status& status,
+                                  const std::string& body) {
+          onCallDone(status);
           if (status.ok()) {
             // Handle 200 response
             if (!response->ParseFromString(body)) {
@@ -233,30 +318,6 @@ ClientCache::ClientCache(
     call->call();
   };
 
-  options.report_transport = [this](const ReportRequest& request,
-                                    ReportResponse* response,
-                                    TransportDoneFunc on_done) {
-    // Don't support tracing on this transport
-    auto& null_span = Envoy::Tracing::NullSpan::instance();
-    auto* call = report_call_factory_->createHttpCall(
-        request, null_span,
-        [response, on_done](const Status& status, const std::string& body) {
-          if (status.ok()) {
-            // Handle 200 response
-            if (!response->ParseFromString(body)) {
-              on_done(Status(Code::INVALID_ARGUMENT,
-                             std::string("Invalid response")));
-              return;
-            }
-          } else {
-            ENVOY_LOG(error, "Failed to call report, error: {}, str body: {}",
-                      status.ToString(), body);
-          }
-          on_done(status);
-        });
-    call->call();
-  };
-
   options.periodic_timer = [&dispatcher](int interval_ms,
                                          std::function<void()> callback)
       -> std::unique_ptr<::google::service_control_client::PeriodicTimer> {
@@ -268,63 +329,151 @@ ClientCache::ClientCache(
       config_.service_name(), config_.service_config_id(), options);
 }
 
+void ClientCache::drainReportQueue() {
+  // The reports are queued again while the circuit breaker is open, the queue
+  // is drained when it closes.
+  if (circuit_breaker_->isOpen() || report_drain_timer_->enabled()) {
+    return;
+  }
+  if (report_queue_->drain(
+          [this](const ReportRequest& request) { sendReport(request); },
+          kReportDrainChunkSize)) {
+    report_drain_timer_->enableTimer(kReportDrainInterval);
+  }
+}
+
+void ClientCache::onCallDone(const Status& status) {
+  if (!circuit_breaker_ || status.error_code() == Code::CANCELLED) {
+    return;
+  }
+  if (status.ok()) {
+    circuit_breaker_->onSuccess();
+  } else {
+    circuit_breaker_->onFailure();
+  }
+}
+
+bool ClientCache::rejectedByCircuitBreaker(Status* status) {
+  if (!circuit_breaker_ || circuit_breaker_->allowCall()) {
+    return false;
+  }
+  *status = circuit_breaker_fail_open_
+                ? Status::OK
+                : Status(Code::UNAVAILABLE, "Service Control is unavailable");
+  return true;
+}
+
+void ClientCache::sendReport(const ReportRequest& request) {
+  if (circuit_breaker_ && circuit_breaker_->isOpen()) {
+    report_queue_->push(request);
+    return;
+  }
+
+  // Don't support tracing on this transport
+  auto& null_span = Envoy::Tracing::NullSpan::instance();
+  auto in_flight_reports = in_flight_reports_;
+  ++*in_flight_reports;
+  auto* call = report_call_factory_->createHttpCall(
+      request, null_span,
+      [in_flight_reports](const Status& status, const std::string& body) {
+        --*in_flight_reports;
+        if (!status.ok()) {
+          ENVOY_LOG(error, "Failed to call report, error: {}, str body: {}",
+                    status.ToString(), body);
+        }
+      });
+  call->call();
+}
+
 CancelFunc ClientCache::callCheck(
     const CheckRequest& request, Envoy::Tracing::Span& parent_span,
     std::function<void(const Status&, const CheckResponseInfo&)> on_done) {
-  CancelFunc cancel_fn;
-  auto check_transport = [this, &parent_span, &cancel_fn](
-                             const CheckRequest& request,
-                             CheckResponse* response,
-                             TransportDoneFunc on_done) {
-    auto* call = check_call_factory_->createHttpCall(
-        request, parent_span,
-        [response, on_done](const Status& status, const std::string& body) {
-          if (status.ok()) {
-            // Handle 200 response
-            if (!response->ParseFromString(body)) {
-              on_done(Status(Code::INVALID_ARGUMENT,
-                             std::string("Invalid response")));
-              return;
-            }
-          } else {
-            ENVOY_LOG(error, "Failed to call check, error: {}, str body: {}",
-                      status.ToString(), body);
-          }
-          on_done(status);
-        });
-    call->call();
-    cancel_fn = [call]() { call->cancel(); };
-  };
-
   parent_span.log(time_source_.systemTime(),
                   "Service Control cache query: Check");
 
-  auto* response = new CheckResponse;
-  client_->Check(request, response,
-                 [this, response, on_done](const Status& status) {
-                   CheckResponseInfo response_info;
-                   if (status.ok()) {
-                     Status converted_status = ::google::api_proxy::
-                         service_control::RequestBuilder::ConvertCheckResponse(
-                             *response, config_.service_name(), &response_info);
-                     on_done(converted_status, response_info);
-                   } else {
-                     if (network_fail_open_) {
-                       on_done(Status::OK, response_info);
-                     } else {
-                       on_done(status, response_info);
-                     }
-                   }
-                   delete response;
-                 },
-                 check_transport);
-  return cancel_fn;
+  const std::string key = CheckCache::makeKey(request);
+  const CheckCache::Result* cached = check_cache_->lookup(key);
+  if (cached != nullptr) {
+    stats_.check_cache_hits_.inc();
+    on_done(cached->status, cached->response_info);
+    return nullptr;
+  }
+  stats_.check_cache_misses_.inc();
+
+  Status circuit_breaker_status;
+  if (rejectedByCircuitBreaker(&circuit_breaker_status)) {
+    onCheckFailed(key, circuit_breaker_status, on_done);
+    return nullptr;
+  }
+
+  auto* call = check_call_factory_->createHttpCall(
+      request, parent_span,
+      [this, key, on_done](const Status& status, const std::string& body) {
+        onCallDone(status);
+        if (!status.ok()) {
+          ENVOY_LOG(error, "Failed to call check, error: {}, str body: {}",
+                    status.ToString(), body);
+          onCheckFailed(key, networkFailStatus(status), on_done);
+          return;
+        }
+
+        // Handle 200 response
+        CheckResponse response;
+        if (!response.ParseFromString(body)) {
+          onCheckFailed(key,
+                        networkFailStatus(Status(
+                            Code::INVALID_ARGUMENT, "Invalid response")),
+                        on_done);
+          return;
+        }
+        CheckCache::Result result;
+        result.status = ::google::api_proxy::service_control::RequestBuilder::
+            ConvertCheckResponse(response, config_.service_name(),
+                                 &result.response_info);
+        check_cache_->insert(key, result);
+        on_done(result.status, result.response_info);
+      });
+  call->call();
+  return [call]() { call->cancel(); };
+}
+
+Status ClientCache::networkFailStatus(const Status& status) const {
+  return network_fail_open_ ? Status::OK : status;
+}
+
+void ClientCache::onCheckFailed(const std::string& key, const Status& status,
+                                const CheckDoneFunc& on_done) {
+  if (serve_stale_check_results_) {
+    const CheckCache::Result* stale = check_cache_->lookupStale(key);
+    if (stale != nullptr) {
+      stats_.check_cache_stale_served_.inc();
+      on_done(stale->status, stale->response_info);
+      return;
+    }
+  }
+  on_done(status, CheckResponseInfo{});
 }
 
 void ClientCache::callQuota(
     const ::google::api::servicecontrol::v1::AllocateQuotaRequest& request,
     std::function<void(const ::google::protobuf::util::Status& status)>
         on_done) {
+  if (quota_bucket_cache_) {
+    Status bucket_status;
+    if (quota_bucket_cache_->tryAcquire(request, &bucket_status)) {
+      stats_.quota_bucket_hits_.inc();
+      on_done(bucket_status);
+      return;
+    }
+    stats_.quota_bucket_misses_.inc();
+  }
+
+  Status circuit_breaker_status;
+  if (rejectedByCircuitBreaker(&circuit_breaker_status)) {
+    on_done(circuit_breaker_status);
+    return;
+  }
+
   auto* response = new AllocateQuotaResponse;
   client_->Quota(
       request, response, [this, response, on_done](const Status& status) {
@@ -341,10 +490,45 @@ void ClientCache::callQuota(
       });
 }
 
+void ClientCache::refillQuotaBucket(const AllocateQuotaRequest& request,
+                                    QuotaDoneFunc on_done) {
+  Status circuit_breaker_status;
+  if (rejectedByCircuitBreaker(&circuit_breaker_status)) {
+    on_done(circuit_breaker_status);
+    return;
+  }
+
+  // Don't support tracing on this transport
+  auto& null_span = Envoy::Tracing::NullSpan::instance();
+  auto* call = quota_call_factory_->createHttpCall(
+      request, null_span,
+      [this, on_done](const Status& status, const std::string& body) {
+        onCallDone(status);
+        if (!status.ok()) {
+          ENVOY_LOG(error,
+                    "Failed to call allocateQuota to refill a quota bucket, "
+                    "error: {}, str body: {}",
+                    status.ToString(), body);
+          on_done(networkFailStatus(status));
+          return;
+        }
+
+        // Handle 200 response
+        AllocateQuotaResponse response;
+        if (!response.ParseFromString(body)) {
+          on_done(networkFailStatus(
+              Status(Code::INVALID_ARGUMENT, "Invalid response")));
+          return;
+        }
+        on_done(::google::api_proxy::service_control::RequestBuilder::
+                    ConvertAllocateQuotaResponse(response,
+                                                 config_.service_name()));
+      });
+  call->call();
+}
+
 void ClientCache::callReport(const ReportRequest& request) {
-  auto* response = new ReportResponse;
-  client_->Report(request, response,
-                  [response](const Status&) { delete response; });
+  report_batcher_->add(request);
 }
 
 }  // namespace ServiceControl
@@ -14,6 +14,8 @@
 
 #pragma once
 
+#include <atomic>
+
 #include "api/envoy/http/service_control/config.pb.h"
 #include "common/common/logger.h"
 #include "envoy/event/dispatcher.h"
@@ -21,7 +23,13 @@
 #include "envoy/upstream/cluster_manager.h"
 #include "include/service_control_client.h"
 #include "src/api_proxy/service_control/request_info.h"
+#include "src/envoy/http/service_control/check_cache.h"
+#include "src/envoy/http/service_control/circuit_breaker.h"
+#include "src/envoy/http/service_control/filter_stats.h"
 #include "src/envoy/http/service_control/http_call.h"
+#include "src/envoy/http/service_control/quota_bucket_cache.h"
+#include "src/envoy/http/service_control/report_batcher.h"
+#include "src/envoy/http/service_control/report_queue.h"
 #include "src/envoy/http/service_control/service_control_callback_func.h"
 
 namespace Envoy {
@@ -39,7 +47,9 @@ class ClientCache : public Logger::Loggable<Logger::Id::filter> {
       Upstream::ClusterManager& cm, Envoy::TimeSource& time_source,
       Event::Dispatcher& dispatcher,
       std::function<const std::string&()> sc_token_fn,
-      std::function<const std::string&()> quota_token_fn);
+      std::function<const std::string&()> quota_token_fn,
+      ServiceControlFilterStats& stats,
+      std::shared_ptr<std::atomic<uint32_t>> in_flight_reports);
 
   CancelFunc callCheck(
       const ::google::api::servicecontrol::v1::CheckRequest& request,
@@ -52,12 +62,48 @@ class ClientCache : public Logger::Loggable<Logger::Id::filter> {
   void callReport(
       const ::google::api::servicecontrol::v1::ReportRequest& request);
 
+  // Sends the batched reports now, when the proxy shuts down.
+  void flushReports() { report_batcher_->flush(); }
+
+  uint32_t report_timeout_ms() const { return report_timeout_ms_; }
+
  private:
+  void sendReport(
+      const ::google::api::servicecontrol::v1::ReportRequest& request);
+
+  // Answers a Check that could not be sent or failed, with the stale cached
+  // result if allowed, or with the status.
+  void onCheckFailed(const std::string& key,
+                     const ::google::protobuf::util::Status& status,
+                     const CheckDoneFunc& on_done);
+
+  // The status of a failed call, with network_fail_open applied.
+  ::google::protobuf::util::Status networkFailStatus(
+      const ::google::protobuf::util::Status& status) const;
+
+  // Sends a Quota call refilling a quota bucket, bypassing the aggregated
+  // Quota calls.
+  void refillQuotaBucket(
+      const ::google::api::servicecontrol::v1::AllocateQuotaRequest& request,
+      QuotaDoneFunc on_done);
+
+  // Sends a chunk of the queued reports, and schedules the next chunk while
+  // the circuit breaker stays closed.
+  void drainReportQueue();
+
+  // Records the result of a Check or Quota call in the circuit breaker.
+  void onCallDone(const ::google::protobuf::util::Status& status);
+
+  // Returns whether the circuit breaker rejects the call, and the status to
+  // use instead of calling Service Control.
+  bool rejectedByCircuitBreaker(::google::protobuf::util::Status* status);
+
   void InitHttpRequestSetting(
       const ::google::api::envoy::http::service_control::FilterConfig&
           filter_config);
 
   const ::google::api::envoy::http::service_control::Service& config_;
+  ServiceControlFilterStats& stats_;
   bool network_fail_open_;
 
   // the configurable timeouts
@@ -70,6 +116,27 @@ class ClientCache : public Logger::Loggable<Logger::Id::filter> {
   uint32_t report_retries_;
   uint32_t quota_retries_;
 
+  // the configurable report batching
+  uint32_t report_batch_max_size_;
+  uint32_t report_batch_max_delay_ms_;
+  uint32_t report_batch_max_bytes_;
+
+  // The cache of the Check results.
+  std::unique_ptr<CheckCache> check_cache_;
+  bool serve_stale_check_results_;
+
+  // The circuit breaker for Service Control outages, null if disabled, and
+  // the queue of the reports while it is open.
+  std::unique_ptr<CircuitBreaker> circuit_breaker_;
+  bool circuit_breaker_fail_open_;
+  std::unique_ptr<ReportQueue> report_queue_;
+  // The timer sending the next chunk of the queued reports.
+  Event::TimerPtr report_drain_timer_;
+
+  // The number of report calls in flight on all the threads, to wait for them
+  // when the proxy shuts down.
+  std::shared_ptr<std::atomic<uint32_t>> in_flight_reports_;
+
   // the http call factories
   std::unique_ptr<HttpCallFactory> check_call_factory_;
   std::unique_ptr<HttpCallFactory> quota_call_factory_;
@@ -84,6 +151,16 @@ class ClientCache : public Logger::Loggable<Logger::Id::filter> {
 
   // Used to retrieve the current time for tracing.
   Envoy::TimeSource& time_source_;
+
+  // The local token buckets of the consumers' quota, null if disabled, and
+  // the timer refilling them.
+  std::unique_ptr<QuotaBucketCache> quota_bucket_cache_;
+  std::chrono::milliseconds quota_refill_interval_;
+  Event::TimerPtr quota_refill_timer_;
+
+  // When the batcher is destroyed, it sends the last batch with
+  // report_call_factory_, so it is placed last to be destroyed first.
+  std::unique_ptr<ReportBatcher> report_batcher_;
 };
 
 }  // namespace ServiceControl
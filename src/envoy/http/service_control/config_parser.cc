@@ -17,6 +17,7 @@
 #include "common/protobuf/utility.h"
 
 using ::google::api::envoy::http::service_control::FilterConfig;
+using ::google::api::envoy::http::service_control::ServiceControlTransport;
 
 namespace Envoy {
 namespace Extensions {
@@ -29,8 +30,22 @@ const char kUnrecognizedOperation[] = "<Unknown Operation Name>";
 }  // namespace
 
 FilterConfigParser::FilterConfigParser(const FilterConfig& config,
-                                       ServiceControlCallFactory& factory)
+                                       ServiceControlCallFactory& factory,
+                                       TimeSource& time_source)
     : config_(config) {
+  if (config_.sc_transport().auth() == ServiceControlTransport::HEADER) {
+    if (config_.sc_transport().auth_header_name().empty()) {
+      throw ProtoValidationException(
+          "auth_header_name is required with the HEADER auth",
+          config_.sc_transport());
+    }
+    if (!config_.sc_transport().has_auth_header_value()) {
+      throw ProtoValidationException(
+          "auth_header_value is required with the HEADER auth",
+          config_.sc_transport());
+    }
+  }
+
   ServiceContext* first_srv_ctx = nullptr;
   for (const auto& service : config_.services()) {
     ServiceContext* srv_ctx = new ServiceContext(service, factory);
@@ -52,9 +67,10 @@ FilterConfigParser::FilterConfigParser(const FilterConfig& config,
     if (service_it == service_map_.end()) {
       throw ProtoValidationException("Invalid service name", requirement);
     }
-    requirements_map_.emplace(requirement.operation_name(),
-                              RequirementContextPtr(new RequirementContext(
-                                  requirement, *service_it->second)));
+    requirements_map_.emplace(
+        requirement.operation_name(),
+        RequirementContextPtr(new RequirementContext(
+            requirement, *service_it->second, time_source)));
   }
 
   if (requirements_map_.size() <
@@ -66,7 +82,13 @@ FilterConfigParser::FilterConfigParser(const FilterConfig& config,
   non_match_rqm_cfg_.set_service_name(first_srv_ctx->config().service_name());
   non_match_rqm_cfg_.set_operation_name(kUnrecognizedOperation);
   non_match_rqm_ctx_.reset(
-      new RequirementContext(non_match_rqm_cfg_, *first_srv_ctx));
+      new RequirementContext(non_match_rqm_cfg_, *first_srv_ctx, time_source));
+
+  if (config_.has_global_rate_limit()) {
+    global_rate_limiter_ = std::make_unique<RateLimiter>(
+        config_.global_rate_limit().requests_per_second(),
+        config_.global_rate_limit().burst(), time_source);
+  }
 
   // The default places to extract api-key
   default_api_keys_.add_locations()->set_query("key");
@@ -19,10 +19,14 @@
 
 #include "api/envoy/http/service_control/config.pb.h"
 #include "api/envoy/http/service_control/requirement.pb.h"
+#include "envoy/common/time.h"
+#include "envoy/http/header_map.h"
+#include "src/envoy/http/service_control/rate_limiter.h"
 #include "src/envoy/http/service_control/service_control_call.h"
 
 // Default minimum interval (milliseconds) for streaming reports.
 #define kDefaultMinStreamReportIntervalMs 10000
+#define kDefaultConsumerProjectHeader "x-endpoint-api-project-id"
 
 namespace Envoy {
 namespace Extensions {
@@ -34,7 +38,12 @@ class ServiceContext {
   ServiceContext(
       const ::google::api::envoy::http::service_control::Service& config,
       ServiceControlCallFactory& factory)
-      : config_(config), service_control_call_(factory.create(config_)) {
+      : config_(config),
+        service_control_call_(factory.create(config_)),
+        consumer_project_header_(config_.consumer_project_header().empty()
+                                     ? kDefaultConsumerProjectHeader
+                                     : config_.consumer_project_header()),
+        consumer_id_header_(config_.consumer_id_header()) {
     min_stream_report_interval_ms_ = config_.min_stream_report_interval_ms();
     if (!min_stream_report_interval_ms_) {
       min_stream_report_interval_ms_ = kDefaultMinStreamReportIntervalMs;
@@ -51,10 +60,21 @@ class ServiceContext {
 
   ServiceControlCall& call() const { return *service_control_call_; }
 
+  const Http::LowerCaseString& consumer_project_header() const {
+    return consumer_project_header_;
+  }
+
+  // The header of the consumer ID, empty if not set.
+  const Http::LowerCaseString& consumer_id_header() const {
+    return consumer_id_header_;
+  }
+
  private:
   const ::google::api::envoy::http::service_control::Service& config_;
   ServiceControlCallPtr service_control_call_;
   int64_t min_stream_report_interval_ms_;
+  const Http::LowerCaseString consumer_project_header_;
+  const Http::LowerCaseString consumer_id_header_;
 };
 typedef std::unique_ptr<ServiceContext> ServiceContextPtr;
 
@@ -62,12 +82,17 @@ class RequirementContext {
  public:
   RequirementContext(
       const ::google::api::envoy::http::service_control::Requirement& config,
-      const ServiceContext& service_ctx)
+      const ServiceContext& service_ctx, TimeSource& time_source)
       : config_(config), service_ctx_(service_ctx) {
     for (const auto& metric_cost : config.metric_costs()) {
       metric_costs_.push_back(
           std::make_pair(metric_cost.name(), metric_cost.cost()));
     }
+    if (config.has_rate_limit()) {
+      rate_limiter_ = std::make_unique<RateLimiter>(
+          config.rate_limit().requests_per_second(),
+          config.rate_limit().burst(), time_source);
+    }
   }
 
   const ::google::api::envoy::http::service_control::Requirement& config()
@@ -81,10 +106,14 @@ class RequirementContext {
     return &metric_costs_;
   }
 
+  // The limit of the requests of the operation, nullptr if unlimited.
+  RateLimiter* rate_limiter() const { return rate_limiter_.get(); }
+
  private:
   const ::google::api::envoy::http::service_control::Requirement& config_;
   const ServiceContext& service_ctx_;
   std::vector<std::pair<std::string, int>> metric_costs_;
+  RateLimiterPtr rate_limiter_;
 };
 typedef std::unique_ptr<RequirementContext> RequirementContextPtr;
 
@@ -92,7 +121,7 @@ class FilterConfigParser {
  public:
   FilterConfigParser(
       const ::google::api::envoy::http::service_control::FilterConfig& config,
-      ServiceControlCallFactory& factory);
+      ServiceControlCallFactory& factory, TimeSource& time_source);
 
   const ::google::api::envoy::http::service_control::FilterConfig& config()
       const {
@@ -115,6 +144,11 @@ class FilterConfigParser {
     return non_match_rqm_ctx_.get();
   }
 
+  // The limit of the requests of all the operations, nullptr if unlimited.
+  RateLimiter* global_rate_limiter() const {
+    return global_rate_limiter_.get();
+  }
+
  private:
   // The proto config.
   const ::google::api::envoy::http::service_control::FilterConfig& config_;
@@ -128,6 +162,8 @@ class FilterConfigParser {
   // The default locations to extract api-key.
   ::google::api::envoy::http::service_control::ApiKeyRequirement
       default_api_keys_;
+  // The limit of the requests of all the operations.
+  RateLimiterPtr global_rate_limiter_;
 };
 
 }  // namespace ServiceControl
@@ -14,6 +14,7 @@
 
 #include "src/envoy/http/service_control/config_parser.h"
 #include "src/envoy/http/service_control/mocks.h"
+#include "test/test_common/simulated_time_system.h"
 #include "test/test_common/utility.h"
 
 #include "gmock/gmock.h"
@@ -32,9 +33,11 @@ using ::google::protobuf::TextFormat;
 TEST(ConfigParserTest, EmptyConfig) {
   FilterConfig config;
   testing::NiceMock<MockServiceControlCallFactory> mock_factory;
+  Event::SimulatedTimeSystem time_system;
 
-  EXPECT_THROW_WITH_REGEX(FilterConfigParser parser(config, mock_factory),
-                          ProtoValidationException, "Empty services");
+  EXPECT_THROW_WITH_REGEX(
+      FilterConfigParser parser(config, mock_factory, time_system),
+      ProtoValidationException, "Empty services");
 }
 
 TEST(ConfigParserTest, ValidConfig) {
@@ -56,7 +59,8 @@ requirements {
 })";
   ASSERT_TRUE(TextFormat::ParseFromString(kFilterConfigBasic, &config));
   testing::NiceMock<MockServiceControlCallFactory> mock_factory;
-  FilterConfigParser parser(config, mock_factory);
+  Event::SimulatedTimeSystem time_system;
+  FilterConfigParser parser(config, mock_factory, time_system);
 
   EXPECT_EQ(parser.FindRequirement("get_foo")->config().operation_name(),
             "get_foo");
@@ -73,6 +77,37 @@ requirements {
   EXPECT_FALSE(parser.FindRequirement("non-existing-operation"));
 }
 
+TEST(ConfigParserTest, RateLimits) {
+  FilterConfig config;
+  const char kFilterConfigRateLimits[] = R"(
+services {
+  service_name: "echo"
+}
+requirements {
+  service_name: "echo"
+  operation_name: "get_foo"
+  rate_limit {
+    requests_per_second: 10
+  }
+}
+requirements {
+  service_name: "echo"
+  operation_name: "post_bar"
+}
+global_rate_limit {
+  requests_per_second: 100
+  burst: 200
+})";
+  ASSERT_TRUE(TextFormat::ParseFromString(kFilterConfigRateLimits, &config));
+  testing::NiceMock<MockServiceControlCallFactory> mock_factory;
+  Event::SimulatedTimeSystem time_system;
+  FilterConfigParser parser(config, mock_factory, time_system);
+
+  EXPECT_TRUE(parser.FindRequirement("get_foo")->rate_limiter());
+  EXPECT_FALSE(parser.FindRequirement("post_bar")->rate_limiter());
+  EXPECT_TRUE(parser.global_rate_limiter());
+}
+
 TEST(ConfigParserTest, DuplicatedServiceNames) {
   FilterConfig config;
   const char kConfigWithDupliacedService[] = R"(
@@ -85,8 +120,51 @@ services {
   ASSERT_TRUE(
       TextFormat::ParseFromString(kConfigWithDupliacedService, &config));
   testing::NiceMock<MockServiceControlCallFactory> mock_factory;
-  EXPECT_THROW_WITH_REGEX(FilterConfigParser parser(config, mock_factory),
-                          ProtoValidationException, "Duplicated service names");
+  Event::SimulatedTimeSystem time_system;
+  EXPECT_THROW_WITH_REGEX(
+      FilterConfigParser parser(config, mock_factory, time_system),
+      ProtoValidationException, "Duplicated service names");
+}
+
+TEST(ConfigParserTest, HeaderAuthWithoutHeaderName) {
+  FilterConfig config;
+  const char kConfigWithHeaderAuth[] = R"(
+services {
+  service_name: "echo"
+}
+sc_transport {
+  protocol: GRPC
+  auth: HEADER
+  auth_header_value {
+    filename: "/etc/metering/api_key"
+  }
+})";
+  ASSERT_TRUE(TextFormat::ParseFromString(kConfigWithHeaderAuth, &config));
+  testing::NiceMock<MockServiceControlCallFactory> mock_factory;
+  Event::SimulatedTimeSystem time_system;
+  EXPECT_THROW_WITH_REGEX(
+      FilterConfigParser parser(config, mock_factory, time_system),
+      ProtoValidationException,
+      "auth_header_name is required with the HEADER auth");
+}
+
+TEST(ConfigParserTest, HeaderAuthWithoutHeaderValue) {
+  FilterConfig config;
+  const char kConfigWithHeaderAuth[] = R"(
+services {
+  service_name: "echo"
+}
+sc_transport {
+  auth: HEADER
+  auth_header_name: "x-api-key"
+})";
+  ASSERT_TRUE(TextFormat::ParseFromString(kConfigWithHeaderAuth, &config));
+  testing::NiceMock<MockServiceControlCallFactory> mock_factory;
+  Event::SimulatedTimeSystem time_system;
+  EXPECT_THROW_WITH_REGEX(
+      FilterConfigParser parser(config, mock_factory, time_system),
+      ProtoValidationException,
+      "auth_header_value is required with the HEADER auth");
 }
 
 TEST(ConfigParserTest, DuplicatedOperationNames) {
@@ -106,9 +184,10 @@ requirements {
   ASSERT_TRUE(
       TextFormat::ParseFromString(kConfigWithDupliacedService, &config));
   testing::NiceMock<MockServiceControlCallFactory> mock_factory;
-  EXPECT_THROW_WITH_REGEX(FilterConfigParser parser(config, mock_factory),
-                          ProtoValidationException,
-                          "Duplicated operation names");
+  Event::SimulatedTimeSystem time_system;
+  EXPECT_THROW_WITH_REGEX(
+      FilterConfigParser parser(config, mock_factory, time_system),
+      ProtoValidationException, "Duplicated operation names");
 }
 
 TEST(ConfigParserTest, InvalidServiceInRequirement) {
@@ -123,8 +202,10 @@ requirements {
 })";
   ASSERT_TRUE(TextFormat::ParseFromString(kFilterInvalidService, &config));
   testing::NiceMock<MockServiceControlCallFactory> mock_factory;
-  EXPECT_THROW_WITH_REGEX(FilterConfigParser parser(config, mock_factory),
-                          ProtoValidationException, "Invalid service name");
+  Event::SimulatedTimeSystem time_system;
+  EXPECT_THROW_WITH_REGEX(
+      FilterConfigParser parser(config, mock_factory, time_system),
+      ProtoValidationException, "Invalid service name");
 }
 
 }  // namespace
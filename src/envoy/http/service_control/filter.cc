@@ -36,6 +36,10 @@ typedef ConstSingleton<RcDetailsValues> RcDetails;
 
 void ServiceControlFilter::onDestroy() {
   ENVOY_LOG(debug, "Called ServiceControl Filter : {}", __func__);
+  if (stream_report_timer_) {
+    stream_report_timer_->disableTimer();
+    stream_report_timer_.reset();
+  }
   if (handler_) {
     handler_->onDestroy();
   }
@@ -78,11 +82,27 @@ void ServiceControlFilter::onCheckDone(
 
   stats_.allowed_.inc();
   state_ = Complete;
+  startStreamReportTimer();
   if (stopped_) {
     decoder_callbacks_->continueDecoding();
   }
 }
 
+void ServiceControlFilter::startStreamReportTimer() {
+  const std::chrono::milliseconds interval = handler_->streamReportInterval();
+  if (interval.count() <= 0) {
+    return;
+  }
+  // The handler skips the report if one was sent for the streamed data within
+  // the interval.
+  stream_report_timer_ =
+      decoder_callbacks_->dispatcher().createTimer([this, interval]() {
+        handler_->tryIntermediateReport(std::chrono::system_clock::now());
+        stream_report_timer_->enableTimer(interval);
+      });
+  stream_report_timer_->enableTimer(interval);
+}
+
 void ServiceControlFilter::rejectRequest(Http::Code code,
                                          absl::string_view error_msg) {
   stats_.denied_.inc();
@@ -118,13 +138,23 @@ Http::FilterTrailersStatus ServiceControlFilter::decodeTrailers(
 }
 
 Http::FilterHeadersStatus ServiceControlFilter::encodeHeaders(
-    Http::ResponseHeaderMap& headers, bool) {
+    Http::ResponseHeaderMap& headers, bool end_stream) {
   ENVOY_LOG(debug, "Called ServiceControl Filter : {} before", __func__);
 
   // For the cases the decodeHeaders not called, like the request get failed in
   // the Jwt-Authn filter, the handler_ is not initialized.
-  if (handler_ != nullptr) {
-    handler_->processResponseHeaders(headers);
+  if (handler_ == nullptr) {
+    return Http::FilterHeadersStatus::Continue;
+  }
+  handler_->processResponseHeaders(headers);
+
+  // Buffer the whole response body to limit its size. If the limit is
+  // exceeded, Envoy replies with an error instead, which is reported.
+  const uint32_t max_response_body_bytes = handler_->maxResponseBodyBytes();
+  if (max_response_body_bytes > 0 && !end_stream) {
+    encoder_callbacks_->setEncoderBufferLimit(max_response_body_bytes);
+    buffer_response_ = true;
+    return Http::FilterHeadersStatus::StopIteration;
   }
   return Http::FilterHeadersStatus::Continue;
 }
@@ -132,6 +162,10 @@ Http::FilterHeadersStatus ServiceControlFilter::encodeHeaders(
 Http::FilterDataStatus ServiceControlFilter::encodeData(Buffer::Instance& data,
                                                         bool end_stream) {
   ENVOY_LOG(debug, "Called ServiceControl Filter : {}", __func__);
+  if (buffer_response_) {
+    return end_stream ? Http::FilterDataStatus::Continue
+                      : Http::FilterDataStatus::StopIterationAndBuffer;
+  }
   if (!end_stream && data.length() > 0) {
     handler_->tryIntermediateReport(std::chrono::system_clock::now());
   }
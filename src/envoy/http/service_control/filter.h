@@ -17,6 +17,7 @@
 #include "common/common/logger.h"
 #include "envoy/access_log/access_log.h"
 #include "envoy/http/filter.h"
+#include "envoy/event/timer.h"
 #include "envoy/http/header_map.h"
 #include "extensions/filters/http/common/pass_through_filter.h"
 #include "src/envoy/http/service_control/filter_stats.h"
@@ -66,6 +67,10 @@ class ServiceControlFilter : public Http::PassThroughFilter,
  private:
   void rejectRequest(Http::Code code, absl::string_view error_msg);
 
+  // Starts the timer of the intermediate reports of a long-lived stream, so
+  // that it is reported even if no data is streamed.
+  void startStreamReportTimer();
+
   ServiceControlFilterStats& stats_;
   const ServiceControlHandlerFactory& factory_;
 
@@ -77,6 +82,10 @@ class ServiceControlFilter : public Http::PassThroughFilter,
   State state_ = Init;
   // Mark if request has been stopped.
   bool stopped_ = false;
+  // Mark if the response body is buffered to limit its size.
+  bool buffer_response_ = false;
+  // The timer of the intermediate reports, null if not a stream.
+  Event::TimerPtr stream_report_timer_;
 };
 
 }  // namespace ServiceControl
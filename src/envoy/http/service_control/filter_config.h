@@ -42,8 +42,8 @@ class ServiceControlFilterConfig : public Logger::Loggable<Logger::Id::filter>,
             std::make_shared<
                 ::google::api::envoy::http::service_control::FilterConfig>(
                 proto_config)),
-        call_factory_(proto_config_, context),
-        config_parser_(*proto_config_, call_factory_),
+        call_factory_(proto_config_, stats(), context),
+        config_parser_(*proto_config_, call_factory_, context.timeSource()),
         handler_factory_(context.random(), config_parser_) {}
 
   const ServiceControlHandlerFactory& handler_factory() const {
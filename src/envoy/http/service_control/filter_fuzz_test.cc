@@ -125,6 +125,12 @@ DEFINE_PROTO_FUZZER(
   EXPECT_CALL(mock_decoder_callbacks, activeSpan())
       .WillRepeatedly(ReturnRef(Envoy::Tracing::NullSpan::instance()));
 
+  // Timer of the intermediate reports of the streams.
+  EXPECT_CALL(mock_decoder_callbacks.dispatcher_, createTimer_(_))
+      .WillRepeatedly(Invoke([](const Envoy::Event::TimerCb&) {
+        return new NiceMock<Event::MockTimer>();
+      }));
+
   // Callback for token subscriber to start.
   Envoy::Event::TimerCb onReadyCallback;
   EXPECT_CALL(context.dispatcher_, createTimer_(_))
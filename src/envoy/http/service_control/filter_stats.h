@@ -29,7 +29,17 @@ namespace ServiceControl {
 // clang-format off
 #define ALL_SERVICE_CONTROL_FILTER_STATS(COUNTER)     \
   COUNTER(allowed)                                    \
-  COUNTER(denied)
+  COUNTER(denied)                                     \
+  COUNTER(report_batches_sent)                        \
+  COUNTER(reports_dropped)                            \
+  COUNTER(circuit_breaker_opened)                     \
+  COUNTER(reports_queued)                             \
+  COUNTER(reports_spilled)                            \
+  COUNTER(check_cache_hits)                           \
+  COUNTER(check_cache_misses)                         \
+  COUNTER(check_cache_stale_served)                   \
+  COUNTER(quota_bucket_hits)                          \
+  COUNTER(quota_bucket_misses)
 // clang-format on
 
 /**
@@ -16,6 +16,7 @@
 #include "gmock/gmock.h"
 #include "google/protobuf/text_format.h"
 #include "gtest/gtest.h"
+#include "test/mocks/event/mocks.h"
 #include "test/mocks/server/mocks.h"
 #include "test/mocks/stats/mocks.h"
 #include "test/mocks/tracing/mocks.h"
@@ -49,12 +50,14 @@ class ServiceControlFilterTest : public ::testing::Test {
     filter_ = std::make_unique<ServiceControlFilter>(stats_base_.stats(),
                                                      mock_handler_factory_);
     filter_->setDecoderFilterCallbacks(mock_decoder_callbacks_);
+    filter_->setEncoderFilterCallbacks(mock_encoder_callbacks_);
 
     mock_span_ = std::make_unique<Envoy::Tracing::MockSpan>();
   }
 
   std::unique_ptr<ServiceControlFilter> filter_;
   testing::NiceMock<MockStreamDecoderFilterCallbacks> mock_decoder_callbacks_;
+  testing::NiceMock<MockStreamEncoderFilterCallbacks> mock_encoder_callbacks_;
   testing::NiceMock<MockFactoryContext> mock_factory_context_;
   testing::NiceMock<MockServiceControlHandlerFactory> mock_handler_factory_;
   testing::NiceMock<MockBuffer> mock_buffer_;
@@ -117,6 +120,51 @@ TEST_F(ServiceControlFilterTest, DecodeHeadersSyncBadStatus) {
             filter_->decodeHeaders(req_headers_, true));
 }
 
+TEST_F(ServiceControlFilterTest, StreamReportTimer) {
+  // Test: The intermediate reports of a stream are tried periodically once
+  // the check is done, and the timer is disabled on destroy.
+  auto* mock_handler = new testing::NiceMock<MockServiceControlHandler>();
+  EXPECT_CALL(mock_handler_factory_, createHandler_(_, _))
+      .WillOnce(Return(mock_handler));
+  EXPECT_CALL(*mock_handler, streamReportInterval())
+      .WillOnce(Return(std::chrono::milliseconds(100)));
+  EXPECT_CALL(*mock_handler, callCheck(_, _, _))
+      .WillOnce(Invoke([](Http::RequestHeaderMap&, Envoy::Tracing::Span&,
+                          ServiceControlHandler::CheckDoneCallback& callback) {
+        callback.onCheckDone(Status::OK);
+      }));
+
+  auto* timer = new testing::NiceMock<Event::MockTimer>(
+      &mock_decoder_callbacks_.dispatcher_);
+  EXPECT_CALL(*timer, enableTimer(std::chrono::milliseconds(100), _)).Times(2);
+  EXPECT_EQ(Http::FilterHeadersStatus::Continue,
+            filter_->decodeHeaders(req_headers_, false));
+
+  EXPECT_CALL(*mock_handler, tryIntermediateReport(_));
+  timer->invokeCallback();
+
+  EXPECT_CALL(*timer, disableTimer());
+  filter_->onDestroy();
+}
+
+TEST_F(ServiceControlFilterTest, NoStreamReportTimer) {
+  // Test: No timer is created for the requests which are not streams.
+  auto* mock_handler = new testing::NiceMock<MockServiceControlHandler>();
+  EXPECT_CALL(mock_handler_factory_, createHandler_(_, _))
+      .WillOnce(Return(mock_handler));
+  EXPECT_CALL(*mock_handler, streamReportInterval())
+      .WillOnce(Return(std::chrono::milliseconds(0)));
+  EXPECT_CALL(*mock_handler, callCheck(_, _, _))
+      .WillOnce(Invoke([](Http::RequestHeaderMap&, Envoy::Tracing::Span&,
+                          ServiceControlHandler::CheckDoneCallback& callback) {
+        callback.onCheckDone(Status::OK);
+      }));
+
+  EXPECT_CALL(mock_decoder_callbacks_.dispatcher_, createTimer_(_)).Times(0);
+  EXPECT_EQ(Http::FilterHeadersStatus::Continue,
+            filter_->decodeHeaders(req_headers_, true));
+}
+
 TEST_F(ServiceControlFilterTest, DecodeHeadersAsyncGoodStatus) {
   // Test: While Filter is Calling/stopped, onCheckDone calls
   // continueDecoding
@@ -284,6 +332,55 @@ TEST_F(ServiceControlFilterTest, EncodeDataSendStreamReport) {
   filter_->encodeData(mock_buffer_, /*end_stream=*/false);
 }
 
+TEST_F(ServiceControlFilterTest, EncodeHeadersBufferResponseWithLimit) {
+  // This puts the Filter into a continue state
+  auto* mock_handler = new testing::NiceMock<MockServiceControlHandler>();
+  EXPECT_CALL(mock_handler_factory_, createHandler_(_, _))
+      .WillOnce(Return(mock_handler));
+  EXPECT_CALL(*mock_handler, callCheck(_, _, _))
+      .WillOnce(Invoke([](Http::RequestHeaderMap&, Envoy::Tracing::Span&,
+                          ServiceControlHandler::CheckDoneCallback& callback) {
+        callback.onCheckDone(Status::OK);
+      }));
+  EXPECT_EQ(Http::FilterHeadersStatus::Continue,
+            filter_->decodeHeaders(req_headers_, /*end_stream=*/true));
+
+  // Test: The response body is buffered up to the limit.
+  EXPECT_CALL(*mock_handler, maxResponseBodyBytes()).WillOnce(Return(1024));
+  EXPECT_CALL(mock_encoder_callbacks_, setEncoderBufferLimit(1024));
+  EXPECT_EQ(Http::FilterHeadersStatus::StopIteration,
+            filter_->encodeHeaders(resp_headers_, /*end_stream=*/false));
+
+  mock_buffer_.add("filler");
+
+  // Test: No intermediate report while buffering.
+  EXPECT_CALL(*mock_handler, tryIntermediateReport(_)).Times(0);
+  EXPECT_EQ(Http::FilterDataStatus::StopIterationAndBuffer,
+            filter_->encodeData(mock_buffer_, /*end_stream=*/false));
+  EXPECT_EQ(Http::FilterDataStatus::Continue,
+            filter_->encodeData(mock_buffer_, /*end_stream=*/true));
+}
+
+TEST_F(ServiceControlFilterTest, EncodeHeadersWithoutLimit) {
+  // This puts the Filter into a continue state
+  auto* mock_handler = new testing::NiceMock<MockServiceControlHandler>();
+  EXPECT_CALL(mock_handler_factory_, createHandler_(_, _))
+      .WillOnce(Return(mock_handler));
+  EXPECT_CALL(*mock_handler, callCheck(_, _, _))
+      .WillOnce(Invoke([](Http::RequestHeaderMap&, Envoy::Tracing::Span&,
+                          ServiceControlHandler::CheckDoneCallback& callback) {
+        callback.onCheckDone(Status::OK);
+      }));
+  EXPECT_EQ(Http::FilterHeadersStatus::Continue,
+            filter_->decodeHeaders(req_headers_, /*end_stream=*/true));
+
+  // Test: The response is not buffered without a limit.
+  EXPECT_CALL(*mock_handler, maxResponseBodyBytes()).WillOnce(Return(0));
+  EXPECT_CALL(mock_encoder_callbacks_, setEncoderBufferLimit(_)).Times(0);
+  EXPECT_EQ(Http::FilterHeadersStatus::Continue,
+            filter_->encodeHeaders(resp_headers_, /*end_stream=*/false));
+}
+
 }  // namespace
 
 }  // namespace ServiceControl
@@ -57,6 +57,13 @@ class ServiceControlHandler {
   virtual void processResponseHeaders(
       const Http::ResponseHeaderMap& response_headers) PURE;
 
+  // The maximum size of the response body, 0 if unlimited.
+  virtual uint32_t maxResponseBodyBytes() const PURE;
+
+  // The interval of the intermediate reports of the long-lived gRPC streams
+  // and WebSocket connections, 0 if the request is neither.
+  virtual std::chrono::milliseconds streamReportInterval() const PURE;
+
   // The request is about to be destroyed need to cancel all async requests.
   virtual void onDestroy() PURE;
 };
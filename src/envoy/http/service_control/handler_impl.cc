@@ -15,6 +15,7 @@
 #include <chrono>
 
 #include "absl/strings/match.h"
+#include "absl/strings/str_cat.h"
 #include "common/http/utility.h"
 #include "extensions/filters/http/grpc_stats/grpc_stats_filter.h"
 #include "src/envoy/http/service_control/handler_impl.h"
@@ -32,14 +33,18 @@ namespace Extensions {
 namespace HttpFilters {
 namespace ServiceControl {
 
-// The HTTP header to send consumer project to backend.
-const Http::LowerCaseString kConsumerProjectId("x-endpoint-api-project-id");
-
 // CheckRequest headers
 const Http::LowerCaseString kIosBundleIdHeader{"x-ios-bundle-identifier"};
 const Http::LowerCaseString kAndroidPackageHeader{"x-android-package"};
 const Http::LowerCaseString kAndroidCertHeader{"x-android-cert"};
-const Http::LowerCaseString kRefererHeader{"referer"};
+
+// The tags set on the spans of the requests with tracing_custom_tags.
+constexpr char kTracingTagApiName[] = "api_name";
+constexpr char kTracingTagApiVersion[] = "api_version";
+constexpr char kTracingTagOperationName[] = "operation_name";
+constexpr char kTracingTagApiKeyProject[] = "api_key_project";
+constexpr char kTracingTagConsumerId[] = "consumer_id";
+constexpr char kConsumerIdProject[] = "project:";
 
 constexpr char JwtPayloadIssuerPath[] = "iss";
 constexpr char JwtPayloadAuidencePath[] = "aud";
@@ -54,10 +59,14 @@ ServiceControlHandlerImpl::ServiceControlHandlerImpl(
       uuid_(uuid),
       request_header_size_(0),
       response_header_size_(0),
+      reported_request_bytes_(0),
+      reported_response_bytes_(0),
       is_grpc_(false),
+      is_websocket_(false),
       is_first_report_(true),
       last_reported_(now) {
   is_grpc_ = Envoy::Grpc::Common::hasGrpcContentType(headers);
+  is_websocket_ = Http::Utility::isWebSocketUpgradeRequest(headers);
 
   absl::string_view original_http_method =
       Utils::readHeaderEntry(headers.Method());
@@ -152,6 +161,20 @@ void ServiceControlHandlerImpl::callCheck(Http::RequestHeaderMap& headers,
     return;
   }
   check_callback_ = &callback;
+  setApiSpanTags(parent_span);
+
+  // The consumer headers are only set by the check, never by the client.
+  const ServiceContext& service_ctx = require_ctx_->service_ctx();
+  headers.remove(service_ctx.consumer_project_header());
+  if (!service_ctx.consumer_id_header().get().empty()) {
+    headers.remove(service_ctx.consumer_id_header());
+  }
+
+  if (isRateLimited()) {
+    check_status_ = Status(Code::RESOURCE_EXHAUSTED, "Rate limit exceeded.");
+    callback.onCheckDone(check_status_);
+    return;
+  }
 
   if (!isCheckRequired()) {
     callQuota();
@@ -174,7 +197,7 @@ void ServiceControlHandlerImpl::callCheck(Http::RequestHeaderMap& headers,
 
   info.ios_bundle_id =
       std::string(Utils::extractHeader(headers, kIosBundleIdHeader));
-  info.referer = std::string(Utils::extractHeader(headers, kRefererHeader));
+  info.referer = getReferer(headers);
   info.android_package_name =
       std::string(Utils::extractHeader(headers, kAndroidPackageHeader));
   info.android_cert_fingerprint =
@@ -183,17 +206,28 @@ void ServiceControlHandlerImpl::callCheck(Http::RequestHeaderMap& headers,
   on_check_done_called_ = false;
   cancel_fn_ = require_ctx_->service_ctx().call().callCheck(
       info, parent_span,
-      [this, &headers](const Status& status,
-                       const CheckResponseInfo& response_info) {
+      [this, &headers, &parent_span](const Status& status,
+                                     const CheckResponseInfo& response_info) {
         cancel_fn_ = nullptr;
         on_check_done_called_ = true;
-        onCheckResponse(headers, status, response_info);
+        onCheckResponse(headers, parent_span, status, response_info);
       });
   if (on_check_done_called_) {
     cancel_fn_ = nullptr;
   }
 }
 
+bool ServiceControlHandlerImpl::isRateLimited() {
+  // The requests over the limit of the operation do not take the tokens of
+  // all the operations.
+  RateLimiter* rate_limiter = require_ctx_->rate_limiter();
+  if (rate_limiter != nullptr && !rate_limiter->tryAcquire()) {
+    return true;
+  }
+  RateLimiter* global_rate_limiter = cfg_parser_.global_rate_limiter();
+  return global_rate_limiter != nullptr && !global_rate_limiter->tryAcquire();
+}
+
 // TODO(taoxuy): add unit test
 void ServiceControlHandlerImpl::callQuota() {
   if (!isQuotaRequired()) {
@@ -219,16 +253,23 @@ void ServiceControlHandlerImpl::callQuota() {
 }
 
 void ServiceControlHandlerImpl::onCheckResponse(
-    Http::RequestHeaderMap& headers, const Status& status,
-    const CheckResponseInfo& response_info) {
+    Http::RequestHeaderMap& headers, Envoy::Tracing::Span& parent_span,
+    const Status& status, const CheckResponseInfo& response_info) {
   check_response_info_ = response_info;
 
   check_status_ = status;
 
-  // Set consumer project_id to backend.
+  // Set consumer project_id and consumer ID to backend.
   if (!response_info.consumer_project_id.empty()) {
-    headers.setReferenceKey(kConsumerProjectId,
+    const ServiceContext& service_ctx = require_ctx_->service_ctx();
+    headers.setReferenceKey(service_ctx.consumer_project_header(),
                             response_info.consumer_project_id);
+    if (!service_ctx.consumer_id_header().get().empty()) {
+      headers.setReferenceKey(
+          service_ctx.consumer_id_header(),
+          absl::StrCat(kConsumerIdProject, response_info.consumer_project_id));
+    }
+    setConsumerSpanTags(parent_span);
   }
 
   if (!check_status_.ok()) {
@@ -239,6 +280,34 @@ void ServiceControlHandlerImpl::onCheckResponse(
   callQuota();
 }
 
+void ServiceControlHandlerImpl::setApiSpanTags(Envoy::Tracing::Span& span) {
+  for (const auto& tag :
+       require_ctx_->service_ctx().config().tracing_custom_tags()) {
+    if (tag == kTracingTagApiName) {
+      span.setTag(tag, require_ctx_->config().api_name());
+    } else if (tag == kTracingTagApiVersion) {
+      span.setTag(tag, require_ctx_->config().api_version());
+    } else if (tag == kTracingTagOperationName) {
+      span.setTag(tag, require_ctx_->config().operation_name());
+    }
+  }
+}
+
+void ServiceControlHandlerImpl::setConsumerSpanTags(
+    Envoy::Tracing::Span& span) {
+  // The consumer is identified by the project of its API key, never by the
+  // API key itself.
+  const std::string& project = check_response_info_.consumer_project_id;
+  for (const auto& tag :
+       require_ctx_->service_ctx().config().tracing_custom_tags()) {
+    if (tag == kTracingTagApiKeyProject) {
+      span.setTag(tag, project);
+    } else if (tag == kTracingTagConsumerId) {
+      span.setTag(tag, absl::StrCat(kConsumerIdProject, project));
+    }
+  }
+}
+
 void ServiceControlHandlerImpl::processResponseHeaders(
     const Http::ResponseHeaderMap& response_headers) {
   frontend_protocol_ = getFrontendProtocol(&response_headers, stream_info_);
@@ -282,13 +351,18 @@ void ServiceControlHandlerImpl::callReport(
       require_ctx_->service_ctx().config().jwt_payload_metadata_name(),
       JwtPayloadAuidencePath, info.auth_audience);
 
+  fillReportLabels(
+      request_headers, stream_info_.dynamicMetadata(),
+      require_ctx_->service_ctx().config().jwt_payload_metadata_name(),
+      require_ctx_->service_ctx().config().report_labels(),
+      info.custom_labels);
+
   info.frontend_protocol = getFrontendProtocol(response_headers, stream_info_);
   info.backend_protocol =
       getBackendProtocol(require_ctx_->service_ctx().config());
 
   if (request_headers) {
-    info.referer =
-        std::string(Utils::extractHeader(*request_headers, kRefererHeader));
+    info.referer = getReferer(*request_headers);
   }
 
   fillLatency(stream_info_, info.latency);
@@ -296,7 +370,8 @@ void ServiceControlHandlerImpl::callReport(
   info.response_code = stream_info_.responseCode().value_or(500);
 
   info.request_size = stream_info_.bytesReceived() + request_header_size_;
-  info.request_bytes = stream_info_.bytesReceived() + request_header_size_;
+  info.request_bytes =
+      info.request_size - static_cast<int64_t>(reported_request_bytes_);
 
   uint64_t response_header_size = 0;
   if (response_headers) {
@@ -306,7 +381,8 @@ void ServiceControlHandlerImpl::callReport(
     response_header_size += response_trailers->byteSize();
   }
   info.response_size = stream_info_.bytesSent() + response_header_size;
-  info.response_bytes = stream_info_.bytesSent() + response_header_size;
+  info.response_bytes =
+      info.response_size - static_cast<int64_t>(reported_response_bytes_);
 
   if (stream_info_.filterState().hasData<GrpcStats::GrpcStatsObject>(
           HttpFilterNames::get().GrpcStats)) {
@@ -329,7 +405,7 @@ void ServiceControlHandlerImpl::callReport(
 
 void ServiceControlHandlerImpl::tryIntermediateReport(
     std::chrono::system_clock::time_point now) {
-  if (!is_grpc_) {
+  if (!is_grpc_ && !is_websocket_) {
     return;
   }
 
@@ -344,8 +420,12 @@ void ServiceControlHandlerImpl::tryIntermediateReport(
   ::google::api_proxy::service_control::ReportRequestInfo info;
   prepareReportRequest(info, now);
 
-  info.request_bytes = stream_info_.bytesReceived() + request_header_size_;
-  info.response_bytes = stream_info_.bytesSent() + response_header_size_;
+  const uint64_t request_bytes =
+      stream_info_.bytesReceived() + request_header_size_;
+  const uint64_t response_bytes =
+      stream_info_.bytesSent() + response_header_size_;
+  info.request_bytes = request_bytes - reported_request_bytes_;
+  info.response_bytes = response_bytes - reported_response_bytes_;
 
   info.frontend_protocol = frontend_protocol_;
   info.is_first_report = is_first_report_;
@@ -353,6 +433,8 @@ void ServiceControlHandlerImpl::tryIntermediateReport(
   require_ctx_->service_ctx().call().callReport(info);
   last_reported_ = now;
   is_first_report_ = false;
+  reported_request_bytes_ = request_bytes;
+  reported_response_bytes_ = response_bytes;
 }
 
 }  // namespace ServiceControl
@@ -61,6 +61,19 @@ class ServiceControlHandlerImpl : public Logger::Loggable<Logger::Id::filter>,
   void processResponseHeaders(
       const Http::ResponseHeaderMap& response_headers) override;
 
+  uint32_t maxResponseBodyBytes() const override {
+    return isConfigured() ? require_ctx_->config().max_response_body_bytes()
+                          : 0;
+  }
+
+  std::chrono::milliseconds streamReportInterval() const override {
+    if (!isConfigured() || (!is_grpc_ && !is_websocket_)) {
+      return std::chrono::milliseconds(0);
+    }
+    return std::chrono::milliseconds(
+        require_ctx_->service_ctx().get_min_stream_report_interval_ms());
+  }
+
   void onDestroy() override;
 
  private:
@@ -91,8 +104,19 @@ class ServiceControlHandlerImpl : public Logger::Loggable<Logger::Id::filter>,
 
   bool hasApiKey() const { return !api_key_.empty(); }
 
+  // Takes a token from the limits of the operation and of all the operations,
+  // returns true if one of them is exceeded.
+  bool isRateLimited();
+
+  // Sets the API attributes in tracing_custom_tags as tags on the span.
+  void setApiSpanTags(Envoy::Tracing::Span& span);
+
+  // Sets the consumer attributes in tracing_custom_tags as tags on the span,
+  // from the check response.
+  void setConsumerSpanTags(Envoy::Tracing::Span& span);
+
   void onCheckResponse(
-      Http::RequestHeaderMap& headers,
+      Http::RequestHeaderMap& headers, Envoy::Tracing::Span& parent_span,
       const ::google::protobuf::util::Status& status,
       const ::google::api_proxy::service_control::CheckResponseInfo&
           response_info);
@@ -119,12 +143,20 @@ class ServiceControlHandlerImpl : public Logger::Loggable<Logger::Id::filter>,
   bool on_check_done_called_;
   uint64_t request_header_size_;
   uint64_t response_header_size_;
+  // The request and response bytes already reported by the intermediate
+  // reports. The bytes are delta metrics, so each report only has the bytes
+  // streamed since the previous one.
+  uint64_t reported_request_bytes_;
+  uint64_t reported_response_bytes_;
 
   // The frontend protocol only for intermediate reports.
   ::google::api_proxy::service_control::protocol::Protocol frontend_protocol_;
 
   // If true, it is a grpc and need to send multiple reports.
   bool is_grpc_;
+  // If true, it is an upgraded WebSocket connection and need to send multiple
+  // reports with the streamed bytes.
+  bool is_websocket_;
   // If true, this is the first report.
   bool is_first_report_;
   // Interval timer for sending intermediate reports.
@@ -20,6 +20,7 @@
 #include "gtest/gtest.h"
 #include "test/mocks/server/mocks.h"
 #include "test/mocks/tracing/mocks.h"
+#include "test/test_common/simulated_time_system.h"
 
 #include "src/envoy/http/service_control/handler_impl.h"
 #include "src/envoy/http/service_control/mocks.h"
@@ -168,8 +169,8 @@ class HandlerTest : public ::testing::Test {
 
     ASSERT_TRUE(TextFormat::ParseFromString(filter_config, &proto_config_));
     EXPECT_CALL(mock_call_factory_, create_(_)).WillOnce(Return(mock_call_));
-    cfg_parser_ =
-        std::make_unique<FilterConfigParser>(proto_config_, mock_call_factory_);
+    cfg_parser_ = std::make_unique<FilterConfigParser>(
+        proto_config_, mock_call_factory_, time_system_);
 
     mock_span_ = std::make_unique<Envoy::Tracing::MockSpan>();
   }
@@ -184,6 +185,7 @@ class HandlerTest : public ::testing::Test {
   testing::NiceMock<MockCheckDoneCallback> mock_check_done_callback_;
   testing::NiceMock<MockStreamInfo> mock_stream_info_;
   testing::NiceMock<MockServiceControlCallFactory> mock_call_factory_;
+  Event::SimulatedTimeSystem time_system_;
 
   // This pointer is managed by cfg_parser
   testing::NiceMock<MockServiceControlCall>* mock_call_;
@@ -412,6 +414,83 @@ TEST_F(HandlerTest, HandlerCheckNotNeeded) {
   handler.callReport(&headers, &response_headers, &resp_trailer_, epoch_);
 }
 
+TEST_F(HandlerTest, HandlerCheckRateLimited) {
+  // Test: The requests over the limit of the operation or of all the
+  // operations are rejected without calling check, and reported
+  const char kRateLimitFilterConfig[] = R"(
+services {
+  service_name: "echo"
+}
+requirements {
+  service_name: "echo"
+  api_name: "test_api"
+  api_version: "test_version"
+  operation_name: "get_no_key"
+  api_key: {
+    allow_without_api_key: true
+  }
+  rate_limit: {
+    requests_per_second: 1
+  }
+}
+requirements {
+  service_name: "echo"
+  api_name: "test_api"
+  api_version: "test_version"
+  operation_name: "get_no_key_unlimited"
+  api_key: {
+    allow_without_api_key: true
+  }
+}
+global_rate_limit: {
+  requests_per_second: 2
+})";
+  setUp(kRateLimitFilterConfig);
+  Status limited_status =
+      Status(Code::RESOURCE_EXHAUSTED, "Rate limit exceeded.");
+  TestRequestHeaderMapImpl headers{{":method", "GET"}, {":path", "/echo"}};
+  EXPECT_CALL(*mock_call_, callCheck(_, _, _)).Times(0);
+
+  Utils::setStringFilterState(*mock_stream_info_.filter_state_,
+                              Utils::kOperation, "get_no_key");
+  ServiceControlHandlerImpl handler1(headers, mock_stream_info_, "test-uuid",
+                                     *cfg_parser_);
+  EXPECT_CALL(mock_check_done_callback_, onCheckDone(Status::OK));
+  handler1.callCheck(headers, *mock_span_, mock_check_done_callback_);
+
+  ServiceControlHandlerImpl handler2(headers, mock_stream_info_, "test-uuid",
+                                     *cfg_parser_);
+  EXPECT_CALL(mock_check_done_callback_, onCheckDone(limited_status));
+  handler2.callCheck(headers, *mock_span_, mock_check_done_callback_);
+
+  ReportRequestInfo expected_report_info;
+  initExpectedReportInfo(expected_report_info);
+  expected_report_info.status = limited_status;
+  expected_report_info.operation_name = "get_no_key";
+  EXPECT_CALL(*mock_call_,
+              callReport(MatchesSimpleReportInfo(expected_report_info)));
+  handler2.callReport(&headers, &resp_headers_, &resp_trailer_, epoch_);
+
+  // The limit of all the operations has one token left.
+  Utils::setStringFilterState(*mock_stream_info_.filter_state_,
+                              Utils::kOperation, "get_no_key_unlimited");
+  ServiceControlHandlerImpl handler3(headers, mock_stream_info_, "test-uuid",
+                                     *cfg_parser_);
+  EXPECT_CALL(mock_check_done_callback_, onCheckDone(Status::OK));
+  handler3.callCheck(headers, *mock_span_, mock_check_done_callback_);
+
+  ServiceControlHandlerImpl handler4(headers, mock_stream_info_, "test-uuid",
+                                     *cfg_parser_);
+  EXPECT_CALL(mock_check_done_callback_, onCheckDone(limited_status));
+  handler4.callCheck(headers, *mock_span_, mock_check_done_callback_);
+
+  time_system_.sleep(std::chrono::seconds(1));
+  ServiceControlHandlerImpl handler5(headers, mock_stream_info_, "test-uuid",
+                                     *cfg_parser_);
+  EXPECT_CALL(mock_check_done_callback_, onCheckDone(Status::OK));
+  handler5.callCheck(headers, *mock_span_, mock_check_done_callback_);
+}
+
 TEST_F(HandlerTest, HandlerCheckMissingApiKey) {
   // Test: If the operation requires a check but none is found, check fails
   // and a report is made
@@ -528,6 +607,131 @@ TEST_F(HandlerTest, HandlerSuccessfulCheckSyncWithoutApiKeyRestrictionFields) {
   handler.callReport(&headers, &response_headers, &resp_trailer_, epoch_);
 }
 
+TEST_F(HandlerTest, HandlerCheckSetsTracingCustomTags) {
+  // Test: The API attributes and the consumer project of the API key are set
+  // as tags on the span, but not the API key itself.
+  const char kTracingFilterConfig[] = R"(
+services {
+  service_name: "echo"
+  producer_project_id: "project-id"
+  tracing_custom_tags: "api_name"
+  tracing_custom_tags: "api_version"
+  tracing_custom_tags: "operation_name"
+  tracing_custom_tags: "api_key_project"
+  tracing_custom_tags: "consumer_id"
+}
+requirements {
+  service_name: "echo"
+  api_name: "test_api"
+  api_version: "test_version"
+  operation_name: "get_header_key"
+  api_key: {
+    allow_without_api_key: false
+    locations: {
+      header: "x-api-key"
+    }
+  }
+})";
+  setUp(kTracingFilterConfig);
+  Utils::setStringFilterState(*mock_stream_info_.filter_state_,
+                              Utils::kOperation, "get_header_key");
+  TestRequestHeaderMapImpl headers{
+      {":method", "GET"}, {":path", "/echo"}, {"x-api-key", "foobar"}};
+  ServiceControlHandlerImpl handler(headers, mock_stream_info_, "test-uuid",
+                                    *cfg_parser_);
+  CheckResponseInfo response_info;
+  response_info.consumer_project_id = "123456";
+
+  EXPECT_CALL(*mock_call_, callCheck(_, _, _))
+      .WillOnce(Invoke([&response_info](const CheckRequestInfo&,
+                                        Envoy::Tracing::Span&,
+                                        CheckDoneFunc on_done) {
+        on_done(Status::OK, response_info);
+        return nullptr;
+      }));
+  EXPECT_CALL(*mock_span_, setTag(_, _)).Times(0);
+  EXPECT_CALL(*mock_span_, setTag(absl::string_view("api_name"),
+                                  absl::string_view("test_api")));
+  EXPECT_CALL(*mock_span_, setTag(absl::string_view("api_version"),
+                                  absl::string_view("test_version")));
+  EXPECT_CALL(*mock_span_, setTag(absl::string_view("operation_name"),
+                                  absl::string_view("get_header_key")));
+  EXPECT_CALL(*mock_span_, setTag(absl::string_view("api_key_project"),
+                                  absl::string_view("123456")));
+  EXPECT_CALL(*mock_span_, setTag(absl::string_view("consumer_id"),
+                                  absl::string_view("project:123456")));
+  EXPECT_CALL(mock_check_done_callback_, onCheckDone(Status::OK));
+  handler.callCheck(headers, *mock_span_, mock_check_done_callback_);
+}
+
+TEST_F(HandlerTest, HandlerCheckSetsConsumerHeaders) {
+  // Test: The consumer headers are set to the consumer of the API key,
+  // replacing the values sent by the client.
+  const char kConsumerHeadersFilterConfig[] = R"(
+services {
+  service_name: "echo"
+  producer_project_id: "project-id"
+  consumer_project_header: "x-consumer-project"
+  consumer_id_header: "x-consumer-id"
+}
+requirements {
+  service_name: "echo"
+  api_name: "test_api"
+  api_version: "test_version"
+  operation_name: "get_header_key"
+  api_key: {
+    allow_without_api_key: false
+    locations: {
+      header: "x-api-key"
+    }
+  }
+})";
+  setUp(kConsumerHeadersFilterConfig);
+  Utils::setStringFilterState(*mock_stream_info_.filter_state_,
+                              Utils::kOperation, "get_header_key");
+  TestRequestHeaderMapImpl headers{{":method", "GET"},
+                                   {":path", "/echo"},
+                                   {"x-api-key", "foobar"},
+                                   {"x-consumer-project", "spoofed"},
+                                   {"x-consumer-id", "spoofed"}};
+  ServiceControlHandlerImpl handler(headers, mock_stream_info_, "test-uuid",
+                                    *cfg_parser_);
+  CheckResponseInfo response_info;
+  response_info.consumer_project_id = "123456";
+
+  EXPECT_CALL(*mock_call_, callCheck(_, _, _))
+      .WillOnce(Invoke([&response_info](const CheckRequestInfo&,
+                                        Envoy::Tracing::Span&,
+                                        CheckDoneFunc on_done) {
+        on_done(Status::OK, response_info);
+        return nullptr;
+      }));
+  EXPECT_CALL(mock_check_done_callback_, onCheckDone(Status::OK));
+  handler.callCheck(headers, *mock_span_, mock_check_done_callback_);
+
+  EXPECT_EQ(headers.get_("x-consumer-project"), "123456");
+  EXPECT_EQ(headers.get_("x-consumer-id"), "project:123456");
+  EXPECT_FALSE(headers.has("x-endpoint-api-project-id"));
+}
+
+TEST_F(HandlerTest, HandlerCheckNotNeededRemovesConsumerHeaders) {
+  // Test: The consumer header sent by the client is removed, even if the
+  // operation does not require check.
+  Utils::setStringFilterState(*mock_stream_info_.filter_state_,
+                              Utils::kOperation, "get_no_key");
+  TestRequestHeaderMapImpl headers{{":method", "GET"},
+                                   {":path", "/echo"},
+                                   {"x-endpoint-api-project-id", "spoofed"}};
+  ServiceControlHandlerImpl handler(headers, mock_stream_info_, "test-uuid",
+                                    *cfg_parser_);
+
+  EXPECT_CALL(*mock_call_, callCheck(_, _, _)).Times(0);
+  EXPECT_CALL(mock_check_done_callback_, onCheckDone(Status::OK));
+  handler.callCheck(headers, *mock_span_, mock_check_done_callback_);
+
+  EXPECT_FALSE(headers.has("x-endpoint-api-project-id"));
+}
+
 TEST_F(HandlerTest, HandlerSuccessfulQuotaSync) {
   // Test: Quota is required and succeeds.
   Utils::setStringFilterState(*mock_stream_info_.filter_state_,
@@ -1048,16 +1252,15 @@ TEST_F(HandlerTest, TryIntermediateReport) {
       .Times(1);
   handler.tryIntermediateReport(time);
 
-  // Test: Next call is sent. First report is false
+  // Test: Next call is sent. First report is false, and only the bytes
+  // streamed since the previous report are reported.
   time += std::chrono::milliseconds(200);
   expected_report_info.is_first_report = false;
 
   mock_stream_info_.bytes_received_ = 789;
   mock_stream_info_.bytes_sent_ = 1456;
-  expected_report_info.request_bytes =
-      mock_stream_info_.bytes_received_ + headers.byteSize();
-  expected_report_info.response_bytes =
-      mock_stream_info_.bytes_sent_ + response_headers.byteSize();
+  expected_report_info.request_bytes = 789 - 123;
+  expected_report_info.response_bytes = 1456 - 456;
 
   EXPECT_CALL(*mock_call_,
               callReport(MatchesDataReportInfo(expected_report_info)))
@@ -1065,6 +1268,125 @@ TEST_F(HandlerTest, TryIntermediateReport) {
   handler.tryIntermediateReport(time);
 }
 
+TEST_F(HandlerTest, FinalReportAfterIntermediateReport) {
+  // Test: The final report only has the bytes streamed since the last
+  // intermediate report, and the message counts of the whole stream.
+  Utils::setStringFilterState(*mock_stream_info_.filter_state_,
+                              Utils::kOperation, "get_header_key");
+  TestRequestHeaderMapImpl headers{{":method", "GET"},
+                                   {":path", "/echo"},
+                                   {"x-api-key", "foobar"},
+                                   {"content-type", "application/grpc"}};
+  TestResponseHeaderMapImpl response_headers{
+      {"content-type", "application/grpc"}};
+  ServiceControlHandlerImpl handler(headers, mock_stream_info_, "test-uuid",
+                                    *cfg_parser_);
+  CheckResponseInfo response_info;
+  EXPECT_CALL(*mock_call_, callCheck(_, _, _))
+      .WillOnce(Invoke([&response_info](const CheckRequestInfo&,
+                                        Envoy::Tracing::Span&,
+                                        CheckDoneFunc on_done) {
+        on_done(Status::OK, response_info);
+        return nullptr;
+      }));
+  EXPECT_CALL(mock_check_done_callback_, onCheckDone(Status::OK));
+  handler.callCheck(headers, *mock_span_, mock_check_done_callback_);
+
+  handler.processResponseHeaders(response_headers);
+
+  std::chrono::system_clock::time_point start_time =
+      std::chrono::system_clock::now();
+  std::chrono::system_clock::time_point time = start_time;
+  mock_stream_info_.start_time_ = start_time;
+
+  time += std::chrono::milliseconds(200);
+  mock_stream_info_.bytes_received_ = 123;
+  mock_stream_info_.bytes_sent_ = 456;
+  EXPECT_CALL(*mock_call_, callReport(_)).Times(1);
+  handler.tryIntermediateReport(time);
+
+  time += std::chrono::milliseconds(200);
+  ReportRequestInfo expected_report_info;
+  initExpectedReportInfo(expected_report_info);
+  expected_report_info.api_key = "foobar";
+  expected_report_info.is_first_report = false;
+  expected_report_info.is_final_report = true;
+  expected_report_info.status = Status::OK;
+  expected_report_info.streaming_durations =
+      std::chrono::duration_cast<std::chrono::microseconds>(time - start_time)
+          .count();
+
+  {
+    auto grpc_state = std::make_unique<GrpcStats::GrpcStatsObject>();
+    grpc_state->request_message_count = 3;
+    grpc_state->response_message_count = 5;
+    mock_stream_info_.filter_state_->setData(
+        HttpFilterNames::get().GrpcStats, std::move(grpc_state),
+        StreamInfo::FilterState::StateType::Mutable);
+  }
+  expected_report_info.streaming_request_message_counts = 3;
+  expected_report_info.streaming_response_message_counts = 5;
+
+  mock_stream_info_.bytes_received_ = 1123;
+  mock_stream_info_.bytes_sent_ = 1456;
+  expected_report_info.request_bytes = 1123 - 123;
+  expected_report_info.response_bytes = 1456 - 456 + resp_trailer_.byteSize();
+
+  EXPECT_CALL(*mock_call_,
+              callReport(MatchesDataReportInfo(expected_report_info)))
+      .Times(1);
+  handler.callReport(&headers, &response_headers, &resp_trailer_, time);
+}
+
+TEST_F(HandlerTest, TryIntermediateReportWebSocket) {
+  Utils::setStringFilterState(*mock_stream_info_.filter_state_,
+                              Utils::kOperation, "get_header_key");
+  TestRequestHeaderMapImpl headers{{":method", "GET"},
+                                   {":path", "/echo"},
+                                   {"x-api-key", "foobar"},
+                                   {"connection", "Upgrade"},
+                                   {"upgrade", "websocket"}};
+  TestResponseHeaderMapImpl response_headers{{":status", "101"}};
+  ServiceControlHandlerImpl handler(headers, mock_stream_info_, "test-uuid",
+                                    *cfg_parser_);
+  CheckResponseInfo response_info;
+  response_info.is_api_key_valid = true;
+  response_info.service_is_activated = true;
+  EXPECT_CALL(*mock_call_, callCheck(_, _, _))
+      .WillOnce(Invoke([&response_info](const CheckRequestInfo&,
+                                        Envoy::Tracing::Span&,
+                                        CheckDoneFunc on_done) {
+        on_done(Status::OK, response_info);
+        return nullptr;
+      }));
+  EXPECT_CALL(mock_check_done_callback_, onCheckDone(Status::OK));
+  handler.callCheck(headers, *mock_span_, mock_check_done_callback_);
+
+  handler.processResponseHeaders(response_headers);
+
+  // Test: The streamed bytes of the upgraded connection are reported once
+  // enough time has passed.
+  std::chrono::system_clock::time_point time =
+      std::chrono::system_clock::now() + std::chrono::milliseconds(200);
+  mock_stream_info_.bytes_received_ = 123;
+  mock_stream_info_.bytes_sent_ = 456;
+  EXPECT_CALL(*mock_call_, callReport(_)).Times(1);
+  handler.tryIntermediateReport(time);
+}
+
+TEST_F(HandlerTest, TryIntermediateReportSkipsUnaryHttp) {
+  Utils::setStringFilterState(*mock_stream_info_.filter_state_,
+                              Utils::kOperation, "get_header_key");
+  TestRequestHeaderMapImpl headers{{":method", "GET"}, {":path", "/echo"}};
+  ServiceControlHandlerImpl handler(headers, mock_stream_info_, "test-uuid",
+                                    *cfg_parser_);
+
+  // Test: No intermediate report for a plain HTTP request.
+  EXPECT_CALL(*mock_call_, callReport(_)).Times(0);
+  handler.tryIntermediateReport(std::chrono::system_clock::now() +
+                                std::chrono::milliseconds(200));
+}
+
 TEST_F(HandlerTest, FinalReports) {
   // CollectEncodeData test cases after the boilerplate
   Utils::setStringFilterState(*mock_stream_info_.filter_state_,
@@ -27,6 +27,7 @@
 #include "src/envoy/http/service_control/handler_utils.h"
 
 using ::google::api::envoy::http::service_control::ApiKeyLocation;
+using ::google::api::envoy::http::service_control::ReportLabel;
 using ::google::api::envoy::http::service_control::Service;
 using ::google::api_proxy::service_control::LatencyInfo;
 using ::google::api_proxy::service_control::protocol::Protocol;
@@ -43,6 +44,8 @@ constexpr char kJwtPayLoadsDelimeter = '.';
 
 constexpr char kContentTypeApplicationGrpcPrefix[] = "application/grpc";
 const Http::LowerCaseString kContentTypeHeader{"content-type"};
+const Http::LowerCaseString kRefererHeader{"referer"};
+const Http::LowerCaseString kOriginHeader{"origin"};
 
 inline int64_t convertNsToMs(std::chrono::nanoseconds ns) {
   return std::chrono::duration_cast<std::chrono::milliseconds>(ns).count();
@@ -245,6 +248,53 @@ void fillJwtPayload(const envoy::config::core::v3::Metadata& metadata,
   }
 }
 
+void fillReportLabels(
+    const Http::HeaderMap* headers,
+    const envoy::config::core::v3::Metadata& metadata,
+    const std::string& jwt_payload_metadata_name,
+    const ::google::protobuf::RepeatedPtrField<ReportLabel>& labels,
+    std::map<std::string, std::string>& info_labels) {
+  for (const auto& label : labels) {
+    switch (label.source_case()) {
+      case ReportLabel::kHeader: {
+        if (headers == nullptr) {
+          break;
+        }
+        auto* entry = headers->get(Http::LowerCaseString(label.header()));
+        if (entry) {
+          info_labels[label.name()] =
+              std::string(entry->value().getStringView());
+        }
+        break;
+      }
+      case ReportLabel::kJwtClaim: {
+        std::vector<std::string> steps =
+            absl::StrSplit(label.jwt_claim(), kJwtPayLoadsDelimeter);
+        steps.insert(steps.begin(), jwt_payload_metadata_name);
+        const ProtobufWkt::Value& value = Config::Metadata::metadataValue(
+            &metadata, HttpFilters::HttpFilterNames::get().JwtAuthn, steps);
+        switch (value.kind_case()) {
+          case ProtobufWkt::Value::kStringValue:
+            info_labels[label.name()] = value.string_value();
+            break;
+          case ProtobufWkt::Value::kNumberValue:
+            info_labels[label.name()] =
+                std::to_string(static_cast<long>(value.number_value()));
+            break;
+          case ProtobufWkt::Value::kBoolValue:
+            info_labels[label.name()] = value.bool_value() ? "true" : "false";
+            break;
+          default:
+            break;
+        }
+        break;
+      }
+      case ReportLabel::SOURCE_NOT_SET:
+        break;
+    }
+  }
+}
+
 bool extractAPIKey(
     const Http::HeaderMap& headers,
     const ::google::protobuf::RepeatedPtrField<
@@ -277,6 +327,14 @@ bool extractAPIKey(
   return false;
 }
 
+std::string getReferer(const Http::HeaderMap& headers) {
+  absl::string_view referer = Utils::extractHeader(headers, kRefererHeader);
+  if (referer.empty()) {
+    referer = Utils::extractHeader(headers, kOriginHeader);
+  }
+  return std::string(referer);
+}
+
 }  // namespace ServiceControl
 }  // namespace HttpFilters
 }  // namespace Extensions
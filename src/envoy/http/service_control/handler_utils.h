@@ -66,6 +66,16 @@ void fillJwtPayload(const envoy::config::core::v3::Metadata& metadata,
                     const std::string& jwt_payload_path,
                     std::string& info_iss_or_aud);
 
+// Fills the custom labels of the info with the values of the request headers
+// or of the claims of the verified JWT.
+void fillReportLabels(
+    const Http::HeaderMap* headers,
+    const envoy::config::core::v3::Metadata& metadata,
+    const std::string& jwt_payload_metadata_name,
+    const ::google::protobuf::RepeatedPtrField<
+        ::google::api::envoy::http::service_control::ReportLabel>& labels,
+    std::map<std::string, std::string>& info_labels);
+
 // Returns the protocol of the frontend request or UNKNOWN if not found
 ::google::api_proxy::service_control::protocol::Protocol getFrontendProtocol(
     const Http::HeaderMap* response_headers,
@@ -75,6 +85,11 @@ void fillJwtPayload(const envoy::config::core::v3::Metadata& metadata,
 ::google::api_proxy::service_control::protocol::Protocol getBackendProtocol(
     const ::google::api::envoy::http::service_control::Service& service);
 
+// Returns the referer checked against the HTTP referrer restrictions of the
+// API keys: the Referer header, or the Origin header if there is no Referer,
+// as browsers may only send the Origin on cross-origin requests.
+std::string getReferer(const Http::HeaderMap& headers);
+
 }  // namespace ServiceControl
 }  // namespace HttpFilters
 }  // namespace Extensions
@@ -158,6 +158,66 @@ TEST(ServiceControlUtils, FillLoggedHeader) {
   EXPECT_TRUE(output == "log-this=foo;" || output == "log-this=bar;");
 }
 
+TEST(ServiceControlUtils, FillReportLabels) {
+  Service service;
+  ASSERT_TRUE(TextFormat::ParseFromString(R"(
+report_labels { name: "tenant_id" header: "x-tenant-id" }
+report_labels { name: "client_version" jwt_claim: "client.version" }
+report_labels { name: "admin" jwt_claim: "admin" }
+report_labels { name: "missing" header: "x-missing" }
+)",
+                                          &service));
+
+  envoy::config::core::v3::Metadata metadata;
+  ASSERT_TRUE(TextFormat::ParseFromString(R"(
+filter_metadata {
+  key: "envoy.filters.http.jwt_authn"
+  value {
+    fields {
+      key: "jwt_payloads"
+      value {
+        struct_value {
+          fields {
+            key: "client"
+            value {
+              struct_value {
+                fields {
+                  key: "version"
+                  value { string_value: "1.2.0" }
+                }
+              }
+            }
+          }
+          fields {
+            key: "admin"
+            value { bool_value: true }
+          }
+        }
+      }
+    }
+  }
+}
+)",
+                                          &metadata));
+
+  Http::TestHeaderMapImpl headers{{"x-tenant-id", "tenant-1"}};
+  std::map<std::string, std::string> labels;
+  fillReportLabels(&headers, metadata, "jwt_payloads",
+                   service.report_labels(), labels);
+  EXPECT_EQ(labels, (std::map<std::string, std::string>{
+                        {"tenant_id", "tenant-1"},
+                        {"client_version", "1.2.0"},
+                        {"admin", "true"},
+                    }));
+
+  // The labels from the JWT claims are still set without headers.
+  labels.clear();
+  fillReportLabels(nullptr, metadata, "jwt_payloads", service.report_labels(),
+                   labels);
+  EXPECT_EQ(labels.count("tenant_id"), 0);
+  EXPECT_EQ(labels["client_version"], "1.2.0");
+}
+
 TEST(ServiceControlUtils, ExtractApiKey) {
   struct TestCase {
     std::string requirement_proto;
@@ -398,6 +458,21 @@ TEST(ServiceControlUtils, GetBackendProtocol) {
   EXPECT_EQ(Protocol::GRPC, getBackendProtocol(service));
 }
 
+TEST(ServiceControlUtils, GetReferer) {
+  // Test: no Referer nor Origin header
+  Http::TestHeaderMapImpl headers;
+  EXPECT_EQ("", getReferer(headers));
+
+  // Test: Origin header is used without Referer header
+  headers = {{"origin", "https://example.com"}};
+  EXPECT_EQ("https://example.com", getReferer(headers));
+
+  // Test: Referer header is preferred over Origin header
+  headers = {{"origin", "https://example.com"},
+             {"referer", "https://example.com/page"}};
+  EXPECT_EQ("https://example.com/page", getReferer(headers));
+}
+
 TEST(ServiceControlUtils, GetFrontendProtocol) {
   Http::TestHeaderMapImpl headers;
   testing::NiceMock<StreamInfo::MockStreamInfo> mock_stream_info;
@@ -14,7 +14,6 @@
 
 #include <memory>
 
-#include "common/common/enum_to_int.h"
 #include "common/http/headers.h"
 #include "common/http/message_impl.h"
 #include "common/http/utility.h"
@@ -31,8 +30,6 @@ namespace HttpFilters {
 namespace ServiceControl {
 namespace {
 
-constexpr absl::string_view KApplicationProto = "application/x-protobuf";
-
 class HttpCallImpl : public HttpCall,
                      public Event::DeferredDeletable,
                      public Logger::Loggable<Logger::Id::filter>,
@@ -40,7 +37,8 @@ class HttpCallImpl : public HttpCall,
  public:
   HttpCallImpl(Upstream::ClusterManager& cm, Event::Dispatcher& dispatcher,
                const HttpUri& uri, const std::string& suffix_url,
-               std::function<const std::string&()> token_fn,
+               WireFormatSharedPtr wire_format,
+               CallCredentialsSharedPtr credentials,
                const Protobuf::Message& body, uint32_t timeout_ms,
                uint32_t retries, Envoy::Tracing::Span& parent_span,
                Envoy::TimeSource& time_source,
@@ -52,14 +50,15 @@ class HttpCallImpl : public HttpCall,
         request_count_(0),
         timeout_ms_(timeout_ms),
         cancelled(false),
-        token_fn_(token_fn),
+        wire_format_(wire_format),
+        credentials_(credentials),
         parent_span_(parent_span),
         time_source_(time_source),
         trace_operation_name_(trace_operation_name) {
     uri_ = http_uri_.uri() + suffix_url;
 
     Http::Utility::extractHostPathFromUri(uri_, host_, path_);
-    body.SerializeToString(&str_body_);
+    str_body_ = wire_format_->encodeRequest(body);
 
     ASSERT(!on_done_);
     ENVOY_LOG(trace, "{}", __func__);
@@ -82,24 +81,21 @@ class HttpCallImpl : public HttpCall,
                             std::to_string(status_code));
       request_span_->finishSpan();
 
-      if (response->body()) {
-        const auto len = response->body()->length();
-        body = std::string(static_cast<char*>(response->body()->linearize(len)),
-                           len);
-      }
-      if (status_code == enumToInt(Http::Code::OK)) {
+      bool retryable = false;
+      const Status status =
+          wire_format_->decodeResponse(*response, &body, &retryable);
+      if (status.ok()) {
         ENVOY_LOG(debug, "http call [uri = {}]: success with body {}", uri_,
                   body);
         on_done_(Status::OK, body);
       } else {
-        if (attemptRetry(status_code)) {
+        if (retryable && attemptRetry()) {
           return;
         }
 
         ENVOY_LOG(debug, "http call response status code: {}, body: {}",
                   status_code, body);
-        on_done_(Status(Code::INTERNAL, "Failed to call service control"),
-                 body);
+        on_done_(status, body);
       }
     } catch (const EnvoyException& e) {
       ENVOY_LOG(debug, "http call invalid status");
@@ -126,7 +122,7 @@ class HttpCallImpl : public HttpCall,
     }
     request_span_->finishSpan();
 
-    if (attemptRetry(0)) {
+    if (attemptRetry()) {
       return;
     }
 
@@ -137,11 +133,7 @@ class HttpCallImpl : public HttpCall,
   }
 
  private:
-  bool attemptRetry(const uint64_t& status_code) {
-    // skip if it is the client side problem.
-    if (status_code >= 400 && status_code < 500) {
-      return false;
-    }
+  bool attemptRetry() {
     if (retries_ <= 0) {
       return false;
     }
@@ -158,11 +150,10 @@ class HttpCallImpl : public HttpCall,
 
   void makeOneCall() {
     request_count_++;
-    std::string token = token_fn_();
-    if (token.empty()) {
-      on_done_(Status(Code::INTERNAL,
-                      "Missing access token for service control call"),
-               "");
+    Http::RequestMessagePtr message = prepareHeaders();
+    const Status status = credentials_->apply(message->headers());
+    if (!status.ok()) {
+      on_done_(status, "");
       deferredDelete();
       return;
     }
@@ -182,7 +173,6 @@ class HttpCallImpl : public HttpCall,
     request_span_->setTag(Tracing::Tags::get().HttpUrl, uri_);
     request_span_->setTag(Tracing::Tags::get().HttpMethod, "POST");
 
-    Http::RequestMessagePtr message = prepareHeaders(token);
     ENVOY_LOG(debug, "http call from [uri = {}]: start", uri_);
     request_ = cm_.httpAsyncClientForCluster(http_uri_.cluster())
                    .send(std::move(message), *this,
@@ -213,7 +203,7 @@ class HttpCallImpl : public HttpCall,
 
   void reset() { request_ = nullptr; }
 
-  Http::RequestMessagePtr prepareHeaders(const std::string& token) {
+  Http::RequestMessagePtr prepareHeaders() {
     Http::RequestMessagePtr message(new Http::RequestMessageImpl());
     message->headers().setPath(path_);
     message->headers().setHost(host_);
@@ -224,11 +214,7 @@ class HttpCallImpl : public HttpCall,
     message->body() =
         std::make_unique<Buffer::OwnedImpl>(str_body_.data(), str_body_.size());
     message->headers().setContentLength(message->body()->length());
-
-    // assume token is not empty
-    std::string token_value = "Bearer " + token;
-    message->headers().setAuthorization(token_value);
-    message->headers().setContentType(KApplicationProto);
+    wire_format_->setRequestHeaders(message->headers());
     return message;
   }
 
@@ -269,8 +255,9 @@ class HttpCallImpl : public HttpCall,
   // whether this call has been cancelled
   bool cancelled;
 
-  // The function for getting token
-  std::function<const std::string&()> token_fn_;
+  // The wire format and the credentials of the call
+  WireFormatSharedPtr wire_format_;
+  CallCredentialsSharedPtr credentials_;
 
   // Tracing data
   Envoy::Tracing::Span& parent_span_;
@@ -284,14 +271,15 @@ class HttpCallImpl : public HttpCall,
 HttpCallFactory::HttpCallFactory(
     Upstream::ClusterManager& cm, Event::Dispatcher& dispatcher,
     const ::google::api::envoy::http::common::HttpUri& uri,
-    const std::string& suffix_url, std::function<const std::string&()> token_fn,
-    uint32_t timeout_ms, uint32_t retries, Envoy::TimeSource& time_source,
-    const std::string& trace_operation_name)
+    const std::string& suffix_url, WireFormatSharedPtr wire_format,
+    CallCredentialsSharedPtr credentials, uint32_t timeout_ms, uint32_t retries,
+    Envoy::TimeSource& time_source, const std::string& trace_operation_name)
     : cm_(cm),
       dispatcher_(dispatcher),
       uri_(uri),
       suffix_url_(suffix_url),
-      token_fn_(token_fn),
+      wire_format_(wire_format),
+      credentials_(credentials),
       timeout_ms_(timeout_ms),
       retries_(retries),
       destruct_mode_(false),
@@ -303,8 +291,8 @@ HttpCall* HttpCallFactory::createHttpCall(const Protobuf::Message& body,
                                           HttpCall::DoneFunc on_done) {
   ENVOY_LOG(debug, "{} is created", trace_operation_name_);
   HttpCallImpl* http_call = new HttpCallImpl(
-      cm_, dispatcher_, uri_, suffix_url_, token_fn_, body, timeout_ms_,
-      retries_, parent_span, time_source_, trace_operation_name_);
+      cm_, dispatcher_, uri_, suffix_url_, wire_format_, credentials_, body,
+      timeout_ms_, retries_, parent_span, time_source_, trace_operation_name_);
   http_call->setDoneFunc([this, on_done, http_call](const Status& status,
                                                     const std::string& body) {
     // When the call is finished, it should be removed from active_calls_ .
@@ -19,6 +19,7 @@
 #include "envoy/tracing/http_tracer.h"
 #include "envoy/upstream/cluster_manager.h"
 #include "google/protobuf/stubs/status.h"
+#include "src/envoy/http/service_control/transport.h"
 
 namespace Envoy {
 namespace Extensions {
@@ -45,9 +46,9 @@ class HttpCallFactory : public Logger::Loggable<Logger::Id::filter> {
   HttpCallFactory(Upstream::ClusterManager& cm, Event::Dispatcher& dispatcher,
                   const ::google::api::envoy::http::common::HttpUri& uri,
                   const std::string& suffix_url,
-                  std::function<const std::string&()> token_fn,
-                  uint32_t timeout_ms, uint32_t retries,
-                  Envoy::TimeSource& time_source,
+                  WireFormatSharedPtr wire_format,
+                  CallCredentialsSharedPtr credentials, uint32_t timeout_ms,
+                  uint32_t retries, Envoy::TimeSource& time_source,
                   const std::string& trace_operation_name);
 
   HttpCall* createHttpCall(const Protobuf::Message& body,
@@ -68,8 +69,9 @@ class HttpCallFactory : public Logger::Loggable<Logger::Id::filter> {
   const ::google::api::envoy::http::common::HttpUri uri_;
   const std::string suffix_url_;
 
-  // token getter
-  std::function<const std::string&()> token_fn_;
+  // the wire format and the credentials of the calls
+  WireFormatSharedPtr wire_format_;
+  CallCredentialsSharedPtr credentials_;
 
   // call setting
   uint32_t timeout_ms_;
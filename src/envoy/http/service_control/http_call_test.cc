@@ -39,6 +39,7 @@ using ::testing::MockFunction;
 using ::testing::Return;
 
 using ::google::api::envoy::http::common::HttpUri;
+using ::google::api::envoy::http::service_control::ServiceControlTransport;
 using ::google::api::servicecontrol::v1::CheckRequest;
 using ::google::api::servicecontrol::v1::CheckResponse;
 using ::google::protobuf::util::Status;
@@ -88,9 +89,15 @@ class HttpCallTest : public testing::Test {
     fake_token_fn_ = [this]() -> const std::string& { return fake_token_; };
 
     fake_request_ = CheckRequest{};
+    resetFactory();
+  }
+
+  void resetFactory() {
     http_call_factory_ = std::make_unique<HttpCallFactory>(
-        cm_, dispatcher_, http_uri_, fake_suffix_url_, fake_token_fn_,
-        timeout_ms_, retries_, mock_time_source_, fake_trace_operation_name_);
+        cm_, dispatcher_, http_uri_, fake_suffix_url_,
+        createWireFormat(transport_),
+        createCallCredentials(transport_, fake_token_fn_), timeout_ms_,
+        retries_, mock_time_source_, fake_trace_operation_name_);
   }
 
   void TearDown() override {
@@ -152,6 +159,7 @@ class HttpCallTest : public testing::Test {
   NiceMock<MockTimeSystem> mock_time_source_;
 
   // Other hardcoded fake parameters
+  ServiceControlTransport transport_;
   CheckRequest fake_request_;
   std::string fake_suffix_url_;
   uint32_t timeout_ms_;
@@ -239,9 +247,7 @@ TEST_F(HttpCallTest, TestEmptyTokenCallFailure) {
 TEST_F(HttpCallTest, TestRetryCallSuccess) {
   // Set request to retry 2 more times
   retries_ = 2;
-  http_call_factory_ = std::make_unique<HttpCallFactory>(
-      cm_, dispatcher_, http_uri_, fake_suffix_url_, fake_token_fn_,
-      timeout_ms_, retries_, mock_time_source_, fake_trace_operation_name_);
+  resetFactory();
   // Phase 1: Create HttpCall and send the request
   auto mock_child_span_1 = makeMockChildSpan();
   EXPECT_CALL(mock_done_fn_, Call(_, _))
@@ -275,9 +281,7 @@ TEST_F(HttpCallTest, TestRetryCallSuccess) {
 TEST_F(HttpCallTest, TestThreeRetriesWithLastSuccess) {
   // Set request to retry 2 more times
   retries_ = 2;
-  http_call_factory_ = std::make_unique<HttpCallFactory>(
-      cm_, dispatcher_, http_uri_, fake_suffix_url_, fake_token_fn_,
-      timeout_ms_, retries_, mock_time_source_, fake_trace_operation_name_);
+  resetFactory();
 
   // Phase 1: Create HttpCall and send the request
   auto mock_child_span_1 = makeMockChildSpan();
@@ -313,9 +317,7 @@ TEST_F(HttpCallTest, TestThreeRetriesWithLastSuccess) {
 TEST_F(HttpCallTest, TestThreeRetriesWithLastFailure) {
   // Set request to retry 2 more times
   retries_ = 2;
-  http_call_factory_ = std::make_unique<HttpCallFactory>(
-      cm_, dispatcher_, http_uri_, fake_suffix_url_, fake_token_fn_,
-      timeout_ms_, retries_, mock_time_source_, fake_trace_operation_name_);
+  resetFactory();
 
   // Phase 1: Create HttpCall and send the request
   auto mock_child_span_1 = makeMockChildSpan();
@@ -42,6 +42,10 @@ class MockServiceControlHandler : public ServiceControlHandler {
   MOCK_METHOD1(processResponseHeaders,
                void(const Http::ResponseHeaderMap& response_headers));
 
+  MOCK_CONST_METHOD0(maxResponseBodyBytes, uint32_t());
+
+  MOCK_CONST_METHOD0(streamReportInterval, std::chrono::milliseconds());
+
   MOCK_METHOD0(onDestroy, void());
 };
 
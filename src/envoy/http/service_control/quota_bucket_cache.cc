@@ -0,0 +1,146 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+#include "src/envoy/http/service_control/quota_bucket_cache.h"
+
+#include <algorithm>
+
+#include "absl/strings/str_cat.h"
+
+using ::google::api::servicecontrol::v1::AllocateQuotaRequest;
+using ::google::api::servicecontrol::v1::QuotaOperation;
+using ::google::protobuf::util::Status;
+
+namespace Envoy {
+namespace Extensions {
+namespace HttpFilters {
+namespace ServiceControl {
+namespace {
+
+// Separates the fields in the bucket key, it is not allowed in them.
+constexpr char kKeySeparator = '\0';
+
+// Appended to the operation id of a request to make the one of a refill.
+constexpr char kRefillOperationIdSuffix[] = ":refill";
+
+}  // namespace
+
+QuotaBucketCache::QuotaBucketCache(uint32_t burst, uint32_t max_entries)
+    : burst_(burst), max_entries_(max_entries) {}
+
+std::string QuotaBucketCache::makeKey(const AllocateQuotaRequest& request) {
+  const auto& operation = request.allocate_operation();
+  std::string key = absl::StrCat(operation.method_name(),
+                                 std::string(1, kKeySeparator),
+                                 operation.consumer_id());
+  for (const auto& metric : operation.quota_metrics()) {
+    for (const auto& value : metric.metric_values()) {
+      absl::StrAppend(&key, std::string(1, kKeySeparator),
+                      metric.metric_name(), "=", value.int64_value());
+    }
+  }
+  return key;
+}
+
+bool QuotaBucketCache::tryAcquire(const AllocateQuotaRequest& request,
+                                  Status* status) {
+  const std::string key = makeKey(request);
+  auto it = buckets_.find(key);
+  if (it == buckets_.end()) {
+    if (buckets_.size() < max_entries_) {
+      Bucket& bucket = buckets_[key];
+      bucket.request = request;
+      bucket.operation_id = request.allocate_operation().operation_id();
+    }
+    return false;
+  }
+
+  Bucket& bucket = it->second;
+  bucket.used = true;
+  bucket.operation_id = request.allocate_operation().operation_id();
+  if (!bucket.denied_status.ok()) {
+    *status = bucket.denied_status;
+    return true;
+  }
+  if (bucket.tokens == 0) {
+    return false;
+  }
+  --bucket.tokens;
+  *status = Status::OK;
+  return true;
+}
+
+void QuotaBucketCache::refill(const RefillFunc& refill_fn) {
+  for (auto it = buckets_.begin(); it != buckets_.end();) {
+    Bucket& bucket = it->second;
+    if (bucket.refilling) {
+      ++it;
+      continue;
+    }
+    if (!bucket.used) {
+      it = buckets_.erase(it);
+      continue;
+    }
+    bucket.used = false;
+
+    // A denied bucket is probed with the cost of a single request.
+    const uint32_t tokens =
+        bucket.denied_status.ok() ? burst_ - bucket.tokens : 1;
+    if (tokens == 0) {
+      ++it;
+      continue;
+    }
+
+    AllocateQuotaRequest request = bucket.request;
+    auto* operation = request.mutable_allocate_operation();
+    operation->set_operation_id(
+        absl::StrCat(bucket.operation_id, kRefillOperationIdSuffix));
+    // The refill is denied if the quota cannot cover all the tokens.
+    operation->set_quota_mode(QuotaOperation::NORMAL);
+    for (auto& metric : *operation->mutable_quota_metrics()) {
+      for (auto& value : *metric.mutable_metric_values()) {
+        value.set_int64_value(value.int64_value() * tokens);
+      }
+    }
+
+    bucket.refilling = true;
+    const std::string key = it->first;
+    ++it;
+    refill_fn(request, [this, key, tokens](const Status& status) {
+      onRefillDone(key, tokens, status);
+    });
+  }
+}
+
+void QuotaBucketCache::onRefillDone(const std::string& key, uint32_t tokens,
+                                    const Status& status) {
+  auto it = buckets_.find(key);
+  if (it == buckets_.end()) {
+    return;
+  }
+  Bucket& bucket = it->second;
+  bucket.refilling = false;
+  if (!status.ok()) {
+    bucket.denied_status = status;
+    bucket.tokens = 0;
+    return;
+  }
+  bucket.denied_status = Status::OK;
+  bucket.tokens = std::min(burst_, bucket.tokens + tokens);
+}
+
+}  // namespace ServiceControl
+}  // namespace HttpFilters
+}  // namespace Extensions
+}  // namespace Envoy
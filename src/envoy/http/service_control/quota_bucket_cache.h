@@ -0,0 +1,85 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+#pragma once
+
+#include <functional>
+#include <unordered_map>
+
+#include "google/api/servicecontrol/v1/quota_controller.pb.h"
+#include "google/protobuf/stubs/status.h"
+
+namespace Envoy {
+namespace Extensions {
+namespace HttpFilters {
+namespace ServiceControl {
+
+// The local token buckets of the consumers' quota of a worker thread, keyed by
+// the operation, the consumer and the quota metrics. A request takes a token
+// of its bucket without calling Service Control. The buckets are refilled
+// periodically by a Quota call allocating the cost of the tokens taken. When
+// this call is denied, the requests are rejected with its status, and the
+// next refills only allocate the cost of a single request until one is
+// allowed. A bucket without tokens lets its requests be allocated by Service
+// Control, and the buckets not used between two refills are removed.
+class QuotaBucketCache {
+ public:
+  // Sends the Quota call refilling a bucket, calling on_done with its status.
+  using RefillFunc = std::function<void(
+      const ::google::api::servicecontrol::v1::AllocateQuotaRequest& request,
+      std::function<void(const ::google::protobuf::util::Status&)> on_done)>;
+
+  QuotaBucketCache(uint32_t burst, uint32_t max_entries);
+
+  // The key of the Quota requests sharing a bucket.
+  static std::string makeKey(
+      const ::google::api::servicecontrol::v1::AllocateQuotaRequest& request);
+
+  // Returns whether the request is answered by its bucket, with its status.
+  // Otherwise, its quota must be allocated by Service Control.
+  bool tryAcquire(
+      const ::google::api::servicecontrol::v1::AllocateQuotaRequest& request,
+      ::google::protobuf::util::Status* status);
+
+  // Sends the Quota calls refilling the used buckets, and removes the others.
+  void refill(const RefillFunc& refill_fn);
+
+  size_t size() const { return buckets_.size(); }
+
+ private:
+  struct Bucket {
+    // The request creating the bucket, to build the refill requests.
+    ::google::api::servicecontrol::v1::AllocateQuotaRequest request;
+    // The operation id of the last request using the bucket.
+    std::string operation_id;
+    uint32_t tokens = 0;
+    // Whether the bucket was used since the last refill.
+    bool used = true;
+    bool refilling = false;
+    // The status of the last refill if denied, OK otherwise.
+    ::google::protobuf::util::Status denied_status;
+  };
+
+  void onRefillDone(const std::string& key, uint32_t tokens,
+                    const ::google::protobuf::util::Status& status);
+
+  const uint32_t burst_;
+  const uint32_t max_entries_;
+  std::unordered_map<std::string, Bucket> buckets_;
+};
+
+}  // namespace ServiceControl
+}  // namespace HttpFilters
+}  // namespace Extensions
+}  // namespace Envoy
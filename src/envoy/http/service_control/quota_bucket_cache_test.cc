@@ -0,0 +1,185 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+#include "src/envoy/http/service_control/quota_bucket_cache.h"
+
+#include <vector>
+
+#include "gmock/gmock.h"
+#include "gtest/gtest.h"
+
+using ::google::api::servicecontrol::v1::AllocateQuotaRequest;
+using ::google::api::servicecontrol::v1::QuotaOperation;
+using ::google::protobuf::util::Status;
+using ::google::protobuf::util::error::Code;
+
+namespace Envoy {
+namespace Extensions {
+namespace HttpFilters {
+namespace ServiceControl {
+namespace {
+
+AllocateQuotaRequest makeRequest(const std::string& consumer_id,
+                                 const std::string& operation_id,
+                                 int64_t cost) {
+  AllocateQuotaRequest request;
+  auto* operation = request.mutable_allocate_operation();
+  operation->set_method_name("ListShelves");
+  operation->set_consumer_id(consumer_id);
+  operation->set_operation_id(operation_id);
+  auto* metric = operation->add_quota_metrics();
+  metric->set_metric_name("read-requests");
+  metric->add_metric_values()->set_int64_value(cost);
+  return request;
+}
+
+class QuotaBucketCacheTest : public testing::Test {
+ protected:
+  // Refills the buckets, keeping the refill requests and their callbacks.
+  void refill(QuotaBucketCache& cache) {
+    cache.refill([this](const AllocateQuotaRequest& request,
+                        std::function<void(const Status&)> on_done) {
+      refill_requests_.push_back(request);
+      refill_callbacks_.push_back(on_done);
+    });
+  }
+
+  // Returns the number of requests answered by the bucket out of count.
+  int tryAcquire(QuotaBucketCache& cache, const AllocateQuotaRequest& request,
+                 int count, Status* status) {
+    int answered = 0;
+    for (int i = 0; i < count; ++i) {
+      if (cache.tryAcquire(request, status)) {
+        ++answered;
+      }
+    }
+    return answered;
+  }
+
+  std::vector<AllocateQuotaRequest> refill_requests_;
+  std::vector<std::function<void(const Status&)>> refill_callbacks_;
+};
+
+TEST(QuotaBucketCacheKeyTest, KeyIgnoresOperationId) {
+  EXPECT_EQ(QuotaBucketCache::makeKey(makeRequest("api_key:key-1", "id-1", 1)),
+            QuotaBucketCache::makeKey(makeRequest("api_key:key-1", "id-2", 1)));
+  EXPECT_NE(QuotaBucketCache::makeKey(makeRequest("api_key:key-1", "id-1", 1)),
+            QuotaBucketCache::makeKey(makeRequest("api_key:key-2", "id-1", 1)));
+  EXPECT_NE(QuotaBucketCache::makeKey(makeRequest("api_key:key-1", "id-1", 1)),
+            QuotaBucketCache::makeKey(makeRequest("api_key:key-1", "id-1", 2)));
+}
+
+TEST_F(QuotaBucketCacheTest, RefillAllocatesTheTakenTokens) {
+  QuotaBucketCache cache(10, 100);
+  Status status;
+
+  // The first request creates the bucket, without tokens.
+  EXPECT_FALSE(cache.tryAcquire(makeRequest("api_key:key-1", "id-1", 2),
+                                &status));
+  EXPECT_EQ(cache.size(), 1U);
+
+  refill(cache);
+  ASSERT_EQ(refill_requests_.size(), 1U);
+  const auto& operation = refill_requests_[0].allocate_operation();
+  EXPECT_EQ(operation.operation_id(), "id-1:refill");
+  EXPECT_EQ(operation.quota_mode(), QuotaOperation::NORMAL);
+  EXPECT_EQ(operation.quota_metrics(0).metric_values(0).int64_value(), 20);
+  refill_callbacks_[0](Status::OK);
+
+  EXPECT_EQ(
+      tryAcquire(cache, makeRequest("api_key:key-1", "id-2", 2), 4, &status),
+      4);
+  EXPECT_TRUE(status.ok());
+
+  // Only the cost of the 4 taken tokens is allocated.
+  refill(cache);
+  ASSERT_EQ(refill_requests_.size(), 2U);
+  const auto& refill_operation = refill_requests_[1].allocate_operation();
+  EXPECT_EQ(refill_operation.operation_id(), "id-2:refill");
+  EXPECT_EQ(refill_operation.quota_metrics(0).metric_values(0).int64_value(),
+            8);
+  refill_callbacks_[1](Status::OK);
+
+  // The requests over the burst are allocated by Service Control.
+  EXPECT_EQ(
+      tryAcquire(cache, makeRequest("api_key:key-1", "id-3", 2), 15, &status),
+      10);
+}
+
+TEST_F(QuotaBucketCacheTest, DeniedRefillRejectsUntilProbeAllowed) {
+  QuotaBucketCache cache(10, 100);
+  const AllocateQuotaRequest request = makeRequest("api_key:key-1", "id-1", 1);
+  Status status;
+
+  cache.tryAcquire(request, &status);
+  refill(cache);
+  refill_callbacks_[0](Status(Code::RESOURCE_EXHAUSTED, "Quota exceeded"));
+
+  EXPECT_TRUE(cache.tryAcquire(request, &status));
+  EXPECT_EQ(status.error_code(), Code::RESOURCE_EXHAUSTED);
+
+  // The denied bucket is probed with the cost of a single request.
+  refill(cache);
+  ASSERT_EQ(refill_requests_.size(), 2U);
+  EXPECT_EQ(refill_requests_[1]
+                .allocate_operation()
+                .quota_metrics(0)
+                .metric_values(0)
+                .int64_value(),
+            1);
+  refill_callbacks_[1](Status::OK);
+
+  EXPECT_EQ(tryAcquire(cache, request, 2, &status), 1);
+  EXPECT_TRUE(status.ok());
+}
+
+TEST_F(QuotaBucketCacheTest, NoRefillWhileRefilling) {
+  QuotaBucketCache cache(10, 100);
+  Status status;
+
+  cache.tryAcquire(makeRequest("api_key:key-1", "id-1", 1), &status);
+  refill(cache);
+  refill(cache);
+  EXPECT_EQ(refill_requests_.size(), 1U);
+}
+
+TEST_F(QuotaBucketCacheTest, RemoveUnusedBuckets) {
+  QuotaBucketCache cache(10, 100);
+  Status status;
+
+  cache.tryAcquire(makeRequest("api_key:key-1", "id-1", 1), &status);
+  refill(cache);
+  refill_callbacks_[0](Status::OK);
+  EXPECT_EQ(cache.size(), 1U);
+
+  // Not used since the last refill.
+  refill(cache);
+  EXPECT_EQ(cache.size(), 0U);
+  EXPECT_EQ(refill_requests_.size(), 1U);
+}
+
+TEST_F(QuotaBucketCacheTest, MaxEntries) {
+  QuotaBucketCache cache(10, 1);
+  Status status;
+
+  cache.tryAcquire(makeRequest("api_key:key-1", "id-1", 1), &status);
+  cache.tryAcquire(makeRequest("api_key:key-2", "id-2", 1), &status);
+  EXPECT_EQ(cache.size(), 1U);
+}
+
+}  // namespace
+}  // namespace ServiceControl
+}  // namespace HttpFilters
+}  // namespace Extensions
+}  // namespace Envoy
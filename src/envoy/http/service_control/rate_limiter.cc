@@ -0,0 +1,50 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+#include "src/envoy/http/service_control/rate_limiter.h"
+
+#include <algorithm>
+
+namespace Envoy {
+namespace Extensions {
+namespace HttpFilters {
+namespace ServiceControl {
+
+RateLimiter::RateLimiter(uint32_t requests_per_second, uint32_t burst,
+                         TimeSource& time_source)
+    : requests_per_second_(requests_per_second),
+      max_tokens_(burst > 0 ? burst : requests_per_second),
+      time_source_(time_source),
+      tokens_(max_tokens_),
+      last_update_(time_source.monotonicTime()) {}
+
+bool RateLimiter::tryAcquire() {
+  Thread::LockGuard lock(mutex_);
+  const MonotonicTime now = time_source_.monotonicTime();
+  const std::chrono::duration<double> elapsed = now - last_update_;
+  tokens_ =
+      std::min(max_tokens_, tokens_ + elapsed.count() * requests_per_second_);
+  last_update_ = now;
+
+  if (tokens_ < 1) {
+    return false;
+  }
+  tokens_ -= 1;
+  return true;
+}
+
+}  // namespace ServiceControl
+}  // namespace HttpFilters
+}  // namespace Extensions
+}  // namespace Envoy
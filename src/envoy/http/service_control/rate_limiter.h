@@ -0,0 +1,51 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+#pragma once
+
+#include "common/common/lock_guard.h"
+#include "common/common/thread.h"
+#include "envoy/common/time.h"
+
+namespace Envoy {
+namespace Extensions {
+namespace HttpFilters {
+namespace ServiceControl {
+
+// A token bucket limiting the requests, shared by the worker threads. It holds
+// up to burst tokens, added at requests_per_second, and each allowed request
+// takes one. The burst defaults to requests_per_second if 0.
+class RateLimiter {
+ public:
+  RateLimiter(uint32_t requests_per_second, uint32_t burst,
+              TimeSource& time_source);
+
+  // Returns whether a request is allowed, taking a token.
+  bool tryAcquire();
+
+ private:
+  const double requests_per_second_;
+  const double max_tokens_;
+  TimeSource& time_source_;
+
+  Thread::MutexBasicLockable mutex_;
+  double tokens_ ABSL_GUARDED_BY(mutex_);
+  MonotonicTime last_update_ ABSL_GUARDED_BY(mutex_);
+};
+typedef std::unique_ptr<RateLimiter> RateLimiterPtr;
+
+}  // namespace ServiceControl
+}  // namespace HttpFilters
+}  // namespace Extensions
+}  // namespace Envoy
@@ -0,0 +1,73 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+#include "src/envoy/http/service_control/rate_limiter.h"
+
+#include "gtest/gtest.h"
+#include "test/test_common/simulated_time_system.h"
+
+namespace Envoy {
+namespace Extensions {
+namespace HttpFilters {
+namespace ServiceControl {
+namespace {
+
+class RateLimiterTest : public testing::Test {
+ protected:
+  // Returns the number of allowed requests out of count.
+  int tryAcquire(RateLimiter& rate_limiter, int count) {
+    int allowed = 0;
+    for (int i = 0; i < count; ++i) {
+      if (rate_limiter.tryAcquire()) {
+        ++allowed;
+      }
+    }
+    return allowed;
+  }
+
+  Event::SimulatedTimeSystem time_system_;
+};
+
+TEST_F(RateLimiterTest, BurstDefaultsToRequestsPerSecond) {
+  RateLimiter rate_limiter(10, 0, time_system_);
+  EXPECT_EQ(tryAcquire(rate_limiter, 20), 10);
+}
+
+TEST_F(RateLimiterTest, AllowBurst) {
+  RateLimiter rate_limiter(10, 30, time_system_);
+  EXPECT_EQ(tryAcquire(rate_limiter, 50), 30);
+
+  // The tokens are added at the rate, up to the burst.
+  time_system_.sleep(std::chrono::milliseconds(500));
+  EXPECT_EQ(tryAcquire(rate_limiter, 50), 5);
+  time_system_.sleep(std::chrono::seconds(10));
+  EXPECT_EQ(tryAcquire(rate_limiter, 50), 30);
+}
+
+TEST_F(RateLimiterTest, AddPartialTokens) {
+  RateLimiter rate_limiter(2, 1, time_system_);
+  EXPECT_TRUE(rate_limiter.tryAcquire());
+  EXPECT_FALSE(rate_limiter.tryAcquire());
+
+  time_system_.sleep(std::chrono::milliseconds(250));
+  EXPECT_FALSE(rate_limiter.tryAcquire());
+  time_system_.sleep(std::chrono::milliseconds(250));
+  EXPECT_TRUE(rate_limiter.tryAcquire());
+}
+
+}  // namespace
+}  // namespace ServiceControl
+}  // namespace HttpFilters
+}  // namespace Extensions
+}  // namespace Envoy
@@ -0,0 +1,78 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+#include "src/envoy/http/service_control/report_batcher.h"
+
+using ::google::api::servicecontrol::v1::ReportRequest;
+
+namespace Envoy {
+namespace Extensions {
+namespace HttpFilters {
+namespace ServiceControl {
+
+ReportBatcher::ReportBatcher(uint32_t max_size, uint32_t max_delay_ms,
+                             uint64_t max_bytes, Event::Dispatcher& dispatcher,
+                             ServiceControlFilterStats& stats,
+                             SendFunc send_fn)
+    : max_size_(max_size),
+      max_delay_ms_(max_delay_ms),
+      max_bytes_(max_bytes),
+      stats_(stats),
+      send_fn_(send_fn),
+      batch_bytes_(0),
+      timer_(dispatcher.createTimer([this]() { flush(); })) {}
+
+ReportBatcher::~ReportBatcher() { flush(); }
+
+void ReportBatcher::add(const ReportRequest& request) {
+  const uint64_t request_bytes = request.ByteSizeLong();
+  if (batch_bytes_ + request_bytes > max_bytes_) {
+    ENVOY_LOG(warn,
+              "Dropped a report of {} bytes, the batch of reports has {} "
+              "bytes, the maximum is {}",
+              request_bytes, batch_bytes_, max_bytes_);
+    stats_.reports_dropped_.inc();
+    return;
+  }
+
+  if (batch_.operations_size() == 0) {
+    batch_.set_service_name(request.service_name());
+    batch_.set_service_config_id(request.service_config_id());
+    timer_->enableTimer(std::chrono::milliseconds(max_delay_ms_));
+  }
+  batch_.mutable_operations()->MergeFrom(request.operations());
+  batch_bytes_ += request_bytes;
+
+  if (static_cast<uint32_t>(batch_.operations_size()) >= max_size_) {
+    flush();
+  }
+}
+
+void ReportBatcher::flush() {
+  timer_->disableTimer();
+  if (batch_.operations_size() == 0) {
+    return;
+  }
+
+  ReportRequest batch;
+  batch.Swap(&batch_);
+  batch_bytes_ = 0;
+  stats_.report_batches_sent_.inc();
+  send_fn_(batch);
+}
+
+}  // namespace ServiceControl
+}  // namespace HttpFilters
+}  // namespace Extensions
+}  // namespace Envoy
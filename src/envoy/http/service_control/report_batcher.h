@@ -0,0 +1,66 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+#pragma once
+
+#include "common/common/logger.h"
+#include "envoy/event/dispatcher.h"
+#include "google/api/servicecontrol/v1/service_controller.pb.h"
+#include "src/envoy/http/service_control/filter_stats.h"
+
+namespace Envoy {
+namespace Extensions {
+namespace HttpFilters {
+namespace ServiceControl {
+
+// The class to batch the reports of a worker thread into a single report
+// request. A batch is sent when it has max_size operations, or max_delay_ms
+// after its first report. The reports exceeding max_bytes in the batch are
+// dropped, to bound the memory when service control is slow or unreachable.
+class ReportBatcher : public Logger::Loggable<Logger::Id::filter> {
+ public:
+  using SendFunc = std::function<void(
+      const ::google::api::servicecontrol::v1::ReportRequest& request)>;
+
+  ReportBatcher(uint32_t max_size, uint32_t max_delay_ms, uint64_t max_bytes,
+                Event::Dispatcher& dispatcher,
+                ServiceControlFilterStats& stats, SendFunc send_fn);
+
+  // Sends the last batch.
+  ~ReportBatcher();
+
+  void add(const ::google::api::servicecontrol::v1::ReportRequest& request);
+
+  // Sends the batch now, if not empty.
+  void flush();
+
+ private:
+  const uint32_t max_size_;
+  const uint32_t max_delay_ms_;
+  const uint64_t max_bytes_;
+  ServiceControlFilterStats& stats_;
+  SendFunc send_fn_;
+
+  // The batch of reports waiting to be sent, and its size in bytes.
+  ::google::api::servicecontrol::v1::ReportRequest batch_;
+  uint64_t batch_bytes_;
+
+  // The timer to send the batch max_delay_ms after its first report.
+  Event::TimerPtr timer_;
+};
+
+}  // namespace ServiceControl
+}  // namespace HttpFilters
+}  // namespace Extensions
+}  // namespace Envoy
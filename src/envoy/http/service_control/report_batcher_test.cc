@@ -0,0 +1,124 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+#include "src/envoy/http/service_control/report_batcher.h"
+
+#include <vector>
+
+#include "gmock/gmock.h"
+#include "gtest/gtest.h"
+#include "test/mocks/event/mocks.h"
+#include "test/mocks/stats/mocks.h"
+#include "test/test_common/utility.h"
+
+using ::google::api::servicecontrol::v1::ReportRequest;
+using ::testing::_;
+
+namespace Envoy {
+namespace Extensions {
+namespace HttpFilters {
+namespace ServiceControl {
+namespace {
+
+class ReportBatcherTest : public testing::Test {
+ protected:
+  ReportBatcherTest() : stat_base_("test.", store_) {}
+
+  void makeBatcher(uint32_t max_size, uint64_t max_bytes) {
+    timer_ = new NiceMock<Event::MockTimer>(&dispatcher_);
+    batcher_ = std::make_unique<ReportBatcher>(
+        max_size, 1000, max_bytes, dispatcher_, stat_base_.stats(),
+        [this](const ReportRequest& request) { sent_.push_back(request); });
+  }
+
+  static ReportRequest makeReport(const std::string& operation_id) {
+    ReportRequest request;
+    request.set_service_name("test-service");
+    request.set_service_config_id("test-config-id");
+    request.add_operations()->set_operation_id(operation_id);
+    return request;
+  }
+
+  NiceMock<Stats::MockIsolatedStatsStore> store_;
+  ServiceControlFilterStatBase stat_base_;
+  NiceMock<Event::MockDispatcher> dispatcher_;
+  Event::MockTimer* timer_;
+  std::vector<ReportRequest> sent_;
+  std::unique_ptr<ReportBatcher> batcher_;
+};
+
+TEST_F(ReportBatcherTest, FlushOnMaxSize) {
+  makeBatcher(2, 1024 * 1024);
+
+  EXPECT_CALL(*timer_, enableTimer(std::chrono::milliseconds(1000), _))
+      .Times(1);
+  batcher_->add(makeReport("1"));
+  EXPECT_TRUE(sent_.empty());
+
+  batcher_->add(makeReport("2"));
+  ASSERT_EQ(sent_.size(), 1);
+  EXPECT_EQ(sent_[0].service_name(), "test-service");
+  EXPECT_EQ(sent_[0].service_config_id(), "test-config-id");
+  ASSERT_EQ(sent_[0].operations_size(), 2);
+  EXPECT_EQ(sent_[0].operations(0).operation_id(), "1");
+  EXPECT_EQ(sent_[0].operations(1).operation_id(), "2");
+  EXPECT_EQ(stat_base_.stats().report_batches_sent_.value(), 1);
+}
+
+TEST_F(ReportBatcherTest, FlushOnMaxDelay) {
+  makeBatcher(100, 1024 * 1024);
+
+  batcher_->add(makeReport("1"));
+  EXPECT_TRUE(sent_.empty());
+
+  timer_->invokeCallback();
+  ASSERT_EQ(sent_.size(), 1);
+  EXPECT_EQ(sent_[0].operations_size(), 1);
+}
+
+TEST_F(ReportBatcherTest, DropOverMaxBytes) {
+  const uint64_t report_bytes = makeReport("1").ByteSizeLong();
+  makeBatcher(100, report_bytes);
+
+  batcher_->add(makeReport("1"));
+  batcher_->add(makeReport("2"));
+  EXPECT_EQ(stat_base_.stats().reports_dropped_.value(), 1);
+
+  batcher_->flush();
+  ASSERT_EQ(sent_.size(), 1);
+  ASSERT_EQ(sent_[0].operations_size(), 1);
+  EXPECT_EQ(sent_[0].operations(0).operation_id(), "1");
+
+  // The memory is released once the batch is sent.
+  batcher_->add(makeReport("3"));
+  EXPECT_EQ(stat_base_.stats().reports_dropped_.value(), 1);
+}
+
+TEST_F(ReportBatcherTest, FlushOnDestroy) {
+  makeBatcher(100, 1024 * 1024);
+
+  batcher_->flush();
+  EXPECT_TRUE(sent_.empty());
+
+  batcher_->add(makeReport("1"));
+  batcher_.reset();
+  ASSERT_EQ(sent_.size(), 1);
+  EXPECT_EQ(sent_[0].operations_size(), 1);
+}
+
+}  // namespace
+}  // namespace ServiceControl
+}  // namespace HttpFilters
+}  // namespace Extensions
+}  // namespace Envoy
@@ -0,0 +1,181 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+#include "src/envoy/http/service_control/report_queue.h"
+
+#include <unistd.h>
+
+#include <atomic>
+#include <cstdio>
+
+#include "absl/strings/str_cat.h"
+#include "google/protobuf/util/delimited_message_util.h"
+
+using ::google::api::servicecontrol::v1::ReportRequest;
+
+namespace Envoy {
+namespace Extensions {
+namespace HttpFilters {
+namespace ServiceControl {
+namespace {
+
+// Each worker thread spills to its own file.
+std::atomic<uint32_t> next_spill_file_id(0);
+
+}  // namespace
+
+ReportQueue::ReportQueue(uint64_t max_bytes, const std::string& spill_dir,
+                         uint64_t spill_max_bytes,
+                         ServiceControlFilterStats& stats)
+    : max_bytes_(max_bytes),
+      spill_max_bytes_(spill_max_bytes),
+      stats_(stats),
+      bytes_(0),
+      spill_bytes_(0) {
+  if (!spill_dir.empty()) {
+    spill_path_ = absl::StrCat(spill_dir, "/service_control_reports_",
+                               ::getpid(), "_", next_spill_file_id++, ".bin");
+    draining_path_ = absl::StrCat(spill_path_, ".draining");
+  }
+}
+
+ReportQueue::~ReportQueue() {
+  if (!empty()) {
+    ENVOY_LOG(warn, "Dropped {} queued reports and {} bytes of spilled reports",
+              reports_.size(), spill_bytes_);
+  }
+  spill_out_.reset();
+  if (spill_bytes_ > 0) {
+    std::remove(spill_path_.c_str());
+  }
+  if (spill_in_ != nullptr) {
+    stopReadingSpillFile();
+  }
+}
+
+void ReportQueue::push(const ReportRequest& request) {
+  const uint64_t request_bytes = request.ByteSizeLong();
+  if (bytes_ + request_bytes <= max_bytes_) {
+    reports_.push_back(request);
+    bytes_ += request_bytes;
+    stats_.reports_queued_.inc();
+    return;
+  }
+
+  if (spill(request, request_bytes)) {
+    stats_.reports_spilled_.inc();
+    return;
+  }
+
+  ENVOY_LOG(warn, "Dropped a report of {} bytes, the report queue is full",
+            request_bytes);
+  stats_.reports_dropped_.inc();
+}
+
+bool ReportQueue::spill(const ReportRequest& request, uint64_t request_bytes) {
+  if (spill_path_.empty() || spill_bytes_ + request_bytes > spill_max_bytes_) {
+    return false;
+  }
+
+  if (spill_out_ == nullptr) {
+    spill_out_ = std::make_unique<std::ofstream>(
+        spill_path_, std::ios::binary | std::ios::app);
+  }
+  if (!*spill_out_ || !::google::protobuf::util::SerializeDelimitedToOstream(
+                          request, spill_out_.get())) {
+    ENVOY_LOG(error, "Failed to spill a report to {}", spill_path_);
+    // The file is opened again for the next report.
+    spill_out_.reset();
+    return false;
+  }
+  spill_bytes_ += request_bytes;
+  return true;
+}
+
+bool ReportQueue::drain(const SendFunc& send_fn, uint32_t max_reports) {
+  uint32_t sent = 0;
+  while (sent < max_reports && !reports_.empty()) {
+    const ReportRequest request = std::move(reports_.front());
+    reports_.pop_front();
+    bytes_ -= request.ByteSizeLong();
+    send_fn(request);
+    ++sent;
+  }
+  if (sent < max_reports) {
+    drainSpillFile(send_fn, max_reports - sent);
+  }
+  return !empty();
+}
+
+bool ReportQueue::startReadingSpillFile() {
+  if (spill_bytes_ == 0) {
+    return false;
+  }
+  // Closing the stream flushes it.
+  spill_out_.reset();
+  spill_bytes_ = 0;
+  if (std::rename(spill_path_.c_str(), draining_path_.c_str()) != 0) {
+    ENVOY_LOG(error, "Failed to read the spilled reports from {}",
+              spill_path_);
+    std::remove(spill_path_.c_str());
+    return false;
+  }
+
+  spill_in_ = std::make_unique<std::ifstream>(draining_path_, std::ios::binary);
+  if (!*spill_in_) {
+    ENVOY_LOG(error, "Failed to read the spilled reports from {}",
+              draining_path_);
+    stopReadingSpillFile();
+    return false;
+  }
+  spill_input_ = std::make_unique<::google::protobuf::io::IstreamInputStream>(
+      spill_in_.get());
+  return true;
+}
+
+uint32_t ReportQueue::drainSpillFile(const SendFunc& send_fn,
+                                     uint32_t max_reports) {
+  uint32_t sent = 0;
+  ReportRequest request;
+  while (sent < max_reports) {
+    if (spill_in_ == nullptr && !startReadingSpillFile()) {
+      break;
+    }
+    bool clean_eof = false;
+    if (!::google::protobuf::util::ParseDelimitedFromZeroCopyStream(
+            &request, spill_input_.get(), &clean_eof)) {
+      if (!clean_eof) {
+        ENVOY_LOG(error, "Failed to parse the spilled reports from {}",
+                  draining_path_);
+      }
+      // The reports spilled meanwhile are in the next spill file.
+      stopReadingSpillFile();
+      continue;
+    }
+    send_fn(request);
+    ++sent;
+  }
+  return sent;
+}
+
+void ReportQueue::stopReadingSpillFile() {
+  spill_input_.reset();
+  spill_in_.reset();
+  std::remove(draining_path_.c_str());
+}
+
+}  // namespace ServiceControl
+}  // namespace HttpFilters
+}  // namespace Extensions
+}  // namespace Envoy
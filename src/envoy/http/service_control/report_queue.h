@@ -0,0 +1,90 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+#pragma once
+
+#include <deque>
+#include <fstream>
+#include <memory>
+
+#include "common/common/logger.h"
+#include "google/api/servicecontrol/v1/service_controller.pb.h"
+#include "google/protobuf/io/zero_copy_stream_impl.h"
+#include "src/envoy/http/service_control/filter_stats.h"
+
+namespace Envoy {
+namespace Extensions {
+namespace HttpFilters {
+namespace ServiceControl {
+
+// The queue of the reports of a worker thread not sent while Service Control
+// is unavailable. The reports are kept in memory up to max_bytes, then
+// appended to a file in spill_dir up to spill_max_bytes. The reports exceeding
+// them are dropped. The spill file is kept open while reports are spilled.
+//
+// The queue is drained in chunks. When the spilled reports start to be read,
+// the spill file is renamed, so the reports spilled meanwhile go to a new one.
+class ReportQueue : public Logger::Loggable<Logger::Id::filter> {
+ public:
+  using SendFunc = std::function<void(
+      const ::google::api::servicecontrol::v1::ReportRequest& request)>;
+
+  ReportQueue(uint64_t max_bytes, const std::string& spill_dir,
+              uint64_t spill_max_bytes, ServiceControlFilterStats& stats);
+
+  // Removes the spill files, the reports still in the queue are lost.
+  ~ReportQueue();
+
+  void push(const ::google::api::servicecontrol::v1::ReportRequest& request);
+
+  // Sends up to max_reports queued reports, the ones in memory first, then
+  // the spilled ones in the order they were spilled. Returns whether reports
+  // are left in the queue.
+  bool drain(const SendFunc& send_fn, uint32_t max_reports);
+
+  bool empty() const {
+    return reports_.empty() && spill_bytes_ == 0 && spill_in_ == nullptr;
+  }
+
+ private:
+  bool spill(const ::google::api::servicecontrol::v1::ReportRequest& request,
+             uint64_t request_bytes);
+  // Renames the spill file to read its reports.
+  bool startReadingSpillFile();
+  uint32_t drainSpillFile(const SendFunc& send_fn, uint32_t max_reports);
+  void stopReadingSpillFile();
+
+  const uint64_t max_bytes_;
+  const uint64_t spill_max_bytes_;
+  ServiceControlFilterStats& stats_;
+
+  std::deque<::google::api::servicecontrol::v1::ReportRequest> reports_;
+  uint64_t bytes_;
+
+  // The file to spill the reports to, empty if spilling is disabled, and its
+  // stream while it is open.
+  std::string spill_path_;
+  uint64_t spill_bytes_;
+  std::unique_ptr<std::ofstream> spill_out_;
+
+  // The renamed spill file whose reports are being drained, and its streams.
+  std::string draining_path_;
+  std::unique_ptr<std::ifstream> spill_in_;
+  std::unique_ptr<::google::protobuf::io::IstreamInputStream> spill_input_;
+};
+
+}  // namespace ServiceControl
+}  // namespace HttpFilters
+}  // namespace Extensions
+}  // namespace Envoy
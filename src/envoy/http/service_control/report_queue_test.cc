@@ -0,0 +1,130 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+#include "src/envoy/http/service_control/report_queue.h"
+
+#include <vector>
+
+#include "gmock/gmock.h"
+#include "gtest/gtest.h"
+#include "test/mocks/stats/mocks.h"
+#include "test/test_common/environment.h"
+
+using ::google::api::servicecontrol::v1::ReportRequest;
+
+namespace Envoy {
+namespace Extensions {
+namespace HttpFilters {
+namespace ServiceControl {
+namespace {
+
+class ReportQueueTest : public testing::Test {
+ protected:
+  ReportQueueTest() : stat_base_("test.", store_) {}
+
+  static ReportRequest makeReport(const std::string& operation_id) {
+    ReportRequest request;
+    request.set_service_name("test-service");
+    request.add_operations()->set_operation_id(operation_id);
+    return request;
+  }
+
+  // Drains the queue in chunks of 2 reports.
+  std::vector<std::string> drain(ReportQueue& queue) {
+    std::vector<std::string> operation_ids;
+    while (drainChunk(queue, 2, &operation_ids)) {
+    }
+    return operation_ids;
+  }
+
+  bool drainChunk(ReportQueue& queue, uint32_t max_reports,
+                  std::vector<std::string>* operation_ids) {
+    return queue.drain(
+        [operation_ids](const ReportRequest& request) {
+          operation_ids->push_back(request.operations(0).operation_id());
+        },
+        max_reports);
+  }
+
+  NiceMock<Stats::MockIsolatedStatsStore> store_;
+  ServiceControlFilterStatBase stat_base_;
+  const uint64_t report_bytes_ = makeReport("1").ByteSizeLong();
+};
+
+TEST_F(ReportQueueTest, DropWithoutSpillDir) {
+  ReportQueue queue(2 * report_bytes_, "", 0, stat_base_.stats());
+
+  queue.push(makeReport("1"));
+  queue.push(makeReport("2"));
+  queue.push(makeReport("3"));
+  EXPECT_EQ(stat_base_.stats().reports_queued_.value(), 2);
+  EXPECT_EQ(stat_base_.stats().reports_dropped_.value(), 1);
+
+  EXPECT_THAT(drain(queue), testing::ElementsAre("1", "2"));
+  EXPECT_TRUE(queue.empty());
+  EXPECT_TRUE(drain(queue).empty());
+}
+
+TEST_F(ReportQueueTest, SpillToDisk) {
+  ReportQueue queue(report_bytes_, TestEnvironment::temporaryDirectory(),
+                    2 * report_bytes_, stat_base_.stats());
+
+  queue.push(makeReport("1"));
+  queue.push(makeReport("2"));
+  queue.push(makeReport("3"));
+  queue.push(makeReport("4"));
+  EXPECT_EQ(stat_base_.stats().reports_queued_.value(), 1);
+  EXPECT_EQ(stat_base_.stats().reports_spilled_.value(), 2);
+  EXPECT_EQ(stat_base_.stats().reports_dropped_.value(), 1);
+
+  EXPECT_THAT(drain(queue), testing::ElementsAre("1", "2", "3"));
+  EXPECT_TRUE(queue.empty());
+
+  // The queue is reusable after it is drained.
+  queue.push(makeReport("5"));
+  queue.push(makeReport("6"));
+  EXPECT_THAT(drain(queue), testing::ElementsAre("5", "6"));
+}
+
+TEST_F(ReportQueueTest, DrainInChunks) {
+  ReportQueue queue(report_bytes_, TestEnvironment::temporaryDirectory(),
+                    3 * report_bytes_, stat_base_.stats());
+
+  queue.push(makeReport("1"));
+  queue.push(makeReport("2"));
+  queue.push(makeReport("3"));
+  queue.push(makeReport("4"));
+
+  std::vector<std::string> operation_ids;
+  EXPECT_TRUE(drainChunk(queue, 2, &operation_ids));
+  EXPECT_THAT(operation_ids, testing::ElementsAre("1", "2"));
+
+  // The reports spilled while the spill file is drained go to a new one.
+  queue.push(makeReport("5"));
+  queue.push(makeReport("6"));
+  EXPECT_EQ(stat_base_.stats().reports_spilled_.value(), 4);
+
+  EXPECT_TRUE(drainChunk(queue, 2, &operation_ids));
+  EXPECT_THAT(operation_ids, testing::ElementsAre("1", "2", "5", "3"));
+  EXPECT_FALSE(drainChunk(queue, 3, &operation_ids));
+  EXPECT_THAT(operation_ids,
+              testing::ElementsAre("1", "2", "5", "3", "4", "6"));
+  EXPECT_TRUE(queue.empty());
+}
+
+}  // namespace
+}  // namespace ServiceControl
+}  // namespace HttpFilters
+}  // namespace Extensions
+}  // namespace Envoy
@@ -14,6 +14,7 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
+#include "absl/strings/ascii.h"
 #include "src/api_proxy/service_control/logs_metrics_loader.h"
 #include "src/envoy/http/service_control/service_control_call_impl.h"
 
@@ -21,8 +22,10 @@ using Envoy::Extensions::Token::ServiceAccountTokenGenerator;
 using Envoy::Extensions::Token::TokenSubscriber;
 using Envoy::Extensions::Token::TokenType;
 using ::google::api::envoy::http::common::AccessToken;
+using ::google::api::envoy::http::common::DataSource;
 using ::google::api::envoy::http::service_control::FilterConfig;
 using ::google::api::envoy::http::service_control::Service;
+using ::google::api::envoy::http::service_control::ServiceControlTransport;
 using ::google::api_proxy::service_control::LogsMetricsLoader;
 using ::google::api_proxy::service_control::RequestBuilder;
 
@@ -40,6 +43,13 @@ constexpr char kServiceControlService[] =
 constexpr char kQuotaControlService[] =
     "/google.api.servicecontrol.v1.QuotaController";
 
+// The interval to check if the report calls are completed on shutdown.
+constexpr std::chrono::milliseconds kShutdownPollInterval(50);
+
+// The interval to read the file of the auth header value again, to pick up a
+// rotated value.
+constexpr std::chrono::seconds kAuthHeaderValueRefreshInterval(60);
+
 }  // namespace
 
 void ServiceControlCallImpl::createImdsTokenSub() {
@@ -119,22 +129,86 @@ void ServiceControlCallImpl::createIamTokenSub() {
       [this]() { return access_token_for_iam_; });
 }
 
+void ServiceControlCallImpl::createAuthHeaderValueSub(
+    Event::Dispatcher& dispatcher, Api::Api& api) {
+  const DataSource& value_source =
+      filter_config_.sc_transport().auth_header_value();
+  if (value_source.specifier_case() == DataSource::kInlineString) {
+    setAuthHeaderValue(value_source.inline_string());
+    return;
+  }
+
+  auth_header_value_timer_ = dispatcher.createTimer([this, &api]() {
+    readAuthHeaderValue(api);
+    auth_header_value_timer_->enableTimer(kAuthHeaderValueRefreshInterval);
+  });
+  readAuthHeaderValue(api);
+  auth_header_value_timer_->enableTimer(kAuthHeaderValueRefreshInterval);
+}
+
+void ServiceControlCallImpl::readAuthHeaderValue(Api::Api& api) {
+  const std::string& filename =
+      filter_config_.sc_transport().auth_header_value().filename();
+  std::string value;
+  try {
+    value = std::string(
+        absl::StripAsciiWhitespace(api.fileSystem().fileReadToEnd(filename)));
+  } catch (const EnvoyException& e) {
+    // The last value is kept.
+    ENVOY_LOG(error, "Failed to read the auth header value from {}: {}",
+              filename, e.what());
+    return;
+  }
+  setAuthHeaderValue(value);
+}
+
+void ServiceControlCallImpl::setAuthHeaderValue(const std::string& value) {
+  if (value == auth_header_value_) {
+    return;
+  }
+  auth_header_value_ = value;
+  // The header value is sent in place of the access tokens.
+  TokenSharedPtr new_value = std::make_shared<std::string>(value);
+  tls_->runOnAllThreads([this, new_value]() {
+    tls_->getTyped<ThreadLocalCache>().set_sc_token(new_value);
+    tls_->getTyped<ThreadLocalCache>().set_quota_token(new_value);
+  });
+}
+
 ServiceControlCallImpl::ServiceControlCallImpl(
     FilterConfigProtoSharedPtr proto_config, const Service& config,
+    ServiceControlFilterStats& stats,
     Server::Configuration::FactoryContext& context)
     : filter_config_(*proto_config),
       token_subscriber_factory_(context),
-      tls_(context.threadLocal().allocateSlot()) {
+      tls_(context.threadLocal().allocateSlot()),
+      in_flight_reports_(std::make_shared<std::atomic<uint32_t>>(0)) {
   // Pass shared_ptr of proto_config to the function capture so that
   // it will not be released when the function is called.
   tls_->set([proto_config, &config, &cm = context.clusterManager(),
-             &time_source = context.timeSource()](Event::Dispatcher& dispatcher)
+             &time_source = context.timeSource(), &stats,
+             in_flight_reports = in_flight_reports_](
+                Event::Dispatcher& dispatcher)
                 -> ThreadLocal::ThreadLocalObjectSharedPtr {
     return std::make_shared<ThreadLocalCache>(config, *proto_config, cm,
-                                              time_source, dispatcher);
+                                              time_source, dispatcher, stats,
+                                              in_flight_reports);
   });
 
-  switch (filter_config_.access_token_case()) {
+  shutdown_handle_ = context.lifecycleNotifier().registerCallback(
+      Server::ServerLifecycleNotifier::Stage::ShutdownExit,
+      [this, &dispatcher = context.dispatcher()](Event::PostCb completion_cb) {
+        flushReportsOnShutdown(dispatcher, completion_cb);
+      });
+
+  // The access tokens are only sent with the ACCESS_TOKEN auth.
+  const auto& transport = filter_config_.sc_transport();
+  if (transport.auth() == ServiceControlTransport::HEADER) {
+    createAuthHeaderValueSub(context.dispatcher(), context.api());
+  }
+  switch (transport.auth() == ServiceControlTransport::ACCESS_TOKEN
+              ? filter_config_.access_token_case()
+              : FilterConfig::ACCESS_TOKEN_NOT_SET) {
     case FilterConfig::kImdsToken: {
       createImdsTokenSub();
     } break;
@@ -145,7 +219,9 @@ ServiceControlCallImpl::ServiceControlCallImpl(
       createIamTokenSub();
     } break;
     default:
-      ENVOY_LOG(error, "No access token set!");
+      if (transport.auth() == ServiceControlTransport::ACCESS_TOKEN) {
+        ENVOY_LOG(error, "No access token set!");
+      }
       break;
   }
 
@@ -166,6 +242,39 @@ ServiceControlCallImpl::ServiceControlCallImpl(
   }
 }  // namespace ServiceControl
 
+void ServiceControlCallImpl::flushReportsOnShutdown(
+    Event::Dispatcher& dispatcher, Event::PostCb completion_cb) {
+  const uint32_t report_timeout_ms =
+      getTLCache().client_cache().report_timeout_ms();
+  ENVOY_LOG(info, "Flushing the batched reports on shutdown");
+  tls_->runOnAllThreads(
+      [this]() { getTLCache().client_cache().flushReports(); },
+      [this, &dispatcher, completion_cb, report_timeout_ms]() {
+        shutdown_deadline_ = dispatcher.timeSource().monotonicTime() +
+                             std::chrono::milliseconds(report_timeout_ms);
+        shutdown_timer_ = dispatcher.createTimer(
+            [this, &dispatcher, completion_cb]() {
+              waitForReports(dispatcher, completion_cb);
+            });
+        waitForReports(dispatcher, completion_cb);
+      });
+}
+
+void ServiceControlCallImpl::waitForReports(Event::Dispatcher& dispatcher,
+                                            Event::PostCb completion_cb) {
+  if (*in_flight_reports_ == 0) {
+    completion_cb();
+    return;
+  }
+  if (dispatcher.timeSource().monotonicTime() >= shutdown_deadline_) {
+    ENVOY_LOG(warn, "Timed out waiting for {} report calls on shutdown",
+              in_flight_reports_->load());
+    completion_cb();
+    return;
+  }
+  shutdown_timer_->enableTimer(kShutdownPollInterval);
+}
+
 CancelFunc ServiceControlCallImpl::callCheck(
     const ::google::api_proxy::service_control::CheckRequestInfo& request_info,
     Envoy::Tracing::Span& parent_span, CheckDoneFunc on_done) {
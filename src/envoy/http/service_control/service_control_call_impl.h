@@ -16,12 +16,16 @@
 
 #include "api/envoy/http/service_control/config.pb.h"
 #include "common/common/logger.h"
+#include "envoy/api/api.h"
+#include "envoy/event/timer.h"
 #include "envoy/server/filter_config.h"
+#include "envoy/server/lifecycle_notifier.h"
 #include "envoy/thread_local/thread_local.h"
 #include "envoy/upstream/cluster_manager.h"
 #include "google/api/service.pb.h"
 #include "src/api_proxy/service_control/request_builder.h"
 #include "src/envoy/http/service_control/client_cache.h"
+#include "src/envoy/http/service_control/filter_stats.h"
 #include "src/envoy/http/service_control/service_control_call.h"
 #include "src/envoy/token/token_subscriber_factory_impl.h"
 
@@ -44,11 +48,13 @@ class ThreadLocalCache : public ThreadLocal::ThreadLocalObject {
       const ::google::api::envoy::http::service_control::FilterConfig&
           filter_config,
       Upstream::ClusterManager& cm, Envoy::TimeSource& time_source,
-      Event::Dispatcher& dispatcher)
+      Event::Dispatcher& dispatcher, ServiceControlFilterStats& stats,
+      std::shared_ptr<std::atomic<uint32_t>> in_flight_reports)
       : client_cache_(
             config, filter_config, cm, time_source, dispatcher,
             [this]() -> const std::string& { return sc_token(); },
-            [this]() -> const std::string& { return quota_token(); }) {}
+            [this]() -> const std::string& { return quota_token(); }, stats,
+            in_flight_reports) {}
 
   void set_sc_token(TokenSharedPtr sc_token) { sc_token_ = sc_token; }
   const std::string& sc_token() const {
@@ -85,6 +91,7 @@ class ServiceControlCallImpl : public ServiceControlCall,
   ServiceControlCallImpl(
       FilterConfigProtoSharedPtr proto_config,
       const ::google::api::envoy::http::service_control::Service& config,
+      ServiceControlFilterStats& stats,
       Server::Configuration::FactoryContext& context);
 
   CancelFunc callCheck(
@@ -109,6 +116,19 @@ class ServiceControlCallImpl : public ServiceControlCall,
   void createTokenGen();
   void createIamTokenSub();
 
+  // Reads the auth header value of the HEADER auth, and reads its file again
+  // periodically.
+  void createAuthHeaderValueSub(Event::Dispatcher& dispatcher, Api::Api& api);
+  void readAuthHeaderValue(Api::Api& api);
+  void setAuthHeaderValue(const std::string& value);
+
+  // Flushes the batched reports of all the threads when the proxy shuts down,
+  // and waits for the report calls to complete.
+  void flushReportsOnShutdown(Event::Dispatcher& dispatcher,
+                              Event::PostCb completion_cb);
+  void waitForReports(Event::Dispatcher& dispatcher,
+                      Event::PostCb completion_cb);
+
   const ::google::api::envoy::http::service_control::FilterConfig&
       filter_config_;
   std::unique_ptr<::google::api_proxy::service_control::RequestBuilder>
@@ -126,27 +146,41 @@ class ServiceControlCallImpl : public ServiceControlCall,
   // Token subscriber used to fetch access token from iam for service control
   Token::TokenSubscriberPtr iam_token_sub_;
 
+  // The auth header value of the HEADER auth, and the timer to read its file
+  // again.
+  std::string auth_header_value_;
+  Event::TimerPtr auth_header_value_timer_;
+
   Token::ServiceAccountTokenPtr sc_token_gen_;
   Token::ServiceAccountTokenPtr quota_token_gen_;
   ThreadLocal::SlotPtr tls_;
+
+  // The number of report calls in flight on all the threads.
+  std::shared_ptr<std::atomic<uint32_t>> in_flight_reports_;
+  // The deadline to wait for the report calls on shutdown.
+  MonotonicTime shutdown_deadline_;
+  Event::TimerPtr shutdown_timer_;
+  Server::ServerLifecycleNotifier::HandlePtr shutdown_handle_;
 };  // namespace ServiceControl
 
 class ServiceControlCallFactoryImpl : public ServiceControlCallFactory {
  public:
   explicit ServiceControlCallFactoryImpl(
       FilterConfigProtoSharedPtr proto_config,
+      ServiceControlFilterStats& stats,
       Server::Configuration::FactoryContext& context)
-      : proto_config_(proto_config), context_(context) {}
+      : proto_config_(proto_config), stats_(stats), context_(context) {}
 
   ServiceControlCallPtr create(
       const ::google::api::envoy::http::service_control::Service& config)
       override {
     return std::make_unique<ServiceControlCallImpl>(proto_config_, config,
-                                                    context_);
+                                                    stats_, context_);
   }
 
  private:
   FilterConfigProtoSharedPtr proto_config_;
+  ServiceControlFilterStats& stats_;
   Server::Configuration::FactoryContext& context_;
 };
 
@@ -0,0 +1,298 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+#include "src/envoy/http/service_control/transport.h"
+
+#include "absl/strings/str_cat.h"
+#include "absl/strings/str_replace.h"
+#include "common/common/enum_to_int.h"
+#include "common/grpc/common.h"
+#include "common/http/headers.h"
+#include "common/http/utility.h"
+
+using ::google::api::envoy::http::service_control::ServiceControlTransport;
+using ::google::protobuf::util::Status;
+using ::google::protobuf::util::error::Code;
+
+namespace Envoy {
+namespace Extensions {
+namespace HttpFilters {
+namespace ServiceControl {
+namespace {
+
+constexpr absl::string_view KApplicationProto = "application/x-protobuf";
+
+// The gRPC methods of the calls.
+constexpr char kGrpcCheckPath[] =
+    "/google.api.servicecontrol.v1.ServiceController/Check";
+constexpr char kGrpcQuotaPath[] =
+    "/google.api.servicecontrol.v1.QuotaController/AllocateQuota";
+constexpr char kGrpcReportPath[] =
+    "/google.api.servicecontrol.v1.ServiceController/Report";
+
+// The size of the header of the gRPC frames: the compressed flag and the
+// message size.
+constexpr size_t kGrpcFrameHeaderSize = 5;
+
+const Status& callFailedStatus() {
+  static const Status* const kCallFailed =
+      new Status(Code::INTERNAL, "Failed to call service control");
+  return *kCallFailed;
+}
+
+// The base of the wire formats, with the paths of the transport overriding the
+// ones of the protocol.
+class WireFormatBase : public WireFormat {
+ public:
+  explicit WireFormatBase(const ServiceControlTransport& transport)
+      : check_path_(transport.check_path()),
+        quota_path_(transport.quota_path()),
+        report_path_(transport.report_path()) {}
+
+  std::string suffixUrl(CallKind kind,
+                        const std::string& service_name) const override {
+    const std::string* path = &report_path_;
+    if (kind == CallKind::Check) {
+      path = &check_path_;
+    } else if (kind == CallKind::Quota) {
+      path = &quota_path_;
+    }
+    if (path->empty()) {
+      return defaultSuffixUrl(kind, service_name);
+    }
+    return absl::StrReplaceAll(*path, {{"{service}", service_name}});
+  }
+
+ protected:
+  virtual std::string defaultSuffixUrl(
+      CallKind kind, const std::string& service_name) const PURE;
+
+ private:
+  const std::string check_path_;
+  const std::string quota_path_;
+  const std::string report_path_;
+};
+
+// The protobuf HTTP calls of the REST API of Google Service Control.
+class HttpWireFormat : public WireFormatBase {
+ public:
+  using WireFormatBase::WireFormatBase;
+
+  std::string encodeRequest(const Protobuf::Message& request) const override {
+    return request.SerializeAsString();
+  }
+
+  void setRequestHeaders(Http::RequestHeaderMap& headers) const override {
+    headers.setContentType(KApplicationProto);
+  }
+
+  Status decodeResponse(Http::ResponseMessage& response,
+                        std::string* response_body,
+                        bool* retryable) const override {
+    const uint64_t status_code =
+        Http::Utility::getResponseStatus(response.headers());
+    if (response.body()) {
+      const auto len = response.body()->length();
+      *response_body = std::string(
+          static_cast<char*>(response.body()->linearize(len)), len);
+    }
+    if (status_code == enumToInt(Http::Code::OK)) {
+      return Status::OK;
+    }
+    // The client side problems are not retried.
+    *retryable = status_code < 400 || status_code >= 500;
+    return callFailedStatus();
+  }
+
+ protected:
+  std::string defaultSuffixUrl(CallKind kind,
+                               const std::string& service_name) const override {
+    switch (kind) {
+      case CallKind::Check:
+        return service_name + ":check";
+      case CallKind::Quota:
+        return service_name + ":allocateQuota";
+      default:
+        return service_name + ":report";
+    }
+  }
+};
+
+// The gRPC calls of the ServiceController and QuotaController services.
+class GrpcWireFormat : public WireFormatBase {
+ public:
+  using WireFormatBase::WireFormatBase;
+
+  std::string encodeRequest(const Protobuf::Message& request) const override {
+    const std::string message = request.SerializeAsString();
+    const uint32_t size = message.size();
+    // The message is not compressed.
+    std::string frame(kGrpcFrameHeaderSize, '\0');
+    frame[1] = static_cast<char>(size >> 24);
+    frame[2] = static_cast<char>(size >> 16);
+    frame[3] = static_cast<char>(size >> 8);
+    frame[4] = static_cast<char>(size);
+    return frame + message;
+  }
+
+  void setRequestHeaders(Http::RequestHeaderMap& headers) const override {
+    headers.setReferenceContentType(
+        Http::Headers::get().ContentTypeValues.Grpc);
+    headers.setReferenceTE(Http::Headers::get().TEValues.Trailers);
+  }
+
+  Status decodeResponse(Http::ResponseMessage& response,
+                        std::string* response_body,
+                        bool* retryable) const override {
+    const uint64_t status_code =
+        Http::Utility::getResponseStatus(response.headers());
+    if (status_code != enumToInt(Http::Code::OK)) {
+      *retryable = status_code < 400 || status_code >= 500;
+      return callFailedStatus();
+    }
+
+    // The status of the trailers-only responses is in their headers.
+    absl::optional<Grpc::Status::GrpcStatus> grpc_status;
+    if (response.trailers()) {
+      grpc_status = Grpc::Common::getGrpcStatus(*response.trailers());
+    }
+    if (!grpc_status) {
+      grpc_status = Grpc::Common::getGrpcStatus(response.headers());
+    }
+    if (!grpc_status) {
+      *retryable = true;
+      return callFailedStatus();
+    }
+    switch (grpc_status.value()) {
+      case Grpc::Status::WellKnownGrpcStatus::Ok:
+        break;
+      case Grpc::Status::WellKnownGrpcStatus::Unknown:
+      case Grpc::Status::WellKnownGrpcStatus::DeadlineExceeded:
+      case Grpc::Status::WellKnownGrpcStatus::Internal:
+      case Grpc::Status::WellKnownGrpcStatus::Unavailable:
+        *retryable = true;
+        return callFailedStatus();
+      default:
+        return callFailedStatus();
+    }
+
+    if (!response.body() ||
+        response.body()->length() < kGrpcFrameHeaderSize) {
+      return callFailedStatus();
+    }
+    const auto len = response.body()->length();
+    const auto* frame =
+        static_cast<const uint8_t*>(response.body()->linearize(len));
+    const uint32_t size = (static_cast<uint32_t>(frame[1]) << 24) |
+                          (static_cast<uint32_t>(frame[2]) << 16) |
+                          (static_cast<uint32_t>(frame[3]) << 8) |
+                          static_cast<uint32_t>(frame[4]);
+    // Only a single uncompressed message is expected.
+    if (frame[0] != 0 || size != len - kGrpcFrameHeaderSize) {
+      return callFailedStatus();
+    }
+    *response_body = std::string(
+        reinterpret_cast<const char*>(frame) + kGrpcFrameHeaderSize, size);
+    return Status::OK;
+  }
+
+ protected:
+  std::string defaultSuffixUrl(CallKind kind,
+                               const std::string&) const override {
+    switch (kind) {
+      case CallKind::Check:
+        return kGrpcCheckPath;
+      case CallKind::Quota:
+        return kGrpcQuotaPath;
+      default:
+        return kGrpcReportPath;
+    }
+  }
+};
+
+// The access token sent as a bearer token.
+class AccessTokenCredentials : public CallCredentials {
+ public:
+  explicit AccessTokenCredentials(std::function<const std::string&()> token_fn)
+      : token_fn_(token_fn) {}
+
+  Status apply(Http::RequestHeaderMap& headers) const override {
+    const std::string& token = token_fn_();
+    if (token.empty()) {
+      return Status(Code::INTERNAL,
+                    "Missing access token for service control call");
+    }
+    headers.setAuthorization(absl::StrCat("Bearer ", token));
+    return Status::OK;
+  }
+
+ private:
+  std::function<const std::string&()> token_fn_;
+};
+
+class NoCredentials : public CallCredentials {
+ public:
+  Status apply(Http::RequestHeaderMap&) const override { return Status::OK; }
+};
+
+// A header read from a file, like an API key of the server.
+class HeaderCredentials : public CallCredentials {
+ public:
+  HeaderCredentials(const std::string& name,
+                    std::function<const std::string&()> value_fn)
+      : name_(name), value_fn_(value_fn) {}
+
+  Status apply(Http::RequestHeaderMap& headers) const override {
+    const std::string& value = value_fn_();
+    if (value.empty()) {
+      return Status(Code::INTERNAL,
+                    "Missing auth header value for service control call");
+    }
+    headers.setCopy(name_, value);
+    return Status::OK;
+  }
+
+ private:
+  const Http::LowerCaseString name_;
+  std::function<const std::string&()> value_fn_;
+};
+
+}  // namespace
+
+WireFormatSharedPtr createWireFormat(
+    const ServiceControlTransport& transport) {
+  if (transport.protocol() == ServiceControlTransport::GRPC) {
+    return std::make_shared<GrpcWireFormat>(transport);
+  }
+  return std::make_shared<HttpWireFormat>(transport);
+}
+
+CallCredentialsSharedPtr createCallCredentials(
+    const ServiceControlTransport& transport,
+    std::function<const std::string&()> token_fn) {
+  switch (transport.auth()) {
+    case ServiceControlTransport::NONE:
+      return std::make_shared<NoCredentials>();
+    case ServiceControlTransport::HEADER:
+      return std::make_shared<HeaderCredentials>(transport.auth_header_name(),
+                                                 token_fn);
+    default:
+      return std::make_shared<AccessTokenCredentials>(token_fn);
+  }
+}
+
+}  // namespace ServiceControl
+}  // namespace HttpFilters
+}  // namespace Extensions
+}  // namespace Envoy
@@ -0,0 +1,92 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+#pragma once
+
+#include <functional>
+#include <memory>
+#include <string>
+
+#include "api/envoy/http/service_control/config.pb.h"
+#include "envoy/common/pure.h"
+#include "envoy/http/message.h"
+#include "google/protobuf/stubs/status.h"
+
+namespace Envoy {
+namespace Extensions {
+namespace HttpFilters {
+namespace ServiceControl {
+
+// The service control calls.
+enum class CallKind { Check, Quota, Report };
+
+// The wire format of the service control calls, to call Google Service Control
+// or a server implementing its API.
+class WireFormat {
+ public:
+  virtual ~WireFormat() = default;
+
+  // Returns the suffix appended to the service control uri for a call to the
+  // service.
+  virtual std::string suffixUrl(CallKind kind,
+                                const std::string& service_name) const PURE;
+
+  // Returns the body of the request of a call.
+  virtual std::string encodeRequest(
+      const Protobuf::Message& request) const PURE;
+
+  // Sets the content type and the other headers of the protocol on the
+  // request of a call.
+  virtual void setRequestHeaders(Http::RequestHeaderMap& headers) const PURE;
+
+  // Returns the serialized response message of a call in response_body, or
+  // why the call failed. retryable is set if the call may succeed if it is
+  // sent again.
+  virtual ::google::protobuf::util::Status decodeResponse(
+      Http::ResponseMessage& response, std::string* response_body,
+      bool* retryable) const PURE;
+};
+
+typedef std::shared_ptr<const WireFormat> WireFormatSharedPtr;
+
+// The credentials sent with the service control calls.
+class CallCredentials {
+ public:
+  virtual ~CallCredentials() = default;
+
+  // Sets the credentials on the request of a call, or returns why they are
+  // missing.
+  virtual ::google::protobuf::util::Status apply(
+      Http::RequestHeaderMap& headers) const PURE;
+};
+
+typedef std::shared_ptr<const CallCredentials> CallCredentialsSharedPtr;
+
+// Returns the wire format of the protocol of the transport.
+WireFormatSharedPtr createWireFormat(
+    const ::google::api::envoy::http::service_control::ServiceControlTransport&
+        transport);
+
+// Returns the credentials of the auth of the transport. token_fn returns the
+// access token sent with the ACCESS_TOKEN auth, or the header value sent with
+// the HEADER auth.
+CallCredentialsSharedPtr createCallCredentials(
+    const ::google::api::envoy::http::service_control::ServiceControlTransport&
+        transport,
+    std::function<const std::string&()> token_fn);
+
+}  // namespace ServiceControl
+}  // namespace HttpFilters
+}  // namespace Extensions
+}  // namespace Envoy
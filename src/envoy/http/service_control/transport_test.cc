@@ -0,0 +1,217 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+#include "src/envoy/http/service_control/transport.h"
+
+#include "common/buffer/buffer_impl.h"
+#include "common/http/header_map_impl.h"
+#include "common/http/message_impl.h"
+#include "google/api/servicecontrol/v1/service_controller.pb.h"
+#include "google/protobuf/text_format.h"
+#include "gtest/gtest.h"
+#include "test/test_common/utility.h"
+
+using ::google::api::envoy::http::service_control::ServiceControlTransport;
+using ::google::api::servicecontrol::v1::CheckRequest;
+using ::google::protobuf::util::Status;
+using ::google::protobuf::util::error::Code;
+
+namespace Envoy {
+namespace Extensions {
+namespace HttpFilters {
+namespace ServiceControl {
+namespace {
+
+ServiceControlTransport makeTransport(const std::string& config) {
+  ServiceControlTransport transport;
+  EXPECT_TRUE(
+      google::protobuf::TextFormat::ParseFromString(config, &transport));
+  return transport;
+}
+
+Http::ResponseMessagePtr makeResponse(uint64_t status_code,
+                                      const std::string& body) {
+  Http::ResponseHeaderMapPtr headers =
+      std::make_unique<Http::ResponseHeaderMapImpl>();
+  headers->setStatus(status_code);
+  auto response =
+      std::make_unique<Http::ResponseMessageImpl>(std::move(headers));
+  response->body() = std::make_unique<Buffer::OwnedImpl>(body);
+  return response;
+}
+
+Http::ResponseMessagePtr makeGrpcResponse(uint64_t grpc_status,
+                                          const std::string& body) {
+  auto response = makeResponse(200, body);
+  Http::ResponseTrailerMapPtr trailers =
+      std::make_unique<Http::ResponseTrailerMapImpl>();
+  trailers->setGrpcStatus(grpc_status);
+  response->trailers(std::move(trailers));
+  return response;
+}
+
+TEST(TransportTest, HttpSuffixUrls) {
+  const auto wire_format = createWireFormat(ServiceControlTransport());
+  EXPECT_EQ(wire_format->suffixUrl(CallKind::Check, "echo"), "echo:check");
+  EXPECT_EQ(wire_format->suffixUrl(CallKind::Quota, "echo"),
+            "echo:allocateQuota");
+  EXPECT_EQ(wire_format->suffixUrl(CallKind::Report, "echo"), "echo:report");
+}
+
+TEST(TransportTest, GrpcSuffixUrls) {
+  const auto wire_format = createWireFormat(makeTransport("protocol: GRPC"));
+  EXPECT_EQ(wire_format->suffixUrl(CallKind::Check, "echo"),
+            "/google.api.servicecontrol.v1.ServiceController/Check");
+  EXPECT_EQ(wire_format->suffixUrl(CallKind::Quota, "echo"),
+            "/google.api.servicecontrol.v1.QuotaController/AllocateQuota");
+  EXPECT_EQ(wire_format->suffixUrl(CallKind::Report, "echo"),
+            "/google.api.servicecontrol.v1.ServiceController/Report");
+}
+
+TEST(TransportTest, OverriddenSuffixUrls) {
+  const auto wire_format = createWireFormat(makeTransport(R"(
+check_path: "/metering/{service}/check"
+report_path: "/metering/report")"));
+  EXPECT_EQ(wire_format->suffixUrl(CallKind::Check, "echo"),
+            "/metering/echo/check");
+  EXPECT_EQ(wire_format->suffixUrl(CallKind::Quota, "echo"),
+            "echo:allocateQuota");
+  EXPECT_EQ(wire_format->suffixUrl(CallKind::Report, "echo"),
+            "/metering/report");
+}
+
+TEST(TransportTest, HttpResponses) {
+  const auto wire_format = createWireFormat(ServiceControlTransport());
+  Http::TestRequestHeaderMapImpl headers;
+  wire_format->setRequestHeaders(headers);
+  EXPECT_EQ(headers.get_("content-type"), "application/x-protobuf");
+
+  CheckRequest request;
+  request.set_service_name("echo");
+  EXPECT_EQ(wire_format->encodeRequest(request), request.SerializeAsString());
+
+  std::string body;
+  bool retryable = false;
+  EXPECT_EQ(wire_format->decodeResponse(*makeResponse(200, "response"), &body,
+                                        &retryable),
+            Status::OK);
+  EXPECT_EQ(body, "response");
+
+  EXPECT_EQ(wire_format->decodeResponse(*makeResponse(403, ""), &body,
+                                        &retryable),
+            Status(Code::INTERNAL, "Failed to call service control"));
+  EXPECT_FALSE(retryable);
+
+  EXPECT_EQ(wire_format->decodeResponse(*makeResponse(503, ""), &body,
+                                        &retryable),
+            Status(Code::INTERNAL, "Failed to call service control"));
+  EXPECT_TRUE(retryable);
+}
+
+TEST(TransportTest, GrpcResponses) {
+  const auto wire_format = createWireFormat(makeTransport("protocol: GRPC"));
+  Http::TestRequestHeaderMapImpl headers;
+  wire_format->setRequestHeaders(headers);
+  EXPECT_EQ(headers.get_("content-type"), "application/grpc");
+  EXPECT_EQ(headers.get_("te"), "trailers");
+
+  CheckRequest request;
+  request.set_service_name("echo");
+  const std::string message = request.SerializeAsString();
+  const std::string frame = wire_format->encodeRequest(request);
+  EXPECT_EQ(frame.substr(0, 5),
+            std::string("\0\0\0\0", 4) + static_cast<char>(message.size()));
+  EXPECT_EQ(frame.substr(5), message);
+
+  // The frame of the response message is removed.
+  std::string body;
+  bool retryable = false;
+  EXPECT_EQ(wire_format->decodeResponse(*makeGrpcResponse(0, frame), &body,
+                                        &retryable),
+            Status::OK);
+  EXPECT_EQ(body, message);
+
+  // A truncated frame.
+  EXPECT_EQ(wire_format->decodeResponse(
+                *makeGrpcResponse(0, frame.substr(0, frame.size() - 1)), &body,
+                &retryable),
+            Status(Code::INTERNAL, "Failed to call service control"));
+  EXPECT_FALSE(retryable);
+
+  // PERMISSION_DENIED is not retried.
+  EXPECT_EQ(wire_format->decodeResponse(*makeGrpcResponse(7, ""), &body,
+                                        &retryable),
+            Status(Code::INTERNAL, "Failed to call service control"));
+  EXPECT_FALSE(retryable);
+
+  // UNAVAILABLE is retried.
+  EXPECT_EQ(wire_format->decodeResponse(*makeGrpcResponse(14, ""), &body,
+                                        &retryable),
+            Status(Code::INTERNAL, "Failed to call service control"));
+  EXPECT_TRUE(retryable);
+}
+
+TEST(TransportTest, AccessTokenCredentials) {
+  std::string token;
+  const auto credentials = createCallCredentials(
+      ServiceControlTransport(),
+      [&token]() -> const std::string& { return token; });
+
+  Http::TestRequestHeaderMapImpl headers;
+  EXPECT_EQ(credentials->apply(headers),
+            Status(Code::INTERNAL,
+                   "Missing access token for service control call"));
+
+  token = "fake-token";
+  EXPECT_EQ(credentials->apply(headers), Status::OK);
+  EXPECT_EQ(headers.get_("authorization"), "Bearer fake-token");
+}
+
+TEST(TransportTest, NoCredentials) {
+  const auto credentials =
+      createCallCredentials(makeTransport("auth: NONE"), nullptr);
+
+  Http::TestRequestHeaderMapImpl headers;
+  EXPECT_EQ(credentials->apply(headers), Status::OK);
+  EXPECT_FALSE(headers.has("authorization"));
+}
+
+TEST(TransportTest, HeaderCredentials) {
+  std::string value;
+  const auto credentials = createCallCredentials(
+      makeTransport(R"(
+auth: HEADER
+auth_header_name: "X-Api-Key"
+auth_header_value {
+  filename: "/etc/metering/api_key"
+})"),
+      [&value]() -> const std::string& { return value; });
+
+  Http::TestRequestHeaderMapImpl headers;
+  EXPECT_EQ(credentials->apply(headers),
+            Status(Code::INTERNAL,
+                   "Missing auth header value for service control call"));
+  EXPECT_FALSE(headers.has("x-api-key"));
+
+  value = "secret";
+  EXPECT_EQ(credentials->apply(headers), Status::OK);
+  EXPECT_EQ(headers.get_("x-api-key"), "secret");
+  EXPECT_FALSE(headers.has("authorization"));
+}
+
+}  // namespace
+}  // namespace ServiceControl
+}  // namespace HttpFilters
+}  // namespace Extensions
+}  // namespace Envoy
@@ -13,8 +13,12 @@
 // limitations under the License.
 
 #include "src/envoy/token/token_subscriber.h"
+
+#include <algorithm>
+
 #include "absl/strings/str_cat.h"
 #include "common/common/enum_to_int.h"
+#include "common/http/codes.h"
 #include "common/http/headers.h"
 #include "common/http/message_impl.h"
 #include "common/http/utility.h"
@@ -27,8 +31,12 @@ namespace Token {
 // Request timeout.
 constexpr std::chrono::milliseconds kRequestTimeoutMs(5000);
 
-// Delay after a failed fetch.
+// Delay after a failed fetch, doubled after each consecutive failure up to
+// the max, so that the token server is not overloaded while it is failing.
+// Only the transient failures, the 5xx responses and the network failures, are
+// retried.
 constexpr std::chrono::seconds kFailedRequestRetryTime(2);
+constexpr std::chrono::seconds kMaxFailedRequestRetryTime(32);
 
 // Update the token `n` seconds before the expiration.
 constexpr std::chrono::seconds kRefreshBuffer(5);
@@ -64,14 +72,35 @@ TokenSubscriber::~TokenSubscriber() {
   }
 }
 
-void TokenSubscriber::handleFailResponse() {
+void TokenSubscriber::handleFailResponse(bool retryable) {
   active_request_ = nullptr;
-  refresh_timer_->enableTimer(kFailedRequestRetryTime);
+
+  if (!retryable) {
+    // A 4xx response, e.g. a missing IAM permission, or a bad response will
+    // not be fixed by a retry.
+    ENVOY_LOG(error, "{}: not retrying the non-retryable failure", debug_name_);
+    consecutive_failures_ = 0;
+    return;
+  }
+
+  std::chrono::seconds retry_time = kFailedRequestRetryTime;
+  for (uint32_t i = 0;
+       i < consecutive_failures_ && retry_time < kMaxFailedRequestRetryTime;
+       i++) {
+    retry_time *= 2;
+  }
+  retry_time = std::min(retry_time, kMaxFailedRequestRetryTime);
+  consecutive_failures_++;
+
+  ENVOY_LOG(warn, "{}: retrying in {} sec after {} consecutive failures",
+            debug_name_, retry_time.count(), consecutive_failures_);
+  refresh_timer_->enableTimer(retry_time);
 }
 
 void TokenSubscriber::handleSuccessResponse(
     absl::string_view token, const std::chrono::seconds& expires_in) {
   active_request_ = nullptr;
+  consecutive_failures_ = 0;
 
   ENVOY_LOG(debug, "{}: Got token with expiry duration: {} , {} sec",
             debug_name_, token, expires_in.count());
@@ -100,7 +129,8 @@ void TokenSubscriber::refresh() {
   if (message == nullptr) {
     // Preconditions in TokenInfo are not met, not an error.
     ENVOY_LOG(warn, "{}: preconditions not met, retrying later", debug_name_);
-    handleFailResponse();
+    active_request_ = nullptr;
+    refresh_timer_->enableTimer(kFailedRequestRetryTime);
     return;
   }
 
@@ -124,14 +154,14 @@ void TokenSubscriber::processResponse(
 
     if (status_code != enumToInt(Envoy::Http::Code::OK)) {
       ENVOY_LOG(error, "{}: failed: {}", debug_name_, status_code);
-      handleFailResponse();
+      handleFailResponse(Envoy::Http::CodeUtility::is5xx(status_code));
       return;
     }
   } catch (const EnvoyException& e) {
     // This occurs if the status header is missing.
     // Catch the exception to prevent unwinding and skipping cleanup.
     ENVOY_LOG(error, "{}: failed: {}", debug_name_, e.what());
-    handleFailResponse();
+    handleFailResponse(false);
     return;
   }
 
@@ -155,7 +185,7 @@ void TokenSubscriber::processResponse(
 
   // Determine status.
   if (!success) {
-    handleFailResponse();
+    handleFailResponse(false);
     return;
   }
 
@@ -182,7 +212,7 @@ void TokenSubscriber::onFailure(
       break;
   }
 
-  handleFailResponse();
+  handleFailResponse(true);
 }
 
 }  // namespace Token
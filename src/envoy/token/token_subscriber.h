@@ -49,7 +49,8 @@ class TokenSubscriber
   ~TokenSubscriber();
 
  private:
-  void handleFailResponse();
+  // Retries the fetch with a backoff if the failure is retryable.
+  void handleFailResponse(bool retryable);
   void handleSuccessResponse(absl::string_view token,
                              const std::chrono::seconds& expires_in);
   void processResponse(Envoy::Http::ResponseMessagePtr&& response);
@@ -68,6 +69,9 @@ class TokenSubscriber
 
   Envoy::Http::AsyncClient::Request* active_request_{};
 
+  // The number of consecutive failed fetches, to back off the retries.
+  uint32_t consecutive_failures_{};
+
   // This uses `Init::Manager` object. This is how `Init::Manager` works:
   //
   // * If your filter needs to make an async remote call, and needs to wait for
@@ -194,7 +194,7 @@ TEST_F(TokenSubscriberTest, ProcessNon200Response) {
   ASSERT_FALSE(init_ready_);
 }
 
-TEST_F(TokenSubscriberTest, ProcessMissingStatusResponse) {
+TEST_F(TokenSubscriberTest, ProcessNon5xxResponseNotRetried) {
   // Setup fake remote request.
   Envoy::Http::RequestHeaderMapPtr req_headers(
       new Envoy::Http::TestRequestHeaderMapImpl());
@@ -204,13 +204,71 @@ TEST_F(TokenSubscriberTest, ProcessMissingStatusResponse) {
           Return(ByMove(std::make_unique<Envoy::Http::RequestMessageImpl>(
               std::move(req_headers)))));
 
-  // Expect subscriber does not succeed.
+  // Expect subscriber does not succeed, and does not retry.
+  EXPECT_CALL(*mock_timer_, enableTimer(_, _)).Times(0);
+  EXPECT_CALL(token_callback_, Call(_)).Times(0);
+
+  // Start class under test.
+  setUp(TokenType::IdentityToken);
+
+  // Setup fake response, e.g. the IAM permission is missing.
+  Envoy::Http::ResponseHeaderMapPtr resp_headers(
+      new Envoy::Http::TestResponseHeaderMapImpl({
+          {":status", "403"},
+      }));
+  Envoy::Http::ResponseMessagePtr response(
+      new Envoy::Http::ResponseMessageImpl(std::move(resp_headers)));
+
+  // Start the response.
+  client_callback_->onSuccess(std::move(response));
+
+  // Assert subscriber did not succeed.
+  ASSERT_EQ(call_count_, 1);
+  ASSERT_FALSE(init_ready_);
+}
+
+TEST_F(TokenSubscriberTest, RetryNetworkFailure) {
+  // Setup fake remote request.
+  Envoy::Http::RequestHeaderMapPtr req_headers(
+      new Envoy::Http::TestRequestHeaderMapImpl());
+  EXPECT_CALL(*info_, prepareRequest(token_url_))
+      .Times(1)
+      .WillRepeatedly(
+          Return(ByMove(std::make_unique<Envoy::Http::RequestMessageImpl>(
+              std::move(req_headers)))));
+
+  // Expect subscriber does not succeed, and retries.
   EXPECT_CALL(*mock_timer_, enableTimer(kFailedExpect, nullptr)).Times(1);
   EXPECT_CALL(token_callback_, Call(_)).Times(0);
 
   // Start class under test.
   setUp(TokenType::IdentityToken);
 
+  // Fail the request.
+  client_callback_->onFailure(Envoy::Http::AsyncClient::FailureReason::Reset);
+
+  // Assert subscriber did not succeed.
+  ASSERT_EQ(call_count_, 1);
+  ASSERT_FALSE(init_ready_);
+}
+
+TEST_F(TokenSubscriberTest, ProcessMissingStatusResponse) {
+  // Setup fake remote request.
+  Envoy::Http::RequestHeaderMapPtr req_headers(
+      new Envoy::Http::TestRequestHeaderMapImpl());
+  EXPECT_CALL(*info_, prepareRequest(token_url_))
+      .Times(1)
+      .WillRepeatedly(
+          Return(ByMove(std::make_unique<Envoy::Http::RequestMessageImpl>(
+              std::move(req_headers)))));
+
+  // Expect subscriber does not succeed, and does not retry.
+  EXPECT_CALL(*mock_timer_, enableTimer(_, _)).Times(0);
+  EXPECT_CALL(token_callback_, Call(_)).Times(0);
+
+  // Start class under test.
+  setUp(TokenType::IdentityToken);
+
   // Setup fake response.
   Envoy::Http::ResponseHeaderMapPtr resp_headers(
       new Envoy::Http::TestResponseHeaderMapImpl());
@@ -238,8 +296,8 @@ TEST_F(TokenSubscriberTest, BadParseIdentityToken) {
   // Setup fake parse status.
   EXPECT_CALL(*info_, parseIdentityToken(_, _)).WillOnce(Return(false));
 
-  // Expect subscriber does not succeed.
-  EXPECT_CALL(*mock_timer_, enableTimer(kFailedExpect, nullptr)).Times(1);
+  // Expect subscriber does not succeed, and does not retry.
+  EXPECT_CALL(*mock_timer_, enableTimer(_, _)).Times(0);
   EXPECT_CALL(token_callback_, Call(_)).Times(0);
 
   // Start class under test.
@@ -274,8 +332,8 @@ TEST_F(TokenSubscriberTest, BadParseAccessToken) {
   // Setup fake parse status.
   EXPECT_CALL(*info_, parseAccessToken(_, _)).WillOnce(Return(false));
 
-  // Expect subscriber does not succeed.
-  EXPECT_CALL(*mock_timer_, enableTimer(kFailedExpect, nullptr)).Times(1);
+  // Expect subscriber does not succeed, and does not retry.
+  EXPECT_CALL(*mock_timer_, enableTimer(_, _)).Times(0);
   EXPECT_CALL(token_callback_, Call(_)).Times(0);
 
   // Start class under test.
@@ -340,6 +398,55 @@ TEST_F(TokenSubscriberTest, Success) {
   ASSERT_TRUE(init_ready_);
 }
 
+TEST_F(TokenSubscriberTest, RetryBackoffOnConsecutiveFailures) {
+  // Setup fake remote requests.
+  EXPECT_CALL(*info_, prepareRequest(token_url_))
+      .WillRepeatedly(Invoke([](absl::string_view) {
+        return std::make_unique<Envoy::Http::RequestMessageImpl>(
+            Envoy::Http::RequestHeaderMapPtr(
+                new Envoy::Http::TestRequestHeaderMapImpl()));
+      }));
+  EXPECT_CALL(*info_, parseAccessToken(_, _))
+      .WillOnce(Invoke([](absl::string_view, TokenResult* ret) {
+        ret->token = "fake-token";
+        ret->expiry_duration = std::chrono::seconds(30);
+        return true;
+      }));
+
+  // Expect the retries to back off after each consecutive failure, and to be
+  // reset by a success.
+  {
+    ::testing::InSequence s;
+    EXPECT_CALL(*mock_timer_, enableTimer(kFailedExpect, nullptr));
+    EXPECT_CALL(*mock_timer_,
+                enableTimer(std::chrono::milliseconds(4000), nullptr));
+    EXPECT_CALL(*mock_timer_,
+                enableTimer(std::chrono::milliseconds(25 * 1000), nullptr));
+    EXPECT_CALL(*mock_timer_, enableTimer(kFailedExpect, nullptr));
+  }
+
+  // Start class under test.
+  setUp(TokenType::AccessToken);
+
+  auto respond = [this](const std::string& status) {
+    Envoy::Http::ResponseHeaderMapPtr resp_headers(
+        new Envoy::Http::TestResponseHeaderMapImpl({{":status", status}}));
+    client_callback_->onSuccess(
+        std::make_unique<Envoy::Http::ResponseMessageImpl>(
+            std::move(resp_headers)));
+  };
+
+  respond("503");
+  timer_cb_();
+  respond("500");
+  timer_cb_();
+  respond("200");
+  ASSERT_TRUE(init_ready_);
+  timer_cb_();
+  respond("503");
+  ASSERT_EQ(call_count_, 4);
+}
+
 TEST_F(TokenSubscriberTest, RetryMissingPreconditionThenSuccess) {
   // Part 1: Failed due to missing precondition
 
@@ -16,6 +16,7 @@ package bootstrap
 
 import (
 	"testing"
+	"time"
 
 	"github.com/GoogleCloudPlatform/esp-v2/src/go/options"
 	"github.com/golang/protobuf/proto"
@@ -26,9 +27,10 @@ import (
 
 func TestCreateAdmin(t *testing.T) {
 	testData := []struct {
-		desc        string
-		enableAdmin bool
-		want        *bootstrappb.Admin
+		desc              string
+		enableAdmin       bool
+		shutdownDrainTime time.Duration
+		want              *bootstrappb.Admin
 	}{
 		{
 			desc:        "Admin interface is disabled",
@@ -52,12 +54,31 @@ func TestCreateAdmin(t *testing.T) {
 				},
 			},
 		},
+		{
+			desc:              "Admin interface is disabled, created on the loopback address to drain on shutdown",
+			enableAdmin:       false,
+			shutdownDrainTime: 30 * time.Second,
+			want: &bootstrappb.Admin{
+				AccessLogPath: "/dev/null",
+				Address: &corepb.Address{
+					Address: &corepb.Address_SocketAddress{
+						SocketAddress: &corepb.SocketAddress{
+							Address: "127.0.0.1",
+							PortSpecifier: &corepb.SocketAddress_PortValue{
+								PortValue: 8001,
+							},
+						},
+					},
+				},
+			},
+		},
 	}
 
 	for _, tc := range testData {
 
 		opts := options.DefaultCommonOptions()
 		opts.EnableAdmin = tc.enableAdmin
+		opts.ShutdownDrainTime = tc.shutdownDrainTime
 
 		got := CreateAdmin(opts)
 
@@ -40,6 +40,11 @@ func CreateBootstrapConfig(opts options.AdsBootstrapperOptions) (string, error)
 	// Parse ADS connect timeout
 	connectTimeoutProto := ptypes.DurationProto(opts.AdsConnectTimeout)
 
+	apiType := corepb.ApiConfigSource_GRPC
+	if opts.AdsDelta {
+		apiType = corepb.ApiConfigSource_DELTA_GRPC
+	}
+
 	bt := &bootstrappb.Bootstrap{
 		// Node info
 		Node: bt.CreateNode(opts.CommonOptions),
@@ -60,11 +65,11 @@ func CreateBootstrapConfig(opts options.AdsBootstrapperOptions) (string, error)
 				},
 			},
 			AdsConfig: &corepb.ApiConfigSource{
-				ApiType: corepb.ApiConfigSource_GRPC,
+				ApiType: apiType,
 				GrpcServices: []*corepb.GrpcService{{
 					TargetSpecifier: &corepb.GrpcService_EnvoyGrpc_{
 						EnvoyGrpc: &corepb.GrpcService_EnvoyGrpc{
-							ClusterName: "ads_cluster",
+							ClusterName: util.AdsClusterName,
 						},
 					},
 				}},
@@ -75,7 +80,7 @@ func CreateBootstrapConfig(opts options.AdsBootstrapperOptions) (string, error)
 		StaticResources: &bootstrappb.Bootstrap_StaticResources{
 			Clusters: []*v2pb.Cluster{
 				{
-					Name:           "ads_cluster",
+					Name:           util.AdsClusterName,
 					LbPolicy:       v2pb.Cluster_ROUND_ROBIN,
 					ConnectTimeout: connectTimeoutProto,
 					ClusterDiscoveryType: &v2pb.Cluster_Type{
@@ -94,6 +99,11 @@ func CreateBootstrapConfig(opts options.AdsBootstrapperOptions) (string, error)
 		}
 	}
 
+	if bt.OverloadManager, err = bootstrap.CreateOverloadManager(opts.CommonOptions); err != nil {
+		return "", fmt.Errorf("failed to create overload manager, error: %v", err)
+	}
+	bt.LayeredRuntime = bootstrap.CreateLayeredRuntime(opts.CommonOptions)
+
 	jsonStr, err := util.ProtoToJson(bt)
 	if err != nil {
 		return "", fmt.Errorf("failed to MarshalToString, error: %v", err)
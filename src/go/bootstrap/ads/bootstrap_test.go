@@ -182,6 +182,80 @@ func TestCreateBootstrapConfig(t *testing.T) {
             }
           }
         }
+      }`,
+		},
+		{
+			desc: "bootstrap with delta xDS",
+			args: map[string]string{
+				"disable_tracing": "true",
+				"enable_admin":    "true",
+				"node":            "test-node",
+				"ads_delta":       "true",
+			},
+			wantConfig: `{
+			  "node": {
+          "id": "test-node",
+          "cluster": "test-node_cluster"
+        },
+        "staticResources": {
+          "clusters": [
+            {
+              "name": "ads_cluster",
+              "type": "STRICT_DNS",
+              "connectTimeout": "10s",
+              "loadAssignment": {
+                "clusterName": "127.0.0.1",
+                "endpoints": [
+                  {
+                    "lbEndpoints": [
+                      {
+                        "endpoint": {
+                          "address": {
+                            "socketAddress": {
+                              "address": "127.0.0.1",
+                              "portValue": 8790
+                            }
+                          }
+                        }
+                      }
+                    ]
+                  }
+                ]
+              },
+              "http2ProtocolOptions": {
+              }
+            }
+          ]
+        },
+        "dynamicResources": {
+          "ldsConfig": {
+            "ads": {
+            }
+          },
+          "cdsConfig": {
+            "ads": {
+            }
+          },
+          "adsConfig": {
+            "apiType": "DELTA_GRPC",
+            "grpcServices": [
+              {
+                "envoyGrpc": {
+                  "clusterName": "ads_cluster"
+                }
+              }
+            ]
+          }
+        },
+        "admin": {
+          "accessLogPath": "/dev/null",
+          "address": {
+            "socketAddress": {
+              "address": "0.0.0.0",
+              "portValue": 8001
+            }
+          }
+        }
       }`,
 		},
 	}
@@ -26,6 +26,8 @@ import (
 
 var (
 	AdsConnectTimeout = flag.Duration("ads_connect_timeout", 10*time.Second, "ads connect timeout in seconds")
+	AdsDelta          = flag.Bool("ads_delta", false, `If true, Envoy uses the incremental (delta) xDS protocol on the ADS stream, so that
+			only the changed clusters and listeners are sent on config rollouts.`)
 )
 
 func DefaultBootstrapperOptionsFromFlags() options.AdsBootstrapperOptions {
@@ -33,6 +35,7 @@ func DefaultBootstrapperOptionsFromFlags() options.AdsBootstrapperOptions {
 	opts := options.AdsBootstrapperOptions{
 		CommonOptions:     common_option,
 		AdsConnectTimeout: *AdsConnectTimeout,
+		AdsDelta:          *AdsDelta,
 		DiscoveryAddress:  fmt.Sprintf("127.0.0.1:%d", common_option.DiscoveryPort),
 	}
 
@@ -20,11 +20,16 @@ import (
 
 	"github.com/GoogleCloudPlatform/esp-v2/src/go/bootstrap/ads"
 	"github.com/GoogleCloudPlatform/esp-v2/src/go/bootstrap/ads/flags"
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/commonflags"
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/util"
 	"github.com/golang/glog"
 )
 
 func main() {
 	flag.Parse()
+	if err := commonflags.ApplyEnvAndConfigFile(flag.CommandLine); err != nil {
+		glog.Exitf("%v", err)
+	}
 	outPath := flag.Arg(0)
 	glog.Infof("Output path: %s", outPath)
 	if outPath == "" {
@@ -32,6 +37,7 @@ func main() {
 	}
 
 	opts := flags.DefaultBootstrapperOptionsFromFlags()
+	util.SetProxy(opts.HttpProxy, opts.HttpsProxy, opts.NoProxy)
 	bootstrapStr, err := ads.CreateBootstrapConfig(opts)
 	if err != nil {
 		glog.Exitf("failed to create bootstrap config, error: %v", err)
@@ -0,0 +1,135 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bootstrap
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/options"
+	"github.com/golang/protobuf/ptypes"
+
+	bootstrappb "github.com/envoyproxy/go-control-plane/envoy/config/bootstrap/v2"
+	overloadpb "github.com/envoyproxy/go-control-plane/envoy/config/overload/v2alpha"
+	fixedheappb "github.com/envoyproxy/go-control-plane/envoy/config/resource_monitor/fixed_heap/v2alpha"
+	structpb "github.com/golang/protobuf/ptypes/struct"
+)
+
+const (
+	fixedHeapResourceMonitor            = "envoy.resource_monitors.fixed_heap"
+	shrinkHeapOverloadAction            = "envoy.overload_actions.shrink_heap"
+	stopAcceptingRequestsOverloadAction = "envoy.overload_actions.stop_accepting_requests"
+
+	overloadRefreshInterval = 250 * time.Millisecond
+)
+
+// downstreamListeners are the listeners limited by --max_downstream_connections.
+var downstreamListeners = []string{"http_listener", "https_listener"}
+
+// CreateOverloadManager outputs the overload manager, releasing the free
+// memory and rejecting new requests as the heap usage gets close to
+// OverloadMaxHeapSizeBytes. It returns nil if OverloadMaxHeapSizeBytes is 0.
+func CreateOverloadManager(opts options.CommonOptions) (*overloadpb.OverloadManager, error) {
+	if opts.OverloadMaxHeapSizeBytes == 0 {
+		return nil, nil
+	}
+	if err := checkThreshold("overload_shrink_heap_threshold", opts.OverloadShrinkHeapThreshold); err != nil {
+		return nil, err
+	}
+	if err := checkThreshold("overload_stop_accepting_requests_threshold", opts.OverloadStopAcceptingRequestsThreshold); err != nil {
+		return nil, err
+	}
+
+	fixedHeap, err := ptypes.MarshalAny(&fixedheappb.FixedHeapConfig{
+		MaxHeapSizeBytes: opts.OverloadMaxHeapSizeBytes,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &overloadpb.OverloadManager{
+		RefreshInterval: ptypes.DurationProto(overloadRefreshInterval),
+		ResourceMonitors: []*overloadpb.ResourceMonitor{
+			{
+				Name: fixedHeapResourceMonitor,
+				ConfigType: &overloadpb.ResourceMonitor_TypedConfig{
+					TypedConfig: fixedHeap,
+				},
+			},
+		},
+		Actions: []*overloadpb.OverloadAction{
+			makeHeapOverloadAction(shrinkHeapOverloadAction, opts.OverloadShrinkHeapThreshold),
+			makeHeapOverloadAction(stopAcceptingRequestsOverloadAction, opts.OverloadStopAcceptingRequestsThreshold),
+		},
+	}, nil
+}
+
+func checkThreshold(name string, threshold float64) error {
+	if threshold <= 0 || threshold > 1 {
+		return fmt.Errorf("invalid %s %v, it must be in (0.0, 1.0]", name, threshold)
+	}
+	return nil
+}
+
+func makeHeapOverloadAction(name string, threshold float64) *overloadpb.OverloadAction {
+	return &overloadpb.OverloadAction{
+		Name: name,
+		Triggers: []*overloadpb.Trigger{
+			{
+				Name: fixedHeapResourceMonitor,
+				TriggerOneof: &overloadpb.Trigger_Threshold{
+					Threshold: &overloadpb.ThresholdTrigger{
+						Value: threshold,
+					},
+				},
+			},
+		},
+	}
+}
+
+// CreateLayeredRuntime outputs the runtime limiting the connections of the
+// downstream listeners to MaxDownstreamConnections, followed by the admin
+// layer Envoy has by default. It returns nil if MaxDownstreamConnections is 0.
+func CreateLayeredRuntime(opts options.CommonOptions) *bootstrappb.LayeredRuntime {
+	if opts.MaxDownstreamConnections == 0 {
+		return nil
+	}
+
+	fields := make(map[string]*structpb.Value)
+	for _, listener := range downstreamListeners {
+		fields[fmt.Sprintf("envoy.resource_limits.listener.%s.connection_limit", listener)] = &structpb.Value{
+			Kind: &structpb.Value_NumberValue{
+				NumberValue: float64(opts.MaxDownstreamConnections),
+			},
+		}
+	}
+	return &bootstrappb.LayeredRuntime{
+		Layers: []*bootstrappb.RuntimeLayer{
+			{
+				Name: "static_layer",
+				LayerSpecifier: &bootstrappb.RuntimeLayer_StaticLayer{
+					StaticLayer: &structpb.Struct{
+						Fields: fields,
+					},
+				},
+			},
+			{
+				Name: "admin_layer",
+				LayerSpecifier: &bootstrappb.RuntimeLayer_AdminLayer_{
+					AdminLayer: &bootstrappb.RuntimeLayer_AdminLayer{},
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,185 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bootstrap
+
+import (
+	"testing"
+
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/options"
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+
+	bootstrappb "github.com/envoyproxy/go-control-plane/envoy/config/bootstrap/v2"
+	overloadpb "github.com/envoyproxy/go-control-plane/envoy/config/overload/v2alpha"
+	fixedheappb "github.com/envoyproxy/go-control-plane/envoy/config/resource_monitor/fixed_heap/v2alpha"
+	structpb "github.com/golang/protobuf/ptypes/struct"
+)
+
+func TestCreateOverloadManager(t *testing.T) {
+	fixedHeap, _ := ptypes.MarshalAny(&fixedheappb.FixedHeapConfig{
+		MaxHeapSizeBytes: 1073741824,
+	})
+
+	testData := []struct {
+		desc                  string
+		maxHeapSizeBytes      uint64
+		shrinkHeapThreshold   float64
+		stopAcceptingRequests float64
+		want                  *overloadpb.OverloadManager
+		wantError             string
+	}{
+		{
+			desc:                  "Overload manager is disabled",
+			maxHeapSizeBytes:      0,
+			shrinkHeapThreshold:   0.95,
+			stopAcceptingRequests: 0.98,
+		},
+		{
+			desc:                  "Overload manager is enabled with the heap thresholds",
+			maxHeapSizeBytes:      1073741824,
+			shrinkHeapThreshold:   0.9,
+			stopAcceptingRequests: 0.95,
+			want: &overloadpb.OverloadManager{
+				RefreshInterval: ptypes.DurationProto(overloadRefreshInterval),
+				ResourceMonitors: []*overloadpb.ResourceMonitor{
+					{
+						Name: "envoy.resource_monitors.fixed_heap",
+						ConfigType: &overloadpb.ResourceMonitor_TypedConfig{
+							TypedConfig: fixedHeap,
+						},
+					},
+				},
+				Actions: []*overloadpb.OverloadAction{
+					{
+						Name: "envoy.overload_actions.shrink_heap",
+						Triggers: []*overloadpb.Trigger{
+							{
+								Name: "envoy.resource_monitors.fixed_heap",
+								TriggerOneof: &overloadpb.Trigger_Threshold{
+									Threshold: &overloadpb.ThresholdTrigger{
+										Value: 0.9,
+									},
+								},
+							},
+						},
+					},
+					{
+						Name: "envoy.overload_actions.stop_accepting_requests",
+						Triggers: []*overloadpb.Trigger{
+							{
+								Name: "envoy.resource_monitors.fixed_heap",
+								TriggerOneof: &overloadpb.Trigger_Threshold{
+									Threshold: &overloadpb.ThresholdTrigger{
+										Value: 0.95,
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			desc:                  "Overload manager fails with a threshold above 1",
+			maxHeapSizeBytes:      1073741824,
+			shrinkHeapThreshold:   0.95,
+			stopAcceptingRequests: 1.5,
+			wantError:             "invalid overload_stop_accepting_requests_threshold 1.5, it must be in (0.0, 1.0]",
+		},
+		{
+			desc:                  "Overload manager fails with a threshold of 0",
+			maxHeapSizeBytes:      1073741824,
+			shrinkHeapThreshold:   0,
+			stopAcceptingRequests: 0.98,
+			wantError:             "invalid overload_shrink_heap_threshold 0, it must be in (0.0, 1.0]",
+		},
+	}
+
+	for _, tc := range testData {
+		opts := options.DefaultCommonOptions()
+		opts.OverloadMaxHeapSizeBytes = tc.maxHeapSizeBytes
+		opts.OverloadShrinkHeapThreshold = tc.shrinkHeapThreshold
+		opts.OverloadStopAcceptingRequestsThreshold = tc.stopAcceptingRequests
+
+		got, err := CreateOverloadManager(opts)
+		if tc.wantError != "" {
+			if err == nil || err.Error() != tc.wantError {
+				t.Errorf("Test (%s): failed, got error: %v, want error: %s", tc.desc, err, tc.wantError)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Test (%s): failed, got error: %v", tc.desc, err)
+			continue
+		}
+
+		if !proto.Equal(got, tc.want) {
+			t.Errorf("Test (%s): failed, got: %v, want: %v", tc.desc, got, tc.want)
+		}
+	}
+}
+
+func TestCreateLayeredRuntime(t *testing.T) {
+	testData := []struct {
+		desc                     string
+		maxDownstreamConnections uint64
+		want                     *bootstrappb.LayeredRuntime
+	}{
+		{
+			desc:                     "Connections are unlimited",
+			maxDownstreamConnections: 0,
+		},
+		{
+			desc:                     "Connections of the downstream listeners are limited",
+			maxDownstreamConnections: 10000,
+			want: &bootstrappb.LayeredRuntime{
+				Layers: []*bootstrappb.RuntimeLayer{
+					{
+						Name: "static_layer",
+						LayerSpecifier: &bootstrappb.RuntimeLayer_StaticLayer{
+							StaticLayer: &structpb.Struct{
+								Fields: map[string]*structpb.Value{
+									"envoy.resource_limits.listener.http_listener.connection_limit": {
+										Kind: &structpb.Value_NumberValue{NumberValue: 10000},
+									},
+									"envoy.resource_limits.listener.https_listener.connection_limit": {
+										Kind: &structpb.Value_NumberValue{NumberValue: 10000},
+									},
+								},
+							},
+						},
+					},
+					{
+						Name: "admin_layer",
+						LayerSpecifier: &bootstrappb.RuntimeLayer_AdminLayer_{
+							AdminLayer: &bootstrappb.RuntimeLayer_AdminLayer{},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	for _, tc := range testData {
+		opts := options.DefaultCommonOptions()
+		opts.MaxDownstreamConnections = tc.maxDownstreamConnections
+
+		got := CreateLayeredRuntime(opts)
+
+		if !proto.Equal(got, tc.want) {
+			t.Errorf("Test (%s): failed, got: %v, want: %v", tc.desc, got, tc.want)
+		}
+	}
+}
@@ -19,6 +19,7 @@ import (
 
 	"github.com/GoogleCloudPlatform/esp-v2/src/go/bootstrap"
 	"github.com/GoogleCloudPlatform/esp-v2/src/go/options"
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/util"
 
 	gen "github.com/GoogleCloudPlatform/esp-v2/src/go/configgenerator"
 	sc "github.com/GoogleCloudPlatform/esp-v2/src/go/configinfo"
@@ -39,6 +40,20 @@ func ServiceToBootstrapConfig(serviceConfig *confpb.Service, id string, opts opt
 	if err != nil {
 		return nil, fmt.Errorf("fail to initialize ServiceInfo, %s", err)
 	}
+	// The token agent for external account credentials only runs in the
+	// config manager, there is nothing serving it with a static bootstrap.
+	if serviceInfo.AccessToken.GetRemoteToken().GetCluster() == util.TokenAgentClusterName {
+		return nil, fmt.Errorf("external account credentials in --service_account_key are only supported with dynamic configuration from the config manager")
+	}
+	// The gRPC health checks are served by the config manager.
+	if opts.HealthGrpcService != "" {
+		return nil, fmt.Errorf("--health_grpc_service is only supported with dynamic configuration from the config manager")
+	}
+	// The OAuth2 access tokens of the backends are served by the token agent
+	// in the config manager.
+	if len(opts.BackendOAuth2) > 0 {
+		return nil, fmt.Errorf("--backend_oauth2_config is only supported with dynamic configuration from the config manager")
+	}
 
 	clusters, err := gen.MakeClusters(serviceInfo)
 	if err != nil {
@@ -55,6 +70,11 @@ func ServiceToBootstrapConfig(serviceConfig *confpb.Service, id string, opts opt
 		}
 	}
 
+	if bt.OverloadManager, err = bootstrap.CreateOverloadManager(opts.CommonOptions); err != nil {
+		return nil, fmt.Errorf("failed to create overload manager, error: %v", err)
+	}
+	bt.LayeredRuntime = bootstrap.CreateLayeredRuntime(opts.CommonOptions)
+
 	bt.StaticResources = &bootstrappb.Bootstrap_StaticResources{
 		Listeners: listeners,
 		Clusters:  clusters,
@@ -18,6 +18,7 @@ import (
 	"bytes"
 	"encoding/json"
 	"io/ioutil"
+	"os"
 	"testing"
 
 	"github.com/GoogleCloudPlatform/esp-v2/src/go/configmanager/flags"
@@ -28,6 +29,7 @@ import (
 
 	bootstrappb "github.com/envoyproxy/go-control-plane/envoy/config/bootstrap/v2"
 	confpb "google.golang.org/genproto/googleapis/api/serviceconfig"
+	apipb "google.golang.org/genproto/protobuf/api"
 )
 
 var (
@@ -148,6 +150,35 @@ func TestServiceToBootstrapConfig(t *testing.T) {
 	}
 }
 
+func TestServiceToBootstrapConfigWithExternalAccount(t *testing.T) {
+	keyFile, err := ioutil.TempFile("", "external_account")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(keyFile.Name())
+	keyFile.WriteString(`{"type": "external_account", "audience": "//iam.googleapis.com/projects/123/locations/global/workloadIdentityPools/pool/providers/provider"}`)
+	keyFile.Close()
+
+	opts := flags.EnvoyConfigOptionsFromFlags()
+	opts.BackendAddress = "http://127.0.0.1:8082"
+	opts.DisableTracing = true
+	opts.ServiceAccountKey = keyFile.Name()
+
+	s := &confpb.Service{
+		Name: "bookstore.endpoints.project123.cloud.goog",
+		Apis: []*apipb.Api{
+			{
+				Name: "endpoints.examples.bookstore.Bookstore",
+			},
+		},
+	}
+	_, err = ServiceToBootstrapConfig(s, FakeConfigID, opts)
+	wantError := "external account credentials in --service_account_key are only supported with dynamic configuration from the config manager"
+	if err == nil || err.Error() != wantError {
+		t.Errorf("got error %v, want %v", err, wantError)
+	}
+}
+
 func bootstrapToJson(protoMsg *bootstrappb.Bootstrap) (string, error) {
 	// Marshal both protos back to json-strings to pretty print them
 	marshaler := &jsonpb.Marshaler{
@@ -27,6 +27,10 @@ import (
 	tracepb "github.com/envoyproxy/go-control-plane/envoy/config/trace/v2"
 )
 
+// allTraceContexts are the trace contexts accepted with a single
+// --tracing_context_format.
+const allTraceContexts = "traceparent,grpc-trace-bin,x-cloud-trace-context,b3"
+
 func createTraceContexts(ctx_str string) ([]tracepb.OpenCensusConfig_TraceContext, error) {
 	var out []tracepb.OpenCensusConfig_TraceContext
 
@@ -35,15 +39,17 @@ func createTraceContexts(ctx_str string) ([]tracepb.OpenCensusConfig_TraceContex
 	}
 
 	for _, ctx := range strings.Split(ctx_str, ",") {
-		switch ctx {
+		switch strings.TrimSpace(ctx) {
 		case "traceparent":
 			out = append(out, tracepb.OpenCensusConfig_TRACE_CONTEXT)
 		case "grpc-trace-bin":
 			out = append(out, tracepb.OpenCensusConfig_GRPC_TRACE_BIN)
 		case "x-cloud-trace-context":
 			out = append(out, tracepb.OpenCensusConfig_CLOUD_TRACE_CONTEXT)
+		case "b3":
+			out = append(out, tracepb.OpenCensusConfig_B3)
 		default:
-			return out, fmt.Errorf("Invalid trace context: %v. It must be one of (traceparent|grpc-trace-bin|x-cloud-trace-context)", ctx)
+			return out, fmt.Errorf("Invalid trace context: %v. It must be one of (traceparent|grpc-trace-bin|x-cloud-trace-context|b3)", ctx)
 		}
 	}
 
@@ -70,11 +76,6 @@ func getTracingProjectId(opts options.CommonOptions) (string, error) {
 // CreateTracing outputs envoy tracing config
 func CreateTracing(opts options.CommonOptions) (*tracepb.Tracing, error) {
 
-	projectId, err := getTracingProjectId(opts)
-	if err != nil {
-		return nil, err
-	}
-
 	cfg := &tracepb.OpenCensusConfig{
 		TraceConfig: &opencensuspb.TraceConfig{
 			MaxNumberOfAttributes:    opts.TracingMaxNumAttributes,
@@ -82,12 +83,33 @@ func CreateTracing(opts options.CommonOptions) (*tracepb.Tracing, error) {
 			MaxNumberOfMessageEvents: opts.TracingMaxNumMessageEvents,
 			MaxNumberOfLinks:         opts.TracingMaxNumLinks,
 		},
-		StackdriverExporterEnabled: true,
-		StackdriverProjectId:       projectId,
 	}
 
-	if opts.TracingStackdriverAddress != "" {
-		cfg.StackdriverAddress = opts.TracingStackdriverAddress
+	switch opts.TracingExporter {
+	case "", "stackdriver":
+		projectId, err := getTracingProjectId(opts)
+		if err != nil {
+			return nil, err
+		}
+		cfg.StackdriverExporterEnabled = true
+		cfg.StackdriverProjectId = projectId
+		if opts.TracingStackdriverAddress != "" {
+			cfg.StackdriverAddress = opts.TracingStackdriverAddress
+		}
+	case "ocagent":
+		if opts.TracingOcagentAddress == "" {
+			return nil, fmt.Errorf("tracing_ocagent_address is required for the ocagent tracing exporter")
+		}
+		cfg.OcagentExporterEnabled = true
+		cfg.OcagentAddress = opts.TracingOcagentAddress
+	case "zipkin":
+		if opts.TracingZipkinUrl == "" {
+			return nil, fmt.Errorf("tracing_zipkin_url is required for the zipkin tracing exporter")
+		}
+		cfg.ZipkinExporterEnabled = true
+		cfg.ZipkinUrl = opts.TracingZipkinUrl
+	default:
+		return nil, fmt.Errorf("Invalid tracing exporter: %v. It must be one of (stackdriver|ocagent|zipkin)", opts.TracingExporter)
 	}
 
 	if ctx, err := createTraceContexts(opts.TracingIncomingContext); err == nil {
@@ -102,7 +124,35 @@ func CreateTracing(opts options.CommonOptions) (*tracepb.Tracing, error) {
 		return nil, err
 	}
 
-	if opts.TracingSamplingRate == 1.0 {
+	// A single context format accepts all the incoming trace contexts, and only
+	// sends this one to the backends.
+	if opts.TracingContextFormat != "" {
+		if opts.TracingIncomingContext != "" || opts.TracingOutgoingContext != "" {
+			return nil, fmt.Errorf("tracing_context_format cannot be used with tracing_incoming_context or tracing_outgoing_context")
+		}
+		if strings.Contains(opts.TracingContextFormat, ",") {
+			return nil, fmt.Errorf("Invalid tracing context format: %v. It must be a single trace context", opts.TracingContextFormat)
+		}
+		ctx, err := createTraceContexts(opts.TracingContextFormat)
+		if err != nil {
+			return nil, err
+		}
+		cfg.IncomingTraceContext, _ = createTraceContexts(allTraceContexts)
+		cfg.OutgoingTraceContext = ctx
+	}
+
+	if opts.TracingSampler != "" && opts.TracingSampler != "probability" && opts.TracingSampler != "parent" {
+		return nil, fmt.Errorf("Invalid tracing sampler: %v. It must be one of (probability|parent)", opts.TracingSampler)
+	}
+
+	// The parent sampler only traces the requests sampled by the caller.
+	if opts.TracingSampler == "parent" {
+		cfg.TraceConfig.Sampler = &opencensuspb.TraceConfig_ConstantSampler{
+			ConstantSampler: &opencensuspb.ConstantSampler{
+				Decision: opencensuspb.ConstantSampler_ALWAYS_PARENT,
+			},
+		}
+	} else if opts.TracingSamplingRate == 1.0 {
 		cfg.TraceConfig.Sampler = &opencensuspb.TraceConfig_ConstantSampler{
 			ConstantSampler: &opencensuspb.ConstantSampler{
 				Decision: opencensuspb.ConstantSampler_ALWAYS_ON,
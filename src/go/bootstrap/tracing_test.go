@@ -47,11 +47,16 @@ func TestNonGCPTracingConfig(t *testing.T) {
 		tracingSampleRate          float64
 		tracingIncomingContext     string
 		tracingOutgoingContext     string
+		tracingContextFormat       string
 		tracingStackdriverAddress  string
 		tracingMaxNumAttributes    int64
 		tracingMaxNumAnnotations   int64
 		tracingMaxNumMessageEvents int64
 		tracingMaxNumLinks         int64
+		tracingSampler             string
+		tracingExporter            string
+		tracingOcagentAddress      string
+		tracingZipkinUrl           string
 		wantError                  string
 		wantResult                 *tracepb.OpenCensusConfig
 	}{
@@ -124,6 +129,53 @@ func TestNonGCPTracingConfig(t *testing.T) {
 				},
 			},
 		},
+		{
+			desc:                       "Success with a single context format",
+			tracingProjectId:           fakeOptsProjectId,
+			tracingSampleRate:          defaultOpts.TracingSamplingRate,
+			tracingContextFormat:       "b3",
+			tracingMaxNumAttributes:    defaultOpts.TracingMaxNumAttributes,
+			tracingMaxNumAnnotations:   defaultOpts.TracingMaxNumAnnotations,
+			tracingMaxNumMessageEvents: defaultOpts.TracingMaxNumMessageEvents,
+			tracingMaxNumLinks:         defaultOpts.TracingMaxNumLinks,
+			wantResult: &tracepb.OpenCensusConfig{
+				TraceConfig: &opencensuspb.TraceConfig{
+					MaxNumberOfAttributes:    defaultOpts.TracingMaxNumAttributes,
+					MaxNumberOfAnnotations:   defaultOpts.TracingMaxNumAnnotations,
+					MaxNumberOfMessageEvents: defaultOpts.TracingMaxNumMessageEvents,
+					MaxNumberOfLinks:         defaultOpts.TracingMaxNumLinks,
+					Sampler: &opencensuspb.TraceConfig_ProbabilitySampler{
+						ProbabilitySampler: &opencensuspb.ProbabilitySampler{
+							SamplingProbability: defaultOpts.TracingSamplingRate,
+						},
+					},
+				},
+				StackdriverExporterEnabled: true,
+				StackdriverProjectId:       fakeOptsProjectId,
+				IncomingTraceContext: []tracepb.OpenCensusConfig_TraceContext{
+					tracepb.OpenCensusConfig_TRACE_CONTEXT,
+					tracepb.OpenCensusConfig_GRPC_TRACE_BIN,
+					tracepb.OpenCensusConfig_CLOUD_TRACE_CONTEXT,
+					tracepb.OpenCensusConfig_B3,
+				},
+				OutgoingTraceContext: []tracepb.OpenCensusConfig_TraceContext{
+					tracepb.OpenCensusConfig_B3,
+				},
+			},
+		},
+		{
+			desc:                   "Failed with a context format and incoming contexts",
+			tracingProjectId:       fakeOptsProjectId,
+			tracingIncomingContext: "traceparent",
+			tracingContextFormat:   "b3",
+			wantError:              "tracing_context_format cannot be used with tracing_incoming_context or tracing_outgoing_context",
+		},
+		{
+			desc:                 "Failed with several context formats",
+			tracingProjectId:     fakeOptsProjectId,
+			tracingContextFormat: "b3,traceparent",
+			wantError:            "Invalid tracing context format: b3,traceparent",
+		},
 		{
 			desc:              "Failed with invalid sampling rate",
 			tracingProjectId:  fakeOptsProjectId,
@@ -254,6 +306,102 @@ func TestNonGCPTracingConfig(t *testing.T) {
 				StackdriverAddress:         fakeStackdriverAddress,
 			},
 		},
+		{
+			desc:                       "Success with ocagent exporter, no project id needed",
+			tracingSampleRate:          defaultOpts.TracingSamplingRate,
+			tracingMaxNumAttributes:    defaultOpts.TracingMaxNumAttributes,
+			tracingMaxNumAnnotations:   defaultOpts.TracingMaxNumAnnotations,
+			tracingMaxNumMessageEvents: defaultOpts.TracingMaxNumMessageEvents,
+			tracingMaxNumLinks:         defaultOpts.TracingMaxNumLinks,
+			tracingExporter:            "ocagent",
+			tracingOcagentAddress:      "dns:otel-collector:55678",
+			wantResult: &tracepb.OpenCensusConfig{
+				TraceConfig: &opencensuspb.TraceConfig{
+					MaxNumberOfAttributes:    defaultOpts.TracingMaxNumAttributes,
+					MaxNumberOfAnnotations:   defaultOpts.TracingMaxNumAnnotations,
+					MaxNumberOfMessageEvents: defaultOpts.TracingMaxNumMessageEvents,
+					MaxNumberOfLinks:         defaultOpts.TracingMaxNumLinks,
+					Sampler: &opencensuspb.TraceConfig_ProbabilitySampler{
+						ProbabilitySampler: &opencensuspb.ProbabilitySampler{
+							SamplingProbability: defaultOpts.TracingSamplingRate,
+						},
+					},
+				},
+				OcagentExporterEnabled: true,
+				OcagentAddress:         "dns:otel-collector:55678",
+			},
+		},
+		{
+			desc:                       "Success with zipkin exporter",
+			tracingSampleRate:          1.0,
+			tracingMaxNumAttributes:    defaultOpts.TracingMaxNumAttributes,
+			tracingMaxNumAnnotations:   defaultOpts.TracingMaxNumAnnotations,
+			tracingMaxNumMessageEvents: defaultOpts.TracingMaxNumMessageEvents,
+			tracingMaxNumLinks:         defaultOpts.TracingMaxNumLinks,
+			tracingExporter:            "zipkin",
+			tracingZipkinUrl:           "http://otel-collector:9411/api/v2/spans",
+			wantResult: &tracepb.OpenCensusConfig{
+				TraceConfig: &opencensuspb.TraceConfig{
+					MaxNumberOfAttributes:    defaultOpts.TracingMaxNumAttributes,
+					MaxNumberOfAnnotations:   defaultOpts.TracingMaxNumAnnotations,
+					MaxNumberOfMessageEvents: defaultOpts.TracingMaxNumMessageEvents,
+					MaxNumberOfLinks:         defaultOpts.TracingMaxNumLinks,
+					Sampler: &opencensuspb.TraceConfig_ConstantSampler{
+						ConstantSampler: &opencensuspb.ConstantSampler{
+							Decision: opencensuspb.ConstantSampler_ALWAYS_ON,
+						},
+					},
+				},
+				ZipkinExporterEnabled: true,
+				ZipkinUrl:             "http://otel-collector:9411/api/v2/spans",
+			},
+		},
+		{
+			desc:                       "Success with parent sampler",
+			tracingProjectId:           fakeOptsProjectId,
+			tracingSampleRate:          0.27,
+			tracingSampler:             "parent",
+			tracingMaxNumAttributes:    defaultOpts.TracingMaxNumAttributes,
+			tracingMaxNumAnnotations:   defaultOpts.TracingMaxNumAnnotations,
+			tracingMaxNumMessageEvents: defaultOpts.TracingMaxNumMessageEvents,
+			tracingMaxNumLinks:         defaultOpts.TracingMaxNumLinks,
+			wantResult: &tracepb.OpenCensusConfig{
+				TraceConfig: &opencensuspb.TraceConfig{
+					MaxNumberOfAttributes:    defaultOpts.TracingMaxNumAttributes,
+					MaxNumberOfAnnotations:   defaultOpts.TracingMaxNumAnnotations,
+					MaxNumberOfMessageEvents: defaultOpts.TracingMaxNumMessageEvents,
+					MaxNumberOfLinks:         defaultOpts.TracingMaxNumLinks,
+					Sampler: &opencensuspb.TraceConfig_ConstantSampler{
+						ConstantSampler: &opencensuspb.ConstantSampler{
+							Decision: opencensuspb.ConstantSampler_ALWAYS_PARENT,
+						},
+					},
+				},
+				StackdriverExporterEnabled: true,
+				StackdriverProjectId:       fakeOptsProjectId,
+			},
+		},
+		{
+			desc:             "Failed with invalid sampler",
+			tracingProjectId: fakeOptsProjectId,
+			tracingSampler:   "rate_limited",
+			wantError:        "Invalid tracing sampler: rate_limited",
+		},
+		{
+			desc:            "Failed with ocagent exporter missing its address",
+			tracingExporter: "ocagent",
+			wantError:       "tracing_ocagent_address is required",
+		},
+		{
+			desc:            "Failed with zipkin exporter missing its url",
+			tracingExporter: "zipkin",
+			wantError:       "tracing_zipkin_url is required",
+		},
+		{
+			desc:            "Failed with invalid exporter",
+			tracingExporter: "otlp",
+			wantError:       "Invalid tracing exporter: otlp",
+		},
 	}
 
 	for _, tc := range testData {
@@ -264,11 +412,16 @@ func TestNonGCPTracingConfig(t *testing.T) {
 		opts.TracingSamplingRate = tc.tracingSampleRate
 		opts.TracingIncomingContext = tc.tracingIncomingContext
 		opts.TracingOutgoingContext = tc.tracingOutgoingContext
+		opts.TracingContextFormat = tc.tracingContextFormat
 		opts.TracingStackdriverAddress = tc.tracingStackdriverAddress
 		opts.TracingMaxNumAttributes = tc.tracingMaxNumAttributes
 		opts.TracingMaxNumAnnotations = tc.tracingMaxNumAnnotations
 		opts.TracingMaxNumMessageEvents = tc.tracingMaxNumMessageEvents
 		opts.TracingMaxNumLinks = tc.tracingMaxNumLinks
+		opts.TracingSampler = tc.tracingSampler
+		opts.TracingExporter = tc.tracingExporter
+		opts.TracingOcagentAddress = tc.tracingOcagentAddress
+		opts.TracingZipkinUrl = tc.tracingZipkinUrl
 
 		got, err := CreateTracing(opts)
 
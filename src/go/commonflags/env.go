@@ -0,0 +1,164 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commonflags
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/golang/glog"
+)
+
+// FlagEnvPrefix is the prefix of the environment variables of the flags, like
+// ESPV2_BACKEND_ADDRESS for --backend_address.
+const FlagEnvPrefix = "ESPV2_"
+
+// ConfigFile is the YAML file of the flags not set on the command line nor by
+// their environment variables.
+var ConfigFile = flag.String("config_file", "", `Path to a YAML file of flag names and values, like "backend_address: http://127.0.0.1:8082",
+	used for the flags not set on the command line nor by their environment variables. The flags of the other ESPv2 binaries are ignored. Each flag can be set by the environment variable
+	ESPV2_{FLAG_NAME}, like ESPV2_BACKEND_ADDRESS for --backend_address. The precedence order is flag > environment variable > config file > default.`)
+
+// FlagEnvName returns the environment variable of a flag.
+func FlagEnvName(name string) string {
+	return FlagEnvPrefix + strings.ToUpper(strings.Replace(name, "-", "_", -1))
+}
+
+// ApplyEnvAndConfigFile sets the flags of the parsed fs which are not set on
+// the command line to their environment variable, else to their value in the
+// file of the config_file flag, if any. The other flags in the file are
+// ignored.
+func ApplyEnvAndConfigFile(fs *flag.FlagSet) error {
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+
+	var errs []string
+	fs.VisitAll(func(f *flag.Flag) {
+		if set[f.Name] {
+			return
+		}
+		env := FlagEnvName(f.Name)
+		value, ok := os.LookupEnv(env)
+		if !ok {
+			return
+		}
+		if err := fs.Set(f.Name, value); err != nil {
+			errs = append(errs, fmt.Sprintf("invalid value %q of %s for --%s: %v", value, env, f.Name, err))
+		}
+		set[f.Name] = true
+	})
+	if len(errs) > 0 {
+		return fmt.Errorf("fail to set flags from environment variables: %s", strings.Join(errs, "; "))
+	}
+
+	configFile := fs.Lookup("config_file")
+	if configFile == nil || configFile.Value.String() == "" {
+		return nil
+	}
+	values, err := readFlagConfigFile(configFile.Value.String())
+	if err != nil {
+		return err
+	}
+	var names []string
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if fs.Lookup(name) == nil {
+			// The config file is shared by the config manager and the
+			// bootstrapper, which have different flags.
+			glog.Infof("flag %q in --config_file is ignored, it is not a flag of this binary", name)
+			continue
+		}
+		if set[name] {
+			continue
+		}
+		if err := fs.Set(name, values[name]); err != nil {
+			return fmt.Errorf("fail to set flags from --config_file: invalid value %q for --%s: %v", values[name], name, err)
+		}
+	}
+	return nil
+}
+
+// readFlagConfigFile reads the flags in a YAML file, which must be a mapping
+// of flag names to scalar values.
+func readFlagConfigFile(path string) (map[string]string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("fail to read --config_file: %v", err)
+	}
+	values := make(map[string]string)
+	for i, line := range strings.Split(string(data), "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || trimmed == "---" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		if trimmed != strings.TrimRight(line, " \t\r") {
+			return nil, fmt.Errorf("fail to parse --config_file at line %d: nested values are not supported", i+1)
+		}
+		kv := strings.SplitN(trimmed, ":", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("fail to parse --config_file at line %d: it must be like \"name: value\"", i+1)
+		}
+		name := strings.TrimSpace(kv[0])
+		value, err := parseYamlScalar(strings.TrimSpace(kv[1]))
+		if err != nil {
+			return nil, fmt.Errorf("fail to parse --config_file at line %d: %v", i+1, err)
+		}
+		if _, ok := values[name]; ok {
+			return nil, fmt.Errorf("fail to parse --config_file at line %d: duplicate flag %q", i+1, name)
+		}
+		values[name] = value
+	}
+	return values, nil
+}
+
+// parseYamlScalar returns the value of a plain, single-quoted or double-quoted
+// YAML scalar, without its comment.
+func parseYamlScalar(s string) (string, error) {
+	switch {
+	case strings.HasPrefix(s, `"`):
+		end := strings.LastIndex(s, `"`)
+		if end == 0 || !isYamlComment(s[end+1:]) {
+			return "", fmt.Errorf("unterminated double-quoted value %s", s)
+		}
+		return strconv.Unquote(s[:end+1])
+	case strings.HasPrefix(s, "'"):
+		end := strings.LastIndex(s, "'")
+		if end == 0 || !isYamlComment(s[end+1:]) {
+			return "", fmt.Errorf("unterminated single-quoted value %s", s)
+		}
+		return strings.Replace(s[1:end], "''", "'", -1), nil
+	case strings.HasPrefix(s, "[") || strings.HasPrefix(s, "{") || strings.HasPrefix(s, "|") || strings.HasPrefix(s, ">"):
+		return "", fmt.Errorf("only scalar values are supported, use a comma separated string for lists")
+	}
+	if i := strings.Index(s, " #"); i >= 0 {
+		s = strings.TrimSpace(s[:i])
+	}
+	return s, nil
+}
+
+func isYamlComment(s string) bool {
+	s = strings.TrimSpace(s)
+	return s == "" || strings.HasPrefix(s, "#")
+}
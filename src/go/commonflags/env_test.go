@@ -0,0 +1,153 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commonflags
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestApplyEnvAndConfigFile(t *testing.T) {
+	testData := []struct {
+		desc       string
+		args       []string
+		env        map[string]string
+		configFile string
+		wantFlags  map[string]string
+		wantError  string
+	}{
+		{
+			desc: "Defaults without environment variables nor config file",
+			wantFlags: map[string]string{
+				"backend_address": "http://127.0.0.1:8082",
+				"listener_port":   "8080",
+				"enable_admin":    "false",
+			},
+		},
+		{
+			desc: "Flag over environment variable over config file",
+			args: []string{"--backend_address=http://flag:8082"},
+			env: map[string]string{
+				"ESPV2_BACKEND_ADDRESS": "http://env:8082",
+				"ESPV2_LISTENER_PORT":   "9000",
+			},
+			configFile: `# ESPv2 flags
+---
+backend_address: http://file:8082
+listener_port: 9090
+enable_admin: true # for debugging
+`,
+			wantFlags: map[string]string{
+				"backend_address": "http://flag:8082",
+				"listener_port":   "9000",
+				"enable_admin":    "true",
+			},
+		},
+		{
+			desc: "Quoted values in config file",
+			configFile: `backend_address: "http://file:8082/a"
+cors_allow_origin: 'it''s #1'
+`,
+			wantFlags: map[string]string{
+				"backend_address":   "http://file:8082/a",
+				"cors_allow_origin": "it's #1",
+			},
+		},
+		{
+			desc: "Fail with invalid environment variable",
+			env: map[string]string{
+				"ESPV2_LISTENER_PORT": "port",
+			},
+			wantError: `invalid value "port" of ESPV2_LISTENER_PORT for --listener_port`,
+		},
+		{
+			desc: "Ignore the flags of the other binaries in config file",
+			configFile: `ads_delta: true
+listener_port: 9090`,
+			wantFlags: map[string]string{
+				"listener_port": "9090",
+			},
+		},
+		{
+			desc: "Fail with nested values in config file",
+			configFile: `backend:
+  address: http://file:8082`,
+			wantError: "nested values are not supported",
+		},
+		{
+			desc:       "Fail with list in config file",
+			configFile: `cors_allow_headers: [a, b]`,
+			wantError:  "only scalar values are supported",
+		},
+	}
+
+	dir, err := ioutil.TempDir("", "config_file")
+	if err != nil {
+		t.Fatalf("fail to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	configPath := filepath.Join(dir, "config.yaml")
+
+	for _, tc := range testData {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		fs.String("backend_address", "http://127.0.0.1:8082", "")
+		fs.Int("listener_port", 8080, "")
+		fs.Bool("enable_admin", false, "")
+		fs.String("cors_allow_origin", "", "")
+		fs.String("cors_allow_headers", "", "")
+		fs.String("config_file", "", "")
+
+		args := tc.args
+		if tc.configFile != "" {
+			if err := ioutil.WriteFile(configPath, []byte(tc.configFile), 0644); err != nil {
+				t.Fatalf("fail to write config file: %v", err)
+			}
+			args = append(args, "--config_file="+configPath)
+		}
+		if err := fs.Parse(args); err != nil {
+			t.Fatalf("Test Desc(%s): fail to parse flags: %v", tc.desc, err)
+		}
+		for name, value := range tc.env {
+			os.Setenv(name, value)
+		}
+
+		err := ApplyEnvAndConfigFile(fs)
+		for name := range tc.env {
+			os.Unsetenv(name)
+		}
+		if tc.wantError != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.wantError) {
+				t.Errorf("Test Desc(%s): got error %v, want error containing %v", tc.desc, err, tc.wantError)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Test Desc(%s): error not expected, got: %v", tc.desc, err)
+			continue
+		}
+		gotFlags := make(map[string]string)
+		for name := range tc.wantFlags {
+			gotFlags[name] = fs.Lookup(name).Value.String()
+		}
+		if !reflect.DeepEqual(gotFlags, tc.wantFlags) {
+			t.Errorf("Test Desc(%s): got flags %v, want %v", tc.desc, gotFlags, tc.wantFlags)
+		}
+	}
+}
@@ -16,6 +16,8 @@ package configgenerator
 
 import (
 	"fmt"
+	"net"
+	"strings"
 	"time"
 
 	"github.com/GoogleCloudPlatform/esp-v2/src/go/options"
@@ -24,8 +26,13 @@ import (
 	"github.com/golang/protobuf/ptypes"
 
 	sc "github.com/GoogleCloudPlatform/esp-v2/src/go/configinfo"
+	scpb "github.com/GoogleCloudPlatform/esp-v2/src/go/proto/api/envoy/http/service_control"
 	v2pb "github.com/envoyproxy/go-control-plane/envoy/api/v2"
+	clusterpb "github.com/envoyproxy/go-control-plane/envoy/api/v2/cluster"
 	corepb "github.com/envoyproxy/go-control-plane/envoy/api/v2/core"
+	typepb "github.com/envoyproxy/go-control-plane/envoy/type"
+	emptypb "github.com/golang/protobuf/ptypes/empty"
+	wrapperspb "github.com/golang/protobuf/ptypes/wrappers"
 )
 
 // MakeClusters provides dynamic cluster settings for Envoy
@@ -48,6 +55,15 @@ func MakeClusters(serviceInfo *sc.ServiceInfo) ([]*v2pb.Cluster, error) {
 		clusters = append(clusters, metadataCluster)
 	}
 
+	tokenAgentCluster := makeTokenAgentCluster(serviceInfo)
+	if tokenAgentCluster != nil {
+		clusters = append(clusters, tokenAgentCluster)
+	}
+
+	if acmeChallengeCluster := makeAcmeChallengeCluster(serviceInfo); acmeChallengeCluster != nil {
+		clusters = append(clusters, acmeChallengeCluster)
+	}
+
 	iamCluster, err := makeIamCluster(serviceInfo)
 	if err != nil {
 		return nil, err
@@ -82,10 +98,49 @@ func MakeClusters(serviceInfo *sc.ServiceInfo) ([]*v2pb.Cluster, error) {
 	if providerClusters != nil {
 		clusters = append(clusters, providerClusters...)
 	}
+
+	if serviceInfo.ExtAuthzCluster != nil {
+		// The backend TLS flags only apply to the backend in --backend_address.
+		extAuthzCluster, err := makeBackendCluster(&serviceInfo.Options, serviceInfo.ExtAuthzCluster, false)
+		if err != nil {
+			return nil, err
+		}
+		clusters = append(clusters, extAuthzCluster)
+	}
+
+	if serviceInfo.RateLimitServiceCluster != nil {
+		rateLimitServiceCluster, err := makeBackendCluster(&serviceInfo.Options, serviceInfo.RateLimitServiceCluster, false)
+		if err != nil {
+			return nil, err
+		}
+		clusters = append(clusters, rateLimitServiceCluster)
+	}
+
+	// The xDS server is called by the Google gRPC client with Google
+	// credentials, without cluster.
+	if serviceInfo.BackendEdsServer != nil && !serviceInfo.Options.BackendEdsGoogleCredentials {
+		// The backend TLS flags only apply to the backend in --backend_address.
+		edsServerCluster, err := makeBackendCluster(&serviceInfo.Options, serviceInfo.BackendEdsServer, false)
+		if err != nil {
+			return nil, err
+		}
+		clusters = append(clusters, edsServerCluster)
+	}
+
+	if serviceInfo.AccessLogServiceCluster != nil {
+		accessLogServiceCluster, err := makeBackendCluster(&serviceInfo.Options, serviceInfo.AccessLogServiceCluster, false)
+		if err != nil {
+			return nil, err
+		}
+		clusters = append(clusters, accessLogServiceCluster)
+	}
 	return clusters, nil
 }
 
 func makeMetadataCluster(serviceInfo *sc.ServiceInfo) (*v2pb.Cluster, error) {
+	if serviceInfo.Options.Standalone {
+		return nil, nil
+	}
 	scheme, hostname, port, _, err := util.ParseURI(serviceInfo.Options.MetadataURL)
 	if err != nil {
 		return nil, err
@@ -114,6 +169,55 @@ func makeMetadataCluster(serviceInfo *sc.ServiceInfo) (*v2pb.Cluster, error) {
 	return c, nil
 }
 
+// makeOutboundTransportSocket makes the upstream TLS context of the cluster of
+// a dependency, with its settings in --outbound_tls_config if any.
+func makeOutboundTransportSocket(serviceInfo *sc.ServiceInfo, dependency, hostname string) (*corepb.TransportSocket, error) {
+	o := serviceInfo.Options.OutboundTlsFor(dependency)
+	if o == nil {
+		return util.CreateUpstreamTransportSocket(hostname, serviceInfo.Options.RootCertsPath, "", nil)
+	}
+	caPath := o.CaPath
+	if caPath == "" {
+		caPath = serviceInfo.Options.RootCertsPath
+	}
+	return util.CreateOutboundTransportSocket(hostname, caPath, o.ClientCertPath, o.ClientKeyPath, o.MinVersion, o.MaxVersion)
+}
+
+// makeTokenAgentCluster makes the cluster of the token agent in the config
+// manager, only used when the access token or the OAuth2 access tokens of the
+// backends are fetched from it.
+func makeTokenAgentCluster(serviceInfo *sc.ServiceInfo) *v2pb.Cluster {
+	if serviceInfo.AccessToken.GetRemoteToken().GetCluster() != util.TokenAgentClusterName && len(serviceInfo.Options.BackendOAuth2) == 0 {
+		return nil
+	}
+	return &v2pb.Cluster{
+		Name:           util.TokenAgentClusterName,
+		LbPolicy:       v2pb.Cluster_ROUND_ROBIN,
+		ConnectTimeout: ptypes.DurationProto(serviceInfo.Options.ClusterConnectTimeout),
+		ClusterDiscoveryType: &v2pb.Cluster_Type{
+			Type: v2pb.Cluster_STATIC,
+		},
+		LoadAssignment: util.CreateLoadAssignment("127.0.0.1", uint32(serviceInfo.Options.TokenAgentPort)),
+	}
+}
+
+// makeAcmeChallengeCluster makes the cluster of the config manager serving the
+// ACME HTTP-01 challenges, if the certificate is issued by an ACME server.
+func makeAcmeChallengeCluster(serviceInfo *sc.ServiceInfo) *v2pb.Cluster {
+	if serviceInfo.Options.SslServerAcmeDirectoryUrl == "" {
+		return nil
+	}
+	return &v2pb.Cluster{
+		Name:           util.AcmeChallengeClusterName,
+		LbPolicy:       v2pb.Cluster_ROUND_ROBIN,
+		ConnectTimeout: ptypes.DurationProto(serviceInfo.Options.ClusterConnectTimeout),
+		ClusterDiscoveryType: &v2pb.Cluster_Type{
+			Type: v2pb.Cluster_STATIC,
+		},
+		LoadAssignment: util.CreateLoadAssignment("127.0.0.1", uint32(serviceInfo.Options.SslServerAcmeChallengePort)),
+	}
+}
+
 func makeIamCluster(serviceInfo *sc.ServiceInfo) (*v2pb.Cluster, error) {
 	if serviceInfo.Options.ServiceControlCredentials == nil && serviceInfo.Options.BackendAuthCredentials == nil {
 		return nil, nil
@@ -136,7 +240,7 @@ func makeIamCluster(serviceInfo *sc.ServiceInfo) (*v2pb.Cluster, error) {
 	}
 
 	if scheme == "https" {
-		transportSocket, err := util.CreateUpstreamTransportSocket(hostname, serviceInfo.Options.RootCertsPath, "", nil)
+		transportSocket, err := makeOutboundTransportSocket(serviceInfo, options.IamDependency, hostname)
 		if err != nil {
 			return nil, fmt.Errorf("error marshaling tls context to transport_socket config for cluster %s, err=%v",
 				c.Name, err)
@@ -153,6 +257,10 @@ func makeJwtProviderClusters(serviceInfo *sc.ServiceInfo) ([]*v2pb.Cluster, erro
 	generatedClusters := map[string]bool{}
 
 	for _, provider := range authn.GetProviders() {
+		// The local JWKS are inlined in the JWT Authn filter config.
+		if _, ok := serviceInfo.LocalJwks[provider.GetId()]; ok {
+			continue
+		}
 		jwksUri := provider.GetJwksUri()
 		clusterName, err := util.ExtraAddressFromURI(jwksUri)
 		if err != nil {
@@ -181,7 +289,7 @@ func makeJwtProviderClusters(serviceInfo *sc.ServiceInfo) ([]*v2pb.Cluster, erro
 			LoadAssignment:       util.CreateLoadAssignment(hostname, port),
 		}
 		if scheme == "https" {
-			transportSocket, err := util.CreateUpstreamTransportSocket(hostname, serviceInfo.Options.RootCertsPath, "", nil)
+			transportSocket, err := makeOutboundTransportSocket(serviceInfo, options.JwksDependency, hostname)
 			if err != nil {
 				return nil, fmt.Errorf("error marshaling tls context to transport_socket config for cluster %s, err=%v",
 					c.Name, err)
@@ -196,7 +304,7 @@ func makeJwtProviderClusters(serviceInfo *sc.ServiceInfo) ([]*v2pb.Cluster, erro
 	return providerClusters, nil
 }
 
-func makeBackendCluster(opt *options.ConfigGeneratorOptions, brc *sc.BackendRoutingCluster) (*v2pb.Cluster, error) {
+func makeBackendCluster(opt *options.ConfigGeneratorOptions, brc *sc.BackendRoutingCluster, withBackendTLSFlags bool) (*v2pb.Cluster, error) {
 	c := &v2pb.Cluster{
 		Name:                 brc.ClusterName,
 		LbPolicy:             v2pb.Cluster_ROUND_ROBIN,
@@ -204,27 +312,57 @@ func makeBackendCluster(opt *options.ConfigGeneratorOptions, brc *sc.BackendRout
 		ClusterDiscoveryType: &v2pb.Cluster_Type{Type: v2pb.Cluster_LOGICAL_DNS},
 		LoadAssignment:       util.CreateLoadAssignment(brc.Hostname, brc.Port),
 	}
+	if brc.ConnectTimeout > 0 {
+		c.ConnectTimeout = ptypes.DurationProto(brc.ConnectTimeout)
+	}
+	// The Unix domain socket is not resolved with DNS.
+	if brc.SocketPath != "" {
+		c.ClusterDiscoveryType = &v2pb.Cluster_Type{Type: v2pb.Cluster_STATIC}
+		c.LoadAssignment = util.CreatePipeLoadAssignment(brc.SocketPath)
+	}
 
 	isHttp2 := brc.Protocol == util.GRPC || brc.Protocol == util.HTTP2
+	withBackendTLSFlags = withBackendTLSFlags && hasBackendTLSFlags(opt)
 
 	if brc.UseTLS {
 		var alpnProtocols []string
 		if isHttp2 {
 			alpnProtocols = []string{"h2"}
 		}
-		transportSocket, err := util.CreateUpstreamTransportSocket(brc.Hostname, opt.RootCertsPath, opt.SslClientCertPath, alpnProtocols)
+		transportSocket, err := makeBackendTransportSocket(opt, brc, withBackendTLSFlags, alpnProtocols)
 		if err != nil {
-			return nil, fmt.Errorf("error marshaling tls context to transport_socket config for cluster %s, err=%v",
-				brc.ClusterName, err)
+			return nil, err
 		}
 		c.TransportSocket = transportSocket
+	} else if withBackendTLSFlags {
+		return nil, fmt.Errorf("backend TLS flags require --backend_address to use https or grpcs, got %s", opt.BackendAddress)
 	}
 
-	if isHttp2 {
-		c.Http2ProtocolOptions = &corepb.Http2ProtocolOptions{}
+	var connectionPool *options.ConnectionPoolOptions
+	if o := brc.ClusterOptions; o != nil {
+		connectionPool = o.ConnectionPool
 	}
-
-	switch opt.BackendDnsLookupFamily {
+	applyConnectionPool(c, opt.BackendConnectionPool, connectionPool, isHttp2)
+
+	dnsLookupFamily := opt.BackendDnsLookupFamily
+	dnsRefreshRate := opt.BackendDnsRefreshRate
+	c.RespectDnsTtl = opt.BackendDnsRespectTtl
+	strictDns := opt.BackendStrictDns
+	if o := brc.ClusterOptions; o != nil {
+		if o.DnsLookupFamily != "" {
+			dnsLookupFamily = o.DnsLookupFamily
+		}
+		if o.DnsRefreshRate > 0 {
+			dnsRefreshRate = time.Duration(o.DnsRefreshRate * float64(time.Second))
+		}
+		if o.RespectDnsTtl != nil {
+			c.RespectDnsTtl = *o.RespectDnsTtl
+		}
+		if o.StrictDns != nil {
+			strictDns = *o.StrictDns
+		}
+	}
+	switch dnsLookupFamily {
 	case "auto":
 		c.DnsLookupFamily = v2pb.Cluster_AUTO
 	case "v4only":
@@ -232,25 +370,362 @@ func makeBackendCluster(opt *options.ConfigGeneratorOptions, brc *sc.BackendRout
 	case "v6only":
 		c.DnsLookupFamily = v2pb.Cluster_V6_ONLY
 	default:
-		return nil, fmt.Errorf("Invalid DnsLookupFamily: %s; Only auto, v4only or v6only are valid.", opt.BackendDnsLookupFamily)
+		return nil, fmt.Errorf("Invalid DnsLookupFamily: %s; Only auto, v4only or v6only are valid.", dnsLookupFamily)
+	}
+	if dnsRefreshRate > 0 {
+		c.DnsRefreshRate = ptypes.DurationProto(dnsRefreshRate)
+	}
+	if strictDns && brc.SocketPath == "" {
+		c.ClusterDiscoveryType = &v2pb.Cluster_Type{Type: v2pb.Cluster_STRICT_DNS}
+	}
+
+	var circuitBreaker *options.CircuitBreakerOptions
+	var outlierDetection *options.OutlierDetectionOptions
+	var healthCheck *options.HealthCheckOptions
+	if o := brc.ClusterOptions; o != nil {
+		circuitBreaker = o.CircuitBreaker
+		outlierDetection = o.OutlierDetection
+		healthCheck = o.HealthCheck
+	}
+	c.CircuitBreakers = makeCircuitBreakers(opt.BackendCircuitBreaker, circuitBreaker)
+	c.OutlierDetection = makeOutlierDetection(opt.BackendOutlierDetection, outlierDetection)
+	if hc := makeHealthCheck(opt.BackendHealthCheck, healthCheck, brc.Hostname, isHttp2); hc != nil {
+		c.HealthChecks = []*corepb.HealthCheck{hc}
 	}
 	return c, nil
 }
 
+// makeLbPolicy returns the load balancing policy of a backend cluster. The
+// hash keys of the consistent hash policies are set on the routes.
+func makeLbPolicy(o *options.LoadBalancingOptions) v2pb.Cluster_LbPolicy {
+	switch o.Policy {
+	case "least_request":
+		return v2pb.Cluster_LEAST_REQUEST
+	case "ring_hash":
+		return v2pb.Cluster_RING_HASH
+	case "maglev":
+		return v2pb.Cluster_MAGLEV
+	default:
+		return v2pb.Cluster_ROUND_ROBIN
+	}
+}
+
+// applyConnectionPool sets the connection pool settings of a backend cluster,
+// with the settings of the backend overriding the ones of the flags.
+func applyConnectionPool(c *v2pb.Cluster, flags options.ConnectionPoolOptions, backend *options.ConnectionPoolOptions, isHttp2 bool) {
+	o := flags
+	if backend != nil {
+		if backend.MaxConcurrentStreams > 0 {
+			o.MaxConcurrentStreams = backend.MaxConcurrentStreams
+		}
+		if backend.InitialStreamWindowSize > 0 {
+			o.InitialStreamWindowSize = backend.InitialStreamWindowSize
+		}
+		if backend.InitialConnectionWindowSize > 0 {
+			o.InitialConnectionWindowSize = backend.InitialConnectionWindowSize
+		}
+		if backend.IdleTimeout > 0 {
+			o.IdleTimeout = backend.IdleTimeout
+		}
+		if backend.MaxRequestsPerConnection > 0 {
+			o.MaxRequestsPerConnection = backend.MaxRequestsPerConnection
+		}
+		if backend.TcpKeepaliveTime > 0 {
+			o.TcpKeepaliveTime = backend.TcpKeepaliveTime
+		}
+		if backend.TcpKeepaliveInterval > 0 {
+			o.TcpKeepaliveInterval = backend.TcpKeepaliveInterval
+		}
+		if backend.TcpKeepaliveProbes > 0 {
+			o.TcpKeepaliveProbes = backend.TcpKeepaliveProbes
+		}
+	}
+
+	if isHttp2 {
+		c.Http2ProtocolOptions = &corepb.Http2ProtocolOptions{
+			MaxConcurrentStreams:        uint32Value(o.MaxConcurrentStreams),
+			InitialStreamWindowSize:     uint32Value(o.InitialStreamWindowSize),
+			InitialConnectionWindowSize: uint32Value(o.InitialConnectionWindowSize),
+		}
+	}
+	if o.IdleTimeout > 0 {
+		c.CommonHttpProtocolOptions = &corepb.HttpProtocolOptions{
+			IdleTimeout: ptypes.DurationProto(time.Duration(o.IdleTimeout * float64(time.Second))),
+		}
+	}
+	c.MaxRequestsPerConnection = uint32Value(o.MaxRequestsPerConnection)
+	if o.TcpKeepaliveTime > 0 || o.TcpKeepaliveInterval > 0 || o.TcpKeepaliveProbes > 0 {
+		c.UpstreamConnectionOptions = &v2pb.UpstreamConnectionOptions{
+			TcpKeepalive: &corepb.TcpKeepalive{
+				KeepaliveTime:     uint32Value(o.TcpKeepaliveTime),
+				KeepaliveInterval: uint32Value(o.TcpKeepaliveInterval),
+				KeepaliveProbes:   uint32Value(o.TcpKeepaliveProbes),
+			},
+		}
+	}
+}
+
+// makeHealthCheck makes the active health check of a backend cluster, with
+// the settings of the backend overriding the ones of the flags, or returns nil
+// if the health check is disabled.
+func makeHealthCheck(flags options.HealthCheckOptions, backend *options.HealthCheckOptions, hostname string, isHttp2 bool) *corepb.HealthCheck {
+	o := flags
+	if backend != nil {
+		if backend.Enabled() {
+			o.Path = backend.Path
+			o.Grpc = backend.Grpc
+			o.GrpcService = backend.GrpcService
+		}
+		if backend.Interval > 0 {
+			o.Interval = backend.Interval
+		}
+		if backend.Timeout > 0 {
+			o.Timeout = backend.Timeout
+		}
+		if backend.HealthyThreshold > 0 {
+			o.HealthyThreshold = backend.HealthyThreshold
+		}
+		if backend.UnhealthyThreshold > 0 {
+			o.UnhealthyThreshold = backend.UnhealthyThreshold
+		}
+	}
+	if !o.Enabled() {
+		return nil
+	}
+
+	hc := &corepb.HealthCheck{
+		Interval:           ptypes.DurationProto(time.Duration(o.Interval * float64(time.Second))),
+		Timeout:            ptypes.DurationProto(time.Duration(o.Timeout * float64(time.Second))),
+		HealthyThreshold:   &wrapperspb.UInt32Value{Value: o.HealthyThreshold},
+		UnhealthyThreshold: &wrapperspb.UInt32Value{Value: o.UnhealthyThreshold},
+	}
+	if o.Grpc {
+		hc.HealthChecker = &corepb.HealthCheck_GrpcHealthCheck_{
+			GrpcHealthCheck: &corepb.HealthCheck_GrpcHealthCheck{
+				ServiceName: o.GrpcService,
+			},
+		}
+		return hc
+	}
+	httpHealthCheck := &corepb.HealthCheck_HttpHealthCheck{
+		Host: hostname,
+		Path: o.Path,
+	}
+	if isHttp2 {
+		httpHealthCheck.CodecClientType = typepb.CodecClientType_HTTP2
+	}
+	hc.HealthChecker = &corepb.HealthCheck_HttpHealthCheck_{
+		HttpHealthCheck: httpHealthCheck,
+	}
+	return hc
+}
+
+// makeCircuitBreakers makes the circuit breaker thresholds of a backend
+// cluster, with the settings of the backend overriding the ones of the flags,
+// or returns nil if none is set.
+func makeCircuitBreakers(flags options.CircuitBreakerOptions, backend *options.CircuitBreakerOptions) *clusterpb.CircuitBreakers {
+	o := flags
+	if backend != nil {
+		if backend.MaxConnections > 0 {
+			o.MaxConnections = backend.MaxConnections
+		}
+		if backend.MaxPendingRequests > 0 {
+			o.MaxPendingRequests = backend.MaxPendingRequests
+		}
+		if backend.MaxRequests > 0 {
+			o.MaxRequests = backend.MaxRequests
+		}
+		if backend.MaxRetries > 0 {
+			o.MaxRetries = backend.MaxRetries
+		}
+	}
+	if o == (options.CircuitBreakerOptions{}) {
+		return nil
+	}
+	return &clusterpb.CircuitBreakers{
+		Thresholds: []*clusterpb.CircuitBreakers_Thresholds{
+			{
+				Priority:           corepb.RoutingPriority_DEFAULT,
+				MaxConnections:     uint32Value(o.MaxConnections),
+				MaxPendingRequests: uint32Value(o.MaxPendingRequests),
+				MaxRequests:        uint32Value(o.MaxRequests),
+				MaxRetries:         uint32Value(o.MaxRetries),
+			},
+		},
+	}
+}
+
+// makeOutlierDetection makes the outlier detection of a backend cluster, with
+// the settings of the backend overriding the ones of the flags, or returns nil
+// if the number of consecutive 5xx responses is not set.
+func makeOutlierDetection(flags options.OutlierDetectionOptions, backend *options.OutlierDetectionOptions) *clusterpb.OutlierDetection {
+	o := flags
+	if backend != nil {
+		if backend.Consecutive5xx > 0 {
+			o.Consecutive5xx = backend.Consecutive5xx
+		}
+		if backend.Interval > 0 {
+			o.Interval = backend.Interval
+		}
+		if backend.BaseEjectionTime > 0 {
+			o.BaseEjectionTime = backend.BaseEjectionTime
+		}
+		if backend.MaxEjectionPercent > 0 {
+			o.MaxEjectionPercent = backend.MaxEjectionPercent
+		}
+	}
+	if o.Consecutive5xx == 0 {
+		return nil
+	}
+	od := &clusterpb.OutlierDetection{
+		Consecutive_5Xx:    uint32Value(o.Consecutive5xx),
+		MaxEjectionPercent: uint32Value(o.MaxEjectionPercent),
+	}
+	if o.Interval > 0 {
+		od.Interval = ptypes.DurationProto(time.Duration(o.Interval * float64(time.Second)))
+	}
+	if o.BaseEjectionTime > 0 {
+		od.BaseEjectionTime = ptypes.DurationProto(time.Duration(o.BaseEjectionTime * float64(time.Second)))
+	}
+	return od
+}
+
+// uint32Value returns the wrapper of v, or nil for the default if v is 0.
+func uint32Value(v uint32) *wrapperspb.UInt32Value {
+	if v == 0 {
+		return nil
+	}
+	return &wrapperspb.UInt32Value{Value: v}
+}
+
+// hasBackendTLSFlags returns true if any of the TLS flags of the backend in
+// --backend_address is set.
+func hasBackendTLSFlags(opt *options.ConfigGeneratorOptions) bool {
+	return opt.BackendMtlsCertPath != "" || opt.BackendMtlsKeyPath != "" || opt.BackendCaPath != "" || opt.BackendVerifySubjectAltNames != ""
+}
+
+// makeBackendTransportSocket makes the upstream TLS context of a backend
+// cluster, with the backend TLS flags if withBackendTLSFlags is set.
+func makeBackendTransportSocket(opt *options.ConfigGeneratorOptions, brc *sc.BackendRoutingCluster, withBackendTLSFlags bool, alpnProtocols []string) (*corepb.TransportSocket, error) {
+	// The SNI must be a host name, not an IP address.
+	sni := brc.Hostname
+	if net.ParseIP(sni) != nil {
+		sni = ""
+	}
+
+	if o := brc.ClusterOptions; o != nil && o.HasTLS() {
+		if o.Sni != "" {
+			sni = o.Sni
+		}
+		caPath := o.CaPath
+		if caPath == "" {
+			caPath = opt.RootCertsPath
+		}
+		return util.CreateUpstreamMtlsTransportSocket(sni, caPath, o.MtlsCertPath, o.MtlsKeyPath, o.VerifySubjectAltNames, alpnProtocols)
+	}
+
+	if !withBackendTLSFlags {
+		transportSocket, err := util.CreateUpstreamTransportSocket(sni, opt.RootCertsPath, opt.SslClientCertPath, alpnProtocols)
+		if err != nil {
+			return nil, fmt.Errorf("error marshaling tls context to transport_socket config for cluster %s, err=%v",
+				brc.ClusterName, err)
+		}
+		return transportSocket, nil
+	}
+
+	// Both would set the client certificate presented to the backend.
+	if opt.SslClientCertPath != "" {
+		return nil, fmt.Errorf("--ssl_client_cert_path cannot be used together with --backend_mtls_cert_path, --backend_mtls_key_path, --backend_ca_path or --backend_verify_san")
+	}
+	caPath := opt.BackendCaPath
+	if caPath == "" {
+		caPath = opt.RootCertsPath
+	}
+	var subjectAltNames []string
+	for _, san := range strings.Split(opt.BackendVerifySubjectAltNames, ",") {
+		if san = strings.TrimSpace(san); san != "" {
+			subjectAltNames = append(subjectAltNames, san)
+		}
+	}
+	return util.CreateUpstreamMtlsTransportSocket(sni, caPath, opt.BackendMtlsCertPath, opt.BackendMtlsKeyPath, subjectAltNames, alpnProtocols)
+}
+
+// makeCatchAllBackendCluster makes the cluster of the backend in
+// --backend_address, the only backend the backend TLS flags apply to.
 func makeCatchAllBackendCluster(serviceInfo *sc.ServiceInfo) (*v2pb.Cluster, error) {
-	c, err := makeBackendCluster(&serviceInfo.Options, serviceInfo.CatchAllBackend)
+	c, err := makeBackendCluster(&serviceInfo.Options, serviceInfo.CatchAllBackend, true)
 	if err != nil {
 		return nil, err
 	}
+	c.LbPolicy = makeLbPolicy(&serviceInfo.Options.BackendLoadBalancing)
+	if serviceInfo.BackendEdsServer != nil {
+		c.ClusterDiscoveryType = &v2pb.Cluster_Type{Type: v2pb.Cluster_EDS}
+		c.LoadAssignment = nil
+		c.EdsClusterConfig = &v2pb.Cluster_EdsClusterConfig{
+			EdsConfig:   makeBackendEdsConfigSource(serviceInfo),
+			ServiceName: serviceInfo.Options.BackendEdsServiceName,
+		}
+	}
 	glog.Infof("Backend cluster configuration for service %s: %v", serviceInfo.Name, c)
 	return c, nil
 }
 
+// makeBackendEdsConfigSource makes the source of the endpoints of the backend,
+// the xDS server in --backend_eds_server_uri. With Google credentials, it is
+// called directly by the Google gRPC client, like Traffic Director.
+func makeBackendEdsConfigSource(serviceInfo *sc.ServiceInfo) *corepb.ConfigSource {
+	grpcService := &corepb.GrpcService{
+		TargetSpecifier: &corepb.GrpcService_EnvoyGrpc_{
+			EnvoyGrpc: &corepb.GrpcService_EnvoyGrpc{
+				ClusterName: util.BackendEdsServerClusterName,
+			},
+		},
+	}
+	if serviceInfo.Options.BackendEdsGoogleCredentials {
+		eds := serviceInfo.BackendEdsServer
+		grpcService.TargetSpecifier = &corepb.GrpcService_GoogleGrpc_{
+			GoogleGrpc: &corepb.GrpcService_GoogleGrpc{
+				TargetUri:  fmt.Sprintf("%s:%d", eds.Hostname, eds.Port),
+				StatPrefix: "backend_eds",
+				ChannelCredentials: &corepb.GrpcService_GoogleGrpc_ChannelCredentials{
+					CredentialSpecifier: &corepb.GrpcService_GoogleGrpc_ChannelCredentials_SslCredentials{
+						SslCredentials: &corepb.GrpcService_GoogleGrpc_SslCredentials{
+							RootCerts: &corepb.DataSource{
+								Specifier: &corepb.DataSource_Filename{
+									Filename: serviceInfo.Options.RootCertsPath,
+								},
+							},
+						},
+					},
+				},
+				CallCredentials: []*corepb.GrpcService_GoogleGrpc_CallCredentials{
+					{
+						CredentialSpecifier: &corepb.GrpcService_GoogleGrpc_CallCredentials_GoogleComputeEngine{
+							GoogleComputeEngine: &emptypb.Empty{},
+						},
+					},
+				},
+			},
+		}
+	}
+	return &corepb.ConfigSource{
+		ConfigSourceSpecifier: &corepb.ConfigSource_ApiConfigSource{
+			ApiConfigSource: &corepb.ApiConfigSource{
+				ApiType:      corepb.ApiConfigSource_GRPC,
+				GrpcServices: []*corepb.GrpcService{grpcService},
+			},
+		},
+	}
+}
+
 func makeServiceControlCluster(serviceInfo *sc.ServiceInfo) (*v2pb.Cluster, error) {
 	uri := serviceInfo.ServiceConfig().GetControl().GetEnvironment()
 	if uri == "" {
 		return nil, nil
 	}
+	if serviceInfo.Options.ServiceControlURL != "" {
+		uri = serviceInfo.Options.ServiceControlURL
+	}
 
 	// The assumption about control.environment field. Its format:
 	//   [scheme://] +  host + [:port]
@@ -266,7 +741,13 @@ func makeServiceControlCluster(serviceInfo *sc.ServiceInfo) (*v2pb.Cluster, erro
 	}
 
 	connectTimeoutProto := ptypes.DurationProto(5 * time.Second)
-	serviceInfo.ServiceControlURI = scheme + "://" + hostname + "/v1/services/"
+	// The paths of the gRPC calls and the overridden paths are relative to the
+	// host.
+	transport := serviceInfo.ServiceControlTransport
+	serviceInfo.ServiceControlURI = scheme + "://" + hostname + util.ServiceControlRestPathPrefix
+	if transport.GetProtocol() == scpb.ServiceControlTransport_GRPC || transport.GetCheckPath() != "" {
+		serviceInfo.ServiceControlURI = scheme + "://" + hostname
+	}
 	c := &v2pb.Cluster{
 		Name:                 util.ServiceControlClusterName,
 		LbPolicy:             v2pb.Cluster_ROUND_ROBIN,
@@ -276,8 +757,12 @@ func makeServiceControlCluster(serviceInfo *sc.ServiceInfo) (*v2pb.Cluster, erro
 		LoadAssignment:       util.CreateLoadAssignment(hostname, port),
 	}
 
+	if transport.GetProtocol() == scpb.ServiceControlTransport_GRPC {
+		c.Http2ProtocolOptions = &corepb.Http2ProtocolOptions{}
+	}
+
 	if scheme == "https" {
-		transportSocket, err := util.CreateUpstreamTransportSocket(hostname, serviceInfo.Options.RootCertsPath, "", nil)
+		transportSocket, err := makeOutboundTransportSocket(serviceInfo, options.ServiceControlDependency, hostname)
 		if err != nil {
 			return nil, fmt.Errorf("error marshaling tls context to transport_socket config for cluster %s, err=%v",
 				c.Name, err)
@@ -292,10 +777,45 @@ func makeBackendRoutingClusters(serviceInfo *sc.ServiceInfo) ([]*v2pb.Cluster, e
 	var brClusters []*v2pb.Cluster
 
 	for _, v := range serviceInfo.BackendRoutingClusters {
-		c, err := makeBackendCluster(&serviceInfo.Options, v)
+		c, err := makeBackendCluster(&serviceInfo.Options, v, false)
 		if err != nil {
 			return nil, err
 		}
+		lb := v.LoadBalancing(&serviceInfo.Options)
+		c.LbPolicy = makeLbPolicy(lb)
+		// The backends with IP addresses are not resolved with DNS.
+		if net.ParseIP(v.Hostname) != nil {
+			c.ClusterDiscoveryType = &v2pb.Cluster_Type{Type: v2pb.Cluster_STATIC}
+		}
+		// The regions of a backend are all resolved, and the requests are sent
+		// to the regions with the least active requests, which are the ones
+		// responding faster. Failing regions are ejected.
+		if len(v.RegionHostnames) > 0 {
+			c.ClusterDiscoveryType = &v2pb.Cluster_Type{Type: v2pb.Cluster_STRICT_DNS}
+			c.LoadAssignment = util.CreateMultiHostLoadAssignment(append([]string{v.Hostname}, v.RegionHostnames...), v.Port)
+			if lb.Policy == "" {
+				c.LbPolicy = v2pb.Cluster_LEAST_REQUEST
+			}
+			if c.OutlierDetection == nil {
+				c.OutlierDetection = &clusterpb.OutlierDetection{}
+			}
+		}
+		// The other endpoints of a backend are all resolved, and the requests
+		// are balanced round robin between them.
+		if len(v.Endpoints) > 0 {
+			c.ClusterDiscoveryType = &v2pb.Cluster_Type{Type: v2pb.Cluster_STRICT_DNS}
+			c.LoadAssignment = util.CreateEndpointsLoadAssignment(append([]util.Endpoint{{Hostname: v.Hostname, Port: v.Port}}, v.Endpoints...))
+		}
+		// The failover regions of a backend have lower priorities, and only
+		// receive the requests when the ones with higher priorities are
+		// ejected or fail their health checks.
+		if len(v.FailoverHostnames) > 0 {
+			c.ClusterDiscoveryType = &v2pb.Cluster_Type{Type: v2pb.Cluster_STRICT_DNS}
+			util.AddFailoverEndpoints(c.LoadAssignment, v.FailoverHostnames, v.Port)
+			if c.OutlierDetection == nil {
+				c.OutlierDetection = &clusterpb.OutlierDetection{}
+			}
+		}
 
 		brClusters = append(brClusters, c)
 		glog.Infof("Add backend routing cluster configuration for %v: %v", v.ClusterName, c)
@@ -15,6 +15,8 @@
 package configgenerator
 
 import (
+	"io/ioutil"
+	"os"
 	"strings"
 	"testing"
 	"time"
@@ -27,7 +29,10 @@ import (
 	"github.com/google/go-cmp/cmp"
 
 	v2pb "github.com/envoyproxy/go-control-plane/envoy/api/v2"
+	clusterpb "github.com/envoyproxy/go-control-plane/envoy/api/v2/cluster"
 	corepb "github.com/envoyproxy/go-control-plane/envoy/api/v2/core"
+	emptypb "github.com/golang/protobuf/ptypes/empty"
+	wrapperspb "github.com/golang/protobuf/ptypes/wrappers"
 	annotationspb "google.golang.org/genproto/googleapis/api/annotations"
 	confpb "google.golang.org/genproto/googleapis/api/serviceconfig"
 	apipb "google.golang.org/genproto/protobuf/api"
@@ -45,6 +50,11 @@ func createTransportSocket(hostname string) *corepb.TransportSocket {
 	return transportSocket
 }
 
+func createOutboundTransportSocket(hostname, caPath, certPath, keyPath, minVersion, maxVersion string) *corepb.TransportSocket {
+	transportSocket, _ := util.CreateOutboundTransportSocket(hostname, caPath, certPath, keyPath, minVersion, maxVersion)
+	return transportSocket
+}
+
 func createH2TransportSocket(hostname string) *corepb.TransportSocket {
 	transportSocket, _ := util.CreateUpstreamTransportSocket(hostname, util.DefaultRootCAPaths, "", []string{"h2"})
 	return transportSocket
@@ -52,10 +62,13 @@ func createH2TransportSocket(hostname string) *corepb.TransportSocket {
 
 func TestMakeServiceControlCluster(t *testing.T) {
 	testData := []struct {
-		desc              string
-		fakeServiceConfig *confpb.Service
-		wantedCluster     v2pb.Cluster
-		BackendAddress    string
+		desc                   string
+		fakeServiceConfig      *confpb.Service
+		wantedCluster          v2pb.Cluster
+		wantedURI              string
+		BackendAddress         string
+		serviceControlURL      string
+		serviceControlProtocol string
 	}{
 		{
 			desc: "Success for gRPC backend",
@@ -112,11 +125,64 @@ func TestMakeServiceControlCluster(t *testing.T) {
 				LoadAssignment:       util.CreateLoadAssignment("127.0.0.1", 8000),
 			},
 		},
+		{
+			desc: "Success with the environment overridden by --service_control_url",
+			fakeServiceConfig: &confpb.Service{
+				Name: testProjectName,
+				Apis: []*apipb.Api{
+					{
+						Name: testApiName,
+					},
+				},
+				Control: &confpb.Control{
+					Environment: testServiceControlEnv,
+				},
+			},
+			BackendAddress:    "http://127.0.0.1:80",
+			serviceControlURL: "https://private.servicecontrol.example.com:8443",
+			wantedCluster: v2pb.Cluster{
+				Name:                 "service-control-cluster",
+				ConnectTimeout:       ptypes.DurationProto(5 * time.Second),
+				ClusterDiscoveryType: &v2pb.Cluster_Type{Type: v2pb.Cluster_LOGICAL_DNS},
+				DnsLookupFamily:      v2pb.Cluster_V4_ONLY,
+				LoadAssignment:       util.CreateLoadAssignment("private.servicecontrol.example.com", 8443),
+				TransportSocket:      createTransportSocket("private.servicecontrol.example.com"),
+			},
+		},
+		{
+			desc: "Success with the gRPC service control protocol",
+			fakeServiceConfig: &confpb.Service{
+				Name: testProjectName,
+				Apis: []*apipb.Api{
+					{
+						Name: testApiName,
+					},
+				},
+				Control: &confpb.Control{
+					Environment: "http://metering.example.com:8081",
+				},
+			},
+			BackendAddress:         "http://127.0.0.1:80",
+			serviceControlProtocol: "grpc",
+			wantedURI:              "http://metering.example.com",
+			wantedCluster: v2pb.Cluster{
+				Name:                 "service-control-cluster",
+				ConnectTimeout:       ptypes.DurationProto(5 * time.Second),
+				ClusterDiscoveryType: &v2pb.Cluster_Type{Type: v2pb.Cluster_LOGICAL_DNS},
+				DnsLookupFamily:      v2pb.Cluster_V4_ONLY,
+				LoadAssignment:       util.CreateLoadAssignment("metering.example.com", 8081),
+				Http2ProtocolOptions: &corepb.Http2ProtocolOptions{},
+			},
+		},
 	}
 
 	for i, tc := range testData {
 		opts := options.DefaultConfigGeneratorOptions()
 		opts.BackendAddress = tc.BackendAddress
+		opts.ServiceControlURL = tc.serviceControlURL
+		if tc.serviceControlProtocol != "" {
+			opts.ServiceControlProtocol = tc.serviceControlProtocol
+		}
 		fakeServiceInfo, err := configinfo.NewServiceInfoFromServiceConfig(tc.fakeServiceConfig, testConfigID, opts)
 		if err != nil {
 			t.Fatal(err)
@@ -130,6 +196,9 @@ func TestMakeServiceControlCluster(t *testing.T) {
 		if !proto.Equal(cluster, &tc.wantedCluster) {
 			t.Errorf("Test Desc(%d): %s, makeServiceControlCluster\ngot Clusters: %v,\nwant: %v", i, tc.desc, cluster, tc.wantedCluster)
 		}
+		if tc.wantedURI != "" && fakeServiceInfo.ServiceControlURI != tc.wantedURI {
+			t.Errorf("Test Desc(%d): %s, got ServiceControlURI: %s, want: %s", i, tc.desc, fakeServiceInfo.ServiceControlURI, tc.wantedURI)
+		}
 	}
 }
 
@@ -138,7 +207,14 @@ func TestMakeBackendRoutingCluster(t *testing.T) {
 		desc                   string
 		fakeServiceConfig      *confpb.Service
 		backendDnsLookupFamily string
+		backendDnsRefreshRate  time.Duration
+		backendStrictDns       bool
+		backendCircuitBreaker  options.CircuitBreakerOptions
+		backendOutlier         options.OutlierDetectionOptions
+		backendHealthCheckPath string
+		backendConnectionPool  options.ConnectionPoolOptions
 		BackendAddress         string
+		backendClusters        []*options.BackendClusterOptions
 		tlsContextSni          string
 		wantedClusters         []*v2pb.Cluster
 		wantedError            string
@@ -512,26 +588,18 @@ func TestMakeBackendRoutingCluster(t *testing.T) {
 			},
 		},
 		{
-			desc:                   "Failure, providing incorrect backend_dns_lookup_family flag",
-			backendDnsLookupFamily: "v5only",
+			desc: "Success for IP address backends, with static clusters and without SNI",
 			fakeServiceConfig: &confpb.Service{
 				Name: testProjectName,
 				Apis: []*apipb.Api{
 					{
-						Name: "1.cloudesf_testing_cloud_goog.run.app",
+						Name: "1.cloudesf_testing_cloud_goog",
 						Methods: []*apipb.Method{
 							{
 								Name: "Foo",
 							},
-						},
-					},
-				},
-				Http: &annotationspb.Http{
-					Rules: []*annotationspb.HttpRule{
-						{
-							Selector: "endpoints.examples.bookstore.Bookstore.ListShelves",
-							Pattern: &annotationspb.HttpRule_Get{
-								Get: "/v1/shelves",
+							{
+								Name: "Bar",
 							},
 						},
 					},
@@ -539,192 +607,884 @@ func TestMakeBackendRoutingCluster(t *testing.T) {
 				Backend: &confpb.Backend{
 					Rules: []*confpb.BackendRule{
 						{
-							Address:         "https://mybackend.run.app",
-							Selector:        "1.cloudesf_testing_cloud_goog.Foo",
-							PathTranslation: confpb.BackendRule_CONSTANT_ADDRESS,
-							Authentication: &confpb.BackendRule_JwtAudience{
-								JwtAudience: "mybackend.run.app",
-							},
+							Address:  "https://10.0.0.1:8443",
+							Selector: "1.cloudesf_testing_cloud_goog.Foo",
+						},
+						{
+							Address:  "http://10.0.0.2:8080",
+							Selector: "1.cloudesf_testing_cloud_goog.Bar",
 						},
 					},
 				},
 			},
 			BackendAddress: "http://127.0.0.1:80",
-			wantedError:    "Invalid DnsLookupFamily: v5only;",
-		},
-	}
-
-	for i, tc := range testData {
-		opts := options.DefaultConfigGeneratorOptions()
-		opts.BackendAddress = tc.BackendAddress
-		if tc.backendDnsLookupFamily != "" {
-			opts.BackendDnsLookupFamily = tc.backendDnsLookupFamily
-		}
-		fakeServiceInfo, err := configinfo.NewServiceInfoFromServiceConfig(tc.fakeServiceConfig, testConfigID, opts)
-		if err != nil {
-			t.Fatal(err)
-		}
-
-		clusters, err := makeBackendRoutingClusters(fakeServiceInfo)
-		if err != nil {
-			if tc.wantedError == "" || !strings.Contains(err.Error(), tc.wantedError) {
-				t.Fatal(err)
-
-			}
-		}
-
-		if tc.wantedClusters != nil && !cmp.Equal(clusters, tc.wantedClusters, cmp.Comparer(proto.Equal)) {
-			t.Errorf("Test Desc(%d): %s, makeBackendRoutingClusters got: %v, want: %v", i, tc.desc, clusters, tc.wantedClusters)
-		}
-	}
-}
-
-func TestMakeJwtProviderClusters(t *testing.T) {
-	testData := []struct {
-		desc            string
-		fakeProviders   []*confpb.AuthProvider
-		backendProtocol string
-		wantedClusters  []*v2pb.Cluster
-		wantedError     string
-	}{
-		{
-			desc: "Use https jwksUri and http jwksUri",
-			fakeProviders: []*confpb.AuthProvider{
-				&confpb.AuthProvider{
-					Id:      "auth_provider",
-					Issuer:  "issuer_0",
-					JwksUri: "https://metadata.com/pkey",
-				},
-				&confpb.AuthProvider{
-					Id:      "auth_provider",
-					Issuer:  "issuer_1",
-					JwksUri: "http://metadata.com/pkey",
-				},
-			},
 			wantedClusters: []*v2pb.Cluster{
 				{
-					Name:                 "metadata.com:443",
+					Name:                 "10.0.0.1:8443",
 					ConnectTimeout:       ptypes.DurationProto(20 * time.Second),
-					ClusterDiscoveryType: &v2pb.Cluster_Type{v2pb.Cluster_LOGICAL_DNS},
-					DnsLookupFamily:      v2pb.Cluster_V4_ONLY,
-					LoadAssignment:       util.CreateLoadAssignment("metadata.com", 443),
-					TransportSocket:      createTransportSocket("metadata.com"),
+					ClusterDiscoveryType: &v2pb.Cluster_Type{Type: v2pb.Cluster_STATIC},
+					LoadAssignment:       util.CreateLoadAssignment("10.0.0.1", 8443),
+					TransportSocket:      createTransportSocket(""),
 				},
 				{
-					Name:                 "metadata.com:80",
+					Name:                 "10.0.0.2:8080",
 					ConnectTimeout:       ptypes.DurationProto(20 * time.Second),
-					ClusterDiscoveryType: &v2pb.Cluster_Type{v2pb.Cluster_LOGICAL_DNS},
-					DnsLookupFamily:      v2pb.Cluster_V4_ONLY,
-					LoadAssignment:       util.CreateLoadAssignment("metadata.com", 80),
+					ClusterDiscoveryType: &v2pb.Cluster_Type{Type: v2pb.Cluster_STATIC},
+					LoadAssignment:       util.CreateLoadAssignment("10.0.0.2", 8080),
 				},
 			},
 		},
 		{
-			desc: "Failed with wrong-format jwksUri",
-			fakeProviders: []*confpb.AuthProvider{
-				&confpb.AuthProvider{
-					Id:      "auth_provider",
-					Issuer:  "issuer_2",
-					JwksUri: "%",
-				}},
-			wantedError: "Fail to parse uri %",
-		},
-		{
-			desc: "Deduplicate Auth Provider With Same Host",
-			fakeProviders: []*confpb.AuthProvider{
-				&confpb.AuthProvider{
-					Id:      "auth_provider",
-					Issuer:  "issuer_0",
-					JwksUri: "https://metadata.com/pkey",
+			desc: "Success for backends with their own cluster settings",
+			fakeServiceConfig: &confpb.Service{
+				Name: testProjectName,
+				Apis: []*apipb.Api{
+					{
+						Name: "1.cloudesf_testing_cloud_goog",
+						Methods: []*apipb.Method{
+							{
+								Name: "Foo",
+							},
+							{
+								Name: "Bar",
+							},
+						},
+					},
 				},
-				&confpb.AuthProvider{
-					Id:      "auth_provider",
-					Issuer:  "issuer_1",
-					JwksUri: "https://metadata.com/pkey",
+				Backend: &confpb.Backend{
+					Rules: []*confpb.BackendRule{
+						{
+							Address:  "grpcs://foo.example.com",
+							Selector: "1.cloudesf_testing_cloud_goog.Foo",
+						},
+						{
+							Address:  "https://bar.example.com",
+							Selector: "1.cloudesf_testing_cloud_goog.Bar",
+						},
+					},
 				},
 			},
-			wantedClusters: []*v2pb.Cluster{
+			BackendAddress: "http://127.0.0.1:80",
+			backendClusters: []*options.BackendClusterOptions{
 				{
-					Name:                 "metadata.com:443",
-					ConnectTimeout:       ptypes.DurationProto(20 * time.Second),
-					ClusterDiscoveryType: &v2pb.Cluster_Type{v2pb.Cluster_LOGICAL_DNS},
-					DnsLookupFamily:      v2pb.Cluster_V4_ONLY,
-					LoadAssignment:       util.CreateLoadAssignment("metadata.com", 443),
-					TransportSocket:      createTransportSocket("metadata.com"),
+					BackendAddress:        "grpcs://foo.example.com",
+					ConnectTimeout:        2,
+					CaPath:                "/etc/foo/ca.crt",
+					MtlsCertPath:          "/etc/foo/client.crt",
+					MtlsKeyPath:           "/etc/foo/client.key",
+					VerifySubjectAltNames: []string{"foo.internal"},
+					Sni:                   "foo.internal",
 				},
-			},
-		},
-	}
-	for i, tc := range testData {
-		fakeServiceConfig := &confpb.Service{
-			Apis: []*apipb.Api{
 				{
-					Name: testApiName,
+					BackendAddress: "https://bar.example.com",
+					ConnectTimeout: 0.5,
 				},
 			},
-			Authentication: &confpb.Authentication{
-				Providers: tc.fakeProviders,
+			wantedClusters: []*v2pb.Cluster{
+				{
+					Name:                 "foo.example.com:443",
+					ConnectTimeout:       ptypes.DurationProto(2 * time.Second),
+					ClusterDiscoveryType: &v2pb.Cluster_Type{Type: v2pb.Cluster_LOGICAL_DNS},
+					LoadAssignment:       util.CreateLoadAssignment("foo.example.com", 443),
+					TransportSocket: func() *corepb.TransportSocket {
+						ts, _ := util.CreateUpstreamMtlsTransportSocket("foo.internal", "/etc/foo/ca.crt", "/etc/foo/client.crt", "/etc/foo/client.key", []string{"foo.internal"}, []string{"h2"})
+						return ts
+					}(),
+					Http2ProtocolOptions: &corepb.Http2ProtocolOptions{},
+				},
+				{
+					Name:                 "bar.example.com:443",
+					ConnectTimeout:       ptypes.DurationProto(500 * time.Millisecond),
+					ClusterDiscoveryType: &v2pb.Cluster_Type{Type: v2pb.Cluster_LOGICAL_DNS},
+					LoadAssignment:       util.CreateLoadAssignment("bar.example.com", 443),
+					TransportSocket:      createTransportSocket("bar.example.com"),
+				},
 			},
-		}
-
-		opts := options.DefaultConfigGeneratorOptions()
-		opts.BackendAddress = "grpc://127.0.0.1:80"
-		fakeServiceInfo, err := configinfo.NewServiceInfoFromServiceConfig(fakeServiceConfig, testConfigID, opts)
-		if err != nil {
-			t.Fatal(err)
-		}
-
-		clusters, err := makeJwtProviderClusters(fakeServiceInfo)
-		if err != nil && !strings.Contains(err.Error(), tc.wantedError) {
-			t.Fatalf("Test Desc(%d): %s, got error:%v, wanted error:%v", i, tc.desc, err, tc.wantedError)
-		}
-
-		if !cmp.Equal(clusters, tc.wantedClusters, cmp.Comparer(proto.Equal)) {
-			t.Errorf("Test Desc(%d): %s, makeJwtProviderClusters\ngot: %v,\nwant: %v", i, tc.desc, clusters, tc.wantedClusters)
-		}
-
-	}
-}
-
-func TestMakeIamCluster(t *testing.T) {
-	testData := []struct {
-		desc                        string
-		BackendAddress              string
-		backendAuthIamCredential    *options.IAMCredentialsOptions
-		serviceControlIamCredential *options.IAMCredentialsOptions
-		fakeServiceConfig           *confpb.Service
-		wantedCluster               *v2pb.Cluster
-		wantedError                 string
-	}{
+		},
 		{
-			desc: "Success, generate iam cluster when backendAuthIamCredential is set",
-			backendAuthIamCredential: &options.IAMCredentialsOptions{
-				ServiceAccountEmail: "service-account@google.com",
-				Delegates:           nil,
-			},
+			desc: "Success for a Cloud Run backend in several regions, balanced by least requests",
 			fakeServiceConfig: &confpb.Service{
 				Name: testProjectName,
 				Apis: []*apipb.Api{
 					{
 						Name: "1.cloudesf_testing_cloud_goog",
+						Methods: []*apipb.Method{
+							{
+								Name: "Foo",
+							},
+						},
+					},
+				},
+				Backend: &confpb.Backend{
+					Rules: []*confpb.BackendRule{
+						{
+							Address:  "https://foo-12345-uc.a.run.app",
+							Selector: "1.cloudesf_testing_cloud_goog.Foo",
+						},
 					},
 				},
 			},
-			BackendAddress: "grpc://127.0.0.1:80",
-			wantedCluster: &v2pb.Cluster{
-				Name:                 util.IamServerClusterName,
-				ConnectTimeout:       ptypes.DurationProto(20 * time.Second),
-				DnsLookupFamily:      v2pb.Cluster_V4_ONLY,
-				ClusterDiscoveryType: &v2pb.Cluster_Type{v2pb.Cluster_STRICT_DNS},
-				LoadAssignment:       util.CreateLoadAssignment("iamcredentials.googleapis.com", 443),
-				TransportSocket:      createTransportSocket("iamcredentials.googleapis.com"),
+			BackendAddress: "http://127.0.0.1:80",
+			backendClusters: []*options.BackendClusterOptions{
+				{
+					BackendAddress:  "https://foo-12345-uc.a.run.app",
+					RegionAddresses: []string{"https://foo-12345-ew.a.run.app", "https://foo-12345-an.a.run.app"},
+				},
+			},
+			wantedClusters: []*v2pb.Cluster{
+				{
+					Name:                 "foo-12345-uc.a.run.app:443",
+					LbPolicy:             v2pb.Cluster_LEAST_REQUEST,
+					ConnectTimeout:       ptypes.DurationProto(20 * time.Second),
+					ClusterDiscoveryType: &v2pb.Cluster_Type{Type: v2pb.Cluster_STRICT_DNS},
+					LoadAssignment:       util.CreateMultiHostLoadAssignment([]string{"foo-12345-uc.a.run.app", "foo-12345-ew.a.run.app", "foo-12345-an.a.run.app"}, 443),
+					TransportSocket:      createTransportSocket("foo-12345-uc.a.run.app"),
+					OutlierDetection:     &clusterpb.OutlierDetection{},
+				},
+			},
+		},
+		{
+			desc: "Success for a Cloud Run backend failing over to other regions",
+			fakeServiceConfig: &confpb.Service{
+				Name: testProjectName,
+				Apis: []*apipb.Api{
+					{
+						Name: "1.cloudesf_testing_cloud_goog",
+						Methods: []*apipb.Method{
+							{
+								Name: "Foo",
+							},
+						},
+					},
+				},
+				Backend: &confpb.Backend{
+					Rules: []*confpb.BackendRule{
+						{
+							Address:  "https://foo-12345-uc.a.run.app",
+							Selector: "1.cloudesf_testing_cloud_goog.Foo",
+						},
+					},
+				},
+			},
+			BackendAddress: "http://127.0.0.1:80",
+			backendClusters: []*options.BackendClusterOptions{
+				{
+					BackendAddress:    "https://foo-12345-uc.a.run.app",
+					FailoverAddresses: []string{"https://foo-12345-ue.a.run.app", "https://foo-12345-ew.a.run.app"},
+				},
+			},
+			wantedClusters: []*v2pb.Cluster{
+				{
+					Name:                 "foo-12345-uc.a.run.app:443",
+					LbPolicy:             v2pb.Cluster_ROUND_ROBIN,
+					ConnectTimeout:       ptypes.DurationProto(20 * time.Second),
+					ClusterDiscoveryType: &v2pb.Cluster_Type{Type: v2pb.Cluster_STRICT_DNS},
+					LoadAssignment: func() *v2pb.ClusterLoadAssignment {
+						la := util.CreateLoadAssignment("foo-12345-uc.a.run.app", 443)
+						ue := util.CreateLoadAssignment("foo-12345-ue.a.run.app", 443).Endpoints[0]
+						ue.Priority = 1
+						ew := util.CreateLoadAssignment("foo-12345-ew.a.run.app", 443).Endpoints[0]
+						ew.Priority = 2
+						la.Endpoints = append(la.Endpoints, ue, ew)
+						return la
+					}(),
+					TransportSocket:  createTransportSocket("foo-12345-uc.a.run.app"),
+					OutlierDetection: &clusterpb.OutlierDetection{},
+				},
+			},
+		},
+		{
+			desc: "Success for a backend in several regions with its own load balancing policy",
+			fakeServiceConfig: &confpb.Service{
+				Name: testProjectName,
+				Apis: []*apipb.Api{
+					{
+						Name: "1.cloudesf_testing_cloud_goog",
+						Methods: []*apipb.Method{
+							{
+								Name: "Foo",
+							},
+						},
+					},
+				},
+				Backend: &confpb.Backend{
+					Rules: []*confpb.BackendRule{
+						{
+							Address:  "https://foo-12345-uc.a.run.app",
+							Selector: "1.cloudesf_testing_cloud_goog.Foo",
+						},
+					},
+				},
+			},
+			BackendAddress: "http://127.0.0.1:80",
+			backendClusters: []*options.BackendClusterOptions{
+				{
+					BackendAddress:  "https://foo-12345-uc.a.run.app",
+					RegionAddresses: []string{"https://foo-12345-ew.a.run.app"},
+					LoadBalancing: &options.LoadBalancingOptions{
+						Policy:       "maglev",
+						HashSourceIp: true,
+					},
+				},
+			},
+			wantedClusters: []*v2pb.Cluster{
+				{
+					Name:                 "foo-12345-uc.a.run.app:443",
+					LbPolicy:             v2pb.Cluster_MAGLEV,
+					ConnectTimeout:       ptypes.DurationProto(20 * time.Second),
+					ClusterDiscoveryType: &v2pb.Cluster_Type{Type: v2pb.Cluster_STRICT_DNS},
+					LoadAssignment:       util.CreateMultiHostLoadAssignment([]string{"foo-12345-uc.a.run.app", "foo-12345-ew.a.run.app"}, 443),
+					TransportSocket:      createTransportSocket("foo-12345-uc.a.run.app"),
+					OutlierDetection:     &clusterpb.OutlierDetection{},
+				},
+			},
+		},
+		{
+			desc: "Success for an on-prem backend with other endpoints and its DNS settings",
+			fakeServiceConfig: &confpb.Service{
+				Name: testProjectName,
+				Apis: []*apipb.Api{
+					{
+						Name: "1.cloudesf_testing_cloud_goog",
+						Methods: []*apipb.Method{
+							{
+								Name: "Foo",
+							},
+						},
+					},
+				},
+				Backend: &confpb.Backend{
+					Rules: []*confpb.BackendRule{
+						{
+							Address:  "http://onprem.example.com:8080",
+							Selector: "1.cloudesf_testing_cloud_goog.Foo",
+						},
+					},
+				},
+			},
+			BackendAddress: "http://127.0.0.1:80",
+			backendClusters: []*options.BackendClusterOptions{
+				{
+					BackendAddress:  "http://onprem.example.com:8080",
+					Endpoints:       []string{"onprem-2.example.com", "10.0.0.3:9090"},
+					DnsLookupFamily: "v4only",
+					DnsRefreshRate:  30,
+					RespectDnsTtl:   proto.Bool(true),
+				},
+			},
+			wantedClusters: []*v2pb.Cluster{
+				{
+					Name:                 "onprem.example.com:8080",
+					LbPolicy:             v2pb.Cluster_ROUND_ROBIN,
+					ConnectTimeout:       ptypes.DurationProto(20 * time.Second),
+					ClusterDiscoveryType: &v2pb.Cluster_Type{Type: v2pb.Cluster_STRICT_DNS},
+					LoadAssignment: util.CreateEndpointsLoadAssignment([]util.Endpoint{
+						{Hostname: "onprem.example.com", Port: 8080},
+						{Hostname: "onprem-2.example.com", Port: 8080},
+						{Hostname: "10.0.0.3", Port: 9090},
+					}),
+					DnsLookupFamily: v2pb.Cluster_V4_ONLY,
+					DnsRefreshRate:  ptypes.DurationProto(30 * time.Second),
+					RespectDnsTtl:   true,
+				},
+			},
+		},
+		{
+			desc:                  "Success for the backend DNS flags",
+			backendDnsRefreshRate: 10 * time.Second,
+			backendStrictDns:      true,
+			fakeServiceConfig: &confpb.Service{
+				Name: testProjectName,
+				Apis: []*apipb.Api{
+					{
+						Name: "1.cloudesf_testing_cloud_goog",
+						Methods: []*apipb.Method{
+							{
+								Name: "Foo",
+							},
+						},
+					},
+				},
+				Backend: &confpb.Backend{
+					Rules: []*confpb.BackendRule{
+						{
+							Address:  "http://onprem.example.com:8080",
+							Selector: "1.cloudesf_testing_cloud_goog.Foo",
+						},
+					},
+				},
+			},
+			BackendAddress: "http://127.0.0.1:80",
+			backendClusters: []*options.BackendClusterOptions{
+				{
+					BackendAddress: "http://onprem.example.com:8080",
+					StrictDns:      proto.Bool(false),
+				},
+			},
+			wantedClusters: []*v2pb.Cluster{
+				{
+					Name:                 "onprem.example.com:8080",
+					LbPolicy:             v2pb.Cluster_ROUND_ROBIN,
+					ConnectTimeout:       ptypes.DurationProto(20 * time.Second),
+					ClusterDiscoveryType: &v2pb.Cluster_Type{Type: v2pb.Cluster_LOGICAL_DNS},
+					LoadAssignment:       util.CreateLoadAssignment("onprem.example.com", 8080),
+					DnsRefreshRate:       ptypes.DurationProto(10 * time.Second),
+				},
+			},
+		},
+		{
+			desc: "Success for the circuit breaker and outlier detection flags, overridden by a backend",
+			backendCircuitBreaker: options.CircuitBreakerOptions{
+				MaxConnections: 100,
+				MaxRetries:     5,
+			},
+			backendOutlier: options.OutlierDetectionOptions{
+				Consecutive5xx:   3,
+				BaseEjectionTime: 60,
+			},
+			fakeServiceConfig: &confpb.Service{
+				Name: testProjectName,
+				Apis: []*apipb.Api{
+					{
+						Name: "1.cloudesf_testing_cloud_goog",
+						Methods: []*apipb.Method{
+							{
+								Name: "Foo",
+							},
+							{
+								Name: "Bar",
+							},
+						},
+					},
+				},
+				Backend: &confpb.Backend{
+					Rules: []*confpb.BackendRule{
+						{
+							Address:  "http://foo.example.com:8080",
+							Selector: "1.cloudesf_testing_cloud_goog.Foo",
+						},
+						{
+							Address:  "http://bar.example.com:8080",
+							Selector: "1.cloudesf_testing_cloud_goog.Bar",
+						},
+					},
+				},
+			},
+			BackendAddress: "http://127.0.0.1:80",
+			backendClusters: []*options.BackendClusterOptions{
+				{
+					BackendAddress: "http://bar.example.com:8080",
+					CircuitBreaker: &options.CircuitBreakerOptions{
+						MaxPendingRequests: 10,
+					},
+					OutlierDetection: &options.OutlierDetectionOptions{
+						Consecutive5xx:     5,
+						Interval:           2.5,
+						MaxEjectionPercent: 50,
+					},
+				},
+			},
+			wantedClusters: []*v2pb.Cluster{
+				{
+					Name:                 "foo.example.com:8080",
+					LbPolicy:             v2pb.Cluster_ROUND_ROBIN,
+					ConnectTimeout:       ptypes.DurationProto(20 * time.Second),
+					ClusterDiscoveryType: &v2pb.Cluster_Type{Type: v2pb.Cluster_LOGICAL_DNS},
+					LoadAssignment:       util.CreateLoadAssignment("foo.example.com", 8080),
+					CircuitBreakers: &clusterpb.CircuitBreakers{
+						Thresholds: []*clusterpb.CircuitBreakers_Thresholds{
+							{
+								MaxConnections: &wrapperspb.UInt32Value{Value: 100},
+								MaxRetries:     &wrapperspb.UInt32Value{Value: 5},
+							},
+						},
+					},
+					OutlierDetection: &clusterpb.OutlierDetection{
+						Consecutive_5Xx:  &wrapperspb.UInt32Value{Value: 3},
+						BaseEjectionTime: ptypes.DurationProto(60 * time.Second),
+					},
+				},
+				{
+					Name:                 "bar.example.com:8080",
+					LbPolicy:             v2pb.Cluster_ROUND_ROBIN,
+					ConnectTimeout:       ptypes.DurationProto(20 * time.Second),
+					ClusterDiscoveryType: &v2pb.Cluster_Type{Type: v2pb.Cluster_LOGICAL_DNS},
+					LoadAssignment:       util.CreateLoadAssignment("bar.example.com", 8080),
+					CircuitBreakers: &clusterpb.CircuitBreakers{
+						Thresholds: []*clusterpb.CircuitBreakers_Thresholds{
+							{
+								MaxConnections:     &wrapperspb.UInt32Value{Value: 100},
+								MaxPendingRequests: &wrapperspb.UInt32Value{Value: 10},
+								MaxRetries:         &wrapperspb.UInt32Value{Value: 5},
+							},
+						},
+					},
+					OutlierDetection: &clusterpb.OutlierDetection{
+						Consecutive_5Xx:    &wrapperspb.UInt32Value{Value: 5},
+						Interval:           ptypes.DurationProto(2500 * time.Millisecond),
+						BaseEjectionTime:   ptypes.DurationProto(60 * time.Second),
+						MaxEjectionPercent: &wrapperspb.UInt32Value{Value: 50},
+					},
+				},
+			},
+		},
+		{
+			desc:                   "Success for the health check flags, overridden by a gRPC backend",
+			backendHealthCheckPath: "/healthz",
+			fakeServiceConfig: &confpb.Service{
+				Name: testProjectName,
+				Apis: []*apipb.Api{
+					{
+						Name: "1.cloudesf_testing_cloud_goog",
+						Methods: []*apipb.Method{
+							{
+								Name: "Foo",
+							},
+							{
+								Name: "Bar",
+							},
+						},
+					},
+				},
+				Backend: &confpb.Backend{
+					Rules: []*confpb.BackendRule{
+						{
+							Address:  "http://foo.example.com:8080",
+							Selector: "1.cloudesf_testing_cloud_goog.Foo",
+						},
+						{
+							Address:  "grpc://bar.example.com:8080",
+							Selector: "1.cloudesf_testing_cloud_goog.Bar",
+						},
+					},
+				},
+			},
+			BackendAddress: "http://127.0.0.1:80",
+			backendClusters: []*options.BackendClusterOptions{
+				{
+					BackendAddress: "grpc://bar.example.com:8080",
+					HealthCheck: &options.HealthCheckOptions{
+						Grpc:               true,
+						GrpcService:        "bar.v1.Bar",
+						UnhealthyThreshold: 5,
+					},
+				},
+			},
+			wantedClusters: []*v2pb.Cluster{
+				{
+					Name:                 "foo.example.com:8080",
+					LbPolicy:             v2pb.Cluster_ROUND_ROBIN,
+					ConnectTimeout:       ptypes.DurationProto(20 * time.Second),
+					ClusterDiscoveryType: &v2pb.Cluster_Type{Type: v2pb.Cluster_LOGICAL_DNS},
+					LoadAssignment:       util.CreateLoadAssignment("foo.example.com", 8080),
+					HealthChecks: []*corepb.HealthCheck{
+						{
+							Interval:           ptypes.DurationProto(5 * time.Second),
+							Timeout:            ptypes.DurationProto(1 * time.Second),
+							HealthyThreshold:   &wrapperspb.UInt32Value{Value: 2},
+							UnhealthyThreshold: &wrapperspb.UInt32Value{Value: 3},
+							HealthChecker: &corepb.HealthCheck_HttpHealthCheck_{
+								HttpHealthCheck: &corepb.HealthCheck_HttpHealthCheck{
+									Host: "foo.example.com",
+									Path: "/healthz",
+								},
+							},
+						},
+					},
+				},
+				{
+					Name:                 "bar.example.com:8080",
+					LbPolicy:             v2pb.Cluster_ROUND_ROBIN,
+					ConnectTimeout:       ptypes.DurationProto(20 * time.Second),
+					ClusterDiscoveryType: &v2pb.Cluster_Type{Type: v2pb.Cluster_LOGICAL_DNS},
+					LoadAssignment:       util.CreateLoadAssignment("bar.example.com", 8080),
+					Http2ProtocolOptions: &corepb.Http2ProtocolOptions{},
+					HealthChecks: []*corepb.HealthCheck{
+						{
+							Interval:           ptypes.DurationProto(5 * time.Second),
+							Timeout:            ptypes.DurationProto(1 * time.Second),
+							HealthyThreshold:   &wrapperspb.UInt32Value{Value: 2},
+							UnhealthyThreshold: &wrapperspb.UInt32Value{Value: 5},
+							HealthChecker: &corepb.HealthCheck_GrpcHealthCheck_{
+								GrpcHealthCheck: &corepb.HealthCheck_GrpcHealthCheck{
+									ServiceName: "bar.v1.Bar",
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			desc: "Success for the connection pool flags, overridden by a gRPC backend",
+			backendConnectionPool: options.ConnectionPoolOptions{
+				MaxConcurrentStreams: 100,
+				IdleTimeout:          300,
+				TcpKeepaliveTime:     60,
+			},
+			fakeServiceConfig: &confpb.Service{
+				Name: testProjectName,
+				Apis: []*apipb.Api{
+					{
+						Name: "1.cloudesf_testing_cloud_goog",
+						Methods: []*apipb.Method{
+							{
+								Name: "Foo",
+							},
+							{
+								Name: "Bar",
+							},
+						},
+					},
+				},
+				Backend: &confpb.Backend{
+					Rules: []*confpb.BackendRule{
+						{
+							Address:  "http://foo.example.com:8080",
+							Selector: "1.cloudesf_testing_cloud_goog.Foo",
+						},
+						{
+							Address:  "grpc://bar.example.com:8080",
+							Selector: "1.cloudesf_testing_cloud_goog.Bar",
+						},
+					},
+				},
+			},
+			BackendAddress: "http://127.0.0.1:80",
+			backendClusters: []*options.BackendClusterOptions{
+				{
+					BackendAddress: "grpc://bar.example.com:8080",
+					ConnectionPool: &options.ConnectionPoolOptions{
+						MaxConcurrentStreams:        1000,
+						InitialStreamWindowSize:     1048576,
+						InitialConnectionWindowSize: 16777216,
+						MaxRequestsPerConnection:    10000,
+						TcpKeepaliveProbes:          3,
+					},
+				},
+			},
+			wantedClusters: []*v2pb.Cluster{
+				{
+					Name:                 "foo.example.com:8080",
+					LbPolicy:             v2pb.Cluster_ROUND_ROBIN,
+					ConnectTimeout:       ptypes.DurationProto(20 * time.Second),
+					ClusterDiscoveryType: &v2pb.Cluster_Type{Type: v2pb.Cluster_LOGICAL_DNS},
+					LoadAssignment:       util.CreateLoadAssignment("foo.example.com", 8080),
+					CommonHttpProtocolOptions: &corepb.HttpProtocolOptions{
+						IdleTimeout: ptypes.DurationProto(300 * time.Second),
+					},
+					UpstreamConnectionOptions: &v2pb.UpstreamConnectionOptions{
+						TcpKeepalive: &corepb.TcpKeepalive{
+							KeepaliveTime: &wrapperspb.UInt32Value{Value: 60},
+						},
+					},
+				},
+				{
+					Name:                 "bar.example.com:8080",
+					LbPolicy:             v2pb.Cluster_ROUND_ROBIN,
+					ConnectTimeout:       ptypes.DurationProto(20 * time.Second),
+					ClusterDiscoveryType: &v2pb.Cluster_Type{Type: v2pb.Cluster_LOGICAL_DNS},
+					LoadAssignment:       util.CreateLoadAssignment("bar.example.com", 8080),
+					Http2ProtocolOptions: &corepb.Http2ProtocolOptions{
+						MaxConcurrentStreams:        &wrapperspb.UInt32Value{Value: 1000},
+						InitialStreamWindowSize:     &wrapperspb.UInt32Value{Value: 1048576},
+						InitialConnectionWindowSize: &wrapperspb.UInt32Value{Value: 16777216},
+					},
+					CommonHttpProtocolOptions: &corepb.HttpProtocolOptions{
+						IdleTimeout: ptypes.DurationProto(300 * time.Second),
+					},
+					MaxRequestsPerConnection: &wrapperspb.UInt32Value{Value: 10000},
+					UpstreamConnectionOptions: &v2pb.UpstreamConnectionOptions{
+						TcpKeepalive: &corepb.TcpKeepalive{
+							KeepaliveTime:   &wrapperspb.UInt32Value{Value: 60},
+							KeepaliveProbes: &wrapperspb.UInt32Value{Value: 3},
+						},
+					},
+				},
+			},
+		},
+		{
+			desc:                   "Failure, providing incorrect backend_dns_lookup_family flag",
+			backendDnsLookupFamily: "v5only",
+			fakeServiceConfig: &confpb.Service{
+				Name: testProjectName,
+				Apis: []*apipb.Api{
+					{
+						Name: "1.cloudesf_testing_cloud_goog.run.app",
+						Methods: []*apipb.Method{
+							{
+								Name: "Foo",
+							},
+						},
+					},
+				},
+				Http: &annotationspb.Http{
+					Rules: []*annotationspb.HttpRule{
+						{
+							Selector: "endpoints.examples.bookstore.Bookstore.ListShelves",
+							Pattern: &annotationspb.HttpRule_Get{
+								Get: "/v1/shelves",
+							},
+						},
+					},
+				},
+				Backend: &confpb.Backend{
+					Rules: []*confpb.BackendRule{
+						{
+							Address:         "https://mybackend.run.app",
+							Selector:        "1.cloudesf_testing_cloud_goog.Foo",
+							PathTranslation: confpb.BackendRule_CONSTANT_ADDRESS,
+							Authentication: &confpb.BackendRule_JwtAudience{
+								JwtAudience: "mybackend.run.app",
+							},
+						},
+					},
+				},
+			},
+			BackendAddress: "http://127.0.0.1:80",
+			wantedError:    "Invalid DnsLookupFamily: v5only;",
+		},
+	}
+
+	for i, tc := range testData {
+		opts := options.DefaultConfigGeneratorOptions()
+		opts.BackendAddress = tc.BackendAddress
+		opts.BackendClusters = tc.backendClusters
+		if tc.backendDnsLookupFamily != "" {
+			opts.BackendDnsLookupFamily = tc.backendDnsLookupFamily
+		}
+		opts.BackendDnsRefreshRate = tc.backendDnsRefreshRate
+		opts.BackendStrictDns = tc.backendStrictDns
+		opts.BackendCircuitBreaker = tc.backendCircuitBreaker
+		opts.BackendOutlierDetection = tc.backendOutlier
+		opts.BackendHealthCheck.Path = tc.backendHealthCheckPath
+		opts.BackendConnectionPool = tc.backendConnectionPool
+		fakeServiceInfo, err := configinfo.NewServiceInfoFromServiceConfig(tc.fakeServiceConfig, testConfigID, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		clusters, err := makeBackendRoutingClusters(fakeServiceInfo)
+		if err != nil {
+			if tc.wantedError == "" || !strings.Contains(err.Error(), tc.wantedError) {
+				t.Fatal(err)
+
+			}
+		}
+
+		if tc.wantedClusters != nil && !cmp.Equal(clusters, tc.wantedClusters, cmp.Comparer(proto.Equal)) {
+			t.Errorf("Test Desc(%d): %s, makeBackendRoutingClusters got: %v, want: %v", i, tc.desc, clusters, tc.wantedClusters)
+		}
+	}
+}
+
+func TestMakeJwtProviderClusters(t *testing.T) {
+	testData := []struct {
+		desc            string
+		fakeProviders   []*confpb.AuthProvider
+		backendProtocol string
+		wantedClusters  []*v2pb.Cluster
+		wantedError     string
+	}{
+		{
+			desc: "Use https jwksUri and http jwksUri",
+			fakeProviders: []*confpb.AuthProvider{
+				&confpb.AuthProvider{
+					Id:      "auth_provider",
+					Issuer:  "issuer_0",
+					JwksUri: "https://metadata.com/pkey",
+				},
+				&confpb.AuthProvider{
+					Id:      "auth_provider",
+					Issuer:  "issuer_1",
+					JwksUri: "http://metadata.com/pkey",
+				},
+			},
+			wantedClusters: []*v2pb.Cluster{
+				{
+					Name:                 "metadata.com:443",
+					ConnectTimeout:       ptypes.DurationProto(20 * time.Second),
+					ClusterDiscoveryType: &v2pb.Cluster_Type{v2pb.Cluster_LOGICAL_DNS},
+					DnsLookupFamily:      v2pb.Cluster_V4_ONLY,
+					LoadAssignment:       util.CreateLoadAssignment("metadata.com", 443),
+					TransportSocket:      createTransportSocket("metadata.com"),
+				},
+				{
+					Name:                 "metadata.com:80",
+					ConnectTimeout:       ptypes.DurationProto(20 * time.Second),
+					ClusterDiscoveryType: &v2pb.Cluster_Type{v2pb.Cluster_LOGICAL_DNS},
+					DnsLookupFamily:      v2pb.Cluster_V4_ONLY,
+					LoadAssignment:       util.CreateLoadAssignment("metadata.com", 80),
+				},
+			},
+		},
+		{
+			desc: "Failed with wrong-format jwksUri",
+			fakeProviders: []*confpb.AuthProvider{
+				&confpb.AuthProvider{
+					Id:      "auth_provider",
+					Issuer:  "issuer_2",
+					JwksUri: "%",
+				}},
+			wantedError: "Fail to parse uri %",
+		},
+		{
+			desc: "No cluster for the provider with local JWKS",
+			fakeProviders: []*confpb.AuthProvider{
+				&confpb.AuthProvider{
+					Id:      "auth_provider",
+					Issuer:  "issuer_0",
+					JwksUri: "https://metadata.com/pkey",
+				},
+				&confpb.AuthProvider{
+					Id:      "local_provider",
+					Issuer:  "issuer_1",
+					JwksUri: `{"keys": []}`,
+				},
+			},
+			wantedClusters: []*v2pb.Cluster{
+				{
+					Name:                 "metadata.com:443",
+					ConnectTimeout:       ptypes.DurationProto(20 * time.Second),
+					ClusterDiscoveryType: &v2pb.Cluster_Type{v2pb.Cluster_LOGICAL_DNS},
+					DnsLookupFamily:      v2pb.Cluster_V4_ONLY,
+					LoadAssignment:       util.CreateLoadAssignment("metadata.com", 443),
+					TransportSocket:      createTransportSocket("metadata.com"),
+				},
+			},
+		},
+		{
+			desc: "Deduplicate Auth Provider With Same Host",
+			fakeProviders: []*confpb.AuthProvider{
+				&confpb.AuthProvider{
+					Id:      "auth_provider",
+					Issuer:  "issuer_0",
+					JwksUri: "https://metadata.com/pkey",
+				},
+				&confpb.AuthProvider{
+					Id:      "auth_provider",
+					Issuer:  "issuer_1",
+					JwksUri: "https://metadata.com/pkey",
+				},
+			},
+			wantedClusters: []*v2pb.Cluster{
+				{
+					Name:                 "metadata.com:443",
+					ConnectTimeout:       ptypes.DurationProto(20 * time.Second),
+					ClusterDiscoveryType: &v2pb.Cluster_Type{v2pb.Cluster_LOGICAL_DNS},
+					DnsLookupFamily:      v2pb.Cluster_V4_ONLY,
+					LoadAssignment:       util.CreateLoadAssignment("metadata.com", 443),
+					TransportSocket:      createTransportSocket("metadata.com"),
+				},
+			},
+		},
+	}
+	for i, tc := range testData {
+		fakeServiceConfig := &confpb.Service{
+			Apis: []*apipb.Api{
+				{
+					Name: testApiName,
+				},
+			},
+			Authentication: &confpb.Authentication{
+				Providers: tc.fakeProviders,
+			},
+		}
+
+		opts := options.DefaultConfigGeneratorOptions()
+		opts.BackendAddress = "grpc://127.0.0.1:80"
+		fakeServiceInfo, err := configinfo.NewServiceInfoFromServiceConfig(fakeServiceConfig, testConfigID, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		clusters, err := makeJwtProviderClusters(fakeServiceInfo)
+		if err != nil && !strings.Contains(err.Error(), tc.wantedError) {
+			t.Fatalf("Test Desc(%d): %s, got error:%v, wanted error:%v", i, tc.desc, err, tc.wantedError)
+		}
+
+		if !cmp.Equal(clusters, tc.wantedClusters, cmp.Comparer(proto.Equal)) {
+			t.Errorf("Test Desc(%d): %s, makeJwtProviderClusters\ngot: %v,\nwant: %v", i, tc.desc, clusters, tc.wantedClusters)
+		}
+
+	}
+}
+
+func TestMakeIamCluster(t *testing.T) {
+	testData := []struct {
+		desc                        string
+		BackendAddress              string
+		backendAuthIamCredential    *options.IAMCredentialsOptions
+		serviceControlIamCredential *options.IAMCredentialsOptions
+		outboundTls                 []*options.OutboundTlsOptions
+		fakeServiceConfig           *confpb.Service
+		wantedCluster               *v2pb.Cluster
+		wantedError                 string
+	}{
+		{
+			desc: "Success, generate iam cluster when backendAuthIamCredential is set",
+			backendAuthIamCredential: &options.IAMCredentialsOptions{
+				ServiceAccountEmail: "service-account@google.com",
+				Delegates:           nil,
+			},
+			fakeServiceConfig: &confpb.Service{
+				Name: testProjectName,
+				Apis: []*apipb.Api{
+					{
+						Name: "1.cloudesf_testing_cloud_goog",
+					},
+				},
+			},
+			BackendAddress: "grpc://127.0.0.1:80",
+			wantedCluster: &v2pb.Cluster{
+				Name:                 util.IamServerClusterName,
+				ConnectTimeout:       ptypes.DurationProto(20 * time.Second),
+				DnsLookupFamily:      v2pb.Cluster_V4_ONLY,
+				ClusterDiscoveryType: &v2pb.Cluster_Type{v2pb.Cluster_STRICT_DNS},
+				LoadAssignment:       util.CreateLoadAssignment("iamcredentials.googleapis.com", 443),
+				TransportSocket:      createTransportSocket("iamcredentials.googleapis.com"),
+			},
+		},
+		{
+			desc: "Success, generate iam cluster when serviceControlIamCredential is set",
+			serviceControlIamCredential: &options.IAMCredentialsOptions{
+				ServiceAccountEmail: "service-account@google.com",
+				Delegates:           nil,
+			},
+			fakeServiceConfig: &confpb.Service{
+				Name: testProjectName,
+				Apis: []*apipb.Api{
+					{
+						Name: "1.cloudesf_testing_cloud_goog",
+					},
+				},
+			},
+			BackendAddress: "grpc://127.0.0.1:80",
+			wantedCluster: &v2pb.Cluster{
+				Name:                 util.IamServerClusterName,
+				ConnectTimeout:       ptypes.DurationProto(20 * time.Second),
+				DnsLookupFamily:      v2pb.Cluster_V4_ONLY,
+				ClusterDiscoveryType: &v2pb.Cluster_Type{v2pb.Cluster_STRICT_DNS},
+				LoadAssignment:       util.CreateLoadAssignment("iamcredentials.googleapis.com", 443),
+				TransportSocket:      createTransportSocket("iamcredentials.googleapis.com"),
 			},
 		},
 		{
-			desc: "Success, generate iam cluster when serviceControlIamCredential is set",
+			desc: "Success, generate iam cluster with the TLS settings of iam",
 			serviceControlIamCredential: &options.IAMCredentialsOptions{
 				ServiceAccountEmail: "service-account@google.com",
-				Delegates:           nil,
+			},
+			outboundTls: []*options.OutboundTlsOptions{
+				{
+					Dependency: options.ServiceControlDependency,
+					CaPath:     "/etc/pki/servicecontrol.crt",
+				},
+				{
+					Dependency:     options.IamDependency,
+					ClientCertPath: "/etc/pki/client.crt",
+					ClientKeyPath:  "/etc/pki/client.key",
+					MinVersion:     "TLSv1_2",
+				},
 			},
 			fakeServiceConfig: &confpb.Service{
 				Name: testProjectName,
@@ -741,7 +1501,7 @@ func TestMakeIamCluster(t *testing.T) {
 				DnsLookupFamily:      v2pb.Cluster_V4_ONLY,
 				ClusterDiscoveryType: &v2pb.Cluster_Type{v2pb.Cluster_STRICT_DNS},
 				LoadAssignment:       util.CreateLoadAssignment("iamcredentials.googleapis.com", 443),
-				TransportSocket:      createTransportSocket("iamcredentials.googleapis.com"),
+				TransportSocket:      createOutboundTransportSocket("iamcredentials.googleapis.com", util.DefaultRootCAPaths, "/etc/pki/client.crt", "/etc/pki/client.key", "TLSv1_2", ""),
 			},
 		},
 		{
@@ -764,6 +1524,7 @@ func TestMakeIamCluster(t *testing.T) {
 		opts.BackendAddress = tc.BackendAddress
 		opts.BackendAuthCredentials = tc.backendAuthIamCredential
 		opts.ServiceControlCredentials = tc.serviceControlIamCredential
+		opts.OutboundTls = tc.outboundTls
 
 		fakeServiceInfo, err := configinfo.NewServiceInfoFromServiceConfig(tc.fakeServiceConfig, testConfigID, opts)
 		if err != nil {
@@ -783,3 +1544,509 @@ func TestMakeIamCluster(t *testing.T) {
 		}
 	}
 }
+
+func TestMakeCatchAllBackendClusterWithMtls(t *testing.T) {
+	testData := []struct {
+		desc                string
+		BackendAddress      string
+		backendMtlsCertPath string
+		backendMtlsKeyPath  string
+		backendCaPath       string
+		backendVerifySan    string
+		sslClientCertPath   string
+		wantTransportSocket func() *corepb.TransportSocket
+		wantedError         string
+	}{
+		{
+			desc:                "Success, https backend with client certificate and SAN verification",
+			BackendAddress:      "https://backend.example.com:8443",
+			backendMtlsCertPath: "/etc/backend/client.crt",
+			backendMtlsKeyPath:  "/etc/backend/client.key",
+			backendCaPath:       "/etc/backend/ca.crt",
+			backendVerifySan:    "backend.example.com, backend.internal",
+			wantTransportSocket: func() *corepb.TransportSocket {
+				ts, _ := util.CreateUpstreamMtlsTransportSocket("backend.example.com", "/etc/backend/ca.crt", "/etc/backend/client.crt", "/etc/backend/client.key", []string{"backend.example.com", "backend.internal"}, nil)
+				return ts
+			},
+		},
+		{
+			desc:                "Success, grpcs backend uses root certs by default and h2",
+			BackendAddress:      "grpcs://backend.example.com:8443",
+			backendMtlsCertPath: "/etc/backend/client.crt",
+			backendMtlsKeyPath:  "/etc/backend/client.key",
+			wantTransportSocket: func() *corepb.TransportSocket {
+				ts, _ := util.CreateUpstreamMtlsTransportSocket("backend.example.com", util.DefaultRootCAPaths, "/etc/backend/client.crt", "/etc/backend/client.key", nil, []string{"h2"})
+				return ts
+			},
+		},
+		{
+			desc:                "Failure, backend without TLS",
+			BackendAddress:      "http://127.0.0.1:8082",
+			backendMtlsCertPath: "/etc/backend/client.crt",
+			backendMtlsKeyPath:  "/etc/backend/client.key",
+			wantedError:         "require --backend_address to use https or grpcs",
+		},
+		{
+			desc:                "Failure, client certificate without key",
+			BackendAddress:      "https://backend.example.com:8443",
+			backendMtlsCertPath: "/etc/backend/client.crt",
+			wantedError:         "must be set together",
+		},
+		{
+			desc:                "Failure, client certificate also set by --ssl_client_cert_path",
+			BackendAddress:      "https://backend.example.com:8443",
+			backendMtlsCertPath: "/etc/backend/client.crt",
+			backendMtlsKeyPath:  "/etc/backend/client.key",
+			sslClientCertPath:   "/etc/nginx/ssl",
+			wantedError:         "--ssl_client_cert_path cannot be used together with --backend_mtls_cert_path",
+		},
+	}
+
+	for i, tc := range testData {
+		opts := options.DefaultConfigGeneratorOptions()
+		opts.BackendAddress = tc.BackendAddress
+		opts.BackendMtlsCertPath = tc.backendMtlsCertPath
+		opts.BackendMtlsKeyPath = tc.backendMtlsKeyPath
+		opts.BackendCaPath = tc.backendCaPath
+		opts.BackendVerifySubjectAltNames = tc.backendVerifySan
+		opts.SslClientCertPath = tc.sslClientCertPath
+
+		fakeServiceInfo, err := configinfo.NewServiceInfoFromServiceConfig(&confpb.Service{
+			Name: testProjectName,
+			Apis: []*apipb.Api{
+				{
+					Name: testApiName,
+				},
+			},
+		}, testConfigID, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		cluster, err := makeCatchAllBackendCluster(fakeServiceInfo)
+		if tc.wantedError != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.wantedError) {
+				t.Errorf("Test Desc(%d): %s, got error %v, want error containing %q", i, tc.desc, err, tc.wantedError)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Test Desc(%d): %s, makeCatchAllBackendCluster got error: %v", i, tc.desc, err)
+		}
+		if want := tc.wantTransportSocket(); !proto.Equal(cluster.TransportSocket, want) {
+			t.Errorf("Test Desc(%d): %s, makeCatchAllBackendCluster got transport socket\ngot: %v,\nwant: %v", i, tc.desc, cluster.TransportSocket, want)
+		}
+	}
+}
+
+func TestMakeCatchAllBackendClusterWithUnixSocket(t *testing.T) {
+	testData := []struct {
+		desc               string
+		BackendAddress     string
+		wantLoadAssignment *v2pb.ClusterLoadAssignment
+		wantHttp2          bool
+	}{
+		{
+			desc:               "http backend on a Unix domain socket",
+			BackendAddress:     "unix:/var/run/backend.sock",
+			wantLoadAssignment: util.CreatePipeLoadAssignment("/var/run/backend.sock"),
+		},
+		{
+			desc:               "grpc backend on a Unix domain socket",
+			BackendAddress:     "grpc+unix:///var/run/backend.sock",
+			wantLoadAssignment: util.CreatePipeLoadAssignment("/var/run/backend.sock"),
+			wantHttp2:          true,
+		},
+	}
+
+	for _, tc := range testData {
+		opts := options.DefaultConfigGeneratorOptions()
+		opts.BackendAddress = tc.BackendAddress
+		opts.BackendStrictDns = true
+
+		fakeServiceInfo, err := configinfo.NewServiceInfoFromServiceConfig(&confpb.Service{
+			Name: testProjectName,
+			Apis: []*apipb.Api{
+				{
+					Name: testApiName,
+				},
+			},
+		}, testConfigID, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		cluster, err := makeCatchAllBackendCluster(fakeServiceInfo)
+		if err != nil {
+			t.Fatalf("Test Desc(%s): makeCatchAllBackendCluster got error: %v", tc.desc, err)
+		}
+		if got := cluster.GetType(); got != v2pb.Cluster_STATIC {
+			t.Errorf("Test Desc(%s): got cluster type %v, want STATIC", tc.desc, got)
+		}
+		if !proto.Equal(cluster.LoadAssignment, tc.wantLoadAssignment) {
+			t.Errorf("Test Desc(%s): got load assignment %v, want %v", tc.desc, cluster.LoadAssignment, tc.wantLoadAssignment)
+		}
+		if gotHttp2 := cluster.Http2ProtocolOptions != nil; gotHttp2 != tc.wantHttp2 {
+			t.Errorf("Test Desc(%s): got HTTP/2 %v, want %v", tc.desc, gotHttp2, tc.wantHttp2)
+		}
+	}
+}
+
+func TestMakeClustersWithBackendEds(t *testing.T) {
+	testData := []struct {
+		desc                 string
+		backendEdsServerUri  string
+		googleCredentials    bool
+		wantEdsGrpcService   *corepb.GrpcService
+		wantEdsServerCluster bool
+		wantedError          string
+	}{
+		{
+			desc:                "Endpoints from an xDS server cluster",
+			backendEdsServerUri: "grpc://xds.example.com:18000",
+			wantEdsGrpcService: &corepb.GrpcService{
+				TargetSpecifier: &corepb.GrpcService_EnvoyGrpc_{
+					EnvoyGrpc: &corepb.GrpcService_EnvoyGrpc{
+						ClusterName: util.BackendEdsServerClusterName,
+					},
+				},
+			},
+			wantEdsServerCluster: true,
+		},
+		{
+			desc:                "Endpoints from Traffic Director with Google credentials",
+			backendEdsServerUri: "grpcs://trafficdirector.googleapis.com:443",
+			googleCredentials:   true,
+			wantEdsGrpcService: &corepb.GrpcService{
+				TargetSpecifier: &corepb.GrpcService_GoogleGrpc_{
+					GoogleGrpc: &corepb.GrpcService_GoogleGrpc{
+						TargetUri:  "trafficdirector.googleapis.com:443",
+						StatPrefix: "backend_eds",
+						ChannelCredentials: &corepb.GrpcService_GoogleGrpc_ChannelCredentials{
+							CredentialSpecifier: &corepb.GrpcService_GoogleGrpc_ChannelCredentials_SslCredentials{
+								SslCredentials: &corepb.GrpcService_GoogleGrpc_SslCredentials{
+									RootCerts: &corepb.DataSource{
+										Specifier: &corepb.DataSource_Filename{
+											Filename: util.DefaultRootCAPaths,
+										},
+									},
+								},
+							},
+						},
+						CallCredentials: []*corepb.GrpcService_GoogleGrpc_CallCredentials{
+							{
+								CredentialSpecifier: &corepb.GrpcService_GoogleGrpc_CallCredentials_GoogleComputeEngine{
+									GoogleComputeEngine: &emptypb.Empty{},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			desc:                "Failure, xDS server over HTTP",
+			backendEdsServerUri: "http://xds.example.com:18000",
+			wantedError:         `the scheme must be "grpc" or "grpcs"`,
+		},
+		{
+			desc:                "Failure, Google credentials without TLS",
+			backendEdsServerUri: "grpc://trafficdirector.googleapis.com:443",
+			googleCredentials:   true,
+			wantedError:         `the scheme must be "grpcs" with --backend_eds_google_credentials`,
+		},
+	}
+
+	for _, tc := range testData {
+		opts := options.DefaultConfigGeneratorOptions()
+		opts.BackendAddress = "http://backend.example.com:8080"
+		opts.BackendEdsServerUri = tc.backendEdsServerUri
+		opts.BackendEdsServiceName = "bookstore-backend"
+		opts.BackendEdsGoogleCredentials = tc.googleCredentials
+
+		fakeServiceInfo, err := configinfo.NewServiceInfoFromServiceConfig(&confpb.Service{
+			Name: testProjectName,
+			Apis: []*apipb.Api{
+				{
+					Name: testApiName,
+				},
+			},
+		}, testConfigID, opts)
+		if tc.wantedError != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.wantedError) {
+				t.Errorf("Test Desc(%s): got error %v, want error containing %q", tc.desc, err, tc.wantedError)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		clusters, err := MakeClusters(fakeServiceInfo)
+		if err != nil {
+			t.Fatalf("Test Desc(%s): MakeClusters got error: %v", tc.desc, err)
+		}
+		backendCluster := clusters[0]
+		if got := backendCluster.GetType(); got != v2pb.Cluster_EDS {
+			t.Errorf("Test Desc(%s): got backend cluster type %v, want EDS", tc.desc, got)
+		}
+		if backendCluster.LoadAssignment != nil {
+			t.Errorf("Test Desc(%s): got backend load assignment %v, want none", tc.desc, backendCluster.LoadAssignment)
+		}
+		wantEdsClusterConfig := &v2pb.Cluster_EdsClusterConfig{
+			EdsConfig: &corepb.ConfigSource{
+				ConfigSourceSpecifier: &corepb.ConfigSource_ApiConfigSource{
+					ApiConfigSource: &corepb.ApiConfigSource{
+						ApiType:      corepb.ApiConfigSource_GRPC,
+						GrpcServices: []*corepb.GrpcService{tc.wantEdsGrpcService},
+					},
+				},
+			},
+			ServiceName: "bookstore-backend",
+		}
+		if !proto.Equal(backendCluster.EdsClusterConfig, wantEdsClusterConfig) {
+			t.Errorf("Test Desc(%s): got EDS cluster config %v, want %v", tc.desc, backendCluster.EdsClusterConfig, wantEdsClusterConfig)
+		}
+
+		gotEdsServerCluster := false
+		for _, c := range clusters {
+			if c.Name == util.BackendEdsServerClusterName {
+				gotEdsServerCluster = true
+				if c.Http2ProtocolOptions == nil {
+					t.Errorf("Test Desc(%s): xDS server cluster is not HTTP/2", tc.desc)
+				}
+			}
+		}
+		if gotEdsServerCluster != tc.wantEdsServerCluster {
+			t.Errorf("Test Desc(%s): got xDS server cluster %v, want %v", tc.desc, gotEdsServerCluster, tc.wantEdsServerCluster)
+		}
+	}
+}
+
+func TestMakeTokenAgentCluster(t *testing.T) {
+	testData := []struct {
+		desc              string
+		serviceAccountKey string
+		backendOAuth2     []*options.BackendOAuth2Options
+		wantedCluster     *v2pb.Cluster
+	}{
+		{
+			desc:              "Success, generate token agent cluster for external account",
+			serviceAccountKey: `{"type": "external_account", "audience": "//iam.googleapis.com/projects/123/locations/global/workloadIdentityPools/pool/providers/aws"}`,
+			wantedCluster: &v2pb.Cluster{
+				Name:                 util.TokenAgentClusterName,
+				ConnectTimeout:       ptypes.DurationProto(20 * time.Second),
+				ClusterDiscoveryType: &v2pb.Cluster_Type{v2pb.Cluster_STATIC},
+				LoadAssignment:       util.CreateLoadAssignment("127.0.0.1", 8791),
+			},
+		},
+		{
+			desc:              "Success, not generate token agent cluster for service account",
+			serviceAccountKey: `{"type": "service_account"}`,
+		},
+		{
+			desc: "Success, generate token agent cluster for backends with OAuth2 client credentials",
+			backendOAuth2: []*options.BackendOAuth2Options{
+				{
+					BackendAddress: "https://oauth2.example.com",
+					TokenURL:       "https://auth.example.com/token",
+					ClientID:       "client-id",
+				},
+			},
+			wantedCluster: &v2pb.Cluster{
+				Name:                 util.TokenAgentClusterName,
+				ConnectTimeout:       ptypes.DurationProto(20 * time.Second),
+				ClusterDiscoveryType: &v2pb.Cluster_Type{v2pb.Cluster_STATIC},
+				LoadAssignment:       util.CreateLoadAssignment("127.0.0.1", 8791),
+			},
+		},
+		{
+			desc: "Success, not generate token agent cluster without service account key",
+		},
+	}
+
+	for _, tc := range testData {
+		opts := options.DefaultConfigGeneratorOptions()
+		opts.BackendOAuth2 = tc.backendOAuth2
+		if tc.serviceAccountKey != "" {
+			keyFile, err := ioutil.TempFile("", "key")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.Remove(keyFile.Name())
+			if _, err := keyFile.WriteString(tc.serviceAccountKey); err != nil {
+				t.Fatal(err)
+			}
+			keyFile.Close()
+			opts.ServiceAccountKey = keyFile.Name()
+		}
+
+		fakeServiceInfo, err := configinfo.NewServiceInfoFromServiceConfig(&confpb.Service{
+			Name: testProjectName,
+			Apis: []*apipb.Api{
+				{
+					Name: "1.cloudesf_testing_cloud_goog",
+				},
+			},
+		}, testConfigID, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		cluster := makeTokenAgentCluster(fakeServiceInfo)
+		if !proto.Equal(cluster, tc.wantedCluster) {
+			t.Errorf("Test Desc(%s): makeTokenAgentCluster\ngot: %v,\nwant: %v", tc.desc, cluster, tc.wantedCluster)
+		}
+	}
+}
+
+func TestMakeAcmeChallengeCluster(t *testing.T) {
+	testData := []struct {
+		desc          string
+		acmeDirectory string
+		wantedCluster *v2pb.Cluster
+	}{
+		{
+			desc:          "Success, generate ACME challenge cluster",
+			acmeDirectory: "https://acme.example.com/directory",
+			wantedCluster: &v2pb.Cluster{
+				Name:                 util.AcmeChallengeClusterName,
+				ConnectTimeout:       ptypes.DurationProto(20 * time.Second),
+				ClusterDiscoveryType: &v2pb.Cluster_Type{v2pb.Cluster_STATIC},
+				LoadAssignment:       util.CreateLoadAssignment("127.0.0.1", 8793),
+			},
+		},
+		{
+			desc: "Success, not generate ACME challenge cluster without ACME",
+		},
+	}
+
+	for _, tc := range testData {
+		opts := options.DefaultConfigGeneratorOptions()
+		opts.SslServerAcmeDirectoryUrl = tc.acmeDirectory
+		fakeServiceInfo, err := configinfo.NewServiceInfoFromServiceConfig(&confpb.Service{
+			Name: testProjectName,
+			Apis: []*apipb.Api{
+				{
+					Name: "1.cloudesf_testing_cloud_goog",
+				},
+			},
+		}, testConfigID, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		cluster := makeAcmeChallengeCluster(fakeServiceInfo)
+		if !proto.Equal(cluster, tc.wantedCluster) {
+			t.Errorf("Test Desc(%s): makeAcmeChallengeCluster\ngot: %v,\nwant: %v", tc.desc, cluster, tc.wantedCluster)
+		}
+	}
+}
+
+func TestMakeExtAuthzCluster(t *testing.T) {
+	testData := []struct {
+		desc          string
+		extAuthzUri   string
+		wantedCluster *v2pb.Cluster
+	}{
+		{
+			desc:        "Success, generate external authorization cluster for a gRPC server",
+			extAuthzUri: "grpc://authz.example.com:9000",
+			wantedCluster: &v2pb.Cluster{
+				Name:                 util.ExtAuthzClusterName,
+				ConnectTimeout:       ptypes.DurationProto(20 * time.Second),
+				ClusterDiscoveryType: &v2pb.Cluster_Type{v2pb.Cluster_LOGICAL_DNS},
+				LoadAssignment:       util.CreateLoadAssignment("authz.example.com", 9000),
+				Http2ProtocolOptions: &corepb.Http2ProtocolOptions{},
+			},
+		},
+		{
+			desc: "Success, not generate external authorization cluster without --ext_authz_uri",
+		},
+	}
+
+	for _, tc := range testData {
+		opts := options.DefaultConfigGeneratorOptions()
+		opts.BackendAddress = "http://127.0.0.1:80"
+		opts.ExtAuthzUri = tc.extAuthzUri
+		fakeServiceInfo, err := configinfo.NewServiceInfoFromServiceConfig(&confpb.Service{
+			Name: testProjectName,
+			Apis: []*apipb.Api{
+				{
+					Name: "1.cloudesf_testing_cloud_goog",
+				},
+			},
+		}, testConfigID, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		clusters, err := MakeClusters(fakeServiceInfo)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var cluster *v2pb.Cluster
+		for _, c := range clusters {
+			if c.Name == util.ExtAuthzClusterName {
+				cluster = c
+			}
+		}
+		if !proto.Equal(cluster, tc.wantedCluster) {
+			t.Errorf("Test Desc(%s): MakeClusters\ngot external authorization cluster: %v,\nwant: %v", tc.desc, cluster, tc.wantedCluster)
+		}
+	}
+}
+
+func TestMakeRateLimitServiceCluster(t *testing.T) {
+	testData := []struct {
+		desc                string
+		rateLimitServiceUri string
+		wantedCluster       *v2pb.Cluster
+	}{
+		{
+			desc:                "Success, generate rate limit service cluster",
+			rateLimitServiceUri: "grpc://ratelimit.example.com:8081",
+			wantedCluster: &v2pb.Cluster{
+				Name:                 util.RateLimitServiceClusterName,
+				ConnectTimeout:       ptypes.DurationProto(20 * time.Second),
+				ClusterDiscoveryType: &v2pb.Cluster_Type{v2pb.Cluster_LOGICAL_DNS},
+				LoadAssignment:       util.CreateLoadAssignment("ratelimit.example.com", 8081),
+				Http2ProtocolOptions: &corepb.Http2ProtocolOptions{},
+			},
+		},
+		{
+			desc: "Success, not generate rate limit service cluster without --rate_limit_service_uri",
+		},
+	}
+
+	for _, tc := range testData {
+		opts := options.DefaultConfigGeneratorOptions()
+		opts.BackendAddress = "http://127.0.0.1:80"
+		opts.RateLimitServiceUri = tc.rateLimitServiceUri
+		fakeServiceInfo, err := configinfo.NewServiceInfoFromServiceConfig(&confpb.Service{
+			Name: testProjectName,
+			Apis: []*apipb.Api{
+				{
+					Name: "1.cloudesf_testing_cloud_goog",
+				},
+			},
+		}, testConfigID, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		clusters, err := MakeClusters(fakeServiceInfo)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var cluster *v2pb.Cluster
+		for _, c := range clusters {
+			if c.Name == util.RateLimitServiceClusterName {
+				cluster = c
+			}
+		}
+		if !proto.Equal(cluster, tc.wantedCluster) {
+			t.Errorf("Test Desc(%s): MakeClusters\ngot rate limit service cluster: %v,\nwant: %v", tc.desc, cluster, tc.wantedCluster)
+		}
+	}
+}
@@ -15,9 +15,13 @@
 package configgenerator
 
 import (
+	"encoding/json"
 	"fmt"
+	"net/http"
+	"regexp"
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/GoogleCloudPlatform/esp-v2/src/go/options"
 	"github.com/GoogleCloudPlatform/esp-v2/src/go/util"
@@ -28,43 +32,196 @@ import (
 	bapb "github.com/GoogleCloudPlatform/esp-v2/src/go/proto/api/envoy/http/backend_auth"
 	brpb "github.com/GoogleCloudPlatform/esp-v2/src/go/proto/api/envoy/http/backend_routing"
 	commonpb "github.com/GoogleCloudPlatform/esp-v2/src/go/proto/api/envoy/http/common"
+	erpb "github.com/GoogleCloudPlatform/esp-v2/src/go/proto/api/envoy/http/error_response"
+	iapb "github.com/GoogleCloudPlatform/esp-v2/src/go/proto/api/envoy/http/ip_acl"
 	pmpb "github.com/GoogleCloudPlatform/esp-v2/src/go/proto/api/envoy/http/path_matcher"
+	rcpb "github.com/GoogleCloudPlatform/esp-v2/src/go/proto/api/envoy/http/response_cache"
 	scpb "github.com/GoogleCloudPlatform/esp-v2/src/go/proto/api/envoy/http/service_control"
 	v2pb "github.com/envoyproxy/go-control-plane/envoy/api/v2"
 	corepb "github.com/envoyproxy/go-control-plane/envoy/api/v2/core"
 	listenerpb "github.com/envoyproxy/go-control-plane/envoy/api/v2/listener"
 	routepb "github.com/envoyproxy/go-control-plane/envoy/api/v2/route"
+	alconfigpb "github.com/envoyproxy/go-control-plane/envoy/config/accesslog/v2"
+	accesslogpb "github.com/envoyproxy/go-control-plane/envoy/config/filter/accesslog/v2"
+	bufferpb "github.com/envoyproxy/go-control-plane/envoy/config/filter/http/buffer/v2"
+	extauthzpb "github.com/envoyproxy/go-control-plane/envoy/config/filter/http/ext_authz/v2"
+	faultpb "github.com/envoyproxy/go-control-plane/envoy/config/filter/http/fault/v2"
 	gspb "github.com/envoyproxy/go-control-plane/envoy/config/filter/http/grpc_stats/v2alpha"
+	gzippb "github.com/envoyproxy/go-control-plane/envoy/config/filter/http/gzip/v2"
 	hcpb "github.com/envoyproxy/go-control-plane/envoy/config/filter/http/health_check/v2"
 	jwtpb "github.com/envoyproxy/go-control-plane/envoy/config/filter/http/jwt_authn/v2alpha"
+	ratelimitpb "github.com/envoyproxy/go-control-plane/envoy/config/filter/http/rate_limit/v2"
+	rbacpb "github.com/envoyproxy/go-control-plane/envoy/config/filter/http/rbac/v2"
 	routerpb "github.com/envoyproxy/go-control-plane/envoy/config/filter/http/router/v2"
 	transcoderpb "github.com/envoyproxy/go-control-plane/envoy/config/filter/http/transcoder/v2"
 	hcmpb "github.com/envoyproxy/go-control-plane/envoy/config/filter/network/http_connection_manager/v2"
+	netrbacpb "github.com/envoyproxy/go-control-plane/envoy/config/filter/network/rbac/v2"
+	rlsconfpb "github.com/envoyproxy/go-control-plane/envoy/config/ratelimit/v2"
+	rbacconfigpb "github.com/envoyproxy/go-control-plane/envoy/config/rbac/v2"
+	typepb "github.com/envoyproxy/go-control-plane/envoy/type"
+	matcher "github.com/envoyproxy/go-control-plane/envoy/type/matcher"
 	anypb "github.com/golang/protobuf/ptypes/any"
 	durationpb "github.com/golang/protobuf/ptypes/duration"
+	structpb "github.com/golang/protobuf/ptypes/struct"
 	wrapperspb "github.com/golang/protobuf/ptypes/wrappers"
 	confpb "google.golang.org/genproto/googleapis/api/serviceconfig"
-	smpb "google.golang.org/genproto/googleapis/api/servicemanagement/v1"
 )
 
 const (
-	statPrefix = "ingress_http"
+	statPrefix           = "ingress_http"
+	clientCertStatPrefix = "client_cert."
+
+	acmeVirtualHostName = "acme"
 )
 
 // MakeListeners provides dynamic listeners for Envoy
 func MakeListeners(serviceInfo *sc.ServiceInfo) ([]*v2pb.Listener, error) {
-	listener, err := makeListener(serviceInfo)
+	httpFilters, err := makeHttpFilters(serviceInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	route, err := MakeRouteConfig(serviceInfo)
+	if err != nil {
+		return nil, fmt.Errorf("makeHttpConnectionManagerRouteConfig got err: %s", err)
+	}
+	return makeFrontListeners(serviceInfo.Options, httpFilters, route)
+}
+
+// MakeServiceDeletedListeners provides the listeners of a service deleted in
+// Service Management, answering all requests with 410 Gone. Only the Router
+// filter is kept, as the requests can no longer be checked or reported.
+func MakeServiceDeletedListeners(serviceInfo *sc.ServiceInfo) ([]*v2pb.Listener, error) {
+	route := &v2pb.RouteConfiguration{
+		Name: routeName,
+		VirtualHosts: []*routepb.VirtualHost{
+			{
+				Name:    virtualHostName,
+				Domains: []string{"*"},
+				Routes: []*routepb.Route{
+					{
+						Match: &routepb.RouteMatch{
+							PathSpecifier: &routepb.RouteMatch_Prefix{
+								Prefix: "/",
+							},
+						},
+						Action: &routepb.Route_DirectResponse{
+							DirectResponse: makeDirectResponseAction(&options.DirectResponseOptions{
+								Status: http.StatusGone,
+								Body:   fmt.Sprintf("service %s is deleted", serviceInfo.Name),
+							}),
+						},
+					},
+				},
+			},
+		},
+	}
+	return makeFrontListeners(serviceInfo.Options, []*hcmpb.HttpFilter{makeRouterFilter(serviceInfo.Options)}, route)
+}
+
+// makeFrontListeners provides the listener on --listener_port and, if HTTP/3
+// is enabled, the listener on --http3_port, advertised in the alt-svc headers
+// of the responses.
+func makeFrontListeners(opts options.ConfigGeneratorOptions, httpFilters []*hcmpb.HttpFilter, route *v2pb.RouteConfiguration) ([]*v2pb.Listener, error) {
+	if opts.Http3Port != 0 && opts.Http3AltSvcMaxAge != 0 {
+		route.ResponseHeadersToAdd = append(route.ResponseHeadersToAdd, &corepb.HeaderValueOption{
+			Header: &corepb.HeaderValue{
+				Key:   "alt-svc",
+				Value: fmt.Sprintf(`%s=":%d"; ma=%d`, util.Http3AlpnProtocol, opts.Http3Port, int64(opts.Http3AltSvcMaxAge.Seconds())),
+			},
+			Append: &wrapperspb.BoolValue{Value: false},
+		})
+	}
+
+	listener, err := makeListenerWithFilters(opts, httpFilters, route)
+	if err != nil {
+		return nil, err
+	}
+	listeners := []*v2pb.Listener{listener}
+	if opts.Http3Port != 0 {
+		http3Listener, err := makeHttp3Listener(opts, httpFilters, route)
+		if err != nil {
+			return nil, err
+		}
+		listeners = append(listeners, http3Listener)
+	}
+	if opts.SslServerAcmeDirectoryUrl != "" {
+		acmeListener, err := makeAcmeHttpListener(opts)
+		if err != nil {
+			return nil, err
+		}
+		listeners = append(listeners, acmeListener)
+	}
+	return listeners, nil
+}
+
+// makeAcmeHttpListener provides the plain HTTP listener on
+// --ssl_server_acme_http_port, serving the ACME HTTP-01 challenges from the
+// config manager and redirecting the other requests to HTTPS.
+func makeAcmeHttpListener(opts options.ConfigGeneratorOptions) (*v2pb.Listener, error) {
+	redirect := &routepb.RedirectAction{
+		SchemeRewriteSpecifier: &routepb.RedirectAction_HttpsRedirect{
+			HttpsRedirect: true,
+		},
+	}
+	if opts.ListenerPort != 443 {
+		redirect.PortRedirect = uint32(opts.ListenerPort)
+	}
+	route := &v2pb.RouteConfiguration{
+		Name: routeName,
+		VirtualHosts: []*routepb.VirtualHost{
+			{
+				Name:    acmeVirtualHostName,
+				Domains: []string{"*"},
+				Routes: []*routepb.Route{
+					{
+						Match: &routepb.RouteMatch{
+							PathSpecifier: &routepb.RouteMatch_Prefix{
+								Prefix: util.AcmeChallengePathPrefix,
+							},
+						},
+						Action: &routepb.Route_Route{
+							Route: &routepb.RouteAction{
+								ClusterSpecifier: &routepb.RouteAction_Cluster{
+									Cluster: util.AcmeChallengeClusterName,
+								},
+							},
+						},
+					},
+					{
+						Match: &routepb.RouteMatch{
+							PathSpecifier: &routepb.RouteMatch_Prefix{
+								Prefix: "/",
+							},
+						},
+						Action: &routepb.Route_Redirect{
+							Redirect: redirect,
+						},
+					},
+				},
+			},
+		},
+	}
+
+	opts.ListenerPort = opts.SslServerAcmeHttpPort
+	opts.SslServerCertPath = ""
+	opts.DisableTracing = true
+	opts.EnableWebsocket = false
+	opts.WebsocketSelectors = ""
+	listener, err := makeListenerWithFilters(opts, []*hcmpb.HttpFilter{makeRouterFilter(opts)}, route)
 	if err != nil {
 		return nil, err
 	}
-	return []*v2pb.Listener{listener}, nil
+	listener.Name = "acme_http_listener"
+	return listener, nil
 }
 
-// makeListener provides a dynamic listener for Envoy
-func makeListener(serviceInfo *sc.ServiceInfo) (*v2pb.Listener, error) {
+// makeHttpFilters provides the HTTP filters of the listener, in the order
+// they are applied to requests.
+func makeHttpFilters(serviceInfo *sc.ServiceInfo) ([]*hcmpb.HttpFilter, error) {
 	httpFilters := []*hcmpb.HttpFilter{}
 
-	if serviceInfo.Options.CorsPreset == "basic" || serviceInfo.Options.CorsPreset == "cors_with_regex" {
+	if serviceInfo.Options.CorsPreset == "basic" || serviceInfo.Options.CorsPreset == "cors_with_regex" || len(serviceInfo.Options.CorsPolicies) != 0 {
 		corsFilter := &hcmpb.HttpFilter{
 			Name: util.CORS,
 		}
@@ -73,6 +230,37 @@ func makeListener(serviceInfo *sc.ServiceInfo) (*v2pb.Listener, error) {
 		glog.Infof("adding CORS Filter config: %v", jsonStr)
 	}
 
+	// Add Gzip filter if the responses are compressed. It is before the other
+	// filters, so it compresses the responses as they are sent to the clients.
+	if gzipFilter := makeGzipFilter(serviceInfo); gzipFilter != nil {
+		httpFilters = append(httpFilters, gzipFilter)
+		jsonStr, _ := util.ProtoToJson(gzipFilter)
+		glog.Infof("adding Gzip Filter config: %v", jsonStr)
+	}
+
+	// Add Error Response filter if the error responses are rewritten. It is
+	// before the filters sending them, and after Gzip filter, so they are
+	// rewritten before they are compressed.
+	if errorResponseFilter := makeErrorResponseFilter(serviceInfo); errorResponseFilter != nil {
+		httpFilters = append(httpFilters, errorResponseFilter)
+		jsonStr, _ := util.ProtoToJson(errorResponseFilter)
+		glog.Infof("adding Error Response Filter config: %v", jsonStr)
+	}
+
+	// Add gRPC-Web filter next if gRPC-Web is enabled, after CORS filter for
+	// the preflight requests. The following filters see the gRPC requests,
+	// with their messages decoded from gRPC-Web text, and the gRPC trailers
+	// of the responses, before they are encoded in gRPC-Web.
+	if serviceInfo.Options.EnableGrpcWeb {
+		if !serviceInfo.GrpcSupportRequired {
+			return nil, fmt.Errorf("--enable_grpc_web requires a gRPC backend")
+		}
+		httpFilters = append(httpFilters, &hcmpb.HttpFilter{
+			Name: util.GRPCWeb,
+		})
+		glog.Infof("adding gRPC-Web Filter before the other filters")
+	}
+
 	// Add Path Matcher filter. The following filters rely on the dynamic
 	// metadata populated by Path Matcher filter.
 	// * Jwt Authentication filter
@@ -98,6 +286,15 @@ func makeListener(serviceInfo *sc.ServiceInfo) (*v2pb.Listener, error) {
 		glog.V(1).Infof("adding Healthz filter config: %v", jsonStr)
 	}
 
+	// Add IP ACL filter if needed. It must be behind Path Matcher filter, for
+	// the operations of the requests, and Health Check filter, so the health
+	// checks are not rejected.
+	if ipAclFilter := makeIpAclFilter(serviceInfo); ipAclFilter != nil {
+		httpFilters = append(httpFilters, ipAclFilter)
+		jsonStr, _ := util.ProtoToJson(ipAclFilter)
+		glog.Infof("adding IP ACL Filter config: %v", jsonStr)
+	}
+
 	// Add JWT Authn filter if needed.
 	if !serviceInfo.Options.SkipJwtAuthnFilter {
 		jwtAuthnFilter := makeJwtAuthnFilter(serviceInfo)
@@ -108,6 +305,40 @@ func makeListener(serviceInfo *sc.ServiceInfo) (*v2pb.Listener, error) {
 		}
 	}
 
+	// Add RBAC filter if the JWT audiences of some methods are matched on
+	// their routes. It must be after JWT Authn filter, which puts the JWT
+	// payloads in the dynamic metadata.
+	if rbacFilter := makeRbacFilter(serviceInfo); rbacFilter != nil {
+		httpFilters = append(httpFilters, rbacFilter)
+		jsonStr, _ := util.ProtoToJson(rbacFilter)
+		glog.Infof("adding RBAC Filter config: %v", jsonStr)
+	}
+
+	// Add Buffer filter if the request bodies are limited. It must be before
+	// Service Control filter, so too large requests are rejected before they
+	// are checked, and still reported.
+	if bufferFilter := makeBufferFilter(serviceInfo); bufferFilter != nil {
+		httpFilters = append(httpFilters, bufferFilter)
+		jsonStr, _ := util.ProtoToJson(bufferFilter)
+		glog.Infof("adding Buffer Filter config: %v", jsonStr)
+	}
+
+	// Add External Authorization filter if needed. It must be before Service
+	// Control filter, so the denied requests are not checked.
+	if extAuthzFilter := makeExtAuthzFilter(serviceInfo); extAuthzFilter != nil {
+		httpFilters = append(httpFilters, extAuthzFilter)
+		jsonStr, _ := util.ProtoToJson(extAuthzFilter)
+		glog.Infof("adding External Authorization Filter config: %v", jsonStr)
+	}
+
+	// Add Rate Limit filter if needed. It must be before Service Control
+	// filter, so the rejected requests are not checked.
+	if rateLimitFilter := makeRateLimitFilter(serviceInfo); rateLimitFilter != nil {
+		httpFilters = append(httpFilters, rateLimitFilter)
+		jsonStr, _ := util.ProtoToJson(rateLimitFilter)
+		glog.Infof("adding Rate Limit Filter config: %v", jsonStr)
+	}
+
 	// Add Service Control filter if needed.
 	if !serviceInfo.Options.SkipServiceControlFilter {
 		serviceControlFilter := makeServiceControlFilter(serviceInfo)
@@ -118,6 +349,19 @@ func makeListener(serviceInfo *sc.ServiceInfo) (*v2pb.Listener, error) {
 		}
 	}
 
+	// Add Response Cache filter if needed. It must be after Service Control
+	// filter, so the requests served from the cache are still checked and
+	// reported.
+	responseCacheFilter, err := makeResponseCacheFilter(serviceInfo)
+	if err != nil {
+		return nil, err
+	}
+	if responseCacheFilter != nil {
+		httpFilters = append(httpFilters, responseCacheFilter)
+		jsonStr, _ := util.ProtoToJson(responseCacheFilter)
+		glog.Infof("adding Response Cache Filter config: %v", jsonStr)
+	}
+
 	// Add gRPC Transcoder filter and gRPCWeb filter configs for gRPC backend.
 	if serviceInfo.GrpcSupportRequired {
 		transcoderFilter := makeTranscoderFilter(serviceInfo)
@@ -127,10 +371,12 @@ func makeListener(serviceInfo *sc.ServiceInfo) (*v2pb.Listener, error) {
 			glog.Infof("adding Transcoder Filter config: %v", jsonStr)
 		}
 
-		grpcWebFilter := &hcmpb.HttpFilter{
-			Name: util.GRPCWeb,
+		if !serviceInfo.Options.EnableGrpcWeb {
+			grpcWebFilter := &hcmpb.HttpFilter{
+				Name: util.GRPCWeb,
+			}
+			httpFilters = append(httpFilters, grpcWebFilter)
 		}
-		httpFilters = append(httpFilters, grpcWebFilter)
 
 		// GrpcStats filter is used to count gRPC frames.
 		// The data is stored in filterState and used by ServiceControl
@@ -157,17 +403,24 @@ func makeListener(serviceInfo *sc.ServiceInfo) (*v2pb.Listener, error) {
 		glog.Infof("adding Backend Routing Filter config: %v", jsonStr)
 	}
 
+	// Add Fault filter if faults are injected in the requests of some methods,
+	// by the per-route configs of their routes. It is right before the Router
+	// filter, so the faulted requests are still checked and reported, like
+	// the failures of the backend.
+	if faultFilter := makeFaultFilter(serviceInfo); faultFilter != nil {
+		httpFilters = append(httpFilters, faultFilter)
+		jsonStr, _ := util.ProtoToJson(faultFilter)
+		glog.Infof("adding Fault Filter config: %v", jsonStr)
+	}
+
 	// Add Envoy Router filter so requests are routed upstream.
 	// Router filter should be the last.
 	routerFilter := makeRouterFilter(serviceInfo.Options)
 	httpFilters = append(httpFilters, routerFilter)
+	return httpFilters, nil
+}
 
-	route, err := MakeRouteConfig(serviceInfo)
-
-	if err != nil {
-		return nil, fmt.Errorf("makeHttpConnectionManagerRouteConfig got err: %s", err)
-	}
-
+func makeHttpConnectionManager(opts options.ConfigGeneratorOptions, httpFilters []*hcmpb.HttpFilter, route *v2pb.RouteConfiguration) *hcmpb.HttpConnectionManager {
 	httpConMgr := &hcmpb.HttpConnectionManager{
 		CodecType:  hcmpb.HttpConnectionManager_AUTO,
 		StatPrefix: statPrefix,
@@ -175,16 +428,138 @@ func makeListener(serviceInfo *sc.ServiceInfo) (*v2pb.Listener, error) {
 			RouteConfig: route,
 		},
 
-		UseRemoteAddress:  &wrapperspb.BoolValue{Value: serviceInfo.Options.EnvoyUseRemoteAddress},
-		XffNumTrustedHops: uint32(serviceInfo.Options.EnvoyXffNumTrustedHops),
+		UseRemoteAddress:  &wrapperspb.BoolValue{Value: opts.EnvoyUseRemoteAddress},
+		XffNumTrustedHops: uint32(opts.EnvoyXffNumTrustedHops),
+	}
+	// With trusted proxies, the downstream remote address is the peer, the
+	// first one checked by IP ACL filter.
+	if opts.TrustedProxyCidrs != "" {
+		httpConMgr.UseRemoteAddress = &wrapperspb.BoolValue{Value: true}
+		httpConMgr.XffNumTrustedHops = 0
+	}
+	switch opts.RequestIdMode {
+	case "preserve":
+		httpConMgr.PreserveExternalRequestId = true
+	case "none":
+		httpConMgr.GenerateRequestId = &wrapperspb.BoolValue{Value: false}
 	}
-	if !serviceInfo.Options.DisableTracing {
+	if !opts.DisableTracing {
 		httpConMgr.Tracing = &hcmpb.HttpConnectionManager_Tracing{}
+		// Envoy forces the tracing of all the requests with the
+		// x-client-trace-id header by default.
+		if opts.TracingClientSampleRate != 1.0 {
+			httpConMgr.Tracing.ClientSampling = &typepb.Percent{Value: opts.TracingClientSampleRate * 100}
+		}
+	}
+	// WebSocket upgrades must be listed here to be allowed per route, but only
+	// allowed on all routes with --enable_websocket.
+	if opts.EnableWebsocket || opts.WebsocketSelectors != "" {
+		httpConMgr.UpgradeConfigs = []*hcmpb.HttpConnectionManager_UpgradeConfig{
+			{
+				UpgradeType: util.WebsocketUpgradeType,
+				Enabled:     &wrapperspb.BoolValue{Value: opts.EnableWebsocket},
+			},
+		}
+	}
+	// The x-forwarded-client-cert header from the clients is always removed,
+	// and only set from the client certificate with
+	// --ssl_server_forward_client_cert.
+	if opts.SslServerForwardClientCert {
+		httpConMgr.ForwardClientCertDetails = hcmpb.HttpConnectionManager_SANITIZE_SET
+		httpConMgr.SetCurrentClientCertDetails = &hcmpb.HttpConnectionManager_SetCurrentClientCertDetails{
+			Subject: &wrapperspb.BoolValue{Value: true},
+			Uri:     true,
+			Dns:     true,
+		}
 	}
 
+	httpConMgr.AccessLog = makeAccessLogs(opts)
+
 	jsonStr, _ := util.ProtoToJson(httpConMgr)
 	glog.Infof("adding Http Connection Manager config: %v", jsonStr)
 	httpConMgr.HttpFilters = httpFilters
+	return httpConMgr
+}
+
+// makeAccessLogs makes the access logs of the requests, written to
+// --access_log_path and sent to the gRPC Access Log Service in
+// --access_log_service_uri. The requests with the AccessLogDisabledHeader,
+// added by the routes of the operations in --access_log_disabled_selectors,
+// are not logged.
+func makeAccessLogs(opts options.ConfigGeneratorOptions) []*accesslogpb.AccessLog {
+	var accessLogs []*accesslogpb.AccessLog
+	if opts.AccessLogPath != "" {
+		fileAccessLog := &alconfigpb.FileAccessLog{
+			Path: opts.AccessLogPath,
+		}
+		if opts.AccessLogFormat != "" {
+			fileAccessLog.AccessLogFormat = &alconfigpb.FileAccessLog_Format{
+				Format: opts.AccessLogFormat,
+			}
+		} else if opts.AccessLogJsonFormat != "" {
+			// The fields were checked to be strings by the flags.
+			var fields map[string]string
+			_ = json.Unmarshal([]byte(opts.AccessLogJsonFormat), &fields)
+			jsonFormat := &structpb.Struct{
+				Fields: make(map[string]*structpb.Value),
+			}
+			if _, ok := fields[util.RequestIdField]; opts.LogRequestId && !ok {
+				fields[util.RequestIdField] = "%REQ(X-REQUEST-ID)%"
+			}
+			for name, format := range fields {
+				jsonFormat.Fields[name] = &structpb.Value{
+					Kind: &structpb.Value_StringValue{StringValue: format},
+				}
+			}
+			fileAccessLog.AccessLogFormat = &alconfigpb.FileAccessLog_JsonFormat{
+				JsonFormat: jsonFormat,
+			}
+		}
+		a, _ := ptypes.MarshalAny(fileAccessLog)
+		accessLogs = append(accessLogs, &accesslogpb.AccessLog{
+			Name:       util.FileAccessLog,
+			ConfigType: &accesslogpb.AccessLog_TypedConfig{TypedConfig: a},
+		})
+	}
+	if opts.AccessLogServiceUri != "" {
+		a, _ := ptypes.MarshalAny(&alconfigpb.HttpGrpcAccessLogConfig{
+			CommonConfig: &alconfigpb.CommonGrpcAccessLogConfig{
+				LogName: opts.AccessLogServiceLogName,
+				GrpcService: &corepb.GrpcService{
+					TargetSpecifier: &corepb.GrpcService_EnvoyGrpc_{
+						EnvoyGrpc: &corepb.GrpcService_EnvoyGrpc{
+							ClusterName: util.AccessLogServiceClusterName,
+						},
+					},
+				},
+			},
+		})
+		accessLogs = append(accessLogs, &accesslogpb.AccessLog{
+			Name:       util.HttpGrpcAccessLog,
+			ConfigType: &accesslogpb.AccessLog_TypedConfig{TypedConfig: a},
+		})
+	}
+
+	if opts.AccessLogDisabledSelectors != "" {
+		for _, accessLog := range accessLogs {
+			accessLog.Filter = &accesslogpb.AccessLogFilter{
+				FilterSpecifier: &accesslogpb.AccessLogFilter_HeaderFilter{
+					HeaderFilter: &accesslogpb.HeaderFilter{
+						Header: &routepb.HeaderMatcher{
+							Name:                 util.AccessLogDisabledHeader,
+							HeaderMatchSpecifier: &routepb.HeaderMatcher_PresentMatch{PresentMatch: true},
+							InvertMatch:          true,
+						},
+					},
+				},
+			}
+		}
+	}
+	return accessLogs
+}
+
+func makeListenerWithFilters(opts options.ConfigGeneratorOptions, httpFilters []*hcmpb.HttpFilter, route *v2pb.RouteConfiguration) (*v2pb.Listener, error) {
+	httpConMgr := makeHttpConnectionManager(opts, httpFilters, route)
 
 	// HTTP filter configuration
 	httpFilterConfig, err := ptypes.MarshalAny(httpConMgr)
@@ -202,29 +577,166 @@ func makeListener(serviceInfo *sc.ServiceInfo) (*v2pb.Listener, error) {
 	}
 
 	listenerName := "http_listener"
-	if serviceInfo.Options.SslServerCertPath != "" {
+	if opts.SslServerCertPath != "" {
 		listenerName = "https_listener"
-		transportSocket, err := util.CreateDownstreamTransportSocket(
-			serviceInfo.Options.SslServerCertPath)
+		var transportSocket *corepb.TransportSocket
+		if opts.SslServerCertSds {
+			transportSocket, err = util.CreateSdsDownstreamTransportSocket(
+				opts.SslServerClientCaPath, opts.SslServerRequireClientCert)
+		} else {
+			transportSocket, err = util.CreateDownstreamTransportSocket(
+				opts.SslServerCertPath, opts.SslServerClientCaPath, opts.SslServerRequireClientCert)
+		}
 		if err != nil {
 			return nil, err
 		}
 		filterChain.TransportSocket = transportSocket
+
+		if rbacFilter := makeClientCertRbacFilter(opts); rbacFilter != nil {
+			filterChain.Filters = append([]*listenerpb.Filter{rbacFilter}, filterChain.Filters...)
+			jsonStr, _ := util.ProtoToJson(rbacFilter)
+			glog.Infof("adding client certificate RBAC Filter config: %v", jsonStr)
+		}
+	}
+	filterChains := []*listenerpb.FilterChain{filterChain}
+	sniFilterChains, err := makeSniFilterChains(opts, filterChain.Filters)
+	if err != nil {
+		return nil, err
+	}
+	filterChains = append(filterChains, sniFilterChains...)
+
+	listener := &v2pb.Listener{
+		Name:         listenerName,
+		Address:      makeListenerAddress(opts.ListenerAddress, opts.ListenerPort),
+		FilterChains: filterChains,
+	}
+	// The PROXY protocol header is read before the TLS handshake.
+	if opts.EnableProxyProtocol {
+		listener.ListenerFilters = append(listener.ListenerFilters, &listenerpb.ListenerFilter{
+			Name: util.ProxyProtocol,
+		})
+	}
+	// The TLS inspector detects the server names matched by the filter
+	// chains of the SNI certificates.
+	if len(sniFilterChains) > 0 {
+		listener.ListenerFilters = append(listener.ListenerFilters, &listenerpb.ListenerFilter{
+			Name: util.TLSInspector,
+		})
+	}
+	return listener, nil
+}
+
+// makeListenerAddress makes the address of the listener on address and port,
+// or on the Unix domain socket of address, like unix:/var/run/esp.sock. The
+// IPv6 wildcard address :: also accepts IPv4 connections, in dual-stack.
+func makeListenerAddress(address string, port int) *corepb.Address {
+	if strings.HasPrefix(address, util.UnixSocketScheme) {
+		if _, path, ok := util.ParseUnixSocketURI(address); ok {
+			return &corepb.Address{
+				Address: &corepb.Address_Pipe{
+					Pipe: &corepb.Pipe{
+						Path: path,
+					},
+				},
+			}
+		}
+	}
+	return &corepb.Address{
+		Address: &corepb.Address_SocketAddress{
+			SocketAddress: &corepb.SocketAddress{
+				Address: address,
+				PortSpecifier: &corepb.SocketAddress_PortValue{
+					PortValue: uint32(port),
+				},
+				Ipv4Compat: address == "::",
+			},
+		},
+	}
+}
+
+// makeSniFilterChains makes a filter chain for each certificate in
+// --ssl_server_sni_config, matching its server names, with the same network
+// filters. The other connections use the certificate in --ssl_server_cert_path.
+func makeSniFilterChains(opts options.ConfigGeneratorOptions, filters []*listenerpb.Filter) ([]*listenerpb.FilterChain, error) {
+	if opts.SslServerCertPath == "" {
+		return nil, nil
+	}
+	var filterChains []*listenerpb.FilterChain
+	for _, sni := range opts.SslServerSniCertificates {
+		transportSocket, err := util.CreateSniDownstreamTransportSocket(
+			sni.CertPath, sni.KeyPath, opts.SslServerClientCaPath, opts.SslServerRequireClientCert)
+		if err != nil {
+			return nil, fmt.Errorf("fail to make transport socket for server names %v: %v", sni.ServerNames, err)
+		}
+		filterChains = append(filterChains, &listenerpb.FilterChain{
+			FilterChainMatch: &listenerpb.FilterChainMatch{
+				ServerNames: sni.ServerNames,
+			},
+			Filters:         filters,
+			TransportSocket: transportSocket,
+		})
+	}
+	return filterChains, nil
+}
+
+// makeHttp3Listener provides the listener serving HTTP/3 over QUIC on the UDP
+// port --http3_port, with the same HTTP filters and routes.
+func makeHttp3Listener(opts options.ConfigGeneratorOptions, httpFilters []*hcmpb.HttpFilter, route *v2pb.RouteConfiguration) (*v2pb.Listener, error) {
+	sslServerCertPath := opts.Http3SslServerCertPath
+	if sslServerCertPath == "" {
+		sslServerCertPath = opts.SslServerCertPath
+	}
+	if sslServerCertPath == "" {
+		return nil, fmt.Errorf("--http3_port requires --http3_ssl_server_cert_path or --ssl_server_cert_path, as QUIC is always encrypted")
+	}
+
+	httpConMgr := makeHttpConnectionManager(opts, httpFilters, route)
+	httpConMgr.CodecType = hcmpb.HttpConnectionManager_HTTP3
+	// WebSocket upgrades are not supported over HTTP/3.
+	httpConMgr.UpgradeConfigs = nil
+	httpFilterConfig, err := ptypes.MarshalAny(httpConMgr)
+	if err != nil {
+		return nil, err
+	}
+	transportSocket, err := util.CreateQuicDownstreamTransportSocket(sslServerCertPath)
+	if err != nil {
+		return nil, err
+	}
+	quicOptions, err := ptypes.MarshalAny(&listenerpb.QuicProtocolOptions{})
+	if err != nil {
+		return nil, err
 	}
 
 	return &v2pb.Listener{
-		Name: listenerName,
+		Name: "http3_listener",
 		Address: &corepb.Address{
 			Address: &corepb.Address_SocketAddress{
 				SocketAddress: &corepb.SocketAddress{
-					Address: serviceInfo.Options.ListenerAddress,
+					Protocol: corepb.SocketAddress_UDP,
+					Address:  opts.ListenerAddress,
 					PortSpecifier: &corepb.SocketAddress_PortValue{
-						PortValue: uint32(serviceInfo.Options.ListenerPort),
+						PortValue: uint32(opts.Http3Port),
+					},
+				},
+			},
+		},
+		FilterChains: []*listenerpb.FilterChain{
+			{
+				Filters: []*listenerpb.Filter{
+					{
+						Name:       util.HTTPConnectionManager,
+						ConfigType: &listenerpb.Filter_TypedConfig{TypedConfig: httpFilterConfig},
 					},
 				},
+				TransportSocket: transportSocket,
+			},
+		},
+		UdpListenerConfig: &listenerpb.UdpListenerConfig{
+			UdpListenerName: util.QuicListenerName,
+			ConfigType: &listenerpb.UdpListenerConfig_TypedConfig{
+				TypedConfig: quicOptions,
 			},
 		},
-		FilterChains: []*listenerpb.FilterChain{filterChain},
 	}, nil
 }
 
@@ -255,6 +767,7 @@ func makePathMatcherFilter(serviceInfo *sc.ServiceInfo) *hcmpb.HttpFilter {
 	if len(serviceInfo.SegmentNames) > 0 {
 		pathMathcherConfig.SegmentNames = serviceInfo.SegmentNames
 	}
+	pathMathcherConfig.UnmatchedPath = makeUnmatchedPath(serviceInfo.Options)
 
 	pathMathcherConfigStruct, _ := ptypes.MarshalAny(pathMathcherConfig)
 	pathMatcherFilter := &hcmpb.HttpFilter{
@@ -264,6 +777,103 @@ func makePathMatcherFilter(serviceInfo *sc.ServiceInfo) *hcmpb.HttpFilter {
 	return pathMatcherFilter
 }
 
+// makeErrorResponseFilter makes the Error Response filter rewriting the error
+// responses of the proxy, nil if they are not rewritten.
+func makeErrorResponseFilter(serviceInfo *sc.ServiceInfo) *hcmpb.HttpFilter {
+	opts := serviceInfo.Options
+	if opts.ErrorResponseFormat == "" && len(opts.ErrorResponseTemplates) == 0 {
+		return nil
+	}
+	errorResponseConfig := &erpb.FilterConfig{}
+	switch opts.ErrorResponseFormat {
+	case "json":
+		errorResponseConfig.Format = erpb.FilterConfig_JSON
+	case "problem_json":
+		errorResponseConfig.Format = erpb.FilterConfig_PROBLEM_JSON
+	}
+	for _, t := range opts.ErrorResponseTemplates {
+		errorResponseConfig.Templates = append(errorResponseConfig.Templates, &erpb.ErrorTemplate{
+			Status:      t.Status,
+			Body:        t.Body,
+			ContentType: t.ContentType,
+		})
+	}
+
+	errorResponseConfigStruct, _ := ptypes.MarshalAny(errorResponseConfig)
+	return &hcmpb.HttpFilter{
+		Name:       util.ErrorResponse,
+		ConfigType: &hcmpb.HttpFilter_TypedConfig{errorResponseConfigStruct},
+	}
+}
+
+// makeIpAclFilter makes the IP ACL filter rejecting the requests from the
+// client addresses not allowed by the IP ACL of their method, and taking the
+// client addresses behind the trusted proxies, nil if there are neither.
+func makeIpAclFilter(serviceInfo *sc.ServiceInfo) *hcmpb.HttpFilter {
+	ipAclConfig := &iapb.FilterConfig{}
+	if serviceInfo.Options.TrustedProxyCidrs != "" {
+		for _, cidr := range strings.Split(serviceInfo.Options.TrustedProxyCidrs, ",") {
+			ipAclConfig.TrustedProxies = append(ipAclConfig.TrustedProxies, strings.TrimSpace(cidr))
+		}
+	}
+	if serviceInfo.GlobalIpAcl != nil {
+		ipAclConfig.DefaultRule = makeIpRule(serviceInfo.GlobalIpAcl)
+	}
+	for _, operation := range serviceInfo.Operations {
+		method := serviceInfo.Methods[operation]
+		if method.IpAcl == nil {
+			continue
+		}
+		ipAclConfig.OperationRules = append(ipAclConfig.OperationRules, &iapb.OperationRule{
+			Operation: operation,
+			Rule:      makeIpRule(method.IpAcl),
+		})
+	}
+	if ipAclConfig.DefaultRule == nil && len(ipAclConfig.OperationRules) == 0 && len(ipAclConfig.TrustedProxies) == 0 {
+		return nil
+	}
+
+	ipAclConfigStruct, _ := ptypes.MarshalAny(ipAclConfig)
+	return &hcmpb.HttpFilter{
+		Name:       util.IpAcl,
+		ConfigType: &hcmpb.HttpFilter_TypedConfig{ipAclConfigStruct},
+	}
+}
+
+func makeIpRule(o *options.IpAclOptions) *iapb.IpRule {
+	return &iapb.IpRule{
+		Allow: o.Allow,
+		Deny:  o.Deny,
+	}
+}
+
+// makeUnmatchedPath makes the handling of the requests whose path does not
+// match any operation, nil to reject them with the default 404.
+func makeUnmatchedPath(opts options.ConfigGeneratorOptions) *pmpb.UnmatchedPath {
+	switch {
+	case opts.UnmatchedPathBody != "":
+		return &pmpb.UnmatchedPath{
+			Action: &pmpb.UnmatchedPath_NotFoundBody{
+				NotFoundBody: opts.UnmatchedPathBody,
+			},
+			ContentType: opts.UnmatchedPathContentType,
+		}
+	case opts.UnmatchedPathRedirectUrl != "":
+		return &pmpb.UnmatchedPath{
+			Action: &pmpb.UnmatchedPath_RedirectUrl{
+				RedirectUrl: opts.UnmatchedPathRedirectUrl,
+			},
+		}
+	case opts.UnmatchedPathToBackend:
+		return &pmpb.UnmatchedPath{
+			Action: &pmpb.UnmatchedPath_PassThrough{
+				PassThrough: true,
+			},
+		}
+	}
+	return nil
+}
+
 func makeGrpcStatsFilter() *hcmpb.HttpFilter {
 	cfg := &gspb.FilterConfig{
 		EmitFilterState: true,
@@ -276,6 +886,66 @@ func makeGrpcStatsFilter() *hcmpb.HttpFilter {
 	}
 }
 
+// makeGzipFilter makes the Gzip filter compressing the responses, nil if they
+// are not compressed.
+func makeGzipFilter(serviceInfo *sc.ServiceInfo) *hcmpb.HttpFilter {
+	if !serviceInfo.Options.EnableResponseCompression {
+		return nil
+	}
+	gzip := &gzippb.Gzip{
+		ContentLength: &wrapperspb.UInt32Value{Value: serviceInfo.Options.ResponseCompressionMinContentLength},
+	}
+	switch serviceInfo.Options.ResponseCompressionLevel {
+	case "best":
+		gzip.CompressionLevel = gzippb.Gzip_CompressionLevel_BEST
+	case "speed":
+		gzip.CompressionLevel = gzippb.Gzip_CompressionLevel_SPEED
+	}
+	if serviceInfo.Options.ResponseCompressionContentTypes != "" {
+		for _, contentType := range strings.Split(serviceInfo.Options.ResponseCompressionContentTypes, ",") {
+			gzip.ContentType = append(gzip.ContentType, strings.TrimSpace(contentType))
+		}
+	}
+	a, _ := ptypes.MarshalAny(gzip)
+	return &hcmpb.HttpFilter{
+		Name:       util.Gzip,
+		ConfigType: &hcmpb.HttpFilter_TypedConfig{TypedConfig: a},
+	}
+}
+
+// makeBufferFilter makes the Buffer filter rejecting the requests with larger
+// bodies than bufferFilterMaxBytes, nil if the request bodies are unlimited.
+func makeBufferFilter(serviceInfo *sc.ServiceInfo) *hcmpb.HttpFilter {
+	maxBytes := bufferFilterMaxBytes(serviceInfo)
+	if maxBytes == 0 {
+		return nil
+	}
+	buffer, _ := ptypes.MarshalAny(&bufferpb.Buffer{
+		MaxRequestBytes: &wrapperspb.UInt32Value{Value: maxBytes},
+	})
+	return &hcmpb.HttpFilter{
+		Name:       util.Buffer,
+		ConfigType: &hcmpb.HttpFilter_TypedConfig{TypedConfig: buffer},
+	}
+}
+
+// bufferFilterMaxBytes returns the maximum size of the request bodies in the
+// Buffer filter, overridden by the routes of the methods with their own one.
+// If only some methods are limited, the filter is disabled on the virtual host
+// and its maximum size is unused.
+func bufferFilterMaxBytes(serviceInfo *sc.ServiceInfo) uint32 {
+	maxBytes := serviceInfo.Options.MaxRequestBodyBytes
+	if maxBytes != 0 {
+		return maxBytes
+	}
+	for _, method := range serviceInfo.Methods {
+		if method.MaxRequestBodyBytes > maxBytes {
+			maxBytes = method.MaxRequestBodyBytes
+		}
+	}
+	return maxBytes
+}
+
 func hasPathParameter(httpPattern string) bool {
 	return strings.ContainsRune(httpPattern, '{')
 }
@@ -325,33 +995,17 @@ func makeJwtAuthnFilter(serviceInfo *sc.ServiceInfo) *hcmpb.HttpFilter {
 	}
 	providers := make(map[string]*jwtpb.JwtProvider)
 	for _, provider := range auth.GetProviders() {
-		clusterName, err := util.ExtraAddressFromURI(provider.GetJwksUri())
-		if err != nil {
-			return nil
-		}
-
 		fromHeaders, fromParams := processJwtLocations(provider)
 
 		jp := &jwtpb.JwtProvider{
-			Issuer: provider.GetIssuer(),
-			JwksSourceSpecifier: &jwtpb.JwtProvider_RemoteJwks{
-				RemoteJwks: &jwtpb.RemoteJwks{
-					HttpUri: &corepb.HttpUri{
-						Uri: provider.GetJwksUri(),
-						HttpUpstreamType: &corepb.HttpUri_Cluster{
-							Cluster: clusterName,
-						},
-						Timeout: ptypes.DurationProto(serviceInfo.Options.HttpRequestTimeout),
-					},
-					CacheDuration: &durationpb.Duration{
-						Seconds: int64(serviceInfo.Options.JwksCacheDurationInS),
-					},
-				},
-			},
+			Issuer:               provider.GetIssuer(),
 			FromHeaders:          fromHeaders,
 			FromParams:           fromParams,
 			ForwardPayloadHeader: "X-Endpoint-API-UserInfo",
 		}
+		if err := setJwksSource(serviceInfo, provider, jp); err != nil {
+			return nil
+		}
 
 		if len(provider.GetAudiences()) != 0 {
 			for _, a := range strings.Split(provider.GetAudiences(), ",") {
@@ -364,6 +1018,15 @@ func makeJwtAuthnFilter(serviceInfo *sc.ServiceInfo) *hcmpb.HttpFilter {
 			defaultAudience := fmt.Sprintf("https://%v", serviceInfo.Name)
 			jp.Audiences = append(jp.Audiences, defaultAudience)
 		}
+		// The JWT Authn filter only matches exact audiences. The audiences of
+		// the providers with wildcard or regex ones are matched on the routes
+		// by the RBAC filter instead.
+		for _, a := range jp.Audiences {
+			if util.IsJwtAudiencePattern(a) {
+				jp.Audiences = nil
+				break
+			}
+		}
 
 		// TODO(taoxuy): add unit test
 		// the JWT Payload will be send to metadata by envoy and it will be used by service control filter
@@ -382,6 +1045,14 @@ func makeJwtAuthnFilter(serviceInfo *sc.ServiceInfo) *hcmpb.HttpFilter {
 			requirements[rule.GetSelector()] = makeJwtRequirement(rule.GetRequirements())
 		}
 	}
+	// The generated OPTIONS operations requiring authentication require the
+	// JWT of their operation.
+	for _, operation := range serviceInfo.Operations {
+		method := serviceInfo.Methods[operation]
+		if requirement, ok := requirements[method.PreflightOperation]; ok {
+			requirements[operation] = requirement
+		}
+	}
 
 	jwtAuthentication := &jwtpb.JwtAuthentication{
 		Providers: providers,
@@ -399,6 +1070,210 @@ func makeJwtAuthnFilter(serviceInfo *sc.ServiceInfo) *hcmpb.HttpFilter {
 	return jwtAuthnFilter
 }
 
+// setJwksSource sets the JWKS source of a JWT provider, either its local JWKS
+// inline, so that changes are pushed to Envoy, or its remote jwks_uri.
+func setJwksSource(serviceInfo *sc.ServiceInfo, provider *confpb.AuthProvider, jp *jwtpb.JwtProvider) error {
+	if jwks, ok := serviceInfo.LocalJwks[provider.GetId()]; ok {
+		jp.JwksSourceSpecifier = &jwtpb.JwtProvider_LocalJwks{
+			LocalJwks: &corepb.DataSource{
+				Specifier: &corepb.DataSource_InlineString{
+					InlineString: jwks,
+				},
+			},
+		}
+		return nil
+	}
+
+	clusterName, err := util.ExtraAddressFromURI(provider.GetJwksUri())
+	if err != nil {
+		return err
+	}
+	jp.JwksSourceSpecifier = &jwtpb.JwtProvider_RemoteJwks{
+		RemoteJwks: &jwtpb.RemoteJwks{
+			HttpUri: &corepb.HttpUri{
+				Uri: provider.GetJwksUri(),
+				HttpUpstreamType: &corepb.HttpUri_Cluster{
+					Cluster: clusterName,
+				},
+				Timeout: ptypes.DurationProto(serviceInfo.Options.HttpRequestTimeout),
+			},
+			CacheDuration: &durationpb.Duration{
+				Seconds: int64(serviceInfo.RemoteJwks[provider.GetId()].CacheDuration / time.Second),
+			},
+		},
+	}
+	return nil
+}
+
+// makeRbacFilter makes the RBAC filter matching the JWT audiences and the
+// authorization policies on the routes of the methods, nil if no method needs
+// it. It allows all requests by
+// default.
+func makeRbacFilter(serviceInfo *sc.ServiceInfo) *hcmpb.HttpFilter {
+	if serviceInfo.Options.SkipJwtAuthnFilter {
+		return nil
+	}
+	for _, method := range serviceInfo.Methods {
+		if len(method.JwtAudiences) != 0 || len(method.AuthorizationPolicies) != 0 {
+			rbac, _ := ptypes.MarshalAny(&rbacpb.RBAC{})
+			return &hcmpb.HttpFilter{
+				Name:       util.RBAC,
+				ConfigType: &hcmpb.HttpFilter_TypedConfig{rbac},
+			}
+		}
+	}
+	return nil
+}
+
+// makeClientCertRbacFilter makes the RBAC network filter rejecting the
+// connections whose client certificate has no SPIFFE ID in
+// --ssl_server_client_spiffe_trust_domain, nil if not set. Without
+// --ssl_server_require_client_cert, the connections without client
+// certificate, whose principal is empty, are allowed.
+func makeClientCertRbacFilter(opts options.ConfigGeneratorOptions) *listenerpb.Filter {
+	if opts.SslServerClientSpiffeTrustDomain == "" {
+		return nil
+	}
+	principals := []*rbacconfigpb.Principal{
+		{
+			Identifier: &rbacconfigpb.Principal_Authenticated_{
+				Authenticated: &rbacconfigpb.Principal_Authenticated{
+					PrincipalName: &matcher.StringMatcher{
+						MatchPattern: &matcher.StringMatcher_Prefix{
+							Prefix: fmt.Sprintf("%s%s/", util.SpiffeIdPrefix, opts.SslServerClientSpiffeTrustDomain),
+						},
+					},
+				},
+			},
+		},
+	}
+	if !opts.SslServerRequireClientCert {
+		principals = append(principals, &rbacconfigpb.Principal{
+			Identifier: &rbacconfigpb.Principal_Authenticated_{
+				Authenticated: &rbacconfigpb.Principal_Authenticated{
+					PrincipalName: &matcher.StringMatcher{
+						MatchPattern: &matcher.StringMatcher_Exact{
+							Exact: "",
+						},
+					},
+				},
+			},
+		})
+	}
+
+	rbac, _ := ptypes.MarshalAny(&netrbacpb.RBAC{
+		StatPrefix: clientCertStatPrefix,
+		Rules: &rbacconfigpb.RBAC{
+			Action: rbacconfigpb.RBAC_ALLOW,
+			Policies: map[string]*rbacconfigpb.Policy{
+				"spiffe_trust_domain": {
+					Permissions: []*rbacconfigpb.Permission{
+						{
+							Rule: &rbacconfigpb.Permission_Any{
+								Any: true,
+							},
+						},
+					},
+					Principals: principals,
+				},
+			},
+		},
+	})
+	return &listenerpb.Filter{
+		Name:       util.NetworkRBAC,
+		ConfigType: &listenerpb.Filter_TypedConfig{TypedConfig: rbac},
+	}
+}
+
+// makeExtAuthzFilter makes the External Authorization filter checking the
+// requests with the server in --ext_authz_uri, nil if not set.
+func makeExtAuthzFilter(serviceInfo *sc.ServiceInfo) *hcmpb.HttpFilter {
+	c := serviceInfo.ExtAuthzCluster
+	if c == nil {
+		return nil
+	}
+	extAuthz := &extauthzpb.ExtAuthz{
+		FailureModeAllow: serviceInfo.Options.ExtAuthzFailureModeAllow,
+	}
+	if c.Protocol == util.GRPC {
+		extAuthz.Services = &extauthzpb.ExtAuthz_GrpcService{
+			GrpcService: &corepb.GrpcService{
+				TargetSpecifier: &corepb.GrpcService_EnvoyGrpc_{
+					EnvoyGrpc: &corepb.GrpcService_EnvoyGrpc{
+						ClusterName: c.ClusterName,
+					},
+				},
+				Timeout: ptypes.DurationProto(serviceInfo.Options.ExtAuthzTimeout),
+			},
+		}
+	} else {
+		scheme := "http"
+		if c.UseTLS {
+			scheme = "https"
+		}
+		extAuthz.Services = &extauthzpb.ExtAuthz_HttpService{
+			HttpService: &extauthzpb.HttpService{
+				ServerUri: &corepb.HttpUri{
+					Uri: fmt.Sprintf("%s://%s:%d%s", scheme, c.Hostname, c.Port, serviceInfo.ExtAuthzPathPrefix),
+					HttpUpstreamType: &corepb.HttpUri_Cluster{
+						Cluster: c.ClusterName,
+					},
+					Timeout: ptypes.DurationProto(serviceInfo.Options.ExtAuthzTimeout),
+				},
+				PathPrefix: serviceInfo.ExtAuthzPathPrefix,
+			},
+		}
+	}
+	a, _ := ptypes.MarshalAny(extAuthz)
+	return &hcmpb.HttpFilter{
+		Name:       util.ExtAuthz,
+		ConfigType: &hcmpb.HttpFilter_TypedConfig{a},
+	}
+}
+
+// makeRateLimitFilter makes the Rate Limit filter calling the rate limit
+// service in --rate_limit_service_uri, nil if not set. The service name is
+// the domain of the descriptors.
+func makeRateLimitFilter(serviceInfo *sc.ServiceInfo) *hcmpb.HttpFilter {
+	if serviceInfo.RateLimitServiceCluster == nil {
+		return nil
+	}
+	rateLimit := &ratelimitpb.RateLimit{
+		Domain:          serviceInfo.Name,
+		Timeout:         ptypes.DurationProto(serviceInfo.Options.RateLimitServiceTimeout),
+		FailureModeDeny: serviceInfo.Options.RateLimitServiceFailureModeDeny,
+		RateLimitService: &rlsconfpb.RateLimitServiceConfig{
+			GrpcService: &corepb.GrpcService{
+				TargetSpecifier: &corepb.GrpcService_EnvoyGrpc_{
+					EnvoyGrpc: &corepb.GrpcService_EnvoyGrpc{
+						ClusterName: serviceInfo.RateLimitServiceCluster.ClusterName,
+					},
+				},
+			},
+		},
+	}
+	a, _ := ptypes.MarshalAny(rateLimit)
+	return &hcmpb.HttpFilter{
+		Name:       util.RateLimit,
+		ConfigType: &hcmpb.HttpFilter_TypedConfig{a},
+	}
+}
+
+// makeFaultFilter makes the Fault filter, injecting no faults by itself, nil if
+// no method has fault injections.
+func makeFaultFilter(serviceInfo *sc.ServiceInfo) *hcmpb.HttpFilter {
+	for _, method := range serviceInfo.Methods {
+		if method.FaultInjection != nil {
+			a, _ := ptypes.MarshalAny(&faultpb.HTTPFault{})
+			return &hcmpb.HttpFilter{
+				Name:       util.Fault,
+				ConfigType: &hcmpb.HttpFilter_TypedConfig{TypedConfig: a},
+			}
+		}
+	}
+	return nil
+}
+
 func makeJwtRequirement(requirements []*confpb.AuthRequirement) *jwtpb.JwtRequirement {
 	// By default, if there are multi requirements, treat it as RequireAny.
 	requires := &jwtpb.JwtRequirement{
@@ -408,25 +1283,17 @@ func makeJwtRequirement(requirements []*confpb.AuthRequirement) *jwtpb.JwtRequir
 	}
 
 	for _, r := range requirements {
-		var require *jwtpb.JwtRequirement
-		if r.GetAudiences() == "" {
-			require = &jwtpb.JwtRequirement{
-				RequiresType: &jwtpb.JwtRequirement_ProviderName{
-					ProviderName: r.GetProviderId(),
-				},
-			}
-		} else {
-			// Note: Audiences in requirements is deprecated.
-			// But if it's specified, we should override the audiences for the provider.
-			var audiences []string
-			for _, a := range strings.Split(r.GetAudiences(), ",") {
-				audiences = append(audiences, strings.TrimSpace(a))
-			}
+		// A requirement listing several providers requires all of them.
+		var providerRequires []*jwtpb.JwtRequirement
+		for _, id := range sc.JwtRequirementProviderIds(r) {
+			providerRequires = append(providerRequires, makeJwtProviderRequirement(id, r.GetAudiences()))
+		}
+		require := providerRequires[0]
+		if len(providerRequires) > 1 {
 			require = &jwtpb.JwtRequirement{
-				RequiresType: &jwtpb.JwtRequirement_ProviderAndAudiences{
-					ProviderAndAudiences: &jwtpb.ProviderWithAudiences{
-						ProviderName: r.GetProviderId(),
-						Audiences:    audiences,
+				RequiresType: &jwtpb.JwtRequirement_RequiresAll{
+					RequiresAll: &jwtpb.JwtRequirementAndList{
+						Requirements: providerRequires,
 					},
 				},
 			}
@@ -441,6 +1308,31 @@ func makeJwtRequirement(requirements []*confpb.AuthRequirement) *jwtpb.JwtRequir
 	return requires
 }
 
+func makeJwtProviderRequirement(providerId, audiences string) *jwtpb.JwtRequirement {
+	if audiences == "" {
+		return &jwtpb.JwtRequirement{
+			RequiresType: &jwtpb.JwtRequirement_ProviderName{
+				ProviderName: providerId,
+			},
+		}
+	}
+
+	// Note: Audiences in requirements is deprecated.
+	// But if it's specified, we should override the audiences for the provider.
+	var providerAudiences []string
+	for _, a := range strings.Split(audiences, ",") {
+		providerAudiences = append(providerAudiences, strings.TrimSpace(a))
+	}
+	return &jwtpb.JwtRequirement{
+		RequiresType: &jwtpb.JwtRequirement_ProviderAndAudiences{
+			ProviderAndAudiences: &jwtpb.ProviderWithAudiences{
+				ProviderName: providerId,
+				Audiences:    providerAudiences,
+			},
+		},
+	}
+}
+
 func makeServiceControlCallingConfig(opts options.ConfigGeneratorOptions) *scpb.ServiceControlCallingConfig {
 	setting := &scpb.ServiceControlCallingConfig{}
 	setting.NetworkFailOpen = &wrapperspb.BoolValue{Value: opts.ServiceControlNetworkFailOpen}
@@ -464,9 +1356,75 @@ func makeServiceControlCallingConfig(opts options.ConfigGeneratorOptions) *scpb.
 	if opts.ScReportRetries > -1 {
 		setting.ReportRetries = &wrapperspb.UInt32Value{Value: uint32(opts.ScReportRetries)}
 	}
+
+	if opts.ScReportBatchMaxSize > 0 {
+		setting.ReportBatchMaxSize = &wrapperspb.UInt32Value{Value: uint32(opts.ScReportBatchMaxSize)}
+	}
+	if opts.ScReportBatchMaxDelayMs > 0 {
+		setting.ReportBatchMaxDelayMs = &wrapperspb.UInt32Value{Value: uint32(opts.ScReportBatchMaxDelayMs)}
+	}
+	if opts.ScReportBatchMaxBytes > 0 {
+		setting.ReportBatchMaxBytes = &wrapperspb.UInt32Value{Value: uint32(opts.ScReportBatchMaxBytes)}
+	}
+
+	if opts.ScCircuitBreakerFailureThreshold > 0 {
+		setting.CircuitBreaker = makeServiceControlCircuitBreaker(opts)
+	}
+
+	if opts.ScCheckCacheTtlMs > 0 || opts.ScCheckCacheMaxEntries > -1 || opts.ScCheckCacheNegativeTtlMs > -1 || opts.ScCheckCacheServeStale {
+		setting.CheckCache = makeServiceControlCheckCache(opts)
+	}
+
+	if opts.ScQuotaBucketBurst > 0 {
+		setting.QuotaBucket = makeServiceControlQuotaBucket(opts)
+	}
 	return setting
 }
 
+func makeServiceControlCircuitBreaker(opts options.ConfigGeneratorOptions) *scpb.CircuitBreakerConfig {
+	circuitBreaker := &scpb.CircuitBreakerConfig{
+		FailureThreshold: uint32(opts.ScCircuitBreakerFailureThreshold),
+		FailOpen:         &wrapperspb.BoolValue{Value: opts.ScCircuitBreakerFailOpen},
+		ReportSpillDir:   opts.ScReportSpillDir,
+	}
+	if opts.ScCircuitBreakerOpenDurationMs > 0 {
+		circuitBreaker.OpenDurationMs = &wrapperspb.UInt32Value{Value: uint32(opts.ScCircuitBreakerOpenDurationMs)}
+	}
+	if opts.ScReportQueueMaxBytes > 0 {
+		circuitBreaker.ReportQueueMaxBytes = &wrapperspb.UInt32Value{Value: uint32(opts.ScReportQueueMaxBytes)}
+	}
+	if opts.ScReportSpillMaxBytes > 0 {
+		circuitBreaker.ReportSpillMaxBytes = &wrapperspb.UInt32Value{Value: uint32(opts.ScReportSpillMaxBytes)}
+	}
+	return circuitBreaker
+}
+
+func makeServiceControlCheckCache(opts options.ConfigGeneratorOptions) *scpb.CheckCacheConfig {
+	checkCache := &scpb.CheckCacheConfig{
+		ServeStaleOnFailure: opts.ScCheckCacheServeStale,
+	}
+	if opts.ScCheckCacheTtlMs > 0 {
+		checkCache.TtlMs = &wrapperspb.UInt32Value{Value: uint32(opts.ScCheckCacheTtlMs)}
+	}
+	if opts.ScCheckCacheMaxEntries > -1 {
+		checkCache.MaxEntries = &wrapperspb.UInt32Value{Value: uint32(opts.ScCheckCacheMaxEntries)}
+	}
+	if opts.ScCheckCacheNegativeTtlMs > -1 {
+		checkCache.NegativeTtlMs = &wrapperspb.UInt32Value{Value: uint32(opts.ScCheckCacheNegativeTtlMs)}
+	}
+	return checkCache
+}
+
+func makeServiceControlQuotaBucket(opts options.ConfigGeneratorOptions) *scpb.QuotaBucketConfig {
+	quotaBucket := &scpb.QuotaBucketConfig{
+		Burst: uint32(opts.ScQuotaBucketBurst),
+	}
+	if opts.ScQuotaBucketRefillIntervalMs > 0 {
+		quotaBucket.RefillIntervalMs = &wrapperspb.UInt32Value{Value: uint32(opts.ScQuotaBucketRefillIntervalMs)}
+	}
+	return quotaBucket
+}
+
 func makeServiceControlFilter(serviceInfo *sc.ServiceInfo) *hcmpb.HttpFilter {
 	if serviceInfo == nil || serviceInfo.ServiceConfig().GetControl().GetEnvironment() == "" {
 		return nil
@@ -497,6 +1455,10 @@ func makeServiceControlFilter(serviceInfo *sc.ServiceInfo) *hcmpb.HttpFilter {
 			service.LogRequestHeaders[i] = strings.TrimSpace(service.LogRequestHeaders[i])
 		}
 	}
+	// The client certificate details are logged with the request headers.
+	if serviceInfo.Options.SslServerForwardClientCert {
+		service.LogRequestHeaders = append(service.LogRequestHeaders, util.ForwardedClientCertHeader)
+	}
 	if serviceInfo.Options.LogResponseHeaders != "" {
 		service.LogResponseHeaders = strings.Split(serviceInfo.Options.LogResponseHeaders, ",")
 		for i := range service.LogResponseHeaders {
@@ -513,6 +1475,15 @@ func makeServiceControlFilter(serviceInfo *sc.ServiceInfo) *hcmpb.HttpFilter {
 		service.MinStreamReportIntervalMs = serviceInfo.Options.MinStreamReportIntervalMs
 	}
 	service.JwtPayloadMetadataName = util.JwtPayloadMetadataName
+	service.ReportLabels = serviceInfo.ReportLabels
+	service.ConsumerProjectHeader = serviceInfo.Options.ConsumerProjectHeader
+	service.ConsumerIdHeader = serviceInfo.Options.ConsumerIdHeader
+	if serviceInfo.Options.TracingCustomTags != "" && !serviceInfo.Options.DisableTracing {
+		service.TracingCustomTags = strings.Split(serviceInfo.Options.TracingCustomTags, ",")
+		for i := range service.TracingCustomTags {
+			service.TracingCustomTags[i] = strings.TrimSpace(service.TracingCustomTags[i])
+		}
+	}
 
 	filterConfig := &scpb.FilterConfig{
 		Services:        []*scpb.Service{service},
@@ -522,6 +1493,8 @@ func makeServiceControlFilter(serviceInfo *sc.ServiceInfo) *hcmpb.HttpFilter {
 			Cluster: util.ServiceControlClusterName,
 			Timeout: ptypes.DurationProto(serviceInfo.Options.HttpRequestTimeout),
 		},
+		GlobalRateLimit: serviceInfo.GlobalRateLimit,
+		ScTransport:     serviceInfo.ServiceControlTransport,
 	}
 
 	if serviceInfo.Options.ServiceControlCredentials != nil {
@@ -540,7 +1513,7 @@ func makeServiceControlFilter(serviceInfo *sc.ServiceInfo) *hcmpb.HttpFilter {
 		}
 	} else {
 		// Use access token from fetched the Instance Metadata Server to talk to Service Controller
-		switch serviceInfo.AccessToken.TokenType.(type) {
+		switch serviceInfo.AccessToken.GetTokenType().(type) {
 		case *commonpb.AccessToken_RemoteToken:
 			filterConfig.AccessToken = &scpb.FilterConfig_ImdsToken{
 				ImdsToken: serviceInfo.AccessToken.GetRemoteToken(),
@@ -556,6 +1529,11 @@ func makeServiceControlFilter(serviceInfo *sc.ServiceInfo) *hcmpb.HttpFilter {
 		}
 	}
 
+	// The access token is only sent with the access_token auth.
+	if filterConfig.ScTransport.GetAuth() != scpb.ServiceControlTransport_ACCESS_TOKEN {
+		filterConfig.AccessToken = nil
+	}
+
 	if serviceInfo.GcpAttributes != nil {
 		filterConfig.GcpAttributes = serviceInfo.GcpAttributes
 	}
@@ -569,15 +1547,18 @@ func makeServiceControlFilter(serviceInfo *sc.ServiceInfo) *hcmpb.HttpFilter {
 	for _, operation := range serviceInfo.Operations {
 		method := serviceInfo.Methods[operation]
 		requirement := &scpb.Requirement{
-			ServiceName:        serviceName,
-			OperationName:      operation,
-			SkipServiceControl: method.SkipServiceControl,
-			MetricCosts:        method.MetricCosts,
+			ServiceName:          serviceName,
+			OperationName:        operation,
+			SkipServiceControl:   method.SkipServiceControl,
+			MetricCosts:          method.MetricCosts,
+			MaxResponseBodyBytes: method.MaxResponseBodyBytes,
+			RateLimit:            method.RateLimit,
 		}
 
 		// For these OPTIONS methods, auth should be disabled and AllowWithoutApiKey
-		// should be true for each CORS.
-		if method.IsGenerated || method.AllowUnregisteredCalls {
+		// should be true for each CORS, unless they require the authentication
+		// of their operation.
+		if (method.IsGenerated && method.PreflightOperation == "") || method.AllowUnregisteredCalls {
 			requirement.ApiKey = &scpb.ApiKeyRequirement{
 				AllowWithoutApiKey: true,
 			}
@@ -623,64 +1604,94 @@ func copyServiceConfigForReportMetrics(src *confpb.Service) *anypb.Any {
 }
 
 func makeTranscoderFilter(serviceInfo *sc.ServiceInfo) *hcmpb.HttpFilter {
-	for _, sourceFile := range serviceInfo.ServiceConfig().GetSourceInfo().GetSourceFiles() {
-		configFile := &smpb.ConfigFile{}
-		ptypes.UnmarshalAny(sourceFile, configFile)
-
-		if configFile.GetFileType() == smpb.ConfigFile_FILE_DESCRIPTOR_SET_PROTO {
-			configContent := configFile.GetFileContents()
-			transcodeConfig := &transcoderpb.GrpcJsonTranscoder{
-				DescriptorSet: &transcoderpb.GrpcJsonTranscoder_ProtoDescriptorBin{
-					ProtoDescriptorBin: configContent,
-				},
-				AutoMapping:       true,
-				ConvertGrpcStatus: true,
-			}
-			for apiKeyQueryParam, used := range serviceInfo.TranscoderIgnoredApiKeyQueryParams {
-				if used {
-					transcodeConfig.IgnoredQueryParameters = append(transcodeConfig.IgnoredQueryParameters, apiKeyQueryParam)
-				}
-			}
-
-			for jwtQueryParam, used := range serviceInfo.TranscoderIgnoredJwtQueryParams {
-				if used {
-					transcodeConfig.IgnoredQueryParameters = append(transcodeConfig.IgnoredQueryParameters, jwtQueryParam)
-				}
-			}
-
-			sort.Sort(sort.StringSlice(transcodeConfig.IgnoredQueryParameters))
+	if serviceInfo.TranscodingDescriptor == nil {
+		// b/148605552: Previous versions of the `gcloud_build_image` script did not download the proto descriptor.
+		// We cannot ensure that users have the latest version of the script, so notify them via non-fatal logs.
+		// Log as error instead of warning because error logs will show up even if `--enable_debug` is false.
+		glog.Error("Unable to setup gRPC-JSON transcoding because no proto descriptor was found in the service config. " +
+			"Please use version 2020-01-29 (or later) of the `gcloud_build_image` script. " +
+			"https://github.com/GoogleCloudPlatform/esp-v2/blob/master/docker/serverless/gcloud_build_image")
+		return nil
+	}
 
-			transcodeConfig.Services = append(transcodeConfig.Services, serviceInfo.ApiNames...)
+	transcodeConfig := &transcoderpb.GrpcJsonTranscoder{
+		DescriptorSet: &transcoderpb.GrpcJsonTranscoder_ProtoDescriptorBin{
+			ProtoDescriptorBin: serviceInfo.TranscodingDescriptor,
+		},
+		AutoMapping:                  !serviceInfo.Options.TranscodingDisableAutoMapping,
+		ConvertGrpcStatus:            true,
+		IgnoreUnknownQueryParameters: serviceInfo.Options.TranscodingIgnoreUnknownQueryParameters,
+		MatchIncomingRequestRoute:    serviceInfo.Options.TranscodingMatchIncomingRequestRoute,
+	}
+	if serviceInfo.Options.TranscodingAlwaysPrintPrimitiveFields || serviceInfo.Options.TranscodingAlwaysPrintEnumsAsInts ||
+		serviceInfo.Options.TranscodingPreserveProtoFieldNames {
+		transcodeConfig.PrintOptions = &transcoderpb.GrpcJsonTranscoder_PrintOptions{
+			AlwaysPrintPrimitiveFields: serviceInfo.Options.TranscodingAlwaysPrintPrimitiveFields,
+			AlwaysPrintEnumsAsInts:     serviceInfo.Options.TranscodingAlwaysPrintEnumsAsInts,
+			PreserveProtoFieldNames:    serviceInfo.Options.TranscodingPreserveProtoFieldNames,
+		}
+	}
+	for apiKeyQueryParam, used := range serviceInfo.TranscoderIgnoredApiKeyQueryParams {
+		if used {
+			transcodeConfig.IgnoredQueryParameters = append(transcodeConfig.IgnoredQueryParameters, apiKeyQueryParam)
+		}
+	}
 
-			transcodeConfigStruct, _ := ptypes.MarshalAny(transcodeConfig)
-			transcodeFilter := &hcmpb.HttpFilter{
-				Name:       util.GRPCJSONTranscoder,
-				ConfigType: &hcmpb.HttpFilter_TypedConfig{transcodeConfigStruct},
-			}
-			return transcodeFilter
+	for jwtQueryParam, used := range serviceInfo.TranscoderIgnoredJwtQueryParams {
+		if used {
+			transcodeConfig.IgnoredQueryParameters = append(transcodeConfig.IgnoredQueryParameters, jwtQueryParam)
 		}
 	}
 
-	// b/148605552: Previous versions of the `gcloud_build_image` script did not download the proto descriptor.
-	// We cannot ensure that users have the latest version of the script, so notify them via non-fatal logs.
-	// Log as error instead of warning because error logs will show up even if `--enable_debug` is false.
-	glog.Error("Unable to setup gRPC-JSON transcoding because no proto descriptor was found in the service config. " +
-		"Please use version 2020-01-29 (or later) of the `gcloud_build_image` script. " +
-		"https://github.com/GoogleCloudPlatform/esp-v2/blob/master/docker/serverless/gcloud_build_image")
-	return nil
+	sort.Sort(sort.StringSlice(transcodeConfig.IgnoredQueryParameters))
+
+	transcodeConfig.Services = append(transcodeConfig.Services, serviceInfo.ApiNames...)
+
+	transcodeConfigStruct, _ := ptypes.MarshalAny(transcodeConfig)
+	transcodeFilter := &hcmpb.HttpFilter{
+		Name:       util.GRPCJSONTranscoder,
+		ConfigType: &hcmpb.HttpFilter_TypedConfig{transcodeConfigStruct},
+	}
+	return transcodeFilter
 }
 
 func makeBackendAuthFilter(serviceInfo *sc.ServiceInfo) *hcmpb.HttpFilter {
 	var rules []*bapb.BackendAuthRule
 	for _, operation := range serviceInfo.Operations {
 		method := serviceInfo.Methods[operation]
-		if method.BackendInfo == nil || method.BackendInfo.JwtAudience == "" {
+		if method.BackendInfo == nil {
+			continue
+		}
+		if method.BackendInfo.AccessTokenUri != "" {
+			rules = append(rules,
+				&bapb.BackendAuthRule{
+					Operation: operation,
+					TokenInfo: &bapb.BackendAuthRule_AccessTokenUri{
+						AccessTokenUri: &commonpb.HttpUri{
+							Uri:     method.BackendInfo.AccessTokenUri,
+							Cluster: util.TokenAgentClusterName,
+							Timeout: ptypes.DurationProto(serviceInfo.Options.HttpRequestTimeout),
+						},
+					},
+				})
+			continue
+		}
+		if method.BackendInfo.JwtAudience == "" {
+			continue
+		}
+		// The ID tokens can only be fetched from IAM in the standalone
+		// profile, the backend is called without one otherwise.
+		if serviceInfo.Options.Standalone && serviceInfo.Options.BackendAuthCredentials == nil {
+			glog.Warningf("no ID token is sent to the backend of operation %s with --standalone, set --backend_auth_iam_service_account to fetch it from IAM", operation)
 			continue
 		}
 		rules = append(rules,
 			&bapb.BackendAuthRule{
-				Operation:   operation,
-				JwtAudience: method.BackendInfo.JwtAudience,
+				Operation: operation,
+				TokenInfo: &bapb.BackendAuthRule_JwtAudience{
+					JwtAudience: method.BackendInfo.JwtAudience,
+				},
+				Delegates: method.BackendInfo.IamDelegates,
 			})
 	}
 	// If none of BackendRules need auth, rules will be empty, not need to add the filter.
@@ -705,7 +1716,7 @@ func makeBackendAuthFilter(serviceInfo *sc.ServiceInfo) *hcmpb.HttpFilter {
 				ServiceAccountEmail: serviceInfo.Options.BackendAuthCredentials.ServiceAccountEmail,
 				Delegates:           serviceInfo.Options.BackendAuthCredentials.Delegates,
 			}}
-	} else {
+	} else if !serviceInfo.Options.Standalone {
 		backendAuthConfig.IdTokenInfo = &bapb.FilterConfig_ImdsToken{
 			ImdsToken: &commonpb.HttpUri{
 				Uri:     fmt.Sprintf("%s%s", serviceInfo.Options.MetadataURL, util.IdentityTokenSuffix),
@@ -726,6 +1737,10 @@ func makeBackendRoutingFilter(serviceInfo *sc.ServiceInfo) (*hcmpb.HttpFilter, e
 	rules := []*brpb.BackendRoutingRule{}
 	for _, operation := range serviceInfo.Operations {
 		method := serviceInfo.Methods[operation]
+		if method.PathRewrite != nil {
+			rules = append(rules, makePathRewriteRule(operation, method.PathRewrite))
+			continue
+		}
 		if method.BackendInfo != nil && method.BackendInfo.TranslationType != confpb.BackendRule_PATH_TRANSLATION_UNSPECIFIED {
 			newRule := &brpb.BackendRoutingRule{
 				Operation:      operation,
@@ -753,6 +1768,108 @@ func makeBackendRoutingFilter(serviceInfo *sc.ServiceInfo) (*hcmpb.HttpFilter, e
 	}, nil
 }
 
+// makePathRewriteRule makes the backend routing rule of the operation
+// rewriting its paths with the regex of rewrite, or with one replacing its
+// prefix.
+func makePathRewriteRule(operation string, rewrite *options.PathRewriteOptions) *brpb.BackendRoutingRule {
+	if rewrite.Prefix != "" {
+		return &brpb.BackendRoutingRule{
+			Operation: operation,
+			Regex:     "^" + regexp.QuoteMeta(rewrite.Prefix),
+			// The backslashes are not capture groups in the prefix rewrite.
+			RegexSubstitution: strings.ReplaceAll(rewrite.PrefixRewrite, `\`, `\\`),
+		}
+	}
+	return &brpb.BackendRoutingRule{
+		Operation:         operation,
+		Regex:             rewrite.Regex,
+		RegexSubstitution: rewrite.Substitution,
+	}
+}
+
+// makeResponseCacheFilter makes the Response Cache filter caching the responses
+// of the GET requests of the methods with a cache, nil if there are none.
+func makeResponseCacheFilter(serviceInfo *sc.ServiceInfo) (*hcmpb.HttpFilter, error) {
+	rules := []*rcpb.CacheRule{}
+	for _, operation := range serviceInfo.Operations {
+		method := serviceInfo.Methods[operation]
+		if method.ResponseCache == nil || !hasGetHttpRule(method.HttpRule) {
+			continue
+		}
+		rule := &rcpb.CacheRule{
+			Operation:   operation,
+			KeyHeaders:  method.ResponseCache.KeyHeaders,
+			JwtRequired: hasJwtRequirement(serviceInfo, operation),
+		}
+		if method.ResponseCache.Ttl > 0 {
+			rule.Ttl = ptypes.DurationProto(time.Duration(method.ResponseCache.Ttl * float64(time.Second)))
+		}
+		if method.ResponseCache.IncludeApiKey {
+			rule.KeyHeaders = append(append([]string{}, rule.KeyHeaders...), apiKeyHeaders(method.ApiKeyLocations)...)
+		}
+		rules = append(rules, rule)
+	}
+	if len(rules) == 0 {
+		return nil, nil
+	}
+
+	responseCacheConfig, err := ptypes.MarshalAny(&rcpb.FilterConfig{
+		Rules:        rules,
+		MaxEntries:   serviceInfo.Options.ResponseCacheMaxEntries,
+		MaxBodyBytes: serviceInfo.Options.ResponseCacheMaxBodyBytes,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &hcmpb.HttpFilter{
+		Name:       util.ResponseCache,
+		ConfigType: &hcmpb.HttpFilter_TypedConfig{responseCacheConfig},
+	}, nil
+}
+
+// hasJwtRequirement returns whether the JWT Authn filter verifies the JWT of
+// the requests to the operation.
+func hasJwtRequirement(serviceInfo *sc.ServiceInfo, operation string) bool {
+	auth := serviceInfo.ServiceConfig().GetAuthentication()
+	if len(auth.GetProviders()) == 0 {
+		return false
+	}
+	for _, rule := range auth.GetRules() {
+		if rule.GetSelector() == operation && len(rule.GetRequirements()) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func hasGetHttpRule(httpRules []*commonpb.Pattern) bool {
+	for _, httpRule := range httpRules {
+		if httpRule.HttpMethod == util.GET {
+			return true
+		}
+	}
+	return false
+}
+
+// apiKeyHeaders returns the request headers which may carry the API keys in
+// locations, or the default x-api-key header if empty. The API keys in the
+// query parameters are already in the paths keying the responses.
+func apiKeyHeaders(locations []*scpb.ApiKeyLocation) []string {
+	if len(locations) == 0 {
+		return []string{"x-api-key"}
+	}
+	var headers []string
+	for _, location := range locations {
+		switch location.Key.(type) {
+		case *scpb.ApiKeyLocation_Header:
+			headers = append(headers, location.GetHeader())
+		case *scpb.ApiKeyLocation_Cookie:
+			headers = append(headers, "cookie")
+		}
+	}
+	return headers
+}
+
 func makeHealthCheckFilter(serviceInfo *sc.ServiceInfo) (*hcmpb.HttpFilter, error) {
 	hcFilterConfig := &hcpb.HealthCheck{
 		PassThroughMode: &wrapperspb.BoolValue{Value: false},
@@ -17,15 +17,27 @@ package configgenerator
 import (
 	"encoding/base64"
 	"fmt"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/GoogleCloudPlatform/esp-v2/src/go/configinfo"
 	"github.com/GoogleCloudPlatform/esp-v2/src/go/options"
 	"github.com/GoogleCloudPlatform/esp-v2/src/go/util"
 	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
 	"github.com/golang/protobuf/ptypes"
 
+	scpb "github.com/GoogleCloudPlatform/esp-v2/src/go/proto/api/envoy/http/service_control"
+	v2pb "github.com/envoyproxy/go-control-plane/envoy/api/v2"
+	authpb "github.com/envoyproxy/go-control-plane/envoy/api/v2/auth"
+	corepb "github.com/envoyproxy/go-control-plane/envoy/api/v2/core"
+	hcmpb "github.com/envoyproxy/go-control-plane/envoy/config/filter/network/http_connection_manager/v2"
+	netrbacpb "github.com/envoyproxy/go-control-plane/envoy/config/filter/network/rbac/v2"
+	typepb "github.com/envoyproxy/go-control-plane/envoy/type"
 	anypb "github.com/golang/protobuf/ptypes/any"
+	wrapperspb "github.com/golang/protobuf/ptypes/wrappers"
 	annotationspb "google.golang.org/genproto/googleapis/api/annotations"
 	confpb "google.golang.org/genproto/googleapis/api/serviceconfig"
 	smpb "google.golang.org/genproto/googleapis/api/servicemanagement/v1"
@@ -48,6 +60,7 @@ func TestTranscoderFilter(t *testing.T) {
 	testData := []struct {
 		desc                 string
 		fakeServiceConfig    *confpb.Service
+		transcodingOptions   func(opts *options.ConfigGeneratorOptions)
 		wantTranscoderFilter string
 	}{
 		{
@@ -168,6 +181,48 @@ func TestTranscoderFilter(t *testing.T) {
          "%s"
       ]
    }
+}
+      `, fakeProtoDescriptor, testApiName),
+		},
+		{
+			desc: "Success. Generate transcoder filter with the transcoding options",
+			fakeServiceConfig: &confpb.Service{
+				Name: testProjectName,
+				Apis: []*apipb.Api{
+					{
+						Name: testApiName,
+					},
+				},
+				SourceInfo: &confpb.SourceInfo{
+					SourceFiles: []*anypb.Any{content},
+				},
+			},
+			transcodingOptions: func(opts *options.ConfigGeneratorOptions) {
+				opts.TranscodingAlwaysPrintPrimitiveFields = true
+				opts.TranscodingAlwaysPrintEnumsAsInts = true
+				opts.TranscodingPreserveProtoFieldNames = true
+				opts.TranscodingIgnoreUnknownQueryParameters = true
+				opts.TranscodingMatchIncomingRequestRoute = true
+				opts.TranscodingDisableAutoMapping = true
+			},
+			wantTranscoderFilter: fmt.Sprintf(`
+{
+   "name":"envoy.grpc_json_transcoder",
+   "typedConfig":{
+      "@type":"type.googleapis.com/envoy.config.filter.http.transcoder.v2.GrpcJsonTranscoder",
+      "convertGrpcStatus":true,
+      "ignoreUnknownQueryParameters":true,
+      "matchIncomingRequestRoute":true,
+      "printOptions":{
+         "alwaysPrintEnumsAsInts":true,
+         "alwaysPrintPrimitiveFields":true,
+         "preserveProtoFieldNames":true
+      },
+      "protoDescriptorBin":"%s",
+      "services":[
+         "%s"
+      ]
+   }
 }
       `, fakeProtoDescriptor, testApiName),
 		},
@@ -176,6 +231,9 @@ func TestTranscoderFilter(t *testing.T) {
 	for i, tc := range testData {
 		opts := options.DefaultConfigGeneratorOptions()
 		opts.BackendAddress = "grpc://127.0.0.0:80"
+		if tc.transcodingOptions != nil {
+			tc.transcodingOptions(&opts)
+		}
 		fakeServiceInfo, err := configinfo.NewServiceInfoFromServiceConfig(tc.fakeServiceConfig, testConfigID, opts)
 		if err != nil {
 			t.Fatal(err)
@@ -194,10 +252,13 @@ func TestTranscoderFilter(t *testing.T) {
 }
 
 func TestJwtAuthnFilter(t *testing.T) {
+	cacheDuration := 600
 	testData := []struct {
-		desc               string
-		fakeServiceConfig  *confpb.Service
-		wantJwtAuthnFilter string
+		desc                     string
+		fakeServiceConfig        *confpb.Service
+		jwksProviders            []*options.JwksProviderOptions
+		corsPreflightRequireAuth bool
+		wantJwtAuthnFilter       string
 	}{
 		{
 			desc: "Success. Generate jwt authn filter with default jwt locations",
@@ -334,202 +395,201 @@ func TestJwtAuthnFilter(t *testing.T) {
     }
 }`,
 		},
-	}
-
-	for i, tc := range testData {
-		opts := options.DefaultConfigGeneratorOptions()
-		opts.BackendAddress = "grpc://127.0.0.0:80"
-		fakeServiceInfo, err := configinfo.NewServiceInfoFromServiceConfig(tc.fakeServiceConfig, testConfigID, opts)
-		if err != nil {
-			t.Fatal(err)
-		}
-
-		marshaler := &jsonpb.Marshaler{}
-		gotFilter, err := marshaler.MarshalToString(makeJwtAuthnFilter(fakeServiceInfo))
-		if err != nil {
-			t.Fatal(err)
-		}
-
-		if err := util.JsonEqual(tc.wantJwtAuthnFilter, gotFilter); err != nil {
-			t.Errorf("Test Desc(%d): %s, makeTranscoderFilter failed, got: %s, want: %s", i, tc.desc, gotFilter, tc.wantJwtAuthnFilter)
-		}
-	}
-}
-
-func TestBackendRoutingFilter(t *testing.T) {
-	testdata := []struct {
-		desc                     string
-		BackendAddress           string
-		fakeServiceConfig        *confpb.Service
-		wantBackendRoutingFilter string
-	}{
 		{
-			desc:           "Success, generate backend routing filter for gRPC",
-			BackendAddress: "grpc://127.0.0.1:80",
+			desc: "Success. Generate jwt authn filter with inline local JWKS",
 			fakeServiceConfig: &confpb.Service{
 				Name: testProjectName,
 				Apis: []*apipb.Api{
 					{
-						Name: "endpoints.examples.bookstore.Bookstore",
-						Methods: []*apipb.Method{
-							{
-								Name: "CreateShelf",
-							},
-							{
-								Name: "ListShelves",
-							},
-						},
+						Name: testApiName,
 					},
 				},
-				Http: &annotationspb.Http{
-					Rules: []*annotationspb.HttpRule{
-						{
-							Selector: "endpoints.examples.bookstore.Bookstore.ListShelves",
-							Pattern: &annotationspb.HttpRule_Get{
-								Get: "/v1/shelves",
-							},
-						},
-						{
-							Selector: "endpoints.examples.bookstore.Bookstore.CreateShelf",
-							Pattern: &annotationspb.HttpRule_Post{
-								Post: "/v1/shelves",
-							},
-							Body: "shelf",
-						},
-					},
+				SourceInfo: &confpb.SourceInfo{
+					SourceFiles: []*anypb.Any{content},
 				},
-				Backend: &confpb.Backend{
-					Rules: []*confpb.BackendRule{
-						{
-							Selector:        "endpoints.examples.bookstore.Bookstore.CreateShelf",
-							Address:         "https://testapipb.com/foo",
-							PathTranslation: confpb.BackendRule_CONSTANT_ADDRESS,
-						},
+				Authentication: &confpb.Authentication{
+					Providers: []*confpb.AuthProvider{
 						{
-							Selector:        "endpoints.examples.bookstore.Bookstore.ListShelves",
-							Address:         "https://testapipb.com/foo",
-							PathTranslation: confpb.BackendRule_APPEND_PATH_TO_ADDRESS,
-							Authentication: &confpb.BackendRule_JwtAudience{
-								JwtAudience: "bar.com",
-							},
+							Id:      "auth_provider",
+							Issuer:  "issuer-0",
+							JwksUri: `{"keys": []}`,
 						},
 					},
 				},
 			},
-			wantBackendRoutingFilter: `{
-        "name": "envoy.filters.http.backend_routing",
-        "typedConfig": {
-          "@type":"type.googleapis.com/google.api.envoy.http.backend_routing.FilterConfig",
-          "rules": [
-            {
-              "isConstAddress": true,
-              "operation": "endpoints.examples.bookstore.Bookstore.CreateShelf",
-              "pathPrefix": "/foo"
-            },
-            {
-              "operation":  "endpoints.examples.bookstore.Bookstore.ListShelves",
-              "pathPrefix": "/foo"
+			wantJwtAuthnFilter: `{
+    "name": "envoy.filters.http.jwt_authn",
+    "typedConfig": {
+        "@type": "type.googleapis.com/envoy.config.filter.http.jwt_authn.v2alpha.JwtAuthentication",
+        "filterStateRules": {
+            "name": "envoy.filters.http.path_matcher.operation"
+        },
+        "providers": {
+            "auth_provider": {
+                "audiences": [
+                    "https://bookstore.endpoints.project123.cloud.goog"
+                ],
+                "forwardPayloadHeader": "X-Endpoint-API-UserInfo",
+                "fromHeaders": [
+                    {
+                        "name": "Authorization",
+                        "valuePrefix": "Bearer "
+                    },
+                    {
+                        "name": "X-Goog-Iap-Jwt-Assertion"
+                    }
+                ],
+                "fromParams": [
+                    "access_token"
+                ],
+                "issuer": "issuer-0",
+                "localJwks": {
+                    "inlineString": "{\"keys\": []}"
+                },
+                "payloadInMetadata": "jwt_payloads"
             }
-          ]
         }
-      }`,
+    }
+}`,
 		},
 		{
-			desc:           "Success, generate backend routing filter for http",
-			BackendAddress: "http://127.0.0.1:80",
+			desc: "Success. Generate jwt authn filter without the wildcard audiences matched on the routes",
 			fakeServiceConfig: &confpb.Service{
 				Name: testProjectName,
 				Apis: []*apipb.Api{
 					{
-						Name: "testapi",
-						Methods: []*apipb.Method{
-							{
-								Name: "foo",
-							},
-							{
-								Name: "bar",
-							},
-						},
+						Name: testApiName,
 					},
 				},
-				Http: &annotationspb.Http{
-					Rules: []*annotationspb.HttpRule{
-						{
-							Selector: "testapi.foo",
-							Pattern: &annotationspb.HttpRule_Get{
-								Get: "foo",
-							},
-						},
-						{
-							Selector: "testapi.bar",
-							Pattern: &annotationspb.HttpRule_Get{
-								Get: "bar",
-							},
-						},
-					},
+				SourceInfo: &confpb.SourceInfo{
+					SourceFiles: []*anypb.Any{content},
 				},
-				Backend: &confpb.Backend{
-					Rules: []*confpb.BackendRule{
-						{
-							Selector: "ignore_me",
-						},
-						{
-							Selector:        "testapi.foo",
-							Address:         "https://testapipb.com/foo",
-							PathTranslation: confpb.BackendRule_CONSTANT_ADDRESS,
-							Authentication: &confpb.BackendRule_JwtAudience{
-								JwtAudience: "foo.com",
-							},
-						},
+				Authentication: &confpb.Authentication{
+					Providers: []*confpb.AuthProvider{
 						{
-							Selector:        "testapi.bar",
-							Address:         "https://testapipb.com/foo",
-							PathTranslation: confpb.BackendRule_APPEND_PATH_TO_ADDRESS,
-							Authentication: &confpb.BackendRule_JwtAudience{
-								JwtAudience: "bar.com",
-							},
+							Id:        "auth_provider",
+							Issuer:    "issuer-0",
+							JwksUri:   "https://fake-jwks.com",
+							Audiences: "https://api.example.com, https://*.example.com",
 						},
 					},
 				},
 			},
-			wantBackendRoutingFilter: `{
-        "name": "envoy.filters.http.backend_routing",
-        "typedConfig": {
-          "@type":"type.googleapis.com/google.api.envoy.http.backend_routing.FilterConfig",
-          "rules": [
-            {
-              "operation": "testapi.bar",
-              "pathPrefix": "/foo"
-            },
-            {
-              "isConstAddress": true,
-              "operation":"testapi.foo",
-              "pathPrefix": "/foo"
+			wantJwtAuthnFilter: `{
+    "name": "envoy.filters.http.jwt_authn",
+    "typedConfig": {
+        "@type": "type.googleapis.com/envoy.config.filter.http.jwt_authn.v2alpha.JwtAuthentication",
+        "filterStateRules": {
+            "name": "envoy.filters.http.path_matcher.operation"
+        },
+        "providers": {
+            "auth_provider": {
+                "forwardPayloadHeader": "X-Endpoint-API-UserInfo",
+                "fromHeaders": [
+                    {
+                        "name": "Authorization",
+                        "valuePrefix": "Bearer "
+                    },
+                    {
+                        "name": "X-Goog-Iap-Jwt-Assertion"
+                    }
+                ],
+                "fromParams": [
+                    "access_token"
+                ],
+                "issuer": "issuer-0",
+                "payloadInMetadata": "jwt_payloads",
+                "remoteJwks": {
+                    "cacheDuration": "300s",
+                    "httpUri": {
+                        "cluster": "fake-jwks.com:443",
+                        "timeout": "5s",
+                        "uri": "https://fake-jwks.com"
+                    }
+                }
             }
-          ]
         }
-      }`,
+    }
+}`,
 		},
 		{
-			desc:           "Success, generate backend routing filter with allow Cors",
-			BackendAddress: "http://127.0.0.1:80",
+			desc: "Success. Generate jwt authn filter with the JWKS cache duration of the provider",
 			fakeServiceConfig: &confpb.Service{
 				Name: testProjectName,
-				Endpoints: []*confpb.Endpoint{
+				Apis: []*apipb.Api{
 					{
-						Name:      testProjectName,
-						AllowCors: true,
+						Name: testApiName,
+					},
+				},
+				SourceInfo: &confpb.SourceInfo{
+					SourceFiles: []*anypb.Any{content},
+				},
+				Authentication: &confpb.Authentication{
+					Providers: []*confpb.AuthProvider{
+						{
+							Id:      "auth_provider",
+							Issuer:  "issuer-0",
+							JwksUri: "https://fake-jwks.com",
+						},
 					},
 				},
+			},
+			jwksProviders: []*options.JwksProviderOptions{
+				{
+					ProviderId:       "auth_provider",
+					CacheDurationInS: &cacheDuration,
+				},
+			},
+			wantJwtAuthnFilter: `{
+    "name": "envoy.filters.http.jwt_authn",
+    "typedConfig": {
+        "@type": "type.googleapis.com/envoy.config.filter.http.jwt_authn.v2alpha.JwtAuthentication",
+        "filterStateRules": {
+            "name": "envoy.filters.http.path_matcher.operation"
+        },
+        "providers": {
+            "auth_provider": {
+                "audiences": [
+                    "https://bookstore.endpoints.project123.cloud.goog"
+                ],
+                "forwardPayloadHeader": "X-Endpoint-API-UserInfo",
+                "fromHeaders": [
+                    {
+                        "name": "Authorization",
+                        "valuePrefix": "Bearer "
+                    },
+                    {
+                        "name": "X-Goog-Iap-Jwt-Assertion"
+                    }
+                ],
+                "fromParams": [
+                    "access_token"
+                ],
+                "issuer": "issuer-0",
+                "payloadInMetadata": "jwt_payloads",
+                "remoteJwks": {
+                    "cacheDuration": "600s",
+                    "httpUri": {
+                        "cluster": "fake-jwks.com:443",
+                        "timeout": "5s",
+                        "uri": "https://fake-jwks.com"
+                    }
+                }
+            }
+        }
+    }
+}`,
+		},
+		{
+			desc: "Success. Generate jwt authn filter with the requirement of an operation for its preflight operation",
+			fakeServiceConfig: &confpb.Service{
+				Name: testProjectName,
 				Apis: []*apipb.Api{
 					{
-						Name: "testapi",
+						Name: testApiName,
 						Methods: []*apipb.Method{
 							{
-								Name: "foo",
-							},
-							{
-								Name: "bar",
+								Name: "Echo",
 							},
 						},
 					},
@@ -537,138 +597,238 @@ func TestBackendRoutingFilter(t *testing.T) {
 				Http: &annotationspb.Http{
 					Rules: []*annotationspb.HttpRule{
 						{
-							Selector: "testapi.foo",
-							Pattern: &annotationspb.HttpRule_Get{
-								Get: "foo",
-							},
-						},
-						{
-							Selector: "testapi.bar",
-							Pattern: &annotationspb.HttpRule_Get{
-								Get: "bar",
+							Selector: testApiName + ".Echo",
+							Pattern: &annotationspb.HttpRule_Post{
+								Post: "/echo",
 							},
 						},
 					},
 				},
-				Backend: &confpb.Backend{
-					Rules: []*confpb.BackendRule{
-						{
-							Selector: "ignore_me",
-						},
+				Authentication: &confpb.Authentication{
+					Providers: []*confpb.AuthProvider{
 						{
-							Selector:        "testapi.foo",
-							Address:         "https://testapipb.com/foo",
-							PathTranslation: confpb.BackendRule_CONSTANT_ADDRESS,
-							Authentication: &confpb.BackendRule_JwtAudience{
-								JwtAudience: "foo.com",
-							},
+							Id:      "auth_provider",
+							Issuer:  "issuer-0",
+							JwksUri: "https://fake-jwks.com",
 						},
+					},
+					Rules: []*confpb.AuthenticationRule{
 						{
-							Selector:        "testapi.bar",
-							Address:         "https://testapipb.com/bar",
-							PathTranslation: confpb.BackendRule_APPEND_PATH_TO_ADDRESS,
-							Authentication: &confpb.BackendRule_JwtAudience{
-								JwtAudience: "bar.com",
+							Selector: testApiName + ".Echo",
+							Requirements: []*confpb.AuthRequirement{
+								{
+									ProviderId: "auth_provider",
+								},
 							},
 						},
 					},
 				},
 			},
-			wantBackendRoutingFilter: `{
-        "name": "envoy.filters.http.backend_routing",
-        "typedConfig": {
-          "@type":"type.googleapis.com/google.api.envoy.http.backend_routing.FilterConfig",
-          "rules": [
-            {
-              "operation":"testapi.CORS_bar",
-              "pathPrefix": "/bar"
-            },
-            {
-              "isConstAddress": true,
-              "operation":"testapi.CORS_foo",
-              "pathPrefix": "/foo"
-            },
-            {
-              "operation": "testapi.bar",
-              "pathPrefix": "/bar"
-            },
-            {
-              "isConstAddress": true,
-              "operation":"testapi.foo",
-              "pathPrefix": "/foo"
+			corsPreflightRequireAuth: true,
+			wantJwtAuthnFilter: `{
+    "name": "envoy.filters.http.jwt_authn",
+    "typedConfig": {
+        "@type": "type.googleapis.com/envoy.config.filter.http.jwt_authn.v2alpha.JwtAuthentication",
+        "filterStateRules": {
+            "name": "envoy.filters.http.path_matcher.operation",
+            "requires": {
+                "endpoints.examples.bookstore.Bookstore.CORS_echo": {
+                    "providerName": "auth_provider"
+                },
+                "endpoints.examples.bookstore.Bookstore.Echo": {
+                    "providerName": "auth_provider"
+                }
+            }
+        },
+        "providers": {
+            "auth_provider": {
+                "audiences": [
+                    "https://bookstore.endpoints.project123.cloud.goog"
+                ],
+                "forwardPayloadHeader": "X-Endpoint-API-UserInfo",
+                "fromHeaders": [
+                    {
+                        "name": "Authorization",
+                        "valuePrefix": "Bearer "
+                    },
+                    {
+                        "name": "X-Goog-Iap-Jwt-Assertion"
+                    }
+                ],
+                "fromParams": [
+                    "access_token"
+                ],
+                "issuer": "issuer-0",
+                "payloadInMetadata": "jwt_payloads",
+                "remoteJwks": {
+                    "cacheDuration": "300s",
+                    "httpUri": {
+                        "cluster": "fake-jwks.com:443",
+                        "timeout": "5s",
+                        "uri": "https://fake-jwks.com"
+                    }
+                }
             }
-          ]
         }
-      }`,
+    }
+}`,
 		},
 	}
 
-	for i, tc := range testdata {
+	for i, tc := range testData {
 		opts := options.DefaultConfigGeneratorOptions()
-		opts.BackendAddress = tc.BackendAddress
+		opts.BackendAddress = "grpc://127.0.0.0:80"
+		opts.JwksProviders = tc.jwksProviders
+		if tc.corsPreflightRequireAuth {
+			opts.CorsPreset = "basic"
+			opts.CorsPreflightOperations = true
+			opts.CorsPreflightRequireAuth = true
+		}
 		fakeServiceInfo, err := configinfo.NewServiceInfoFromServiceConfig(tc.fakeServiceConfig, testConfigID, opts)
 		if err != nil {
 			t.Fatal(err)
 		}
 
 		marshaler := &jsonpb.Marshaler{}
-		filter, err := makeBackendRoutingFilter(fakeServiceInfo)
+		gotFilter, err := marshaler.MarshalToString(makeJwtAuthnFilter(fakeServiceInfo))
 		if err != nil {
 			t.Fatal(err)
 		}
 
-		gotFilter, err := marshaler.MarshalToString(filter)
+		if err := util.JsonEqual(tc.wantJwtAuthnFilter, gotFilter); err != nil {
+			t.Errorf("Test Desc(%d): %s, makeTranscoderFilter failed, got: %s, want: %s", i, tc.desc, gotFilter, tc.wantJwtAuthnFilter)
+		}
+	}
+}
+
+func TestMakeJwtRequirement(t *testing.T) {
+	testData := []struct {
+		desc            string
+		requirements    []*confpb.AuthRequirement
+		wantRequirement string
+	}{
+		{
+			desc: "Single provider",
+			requirements: []*confpb.AuthRequirement{
+				{
+					ProviderId: "provider_1",
+				},
+			},
+			wantRequirement: `{"providerName": "provider_1"}`,
+		},
+		{
+			desc: "Any of the providers",
+			requirements: []*confpb.AuthRequirement{
+				{
+					ProviderId: "provider_1",
+				},
+				{
+					ProviderId: "provider_2",
+					Audiences:  "aud_1, aud_2",
+				},
+			},
+			wantRequirement: `{
+  "requiresAny": {
+    "requirements": [
+      {"providerName": "provider_1"},
+      {"providerAndAudiences": {"providerName": "provider_2", "audiences": ["aud_1", "aud_2"]}}
+    ]
+  }
+}`,
+		},
+		{
+			desc: "All of the providers, or another one",
+			requirements: []*confpb.AuthRequirement{
+				{
+					ProviderId: "provider_1, provider_2",
+				},
+				{
+					ProviderId: "provider_3",
+				},
+			},
+			wantRequirement: `{
+  "requiresAny": {
+    "requirements": [
+      {
+        "requiresAll": {
+          "requirements": [
+            {"providerName": "provider_1"},
+            {"providerName": "provider_2"}
+          ]
+        }
+      },
+      {"providerName": "provider_3"}
+    ]
+  }
+}`,
+		},
+	}
+
+	for i, tc := range testData {
+		gotRequirement, err := util.ProtoToJson(makeJwtRequirement(tc.requirements))
 		if err != nil {
 			t.Fatal(err)
 		}
-		if err := util.JsonEqual(tc.wantBackendRoutingFilter, gotFilter); err != nil {
-			t.Errorf("Test Desc(%d): %s, makeBackendAuthFilter failed,\n %v", i, tc.desc, err)
+		if err := util.JsonEqual(tc.wantRequirement, gotRequirement); err != nil {
+			t.Errorf("Test Desc(%d): %s, makeJwtRequirement failed, %v", i, tc.desc, err)
 		}
 	}
 }
 
-func TestBackendAuthFilter(t *testing.T) {
+func TestBackendRoutingFilter(t *testing.T) {
 	testdata := []struct {
-		desc                  string
-		iamServiceAccount     string
-		fakeServiceConfig     *confpb.Service
-		delegates             []string
-		wantBackendAuthFilter string
+		desc                     string
+		BackendAddress           string
+		fakeServiceConfig        *confpb.Service
+		pathRewrites             []*options.PathRewriteOptions
+		wantBackendRoutingFilter string
 	}{
 		{
-			desc: "Success, generate backend auth filter in general",
+			desc:           "Success, generate backend routing filter for gRPC",
+			BackendAddress: "grpc://127.0.0.1:80",
 			fakeServiceConfig: &confpb.Service{
 				Name: testProjectName,
 				Apis: []*apipb.Api{
 					{
-						Name: "testapi",
+						Name: "endpoints.examples.bookstore.Bookstore",
 						Methods: []*apipb.Method{
 							{
-								Name: "foo",
+								Name: "CreateShelf",
 							},
 							{
-								Name: "bar",
+								Name: "ListShelves",
 							},
 						},
 					},
 				},
-				Backend: &confpb.Backend{
-					Rules: []*confpb.BackendRule{
+				Http: &annotationspb.Http{
+					Rules: []*annotationspb.HttpRule{
 						{
-							Selector: "ignore_me",
+							Selector: "endpoints.examples.bookstore.Bookstore.ListShelves",
+							Pattern: &annotationspb.HttpRule_Get{
+								Get: "/v1/shelves",
+							},
 						},
 						{
-							Selector:        "testapipb.foo",
-							Address:         "https://testapipb.com/foo",
-							PathTranslation: confpb.BackendRule_CONSTANT_ADDRESS,
-							Authentication: &confpb.BackendRule_JwtAudience{
-								JwtAudience: "foo.com",
+							Selector: "endpoints.examples.bookstore.Bookstore.CreateShelf",
+							Pattern: &annotationspb.HttpRule_Post{
+								Post: "/v1/shelves",
 							},
+							Body: "shelf",
 						},
+					},
+				},
+				Backend: &confpb.Backend{
+					Rules: []*confpb.BackendRule{
 						{
-							Selector:        "testapipb.bar",
+							Selector:        "endpoints.examples.bookstore.Bookstore.CreateShelf",
 							Address:         "https://testapipb.com/foo",
 							PathTranslation: confpb.BackendRule_CONSTANT_ADDRESS,
+						},
+						{
+							Selector:        "endpoints.examples.bookstore.Bookstore.ListShelves",
+							Address:         "https://testapipb.com/foo",
+							PathTranslation: confpb.BackendRule_APPEND_PATH_TO_ADDRESS,
 							Authentication: &confpb.BackendRule_JwtAudience{
 								JwtAudience: "bar.com",
 							},
@@ -676,32 +836,103 @@ func TestBackendAuthFilter(t *testing.T) {
 					},
 				},
 			},
-			wantBackendAuthFilter: `
-{
-   "name":"envoy.filters.http.backend_auth",
-   "typedConfig":{
-      "@type":"type.googleapis.com/google.api.envoy.http.backend_auth.FilterConfig",
-      "imdsToken":{
-          "cluster":"metadata-cluster",
-          "timeout":"5s",
-          "uri":"http://169.254.169.254/computeMetadata/v1/instance/service-accounts/default/identity"
-      },
-      "rules":[
-         {
-            "jwtAudience":"bar.com",
-            "operation":"testapipb.bar"
-         },
-         {
-            "jwtAudience":"foo.com",
-            "operation":"testapipb.foo"
-         }
-      ]
-   }
-}
-`,
+			wantBackendRoutingFilter: `{
+        "name": "envoy.filters.http.backend_routing",
+        "typedConfig": {
+          "@type":"type.googleapis.com/google.api.envoy.http.backend_routing.FilterConfig",
+          "rules": [
+            {
+              "isConstAddress": true,
+              "operation": "endpoints.examples.bookstore.Bookstore.CreateShelf",
+              "pathPrefix": "/foo"
+            },
+            {
+              "operation":  "endpoints.examples.bookstore.Bookstore.ListShelves",
+              "pathPrefix": "/foo"
+            }
+          ]
+        }
+      }`,
 		},
 		{
-			desc: "Success, generate backend auth filter with allow Cors",
+			desc:           "Success, generate backend routing filter for http",
+			BackendAddress: "http://127.0.0.1:80",
+			fakeServiceConfig: &confpb.Service{
+				Name: testProjectName,
+				Apis: []*apipb.Api{
+					{
+						Name: "testapi",
+						Methods: []*apipb.Method{
+							{
+								Name: "foo",
+							},
+							{
+								Name: "bar",
+							},
+						},
+					},
+				},
+				Http: &annotationspb.Http{
+					Rules: []*annotationspb.HttpRule{
+						{
+							Selector: "testapi.foo",
+							Pattern: &annotationspb.HttpRule_Get{
+								Get: "foo",
+							},
+						},
+						{
+							Selector: "testapi.bar",
+							Pattern: &annotationspb.HttpRule_Get{
+								Get: "bar",
+							},
+						},
+					},
+				},
+				Backend: &confpb.Backend{
+					Rules: []*confpb.BackendRule{
+						{
+							Selector: "ignore_me",
+						},
+						{
+							Selector:        "testapi.foo",
+							Address:         "https://testapipb.com/foo",
+							PathTranslation: confpb.BackendRule_CONSTANT_ADDRESS,
+							Authentication: &confpb.BackendRule_JwtAudience{
+								JwtAudience: "foo.com",
+							},
+						},
+						{
+							Selector:        "testapi.bar",
+							Address:         "https://testapipb.com/foo",
+							PathTranslation: confpb.BackendRule_APPEND_PATH_TO_ADDRESS,
+							Authentication: &confpb.BackendRule_JwtAudience{
+								JwtAudience: "bar.com",
+							},
+						},
+					},
+				},
+			},
+			wantBackendRoutingFilter: `{
+        "name": "envoy.filters.http.backend_routing",
+        "typedConfig": {
+          "@type":"type.googleapis.com/google.api.envoy.http.backend_routing.FilterConfig",
+          "rules": [
+            {
+              "operation": "testapi.bar",
+              "pathPrefix": "/foo"
+            },
+            {
+              "isConstAddress": true,
+              "operation":"testapi.foo",
+              "pathPrefix": "/foo"
+            }
+          ]
+        }
+      }`,
+		},
+		{
+			desc:           "Success, generate backend routing filter with allow Cors",
+			BackendAddress: "http://127.0.0.1:80",
 			fakeServiceConfig: &confpb.Service{
 				Name: testProjectName,
 				Endpoints: []*confpb.Endpoint{
@@ -726,13 +957,13 @@ func TestBackendAuthFilter(t *testing.T) {
 				Http: &annotationspb.Http{
 					Rules: []*annotationspb.HttpRule{
 						{
-							Selector: "get_testapi.foo",
+							Selector: "testapi.foo",
 							Pattern: &annotationspb.HttpRule_Get{
 								Get: "foo",
 							},
 						},
 						{
-							Selector: "get_testapi.bar",
+							Selector: "testapi.bar",
 							Pattern: &annotationspb.HttpRule_Get{
 								Get: "bar",
 							},
@@ -745,7 +976,7 @@ func TestBackendAuthFilter(t *testing.T) {
 							Selector: "ignore_me",
 						},
 						{
-							Selector:        "get_testapi.foo",
+							Selector:        "testapi.foo",
 							Address:         "https://testapipb.com/foo",
 							PathTranslation: confpb.BackendRule_CONSTANT_ADDRESS,
 							Authentication: &confpb.BackendRule_JwtAudience{
@@ -753,7 +984,7 @@ func TestBackendAuthFilter(t *testing.T) {
 							},
 						},
 						{
-							Selector:        "get_testapi.bar",
+							Selector:        "testapi.bar",
 							Address:         "https://testapipb.com/bar",
 							PathTranslation: confpb.BackendRule_APPEND_PATH_TO_ADDRESS,
 							Authentication: &confpb.BackendRule_JwtAudience{
@@ -763,193 +994,230 @@ func TestBackendAuthFilter(t *testing.T) {
 					},
 				},
 			},
-			wantBackendAuthFilter: `{
-        "name":"envoy.filters.http.backend_auth",
-        "typedConfig":{
-          "@type":"type.googleapis.com/google.api.envoy.http.backend_auth.FilterConfig",
-          "imdsToken":{
-            "cluster":"metadata-cluster",
-            "timeout":"5s",
-            "uri":"http://169.254.169.254/computeMetadata/v1/instance/service-accounts/default/identity"
-          },
-          "rules":[
+			wantBackendRoutingFilter: `{
+        "name": "envoy.filters.http.backend_routing",
+        "typedConfig": {
+          "@type":"type.googleapis.com/google.api.envoy.http.backend_routing.FilterConfig",
+          "rules": [
             {
-            	"jwtAudience": "bar.com",
-            	"operation": "get_testapi.CORS_bar"
+              "operation":"testapi.CORS_bar",
+              "pathPrefix": "/bar"
             },
             {
-            	"jwtAudience": "foo.com",
-            	"operation":"get_testapi.CORS_foo"
+              "isConstAddress": true,
+              "operation":"testapi.CORS_foo",
+              "pathPrefix": "/foo"
             },
             {
-              "jwtAudience": "bar.com",
-              "operation": "get_testapi.bar"
+              "operation": "testapi.bar",
+              "pathPrefix": "/bar"
             },
             {
-              "jwtAudience": "foo.com",
-              "operation": "get_testapi.foo"
+              "isConstAddress": true,
+              "operation":"testapi.foo",
+              "pathPrefix": "/foo"
             }
           ]
         }
       }`,
 		},
 		{
-			desc:              "Success, set iamIdToken when iam service account is set",
-			iamServiceAccount: "service-account@google.com",
-			delegates:         []string{"delegate_foo", "delegate_bar", "delegate_baz"},
+			desc:           "Success, generate backend routing filter for path rewrites",
+			BackendAddress: "http://127.0.0.1:80",
 			fakeServiceConfig: &confpb.Service{
 				Name: testProjectName,
 				Apis: []*apipb.Api{
 					{
 						Name: "testapi",
+						Methods: []*apipb.Method{
+							{
+								Name: "foo",
+							},
+							{
+								Name: "bar",
+							},
+						},
+					},
+				},
+				Http: &annotationspb.Http{
+					Rules: []*annotationspb.HttpRule{
+						{
+							Selector: "testapi.foo",
+							Pattern: &annotationspb.HttpRule_Get{
+								Get: "/v1/foo",
+							},
+						},
+						{
+							Selector: "testapi.bar",
+							Pattern: &annotationspb.HttpRule_Get{
+								Get: "/v1/bar/{id}",
+							},
+						},
 					},
 				},
 				Backend: &confpb.Backend{
 					Rules: []*confpb.BackendRule{
 						{
-							Selector:        "testapipb.bar",
+							Selector:        "testapi.foo",
 							Address:         "https://testapipb.com/foo",
 							PathTranslation: confpb.BackendRule_CONSTANT_ADDRESS,
-							Authentication: &confpb.BackendRule_JwtAudience{
-								JwtAudience: "bar.com",
-							},
 						},
 					},
 				},
 			},
-			wantBackendAuthFilter: `
-{
-   "name":"envoy.filters.http.backend_auth",
-   "typedConfig":{
-      "@type":"type.googleapis.com/google.api.envoy.http.backend_auth.FilterConfig",
-      "iamToken":{
-         "accessToken":{
-            "remoteToken":{
-               "cluster":"metadata-cluster",
-               "timeout":"5s",
-               "uri":"http://169.254.169.254/computeMetadata/v1/instance/service-accounts/default/token"
+			pathRewrites: []*options.PathRewriteOptions{
+				{
+					Selector:      "testapi.foo",
+					Prefix:        "/v1.",
+					PrefixRewrite: `/api\`,
+				},
+				{
+					Selector:     "testapi.bar",
+					Regex:        "^/v1/bar/([^/]+)$",
+					Substitution: `/bar/\1`,
+				},
+			},
+			wantBackendRoutingFilter: `{
+        "name": "envoy.filters.http.backend_routing",
+        "typedConfig": {
+          "@type":"type.googleapis.com/google.api.envoy.http.backend_routing.FilterConfig",
+          "rules": [
+            {
+              "operation": "testapi.bar",
+              "regex": "^/v1/bar/([^/]+)$",
+              "regexSubstitution": "/bar/\\1"
+            },
+            {
+              "operation":"testapi.foo",
+              "regex": "^/v1\\.",
+              "regexSubstitution": "/api\\\\"
             }
-         },
-         "iamUri":{
-            "cluster":"iam-cluster",
-            "timeout":"5s",
-            "uri":"https://iamcredentials.googleapis.com/v1/projects/-/serviceAccounts/service-account@google.com:generateIdToken"
-         },
-         "delegates":["delegate_foo","delegate_bar","delegate_baz"],
-         "serviceAccountEmail":"service-account@google.com"
-      },
-      "rules":[
-         {
-            "jwtAudience":"bar.com",
-            "operation":"testapipb.bar"
-         }
-      ]
-   }
-}
-`,
+          ]
+        }
+      }`,
 		},
 	}
 
 	for i, tc := range testdata {
 		opts := options.DefaultConfigGeneratorOptions()
-		opts.BackendAddress = "grpc://127.0.0.1:80"
-		if tc.iamServiceAccount != "" {
-			opts.BackendAuthCredentials = &options.IAMCredentialsOptions{
-				ServiceAccountEmail: tc.iamServiceAccount,
-				TokenKind:           options.IDToken,
-				Delegates:           tc.delegates,
-			}
-		}
-
+		opts.BackendAddress = tc.BackendAddress
+		opts.PathRewrites = tc.pathRewrites
 		fakeServiceInfo, err := configinfo.NewServiceInfoFromServiceConfig(tc.fakeServiceConfig, testConfigID, opts)
 		if err != nil {
 			t.Fatal(err)
 		}
 
 		marshaler := &jsonpb.Marshaler{}
-		gotFilter, err := marshaler.MarshalToString(makeBackendAuthFilter(fakeServiceInfo))
+		filter, err := makeBackendRoutingFilter(fakeServiceInfo)
 		if err != nil {
 			t.Fatal(err)
 		}
-		if err := util.JsonEqual(tc.wantBackendAuthFilter, gotFilter); err != nil {
+
+		gotFilter, err := marshaler.MarshalToString(filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := util.JsonEqual(tc.wantBackendRoutingFilter, gotFilter); err != nil {
 			t.Errorf("Test Desc(%d): %s, makeBackendAuthFilter failed,\n %v", i, tc.desc, err)
 		}
 	}
 }
 
-func TestPathMatcherFilter(t *testing.T) {
-	var testData = []struct {
+func TestBackendAuthFilter(t *testing.T) {
+	testdata := []struct {
 		desc                  string
+		iamServiceAccount     string
 		fakeServiceConfig     *confpb.Service
-		BackendAddress        string
-		healthz               string
-		wantPathMatcherFilter string
+		delegates             []string
+		backendClusters       []*options.BackendClusterOptions
+		backendOAuth2         []*options.BackendOAuth2Options
+		standalone            bool
+		wantBackendAuthFilter string
 	}{
 		{
-			desc: "Path Matcher filter with Healthz - gRPC backend",
+			desc: "Success, generate backend auth filter in general",
 			fakeServiceConfig: &confpb.Service{
 				Name: testProjectName,
 				Apis: []*apipb.Api{
 					{
-						Name: testApiName,
+						Name: "testapi",
 						Methods: []*apipb.Method{
 							{
-								Name: "ListShelves",
+								Name: "foo",
 							},
 							{
-								Name: "CreateShelf",
+								Name: "bar",
+							},
+						},
+					},
+				},
+				Backend: &confpb.Backend{
+					Rules: []*confpb.BackendRule{
+						{
+							Selector: "ignore_me",
+						},
+						{
+							Selector:        "testapipb.foo",
+							Address:         "https://testapipb.com/foo",
+							PathTranslation: confpb.BackendRule_CONSTANT_ADDRESS,
+							Authentication: &confpb.BackendRule_JwtAudience{
+								JwtAudience: "foo.com",
+							},
+						},
+						{
+							Selector:        "testapipb.bar",
+							Address:         "https://testapipb.com/foo",
+							PathTranslation: confpb.BackendRule_CONSTANT_ADDRESS,
+							Authentication: &confpb.BackendRule_JwtAudience{
+								JwtAudience: "bar.com",
 							},
 						},
 					},
 				},
 			},
-			BackendAddress: "grpc://127.0.0.1:80",
-			healthz:        "healthz",
-			wantPathMatcherFilter: `
+			wantBackendAuthFilter: `
 {
-   "name":"envoy.filters.http.path_matcher",
+   "name":"envoy.filters.http.backend_auth",
    "typedConfig":{
-      "@type":"type.googleapis.com/google.api.envoy.http.path_matcher.FilterConfig",
+      "@type":"type.googleapis.com/google.api.envoy.http.backend_auth.FilterConfig",
+      "imdsToken":{
+          "cluster":"metadata-cluster",
+          "timeout":"5s",
+          "uri":"http://169.254.169.254/computeMetadata/v1/instance/service-accounts/default/identity"
+      },
       "rules":[
          {
-            "operation":"ESPv2.HealthCheck",
-            "pattern":{
-               "httpMethod":"GET",
-               "uriTemplate":"/healthz"
-            }
-         },
-	 {
-            "operation":"endpoints.examples.bookstore.Bookstore.CreateShelf",
-            "pattern":{
-               "httpMethod":"POST",
-               "uriTemplate":"/endpoints.examples.bookstore.Bookstore/CreateShelf"
-            }
+            "jwtAudience":"bar.com",
+            "operation":"testapipb.bar"
          },
          {
-            "operation":"endpoints.examples.bookstore.Bookstore.ListShelves",
-            "pattern":{
-               "httpMethod":"POST",
-               "uriTemplate":"/endpoints.examples.bookstore.Bookstore/ListShelves"
-            }
+            "jwtAudience":"foo.com",
+            "operation":"testapipb.foo"
          }
       ]
    }
 }
-			      `,
+`,
 		},
 		{
-			desc: "Path Matcher filter with Healthz - HTTP backend",
+			desc: "Success, generate backend auth filter with allow Cors",
 			fakeServiceConfig: &confpb.Service{
 				Name: testProjectName,
+				Endpoints: []*confpb.Endpoint{
+					{
+						Name:      testProjectName,
+						AllowCors: true,
+					},
+				},
 				Apis: []*apipb.Api{
 					{
-						Name: "1.echo_api_endpoints_cloudesf_testing_cloud_goog",
+						Name: "testapi",
 						Methods: []*apipb.Method{
 							{
-								Name: "Echo_Auth_Jwt",
+								Name: "foo",
 							},
 							{
-								Name: "Echo",
+								Name: "bar",
 							},
 						},
 					},
@@ -957,68 +1225,143 @@ func TestPathMatcherFilter(t *testing.T) {
 				Http: &annotationspb.Http{
 					Rules: []*annotationspb.HttpRule{
 						{
-							Selector: "1.echo_api_endpoints_cloudesf_testing_cloud_goog.Echo_Auth_Jwt",
+							Selector: "get_testapi.foo",
 							Pattern: &annotationspb.HttpRule_Get{
-								Get: "/auth/info/googlejwt",
+								Get: "foo",
 							},
 						},
 						{
-							Selector: "1.echo_api_endpoints_cloudesf_testing_cloud_goog.Echo",
-							Pattern: &annotationspb.HttpRule_Post{
-								Post: "/echo",
+							Selector: "get_testapi.bar",
+							Pattern: &annotationspb.HttpRule_Get{
+								Get: "bar",
+							},
+						},
+					},
+				},
+				Backend: &confpb.Backend{
+					Rules: []*confpb.BackendRule{
+						{
+							Selector: "ignore_me",
+						},
+						{
+							Selector:        "get_testapi.foo",
+							Address:         "https://testapipb.com/foo",
+							PathTranslation: confpb.BackendRule_CONSTANT_ADDRESS,
+							Authentication: &confpb.BackendRule_JwtAudience{
+								JwtAudience: "foo.com",
+							},
+						},
+						{
+							Selector:        "get_testapi.bar",
+							Address:         "https://testapipb.com/bar",
+							PathTranslation: confpb.BackendRule_APPEND_PATH_TO_ADDRESS,
+							Authentication: &confpb.BackendRule_JwtAudience{
+								JwtAudience: "bar.com",
 							},
-							Body: "message",
 						},
 					},
 				},
 			},
-			BackendAddress: "http://127.0.0.1:80",
-			healthz:        "/",
-			wantPathMatcherFilter: `
-			        {
-   "name":"envoy.filters.http.path_matcher",
+			wantBackendAuthFilter: `{
+        "name":"envoy.filters.http.backend_auth",
+        "typedConfig":{
+          "@type":"type.googleapis.com/google.api.envoy.http.backend_auth.FilterConfig",
+          "imdsToken":{
+            "cluster":"metadata-cluster",
+            "timeout":"5s",
+            "uri":"http://169.254.169.254/computeMetadata/v1/instance/service-accounts/default/identity"
+          },
+          "rules":[
+            {
+            	"jwtAudience": "bar.com",
+            	"operation": "get_testapi.CORS_bar"
+            },
+            {
+            	"jwtAudience": "foo.com",
+            	"operation":"get_testapi.CORS_foo"
+            },
+            {
+              "jwtAudience": "bar.com",
+              "operation": "get_testapi.bar"
+            },
+            {
+              "jwtAudience": "foo.com",
+              "operation": "get_testapi.foo"
+            }
+          ]
+        }
+      }`,
+		},
+		{
+			desc:              "Success, set iamIdToken when iam service account is set",
+			iamServiceAccount: "service-account@google.com",
+			delegates:         []string{"delegate_foo", "delegate_bar", "delegate_baz"},
+			fakeServiceConfig: &confpb.Service{
+				Name: testProjectName,
+				Apis: []*apipb.Api{
+					{
+						Name: "testapi",
+					},
+				},
+				Backend: &confpb.Backend{
+					Rules: []*confpb.BackendRule{
+						{
+							Selector:        "testapipb.bar",
+							Address:         "https://testapipb.com/foo",
+							PathTranslation: confpb.BackendRule_CONSTANT_ADDRESS,
+							Authentication: &confpb.BackendRule_JwtAudience{
+								JwtAudience: "bar.com",
+							},
+						},
+					},
+				},
+			},
+			wantBackendAuthFilter: `
+{
+   "name":"envoy.filters.http.backend_auth",
    "typedConfig":{
-      "@type":"type.googleapis.com/google.api.envoy.http.path_matcher.FilterConfig",
-      "rules":[
-         {
-            "operation":"1.echo_api_endpoints_cloudesf_testing_cloud_goog.Echo",
-            "pattern":{
-               "httpMethod":"POST",
-               "uriTemplate":"/echo"
+      "@type":"type.googleapis.com/google.api.envoy.http.backend_auth.FilterConfig",
+      "iamToken":{
+         "accessToken":{
+            "remoteToken":{
+               "cluster":"metadata-cluster",
+               "timeout":"5s",
+               "uri":"http://169.254.169.254/computeMetadata/v1/instance/service-accounts/default/token"
             }
          },
-         {
-            "operation":"1.echo_api_endpoints_cloudesf_testing_cloud_goog.Echo_Auth_Jwt",
-            "pattern":{
-               "httpMethod":"GET",
-               "uriTemplate":"/auth/info/googlejwt"
-            }
+         "iamUri":{
+            "cluster":"iam-cluster",
+            "timeout":"5s",
+            "uri":"https://iamcredentials.googleapis.com/v1/projects/-/serviceAccounts/service-account@google.com:generateIdToken"
          },
+         "delegates":["delegate_foo","delegate_bar","delegate_baz"],
+         "serviceAccountEmail":"service-account@google.com"
+      },
+      "rules":[
          {
-            "operation":"ESPv2.HealthCheck",
-            "pattern":{
-               "httpMethod":"GET",
-               "uriTemplate":"/"
-            }
+            "jwtAudience":"bar.com",
+            "operation":"testapipb.bar"
          }
       ]
    }
 }
-			      `,
+`,
 		},
 		{
-			desc: "Path Matcher filter - HTTP backend with path parameters",
+			desc:              "Success, override the IAM delegates per backend",
+			iamServiceAccount: "service-account@google.com",
+			delegates:         []string{"delegate_foo"},
 			fakeServiceConfig: &confpb.Service{
-				Name: "foo.endpoints.bar.cloud.goog",
+				Name: testProjectName,
 				Apis: []*apipb.Api{
 					{
-						Name: "1.cloudesf_testing_cloud_goog",
+						Name: "testapi",
 						Methods: []*apipb.Method{
 							{
-								Name: "Foo",
+								Name: "foo",
 							},
 							{
-								Name: "Bar",
+								Name: "bar",
 							},
 						},
 					},
@@ -1026,144 +1369,150 @@ func TestPathMatcherFilter(t *testing.T) {
 				Backend: &confpb.Backend{
 					Rules: []*confpb.BackendRule{
 						{
-							Address:         "https://mybackend.com",
-							Selector:        "1.cloudesf_testing_cloud_goog.Foo",
+							Selector:        "testapipb.foo",
+							Address:         "https://foo.example.com/foo",
 							PathTranslation: confpb.BackendRule_CONSTANT_ADDRESS,
 							Authentication: &confpb.BackendRule_JwtAudience{
-								JwtAudience: "mybackend.com",
+								JwtAudience: "audience.com",
 							},
 						},
 						{
-							Address:         "https://mybackend.com",
-							Selector:        "1.cloudesf_testing_cloud_goog.Bar",
-							PathTranslation: confpb.BackendRule_APPEND_PATH_TO_ADDRESS,
+							Selector:        "testapipb.bar",
+							Address:         "https://bar.example.com/bar",
+							PathTranslation: confpb.BackendRule_CONSTANT_ADDRESS,
 							Authentication: &confpb.BackendRule_JwtAudience{
-								JwtAudience: "mybackend.com",
+								JwtAudience: "audience.com",
 							},
 						},
 					},
 				},
-				Http: &annotationspb.Http{
-					Rules: []*annotationspb.HttpRule{
-						{
-							Selector: "1.cloudesf_testing_cloud_goog.Foo",
-							Pattern: &annotationspb.HttpRule_Get{
-								Get: "foo/{id}",
-							},
-						},
-						{
-							Selector: "1.cloudesf_testing_cloud_goog.Bar",
-							Pattern: &annotationspb.HttpRule_Get{
-								Get: "foo",
-							},
-						},
-					},
+			},
+			backendClusters: []*options.BackendClusterOptions{
+				{
+					BackendAddress: "https://bar.example.com",
+					IamDelegates:   []string{"delegate_bar", "delegate_baz"},
 				},
 			},
-			BackendAddress: "http://127.0.0.1:80",
-			wantPathMatcherFilter: `
-			        {
-   "name":"envoy.filters.http.path_matcher",
+			wantBackendAuthFilter: `
+{
+   "name":"envoy.filters.http.backend_auth",
    "typedConfig":{
-      "@type":"type.googleapis.com/google.api.envoy.http.path_matcher.FilterConfig",
+      "@type":"type.googleapis.com/google.api.envoy.http.backend_auth.FilterConfig",
+      "iamToken":{
+         "accessToken":{
+            "remoteToken":{
+               "cluster":"metadata-cluster",
+               "timeout":"5s",
+               "uri":"http://169.254.169.254/computeMetadata/v1/instance/service-accounts/default/token"
+            }
+         },
+         "iamUri":{
+            "cluster":"iam-cluster",
+            "timeout":"5s",
+            "uri":"https://iamcredentials.googleapis.com/v1/projects/-/serviceAccounts/service-account@google.com:generateIdToken"
+         },
+         "delegates":["delegate_foo"],
+         "serviceAccountEmail":"service-account@google.com"
+      },
       "rules":[
          {
-            "operation":"1.cloudesf_testing_cloud_goog.Bar",
-            "pattern":{
-               "httpMethod":"GET",
-               "uriTemplate":"foo"
-            }
+            "jwtAudience":"audience.com",
+            "operation":"testapipb.bar",
+            "delegates":["delegate_bar","delegate_baz"]
          },
          {
-            "extractPathParameters":true,
-            "operation":"1.cloudesf_testing_cloud_goog.Foo",
-            "pattern":{
-               "httpMethod":"GET",
-               "uriTemplate":"foo/{id}"
-            }
+            "jwtAudience":"audience.com",
+            "operation":"testapipb.foo"
          }
       ]
    }
 }
-			      `,
+`,
 		},
 		{
-			desc: "Path Matcher filter - CORS support",
+			desc: "Success, fetch the access token from the token agent for backends with OAuth2 client credentials",
 			fakeServiceConfig: &confpb.Service{
-				Name: "foo.endpoints.bar.cloud.goog",
+				Name: testProjectName,
 				Apis: []*apipb.Api{
 					{
-						Name: "1.cloudesf_testing_cloud_goog",
+						Name: "testapi",
 						Methods: []*apipb.Method{
 							{
-								Name: "Foo",
+								Name: "foo",
+							},
+							{
+								Name: "bar",
 							},
 						},
 					},
 				},
-				Endpoints: []*confpb.Endpoint{
-					{
-						Name:      "foo.endpoints.bar.cloud.goog",
-						AllowCors: true,
-					},
-				},
-				Http: &annotationspb.Http{
-					Rules: []*annotationspb.HttpRule{
+				Backend: &confpb.Backend{
+					Rules: []*confpb.BackendRule{
 						{
-							Selector: "1.cloudesf_testing_cloud_goog.Foo",
-							Pattern: &annotationspb.HttpRule_Get{
-								Get: "foo",
+							Selector:        "testapipb.foo",
+							Address:         "https://testapipb.com/foo",
+							PathTranslation: confpb.BackendRule_CONSTANT_ADDRESS,
+							Authentication: &confpb.BackendRule_JwtAudience{
+								JwtAudience: "foo.com",
 							},
 						},
+						{
+							Selector:        "testapipb.bar",
+							Address:         "https://oauth2.example.com/bar",
+							PathTranslation: confpb.BackendRule_CONSTANT_ADDRESS,
+						},
 					},
 				},
 			},
-			BackendAddress: "http://127.0.0.1:80",
-			wantPathMatcherFilter: `
-			        {
-   "name":"envoy.filters.http.path_matcher",
+			backendOAuth2: []*options.BackendOAuth2Options{
+				{
+					BackendAddress: "https://oauth2.example.com",
+					TokenURL:       "https://auth.example.com/token",
+					ClientID:       "client-id",
+					ClientSecret:   "client-secret",
+				},
+			},
+			wantBackendAuthFilter: `
+{
+   "name":"envoy.filters.http.backend_auth",
    "typedConfig":{
-      "@type":"type.googleapis.com/google.api.envoy.http.path_matcher.FilterConfig",
+      "@type":"type.googleapis.com/google.api.envoy.http.backend_auth.FilterConfig",
+      "imdsToken":{
+          "cluster":"metadata-cluster",
+          "timeout":"5s",
+          "uri":"http://169.254.169.254/computeMetadata/v1/instance/service-accounts/default/identity"
+      },
       "rules":[
          {
-            "operation":"1.cloudesf_testing_cloud_goog.CORS_foo",
-            "pattern":{
-               "httpMethod":"OPTIONS",
-               "uriTemplate":"foo"
-            }
+            "accessTokenUri":{
+               "cluster":"token-agent-cluster",
+               "timeout":"5s",
+               "uri":"http://127.0.0.1:8791/v1/backendOAuth2Token/oauth2.example.com:443"
+            },
+            "operation":"testapipb.bar"
          },
          {
-            "operation":"1.cloudesf_testing_cloud_goog.Foo",
-            "pattern":{
-               "httpMethod":"GET",
-               "uriTemplate":"foo"
-            }
+            "jwtAudience":"foo.com",
+            "operation":"testapipb.foo"
          }
       ]
    }
 }
-			      `,
+`,
 		},
 		{
-			desc: "Path Matcher filter - Segment Name Mapping for snake-case field",
+			desc: "Success, skip the ID tokens of the metadata server in the standalone profile",
 			fakeServiceConfig: &confpb.Service{
-				Name: "foo.endpoints.bar.cloud.goog",
+				Name: testProjectName,
 				Apis: []*apipb.Api{
 					{
-						Name: "1.cloudesf_testing_cloud_goog",
+						Name: "testapi",
 						Methods: []*apipb.Method{
 							{
-								Name: "Foo",
+								Name: "foo",
 							},
-						},
-					},
-				},
-				Types: []*ptypepb.Type{
-					{
-						Fields: []*ptypepb.Field{
 							{
-								JsonName: "fooBar",
-								Name:     "foo_bar",
+								Name: "bar",
 							},
 						},
 					},
@@ -1171,89 +1520,103 @@ func TestPathMatcherFilter(t *testing.T) {
 				Backend: &confpb.Backend{
 					Rules: []*confpb.BackendRule{
 						{
-							Address:         "https://mybackend.com",
-							Selector:        "1.cloudesf_testing_cloud_goog.Foo",
+							Selector:        "testapipb.foo",
+							Address:         "https://testapipb.com/foo",
 							PathTranslation: confpb.BackendRule_CONSTANT_ADDRESS,
 							Authentication: &confpb.BackendRule_JwtAudience{
-								JwtAudience: "mybackend.com",
+								JwtAudience: "foo.com",
 							},
 						},
-					},
-				},
-				Http: &annotationspb.Http{
-					Rules: []*annotationspb.HttpRule{
 						{
-							Selector: "1.cloudesf_testing_cloud_goog.Foo",
-							Pattern: &annotationspb.HttpRule_Get{
-								Get: "foo/{foo_bar}",
-							},
+							Selector:        "testapipb.bar",
+							Address:         "https://oauth2.example.com/bar",
+							PathTranslation: confpb.BackendRule_CONSTANT_ADDRESS,
 						},
 					},
 				},
 			},
-			BackendAddress: "http://127.0.0.1:80",
-			wantPathMatcherFilter: `
-			        {
-   "name":"envoy.filters.http.path_matcher",
+			backendOAuth2: []*options.BackendOAuth2Options{
+				{
+					BackendAddress: "https://oauth2.example.com",
+					TokenURL:       "https://auth.example.com/token",
+					ClientID:       "client-id",
+					ClientSecret:   "client-secret",
+				},
+			},
+			standalone: true,
+			wantBackendAuthFilter: `
+{
+   "name":"envoy.filters.http.backend_auth",
    "typedConfig":{
-      "@type":"type.googleapis.com/google.api.envoy.http.path_matcher.FilterConfig",
+      "@type":"type.googleapis.com/google.api.envoy.http.backend_auth.FilterConfig",
       "rules":[
          {
-            "extractPathParameters":true,
-            "operation":"1.cloudesf_testing_cloud_goog.Foo",
-            "pattern":{
-               "httpMethod":"GET",
-               "uriTemplate":"foo/{foo_bar}"
-            }
-         }
-      ],
-      "segmentNames":[
-         {
-            "jsonName":"fooBar",
-            "snakeName":"foo_bar"
+            "accessTokenUri":{
+               "cluster":"token-agent-cluster",
+               "timeout":"5s",
+               "uri":"http://127.0.0.1:8791/v1/backendOAuth2Token/oauth2.example.com:443"
+            },
+            "operation":"testapipb.bar"
          }
       ]
    }
-}`,
+}
+`,
 		},
 	}
-	for i, tc := range testData {
+
+	for i, tc := range testdata {
 		opts := options.DefaultConfigGeneratorOptions()
-		opts.BackendAddress = tc.BackendAddress
-		opts.Healthz = tc.healthz
+		opts.BackendAddress = "grpc://127.0.0.1:80"
+		opts.BackendClusters = tc.backendClusters
+		opts.BackendOAuth2 = tc.backendOAuth2
+		opts.Standalone = tc.standalone
+		if tc.iamServiceAccount != "" {
+			opts.BackendAuthCredentials = &options.IAMCredentialsOptions{
+				ServiceAccountEmail: tc.iamServiceAccount,
+				TokenKind:           options.IDToken,
+				Delegates:           tc.delegates,
+			}
+		}
+
 		fakeServiceInfo, err := configinfo.NewServiceInfoFromServiceConfig(tc.fakeServiceConfig, testConfigID, opts)
 		if err != nil {
 			t.Fatal(err)
 		}
+
 		marshaler := &jsonpb.Marshaler{}
-		gotFilter, err := marshaler.MarshalToString(makePathMatcherFilter(fakeServiceInfo))
+		gotFilter, err := marshaler.MarshalToString(makeBackendAuthFilter(fakeServiceInfo))
 		if err != nil {
 			t.Fatal(err)
 		}
-		if err := util.JsonEqual(tc.wantPathMatcherFilter, gotFilter); err != nil {
-			t.Errorf("Test Desc(%d): %s, makePathMatcherFilter failed, \n %v", i, tc.desc, err)
+		if err := util.JsonEqual(tc.wantBackendAuthFilter, gotFilter); err != nil {
+			t.Errorf("Test Desc(%d): %s, makeBackendAuthFilter failed,\n %v", i, tc.desc, err)
 		}
 	}
 }
 
-func TestHealthCheckFilter(t *testing.T) {
-	testdata := []struct {
-		desc                  string
-		BackendAddress        string
-		healthz               string
-		fakeServiceConfig     *confpb.Service
-		wantHealthCheckFilter string
+func TestPathMatcherFilter(t *testing.T) {
+	var testData = []struct {
+		desc                     string
+		fakeServiceConfig        *confpb.Service
+		BackendAddress           string
+		healthz                  string
+		unmatchedPathBody        string
+		unmatchedPathContentType string
+		unmatchedPathRedirectUrl string
+		wantPathMatcherFilter    string
 	}{
 		{
-			desc:           "Success, generate health check filter for gRPC",
-			BackendAddress: "grpc://127.0.0.1:80",
-			healthz:        "healthz",
+			desc: "Path Matcher filter with Healthz - gRPC backend",
 			fakeServiceConfig: &confpb.Service{
 				Name: testProjectName,
 				Apis: []*apipb.Api{
 					{
-						Name: "endpoints.examples.bookstore.Bookstore",
+						Name: testApiName,
 						Methods: []*apipb.Method{
+							{
+								Name: "ListShelves",
+							},
 							{
 								Name: "CreateShelf",
 							},
@@ -1261,33 +1624,143 @@ func TestHealthCheckFilter(t *testing.T) {
 					},
 				},
 			},
-			wantHealthCheckFilter: `{
-        "name": "envoy.health_check",
-        "typedConfig": {
-          "@type":"type.googleapis.com/envoy.config.filter.http.health_check.v2.HealthCheck",
-          "passThroughMode":false,
-          "headers": [
-            {
-              "exactMatch": "/healthz",
-              "name":":path"
+			BackendAddress: "grpc://127.0.0.1:80",
+			healthz:        "healthz",
+			wantPathMatcherFilter: `
+{
+   "name":"envoy.filters.http.path_matcher",
+   "typedConfig":{
+      "@type":"type.googleapis.com/google.api.envoy.http.path_matcher.FilterConfig",
+      "rules":[
+         {
+            "operation":"ESPv2.HealthCheck",
+            "pattern":{
+               "httpMethod":"GET",
+               "uriTemplate":"/healthz"
             }
-          ]
-        }
-      }`,
+         },
+	 {
+            "operation":"endpoints.examples.bookstore.Bookstore.CreateShelf",
+            "pattern":{
+               "httpMethod":"POST",
+               "uriTemplate":"/endpoints.examples.bookstore.Bookstore/CreateShelf"
+            }
+         },
+         {
+            "operation":"endpoints.examples.bookstore.Bookstore.ListShelves",
+            "pattern":{
+               "httpMethod":"POST",
+               "uriTemplate":"/endpoints.examples.bookstore.Bookstore/ListShelves"
+            }
+         }
+      ]
+   }
+}
+			      `,
 		},
 		{
-			desc:           "Success, generate health check filter for http",
-			BackendAddress: "http://127.0.0.1:80",
-			healthz:        "/",
+			desc: "Path Matcher filter with Healthz - HTTP backend",
 			fakeServiceConfig: &confpb.Service{
-				Name: "foo.endpoints.bar.cloud.goog",
+				Name: testProjectName,
 				Apis: []*apipb.Api{
 					{
-						Name: "1.cloudesf_testing_cloud_goog",
+						Name: "1.echo_api_endpoints_cloudesf_testing_cloud_goog",
+						Methods: []*apipb.Method{
+							{
+								Name: "Echo_Auth_Jwt",
+							},
+							{
+								Name: "Echo",
+							},
+						},
+					},
+				},
+				Http: &annotationspb.Http{
+					Rules: []*annotationspb.HttpRule{
+						{
+							Selector: "1.echo_api_endpoints_cloudesf_testing_cloud_goog.Echo_Auth_Jwt",
+							Pattern: &annotationspb.HttpRule_Get{
+								Get: "/auth/info/googlejwt",
+							},
+						},
+						{
+							Selector: "1.echo_api_endpoints_cloudesf_testing_cloud_goog.Echo",
+							Pattern: &annotationspb.HttpRule_Post{
+								Post: "/echo",
+							},
+							Body: "message",
+						},
+					},
+				},
+			},
+			BackendAddress: "http://127.0.0.1:80",
+			healthz:        "/",
+			wantPathMatcherFilter: `
+			        {
+   "name":"envoy.filters.http.path_matcher",
+   "typedConfig":{
+      "@type":"type.googleapis.com/google.api.envoy.http.path_matcher.FilterConfig",
+      "rules":[
+         {
+            "operation":"1.echo_api_endpoints_cloudesf_testing_cloud_goog.Echo",
+            "pattern":{
+               "httpMethod":"POST",
+               "uriTemplate":"/echo"
+            }
+         },
+         {
+            "operation":"1.echo_api_endpoints_cloudesf_testing_cloud_goog.Echo_Auth_Jwt",
+            "pattern":{
+               "httpMethod":"GET",
+               "uriTemplate":"/auth/info/googlejwt"
+            }
+         },
+         {
+            "operation":"ESPv2.HealthCheck",
+            "pattern":{
+               "httpMethod":"GET",
+               "uriTemplate":"/"
+            }
+         }
+      ]
+   }
+}
+			      `,
+		},
+		{
+			desc: "Path Matcher filter - HTTP backend with path parameters",
+			fakeServiceConfig: &confpb.Service{
+				Name: "foo.endpoints.bar.cloud.goog",
+				Apis: []*apipb.Api{
+					{
+						Name: "1.cloudesf_testing_cloud_goog",
 						Methods: []*apipb.Method{
 							{
 								Name: "Foo",
 							},
+							{
+								Name: "Bar",
+							},
+						},
+					},
+				},
+				Backend: &confpb.Backend{
+					Rules: []*confpb.BackendRule{
+						{
+							Address:         "https://mybackend.com",
+							Selector:        "1.cloudesf_testing_cloud_goog.Foo",
+							PathTranslation: confpb.BackendRule_CONSTANT_ADDRESS,
+							Authentication: &confpb.BackendRule_JwtAudience{
+								JwtAudience: "mybackend.com",
+							},
+						},
+						{
+							Address:         "https://mybackend.com",
+							Selector:        "1.cloudesf_testing_cloud_goog.Bar",
+							PathTranslation: confpb.BackendRule_APPEND_PATH_TO_ADDRESS,
+							Authentication: &confpb.BackendRule_JwtAudience{
+								JwtAudience: "mybackend.com",
+							},
 						},
 					},
 				},
@@ -1299,57 +1772,2375 @@ func TestHealthCheckFilter(t *testing.T) {
 								Get: "foo/{id}",
 							},
 						},
+						{
+							Selector: "1.cloudesf_testing_cloud_goog.Bar",
+							Pattern: &annotationspb.HttpRule_Get{
+								Get: "foo",
+							},
+						},
 					},
 				},
 			},
-			wantHealthCheckFilter: `{
-        "name": "envoy.health_check",
-        "typedConfig": {
-          "@type":"type.googleapis.com/envoy.config.filter.http.health_check.v2.HealthCheck",
-          "passThroughMode":false,
-          "headers": [
-            {
-              "exactMatch": "/",
-              "name":":path"
+			BackendAddress: "http://127.0.0.1:80",
+			wantPathMatcherFilter: `
+			        {
+   "name":"envoy.filters.http.path_matcher",
+   "typedConfig":{
+      "@type":"type.googleapis.com/google.api.envoy.http.path_matcher.FilterConfig",
+      "rules":[
+         {
+            "operation":"1.cloudesf_testing_cloud_goog.Bar",
+            "pattern":{
+               "httpMethod":"GET",
+               "uriTemplate":"foo"
             }
-          ]
-        }
-      }`,
+         },
+         {
+            "extractPathParameters":true,
+            "operation":"1.cloudesf_testing_cloud_goog.Foo",
+            "pattern":{
+               "httpMethod":"GET",
+               "uriTemplate":"foo/{id}"
+            }
+         }
+      ]
+   }
+}
+			      `,
+		},
+		{
+			desc: "Path Matcher filter - CORS support",
+			fakeServiceConfig: &confpb.Service{
+				Name: "foo.endpoints.bar.cloud.goog",
+				Apis: []*apipb.Api{
+					{
+						Name: "1.cloudesf_testing_cloud_goog",
+						Methods: []*apipb.Method{
+							{
+								Name: "Foo",
+							},
+						},
+					},
+				},
+				Endpoints: []*confpb.Endpoint{
+					{
+						Name:      "foo.endpoints.bar.cloud.goog",
+						AllowCors: true,
+					},
+				},
+				Http: &annotationspb.Http{
+					Rules: []*annotationspb.HttpRule{
+						{
+							Selector: "1.cloudesf_testing_cloud_goog.Foo",
+							Pattern: &annotationspb.HttpRule_Get{
+								Get: "foo",
+							},
+						},
+					},
+				},
+			},
+			BackendAddress: "http://127.0.0.1:80",
+			wantPathMatcherFilter: `
+			        {
+   "name":"envoy.filters.http.path_matcher",
+   "typedConfig":{
+      "@type":"type.googleapis.com/google.api.envoy.http.path_matcher.FilterConfig",
+      "rules":[
+         {
+            "operation":"1.cloudesf_testing_cloud_goog.CORS_foo",
+            "pattern":{
+               "httpMethod":"OPTIONS",
+               "uriTemplate":"foo"
+            }
+         },
+         {
+            "operation":"1.cloudesf_testing_cloud_goog.Foo",
+            "pattern":{
+               "httpMethod":"GET",
+               "uriTemplate":"foo"
+            }
+         }
+      ]
+   }
+}
+			      `,
+		},
+		{
+			desc: "Path Matcher filter - Segment Name Mapping for snake-case field",
+			fakeServiceConfig: &confpb.Service{
+				Name: "foo.endpoints.bar.cloud.goog",
+				Apis: []*apipb.Api{
+					{
+						Name: "1.cloudesf_testing_cloud_goog",
+						Methods: []*apipb.Method{
+							{
+								Name: "Foo",
+							},
+						},
+					},
+				},
+				Types: []*ptypepb.Type{
+					{
+						Fields: []*ptypepb.Field{
+							{
+								JsonName: "fooBar",
+								Name:     "foo_bar",
+							},
+						},
+					},
+				},
+				Backend: &confpb.Backend{
+					Rules: []*confpb.BackendRule{
+						{
+							Address:         "https://mybackend.com",
+							Selector:        "1.cloudesf_testing_cloud_goog.Foo",
+							PathTranslation: confpb.BackendRule_CONSTANT_ADDRESS,
+							Authentication: &confpb.BackendRule_JwtAudience{
+								JwtAudience: "mybackend.com",
+							},
+						},
+					},
+				},
+				Http: &annotationspb.Http{
+					Rules: []*annotationspb.HttpRule{
+						{
+							Selector: "1.cloudesf_testing_cloud_goog.Foo",
+							Pattern: &annotationspb.HttpRule_Get{
+								Get: "foo/{foo_bar}",
+							},
+						},
+					},
+				},
+			},
+			BackendAddress: "http://127.0.0.1:80",
+			wantPathMatcherFilter: `
+			        {
+   "name":"envoy.filters.http.path_matcher",
+   "typedConfig":{
+      "@type":"type.googleapis.com/google.api.envoy.http.path_matcher.FilterConfig",
+      "rules":[
+         {
+            "extractPathParameters":true,
+            "operation":"1.cloudesf_testing_cloud_goog.Foo",
+            "pattern":{
+               "httpMethod":"GET",
+               "uriTemplate":"foo/{foo_bar}"
+            }
+         }
+      ],
+      "segmentNames":[
+         {
+            "jsonName":"fooBar",
+            "snakeName":"foo_bar"
+         }
+      ]
+   }
+}`,
+		},
+		{
+			desc: "Path Matcher filter with a custom 404 body for the unmatched paths",
+			fakeServiceConfig: &confpb.Service{
+				Name: testProjectName,
+				Apis: []*apipb.Api{
+					{
+						Name: testApiName,
+						Methods: []*apipb.Method{
+							{
+								Name: "ListShelves",
+							},
+						},
+					},
+				},
+				Http: &annotationspb.Http{
+					Rules: []*annotationspb.HttpRule{
+						{
+							Selector: "endpoints.examples.bookstore.Bookstore.ListShelves",
+							Pattern: &annotationspb.HttpRule_Get{
+								Get: "/v1/shelves",
+							},
+						},
+					},
+				},
+			},
+			BackendAddress:           "http://127.0.0.1:80",
+			unmatchedPathBody:        `{"error": "not found"}`,
+			unmatchedPathContentType: "application/json",
+			wantPathMatcherFilter: `
+{
+   "name":"envoy.filters.http.path_matcher",
+   "typedConfig":{
+      "@type":"type.googleapis.com/google.api.envoy.http.path_matcher.FilterConfig",
+      "rules":[
+         {
+            "operation":"endpoints.examples.bookstore.Bookstore.ListShelves",
+            "pattern":{
+               "httpMethod":"GET",
+               "uriTemplate":"/v1/shelves"
+            }
+         }
+      ],
+      "unmatchedPath":{
+         "notFoundBody":"{\"error\": \"not found\"}",
+         "contentType":"application/json"
+      }
+   }
+}`,
+		},
+		{
+			desc: "Path Matcher filter redirecting the unmatched paths",
+			fakeServiceConfig: &confpb.Service{
+				Name: testProjectName,
+				Apis: []*apipb.Api{
+					{
+						Name: testApiName,
+						Methods: []*apipb.Method{
+							{
+								Name: "ListShelves",
+							},
+						},
+					},
+				},
+				Http: &annotationspb.Http{
+					Rules: []*annotationspb.HttpRule{
+						{
+							Selector: "endpoints.examples.bookstore.Bookstore.ListShelves",
+							Pattern: &annotationspb.HttpRule_Get{
+								Get: "/v1/shelves",
+							},
+						},
+					},
+				},
+			},
+			BackendAddress:           "http://127.0.0.1:80",
+			unmatchedPathRedirectUrl: "https://docs.example.com/",
+			wantPathMatcherFilter: `
+{
+   "name":"envoy.filters.http.path_matcher",
+   "typedConfig":{
+      "@type":"type.googleapis.com/google.api.envoy.http.path_matcher.FilterConfig",
+      "rules":[
+         {
+            "operation":"endpoints.examples.bookstore.Bookstore.ListShelves",
+            "pattern":{
+               "httpMethod":"GET",
+               "uriTemplate":"/v1/shelves"
+            }
+         }
+      ],
+      "unmatchedPath":{
+         "redirectUrl":"https://docs.example.com/"
+      }
+   }
+}`,
+		},
+	}
+	for i, tc := range testData {
+		opts := options.DefaultConfigGeneratorOptions()
+		opts.BackendAddress = tc.BackendAddress
+		opts.Healthz = tc.healthz
+		opts.UnmatchedPathBody = tc.unmatchedPathBody
+		opts.UnmatchedPathContentType = tc.unmatchedPathContentType
+		opts.UnmatchedPathRedirectUrl = tc.unmatchedPathRedirectUrl
+		fakeServiceInfo, err := configinfo.NewServiceInfoFromServiceConfig(tc.fakeServiceConfig, testConfigID, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		marshaler := &jsonpb.Marshaler{}
+		gotFilter, err := marshaler.MarshalToString(makePathMatcherFilter(fakeServiceInfo))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := util.JsonEqual(tc.wantPathMatcherFilter, gotFilter); err != nil {
+			t.Errorf("Test Desc(%d): %s, makePathMatcherFilter failed, \n %v", i, tc.desc, err)
+		}
+	}
+}
+
+func TestHealthCheckFilter(t *testing.T) {
+	testdata := []struct {
+		desc                  string
+		BackendAddress        string
+		healthz               string
+		fakeServiceConfig     *confpb.Service
+		wantHealthCheckFilter string
+	}{
+		{
+			desc:           "Success, generate health check filter for gRPC",
+			BackendAddress: "grpc://127.0.0.1:80",
+			healthz:        "healthz",
+			fakeServiceConfig: &confpb.Service{
+				Name: testProjectName,
+				Apis: []*apipb.Api{
+					{
+						Name: "endpoints.examples.bookstore.Bookstore",
+						Methods: []*apipb.Method{
+							{
+								Name: "CreateShelf",
+							},
+						},
+					},
+				},
+			},
+			wantHealthCheckFilter: `{
+        "name": "envoy.health_check",
+        "typedConfig": {
+          "@type":"type.googleapis.com/envoy.config.filter.http.health_check.v2.HealthCheck",
+          "passThroughMode":false,
+          "headers": [
+            {
+              "exactMatch": "/healthz",
+              "name":":path"
+            }
+          ]
+        }
+      }`,
+		},
+		{
+			desc:           "Success, generate health check filter for http",
+			BackendAddress: "http://127.0.0.1:80",
+			healthz:        "/",
+			fakeServiceConfig: &confpb.Service{
+				Name: "foo.endpoints.bar.cloud.goog",
+				Apis: []*apipb.Api{
+					{
+						Name: "1.cloudesf_testing_cloud_goog",
+						Methods: []*apipb.Method{
+							{
+								Name: "Foo",
+							},
+						},
+					},
+				},
+				Http: &annotationspb.Http{
+					Rules: []*annotationspb.HttpRule{
+						{
+							Selector: "1.cloudesf_testing_cloud_goog.Foo",
+							Pattern: &annotationspb.HttpRule_Get{
+								Get: "foo/{id}",
+							},
+						},
+					},
+				},
+			},
+			wantHealthCheckFilter: `{
+        "name": "envoy.health_check",
+        "typedConfig": {
+          "@type":"type.googleapis.com/envoy.config.filter.http.health_check.v2.HealthCheck",
+          "passThroughMode":false,
+          "headers": [
+            {
+              "exactMatch": "/",
+              "name":":path"
+            }
+          ]
+        }
+      }`,
+		},
+	}
+
+	for i, tc := range testdata {
+		opts := options.DefaultConfigGeneratorOptions()
+		opts.BackendAddress = tc.BackendAddress
+		opts.Healthz = tc.healthz
+		fakeServiceInfo, err := configinfo.NewServiceInfoFromServiceConfig(tc.fakeServiceConfig, testConfigID, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		marshaler := &jsonpb.Marshaler{}
+		filter, err := makeHealthCheckFilter(fakeServiceInfo)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		gotFilter, err := marshaler.MarshalToString(filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := util.JsonEqual(tc.wantHealthCheckFilter, gotFilter); err != nil {
+			t.Errorf("Test Desc(%d): %s, makeHealthCheckFilter failed,\n%v", i, tc.desc, err)
+		}
+	}
+}
+
+func TestBufferFilter(t *testing.T) {
+	maxRequestBodyBytes := uint32(2048)
+	testdata := []struct {
+		desc                string
+		maxRequestBodyBytes uint32
+		bodySizeLimits      []*options.BodySizeLimitOptions
+		wantFilters         []string
+		wantBufferFilter    string
+	}{
+		{
+			desc:        "No Buffer filter if the request bodies are unlimited",
+			wantFilters: []string{util.PathMatcher, util.ServiceControl, util.Router},
+		},
+		{
+			desc:                "Buffer filter before Service Control filter with max_request_body_bytes",
+			maxRequestBodyBytes: 1024,
+			wantFilters:         []string{util.PathMatcher, util.Buffer, util.ServiceControl, util.Router},
+			wantBufferFilter: `{
+        "name": "envoy.buffer",
+        "typedConfig": {
+          "@type":"type.googleapis.com/envoy.config.filter.http.buffer.v2.Buffer",
+          "maxRequestBytes": 1024
+        }
+      }`,
+		},
+		{
+			desc: "Buffer filter with the largest limit if only some operations are limited",
+			bodySizeLimits: []*options.BodySizeLimitOptions{
+				{
+					Selector:            "endpoints.examples.bookstore.Bookstore.CreateShelf",
+					MaxRequestBodyBytes: &maxRequestBodyBytes,
+				},
+			},
+			wantFilters: []string{util.PathMatcher, util.Buffer, util.ServiceControl, util.Router},
+			wantBufferFilter: `{
+        "name": "envoy.buffer",
+        "typedConfig": {
+          "@type":"type.googleapis.com/envoy.config.filter.http.buffer.v2.Buffer",
+          "maxRequestBytes": 2048
+        }
+      }`,
+		},
+	}
+
+	for _, tc := range testdata {
+		opts := options.DefaultConfigGeneratorOptions()
+		opts.MaxRequestBodyBytes = tc.maxRequestBodyBytes
+		opts.BodySizeLimits = tc.bodySizeLimits
+		fakeServiceInfo, err := configinfo.NewServiceInfoFromServiceConfig(&confpb.Service{
+			Name: testProjectName,
+			Apis: []*apipb.Api{
+				{
+					Name: "endpoints.examples.bookstore.Bookstore",
+					Methods: []*apipb.Method{
+						{
+							Name: "CreateShelf",
+						},
+					},
+				},
+			},
+			Http: &annotationspb.Http{
+				Rules: []*annotationspb.HttpRule{
+					{
+						Selector: "endpoints.examples.bookstore.Bookstore.CreateShelf",
+						Pattern: &annotationspb.HttpRule_Post{
+							Post: "/v1/shelves",
+						},
+					},
+				},
+			},
+			Control: &confpb.Control{
+				Environment: testServiceControlEnv,
+			},
+		}, testConfigID, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		filters, err := makeHttpFilters(fakeServiceInfo)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var gotFilters []string
+		for _, filter := range filters {
+			gotFilters = append(gotFilters, filter.GetName())
+		}
+		if !reflect.DeepEqual(gotFilters, tc.wantFilters) {
+			t.Errorf("Test Desc(%s): got filters %v, want %v", tc.desc, gotFilters, tc.wantFilters)
+		}
+
+		filter := makeBufferFilter(fakeServiceInfo)
+		if tc.wantBufferFilter == "" {
+			if filter != nil {
+				t.Errorf("Test Desc(%s): got Buffer filter %v, want none", tc.desc, filter)
+			}
+			continue
+		}
+		gotFilter, err := (&jsonpb.Marshaler{}).MarshalToString(filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := util.JsonEqual(tc.wantBufferFilter, gotFilter); err != nil {
+			t.Errorf("Test Desc(%s): makeBufferFilter failed,\n%v", tc.desc, err)
+		}
+	}
+}
+
+func TestGzipFilter(t *testing.T) {
+	testdata := []struct {
+		desc                      string
+		enableResponseCompression bool
+		minContentLength          uint32
+		contentTypes              string
+		level                     string
+		wantFilters               []string
+		wantGzipFilter            string
+	}{
+		{
+			desc:        "No Gzip filter if the responses are not compressed",
+			wantFilters: []string{util.PathMatcher, util.ServiceControl, util.Router},
+		},
+		{
+			desc:                      "Gzip filter before the other filters with the default options",
+			enableResponseCompression: true,
+			minContentLength:          30,
+			level:                     "default",
+			wantFilters:               []string{util.Gzip, util.PathMatcher, util.ServiceControl, util.Router},
+			wantGzipFilter: `{
+        "name": "envoy.gzip",
+        "typedConfig": {
+          "@type":"type.googleapis.com/envoy.config.filter.http.gzip.v2.Gzip",
+          "contentLength": 30
+        }
+      }`,
+		},
+		{
+			desc:                      "Gzip filter with content types and compression level",
+			enableResponseCompression: true,
+			minContentLength:          1024,
+			contentTypes:              "application/json, text/html",
+			level:                     "speed",
+			wantFilters:               []string{util.Gzip, util.PathMatcher, util.ServiceControl, util.Router},
+			wantGzipFilter: `{
+        "name": "envoy.gzip",
+        "typedConfig": {
+          "@type":"type.googleapis.com/envoy.config.filter.http.gzip.v2.Gzip",
+          "contentLength": 1024,
+          "compressionLevel": "SPEED",
+          "contentType": ["application/json", "text/html"]
+        }
+      }`,
+		},
+	}
+
+	for _, tc := range testdata {
+		opts := options.DefaultConfigGeneratorOptions()
+		opts.EnableResponseCompression = tc.enableResponseCompression
+		opts.ResponseCompressionMinContentLength = tc.minContentLength
+		opts.ResponseCompressionContentTypes = tc.contentTypes
+		opts.ResponseCompressionLevel = tc.level
+		fakeServiceInfo, err := configinfo.NewServiceInfoFromServiceConfig(&confpb.Service{
+			Name: testProjectName,
+			Apis: []*apipb.Api{
+				{
+					Name: "endpoints.examples.bookstore.Bookstore",
+					Methods: []*apipb.Method{
+						{
+							Name: "CreateShelf",
+						},
+					},
+				},
+			},
+			Http: &annotationspb.Http{
+				Rules: []*annotationspb.HttpRule{
+					{
+						Selector: "endpoints.examples.bookstore.Bookstore.CreateShelf",
+						Pattern: &annotationspb.HttpRule_Post{
+							Post: "/v1/shelves",
+						},
+					},
+				},
+			},
+			Control: &confpb.Control{
+				Environment: testServiceControlEnv,
+			},
+		}, testConfigID, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		filters, err := makeHttpFilters(fakeServiceInfo)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var gotFilters []string
+		for _, filter := range filters {
+			gotFilters = append(gotFilters, filter.GetName())
+		}
+		if !reflect.DeepEqual(gotFilters, tc.wantFilters) {
+			t.Errorf("Test Desc(%s): got filters %v, want %v", tc.desc, gotFilters, tc.wantFilters)
+		}
+
+		filter := makeGzipFilter(fakeServiceInfo)
+		if tc.wantGzipFilter == "" {
+			if filter != nil {
+				t.Errorf("Test Desc(%s): got Gzip filter %v, want none", tc.desc, filter)
+			}
+			continue
+		}
+		gotFilter, err := (&jsonpb.Marshaler{}).MarshalToString(filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := util.JsonEqual(tc.wantGzipFilter, gotFilter); err != nil {
+			t.Errorf("Test Desc(%s): makeGzipFilter failed,\n%v", tc.desc, err)
+		}
+	}
+}
+
+func TestErrorResponseFilter(t *testing.T) {
+	testdata := []struct {
+		desc                    string
+		errorResponseFormat     string
+		errorResponseTemplates  []*options.ErrorResponseTemplateOptions
+		enableCompression       bool
+		wantFilters             []string
+		wantErrorResponseFilter string
+	}{
+		{
+			desc:        "No Error Response filter if the error responses are not rewritten",
+			wantFilters: []string{util.PathMatcher, util.ServiceControl, util.Router},
+		},
+		{
+			desc:                "Error Response filter with the problem details format",
+			errorResponseFormat: "problem_json",
+			wantFilters:         []string{util.ErrorResponse, util.PathMatcher, util.ServiceControl, util.Router},
+			wantErrorResponseFilter: `{
+        "name": "envoy.filters.http.error_response",
+        "typedConfig": {
+          "@type":"type.googleapis.com/google.api.envoy.http.error_response.FilterConfig",
+          "format": "PROBLEM_JSON"
+        }
+      }`,
+		},
+		{
+			desc: "Error Response filter after Gzip filter with templates only",
+			errorResponseTemplates: []*options.ErrorResponseTemplateOptions{
+				{
+					Status: 429,
+					Body:   `{"error": "%MESSAGE%"}`,
+				},
+			},
+			enableCompression: true,
+			wantFilters:       []string{util.Gzip, util.ErrorResponse, util.PathMatcher, util.ServiceControl, util.Router},
+			wantErrorResponseFilter: `{
+        "name": "envoy.filters.http.error_response",
+        "typedConfig": {
+          "@type":"type.googleapis.com/google.api.envoy.http.error_response.FilterConfig",
+          "templates": [
+            {
+              "status": 429,
+              "body": "{\"error\": \"%MESSAGE%\"}"
+            }
+          ]
+        }
+      }`,
+		},
+	}
+
+	for _, tc := range testdata {
+		opts := options.DefaultConfigGeneratorOptions()
+		opts.ErrorResponseFormat = tc.errorResponseFormat
+		opts.ErrorResponseTemplates = tc.errorResponseTemplates
+		opts.EnableResponseCompression = tc.enableCompression
+		fakeServiceInfo, err := configinfo.NewServiceInfoFromServiceConfig(&confpb.Service{
+			Name: testProjectName,
+			Apis: []*apipb.Api{
+				{
+					Name: "endpoints.examples.bookstore.Bookstore",
+					Methods: []*apipb.Method{
+						{
+							Name: "CreateShelf",
+						},
+					},
+				},
+			},
+			Http: &annotationspb.Http{
+				Rules: []*annotationspb.HttpRule{
+					{
+						Selector: "endpoints.examples.bookstore.Bookstore.CreateShelf",
+						Pattern: &annotationspb.HttpRule_Post{
+							Post: "/v1/shelves",
+						},
+					},
+				},
+			},
+			Control: &confpb.Control{
+				Environment: testServiceControlEnv,
+			},
+		}, testConfigID, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		filters, err := makeHttpFilters(fakeServiceInfo)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var gotFilters []string
+		for _, filter := range filters {
+			gotFilters = append(gotFilters, filter.GetName())
+		}
+		if !reflect.DeepEqual(gotFilters, tc.wantFilters) {
+			t.Errorf("Test Desc(%s): got filters %v, want %v", tc.desc, gotFilters, tc.wantFilters)
+		}
+
+		filter := makeErrorResponseFilter(fakeServiceInfo)
+		if tc.wantErrorResponseFilter == "" {
+			if filter != nil {
+				t.Errorf("Test Desc(%s): got Error Response filter %v, want none", tc.desc, filter)
+			}
+			continue
+		}
+		gotFilter, err := (&jsonpb.Marshaler{}).MarshalToString(filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := util.JsonEqual(tc.wantErrorResponseFilter, gotFilter); err != nil {
+			t.Errorf("Test Desc(%s): makeErrorResponseFilter failed,\n%v", tc.desc, err)
+		}
+	}
+}
+
+func TestIpAclFilter(t *testing.T) {
+	testdata := []struct {
+		desc              string
+		ipAcls            []*options.IpAclOptions
+		trustedProxyCidrs string
+		healthz           string
+		wantFilters       []string
+		wantIpAclFilter   string
+	}{
+		{
+			desc:        "No IP ACL filter without IP ACLs",
+			wantFilters: []string{util.PathMatcher, util.ServiceControl, util.Router},
+		},
+		{
+			desc: "IP ACL filter with the default rule and the rule of an operation, unknown selectors are ignored",
+			ipAcls: []*options.IpAclOptions{
+				{
+					Selector: "*",
+					Deny:     []string{"10.1.0.0/16"},
+				},
+				{
+					Selector: "endpoints.examples.bookstore.Bookstore.ListShelves",
+					Allow:    []string{"10.0.0.0/8", "2001:db8::/32"},
+				},
+				{
+					Selector: "endpoints.examples.bookstore.Bookstore.Unknown",
+					Allow:    []string{"10.0.0.0/8"},
+				},
+			},
+			wantFilters: []string{util.PathMatcher, util.IpAcl, util.ServiceControl, util.Router},
+			wantIpAclFilter: `{
+        "name": "envoy.filters.http.ip_acl",
+        "typedConfig": {
+          "@type":"type.googleapis.com/google.api.envoy.http.ip_acl.FilterConfig",
+          "defaultRule": {
+            "deny": ["10.1.0.0/16"]
+          },
+          "operationRules": [
+            {
+              "operation": "endpoints.examples.bookstore.Bookstore.ListShelves",
+              "rule": {
+                "allow": ["10.0.0.0/8", "2001:db8::/32"]
+              }
+            }
+          ]
+        }
+      }`,
+		},
+		{
+			desc:              "IP ACL filter with trusted proxies only",
+			trustedProxyCidrs: "35.191.0.0/16, 130.211.0.0/22",
+			wantFilters:       []string{util.PathMatcher, util.IpAcl, util.ServiceControl, util.Router},
+			wantIpAclFilter: `{
+        "name": "envoy.filters.http.ip_acl",
+        "typedConfig": {
+          "@type":"type.googleapis.com/google.api.envoy.http.ip_acl.FilterConfig",
+          "trustedProxies": ["35.191.0.0/16", "130.211.0.0/22"]
+        }
+      }`,
+		},
+		{
+			desc: "IP ACL filter after Health Check filter",
+			ipAcls: []*options.IpAclOptions{
+				{
+					Selector: "endpoints.examples.bookstore.Bookstore.CreateShelf",
+					Allow:    []string{"192.168.0.0/16"},
+				},
+			},
+			healthz:     "healthz",
+			wantFilters: []string{util.PathMatcher, util.HealthCheck, util.IpAcl, util.ServiceControl, util.Router},
+			wantIpAclFilter: `{
+        "name": "envoy.filters.http.ip_acl",
+        "typedConfig": {
+          "@type":"type.googleapis.com/google.api.envoy.http.ip_acl.FilterConfig",
+          "operationRules": [
+            {
+              "operation": "endpoints.examples.bookstore.Bookstore.CreateShelf",
+              "rule": {
+                "allow": ["192.168.0.0/16"]
+              }
+            }
+          ]
+        }
+      }`,
+		},
+	}
+
+	for _, tc := range testdata {
+		opts := options.DefaultConfigGeneratorOptions()
+		opts.IpAcls = tc.ipAcls
+		opts.TrustedProxyCidrs = tc.trustedProxyCidrs
+		opts.Healthz = tc.healthz
+		fakeServiceInfo, err := configinfo.NewServiceInfoFromServiceConfig(&confpb.Service{
+			Name: testProjectName,
+			Apis: []*apipb.Api{
+				{
+					Name: "endpoints.examples.bookstore.Bookstore",
+					Methods: []*apipb.Method{
+						{
+							Name: "CreateShelf",
+						},
+						{
+							Name: "ListShelves",
+						},
+					},
+				},
+			},
+			Http: &annotationspb.Http{
+				Rules: []*annotationspb.HttpRule{
+					{
+						Selector: "endpoints.examples.bookstore.Bookstore.CreateShelf",
+						Pattern: &annotationspb.HttpRule_Post{
+							Post: "/v1/shelves",
+						},
+					},
+					{
+						Selector: "endpoints.examples.bookstore.Bookstore.ListShelves",
+						Pattern: &annotationspb.HttpRule_Get{
+							Get: "/v1/shelves",
+						},
+					},
+				},
+			},
+			Control: &confpb.Control{
+				Environment: testServiceControlEnv,
+			},
+		}, testConfigID, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		filters, err := makeHttpFilters(fakeServiceInfo)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var gotFilters []string
+		for _, filter := range filters {
+			gotFilters = append(gotFilters, filter.GetName())
+		}
+		if !reflect.DeepEqual(gotFilters, tc.wantFilters) {
+			t.Errorf("Test Desc(%s): got filters %v, want %v", tc.desc, gotFilters, tc.wantFilters)
+		}
+
+		filter := makeIpAclFilter(fakeServiceInfo)
+		if tc.wantIpAclFilter == "" {
+			if filter != nil {
+				t.Errorf("Test Desc(%s): got IP ACL filter %v, want none", tc.desc, filter)
+			}
+			continue
+		}
+		gotFilter, err := (&jsonpb.Marshaler{}).MarshalToString(filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := util.JsonEqual(tc.wantIpAclFilter, gotFilter); err != nil {
+			t.Errorf("Test Desc(%s): makeIpAclFilter failed,\n%v", tc.desc, err)
+		}
+	}
+}
+
+func TestResponseCacheFilter(t *testing.T) {
+	testdata := []struct {
+		desc                    string
+		responseCaches          []*options.ResponseCacheOptions
+		apiKeyLocations         string
+		authentication          *confpb.Authentication
+		wantFilters             []string
+		wantResponseCacheFilter string
+	}{
+		{
+			desc:        "No Response Cache filter if the responses are not cached",
+			wantFilters: []string{util.PathMatcher, util.ServiceControl, util.Router},
+		},
+		{
+			desc: "Response Cache filter after Service Control filter for the GET operations only",
+			responseCaches: []*options.ResponseCacheOptions{
+				{
+					Selector:   "*",
+					Ttl:        30,
+					KeyHeaders: []string{"accept-language"},
+				},
+			},
+			wantFilters: []string{util.PathMatcher, util.ServiceControl, util.ResponseCache, util.Router},
+			wantResponseCacheFilter: `{
+        "name": "envoy.filters.http.response_cache",
+        "typedConfig": {
+          "@type":"type.googleapis.com/google.api.envoy.http.response_cache.FilterConfig",
+          "rules": [
+            {
+              "operation": "endpoints.examples.bookstore.Bookstore.ListShelves",
+              "ttl": "30s",
+              "keyHeaders": ["accept-language"]
+            }
+          ],
+          "maxEntries": 1000,
+          "maxBodyBytes": 1048576
+        }
+      }`,
+		},
+		{
+			desc: "Response Cache filter keyed by the API key headers",
+			responseCaches: []*options.ResponseCacheOptions{
+				{
+					Selector:      "endpoints.examples.bookstore.Bookstore.ListShelves",
+					IncludeApiKey: true,
+				},
+			},
+			apiKeyLocations: "query:key,header:x-custom-key",
+			wantFilters:     []string{util.PathMatcher, util.ServiceControl, util.ResponseCache, util.Router},
+			wantResponseCacheFilter: `{
+        "name": "envoy.filters.http.response_cache",
+        "typedConfig": {
+          "@type":"type.googleapis.com/google.api.envoy.http.response_cache.FilterConfig",
+          "rules": [
+            {
+              "operation": "endpoints.examples.bookstore.Bookstore.ListShelves",
+              "keyHeaders": ["x-custom-key"]
+            }
+          ],
+          "maxEntries": 1000,
+          "maxBodyBytes": 1048576
+        }
+      }`,
+		},
+		{
+			desc: "Response Cache filter for an operation with a JWT requirement",
+			responseCaches: []*options.ResponseCacheOptions{
+				{
+					Selector: "endpoints.examples.bookstore.Bookstore.ListShelves",
+				},
+			},
+			authentication: &confpb.Authentication{
+				Providers: []*confpb.AuthProvider{
+					{
+						Id:      "auth_provider",
+						Issuer:  "issuer",
+						JwksUri: "https://fake-jwks.com",
+					},
+				},
+				Rules: []*confpb.AuthenticationRule{
+					{
+						Selector: "endpoints.examples.bookstore.Bookstore.ListShelves",
+						Requirements: []*confpb.AuthRequirement{
+							{
+								ProviderId: "auth_provider",
+							},
+						},
+					},
+				},
+			},
+			wantFilters: []string{util.PathMatcher, util.JwtAuthn, util.ServiceControl, util.ResponseCache, util.Router},
+			wantResponseCacheFilter: `{
+        "name": "envoy.filters.http.response_cache",
+        "typedConfig": {
+          "@type":"type.googleapis.com/google.api.envoy.http.response_cache.FilterConfig",
+          "rules": [
+            {
+              "operation": "endpoints.examples.bookstore.Bookstore.ListShelves",
+              "jwtRequired": true
+            }
+          ],
+          "maxEntries": 1000,
+          "maxBodyBytes": 1048576
+        }
+      }`,
+		},
+		{
+			desc: "No Response Cache filter for a POST operation",
+			responseCaches: []*options.ResponseCacheOptions{
+				{
+					Selector: "endpoints.examples.bookstore.Bookstore.CreateShelf",
+				},
+			},
+			wantFilters: []string{util.PathMatcher, util.ServiceControl, util.Router},
+		},
+	}
+
+	for _, tc := range testdata {
+		opts := options.DefaultConfigGeneratorOptions()
+		opts.ResponseCaches = tc.responseCaches
+		opts.ApiKeyLocations = tc.apiKeyLocations
+		fakeServiceInfo, err := configinfo.NewServiceInfoFromServiceConfig(&confpb.Service{
+			Name: testProjectName,
+			Apis: []*apipb.Api{
+				{
+					Name: "endpoints.examples.bookstore.Bookstore",
+					Methods: []*apipb.Method{
+						{
+							Name: "CreateShelf",
+						},
+						{
+							Name: "ListShelves",
+						},
+					},
+				},
+			},
+			Http: &annotationspb.Http{
+				Rules: []*annotationspb.HttpRule{
+					{
+						Selector: "endpoints.examples.bookstore.Bookstore.CreateShelf",
+						Pattern: &annotationspb.HttpRule_Post{
+							Post: "/v1/shelves",
+						},
+					},
+					{
+						Selector: "endpoints.examples.bookstore.Bookstore.ListShelves",
+						Pattern: &annotationspb.HttpRule_Get{
+							Get: "/v1/shelves",
+						},
+					},
+				},
+			},
+			Control: &confpb.Control{
+				Environment: testServiceControlEnv,
+			},
+			Authentication: tc.authentication,
+		}, testConfigID, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		filters, err := makeHttpFilters(fakeServiceInfo)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var gotFilters []string
+		for _, filter := range filters {
+			gotFilters = append(gotFilters, filter.GetName())
+		}
+		if !reflect.DeepEqual(gotFilters, tc.wantFilters) {
+			t.Errorf("Test Desc(%s): got filters %v, want %v", tc.desc, gotFilters, tc.wantFilters)
+		}
+
+		filter, err := makeResponseCacheFilter(fakeServiceInfo)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tc.wantResponseCacheFilter == "" {
+			if filter != nil {
+				t.Errorf("Test Desc(%s): got Response Cache filter %v, want none", tc.desc, filter)
+			}
+			continue
+		}
+		gotFilter, err := (&jsonpb.Marshaler{}).MarshalToString(filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := util.JsonEqual(tc.wantResponseCacheFilter, gotFilter); err != nil {
+			t.Errorf("Test Desc(%s): makeResponseCacheFilter failed,\n%v", tc.desc, err)
+		}
+	}
+}
+
+func TestExtAuthzFilter(t *testing.T) {
+	testdata := []struct {
+		desc                     string
+		extAuthzUri              string
+		extAuthzTimeout          time.Duration
+		extAuthzFailureModeAllow bool
+		wantFilters              []string
+		wantExtAuthzFilter       string
+	}{
+		{
+			desc:        "No External Authorization filter without --ext_authz_uri",
+			wantFilters: []string{util.PathMatcher, util.ServiceControl, util.Router},
+		},
+		{
+			desc:            "External Authorization filter with a gRPC server before Service Control filter",
+			extAuthzUri:     "grpc://authz.example.com:9000",
+			extAuthzTimeout: 2 * time.Second,
+			wantFilters:     []string{util.PathMatcher, util.ExtAuthz, util.ServiceControl, util.Router},
+			wantExtAuthzFilter: `{
+        "name": "envoy.filters.http.ext_authz",
+        "typedConfig": {
+          "@type":"type.googleapis.com/envoy.config.filter.http.ext_authz.v2.ExtAuthz",
+          "grpcService": {
+            "envoyGrpc": {
+              "clusterName": "ext-authz-cluster"
+            },
+            "timeout": "2s"
+          }
+        }
+      }`,
+		},
+		{
+			desc:                     "External Authorization filter with an HTTP server and a path prefix, allowing the requests on failures",
+			extAuthzUri:              "https://authz.example.com/check",
+			extAuthzTimeout:          time.Second,
+			extAuthzFailureModeAllow: true,
+			wantFilters:              []string{util.PathMatcher, util.ExtAuthz, util.ServiceControl, util.Router},
+			wantExtAuthzFilter: `{
+        "name": "envoy.filters.http.ext_authz",
+        "typedConfig": {
+          "@type":"type.googleapis.com/envoy.config.filter.http.ext_authz.v2.ExtAuthz",
+          "failureModeAllow": true,
+          "httpService": {
+            "serverUri": {
+              "uri": "https://authz.example.com:443/check",
+              "cluster": "ext-authz-cluster",
+              "timeout": "1s"
+            },
+            "pathPrefix": "/check"
+          }
+        }
+      }`,
+		},
+	}
+
+	for _, tc := range testdata {
+		opts := options.DefaultConfigGeneratorOptions()
+		opts.ExtAuthzUri = tc.extAuthzUri
+		opts.ExtAuthzTimeout = tc.extAuthzTimeout
+		opts.ExtAuthzFailureModeAllow = tc.extAuthzFailureModeAllow
+		fakeServiceInfo, err := configinfo.NewServiceInfoFromServiceConfig(&confpb.Service{
+			Name: testProjectName,
+			Apis: []*apipb.Api{
+				{
+					Name: "endpoints.examples.bookstore.Bookstore",
+					Methods: []*apipb.Method{
+						{
+							Name: "CreateShelf",
+						},
+					},
+				},
+			},
+			Http: &annotationspb.Http{
+				Rules: []*annotationspb.HttpRule{
+					{
+						Selector: "endpoints.examples.bookstore.Bookstore.CreateShelf",
+						Pattern: &annotationspb.HttpRule_Post{
+							Post: "/v1/shelves",
+						},
+					},
+				},
+			},
+			Control: &confpb.Control{
+				Environment: testServiceControlEnv,
+			},
+		}, testConfigID, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		filters, err := makeHttpFilters(fakeServiceInfo)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var gotFilters []string
+		for _, filter := range filters {
+			gotFilters = append(gotFilters, filter.GetName())
+		}
+		if !reflect.DeepEqual(gotFilters, tc.wantFilters) {
+			t.Errorf("Test Desc(%s): got filters %v, want %v", tc.desc, gotFilters, tc.wantFilters)
+		}
+
+		filter := makeExtAuthzFilter(fakeServiceInfo)
+		if tc.wantExtAuthzFilter == "" {
+			if filter != nil {
+				t.Errorf("Test Desc(%s): got External Authorization filter %v, want none", tc.desc, filter)
+			}
+			continue
+		}
+		gotFilter, err := (&jsonpb.Marshaler{}).MarshalToString(filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := util.JsonEqual(tc.wantExtAuthzFilter, gotFilter); err != nil {
+			t.Errorf("Test Desc(%s): makeExtAuthzFilter failed,\n%v", tc.desc, err)
+		}
+	}
+}
+
+func TestRateLimitFilter(t *testing.T) {
+	testdata := []struct {
+		desc                       string
+		rateLimitRequestsPerSecond uint32
+		rateLimits                 []*options.RateLimitOptions
+		rateLimitServiceUri        string
+		wantFilters                []string
+		wantRateLimitFilter        string
+		wantGlobalRateLimit        *scpb.RateLimit
+		wantRequirementRateLimit   *scpb.RateLimit
+	}{
+		{
+			desc:        "No Rate Limit filter without --rate_limit_service_uri",
+			wantFilters: []string{util.PathMatcher, util.ServiceControl, util.Router},
+		},
+		{
+			desc:                       "Local rate limits in the Service Control filter",
+			rateLimitRequestsPerSecond: 100,
+			rateLimits: []*options.RateLimitOptions{
+				{
+					Selector:          "endpoints.examples.bookstore.Bookstore.CreateShelf",
+					RequestsPerSecond: 10,
+					Burst:             20,
+				},
+			},
+			wantFilters: []string{util.PathMatcher, util.ServiceControl, util.Router},
+			wantGlobalRateLimit: &scpb.RateLimit{
+				RequestsPerSecond: 100,
+			},
+			wantRequirementRateLimit: &scpb.RateLimit{
+				RequestsPerSecond: 10,
+				Burst:             20,
+			},
+		},
+		{
+			desc:                "Rate Limit filter with the rate limit service before Service Control filter",
+			rateLimitServiceUri: "grpc://ratelimit.example.com:8081",
+			wantFilters:         []string{util.PathMatcher, util.RateLimit, util.ServiceControl, util.Router},
+			wantRateLimitFilter: `{
+        "name": "envoy.filters.http.ratelimit",
+        "typedConfig": {
+          "@type":"type.googleapis.com/envoy.config.filter.http.rate_limit.v2.RateLimit",
+          "domain": "bookstore.endpoints.project123.cloud.goog",
+          "timeout": "0.020s",
+          "rateLimitService": {
+            "grpcService": {
+              "envoyGrpc": {
+                "clusterName": "rate-limit-service-cluster"
+              }
+            }
+          }
+        }
+      }`,
+		},
+	}
+
+	for _, tc := range testdata {
+		opts := options.DefaultConfigGeneratorOptions()
+		opts.RateLimitRequestsPerSecond = tc.rateLimitRequestsPerSecond
+		opts.RateLimits = tc.rateLimits
+		opts.RateLimitServiceUri = tc.rateLimitServiceUri
+		fakeServiceInfo, err := configinfo.NewServiceInfoFromServiceConfig(&confpb.Service{
+			Name: testProjectName,
+			Apis: []*apipb.Api{
+				{
+					Name: "endpoints.examples.bookstore.Bookstore",
+					Methods: []*apipb.Method{
+						{
+							Name: "CreateShelf",
+						},
+					},
+				},
+			},
+			Http: &annotationspb.Http{
+				Rules: []*annotationspb.HttpRule{
+					{
+						Selector: "endpoints.examples.bookstore.Bookstore.CreateShelf",
+						Pattern: &annotationspb.HttpRule_Post{
+							Post: "/v1/shelves",
+						},
+					},
+				},
+			},
+			Control: &confpb.Control{
+				Environment: testServiceControlEnv,
+			},
+		}, testConfigID, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		filters, err := makeHttpFilters(fakeServiceInfo)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var gotFilters []string
+		for _, filter := range filters {
+			gotFilters = append(gotFilters, filter.GetName())
+		}
+		if !reflect.DeepEqual(gotFilters, tc.wantFilters) {
+			t.Errorf("Test Desc(%s): got filters %v, want %v", tc.desc, gotFilters, tc.wantFilters)
+		}
+
+		scFilterConfig := &scpb.FilterConfig{}
+		if err := ptypes.UnmarshalAny(makeServiceControlFilter(fakeServiceInfo).GetTypedConfig(), scFilterConfig); err != nil {
+			t.Fatal(err)
+		}
+		if got := scFilterConfig.GetGlobalRateLimit(); !proto.Equal(got, tc.wantGlobalRateLimit) {
+			t.Errorf("Test Desc(%s): got global rate limit %v, want %v", tc.desc, got, tc.wantGlobalRateLimit)
+		}
+		for _, requirement := range scFilterConfig.GetRequirements() {
+			if requirement.GetOperationName() != "endpoints.examples.bookstore.Bookstore.CreateShelf" {
+				continue
+			}
+			if got := requirement.GetRateLimit(); !proto.Equal(got, tc.wantRequirementRateLimit) {
+				t.Errorf("Test Desc(%s): got rate limit of the requirement %v, want %v", tc.desc, got, tc.wantRequirementRateLimit)
+			}
+		}
+
+		filter := makeRateLimitFilter(fakeServiceInfo)
+		if tc.wantRateLimitFilter == "" {
+			if filter != nil {
+				t.Errorf("Test Desc(%s): got Rate Limit filter %v, want none", tc.desc, filter)
+			}
+			continue
+		}
+		gotFilter, err := (&jsonpb.Marshaler{}).MarshalToString(filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := util.JsonEqual(tc.wantRateLimitFilter, gotFilter); err != nil {
+			t.Errorf("Test Desc(%s): makeRateLimitFilter failed,\n%v", tc.desc, err)
+		}
+	}
+}
+
+func TestGrpcWebFilter(t *testing.T) {
+	testdata := []struct {
+		desc           string
+		backendAddress string
+		enableGrpcWeb  bool
+		wantFilters    []string
+		wantError      string
+	}{
+		{
+			desc:           "gRPC-Web filter after the transcoder by default",
+			backendAddress: "grpc://127.0.0.1:8082",
+			wantFilters:    []string{util.PathMatcher, util.ServiceControl, util.GRPCWeb, util.GrpcStatsFilterName, util.Router},
+		},
+		{
+			desc:           "gRPC-Web filter before the other filters with --enable_grpc_web",
+			backendAddress: "grpc://127.0.0.1:8082",
+			enableGrpcWeb:  true,
+			wantFilters:    []string{util.GRPCWeb, util.PathMatcher, util.ServiceControl, util.GrpcStatsFilterName, util.Router},
+		},
+		{
+			desc:           "Fail with --enable_grpc_web without gRPC backend",
+			backendAddress: "http://127.0.0.1:8082",
+			enableGrpcWeb:  true,
+			wantError:      "--enable_grpc_web requires a gRPC backend",
+		},
+	}
+
+	for _, tc := range testdata {
+		opts := options.DefaultConfigGeneratorOptions()
+		opts.BackendAddress = tc.backendAddress
+		opts.EnableGrpcWeb = tc.enableGrpcWeb
+		fakeServiceInfo, err := configinfo.NewServiceInfoFromServiceConfig(&confpb.Service{
+			Name: testProjectName,
+			Apis: []*apipb.Api{
+				{
+					Name: "endpoints.examples.bookstore.Bookstore",
+					Methods: []*apipb.Method{
+						{
+							Name: "CreateShelf",
+						},
+					},
+				},
+			},
+			Control: &confpb.Control{
+				Environment: testServiceControlEnv,
+			},
+		}, testConfigID, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		filters, err := makeHttpFilters(fakeServiceInfo)
+		if tc.wantError != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.wantError) {
+				t.Errorf("Test Desc(%s): got error %v, want error containing %q", tc.desc, err, tc.wantError)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		var gotFilters []string
+		for _, filter := range filters {
+			gotFilters = append(gotFilters, filter.GetName())
+		}
+		if !reflect.DeepEqual(gotFilters, tc.wantFilters) {
+			t.Errorf("Test Desc(%s): got filters %v, want %v", tc.desc, gotFilters, tc.wantFilters)
+		}
+	}
+}
+
+func TestMakeHttp3Listener(t *testing.T) {
+	testdata := []struct {
+		desc                   string
+		sslServerCertPath      string
+		http3SslServerCertPath string
+		http3AltSvcMaxAge      time.Duration
+		wantCertificate        string
+		wantAltSvc             string
+		wantError              string
+	}{
+		{
+			desc:              "HTTP/3 listener with the certificate of --ssl_server_cert_path",
+			sslServerCertPath: "/etc/ssl/endpoints/",
+			http3AltSvcMaxAge: 24 * time.Hour,
+			wantCertificate:   "/etc/ssl/endpoints/server.crt",
+			wantAltSvc:        `h3-27=":8443"; ma=86400`,
+		},
+		{
+			desc:                   "HTTP/3 listener with its own certificate, not advertised",
+			sslServerCertPath:      "/etc/ssl/endpoints/",
+			http3SslServerCertPath: "/etc/ssl/http3/",
+			wantCertificate:        "/etc/ssl/http3/server.crt",
+		},
+		{
+			desc:      "Fail without certificate",
+			wantError: "--http3_port requires --http3_ssl_server_cert_path or --ssl_server_cert_path",
+		},
+	}
+
+	for _, tc := range testdata {
+		opts := options.DefaultConfigGeneratorOptions()
+		opts.BackendAddress = "http://127.0.0.1:8082"
+		opts.SslServerCertPath = tc.sslServerCertPath
+		opts.Http3Port = 8443
+		opts.Http3SslServerCertPath = tc.http3SslServerCertPath
+		opts.Http3AltSvcMaxAge = tc.http3AltSvcMaxAge
+		fakeServiceInfo, err := configinfo.NewServiceInfoFromServiceConfig(&confpb.Service{
+			Name: testProjectName,
+			Apis: []*apipb.Api{
+				{
+					Name: testApiName,
+				},
+			},
+		}, testConfigID, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		listeners, err := MakeListeners(fakeServiceInfo)
+		if tc.wantError != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.wantError) {
+				t.Errorf("Test Desc(%s): got error %v, want error containing %q", tc.desc, err, tc.wantError)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(listeners) != 2 {
+			t.Fatalf("Test Desc(%s): got %d listeners, want 2", tc.desc, len(listeners))
+		}
+
+		http3Listener := listeners[1]
+		address := http3Listener.GetAddress().GetSocketAddress()
+		if address.GetProtocol() != corepb.SocketAddress_UDP || address.GetPortValue() != 8443 {
+			t.Errorf("Test Desc(%s): got address %v, want UDP port 8443", tc.desc, address)
+		}
+		if got := http3Listener.GetUdpListenerConfig().GetUdpListenerName(); got != util.QuicListenerName {
+			t.Errorf("Test Desc(%s): got UDP listener %q, want %q", tc.desc, got, util.QuicListenerName)
+		}
+		transportSocket := http3Listener.GetFilterChains()[0].GetTransportSocket()
+		tlsContext := &authpb.DownstreamTlsContext{}
+		if err := ptypes.UnmarshalAny(transportSocket.GetTypedConfig(), tlsContext); err != nil {
+			t.Fatal(err)
+		}
+		if transportSocket.GetName() != util.QUICTransportSocket {
+			t.Errorf("Test Desc(%s): got transport socket %q, want %q", tc.desc, transportSocket.GetName(), util.QUICTransportSocket)
+		}
+		if got := tlsContext.GetCommonTlsContext().GetTlsCertificates()[0].GetCertificateChain().GetFilename(); got != tc.wantCertificate {
+			t.Errorf("Test Desc(%s): got certificate %q, want %q", tc.desc, got, tc.wantCertificate)
+		}
+		httpConMgr := &hcmpb.HttpConnectionManager{}
+		if err := ptypes.UnmarshalAny(http3Listener.GetFilterChains()[0].GetFilters()[0].GetTypedConfig(), httpConMgr); err != nil {
+			t.Fatal(err)
+		}
+		if httpConMgr.GetCodecType() != hcmpb.HttpConnectionManager_HTTP3 {
+			t.Errorf("Test Desc(%s): got codec type %v, want HTTP3", tc.desc, httpConMgr.GetCodecType())
+		}
+
+		frontConMgr := &hcmpb.HttpConnectionManager{}
+		if err := ptypes.UnmarshalAny(listeners[0].GetFilterChains()[0].GetFilters()[0].GetTypedConfig(), frontConMgr); err != nil {
+			t.Fatal(err)
+		}
+		var gotAltSvc string
+		for _, header := range frontConMgr.GetRouteConfig().GetResponseHeadersToAdd() {
+			if header.GetHeader().GetKey() == "alt-svc" {
+				gotAltSvc = header.GetHeader().GetValue()
+			}
+		}
+		if gotAltSvc != tc.wantAltSvc {
+			t.Errorf("Test Desc(%s): got alt-svc %q, want %q", tc.desc, gotAltSvc, tc.wantAltSvc)
+		}
+	}
+}
+
+func TestMakeAcmeHttpListener(t *testing.T) {
+	testdata := []struct {
+		desc          string
+		listenerPort  int
+		acmeDirectory string
+		wantListeners int
+		wantRedirect  uint32
+	}{
+		{
+			desc:          "SDS server certificate without ACME",
+			listenerPort:  443,
+			wantListeners: 1,
+		},
+		{
+			desc:          "ACME HTTP listener redirecting to the default HTTPS port",
+			listenerPort:  443,
+			acmeDirectory: "https://acme.example.com/directory",
+			wantListeners: 2,
+		},
+		{
+			desc:          "ACME HTTP listener redirecting to the listener port",
+			listenerPort:  8443,
+			acmeDirectory: "https://acme.example.com/directory",
+			wantListeners: 2,
+			wantRedirect:  8443,
+		},
+	}
+
+	for _, tc := range testdata {
+		opts := options.DefaultConfigGeneratorOptions()
+		opts.BackendAddress = "http://127.0.0.1:8082"
+		opts.ListenerPort = tc.listenerPort
+		opts.SslServerCertPath = "/etc/ssl/endpoints/"
+		opts.SslServerCertSds = true
+		opts.SslServerAcmeDirectoryUrl = tc.acmeDirectory
+		fakeServiceInfo, err := configinfo.NewServiceInfoFromServiceConfig(&confpb.Service{
+			Name: testProjectName,
+			Apis: []*apipb.Api{
+				{
+					Name: testApiName,
+				},
+			},
+		}, testConfigID, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		listeners, err := MakeListeners(fakeServiceInfo)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(listeners) != tc.wantListeners {
+			t.Fatalf("Test Desc(%s): got %d listeners, want %d", tc.desc, len(listeners), tc.wantListeners)
+		}
+
+		tlsContext := &authpb.DownstreamTlsContext{}
+		if err := ptypes.UnmarshalAny(listeners[0].GetFilterChains()[0].GetTransportSocket().GetTypedConfig(), tlsContext); err != nil {
+			t.Fatal(err)
+		}
+		sdsConfigs := tlsContext.GetCommonTlsContext().GetTlsCertificateSdsSecretConfigs()
+		if len(sdsConfigs) != 1 || sdsConfigs[0].GetName() != util.ServerCertSecretName {
+			t.Errorf("Test Desc(%s): got SDS secret configs %v, want secret %q", tc.desc, sdsConfigs, util.ServerCertSecretName)
+		}
+		if tc.acmeDirectory == "" {
+			continue
+		}
+
+		acmeListener := listeners[1]
+		if got := acmeListener.GetAddress().GetSocketAddress().GetPortValue(); got != 80 {
+			t.Errorf("Test Desc(%s): got ACME listener port %d, want 80", tc.desc, got)
+		}
+		if acmeListener.GetFilterChains()[0].GetTransportSocket() != nil {
+			t.Errorf("Test Desc(%s): got transport socket on the ACME listener, want plain HTTP", tc.desc)
+		}
+		httpConMgr := &hcmpb.HttpConnectionManager{}
+		if err := ptypes.UnmarshalAny(acmeListener.GetFilterChains()[0].GetFilters()[0].GetTypedConfig(), httpConMgr); err != nil {
+			t.Fatal(err)
+		}
+		routes := httpConMgr.GetRouteConfig().GetVirtualHosts()[0].GetRoutes()
+		if got := routes[0].GetRoute().GetCluster(); got != util.AcmeChallengeClusterName {
+			t.Errorf("Test Desc(%s): got challenge cluster %q, want %q", tc.desc, got, util.AcmeChallengeClusterName)
+		}
+		if got := routes[1].GetRedirect(); !got.GetHttpsRedirect() || got.GetPortRedirect() != tc.wantRedirect {
+			t.Errorf("Test Desc(%s): got redirect %v, want HTTPS redirect to port %d", tc.desc, got, tc.wantRedirect)
+		}
+	}
+}
+
+func TestMakeServiceDeletedListeners(t *testing.T) {
+	opts := options.DefaultConfigGeneratorOptions()
+	opts.BackendAddress = "http://127.0.0.1:8082"
+	fakeServiceInfo, err := configinfo.NewServiceInfoFromServiceConfig(&confpb.Service{
+		Name: testProjectName,
+		Apis: []*apipb.Api{
+			{
+				Name: testApiName,
+			},
+		},
+	}, testConfigID, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listeners, err := MakeServiceDeletedListeners(fakeServiceInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listeners) != 1 {
+		t.Fatalf("got %d listeners, want 1", len(listeners))
+	}
+	httpConMgr := &hcmpb.HttpConnectionManager{}
+	if err := ptypes.UnmarshalAny(listeners[0].GetFilterChains()[0].GetFilters()[0].GetTypedConfig(), httpConMgr); err != nil {
+		t.Fatal(err)
+	}
+	if filters := httpConMgr.GetHttpFilters(); len(filters) != 1 || filters[0].GetName() != util.Router {
+		t.Errorf("got HTTP filters %v, want only the Router filter", filters)
+	}
+	routes := httpConMgr.GetRouteConfig().GetVirtualHosts()[0].GetRoutes()
+	wantBody := fmt.Sprintf("service %s is deleted", testProjectName)
+	if len(routes) != 1 || routes[0].GetDirectResponse().GetStatus() != 410 || routes[0].GetDirectResponse().GetBody().GetInlineString() != wantBody {
+		t.Errorf("got routes %v, want a single direct response 410 with body %q", routes, wantBody)
+	}
+}
+
+func TestDownstreamClientCert(t *testing.T) {
+	testdata := []struct {
+		desc              string
+		requireClientCert bool
+		spiffeTrustDomain string
+		forwardClientCert bool
+		wantPrincipals    []string
+		wantXfcc          hcmpb.HttpConnectionManager_ForwardClientCertDetails
+		wantLogHeaders    []string
+	}{
+		{
+			desc: "Optional client certificates validated with the CA",
+		},
+		{
+			desc:              "Required client certificates in a SPIFFE trust domain",
+			requireClientCert: true,
+			spiffeTrustDomain: "example.org",
+			wantPrincipals:    []string{"spiffe://example.org/"},
+		},
+		{
+			desc:              "Optional client certificates in a SPIFFE trust domain, forwarded to the backend",
+			spiffeTrustDomain: "example.org",
+			forwardClientCert: true,
+			wantPrincipals:    []string{"spiffe://example.org/", ""},
+			wantXfcc:          hcmpb.HttpConnectionManager_SANITIZE_SET,
+			wantLogHeaders:    []string{util.ForwardedClientCertHeader},
+		},
+	}
+
+	for _, tc := range testdata {
+		opts := options.DefaultConfigGeneratorOptions()
+		opts.BackendAddress = "http://127.0.0.1:8082"
+		opts.SslServerCertPath = "/etc/ssl/endpoints/"
+		opts.SslServerClientCaPath = "/etc/ssl/clients/ca.crt"
+		opts.SslServerRequireClientCert = tc.requireClientCert
+		opts.SslServerClientSpiffeTrustDomain = tc.spiffeTrustDomain
+		opts.SslServerForwardClientCert = tc.forwardClientCert
+		fakeServiceInfo, err := configinfo.NewServiceInfoFromServiceConfig(&confpb.Service{
+			Name: testProjectName,
+			Apis: []*apipb.Api{
+				{
+					Name: testApiName,
+				},
+			},
+			Control: &confpb.Control{
+				Environment: testServiceControlEnv,
+			},
+		}, testConfigID, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		listeners, err := MakeListeners(fakeServiceInfo)
+		if err != nil {
+			t.Fatal(err)
+		}
+		filterChain := listeners[0].GetFilterChains()[0]
+
+		tlsContext := &authpb.DownstreamTlsContext{}
+		if err := ptypes.UnmarshalAny(filterChain.GetTransportSocket().GetTypedConfig(), tlsContext); err != nil {
+			t.Fatal(err)
+		}
+		if got := tlsContext.GetCommonTlsContext().GetValidationContext().GetTrustedCa().GetFilename(); got != opts.SslServerClientCaPath {
+			t.Errorf("Test Desc(%s): got client CA %q, want %q", tc.desc, got, opts.SslServerClientCaPath)
+		}
+		if got := tlsContext.GetRequireClientCertificate().GetValue(); got != tc.requireClientCert {
+			t.Errorf("Test Desc(%s): got require client certificate %v, want %v", tc.desc, got, tc.requireClientCert)
+		}
+
+		var gotPrincipals []string
+		if filterChain.GetFilters()[0].GetName() == util.NetworkRBAC {
+			rbac := &netrbacpb.RBAC{}
+			if err := ptypes.UnmarshalAny(filterChain.GetFilters()[0].GetTypedConfig(), rbac); err != nil {
+				t.Fatal(err)
+			}
+			for _, principal := range rbac.GetRules().GetPolicies()["spiffe_trust_domain"].GetPrincipals() {
+				name := principal.GetAuthenticated().GetPrincipalName()
+				gotPrincipals = append(gotPrincipals, name.GetPrefix()+name.GetExact())
+			}
+		}
+		if !reflect.DeepEqual(gotPrincipals, tc.wantPrincipals) {
+			t.Errorf("Test Desc(%s): got RBAC principals %q, want %q", tc.desc, gotPrincipals, tc.wantPrincipals)
+		}
+
+		httpConMgr := &hcmpb.HttpConnectionManager{}
+		if err := ptypes.UnmarshalAny(filterChain.GetFilters()[len(filterChain.GetFilters())-1].GetTypedConfig(), httpConMgr); err != nil {
+			t.Fatal(err)
+		}
+		if got := httpConMgr.GetForwardClientCertDetails(); got != tc.wantXfcc {
+			t.Errorf("Test Desc(%s): got forward client cert details %v, want %v", tc.desc, got, tc.wantXfcc)
+		}
+
+		scConfig := &scpb.FilterConfig{}
+		if err := ptypes.UnmarshalAny(makeServiceControlFilter(fakeServiceInfo).GetTypedConfig(), scConfig); err != nil {
+			t.Fatal(err)
+		}
+		if got := scConfig.GetServices()[0].GetLogRequestHeaders(); !reflect.DeepEqual(got, tc.wantLogHeaders) {
+			t.Errorf("Test Desc(%s): got logged request headers %q, want %q", tc.desc, got, tc.wantLogHeaders)
+		}
+	}
+}
+
+func TestMakeSniFilterChains(t *testing.T) {
+	opts := options.DefaultConfigGeneratorOptions()
+	opts.BackendAddress = "http://127.0.0.1:8082"
+	opts.SslServerCertPath = "/etc/ssl/endpoints/"
+	opts.SslServerSniCertificates = []*options.SniCertificateOptions{
+		{
+			ServerNames: []string{"api.example.com"},
+			CertPath:    "/etc/ssl/example/api.crt",
+			KeyPath:     "/etc/ssl/example/api.key",
+		},
+		{
+			ServerNames: []string{"*.example.org", "example.org"},
+			CertPath:    "/etc/ssl/example/org.crt",
+			KeyPath:     "/etc/ssl/example/org.key",
+		},
+	}
+	fakeServiceInfo, err := configinfo.NewServiceInfoFromServiceConfig(&confpb.Service{
+		Name: testProjectName,
+		Apis: []*apipb.Api{
+			{
+				Name: testApiName,
+			},
+		},
+	}, testConfigID, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listeners, err := MakeListeners(fakeServiceInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener := listeners[0]
+	if len(listener.GetListenerFilters()) != 1 || listener.GetListenerFilters()[0].GetName() != util.TLSInspector {
+		t.Errorf("got listener filters %v, want %s", listener.GetListenerFilters(), util.TLSInspector)
+	}
+
+	wantServerNames := [][]string{nil, {"api.example.com"}, {"*.example.org", "example.org"}}
+	wantCertificates := []string{"/etc/ssl/endpoints/server.crt", "/etc/ssl/example/api.crt", "/etc/ssl/example/org.crt"}
+	if len(listener.GetFilterChains()) != len(wantServerNames) {
+		t.Fatalf("got %d filter chains, want %d", len(listener.GetFilterChains()), len(wantServerNames))
+	}
+	for i, filterChain := range listener.GetFilterChains() {
+		if got := filterChain.GetFilterChainMatch().GetServerNames(); !reflect.DeepEqual(got, wantServerNames[i]) {
+			t.Errorf("Test Desc(%d): got server names %v, want %v", i, got, wantServerNames[i])
+		}
+		tlsContext := &authpb.DownstreamTlsContext{}
+		if err := ptypes.UnmarshalAny(filterChain.GetTransportSocket().GetTypedConfig(), tlsContext); err != nil {
+			t.Fatal(err)
+		}
+		if got := tlsContext.GetCommonTlsContext().GetTlsCertificates()[0].GetCertificateChain().GetFilename(); got != wantCertificates[i] {
+			t.Errorf("Test Desc(%d): got certificate %q, want %q", i, got, wantCertificates[i])
+		}
+		if got := filterChain.GetFilters()[0].GetName(); got != util.HTTPConnectionManager {
+			t.Errorf("Test Desc(%d): got filter %q, want %q", i, got, util.HTTPConnectionManager)
+		}
+	}
+}
+
+func TestHttpConnectionManagerTracing(t *testing.T) {
+	testdata := []struct {
+		desc                    string
+		disableTracing          bool
+		tracingClientSampleRate float64
+		wantTracing             *hcmpb.HttpConnectionManager_Tracing
+	}{
+		{
+			desc:           "No tracing with tracing disabled",
+			disableTracing: true,
+		},
+		{
+			desc:                    "All requests with x-client-trace-id forced by default",
+			tracingClientSampleRate: 1.0,
+			wantTracing:             &hcmpb.HttpConnectionManager_Tracing{},
+		},
+		{
+			desc:                    "Half the requests with x-client-trace-id forced",
+			tracingClientSampleRate: 0.5,
+			wantTracing: &hcmpb.HttpConnectionManager_Tracing{
+				ClientSampling: &typepb.Percent{Value: 50},
+			},
+		},
+		{
+			desc:                    "x-client-trace-id ignored",
+			tracingClientSampleRate: 0.0,
+			wantTracing: &hcmpb.HttpConnectionManager_Tracing{
+				ClientSampling: &typepb.Percent{Value: 0},
+			},
+		},
+	}
+
+	for _, tc := range testdata {
+		opts := options.DefaultConfigGeneratorOptions()
+		opts.DisableTracing = tc.disableTracing
+		opts.TracingClientSampleRate = tc.tracingClientSampleRate
+		httpConMgr := makeHttpConnectionManager(opts, nil, nil)
+		if !proto.Equal(httpConMgr.GetTracing(), tc.wantTracing) {
+			t.Errorf("Test Desc(%s): got tracing %v, want %v", tc.desc, httpConMgr.GetTracing(), tc.wantTracing)
+		}
+	}
+}
+
+func TestHttpConnectionManagerRequestId(t *testing.T) {
+	testdata := []struct {
+		desc                          string
+		requestIdMode                 string
+		wantGenerateRequestId         *wrapperspb.BoolValue
+		wantPreserveExternalRequestId bool
+	}{
+		{
+			desc:          "Request IDs generated by default",
+			requestIdMode: "generate",
+		},
+		{
+			desc:                          "Request IDs of the external requests preserved",
+			requestIdMode:                 "preserve",
+			wantPreserveExternalRequestId: true,
+		},
+		{
+			desc:                  "Request IDs not generated",
+			requestIdMode:         "none",
+			wantGenerateRequestId: &wrapperspb.BoolValue{Value: false},
+		},
+	}
+
+	for _, tc := range testdata {
+		opts := options.DefaultConfigGeneratorOptions()
+		opts.RequestIdMode = tc.requestIdMode
+		httpConMgr := makeHttpConnectionManager(opts, nil, nil)
+		if !proto.Equal(httpConMgr.GetGenerateRequestId(), tc.wantGenerateRequestId) {
+			t.Errorf("Test Desc(%s): got generate_request_id %v, want %v", tc.desc, httpConMgr.GetGenerateRequestId(), tc.wantGenerateRequestId)
+		}
+		if httpConMgr.GetPreserveExternalRequestId() != tc.wantPreserveExternalRequestId {
+			t.Errorf("Test Desc(%s): got preserve_external_request_id %v, want %v", tc.desc, httpConMgr.GetPreserveExternalRequestId(), tc.wantPreserveExternalRequestId)
+		}
+	}
+}
+
+func TestHttpConnectionManagerTrustedProxies(t *testing.T) {
+	testdata := []struct {
+		desc                  string
+		trustedProxyCidrs     string
+		wantUseRemoteAddress  bool
+		wantXffNumTrustedHops uint32
+	}{
+		{
+			desc:                  "x-forwarded-for trusted by default",
+			wantXffNumTrustedHops: 2,
+		},
+		{
+			desc:                 "Peer address used with trusted proxies",
+			trustedProxyCidrs:    "35.191.0.0/16, 130.211.0.0/22",
+			wantUseRemoteAddress: true,
+		},
+	}
+
+	for _, tc := range testdata {
+		opts := options.DefaultConfigGeneratorOptions()
+		opts.TrustedProxyCidrs = tc.trustedProxyCidrs
+		httpConMgr := makeHttpConnectionManager(opts, nil, nil)
+		if got := httpConMgr.GetUseRemoteAddress().GetValue(); got != tc.wantUseRemoteAddress {
+			t.Errorf("Test Desc(%s): got use_remote_address %v, want %v", tc.desc, got, tc.wantUseRemoteAddress)
+		}
+		if got := httpConMgr.GetXffNumTrustedHops(); got != tc.wantXffNumTrustedHops {
+			t.Errorf("Test Desc(%s): got xff_num_trusted_hops %v, want %v", tc.desc, got, tc.wantXffNumTrustedHops)
+		}
+	}
+}
+
+func TestListenerAddress(t *testing.T) {
+	testdata := []struct {
+		desc            string
+		listenerAddress string
+		wantAddress     *corepb.Address
+	}{
+		{
+			desc:            "IPv4 address",
+			listenerAddress: "0.0.0.0",
+			wantAddress: &corepb.Address{
+				Address: &corepb.Address_SocketAddress{
+					SocketAddress: &corepb.SocketAddress{
+						Address: "0.0.0.0",
+						PortSpecifier: &corepb.SocketAddress_PortValue{
+							PortValue: 8080,
+						},
+					},
+				},
+			},
+		},
+		{
+			desc:            "IPv6 wildcard address in dual-stack",
+			listenerAddress: "::",
+			wantAddress: &corepb.Address{
+				Address: &corepb.Address_SocketAddress{
+					SocketAddress: &corepb.SocketAddress{
+						Address: "::",
+						PortSpecifier: &corepb.SocketAddress_PortValue{
+							PortValue: 8080,
+						},
+						Ipv4Compat: true,
+					},
+				},
+			},
+		},
+		{
+			desc:            "Unix domain socket",
+			listenerAddress: "unix:/var/run/esp.sock",
+			wantAddress: &corepb.Address{
+				Address: &corepb.Address_Pipe{
+					Pipe: &corepb.Pipe{
+						Path: "/var/run/esp.sock",
+					},
+				},
+			},
+		},
+	}
+
+	for _, tc := range testdata {
+		opts := options.DefaultConfigGeneratorOptions()
+		opts.BackendAddress = "http://127.0.0.1:8082"
+		opts.ListenerAddress = tc.listenerAddress
+		listener, err := makeListenerWithFilters(opts, nil, &v2pb.RouteConfiguration{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !proto.Equal(listener.GetAddress(), tc.wantAddress) {
+			t.Errorf("Test Desc(%s): got address %v, want %v", tc.desc, listener.GetAddress(), tc.wantAddress)
+		}
+	}
+}
+
+func TestListenerProxyProtocol(t *testing.T) {
+	testdata := []struct {
+		desc                string
+		enableProxyProtocol bool
+		sniCertificates     []*options.SniCertificateOptions
+		wantListenerFilters []string
+	}{
+		{
+			desc: "No listener filters by default",
+		},
+		{
+			desc:                "PROXY protocol enabled",
+			enableProxyProtocol: true,
+			wantListenerFilters: []string{util.ProxyProtocol},
+		},
+		{
+			desc:                "PROXY protocol before TLS inspector",
+			enableProxyProtocol: true,
+			sniCertificates: []*options.SniCertificateOptions{
+				{
+					ServerNames: []string{"api.example.com"},
+					CertPath:    "/etc/ssl/example/api.crt",
+					KeyPath:     "/etc/ssl/example/api.key",
+				},
+			},
+			wantListenerFilters: []string{util.ProxyProtocol, util.TLSInspector},
+		},
+	}
+
+	for _, tc := range testdata {
+		opts := options.DefaultConfigGeneratorOptions()
+		opts.BackendAddress = "http://127.0.0.1:8082"
+		opts.EnableProxyProtocol = tc.enableProxyProtocol
+		if tc.sniCertificates != nil {
+			opts.SslServerCertPath = "/etc/ssl/endpoints/"
+			opts.SslServerSniCertificates = tc.sniCertificates
+		}
+		listener, err := makeListenerWithFilters(opts, nil, &v2pb.RouteConfiguration{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var gotListenerFilters []string
+		for _, filter := range listener.GetListenerFilters() {
+			gotListenerFilters = append(gotListenerFilters, filter.GetName())
+		}
+		if !reflect.DeepEqual(gotListenerFilters, tc.wantListenerFilters) {
+			t.Errorf("Test Desc(%s): got listener filters %v, want %v", tc.desc, gotListenerFilters, tc.wantListenerFilters)
+		}
+	}
+}
+
+func TestServiceControlTracingCustomTags(t *testing.T) {
+	testdata := []struct {
+		desc              string
+		disableTracing    bool
+		tracingCustomTags string
+		wantTags          []string
+	}{
+		{
+			desc: "No tags by default",
+		},
+		{
+			desc:              "API and consumer tags",
+			tracingCustomTags: "api_name, operation_name,consumer_id",
+			wantTags:          []string{"api_name", "operation_name", "consumer_id"},
+		},
+		{
+			desc:              "No tags with tracing disabled",
+			disableTracing:    true,
+			tracingCustomTags: "api_name",
+		},
+	}
+
+	for _, tc := range testdata {
+		opts := options.DefaultConfigGeneratorOptions()
+		opts.DisableTracing = tc.disableTracing
+		opts.TracingCustomTags = tc.tracingCustomTags
+		fakeServiceInfo, err := configinfo.NewServiceInfoFromServiceConfig(&confpb.Service{
+			Name: testProjectName,
+			Apis: []*apipb.Api{
+				{
+					Name: testApiName,
+				},
+			},
+			Control: &confpb.Control{
+				Environment: testServiceControlEnv,
+			},
+		}, testConfigID, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		scConfig := &scpb.FilterConfig{}
+		if err := ptypes.UnmarshalAny(makeServiceControlFilter(fakeServiceInfo).GetTypedConfig(), scConfig); err != nil {
+			t.Fatal(err)
+		}
+		if got := scConfig.GetServices()[0].GetTracingCustomTags(); !reflect.DeepEqual(got, tc.wantTags) {
+			t.Errorf("Test Desc(%s): got tracing custom tags %q, want %q", tc.desc, got, tc.wantTags)
+		}
+	}
+}
+
+func TestServiceControlMetricCosts(t *testing.T) {
+	opts := options.DefaultConfigGeneratorOptions()
+	fakeServiceInfo, err := configinfo.NewServiceInfoFromServiceConfig(&confpb.Service{
+		Name: testProjectName,
+		Apis: []*apipb.Api{
+			{
+				Name: testApiName,
+				Methods: []*apipb.Method{
+					{
+						Name: "ListShelves",
+					},
+					{
+						Name: "CreateShelf",
+					},
+				},
+			},
+		},
+		Control: &confpb.Control{
+			Environment: testServiceControlEnv,
+		},
+		Quota: &confpb.Quota{
+			Limits: []*confpb.QuotaLimit{
+				{
+					Name:   "read-limit",
+					Metric: "read-requests",
+				},
+				{
+					Name:   "expensive-limit",
+					Metric: "expensive-requests",
+				},
+			},
+			MetricRules: []*confpb.MetricRule{
+				{
+					Selector: fmt.Sprintf("%s.ListShelves", testApiName),
+					MetricCosts: map[string]int64{
+						"read-requests":      1,
+						"expensive-requests": 5,
+					},
+				},
+			},
+		},
+	}, testConfigID, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	scConfig := &scpb.FilterConfig{}
+	if err := ptypes.UnmarshalAny(makeServiceControlFilter(fakeServiceInfo).GetTypedConfig(), scConfig); err != nil {
+		t.Fatal(err)
+	}
+	wantMetricCosts := map[string][]*scpb.MetricCost{
+		fmt.Sprintf("%s.ListShelves", testApiName): {
+			{
+				Name: "expensive-requests",
+				Cost: 5,
+			},
+			{
+				Name: "read-requests",
+				Cost: 1,
+			},
+		},
+		fmt.Sprintf("%s.CreateShelf", testApiName): nil,
+	}
+	for _, requirement := range scConfig.GetRequirements() {
+		want, ok := wantMetricCosts[requirement.GetOperationName()]
+		if !ok {
+			continue
+		}
+		got := requirement.GetMetricCosts()
+		equal := len(got) == len(want)
+		for i := 0; equal && i < len(got); i++ {
+			equal = proto.Equal(got[i], want[i])
+		}
+		if !equal {
+			t.Errorf("operation %s: got metric costs %v, want %v", requirement.GetOperationName(), got, want)
+		}
+	}
+}
+
+func TestServiceControlConsumerHeaders(t *testing.T) {
+	testdata := []struct {
+		desc                  string
+		consumerProjectHeader string
+		consumerIdHeader      string
+	}{
+		{
+			desc: "Default consumer headers",
+		},
+		{
+			desc:                  "Custom consumer headers",
+			consumerProjectHeader: "x-endpoint-api-consumer-project",
+			consumerIdHeader:      "x-endpoint-api-consumer-id",
+		},
+	}
+
+	for _, tc := range testdata {
+		opts := options.DefaultConfigGeneratorOptions()
+		opts.ConsumerProjectHeader = tc.consumerProjectHeader
+		opts.ConsumerIdHeader = tc.consumerIdHeader
+		fakeServiceInfo, err := configinfo.NewServiceInfoFromServiceConfig(&confpb.Service{
+			Name: testProjectName,
+			Apis: []*apipb.Api{
+				{
+					Name: testApiName,
+				},
+			},
+			Control: &confpb.Control{
+				Environment: testServiceControlEnv,
+			},
+		}, testConfigID, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		scConfig := &scpb.FilterConfig{}
+		if err := ptypes.UnmarshalAny(makeServiceControlFilter(fakeServiceInfo).GetTypedConfig(), scConfig); err != nil {
+			t.Fatal(err)
+		}
+		service := scConfig.GetServices()[0]
+		if got := service.GetConsumerProjectHeader(); got != tc.consumerProjectHeader {
+			t.Errorf("Test Desc(%s): got consumer project header %q, want %q", tc.desc, got, tc.consumerProjectHeader)
+		}
+		if got := service.GetConsumerIdHeader(); got != tc.consumerIdHeader {
+			t.Errorf("Test Desc(%s): got consumer ID header %q, want %q", tc.desc, got, tc.consumerIdHeader)
+		}
+	}
+}
+
+func TestServiceControlTransport(t *testing.T) {
+	testdata := []struct {
+		desc              string
+		protocol          string
+		auth              string
+		wantedTransport   *scpb.ServiceControlTransport
+		wantedAccessToken bool
+	}{
+		{
+			desc:              "Default transport with the access token",
+			protocol:          "http",
+			auth:              "access_token",
+			wantedAccessToken: true,
+		},
+		{
+			desc:     "gRPC transport without auth",
+			protocol: "grpc",
+			auth:     "none",
+			wantedTransport: &scpb.ServiceControlTransport{
+				Protocol: scpb.ServiceControlTransport_GRPC,
+				Auth:     scpb.ServiceControlTransport_NONE,
+			},
 		},
 	}
 
-	for i, tc := range testdata {
+	for _, tc := range testdata {
 		opts := options.DefaultConfigGeneratorOptions()
-		opts.BackendAddress = tc.BackendAddress
-		opts.Healthz = tc.healthz
-		fakeServiceInfo, err := configinfo.NewServiceInfoFromServiceConfig(tc.fakeServiceConfig, testConfigID, opts)
+		opts.ServiceControlProtocol = tc.protocol
+		opts.ServiceControlAuth = tc.auth
+		fakeServiceInfo, err := configinfo.NewServiceInfoFromServiceConfig(&confpb.Service{
+			Name: testProjectName,
+			Apis: []*apipb.Api{
+				{
+					Name: testApiName,
+				},
+			},
+			Control: &confpb.Control{
+				Environment: testServiceControlEnv,
+			},
+		}, testConfigID, opts)
 		if err != nil {
 			t.Fatal(err)
 		}
 
-		marshaler := &jsonpb.Marshaler{}
-		filter, err := makeHealthCheckFilter(fakeServiceInfo)
-		if err != nil {
+		scConfig := &scpb.FilterConfig{}
+		if err := ptypes.UnmarshalAny(makeServiceControlFilter(fakeServiceInfo).GetTypedConfig(), scConfig); err != nil {
 			t.Fatal(err)
 		}
+		if !proto.Equal(scConfig.GetScTransport(), tc.wantedTransport) {
+			t.Errorf("Test Desc(%s): got transport %v, want %v", tc.desc, scConfig.GetScTransport(), tc.wantedTransport)
+		}
+		if got := scConfig.GetAccessToken() != nil; got != tc.wantedAccessToken {
+			t.Errorf("Test Desc(%s): got access token set %v, want %v", tc.desc, got, tc.wantedAccessToken)
+		}
+	}
+}
 
-		gotFilter, err := marshaler.MarshalToString(filter)
+func TestMakeServiceControlCallingConfig(t *testing.T) {
+	testdata := []struct {
+		desc                    string
+		scReportBatchMaxSize    int
+		scReportBatchMaxDelayMs int
+		scReportBatchMaxBytes   int
+		circuitBreakerThreshold int
+		circuitBreakerFailOpen  bool
+		reportSpillDir          string
+		checkCacheMaxEntries    int
+		checkCacheNegativeTtlMs int
+		checkCacheServeStale    bool
+		quotaBucketBurst        int
+		quotaRefillIntervalMs   int
+		wantCallingConfig       string
+	}{
+		{
+			desc:                    "Report batching is not set by default",
+			checkCacheMaxEntries:    -1,
+			checkCacheNegativeTtlMs: -1,
+			wantCallingConfig: `{
+        "networkFailOpen": true
+      }`,
+		},
+		{
+			desc:                    "Report batching is set with the flags",
+			checkCacheMaxEntries:    -1,
+			checkCacheNegativeTtlMs: -1,
+			scReportBatchMaxSize:    500,
+			scReportBatchMaxDelayMs: 2000,
+			scReportBatchMaxBytes:   1048576,
+			wantCallingConfig: `{
+        "networkFailOpen": true,
+        "reportBatchMaxSize": 500,
+        "reportBatchMaxDelayMs": 2000,
+        "reportBatchMaxBytes": 1048576
+      }`,
+		},
+		{
+			desc:                    "Circuit breaker is set with the flags",
+			checkCacheMaxEntries:    -1,
+			checkCacheNegativeTtlMs: -1,
+			circuitBreakerThreshold: 5,
+			circuitBreakerFailOpen:  false,
+			reportSpillDir:          "/var/spool/espv2",
+			wantCallingConfig: `{
+        "networkFailOpen": true,
+        "circuitBreaker": {
+          "failureThreshold": 5,
+          "failOpen": false,
+          "reportSpillDir": "/var/spool/espv2"
+        }
+      }`,
+		},
+		{
+			desc:                    "Check cache is set with the flags, 0 is kept",
+			checkCacheMaxEntries:    100,
+			checkCacheNegativeTtlMs: 0,
+			checkCacheServeStale:    true,
+			wantCallingConfig: `{
+        "networkFailOpen": true,
+        "checkCache": {
+          "maxEntries": 100,
+          "negativeTtlMs": 0,
+          "serveStaleOnFailure": true
+        }
+      }`,
+		},
+		{
+			desc:                    "Quota buckets are set with the flags",
+			checkCacheMaxEntries:    -1,
+			checkCacheNegativeTtlMs: -1,
+			quotaBucketBurst:        100,
+			quotaRefillIntervalMs:   500,
+			wantCallingConfig: `{
+        "networkFailOpen": true,
+        "quotaBucket": {
+          "burst": 100,
+          "refillIntervalMs": 500
+        }
+      }`,
+		},
+	}
+
+	for _, tc := range testdata {
+		opts := options.DefaultConfigGeneratorOptions()
+		opts.ScReportBatchMaxSize = tc.scReportBatchMaxSize
+		opts.ScReportBatchMaxDelayMs = tc.scReportBatchMaxDelayMs
+		opts.ScReportBatchMaxBytes = tc.scReportBatchMaxBytes
+		opts.ScCircuitBreakerFailureThreshold = tc.circuitBreakerThreshold
+		opts.ScCircuitBreakerFailOpen = tc.circuitBreakerFailOpen
+		opts.ScReportSpillDir = tc.reportSpillDir
+		opts.ScCheckCacheMaxEntries = tc.checkCacheMaxEntries
+		opts.ScCheckCacheNegativeTtlMs = tc.checkCacheNegativeTtlMs
+		opts.ScCheckCacheServeStale = tc.checkCacheServeStale
+		opts.ScQuotaBucketBurst = tc.quotaBucketBurst
+		opts.ScQuotaBucketRefillIntervalMs = tc.quotaRefillIntervalMs
+
+		gotCallingConfig, err := (&jsonpb.Marshaler{}).MarshalToString(makeServiceControlCallingConfig(opts))
 		if err != nil {
 			t.Fatal(err)
 		}
-
-		if err := util.JsonEqual(tc.wantHealthCheckFilter, gotFilter); err != nil {
-			t.Errorf("Test Desc(%d): %s, makeHealthCheckFilter failed,\n%v", i, tc.desc, err)
+		if err := util.JsonEqual(tc.wantCallingConfig, gotCallingConfig); err != nil {
+			t.Errorf("Test Desc(%s): makeServiceControlCallingConfig failed,\n%v", tc.desc, err)
 		}
 	}
 }
 
 func TestMakeListeners(t *testing.T) {
 	testdata := []struct {
-		desc              string
-		sslServerCertPath string
-		fakeServiceConfig *confpb.Service
-		wantListeners     []string
+		desc               string
+		sslServerCertPath  string
+		enableWebsocket    bool
+		websocketSelectors string
+		fakeServiceConfig  *confpb.Service
+		wantListeners      []string
 	}{
 		{
 			desc:              "Success, generate redirect listener when ssl_port is configured",
@@ -1443,11 +4234,217 @@ func TestMakeListeners(t *testing.T) {
 				}`,
 			},
 		},
+		{
+			desc:            "Success, allow WebSocket upgrades on all routes with enable_websocket",
+			enableWebsocket: true,
+			fakeServiceConfig: &confpb.Service{
+				Name: testProjectName,
+				Apis: []*apipb.Api{
+					{
+						Name: "endpoints.examples.bookstore.Bookstore",
+						Methods: []*apipb.Method{
+							{
+								Name: "CreateShelf",
+							},
+						},
+					},
+				},
+			},
+			wantListeners: []string{
+				`{
+					"name": "http_listener",
+					"address":{
+						"socketAddress":{
+							"address":"0.0.0.0",
+							"portValue":8080
+							}
+					},
+					"filterChains":[
+						{
+							"filters":[
+								{
+									"name":"envoy.http_connection_manager",
+									"typedConfig":{
+										"@type":"type.googleapis.com/envoy.config.filter.network.http_connection_manager.v2.HttpConnectionManager",
+									  "httpFilters":[
+											{
+												"name":"envoy.router",
+												"typedConfig":{
+													"@type":"type.googleapis.com/envoy.config.filter.http.router.v2.Router",
+													"startChildSpan":true
+												}
+											}
+										],
+										"routeConfig":{
+											"name":"local_route",
+											"virtualHosts":[
+												{
+													"domains":["*"],
+													"name":"backend",
+													"routes":[
+														{
+															"match":{
+																"prefix":"/"
+															},
+															"route":{
+																"cluster":"bookstore.endpoints.project123.cloud.goog_local",
+																"timeout":"15s"
+															}
+														}
+													]
+												}
+											]
+										},
+										"statPrefix":"ingress_http",
+										"tracing":{},
+										"upgradeConfigs":[
+											{
+												"upgradeType":"websocket",
+												"enabled":true
+											}
+										],
+										"useRemoteAddress":false,
+										"xffNumTrustedHops":2
+									}
+								}
+							]
+						}
+					]
+				}`,
+			},
+		},
+		{
+			desc:               "Success, allow WebSocket upgrades only on the routes of websocket_selectors",
+			websocketSelectors: "endpoints.examples.bookstore.Bookstore.WatchShelves",
+			fakeServiceConfig: &confpb.Service{
+				Name: testProjectName,
+				Apis: []*apipb.Api{
+					{
+						Name: "endpoints.examples.bookstore.Bookstore",
+						Methods: []*apipb.Method{
+							{
+								Name: "WatchShelves",
+							},
+						},
+					},
+				},
+				Http: &annotationspb.Http{
+					Rules: []*annotationspb.HttpRule{
+						{
+							Selector: "endpoints.examples.bookstore.Bookstore.WatchShelves",
+							Pattern: &annotationspb.HttpRule_Get{
+								Get: "/v1/shelves:watch",
+							},
+						},
+					},
+				},
+			},
+			wantListeners: []string{
+				`{
+					"name": "http_listener",
+					"address":{
+						"socketAddress":{
+							"address":"0.0.0.0",
+							"portValue":8080
+							}
+					},
+					"filterChains":[
+						{
+							"filters":[
+								{
+									"name":"envoy.http_connection_manager",
+									"typedConfig":{
+										"@type":"type.googleapis.com/envoy.config.filter.network.http_connection_manager.v2.HttpConnectionManager",
+									  "httpFilters":[
+											{
+												"name":"envoy.filters.http.path_matcher",
+												"typedConfig":{
+													"@type":"type.googleapis.com/google.api.envoy.http.path_matcher.FilterConfig",
+													"rules":[
+														{
+															"operation":"endpoints.examples.bookstore.Bookstore.WatchShelves",
+															"pattern":{
+																"httpMethod":"GET",
+																"uriTemplate":"/v1/shelves:watch"
+															}
+														}
+													]
+												}
+											},
+											{
+												"name":"envoy.router",
+												"typedConfig":{
+													"@type":"type.googleapis.com/envoy.config.filter.http.router.v2.Router",
+													"startChildSpan":true
+												}
+											}
+										],
+										"routeConfig":{
+											"name":"local_route",
+											"virtualHosts":[
+												{
+													"domains":["*"],
+													"name":"backend",
+													"routes":[
+														{
+															"match":{
+																"headers":[
+																	{
+																		"exactMatch":"GET",
+																		"name":":method"
+																	}
+																],
+																"path":"/v1/shelves:watch"
+															},
+															"route":{
+																"cluster":"bookstore.endpoints.project123.cloud.goog_local",
+																"timeout":"0s",
+																"upgradeConfigs":[
+																	{
+																		"upgradeType":"websocket",
+																		"enabled":true
+																	}
+																]
+															}
+														},
+														{
+															"match":{
+																"prefix":"/"
+															},
+															"route":{
+																"cluster":"bookstore.endpoints.project123.cloud.goog_local",
+																"timeout":"15s"
+															}
+														}
+													]
+												}
+											]
+										},
+										"statPrefix":"ingress_http",
+										"tracing":{},
+										"upgradeConfigs":[
+											{
+												"upgradeType":"websocket",
+												"enabled":false
+											}
+										],
+										"useRemoteAddress":false,
+										"xffNumTrustedHops":2
+									}
+								}
+							]
+						}
+					]
+				}`,
+			},
+		},
 	}
 
 	for i, tc := range testdata {
 		opts := options.DefaultConfigGeneratorOptions()
 		opts.SslServerCertPath = tc.sslServerCertPath
+		opts.EnableWebsocket = tc.enableWebsocket
+		opts.WebsocketSelectors = tc.websocketSelectors
 		fakeServiceInfo, err := configinfo.NewServiceInfoFromServiceConfig(tc.fakeServiceConfig, testConfigID, opts)
 		if err != nil {
 			t.Fatal(err)
@@ -1475,3 +4472,150 @@ func TestMakeListeners(t *testing.T) {
 		}
 	}
 }
+
+func TestHttpConnectionManagerAccessLog(t *testing.T) {
+	testdata := []struct {
+		desc                       string
+		accessLogPath              string
+		accessLogFormat            string
+		accessLogJsonFormat        string
+		accessLogServiceUri        string
+		accessLogDisabledSelectors string
+		logRequestId               bool
+		wantAccessLog              string
+	}{
+		{
+			desc:          "No access logs by default",
+			wantAccessLog: `{}`,
+		},
+		{
+			desc:            "Access logs written to stdout with a format string",
+			accessLogPath:   "/dev/stdout",
+			accessLogFormat: "%REQ(:PATH)% %RESPONSE_CODE%\n",
+			wantAccessLog: `
+{
+  "accessLog": [
+    {
+      "name": "envoy.file_access_log",
+      "typedConfig": {
+        "@type": "type.googleapis.com/envoy.config.accesslog.v2.FileAccessLog",
+        "format": "%REQ(:PATH)% %RESPONSE_CODE%\n",
+        "path": "/dev/stdout"
+      }
+    }
+  ]
+}`,
+		},
+		{
+			desc:                "Access logs written to a file as JSON",
+			accessLogPath:       "/var/log/esp-v2/access.log",
+			accessLogJsonFormat: `{"path": "%REQ(:PATH)%", "code": "%RESPONSE_CODE%"}`,
+			wantAccessLog: `
+{
+  "accessLog": [
+    {
+      "name": "envoy.file_access_log",
+      "typedConfig": {
+        "@type": "type.googleapis.com/envoy.config.accesslog.v2.FileAccessLog",
+        "jsonFormat": {
+          "code": "%RESPONSE_CODE%",
+          "path": "%REQ(:PATH)%"
+        },
+        "path": "/var/log/esp-v2/access.log"
+      }
+    }
+  ]
+}`,
+		},
+		{
+			desc:                "Access logs written to a file as JSON with the request ID",
+			accessLogPath:       "/var/log/esp-v2/access.log",
+			accessLogJsonFormat: `{"path": "%REQ(:PATH)%"}`,
+			logRequestId:        true,
+			wantAccessLog: `
+{
+  "accessLog": [
+    {
+      "name": "envoy.file_access_log",
+      "typedConfig": {
+        "@type": "type.googleapis.com/envoy.config.accesslog.v2.FileAccessLog",
+        "jsonFormat": {
+          "path": "%REQ(:PATH)%",
+          "request_id": "%REQ(X-REQUEST-ID)%"
+        },
+        "path": "/var/log/esp-v2/access.log"
+      }
+    }
+  ]
+}`,
+		},
+		{
+			desc:                       "Access logs written to stdout and sent to the access log service, except for disabled operations",
+			accessLogPath:              "/dev/stdout",
+			accessLogServiceUri:        "grpc://als:9001",
+			accessLogDisabledSelectors: "endpoints.examples.bookstore.Bookstore.ListShelves",
+			wantAccessLog: `
+{
+  "accessLog": [
+    {
+      "filter": {
+        "headerFilter": {
+          "header": {
+            "invertMatch": true,
+            "name": "x-esp-v2-access-log-disabled",
+            "presentMatch": true
+          }
+        }
+      },
+      "name": "envoy.file_access_log",
+      "typedConfig": {
+        "@type": "type.googleapis.com/envoy.config.accesslog.v2.FileAccessLog",
+        "path": "/dev/stdout"
+      }
+    },
+    {
+      "filter": {
+        "headerFilter": {
+          "header": {
+            "invertMatch": true,
+            "name": "x-esp-v2-access-log-disabled",
+            "presentMatch": true
+          }
+        }
+      },
+      "name": "envoy.http_grpc_access_log",
+      "typedConfig": {
+        "@type": "type.googleapis.com/envoy.config.accesslog.v2.HttpGrpcAccessLogConfig",
+        "commonConfig": {
+          "grpcService": {
+            "envoyGrpc": {
+              "clusterName": "access-log-service-cluster"
+            }
+          },
+          "logName": "esp-v2"
+        }
+      }
+    }
+  ]
+}`,
+		},
+	}
+
+	for _, tc := range testdata {
+		opts := options.DefaultConfigGeneratorOptions()
+		opts.AccessLogPath = tc.accessLogPath
+		opts.AccessLogFormat = tc.accessLogFormat
+		opts.AccessLogJsonFormat = tc.accessLogJsonFormat
+		opts.AccessLogServiceUri = tc.accessLogServiceUri
+		opts.AccessLogDisabledSelectors = tc.accessLogDisabledSelectors
+		opts.LogRequestId = tc.logRequestId
+		httpConMgr := makeHttpConnectionManager(opts, nil, nil)
+		gotAccessLog, err := util.ProtoToJson(&hcmpb.HttpConnectionManager{AccessLog: httpConMgr.GetAccessLog()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := util.JsonEqual(tc.wantAccessLog, gotAccessLog); err != nil {
+			t.Errorf("Test Desc(%s): %v", tc.desc, err)
+		}
+	}
+}
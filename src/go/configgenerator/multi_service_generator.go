@@ -0,0 +1,293 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package configgenerator
+
+import (
+	"fmt"
+
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/options"
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/util"
+	"github.com/golang/protobuf/ptypes"
+
+	sc "github.com/GoogleCloudPlatform/esp-v2/src/go/configinfo"
+	v2pb "github.com/envoyproxy/go-control-plane/envoy/api/v2"
+	corepb "github.com/envoyproxy/go-control-plane/envoy/api/v2/core"
+	listenerpb "github.com/envoyproxy/go-control-plane/envoy/api/v2/listener"
+	routepb "github.com/envoyproxy/go-control-plane/envoy/api/v2/route"
+	hcmpb "github.com/envoyproxy/go-control-plane/envoy/config/filter/network/http_connection_manager/v2"
+)
+
+const (
+	// Address of the internal listeners, each serving one service or one
+	// config with the HTTP filters made for it.
+	internalListenerAddress = "127.0.0.1"
+
+	multiServiceDefaultVirtualHostName = "multi_service"
+)
+
+// MultiServiceConfig is a service served by one proxy with other services.
+type MultiServiceConfig struct {
+	ServiceInfo *sc.ServiceInfo
+	// Requests with this path prefix are routed to the service. When empty,
+	// requests are routed to the service by host.
+	PathPrefix string
+}
+
+// multiServiceClusterName returns the name of the cluster forwarding requests
+// to the internal listener of a service.
+func multiServiceClusterName(config MultiServiceConfig) string {
+	return fmt.Sprintf("multi_service_%s", config.ServiceInfo.Name)
+}
+
+// MakeClustersForServices provides dynamic cluster settings for Envoy when
+// multiple services are served by one proxy. Clusters shared by the services,
+// like the Service Control cluster, are only added once.
+func MakeClustersForServices(serviceInfos []*sc.ServiceInfo) ([]*v2pb.Cluster, error) {
+	var clusters []*v2pb.Cluster
+	clusterNames := make(map[string]bool)
+	for _, serviceInfo := range serviceInfos {
+		serviceClusters, err := MakeClusters(serviceInfo)
+		if err != nil {
+			return nil, fmt.Errorf("fail to make clusters for service %v: %v", serviceInfo.Name, err)
+		}
+		for _, cluster := range serviceClusters {
+			if clusterNames[cluster.Name] {
+				continue
+			}
+			clusterNames[cluster.Name] = true
+			clusters = append(clusters, cluster)
+		}
+	}
+	return clusters, nil
+}
+
+// MakeClustersForMultiService provides dynamic cluster settings for Envoy when
+// multiple services are served by one proxy. Besides the clusters of all
+// services, there is one cluster for the internal listener of each service,
+// see MakeListenersForMultiService.
+func MakeClustersForMultiService(configs []MultiServiceConfig, basePort int) ([]*v2pb.Cluster, error) {
+	var serviceInfos []*sc.ServiceInfo
+	for _, config := range configs {
+		serviceInfos = append(serviceInfos, config.ServiceInfo)
+	}
+	clusters, err := MakeClustersForServices(serviceInfos)
+	if err != nil {
+		return nil, err
+	}
+	if len(configs) == 1 {
+		return clusters, nil
+	}
+
+	for i, config := range configs {
+		clusters = append(clusters, makeInternalListenerCluster(multiServiceClusterName(config), basePort+i, config.ServiceInfo.Options))
+	}
+	return clusters, nil
+}
+
+// MakeListenersForMultiService provides dynamic listeners for Envoy when
+// multiple services are served by one proxy.
+//
+// As the HTTP filters are set per listener, each service is served by its own
+// internal listener on 127.0.0.1, on basePort plus the index of the service,
+// so a request is only matched against the operations of the service it is
+// routed to. The listener on --listener_port only routes each request to one
+// of the internal listeners: first by host to the services without a path
+// prefix, then by path prefix to the other services. Other requests are routed
+// to the first service.
+//
+// Listener level settings, like the listener port, are taken from the first service.
+func MakeListenersForMultiService(configs []MultiServiceConfig, basePort int) ([]*v2pb.Listener, error) {
+	if len(configs) == 0 {
+		return nil, fmt.Errorf("no service to make listeners for")
+	}
+	if len(configs) == 1 {
+		return MakeListeners(configs[0].ServiceInfo)
+	}
+
+	defaultHost := &routepb.VirtualHost{
+		Name:    multiServiceDefaultVirtualHostName,
+		Domains: []string{"*"},
+	}
+	route := &v2pb.RouteConfiguration{
+		Name: routeName,
+	}
+	var listeners []*v2pb.Listener
+	for i, config := range configs {
+		listener, err := makeInternalListener(config.ServiceInfo, basePort+i, config.ServiceInfo.Name)
+		if err != nil {
+			return nil, fmt.Errorf("fail to make listener for service %v: %v", config.ServiceInfo.Name, err)
+		}
+		listeners = append(listeners, listener)
+
+		clusterName := multiServiceClusterName(config)
+		if config.PathPrefix != "" {
+			defaultHost.Routes = append(defaultHost.Routes, makeInternalListenerRoute(config.PathPrefix, clusterName))
+		} else if i > 0 {
+			route.VirtualHosts = append(route.VirtualHosts, &routepb.VirtualHost{
+				Name:    fmt.Sprintf("%s_%s", virtualHostName, config.ServiceInfo.Name),
+				Domains: serviceDomains(config.ServiceInfo),
+				Routes:  []*routepb.Route{makeInternalListenerRoute("/", clusterName)},
+			})
+		}
+	}
+	defaultHost.Routes = append(defaultHost.Routes, makeInternalListenerRoute("/", multiServiceClusterName(configs[0])))
+	route.VirtualHosts = append(route.VirtualHosts, defaultHost)
+
+	// Spans are reported by the internal listeners.
+	opts := configs[0].ServiceInfo.Options
+	opts.DisableTracing = true
+	httpFilters := []*hcmpb.HttpFilter{makeRouterFilter(opts)}
+	frontListeners, err := makeFrontListeners(opts, httpFilters, route)
+	if err != nil {
+		return nil, err
+	}
+	if err := routeSniFilterChains(frontListeners[0], configs, opts, httpFilters); err != nil {
+		return nil, err
+	}
+	return append(frontListeners, listeners...), nil
+}
+
+// routeSniFilterChains routes all the requests on the filter chain of each
+// certificate in --ssl_server_sni_config with a service to the internal
+// listener of that service, instead of by host or path prefix.
+func routeSniFilterChains(listener *v2pb.Listener, configs []MultiServiceConfig, opts options.ConfigGeneratorOptions, httpFilters []*hcmpb.HttpFilter) error {
+	clusterNames := make(map[string]string)
+	for _, config := range configs {
+		clusterNames[config.ServiceInfo.Name] = multiServiceClusterName(config)
+	}
+
+	// The filter chains of the SNI certificates follow the default one, in
+	// the same order.
+	for i, sni := range opts.SslServerSniCertificates {
+		if sni.Service == "" || i+1 >= len(listener.FilterChains) {
+			continue
+		}
+		clusterName, ok := clusterNames[sni.Service]
+		if !ok {
+			return fmt.Errorf("service %s of server names %v is not in --service", sni.Service, sni.ServerNames)
+		}
+		route := &v2pb.RouteConfiguration{
+			Name: routeName,
+			VirtualHosts: []*routepb.VirtualHost{
+				{
+					Name:    fmt.Sprintf("%s_%s", virtualHostName, sni.Service),
+					Domains: []string{"*"},
+					Routes:  []*routepb.Route{makeInternalListenerRoute("/", clusterName)},
+				},
+			},
+		}
+		httpFilterConfig, err := ptypes.MarshalAny(makeHttpConnectionManager(opts, httpFilters, route))
+		if err != nil {
+			return err
+		}
+
+		// The network filters are shared with the other filter chains, and
+		// the HTTP connection manager is the last one.
+		filterChain := listener.FilterChains[i+1]
+		filters := append([]*listenerpb.Filter{}, filterChain.Filters...)
+		filters[len(filters)-1] = &listenerpb.Filter{
+			Name:       util.HTTPConnectionManager,
+			ConfigType: &listenerpb.Filter_TypedConfig{TypedConfig: httpFilterConfig},
+		}
+		filterChain.Filters = filters
+	}
+	return nil
+}
+
+// serviceDomains returns the hosts routed to a service, with and without port.
+func serviceDomains(serviceInfo *sc.ServiceInfo) []string {
+	hosts := []string{serviceInfo.Name}
+	for _, endpoint := range serviceInfo.ServiceConfig().GetEndpoints() {
+		if endpoint.GetName() != "" && endpoint.GetName() != serviceInfo.Name {
+			hosts = append(hosts, endpoint.GetName())
+		}
+		hosts = append(hosts, endpoint.GetAliases()...)
+	}
+
+	var domains []string
+	for _, host := range hosts {
+		domains = append(domains, host, host+":*")
+	}
+	return domains
+}
+
+// makeInternalListenerCluster makes the cluster forwarding requests to the
+// internal listener on port. HTTP/2 is used so that gRPC requests are kept as is.
+func makeInternalListenerCluster(name string, port int, opts options.ConfigGeneratorOptions) *v2pb.Cluster {
+	return &v2pb.Cluster{
+		Name:                 name,
+		LbPolicy:             v2pb.Cluster_ROUND_ROBIN,
+		ConnectTimeout:       ptypes.DurationProto(opts.ClusterConnectTimeout),
+		ClusterDiscoveryType: &v2pb.Cluster_Type{Type: v2pb.Cluster_STATIC},
+		LoadAssignment:       util.CreateLoadAssignment(internalListenerAddress, uint32(port)),
+		Http2ProtocolOptions: &corepb.Http2ProtocolOptions{},
+	}
+}
+
+// makeInternalListenerRoute routes requests with the path prefix to the
+// internal listener behind clusterName.
+func makeInternalListenerRoute(prefix, clusterName string) *routepb.Route {
+	return &routepb.Route{
+		Match: &routepb.RouteMatch{
+			PathSpecifier: &routepb.RouteMatch_Prefix{
+				Prefix: prefix,
+			},
+		},
+		Action: &routepb.Route_Route{
+			Route: &routepb.RouteAction{
+				ClusterSpecifier: &routepb.RouteAction_Cluster{
+					Cluster: clusterName,
+				},
+				// Timeouts are enforced by the internal listeners.
+				Timeout: ptypes.DurationProto(0),
+			},
+		},
+	}
+}
+
+// makeInternalListener makes the listener on 127.0.0.1 serving requests
+// forwarded by the listener on --listener_port with the HTTP filters and
+// routes of serviceInfo.
+func makeInternalListener(serviceInfo *sc.ServiceInfo, port int, nameSuffix string) (*v2pb.Listener, error) {
+	httpFilters, err := makeHttpFilters(serviceInfo)
+	if err != nil {
+		return nil, err
+	}
+	route, err := MakeRouteConfig(serviceInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	// The front listener terminates TLS and the PROXY protocol and, with
+	// --envoy_use_remote_address or --trusted_proxy_cidrs, appends the client
+	// address to x-forwarded-for, which is then trusted here.
+	opts := serviceInfo.Options
+	opts.ListenerAddress = internalListenerAddress
+	opts.ListenerPort = port
+	opts.SslServerCertPath = ""
+	opts.Http3Port = 0
+	opts.EnableProxyProtocol = false
+	if opts.EnvoyUseRemoteAddress || opts.TrustedProxyCidrs != "" {
+		opts.EnvoyUseRemoteAddress = false
+		opts.EnvoyXffNumTrustedHops = 0
+		opts.TrustedProxyCidrs = ""
+	}
+	listener, err := makeListenerWithFilters(opts, httpFilters, route)
+	if err != nil {
+		return nil, err
+	}
+	listener.Name = fmt.Sprintf("%s_%s", listener.Name, nameSuffix)
+	return listener, nil
+}
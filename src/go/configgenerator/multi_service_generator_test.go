@@ -0,0 +1,343 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package configgenerator
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/configinfo"
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/options"
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/util"
+	"github.com/golang/protobuf/ptypes"
+
+	pmpb "github.com/GoogleCloudPlatform/esp-v2/src/go/proto/api/envoy/http/path_matcher"
+	hcmpb "github.com/envoyproxy/go-control-plane/envoy/config/filter/network/http_connection_manager/v2"
+	annotationspb "google.golang.org/genproto/googleapis/api/annotations"
+	confpb "google.golang.org/genproto/googleapis/api/serviceconfig"
+	apipb "google.golang.org/genproto/protobuf/api"
+)
+
+func fakeServiceConfigWithPath(serviceName, apiName, path string) *confpb.Service {
+	return &confpb.Service{
+		Name: serviceName,
+		Apis: []*apipb.Api{
+			{
+				Name: apiName,
+				Methods: []*apipb.Method{
+					{
+						Name: "Get",
+					},
+				},
+			},
+		},
+		Endpoints: []*confpb.Endpoint{
+			{
+				Name:    serviceName,
+				Aliases: []string{"alias." + serviceName},
+			},
+		},
+		Http: &annotationspb.Http{
+			Rules: []*annotationspb.HttpRule{
+				{
+					Selector: apiName + ".Get",
+					Pattern: &annotationspb.HttpRule_Get{
+						Get: path,
+					},
+				},
+			},
+		},
+	}
+}
+
+func TestMakeListenersForMultiService(t *testing.T) {
+	type fakeService struct {
+		serviceName, apiName, path, pathPrefix string
+	}
+	testData := []struct {
+		desc           string
+		fakeServices   []fakeService
+		wantListeners  []string
+		wantPorts      []uint32
+		wantOperations [][]string
+		wantDomains    [][]string
+		wantRoutes     [][]string
+	}{
+		{
+			desc: "Success, route by host, the same path is served by each service",
+			fakeServices: []fakeService{
+				{serviceName: "foo.endpoints.project123.cloud.goog", apiName: "foo.Foo", path: "/foo"},
+				{serviceName: "bar.endpoints.project123.cloud.goog", apiName: "bar.Bar", path: "/foo"},
+			},
+			wantListeners: []string{
+				"http_listener",
+				"http_listener_foo.endpoints.project123.cloud.goog",
+				"http_listener_bar.endpoints.project123.cloud.goog",
+			},
+			wantPorts:      []uint32{8080, 8090, 8091},
+			wantOperations: [][]string{nil, {"foo.Foo.Get"}, {"bar.Bar.Get"}},
+			wantDomains: [][]string{
+				{
+					"bar.endpoints.project123.cloud.goog",
+					"bar.endpoints.project123.cloud.goog:*",
+					"alias.bar.endpoints.project123.cloud.goog",
+					"alias.bar.endpoints.project123.cloud.goog:*",
+				},
+				{"*"},
+			},
+			wantRoutes: [][]string{
+				{"/ multi_service_bar.endpoints.project123.cloud.goog"},
+				{"/ multi_service_foo.endpoints.project123.cloud.goog"},
+			},
+		},
+		{
+			desc: "Success, route by path prefix",
+			fakeServices: []fakeService{
+				{serviceName: "foo.endpoints.project123.cloud.goog", apiName: "foo.Foo", path: "/foo/get"},
+				{serviceName: "bar.endpoints.project123.cloud.goog", apiName: "bar.Bar", path: "/bar/get", pathPrefix: "/bar/"},
+			},
+			wantListeners: []string{
+				"http_listener",
+				"http_listener_foo.endpoints.project123.cloud.goog",
+				"http_listener_bar.endpoints.project123.cloud.goog",
+			},
+			wantPorts:      []uint32{8080, 8090, 8091},
+			wantOperations: [][]string{nil, {"foo.Foo.Get"}, {"bar.Bar.Get"}},
+			wantDomains:    [][]string{{"*"}},
+			wantRoutes: [][]string{
+				{
+					"/bar/ multi_service_bar.endpoints.project123.cloud.goog",
+					"/ multi_service_foo.endpoints.project123.cloud.goog",
+				},
+			},
+		},
+	}
+
+	for _, tc := range testData {
+		var configs []MultiServiceConfig
+		for _, s := range tc.fakeServices {
+			serviceConfig := fakeServiceConfigWithPath(s.serviceName, s.apiName, s.path)
+			serviceInfo, err := configinfo.NewServiceInfoFromServiceConfig(serviceConfig, testConfigID, options.DefaultConfigGeneratorOptions())
+			if err != nil {
+				t.Fatal(err)
+			}
+			configs = append(configs, MultiServiceConfig{
+				ServiceInfo: serviceInfo,
+				PathPrefix:  s.pathPrefix,
+			})
+		}
+
+		listeners, err := MakeListenersForMultiService(configs, 8090)
+		if err != nil {
+			t.Fatalf("Test Desc(%s): MakeListenersForMultiService got error: %v", tc.desc, err)
+		}
+
+		var gotListeners []string
+		var gotPorts []uint32
+		var gotOperations [][]string
+		var httpConMgrs []*hcmpb.HttpConnectionManager
+		for _, listener := range listeners {
+			gotListeners = append(gotListeners, listener.GetName())
+			gotPorts = append(gotPorts, listener.GetAddress().GetSocketAddress().GetPortValue())
+
+			httpConMgr := &hcmpb.HttpConnectionManager{}
+			if err := ptypes.UnmarshalAny(listener.GetFilterChains()[0].GetFilters()[0].GetTypedConfig(), httpConMgr); err != nil {
+				t.Fatal(err)
+			}
+			httpConMgrs = append(httpConMgrs, httpConMgr)
+
+			var operations []string
+			for _, filter := range httpConMgr.GetHttpFilters() {
+				if filter.GetName() != util.PathMatcher {
+					continue
+				}
+				pathMatcherConfig := &pmpb.FilterConfig{}
+				if err := ptypes.UnmarshalAny(filter.GetTypedConfig(), pathMatcherConfig); err != nil {
+					t.Fatal(err)
+				}
+				for _, rule := range pathMatcherConfig.GetRules() {
+					operations = append(operations, rule.GetOperation())
+				}
+			}
+			gotOperations = append(gotOperations, operations)
+		}
+		if !reflect.DeepEqual(gotListeners, tc.wantListeners) {
+			t.Errorf("Test Desc(%s): got listeners %v, want %v", tc.desc, gotListeners, tc.wantListeners)
+		}
+		if !reflect.DeepEqual(gotPorts, tc.wantPorts) {
+			t.Errorf("Test Desc(%s): got listener ports %v, want %v", tc.desc, gotPorts, tc.wantPorts)
+		}
+		if !reflect.DeepEqual(gotOperations, tc.wantOperations) {
+			t.Errorf("Test Desc(%s): got path matcher operations %v, want %v", tc.desc, gotOperations, tc.wantOperations)
+		}
+
+		var gotDomains, gotRoutes [][]string
+		for _, host := range httpConMgrs[0].GetRouteConfig().GetVirtualHosts() {
+			gotDomains = append(gotDomains, host.GetDomains())
+			var routes []string
+			for _, route := range host.GetRoutes() {
+				routes = append(routes, route.GetMatch().GetPrefix()+" "+route.GetRoute().GetCluster())
+			}
+			gotRoutes = append(gotRoutes, routes)
+		}
+		if !reflect.DeepEqual(gotDomains, tc.wantDomains) {
+			t.Errorf("Test Desc(%s): got virtual host domains %v, want %v", tc.desc, gotDomains, tc.wantDomains)
+		}
+		if !reflect.DeepEqual(gotRoutes, tc.wantRoutes) {
+			t.Errorf("Test Desc(%s): got routes %v, want %v", tc.desc, gotRoutes, tc.wantRoutes)
+		}
+
+		clusters, err := MakeClustersForMultiService(configs, 8090)
+		if err != nil {
+			t.Fatalf("Test Desc(%s): MakeClustersForMultiService got error: %v", tc.desc, err)
+		}
+		clusterNames := make(map[string]bool)
+		for _, cluster := range clusters {
+			clusterNames[cluster.GetName()] = true
+		}
+		for _, config := range configs {
+			if want := multiServiceClusterName(config); !clusterNames[want] {
+				t.Errorf("Test Desc(%s): got clusters %v, want cluster %v", tc.desc, clusterNames, want)
+			}
+		}
+	}
+}
+
+func TestMakeListenersForMultiServiceWithTrustedProxies(t *testing.T) {
+	opts := options.DefaultConfigGeneratorOptions()
+	opts.TrustedProxyCidrs = "35.191.0.0/16"
+	opts.EnableProxyProtocol = true
+	var configs []MultiServiceConfig
+	for _, name := range []string{"foo", "bar"} {
+		serviceName := name + ".endpoints.project123.cloud.goog"
+		serviceInfo, err := configinfo.NewServiceInfoFromServiceConfig(fakeServiceConfigWithPath(serviceName, "api."+name, "/"+name), testConfigID, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		configs = append(configs, MultiServiceConfig{
+			ServiceInfo: serviceInfo,
+			PathPrefix:  "/" + name,
+		})
+	}
+
+	listeners, err := MakeListenersForMultiService(configs, 8090)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The front listener reads the PROXY protocol and appends the peer
+	// address to x-forwarded-for, the internal listeners trust it.
+	wantProxyProtocol := []bool{true, false, false}
+	wantUseRemoteAddress := []bool{true, false, false}
+	if len(listeners) != len(wantProxyProtocol) {
+		t.Fatalf("got %d listeners, want %d", len(listeners), len(wantProxyProtocol))
+	}
+	for i, listener := range listeners {
+		if got := len(listener.GetListenerFilters()) != 0; got != wantProxyProtocol[i] {
+			t.Errorf("Test Desc(%s): got PROXY protocol %v, want %v", listener.GetName(), got, wantProxyProtocol[i])
+		}
+		httpConMgr := &hcmpb.HttpConnectionManager{}
+		if err := ptypes.UnmarshalAny(listener.GetFilterChains()[0].GetFilters()[0].GetTypedConfig(), httpConMgr); err != nil {
+			t.Fatal(err)
+		}
+		if got := httpConMgr.GetUseRemoteAddress().GetValue(); got != wantUseRemoteAddress[i] {
+			t.Errorf("Test Desc(%s): got use_remote_address %v, want %v", listener.GetName(), got, wantUseRemoteAddress[i])
+		}
+		if got := httpConMgr.GetXffNumTrustedHops(); got != 0 {
+			t.Errorf("Test Desc(%s): got xff_num_trusted_hops %v, want 0", listener.GetName(), got)
+		}
+	}
+}
+
+func TestMakeListenersForMultiServiceWithSni(t *testing.T) {
+	testData := []struct {
+		desc       string
+		service    string
+		wantRoutes [][]string
+		wantError  string
+	}{
+		{
+			desc:    "Success, route all the requests with the server names to the service",
+			service: "bar.endpoints.project123.cloud.goog",
+			wantRoutes: [][]string{
+				{"/ multi_service_bar.endpoints.project123.cloud.goog"},
+			},
+		},
+		{
+			desc: "Success, route the requests with the server names by host without service",
+			wantRoutes: [][]string{
+				{"/ multi_service_bar.endpoints.project123.cloud.goog"},
+				{"/ multi_service_foo.endpoints.project123.cloud.goog"},
+			},
+		},
+		{
+			desc:      "Failure, unknown service",
+			service:   "baz.endpoints.project123.cloud.goog",
+			wantError: "service baz.endpoints.project123.cloud.goog of server names [api.example.com] is not in --service",
+		},
+	}
+
+	for _, tc := range testData {
+		opts := options.DefaultConfigGeneratorOptions()
+		opts.SslServerCertPath = "/etc/ssl/endpoints/"
+		opts.SslServerSniCertificates = []*options.SniCertificateOptions{
+			{
+				ServerNames: []string{"api.example.com"},
+				CertPath:    "/etc/ssl/example/api.crt",
+				KeyPath:     "/etc/ssl/example/api.key",
+				Service:     tc.service,
+			},
+		}
+		var configs []MultiServiceConfig
+		for _, name := range []string{"foo", "bar"} {
+			serviceConfig := fakeServiceConfigWithPath(name+".endpoints.project123.cloud.goog", name+"."+name, "/"+name)
+			serviceInfo, err := configinfo.NewServiceInfoFromServiceConfig(serviceConfig, testConfigID, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			configs = append(configs, MultiServiceConfig{ServiceInfo: serviceInfo})
+		}
+
+		listeners, err := MakeListenersForMultiService(configs, 8090)
+		if tc.wantError != "" {
+			if err == nil || err.Error() != tc.wantError {
+				t.Errorf("Test Desc(%s): got error %v, want %v", tc.desc, err, tc.wantError)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Test Desc(%s): MakeListenersForMultiService got error: %v", tc.desc, err)
+		}
+
+		filterChains := listeners[0].GetFilterChains()
+		if len(filterChains) != 2 {
+			t.Fatalf("Test Desc(%s): got %d filter chains, want 2", tc.desc, len(filterChains))
+		}
+		httpConMgr := &hcmpb.HttpConnectionManager{}
+		if err := ptypes.UnmarshalAny(filterChains[1].GetFilters()[0].GetTypedConfig(), httpConMgr); err != nil {
+			t.Fatal(err)
+		}
+		var gotRoutes [][]string
+		for _, host := range httpConMgr.GetRouteConfig().GetVirtualHosts() {
+			var routes []string
+			for _, route := range host.GetRoutes() {
+				routes = append(routes, route.GetMatch().GetPrefix()+" "+route.GetRoute().GetCluster())
+			}
+			gotRoutes = append(gotRoutes, routes)
+		}
+		if !reflect.DeepEqual(gotRoutes, tc.wantRoutes) {
+			t.Errorf("Test Desc(%s): got routes %v, want %v", tc.desc, gotRoutes, tc.wantRoutes)
+		}
+	}
+}
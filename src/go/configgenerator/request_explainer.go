@@ -0,0 +1,218 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package configgenerator
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/configinfo"
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/util"
+
+	scpb "github.com/GoogleCloudPlatform/esp-v2/src/go/proto/api/envoy/http/service_control"
+	routepb "github.com/envoyproxy/go-control-plane/envoy/api/v2/route"
+)
+
+// RequestExplanation explains how a request would be handled by the
+// generated config, without sending it.
+type RequestExplanation struct {
+	// Operation matched by the Path Matcher filter, empty if the request is
+	// rejected as an unknown operation.
+	Operation string `json:"operation,omitempty"`
+	HttpRule  string `json:"httpRule,omitempty"`
+	// First route matching the request, nil if none.
+	Route *RouteEntry `json:"route,omitempty"`
+	// JWT requirements of the operation, any of which is accepted.
+	JwtRequirements        []*JwtRequirement `json:"jwtRequirements,omitempty"`
+	AllowWithoutCredential bool              `json:"allowWithoutCredential,omitempty"`
+	// API key of the operation, and its locations tried in order.
+	ApiKeyRequired     bool     `json:"apiKeyRequired"`
+	ApiKeyLocations    []string `json:"apiKeyLocations,omitempty"`
+	SkipServiceControl bool     `json:"skipServiceControl,omitempty"`
+}
+
+// JwtRequirement is a JWT provider accepted by an operation, with the
+// audiences of the JWT, the ones of the provider if empty.
+type JwtRequirement struct {
+	ProviderId string `json:"providerId"`
+	Audiences  string `json:"audiences,omitempty"`
+}
+
+// ExplainRequest explains how a request with the HTTP method, path and headers
+// would be handled by the generated config of serviceInfo: its operation, the
+// route and clusters it is sent to, and the JWT and API key it requires. The
+// operation is the one of the HTTP rule matching the path literally, else of
+// the first HTTP rule matching it, which is an approximation of the templates
+// matching of the Path Matcher filter. The header names must be lower case.
+func ExplainRequest(serviceInfo *configinfo.ServiceInfo, httpMethod, path string, headers map[string]string) (*RequestExplanation, error) {
+	routes, table, err := makeRouteTable(serviceInfo)
+	if err != nil {
+		return nil, err
+	}
+	if i := strings.IndexByte(path, '?'); i >= 0 {
+		path = path[:i]
+	}
+	requestHeaders := map[string]string{":method": httpMethod, ":path": path}
+	for name, value := range headers {
+		requestHeaders[name] = value
+	}
+
+	explanation := &RequestExplanation{}
+	for i, r := range routes {
+		if routeMatches(r.GetMatch(), path, requestHeaders) {
+			explanation.Route = table[i]
+			break
+		}
+	}
+
+	operation, httpRule := findOperation(serviceInfo, httpMethod, path)
+	if operation == "" {
+		return explanation, nil
+	}
+	method := serviceInfo.Methods[operation]
+	explanation.Operation = operation
+	explanation.HttpRule = httpRule
+	explanation.SkipServiceControl = method.SkipServiceControl
+
+	// The generated operations are exempt from authentication, except the
+	// OPTIONS operations requiring the authentication of their operation.
+	exempt := method.IsGenerated && method.PreflightOperation == ""
+	authOperation := operation
+	if method.PreflightOperation != "" {
+		authOperation = method.PreflightOperation
+	}
+	for _, rule := range serviceInfo.ServiceConfig().GetAuthentication().GetRules() {
+		if exempt || rule.GetSelector() != authOperation {
+			continue
+		}
+		for _, requirement := range rule.GetRequirements() {
+			explanation.JwtRequirements = append(explanation.JwtRequirements, &JwtRequirement{
+				ProviderId: requirement.GetProviderId(),
+				Audiences:  requirement.GetAudiences(),
+			})
+		}
+		explanation.AllowWithoutCredential = rule.GetAllowWithoutCredential()
+	}
+
+	explanation.ApiKeyRequired = !method.AllowUnregisteredCalls && !exempt
+	for _, location := range method.ApiKeyLocations {
+		explanation.ApiKeyLocations = append(explanation.ApiKeyLocations, apiKeyLocationString(location))
+	}
+	if explanation.ApiKeyRequired && len(explanation.ApiKeyLocations) == 0 {
+		explanation.ApiKeyLocations = []string{
+			"query:" + util.DefaultApiKeyQueryParamKey,
+			"query:" + util.DefaultApiKeyQueryParamApiKey,
+			"header:x-api-key",
+		}
+	}
+	return explanation, nil
+}
+
+// findOperation returns the operation and the HTTP rule matching the HTTP
+// method and path of a request, preferring the HTTP rules matching the path
+// literally.
+func findOperation(serviceInfo *configinfo.ServiceInfo, httpMethod, path string) (string, string) {
+	var operation, httpRule string
+	for _, op := range serviceInfo.Operations {
+		for _, rule := range serviceInfo.Methods[op].HttpRule {
+			if rule.HttpMethod != httpMethod && rule.HttpMethod != "*" {
+				continue
+			}
+			routeMatcher := makeHttpRouteMatcher(rule)
+			if routeMatcher == nil || !pathMatches(routeMatcher, path) {
+				continue
+			}
+			if routeMatcher.GetPath() != "" {
+				return op, fmt.Sprintf("%s %s", rule.HttpMethod, rule.UriTemplate)
+			}
+			if operation == "" {
+				operation, httpRule = op, fmt.Sprintf("%s %s", rule.HttpMethod, rule.UriTemplate)
+			}
+		}
+	}
+	return operation, httpRule
+}
+
+// routeMatches returns whether a route matches the path and the headers of a
+// request.
+func routeMatches(routeMatcher *routepb.RouteMatch, path string, headers map[string]string) bool {
+	if !pathMatches(routeMatcher, path) {
+		return false
+	}
+	for _, h := range routeMatcher.GetHeaders() {
+		if headerMatches(h, headers) == h.GetInvertMatch() {
+			return false
+		}
+	}
+	return true
+}
+
+// pathMatches returns whether the path specifier of a route matches a path.
+func pathMatches(routeMatcher *routepb.RouteMatch, path string) bool {
+	switch {
+	case routeMatcher.GetPath() != "":
+		return routeMatcher.GetPath() == path
+	case routeMatcher.GetSafeRegex() != nil:
+		return fullMatch(routeMatcher.GetSafeRegex().GetRegex(), path)
+	case routeMatcher.GetRegex() != "":
+		return fullMatch(routeMatcher.GetRegex(), path)
+	default:
+		return strings.HasPrefix(path, routeMatcher.GetPrefix())
+	}
+}
+
+// headerMatches returns whether a header matcher matches the headers of a
+// request, ignoring its invert_match.
+func headerMatches(h *routepb.HeaderMatcher, headers map[string]string) bool {
+	value, ok := headers[strings.ToLower(h.GetName())]
+	if !ok {
+		return false
+	}
+	switch spec := h.GetHeaderMatchSpecifier().(type) {
+	case *routepb.HeaderMatcher_ExactMatch:
+		return value == spec.ExactMatch
+	case *routepb.HeaderMatcher_PresentMatch:
+		return spec.PresentMatch
+	case *routepb.HeaderMatcher_PrefixMatch:
+		return strings.HasPrefix(value, spec.PrefixMatch)
+	case *routepb.HeaderMatcher_SuffixMatch:
+		return strings.HasSuffix(value, spec.SuffixMatch)
+	case *routepb.HeaderMatcher_SafeRegexMatch:
+		return fullMatch(spec.SafeRegexMatch.GetRegex(), value)
+	default:
+		return false
+	}
+}
+
+// fullMatch returns whether a regex in RE2 syntax matches the whole value,
+// like in Envoy.
+func fullMatch(regex, value string) bool {
+	re, err := regexp.Compile(fmt.Sprintf("^(?:%s)$", regex))
+	return err == nil && re.MatchString(value)
+}
+
+func apiKeyLocationString(location *scpb.ApiKeyLocation) string {
+	switch key := location.GetKey().(type) {
+	case *scpb.ApiKeyLocation_Query:
+		return "query:" + key.Query
+	case *scpb.ApiKeyLocation_Header:
+		return "header:" + key.Header
+	case *scpb.ApiKeyLocation_Cookie:
+		return "cookie:" + key.Cookie
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,180 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package configgenerator
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/configinfo"
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/options"
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/util"
+
+	annotationspb "google.golang.org/genproto/googleapis/api/annotations"
+	confpb "google.golang.org/genproto/googleapis/api/serviceconfig"
+	apipb "google.golang.org/genproto/protobuf/api"
+)
+
+func TestExplainRequest(t *testing.T) {
+	testData := []struct {
+		desc            string
+		httpMethod      string
+		path            string
+		headers         map[string]string
+		wantExplanation string
+	}{
+		{
+			desc:       "Operation with JWT routed to its backend",
+			httpMethod: "GET",
+			path:       "/v1/shelves/1?view=full",
+			wantExplanation: `
+{
+  "operation": "endpoints.examples.bookstore.Bookstore.GetShelf",
+  "httpRule": "GET /v1/shelves/{shelf}",
+  "route": {
+    "match": {
+      "headers": [{"exactMatch": "GET", "name": ":method"}],
+      "safeRegex": {"googleRe2": {"maxProgramSize": 1000}, "regex": "/v1/shelves/[^\\/]+$"}
+    },
+    "origin": "backend_rule",
+    "operations": ["endpoints.examples.bookstore.Bookstore.GetShelf"],
+    "httpRule": "GET /v1/shelves/{shelf}",
+    "clusters": ["shelves.example.com:443"]
+  },
+  "jwtRequirements": [{"providerId": "auth_provider", "audiences": "shelves"}],
+  "apiKeyRequired": true,
+  "apiKeyLocations": ["query:key", "query:api_key", "header:x-api-key"]
+}`,
+		},
+		{
+			desc:       "Operation without API key matched literally, but routed by the template of another one",
+			httpMethod: "GET",
+			path:       "/v1/shelves/featured",
+			wantExplanation: `
+{
+  "operation": "endpoints.examples.bookstore.Bookstore.GetFeaturedShelf",
+  "httpRule": "GET /v1/shelves/featured",
+  "route": {
+    "match": {
+      "headers": [{"exactMatch": "GET", "name": ":method"}],
+      "safeRegex": {"googleRe2": {"maxProgramSize": 1000}, "regex": "/v1/shelves/[^\\/]+$"}
+    },
+    "origin": "backend_rule",
+    "operations": ["endpoints.examples.bookstore.Bookstore.GetShelf"],
+    "httpRule": "GET /v1/shelves/{shelf}",
+    "clusters": ["shelves.example.com:443"]
+  },
+  "apiKeyRequired": false
+}`,
+		},
+		{
+			desc:       "Unknown operation without route, as there is no catch-all route with dynamic routing",
+			httpMethod: "DELETE",
+			path:       "/v1/shelves/1",
+			wantExplanation: `
+{
+  "apiKeyRequired": false
+}`,
+		},
+	}
+
+	opts := options.DefaultConfigGeneratorOptions()
+	opts.BackendAddress = "http://127.0.0.1:80"
+	fakeServiceInfo, err := configinfo.NewServiceInfoFromServiceConfig(&confpb.Service{
+		Name: testProjectName,
+		Apis: []*apipb.Api{
+			{
+				Name: testApiName,
+				Methods: []*apipb.Method{
+					{
+						Name: "GetShelf",
+					},
+					{
+						Name: "GetFeaturedShelf",
+					},
+				},
+			},
+		},
+		Http: &annotationspb.Http{
+			Rules: []*annotationspb.HttpRule{
+				{
+					Selector: testApiName + ".GetShelf",
+					Pattern: &annotationspb.HttpRule_Get{
+						Get: "/v1/shelves/{shelf}",
+					},
+				},
+				{
+					Selector: testApiName + ".GetFeaturedShelf",
+					Pattern: &annotationspb.HttpRule_Get{
+						Get: "/v1/shelves/featured",
+					},
+				},
+			},
+		},
+		Backend: &confpb.Backend{
+			Rules: []*confpb.BackendRule{
+				{
+					Selector: testApiName + ".GetShelf",
+					Address:  "https://shelves.example.com",
+				},
+			},
+		},
+		Authentication: &confpb.Authentication{
+			Providers: []*confpb.AuthProvider{
+				{
+					Id:      "auth_provider",
+					Issuer:  "issuer-0",
+					JwksUri: "https://fake-jwks.com",
+				},
+			},
+			Rules: []*confpb.AuthenticationRule{
+				{
+					Selector: testApiName + ".GetShelf",
+					Requirements: []*confpb.AuthRequirement{
+						{
+							ProviderId: "auth_provider",
+							Audiences:  "shelves",
+						},
+					},
+				},
+			},
+		},
+		Usage: &confpb.Usage{
+			Rules: []*confpb.UsageRule{
+				{
+					Selector:               testApiName + ".GetFeaturedShelf",
+					AllowUnregisteredCalls: true,
+				},
+			},
+		},
+	}, testConfigID, opts)
+	if err != nil {
+		t.Fatalf("fail to create ServiceInfo: %v", err)
+	}
+
+	for _, tc := range testData {
+		explanation, err := ExplainRequest(fakeServiceInfo, tc.httpMethod, tc.path, tc.headers)
+		if err != nil {
+			t.Fatalf("Test Desc(%s): ExplainRequest failed: %v", tc.desc, err)
+		}
+		gotJson, err := json.Marshal(explanation)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := util.JsonEqual(tc.wantExplanation, string(gotJson)); err != nil {
+			t.Errorf("Test Desc(%s): ExplainRequest failed, %v", tc.desc, err)
+		}
+	}
+}
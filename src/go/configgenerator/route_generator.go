@@ -15,19 +15,34 @@
 package configgenerator
 
 import (
+	"encoding/json"
 	"fmt"
+	"math"
 	"regexp"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/GoogleCloudPlatform/esp-v2/src/go/configinfo"
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/options"
 	"github.com/GoogleCloudPlatform/esp-v2/src/go/util"
 	"github.com/golang/glog"
+	"github.com/golang/protobuf/proto"
 	"github.com/golang/protobuf/ptypes"
 
 	commonpb "github.com/GoogleCloudPlatform/esp-v2/src/go/proto/api/envoy/http/common"
 	v2pb "github.com/envoyproxy/go-control-plane/envoy/api/v2"
+	corepb "github.com/envoyproxy/go-control-plane/envoy/api/v2/core"
 	routepb "github.com/envoyproxy/go-control-plane/envoy/api/v2/route"
+	commonfaultpb "github.com/envoyproxy/go-control-plane/envoy/config/filter/fault/v2"
+	bufferpb "github.com/envoyproxy/go-control-plane/envoy/config/filter/http/buffer/v2"
+	extauthzpb "github.com/envoyproxy/go-control-plane/envoy/config/filter/http/ext_authz/v2"
+	faultpb "github.com/envoyproxy/go-control-plane/envoy/config/filter/http/fault/v2"
+	rbacpb "github.com/envoyproxy/go-control-plane/envoy/config/filter/http/rbac/v2"
+	rbacconfigpb "github.com/envoyproxy/go-control-plane/envoy/config/rbac/v2"
+	typepb "github.com/envoyproxy/go-control-plane/envoy/type"
 	matcher "github.com/envoyproxy/go-control-plane/envoy/type/matcher"
+	anypb "github.com/golang/protobuf/ptypes/any"
 	wrapperspb "github.com/golang/protobuf/ptypes/wrappers"
 )
 
@@ -36,6 +51,13 @@ const (
 	virtualHostName = "backend"
 )
 
+var (
+	// Headers of the gRPC-Web requests and responses of browsers, allowed by
+	// the CORS policy if gRPC-Web is enabled.
+	grpcWebCorsAllowHeaders  = []string{"content-type", "x-grpc-web", "x-user-agent", "grpc-timeout"}
+	grpcWebCorsExposeHeaders = []string{"grpc-status", "grpc-message"}
+)
+
 func MakeRouteConfig(serviceInfo *configinfo.ServiceInfo) (*v2pb.RouteConfiguration, error) {
 	var virtualHosts []*routepb.VirtualHost
 	host := routepb.VirtualHost{
@@ -43,15 +65,46 @@ func MakeRouteConfig(serviceInfo *configinfo.ServiceInfo) (*v2pb.RouteConfigurat
 		Domains: []string{"*"},
 	}
 
+	// If only some operations limit their request bodies, the Buffer filter is
+	// disabled by default.
+	if serviceInfo.Options.MaxRequestBodyBytes == 0 && bufferFilterMaxBytes(serviceInfo) != 0 {
+		host.TypedPerFilterConfig = makeBufferPerRoute(0)
+	}
+
+	// Per-selector routes returning the canned responses of the operations,
+	// before their routes to the backends.
+	directRoutes, err := makeDirectResponseRoutes(serviceInfo)
+	if err != nil {
+		return nil, err
+	}
+	host.Routes = directRoutes
+
 	// Per-selector routes for dynamic routing.
 	brRoutes, err := makeDynamicRoutingConfig(serviceInfo)
 	if err != nil {
 		return nil, err
 	}
-	host.Routes = brRoutes
+	host.Routes = append(host.Routes, brRoutes...)
+
+	// Per-selector routes to the local backend, for deadlines and retry
+	// policies of the operations.
+	localRoutes, err := makeLocalBackendRoutes(serviceInfo)
+	if err != nil {
+		return nil, err
+	}
+	host.Routes = append(host.Routes, localRoutes...)
 
-	if len(host.Routes) == 0 {
-		// Catch-all route if dynamic routing is not enabled.
+	// Routes of the preflight requests to the paths of the operations with a
+	// CORS policy, after the routes of their OPTIONS operations, if any.
+	preflightRoutes, err := makeCorsPreflightRoutes(serviceInfo)
+	if err != nil {
+		return nil, err
+	}
+	host.Routes = append(host.Routes, preflightRoutes...)
+
+	if len(brRoutes) == 0 || serviceInfo.Options.UnmatchedPathToBackend {
+		// Catch-all route if dynamic routing is not enabled, or for the
+		// requests whose path does not match any operation.
 		catchAllRt := &routepb.Route{
 			Match: &routepb.RouteMatch{
 				PathSpecifier: &routepb.RouteMatch_Prefix{
@@ -66,7 +119,9 @@ func MakeRouteConfig(serviceInfo *configinfo.ServiceInfo) (*v2pb.RouteConfigurat
 					// Use the default deadline for the catch-all route.
 					// If a customer needs to override this, dynamic routing must be used.
 					// This is the intended design of the feature (b/147813008).
-					Timeout: ptypes.DurationProto(util.DefaultResponseDeadline),
+					Timeout:     ptypes.DurationProto(util.DefaultResponseDeadline),
+					RetryPolicy: makeRetryPolicy(serviceInfo.DefaultBackendRetry),
+					HashPolicy:  makeHashPolicy(&serviceInfo.Options.BackendLoadBalancing),
 				},
 			},
 		}
@@ -76,6 +131,33 @@ func MakeRouteConfig(serviceInfo *configinfo.ServiceInfo) (*v2pb.RouteConfigurat
 		glog.Infof("adding catch-all routing configuration: %v", jsonStr)
 	}
 
+	// The gRPC health checks are served by the config manager, which knows
+	// whether the service config is sent to Envoy, on the ADS cluster.
+	if serviceInfo.Options.HealthGrpcService != "" {
+		hcRt := &routepb.Route{
+			Match: &routepb.RouteMatch{
+				PathSpecifier: &routepb.RouteMatch_Path{
+					Path: util.GrpcHealthCheckPath,
+				},
+			},
+			Action: &routepb.Route_Route{
+				Route: &routepb.RouteAction{
+					ClusterSpecifier: &routepb.RouteAction_Cluster{
+						Cluster: util.AdsClusterName,
+					},
+					Timeout: ptypes.DurationProto(util.DefaultResponseDeadline),
+				},
+			},
+		}
+		if serviceInfo.ExtAuthzCluster != nil {
+			// The health checks are not sent to the external authorization server.
+			hcRt.TypedPerFilterConfig = map[string]*anypb.Any{
+				util.ExtAuthz: makeExtAuthzDisabledPerRoute(),
+			}
+		}
+		host.Routes = append([]*routepb.Route{hcRt}, host.Routes...)
+	}
+
 	switch serviceInfo.Options.CorsPreset {
 	case "basic":
 		org := serviceInfo.Options.CorsAllowOrigin
@@ -128,6 +210,10 @@ func MakeRouteConfig(serviceInfo *configinfo.ServiceInfo) (*v2pb.RouteConfigurat
 		host.GetCors().AllowHeaders = serviceInfo.Options.CorsAllowHeaders
 		host.GetCors().ExposeHeaders = serviceInfo.Options.CorsExposeHeaders
 		host.GetCors().AllowCredentials = &wrapperspb.BoolValue{Value: serviceInfo.Options.CorsAllowCredentials}
+		if serviceInfo.Options.EnableGrpcWeb {
+			host.GetCors().AllowHeaders = appendCorsHeaders(host.GetCors().AllowHeaders, grpcWebCorsAllowHeaders)
+			host.GetCors().ExposeHeaders = appendCorsHeaders(host.GetCors().ExposeHeaders, grpcWebCorsExposeHeaders)
+		}
 
 		// In order apply Envoy cors policy, need to have a route rule
 		// to route OPTIONS request to this host
@@ -159,36 +245,241 @@ func MakeRouteConfig(serviceInfo *configinfo.ServiceInfo) (*v2pb.RouteConfigurat
 		glog.Infof("adding cors route configuration: %v", jsonStr)
 	}
 
+	// The header rule of all the requests is applied before the ones of the
+	// operations, which overwrite its headers.
+	mostSpecificHeaderMutationsWins := false
+	if rule := serviceInfo.GlobalHeaderRule; rule != nil {
+		host.RequestHeadersToAdd, host.RequestHeadersToRemove, host.ResponseHeadersToAdd, host.ResponseHeadersToRemove = makeHeaderRuleHeaders(rule)
+		mostSpecificHeaderMutationsWins = true
+	}
+
+	// The request ID is copied to its additional header in the requests to
+	// the backends and in the responses.
+	if name := serviceInfo.Options.RequestIdHeader; name != "" {
+		requestId := &corepb.HeaderValueOption{
+			Header: &corepb.HeaderValue{
+				Key:   name,
+				Value: fmt.Sprintf("%%REQ(%s)%%", util.RequestIdHeader),
+			},
+			Append: &wrapperspb.BoolValue{
+				Value: false,
+			},
+		}
+		host.RequestHeadersToAdd = append(host.RequestHeadersToAdd, requestId)
+		host.ResponseHeadersToAdd = append(host.ResponseHeadersToAdd, requestId)
+	}
+
 	virtualHosts = append(virtualHosts, &host)
 	return &v2pb.RouteConfiguration{
-		Name:         routeName,
-		VirtualHosts: virtualHosts,
+		Name:                            routeName,
+		VirtualHosts:                    virtualHosts,
+		MostSpecificHeaderMutationsWins: mostSpecificHeaderMutationsWins,
 	}, nil
 }
 
+// makeHeaderRuleHeaders makes the request headers to add and remove, and the
+// response headers to add and remove, of a header rule.
+func makeHeaderRuleHeaders(rule *options.HeaderRuleOptions) ([]*corepb.HeaderValueOption, []string, []*corepb.HeaderValueOption, []string) {
+	return makeHeaderValueOptions(rule.RequestHeadersToAdd), rule.RequestHeadersToRemove, makeHeaderValueOptions(rule.ResponseHeadersToAdd), rule.ResponseHeadersToRemove
+}
+
+func makeHeaderValueOptions(headers []*options.HeaderValueOptions) []*corepb.HeaderValueOption {
+	var headerValueOptions []*corepb.HeaderValueOption
+	for _, h := range headers {
+		headerValueOptions = append(headerValueOptions, &corepb.HeaderValueOption{
+			Header: &corepb.HeaderValue{
+				Key:   h.Name,
+				Value: h.Value,
+			},
+			Append: &wrapperspb.BoolValue{
+				Value: !h.Overwrite,
+			},
+		})
+	}
+	return headerValueOptions
+}
+
+// applyHeaderRule adds the headers of the header rule of a method, if any, to
+// its route.
+func applyHeaderRule(r *routepb.Route, rule *options.HeaderRuleOptions) {
+	if rule == nil {
+		return
+	}
+	requestHeadersToAdd, requestHeadersToRemove, responseHeadersToAdd, responseHeadersToRemove := makeHeaderRuleHeaders(rule)
+	r.RequestHeadersToAdd = append(r.RequestHeadersToAdd, requestHeadersToAdd...)
+	r.RequestHeadersToRemove = append(r.RequestHeadersToRemove, requestHeadersToRemove...)
+	r.ResponseHeadersToAdd = append(r.ResponseHeadersToAdd, responseHeadersToAdd...)
+	r.ResponseHeadersToRemove = append(r.ResponseHeadersToRemove, responseHeadersToRemove...)
+}
+
+// appendCorsHeaders appends the headers missing from the comma separated
+// headers of a CORS policy.
+func appendCorsHeaders(headers string, extra []string) string {
+	var list []string
+	present := make(map[string]bool)
+	for _, h := range strings.Split(headers, ",") {
+		if h = strings.TrimSpace(h); h != "" {
+			list = append(list, h)
+			present[strings.ToLower(h)] = true
+		}
+	}
+	for _, h := range extra {
+		if !present[h] {
+			list = append(list, h)
+		}
+	}
+	return strings.Join(list, ",")
+}
+
+// makeCorsPolicy makes the CORS policy of the routes of an operation, nil if
+// it has none. Its empty fields fall back to the ones of the policy of the
+// virtual host, so the gRPC-Web headers are only added to them if they are
+// set or if there is no such policy.
+func makeCorsPolicy(serviceInfo *configinfo.ServiceInfo, o *options.CorsPolicyOptions) *routepb.CorsPolicy {
+	if o == nil {
+		return nil
+	}
+	cors := &routepb.CorsPolicy{
+		AllowMethods:  strings.Join(o.AllowMethods, ","),
+		AllowHeaders:  strings.Join(o.AllowHeaders, ","),
+		ExposeHeaders: strings.Join(o.ExposeHeaders, ","),
+	}
+	for _, r := range o.AllowOriginRegexes {
+		cors.AllowOriginStringMatch = append(cors.AllowOriginStringMatch, &matcher.StringMatcher{
+			MatchPattern: &matcher.StringMatcher_SafeRegex{
+				SafeRegex: &matcher.RegexMatcher{
+					EngineType: &matcher.RegexMatcher_GoogleRe2{
+						GoogleRe2: &matcher.RegexMatcher_GoogleRE2{
+							MaxProgramSize: &wrapperspb.UInt32Value{
+								Value: util.GoogleRE2MaxProgramSize,
+							},
+						},
+					},
+					Regex: r,
+				},
+			},
+		})
+	}
+	if o.MaxAge != 0 {
+		cors.MaxAge = strconv.FormatUint(uint64(o.MaxAge), 10)
+	}
+	if o.AllowCredentials {
+		cors.AllowCredentials = &wrapperspb.BoolValue{Value: true}
+	}
+	if serviceInfo.Options.EnableGrpcWeb {
+		if cors.AllowHeaders != "" || serviceInfo.Options.CorsPreset == "" {
+			cors.AllowHeaders = appendCorsHeaders(cors.AllowHeaders, grpcWebCorsAllowHeaders)
+		}
+		if cors.ExposeHeaders != "" || serviceInfo.Options.CorsPreset == "" {
+			cors.ExposeHeaders = appendCorsHeaders(cors.ExposeHeaders, grpcWebCorsExposeHeaders)
+		}
+	}
+	return cors
+}
+
+// makeRouteCorsPolicy makes the CORS policy of the route of an HTTP rule of a
+// method with the policy o. The OPTIONS rules of the methods without their own
+// policy use the one of their path, for the preflight requests.
+func makeRouteCorsPolicy(serviceInfo *configinfo.ServiceInfo, o *options.CorsPolicyOptions, httpRule *commonpb.Pattern) *routepb.CorsPolicy {
+	if o == nil && httpRule.HttpMethod == util.OPTIONS {
+		o = pathCorsPolicy(serviceInfo, httpRule.UriTemplate)
+	}
+	return makeCorsPolicy(serviceInfo, o)
+}
+
+// pathCorsPolicy returns the CORS policy of the first operation with one and
+// a non-OPTIONS HTTP rule with the path template, nil if there is none.
+func pathCorsPolicy(serviceInfo *configinfo.ServiceInfo, uriTemplate string) *options.CorsPolicyOptions {
+	for _, operation := range serviceInfo.Operations {
+		method := serviceInfo.Methods[operation]
+		if method.CorsPolicy == nil {
+			continue
+		}
+		for _, httpRule := range method.HttpRule {
+			if httpRule.HttpMethod != util.OPTIONS && httpRule.UriTemplate == uriTemplate {
+				return method.CorsPolicy
+			}
+		}
+	}
+	return nil
+}
+
+// makeCorsPreflightRoutes makes the routes of the preflight requests to the
+// paths of the operations with a CORS policy, which is the one of the first
+// operation of each path. The requests to a path which are not preflight
+// requests are routed to the backend of its operation.
+func makeCorsPreflightRoutes(serviceInfo *configinfo.ServiceInfo) ([]*routepb.Route, error) {
+	var routes []*routepb.Route
+	seen := make(map[string]bool)
+	for _, operation := range serviceInfo.Operations {
+		method := serviceInfo.Methods[operation]
+		if method.CorsPolicy == nil {
+			continue
+		}
+		cluster := serviceInfo.BackendClusterName()
+		if method.BackendInfo != nil {
+			cluster = method.BackendInfo.ClusterName
+		}
+		for _, httpRule := range method.HttpRule {
+			if httpRule.HttpMethod == util.OPTIONS || seen[httpRule.UriTemplate] {
+				continue
+			}
+			seen[httpRule.UriTemplate] = true
+
+			routeMatcher := makeHttpRouteMatcher(&commonpb.Pattern{
+				UriTemplate: httpRule.UriTemplate,
+				HttpMethod:  util.OPTIONS,
+			})
+			if routeMatcher == nil {
+				return nil, fmt.Errorf("error making HTTP route matcher for selector: %v", operation)
+			}
+			r := &routepb.Route{
+				Match: routeMatcher,
+				Action: &routepb.Route_Route{
+					Route: &routepb.RouteAction{
+						ClusterSpecifier: &routepb.RouteAction_Cluster{
+							Cluster: cluster,
+						},
+						Cors: makeCorsPolicy(serviceInfo, method.CorsPolicy),
+					},
+				},
+			}
+			routes = append(routes, r)
+
+			jsonStr, _ := util.ProtoToJson(r)
+			glog.Infof("adding CORS preflight routing configuration: %v", jsonStr)
+		}
+	}
+	return routes, nil
+}
+
 func makeDynamicRoutingConfig(serviceInfo *configinfo.ServiceInfo) ([]*routepb.Route, error) {
 	var backendRoutes []*routepb.Route
 	for _, operation := range serviceInfo.Operations {
 		method := serviceInfo.Methods[operation]
 		var routeMatcher *routepb.RouteMatch
-		if method.BackendInfo == nil {
+		if method.BackendInfo == nil || method.DirectResponse != nil {
 			continue
 		}
 
 		// Response timeouts are not compatible with streaming methods (documented in Envoy).
 		// If this method is non-unary gRPC, explicitly set 0s to disable the timeout.
 		// This even applies for routes with gRPC-JSON transcoding where only the upstream is streaming.
+		// Upgraded WebSocket connections are streaming too.
 		var respTimeout time.Duration
-		if method.IsStreaming {
+		var retryPolicy *routepb.RetryPolicy
+		if method.IsStreaming || method.EnableWebsocket {
 			respTimeout = 0 * time.Second
 		} else {
 			respTimeout = method.BackendInfo.Deadline
+			retryPolicy = makeRetryPolicy(method.BackendRetry)
 		}
 
 		for _, httpRule := range method.HttpRule {
 			if routeMatcher = makeHttpRouteMatcher(httpRule); routeMatcher == nil {
 				return nil, fmt.Errorf("error making HTTP route matcher for selector: %v", operation)
 			}
+			applyPathRewriteMatch(routeMatcher, method.PathRewrite)
 
 			r := routepb.Route{
 				Match: routeMatcher,
@@ -197,22 +488,709 @@ func makeDynamicRoutingConfig(serviceInfo *configinfo.ServiceInfo) ([]*routepb.R
 						ClusterSpecifier: &routepb.RouteAction_Cluster{
 							Cluster: method.BackendInfo.ClusterName,
 						},
+						HedgePolicy: makeHedgePolicy(retryPolicy, method.BackendRetry, httpRule.HttpMethod),
 						HostRewriteSpecifier: &routepb.RouteAction_HostRewrite{
 							HostRewrite: method.BackendInfo.Hostname,
 						},
-						Timeout: ptypes.DurationProto(respTimeout),
+						Timeout:        ptypes.DurationProto(respTimeout),
+						RetryPolicy:    retryPolicy,
+						UpgradeConfigs: makeRouteUpgradeConfigs(method.EnableWebsocket),
+						RateLimits:     makeRouteRateLimits(serviceInfo, operation),
+						Cors:           makeRouteCorsPolicy(serviceInfo, method.CorsPolicy, httpRule),
+						HashPolicy:     makeHashPolicy(method.BackendInfo.LoadBalancing),
+
+						RequestMirrorPolicy: makeRequestMirrorPolicy(method.BackendMirror),
 					},
 				},
 			}
-			backendRoutes = append(backendRoutes, &r)
+			// The backends in several regions are sent the requests with the
+			// hostname of the region.
+			if method.BackendInfo.AutoHostRewrite {
+				r.GetRoute().HostRewriteSpecifier = &routepb.RouteAction_AutoHostRewrite{
+					AutoHostRewrite: &wrapperspb.BoolValue{Value: true},
+				}
+			}
+			r.Tracing = makeRouteTracing(method.TracingSampleRate)
+			r.TypedPerFilterConfig = makeRoutePerFilterConfig(serviceInfo, operation)
+			r.RequestHeadersToAdd, r.RequestHeadersToRemove = makeJwtClaimRequestHeaders(method.JwtClaimHeaders)
+			if method.DisableAccessLog {
+				r.RequestHeadersToAdd = append(r.RequestHeadersToAdd, makeAccessLogDisabledHeader())
+			}
+			applyHeaderRule(&r, method.HeaderRule)
+			for _, sr := range makeBackendSplitRoutes(serviceInfo, method.BackendSplit, &r) {
+				backendRoutes = append(backendRoutes, sr)
 
-			jsonStr, _ := util.ProtoToJson(&r)
-			glog.Infof("adding Dynamic Routing configuration: %v", jsonStr)
+				jsonStr, _ := util.ProtoToJson(sr)
+				glog.Infof("adding Dynamic Routing configuration: %v", jsonStr)
+			}
 		}
 	}
 	return backendRoutes, nil
 }
 
+// makeLocalBackendRoutes makes the routes of the operations served by the
+// local backend with their own deadline, retry policy, WebSocket upgrades,
+// request body limit, JWT audiences, JWT claim headers, authorization policies,
+// backend split, backend mirror, fault injection, tracing sample rate,
+// disabled external authorization, disabled access logs, header rule, path
+// rewrite or CORS policy. All
+// of them have their own routes with the rate limit service, which is asked
+// for the requests of each operation. Other operations use the catch-all
+// route.
+func makeLocalBackendRoutes(serviceInfo *configinfo.ServiceInfo) ([]*routepb.Route, error) {
+	var localRoutes []*routepb.Route
+	for _, operation := range serviceInfo.Operations {
+		method := serviceInfo.Methods[operation]
+		if method.BackendInfo != nil || method.IsGenerated || method.DirectResponse != nil {
+			continue
+		}
+		hasOwnRetry := method.BackendRetry != nil && method.BackendRetry != serviceInfo.DefaultBackendRetry
+		hasOwnBodyLimit := hasOwnRequestBodyLimit(serviceInfo, operation)
+		if method.LocalBackendDeadline == 0 && !hasOwnRetry && !method.EnableWebsocket && !hasOwnBodyLimit &&
+			len(method.JwtAudiences) == 0 && len(method.JwtClaimHeaders) == 0 && len(method.AuthorizationPolicies) == 0 &&
+			len(method.BackendSplit) == 0 && method.BackendMirror == nil && method.FaultInjection == nil && method.TracingSampleRate == nil && !method.DisableExtAuthz && !method.DisableAccessLog &&
+			method.HeaderRule == nil && method.PathRewrite == nil && method.CorsPolicy == nil && serviceInfo.RateLimitServiceCluster == nil {
+			continue
+		}
+
+		respTimeout := util.DefaultResponseDeadline
+		if method.LocalBackendDeadline != 0 {
+			respTimeout = method.LocalBackendDeadline
+		}
+		var retryPolicy *routepb.RetryPolicy
+		if method.IsStreaming || method.EnableWebsocket {
+			respTimeout = 0 * time.Second
+		} else {
+			retryPolicy = makeRetryPolicy(method.BackendRetry)
+		}
+
+		for _, httpRule := range method.HttpRule {
+			routeMatcher := makeHttpRouteMatcher(httpRule)
+			if routeMatcher == nil {
+				return nil, fmt.Errorf("error making HTTP route matcher for selector: %v", operation)
+			}
+			applyPathRewriteMatch(routeMatcher, method.PathRewrite)
+
+			r := &routepb.Route{
+				Match: routeMatcher,
+				Action: &routepb.Route_Route{
+					Route: &routepb.RouteAction{
+						ClusterSpecifier: &routepb.RouteAction_Cluster{
+							Cluster: serviceInfo.BackendClusterName(),
+						},
+						HedgePolicy:    makeHedgePolicy(retryPolicy, method.BackendRetry, httpRule.HttpMethod),
+						Timeout:        ptypes.DurationProto(respTimeout),
+						RetryPolicy:    retryPolicy,
+						UpgradeConfigs: makeRouteUpgradeConfigs(method.EnableWebsocket),
+						RateLimits:     makeRouteRateLimits(serviceInfo, operation),
+						Cors:           makeRouteCorsPolicy(serviceInfo, method.CorsPolicy, httpRule),
+						HashPolicy:     makeHashPolicy(&serviceInfo.Options.BackendLoadBalancing),
+
+						RequestMirrorPolicy: makeRequestMirrorPolicy(method.BackendMirror),
+					},
+				},
+			}
+			r.Tracing = makeRouteTracing(method.TracingSampleRate)
+			r.TypedPerFilterConfig = makeRoutePerFilterConfig(serviceInfo, operation)
+			r.RequestHeadersToAdd, r.RequestHeadersToRemove = makeJwtClaimRequestHeaders(method.JwtClaimHeaders)
+			if method.DisableAccessLog {
+				r.RequestHeadersToAdd = append(r.RequestHeadersToAdd, makeAccessLogDisabledHeader())
+			}
+			applyHeaderRule(r, method.HeaderRule)
+			for _, sr := range makeBackendSplitRoutes(serviceInfo, method.BackendSplit, r) {
+				localRoutes = append(localRoutes, sr)
+
+				jsonStr, _ := util.ProtoToJson(sr)
+				glog.Infof("adding local backend routing configuration: %v", jsonStr)
+			}
+		}
+	}
+	return localRoutes, nil
+}
+
+// makeDirectResponseRoutes makes the routes of the operations with a canned
+// response, returned by the proxy without a backend. The requests are still
+// checked and reported by the filters, with the per-route config, tracing
+// sample rate and header rule of the operations.
+func makeDirectResponseRoutes(serviceInfo *configinfo.ServiceInfo) ([]*routepb.Route, error) {
+	var directRoutes []*routepb.Route
+	for _, operation := range serviceInfo.Operations {
+		method := serviceInfo.Methods[operation]
+		if method.DirectResponse == nil || method.IsGenerated {
+			continue
+		}
+		for _, httpRule := range method.HttpRule {
+			routeMatcher := makeHttpRouteMatcher(httpRule)
+			if routeMatcher == nil {
+				return nil, fmt.Errorf("error making HTTP route matcher for selector: %v", operation)
+			}
+
+			r := &routepb.Route{
+				Match: routeMatcher,
+				Action: &routepb.Route_DirectResponse{
+					DirectResponse: makeDirectResponseAction(method.DirectResponse),
+				},
+			}
+			r.Tracing = makeRouteTracing(method.TracingSampleRate)
+			r.TypedPerFilterConfig = makeRoutePerFilterConfig(serviceInfo, operation)
+			r.RequestHeadersToAdd, r.RequestHeadersToRemove = makeJwtClaimRequestHeaders(method.JwtClaimHeaders)
+			if method.DisableAccessLog {
+				r.RequestHeadersToAdd = append(r.RequestHeadersToAdd, makeAccessLogDisabledHeader())
+			}
+			applyHeaderRule(r, method.HeaderRule)
+			r.ResponseHeadersToAdd = append(r.ResponseHeadersToAdd, makeDirectResponseHeaders(method.DirectResponse)...)
+			directRoutes = append(directRoutes, r)
+
+			jsonStr, _ := util.ProtoToJson(r)
+			glog.Infof("adding direct response configuration: %v", jsonStr)
+		}
+	}
+	return directRoutes, nil
+}
+
+// makeDirectResponseAction makes the direct response with the status and the
+// inline body of a canned response.
+func makeDirectResponseAction(o *options.DirectResponseOptions) *routepb.DirectResponseAction {
+	action := &routepb.DirectResponseAction{
+		Status: o.Status,
+	}
+	if o.Body != "" {
+		action.Body = &corepb.DataSource{
+			Specifier: &corepb.DataSource_InlineString{
+				InlineString: o.Body,
+			},
+		}
+	}
+	return action
+}
+
+// makeDirectResponseHeaders makes the response headers of a canned response.
+// The content type overwrites the text/plain set by Envoy for the bodies of
+// direct responses.
+func makeDirectResponseHeaders(o *options.DirectResponseOptions) []*corepb.HeaderValueOption {
+	headers := makeHeaderValueOptions(o.Headers)
+	if o.ContentType != "" {
+		headers = append(headers, &corepb.HeaderValueOption{
+			Header: &corepb.HeaderValue{
+				Key:   "content-type",
+				Value: o.ContentType,
+			},
+			Append: &wrapperspb.BoolValue{
+				Value: false,
+			},
+		})
+	}
+	return headers
+}
+
+// makeBackendSplitRoutes splits the requests of the route of a method between
+// the weighted backends in split, with the Host header of each backend. If the
+// BackendSplitHeader is set, the route is preceded by a route per backend,
+// matching the requests with its name in the header. The route is returned
+// as is if the requests of the method are not split.
+func makeBackendSplitRoutes(serviceInfo *configinfo.ServiceInfo, split []*configinfo.WeightedBackend, r *routepb.Route) []*routepb.Route {
+	if len(split) == 0 {
+		return []*routepb.Route{r}
+	}
+
+	var routes []*routepb.Route
+	weightedClusters := &routepb.WeightedCluster{}
+	var totalWeight uint32
+	for _, b := range split {
+		weightedClusters.Clusters = append(weightedClusters.Clusters, &routepb.WeightedCluster_ClusterWeight{
+			Name:   b.ClusterName,
+			Weight: &wrapperspb.UInt32Value{Value: b.Weight},
+		})
+		totalWeight += b.Weight
+
+		if serviceInfo.Options.BackendSplitHeader == "" {
+			continue
+		}
+		forced := proto.Clone(r).(*routepb.Route)
+		forced.Match.Headers = append(forced.Match.Headers, &routepb.HeaderMatcher{
+			Name: serviceInfo.Options.BackendSplitHeader,
+			HeaderMatchSpecifier: &routepb.HeaderMatcher_ExactMatch{
+				ExactMatch: b.Name,
+			},
+		})
+		forced.GetRoute().ClusterSpecifier = &routepb.RouteAction_Cluster{
+			Cluster: b.ClusterName,
+		}
+		forced.GetRoute().HostRewriteSpecifier = &routepb.RouteAction_AutoHostRewrite{
+			AutoHostRewrite: &wrapperspb.BoolValue{Value: true},
+		}
+		routes = append(routes, forced)
+	}
+	weightedClusters.TotalWeight = &wrapperspb.UInt32Value{Value: totalWeight}
+
+	// The host of the upstream is used, as the hostname of the BackendRule is
+	// not the one of every backend.
+	r.GetRoute().ClusterSpecifier = &routepb.RouteAction_WeightedClusters{
+		WeightedClusters: weightedClusters,
+	}
+	r.GetRoute().HostRewriteSpecifier = &routepb.RouteAction_AutoHostRewrite{
+		AutoHostRewrite: &wrapperspb.BoolValue{Value: true},
+	}
+	return append(routes, r)
+}
+
+// makeRouteRateLimits makes the rate limit actions of the routes of an
+// operation, asking the rate limit service with the operation as the
+// "generic_key" descriptor, nil if the service is not set.
+func makeRouteRateLimits(serviceInfo *configinfo.ServiceInfo, operation string) []*routepb.RateLimit {
+	if serviceInfo.RateLimitServiceCluster == nil {
+		return nil
+	}
+	return []*routepb.RateLimit{
+		{
+			Actions: []*routepb.RateLimit_Action{
+				{
+					ActionSpecifier: &routepb.RateLimit_Action_GenericKey_{
+						GenericKey: &routepb.RateLimit_Action_GenericKey{
+							DescriptorValue: operation,
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// makeRetryPolicy makes the Envoy retry policy of a route, nil if disabled.
+func makeRetryPolicy(policy *configinfo.BackendRetryPolicy) *routepb.RetryPolicy {
+	if policy == nil {
+		return nil
+	}
+	retryPolicy := &routepb.RetryPolicy{
+		RetryOn:    policy.RetryOn,
+		NumRetries: &wrapperspb.UInt32Value{Value: policy.NumRetries},
+	}
+	if policy.PerTryTimeout != 0 {
+		retryPolicy.PerTryTimeout = ptypes.DurationProto(policy.PerTryTimeout)
+	}
+	return retryPolicy
+}
+
+// makeHedgePolicy makes the Envoy hedge policy of a route with the retry policy
+// retryPolicy, made from policy, nil if hedging is disabled or httpMethod is
+// not idempotent.
+func makeHedgePolicy(retryPolicy *routepb.RetryPolicy, policy *configinfo.BackendRetryPolicy, httpMethod string) *routepb.HedgePolicy {
+	if retryPolicy == nil || !policy.Hedge || policy.PerTryTimeout == 0 {
+		return nil
+	}
+	switch httpMethod {
+	case util.GET, util.HEAD, util.OPTIONS, util.PUT, util.DELETE:
+	default:
+		return nil
+	}
+	return &routepb.HedgePolicy{
+		HedgeOnPerTryTimeout: true,
+	}
+}
+
+// makeRequestMirrorPolicy makes the policy mirroring a fraction of the
+// requests of a route to the secondary backend of its method, nil if they are
+// not mirrored. Envoy appends "-shadow" to the Host header of the mirrored
+// requests, and ignores their responses.
+func makeRequestMirrorPolicy(mirror *configinfo.BackendMirror) *routepb.RouteAction_RequestMirrorPolicy {
+	if mirror == nil {
+		return nil
+	}
+	return &routepb.RouteAction_RequestMirrorPolicy{
+		Cluster: mirror.ClusterName,
+		RuntimeFraction: &corepb.RuntimeFractionalPercent{
+			DefaultValue: &typepb.FractionalPercent{
+				Numerator:   uint32(math.Round(mirror.Fraction * 1000000)),
+				Denominator: typepb.FractionalPercent_MILLION,
+			},
+		},
+	}
+}
+
+// makeHashPolicy makes the hash policy of a route to a backend with the
+// consistent hash load balancing policy o, nil for the other policies. The
+// first hash key found in the request is used.
+func makeHashPolicy(o *options.LoadBalancingOptions) []*routepb.RouteAction_HashPolicy {
+	if o == nil || !o.IsConsistentHash() {
+		return nil
+	}
+	var hashPolicy []*routepb.RouteAction_HashPolicy
+	if o.HashHeader != "" {
+		hashPolicy = append(hashPolicy, &routepb.RouteAction_HashPolicy{
+			PolicySpecifier: &routepb.RouteAction_HashPolicy_Header_{
+				Header: &routepb.RouteAction_HashPolicy_Header{
+					HeaderName: o.HashHeader,
+				},
+			},
+			Terminal: true,
+		})
+	}
+	if o.HashCookie != "" {
+		cookie := &routepb.RouteAction_HashPolicy_Cookie{
+			Name: o.HashCookie,
+		}
+		if o.HashCookieTtl > 0 {
+			cookie.Ttl = ptypes.DurationProto(time.Duration(o.HashCookieTtl * float64(time.Second)))
+			cookie.Path = "/"
+		}
+		hashPolicy = append(hashPolicy, &routepb.RouteAction_HashPolicy{
+			PolicySpecifier: &routepb.RouteAction_HashPolicy_Cookie_{
+				Cookie: cookie,
+			},
+			Terminal: true,
+		})
+	}
+	if o.HashSourceIp {
+		hashPolicy = append(hashPolicy, &routepb.RouteAction_HashPolicy{
+			PolicySpecifier: &routepb.RouteAction_HashPolicy_ConnectionProperties_{
+				ConnectionProperties: &routepb.RouteAction_HashPolicy_ConnectionProperties{
+					SourceIp: true,
+				},
+			},
+			Terminal: true,
+		})
+	}
+	return hashPolicy
+}
+
+// makeRouteUpgradeConfigs allows WebSocket upgrades on the routes of a method,
+// even if they are not allowed by the HTTP connection manager.
+func makeRouteUpgradeConfigs(enableWebsocket bool) []*routepb.RouteAction_UpgradeConfig {
+	if !enableWebsocket {
+		return nil
+	}
+	return []*routepb.RouteAction_UpgradeConfig{
+		{
+			UpgradeType: util.WebsocketUpgradeType,
+			Enabled:     &wrapperspb.BoolValue{Value: true},
+		},
+	}
+}
+
+// makeRouteTracing overrides the fraction of the requests traced by Envoy on
+// the routes of a method with its sample rate, nil if it is not overridden.
+func makeRouteTracing(sampleRate *float64) *routepb.Tracing {
+	if sampleRate == nil {
+		return nil
+	}
+	return &routepb.Tracing{
+		RandomSampling: &typepb.FractionalPercent{
+			Numerator:   uint32(math.Round(*sampleRate * 1000000)),
+			Denominator: typepb.FractionalPercent_MILLION,
+		},
+	}
+}
+
+// hasOwnRequestBodyLimit returns true if the request bodies of the method are
+// limited differently from the Buffer filter by default.
+func hasOwnRequestBodyLimit(serviceInfo *configinfo.ServiceInfo, operation string) bool {
+	method := serviceInfo.Methods[operation]
+	return !method.IsGenerated && method.MaxRequestBodyBytes != serviceInfo.Options.MaxRequestBodyBytes
+}
+
+// makeRoutePerFilterConfig makes the per-route configs of the filters on the
+// routes of an operation, nil if it has none.
+func makeRoutePerFilterConfig(serviceInfo *configinfo.ServiceInfo, operation string) map[string]*anypb.Any {
+	method := serviceInfo.Methods[operation]
+	var perFilterConfig map[string]*anypb.Any
+	if hasOwnRequestBodyLimit(serviceInfo, operation) {
+		perFilterConfig = makeBufferPerRoute(method.MaxRequestBodyBytes)
+	}
+	if len(method.JwtAudiences) != 0 || len(method.AuthorizationPolicies) != 0 {
+		if perFilterConfig == nil {
+			perFilterConfig = make(map[string]*anypb.Any)
+		}
+		perFilterConfig[util.RBAC] = makeRbacPerRoute(method.JwtAudiences, method.AuthorizationPolicies)
+	}
+	if method.DisableExtAuthz {
+		if perFilterConfig == nil {
+			perFilterConfig = make(map[string]*anypb.Any)
+		}
+		perFilterConfig[util.ExtAuthz] = makeExtAuthzDisabledPerRoute()
+	}
+	if method.FaultInjection != nil {
+		if perFilterConfig == nil {
+			perFilterConfig = make(map[string]*anypb.Any)
+		}
+		perFilterConfig[util.Fault] = makeFaultPerRoute(method.FaultInjection, serviceInfo.Options.FaultInjectionHeader)
+	}
+	return perFilterConfig
+}
+
+// makeFaultPerRoute makes the per-route config of the Fault filter, injecting
+// the faults of a method in its requests with the opt-in header.
+func makeFaultPerRoute(o *options.FaultInjectionOptions, header string) *anypb.Any {
+	fault := &faultpb.HTTPFault{
+		Headers: []*routepb.HeaderMatcher{
+			{
+				Name: header,
+				HeaderMatchSpecifier: &routepb.HeaderMatcher_PresentMatch{
+					PresentMatch: true,
+				},
+			},
+		},
+	}
+	if d := o.Delay; d != nil {
+		fault.Delay = &commonfaultpb.FaultDelay{
+			FaultDelaySecifier: &commonfaultpb.FaultDelay_FixedDelay{
+				FixedDelay: ptypes.DurationProto(time.Duration(d.Duration * float64(time.Second))),
+			},
+			Percentage: makeFaultPercent(d.Percent),
+		}
+	}
+	if a := o.Abort; a != nil {
+		fault.Abort = &faultpb.FaultAbort{
+			ErrorType: &faultpb.FaultAbort_HttpStatus{
+				HttpStatus: a.Status,
+			},
+			Percentage: makeFaultPercent(a.Percent),
+		}
+	}
+	f, _ := ptypes.MarshalAny(fault)
+	return f
+}
+
+// makeFaultPercent converts a percent of the requests to a fraction in
+// millionths.
+func makeFaultPercent(percent float64) *typepb.FractionalPercent {
+	return &typepb.FractionalPercent{
+		Numerator:   uint32(math.Round(percent * 10000)),
+		Denominator: typepb.FractionalPercent_MILLION,
+	}
+}
+
+// makeExtAuthzDisabledPerRoute makes the per-route config disabling the
+// External Authorization filter.
+func makeExtAuthzDisabledPerRoute() *anypb.Any {
+	a, _ := ptypes.MarshalAny(&extauthzpb.ExtAuthzPerRoute{
+		Override: &extauthzpb.ExtAuthzPerRoute_Disabled{
+			Disabled: true,
+		},
+	})
+	return a
+}
+
+// makeJwtClaimRequestHeaders makes the request headers of a route with the
+// claims of the verified JWTs, read from the JWT payloads in the dynamic
+// metadata of the JWT Authn filter. The headers sent by the clients are
+// removed, so that the backend only gets the verified claims.
+func makeJwtClaimRequestHeaders(claimHeaders []*configinfo.JwtClaimHeader) ([]*corepb.HeaderValueOption, []string) {
+	var headersToAdd []*corepb.HeaderValueOption
+	var headersToRemove []string
+	for _, ch := range claimHeaders {
+		path, _ := json.Marshal(append([]string{util.JwtAuthn, util.JwtPayloadMetadataName}, ch.ClaimPath...))
+		headersToAdd = append(headersToAdd, &corepb.HeaderValueOption{
+			Header: &corepb.HeaderValue{
+				Key:   ch.Header,
+				Value: fmt.Sprintf("%%DYNAMIC_METADATA(%s)%%", path),
+			},
+			Append: &wrapperspb.BoolValue{
+				Value: false,
+			},
+		})
+		headersToRemove = append(headersToRemove, ch.Header)
+	}
+	return headersToAdd, headersToRemove
+}
+
+// makeAccessLogDisabledHeader makes the request header of the routes whose
+// requests are not access logged, matched by the filter of the access logs.
+func makeAccessLogDisabledHeader() *corepb.HeaderValueOption {
+	return &corepb.HeaderValueOption{
+		Header: &corepb.HeaderValue{
+			Key:   util.AccessLogDisabledHeader,
+			Value: "true",
+		},
+		Append: &wrapperspb.BoolValue{
+			Value: false,
+		},
+	}
+}
+
+// makeRbacPerRoute makes the per-route config of the RBAC filter, only
+// allowing the requests whose JWT audience, a string or a list, matches one of
+// the audiences, if any, and whose JWTs match one of the authorization
+// policies, if any.
+func makeRbacPerRoute(audiences []string, policies []*configinfo.AuthorizationPolicy) *anypb.Any {
+	var audiencePrincipals []*rbacconfigpb.Principal
+	if len(audiences) != 0 {
+		var regexes []string
+		for _, a := range audiences {
+			if util.IsJwtAudiencePattern(a) {
+				regexes = append(regexes, fmt.Sprintf("(?:%s)", util.JwtAudienceRegex(a)))
+			} else {
+				regexes = append(regexes, regexp.QuoteMeta(a))
+			}
+		}
+		audiencePrincipals = makeJwtClaimPrincipals([]string{"aud"}, strings.Join(regexes, "|"))
+	}
+
+	anyPermission := []*rbacconfigpb.Permission{
+		{
+			Rule: &rbacconfigpb.Permission_Any{
+				Any: true,
+			},
+		},
+	}
+	rbacPolicies := make(map[string]*rbacconfigpb.Policy)
+	if len(policies) == 0 {
+		rbacPolicies["jwt_audiences"] = &rbacconfigpb.Policy{
+			Permissions: anyPermission,
+			Principals:  audiencePrincipals,
+		}
+	}
+	for i, policy := range policies {
+		// All the claims of the policy, and the audience, are required.
+		var required []*rbacconfigpb.Principal
+		if audiencePrincipals != nil {
+			required = append(required, orPrincipals(audiencePrincipals))
+		}
+		for _, claim := range policy.Claims {
+			var regexes []string
+			for _, v := range claim.Values {
+				regexes = append(regexes, regexp.QuoteMeta(v))
+			}
+			required = append(required, orPrincipals(makeJwtClaimPrincipals(claim.ClaimPath, strings.Join(regexes, "|"))))
+		}
+		rbacPolicies[fmt.Sprintf("authorization_%d", i)] = &rbacconfigpb.Policy{
+			Permissions: anyPermission,
+			Principals: []*rbacconfigpb.Principal{
+				{
+					Identifier: &rbacconfigpb.Principal_AndIds{
+						AndIds: &rbacconfigpb.Principal_Set{
+							Ids: required,
+						},
+					},
+				},
+			},
+		}
+	}
+
+	rbacPerRoute := &rbacpb.RBACPerRoute{
+		Rbac: &rbacpb.RBAC{
+			Rules: &rbacconfigpb.RBAC{
+				Action:   rbacconfigpb.RBAC_ALLOW,
+				Policies: rbacPolicies,
+			},
+		},
+	}
+	a, _ := ptypes.MarshalAny(rbacPerRoute)
+	return a
+}
+
+// makeJwtClaimPrincipals makes the principals matching the requests whose
+// JWT claim, a string or a list, matches the regex.
+func makeJwtClaimPrincipals(claimPath []string, regex string) []*rbacconfigpb.Principal {
+	valueMatcher := &matcher.ValueMatcher{
+		MatchPattern: &matcher.ValueMatcher_StringMatch{
+			StringMatch: &matcher.StringMatcher{
+				MatchPattern: &matcher.StringMatcher_SafeRegex{
+					SafeRegex: &matcher.RegexMatcher{
+						EngineType: &matcher.RegexMatcher_GoogleRe2{
+							GoogleRe2: &matcher.RegexMatcher_GoogleRE2{
+								MaxProgramSize: &wrapperspb.UInt32Value{
+									Value: util.GoogleRE2MaxProgramSize,
+								},
+							},
+						},
+						Regex: regex,
+					},
+				},
+			},
+		},
+	}
+	path := []*matcher.MetadataMatcher_PathSegment{
+		{
+			Segment: &matcher.MetadataMatcher_PathSegment_Key{
+				Key: util.JwtPayloadMetadataName,
+			},
+		},
+	}
+	for _, key := range claimPath {
+		path = append(path, &matcher.MetadataMatcher_PathSegment{
+			Segment: &matcher.MetadataMatcher_PathSegment_Key{
+				Key: key,
+			},
+		})
+	}
+	makePrincipal := func(value *matcher.ValueMatcher) *rbacconfigpb.Principal {
+		return &rbacconfigpb.Principal{
+			Identifier: &rbacconfigpb.Principal_Metadata{
+				Metadata: &matcher.MetadataMatcher{
+					Filter: util.JwtAuthn,
+					Path:   path,
+					Value:  value,
+				},
+			},
+		}
+	}
+
+	return []*rbacconfigpb.Principal{
+		makePrincipal(valueMatcher),
+		makePrincipal(&matcher.ValueMatcher{
+			MatchPattern: &matcher.ValueMatcher_ListMatch{
+				ListMatch: &matcher.ListMatcher{
+					MatchPattern: &matcher.ListMatcher_OneOf{
+						OneOf: valueMatcher,
+					},
+				},
+			},
+		}),
+	}
+}
+
+func orPrincipals(principals []*rbacconfigpb.Principal) *rbacconfigpb.Principal {
+	return &rbacconfigpb.Principal{
+		Identifier: &rbacconfigpb.Principal_OrIds{
+			OrIds: &rbacconfigpb.Principal_Set{
+				Ids: principals,
+			},
+		},
+	}
+}
+
+// makeBufferPerRoute makes the per-route config of the Buffer filter, limiting
+// the request bodies to maxBytes, or disabling the filter if 0.
+func makeBufferPerRoute(maxBytes uint32) map[string]*anypb.Any {
+	bufferPerRoute := &bufferpb.BufferPerRoute{
+		Override: &bufferpb.BufferPerRoute_Disabled{
+			Disabled: true,
+		},
+	}
+	if maxBytes != 0 {
+		bufferPerRoute.Override = &bufferpb.BufferPerRoute_Buffer{
+			Buffer: &bufferpb.Buffer{
+				MaxRequestBytes: &wrapperspb.UInt32Value{Value: maxBytes},
+			},
+		}
+	}
+	a, _ := ptypes.MarshalAny(bufferPerRoute)
+	return map[string]*anypb.Any{
+		util.Buffer: a,
+	}
+}
+
+// applyPathRewriteMatch makes the route matcher match the whole paths with the
+// regex of rewrite instead of the path template, if the paths are rewritten
+// with a regex.
+func applyPathRewriteMatch(routeMatcher *routepb.RouteMatch, rewrite *options.PathRewriteOptions) {
+	if rewrite == nil || rewrite.Regex == "" {
+		return
+	}
+	routeMatcher.PathSpecifier = &routepb.RouteMatch_SafeRegex{
+		SafeRegex: &matcher.RegexMatcher{
+			EngineType: &matcher.RegexMatcher_GoogleRe2{
+				GoogleRe2: &matcher.RegexMatcher_GoogleRE2{
+					MaxProgramSize: &wrapperspb.UInt32Value{
+						Value: util.GoogleRE2MaxProgramSize,
+					},
+				},
+			},
+			Regex: rewrite.Regex,
+		},
+	}
+}
+
 func makeHttpRouteMatcher(httpRule *commonpb.Pattern) *routepb.RouteMatch {
 	if httpRule == nil {
 		return nil
@@ -22,10 +22,15 @@ import (
 	"github.com/GoogleCloudPlatform/esp-v2/src/go/options"
 	"github.com/GoogleCloudPlatform/esp-v2/src/go/util"
 	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
 
 	routepb "github.com/envoyproxy/go-control-plane/envoy/api/v2/route"
 	matcher "github.com/envoyproxy/go-control-plane/envoy/type/matcher"
+	anypb "github.com/golang/protobuf/ptypes/any"
 	wrapperspb "github.com/golang/protobuf/ptypes/wrappers"
+	annotationspb "google.golang.org/genproto/googleapis/api/annotations"
+	confpb "google.golang.org/genproto/googleapis/api/serviceconfig"
+	apipb "google.golang.org/genproto/protobuf/api"
 )
 
 func TestMakeRouteConfigForCors(t *testing.T) {
@@ -36,6 +41,7 @@ func TestMakeRouteConfigForCors(t *testing.T) {
 		// "cors_expose_headers"
 		params           []string
 		allowCredentials bool
+		enableGrpcWeb    bool
 		wantedError      string
 		wantCorsPolicy   *routepb.CorsPolicy
 	}{
@@ -122,6 +128,23 @@ func TestMakeRouteConfigForCors(t *testing.T) {
 				AllowCredentials: &wrapperspb.BoolValue{Value: true},
 			},
 		},
+		{
+			desc:          "Correct configured basic Cors, with gRPC-Web headers",
+			params:        []string{"basic", "http://example.com", "", "", "Content-Type,Authorization", ""},
+			enableGrpcWeb: true,
+			wantCorsPolicy: &routepb.CorsPolicy{
+				AllowOriginStringMatch: []*matcher.StringMatcher{
+					{
+						MatchPattern: &matcher.StringMatcher_Exact{
+							Exact: "http://example.com",
+						},
+					},
+				},
+				AllowHeaders:     "Content-Type,Authorization,x-grpc-web,x-user-agent,grpc-timeout",
+				ExposeHeaders:    "grpc-status,grpc-message",
+				AllowCredentials: &wrapperspb.BoolValue{Value: false},
+			},
+		},
 	}
 
 	for _, tc := range testData {
@@ -135,6 +158,7 @@ func TestMakeRouteConfigForCors(t *testing.T) {
 			opts.CorsExposeHeaders = tc.params[5]
 		}
 		opts.CorsAllowCredentials = tc.allowCredentials
+		opts.EnableGrpcWeb = tc.enableGrpcWeb
 
 		gotRoute, err := MakeRouteConfig(&configinfo.ServiceInfo{
 			Name:    "test-api",
@@ -157,3 +181,2343 @@ func TestMakeRouteConfigForCors(t *testing.T) {
 		}
 	}
 }
+
+func TestMakeRouteConfigForGrpcHealthCheck(t *testing.T) {
+	testData := []struct {
+		desc              string
+		healthGrpcService string
+		extAuthzCluster   *configinfo.BackendRoutingCluster
+		wantFirstRoute    *routepb.Route
+	}{
+		{
+			desc: "No gRPC health check route",
+		},
+		{
+			desc:              "gRPC health checks are routed to the ADS cluster",
+			healthGrpcService: "bookstore.Bookstore",
+			wantFirstRoute: &routepb.Route{
+				Match: &routepb.RouteMatch{
+					PathSpecifier: &routepb.RouteMatch_Path{
+						Path: "/grpc.health.v1.Health/Check",
+					},
+				},
+				Action: &routepb.Route_Route{
+					Route: &routepb.RouteAction{
+						ClusterSpecifier: &routepb.RouteAction_Cluster{
+							Cluster: "ads_cluster",
+						},
+						Timeout: ptypes.DurationProto(util.DefaultResponseDeadline),
+					},
+				},
+			},
+		},
+		{
+			desc:              "gRPC health checks are not sent to the external authorization server",
+			healthGrpcService: "bookstore.Bookstore",
+			extAuthzCluster: &configinfo.BackendRoutingCluster{
+				ClusterName: util.ExtAuthzClusterName,
+			},
+			wantFirstRoute: &routepb.Route{
+				Match: &routepb.RouteMatch{
+					PathSpecifier: &routepb.RouteMatch_Path{
+						Path: "/grpc.health.v1.Health/Check",
+					},
+				},
+				Action: &routepb.Route_Route{
+					Route: &routepb.RouteAction{
+						ClusterSpecifier: &routepb.RouteAction_Cluster{
+							Cluster: "ads_cluster",
+						},
+						Timeout: ptypes.DurationProto(util.DefaultResponseDeadline),
+					},
+				},
+				TypedPerFilterConfig: map[string]*anypb.Any{
+					util.ExtAuthz: makeExtAuthzDisabledPerRoute(),
+				},
+			},
+		},
+	}
+
+	for _, tc := range testData {
+		opts := options.DefaultConfigGeneratorOptions()
+		opts.HealthGrpcService = tc.healthGrpcService
+
+		gotRoute, err := MakeRouteConfig(&configinfo.ServiceInfo{
+			Name:            "test-api",
+			Options:         opts,
+			ExtAuthzCluster: tc.extAuthzCluster,
+		})
+		if err != nil {
+			t.Fatalf("Test (%s): makeRouteConfig failed: %v", tc.desc, err)
+		}
+
+		var gotFirstRoute *routepb.Route
+		if routes := gotRoute.GetVirtualHosts()[0].GetRoutes(); len(routes) > 0 {
+			gotFirstRoute = routes[0]
+		}
+		if tc.wantFirstRoute == nil {
+			if gotFirstRoute.GetMatch().GetPath() == util.GrpcHealthCheckPath {
+				t.Errorf("Test (%s): got unexpected gRPC health check route: %v", tc.desc, gotFirstRoute)
+			}
+			continue
+		}
+		if !proto.Equal(gotFirstRoute, tc.wantFirstRoute) {
+			t.Errorf("Test (%s): makeRouteConfig failed, got first route: %v, want: %v", tc.desc, gotFirstRoute, tc.wantFirstRoute)
+		}
+	}
+}
+
+func TestMakeRouteConfigForBodySizeLimits(t *testing.T) {
+	maxRequestBodyBytes := uint32(2048)
+	testData := []struct {
+		desc                string
+		maxRequestBodyBytes uint32
+		bodySizeLimits      []*options.BodySizeLimitOptions
+		wantRouteConfig     string
+	}{
+		{
+			desc:                "Streaming methods are not limited",
+			maxRequestBodyBytes: 1024,
+			wantRouteConfig: `
+{
+  "name": "local_route",
+  "virtualHosts": [
+    {
+      "domains": ["*"],
+      "name": "backend",
+      "routes": [
+        {
+          "match": {
+            "headers": [{"exactMatch": "POST", "name": ":method"}],
+            "path": "/endpoints.examples.bookstore.Bookstore/WatchShelves"
+          },
+          "route": {
+            "cluster": "bookstore.endpoints.project123.cloud.goog_local",
+            "timeout": "0s"
+          },
+          "typedPerFilterConfig": {
+            "envoy.buffer": {
+              "@type": "type.googleapis.com/envoy.config.filter.http.buffer.v2.BufferPerRoute",
+              "disabled": true
+            }
+          }
+        },
+        {
+          "match": {"prefix": "/"},
+          "route": {
+            "cluster": "bookstore.endpoints.project123.cloud.goog_local",
+            "timeout": "15s"
+          }
+        }
+      ]
+    }
+  ]
+}`,
+		},
+		{
+			desc: "Only an operation is limited",
+			bodySizeLimits: []*options.BodySizeLimitOptions{
+				{
+					Selector:            "endpoints.examples.bookstore.Bookstore.ListShelves",
+					MaxRequestBodyBytes: &maxRequestBodyBytes,
+				},
+			},
+			wantRouteConfig: `
+{
+  "name": "local_route",
+  "virtualHosts": [
+    {
+      "domains": ["*"],
+      "name": "backend",
+      "routes": [
+        {
+          "match": {
+            "headers": [{"exactMatch": "POST", "name": ":method"}],
+            "path": "/endpoints.examples.bookstore.Bookstore/ListShelves"
+          },
+          "route": {
+            "cluster": "bookstore.endpoints.project123.cloud.goog_local",
+            "timeout": "15s"
+          },
+          "typedPerFilterConfig": {
+            "envoy.buffer": {
+              "@type": "type.googleapis.com/envoy.config.filter.http.buffer.v2.BufferPerRoute",
+              "buffer": {"maxRequestBytes": 2048}
+            }
+          }
+        },
+        {
+          "match": {"prefix": "/"},
+          "route": {
+            "cluster": "bookstore.endpoints.project123.cloud.goog_local",
+            "timeout": "15s"
+          }
+        }
+      ],
+      "typedPerFilterConfig": {
+        "envoy.buffer": {
+          "@type": "type.googleapis.com/envoy.config.filter.http.buffer.v2.BufferPerRoute",
+          "disabled": true
+        }
+      }
+    }
+  ]
+}`,
+		},
+	}
+
+	for _, tc := range testData {
+		opts := options.DefaultConfigGeneratorOptions()
+		opts.BackendAddress = "grpc://127.0.0.1:80"
+		opts.MaxRequestBodyBytes = tc.maxRequestBodyBytes
+		opts.BodySizeLimits = tc.bodySizeLimits
+		fakeServiceInfo, err := configinfo.NewServiceInfoFromServiceConfig(&confpb.Service{
+			Name: testProjectName,
+			Apis: []*apipb.Api{
+				{
+					Name: testApiName,
+					Methods: []*apipb.Method{
+						{
+							Name: "ListShelves",
+						},
+						{
+							Name:             "WatchShelves",
+							RequestStreaming: true,
+						},
+					},
+				},
+			},
+		}, testConfigID, opts)
+		if err != nil {
+			t.Fatalf("Test (%s): fail to create ServiceInfo: %v", tc.desc, err)
+		}
+
+		gotRoute, err := MakeRouteConfig(fakeServiceInfo)
+		if err != nil {
+			t.Fatalf("Test (%s): makeRouteConfig failed: %v", tc.desc, err)
+		}
+		gotJson, err := util.ProtoToJson(gotRoute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := util.JsonEqual(tc.wantRouteConfig, gotJson); err != nil {
+			t.Errorf("Test (%s): makeRouteConfig failed, %v", tc.desc, err)
+		}
+	}
+}
+
+func TestMakeRouteConfigForDeadlineAndRetry(t *testing.T) {
+	testData := []struct {
+		desc            string
+		backendRules    []*confpb.BackendRule
+		httpRules       []*annotationspb.HttpRule
+		backendRetry    []*options.BackendRetryOptions
+		wantRouteConfig string
+	}{
+		{
+			desc: "Local backend with per-operation deadline and default retry policy",
+			backendRules: []*confpb.BackendRule{
+				{
+					Selector: "endpoints.examples.bookstore.Bookstore.ListShelves",
+					Deadline: 10.5,
+				},
+			},
+			backendRetry: []*options.BackendRetryOptions{
+				{
+					Selector:   "*",
+					NumRetries: 2,
+					RetryOn:    "5xx,reset",
+				},
+			},
+			wantRouteConfig: `
+{
+  "name": "local_route",
+  "virtualHosts": [
+    {
+      "domains": ["*"],
+      "name": "backend",
+      "routes": [
+        {
+          "match": {
+            "headers": [{"exactMatch": "POST", "name": ":method"}],
+            "path": "/endpoints.examples.bookstore.Bookstore/ListShelves"
+          },
+          "route": {
+            "cluster": "bookstore.endpoints.project123.cloud.goog_local",
+            "retryPolicy": {"numRetries": 2, "retryOn": "5xx,reset"},
+            "timeout": "10.500s"
+          }
+        },
+        {
+          "match": {"prefix": "/"},
+          "route": {
+            "cluster": "bookstore.endpoints.project123.cloud.goog_local",
+            "retryPolicy": {"numRetries": 2, "retryOn": "5xx,reset"},
+            "timeout": "15s"
+          }
+        }
+      ]
+    }
+  ]
+}`,
+		},
+		{
+			desc: "Dynamic routing with a retry policy for an operation on the local backend",
+			backendRules: []*confpb.BackendRule{
+				{
+					Selector:        "endpoints.examples.bookstore.Bookstore.ListShelves",
+					Address:         "https://shelves.example.com",
+					PathTranslation: confpb.BackendRule_APPEND_PATH_TO_ADDRESS,
+				},
+			},
+			backendRetry: []*options.BackendRetryOptions{
+				{
+					Selector:      "endpoints.examples.bookstore.Bookstore.CreateShelf",
+					NumRetries:    3,
+					RetryOn:       "connect-failure",
+					PerTryTimeout: 1.5,
+				},
+			},
+			wantRouteConfig: `
+{
+  "name": "local_route",
+  "virtualHosts": [
+    {
+      "domains": ["*"],
+      "name": "backend",
+      "routes": [
+        {
+          "match": {
+            "headers": [{"exactMatch": "POST", "name": ":method"}],
+            "path": "/endpoints.examples.bookstore.Bookstore/ListShelves"
+          },
+          "route": {
+            "cluster": "shelves.example.com:443",
+            "hostRewrite": "shelves.example.com",
+            "timeout": "15s"
+          }
+        },
+        {
+          "match": {
+            "headers": [{"exactMatch": "POST", "name": ":method"}],
+            "path": "/endpoints.examples.bookstore.Bookstore/CreateShelf"
+          },
+          "route": {
+            "cluster": "bookstore.endpoints.project123.cloud.goog_local",
+            "retryPolicy": {"numRetries": 3, "perTryTimeout": "1.500s", "retryOn": "connect-failure"},
+            "timeout": "15s"
+          }
+        }
+      ]
+    }
+  ]
+}`,
+		},
+		{
+			desc: "Dynamic routing with hedging only on the routes of idempotent HTTP methods",
+			backendRules: []*confpb.BackendRule{
+				{
+					Selector:        "endpoints.examples.bookstore.Bookstore.ListShelves",
+					Address:         "https://shelves.example.com",
+					PathTranslation: confpb.BackendRule_APPEND_PATH_TO_ADDRESS,
+				},
+			},
+			httpRules: []*annotationspb.HttpRule{
+				{
+					Selector: "endpoints.examples.bookstore.Bookstore.ListShelves",
+					Pattern: &annotationspb.HttpRule_Get{
+						Get: "/v1/shelves",
+					},
+				},
+			},
+			backendRetry: []*options.BackendRetryOptions{
+				{
+					Selector:      "endpoints.examples.bookstore.Bookstore.ListShelves",
+					NumRetries:    2,
+					RetryOn:       "5xx",
+					PerTryTimeout: 0.2,
+					Hedge:         true,
+				},
+			},
+			wantRouteConfig: `
+{
+  "name": "local_route",
+  "virtualHosts": [
+    {
+      "domains": ["*"],
+      "name": "backend",
+      "routes": [
+        {
+          "match": {
+            "headers": [{"exactMatch": "GET", "name": ":method"}],
+            "path": "/v1/shelves"
+          },
+          "route": {
+            "cluster": "shelves.example.com:443",
+            "hedgePolicy": {"hedgeOnPerTryTimeout": true},
+            "hostRewrite": "shelves.example.com",
+            "retryPolicy": {"numRetries": 2, "perTryTimeout": "0.200s", "retryOn": "5xx"},
+            "timeout": "15s"
+          }
+        },
+        {
+          "match": {
+            "headers": [{"exactMatch": "POST", "name": ":method"}],
+            "path": "/endpoints.examples.bookstore.Bookstore/ListShelves"
+          },
+          "route": {
+            "cluster": "shelves.example.com:443",
+            "hostRewrite": "shelves.example.com",
+            "retryPolicy": {"numRetries": 2, "perTryTimeout": "0.200s", "retryOn": "5xx"},
+            "timeout": "15s"
+          }
+        }
+      ]
+    }
+  ]
+}`,
+		},
+	}
+
+	for _, tc := range testData {
+		opts := options.DefaultConfigGeneratorOptions()
+		opts.BackendAddress = "grpc://127.0.0.1:80"
+		opts.BackendRetry = tc.backendRetry
+		fakeServiceInfo, err := configinfo.NewServiceInfoFromServiceConfig(&confpb.Service{
+			Name: testProjectName,
+			Apis: []*apipb.Api{
+				{
+					Name: testApiName,
+					Methods: []*apipb.Method{
+						{
+							Name: "ListShelves",
+						},
+						{
+							Name: "CreateShelf",
+						},
+					},
+				},
+			},
+			Backend: &confpb.Backend{
+				Rules: tc.backendRules,
+			},
+			Http: &annotationspb.Http{
+				Rules: tc.httpRules,
+			},
+		}, testConfigID, opts)
+		if err != nil {
+			t.Fatalf("Test (%s): fail to create ServiceInfo: %v", tc.desc, err)
+		}
+
+		gotRoute, err := MakeRouteConfig(fakeServiceInfo)
+		if err != nil {
+			t.Fatalf("Test (%s): makeRouteConfig failed: %v", tc.desc, err)
+		}
+		gotJson, err := util.ProtoToJson(gotRoute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := util.JsonEqual(tc.wantRouteConfig, gotJson); err != nil {
+			t.Errorf("Test (%s): makeRouteConfig failed, %v", tc.desc, err)
+		}
+	}
+}
+
+func TestMakeRouteConfigForBackendSplits(t *testing.T) {
+	backendSplits := []*options.BackendSplitOptions{
+		{
+			Selector: "endpoints.examples.bookstore.Bookstore.ListShelves",
+			Backends: []*options.WeightedBackendOptions{
+				{
+					Name:    "v1",
+					Address: "https://v1.example.com",
+					Weight:  90,
+				},
+				{
+					Name:    "v2",
+					Address: "https://v2.example.com",
+					Weight:  10,
+				},
+			},
+		},
+	}
+	testData := []struct {
+		desc               string
+		backendRules       []*confpb.BackendRule
+		backendSplitHeader string
+		wantRouteConfig    string
+	}{
+		{
+			desc: "Dynamic routing split between weighted backends, forced by header",
+			backendRules: []*confpb.BackendRule{
+				{
+					Selector:        "endpoints.examples.bookstore.Bookstore.ListShelves",
+					Address:         "https://shelves.example.com",
+					PathTranslation: confpb.BackendRule_APPEND_PATH_TO_ADDRESS,
+				},
+			},
+			backendSplitHeader: "x-backend-version",
+			wantRouteConfig: `
+{
+  "name": "local_route",
+  "virtualHosts": [
+    {
+      "domains": ["*"],
+      "name": "backend",
+      "routes": [
+        {
+          "match": {
+            "headers": [
+              {"exactMatch": "POST", "name": ":method"},
+              {"exactMatch": "v1", "name": "x-backend-version"}
+            ],
+            "path": "/endpoints.examples.bookstore.Bookstore/ListShelves"
+          },
+          "route": {
+            "autoHostRewrite": true,
+            "cluster": "v1.example.com:443",
+            "timeout": "15s"
+          }
+        },
+        {
+          "match": {
+            "headers": [
+              {"exactMatch": "POST", "name": ":method"},
+              {"exactMatch": "v2", "name": "x-backend-version"}
+            ],
+            "path": "/endpoints.examples.bookstore.Bookstore/ListShelves"
+          },
+          "route": {
+            "autoHostRewrite": true,
+            "cluster": "v2.example.com:443",
+            "timeout": "15s"
+          }
+        },
+        {
+          "match": {
+            "headers": [{"exactMatch": "POST", "name": ":method"}],
+            "path": "/endpoints.examples.bookstore.Bookstore/ListShelves"
+          },
+          "route": {
+            "autoHostRewrite": true,
+            "timeout": "15s",
+            "weightedClusters": {
+              "clusters": [
+                {"name": "v1.example.com:443", "weight": 90},
+                {"name": "v2.example.com:443", "weight": 10}
+              ],
+              "totalWeight": 100
+            }
+          }
+        }
+      ]
+    }
+  ]
+}`,
+		},
+		{
+			desc: "Local backend operation split between weighted backends",
+			wantRouteConfig: `
+{
+  "name": "local_route",
+  "virtualHosts": [
+    {
+      "domains": ["*"],
+      "name": "backend",
+      "routes": [
+        {
+          "match": {
+            "headers": [{"exactMatch": "POST", "name": ":method"}],
+            "path": "/endpoints.examples.bookstore.Bookstore/ListShelves"
+          },
+          "route": {
+            "autoHostRewrite": true,
+            "timeout": "15s",
+            "weightedClusters": {
+              "clusters": [
+                {"name": "v1.example.com:443", "weight": 90},
+                {"name": "v2.example.com:443", "weight": 10}
+              ],
+              "totalWeight": 100
+            }
+          }
+        },
+        {
+          "match": {"prefix": "/"},
+          "route": {
+            "cluster": "bookstore.endpoints.project123.cloud.goog_local",
+            "timeout": "15s"
+          }
+        }
+      ]
+    }
+  ]
+}`,
+		},
+	}
+
+	for _, tc := range testData {
+		opts := options.DefaultConfigGeneratorOptions()
+		opts.BackendAddress = "grpc://127.0.0.1:80"
+		opts.BackendSplits = backendSplits
+		opts.BackendSplitHeader = tc.backendSplitHeader
+		fakeServiceInfo, err := configinfo.NewServiceInfoFromServiceConfig(&confpb.Service{
+			Name: testProjectName,
+			Apis: []*apipb.Api{
+				{
+					Name: testApiName,
+					Methods: []*apipb.Method{
+						{
+							Name: "ListShelves",
+						},
+					},
+				},
+			},
+			Backend: &confpb.Backend{
+				Rules: tc.backendRules,
+			},
+		}, testConfigID, opts)
+		if err != nil {
+			t.Fatalf("Test (%s): fail to create ServiceInfo: %v", tc.desc, err)
+		}
+
+		gotRoute, err := MakeRouteConfig(fakeServiceInfo)
+		if err != nil {
+			t.Fatalf("Test (%s): makeRouteConfig failed: %v", tc.desc, err)
+		}
+		gotJson, err := util.ProtoToJson(gotRoute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := util.JsonEqual(tc.wantRouteConfig, gotJson); err != nil {
+			t.Errorf("Test (%s): makeRouteConfig failed, %v", tc.desc, err)
+		}
+	}
+}
+
+func TestMakeRouteConfigForJwtAudiences(t *testing.T) {
+	testData := []struct {
+		desc            string
+		audiences       string
+		wantRouteConfig string
+	}{
+		{
+			desc:      "Exact audiences are matched by the JWT Authn filter",
+			audiences: "https://api.example.com",
+			wantRouteConfig: `
+{
+  "name": "local_route",
+  "virtualHosts": [
+    {
+      "domains": ["*"],
+      "name": "backend",
+      "routes": [
+        {
+          "match": {"prefix": "/"},
+          "route": {
+            "cluster": "bookstore.endpoints.project123.cloud.goog_local",
+            "timeout": "15s"
+          }
+        }
+      ]
+    }
+  ]
+}`,
+		},
+		{
+			desc:      "Wildcard and regex audiences are matched on the routes",
+			audiences: "https://api.example.com,https://*.example.com,regex:https://(dev|prod)\\.example\\.org",
+			wantRouteConfig: `
+{
+  "name": "local_route",
+  "virtualHosts": [
+    {
+      "domains": ["*"],
+      "name": "backend",
+      "routes": [
+        {
+          "match": {
+            "headers": [{"exactMatch": "POST", "name": ":method"}],
+            "path": "/endpoints.examples.bookstore.Bookstore/ListShelves"
+          },
+          "route": {
+            "cluster": "bookstore.endpoints.project123.cloud.goog_local",
+            "timeout": "15s"
+          },
+          "typedPerFilterConfig": {
+            "envoy.filters.http.rbac": {
+              "@type": "type.googleapis.com/envoy.config.filter.http.rbac.v2.RBACPerRoute",
+              "rbac": {
+                "rules": {
+                  "policies": {
+                    "jwt_audiences": {
+                      "permissions": [{"any": true}],
+                      "principals": [
+                        {
+                          "metadata": {
+                            "filter": "envoy.filters.http.jwt_authn",
+                            "path": [{"key": "jwt_payloads"}, {"key": "aud"}],
+                            "value": {
+                              "stringMatch": {
+                                "safeRegex": {
+                                  "googleRe2": {"maxProgramSize": 1000},
+                                  "regex": "https://api\\.example\\.com|(?:https://[^/]*\\.example\\.com)|(?:https://(dev|prod)\\.example\\.org)"
+                                }
+                              }
+                            }
+                          }
+                        },
+                        {
+                          "metadata": {
+                            "filter": "envoy.filters.http.jwt_authn",
+                            "path": [{"key": "jwt_payloads"}, {"key": "aud"}],
+                            "value": {
+                              "listMatch": {
+                                "oneOf": {
+                                  "stringMatch": {
+                                    "safeRegex": {
+                                      "googleRe2": {"maxProgramSize": 1000},
+                                      "regex": "https://api\\.example\\.com|(?:https://[^/]*\\.example\\.com)|(?:https://(dev|prod)\\.example\\.org)"
+                                    }
+                                  }
+                                }
+                              }
+                            }
+                          }
+                        }
+                      ]
+                    }
+                  }
+                }
+              }
+            }
+          }
+        },
+        {
+          "match": {"prefix": "/"},
+          "route": {
+            "cluster": "bookstore.endpoints.project123.cloud.goog_local",
+            "timeout": "15s"
+          }
+        }
+      ]
+    }
+  ]
+}`,
+		},
+	}
+
+	for _, tc := range testData {
+		opts := options.DefaultConfigGeneratorOptions()
+		opts.BackendAddress = "grpc://127.0.0.1:80"
+		fakeServiceInfo, err := configinfo.NewServiceInfoFromServiceConfig(&confpb.Service{
+			Name: testProjectName,
+			Apis: []*apipb.Api{
+				{
+					Name: testApiName,
+					Methods: []*apipb.Method{
+						{
+							Name: "ListShelves",
+						},
+						{
+							Name: "GetShelf",
+						},
+					},
+				},
+			},
+			Authentication: &confpb.Authentication{
+				Providers: []*confpb.AuthProvider{
+					{
+						Id:        "auth_provider",
+						Issuer:    "issuer-0",
+						JwksUri:   "https://fake-jwks.com",
+						Audiences: tc.audiences,
+					},
+				},
+				Rules: []*confpb.AuthenticationRule{
+					{
+						Selector: "endpoints.examples.bookstore.Bookstore.ListShelves",
+						Requirements: []*confpb.AuthRequirement{
+							{
+								ProviderId: "auth_provider",
+							},
+						},
+					},
+				},
+			},
+		}, testConfigID, opts)
+		if err != nil {
+			t.Fatalf("Test (%s): fail to create ServiceInfo: %v", tc.desc, err)
+		}
+
+		gotRoute, err := MakeRouteConfig(fakeServiceInfo)
+		if err != nil {
+			t.Fatalf("Test (%s): makeRouteConfig failed: %v", tc.desc, err)
+		}
+		gotJson, err := util.ProtoToJson(gotRoute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := util.JsonEqual(tc.wantRouteConfig, gotJson); err != nil {
+			t.Errorf("Test (%s): makeRouteConfig failed, %v", tc.desc, err)
+		}
+	}
+}
+
+func TestMakeRouteConfigForJwtClaimHeaders(t *testing.T) {
+	testData := []struct {
+		desc            string
+		jwtClaimHeaders string
+		wantRouteConfig string
+	}{
+		{
+			desc: "No claims are forwarded by default",
+			wantRouteConfig: `
+{
+  "name": "local_route",
+  "virtualHosts": [
+    {
+      "domains": ["*"],
+      "name": "backend",
+      "routes": [
+        {
+          "match": {"prefix": "/"},
+          "route": {
+            "cluster": "bookstore.endpoints.project123.cloud.goog_local",
+            "timeout": "15s"
+          }
+        }
+      ]
+    }
+  ]
+}`,
+		},
+		{
+			desc:            "Claims are forwarded for the operations requiring JWTs",
+			jwtClaimHeaders: "sub=X-User-Id,google.project_id=x-project-id",
+			wantRouteConfig: `
+{
+  "name": "local_route",
+  "virtualHosts": [
+    {
+      "domains": ["*"],
+      "name": "backend",
+      "routes": [
+        {
+          "match": {
+            "headers": [{"exactMatch": "POST", "name": ":method"}],
+            "path": "/endpoints.examples.bookstore.Bookstore/ListShelves"
+          },
+          "requestHeadersToAdd": [
+            {
+              "append": false,
+              "header": {
+                "key": "x-project-id",
+                "value": "%DYNAMIC_METADATA([\"envoy.filters.http.jwt_authn\",\"jwt_payloads\",\"google\",\"project_id\"])%"
+              }
+            },
+            {
+              "append": false,
+              "header": {
+                "key": "x-user-id",
+                "value": "%DYNAMIC_METADATA([\"envoy.filters.http.jwt_authn\",\"jwt_payloads\",\"sub\"])%"
+              }
+            }
+          ],
+          "requestHeadersToRemove": ["x-project-id", "x-user-id"],
+          "route": {
+            "cluster": "bookstore.endpoints.project123.cloud.goog_local",
+            "timeout": "15s"
+          }
+        },
+        {
+          "match": {"prefix": "/"},
+          "route": {
+            "cluster": "bookstore.endpoints.project123.cloud.goog_local",
+            "timeout": "15s"
+          }
+        }
+      ]
+    }
+  ]
+}`,
+		},
+	}
+
+	for _, tc := range testData {
+		opts := options.DefaultConfigGeneratorOptions()
+		opts.BackendAddress = "grpc://127.0.0.1:80"
+		opts.JwtClaimHeaders = tc.jwtClaimHeaders
+		fakeServiceInfo, err := configinfo.NewServiceInfoFromServiceConfig(&confpb.Service{
+			Name: testProjectName,
+			Apis: []*apipb.Api{
+				{
+					Name: testApiName,
+					Methods: []*apipb.Method{
+						{
+							Name: "ListShelves",
+						},
+						{
+							Name: "GetShelf",
+						},
+					},
+				},
+			},
+			Authentication: &confpb.Authentication{
+				Providers: []*confpb.AuthProvider{
+					{
+						Id:      "auth_provider",
+						Issuer:  "issuer-0",
+						JwksUri: "https://fake-jwks.com",
+					},
+				},
+				Rules: []*confpb.AuthenticationRule{
+					{
+						Selector: "endpoints.examples.bookstore.Bookstore.ListShelves",
+						Requirements: []*confpb.AuthRequirement{
+							{
+								ProviderId: "auth_provider",
+							},
+						},
+					},
+				},
+			},
+		}, testConfigID, opts)
+		if err != nil {
+			t.Fatalf("Test (%s): fail to create ServiceInfo: %v", tc.desc, err)
+		}
+
+		gotRoute, err := MakeRouteConfig(fakeServiceInfo)
+		if err != nil {
+			t.Fatalf("Test (%s): makeRouteConfig failed: %v", tc.desc, err)
+		}
+		gotJson, err := util.ProtoToJson(gotRoute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := util.JsonEqual(tc.wantRouteConfig, gotJson); err != nil {
+			t.Errorf("Test (%s): makeRouteConfig failed, %v", tc.desc, err)
+		}
+	}
+}
+
+func TestMakeRouteConfigForAuthorizationRules(t *testing.T) {
+	testData := []struct {
+		desc            string
+		audiences       string
+		wantRouteConfig string
+	}{
+		{
+			desc: "Authorization policies are matched on the routes",
+			wantRouteConfig: `
+{
+  "name": "local_route",
+  "virtualHosts": [
+    {
+      "domains": ["*"],
+      "name": "backend",
+      "routes": [
+        {
+          "match": {
+            "headers": [{"exactMatch": "POST", "name": ":method"}],
+            "path": "/endpoints.examples.bookstore.Bookstore/ListShelves"
+          },
+          "route": {
+            "cluster": "bookstore.endpoints.project123.cloud.goog_local",
+            "timeout": "15s"
+          },
+          "typedPerFilterConfig": {
+            "envoy.filters.http.rbac": {
+              "@type": "type.googleapis.com/envoy.config.filter.http.rbac.v2.RBACPerRoute",
+              "rbac": {
+                "rules": {
+                  "policies": {
+                    "authorization_0": {
+                      "permissions": [{"any": true}],
+                      "principals": [
+                        {
+                          "andIds": {
+                            "ids": [
+                              {
+                                "orIds": {
+                                  "ids": [
+                                    {
+                                      "metadata": {
+                                        "filter": "envoy.filters.http.jwt_authn",
+                                        "path": [{"key": "jwt_payloads"}, {"key": "roles"}],
+                                        "value": {"stringMatch": {"safeRegex": {"googleRe2": {"maxProgramSize": 1000}, "regex": "admin|editor\\.all"}}}
+                                      }
+                                    },
+                                    {
+                                      "metadata": {
+                                        "filter": "envoy.filters.http.jwt_authn",
+                                        "path": [{"key": "jwt_payloads"}, {"key": "roles"}],
+                                        "value": {"listMatch": {"oneOf": {"stringMatch": {"safeRegex": {"googleRe2": {"maxProgramSize": 1000}, "regex": "admin|editor\\.all"}}}}}
+                                      }
+                                    }
+                                  ]
+                                }
+                              }
+                            ]
+                          }
+                        }
+                      ]
+                    }
+                  }
+                }
+              }
+            }
+          }
+        },
+        {
+          "match": {"prefix": "/"},
+          "route": {
+            "cluster": "bookstore.endpoints.project123.cloud.goog_local",
+            "timeout": "15s"
+          }
+        }
+      ]
+    }
+  ]
+}`,
+		},
+		{
+			desc:      "Authorization policies require the wildcard audiences",
+			audiences: "https://*.example.com",
+			wantRouteConfig: `
+{
+  "name": "local_route",
+  "virtualHosts": [
+    {
+      "domains": ["*"],
+      "name": "backend",
+      "routes": [
+        {
+          "match": {
+            "headers": [{"exactMatch": "POST", "name": ":method"}],
+            "path": "/endpoints.examples.bookstore.Bookstore/ListShelves"
+          },
+          "route": {
+            "cluster": "bookstore.endpoints.project123.cloud.goog_local",
+            "timeout": "15s"
+          },
+          "typedPerFilterConfig": {
+            "envoy.filters.http.rbac": {
+              "@type": "type.googleapis.com/envoy.config.filter.http.rbac.v2.RBACPerRoute",
+              "rbac": {
+                "rules": {
+                  "policies": {
+                    "authorization_0": {
+                      "permissions": [{"any": true}],
+                      "principals": [
+                        {
+                          "andIds": {
+                            "ids": [
+                              {
+                                "orIds": {
+                                  "ids": [
+                                    {
+                                      "metadata": {
+                                        "filter": "envoy.filters.http.jwt_authn",
+                                        "path": [{"key": "jwt_payloads"}, {"key": "aud"}],
+                                        "value": {"stringMatch": {"safeRegex": {"googleRe2": {"maxProgramSize": 1000}, "regex": "(?:https://[^/]*\\.example\\.com)"}}}
+                                      }
+                                    },
+                                    {
+                                      "metadata": {
+                                        "filter": "envoy.filters.http.jwt_authn",
+                                        "path": [{"key": "jwt_payloads"}, {"key": "aud"}],
+                                        "value": {"listMatch": {"oneOf": {"stringMatch": {"safeRegex": {"googleRe2": {"maxProgramSize": 1000}, "regex": "(?:https://[^/]*\\.example\\.com)"}}}}}
+                                      }
+                                    }
+                                  ]
+                                }
+                              },
+                              {
+                                "orIds": {
+                                  "ids": [
+                                    {
+                                      "metadata": {
+                                        "filter": "envoy.filters.http.jwt_authn",
+                                        "path": [{"key": "jwt_payloads"}, {"key": "roles"}],
+                                        "value": {"stringMatch": {"safeRegex": {"googleRe2": {"maxProgramSize": 1000}, "regex": "admin|editor\\.all"}}}
+                                      }
+                                    },
+                                    {
+                                      "metadata": {
+                                        "filter": "envoy.filters.http.jwt_authn",
+                                        "path": [{"key": "jwt_payloads"}, {"key": "roles"}],
+                                        "value": {"listMatch": {"oneOf": {"stringMatch": {"safeRegex": {"googleRe2": {"maxProgramSize": 1000}, "regex": "admin|editor\\.all"}}}}}
+                                      }
+                                    }
+                                  ]
+                                }
+                              }
+                            ]
+                          }
+                        }
+                      ]
+                    }
+                  }
+                }
+              }
+            }
+          }
+        },
+        {
+          "match": {"prefix": "/"},
+          "route": {
+            "cluster": "bookstore.endpoints.project123.cloud.goog_local",
+            "timeout": "15s"
+          }
+        }
+      ]
+    }
+  ]
+}`,
+		},
+	}
+
+	for _, tc := range testData {
+		opts := options.DefaultConfigGeneratorOptions()
+		opts.BackendAddress = "grpc://127.0.0.1:80"
+		opts.AuthorizationRules = []*options.AuthorizationRuleOptions{
+			{
+				Selector: "endpoints.examples.bookstore.Bookstore.ListShelves",
+				Allow: []*options.AuthorizationPolicyOptions{
+					{
+						Claims: map[string][]string{"roles": {"admin", "editor.all"}},
+					},
+				},
+			},
+		}
+		fakeServiceInfo, err := configinfo.NewServiceInfoFromServiceConfig(&confpb.Service{
+			Name: testProjectName,
+			Apis: []*apipb.Api{
+				{
+					Name: testApiName,
+					Methods: []*apipb.Method{
+						{
+							Name: "ListShelves",
+						},
+						{
+							Name: "GetShelf",
+						},
+					},
+				},
+			},
+			Authentication: &confpb.Authentication{
+				Providers: []*confpb.AuthProvider{
+					{
+						Id:        "auth_provider",
+						Issuer:    "issuer-0",
+						JwksUri:   "https://fake-jwks.com",
+						Audiences: tc.audiences,
+					},
+				},
+				Rules: []*confpb.AuthenticationRule{
+					{
+						Selector: "endpoints.examples.bookstore.Bookstore.ListShelves",
+						Requirements: []*confpb.AuthRequirement{
+							{
+								ProviderId: "auth_provider",
+							},
+						},
+					},
+				},
+			},
+		}, testConfigID, opts)
+		if err != nil {
+			t.Fatalf("Test (%s): fail to create ServiceInfo: %v", tc.desc, err)
+		}
+
+		gotRoute, err := MakeRouteConfig(fakeServiceInfo)
+		if err != nil {
+			t.Fatalf("Test (%s): makeRouteConfig failed: %v", tc.desc, err)
+		}
+		gotJson, err := util.ProtoToJson(gotRoute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := util.JsonEqual(tc.wantRouteConfig, gotJson); err != nil {
+			t.Errorf("Test (%s): makeRouteConfig failed, %v", tc.desc, err)
+		}
+	}
+}
+
+func TestMakeRouteConfigForExtAuthz(t *testing.T) {
+	opts := options.DefaultConfigGeneratorOptions()
+	opts.BackendAddress = "grpc://127.0.0.1:80"
+	opts.ExtAuthzUri = "grpc://authz.example.com:9000"
+	opts.ExtAuthzDisabledSelectors = "endpoints.examples.bookstore.Bookstore.ListShelves"
+	fakeServiceInfo, err := configinfo.NewServiceInfoFromServiceConfig(&confpb.Service{
+		Name: testProjectName,
+		Apis: []*apipb.Api{
+			{
+				Name: testApiName,
+				Methods: []*apipb.Method{
+					{
+						Name: "ListShelves",
+					},
+					{
+						Name: "GetShelf",
+					},
+				},
+			},
+		},
+	}, testConfigID, opts)
+	if err != nil {
+		t.Fatalf("fail to create ServiceInfo: %v", err)
+	}
+
+	wantRouteConfig := `
+{
+  "name": "local_route",
+  "virtualHosts": [
+    {
+      "domains": ["*"],
+      "name": "backend",
+      "routes": [
+        {
+          "match": {
+            "headers": [{"exactMatch": "POST", "name": ":method"}],
+            "path": "/endpoints.examples.bookstore.Bookstore/ListShelves"
+          },
+          "route": {
+            "cluster": "bookstore.endpoints.project123.cloud.goog_local",
+            "timeout": "15s"
+          },
+          "typedPerFilterConfig": {
+            "envoy.filters.http.ext_authz": {
+              "@type": "type.googleapis.com/envoy.config.filter.http.ext_authz.v2.ExtAuthzPerRoute",
+              "disabled": true
+            }
+          }
+        },
+        {
+          "match": {"prefix": "/"},
+          "route": {
+            "cluster": "bookstore.endpoints.project123.cloud.goog_local",
+            "timeout": "15s"
+          }
+        }
+      ]
+    }
+  ]
+}`
+	gotRoute, err := MakeRouteConfig(fakeServiceInfo)
+	if err != nil {
+		t.Fatalf("makeRouteConfig failed: %v", err)
+	}
+	gotJson, err := util.ProtoToJson(gotRoute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := util.JsonEqual(wantRouteConfig, gotJson); err != nil {
+		t.Errorf("makeRouteConfig failed, %v", err)
+	}
+}
+
+func TestMakeRouteConfigForTracingSampleRates(t *testing.T) {
+	opts := options.DefaultConfigGeneratorOptions()
+	opts.BackendAddress = "grpc://127.0.0.1:80"
+	opts.TracingOperationSampleRates = "endpoints.examples.bookstore.Bookstore.ListShelves=0.25"
+	fakeServiceInfo, err := configinfo.NewServiceInfoFromServiceConfig(&confpb.Service{
+		Name: testProjectName,
+		Apis: []*apipb.Api{
+			{
+				Name: testApiName,
+				Methods: []*apipb.Method{
+					{
+						Name: "ListShelves",
+					},
+					{
+						Name: "GetShelf",
+					},
+				},
+			},
+		},
+	}, testConfigID, opts)
+	if err != nil {
+		t.Fatalf("fail to create ServiceInfo: %v", err)
+	}
+
+	wantRouteConfig := `
+{
+  "name": "local_route",
+  "virtualHosts": [
+    {
+      "domains": ["*"],
+      "name": "backend",
+      "routes": [
+        {
+          "match": {
+            "headers": [{"exactMatch": "POST", "name": ":method"}],
+            "path": "/endpoints.examples.bookstore.Bookstore/ListShelves"
+          },
+          "route": {
+            "cluster": "bookstore.endpoints.project123.cloud.goog_local",
+            "timeout": "15s"
+          },
+          "tracing": {
+            "randomSampling": {"numerator": 250000, "denominator": "MILLION"}
+          }
+        },
+        {
+          "match": {"prefix": "/"},
+          "route": {
+            "cluster": "bookstore.endpoints.project123.cloud.goog_local",
+            "timeout": "15s"
+          }
+        }
+      ]
+    }
+  ]
+}`
+	gotRoute, err := MakeRouteConfig(fakeServiceInfo)
+	if err != nil {
+		t.Fatalf("makeRouteConfig failed: %v", err)
+	}
+	gotJson, err := util.ProtoToJson(gotRoute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := util.JsonEqual(wantRouteConfig, gotJson); err != nil {
+		t.Errorf("makeRouteConfig failed, %v", err)
+	}
+}
+
+func TestMakeRouteConfigForAccessLogDisabledSelectors(t *testing.T) {
+	opts := options.DefaultConfigGeneratorOptions()
+	opts.BackendAddress = "grpc://127.0.0.1:80"
+	opts.AccessLogPath = "/dev/stdout"
+	opts.AccessLogDisabledSelectors = "endpoints.examples.bookstore.Bookstore.ListShelves"
+	fakeServiceInfo, err := configinfo.NewServiceInfoFromServiceConfig(&confpb.Service{
+		Name: testProjectName,
+		Apis: []*apipb.Api{
+			{
+				Name: testApiName,
+				Methods: []*apipb.Method{
+					{
+						Name: "ListShelves",
+					},
+					{
+						Name: "GetShelf",
+					},
+				},
+			},
+		},
+	}, testConfigID, opts)
+	if err != nil {
+		t.Fatalf("fail to create ServiceInfo: %v", err)
+	}
+
+	wantRouteConfig := `
+{
+  "name": "local_route",
+  "virtualHosts": [
+    {
+      "domains": ["*"],
+      "name": "backend",
+      "routes": [
+        {
+          "match": {
+            "headers": [{"exactMatch": "POST", "name": ":method"}],
+            "path": "/endpoints.examples.bookstore.Bookstore/ListShelves"
+          },
+          "route": {
+            "cluster": "bookstore.endpoints.project123.cloud.goog_local",
+            "timeout": "15s"
+          },
+          "requestHeadersToAdd": [
+            {
+              "append": false,
+              "header": {"key": "x-esp-v2-access-log-disabled", "value": "true"}
+            }
+          ]
+        },
+        {
+          "match": {"prefix": "/"},
+          "route": {
+            "cluster": "bookstore.endpoints.project123.cloud.goog_local",
+            "timeout": "15s"
+          }
+        }
+      ]
+    }
+  ]
+}`
+	gotRoute, err := MakeRouteConfig(fakeServiceInfo)
+	if err != nil {
+		t.Fatalf("makeRouteConfig failed: %v", err)
+	}
+	gotJson, err := util.ProtoToJson(gotRoute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := util.JsonEqual(wantRouteConfig, gotJson); err != nil {
+		t.Errorf("makeRouteConfig failed, %v", err)
+	}
+}
+
+func TestMakeRouteConfigForHeaderRules(t *testing.T) {
+	opts := options.DefaultConfigGeneratorOptions()
+	opts.BackendAddress = "grpc://127.0.0.1:80"
+	opts.HeaderRules = []*options.HeaderRuleOptions{
+		{
+			Selector:                "*",
+			ResponseHeadersToRemove: []string{"server"},
+		},
+		{
+			Selector: "endpoints.examples.bookstore.Bookstore.ListShelves",
+			RequestHeadersToAdd: []*options.HeaderValueOptions{
+				{
+					Name:      "x-env",
+					Value:     "prod",
+					Overwrite: true,
+				},
+			},
+			RequestHeadersToRemove: []string{"x-debug"},
+			ResponseHeadersToAdd: []*options.HeaderValueOptions{
+				{
+					Name:  "x-served-by",
+					Value: "esp-v2",
+				},
+			},
+		},
+	}
+	fakeServiceInfo, err := configinfo.NewServiceInfoFromServiceConfig(&confpb.Service{
+		Name: testProjectName,
+		Apis: []*apipb.Api{
+			{
+				Name: testApiName,
+				Methods: []*apipb.Method{
+					{
+						Name: "ListShelves",
+					},
+					{
+						Name: "GetShelf",
+					},
+				},
+			},
+		},
+	}, testConfigID, opts)
+	if err != nil {
+		t.Fatalf("fail to create ServiceInfo: %v", err)
+	}
+
+	wantRouteConfig := `
+{
+  "name": "local_route",
+  "mostSpecificHeaderMutationsWins": true,
+  "virtualHosts": [
+    {
+      "domains": ["*"],
+      "name": "backend",
+      "responseHeadersToRemove": ["server"],
+      "routes": [
+        {
+          "match": {
+            "headers": [{"exactMatch": "POST", "name": ":method"}],
+            "path": "/endpoints.examples.bookstore.Bookstore/ListShelves"
+          },
+          "route": {
+            "cluster": "bookstore.endpoints.project123.cloud.goog_local",
+            "timeout": "15s"
+          },
+          "requestHeadersToAdd": [
+            {
+              "append": false,
+              "header": {"key": "x-env", "value": "prod"}
+            }
+          ],
+          "requestHeadersToRemove": ["x-debug"],
+          "responseHeadersToAdd": [
+            {
+              "append": true,
+              "header": {"key": "x-served-by", "value": "esp-v2"}
+            }
+          ]
+        },
+        {
+          "match": {"prefix": "/"},
+          "route": {
+            "cluster": "bookstore.endpoints.project123.cloud.goog_local",
+            "timeout": "15s"
+          }
+        }
+      ]
+    }
+  ]
+}`
+	gotRoute, err := MakeRouteConfig(fakeServiceInfo)
+	if err != nil {
+		t.Fatalf("makeRouteConfig failed: %v", err)
+	}
+	gotJson, err := util.ProtoToJson(gotRoute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := util.JsonEqual(wantRouteConfig, gotJson); err != nil {
+		t.Errorf("makeRouteConfig failed, %v", err)
+	}
+}
+
+func TestMakeRouteConfigForRequestIdHeader(t *testing.T) {
+	opts := options.DefaultConfigGeneratorOptions()
+	opts.BackendAddress = "grpc://127.0.0.1:80"
+	opts.RequestIdHeader = "x-correlation-id"
+	fakeServiceInfo, err := configinfo.NewServiceInfoFromServiceConfig(&confpb.Service{
+		Name: testProjectName,
+		Apis: []*apipb.Api{
+			{
+				Name: testApiName,
+				Methods: []*apipb.Method{
+					{
+						Name: "ListShelves",
+					},
+				},
+			},
+		},
+	}, testConfigID, opts)
+	if err != nil {
+		t.Fatalf("fail to create ServiceInfo: %v", err)
+	}
+
+	wantRouteConfig := `
+{
+  "name": "local_route",
+  "virtualHosts": [
+    {
+      "domains": ["*"],
+      "name": "backend",
+      "requestHeadersToAdd": [
+        {
+          "append": false,
+          "header": {"key": "x-correlation-id", "value": "%REQ(x-request-id)%"}
+        }
+      ],
+      "responseHeadersToAdd": [
+        {
+          "append": false,
+          "header": {"key": "x-correlation-id", "value": "%REQ(x-request-id)%"}
+        }
+      ],
+      "routes": [
+        {
+          "match": {"prefix": "/"},
+          "route": {
+            "cluster": "bookstore.endpoints.project123.cloud.goog_local",
+            "timeout": "15s"
+          }
+        }
+      ]
+    }
+  ]
+}`
+	gotRoute, err := MakeRouteConfig(fakeServiceInfo)
+	if err != nil {
+		t.Fatalf("makeRouteConfig failed: %v", err)
+	}
+	gotJson, err := util.ProtoToJson(gotRoute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := util.JsonEqual(wantRouteConfig, gotJson); err != nil {
+		t.Errorf("makeRouteConfig failed, %v", err)
+	}
+}
+
+func TestMakeRouteConfigForPathRewrites(t *testing.T) {
+	opts := options.DefaultConfigGeneratorOptions()
+	opts.BackendAddress = "http://127.0.0.1:80"
+	opts.PathRewrites = []*options.PathRewriteOptions{
+		{
+			Selector:     "endpoints.examples.bookstore.Bookstore.GetShelf",
+			Regex:        "^/v1/shelves/([0-9]+)$",
+			Substitution: `/shelf/\1`,
+		},
+		{
+			Selector:      "endpoints.examples.bookstore.Bookstore.ListShelves",
+			Prefix:        "/v1/",
+			PrefixRewrite: "/api/",
+		},
+	}
+	fakeServiceInfo, err := configinfo.NewServiceInfoFromServiceConfig(&confpb.Service{
+		Name: testProjectName,
+		Apis: []*apipb.Api{
+			{
+				Name: testApiName,
+				Methods: []*apipb.Method{
+					{
+						Name: "ListShelves",
+					},
+					{
+						Name: "GetShelf",
+					},
+				},
+			},
+		},
+		Http: &annotationspb.Http{
+			Rules: []*annotationspb.HttpRule{
+				{
+					Selector: "endpoints.examples.bookstore.Bookstore.ListShelves",
+					Pattern: &annotationspb.HttpRule_Get{
+						Get: "/v1/shelves",
+					},
+				},
+				{
+					Selector: "endpoints.examples.bookstore.Bookstore.GetShelf",
+					Pattern: &annotationspb.HttpRule_Get{
+						Get: "/v1/shelves/{shelf}",
+					},
+				},
+			},
+		},
+	}, testConfigID, opts)
+	if err != nil {
+		t.Fatalf("fail to create ServiceInfo: %v", err)
+	}
+
+	wantRouteConfig := `
+{
+  "name": "local_route",
+  "virtualHosts": [
+    {
+      "domains": ["*"],
+      "name": "backend",
+      "routes": [
+        {
+          "match": {
+            "headers": [{"exactMatch": "GET", "name": ":method"}],
+            "safeRegex": {"googleRe2": {"maxProgramSize": 1000}, "regex": "^/v1/shelves/([0-9]+)$"}
+          },
+          "route": {
+            "cluster": "bookstore.endpoints.project123.cloud.goog_local",
+            "timeout": "15s"
+          }
+        },
+        {
+          "match": {
+            "headers": [{"exactMatch": "GET", "name": ":method"}],
+            "path": "/v1/shelves"
+          },
+          "route": {
+            "cluster": "bookstore.endpoints.project123.cloud.goog_local",
+            "timeout": "15s"
+          }
+        },
+        {
+          "match": {"prefix": "/"},
+          "route": {
+            "cluster": "bookstore.endpoints.project123.cloud.goog_local",
+            "timeout": "15s"
+          }
+        }
+      ]
+    }
+  ]
+}`
+	gotRoute, err := MakeRouteConfig(fakeServiceInfo)
+	if err != nil {
+		t.Fatalf("makeRouteConfig failed: %v", err)
+	}
+	gotJson, err := util.ProtoToJson(gotRoute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := util.JsonEqual(wantRouteConfig, gotJson); err != nil {
+		t.Errorf("makeRouteConfig failed, %v", err)
+	}
+}
+
+func TestMakeRouteConfigForCorsPolicies(t *testing.T) {
+	opts := options.DefaultConfigGeneratorOptions()
+	opts.BackendAddress = "grpc://127.0.0.1:80"
+	opts.CorsPreset = "basic"
+	opts.CorsAllowOrigin = "http://example.com"
+	opts.CorsAllowMethods = "GET,POST"
+	opts.CorsPolicies = []*options.CorsPolicyOptions{
+		{
+			Selector:           "*",
+			AllowOriginRegexes: []string{`https://.*\.example\.com`},
+		},
+		{
+			Selector:           "endpoints.examples.bookstore.Bookstore.ListShelves",
+			AllowOriginRegexes: []string{"https://app.example.com", "https://admin.example.com"},
+			AllowHeaders:       []string{"authorization", "x-api-key"},
+			MaxAge:             600,
+			AllowCredentials:   true,
+		},
+	}
+	fakeServiceInfo, err := configinfo.NewServiceInfoFromServiceConfig(&confpb.Service{
+		Name: testProjectName,
+		Apis: []*apipb.Api{
+			{
+				Name: testApiName,
+				Methods: []*apipb.Method{
+					{
+						Name: "ListShelves",
+					},
+					{
+						Name: "GetShelf",
+					},
+				},
+			},
+		},
+	}, testConfigID, opts)
+	if err != nil {
+		t.Fatalf("fail to create ServiceInfo: %v", err)
+	}
+
+	// The empty fields of the policies, like the allowed methods, fall back to
+	// the ones of the virtual host.
+	wantRouteConfig := `
+{
+  "name": "local_route",
+  "virtualHosts": [
+    {
+      "cors": {
+        "allowCredentials": false,
+        "allowMethods": "GET,POST",
+        "allowOriginStringMatch": [{"exact": "http://example.com"}]
+      },
+      "domains": ["*"],
+      "name": "backend",
+      "routes": [
+        {
+          "match": {
+            "headers": [{"exactMatch": "POST", "name": ":method"}],
+            "path": "/endpoints.examples.bookstore.Bookstore/GetShelf"
+          },
+          "route": {
+            "cluster": "bookstore.endpoints.project123.cloud.goog_local",
+            "cors": {
+              "allowOriginStringMatch": [
+                {"safeRegex": {"googleRe2": {"maxProgramSize": 1000}, "regex": "https://.*\\.example\\.com"}}
+              ]
+            },
+            "timeout": "15s"
+          }
+        },
+        {
+          "match": {
+            "headers": [{"exactMatch": "POST", "name": ":method"}],
+            "path": "/endpoints.examples.bookstore.Bookstore/ListShelves"
+          },
+          "route": {
+            "cluster": "bookstore.endpoints.project123.cloud.goog_local",
+            "cors": {
+              "allowCredentials": true,
+              "allowHeaders": "authorization,x-api-key",
+              "allowOriginStringMatch": [
+                {"safeRegex": {"googleRe2": {"maxProgramSize": 1000}, "regex": "https://app.example.com"}},
+                {"safeRegex": {"googleRe2": {"maxProgramSize": 1000}, "regex": "https://admin.example.com"}}
+              ],
+              "maxAge": "600"
+            },
+            "timeout": "15s"
+          }
+        },
+        {
+          "match": {
+            "headers": [{"exactMatch": "OPTIONS", "name": ":method"}],
+            "path": "/endpoints.examples.bookstore.Bookstore/GetShelf"
+          },
+          "route": {
+            "cluster": "bookstore.endpoints.project123.cloud.goog_local",
+            "cors": {
+              "allowOriginStringMatch": [
+                {"safeRegex": {"googleRe2": {"maxProgramSize": 1000}, "regex": "https://.*\\.example\\.com"}}
+              ]
+            }
+          }
+        },
+        {
+          "match": {
+            "headers": [{"exactMatch": "OPTIONS", "name": ":method"}],
+            "path": "/endpoints.examples.bookstore.Bookstore/ListShelves"
+          },
+          "route": {
+            "cluster": "bookstore.endpoints.project123.cloud.goog_local",
+            "cors": {
+              "allowCredentials": true,
+              "allowHeaders": "authorization,x-api-key",
+              "allowOriginStringMatch": [
+                {"safeRegex": {"googleRe2": {"maxProgramSize": 1000}, "regex": "https://app.example.com"}},
+                {"safeRegex": {"googleRe2": {"maxProgramSize": 1000}, "regex": "https://admin.example.com"}}
+              ],
+              "maxAge": "600"
+            }
+          }
+        },
+        {
+          "match": {"prefix": "/"},
+          "route": {
+            "cluster": "bookstore.endpoints.project123.cloud.goog_local",
+            "timeout": "15s"
+          }
+        },
+        {
+          "match": {
+            "headers": [{"exactMatch": "OPTIONS", "name": ":method"}],
+            "prefix": "/"
+          },
+          "route": {
+            "cluster": "bookstore.endpoints.project123.cloud.goog_local"
+          }
+        }
+      ]
+    }
+  ]
+}`
+	gotRoute, err := MakeRouteConfig(fakeServiceInfo)
+	if err != nil {
+		t.Fatalf("makeRouteConfig failed: %v", err)
+	}
+	gotJson, err := util.ProtoToJson(gotRoute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := util.JsonEqual(wantRouteConfig, gotJson); err != nil {
+		t.Errorf("makeRouteConfig failed, %v", err)
+	}
+}
+
+func TestMakeRouteConfigForRateLimitService(t *testing.T) {
+	opts := options.DefaultConfigGeneratorOptions()
+	opts.BackendAddress = "grpc://127.0.0.1:80"
+	opts.RateLimitServiceUri = "grpc://ratelimit.example.com:8081"
+	fakeServiceInfo, err := configinfo.NewServiceInfoFromServiceConfig(&confpb.Service{
+		Name: testProjectName,
+		Apis: []*apipb.Api{
+			{
+				Name: testApiName,
+				Methods: []*apipb.Method{
+					{
+						Name: "ListShelves",
+					},
+				},
+			},
+		},
+	}, testConfigID, opts)
+	if err != nil {
+		t.Fatalf("fail to create ServiceInfo: %v", err)
+	}
+
+	wantRouteConfig := `
+{
+  "name": "local_route",
+  "virtualHosts": [
+    {
+      "domains": ["*"],
+      "name": "backend",
+      "routes": [
+        {
+          "match": {
+            "headers": [{"exactMatch": "POST", "name": ":method"}],
+            "path": "/endpoints.examples.bookstore.Bookstore/ListShelves"
+          },
+          "route": {
+            "cluster": "bookstore.endpoints.project123.cloud.goog_local",
+            "timeout": "15s",
+            "rateLimits": [
+              {
+                "actions": [
+                  {
+                    "genericKey": {
+                      "descriptorValue": "endpoints.examples.bookstore.Bookstore.ListShelves"
+                    }
+                  }
+                ]
+              }
+            ]
+          }
+        },
+        {
+          "match": {"prefix": "/"},
+          "route": {
+            "cluster": "bookstore.endpoints.project123.cloud.goog_local",
+            "timeout": "15s"
+          }
+        }
+      ]
+    }
+  ]
+}`
+	gotRoute, err := MakeRouteConfig(fakeServiceInfo)
+	if err != nil {
+		t.Fatalf("makeRouteConfig failed: %v", err)
+	}
+	gotJson, err := util.ProtoToJson(gotRoute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := util.JsonEqual(wantRouteConfig, gotJson); err != nil {
+		t.Errorf("makeRouteConfig failed, %v", err)
+	}
+}
+
+func TestMakeRouteConfigForUnmatchedPathToBackend(t *testing.T) {
+	opts := options.DefaultConfigGeneratorOptions()
+	opts.BackendAddress = "grpc://127.0.0.1:80"
+	opts.UnmatchedPathToBackend = true
+	fakeServiceInfo, err := configinfo.NewServiceInfoFromServiceConfig(&confpb.Service{
+		Name: testProjectName,
+		Apis: []*apipb.Api{
+			{
+				Name: testApiName,
+				Methods: []*apipb.Method{
+					{
+						Name: "ListShelves",
+					},
+				},
+			},
+		},
+		Backend: &confpb.Backend{
+			Rules: []*confpb.BackendRule{
+				{
+					Selector:        "endpoints.examples.bookstore.Bookstore.ListShelves",
+					Address:         "https://shelves.example.com",
+					PathTranslation: confpb.BackendRule_APPEND_PATH_TO_ADDRESS,
+				},
+			},
+		},
+	}, testConfigID, opts)
+	if err != nil {
+		t.Fatalf("fail to create ServiceInfo: %v", err)
+	}
+
+	// The catch-all route to the local backend is added despite the dynamic
+	// routing.
+	wantRouteConfig := `
+{
+  "name": "local_route",
+  "virtualHosts": [
+    {
+      "domains": ["*"],
+      "name": "backend",
+      "routes": [
+        {
+          "match": {
+            "headers": [{"exactMatch": "POST", "name": ":method"}],
+            "path": "/endpoints.examples.bookstore.Bookstore/ListShelves"
+          },
+          "route": {
+            "cluster": "shelves.example.com:443",
+            "hostRewrite": "shelves.example.com",
+            "timeout": "15s"
+          }
+        },
+        {
+          "match": {"prefix": "/"},
+          "route": {
+            "cluster": "bookstore.endpoints.project123.cloud.goog_local",
+            "timeout": "15s"
+          }
+        }
+      ]
+    }
+  ]
+}`
+	gotRoute, err := MakeRouteConfig(fakeServiceInfo)
+	if err != nil {
+		t.Fatalf("makeRouteConfig failed: %v", err)
+	}
+	gotJson, err := util.ProtoToJson(gotRoute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := util.JsonEqual(wantRouteConfig, gotJson); err != nil {
+		t.Errorf("makeRouteConfig failed, %v", err)
+	}
+}
+
+func TestMakeRouteConfigForLoadBalancing(t *testing.T) {
+	opts := options.DefaultConfigGeneratorOptions()
+	opts.BackendAddress = "grpc://127.0.0.1:80"
+	opts.UnmatchedPathToBackend = true
+	opts.BackendLoadBalancing = options.LoadBalancingOptions{
+		Policy:     "maglev",
+		HashHeader: "x-user-id",
+	}
+	opts.BackendClusters = []*options.BackendClusterOptions{
+		{
+			BackendAddress: "https://shelves.example.com",
+			LoadBalancing: &options.LoadBalancingOptions{
+				Policy:        "ring_hash",
+				HashCookie:    "session",
+				HashCookieTtl: 3600,
+				HashSourceIp:  true,
+			},
+		},
+	}
+	fakeServiceInfo, err := configinfo.NewServiceInfoFromServiceConfig(&confpb.Service{
+		Name: testProjectName,
+		Apis: []*apipb.Api{
+			{
+				Name: testApiName,
+				Methods: []*apipb.Method{
+					{
+						Name: "ListShelves",
+					},
+				},
+			},
+		},
+		Backend: &confpb.Backend{
+			Rules: []*confpb.BackendRule{
+				{
+					Selector:        "endpoints.examples.bookstore.Bookstore.ListShelves",
+					Address:         "https://shelves.example.com",
+					PathTranslation: confpb.BackendRule_APPEND_PATH_TO_ADDRESS,
+				},
+			},
+		},
+	}, testConfigID, opts)
+	if err != nil {
+		t.Fatalf("fail to create ServiceInfo: %v", err)
+	}
+
+	// The routes to the remote backend hash its cookie or the source IP, and
+	// the catch-all route the header of the flags.
+	wantRouteConfig := `
+{
+  "name": "local_route",
+  "virtualHosts": [
+    {
+      "domains": ["*"],
+      "name": "backend",
+      "routes": [
+        {
+          "match": {
+            "headers": [{"exactMatch": "POST", "name": ":method"}],
+            "path": "/endpoints.examples.bookstore.Bookstore/ListShelves"
+          },
+          "route": {
+            "cluster": "shelves.example.com:443",
+            "hashPolicy": [
+              {"cookie": {"name": "session", "path": "/", "ttl": "3600s"}, "terminal": true},
+              {"connectionProperties": {"sourceIp": true}, "terminal": true}
+            ],
+            "hostRewrite": "shelves.example.com",
+            "timeout": "15s"
+          }
+        },
+        {
+          "match": {"prefix": "/"},
+          "route": {
+            "cluster": "bookstore.endpoints.project123.cloud.goog_local",
+            "hashPolicy": [
+              {"header": {"headerName": "x-user-id"}, "terminal": true}
+            ],
+            "timeout": "15s"
+          }
+        }
+      ]
+    }
+  ]
+}`
+	gotRoute, err := MakeRouteConfig(fakeServiceInfo)
+	if err != nil {
+		t.Fatalf("makeRouteConfig failed: %v", err)
+	}
+	gotJson, err := util.ProtoToJson(gotRoute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := util.JsonEqual(wantRouteConfig, gotJson); err != nil {
+		t.Errorf("makeRouteConfig failed, %v", err)
+	}
+}
+
+func TestMakeRouteConfigForBackendMirrors(t *testing.T) {
+	opts := options.DefaultConfigGeneratorOptions()
+	opts.BackendAddress = "grpc://127.0.0.1:80"
+	opts.BackendMirrors = []*options.BackendMirrorOptions{
+		{
+			Selector: "endpoints.examples.bookstore.Bookstore.ListShelves",
+			Address:  "grpcs://v2.example.com",
+			Fraction: 0.25,
+		},
+		{
+			Selector: "endpoints.examples.bookstore.Bookstore.GetShelf",
+			Address:  "grpcs://v2.example.com",
+		},
+	}
+	fakeServiceInfo, err := configinfo.NewServiceInfoFromServiceConfig(&confpb.Service{
+		Name: testProjectName,
+		Apis: []*apipb.Api{
+			{
+				Name: testApiName,
+				Methods: []*apipb.Method{
+					{
+						Name: "ListShelves",
+					},
+					{
+						Name: "GetShelf",
+					},
+				},
+			},
+		},
+		Backend: &confpb.Backend{
+			Rules: []*confpb.BackendRule{
+				{
+					Selector:        "endpoints.examples.bookstore.Bookstore.GetShelf",
+					Address:         "grpcs://v1.example.com",
+					PathTranslation: confpb.BackendRule_APPEND_PATH_TO_ADDRESS,
+				},
+			},
+		},
+	}, testConfigID, opts)
+	if err != nil {
+		t.Fatalf("fail to create ServiceInfo: %v", err)
+	}
+
+	wantRouteConfig := `
+{
+  "name": "local_route",
+  "virtualHosts": [
+    {
+      "domains": ["*"],
+      "name": "backend",
+      "routes": [
+        {
+          "match": {
+            "headers": [{"exactMatch": "POST", "name": ":method"}],
+            "path": "/endpoints.examples.bookstore.Bookstore/GetShelf"
+          },
+          "route": {
+            "cluster": "v1.example.com:443",
+            "hostRewrite": "v1.example.com",
+            "requestMirrorPolicy": {
+              "cluster": "v2.example.com:443",
+              "runtimeFraction": {"defaultValue": {"numerator": 1000000, "denominator": "MILLION"}}
+            },
+            "timeout": "15s"
+          }
+        },
+        {
+          "match": {
+            "headers": [{"exactMatch": "POST", "name": ":method"}],
+            "path": "/endpoints.examples.bookstore.Bookstore/ListShelves"
+          },
+          "route": {
+            "cluster": "bookstore.endpoints.project123.cloud.goog_local",
+            "requestMirrorPolicy": {
+              "cluster": "v2.example.com:443",
+              "runtimeFraction": {"defaultValue": {"numerator": 250000, "denominator": "MILLION"}}
+            },
+            "timeout": "15s"
+          }
+        }
+      ]
+    }
+  ]
+}`
+	gotRoute, err := MakeRouteConfig(fakeServiceInfo)
+	if err != nil {
+		t.Fatalf("makeRouteConfig failed: %v", err)
+	}
+	gotJson, err := util.ProtoToJson(gotRoute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := util.JsonEqual(wantRouteConfig, gotJson); err != nil {
+		t.Errorf("makeRouteConfig failed, %v", err)
+	}
+}
+
+func TestMakeRouteConfigForFaultInjections(t *testing.T) {
+	opts := options.DefaultConfigGeneratorOptions()
+	opts.BackendAddress = "grpc://127.0.0.1:80"
+	opts.FaultInjectionHeader = "x-fault-injection"
+	opts.FaultInjections = []*options.FaultInjectionOptions{
+		{
+			Selector: "endpoints.examples.bookstore.Bookstore.ListShelves",
+			Delay: &options.FaultDelayOptions{
+				Percent:  12.5,
+				Duration: 1.5,
+			},
+			Abort: &options.FaultAbortOptions{
+				Percent: 5,
+				Status:  503,
+			},
+		},
+	}
+	fakeServiceInfo, err := configinfo.NewServiceInfoFromServiceConfig(&confpb.Service{
+		Name: testProjectName,
+		Apis: []*apipb.Api{
+			{
+				Name: testApiName,
+				Methods: []*apipb.Method{
+					{
+						Name: "ListShelves",
+					},
+					{
+						Name: "GetShelf",
+					},
+				},
+			},
+		},
+	}, testConfigID, opts)
+	if err != nil {
+		t.Fatalf("fail to create ServiceInfo: %v", err)
+	}
+
+	wantRouteConfig := `
+{
+  "name": "local_route",
+  "virtualHosts": [
+    {
+      "domains": ["*"],
+      "name": "backend",
+      "routes": [
+        {
+          "match": {
+            "headers": [{"exactMatch": "POST", "name": ":method"}],
+            "path": "/endpoints.examples.bookstore.Bookstore/ListShelves"
+          },
+          "route": {
+            "cluster": "bookstore.endpoints.project123.cloud.goog_local",
+            "timeout": "15s"
+          },
+          "typedPerFilterConfig": {
+            "envoy.filters.http.fault": {
+              "@type": "type.googleapis.com/envoy.config.filter.http.fault.v2.HTTPFault",
+              "abort": {"httpStatus": 503, "percentage": {"numerator": 50000, "denominator": "MILLION"}},
+              "delay": {"fixedDelay": "1.500s", "percentage": {"numerator": 125000, "denominator": "MILLION"}},
+              "headers": [{"name": "x-fault-injection", "presentMatch": true}]
+            }
+          }
+        },
+        {
+          "match": {"prefix": "/"},
+          "route": {
+            "cluster": "bookstore.endpoints.project123.cloud.goog_local",
+            "timeout": "15s"
+          }
+        }
+      ]
+    }
+  ]
+}`
+	gotRoute, err := MakeRouteConfig(fakeServiceInfo)
+	if err != nil {
+		t.Fatalf("makeRouteConfig failed: %v", err)
+	}
+	gotJson, err := util.ProtoToJson(gotRoute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := util.JsonEqual(wantRouteConfig, gotJson); err != nil {
+		t.Errorf("makeRouteConfig failed, %v", err)
+	}
+
+	opts.FaultInjectionHeader = ""
+	wantError := "fault injections require --fault_injection_header"
+	if _, err := configinfo.NewServiceInfoFromServiceConfig(&confpb.Service{
+		Name: testProjectName,
+		Apis: []*apipb.Api{{Name: testApiName}},
+	}, testConfigID, opts); err == nil || err.Error() != wantError {
+		t.Errorf("NewServiceInfoFromServiceConfig got error %v, want %s", err, wantError)
+	}
+}
+
+func TestMakeRouteConfigForDirectResponses(t *testing.T) {
+	opts := options.DefaultConfigGeneratorOptions()
+	opts.BackendAddress = "grpc://127.0.0.1:80"
+	opts.DirectResponses = []*options.DirectResponseOptions{
+		{
+			Selector: "endpoints.examples.bookstore.Bookstore.GetShelf",
+			Status:   200,
+			Headers: []*options.HeaderValueOptions{
+				{
+					Name:  "cache-control",
+					Value: "max-age=3600",
+				},
+			},
+			ContentType: "application/json",
+			Body:        `{"shelf": "stub"}`,
+		},
+		{
+			Selector: "endpoints.examples.bookstore.Bookstore.DeleteShelf",
+			Status:   501,
+		},
+	}
+	fakeServiceInfo, err := configinfo.NewServiceInfoFromServiceConfig(&confpb.Service{
+		Name: testProjectName,
+		Apis: []*apipb.Api{
+			{
+				Name: testApiName,
+				Methods: []*apipb.Method{
+					{
+						Name: "ListShelves",
+					},
+					{
+						Name: "GetShelf",
+					},
+					{
+						Name: "DeleteShelf",
+					},
+				},
+			},
+		},
+	}, testConfigID, opts)
+	if err != nil {
+		t.Fatalf("fail to create ServiceInfo: %v", err)
+	}
+
+	wantRouteConfig := `
+{
+  "name": "local_route",
+  "virtualHosts": [
+    {
+      "domains": ["*"],
+      "name": "backend",
+      "routes": [
+        {
+          "match": {
+            "headers": [{"exactMatch": "POST", "name": ":method"}],
+            "path": "/endpoints.examples.bookstore.Bookstore/DeleteShelf"
+          },
+          "directResponse": {
+            "status": 501
+          }
+        },
+        {
+          "match": {
+            "headers": [{"exactMatch": "POST", "name": ":method"}],
+            "path": "/endpoints.examples.bookstore.Bookstore/GetShelf"
+          },
+          "directResponse": {
+            "status": 200,
+            "body": {"inlineString": "{\"shelf\": \"stub\"}"}
+          },
+          "responseHeadersToAdd": [
+            {"header": {"key": "cache-control", "value": "max-age=3600"}, "append": true},
+            {"header": {"key": "content-type", "value": "application/json"}, "append": false}
+          ]
+        },
+        {
+          "match": {"prefix": "/"},
+          "route": {
+            "cluster": "bookstore.endpoints.project123.cloud.goog_local",
+            "timeout": "15s"
+          }
+        }
+      ]
+    }
+  ]
+}`
+	gotRoute, err := MakeRouteConfig(fakeServiceInfo)
+	if err != nil {
+		t.Fatalf("makeRouteConfig failed: %v", err)
+	}
+	gotJson, err := util.ProtoToJson(gotRoute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := util.JsonEqual(wantRouteConfig, gotJson); err != nil {
+		t.Errorf("makeRouteConfig failed, %v", err)
+	}
+}
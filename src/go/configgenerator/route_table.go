@@ -0,0 +1,182 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package configgenerator
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/configinfo"
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/util"
+	"github.com/golang/protobuf/proto"
+
+	commonpb "github.com/GoogleCloudPlatform/esp-v2/src/go/proto/api/envoy/http/common"
+	routepb "github.com/envoyproxy/go-control-plane/envoy/api/v2/route"
+)
+
+// Origins of the generated routes.
+const (
+	// The route returns the canned response of an operation.
+	RouteOriginDirectResponse = "direct_response"
+	// The route sends the requests of an operation to the backend of its
+	// backend rule, or x-google-backend extension.
+	RouteOriginBackendRule = "backend_rule"
+	// The route sends the requests of an operation to the local backend, for
+	// its own settings.
+	RouteOriginLocalBackend = "local_backend"
+	// The route answers the preflight requests to the path of an operation
+	// with its CORS policy.
+	RouteOriginCorsPreflight = "cors_preflight"
+	// The route sends all the other requests to the local backend.
+	RouteOriginCatchAll = "catch_all"
+	// The route is not generated for an operation.
+	RouteOriginGenerated = "generated"
+)
+
+// RouteEntry describes a generated route, in the order they are matched, with
+// the operations and the HTTP rule it was generated for, to debug the routing
+// of the requests.
+type RouteEntry struct {
+	Match      json.RawMessage `json:"match"`
+	Origin     string          `json:"origin"`
+	Operations []string        `json:"operations,omitempty"`
+	HttpRule   string          `json:"httpRule,omitempty"`
+	Clusters   []string        `json:"clusters,omitempty"`
+	// Status of the direct responses.
+	Status uint32 `json:"status,omitempty"`
+}
+
+// routeSource is an HTTP rule of an operation.
+type routeSource struct {
+	operations []string
+	httpRule   string
+}
+
+// MakeRouteTable makes the route config of serviceInfo, and describes its
+// routes with the operations and HTTP rules they were generated for.
+func MakeRouteTable(serviceInfo *configinfo.ServiceInfo) ([]*RouteEntry, error) {
+	_, table, err := makeRouteTable(serviceInfo)
+	return table, err
+}
+
+// makeRouteTable returns the routes of the route config of serviceInfo, and
+// their descriptions.
+func makeRouteTable(serviceInfo *configinfo.ServiceInfo) ([]*routepb.Route, []*RouteEntry, error) {
+	routeConfig, err := MakeRouteConfig(serviceInfo)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// The routes of the operations are found by the path and the HTTP method
+	// they match, ignoring the other headers.
+	sources := make(map[string]*routeSource)
+	preflightSources := make(map[string]*routeSource)
+	addSource := func(m map[string]*routeSource, operation string, httpRule *commonpb.Pattern, routeMatcher *routepb.RouteMatch) {
+		key := routeMatchKey(routeMatcher)
+		if s, ok := m[key]; ok {
+			s.operations = append(s.operations, operation)
+			return
+		}
+		m[key] = &routeSource{
+			operations: []string{operation},
+			httpRule:   fmt.Sprintf("%s %s", httpRule.HttpMethod, httpRule.UriTemplate),
+		}
+	}
+	for _, operation := range serviceInfo.Operations {
+		method := serviceInfo.Methods[operation]
+		for _, httpRule := range method.HttpRule {
+			if routeMatcher := makeHttpRouteMatcher(httpRule); routeMatcher != nil {
+				applyPathRewriteMatch(routeMatcher, method.PathRewrite)
+				addSource(sources, operation, httpRule, routeMatcher)
+			}
+			if httpRule.HttpMethod == util.OPTIONS {
+				continue
+			}
+			preflightRule := &commonpb.Pattern{
+				UriTemplate: httpRule.UriTemplate,
+				HttpMethod:  util.OPTIONS,
+			}
+			if routeMatcher := makeHttpRouteMatcher(preflightRule); routeMatcher != nil {
+				addSource(preflightSources, operation, preflightRule, routeMatcher)
+			}
+		}
+	}
+
+	var routes []*routepb.Route
+	var table []*RouteEntry
+	for _, host := range routeConfig.GetVirtualHosts() {
+		for _, r := range host.GetRoutes() {
+			match, err := util.ProtoToJson(r.GetMatch())
+			if err != nil {
+				return nil, nil, err
+			}
+			entry := &RouteEntry{
+				Match:  json.RawMessage(match),
+				Origin: RouteOriginGenerated,
+			}
+			key := routeMatchKey(r.GetMatch())
+			source := sources[key]
+			switch {
+			case r.GetDirectResponse() != nil:
+				entry.Origin = RouteOriginDirectResponse
+				entry.Status = r.GetDirectResponse().GetStatus()
+			case source != nil:
+				entry.Origin = RouteOriginLocalBackend
+				if serviceInfo.Methods[source.operations[0]].BackendInfo != nil {
+					entry.Origin = RouteOriginBackendRule
+				}
+			case preflightSources[key] != nil:
+				entry.Origin = RouteOriginCorsPreflight
+				source = preflightSources[key]
+			case r.GetMatch().GetPrefix() == "/":
+				entry.Origin = RouteOriginCatchAll
+			}
+			if source != nil {
+				entry.Operations = source.operations
+				entry.HttpRule = source.httpRule
+			}
+			entry.Clusters = routeClusters(r.GetRoute())
+			routes = append(routes, r)
+			table = append(table, entry)
+		}
+	}
+	return routes, table, nil
+}
+
+// routeMatchKey returns a key of the path and the HTTP method matched by a
+// route.
+func routeMatchKey(routeMatcher *routepb.RouteMatch) string {
+	key := &routepb.RouteMatch{
+		PathSpecifier: routeMatcher.GetPathSpecifier(),
+	}
+	for _, h := range routeMatcher.GetHeaders() {
+		if h.GetName() == ":method" {
+			key.Headers = append(key.Headers, h)
+		}
+	}
+	return proto.CompactTextString(key)
+}
+
+// routeClusters returns the clusters the requests are sent to by a route.
+func routeClusters(action *routepb.RouteAction) []string {
+	if cluster := action.GetCluster(); cluster != "" {
+		return []string{cluster}
+	}
+	var clusters []string
+	for _, c := range action.GetWeightedClusters().GetClusters() {
+		clusters = append(clusters, c.GetName())
+	}
+	return clusters
+}
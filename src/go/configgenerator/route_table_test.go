@@ -0,0 +1,158 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package configgenerator
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/configinfo"
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/options"
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/util"
+
+	annotationspb "google.golang.org/genproto/googleapis/api/annotations"
+	confpb "google.golang.org/genproto/googleapis/api/serviceconfig"
+	apipb "google.golang.org/genproto/protobuf/api"
+)
+
+func TestMakeRouteTable(t *testing.T) {
+	opts := options.DefaultConfigGeneratorOptions()
+	opts.BackendAddress = "http://127.0.0.1:80"
+	opts.CorsPolicies = []*options.CorsPolicyOptions{
+		{
+			Selector:           testApiName + ".GetShelf",
+			AllowOriginRegexes: []string{`https://.*\.example\.com`},
+		},
+	}
+	opts.DirectResponses = []*options.DirectResponseOptions{
+		{
+			Selector: testApiName + ".ListShelves",
+			Status:   501,
+		},
+	}
+	fakeServiceInfo, err := configinfo.NewServiceInfoFromServiceConfig(&confpb.Service{
+		Name: testProjectName,
+		Apis: []*apipb.Api{
+			{
+				Name: testApiName,
+				Methods: []*apipb.Method{
+					{
+						Name: "GetShelf",
+					},
+					{
+						Name: "ListShelves",
+					},
+					{
+						Name: "CreateShelf",
+					},
+				},
+			},
+		},
+		Http: &annotationspb.Http{
+			Rules: []*annotationspb.HttpRule{
+				{
+					Selector: testApiName + ".GetShelf",
+					Pattern: &annotationspb.HttpRule_Get{
+						Get: "/v1/shelves/{shelf}",
+					},
+				},
+				{
+					Selector: testApiName + ".ListShelves",
+					Pattern: &annotationspb.HttpRule_Get{
+						Get: "/v1/shelves",
+					},
+				},
+				{
+					Selector: testApiName + ".CreateShelf",
+					Pattern: &annotationspb.HttpRule_Post{
+						Post: "/v1/shelves",
+					},
+				},
+			},
+		},
+		Backend: &confpb.Backend{
+			Rules: []*confpb.BackendRule{
+				{
+					Selector: testApiName + ".GetShelf",
+					Address:  "https://shelves.example.com",
+				},
+				{
+					Selector: testApiName + ".CreateShelf",
+					Deadline: 30,
+				},
+			},
+		},
+	}, testConfigID, opts)
+	if err != nil {
+		t.Fatalf("fail to create ServiceInfo: %v", err)
+	}
+
+	table, err := MakeRouteTable(fakeServiceInfo)
+	if err != nil {
+		t.Fatalf("MakeRouteTable failed: %v", err)
+	}
+	gotJson, err := json.Marshal(map[string]interface{}{"routes": table})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantJson := `
+{
+  "routes": [
+    {
+      "match": {
+        "headers": [{"exactMatch": "GET", "name": ":method"}],
+        "path": "/v1/shelves"
+      },
+      "origin": "direct_response",
+      "operations": ["endpoints.examples.bookstore.Bookstore.ListShelves"],
+      "httpRule": "GET /v1/shelves",
+      "status": 501
+    },
+    {
+      "match": {
+        "headers": [{"exactMatch": "GET", "name": ":method"}],
+        "safeRegex": {"googleRe2": {"maxProgramSize": 1000}, "regex": "/v1/shelves/[^\\/]+$"}
+      },
+      "origin": "backend_rule",
+      "operations": ["endpoints.examples.bookstore.Bookstore.GetShelf"],
+      "httpRule": "GET /v1/shelves/{shelf}",
+      "clusters": ["shelves.example.com:443"]
+    },
+    {
+      "match": {
+        "headers": [{"exactMatch": "POST", "name": ":method"}],
+        "path": "/v1/shelves"
+      },
+      "origin": "local_backend",
+      "operations": ["endpoints.examples.bookstore.Bookstore.CreateShelf"],
+      "httpRule": "POST /v1/shelves",
+      "clusters": ["bookstore.endpoints.project123.cloud.goog_local"]
+    },
+    {
+      "match": {
+        "headers": [{"exactMatch": "OPTIONS", "name": ":method"}],
+        "safeRegex": {"googleRe2": {"maxProgramSize": 1000}, "regex": "/v1/shelves/[^\\/]+$"}
+      },
+      "origin": "cors_preflight",
+      "operations": ["endpoints.examples.bookstore.Bookstore.GetShelf"],
+      "httpRule": "OPTIONS /v1/shelves/{shelf}",
+      "clusters": ["shelves.example.com:443"]
+    }
+  ]
+}`
+	if err := util.JsonEqual(wantJson, string(gotJson)); err != nil {
+		t.Errorf("MakeRouteTable failed, %v", err)
+	}
+}
@@ -0,0 +1,143 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package configgenerator
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/golang/protobuf/ptypes"
+
+	sc "github.com/GoogleCloudPlatform/esp-v2/src/go/configinfo"
+	v2pb "github.com/envoyproxy/go-control-plane/envoy/api/v2"
+	routepb "github.com/envoyproxy/go-control-plane/envoy/api/v2/route"
+	hcmpb "github.com/envoyproxy/go-control-plane/envoy/config/filter/network/http_connection_manager/v2"
+	wrapperspb "github.com/golang/protobuf/ptypes/wrappers"
+)
+
+const (
+	trafficSplitVirtualHostName = "traffic_split"
+)
+
+// TrafficSplitConfig is a service config of a rollout with the percentage of
+// traffic it should receive.
+type TrafficSplitConfig struct {
+	ServiceInfo *sc.ServiceInfo
+	Percentage  float64
+}
+
+// trafficSplitClusterName returns the name of the cluster forwarding requests
+// to the internal listener of a config.
+func trafficSplitClusterName(config TrafficSplitConfig) string {
+	return fmt.Sprintf("traffic_split_%s", config.ServiceInfo.ConfigID)
+}
+
+// MakeClustersForTrafficSplit provides dynamic cluster settings for Envoy when
+// the configs of a rollout are served with their traffic percentages. Besides
+// the clusters of all configs, there is one cluster for the internal listener
+// of each config, see MakeListenersForTrafficSplit.
+func MakeClustersForTrafficSplit(configs []TrafficSplitConfig, basePort int) ([]*v2pb.Cluster, error) {
+	var serviceInfos []*sc.ServiceInfo
+	for _, config := range configs {
+		serviceInfos = append(serviceInfos, config.ServiceInfo)
+	}
+	clusters, err := MakeClustersForServices(serviceInfos)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, config := range configs {
+		clusters = append(clusters, makeInternalListenerCluster(trafficSplitClusterName(config), basePort+i, config.ServiceInfo.Options))
+	}
+	return clusters, nil
+}
+
+// MakeListenersForTrafficSplit provides dynamic listeners for Envoy when the
+// configs of a rollout are served with their traffic percentages.
+//
+// As the HTTP filters are set per listener, each config is served by its own
+// internal listener on 127.0.0.1, on basePort plus the index of the config.
+// The listener on --listener_port only routes each request to one of the
+// internal listeners, picked randomly with the weights of the configs.
+//
+// Listener level settings are taken from the first config.
+func MakeListenersForTrafficSplit(configs []TrafficSplitConfig, basePort int) ([]*v2pb.Listener, error) {
+	if len(configs) == 0 {
+		return nil, fmt.Errorf("no config to make listeners for")
+	}
+
+	weightedClusters := &routepb.WeightedCluster{}
+	var totalWeight uint32
+	var listeners []*v2pb.Listener
+	for i, config := range configs {
+		// Percentages of rollouts have up to 2 decimals.
+		weight := uint32(math.Round(config.Percentage * 100))
+		if weight == 0 {
+			continue
+		}
+		totalWeight += weight
+		weightedClusters.Clusters = append(weightedClusters.Clusters, &routepb.WeightedCluster_ClusterWeight{
+			Name:   trafficSplitClusterName(config),
+			Weight: &wrapperspb.UInt32Value{Value: weight},
+		})
+
+		listener, err := makeInternalListener(config.ServiceInfo, basePort+i, config.ServiceInfo.ConfigID)
+		if err != nil {
+			return nil, fmt.Errorf("fail to make listener for configuration id %v: %v", config.ServiceInfo.ConfigID, err)
+		}
+		listeners = append(listeners, listener)
+	}
+	if totalWeight == 0 {
+		return nil, fmt.Errorf("no config with a positive traffic percentage")
+	}
+	weightedClusters.TotalWeight = &wrapperspb.UInt32Value{Value: totalWeight}
+
+	route := &v2pb.RouteConfiguration{
+		Name: routeName,
+		VirtualHosts: []*routepb.VirtualHost{
+			{
+				Name:    trafficSplitVirtualHostName,
+				Domains: []string{"*"},
+				Routes: []*routepb.Route{
+					{
+						Match: &routepb.RouteMatch{
+							PathSpecifier: &routepb.RouteMatch_Prefix{
+								Prefix: "/",
+							},
+						},
+						Action: &routepb.Route_Route{
+							Route: &routepb.RouteAction{
+								ClusterSpecifier: &routepb.RouteAction_WeightedClusters{
+									WeightedClusters: weightedClusters,
+								},
+								// Timeouts are enforced by the internal listeners.
+								Timeout: ptypes.DurationProto(0),
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	// Spans are reported by the internal listeners.
+	opts := configs[0].ServiceInfo.Options
+	opts.DisableTracing = true
+	frontListeners, err := makeFrontListeners(opts, []*hcmpb.HttpFilter{makeRouterFilter(opts)}, route)
+	if err != nil {
+		return nil, err
+	}
+	return append(frontListeners, listeners...), nil
+}
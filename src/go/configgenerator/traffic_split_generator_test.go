@@ -0,0 +1,142 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package configgenerator
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/configinfo"
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/options"
+	"github.com/golang/protobuf/ptypes"
+
+	hcmpb "github.com/envoyproxy/go-control-plane/envoy/config/filter/network/http_connection_manager/v2"
+)
+
+func TestMakeListenersForTrafficSplit(t *testing.T) {
+	testData := []struct {
+		desc            string
+		percentages     map[string]float64
+		configIDs       []string
+		wantListeners   []string
+		wantPorts       []uint32
+		wantClusters    []string
+		wantWeights     []uint32
+		wantTotalWeight uint32
+		wantError       string
+	}{
+		{
+			desc:            "Success, split traffic between two configs",
+			configIDs:       []string{"2019-01-02r0", "2019-01-01r0"},
+			percentages:     map[string]float64{"2019-01-02r0": 90, "2019-01-01r0": 10},
+			wantListeners:   []string{"http_listener", "http_listener_2019-01-02r0", "http_listener_2019-01-01r0"},
+			wantPorts:       []uint32{8080, 8090, 8091},
+			wantClusters:    []string{"traffic_split_2019-01-02r0", "traffic_split_2019-01-01r0"},
+			wantWeights:     []uint32{9000, 1000},
+			wantTotalWeight: 10000,
+		},
+		{
+			desc:            "Success, config without traffic is not served",
+			configIDs:       []string{"2019-01-02r0", "2019-01-01r0"},
+			percentages:     map[string]float64{"2019-01-02r0": 100, "2019-01-01r0": 0},
+			wantListeners:   []string{"http_listener", "http_listener_2019-01-02r0"},
+			wantPorts:       []uint32{8080, 8090},
+			wantClusters:    []string{"traffic_split_2019-01-02r0"},
+			wantWeights:     []uint32{10000},
+			wantTotalWeight: 10000,
+		},
+		{
+			desc:        "Failure, no config with traffic",
+			configIDs:   []string{"2019-01-01r0"},
+			percentages: map[string]float64{"2019-01-01r0": 0},
+			wantError:   "no config with a positive traffic percentage",
+		},
+	}
+
+	for _, tc := range testData {
+		var configs []TrafficSplitConfig
+		for _, configID := range tc.configIDs {
+			serviceConfig := fakeServiceConfigWithPath("foo.endpoints.project123.cloud.goog", "foo.Foo", "/foo")
+			serviceInfo, err := configinfo.NewServiceInfoFromServiceConfig(serviceConfig, configID, options.DefaultConfigGeneratorOptions())
+			if err != nil {
+				t.Fatal(err)
+			}
+			configs = append(configs, TrafficSplitConfig{
+				ServiceInfo: serviceInfo,
+				Percentage:  tc.percentages[configID],
+			})
+		}
+
+		listeners, err := MakeListenersForTrafficSplit(configs, 8090)
+		if tc.wantError != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.wantError) {
+				t.Errorf("Test Desc(%s): got error %v, want error containing %q", tc.desc, err, tc.wantError)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Test Desc(%s): MakeListenersForTrafficSplit got error: %v", tc.desc, err)
+		}
+
+		var gotListeners []string
+		var gotPorts []uint32
+		for _, listener := range listeners {
+			gotListeners = append(gotListeners, listener.GetName())
+			gotPorts = append(gotPorts, listener.GetAddress().GetSocketAddress().GetPortValue())
+		}
+		if !reflect.DeepEqual(gotListeners, tc.wantListeners) {
+			t.Errorf("Test Desc(%s): got listeners %v, want %v", tc.desc, gotListeners, tc.wantListeners)
+		}
+		if !reflect.DeepEqual(gotPorts, tc.wantPorts) {
+			t.Errorf("Test Desc(%s): got listener ports %v, want %v", tc.desc, gotPorts, tc.wantPorts)
+		}
+
+		httpConMgr := &hcmpb.HttpConnectionManager{}
+		if err := ptypes.UnmarshalAny(listeners[0].GetFilterChains()[0].GetFilters()[0].GetTypedConfig(), httpConMgr); err != nil {
+			t.Fatal(err)
+		}
+		weightedClusters := httpConMgr.GetRouteConfig().GetVirtualHosts()[0].GetRoutes()[0].GetRoute().GetWeightedClusters()
+		var gotClusters []string
+		var gotWeights []uint32
+		for _, cluster := range weightedClusters.GetClusters() {
+			gotClusters = append(gotClusters, cluster.GetName())
+			gotWeights = append(gotWeights, cluster.GetWeight().GetValue())
+		}
+		if !reflect.DeepEqual(gotClusters, tc.wantClusters) {
+			t.Errorf("Test Desc(%s): got weighted clusters %v, want %v", tc.desc, gotClusters, tc.wantClusters)
+		}
+		if !reflect.DeepEqual(gotWeights, tc.wantWeights) {
+			t.Errorf("Test Desc(%s): got weights %v, want %v", tc.desc, gotWeights, tc.wantWeights)
+		}
+		if got := weightedClusters.GetTotalWeight().GetValue(); got != tc.wantTotalWeight {
+			t.Errorf("Test Desc(%s): got total weight %v, want %v", tc.desc, got, tc.wantTotalWeight)
+		}
+
+		clusters, err := MakeClustersForTrafficSplit(configs, 8090)
+		if err != nil {
+			t.Fatalf("Test Desc(%s): MakeClustersForTrafficSplit got error: %v", tc.desc, err)
+		}
+		clusterNames := make(map[string]bool)
+		for _, cluster := range clusters {
+			clusterNames[cluster.GetName()] = true
+		}
+		for _, want := range tc.wantClusters {
+			if !clusterNames[want] {
+				t.Errorf("Test Desc(%s): got clusters %v, want cluster %v", tc.desc, clusterNames, want)
+			}
+		}
+	}
+}
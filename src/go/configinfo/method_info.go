@@ -17,6 +17,8 @@ package configinfo
 import (
 	"time"
 
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/options"
+
 	commonpb "github.com/GoogleCloudPlatform/esp-v2/src/go/proto/api/envoy/http/common"
 	scpb "github.com/GoogleCloudPlatform/esp-v2/src/go/proto/api/envoy/http/service_control"
 	confpb "google.golang.org/genproto/googleapis/api/serviceconfig"
@@ -36,15 +38,134 @@ type methodInfo struct {
 	MetricCosts        []*scpb.MetricCost
 	// All non-unary gRPC methods are considered streaming.
 	IsStreaming bool
+	// Operation whose JWT and API key are required by the generated OPTIONS
+	// method, empty if it is exempt from authentication.
+	PreflightOperation string
+	// Response timeout of the method served by the local backend, set by its
+	// BackendRule without address, 0 to use the default one.
+	LocalBackendDeadline time.Duration
+	// Retry policy of the routes of the method, nil if disabled.
+	BackendRetry *BackendRetryPolicy
+	// Headers added to or removed from the requests of the method and their
+	// responses on its routes, nil if none.
+	HeaderRule *options.HeaderRuleOptions
+	// Rewrite of the paths of the requests of the method sent to its backend,
+	// nil to use the path translation of its backend rule.
+	PathRewrite *options.PathRewriteOptions
+	// Cache of the responses of the GET requests of the method, nil if they
+	// are not cached.
+	ResponseCache *options.ResponseCacheOptions
+	// CORS policy of the routes of the method, and of the preflight requests
+	// to its paths, nil to use the one of the virtual host.
+	CorsPolicy *options.CorsPolicyOptions
+	// IP ACL of the requests of the method, nil to use the global one.
+	IpAcl *options.IpAclOptions
+	// Weighted backends sharing the requests of the method, instead of the
+	// cluster of its routes, empty if the requests are not split.
+	BackendSplit []*WeightedBackend
+	// Secondary backend the requests of the method are mirrored to, nil if
+	// they are not mirrored.
+	BackendMirror *BackendMirror
+	// Faults injected in the requests of the method with the
+	// FaultInjectionHeader, nil if none.
+	FaultInjection *options.FaultInjectionOptions
+	// Canned response returned by the routes of the method instead of sending
+	// its requests to a backend, nil if none.
+	DirectResponse *options.DirectResponseOptions
+	// If true, the routes of the method allow WebSocket upgrades.
+	EnableWebsocket bool
+	// Fraction of the requests to the method that Envoy decides to trace on
+	// its routes, nil to use the one of the listener.
+	TracingSampleRate *float64
+	// If true, the method is not checked by the external authorization server.
+	DisableExtAuthz bool
+	// If true, the requests to the method are not access logged.
+	DisableAccessLog bool
+	// Token-bucket limit of the requests to the method, nil if unlimited.
+	RateLimit *scpb.RateLimit
+	// Maximum sizes of the request and response bodies of the method, 0 if
+	// unlimited.
+	MaxRequestBodyBytes  uint32
+	MaxResponseBodyBytes uint32
+	// Audiences allowed in the JWTs of the method, set only if some of them
+	// are wildcard or regex patterns, which are matched on its routes.
+	JwtAudiences []string
+	// Claims of the verified JWTs forwarded to the backend in request headers,
+	// sorted by header.
+	JwtClaimHeaders []*JwtClaimHeader
+	// Policies allowing the requests of the method by the claims of their
+	// verified JWTs, matched on its routes. All requests are allowed if empty.
+	AuthorizationPolicies []*AuthorizationPolicy
+}
+
+// JwtClaimHeader stores a claim of the JWTs forwarded in a request header.
+type JwtClaimHeader struct {
+	// Path of the claim in the JWT payload, with a key per nested claim.
+	ClaimPath []string
+	Header    string
+}
+
+// AuthorizationPolicy stores the claims required in the verified JWTs of the
+// requests allowed by a policy, sorted by claim.
+type AuthorizationPolicy struct {
+	Claims []*AuthorizationClaim
+}
+
+// AuthorizationClaim stores the values of a claim accepted by a policy. A
+// claim of the list type matches if it contains one of them.
+type AuthorizationClaim struct {
+	// Path of the claim in the JWT payload, with a key per nested claim.
+	ClaimPath []string
+	Values    []string
+}
+
+// BackendRetryPolicy stores the retry policy of the routes of a method.
+type BackendRetryPolicy struct {
+	NumRetries uint32
+	RetryOn    string
+	// 0 uses the timeout of the route.
+	PerTryTimeout time.Duration
+	// If true, the tries exceeding PerTryTimeout are hedged on the routes of
+	// idempotent HTTP methods.
+	Hedge bool
+}
+
+// WeightedBackend stores a backend receiving a share of the requests of a
+// method, in proportion to its weight.
+type WeightedBackend struct {
+	// Name of the backend, matched with the value of the BackendSplitHeader.
+	Name        string
+	ClusterName string
+	Weight      uint32
+}
+
+// BackendMirror stores a secondary backend receiving a copy of a fraction of
+// the requests of a method.
+type BackendMirror struct {
+	ClusterName string
+	Fraction    float64
 }
 
 // backendInfo stores information from Backend rule for backend rerouting.
 type backendInfo struct {
-	ClusterName     string
-	Uri             string
-	Hostname        string
+	ClusterName string
+	Uri         string
+	Hostname    string
+	// If true, the Host header is the hostname of the region the request is
+	// sent to, instead of Hostname.
+	AutoHostRewrite bool
 	TranslationType confpb.BackendRule_PathTranslation
 	JwtAudience     string
+	// Delegates used to fetch the identity token of JwtAudience from IAM,
+	// overriding the ones of --backend_auth_iam_delegates. The tokens are
+	// cached per audience and delegates.
+	IamDelegates []string
+	// If set, the OAuth2 access token of the backend is fetched from this uri
+	// of the token agent and sent instead of an identity token for JwtAudience.
+	AccessTokenUri string
 	// Response timeout for the backend.
 	Deadline time.Duration
+	// Load balancing policy of the backend, whose hash keys are set on the
+	// routes, only set if it consistently hashes the requests.
+	LoadBalancing *options.LoadBalancingOptions
 }
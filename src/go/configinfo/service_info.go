@@ -15,26 +15,40 @@
 package configinfo
 
 import (
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"math"
 	"net"
+	"regexp"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/GoogleCloudPlatform/esp-v2/src/go/options"
 	"github.com/GoogleCloudPlatform/esp-v2/src/go/util"
 	"github.com/golang/glog"
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
 
 	commonpb "github.com/GoogleCloudPlatform/esp-v2/src/go/proto/api/envoy/http/common"
 	pmpb "github.com/GoogleCloudPlatform/esp-v2/src/go/proto/api/envoy/http/path_matcher"
 	scpb "github.com/GoogleCloudPlatform/esp-v2/src/go/proto/api/envoy/http/service_control"
+	descpb "github.com/golang/protobuf/protoc-gen-go/descriptor"
 	durationpb "github.com/golang/protobuf/ptypes/duration"
 	annotationspb "google.golang.org/genproto/googleapis/api/annotations"
 	confpb "google.golang.org/genproto/googleapis/api/serviceconfig"
+	smpb "google.golang.org/genproto/googleapis/api/servicemanagement/v1"
 )
 
+// fetchJwks fetches the JWKS of a JWT provider, mocked in tests.
+var fetchJwks = util.FetchJwks
+
+// httpHeaderName matches the valid names of the HTTP headers, the tokens of
+// RFC 7230.
+var httpHeaderName = regexp.MustCompile("^[A-Za-z0-9!#$%&'*+.^_`|~-]+$")
+
 // ServiceInfo contains service level information.
 type ServiceInfo struct {
 	Name     string
@@ -67,6 +81,53 @@ type ServiceInfo struct {
 	GrpcSupportRequired    bool
 	CatchAllBackend        *BackendRoutingCluster
 	BackendRoutingClusters []*BackendRoutingCluster
+	// Retry policy of the catch-all route, and the methods without their own.
+	DefaultBackendRetry *BackendRetryPolicy
+	// Header rule of all the requests, nil if none.
+	GlobalHeaderRule *options.HeaderRuleOptions
+	// IP ACL of the requests of the methods without their own, nil if none.
+	GlobalIpAcl *options.IpAclOptions
+	// Cluster of the external authorization server, nil if disabled, and the
+	// path prefixing the checked paths of an HTTP server.
+	ExtAuthzCluster    *BackendRoutingCluster
+	ExtAuthzPathPrefix string
+	// Token-bucket limit of the requests to all the methods, nil if
+	// unlimited.
+	GlobalRateLimit *scpb.RateLimit
+	// Transport of the service control calls, nil for the HTTP calls of
+	// Google Service Control with the access token.
+	ServiceControlTransport *scpb.ServiceControlTransport
+	// Cluster of the Envoy rate limit service, nil if disabled.
+	RateLimitServiceCluster *BackendRoutingCluster
+	// Cluster of the gRPC Access Log Service, nil if disabled.
+	AccessLogServiceCluster *BackendRoutingCluster
+	// xDS server of the endpoints of the backend in --backend_address, nil if
+	// they are resolved with DNS.
+	BackendEdsServer *BackendRoutingCluster
+	// Custom labels of the operations reported to Service Control.
+	ReportLabels []*scpb.ReportLabel
+
+	// JWKS of the JWT providers with local JWKS, by provider id.
+	LocalJwks map[string]string
+	// Local JWKS files of the JWT providers, by provider id.
+	LocalJwksFiles map[string]string
+	// How the JWKS of the remote JWT providers are fetched, by provider id.
+	RemoteJwks map[string]*RemoteJwksInfo
+
+	// Proto descriptor set of the gRPC-JSON transcoder, read from
+	// --transcoding_descriptor_path if set, otherwise from the service config.
+	// Nil if the service config has none.
+	TranscodingDescriptor []byte
+}
+
+// RemoteJwksInfo stores how the JWKS of a remote JWT provider are fetched.
+type RemoteJwksInfo struct {
+	Uri           string
+	CacheDuration time.Duration
+	// If true, the JWKS are fetched by the config manager and inlined, and
+	// are in LocalJwks if the fetch succeeded.
+	AsyncFetch   bool
+	FetchRetries int
 }
 
 type BackendRoutingCluster struct {
@@ -75,6 +136,31 @@ type BackendRoutingCluster struct {
 	Port        uint32
 	UseTLS      bool
 	Protocol    util.BackendProtocol
+	// Path of the Unix domain socket of the backend, used instead of Hostname
+	// and Port if set.
+	SocketPath string
+
+	// Overrides of the connect timeout, 0 if none, and of the TLS settings of
+	// the cluster, nil if none.
+	ConnectTimeout time.Duration
+	ClusterOptions *options.BackendClusterOptions
+	// Hostnames of the backend in other regions, sharing the cluster with
+	// Hostname. Empty if the backend is in one region.
+	RegionHostnames []string
+	// Hostnames of the backend in other regions the requests fail over to,
+	// in order, when the backend is unhealthy.
+	FailoverHostnames []string
+	// Other endpoints of the backend, sharing the cluster with Hostname.
+	Endpoints []util.Endpoint
+}
+
+// LoadBalancing returns the load balancing policy of the backend, the one of
+// its cluster settings if set, or the one of the flags in opts.
+func (b *BackendRoutingCluster) LoadBalancing(opts *options.ConfigGeneratorOptions) *options.LoadBalancingOptions {
+	if b.ClusterOptions != nil && b.ClusterOptions.LoadBalancing != nil {
+		return b.ClusterOptions.LoadBalancing
+	}
+	return &opts.BackendLoadBalancing
 }
 
 // NewServiceInfoFromServiceConfig returns an instance of ServiceInfo.
@@ -94,6 +180,9 @@ func NewServiceInfoFromServiceConfig(serviceConfig *confpb.Service, id string, o
 		Methods:                            make(map[string]*methodInfo),
 		TranscoderIgnoredJwtQueryParams:    make(map[string]bool),
 		TranscoderIgnoredApiKeyQueryParams: make(map[string]bool),
+		LocalJwks:                          make(map[string]string),
+		LocalJwksFiles:                     make(map[string]string),
+		RemoteJwks:                         make(map[string]*RemoteJwksInfo),
 	}
 
 	// Calling order is required due to following variable usage
@@ -108,16 +197,52 @@ func NewServiceInfoFromServiceConfig(serviceConfig *confpb.Service, id string, o
 	//     used by addGrpcHttpRules
 	// * Methods:
 	//		 set by processApis, processHttpRule, addGrpcHttpRules, processUsageRule
-	//     used by processApiKeyLocations
+	//     used by processApiKeyLocations, processJwtAudiences,
+	//     processJwtClaimHeaders, processAuthorizationRules
 	if err := serviceInfo.buildCatchAllBackend(); err != nil {
 		return nil, err
 	}
 	serviceInfo.processEndpoints()
 	serviceInfo.processApis()
-	serviceInfo.processQuota()
+	if err := serviceInfo.processQuota(); err != nil {
+		return nil, fmt.Errorf("fail to process quota: %v", err)
+	}
 	if err := serviceInfo.processBackendRule(); err != nil {
 		return nil, err
 	}
+	if err := serviceInfo.processBackendSplits(); err != nil {
+		return nil, fmt.Errorf("fail to process backend splits: %v", err)
+	}
+	if err := serviceInfo.processBackendMirrors(); err != nil {
+		return nil, fmt.Errorf("fail to process backend mirrors: %v", err)
+	}
+	serviceInfo.processBackendRetry()
+	serviceInfo.processHeaderRules()
+	serviceInfo.processPathRewrites()
+	serviceInfo.processResponseCaches()
+	if err := serviceInfo.processFaultInjections(); err != nil {
+		return nil, err
+	}
+	serviceInfo.processDirectResponses()
+	serviceInfo.processCorsPolicies()
+	serviceInfo.processIpAcls()
+	serviceInfo.processWebsocketSelectors()
+	if err := serviceInfo.processTracingSampleRates(); err != nil {
+		return nil, err
+	}
+	if err := serviceInfo.processExtAuthz(); err != nil {
+		return nil, err
+	}
+	if err := serviceInfo.processRateLimits(); err != nil {
+		return nil, err
+	}
+	if err := serviceInfo.processAccessLog(); err != nil {
+		return nil, err
+	}
+	if err := serviceInfo.processReportLabels(); err != nil {
+		return nil, err
+	}
+	serviceInfo.processBodySizeLimits()
 	if err := serviceInfo.processHttpRule(); err != nil {
 		return nil, err
 	}
@@ -126,18 +251,38 @@ func NewServiceInfoFromServiceConfig(serviceConfig *confpb.Service, id string, o
 	}
 
 	serviceInfo.processAccessToken()
+	if err := serviceInfo.processServiceControlTransport(); err != nil {
+		return nil, err
+	}
 	serviceInfo.processTypes()
 	serviceInfo.addGrpcHttpRules()
+	if err := serviceInfo.processTranscodingDescriptor(); err != nil {
+		return nil, err
+	}
 	if err := serviceInfo.processJwtLocations(); err != nil {
 		return nil, err
 	}
 	if err := serviceInfo.processApiKeyLocations(); err != nil {
 		return nil, err
 	}
+	serviceInfo.processPreflightAuth()
+	if err := serviceInfo.processJwtAudiences(); err != nil {
+		return nil, err
+	}
+	if err := serviceInfo.processJwtClaimHeaders(); err != nil {
+		return nil, err
+	}
+	if err := serviceInfo.processAuthorizationRules(); err != nil {
+		return nil, err
+	}
 
+	if err := serviceInfo.processLocalJwks(); err != nil {
+		return nil, err
+	}
 	if err := serviceInfo.processEmptyJwksUriByOpenID(); err != nil {
 		return nil, err
 	}
+	serviceInfo.processRemoteJwks()
 
 	// Sort Methods according to name.
 	for operation := range serviceInfo.Methods {
@@ -150,9 +295,15 @@ func NewServiceInfoFromServiceConfig(serviceConfig *confpb.Service, id string, o
 
 func (s *ServiceInfo) buildCatchAllBackend() error {
 
-	scheme, hostname, port, _, err := util.ParseURI(s.Options.BackendAddress)
-	if err != nil {
-		return fmt.Errorf("error parsing backend uri: %v", err)
+	scheme, socketPath, isUnixSocket := util.ParseUnixSocketURI(s.Options.BackendAddress)
+	var hostname string
+	var port uint32
+	if !isUnixSocket {
+		var err error
+		if scheme, hostname, port, _, err = util.ParseURI(s.Options.BackendAddress); err != nil {
+			return fmt.Errorf("error parsing backend uri: %v", err)
+		}
+		hostname = s.kubernetesServiceHostname(hostname)
 	}
 
 	// For local backend, user cannot configure http protocol explicitly.
@@ -170,6 +321,43 @@ func (s *ServiceInfo) buildCatchAllBackend() error {
 		ClusterName: s.BackendClusterName(),
 		Hostname:    hostname,
 		Port:        port,
+		SocketPath:  socketPath,
+	}
+	return s.processBackendEds()
+}
+
+// processBackendEds sets the xDS server in --backend_eds_server_uri the
+// endpoints of the backend in --backend_address are discovered from.
+func (s *ServiceInfo) processBackendEds() error {
+	if s.Options.BackendEdsServerUri == "" {
+		return nil
+	}
+	if s.CatchAllBackend.SocketPath != "" {
+		return fmt.Errorf("fail to process --backend_eds_server_uri: --backend_address cannot be a Unix domain socket")
+	}
+	if s.Options.BackendEdsServiceName == "" {
+		return fmt.Errorf("fail to process --backend_eds_server_uri: --backend_eds_service_name is required")
+	}
+	scheme, hostname, port, _, err := util.ParseURI(s.Options.BackendEdsServerUri)
+	if err != nil {
+		return fmt.Errorf("fail to parse --backend_eds_server_uri: %v", err)
+	}
+	protocol, tls, err := util.ParseBackendProtocol(scheme, "")
+	if err != nil {
+		return fmt.Errorf("fail to parse --backend_eds_server_uri: %v", err)
+	}
+	if protocol != util.GRPC {
+		return fmt.Errorf(`fail to parse --backend_eds_server_uri: the scheme must be "grpc" or "grpcs", got %s`, scheme)
+	}
+	if s.Options.BackendEdsGoogleCredentials && !tls {
+		return fmt.Errorf(`fail to parse --backend_eds_server_uri: the scheme must be "grpcs" with --backend_eds_google_credentials, got %s`, scheme)
+	}
+	s.BackendEdsServer = &BackendRoutingCluster{
+		ClusterName: util.BackendEdsServerClusterName,
+		Hostname:    hostname,
+		Port:        port,
+		UseTLS:      tls,
+		Protocol:    protocol,
 	}
 	return nil
 }
@@ -179,6 +367,150 @@ func (s *ServiceInfo) ServiceConfig() *confpb.Service {
 	return s.serviceConfig
 }
 
+// processTranscodingDescriptor reads the proto descriptor set of the
+// transcoder. A descriptor set from --transcoding_descriptor_path is validated
+// to define all the APIs, as Envoy rejects the whole listener otherwise.
+func (s *ServiceInfo) processTranscodingDescriptor() error {
+	if s.Options.TranscodingDescriptorPath == "" {
+		for _, sourceFile := range s.serviceConfig.GetSourceInfo().GetSourceFiles() {
+			configFile := &smpb.ConfigFile{}
+			if err := ptypes.UnmarshalAny(sourceFile, configFile); err != nil {
+				continue
+			}
+			if configFile.GetFileType() == smpb.ConfigFile_FILE_DESCRIPTOR_SET_PROTO {
+				s.TranscodingDescriptor = configFile.GetFileContents()
+				return nil
+			}
+		}
+		return nil
+	}
+
+	content, err := ioutil.ReadFile(s.Options.TranscodingDescriptorPath)
+	if err != nil {
+		return fmt.Errorf("fail to read the transcoding descriptor: %v", err)
+	}
+	if err := validateTranscodingDescriptor(content, s.ApiNames); err != nil {
+		return fmt.Errorf("invalid transcoding descriptor %s: %v", s.Options.TranscodingDescriptorPath, err)
+	}
+	s.TranscodingDescriptor = content
+	return nil
+}
+
+func validateTranscodingDescriptor(content []byte, apiNames []string) error {
+	descriptorSet := &descpb.FileDescriptorSet{}
+	if err := proto.Unmarshal(content, descriptorSet); err != nil {
+		return fmt.Errorf("fail to unmarshal the descriptor set: %v", err)
+	}
+	services := make(map[string]bool)
+	for _, file := range descriptorSet.GetFile() {
+		for _, service := range file.GetService() {
+			if file.GetPackage() == "" {
+				services[service.GetName()] = true
+			} else {
+				services[file.GetPackage()+"."+service.GetName()] = true
+			}
+		}
+	}
+	for _, apiName := range apiNames {
+		if !services[apiName] {
+			return fmt.Errorf("service %s is not defined", apiName)
+		}
+	}
+	return nil
+}
+
+// processLocalJwks reads the JWKS of the JWT providers whose jwks_uri is a
+// local file, like "file:///etc/jwks.json", or the JWKS itself. The jwks_uri
+// of the providers in --local_jwks are replaced by their files.
+func (s *ServiceInfo) processLocalJwks() error {
+	files := make(map[string]string)
+	if s.Options.LocalJwks != "" {
+		for _, pair := range strings.Split(s.Options.LocalJwks, ",") {
+			kv := strings.SplitN(pair, "=", 2)
+			if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" || strings.TrimSpace(kv[1]) == "" {
+				return fmt.Errorf(`fail to parse --local_jwks: %q is not "<provider_id>=<path>"`, pair)
+			}
+			files[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+		}
+	}
+
+	for _, provider := range s.ServiceConfig().GetAuthentication().GetProviders() {
+		if path, ok := files[provider.GetId()]; ok {
+			provider.JwksUri = util.LocalJwksPrefix + path
+			delete(files, provider.GetId())
+		}
+
+		jwksUri := strings.TrimSpace(provider.GetJwksUri())
+		switch {
+		case strings.HasPrefix(jwksUri, util.LocalJwksPrefix):
+			path := strings.TrimPrefix(jwksUri, util.LocalJwksPrefix)
+			jwks, err := ioutil.ReadFile(path)
+			if err != nil {
+				return fmt.Errorf("fail to read the local JWKS of JWT provider %s: %v", provider.GetId(), err)
+			}
+			if !json.Valid(jwks) {
+				return fmt.Errorf("fail to parse the local JWKS of JWT provider %s: %s is not JSON", provider.GetId(), path)
+			}
+			s.LocalJwks[provider.GetId()] = string(jwks)
+			s.LocalJwksFiles[provider.GetId()] = path
+		case strings.HasPrefix(jwksUri, "{"):
+			if !json.Valid([]byte(jwksUri)) {
+				return fmt.Errorf("fail to parse the inline JWKS of JWT provider %s", provider.GetId())
+			}
+			s.LocalJwks[provider.GetId()] = jwksUri
+		}
+	}
+
+	for id := range files {
+		return fmt.Errorf("fail to parse --local_jwks: JWT provider %s not found", id)
+	}
+	return nil
+}
+
+// processRemoteJwks sets how the JWKS of the remote JWT providers are fetched,
+// from the flags and their overrides by provider. The JWKS fetched in advance
+// are inlined like the local JWKS, so that no request waits for a fetch by
+// Envoy, which still fetches them if they cannot be fetched here.
+func (s *ServiceInfo) processRemoteJwks() {
+	overrides := make(map[string]*options.JwksProviderOptions)
+	for _, o := range s.Options.JwksProviders {
+		overrides[o.ProviderId] = o
+	}
+	for _, provider := range s.ServiceConfig().GetAuthentication().GetProviders() {
+		if _, ok := s.LocalJwks[provider.GetId()]; ok {
+			continue
+		}
+		info := &RemoteJwksInfo{
+			Uri:           provider.GetJwksUri(),
+			CacheDuration: time.Duration(s.Options.JwksCacheDurationInS) * time.Second,
+			AsyncFetch:    s.Options.JwksAsyncFetch,
+			FetchRetries:  s.Options.JwksFetchRetries,
+		}
+		if o, ok := overrides[provider.GetId()]; ok {
+			if o.CacheDurationInS != nil {
+				info.CacheDuration = time.Duration(*o.CacheDurationInS) * time.Second
+			}
+			if o.AsyncFetch != nil {
+				info.AsyncFetch = *o.AsyncFetch
+			}
+			if o.FetchRetries != nil {
+				info.FetchRetries = *o.FetchRetries
+			}
+		}
+		s.RemoteJwks[provider.GetId()] = info
+
+		if !info.AsyncFetch {
+			continue
+		}
+		jwks, err := fetchJwks(info.Uri, info.FetchRetries)
+		if err != nil {
+			glog.Warningf("JWKS of JWT provider %s are fetched by Envoy instead: %v", provider.GetId(), err)
+			continue
+		}
+		s.LocalJwks[provider.GetId()] = string(jwks)
+	}
+}
+
 func (s *ServiceInfo) processEmptyJwksUriByOpenID() error {
 	authn := s.serviceConfig.GetAuthentication()
 	for _, provider := range authn.GetProviders() {
@@ -236,6 +568,20 @@ func (s *ServiceInfo) addGrpcHttpRules() {
 func (s *ServiceInfo) processAccessToken() {
 	if s.Options.ServiceAccountKey != "" {
 		data, _ := ioutil.ReadFile(s.Options.ServiceAccountKey)
+		if util.IsExternalAccount(data) {
+			// Envoy cannot exchange external account credentials, it fetches
+			// the tokens from the token agent in the config manager instead.
+			s.AccessToken = &commonpb.AccessToken{
+				TokenType: &commonpb.AccessToken_RemoteToken{
+					RemoteToken: &commonpb.HttpUri{
+						Uri:     fmt.Sprintf("http://127.0.0.1:%d%s", s.Options.TokenAgentPort, util.AccessTokenSuffix),
+						Cluster: util.TokenAgentClusterName,
+						Timeout: &durationpb.Duration{Seconds: 5},
+					},
+				},
+			}
+			return
+		}
 		s.AccessToken = &commonpb.AccessToken{
 			TokenType: &commonpb.AccessToken_ServiceAccountSecret{
 				ServiceAccountSecret: &commonpb.DataSource{
@@ -247,6 +593,10 @@ func (s *ServiceInfo) processAccessToken() {
 		}
 		return
 	}
+	// There is no metadata server to fetch the access tokens from.
+	if s.Options.Standalone {
+		return
+	}
 	s.AccessToken = &commonpb.AccessToken{
 		TokenType: &commonpb.AccessToken_RemoteToken{
 			RemoteToken: &commonpb.HttpUri{
@@ -259,17 +609,95 @@ func (s *ServiceInfo) processAccessToken() {
 	}
 }
 
-func (s *ServiceInfo) processQuota() {
+// processServiceControlTransport sets the transport of the service control
+// calls from the options. If any path is overridden, the other ones are the
+// REST paths of Google Service Control, relative to the host.
+func (s *ServiceInfo) processServiceControlTransport() error {
+	o := s.Options
+	transport := &scpb.ServiceControlTransport{
+		CheckPath:  o.ServiceControlCheckPath,
+		QuotaPath:  o.ServiceControlQuotaPath,
+		ReportPath: o.ServiceControlReportPath,
+	}
+	switch o.ServiceControlProtocol {
+	case "", "http":
+		if transport.CheckPath != "" || transport.QuotaPath != "" || transport.ReportPath != "" {
+			if transport.CheckPath == "" {
+				transport.CheckPath = util.ServiceControlRestPathPrefix + "{service}:check"
+			}
+			if transport.QuotaPath == "" {
+				transport.QuotaPath = util.ServiceControlRestPathPrefix + "{service}:allocateQuota"
+			}
+			if transport.ReportPath == "" {
+				transport.ReportPath = util.ServiceControlRestPathPrefix + "{service}:report"
+			}
+		}
+	case "grpc":
+		transport.Protocol = scpb.ServiceControlTransport_GRPC
+	default:
+		return fmt.Errorf("invalid service control protocol %q, it must be http or grpc", o.ServiceControlProtocol)
+	}
+
+	switch o.ServiceControlAuth {
+	case "", "access_token":
+	case "none":
+		transport.Auth = scpb.ServiceControlTransport_NONE
+	case "header":
+		if o.ServiceControlAuthHeaderName == "" {
+			return fmt.Errorf("the service control auth header requires a header name")
+		}
+		if o.ServiceControlAuthHeaderValuePath == "" {
+			return fmt.Errorf("the service control auth header requires the file of its value")
+		}
+		// The value is a secret, so only its file is in the config and the
+		// proxy reads it.
+		transport.Auth = scpb.ServiceControlTransport_HEADER
+		transport.AuthHeaderName = o.ServiceControlAuthHeaderName
+		transport.AuthHeaderValue = &commonpb.DataSource{
+			Specifier: &commonpb.DataSource_Filename{
+				Filename: o.ServiceControlAuthHeaderValuePath,
+			},
+		}
+	default:
+		return fmt.Errorf("invalid service control auth %q, it must be access_token, none or header", o.ServiceControlAuth)
+	}
+
+	if !proto.Equal(transport, &scpb.ServiceControlTransport{}) {
+		s.ServiceControlTransport = transport
+	}
+	return nil
+}
+
+// processQuota sets the metric costs of the operations from the metric rules,
+// sorted by metric name. If the service config has quota limits, the metrics
+// must be the ones of the limits.
+func (s *ServiceInfo) processQuota() error {
+	limitMetrics := make(map[string]bool)
+	for _, limit := range s.ServiceConfig().GetQuota().GetLimits() {
+		limitMetrics[limit.GetMetric()] = true
+	}
 	for _, metricRule := range s.ServiceConfig().GetQuota().GetMetricRules() {
+		method, ok := s.Methods[metricRule.GetSelector()]
+		if !ok {
+			return fmt.Errorf("metric rule selector %q is not an operation", metricRule.GetSelector())
+		}
 		var metricCosts []*scpb.MetricCost
 		for name, cost := range metricRule.GetMetricCosts() {
+			if cost <= 0 {
+				return fmt.Errorf("cost of metric %q of operation %q must be positive, got %d", name, metricRule.GetSelector(), cost)
+			}
+			if len(limitMetrics) > 0 && !limitMetrics[name] {
+				return fmt.Errorf("metric %q of operation %q has no quota limit", name, metricRule.GetSelector())
+			}
 			metricCosts = append(metricCosts, &scpb.MetricCost{
 				Name: name,
 				Cost: cost,
 			})
 		}
-		s.Methods[metricRule.GetSelector()].MetricCosts = metricCosts
+		sort.Slice(metricCosts, func(i, j int) bool { return metricCosts[i].Name < metricCosts[j].Name })
+		method.MetricCosts = metricCosts
 	}
+	return nil
 }
 
 func (s *ServiceInfo) processEndpoints() {
@@ -278,6 +706,11 @@ func (s *ServiceInfo) processEndpoints() {
 			s.AllowCors = true
 		}
 	}
+	// The OPTIONS operations can be generated when CORS is enabled by the
+	// flags too.
+	if s.Options.CorsPreflightOperations && (s.Options.CorsPreset != "" || len(s.Options.CorsPolicies) != 0) {
+		s.AllowCors = true
+	}
 }
 
 func addHttpRule(method *methodInfo, r *annotationspb.HttpRule, httpPathWithOptionsSet map[string]bool) error {
@@ -355,7 +788,7 @@ func (s *ServiceInfo) processHttpRule() error {
 			for _, httpRule := range method.HttpRule {
 				if httpRule.HttpMethod != "OPTIONS" {
 					if _, exist := httpPathWithOptionsSet[httpRule.UriTemplate]; !exist {
-						s.addOptionMethod(method.ApiName, httpRule.UriTemplate, method.BackendInfo)
+						s.addOptionMethod(r.GetSelector(), method.ApiName, httpRule.UriTemplate, method.BackendInfo)
 						httpPathWithOptionsSet[httpRule.UriTemplate] = true
 					}
 
@@ -382,10 +815,27 @@ func (s *ServiceInfo) processHttpRule() error {
 		hcMethod.IsGenerated = true
 	}
 
+	// Add HttpRule for the Check method of the gRPC health checking protocol,
+	// unless the method is already in the service config.
+	if s.Options.HealthGrpcService != "" {
+		hcMethod, err := s.getOrCreateMethod("grpc.health.v1.Health.Check")
+		if err != nil {
+			return err
+		}
+		if len(hcMethod.HttpRule) == 0 {
+			hcMethod.HttpRule = append(hcMethod.HttpRule, &commonpb.Pattern{
+				UriTemplate: util.GrpcHealthCheckPath,
+				HttpMethod:  util.POST,
+			})
+			hcMethod.SkipServiceControl = true
+			hcMethod.IsGenerated = true
+		}
+	}
+
 	return nil
 }
 
-func (s *ServiceInfo) addOptionMethod(apiName string, path string, backendInfo *backendInfo) {
+func (s *ServiceInfo) addOptionMethod(operation, apiName string, path string, backendInfo *backendInfo) {
 	// All options have their operation as the following format: CORS_${suffix}.
 	// Appends ${suffix} to make sure it is not used by any http rules.
 	//
@@ -412,11 +862,38 @@ func (s *ServiceInfo) addOptionMethod(apiName string, path string, backendInfo *
 		},
 		IsGenerated: true,
 		BackendInfo: backendInfo,
+
+		SkipServiceControl: s.Options.CorsPreflightSkipServiceControl,
+	}
+	if s.Options.CorsPreflightRequireAuth {
+		s.Methods[genOperation].PreflightOperation = operation
+	}
+}
+
+// processPreflightAuth makes the generated OPTIONS methods requiring
+// authentication require the API key of their operation, like their JWT.
+func (s *ServiceInfo) processPreflightAuth() {
+	for _, method := range s.Methods {
+		if method.PreflightOperation == "" {
+			continue
+		}
+		if operation, ok := s.Methods[method.PreflightOperation]; ok {
+			method.AllowUnregisteredCalls = operation.AllowUnregisteredCalls
+			method.ApiKeyLocations = operation.ApiKeyLocations
+		}
 	}
 }
 
 func (s *ServiceInfo) processBackendRule() error {
-	backendRoutingClustersMap := make(map[string]string)
+	// Backends authenticated with OAuth2 access tokens, by host and port.
+	oauth2Backends := make(map[string]bool)
+	for _, o := range s.Options.BackendOAuth2 {
+		_, hostname, port, _, err := util.ParseURI(o.BackendAddress)
+		if err != nil {
+			return err
+		}
+		oauth2Backends[fmt.Sprintf("%v:%v", hostname, port)] = true
+	}
 
 	for _, r := range s.ServiceConfig().Backend.GetRules() {
 		if r.Address != "" {
@@ -424,34 +901,13 @@ func (s *ServiceInfo) processBackendRule() error {
 			if err != nil {
 				return err
 			}
-			if net.ParseIP(hostname) != nil {
-				return fmt.Errorf("dynamic routing only supports domain name, got IP address: %v", hostname)
-			}
 			address := fmt.Sprintf("%v:%v", hostname, port)
 
-			if _, exist := backendRoutingClustersMap[address]; !exist {
-				protocol, tls, err := util.ParseBackendProtocol(scheme, r.Protocol)
-				if err != nil {
-					return err
-				}
-				if protocol == util.GRPC {
-					s.GrpcSupportRequired = true
-				}
-
-				backendSelector := address
-				s.BackendRoutingClusters = append(s.BackendRoutingClusters,
-					&BackendRoutingCluster{
-						ClusterName: backendSelector,
-						UseTLS:      tls,
-						Protocol:    protocol,
-						Hostname:    hostname,
-						Port:        port,
-					})
-				backendRoutingClustersMap[address] = backendSelector
+			brc, err := s.getOrCreateBackendRoutingCluster(scheme, hostname, port, r.Protocol)
+			if err != nil {
+				return err
 			}
 
-			clusterName := backendRoutingClustersMap[address]
-
 			method, err := s.getOrCreateMethod(r.GetSelector())
 			if err != nil {
 				return err
@@ -463,28 +919,16 @@ func (s *ServiceInfo) processBackendRule() error {
 				uri = "/"
 			}
 
-			var deadline time.Duration
-			if r.Deadline == 0 {
-				// If no deadline specified by the user, explicitly use default.
-				deadline = util.DefaultResponseDeadline
-			} else if r.Deadline < 0 {
-				glog.Warningf("Negative deadline of %v specified for method %v. "+
-					"Using default deadline %v instead.", r.Deadline, address, util.DefaultResponseDeadline)
-				deadline = util.DefaultResponseDeadline
-			} else {
-				// The backend deadline from the BackendRule is a float64 that represents seconds.
-				// But float64 has a large precision, so we must explicitly lower the precision.
-				// For the purposes of a network proxy, round the deadline to the nearest millisecond.
-				deadlineMs := int64(math.Round(r.Deadline * 1000))
-				deadline = time.Duration(deadlineMs) * time.Millisecond
-			}
-
 			method.BackendInfo = &backendInfo{
-				ClusterName:     clusterName,
+				ClusterName:     brc.ClusterName,
 				Uri:             uri,
 				Hostname:        hostname,
+				AutoHostRewrite: len(brc.RegionHostnames) > 0 || len(brc.FailoverHostnames) > 0,
 				TranslationType: r.PathTranslation,
-				Deadline:        deadline,
+				Deadline:        backendDeadline(r, address),
+			}
+			if lb := brc.LoadBalancing(&s.Options); lb.IsConsistentHash() {
+				method.BackendInfo.LoadBalancing = lb
 			}
 
 			//TODO(taoxuy): b/149334660 Check if the scopes for IAM include the path prefix
@@ -495,86 +939,996 @@ func (s *ServiceInfo) processBackendRule() error {
 				if r.GetDisableAuth() {
 					break
 				}
-				method.BackendInfo.JwtAudience = getJwtAudienceFromBackendAddr(scheme, hostname)
+				method.BackendInfo.JwtAudience = getJwtAudienceFromBackendAddr(scheme, hostname, uri)
 			default:
-				method.BackendInfo.JwtAudience = getJwtAudienceFromBackendAddr(scheme, hostname)
+				method.BackendInfo.JwtAudience = getJwtAudienceFromBackendAddr(scheme, hostname, uri)
+			}
+			if o := brc.ClusterOptions; o != nil && len(o.IamDelegates) > 0 && method.BackendInfo.JwtAudience != "" {
+				if s.Options.BackendAuthCredentials == nil {
+					return fmt.Errorf("iam_delegates of backend %s require --backend_auth_iam_service_account", address)
+				}
+				method.BackendInfo.IamDelegates = o.IamDelegates
+			}
+
+			// The OAuth2 client credentials grant replaces the identity token,
+			// as the backend is not a Google service.
+			if oauth2Backends[address] {
+				method.BackendInfo.JwtAudience = ""
+				method.BackendInfo.IamDelegates = nil
+				method.BackendInfo.AccessTokenUri = fmt.Sprintf("http://127.0.0.1:%d%s", s.Options.TokenAgentPort, util.BackendOAuth2TokenSuffix(address))
 			}
+		} else if r.Deadline != 0 {
+			// The deadline of an operation served by the local backend is set
+			// on its own routes, instead of the default one of the catch-all route.
+			method, err := s.getOrCreateMethod(r.GetSelector())
+			if err != nil {
+				return err
+			}
+			method.LocalBackendDeadline = backendDeadline(r, s.Options.BackendAddress)
 		}
 	}
 	return nil
 }
 
-func (s *ServiceInfo) processUsageRule() error {
-	for _, r := range s.ServiceConfig().GetUsage().GetRules() {
-		method, err := s.getOrCreateMethod(r.GetSelector())
-		if err != nil {
-			return err
+// getOrCreateBackendRoutingCluster returns the dynamic routing cluster of the
+// backend at hostname and port, created with its settings in
+// --backend_cluster_config if it does not exist yet.
+func (s *ServiceInfo) getOrCreateBackendRoutingCluster(scheme, hostname string, port uint32, ruleProtocol string) (*BackendRoutingCluster, error) {
+	hostname = s.kubernetesServiceHostname(hostname)
+	address := fmt.Sprintf("%v:%v", hostname, port)
+	for _, c := range s.BackendRoutingClusters {
+		if c.ClusterName == address {
+			return c, nil
 		}
-		method.AllowUnregisteredCalls = r.GetAllowUnregisteredCalls()
-		method.SkipServiceControl = r.GetSkipServiceControl()
 	}
-	return nil
-}
 
-func (s *ServiceInfo) processJwtLocations() error {
-	authn := s.serviceConfig.GetAuthentication()
-	for _, provider := range authn.GetProviders() {
-		// no custom JwtLocation so use default ones and set the one in query
-		// parameter for transcoder to ignore.
-		if len(provider.JwtLocations) == 0 {
-			s.TranscoderIgnoredJwtQueryParams[util.DefaultJwtQueryParamAccessToken] = true
+	protocol, tls, err := util.ParseBackendProtocol(scheme, ruleProtocol)
+	if err != nil {
+		return nil, err
+	}
+	// The managed domains of the serverless platforms only serve TLS, with
+	// the SNI of the backend host.
+	if util.IsServerlessHost(hostname) && !tls {
+		return nil, fmt.Errorf("backend %s is on a serverless platform, which requires https or grpcs", address)
+	}
+	if protocol == util.GRPC {
+		s.GrpcSupportRequired = true
+	}
+	brc := &BackendRoutingCluster{
+		ClusterName: address,
+		UseTLS:      tls,
+		Protocol:    protocol,
+		Hostname:    hostname,
+		Port:        port,
+	}
+	for _, o := range s.Options.BackendClusters {
+		_, oHostname, oPort, _, err := util.ParseURI(o.BackendAddress)
+		if err != nil {
+			return nil, err
+		}
+		if s.kubernetesServiceHostname(oHostname) != hostname || oPort != port {
 			continue
 		}
-
-		for _, jwtLocation := range provider.JwtLocations {
-			switch jwtLocation.In.(type) {
-			case *confpb.JwtLocation_Query:
-				if jwtLocation.ValuePrefix != "" {
-					return fmt.Errorf("JwtLocation_Query should be set without valuePrefix, get JwtLocation {%v}", jwtLocation)
-				}
-				// set the custom JwtLocation in query parameter for transcoder to ignore.
-				s.TranscoderIgnoredJwtQueryParams[jwtLocation.GetQuery()] = true
-			default:
-				continue
-			}
+		if o.HasTLS() && !tls {
+			return nil, fmt.Errorf("TLS settings of backend %s in --backend_cluster_config require https or grpcs", address)
+		}
+		brc.ConnectTimeout = secondsToDuration(o.ConnectTimeout)
+		brc.ClusterOptions = o
+		if brc.RegionHostnames, err = regionHostnames("region", o.RegionAddresses, scheme, hostname, port); err != nil {
+			return nil, err
+		}
+		if brc.FailoverHostnames, err = regionHostnames("failover", o.FailoverAddresses, scheme, hostname, port); err != nil {
+			return nil, err
+		}
+		if brc.Endpoints, err = backendEndpoints(o, port); err != nil {
+			return nil, err
 		}
 	}
-	return nil
+	s.BackendRoutingClusters = append(s.BackendRoutingClusters, brc)
+	return brc, nil
 }
 
-func (s *ServiceInfo) processApiKeyLocations() error {
-	for _, rule := range s.ServiceConfig().GetSystemParameters().GetRules() {
-		apiKeyLocationParameters := []*confpb.SystemParameter{}
-
-		for _, parameter := range rule.GetParameters() {
-			if parameter.GetName() == util.ApiKeyParameterName {
-				apiKeyLocationParameters = append(apiKeyLocationParameters, parameter)
-			}
-		}
+// kubernetesServiceHostname returns the DNS name of the backend hostname in
+// the Kubernetes namespace of the proxy, if any.
+func (s *ServiceInfo) kubernetesServiceHostname(hostname string) string {
+	return util.KubernetesServiceHostname(hostname, s.Options.KubernetesNamespace, s.Options.KubernetesClusterDomain)
+}
 
-		method, err := s.getOrCreateMethod(rule.GetSelector())
+// regionHostnames returns the hostnames of the region or failover addresses,
+// as named by kind, of the backend at hostname and port, which must have its
+// scheme and port, and no path.
+func regionHostnames(kind string, addresses []string, scheme, hostname string, port uint32) ([]string, error) {
+	if len(addresses) == 0 {
+		return nil, nil
+	}
+	// The regions are resolved with DNS, in the same cluster.
+	if net.ParseIP(hostname) != nil {
+		return nil, fmt.Errorf("backend %s:%v with %s addresses must not be an IP address", hostname, port, kind)
+	}
+	var hostnames []string
+	for _, a := range addresses {
+		rScheme, rHostname, rPort, rUri, err := util.ParseURI(a)
 		if err != nil {
-			return err
+			return nil, err
 		}
-
-		s.extractApiKeyLocations(method, apiKeyLocationParameters)
+		if rScheme != scheme || rPort != port || rUri != "" || net.ParseIP(rHostname) != nil {
+			return nil, fmt.Errorf("%s address %s of backend %s:%v must have its scheme and port, a hostname and no path", kind, a, hostname, port)
+		}
+		hostnames = append(hostnames, rHostname)
 	}
+	return hostnames, nil
+}
 
-	for _, method := range s.Methods {
-		// If any of method is not set with custom ApiKeyLocations, use the default
-		// one and set the custom ApiKeyLocations in query parameter for transcoder
-		// to ignore.
-		if len(method.ApiKeyLocations) == 0 {
-			s.TranscoderIgnoredApiKeyQueryParams[util.DefaultApiKeyQueryParamKey] = true
-			s.TranscoderIgnoredApiKeyQueryParams[util.DefaultApiKeyQueryParamApiKey] = true
+// backendEndpoints returns the other endpoints of the backend, with the port
+// of the backend if they have none.
+func backendEndpoints(o *options.BackendClusterOptions, port uint32) ([]util.Endpoint, error) {
+	var endpoints []util.Endpoint
+	for _, e := range o.Endpoints {
+		endpoint := util.Endpoint{Hostname: e, Port: port}
+		if h, p, err := net.SplitHostPort(e); err == nil {
+			n, err := strconv.ParseUint(p, 10, 16)
+			if err != nil || n == 0 {
+				return nil, fmt.Errorf("invalid port of endpoint %s of backend %s", e, o.BackendAddress)
+			}
+			endpoint = util.Endpoint{Hostname: h, Port: uint32(n)}
+		}
+		if endpoint.Hostname == "" || (strings.ContainsAny(endpoint.Hostname, "/:") && net.ParseIP(endpoint.Hostname) == nil) {
+			return nil, fmt.Errorf("endpoint %s of backend %s must be a host or host:port", e, o.BackendAddress)
 		}
+		endpoints = append(endpoints, endpoint)
+	}
+	return endpoints, nil
+}
 
+// backendDeadline returns the response timeout of the backend at address in
+// the BackendRule.
+func backendDeadline(r *confpb.BackendRule, address string) time.Duration {
+	if r.Deadline == 0 {
+		// If no deadline specified by the user, explicitly use default.
+		return util.DefaultResponseDeadline
 	}
+	if r.Deadline < 0 {
+		glog.Warningf("Negative deadline of %v specified for method %v. "+
+			"Using default deadline %v instead.", r.Deadline, address, util.DefaultResponseDeadline)
+		return util.DefaultResponseDeadline
+	}
+	// The backend deadline from the BackendRule is a float64 that represents seconds.
+	// But float64 has a large precision, so we must explicitly lower the precision.
+	// For the purposes of a network proxy, round the deadline to the nearest millisecond.
+	return secondsToDuration(r.Deadline)
+}
 
-	return nil
+// secondsToDuration rounds the float64 seconds to the nearest millisecond.
+func secondsToDuration(seconds float64) time.Duration {
+	return time.Duration(int64(math.Round(seconds*1000))) * time.Millisecond
 }
 
-func (s *ServiceInfo) extractApiKeyLocations(method *methodInfo, parameters []*confpb.SystemParameter) {
+// processBackendSplits splits the requests of the methods in BackendSplits
+// between their weighted backends, each with its own dynamic routing cluster.
+// Unknown selectors are ignored.
+func (s *ServiceInfo) processBackendSplits() error {
+	for _, o := range s.Options.BackendSplits {
+		method, ok := s.Methods[o.Selector]
+		if !ok {
+			continue
+		}
+		var split []*WeightedBackend
+		names := make(map[string]bool)
+		var totalWeight uint32
+		for _, b := range o.Backends {
+			if b.Name == "" || b.Address == "" {
+				return fmt.Errorf("name and address of the backends of selector %s are required", o.Selector)
+			}
+			if names[b.Name] {
+				return fmt.Errorf("duplicate backend %s for selector %s", b.Name, o.Selector)
+			}
+			names[b.Name] = true
+			scheme, hostname, port, uri, err := util.ParseURI(b.Address)
+			if err != nil {
+				return err
+			}
+			// The path of the requests is translated with the BackendRule of the
+			// method, the same for all its backends.
+			if uri != "" {
+				return fmt.Errorf("address %s of backend %s for selector %s must not have a path", b.Address, b.Name, o.Selector)
+			}
+			brc, err := s.getOrCreateBackendRoutingCluster(scheme, hostname, port, "")
+			if err != nil {
+				return err
+			}
+			split = append(split, &WeightedBackend{
+				Name:        b.Name,
+				ClusterName: brc.ClusterName,
+				Weight:      b.Weight,
+			})
+			totalWeight += b.Weight
+		}
+		if totalWeight == 0 {
+			return fmt.Errorf("backends of selector %s must have a positive total weight", o.Selector)
+		}
+		method.BackendSplit = split
+	}
+	return nil
+}
+
+// processBackendMirrors mirrors the requests of the methods in BackendMirrors
+// to their secondary backend, with its own dynamic routing cluster. Unknown
+// selectors are ignored.
+func (s *ServiceInfo) processBackendMirrors() error {
+	for _, o := range s.Options.BackendMirrors {
+		method, ok := s.Methods[o.Selector]
+		if !ok {
+			continue
+		}
+		scheme, hostname, port, uri, err := util.ParseURI(o.Address)
+		if err != nil {
+			return err
+		}
+		// The path of the requests is translated like for the backend of the
+		// method.
+		if uri != "" {
+			return fmt.Errorf("address %s of the backend mirror for selector %s must not have a path", o.Address, o.Selector)
+		}
+		brc, err := s.getOrCreateBackendRoutingCluster(scheme, hostname, port, "")
+		if err != nil {
+			return err
+		}
+		fraction := o.Fraction
+		if fraction == 0 {
+			fraction = 1
+		}
+		method.BackendMirror = &BackendMirror{
+			ClusterName: brc.ClusterName,
+			Fraction:    fraction,
+		}
+	}
+	return nil
+}
+
+// processBackendRetry sets the retry policy of the methods, from the policy of
+// their selector, or the default one for "*" in DefaultBackendRetry.
+func (s *ServiceInfo) processBackendRetry() {
+	policies := make(map[string]*BackendRetryPolicy)
+	for _, o := range s.Options.BackendRetry {
+		policies[o.Selector] = &BackendRetryPolicy{
+			NumRetries:    o.NumRetries,
+			RetryOn:       o.RetryOn,
+			PerTryTimeout: secondsToDuration(o.PerTryTimeout),
+			Hedge:         o.Hedge,
+		}
+	}
+	if len(policies) == 0 {
+		return
+	}
+	s.DefaultBackendRetry = policies["*"]
+	for selector, method := range s.Methods {
+		if policy, ok := policies[selector]; ok {
+			method.BackendRetry = policy
+		} else {
+			method.BackendRetry = policies["*"]
+		}
+	}
+}
+
+// processHeaderRules sets the header rules of the methods, and the one of all
+// the requests for "*" in GlobalHeaderRule. Unknown selectors are ignored.
+func (s *ServiceInfo) processHeaderRules() {
+	for _, o := range s.Options.HeaderRules {
+		if o.Selector == "*" {
+			s.GlobalHeaderRule = o
+			continue
+		}
+		if method, ok := s.Methods[o.Selector]; ok {
+			method.HeaderRule = o
+		}
+	}
+}
+
+// processPathRewrites sets the rewrites of the paths of the methods. Unknown
+// selectors are ignored.
+func (s *ServiceInfo) processPathRewrites() {
+	for _, o := range s.Options.PathRewrites {
+		if method, ok := s.Methods[o.Selector]; ok {
+			method.PathRewrite = o
+		}
+	}
+}
+
+// processFaultInjections sets the faults injected in the requests of the
+// methods, which require the FaultInjectionHeader. Unknown selectors are
+// ignored.
+func (s *ServiceInfo) processFaultInjections() error {
+	if len(s.Options.FaultInjections) == 0 {
+		return nil
+	}
+	if s.Options.FaultInjectionHeader == "" {
+		return fmt.Errorf("fault injections require --fault_injection_header")
+	}
+	for _, o := range s.Options.FaultInjections {
+		if method, ok := s.Methods[o.Selector]; ok {
+			method.FaultInjection = o
+		}
+	}
+	return nil
+}
+
+// processDirectResponses sets the canned responses of the methods. Unknown
+// selectors are ignored.
+func (s *ServiceInfo) processDirectResponses() {
+	for _, o := range s.Options.DirectResponses {
+		if method, ok := s.Methods[o.Selector]; ok {
+			method.DirectResponse = o
+		}
+	}
+}
+
+// processResponseCaches sets the caches of the responses of the methods. The
+// selector "*" applies to the methods without their own cache. Unknown
+// selectors are ignored.
+func (s *ServiceInfo) processResponseCaches() {
+	var global *options.ResponseCacheOptions
+	for _, o := range s.Options.ResponseCaches {
+		if o.Selector == "*" {
+			global = o
+			continue
+		}
+		if method, ok := s.Methods[o.Selector]; ok {
+			method.ResponseCache = o
+		}
+	}
+	if global == nil {
+		return
+	}
+	for _, method := range s.Methods {
+		if method.ResponseCache == nil {
+			method.ResponseCache = global
+		}
+	}
+}
+
+// processCorsPolicies sets the CORS policies of the methods, and the one for
+// "*" on the methods without their own. Unknown selectors are ignored.
+func (s *ServiceInfo) processCorsPolicies() {
+	var global *options.CorsPolicyOptions
+	for _, o := range s.Options.CorsPolicies {
+		if o.Selector == "*" {
+			global = o
+			continue
+		}
+		if method, ok := s.Methods[o.Selector]; ok {
+			method.CorsPolicy = o
+		}
+	}
+	if global == nil {
+		return
+	}
+	for _, method := range s.Methods {
+		if method.CorsPolicy == nil {
+			method.CorsPolicy = global
+		}
+	}
+}
+
+// processIpAcls sets the IP ACLs of the methods, and the global one for "*".
+// Unknown selectors are ignored.
+func (s *ServiceInfo) processIpAcls() {
+	for _, o := range s.Options.IpAcls {
+		if o.Selector == "*" {
+			s.GlobalIpAcl = o
+			continue
+		}
+		if method, ok := s.Methods[o.Selector]; ok {
+			method.IpAcl = o
+		}
+	}
+}
+
+// processWebsocketSelectors allows WebSocket upgrades on the routes of the
+// methods in --websocket_selectors.
+func (s *ServiceInfo) processWebsocketSelectors() {
+	if s.Options.WebsocketSelectors == "" {
+		return
+	}
+	for _, selector := range strings.Split(s.Options.WebsocketSelectors, ",") {
+		if method, ok := s.Methods[strings.TrimSpace(selector)]; ok {
+			method.EnableWebsocket = true
+		}
+	}
+}
+
+// processTracingSampleRates sets the fraction of the requests traced on the
+// routes of the methods in --tracing_operation_sample_rates. Unknown selectors
+// are ignored.
+func (s *ServiceInfo) processTracingSampleRates() error {
+	if s.Options.TracingOperationSampleRates == "" || s.Options.DisableTracing {
+		return nil
+	}
+	for _, pair := range strings.Split(s.Options.TracingOperationSampleRates, ",") {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" {
+			return fmt.Errorf(`fail to parse --tracing_operation_sample_rates: %q is not "<selector>=<rate>"`, pair)
+		}
+		rate, err := strconv.ParseFloat(strings.TrimSpace(kv[1]), 64)
+		if err != nil || rate < 0.0 || rate > 1.0 {
+			return fmt.Errorf("fail to parse --tracing_operation_sample_rates: rate of %s must be >= 0.0 and <= 1.0, got %q", strings.TrimSpace(kv[0]), kv[1])
+		}
+		if method, ok := s.Methods[strings.TrimSpace(kv[0])]; ok {
+			method.TracingSampleRate = &rate
+		}
+	}
+	return nil
+}
+
+// processExtAuthz sets the cluster of the external authorization server in
+// --ext_authz_uri, and disables the external authorization of the methods in
+// --ext_authz_disabled_selectors.
+func (s *ServiceInfo) processExtAuthz() error {
+	if s.Options.ExtAuthzUri == "" {
+		return nil
+	}
+	scheme, hostname, port, path, err := util.ParseURI(s.Options.ExtAuthzUri)
+	if err != nil {
+		return fmt.Errorf("fail to parse --ext_authz_uri: %v", err)
+	}
+	protocol, tls, err := util.ParseBackendProtocol(scheme, "")
+	if err != nil {
+		return fmt.Errorf("fail to parse --ext_authz_uri: %v", err)
+	}
+	if protocol == util.GRPC && path != "" {
+		return fmt.Errorf("fail to parse --ext_authz_uri: the uri of a gRPC server cannot have a path, got %s", path)
+	}
+	s.ExtAuthzCluster = &BackendRoutingCluster{
+		ClusterName: util.ExtAuthzClusterName,
+		Hostname:    hostname,
+		Port:        port,
+		UseTLS:      tls,
+		Protocol:    protocol,
+	}
+	s.ExtAuthzPathPrefix = path
+
+	if s.Options.ExtAuthzDisabledSelectors == "" {
+		return nil
+	}
+	for _, selector := range strings.Split(s.Options.ExtAuthzDisabledSelectors, ",") {
+		if method, ok := s.Methods[strings.TrimSpace(selector)]; ok {
+			method.DisableExtAuthz = true
+		}
+	}
+	return nil
+}
+
+// processRateLimits sets the token-bucket limits of the requests to all the
+// methods and to each method, enforced by the Service Control filter, and the
+// cluster of the rate limit service in --rate_limit_service_uri.
+func (s *ServiceInfo) processRateLimits() error {
+	hasLocalLimits := false
+	if s.Options.RateLimitRequestsPerSecond != 0 {
+		s.GlobalRateLimit = &scpb.RateLimit{
+			RequestsPerSecond: s.Options.RateLimitRequestsPerSecond,
+			Burst:             s.Options.RateLimitBurst,
+		}
+		hasLocalLimits = true
+	} else if s.Options.RateLimitBurst != 0 {
+		return fmt.Errorf("fail to process rate limits: --rate_limit_burst requires --rate_limit_requests_per_second")
+	}
+	for _, o := range s.Options.RateLimits {
+		if o.RequestsPerSecond == 0 {
+			if o.Burst != 0 {
+				return fmt.Errorf("fail to process rate limits: burst of selector %s requires requests_per_second", o.Selector)
+			}
+			continue
+		}
+		method, ok := s.Methods[o.Selector]
+		if !ok {
+			continue
+		}
+		method.RateLimit = &scpb.RateLimit{
+			RequestsPerSecond: o.RequestsPerSecond,
+			Burst:             o.Burst,
+		}
+		hasLocalLimits = true
+	}
+	if hasLocalLimits && (s.Options.SkipServiceControlFilter || s.ServiceConfig().GetControl().GetEnvironment() == "") {
+		return fmt.Errorf("fail to process rate limits: the rate limits are enforced by the Service Control filter, which is disabled")
+	}
+
+	if s.Options.RateLimitServiceUri == "" {
+		return nil
+	}
+	scheme, hostname, port, _, err := util.ParseURI(s.Options.RateLimitServiceUri)
+	if err != nil {
+		return fmt.Errorf("fail to parse --rate_limit_service_uri: %v", err)
+	}
+	protocol, tls, err := util.ParseBackendProtocol(scheme, "")
+	if err != nil {
+		return fmt.Errorf("fail to parse --rate_limit_service_uri: %v", err)
+	}
+	if protocol != util.GRPC {
+		return fmt.Errorf(`fail to parse --rate_limit_service_uri: the scheme must be "grpc" or "grpcs", got %s`, scheme)
+	}
+	s.RateLimitServiceCluster = &BackendRoutingCluster{
+		ClusterName: util.RateLimitServiceClusterName,
+		Hostname:    hostname,
+		Port:        port,
+		UseTLS:      tls,
+		Protocol:    protocol,
+	}
+	return nil
+}
+
+// processAccessLog sets the cluster of the gRPC Access Log Service in
+// --access_log_service_uri, and disables the access logs of the methods in
+// --access_log_disabled_selectors.
+func (s *ServiceInfo) processAccessLog() error {
+	if s.Options.AccessLogDisabledSelectors != "" {
+		for _, selector := range strings.Split(s.Options.AccessLogDisabledSelectors, ",") {
+			if method, ok := s.Methods[strings.TrimSpace(selector)]; ok {
+				method.DisableAccessLog = true
+			}
+		}
+	}
+
+	if s.Options.AccessLogServiceUri == "" {
+		return nil
+	}
+	scheme, hostname, port, _, err := util.ParseURI(s.Options.AccessLogServiceUri)
+	if err != nil {
+		return fmt.Errorf("fail to parse --access_log_service_uri: %v", err)
+	}
+	protocol, tls, err := util.ParseBackendProtocol(scheme, "")
+	if err != nil {
+		return fmt.Errorf("fail to parse --access_log_service_uri: %v", err)
+	}
+	if protocol != util.GRPC {
+		return fmt.Errorf(`fail to parse --access_log_service_uri: the scheme must be "grpc" or "grpcs", got %s`, scheme)
+	}
+	s.AccessLogServiceCluster = &BackendRoutingCluster{
+		ClusterName: util.AccessLogServiceClusterName,
+		Hostname:    hostname,
+		Port:        port,
+		UseTLS:      tls,
+		Protocol:    protocol,
+	}
+	return nil
+}
+
+// processReportLabels sets the custom labels of the reported operations in
+// --service_control_report_labels, from a request header or a JWT claim, and
+// the label of the request ID with --log_request_id, unless it is set.
+func (s *ServiceInfo) processReportLabels() error {
+	names := make(map[string]bool)
+	if s.Options.ServiceControlReportLabels != "" {
+		if err := s.parseReportLabels(names); err != nil {
+			return err
+		}
+	}
+	if s.Options.LogRequestId && !names[util.RequestIdField] {
+		s.ReportLabels = append(s.ReportLabels, &scpb.ReportLabel{
+			Name: util.RequestIdField,
+			Source: &scpb.ReportLabel_Header{
+				Header: util.RequestIdHeader,
+			},
+		})
+	}
+	return nil
+}
+
+// parseReportLabels parses the labels in --service_control_report_labels,
+// adding their names to names.
+func (s *ServiceInfo) parseReportLabels(names map[string]bool) error {
+	for _, pair := range strings.Split(s.Options.ServiceControlReportLabels, ",") {
+		kv := strings.SplitN(pair, "=", 2)
+		name := strings.TrimSpace(kv[0])
+		if len(kv) != 2 || name == "" {
+			return fmt.Errorf(`fail to parse --service_control_report_labels: %q is not "<label>=header:<header>" or "<label>=jwt:<claim path>"`, pair)
+		}
+		if names[name] {
+			return fmt.Errorf("fail to parse --service_control_report_labels: label %s is duplicated", name)
+		}
+		names[name] = true
+
+		label := &scpb.ReportLabel{
+			Name: name,
+		}
+		source := strings.TrimSpace(kv[1])
+		switch {
+		case strings.HasPrefix(source, "header:") && len(source) > len("header:"):
+			label.Source = &scpb.ReportLabel_Header{
+				Header: strings.TrimPrefix(source, "header:"),
+			}
+		case strings.HasPrefix(source, "jwt:") && len(source) > len("jwt:"):
+			label.Source = &scpb.ReportLabel_JwtClaim{
+				JwtClaim: strings.TrimPrefix(source, "jwt:"),
+			}
+		default:
+			return fmt.Errorf(`fail to parse --service_control_report_labels: source of label %s must be "header:<header>" or "jwt:<claim path>", got %q`, name, kv[1])
+		}
+		s.ReportLabels = append(s.ReportLabels, label)
+	}
+	return nil
+}
+
+// processBodySizeLimits sets the maximum sizes of the request and response
+// bodies of the methods, from the flags and their overrides by selector.
+// Streaming methods, including WebSocket upgrades, cannot be buffered and are
+// not limited.
+func (s *ServiceInfo) processBodySizeLimits() {
+	overrides := make(map[string]*options.BodySizeLimitOptions)
+	for _, o := range s.Options.BodySizeLimits {
+		overrides[o.Selector] = o
+	}
+	for selector, method := range s.Methods {
+		if method.IsStreaming || method.EnableWebsocket {
+			continue
+		}
+		method.MaxRequestBodyBytes = s.Options.MaxRequestBodyBytes
+		method.MaxResponseBodyBytes = s.Options.MaxResponseBodyBytes
+		if o, ok := overrides[selector]; ok {
+			if o.MaxRequestBodyBytes != nil {
+				method.MaxRequestBodyBytes = *o.MaxRequestBodyBytes
+			}
+			if o.MaxResponseBodyBytes != nil {
+				method.MaxResponseBodyBytes = *o.MaxResponseBodyBytes
+			}
+		}
+	}
+}
+
+func (s *ServiceInfo) processUsageRule() error {
+	for _, r := range s.ServiceConfig().GetUsage().GetRules() {
+		method, err := s.getOrCreateMethod(r.GetSelector())
+		if err != nil {
+			return err
+		}
+		method.AllowUnregisteredCalls = r.GetAllowUnregisteredCalls()
+		method.SkipServiceControl = r.GetSkipServiceControl()
+	}
+	return nil
+}
+
+func (s *ServiceInfo) processJwtLocations() error {
+	authn := s.serviceConfig.GetAuthentication()
+	for _, provider := range authn.GetProviders() {
+		// no custom JwtLocation so use default ones and set the one in query
+		// parameter for transcoder to ignore.
+		if len(provider.JwtLocations) == 0 {
+			s.TranscoderIgnoredJwtQueryParams[util.DefaultJwtQueryParamAccessToken] = true
+			continue
+		}
+
+		for _, jwtLocation := range provider.JwtLocations {
+			switch jwtLocation.In.(type) {
+			case *confpb.JwtLocation_Query:
+				if jwtLocation.ValuePrefix != "" {
+					return fmt.Errorf("JwtLocation_Query should be set without valuePrefix, get JwtLocation {%v}", jwtLocation)
+				}
+				// set the custom JwtLocation in query parameter for transcoder to ignore.
+				s.TranscoderIgnoredJwtQueryParams[jwtLocation.GetQuery()] = true
+			default:
+				continue
+			}
+		}
+	}
+	return nil
+}
+
+// processJwtAudiences sets the audiences allowed in the JWTs of the methods
+// requiring a provider with wildcard or regex audiences. The JWT Authn filter
+// only matches exact audiences, so these are matched on the method routes.
+func (s *ServiceInfo) processJwtAudiences() error {
+	if s.Options.SkipJwtAuthnFilter {
+		return nil
+	}
+	auth := s.ServiceConfig().GetAuthentication()
+	providerAudiences := make(map[string][]string)
+	hasPattern := make(map[string]bool)
+	for _, provider := range auth.GetProviders() {
+		// Same default audience as the JWT Authn filter config.
+		audiences := []string{fmt.Sprintf("https://%v", s.Name)}
+		if provider.GetAudiences() != "" {
+			audiences = splitAudiences(provider.GetAudiences())
+		}
+		for _, a := range audiences {
+			if !util.IsJwtAudiencePattern(a) {
+				continue
+			}
+			if _, err := regexp.Compile(util.JwtAudienceRegex(a)); err != nil {
+				return fmt.Errorf("fail to parse the audience %s of JWT provider %s: %v", a, provider.GetId(), err)
+			}
+			hasPattern[provider.GetId()] = true
+		}
+		providerAudiences[provider.GetId()] = audiences
+	}
+	if len(hasPattern) == 0 {
+		return nil
+	}
+
+	for _, rule := range auth.GetRules() {
+		method, ok := s.Methods[rule.GetSelector()]
+		if !ok {
+			continue
+		}
+		var audiences []string
+		matchedOnRoutes := false
+		for _, r := range rule.GetRequirements() {
+			// The deprecated audiences of a requirement override the ones of
+			// its provider, and are matched by the JWT Authn filter.
+			if r.GetAudiences() != "" {
+				audiences = append(audiences, splitAudiences(r.GetAudiences())...)
+				continue
+			}
+			for _, id := range JwtRequirementProviderIds(r) {
+				audiences = append(audiences, providerAudiences[id]...)
+				matchedOnRoutes = matchedOnRoutes || hasPattern[id]
+			}
+		}
+		if matchedOnRoutes {
+			method.JwtAudiences = audiences
+		}
+	}
+	return nil
+}
+
+// processJwtClaimHeaders sets the claims forwarded to the backend in request
+// headers for the methods requiring JWTs, from --jwt_claim_headers and its
+// overrides by operation.
+func (s *ServiceInfo) processJwtClaimHeaders() error {
+	if s.Options.SkipJwtAuthnFilter {
+		return nil
+	}
+	var defaultClaimHeaders []*JwtClaimHeader
+	if s.Options.JwtClaimHeaders != "" {
+		claimHeaders := make(map[string]string)
+		for _, pair := range strings.Split(s.Options.JwtClaimHeaders, ",") {
+			kv := strings.SplitN(pair, "=", 2)
+			if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" || strings.TrimSpace(kv[1]) == "" {
+				return fmt.Errorf(`fail to parse --jwt_claim_headers: %q is not "<claim>=<header>"`, pair)
+			}
+			claimHeaders[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+		}
+		var err error
+		if defaultClaimHeaders, err = parseJwtClaimHeaders(claimHeaders); err != nil {
+			return fmt.Errorf("fail to parse --jwt_claim_headers: %v", err)
+		}
+	}
+
+	overrides := make(map[string][]*JwtClaimHeader)
+	for _, o := range s.Options.JwtClaimHeadersConfig {
+		claimHeaders, err := parseJwtClaimHeaders(o.ClaimHeaders)
+		if err != nil {
+			return fmt.Errorf("fail to parse the JWT claim headers of selector %s: %v", o.Selector, err)
+		}
+		overrides[o.Selector] = claimHeaders
+	}
+
+	for _, rule := range s.ServiceConfig().GetAuthentication().GetRules() {
+		method, ok := s.Methods[rule.GetSelector()]
+		if !ok || len(rule.GetRequirements()) == 0 {
+			continue
+		}
+		if claimHeaders, ok := overrides[rule.GetSelector()]; ok {
+			method.JwtClaimHeaders = claimHeaders
+		} else {
+			method.JwtClaimHeaders = defaultClaimHeaders
+		}
+	}
+	return nil
+}
+
+// parseJwtClaimHeaders parses the request header of each claim, with the
+// nested claims separated by dots.
+func parseJwtClaimHeaders(claimHeaders map[string]string) ([]*JwtClaimHeader, error) {
+	var result []*JwtClaimHeader
+	claims := make(map[string]string)
+	for claim, header := range claimHeaders {
+		if !httpHeaderName.MatchString(header) {
+			return nil, fmt.Errorf("invalid header name %q for claim %s", header, claim)
+		}
+		header = strings.ToLower(header)
+		if prev, ok := claims[header]; ok {
+			return nil, fmt.Errorf("header %s is set by both claims %s and %s", header, prev, claim)
+		}
+		claims[header] = claim
+
+		path, err := parseJwtClaimPath(claim)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, &JwtClaimHeader{
+			ClaimPath: path,
+			Header:    header,
+		})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Header < result[j].Header
+	})
+	return result, nil
+}
+
+// parseJwtClaimPath splits a claim into the keys of its nested claims.
+func parseJwtClaimPath(claim string) ([]string, error) {
+	path := strings.Split(claim, ".")
+	for _, key := range path {
+		if key == "" {
+			return nil, fmt.Errorf("invalid claim %q", claim)
+		}
+	}
+	return path, nil
+}
+
+// processAuthorizationRules sets the policies allowing the requests of the
+// methods by the claims of their verified JWTs. The methods must require JWTs,
+// since the requests without them are never allowed.
+func (s *ServiceInfo) processAuthorizationRules() error {
+	if len(s.Options.AuthorizationRules) == 0 {
+		return nil
+	}
+	if s.Options.SkipJwtAuthnFilter {
+		return fmt.Errorf("authorization rules are not supported without the JWT Authn filter")
+	}
+	requireJwt := make(map[string]bool)
+	for _, rule := range s.ServiceConfig().GetAuthentication().GetRules() {
+		requireJwt[rule.GetSelector()] = len(rule.GetRequirements()) != 0
+	}
+
+	for _, o := range s.Options.AuthorizationRules {
+		method, ok := s.Methods[o.Selector]
+		if !ok {
+			continue
+		}
+		if !requireJwt[o.Selector] {
+			return fmt.Errorf("fail to process the authorization rule of selector %s: the operation requires no JWTs", o.Selector)
+		}
+		var policies []*AuthorizationPolicy
+		for i, p := range o.Allow {
+			policy, err := parseAuthorizationPolicy(p)
+			if err != nil {
+				return fmt.Errorf("fail to process the authorization rule of selector %s: policy %d: %v", o.Selector, i, err)
+			}
+			policies = append(policies, policy)
+		}
+		method.AuthorizationPolicies = policies
+	}
+	return nil
+}
+
+func parseAuthorizationPolicy(p *options.AuthorizationPolicyOptions) (*AuthorizationPolicy, error) {
+	if len(p.Claims) == 0 {
+		return nil, fmt.Errorf("claims are required")
+	}
+	policy := &AuthorizationPolicy{}
+	for claim, values := range p.Claims {
+		if len(values) == 0 {
+			return nil, fmt.Errorf("claim %s has no values", claim)
+		}
+		path, err := parseJwtClaimPath(claim)
+		if err != nil {
+			return nil, err
+		}
+		policy.Claims = append(policy.Claims, &AuthorizationClaim{
+			ClaimPath: path,
+			Values:    values,
+		})
+	}
+	sort.Slice(policy.Claims, func(i, j int) bool {
+		return strings.Join(policy.Claims[i].ClaimPath, ".") < strings.Join(policy.Claims[j].ClaimPath, ".")
+	})
+	return policy, nil
+}
+
+// JwtRequirementProviderIds returns the ids of the providers whose JWTs are
+// all required by the requirement. Its provider_id lists several providers,
+// separated by commas, to require JWTs from all of them.
+func JwtRequirementProviderIds(requirement *confpb.AuthRequirement) []string {
+	var ids []string
+	for _, id := range strings.Split(requirement.GetProviderId(), ",") {
+		ids = append(ids, strings.TrimSpace(id))
+	}
+	return ids
+}
+
+func splitAudiences(audiences string) []string {
+	var result []string
+	for _, a := range strings.Split(audiences, ",") {
+		result = append(result, strings.TrimSpace(a))
+	}
+	return result
+}
+
+func (s *ServiceInfo) processApiKeyLocations() error {
+	for _, rule := range s.ServiceConfig().GetSystemParameters().GetRules() {
+		apiKeyLocationParameters := []*confpb.SystemParameter{}
+
+		for _, parameter := range rule.GetParameters() {
+			if parameter.GetName() == util.ApiKeyParameterName {
+				apiKeyLocationParameters = append(apiKeyLocationParameters, parameter)
+			}
+		}
+
+		method, err := s.getOrCreateMethod(rule.GetSelector())
+		if err != nil {
+			return err
+		}
+
+		s.extractApiKeyLocations(method, apiKeyLocationParameters)
+	}
+
+	for _, o := range s.Options.ApiKeyLocationsConfig {
+		method, ok := s.Methods[o.Selector]
+		if !ok {
+			continue
+		}
+		locations, err := s.parseApiKeyLocations(o.Locations)
+		if err != nil {
+			return fmt.Errorf("fail to parse the API key locations of selector %s: %v", o.Selector, err)
+		}
+		method.ApiKeyLocations = locations
+	}
+
+	var defaultLocations []*scpb.ApiKeyLocation
+	if s.Options.ApiKeyLocations != "" {
+		var err error
+		defaultLocations, err = s.parseApiKeyLocations(strings.Split(s.Options.ApiKeyLocations, ","))
+		if err != nil {
+			return fmt.Errorf("fail to parse --api_key_locations: %v", err)
+		}
+	}
+
+	for _, method := range s.Methods {
+		if len(method.ApiKeyLocations) != 0 {
+			continue
+		}
+		if defaultLocations != nil {
+			method.ApiKeyLocations = defaultLocations
+			continue
+		}
+		// If any of method is not set with custom ApiKeyLocations, use the default
+		// one and set the custom ApiKeyLocations in query parameter for transcoder
+		// to ignore.
+		s.TranscoderIgnoredApiKeyQueryParams[util.DefaultApiKeyQueryParamKey] = true
+		s.TranscoderIgnoredApiKeyQueryParams[util.DefaultApiKeyQueryParamApiKey] = true
+	}
+
+	return nil
+}
+
+// parseApiKeyLocations parses the API key locations from the flags, each
+// "query:<name>", "header:<name>" or "cookie:<name>".
+func (s *ServiceInfo) parseApiKeyLocations(locations []string) ([]*scpb.ApiKeyLocation, error) {
+	var apiKeyLocations []*scpb.ApiKeyLocation
+	for _, location := range locations {
+		parts := strings.SplitN(strings.TrimSpace(location), ":", 2)
+		if len(parts) != 2 || parts[1] == "" {
+			return nil, fmt.Errorf("invalid location %q, must be query:<name>, header:<name> or cookie:<name>", location)
+		}
+		switch name := parts[1]; parts[0] {
+		case "query":
+			apiKeyLocations = append(apiKeyLocations, &scpb.ApiKeyLocation{
+				Key: &scpb.ApiKeyLocation_Query{
+					Query: name,
+				},
+			})
+			s.TranscoderIgnoredApiKeyQueryParams[name] = true
+		case "header":
+			apiKeyLocations = append(apiKeyLocations, &scpb.ApiKeyLocation{
+				Key: &scpb.ApiKeyLocation_Header{
+					Header: name,
+				},
+			})
+		case "cookie":
+			apiKeyLocations = append(apiKeyLocations, &scpb.ApiKeyLocation{
+				Key: &scpb.ApiKeyLocation_Cookie{
+					Cookie: name,
+				},
+			})
+		default:
+			return nil, fmt.Errorf("invalid location %q, must be query:<name>, header:<name> or cookie:<name>", location)
+		}
+	}
+	return apiKeyLocations, nil
+}
+
+func (s *ServiceInfo) extractApiKeyLocations(method *methodInfo, parameters []*confpb.SystemParameter) {
 	var urlQueryNames, headerNames []*scpb.ApiKeyLocation
 	for _, parameter := range parameters {
 		if urlQueryName := parameter.GetUrlQueryParameter(); urlQueryName != "" {
@@ -635,7 +1989,11 @@ func (s *ServiceInfo) BackendClusterName() string {
 }
 
 // If the backend address's scheme is grpc/grpcs, it should be changed it http or https.
-func getJwtAudienceFromBackendAddr(scheme, hostname string) string {
+// The audiences of the serverless backends are derived from their URLs.
+func getJwtAudienceFromBackendAddr(scheme, hostname, uri string) string {
+	if util.IsServerlessHost(hostname) {
+		return util.ServerlessJwtAudience(hostname, uri)
+	}
 	_, tls, _ := util.ParseBackendProtocol(scheme, "")
 	if tls {
 		return fmt.Sprintf("https://%s", hostname)
@@ -17,23 +17,29 @@ package configinfo
 import (
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"reflect"
-	"sort"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/GoogleCloudPlatform/esp-v2/src/go/options"
 	"github.com/GoogleCloudPlatform/esp-v2/src/go/util"
 	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
 	"github.com/google/go-cmp/cmp"
 	"github.com/gorilla/mux"
 
 	commonpb "github.com/GoogleCloudPlatform/esp-v2/src/go/proto/api/envoy/http/common"
 	scpb "github.com/GoogleCloudPlatform/esp-v2/src/go/proto/api/envoy/http/service_control"
+	descpb "github.com/golang/protobuf/protoc-gen-go/descriptor"
+	anypb "github.com/golang/protobuf/ptypes/any"
 	annotationspb "google.golang.org/genproto/googleapis/api/annotations"
 	confpb "google.golang.org/genproto/googleapis/api/serviceconfig"
+	smpb "google.golang.org/genproto/googleapis/api/servicemanagement/v1"
 	apipb "google.golang.org/genproto/protobuf/api"
 )
 
@@ -45,9 +51,11 @@ var (
 
 func TestProcessEndpoints(t *testing.T) {
 	testData := []struct {
-		desc              string
-		fakeServiceConfig *confpb.Service
-		wantedAllowCors   bool
+		desc                    string
+		fakeServiceConfig       *confpb.Service
+		corsPreset              string
+		corsPreflightOperations bool
+		wantedAllowCors         bool
 	}{
 		{
 			desc: "Return true for endpoint name matching service name",
@@ -114,10 +122,39 @@ func TestProcessEndpoints(t *testing.T) {
 			},
 			wantedAllowCors: false,
 		},
+		{
+			desc: "Return true for preflight operations with CORS preset",
+			fakeServiceConfig: &confpb.Service{
+				Name: testProjectName,
+				Apis: []*apipb.Api{
+					{
+						Name: testApiName,
+					},
+				},
+			},
+			corsPreset:              "basic",
+			corsPreflightOperations: true,
+			wantedAllowCors:         true,
+		},
+		{
+			desc: "Return false for preflight operations without CORS",
+			fakeServiceConfig: &confpb.Service{
+				Name: testProjectName,
+				Apis: []*apipb.Api{
+					{
+						Name: testApiName,
+					},
+				},
+			},
+			corsPreflightOperations: true,
+			wantedAllowCors:         false,
+		},
 	}
 
 	for i, tc := range testData {
 		opts := options.DefaultConfigGeneratorOptions()
+		opts.CorsPreset = tc.corsPreset
+		opts.CorsPreflightOperations = tc.corsPreflightOperations
 		serviceInfo, err := NewServiceInfoFromServiceConfig(tc.fakeServiceConfig, testConfigID, opts)
 		if err != nil {
 			t.Fatal(err)
@@ -480,6 +517,128 @@ func TestProcessApiKeyLocations(t *testing.T) {
 	}
 }
 
+func TestProcessApiKeyLocationsFromFlags(t *testing.T) {
+	testData := []struct {
+		desc                                     string
+		apiKeyLocations                          string
+		apiKeyLocationsConfig                    []*options.ApiKeyLocationOptions
+		wantedApiKeyLocations                    map[string][]*scpb.ApiKeyLocation
+		wantedTranscoderIgnoredApiKeyQueryParams map[string]bool
+		wantedError                              string
+	}{
+		{
+			desc:            "Flag replaces the default locations, not the ones in the service config",
+			apiKeyLocations: "header:x-my-key, cookie:apikey,query:my_key",
+			wantedApiKeyLocations: map[string][]*scpb.ApiKeyLocation{
+				"1.echo_api_endpoints_cloudesf_testing_cloud_goog.echo": {
+					{Key: &scpb.ApiKeyLocation_Header{Header: "header_name"}},
+				},
+				"1.echo_api_endpoints_cloudesf_testing_cloud_goog.foo": {
+					{Key: &scpb.ApiKeyLocation_Header{Header: "x-my-key"}},
+					{Key: &scpb.ApiKeyLocation_Cookie{Cookie: "apikey"}},
+					{Key: &scpb.ApiKeyLocation_Query{Query: "my_key"}},
+				},
+			},
+			wantedTranscoderIgnoredApiKeyQueryParams: map[string]bool{
+				"my_key": true,
+			},
+		},
+		{
+			desc: "Config file overrides the service config, others use the default locations",
+			apiKeyLocationsConfig: []*options.ApiKeyLocationOptions{
+				{
+					Selector:  "1.echo_api_endpoints_cloudesf_testing_cloud_goog.echo",
+					Locations: []string{"cookie:session_key", "header:x-api-key"},
+				},
+				{
+					Selector:  "1.echo_api_endpoints_cloudesf_testing_cloud_goog.unknown",
+					Locations: []string{"cookie:unknown"},
+				},
+			},
+			wantedApiKeyLocations: map[string][]*scpb.ApiKeyLocation{
+				"1.echo_api_endpoints_cloudesf_testing_cloud_goog.echo": {
+					{Key: &scpb.ApiKeyLocation_Cookie{Cookie: "session_key"}},
+					{Key: &scpb.ApiKeyLocation_Header{Header: "x-api-key"}},
+				},
+				"1.echo_api_endpoints_cloudesf_testing_cloud_goog.foo": nil,
+			},
+			wantedTranscoderIgnoredApiKeyQueryParams: map[string]bool{
+				"key":     true,
+				"api_key": true,
+			},
+		},
+		{
+			desc:            "Fail with an invalid location",
+			apiKeyLocations: "body:key",
+			wantedError:     `fail to parse --api_key_locations: invalid location "body:key"`,
+		},
+		{
+			desc: "Fail with a location without name",
+			apiKeyLocationsConfig: []*options.ApiKeyLocationOptions{
+				{
+					Selector:  "1.echo_api_endpoints_cloudesf_testing_cloud_goog.echo",
+					Locations: []string{"header:"},
+				},
+			},
+			wantedError: `fail to parse the API key locations of selector 1.echo_api_endpoints_cloudesf_testing_cloud_goog.echo: invalid location "header:"`,
+		},
+	}
+
+	fakeServiceConfig := &confpb.Service{
+		Apis: []*apipb.Api{
+			{
+				Name: "1.echo_api_endpoints_cloudesf_testing_cloud_goog",
+				Methods: []*apipb.Method{
+					{
+						Name: "echo",
+					},
+					{
+						Name: "foo",
+					},
+				},
+			},
+		},
+		SystemParameters: &confpb.SystemParameters{
+			Rules: []*confpb.SystemParameterRule{
+				{
+					Selector: "1.echo_api_endpoints_cloudesf_testing_cloud_goog.echo",
+					Parameters: []*confpb.SystemParameter{
+						{
+							Name:       "api_key",
+							HttpHeader: "header_name",
+						},
+					},
+				},
+			},
+		},
+	}
+
+	for i, tc := range testData {
+		opts := options.DefaultConfigGeneratorOptions()
+		opts.ApiKeyLocations = tc.apiKeyLocations
+		opts.ApiKeyLocationsConfig = tc.apiKeyLocationsConfig
+		s, err := NewServiceInfoFromServiceConfig(fakeServiceConfig, testConfigID, opts)
+		if tc.wantedError != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.wantedError) {
+				t.Errorf("Test Desc(%d): %s, got error: %v, wanted error containing: %s", i, tc.desc, err, tc.wantedError)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Test Desc(%d): %s, error not expected, got: %v", i, tc.desc, err)
+		}
+
+		for selector, wantLocations := range tc.wantedApiKeyLocations {
+			if gotLocations := s.Methods[selector].ApiKeyLocations; !cmp.Equal(gotLocations, wantLocations, cmp.Comparer(proto.Equal)) {
+				t.Errorf("Test Desc(%d): %s, ApiKeyLocations of %s not expected, got: %v, want: %v", i, tc.desc, selector, gotLocations, wantLocations)
+			}
+		}
+		if !reflect.DeepEqual(s.TranscoderIgnoredApiKeyQueryParams, tc.wantedTranscoderIgnoredApiKeyQueryParams) {
+			t.Errorf("Test Desc(%d): %s, got TranscoderIgnoredApiKeyQueryParams: %v, wanted: %v", i, tc.desc, s.TranscoderIgnoredApiKeyQueryParams, tc.wantedTranscoderIgnoredApiKeyQueryParams)
+		}
+	}
+}
+
 func TestProcessJwtLocations(t *testing.T) {
 	testData := []struct {
 		desc                                  string
@@ -616,6 +775,7 @@ func TestMethods(t *testing.T) {
 		fakeServiceConfig *confpb.Service
 		BackendAddress    string
 		healthz           string
+		healthGrpcService string
 		wantMethods       map[string]*methodInfo
 		wantError         string
 	}{
@@ -674,6 +834,48 @@ func TestMethods(t *testing.T) {
 				},
 			},
 		},
+		{
+			desc: "Succeed for gRPC, with gRPC health checks",
+			fakeServiceConfig: &confpb.Service{
+				Name: testProjectName,
+				Apis: []*apipb.Api{
+					{
+						Name: testApiName,
+						Methods: []*apipb.Method{
+							{
+								Name: "ListShelves",
+							},
+						},
+					},
+				},
+			},
+			BackendAddress:    "grpc://127.0.0.1:80",
+			healthGrpcService: testApiName,
+			wantMethods: map[string]*methodInfo{
+				fmt.Sprintf("%s.%s", testApiName, "ListShelves"): &methodInfo{
+					ShortName: "ListShelves",
+					ApiName:   testApiName,
+					HttpRule: []*commonpb.Pattern{
+						{
+							UriTemplate: fmt.Sprintf("/%s/%s", testApiName, "ListShelves"),
+							HttpMethod:  util.POST,
+						},
+					},
+				},
+				"grpc.health.v1.Health.Check": &methodInfo{
+					ShortName:          "Check",
+					ApiName:            "grpc.health.v1.Health",
+					SkipServiceControl: true,
+					IsGenerated:        true,
+					HttpRule: []*commonpb.Pattern{
+						{
+							UriTemplate: "/grpc.health.v1.Health/Check",
+							HttpMethod:  util.POST,
+						},
+					},
+				},
+			},
+		},
 		{
 			desc: "Succeed for HTTP, with Healthz",
 			fakeServiceConfig: &confpb.Service{
@@ -1110,6 +1312,7 @@ func TestMethods(t *testing.T) {
 		opts := options.DefaultConfigGeneratorOptions()
 		opts.BackendAddress = tc.BackendAddress
 		opts.Healthz = tc.healthz
+		opts.HealthGrpcService = tc.healthGrpcService
 		serviceInfo, err := NewServiceInfoFromServiceConfig(tc.fakeServiceConfig, testConfigID, opts)
 		if tc.wantError != "" {
 			if err == nil || err.Error() != tc.wantError {
@@ -1410,6 +1613,33 @@ func TestProcessBackendRuleForJwtAudience(t *testing.T) {
 				"mno.com.api": "https://mno.com",
 			},
 		},
+		{
+			desc: "Audiences of serverless backends are derived from their URLs",
+			fakeServiceConfig: &confpb.Service{
+				Apis: []*apipb.Api{
+					{
+						Name: testApiName,
+					},
+				},
+				Backend: &confpb.Backend{
+					Rules: []*confpb.BackendRule{
+						{
+							Address:  "grpcs://bookstore-12345-uc.a.run.app/api",
+							Selector: "run.app.api",
+						},
+						{
+							Address:         "https://us-central1-project.cloudfunctions.net/hello",
+							Selector:        "cloudfunctions.net.hello",
+							PathTranslation: confpb.BackendRule_CONSTANT_ADDRESS,
+						},
+					},
+				},
+			},
+			wantedJwtAudience: map[string]string{
+				"run.app.api":              "https://bookstore-12345-uc.a.run.app",
+				"cloudfunctions.net.hello": "https://us-central1-project.cloudfunctions.net/hello",
+			},
+		},
 	}
 
 	for i, tc := range testData {
@@ -1432,104 +1662,80 @@ func TestProcessBackendRuleForJwtAudience(t *testing.T) {
 	}
 }
 
-func TestProcessQuota(t *testing.T) {
+func TestProcessBackendRuleForOAuth2(t *testing.T) {
 	testData := []struct {
 		desc              string
-		fakeServiceConfig *confpb.Service
-		wantMethods       map[string]*methodInfo
+		backendOAuth2     []*options.BackendOAuth2Options
+		wantedJwtAudience map[string]string
+		wantedTokenUri    map[string]string
+		wantedError       string
 	}{
 		{
-			desc: "Succeed, simple case",
-			fakeServiceConfig: &confpb.Service{
-				Apis: []*apipb.Api{
-					{
-						Name: testApiName,
-						Methods: []*apipb.Method{
-							{
-								Name: "ListShelves",
-							},
-						},
-					},
-				},
-				Quota: &confpb.Quota{
-					MetricRules: []*confpb.MetricRule{
-						{
-							Selector: "endpoints.examples.bookstore.Bookstore.ListShelves",
-							MetricCosts: map[string]int64{
-								"metric_a": 2,
-								"metric_b": 1,
-							},
-						},
-					},
+			desc: "Backends with OAuth2 client credentials get access tokens from the token agent",
+			backendOAuth2: []*options.BackendOAuth2Options{
+				{
+					BackendAddress: "https://oauth2.example.com",
+					TokenURL:       "https://auth.example.com/token",
+					ClientID:       "client-id",
 				},
 			},
-			wantMethods: map[string]*methodInfo{
-				fmt.Sprintf("%s.%s", testApiName, "ListShelves"): &methodInfo{
-					ShortName: "ListShelves",
-					ApiName:   testApiName,
-					HttpRule: []*commonpb.Pattern{
-						{
-							UriTemplate: fmt.Sprintf("/%s/%s", testApiName, "ListShelves"),
-							HttpMethod:  util.POST,
-						},
-					},
-					MetricCosts: []*scpb.MetricCost{
-						{
-							Name: "metric_a",
-							Cost: 2,
-						},
-						{
-							Name: "metric_b",
-							Cost: 1,
-						},
-					},
-				},
+			wantedJwtAudience: map[string]string{
+				"abc.com.api": "audience-foo",
+			},
+			wantedTokenUri: map[string]string{
+				"oauth2.api":     "http://127.0.0.1:8791/v1/backendOAuth2Token/oauth2.example.com:443",
+				"oauth2.api.foo": "http://127.0.0.1:8791/v1/backendOAuth2Token/oauth2.example.com:443",
 			},
 		},
 		{
-			desc: "Succeed, two metric cost items",
-			fakeServiceConfig: &confpb.Service{
-				Apis: []*apipb.Api{
-					{
-						Name: testApiName,
-						Methods: []*apipb.Method{
-							{
-								Name: "ListShelves",
-							},
-						},
-					},
+			desc: "Only the host and port of the backend address are matched",
+			backendOAuth2: []*options.BackendOAuth2Options{
+				{
+					BackendAddress: "https://oauth2.example.com:8443/api",
+					TokenURL:       "https://auth.example.com/token",
+					ClientID:       "client-id",
 				},
-				Quota: &confpb.Quota{
-					MetricRules: []*confpb.MetricRule{
-						{
-							Selector: "endpoints.examples.bookstore.Bookstore.ListShelves",
-							MetricCosts: map[string]int64{
-								"metric_c": 2,
-								"metric_a": 3,
-							},
-						},
-					},
+			},
+			wantedJwtAudience: map[string]string{
+				"abc.com.api": "audience-foo",
+				"oauth2.api":  "https://oauth2.example.com",
+			},
+		},
+		{
+			desc: "Fail with an invalid backend address",
+			backendOAuth2: []*options.BackendOAuth2Options{
+				{
+					BackendAddress: "oauth2.example.com:abc",
 				},
 			},
-			wantMethods: map[string]*methodInfo{
-				fmt.Sprintf("%s.%s", testApiName, "ListShelves"): &methodInfo{
-					ShortName: "ListShelves",
-					ApiName:   testApiName,
-					HttpRule: []*commonpb.Pattern{
-						{
-							UriTemplate: fmt.Sprintf("/%s/%s", testApiName, "ListShelves"),
-							HttpMethod:  util.POST,
-						},
+			wantedError: "oauth2.example.com:abc",
+		},
+	}
+
+	fakeServiceConfig := &confpb.Service{
+		Apis: []*apipb.Api{
+			{
+				Name: testApiName,
+			},
+		},
+		Backend: &confpb.Backend{
+			Rules: []*confpb.BackendRule{
+				{
+					Address:  "https://abc.com/api",
+					Selector: "abc.com.api",
+					Authentication: &confpb.BackendRule_JwtAudience{
+						JwtAudience: "audience-foo",
 					},
-					MetricCosts: []*scpb.MetricCost{
-						{
-							Name: "metric_a",
-							Cost: 3,
-						},
-						{
-							Name: "metric_c",
-							Cost: 2,
-						},
+				},
+				{
+					Address:  "https://oauth2.example.com/api",
+					Selector: "oauth2.api",
+				},
+				{
+					Address:  "https://oauth2.example.com/api/foo",
+					Selector: "oauth2.api.foo",
+					Authentication: &confpb.BackendRule_DisableAuth{
+						DisableAuth: true,
 					},
 				},
 			},
@@ -1538,26 +1744,2385 @@ func TestProcessQuota(t *testing.T) {
 
 	for i, tc := range testData {
 		opts := options.DefaultConfigGeneratorOptions()
-		opts.BackendAddress = "grpc://127.0.0.1:80"
-		serviceInfo, _ := NewServiceInfoFromServiceConfig(tc.fakeServiceConfig, testConfigID, opts)
-
-		for key, gotMethod := range serviceInfo.Methods {
-			wantMethod := tc.wantMethods[key]
+		opts.BackendOAuth2 = tc.backendOAuth2
+		s, err := NewServiceInfoFromServiceConfig(fakeServiceConfig, testConfigID, opts)
+		if tc.wantedError != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.wantedError) {
+				t.Errorf("Test Desc(%d): %s, got error: %v, want error containing: %v", i, tc.desc, err, tc.wantedError)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Test Desc(%d): %s, error not expected, got: %v", i, tc.desc, err)
+			continue
+		}
 
-			sort.Slice(gotMethod.MetricCosts, func(i, j int) bool { return gotMethod.MetricCosts[i].Name < gotMethod.MetricCosts[j].Name })
-			if eq := cmp.Equal(gotMethod, wantMethod, cmp.Comparer(proto.Equal)); !eq {
-				t.Errorf("Test Desc(%d): %s,\ngot Method: %v,\nwant Method: %v", i, tc.desc, gotMethod, wantMethod)
+		for _, rule := range fakeServiceConfig.Backend.Rules {
+			backendInfo := s.Methods[rule.Selector].BackendInfo
+			if got, want := backendInfo.JwtAudience, tc.wantedJwtAudience[rule.Selector]; got != want {
+				t.Errorf("Test Desc(%d): %s, JwtAudience of %s not expected, got: %v, want: %v", i, tc.desc, rule.Selector, got, want)
+			}
+			if got, want := backendInfo.AccessTokenUri, tc.wantedTokenUri[rule.Selector]; got != want {
+				t.Errorf("Test Desc(%d): %s, AccessTokenUri of %s not expected, got: %v, want: %v", i, tc.desc, rule.Selector, got, want)
 			}
 		}
 	}
 }
 
-func TestProcessEmptyJwksUriByOpenID(t *testing.T) {
-	r := mux.NewRouter()
-	jwksUriEntry, _ := json.Marshal(map[string]string{"jwks_uri": "this-is-jwksUri"})
-	r.Path(util.OpenIDDiscoveryCfgURLSuffix).Methods("GET").Handler(
-		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			w.Write(jwksUriEntry)
+func TestProcessBackendRuleForIamDelegates(t *testing.T) {
+	testData := []struct {
+		desc              string
+		iamServiceAccount string
+		backendClusters   []*options.BackendClusterOptions
+		wantedDelegates   map[string][]string
+		wantedError       string
+	}{
+		{
+			desc:              "Backends with IAM delegates fetch their ID tokens with them",
+			iamServiceAccount: "service-account@google.com",
+			backendClusters: []*options.BackendClusterOptions{
+				{
+					BackendAddress: "https://abc.com",
+					IamDelegates:   []string{"delegate-foo", "delegate-bar"},
+				},
+			},
+			wantedDelegates: map[string][]string{
+				"abc.com.api": {"delegate-foo", "delegate-bar"},
+			},
+		},
+		{
+			desc:              "IAM delegates are not used without the ID tokens",
+			iamServiceAccount: "service-account@google.com",
+			backendClusters: []*options.BackendClusterOptions{
+				{
+					BackendAddress: "https://def.com",
+					IamDelegates:   []string{"delegate-foo"},
+				},
+			},
+		},
+		{
+			desc: "Fail with IAM delegates without the IAM service account",
+			backendClusters: []*options.BackendClusterOptions{
+				{
+					BackendAddress: "https://abc.com",
+					IamDelegates:   []string{"delegate-foo"},
+				},
+			},
+			wantedError: "iam_delegates of backend abc.com:443 require --backend_auth_iam_service_account",
+		},
+	}
+
+	fakeServiceConfig := &confpb.Service{
+		Apis: []*apipb.Api{
+			{
+				Name: testApiName,
+			},
+		},
+		Backend: &confpb.Backend{
+			Rules: []*confpb.BackendRule{
+				{
+					Address:  "https://abc.com/api",
+					Selector: "abc.com.api",
+					Authentication: &confpb.BackendRule_JwtAudience{
+						JwtAudience: "audience-foo",
+					},
+				},
+				{
+					Address:  "https://def.com/api",
+					Selector: "def.com.api",
+					Authentication: &confpb.BackendRule_DisableAuth{
+						DisableAuth: true,
+					},
+				},
+			},
+		},
+	}
+
+	for i, tc := range testData {
+		opts := options.DefaultConfigGeneratorOptions()
+		opts.BackendClusters = tc.backendClusters
+		if tc.iamServiceAccount != "" {
+			opts.BackendAuthCredentials = &options.IAMCredentialsOptions{
+				ServiceAccountEmail: tc.iamServiceAccount,
+				TokenKind:           options.IDToken,
+			}
+		}
+		s, err := NewServiceInfoFromServiceConfig(fakeServiceConfig, testConfigID, opts)
+		if tc.wantedError != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.wantedError) {
+				t.Errorf("Test Desc(%d): %s, got error: %v, want error containing: %v", i, tc.desc, err, tc.wantedError)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Test Desc(%d): %s, error not expected, got: %v", i, tc.desc, err)
+			continue
+		}
+
+		for _, rule := range fakeServiceConfig.Backend.Rules {
+			backendInfo := s.Methods[rule.Selector].BackendInfo
+			if got, want := backendInfo.IamDelegates, tc.wantedDelegates[rule.Selector]; !reflect.DeepEqual(got, want) {
+				t.Errorf("Test Desc(%d): %s, IamDelegates of %s not expected, got: %v, want: %v", i, tc.desc, rule.Selector, got, want)
+			}
+		}
+	}
+}
+
+func TestProcessBackendRuleForClusterOptions(t *testing.T) {
+	testData := []struct {
+		desc            string
+		backendClusters []*options.BackendClusterOptions
+		wantedClusters  []*BackendRoutingCluster
+		wantedError     string
+	}{
+		{
+			desc: "One cluster by host and port, including IP addresses",
+			wantedClusters: []*BackendRoutingCluster{
+				{
+					ClusterName: "abc.com:443",
+					Hostname:    "abc.com",
+					Port:        443,
+					UseTLS:      true,
+					Protocol:    util.HTTP1,
+				},
+				{
+					ClusterName: "10.0.0.1:8080",
+					Hostname:    "10.0.0.1",
+					Port:        8080,
+					Protocol:    util.HTTP1,
+				},
+			},
+		},
+		{
+			desc: "Cluster settings are matched by host and port",
+			backendClusters: []*options.BackendClusterOptions{
+				{
+					BackendAddress: "https://abc.com:443/other",
+					ConnectTimeout: 2.5,
+					CaPath:         "/etc/backend/ca.crt",
+				},
+				{
+					BackendAddress: "http://10.0.0.1:8080",
+					ConnectTimeout: 1,
+				},
+				{
+					BackendAddress: "https://unused.com",
+					ConnectTimeout: 1,
+				},
+			},
+			wantedClusters: []*BackendRoutingCluster{
+				{
+					ClusterName:    "abc.com:443",
+					Hostname:       "abc.com",
+					Port:           443,
+					UseTLS:         true,
+					Protocol:       util.HTTP1,
+					ConnectTimeout: 2500 * time.Millisecond,
+					ClusterOptions: &options.BackendClusterOptions{
+						BackendAddress: "https://abc.com:443/other",
+						ConnectTimeout: 2.5,
+						CaPath:         "/etc/backend/ca.crt",
+					},
+				},
+				{
+					ClusterName:    "10.0.0.1:8080",
+					Hostname:       "10.0.0.1",
+					Port:           8080,
+					Protocol:       util.HTTP1,
+					ConnectTimeout: time.Second,
+					ClusterOptions: &options.BackendClusterOptions{
+						BackendAddress: "http://10.0.0.1:8080",
+						ConnectTimeout: 1,
+					},
+				},
+			},
+		},
+		{
+			desc: "Fail with TLS settings for a backend without TLS",
+			backendClusters: []*options.BackendClusterOptions{
+				{
+					BackendAddress: "http://10.0.0.1:8080",
+					Sni:            "backend.internal",
+				},
+			},
+			wantedError: "TLS settings of backend 10.0.0.1:8080 in --backend_cluster_config require https or grpcs",
+		},
+		{
+			desc: "Backend in several regions",
+			backendClusters: []*options.BackendClusterOptions{
+				{
+					BackendAddress:  "https://abc.com",
+					RegionAddresses: []string{"https://abc-eu.com", "https://abc-asia.com/"},
+				},
+			},
+			wantedClusters: []*BackendRoutingCluster{
+				{
+					ClusterName: "abc.com:443",
+					Hostname:    "abc.com",
+					Port:        443,
+					UseTLS:      true,
+					Protocol:    util.HTTP1,
+					ClusterOptions: &options.BackendClusterOptions{
+						BackendAddress:  "https://abc.com",
+						RegionAddresses: []string{"https://abc-eu.com", "https://abc-asia.com/"},
+					},
+					RegionHostnames: []string{"abc-eu.com", "abc-asia.com"},
+				},
+				{
+					ClusterName: "10.0.0.1:8080",
+					Hostname:    "10.0.0.1",
+					Port:        8080,
+					Protocol:    util.HTTP1,
+				},
+			},
+		},
+		{
+			desc: "Fail with a region address on another port",
+			backendClusters: []*options.BackendClusterOptions{
+				{
+					BackendAddress:  "https://abc.com",
+					RegionAddresses: []string{"https://abc-eu.com:8443"},
+				},
+			},
+			wantedError: "region address https://abc-eu.com:8443 of backend abc.com:443 must have its scheme and port, a hostname and no path",
+		},
+		{
+			desc: "Fail with region addresses of an IP address backend",
+			backendClusters: []*options.BackendClusterOptions{
+				{
+					BackendAddress:  "http://10.0.0.1:8080",
+					RegionAddresses: []string{"http://10.0.0.2:8080"},
+				},
+			},
+			wantedError: "backend 10.0.0.1:8080 with region addresses must not be an IP address",
+		},
+		{
+			desc: "Backend failing over to other regions",
+			backendClusters: []*options.BackendClusterOptions{
+				{
+					BackendAddress:    "https://abc.com",
+					FailoverAddresses: []string{"https://abc-eu.com"},
+				},
+			},
+			wantedClusters: []*BackendRoutingCluster{
+				{
+					ClusterName: "abc.com:443",
+					Hostname:    "abc.com",
+					Port:        443,
+					UseTLS:      true,
+					Protocol:    util.HTTP1,
+					ClusterOptions: &options.BackendClusterOptions{
+						BackendAddress:    "https://abc.com",
+						FailoverAddresses: []string{"https://abc-eu.com"},
+					},
+					FailoverHostnames: []string{"abc-eu.com"},
+				},
+				{
+					ClusterName: "10.0.0.1:8080",
+					Hostname:    "10.0.0.1",
+					Port:        8080,
+					Protocol:    util.HTTP1,
+				},
+			},
+		},
+		{
+			desc: "Fail with a failover address with a path",
+			backendClusters: []*options.BackendClusterOptions{
+				{
+					BackendAddress:    "https://abc.com",
+					FailoverAddresses: []string{"https://abc-eu.com/v1"},
+				},
+			},
+			wantedError: "failover address https://abc-eu.com/v1 of backend abc.com:443 must have its scheme and port, a hostname and no path",
+		},
+		{
+			desc: "Backend with other endpoints",
+			backendClusters: []*options.BackendClusterOptions{
+				{
+					BackendAddress: "http://10.0.0.1:8080",
+					Endpoints:      []string{"10.0.0.2", "backend-3.internal:9090", "fd00::4"},
+				},
+			},
+			wantedClusters: []*BackendRoutingCluster{
+				{
+					ClusterName: "abc.com:443",
+					Hostname:    "abc.com",
+					Port:        443,
+					UseTLS:      true,
+					Protocol:    util.HTTP1,
+				},
+				{
+					ClusterName: "10.0.0.1:8080",
+					Hostname:    "10.0.0.1",
+					Port:        8080,
+					Protocol:    util.HTTP1,
+					ClusterOptions: &options.BackendClusterOptions{
+						BackendAddress: "http://10.0.0.1:8080",
+						Endpoints:      []string{"10.0.0.2", "backend-3.internal:9090", "fd00::4"},
+					},
+					Endpoints: []util.Endpoint{
+						{Hostname: "10.0.0.2", Port: 8080},
+						{Hostname: "backend-3.internal", Port: 9090},
+						{Hostname: "fd00::4", Port: 8080},
+					},
+				},
+			},
+		},
+		{
+			desc: "Fail with an endpoint with an invalid port",
+			backendClusters: []*options.BackendClusterOptions{
+				{
+					BackendAddress: "http://10.0.0.1:8080",
+					Endpoints:      []string{"10.0.0.2:http"},
+				},
+			},
+			wantedError: "invalid port of endpoint 10.0.0.2:http of backend http://10.0.0.1:8080",
+		},
+	}
+
+	fakeServiceConfig := &confpb.Service{
+		Apis: []*apipb.Api{
+			{
+				Name: testApiName,
+			},
+		},
+		Backend: &confpb.Backend{
+			Rules: []*confpb.BackendRule{
+				{
+					Address:  "https://abc.com/api",
+					Selector: "abc.com.api",
+				},
+				{
+					Address:  "http://10.0.0.1:8080/api",
+					Selector: "ip.api",
+				},
+				{
+					Address:  "https://abc.com/api/foo",
+					Selector: "abc.com.api.foo",
+				},
+			},
+		},
+	}
+
+	for i, tc := range testData {
+		opts := options.DefaultConfigGeneratorOptions()
+		opts.BackendClusters = tc.backendClusters
+		s, err := NewServiceInfoFromServiceConfig(fakeServiceConfig, testConfigID, opts)
+		if tc.wantedError != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.wantedError) {
+				t.Errorf("Test Desc(%d): %s, got error: %v, want error containing: %v", i, tc.desc, err, tc.wantedError)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Test Desc(%d): %s, error not expected, got: %v", i, tc.desc, err)
+			continue
+		}
+		if !reflect.DeepEqual(s.BackendRoutingClusters, tc.wantedClusters) {
+			t.Errorf("Test Desc(%d): %s, BackendRoutingClusters not expected, got: %+v, want: %+v", i, tc.desc, s.BackendRoutingClusters, tc.wantedClusters)
+		}
+	}
+}
+
+func TestProcessBackendRuleForServerless(t *testing.T) {
+	testData := []struct {
+		desc                  string
+		address               string
+		backendClusters       []*options.BackendClusterOptions
+		wantedAutoHostRewrite bool
+		wantedError           string
+	}{
+		{
+			desc:    "Cloud Run service in one region",
+			address: "https://bookstore-12345-uc.a.run.app",
+		},
+		{
+			desc:    "Cloud Run service in several regions is sent the hostname of each region",
+			address: "https://bookstore-12345-uc.a.run.app",
+			backendClusters: []*options.BackendClusterOptions{
+				{
+					BackendAddress:  "https://bookstore-12345-uc.a.run.app",
+					RegionAddresses: []string{"https://bookstore-12345-ew.a.run.app"},
+				},
+			},
+			wantedAutoHostRewrite: true,
+		},
+		{
+			desc:        "Fail with Cloud Functions without TLS",
+			address:     "http://us-central1-project.cloudfunctions.net/hello",
+			wantedError: "backend us-central1-project.cloudfunctions.net:80 is on a serverless platform, which requires https or grpcs",
+		},
+	}
+
+	for i, tc := range testData {
+		fakeServiceConfig := &confpb.Service{
+			Apis: []*apipb.Api{
+				{
+					Name: testApiName,
+				},
+			},
+			Backend: &confpb.Backend{
+				Rules: []*confpb.BackendRule{
+					{
+						Address:  tc.address,
+						Selector: "serverless.api",
+					},
+				},
+			},
+		}
+		opts := options.DefaultConfigGeneratorOptions()
+		opts.BackendClusters = tc.backendClusters
+		s, err := NewServiceInfoFromServiceConfig(fakeServiceConfig, testConfigID, opts)
+		if tc.wantedError != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.wantedError) {
+				t.Errorf("Test Desc(%d): %s, got error: %v, want error containing: %v", i, tc.desc, err, tc.wantedError)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Test Desc(%d): %s, error not expected, got: %v", i, tc.desc, err)
+			continue
+		}
+		if got := s.Methods["serverless.api"].BackendInfo.AutoHostRewrite; got != tc.wantedAutoHostRewrite {
+			t.Errorf("Test Desc(%d): %s, AutoHostRewrite not expected, got: %v, want: %v", i, tc.desc, got, tc.wantedAutoHostRewrite)
+		}
+	}
+}
+
+func TestProcessBackendRuleForKubernetesNamespace(t *testing.T) {
+	testData := []struct {
+		desc                 string
+		namespace            string
+		backendAddress       string
+		address              string
+		backendClusters      []*options.BackendClusterOptions
+		wantedCatchAllHost   string
+		wantedClusterName    string
+		wantedConnectTimeout time.Duration
+	}{
+		{
+			desc:               "Services in the namespace",
+			namespace:          "prod",
+			backendAddress:     "http://bookstore:8080",
+			address:            "https://shelves:8443/v1",
+			wantedCatchAllHost: "bookstore.prod.svc.cluster.local",
+			wantedClusterName:  "shelves.prod.svc.cluster.local:8443",
+		},
+		{
+			desc:           "Settings of the backend by its short or qualified hostname",
+			namespace:      "prod",
+			backendAddress: "http://127.0.0.1:8082",
+			address:        "https://shelves:8443/v1",
+			backendClusters: []*options.BackendClusterOptions{
+				{
+					BackendAddress: "https://shelves.prod.svc.cluster.local:8443",
+					ConnectTimeout: 5,
+				},
+			},
+			wantedCatchAllHost:   "127.0.0.1",
+			wantedClusterName:    "shelves.prod.svc.cluster.local:8443",
+			wantedConnectTimeout: 5 * time.Second,
+		},
+		{
+			desc:               "Hostnames as is without namespace",
+			backendAddress:     "http://bookstore:8080",
+			address:            "https://shelves:8443/v1",
+			wantedCatchAllHost: "bookstore",
+			wantedClusterName:  "shelves:8443",
+		},
+	}
+
+	for i, tc := range testData {
+		fakeServiceConfig := &confpb.Service{
+			Apis: []*apipb.Api{
+				{
+					Name: testApiName,
+				},
+			},
+			Backend: &confpb.Backend{
+				Rules: []*confpb.BackendRule{
+					{
+						Address:  tc.address,
+						Selector: "shelves.api",
+					},
+				},
+			},
+		}
+		opts := options.DefaultConfigGeneratorOptions()
+		opts.KubernetesNamespace = tc.namespace
+		opts.BackendAddress = tc.backendAddress
+		opts.BackendClusters = tc.backendClusters
+		s, err := NewServiceInfoFromServiceConfig(fakeServiceConfig, testConfigID, opts)
+		if err != nil {
+			t.Errorf("Test Desc(%d): %s, error not expected, got: %v", i, tc.desc, err)
+			continue
+		}
+		if got := s.CatchAllBackend.Hostname; got != tc.wantedCatchAllHost {
+			t.Errorf("Test Desc(%d): %s, catch-all backend hostname got: %v, want: %v", i, tc.desc, got, tc.wantedCatchAllHost)
+		}
+		if got := s.Methods["shelves.api"].BackendInfo.ClusterName; got != tc.wantedClusterName {
+			t.Errorf("Test Desc(%d): %s, cluster name got: %v, want: %v", i, tc.desc, got, tc.wantedClusterName)
+		}
+		if got := s.BackendRoutingClusters[0].ConnectTimeout; got != tc.wantedConnectTimeout {
+			t.Errorf("Test Desc(%d): %s, connect timeout got: %v, want: %v", i, tc.desc, got, tc.wantedConnectTimeout)
+		}
+	}
+}
+
+func TestProcessBackendSplits(t *testing.T) {
+	testData := []struct {
+		desc           string
+		backendSplits  []*options.BackendSplitOptions
+		wantedSplit    []*WeightedBackend
+		wantedClusters []string
+		wantedError    string
+	}{
+		{
+			desc: "Backends of a split share the clusters by host and port",
+			backendSplits: []*options.BackendSplitOptions{
+				{
+					Selector: "endpoints.examples.bookstore.Bookstore.ListShelves",
+					Backends: []*options.WeightedBackendOptions{
+						{
+							Name:    "v1",
+							Address: "https://abc.com",
+							Weight:  90,
+						},
+						{
+							Name:    "v2",
+							Address: "http://10.0.0.2:8080",
+							Weight:  10,
+						},
+					},
+				},
+				{
+					Selector: "unknown.selector",
+					Backends: []*options.WeightedBackendOptions{
+						{
+							Name:    "v1",
+							Address: "https://unknown.com",
+							Weight:  1,
+						},
+					},
+				},
+			},
+			wantedSplit: []*WeightedBackend{
+				{
+					Name:        "v1",
+					ClusterName: "abc.com:443",
+					Weight:      90,
+				},
+				{
+					Name:        "v2",
+					ClusterName: "10.0.0.2:8080",
+					Weight:      10,
+				},
+			},
+			wantedClusters: []string{"abc.com:443", "10.0.0.2:8080"},
+		},
+		{
+			desc: "Fail with duplicate backend names",
+			backendSplits: []*options.BackendSplitOptions{
+				{
+					Selector: "endpoints.examples.bookstore.Bookstore.ListShelves",
+					Backends: []*options.WeightedBackendOptions{
+						{
+							Name:    "v1",
+							Address: "https://abc.com",
+							Weight:  1,
+						},
+						{
+							Name:    "v1",
+							Address: "https://def.com",
+							Weight:  1,
+						},
+					},
+				},
+			},
+			wantedError: "fail to process backend splits: duplicate backend v1 for selector endpoints.examples.bookstore.Bookstore.ListShelves",
+		},
+		{
+			desc: "Fail with the path in a backend address",
+			backendSplits: []*options.BackendSplitOptions{
+				{
+					Selector: "endpoints.examples.bookstore.Bookstore.ListShelves",
+					Backends: []*options.WeightedBackendOptions{
+						{
+							Name:    "v1",
+							Address: "https://abc.com/v1",
+							Weight:  1,
+						},
+					},
+				},
+			},
+			wantedError: "address https://abc.com/v1 of backend v1 for selector endpoints.examples.bookstore.Bookstore.ListShelves must not have a path",
+		},
+		{
+			desc: "Fail without weights",
+			backendSplits: []*options.BackendSplitOptions{
+				{
+					Selector: "endpoints.examples.bookstore.Bookstore.ListShelves",
+					Backends: []*options.WeightedBackendOptions{
+						{
+							Name:    "v1",
+							Address: "https://abc.com",
+						},
+					},
+				},
+			},
+			wantedError: "backends of selector endpoints.examples.bookstore.Bookstore.ListShelves must have a positive total weight",
+		},
+	}
+
+	fakeServiceConfig := &confpb.Service{
+		Apis: []*apipb.Api{
+			{
+				Name: testApiName,
+				Methods: []*apipb.Method{
+					{
+						Name: "ListShelves",
+					},
+				},
+			},
+		},
+		Backend: &confpb.Backend{
+			Rules: []*confpb.BackendRule{
+				{
+					Address:  "https://abc.com/api",
+					Selector: "endpoints.examples.bookstore.Bookstore.ListShelves",
+				},
+			},
+		},
+	}
+
+	for i, tc := range testData {
+		opts := options.DefaultConfigGeneratorOptions()
+		opts.BackendSplits = tc.backendSplits
+		s, err := NewServiceInfoFromServiceConfig(fakeServiceConfig, testConfigID, opts)
+		if tc.wantedError != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.wantedError) {
+				t.Errorf("Test Desc(%d): %s, got error: %v, want error containing: %v", i, tc.desc, err, tc.wantedError)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Test Desc(%d): %s, error not expected, got: %v", i, tc.desc, err)
+			continue
+		}
+		gotSplit := s.Methods["endpoints.examples.bookstore.Bookstore.ListShelves"].BackendSplit
+		if !reflect.DeepEqual(gotSplit, tc.wantedSplit) {
+			t.Errorf("Test Desc(%d): %s, BackendSplit not expected, got: %+v, want: %+v", i, tc.desc, gotSplit, tc.wantedSplit)
+		}
+		var gotClusters []string
+		for _, c := range s.BackendRoutingClusters {
+			gotClusters = append(gotClusters, c.ClusterName)
+		}
+		if !reflect.DeepEqual(gotClusters, tc.wantedClusters) {
+			t.Errorf("Test Desc(%d): %s, BackendRoutingClusters not expected, got: %v, want: %v", i, tc.desc, gotClusters, tc.wantedClusters)
+		}
+	}
+}
+
+func TestProcessBodySizeLimits(t *testing.T) {
+	unlimited := uint32(0)
+	maxResponseBodyBytes := uint32(4096)
+	testData := []struct {
+		desc                       string
+		maxRequestBodyBytes        uint32
+		maxResponseBodyBytes       uint32
+		bodySizeLimits             []*options.BodySizeLimitOptions
+		wantedMaxRequestBodyBytes  map[string]uint32
+		wantedMaxResponseBodyBytes map[string]uint32
+	}{
+		{
+			desc:                 "The flags limit all the methods, except streaming ones",
+			maxRequestBodyBytes:  1024,
+			maxResponseBodyBytes: 2048,
+			wantedMaxRequestBodyBytes: map[string]uint32{
+				"endpoints.examples.bookstore.Bookstore.CreateShelf": 1024,
+			},
+			wantedMaxResponseBodyBytes: map[string]uint32{
+				"endpoints.examples.bookstore.Bookstore.CreateShelf": 2048,
+			},
+		},
+		{
+			desc:                "The limits of an operation are overridden",
+			maxRequestBodyBytes: 1024,
+			bodySizeLimits: []*options.BodySizeLimitOptions{
+				{
+					Selector:             "endpoints.examples.bookstore.Bookstore.CreateShelf",
+					MaxRequestBodyBytes:  &unlimited,
+					MaxResponseBodyBytes: &maxResponseBodyBytes,
+				},
+				{
+					Selector:             "endpoints.examples.bookstore.Bookstore.WatchShelves",
+					MaxResponseBodyBytes: &maxResponseBodyBytes,
+				},
+			},
+			wantedMaxResponseBodyBytes: map[string]uint32{
+				"endpoints.examples.bookstore.Bookstore.CreateShelf": 4096,
+			},
+		},
+	}
+
+	fakeServiceConfig := &confpb.Service{
+		Apis: []*apipb.Api{
+			{
+				Name: "endpoints.examples.bookstore.Bookstore",
+				Methods: []*apipb.Method{
+					{
+						Name: "CreateShelf",
+					},
+					{
+						Name:              "WatchShelves",
+						ResponseStreaming: true,
+					},
+				},
+			},
+		},
+	}
+
+	for i, tc := range testData {
+		opts := options.DefaultConfigGeneratorOptions()
+		opts.MaxRequestBodyBytes = tc.maxRequestBodyBytes
+		opts.MaxResponseBodyBytes = tc.maxResponseBodyBytes
+		opts.BodySizeLimits = tc.bodySizeLimits
+		s, err := NewServiceInfoFromServiceConfig(fakeServiceConfig, testConfigID, opts)
+		if err != nil {
+			t.Fatalf("Test Desc(%d): %s, error not expected, got: %v", i, tc.desc, err)
+		}
+
+		for _, selector := range []string{"endpoints.examples.bookstore.Bookstore.CreateShelf", "endpoints.examples.bookstore.Bookstore.WatchShelves"} {
+			method := s.Methods[selector]
+			if got, want := method.MaxRequestBodyBytes, tc.wantedMaxRequestBodyBytes[selector]; got != want {
+				t.Errorf("Test Desc(%d): %s, MaxRequestBodyBytes of %s not expected, got: %v, want: %v", i, tc.desc, selector, got, want)
+			}
+			if got, want := method.MaxResponseBodyBytes, tc.wantedMaxResponseBodyBytes[selector]; got != want {
+				t.Errorf("Test Desc(%d): %s, MaxResponseBodyBytes of %s not expected, got: %v, want: %v", i, tc.desc, selector, got, want)
+			}
+		}
+	}
+}
+
+func TestProcessExtAuthz(t *testing.T) {
+	testData := []struct {
+		desc                      string
+		extAuthzUri               string
+		extAuthzDisabledSelectors string
+		wantedCluster             *BackendRoutingCluster
+		wantedPathPrefix          string
+		wantedDisabledSelectors   []string
+		wantedError               string
+	}{
+		{
+			desc: "No external authorization without --ext_authz_uri",
+		},
+		{
+			desc:        "External authorization with a gRPC server",
+			extAuthzUri: "grpc://authz.example.com:9000",
+			wantedCluster: &BackendRoutingCluster{
+				ClusterName: util.ExtAuthzClusterName,
+				Hostname:    "authz.example.com",
+				Port:        9000,
+				Protocol:    util.GRPC,
+			},
+		},
+		{
+			desc:                      "External authorization with an HTTP server and a path prefix, disabled for an operation",
+			extAuthzUri:               "https://authz.example.com/check",
+			extAuthzDisabledSelectors: "endpoints.examples.bookstore.Bookstore.ListShelves, endpoints.examples.bookstore.Bookstore.Unknown",
+			wantedCluster: &BackendRoutingCluster{
+				ClusterName: util.ExtAuthzClusterName,
+				Hostname:    "authz.example.com",
+				Port:        443,
+				UseTLS:      true,
+				Protocol:    util.HTTP1,
+			},
+			wantedPathPrefix:        "/check",
+			wantedDisabledSelectors: []string{"endpoints.examples.bookstore.Bookstore.ListShelves"},
+		},
+		{
+			desc:        "Fail, the uri of a gRPC server has a path",
+			extAuthzUri: "grpcs://authz.example.com/check",
+			wantedError: "fail to parse --ext_authz_uri: the uri of a gRPC server cannot have a path, got /check",
+		},
+		{
+			desc:        "Fail, the uri has an unknown scheme",
+			extAuthzUri: "ftp://authz.example.com",
+			wantedError: `fail to parse --ext_authz_uri: unknown backend scheme [ftp], should be one of "http(s)" or "grpc(s)"`,
+		},
+	}
+
+	fakeServiceConfig := &confpb.Service{
+		Apis: []*apipb.Api{
+			{
+				Name: "endpoints.examples.bookstore.Bookstore",
+				Methods: []*apipb.Method{
+					{
+						Name: "CreateShelf",
+					},
+					{
+						Name: "ListShelves",
+					},
+				},
+			},
+		},
+	}
+
+	for i, tc := range testData {
+		opts := options.DefaultConfigGeneratorOptions()
+		opts.ExtAuthzUri = tc.extAuthzUri
+		opts.ExtAuthzDisabledSelectors = tc.extAuthzDisabledSelectors
+		s, err := NewServiceInfoFromServiceConfig(fakeServiceConfig, testConfigID, opts)
+		if tc.wantedError != "" {
+			if err == nil || err.Error() != tc.wantedError {
+				t.Errorf("Test Desc(%d): %s, got error: %v, want: %s", i, tc.desc, err, tc.wantedError)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Test Desc(%d): %s, error not expected, got: %v", i, tc.desc, err)
+		}
+
+		if !reflect.DeepEqual(s.ExtAuthzCluster, tc.wantedCluster) {
+			t.Errorf("Test Desc(%d): %s, ExtAuthzCluster not expected, got: %v, want: %v", i, tc.desc, s.ExtAuthzCluster, tc.wantedCluster)
+		}
+		if s.ExtAuthzPathPrefix != tc.wantedPathPrefix {
+			t.Errorf("Test Desc(%d): %s, ExtAuthzPathPrefix not expected, got: %v, want: %v", i, tc.desc, s.ExtAuthzPathPrefix, tc.wantedPathPrefix)
+		}
+		var gotDisabledSelectors []string
+		for _, selector := range []string{"endpoints.examples.bookstore.Bookstore.CreateShelf", "endpoints.examples.bookstore.Bookstore.ListShelves"} {
+			if s.Methods[selector].DisableExtAuthz {
+				gotDisabledSelectors = append(gotDisabledSelectors, selector)
+			}
+		}
+		if !reflect.DeepEqual(gotDisabledSelectors, tc.wantedDisabledSelectors) {
+			t.Errorf("Test Desc(%d): %s, methods with disabled external authorization not expected, got: %v, want: %v", i, tc.desc, gotDisabledSelectors, tc.wantedDisabledSelectors)
+		}
+	}
+}
+
+func TestProcessTracingSampleRates(t *testing.T) {
+	testData := []struct {
+		desc                        string
+		tracingOperationSampleRates string
+		disableTracing              bool
+		wantedSampleRates           map[string]float64
+		wantedError                 string
+	}{
+		{
+			desc: "No sample rates without --tracing_operation_sample_rates",
+		},
+		{
+			desc:                        "Sample rates of operations, unknown selectors ignored",
+			tracingOperationSampleRates: "endpoints.examples.bookstore.Bookstore.ListShelves=1.0, endpoints.examples.bookstore.Bookstore.Unknown=0.5",
+			wantedSampleRates: map[string]float64{
+				"endpoints.examples.bookstore.Bookstore.ListShelves": 1.0,
+			},
+		},
+		{
+			desc:                        "No sample rates with tracing disabled",
+			tracingOperationSampleRates: "endpoints.examples.bookstore.Bookstore.ListShelves=1.0",
+			disableTracing:              true,
+		},
+		{
+			desc:                        "Fail, missing the rate",
+			tracingOperationSampleRates: "endpoints.examples.bookstore.Bookstore.ListShelves",
+			wantedError:                 `fail to parse --tracing_operation_sample_rates: "endpoints.examples.bookstore.Bookstore.ListShelves" is not "<selector>=<rate>"`,
+		},
+		{
+			desc:                        "Fail, the rate is above 1.0",
+			tracingOperationSampleRates: "endpoints.examples.bookstore.Bookstore.ListShelves=2",
+			wantedError:                 `fail to parse --tracing_operation_sample_rates: rate of endpoints.examples.bookstore.Bookstore.ListShelves must be >= 0.0 and <= 1.0, got "2"`,
+		},
+	}
+
+	fakeServiceConfig := &confpb.Service{
+		Apis: []*apipb.Api{
+			{
+				Name: "endpoints.examples.bookstore.Bookstore",
+				Methods: []*apipb.Method{
+					{
+						Name: "CreateShelf",
+					},
+					{
+						Name: "ListShelves",
+					},
+				},
+			},
+		},
+	}
+
+	for i, tc := range testData {
+		opts := options.DefaultConfigGeneratorOptions()
+		opts.TracingOperationSampleRates = tc.tracingOperationSampleRates
+		opts.DisableTracing = tc.disableTracing
+		s, err := NewServiceInfoFromServiceConfig(fakeServiceConfig, testConfigID, opts)
+		if tc.wantedError != "" {
+			if err == nil || err.Error() != tc.wantedError {
+				t.Errorf("Test Desc(%d): %s, got error: %v, want: %s", i, tc.desc, err, tc.wantedError)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Test Desc(%d): %s, error not expected, got: %v", i, tc.desc, err)
+		}
+
+		var gotSampleRates map[string]float64
+		for selector, method := range s.Methods {
+			if method.TracingSampleRate != nil {
+				if gotSampleRates == nil {
+					gotSampleRates = make(map[string]float64)
+				}
+				gotSampleRates[selector] = *method.TracingSampleRate
+			}
+		}
+		if !reflect.DeepEqual(gotSampleRates, tc.wantedSampleRates) {
+			t.Errorf("Test Desc(%d): %s, sample rates not expected, got: %v, want: %v", i, tc.desc, gotSampleRates, tc.wantedSampleRates)
+		}
+	}
+}
+
+func TestProcessRateLimits(t *testing.T) {
+	testData := []struct {
+		desc                       string
+		rateLimitRequestsPerSecond uint32
+		rateLimitBurst             uint32
+		rateLimits                 []*options.RateLimitOptions
+		rateLimitServiceUri        string
+		skipServiceControlFilter   bool
+		wantedGlobalRateLimit      *scpb.RateLimit
+		wantedRateLimits           map[string]*scpb.RateLimit
+		wantedCluster              *BackendRoutingCluster
+		wantedError                string
+	}{
+		{
+			desc: "No rate limits",
+		},
+		{
+			desc:                       "The flags limit all the methods, and the limits of an operation are overridden",
+			rateLimitRequestsPerSecond: 100,
+			rateLimitBurst:             200,
+			rateLimits: []*options.RateLimitOptions{
+				{
+					Selector:          "endpoints.examples.bookstore.Bookstore.CreateShelf",
+					RequestsPerSecond: 10,
+				},
+				{
+					Selector:          "endpoints.examples.bookstore.Bookstore.Unknown",
+					RequestsPerSecond: 10,
+				},
+			},
+			wantedGlobalRateLimit: &scpb.RateLimit{
+				RequestsPerSecond: 100,
+				Burst:             200,
+			},
+			wantedRateLimits: map[string]*scpb.RateLimit{
+				"endpoints.examples.bookstore.Bookstore.CreateShelf": {
+					RequestsPerSecond: 10,
+				},
+			},
+		},
+		{
+			desc:                "The rate limit service cluster",
+			rateLimitServiceUri: "grpcs://ratelimit.example.com",
+			wantedCluster: &BackendRoutingCluster{
+				ClusterName: util.RateLimitServiceClusterName,
+				Hostname:    "ratelimit.example.com",
+				Port:        443,
+				UseTLS:      true,
+				Protocol:    util.GRPC,
+			},
+		},
+		{
+			desc:           "Fail, burst without requests per second",
+			rateLimitBurst: 10,
+			wantedError:    "fail to process rate limits: --rate_limit_burst requires --rate_limit_requests_per_second",
+		},
+		{
+			desc: "Fail, burst of an operation without requests per second",
+			rateLimits: []*options.RateLimitOptions{
+				{
+					Selector: "endpoints.examples.bookstore.Bookstore.CreateShelf",
+					Burst:    10,
+				},
+			},
+			wantedError: "fail to process rate limits: burst of selector endpoints.examples.bookstore.Bookstore.CreateShelf requires requests_per_second",
+		},
+		{
+			desc:                       "Fail, the Service Control filter is disabled",
+			rateLimitRequestsPerSecond: 100,
+			skipServiceControlFilter:   true,
+			wantedError:                "fail to process rate limits: the rate limits are enforced by the Service Control filter, which is disabled",
+		},
+		{
+			desc:                "Fail, the rate limit service does not use gRPC",
+			rateLimitServiceUri: "http://ratelimit.example.com",
+			wantedError:         `fail to parse --rate_limit_service_uri: the scheme must be "grpc" or "grpcs", got http`,
+		},
+	}
+
+	fakeServiceConfig := &confpb.Service{
+		Apis: []*apipb.Api{
+			{
+				Name: "endpoints.examples.bookstore.Bookstore",
+				Methods: []*apipb.Method{
+					{
+						Name: "CreateShelf",
+					},
+					{
+						Name: "ListShelves",
+					},
+				},
+			},
+		},
+		Control: &confpb.Control{
+			Environment: "servicecontrol.googleapis.com",
+		},
+	}
+
+	for i, tc := range testData {
+		opts := options.DefaultConfigGeneratorOptions()
+		opts.RateLimitRequestsPerSecond = tc.rateLimitRequestsPerSecond
+		opts.RateLimitBurst = tc.rateLimitBurst
+		opts.RateLimits = tc.rateLimits
+		opts.RateLimitServiceUri = tc.rateLimitServiceUri
+		opts.SkipServiceControlFilter = tc.skipServiceControlFilter
+		s, err := NewServiceInfoFromServiceConfig(fakeServiceConfig, testConfigID, opts)
+		if tc.wantedError != "" {
+			if err == nil || err.Error() != tc.wantedError {
+				t.Errorf("Test Desc(%d): %s, got error: %v, want: %s", i, tc.desc, err, tc.wantedError)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Test Desc(%d): %s, error not expected, got: %v", i, tc.desc, err)
+		}
+
+		if !proto.Equal(s.GlobalRateLimit, tc.wantedGlobalRateLimit) {
+			t.Errorf("Test Desc(%d): %s, GlobalRateLimit not expected, got: %v, want: %v", i, tc.desc, s.GlobalRateLimit, tc.wantedGlobalRateLimit)
+		}
+		for _, selector := range []string{"endpoints.examples.bookstore.Bookstore.CreateShelf", "endpoints.examples.bookstore.Bookstore.ListShelves"} {
+			if got, want := s.Methods[selector].RateLimit, tc.wantedRateLimits[selector]; !proto.Equal(got, want) {
+				t.Errorf("Test Desc(%d): %s, RateLimit of %s not expected, got: %v, want: %v", i, tc.desc, selector, got, want)
+			}
+		}
+		if !reflect.DeepEqual(s.RateLimitServiceCluster, tc.wantedCluster) {
+			t.Errorf("Test Desc(%d): %s, RateLimitServiceCluster not expected, got: %v, want: %v", i, tc.desc, s.RateLimitServiceCluster, tc.wantedCluster)
+		}
+	}
+}
+
+func TestProcessAccessLog(t *testing.T) {
+	testData := []struct {
+		desc                       string
+		accessLogServiceUri        string
+		accessLogDisabledSelectors string
+		wantedDisabled             map[string]bool
+		wantedCluster              *BackendRoutingCluster
+		wantedError                string
+	}{
+		{
+			desc: "Success, access logs of all operations without the access log service",
+		},
+		{
+			desc:                       "Success, access logs of an operation disabled",
+			accessLogDisabledSelectors: "endpoints.examples.bookstore.Bookstore.CreateShelf, endpoints.examples.bookstore.Bookstore.Unknown",
+			wantedDisabled: map[string]bool{
+				"endpoints.examples.bookstore.Bookstore.CreateShelf": true,
+			},
+		},
+		{
+			desc:                "Success, access log service over TLS",
+			accessLogServiceUri: "grpcs://als.example.com",
+			wantedCluster: &BackendRoutingCluster{
+				ClusterName: "access-log-service-cluster",
+				Hostname:    "als.example.com",
+				Port:        443,
+				UseTLS:      true,
+				Protocol:    util.GRPC,
+			},
+		},
+		{
+			desc:                "Fail, the access log service does not use gRPC",
+			accessLogServiceUri: "https://als.example.com",
+			wantedError:         `fail to parse --access_log_service_uri: the scheme must be "grpc" or "grpcs", got https`,
+		},
+	}
+
+	fakeServiceConfig := &confpb.Service{
+		Apis: []*apipb.Api{
+			{
+				Name: "endpoints.examples.bookstore.Bookstore",
+				Methods: []*apipb.Method{
+					{
+						Name: "CreateShelf",
+					},
+					{
+						Name: "ListShelves",
+					},
+				},
+			},
+		},
+	}
+
+	for i, tc := range testData {
+		opts := options.DefaultConfigGeneratorOptions()
+		opts.AccessLogServiceUri = tc.accessLogServiceUri
+		opts.AccessLogDisabledSelectors = tc.accessLogDisabledSelectors
+		s, err := NewServiceInfoFromServiceConfig(fakeServiceConfig, testConfigID, opts)
+		if tc.wantedError != "" {
+			if err == nil || err.Error() != tc.wantedError {
+				t.Errorf("Test Desc(%d): %s, got error: %v, want: %s", i, tc.desc, err, tc.wantedError)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Test Desc(%d): %s, error not expected, got: %v", i, tc.desc, err)
+		}
+
+		for _, selector := range []string{"endpoints.examples.bookstore.Bookstore.CreateShelf", "endpoints.examples.bookstore.Bookstore.ListShelves"} {
+			if got, want := s.Methods[selector].DisableAccessLog, tc.wantedDisabled[selector]; got != want {
+				t.Errorf("Test Desc(%d): %s, DisableAccessLog of %s not expected, got: %v, want: %v", i, tc.desc, selector, got, want)
+			}
+		}
+		if !reflect.DeepEqual(s.AccessLogServiceCluster, tc.wantedCluster) {
+			t.Errorf("Test Desc(%d): %s, AccessLogServiceCluster not expected, got: %v, want: %v", i, tc.desc, s.AccessLogServiceCluster, tc.wantedCluster)
+		}
+	}
+}
+
+func TestProcessReportLabels(t *testing.T) {
+	testData := []struct {
+		desc         string
+		reportLabels string
+		logRequestId bool
+		wantedLabels []*scpb.ReportLabel
+		wantedError  string
+	}{
+		{
+			desc: "Success, no report labels",
+		},
+		{
+			desc:         "Success, labels from a header and a JWT claim",
+			reportLabels: "tenant_id=header:x-tenant-id, client_version=jwt:client.version",
+			wantedLabels: []*scpb.ReportLabel{
+				{
+					Name:   "tenant_id",
+					Source: &scpb.ReportLabel_Header{Header: "x-tenant-id"},
+				},
+				{
+					Name:   "client_version",
+					Source: &scpb.ReportLabel_JwtClaim{JwtClaim: "client.version"},
+				},
+			},
+		},
+		{
+			desc:         "Success, label of the request ID",
+			reportLabels: "tenant_id=header:x-tenant-id",
+			logRequestId: true,
+			wantedLabels: []*scpb.ReportLabel{
+				{
+					Name:   "tenant_id",
+					Source: &scpb.ReportLabel_Header{Header: "x-tenant-id"},
+				},
+				{
+					Name:   "request_id",
+					Source: &scpb.ReportLabel_Header{Header: "x-request-id"},
+				},
+			},
+		},
+		{
+			desc:         "Success, label of the request ID set by the flag",
+			reportLabels: "request_id=header:x-correlation-id",
+			logRequestId: true,
+			wantedLabels: []*scpb.ReportLabel{
+				{
+					Name:   "request_id",
+					Source: &scpb.ReportLabel_Header{Header: "x-correlation-id"},
+				},
+			},
+		},
+		{
+			desc:         "Fail, label without source",
+			reportLabels: "tenant_id",
+			wantedError:  `fail to parse --service_control_report_labels: "tenant_id" is not "<label>=header:<header>" or "<label>=jwt:<claim path>"`,
+		},
+		{
+			desc:         "Fail, unknown source",
+			reportLabels: "tenant_id=query:tenant",
+			wantedError:  `fail to parse --service_control_report_labels: source of label tenant_id must be "header:<header>" or "jwt:<claim path>", got "query:tenant"`,
+		},
+		{
+			desc:         "Fail, duplicated label",
+			reportLabels: "tenant_id=header:x-tenant-id,tenant_id=jwt:tenant",
+			wantedError:  "fail to parse --service_control_report_labels: label tenant_id is duplicated",
+		},
+	}
+
+	for i, tc := range testData {
+		opts := options.DefaultConfigGeneratorOptions()
+		opts.ServiceControlReportLabels = tc.reportLabels
+		opts.LogRequestId = tc.logRequestId
+		s, err := NewServiceInfoFromServiceConfig(&confpb.Service{
+			Apis: []*apipb.Api{
+				{
+					Name: "endpoints.examples.bookstore.Bookstore",
+				},
+			},
+		}, testConfigID, opts)
+		if tc.wantedError != "" {
+			if err == nil || err.Error() != tc.wantedError {
+				t.Errorf("Test Desc(%d): %s, got error: %v, want: %s", i, tc.desc, err, tc.wantedError)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Test Desc(%d): %s, error not expected, got: %v", i, tc.desc, err)
+		}
+
+		if len(s.ReportLabels) != len(tc.wantedLabels) {
+			t.Fatalf("Test Desc(%d): %s, got ReportLabels: %v, want: %v", i, tc.desc, s.ReportLabels, tc.wantedLabels)
+		}
+		for j := range s.ReportLabels {
+			if !proto.Equal(s.ReportLabels[j], tc.wantedLabels[j]) {
+				t.Errorf("Test Desc(%d): %s, got ReportLabel: %v, want: %v", i, tc.desc, s.ReportLabels[j], tc.wantedLabels[j])
+			}
+		}
+	}
+}
+
+func TestProcessJwtAudiences(t *testing.T) {
+	testData := []struct {
+		desc              string
+		providers         []*confpb.AuthProvider
+		requirements      []*confpb.AuthRequirement
+		wantedAudiences   []string
+		wantedErrorPrefix string
+	}{
+		{
+			desc: "Exact audiences are not matched on the routes",
+			providers: []*confpb.AuthProvider{
+				{
+					Id:        "provider_1",
+					Audiences: "https://api.example.com",
+				},
+			},
+			requirements: []*confpb.AuthRequirement{
+				{
+					ProviderId: "provider_1",
+				},
+			},
+		},
+		{
+			desc: "The audiences of all the providers are matched if one has a wildcard audience",
+			providers: []*confpb.AuthProvider{
+				{
+					Id:        "provider_1",
+					Audiences: "https://api.example.com, https://*.example.com",
+				},
+				{
+					Id: "provider_2",
+				},
+				{
+					Id:        "provider_3",
+					Audiences: "regex:https://.*",
+				},
+			},
+			requirements: []*confpb.AuthRequirement{
+				{
+					ProviderId: "provider_1",
+				},
+				{
+					ProviderId: "provider_2",
+				},
+				{
+					ProviderId: "provider_3",
+					Audiences:  "https://override.example.com",
+				},
+			},
+			wantedAudiences: []string{
+				"https://api.example.com",
+				"https://*.example.com",
+				"https://bookstore.endpoints.project123.cloud.goog",
+				"https://override.example.com",
+			},
+		},
+		{
+			desc: "Overridden audiences are matched by the JWT Authn filter",
+			providers: []*confpb.AuthProvider{
+				{
+					Id:        "provider_1",
+					Audiences: "https://*.example.com",
+				},
+			},
+			requirements: []*confpb.AuthRequirement{
+				{
+					ProviderId: "provider_1",
+					Audiences:  "https://api.example.com",
+				},
+			},
+		},
+		{
+			desc: "Fail with an invalid regex audience",
+			providers: []*confpb.AuthProvider{
+				{
+					Id:        "provider_1",
+					Audiences: "regex:https://(",
+				},
+			},
+			wantedErrorPrefix: "fail to parse the audience regex:https://( of JWT provider provider_1",
+		},
+	}
+
+	for i, tc := range testData {
+		for _, provider := range tc.providers {
+			provider.JwksUri = "https://fake-jwks.com"
+		}
+		fakeServiceConfig := &confpb.Service{
+			Name: testProjectName,
+			Apis: []*apipb.Api{
+				{
+					Name: testApiName,
+					Methods: []*apipb.Method{
+						{
+							Name: "ListShelves",
+						},
+					},
+				},
+			},
+			Authentication: &confpb.Authentication{
+				Providers: tc.providers,
+				Rules: []*confpb.AuthenticationRule{
+					{
+						Selector:     "endpoints.examples.bookstore.Bookstore.ListShelves",
+						Requirements: tc.requirements,
+					},
+				},
+			},
+		}
+		s, err := NewServiceInfoFromServiceConfig(fakeServiceConfig, testConfigID, options.DefaultConfigGeneratorOptions())
+		if tc.wantedErrorPrefix != "" {
+			if err == nil || !strings.HasPrefix(err.Error(), tc.wantedErrorPrefix) {
+				t.Errorf("Test Desc(%d): %s, got error: %v, want error prefix: %s", i, tc.desc, err, tc.wantedErrorPrefix)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Test Desc(%d): %s, error not expected, got: %v", i, tc.desc, err)
+		}
+
+		got := s.Methods["endpoints.examples.bookstore.Bookstore.ListShelves"].JwtAudiences
+		if !reflect.DeepEqual(got, tc.wantedAudiences) {
+			t.Errorf("Test Desc(%d): %s, JwtAudiences not expected, got: %v, want: %v", i, tc.desc, got, tc.wantedAudiences)
+		}
+	}
+}
+
+func TestProcessJwtClaimHeaders(t *testing.T) {
+	testData := []struct {
+		desc                  string
+		jwtClaimHeaders       string
+		jwtClaimHeadersConfig []*options.JwtClaimHeaderOptions
+		wantedListShelves     []*JwtClaimHeader
+		wantedGetShelf        []*JwtClaimHeader
+		wantedErrorPrefix     string
+	}{
+		{
+			desc: "No claims are forwarded by default",
+		},
+		{
+			desc:            "Claims are forwarded for the methods requiring JWTs",
+			jwtClaimHeaders: "sub=X-User-Id, google.project_id=x-project-id",
+			wantedListShelves: []*JwtClaimHeader{
+				{
+					ClaimPath: []string{"google", "project_id"},
+					Header:    "x-project-id",
+				},
+				{
+					ClaimPath: []string{"sub"},
+					Header:    "x-user-id",
+				},
+			},
+		},
+		{
+			desc:            "Claims are overridden by operation",
+			jwtClaimHeaders: "sub=x-user-id",
+			jwtClaimHeadersConfig: []*options.JwtClaimHeaderOptions{
+				{
+					Selector:     "endpoints.examples.bookstore.Bookstore.ListShelves",
+					ClaimHeaders: map[string]string{"email": "x-user-email"},
+				},
+			},
+			wantedListShelves: []*JwtClaimHeader{
+				{
+					ClaimPath: []string{"email"},
+					Header:    "x-user-email",
+				},
+			},
+		},
+		{
+			desc:              "Fail with a malformed --jwt_claim_headers",
+			jwtClaimHeaders:   "sub",
+			wantedErrorPrefix: "fail to parse --jwt_claim_headers",
+		},
+		{
+			desc:              "Fail with an invalid header name",
+			jwtClaimHeaders:   "sub=x user",
+			wantedErrorPrefix: `fail to parse --jwt_claim_headers: invalid header name "x user" for claim sub`,
+		},
+		{
+			desc:              "Fail with an empty nested claim",
+			jwtClaimHeaders:   "google..id=x-id",
+			wantedErrorPrefix: `fail to parse --jwt_claim_headers: invalid claim "google..id"`,
+		},
+		{
+			desc: "Fail with a header set by two claims",
+			jwtClaimHeadersConfig: []*options.JwtClaimHeaderOptions{
+				{
+					Selector:     "endpoints.examples.bookstore.Bookstore.ListShelves",
+					ClaimHeaders: map[string]string{"sub": "x-user", "email": "X-User"},
+				},
+			},
+			wantedErrorPrefix: "fail to parse the JWT claim headers of selector endpoints.examples.bookstore.Bookstore.ListShelves: header x-user is set by both claims",
+		},
+	}
+
+	for i, tc := range testData {
+		fakeServiceConfig := &confpb.Service{
+			Name: testProjectName,
+			Apis: []*apipb.Api{
+				{
+					Name: testApiName,
+					Methods: []*apipb.Method{
+						{
+							Name: "ListShelves",
+						},
+						{
+							Name: "GetShelf",
+						},
+					},
+				},
+			},
+			Authentication: &confpb.Authentication{
+				Providers: []*confpb.AuthProvider{
+					{
+						Id:      "auth_provider",
+						Issuer:  "issuer-0",
+						JwksUri: "https://fake-jwks.com",
+					},
+				},
+				Rules: []*confpb.AuthenticationRule{
+					{
+						Selector: "endpoints.examples.bookstore.Bookstore.ListShelves",
+						Requirements: []*confpb.AuthRequirement{
+							{
+								ProviderId: "auth_provider",
+							},
+						},
+					},
+				},
+			},
+		}
+		opts := options.DefaultConfigGeneratorOptions()
+		opts.JwtClaimHeaders = tc.jwtClaimHeaders
+		opts.JwtClaimHeadersConfig = tc.jwtClaimHeadersConfig
+		s, err := NewServiceInfoFromServiceConfig(fakeServiceConfig, testConfigID, opts)
+		if tc.wantedErrorPrefix != "" {
+			if err == nil || !strings.HasPrefix(err.Error(), tc.wantedErrorPrefix) {
+				t.Errorf("Test Desc(%d): %s, got error: %v, want error prefix: %s", i, tc.desc, err, tc.wantedErrorPrefix)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Test Desc(%d): %s, error not expected, got: %v", i, tc.desc, err)
+		}
+
+		got := s.Methods["endpoints.examples.bookstore.Bookstore.ListShelves"].JwtClaimHeaders
+		if !reflect.DeepEqual(got, tc.wantedListShelves) {
+			t.Errorf("Test Desc(%d): %s, JwtClaimHeaders of ListShelves not expected, got: %v, want: %v", i, tc.desc, got, tc.wantedListShelves)
+		}
+		got = s.Methods["endpoints.examples.bookstore.Bookstore.GetShelf"].JwtClaimHeaders
+		if !reflect.DeepEqual(got, tc.wantedGetShelf) {
+			t.Errorf("Test Desc(%d): %s, JwtClaimHeaders of GetShelf not expected, got: %v, want: %v", i, tc.desc, got, tc.wantedGetShelf)
+		}
+	}
+}
+
+func TestProcessAuthorizationRules(t *testing.T) {
+	testData := []struct {
+		desc               string
+		authorizationRules []*options.AuthorizationRuleOptions
+		wantedPolicies     []*AuthorizationPolicy
+		wantedErrorPrefix  string
+	}{
+		{
+			desc: "No authorization rules",
+		},
+		{
+			desc: "Authorization policies by claims",
+			authorizationRules: []*options.AuthorizationRuleOptions{
+				{
+					Selector: "endpoints.examples.bookstore.Bookstore.ListShelves",
+					Allow: []*options.AuthorizationPolicyOptions{
+						{
+							Claims: map[string][]string{"roles": {"admin"}, "google.org": {"example.com", "example.org"}},
+						},
+						{
+							Claims: map[string][]string{"sub": {"user-1"}},
+						},
+					},
+				},
+				{
+					Selector: "endpoints.examples.bookstore.Bookstore.UnknownMethod",
+					Allow: []*options.AuthorizationPolicyOptions{
+						{
+							Claims: map[string][]string{"sub": {"user-1"}},
+						},
+					},
+				},
+			},
+			wantedPolicies: []*AuthorizationPolicy{
+				{
+					Claims: []*AuthorizationClaim{
+						{
+							ClaimPath: []string{"google", "org"},
+							Values:    []string{"example.com", "example.org"},
+						},
+						{
+							ClaimPath: []string{"roles"},
+							Values:    []string{"admin"},
+						},
+					},
+				},
+				{
+					Claims: []*AuthorizationClaim{
+						{
+							ClaimPath: []string{"sub"},
+							Values:    []string{"user-1"},
+						},
+					},
+				},
+			},
+		},
+		{
+			desc: "Fail with an operation requiring no JWTs",
+			authorizationRules: []*options.AuthorizationRuleOptions{
+				{
+					Selector: "endpoints.examples.bookstore.Bookstore.GetShelf",
+					Allow: []*options.AuthorizationPolicyOptions{
+						{
+							Claims: map[string][]string{"sub": {"user-1"}},
+						},
+					},
+				},
+			},
+			wantedErrorPrefix: "fail to process the authorization rule of selector endpoints.examples.bookstore.Bookstore.GetShelf: the operation requires no JWTs",
+		},
+		{
+			desc: "Fail with a policy without claims",
+			authorizationRules: []*options.AuthorizationRuleOptions{
+				{
+					Selector: "endpoints.examples.bookstore.Bookstore.ListShelves",
+					Allow: []*options.AuthorizationPolicyOptions{
+						{},
+					},
+				},
+			},
+			wantedErrorPrefix: "fail to process the authorization rule of selector endpoints.examples.bookstore.Bookstore.ListShelves: policy 0: claims are required",
+		},
+		{
+			desc: "Fail with a claim without values",
+			authorizationRules: []*options.AuthorizationRuleOptions{
+				{
+					Selector: "endpoints.examples.bookstore.Bookstore.ListShelves",
+					Allow: []*options.AuthorizationPolicyOptions{
+						{
+							Claims: map[string][]string{"roles": {}},
+						},
+					},
+				},
+			},
+			wantedErrorPrefix: "fail to process the authorization rule of selector endpoints.examples.bookstore.Bookstore.ListShelves: policy 0: claim roles has no values",
+		},
+	}
+
+	for i, tc := range testData {
+		fakeServiceConfig := &confpb.Service{
+			Name: testProjectName,
+			Apis: []*apipb.Api{
+				{
+					Name: testApiName,
+					Methods: []*apipb.Method{
+						{
+							Name: "ListShelves",
+						},
+						{
+							Name: "GetShelf",
+						},
+					},
+				},
+			},
+			Authentication: &confpb.Authentication{
+				Providers: []*confpb.AuthProvider{
+					{
+						Id:      "auth_provider",
+						Issuer:  "issuer-0",
+						JwksUri: "https://fake-jwks.com",
+					},
+				},
+				Rules: []*confpb.AuthenticationRule{
+					{
+						Selector: "endpoints.examples.bookstore.Bookstore.ListShelves",
+						Requirements: []*confpb.AuthRequirement{
+							{
+								ProviderId: "auth_provider",
+							},
+						},
+					},
+				},
+			},
+		}
+		opts := options.DefaultConfigGeneratorOptions()
+		opts.AuthorizationRules = tc.authorizationRules
+		s, err := NewServiceInfoFromServiceConfig(fakeServiceConfig, testConfigID, opts)
+		if tc.wantedErrorPrefix != "" {
+			if err == nil || !strings.HasPrefix(err.Error(), tc.wantedErrorPrefix) {
+				t.Errorf("Test Desc(%d): %s, got error: %v, want error prefix: %s", i, tc.desc, err, tc.wantedErrorPrefix)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Test Desc(%d): %s, error not expected, got: %v", i, tc.desc, err)
+		}
+
+		got := s.Methods["endpoints.examples.bookstore.Bookstore.ListShelves"].AuthorizationPolicies
+		if !reflect.DeepEqual(got, tc.wantedPolicies) {
+			t.Errorf("Test Desc(%d): %s, AuthorizationPolicies not expected, got: %v, want: %v", i, tc.desc, got, tc.wantedPolicies)
+		}
+	}
+}
+
+func TestProcessQuota(t *testing.T) {
+	testData := []struct {
+		desc              string
+		fakeServiceConfig *confpb.Service
+		wantMethods       map[string]*methodInfo
+		wantError         string
+	}{
+		{
+			desc: "Succeed, simple case",
+			fakeServiceConfig: &confpb.Service{
+				Apis: []*apipb.Api{
+					{
+						Name: testApiName,
+						Methods: []*apipb.Method{
+							{
+								Name: "ListShelves",
+							},
+						},
+					},
+				},
+				Quota: &confpb.Quota{
+					MetricRules: []*confpb.MetricRule{
+						{
+							Selector: "endpoints.examples.bookstore.Bookstore.ListShelves",
+							MetricCosts: map[string]int64{
+								"metric_a": 2,
+								"metric_b": 1,
+							},
+						},
+					},
+				},
+			},
+			wantMethods: map[string]*methodInfo{
+				fmt.Sprintf("%s.%s", testApiName, "ListShelves"): &methodInfo{
+					ShortName: "ListShelves",
+					ApiName:   testApiName,
+					HttpRule: []*commonpb.Pattern{
+						{
+							UriTemplate: fmt.Sprintf("/%s/%s", testApiName, "ListShelves"),
+							HttpMethod:  util.POST,
+						},
+					},
+					MetricCosts: []*scpb.MetricCost{
+						{
+							Name: "metric_a",
+							Cost: 2,
+						},
+						{
+							Name: "metric_b",
+							Cost: 1,
+						},
+					},
+				},
+			},
+		},
+		{
+			desc: "Succeed, two metric cost items",
+			fakeServiceConfig: &confpb.Service{
+				Apis: []*apipb.Api{
+					{
+						Name: testApiName,
+						Methods: []*apipb.Method{
+							{
+								Name: "ListShelves",
+							},
+						},
+					},
+				},
+				Quota: &confpb.Quota{
+					MetricRules: []*confpb.MetricRule{
+						{
+							Selector: "endpoints.examples.bookstore.Bookstore.ListShelves",
+							MetricCosts: map[string]int64{
+								"metric_c": 2,
+								"metric_a": 3,
+							},
+						},
+					},
+				},
+			},
+			wantMethods: map[string]*methodInfo{
+				fmt.Sprintf("%s.%s", testApiName, "ListShelves"): &methodInfo{
+					ShortName: "ListShelves",
+					ApiName:   testApiName,
+					HttpRule: []*commonpb.Pattern{
+						{
+							UriTemplate: fmt.Sprintf("/%s/%s", testApiName, "ListShelves"),
+							HttpMethod:  util.POST,
+						},
+					},
+					MetricCosts: []*scpb.MetricCost{
+						{
+							Name: "metric_a",
+							Cost: 3,
+						},
+						{
+							Name: "metric_c",
+							Cost: 2,
+						},
+					},
+				},
+			},
+		},
+		{
+			desc: "Succeed, metrics of the quota limits",
+			fakeServiceConfig: &confpb.Service{
+				Apis: []*apipb.Api{
+					{
+						Name: testApiName,
+						Methods: []*apipb.Method{
+							{
+								Name: "ListShelves",
+							},
+						},
+					},
+				},
+				Quota: &confpb.Quota{
+					Limits: []*confpb.QuotaLimit{
+						{
+							Name:   "read_limit",
+							Metric: "metric_a",
+						},
+					},
+					MetricRules: []*confpb.MetricRule{
+						{
+							Selector: "endpoints.examples.bookstore.Bookstore.ListShelves",
+							MetricCosts: map[string]int64{
+								"metric_a": 1,
+							},
+						},
+					},
+				},
+			},
+			wantMethods: map[string]*methodInfo{
+				fmt.Sprintf("%s.%s", testApiName, "ListShelves"): &methodInfo{
+					ShortName: "ListShelves",
+					ApiName:   testApiName,
+					HttpRule: []*commonpb.Pattern{
+						{
+							UriTemplate: fmt.Sprintf("/%s/%s", testApiName, "ListShelves"),
+							HttpMethod:  util.POST,
+						},
+					},
+					MetricCosts: []*scpb.MetricCost{
+						{
+							Name: "metric_a",
+							Cost: 1,
+						},
+					},
+				},
+			},
+		},
+		{
+			desc: "Fail, metric without quota limit",
+			fakeServiceConfig: &confpb.Service{
+				Apis: []*apipb.Api{
+					{
+						Name: testApiName,
+						Methods: []*apipb.Method{
+							{
+								Name: "ListShelves",
+							},
+						},
+					},
+				},
+				Quota: &confpb.Quota{
+					Limits: []*confpb.QuotaLimit{
+						{
+							Name:   "read_limit",
+							Metric: "metric_a",
+						},
+					},
+					MetricRules: []*confpb.MetricRule{
+						{
+							Selector: "endpoints.examples.bookstore.Bookstore.ListShelves",
+							MetricCosts: map[string]int64{
+								"metric_b": 1,
+							},
+						},
+					},
+				},
+			},
+			wantError: `metric "metric_b" of operation "endpoints.examples.bookstore.Bookstore.ListShelves" has no quota limit`,
+		},
+		{
+			desc: "Fail, metric rule of unknown operation",
+			fakeServiceConfig: &confpb.Service{
+				Apis: []*apipb.Api{
+					{
+						Name: testApiName,
+						Methods: []*apipb.Method{
+							{
+								Name: "ListShelves",
+							},
+						},
+					},
+				},
+				Quota: &confpb.Quota{
+					MetricRules: []*confpb.MetricRule{
+						{
+							Selector: "endpoints.examples.bookstore.Bookstore.CreateShelf",
+							MetricCosts: map[string]int64{
+								"metric_a": 1,
+							},
+						},
+					},
+				},
+			},
+			wantError: `metric rule selector "endpoints.examples.bookstore.Bookstore.CreateShelf" is not an operation`,
+		},
+		{
+			desc: "Fail, negative metric cost",
+			fakeServiceConfig: &confpb.Service{
+				Apis: []*apipb.Api{
+					{
+						Name: testApiName,
+						Methods: []*apipb.Method{
+							{
+								Name: "ListShelves",
+							},
+						},
+					},
+				},
+				Quota: &confpb.Quota{
+					MetricRules: []*confpb.MetricRule{
+						{
+							Selector: "endpoints.examples.bookstore.Bookstore.ListShelves",
+							MetricCosts: map[string]int64{
+								"metric_a": -1,
+							},
+						},
+					},
+				},
+			},
+			wantError: `cost of metric "metric_a" of operation "endpoints.examples.bookstore.Bookstore.ListShelves" must be positive, got -1`,
+		},
+	}
+
+	for i, tc := range testData {
+		opts := options.DefaultConfigGeneratorOptions()
+		opts.BackendAddress = "grpc://127.0.0.1:80"
+		serviceInfo, err := NewServiceInfoFromServiceConfig(tc.fakeServiceConfig, testConfigID, opts)
+		if tc.wantError != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.wantError) {
+				t.Errorf("Test Desc(%d): %s, got error: %v, want error containing: %s", i, tc.desc, err, tc.wantError)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Test Desc(%d): %s, got error: %v", i, tc.desc, err)
+		}
+
+		for key, gotMethod := range serviceInfo.Methods {
+			wantMethod := tc.wantMethods[key]
+
+			if eq := cmp.Equal(gotMethod, wantMethod, cmp.Comparer(proto.Equal)); !eq {
+				t.Errorf("Test Desc(%d): %s,\ngot Method: %v,\nwant Method: %v", i, tc.desc, gotMethod, wantMethod)
+			}
+		}
+	}
+}
+
+func TestProcessLocalJwks(t *testing.T) {
+	jwksFile, err := ioutil.TempFile("", "jwks")
+	if err != nil {
+		t.Fatalf("fail to create temp file: %v", err)
+	}
+	defer os.Remove(jwksFile.Name())
+	if _, err := jwksFile.WriteString(`{"keys": []}`); err != nil {
+		t.Fatalf("fail to write temp file: %v", err)
+	}
+	jwksFile.Close()
+
+	testData := []struct {
+		desc                string
+		jwksUri             string
+		localJwks           string
+		wantedLocalJwks     map[string]string
+		wantedLocalJwksFile map[string]string
+		wantedErrorPrefix   string
+	}{
+		{
+			desc:                "Local JWKS file in jwks_uri",
+			jwksUri:             "file://" + jwksFile.Name(),
+			wantedLocalJwks:     map[string]string{"auth_provider": `{"keys": []}`},
+			wantedLocalJwksFile: map[string]string{"auth_provider": jwksFile.Name()},
+		},
+		{
+			desc:                "Local JWKS file in --local_jwks",
+			jwksUri:             "https://fake-jwks.com",
+			localJwks:           "auth_provider=" + jwksFile.Name(),
+			wantedLocalJwks:     map[string]string{"auth_provider": `{"keys": []}`},
+			wantedLocalJwksFile: map[string]string{"auth_provider": jwksFile.Name()},
+		},
+		{
+			desc:                "Inline JWKS in jwks_uri",
+			jwksUri:             `{"keys": [{"kty": "RSA"}]}`,
+			wantedLocalJwks:     map[string]string{"auth_provider": `{"keys": [{"kty": "RSA"}]}`},
+			wantedLocalJwksFile: map[string]string{},
+		},
+		{
+			desc:                "Remote JWKS",
+			jwksUri:             "https://fake-jwks.com",
+			wantedLocalJwks:     map[string]string{},
+			wantedLocalJwksFile: map[string]string{},
+		},
+		{
+			desc:              "Fail with a missing local JWKS file",
+			jwksUri:           "file:///missing/jwks.json",
+			wantedErrorPrefix: "fail to read the local JWKS of JWT provider auth_provider",
+		},
+		{
+			desc:              "Fail with an invalid inline JWKS",
+			jwksUri:           `{"keys": [`,
+			wantedErrorPrefix: "fail to parse the inline JWKS of JWT provider auth_provider",
+		},
+		{
+			desc:              "Fail with an unknown provider in --local_jwks",
+			jwksUri:           "https://fake-jwks.com",
+			localJwks:         "unknown_provider=" + jwksFile.Name(),
+			wantedErrorPrefix: "fail to parse --local_jwks: JWT provider unknown_provider not found",
+		},
+		{
+			desc:              "Fail with a malformed --local_jwks",
+			jwksUri:           "https://fake-jwks.com",
+			localJwks:         "auth_provider",
+			wantedErrorPrefix: "fail to parse --local_jwks",
+		},
+	}
+
+	for i, tc := range testData {
+		fakeServiceConfig := &confpb.Service{
+			Apis: []*apipb.Api{
+				{
+					Name: testApiName,
+				},
+			},
+			Authentication: &confpb.Authentication{
+				Providers: []*confpb.AuthProvider{
+					{
+						Id:      "auth_provider",
+						Issuer:  "issuer-0",
+						JwksUri: tc.jwksUri,
+					},
+				},
+			},
+		}
+		opts := options.DefaultConfigGeneratorOptions()
+		opts.LocalJwks = tc.localJwks
+		serviceInfo, err := NewServiceInfoFromServiceConfig(fakeServiceConfig, testConfigID, opts)
+		if tc.wantedErrorPrefix != "" {
+			if err == nil || !strings.HasPrefix(err.Error(), tc.wantedErrorPrefix) {
+				t.Errorf("Test Desc(%d): %s, got error: %v, want error prefix: %s", i, tc.desc, err, tc.wantedErrorPrefix)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Test Desc(%d): %s, error not expected, got: %v", i, tc.desc, err)
+		}
+
+		if !reflect.DeepEqual(serviceInfo.LocalJwks, tc.wantedLocalJwks) {
+			t.Errorf("Test Desc(%d): %s, LocalJwks got: %v, want: %v", i, tc.desc, serviceInfo.LocalJwks, tc.wantedLocalJwks)
+		}
+		if !reflect.DeepEqual(serviceInfo.LocalJwksFiles, tc.wantedLocalJwksFile) {
+			t.Errorf("Test Desc(%d): %s, LocalJwksFiles got: %v, want: %v", i, tc.desc, serviceInfo.LocalJwksFiles, tc.wantedLocalJwksFile)
+		}
+	}
+}
+
+func TestProcessServiceControlTransport(t *testing.T) {
+	testData := []struct {
+		desc                string
+		protocol            string
+		checkPath           string
+		reportPath          string
+		auth                string
+		authHeaderName      string
+		authHeaderValuePath string
+		wantedTransport     *scpb.ServiceControlTransport
+		wantedErrorPrefix   string
+	}{
+		{
+			desc:     "Default transport",
+			protocol: "http",
+			auth:     "access_token",
+		},
+		{
+			desc:     "gRPC transport without auth",
+			protocol: "grpc",
+			auth:     "none",
+			wantedTransport: &scpb.ServiceControlTransport{
+				Protocol: scpb.ServiceControlTransport_GRPC,
+				Auth:     scpb.ServiceControlTransport_NONE,
+			},
+		},
+		{
+			desc:       "HTTP paths are completed with the default ones",
+			protocol:   "http",
+			checkPath:  "/metering/{service}/check",
+			reportPath: "/metering/{service}/report",
+			auth:       "access_token",
+			wantedTransport: &scpb.ServiceControlTransport{
+				CheckPath:  "/metering/{service}/check",
+				QuotaPath:  "/v1/services/{service}:allocateQuota",
+				ReportPath: "/metering/{service}/report",
+			},
+		},
+		{
+			desc:                "Header auth",
+			protocol:            "http",
+			auth:                "header",
+			authHeaderName:      "x-api-key",
+			authHeaderValuePath: "/etc/metering/api_key",
+			wantedTransport: &scpb.ServiceControlTransport{
+				Auth:           scpb.ServiceControlTransport_HEADER,
+				AuthHeaderName: "x-api-key",
+				AuthHeaderValue: &commonpb.DataSource{
+					Specifier: &commonpb.DataSource_Filename{
+						Filename: "/etc/metering/api_key",
+					},
+				},
+			},
+		},
+		{
+			desc:              "Fail with an unknown protocol",
+			protocol:          "thrift",
+			auth:              "access_token",
+			wantedErrorPrefix: `invalid service control protocol "thrift"`,
+		},
+		{
+			desc:              "Fail with an unknown auth",
+			protocol:          "http",
+			auth:              "basic",
+			wantedErrorPrefix: `invalid service control auth "basic"`,
+		},
+		{
+			desc:              "Fail with a header auth without header name",
+			protocol:          "http",
+			auth:              "header",
+			wantedErrorPrefix: "the service control auth header requires a header name",
+		},
+		{
+			desc:              "Fail with a header auth without the file of its value",
+			protocol:          "http",
+			auth:              "header",
+			authHeaderName:    "x-api-key",
+			wantedErrorPrefix: "the service control auth header requires the file of its value",
+		},
+	}
+
+	for i, tc := range testData {
+		fakeServiceConfig := &confpb.Service{
+			Apis: []*apipb.Api{
+				{
+					Name: testApiName,
+				},
+			},
+		}
+		opts := options.DefaultConfigGeneratorOptions()
+		opts.ServiceControlProtocol = tc.protocol
+		opts.ServiceControlCheckPath = tc.checkPath
+		opts.ServiceControlReportPath = tc.reportPath
+		opts.ServiceControlAuth = tc.auth
+		opts.ServiceControlAuthHeaderName = tc.authHeaderName
+		opts.ServiceControlAuthHeaderValuePath = tc.authHeaderValuePath
+		serviceInfo, err := NewServiceInfoFromServiceConfig(fakeServiceConfig, testConfigID, opts)
+		if tc.wantedErrorPrefix != "" {
+			if err == nil || !strings.HasPrefix(err.Error(), tc.wantedErrorPrefix) {
+				t.Errorf("Test Desc(%d): %s, got error: %v, want error prefix: %s", i, tc.desc, err, tc.wantedErrorPrefix)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Test Desc(%d): %s, error not expected, got: %v", i, tc.desc, err)
+		}
+
+		if !proto.Equal(serviceInfo.ServiceControlTransport, tc.wantedTransport) {
+			t.Errorf("Test Desc(%d): %s, ServiceControlTransport got: %v, want: %v", i, tc.desc, serviceInfo.ServiceControlTransport, tc.wantedTransport)
+		}
+	}
+}
+
+func TestProcessTranscodingDescriptor(t *testing.T) {
+	writeDescriptor := func(descriptorSet *descpb.FileDescriptorSet) string {
+		content, err := proto.Marshal(descriptorSet)
+		if err != nil {
+			t.Fatalf("fail to marshal descriptor set: %v", err)
+		}
+		f, err := ioutil.TempFile("", "descriptor")
+		if err != nil {
+			t.Fatalf("fail to create temp file: %v", err)
+		}
+		if _, err := f.Write(content); err != nil {
+			t.Fatalf("fail to write temp file: %v", err)
+		}
+		f.Close()
+		return f.Name()
+	}
+	validDescriptor := writeDescriptor(&descpb.FileDescriptorSet{
+		File: []*descpb.FileDescriptorProto{
+			{
+				Name:    proto.String("bookstore.proto"),
+				Package: proto.String("endpoints.examples.bookstore"),
+				Service: []*descpb.ServiceDescriptorProto{
+					{
+						Name: proto.String("Bookstore"),
+					},
+				},
+			},
+		},
+	})
+	defer os.Remove(validDescriptor)
+	otherDescriptor := writeDescriptor(&descpb.FileDescriptorSet{
+		File: []*descpb.FileDescriptorProto{
+			{
+				Name:    proto.String("shelves.proto"),
+				Package: proto.String("endpoints.examples.bookstore"),
+				Service: []*descpb.ServiceDescriptorProto{
+					{
+						Name: proto.String("Shelves"),
+					},
+				},
+			},
+		},
+	})
+	defer os.Remove(otherDescriptor)
+	invalidFile, err := ioutil.TempFile("", "descriptor")
+	if err != nil {
+		t.Fatalf("fail to create temp file: %v", err)
+	}
+	defer os.Remove(invalidFile.Name())
+	if _, err := invalidFile.WriteString("rawDescriptor"); err != nil {
+		t.Fatalf("fail to write temp file: %v", err)
+	}
+	invalidFile.Close()
+
+	validContent, err := ioutil.ReadFile(validDescriptor)
+	if err != nil {
+		t.Fatalf("fail to read temp file: %v", err)
+	}
+	sourceFile, err := ptypes.MarshalAny(&smpb.ConfigFile{
+		FilePath:     "api_descriptor.pb",
+		FileContents: []byte("serviceConfigDescriptor"),
+		FileType:     smpb.ConfigFile_FILE_DESCRIPTOR_SET_PROTO,
+	})
+	if err != nil {
+		t.Fatalf("fail to marshal config file: %v", err)
+	}
+
+	testData := []struct {
+		desc              string
+		sourceInfo        *confpb.SourceInfo
+		descriptorPath    string
+		wantedDescriptor  []byte
+		wantedErrorPrefix string
+	}{
+		{
+			desc: "Descriptor from the service config",
+			sourceInfo: &confpb.SourceInfo{
+				SourceFiles: []*anypb.Any{sourceFile},
+			},
+			wantedDescriptor: []byte("serviceConfigDescriptor"),
+		},
+		{
+			desc: "No descriptor",
+		},
+		{
+			desc: "Descriptor from --transcoding_descriptor_path overrides the service config",
+			sourceInfo: &confpb.SourceInfo{
+				SourceFiles: []*anypb.Any{sourceFile},
+			},
+			descriptorPath:   validDescriptor,
+			wantedDescriptor: validContent,
+		},
+		{
+			desc:              "Fail with a missing descriptor file",
+			descriptorPath:    "/missing/descriptor.pb",
+			wantedErrorPrefix: "fail to read the transcoding descriptor",
+		},
+		{
+			desc:              "Fail with an invalid descriptor set",
+			descriptorPath:    invalidFile.Name(),
+			wantedErrorPrefix: "invalid transcoding descriptor " + invalidFile.Name() + ": fail to unmarshal the descriptor set",
+		},
+		{
+			desc:              "Fail with a descriptor set missing an API",
+			descriptorPath:    otherDescriptor,
+			wantedErrorPrefix: "invalid transcoding descriptor " + otherDescriptor + ": service endpoints.examples.bookstore.Bookstore is not defined",
+		},
+	}
+
+	for i, tc := range testData {
+		fakeServiceConfig := &confpb.Service{
+			Apis: []*apipb.Api{
+				{
+					Name: testApiName,
+				},
+			},
+			SourceInfo: tc.sourceInfo,
+		}
+		opts := options.DefaultConfigGeneratorOptions()
+		opts.BackendAddress = "grpc://127.0.0.1:80"
+		opts.TranscodingDescriptorPath = tc.descriptorPath
+		serviceInfo, err := NewServiceInfoFromServiceConfig(fakeServiceConfig, testConfigID, opts)
+		if tc.wantedErrorPrefix != "" {
+			if err == nil || !strings.HasPrefix(err.Error(), tc.wantedErrorPrefix) {
+				t.Errorf("Test Desc(%d): %s, got error: %v, want error prefix: %s", i, tc.desc, err, tc.wantedErrorPrefix)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Test Desc(%d): %s, error not expected, got: %v", i, tc.desc, err)
+		}
+
+		if !reflect.DeepEqual(serviceInfo.TranscodingDescriptor, tc.wantedDescriptor) {
+			t.Errorf("Test Desc(%d): %s, TranscodingDescriptor got: %q, want: %q", i, tc.desc, serviceInfo.TranscodingDescriptor, tc.wantedDescriptor)
+		}
+	}
+}
+
+func TestProcessRemoteJwks(t *testing.T) {
+	cacheDuration := 60
+	asyncFetch := true
+	fetchRetries := 1
+	testData := []struct {
+		desc             string
+		asyncFetch       bool
+		jwksProviders    []*options.JwksProviderOptions
+		fetchError       error
+		wantedRemoteJwks *RemoteJwksInfo
+		wantedLocalJwks  map[string]string
+	}{
+		{
+			desc: "Remote JWKS fetched by Envoy with the flags",
+			wantedRemoteJwks: &RemoteJwksInfo{
+				Uri:           "https://fake-jwks.com",
+				CacheDuration: 5 * time.Minute,
+				FetchRetries:  3,
+			},
+			wantedLocalJwks: map[string]string{},
+		},
+		{
+			desc: "Remote JWKS fetched in advance with the overrides of the provider",
+			jwksProviders: []*options.JwksProviderOptions{
+				{
+					ProviderId:       "auth_provider",
+					CacheDurationInS: &cacheDuration,
+					AsyncFetch:       &asyncFetch,
+					FetchRetries:     &fetchRetries,
+				},
+			},
+			wantedRemoteJwks: &RemoteJwksInfo{
+				Uri:           "https://fake-jwks.com",
+				CacheDuration: time.Minute,
+				AsyncFetch:    true,
+				FetchRetries:  1,
+			},
+			wantedLocalJwks: map[string]string{"auth_provider": `{"keys": []}`},
+		},
+		{
+			desc:       "Remote JWKS fetched by Envoy when the fetch in advance fails",
+			asyncFetch: true,
+			fetchError: fmt.Errorf("connection refused"),
+			wantedRemoteJwks: &RemoteJwksInfo{
+				Uri:           "https://fake-jwks.com",
+				CacheDuration: 5 * time.Minute,
+				AsyncFetch:    true,
+				FetchRetries:  3,
+			},
+			wantedLocalJwks: map[string]string{},
+		},
+	}
+
+	for i, tc := range testData {
+		fetchJwks = func(jwksUri string, retries int) ([]byte, error) {
+			if tc.fetchError != nil {
+				return nil, tc.fetchError
+			}
+			return []byte(`{"keys": []}`), nil
+		}
+		fakeServiceConfig := &confpb.Service{
+			Apis: []*apipb.Api{
+				{
+					Name: testApiName,
+				},
+			},
+			Authentication: &confpb.Authentication{
+				Providers: []*confpb.AuthProvider{
+					{
+						Id:      "auth_provider",
+						Issuer:  "issuer-0",
+						JwksUri: "https://fake-jwks.com",
+					},
+				},
+			},
+		}
+		opts := options.DefaultConfigGeneratorOptions()
+		opts.JwksAsyncFetch = tc.asyncFetch
+		opts.JwksProviders = tc.jwksProviders
+		serviceInfo, err := NewServiceInfoFromServiceConfig(fakeServiceConfig, testConfigID, opts)
+		if err != nil {
+			t.Fatalf("Test Desc(%d): %s, error not expected, got: %v", i, tc.desc, err)
+		}
+
+		if !reflect.DeepEqual(serviceInfo.RemoteJwks["auth_provider"], tc.wantedRemoteJwks) {
+			t.Errorf("Test Desc(%d): %s, RemoteJwks got: %v, want: %v", i, tc.desc, serviceInfo.RemoteJwks["auth_provider"], tc.wantedRemoteJwks)
+		}
+		if !reflect.DeepEqual(serviceInfo.LocalJwks, tc.wantedLocalJwks) {
+			t.Errorf("Test Desc(%d): %s, LocalJwks got: %v, want: %v", i, tc.desc, serviceInfo.LocalJwks, tc.wantedLocalJwks)
+		}
+	}
+	fetchJwks = util.FetchJwks
+}
+
+func TestProcessEmptyJwksUriByOpenID(t *testing.T) {
+	r := mux.NewRouter()
+	jwksUriEntry, _ := json.Marshal(map[string]string{"jwks_uri": "this-is-jwksUri"})
+	r.Path(util.OpenIDDiscoveryCfgURLSuffix).Methods("GET").Handler(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write(jwksUriEntry)
 		}))
 	openIDServer := httptest.NewServer(r)
 
@@ -1824,3 +4389,96 @@ func TestProcessApisForGrpc(t *testing.T) {
 		}
 	}
 }
+
+func TestProcessPreflightAuth(t *testing.T) {
+	testData := []struct {
+		desc                            string
+		corsPreflightRequireAuth        bool
+		corsPreflightSkipServiceControl bool
+		wantMethod                      *methodInfo
+	}{
+		{
+			desc: "Generated OPTIONS method exempt from authentication",
+			wantMethod: &methodInfo{
+				ShortName: "CORS_echo",
+				ApiName:   testApiName,
+				HttpRule: []*commonpb.Pattern{
+					{
+						UriTemplate: "/echo",
+						HttpMethod:  util.OPTIONS,
+					},
+				},
+				IsGenerated: true,
+			},
+		},
+		{
+			desc:                            "Generated OPTIONS method requiring the authentication of its operation",
+			corsPreflightRequireAuth:        true,
+			corsPreflightSkipServiceControl: true,
+			wantMethod: &methodInfo{
+				ShortName: "CORS_echo",
+				ApiName:   testApiName,
+				HttpRule: []*commonpb.Pattern{
+					{
+						UriTemplate: "/echo",
+						HttpMethod:  util.OPTIONS,
+					},
+				},
+				AllowUnregisteredCalls: true,
+				IsGenerated:            true,
+				SkipServiceControl:     true,
+				PreflightOperation:     testApiName + ".Echo",
+			},
+		},
+	}
+
+	for _, tc := range testData {
+		opts := options.DefaultConfigGeneratorOptions()
+		opts.CorsPreset = "basic"
+		opts.CorsPreflightOperations = true
+		opts.CorsPreflightRequireAuth = tc.corsPreflightRequireAuth
+		opts.CorsPreflightSkipServiceControl = tc.corsPreflightSkipServiceControl
+		serviceInfo, err := NewServiceInfoFromServiceConfig(&confpb.Service{
+			Name: testProjectName,
+			Apis: []*apipb.Api{
+				{
+					Name: testApiName,
+					Methods: []*apipb.Method{
+						{
+							Name: "Echo",
+						},
+					},
+				},
+			},
+			Http: &annotationspb.Http{
+				Rules: []*annotationspb.HttpRule{
+					{
+						Selector: testApiName + ".Echo",
+						Pattern: &annotationspb.HttpRule_Post{
+							Post: "/echo",
+						},
+					},
+				},
+			},
+			Usage: &confpb.Usage{
+				Rules: []*confpb.UsageRule{
+					{
+						Selector:               testApiName + ".Echo",
+						AllowUnregisteredCalls: true,
+					},
+				},
+			},
+		}, testConfigID, opts)
+		if err != nil {
+			t.Fatalf("Test Desc(%s): %v", tc.desc, err)
+		}
+
+		gotMethod := serviceInfo.Methods[testApiName+".CORS_echo"]
+		if gotMethod == nil {
+			t.Fatalf("Test Desc(%s): generated OPTIONS method not found", tc.desc)
+		}
+		if !reflect.DeepEqual(gotMethod, tc.wantMethod) {
+			t.Errorf("Test Desc(%s): got method %+v, want %+v", tc.desc, gotMethod, tc.wantMethod)
+		}
+	}
+}
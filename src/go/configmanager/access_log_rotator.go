@@ -0,0 +1,91 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package configmanager
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/logging"
+)
+
+// WatchAccessLog starts checking the size of the access log file in
+// --access_log_path every --access_log_check_interval, and rotating it once
+// it is larger than --access_log_max_bytes.
+func (m *ConfigManager) WatchAccessLog() {
+	opts := m.envoyConfigOptions
+	if opts.AccessLogPath == "" || opts.AccessLogMaxBytes == 0 || *accessLogCheckInterval == 0 {
+		return
+	}
+
+	logging.Infof("start checking the access log file %s every %v", opts.AccessLogPath, *accessLogCheckInterval)
+	go func() {
+		for range time.Tick(*accessLogCheckInterval) {
+			if err := rotateAccessLog(opts.AccessLogPath, opts.AccessLogMaxBytes, opts.AccessLogMaxFiles); err != nil {
+				logging.Errorf("error occurred when rotating the access log file, %v", err)
+			}
+		}
+	}()
+}
+
+// rotateAccessLog rotates the access log file at path if it is larger than
+// maxBytes, keeping maxFiles rotated files from path.1, the newest, to
+// path.<maxFiles>. Envoy keeps the file open in append mode, so it is copied
+// to path.1 and truncated instead of being renamed. The logs written between
+// the copy and the truncation are lost.
+func rotateAccessLog(path string, maxBytes int64, maxFiles int) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("fail to stat the access log file: %v", err)
+	}
+	// Only regular files are rotated, not /dev/stdout.
+	if !info.Mode().IsRegular() || info.Size() <= maxBytes {
+		return nil
+	}
+
+	for i := maxFiles - 1; i > 0; i-- {
+		older := fmt.Sprintf("%s.%d", path, i)
+		if _, err := os.Stat(older); os.IsNotExist(err) {
+			continue
+		}
+		if err := os.Rename(older, fmt.Sprintf("%s.%d", path, i+1)); err != nil {
+			return fmt.Errorf("fail to rotate the access log file: %v", err)
+		}
+	}
+
+	src, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("fail to open the access log file: %v", err)
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(path+".1", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return fmt.Errorf("fail to create the rotated access log file: %v", err)
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return fmt.Errorf("fail to copy the access log file: %v", err)
+	}
+	if err := dst.Close(); err != nil {
+		return fmt.Errorf("fail to copy the access log file: %v", err)
+	}
+	if err := os.Truncate(path, 0); err != nil {
+		return fmt.Errorf("fail to truncate the access log file: %v", err)
+	}
+	logging.Infof("rotated the access log file %s of %d bytes", path, info.Size())
+	return nil
+}
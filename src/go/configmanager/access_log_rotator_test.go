@@ -0,0 +1,107 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package configmanager
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRotateAccessLog(t *testing.T) {
+	testData := []struct {
+		desc      string
+		logs      string
+		oldFiles  map[string]string
+		maxBytes  int64
+		maxFiles  int
+		wantFiles map[string]string
+	}{
+		{
+			desc:     "Not rotated when smaller than the max size",
+			logs:     "log1\n",
+			maxBytes: 10,
+			maxFiles: 2,
+			wantFiles: map[string]string{
+				"access.log": "log1\n",
+			},
+		},
+		{
+			desc:     "Rotated to the first file",
+			logs:     "log1\nlog2\n",
+			maxBytes: 5,
+			maxFiles: 2,
+			wantFiles: map[string]string{
+				"access.log":   "",
+				"access.log.1": "log1\nlog2\n",
+			},
+		},
+		{
+			desc: "Rotated files shifted, the oldest one dropped",
+			logs: "log3\nlog4\n",
+			oldFiles: map[string]string{
+				"access.log.1": "log2\n",
+				"access.log.2": "log1\n",
+			},
+			maxBytes: 5,
+			maxFiles: 2,
+			wantFiles: map[string]string{
+				"access.log":   "",
+				"access.log.1": "log3\nlog4\n",
+				"access.log.2": "log2\n",
+			},
+		},
+	}
+
+	for _, tc := range testData {
+		dir, err := ioutil.TempDir("", "access_log")
+		if err != nil {
+			t.Fatalf("fail to create temp dir: %v", err)
+		}
+		defer os.RemoveAll(dir)
+		files := map[string]string{"access.log": tc.logs}
+		for name, content := range tc.oldFiles {
+			files[name] = content
+		}
+		for name, content := range files {
+			if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+				t.Fatalf("fail to write %s: %v", name, err)
+			}
+		}
+
+		if err := rotateAccessLog(filepath.Join(dir, "access.log"), tc.maxBytes, tc.maxFiles); err != nil {
+			t.Fatalf("Test Desc(%s): rotateAccessLog failed: %v", tc.desc, err)
+		}
+
+		gotFiles, err := ioutil.ReadDir(dir)
+		if err != nil {
+			t.Fatalf("fail to read temp dir: %v", err)
+		}
+		if len(gotFiles) != len(tc.wantFiles) {
+			t.Errorf("Test Desc(%s): got %d files, want %d", tc.desc, len(gotFiles), len(tc.wantFiles))
+		}
+		for name, want := range tc.wantFiles {
+			got, err := ioutil.ReadFile(filepath.Join(dir, name))
+			if err != nil {
+				t.Errorf("Test Desc(%s): fail to read %s: %v", tc.desc, name, err)
+				continue
+			}
+			if string(got) != want {
+				t.Errorf("Test Desc(%s): got %s %q, want %q", tc.desc, name, got, want)
+			}
+		}
+	}
+}
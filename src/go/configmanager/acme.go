@@ -0,0 +1,522 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package configmanager
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/logging"
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/options"
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/util"
+)
+
+const (
+	// The ACME account key is kept next to the server certificate, so the
+	// account is reused after restarts.
+	acmeAccountKeyFilename = "acme_account.key"
+	acmeBadNonceError      = "urn:ietf:params:acme:error:badNonce"
+)
+
+var (
+	// The certificate is renewed when it expires within acmeRenewBefore,
+	// which is checked every acmeCheckInterval, or acmeRetryInterval after
+	// a failure.
+	acmeRenewBefore   = 30 * 24 * time.Hour
+	acmeCheckInterval = 12 * time.Hour
+	acmeRetryInterval = time.Hour
+	// The orders and authorizations are polled every acmePollInterval, up to
+	// acmePollAttempts times.
+	acmePollInterval = 2 * time.Second
+	acmePollAttempts = 60
+)
+
+// acmeManager issues and renews the server certificate with an ACME server,
+// like Let's Encrypt, answering the HTTP-01 challenges. The certificate and
+// key are written to --ssl_server_cert_path, and sent to Envoy over SDS.
+type acmeManager struct {
+	client     *acmeClient
+	domains    []string
+	certPath   string
+	keyPath    string
+	challenges *acmeChallengeHandler
+}
+
+func newAcmeManager(opts options.ConfigGeneratorOptions) (*acmeManager, error) {
+	var domains []string
+	for _, domain := range strings.Split(opts.SslServerAcmeDomains, ",") {
+		if domain = strings.TrimSpace(domain); domain != "" {
+			domains = append(domains, domain)
+		}
+	}
+	if len(domains) == 0 {
+		return nil, fmt.Errorf("--ssl_server_acme_domains is empty")
+	}
+
+	accountKey, err := loadOrCreateAcmeAccountKey(filepath.Join(opts.SslServerCertPath, acmeAccountKeyFilename))
+	if err != nil {
+		return nil, err
+	}
+	certPath, keyPath := util.ServerSslFiles(opts.SslServerCertPath)
+	return &acmeManager{
+		client: &acmeClient{
+			directoryUrl: opts.SslServerAcmeDirectoryUrl,
+			email:        opts.SslServerAcmeEmail,
+			accountKey:   accountKey,
+			httpClient:   &http.Client{Transport: &http.Transport{Proxy: util.Proxy}, Timeout: 30 * time.Second},
+		},
+		domains:    domains,
+		certPath:   certPath,
+		keyPath:    keyPath,
+		challenges: &acmeChallengeHandler{keyAuthorizations: make(map[string]string)},
+	}, nil
+}
+
+// AcmeChallengeHandler returns the handler serving the ACME HTTP-01
+// challenges to Envoy, nil without --ssl_server_acme_directory_url.
+func (m *ConfigManager) AcmeChallengeHandler() http.Handler {
+	if m.acme == nil {
+		return nil
+	}
+	return m.acme.challenges
+}
+
+// renewLoop renews the certificate when it is missing, about to expire or
+// not valid for all the domains, and calls onRenew once it is written.
+func (a *acmeManager) renewLoop(onRenew func() error) {
+	for {
+		interval := acmeCheckInterval
+		if a.needsRenewal() {
+			if err := a.renew(); err != nil {
+				logging.Errorf("fail to obtain the server certificate from the ACME server: %v", err)
+				interval = acmeRetryInterval
+			} else if err := onRenew(); err != nil {
+				logging.Errorf("fail to apply the server certificate obtained from the ACME server: %v", err)
+			}
+		}
+		time.Sleep(interval)
+	}
+}
+
+func (a *acmeManager) needsRenewal() bool {
+	data, err := ioutil.ReadFile(a.certPath)
+	if err != nil {
+		return true
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return true
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return true
+	}
+	if time.Now().Add(acmeRenewBefore).After(cert.NotAfter) {
+		return true
+	}
+	for _, domain := range a.domains {
+		if cert.VerifyHostname(domain) != nil {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *acmeManager) renew() error {
+	logging.Infof("obtaining the server certificate for %v from the ACME server", a.domains)
+	cert, key, err := a.client.obtainCertificate(a.domains, a.challenges)
+	if err != nil {
+		return err
+	}
+	// The key is written first, the certificate watcher waits for both files
+	// to match.
+	if err := writeFileAtomically(a.keyPath, key, 0600); err != nil {
+		return err
+	}
+	if err := writeFileAtomically(a.certPath, cert, 0644); err != nil {
+		return err
+	}
+	logging.Infof("obtained the server certificate for %v from the ACME server", a.domains)
+	return nil
+}
+
+func writeFileAtomically(path string, data []byte, perm os.FileMode) error {
+	tmpPath := path + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, data, perm); err != nil {
+		return fmt.Errorf("fail to write %s: %v", path, err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("fail to write %s: %v", path, err)
+	}
+	return nil
+}
+
+func loadOrCreateAcmeAccountKey(path string) (*ecdsa.PrivateKey, error) {
+	if data, err := ioutil.ReadFile(path); err == nil {
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return nil, fmt.Errorf("fail to decode the ACME account key %s", path)
+		}
+		return x509.ParseECPrivateKey(block.Bytes)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("fail to generate the ACME account key: %v", err)
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("fail to marshal the ACME account key: %v", err)
+	}
+	if err := writeFileAtomically(path, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		// The account is created again after restarts.
+		logging.Warningf("fail to save the ACME account key: %v", err)
+	}
+	return key, nil
+}
+
+// acmeChallengeHandler serves the key authorizations of the pending HTTP-01
+// challenges by their tokens.
+type acmeChallengeHandler struct {
+	mu                sync.Mutex
+	keyAuthorizations map[string]string
+}
+
+func (h *acmeChallengeHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	token := strings.TrimPrefix(r.URL.Path, util.AcmeChallengePathPrefix)
+	h.mu.Lock()
+	keyAuthorization, ok := h.keyAuthorizations[token]
+	h.mu.Unlock()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write([]byte(keyAuthorization))
+}
+
+func (h *acmeChallengeHandler) set(token, keyAuthorization string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.keyAuthorizations[token] = keyAuthorization
+}
+
+func (h *acmeChallengeHandler) remove(token string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.keyAuthorizations, token)
+}
+
+// acmeClient is a minimal client of the ACME protocol, RFC 8555, with an
+// ECDSA P-256 account key.
+type acmeClient struct {
+	directoryUrl string
+	email        string
+	accountKey   *ecdsa.PrivateKey
+	httpClient   *http.Client
+
+	directory *acmeDirectory
+	// Account url, set once registered.
+	kid   string
+	nonce string
+}
+
+type acmeDirectory struct {
+	NewNonce   string `json:"newNonce"`
+	NewAccount string `json:"newAccount"`
+	NewOrder   string `json:"newOrder"`
+}
+
+type acmeIdentifier struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+type acmeOrder struct {
+	Status         string   `json:"status"`
+	Authorizations []string `json:"authorizations"`
+	Finalize       string   `json:"finalize"`
+	Certificate    string   `json:"certificate"`
+}
+
+type acmeAuthorization struct {
+	Status     string          `json:"status"`
+	Challenges []acmeChallenge `json:"challenges"`
+}
+
+type acmeChallenge struct {
+	Type  string `json:"type"`
+	Url   string `json:"url"`
+	Token string `json:"token"`
+}
+
+// obtainCertificate orders a certificate for the domains, and returns the
+// certificate chain and its key in PEM.
+func (c *acmeClient) obtainCertificate(domains []string, challenges *acmeChallengeHandler) ([]byte, []byte, error) {
+	if err := c.register(); err != nil {
+		return nil, nil, err
+	}
+
+	var identifiers []acmeIdentifier
+	for _, domain := range domains {
+		identifiers = append(identifiers, acmeIdentifier{Type: "dns", Value: domain})
+	}
+	order := &acmeOrder{}
+	header, err := c.post(c.directory.NewOrder, map[string]interface{}{"identifiers": identifiers}, order)
+	if err != nil {
+		return nil, nil, fmt.Errorf("fail to create order: %v", err)
+	}
+	orderUrl := header.Get("Location")
+
+	for _, authorizationUrl := range order.Authorizations {
+		if err := c.authorize(authorizationUrl, challenges); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, fmt.Errorf("fail to generate the certificate key: %v", err)
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: domains[0]},
+		DNSNames: domains,
+	}, key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("fail to create the certificate request: %v", err)
+	}
+	if _, err := c.post(order.Finalize, map[string]string{"csr": base64.RawURLEncoding.EncodeToString(csr)}, order); err != nil {
+		return nil, nil, fmt.Errorf("fail to finalize order: %v", err)
+	}
+	for attempt := 0; order.Status != "valid"; attempt++ {
+		if order.Status == "invalid" || attempt == acmePollAttempts {
+			return nil, nil, fmt.Errorf("order %s is %s", orderUrl, order.Status)
+		}
+		time.Sleep(acmePollInterval)
+		if _, err := c.post(orderUrl, nil, order); err != nil {
+			return nil, nil, fmt.Errorf("fail to get order: %v", err)
+		}
+	}
+
+	var cert []byte
+	if _, err := c.post(order.Certificate, nil, &cert); err != nil {
+		return nil, nil, fmt.Errorf("fail to download certificate: %v", err)
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("fail to marshal the certificate key: %v", err)
+	}
+	return cert, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), nil
+}
+
+func (c *acmeClient) register() error {
+	if c.directory == nil {
+		resp, err := c.httpClient.Get(c.directoryUrl)
+		if err != nil {
+			return fmt.Errorf("fail to get ACME directory: %v", err)
+		}
+		defer resp.Body.Close()
+		directory := &acmeDirectory{}
+		if err := json.NewDecoder(resp.Body).Decode(directory); err != nil {
+			return fmt.Errorf("fail to unmarshal ACME directory: %v", err)
+		}
+		c.directory = directory
+	}
+	if c.kid != "" {
+		return nil
+	}
+
+	account := map[string]interface{}{"termsOfServiceAgreed": true}
+	if c.email != "" {
+		account["contact"] = []string{"mailto:" + c.email}
+	}
+	header, err := c.post(c.directory.NewAccount, account, nil)
+	if err != nil {
+		return fmt.Errorf("fail to register ACME account: %v", err)
+	}
+	c.kid = header.Get("Location")
+	return nil
+}
+
+// authorize answers the HTTP-01 challenge of an authorization, and waits for
+// the authorization to be valid.
+func (c *acmeClient) authorize(authorizationUrl string, challenges *acmeChallengeHandler) error {
+	authorization := &acmeAuthorization{}
+	if _, err := c.post(authorizationUrl, nil, authorization); err != nil {
+		return fmt.Errorf("fail to get authorization: %v", err)
+	}
+	if authorization.Status == "valid" {
+		return nil
+	}
+
+	var challenge *acmeChallenge
+	for i := range authorization.Challenges {
+		if authorization.Challenges[i].Type == "http-01" {
+			challenge = &authorization.Challenges[i]
+		}
+	}
+	if challenge == nil {
+		return fmt.Errorf("authorization %s has no http-01 challenge", authorizationUrl)
+	}
+	challenges.set(challenge.Token, challenge.Token+"."+c.thumbprint())
+	defer challenges.remove(challenge.Token)
+	if _, err := c.post(challenge.Url, struct{}{}, nil); err != nil {
+		return fmt.Errorf("fail to answer challenge: %v", err)
+	}
+
+	for attempt := 0; authorization.Status != "valid"; attempt++ {
+		if authorization.Status == "invalid" || attempt == acmePollAttempts {
+			return fmt.Errorf("authorization %s is %s", authorizationUrl, authorization.Status)
+		}
+		time.Sleep(acmePollInterval)
+		if _, err := c.post(authorizationUrl, nil, authorization); err != nil {
+			return fmt.Errorf("fail to get authorization: %v", err)
+		}
+	}
+	return nil
+}
+
+// post sends a JWS signed request, with an empty payload for a nil payload,
+// and unmarshals the response into out, or copies it if out is a *[]byte.
+// A request rejected for its nonce is retried once.
+func (c *acmeClient) post(url string, payload interface{}, out interface{}) (http.Header, error) {
+	for attempt := 0; ; attempt++ {
+		body, err := c.sign(url, payload)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := c.httpClient.Post(url, "application/jose+json", bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		data, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		c.nonce = resp.Header.Get("Replay-Nonce")
+
+		if resp.StatusCode >= 400 {
+			problem := struct {
+				Type string `json:"type"`
+			}{}
+			json.Unmarshal(data, &problem)
+			if problem.Type == acmeBadNonceError && attempt == 0 {
+				continue
+			}
+			return nil, fmt.Errorf("ACME server returned %v: %s", resp.Status, data)
+		}
+		if raw, ok := out.(*[]byte); ok {
+			*raw = data
+		} else if out != nil {
+			if err := json.Unmarshal(data, out); err != nil {
+				return nil, fmt.Errorf("fail to unmarshal ACME response: %v", err)
+			}
+		}
+		return resp.Header, nil
+	}
+}
+
+func (c *acmeClient) sign(url string, payload interface{}) ([]byte, error) {
+	if c.nonce == "" {
+		resp, err := c.httpClient.Head(c.directory.NewNonce)
+		if err != nil {
+			return nil, fmt.Errorf("fail to get ACME nonce: %v", err)
+		}
+		resp.Body.Close()
+		c.nonce = resp.Header.Get("Replay-Nonce")
+	}
+
+	protected := map[string]interface{}{
+		"alg":   "ES256",
+		"nonce": c.nonce,
+		"url":   url,
+	}
+	c.nonce = ""
+	if c.kid != "" {
+		protected["kid"] = c.kid
+	} else {
+		protected["jwk"] = c.jwk()
+	}
+	protectedJson, err := json.Marshal(protected)
+	if err != nil {
+		return nil, err
+	}
+	var payloadJson []byte
+	if payload != nil {
+		if payloadJson, err = json.Marshal(payload); err != nil {
+			return nil, err
+		}
+	}
+
+	encodedProtected := base64.RawURLEncoding.EncodeToString(protectedJson)
+	encodedPayload := base64.RawURLEncoding.EncodeToString(payloadJson)
+	hash := sha256.Sum256([]byte(encodedProtected + "." + encodedPayload))
+	r, s, err := ecdsa.Sign(rand.Reader, c.accountKey, hash[:])
+	if err != nil {
+		return nil, fmt.Errorf("fail to sign ACME request: %v", err)
+	}
+	signature := append(padTo32Bytes(r), padTo32Bytes(s)...)
+	return json.Marshal(map[string]string{
+		"protected": encodedProtected,
+		"payload":   encodedPayload,
+		"signature": base64.RawURLEncoding.EncodeToString(signature),
+	})
+}
+
+// jwk returns the public account key as a JWK, with its members in
+// lexicographic order as required by the thumbprint.
+func (c *acmeClient) jwk() map[string]string {
+	return map[string]string{
+		"crv": "P-256",
+		"kty": "EC",
+		"x":   base64.RawURLEncoding.EncodeToString(padTo32Bytes(c.accountKey.X)),
+		"y":   base64.RawURLEncoding.EncodeToString(padTo32Bytes(c.accountKey.Y)),
+	}
+}
+
+// thumbprint returns the JWK thumbprint of the account key, RFC 7638.
+func (c *acmeClient) thumbprint() string {
+	// json.Marshal sorts the keys of maps.
+	data, _ := json.Marshal(c.jwk())
+	hash := crypto.SHA256.New()
+	hash.Write(data)
+	return base64.RawURLEncoding.EncodeToString(hash.Sum(nil))
+}
+
+func padTo32Bytes(n *big.Int) []byte {
+	b := n.Bytes()
+	padded := make([]byte, 32)
+	copy(padded[32-len(b):], b)
+	return padded
+}
@@ -0,0 +1,248 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package configmanager
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/options"
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/util"
+)
+
+// fakeAcmeServer issues certificates for a single order, verifying the
+// signatures of the requests and the HTTP-01 challenge answer.
+type fakeAcmeServer struct {
+	t          *testing.T
+	server     *httptest.Server
+	challenges http.Handler
+
+	accountKey    *ecdsa.PublicKey
+	thumbprint    string
+	challengeDone bool
+	certificate   []byte
+	badNonce      bool
+}
+
+func newFakeAcmeServer(t *testing.T) *fakeAcmeServer {
+	s := &fakeAcmeServer{t: t, badNonce: true}
+	s.server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+func (s *fakeAcmeServer) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	url := s.server.URL
+	w.Header().Set("Replay-Nonce", fmt.Sprintf("nonce-%d", time.Now().UnixNano()))
+	if r.URL.Path == "/directory" {
+		fmt.Fprintf(w, `{"newNonce":"%s/nonce","newAccount":"%s/account","newOrder":"%s/order"}`, url, url, url)
+		return
+	}
+	if r.URL.Path == "/nonce" {
+		return
+	}
+
+	payload := s.verify(r)
+	// The first request is rejected for its nonce, to check it is retried.
+	if s.badNonce {
+		s.badNonce = false
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, `{"type":"%s"}`, acmeBadNonceError)
+		return
+	}
+
+	switch r.URL.Path {
+	case "/account":
+		w.Header().Set("Location", url+"/account/1")
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprint(w, `{"status":"valid"}`)
+	case "/order":
+		w.Header().Set("Location", url+"/order/1")
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprintf(w, `{"status":"pending","authorizations":["%s/authz/1"],"finalize":"%s/finalize/1"}`, url, url)
+	case "/authz/1":
+		status := "pending"
+		if s.challengeDone {
+			status = "valid"
+		}
+		fmt.Fprintf(w, `{"status":"%s","challenges":[{"type":"dns-01","url":"%s/challenge/2","token":"dns-token"},{"type":"http-01","url":"%s/challenge/1","token":"http-token"}]}`, status, url, url)
+	case "/challenge/1":
+		resp := httptest.NewRecorder()
+		s.challenges.ServeHTTP(resp, httptest.NewRequest("GET", util.AcmeChallengePathPrefix+"http-token", nil))
+		if got, want := resp.Body.String(), "http-token."+s.thumbprint; got != want {
+			s.t.Errorf("got key authorization %s, want %s", got, want)
+		}
+		s.challengeDone = true
+		fmt.Fprint(w, `{"status":"processing"}`)
+	case "/finalize/1":
+		csr := struct {
+			Csr string `json:"csr"`
+		}{}
+		if err := json.Unmarshal(payload, &csr); err != nil {
+			s.t.Fatalf("fail to unmarshal finalize request: %v", err)
+		}
+		s.certificate = s.issue(csr.Csr)
+		fmt.Fprintf(w, `{"status":"processing","finalize":"%s/finalize/1"}`, url)
+	case "/order/1":
+		fmt.Fprintf(w, `{"status":"valid","certificate":"%s/cert/1"}`, url)
+	case "/cert/1":
+		w.Write(s.certificate)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// verify checks the signature of a JWS request, and returns its payload.
+func (s *fakeAcmeServer) verify(r *http.Request) []byte {
+	jws := struct {
+		Protected string `json:"protected"`
+		Payload   string `json:"payload"`
+		Signature string `json:"signature"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&jws); err != nil {
+		s.t.Fatalf("fail to unmarshal JWS: %v", err)
+	}
+	protectedJson, _ := base64.RawURLEncoding.DecodeString(jws.Protected)
+	protected := struct {
+		Jwk map[string]string `json:"jwk"`
+		Kid string            `json:"kid"`
+		Url string            `json:"url"`
+	}{}
+	if err := json.Unmarshal(protectedJson, &protected); err != nil {
+		s.t.Fatalf("fail to unmarshal JWS header: %v", err)
+	}
+	if protected.Url != s.server.URL+r.URL.Path {
+		s.t.Errorf("got JWS url %s, want %s", protected.Url, s.server.URL+r.URL.Path)
+	}
+	if r.URL.Path == "/account" {
+		x, _ := base64.RawURLEncoding.DecodeString(protected.Jwk["x"])
+		y, _ := base64.RawURLEncoding.DecodeString(protected.Jwk["y"])
+		s.accountKey = &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		thumbprintJson := fmt.Sprintf(`{"crv":"P-256","kty":"EC","x":"%s","y":"%s"}`, protected.Jwk["x"], protected.Jwk["y"])
+		hash := sha256.Sum256([]byte(thumbprintJson))
+		s.thumbprint = base64.RawURLEncoding.EncodeToString(hash[:])
+	} else if protected.Kid != s.server.URL+"/account/1" {
+		s.t.Errorf("got JWS kid %s on %s, want the account url", protected.Kid, r.URL.Path)
+	}
+
+	signature, _ := base64.RawURLEncoding.DecodeString(jws.Signature)
+	hash := sha256.Sum256([]byte(jws.Protected + "." + jws.Payload))
+	if len(signature) != 64 || !ecdsa.Verify(s.accountKey, hash[:], new(big.Int).SetBytes(signature[:32]), new(big.Int).SetBytes(signature[32:])) {
+		s.t.Errorf("invalid JWS signature on %s", r.URL.Path)
+	}
+	payload, _ := base64.RawURLEncoding.DecodeString(jws.Payload)
+	return payload
+}
+
+func (s *fakeAcmeServer) issue(encodedCsr string) []byte {
+	der, _ := base64.RawURLEncoding.DecodeString(encodedCsr)
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		s.t.Fatalf("fail to parse certificate request: %v", err)
+	}
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		s.t.Fatalf("fail to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      csr.Subject,
+		DNSNames:     csr.DNSNames,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(90 * 24 * time.Hour),
+	}
+	cert, err := x509.CreateCertificate(rand.Reader, template, template, csr.PublicKey, caKey)
+	if err != nil {
+		s.t.Fatalf("fail to create certificate: %v", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert})
+}
+
+func TestAcmeRenew(t *testing.T) {
+	dir, err := ioutil.TempDir("", "acme")
+	if err != nil {
+		t.Fatalf("fail to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(interval time.Duration) { acmePollInterval = interval }(acmePollInterval)
+	acmePollInterval = time.Millisecond
+
+	server := newFakeAcmeServer(t)
+	defer server.server.Close()
+
+	opts := options.DefaultConfigGeneratorOptions()
+	opts.SslServerCertPath = dir
+	opts.SslServerAcmeDirectoryUrl = server.server.URL + "/directory"
+	opts.SslServerAcmeDomains = "example.com, www.example.com"
+	opts.SslServerAcmeEmail = "admin@example.com"
+	acme, err := newAcmeManager(opts)
+	if err != nil {
+		t.Fatalf("fail to create ACME manager: %v", err)
+	}
+	server.challenges = acme.challenges
+
+	if !acme.needsRenewal() {
+		t.Errorf("missing certificate does not need renewal")
+	}
+	if err := acme.renew(); err != nil {
+		t.Fatalf("fail to renew certificate: %v", err)
+	}
+	if acme.needsRenewal() {
+		t.Errorf("renewed certificate still needs renewal")
+	}
+
+	// The renewed certificate is loaded by the certificate watcher.
+	m := &ConfigManager{envoyConfigOptions: opts}
+	if changed, err := m.loadServerCert(); err != nil || !changed {
+		t.Errorf("fail to load renewed certificate, changed: %v, error: %v", changed, err)
+	}
+
+	// The account key is reused after restarts.
+	restarted, err := newAcmeManager(opts)
+	if err != nil {
+		t.Fatalf("fail to create ACME manager: %v", err)
+	}
+	if restarted.client.accountKey.D.Cmp(acme.client.accountKey.D) != 0 {
+		t.Errorf("got a new ACME account key after restart")
+	}
+
+	// Expiring certificates or certificates missing a domain are renewed.
+	cert, key := makeServerCert(t, "example.com", time.Now().Add(90*24*time.Hour))
+	ioutil.WriteFile(acme.certPath, cert, 0644)
+	ioutil.WriteFile(acme.keyPath, key, 0600)
+	if !acme.needsRenewal() {
+		t.Errorf("certificate missing a domain does not need renewal")
+	}
+	cert, key = makeServerCert(t, "example.com", time.Now().Add(24*time.Hour))
+	ioutil.WriteFile(acme.certPath, cert, 0644)
+	ioutil.WriteFile(acme.keyPath, key, 0600)
+	acme.domains = []string{"example.com"}
+	if !acme.needsRenewal() {
+		t.Errorf("expiring certificate does not need renewal")
+	}
+}
@@ -0,0 +1,152 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package configmanager
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/logging"
+	"github.com/golang/protobuf/proto"
+
+	confpb "google.golang.org/genproto/googleapis/api/serviceconfig"
+)
+
+// cachedServiceConfig is the on-disk format of the last-known-good service
+// config. The service config is stored as serialized proto bytes so it can be
+// read back without resolving the Any types inside it.
+type cachedServiceConfig struct {
+	ServiceName   string    `json:"serviceName"`
+	RolloutID     string    `json:"rolloutId,omitempty"`
+	ConfigID      string    `json:"configId"`
+	FetchTime     time.Time `json:"fetchTime"`
+	ServiceConfig []byte    `json:"serviceConfig"`
+}
+
+// writeConfigCache persists the service config to cachePath. The file is
+// written to a temporary file first and renamed, so a crash in the middle of
+// writing never leaves a truncated cache behind.
+func writeConfigCache(cachePath, rolloutID string, serviceConfig *confpb.Service, now time.Time) error {
+	serviceConfigBytes, err := proto.Marshal(serviceConfig)
+	if err != nil {
+		return fmt.Errorf("fail to marshal service config: %v", err)
+	}
+	content, err := json.Marshal(&cachedServiceConfig{
+		ServiceName:   serviceConfig.GetName(),
+		RolloutID:     rolloutID,
+		ConfigID:      serviceConfig.GetId(),
+		FetchTime:     now,
+		ServiceConfig: serviceConfigBytes,
+	})
+	if err != nil {
+		return fmt.Errorf("fail to marshal service config cache: %v", err)
+	}
+
+	tmpFile, err := ioutil.TempFile(filepath.Dir(cachePath), filepath.Base(cachePath)+".tmp")
+	if err != nil {
+		return fmt.Errorf("fail to create service config cache file: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+	if _, err := tmpFile.Write(content); err != nil {
+		tmpFile.Close()
+		return fmt.Errorf("fail to write service config cache file: %v", err)
+	}
+	if err := tmpFile.Close(); err != nil {
+		return fmt.Errorf("fail to write service config cache file: %v", err)
+	}
+	return os.Rename(tmpFile.Name(), cachePath)
+}
+
+// readConfigCache reads the service config cached at cachePath. It fails if
+// the cache is for another service, for another config id when configID is
+// not empty, or is older than maxStaleness when maxStaleness is positive.
+func readConfigCache(cachePath, serviceName, configID string, maxStaleness time.Duration, now time.Time) (*cachedServiceConfig, *confpb.Service, error) {
+	content, err := ioutil.ReadFile(cachePath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("fail to read service config cache file: %v", err)
+	}
+	cached := &cachedServiceConfig{}
+	if err := json.Unmarshal(content, cached); err != nil {
+		return nil, nil, fmt.Errorf("fail to unmarshal service config cache: %v", err)
+	}
+	if cached.ServiceName != serviceName {
+		return nil, nil, fmt.Errorf("service config cache is for service %v, not %v", cached.ServiceName, serviceName)
+	}
+	if configID != "" && cached.ConfigID != configID {
+		return nil, nil, fmt.Errorf("service config cache has config id %v, not %v", cached.ConfigID, configID)
+	}
+	if age := now.Sub(cached.FetchTime); maxStaleness > 0 && age > maxStaleness {
+		return nil, nil, fmt.Errorf("service config cache is %v old, older than the limit %v", age, maxStaleness)
+	}
+
+	serviceConfig := new(confpb.Service)
+	if err := proto.Unmarshal(cached.ServiceConfig, serviceConfig); err != nil {
+		return nil, nil, fmt.Errorf("fail to unmarshal cached Service: %v", err)
+	}
+	return cached, serviceConfig, nil
+}
+
+// configCachePath returns the cache file of a service. The first service is
+// cached at --service_config_cache_path, other services at
+// --service_config_cache_path.{service}.
+func (m *ConfigManager) configCachePath(serviceName string) string {
+	if serviceName == m.serviceName {
+		return *ServiceConfigCachePath
+	}
+	return *ServiceConfigCachePath + "." + serviceName
+}
+
+// saveConfigCache writes the service config of a service to its cache file, if
+// --service_config_cache_path is set. Failures are only logged, since the proxy
+// can still serve without it.
+func (m *ConfigManager) saveConfigCache(serviceName, rolloutID string, serviceConfig *confpb.Service) {
+	if *ServiceConfigCachePath == "" {
+		return
+	}
+	cachePath := m.configCachePath(serviceName)
+	if err := writeConfigCache(cachePath, rolloutID, serviceConfig, time.Now()); err != nil {
+		logging.WithFields(logging.Fields{"service_name": serviceName, "config_id": serviceConfig.GetId()}).Warningf("fail to save service config cache to %v: %v", cachePath, err)
+		return
+	}
+	logging.WithFields(logging.Fields{
+		"service_name": serviceName,
+		"rollout_id":   rolloutID,
+		"config_id":    serviceConfig.GetId(),
+	}).Infof("saved service config to cache %v", cachePath)
+}
+
+// loadConfigCache reads the cached service config of a service. It is only
+// used when the service config cannot be fetched on startup.
+func (m *ConfigManager) loadConfigCache(serviceName, configID string) (*cachedServiceConfig, *confpb.Service, error) {
+	if *ServiceConfigCachePath == "" {
+		return nil, nil, fmt.Errorf("--service_config_cache_path is not set")
+	}
+	return readConfigCache(m.configCachePath(serviceName), serviceName, configID, *ServiceConfigCacheMaxStaleness, time.Now())
+}
+
+// applyConfigCache applies the cached service config of the first service.
+func (m *ConfigManager) applyConfigCache(configID string) error {
+	cached, serviceConfig, err := m.loadConfigCache(m.serviceName, configID)
+	if err != nil {
+		return err
+	}
+	m.curRolloutID = cached.RolloutID
+	m.curConfigID = cached.ConfigID
+	return m.applyServiceConfig(serviceConfig)
+}
@@ -0,0 +1,173 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package configmanager
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/metadata"
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/options"
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/util"
+	"github.com/golang/protobuf/proto"
+
+	confpb "google.golang.org/genproto/googleapis/api/serviceconfig"
+)
+
+func TestReadConfigCache(t *testing.T) {
+	now := time.Date(2019, 10, 1, 0, 0, 0, 0, time.UTC)
+	serviceConfig := &confpb.Service{
+		Name: testProjectName,
+		Id:   testConfigID,
+	}
+
+	testCases := []struct {
+		desc          string
+		serviceName   string
+		configID      string
+		maxStaleness  time.Duration
+		readTime      time.Time
+		wantErrSubstr string
+	}{
+		{
+			desc:        "Success reading cache in managed mode",
+			serviceName: testProjectName,
+			readTime:    now.Add(48 * time.Hour),
+		},
+		{
+			desc:         "Success reading cache within staleness limit",
+			serviceName:  testProjectName,
+			configID:     testConfigID,
+			maxStaleness: time.Hour,
+			readTime:     now.Add(time.Minute),
+		},
+		{
+			desc:          "Failure reading cache of another service",
+			serviceName:   "another-service",
+			readTime:      now,
+			wantErrSubstr: "not another-service",
+		},
+		{
+			desc:          "Failure reading cache of another config id",
+			serviceName:   testProjectName,
+			configID:      "2017-05-01r1",
+			readTime:      now,
+			wantErrSubstr: "not 2017-05-01r1",
+		},
+		{
+			desc:          "Failure reading stale cache",
+			serviceName:   testProjectName,
+			maxStaleness:  time.Hour,
+			readTime:      now.Add(2 * time.Hour),
+			wantErrSubstr: "older than the limit 1h0m0s",
+		},
+	}
+
+	dir, err := ioutil.TempDir("", "config_cache")
+	if err != nil {
+		t.Fatalf("fail to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	cachePath := filepath.Join(dir, "service.json")
+	if err := writeConfigCache(cachePath, "rollout-1", serviceConfig, now); err != nil {
+		t.Fatalf("writeConfigCache got error: %v", err)
+	}
+
+	for _, tc := range testCases {
+		cached, gotConfig, err := readConfigCache(cachePath, tc.serviceName, tc.configID, tc.maxStaleness, tc.readTime)
+		if tc.wantErrSubstr != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.wantErrSubstr) {
+				t.Errorf("Test Desc(%s): got error %v, want error containing %q", tc.desc, err, tc.wantErrSubstr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Test Desc(%s): got error: %v", tc.desc, err)
+			continue
+		}
+		if cached.RolloutID != "rollout-1" || cached.ConfigID != testConfigID {
+			t.Errorf("Test Desc(%s): got rollout id %v and config id %v, want rollout-1 and %v", tc.desc, cached.RolloutID, cached.ConfigID, testConfigID)
+		}
+		if !proto.Equal(gotConfig, serviceConfig) {
+			t.Errorf("Test Desc(%s): got service config %v, want %v", tc.desc, gotConfig, serviceConfig)
+		}
+	}
+}
+
+func TestNewConfigManagerWithConfigCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config_cache")
+	if err != nil {
+		t.Fatalf("fail to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	cachePath := filepath.Join(dir, "service.json")
+
+	flag.Set("service", testProjectName)
+	flag.Set("service_config_id", testConfigID)
+	flag.Set("rollout_strategy", "fixed")
+	flag.Set("service_management_fetch_retries", "0")
+	flag.Set("service_config_cache_path", cachePath)
+	defer flag.Set("service_management_fetch_retries", "3")
+	defer flag.Set("service_config_cache_path", "")
+
+	fakeConfig, err = genFakeConfig(fmt.Sprintf(`{"name":"%s","id":"%s","apis":[{"name":"%s"}]}`, testProjectName, testConfigID, testEndpointName))
+	if err != nil {
+		t.Fatalf("fail to generate fake config: %v", err)
+	}
+	opts := options.DefaultConfigGeneratorOptions()
+	opts.BackendAddress = "http://127.0.0.1:8082"
+
+	// Servicemanagement is available, the fetched config is saved into the cache.
+	runTest(t, opts, func(env *testEnv) {})
+	if _, err := os.Stat(cachePath); err != nil {
+		t.Fatalf("service config cache is not written: %v", err)
+	}
+
+	// Servicemanagement is unavailable, the config is loaded from the cache.
+	unavailableServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer unavailableServer.Close()
+	fetchConfigURL = func(serviceName, configID string) string {
+		return unavailableServer.URL
+	}
+	mockMetadataServer := util.InitMockServerFromPathResp(map[string]string{
+		util.AccessTokenSuffix: fakeToken,
+	})
+	defer mockMetadataServer.Close()
+	mf := metadata.NewMockMetadataFetcher(mockMetadataServer.URL, time.Now())
+
+	manager, err := NewConfigManager(mf, opts)
+	if err != nil {
+		t.Fatalf("NewConfigManager should load the cached service config, got error: %v", err)
+	}
+	if manager.curConfigID != testConfigID {
+		t.Errorf("got config id %v, want %v", manager.curConfigID, testConfigID)
+	}
+
+	// Without the cache, NewConfigManager fails.
+	flag.Set("service_config_cache_path", "")
+	if _, err := NewConfigManager(mf, opts); err == nil {
+		t.Errorf("NewConfigManager should fail without the service config cache")
+	}
+}
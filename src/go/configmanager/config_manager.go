@@ -17,19 +17,28 @@ package configmanager
 import (
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/GoogleCloudPlatform/esp-v2/src/go/commonflags"
 	"github.com/GoogleCloudPlatform/esp-v2/src/go/configinfo"
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/logging"
 	"github.com/GoogleCloudPlatform/esp-v2/src/go/metadata"
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/openapi"
 	"github.com/GoogleCloudPlatform/esp-v2/src/go/options"
 	"github.com/GoogleCloudPlatform/esp-v2/src/go/util"
 	"github.com/envoyproxy/go-control-plane/pkg/cache"
-	"github.com/golang/glog"
+	"github.com/golang/protobuf/ptypes"
+	"google.golang.org/grpc"
 
 	gen "github.com/GoogleCloudPlatform/esp-v2/src/go/configgenerator"
+	v2pb "github.com/envoyproxy/go-control-plane/envoy/api/v2"
+	authpb "github.com/envoyproxy/go-control-plane/envoy/api/v2/auth"
 	corepb "github.com/envoyproxy/go-control-plane/envoy/api/v2/core"
+	hcmpb "github.com/envoyproxy/go-control-plane/envoy/config/filter/network/http_connection_manager/v2"
+	anypb "github.com/golang/protobuf/ptypes/any"
 	confpb "google.golang.org/genproto/googleapis/api/serviceconfig"
 )
 
@@ -38,20 +47,131 @@ var (
 	checkNewRolloutInterval = flag.Duration("check_rollout_interval", 60*time.Second, `the interval periodically to call servicemanagment to check the latest rolloutil.`)
 	CheckMetadata           = flag.Bool("check_metadata", false, `enable fetching service name, config ID and rollout strategy from service metadata server`)
 	RolloutStrategy         = flag.String("rollout_strategy", "fixed", `service config rollout strategy, must be either "managed" or "fixed"`)
-	ServiceConfigID         = flag.String("service_config_id", "", "initial service config id")
-	ServiceName             = flag.String("service", "", "endpoint service name")
-	ServicePath             = flag.String("service_json_path", "", `file path to the endpoint service config.
-					When this flag is used, fixed rollout_strategy will be used,
-					GCP metadata server will not be called to fetch access token, and
-					following flags will be ignored; --service_config_id, --service,
-					--rollout_strategy`)
+	ServiceConfigID         = flag.String("service_config_id", "", "initial service config id, comma separated in the same order as --service when multiple services are served")
+	ServiceName             = flag.String("service", "", `endpoint service name, or a comma separated list of services served by one proxy, or the path of a JSON
+					descriptor file listing the services, like {"services": [{"name": "...", "configId": "...", "pathPrefix": "/..."}]}.
+					Requests are routed to a service by its path prefix if set, otherwise by its host, and other requests are
+					routed to the first service.`)
+	ServicePath = flag.String("service_json_path", "", `file path to the endpoint service config.
+					When this flag is used, GCP metadata server will not be called to fetch access token, and
+					following flags will be ignored; --service_config_id, --service.
+					With "managed" rollout_strategy, the file is checked for changes every --service_json_path_check_interval
+					and reloaded without restarting the proxy.`)
+	servicePathCheckInterval = flag.Duration("service_json_path_check_interval", 5*time.Second, `the interval to check --service_json_path for changes
+					with "managed" rollout_strategy.`)
+	localJwksCheckInterval = flag.Duration("local_jwks_check_interval", 5*time.Second, `the interval to check the local JWKS files of the JWT providers
+					for changes, 0 to disable.`)
+	sslServerCertCheckInterval = flag.Duration("ssl_server_cert_check_interval", 30*time.Second, `the interval to check the certificate in --ssl_server_cert_path
+					for changes with --ssl_server_cert_sds, 0 to disable.`)
+	transcodingDescriptorCheckInterval = flag.Duration("transcoding_descriptor_check_interval", 5*time.Second, `the interval to check --transcoding_descriptor_path
+					for changes, 0 to disable.`)
+	accessLogCheckInterval = flag.Duration("access_log_check_interval", 10*time.Second, `the interval to check the size of --access_log_path
+					for rotation with --access_log_max_bytes.`)
+	OpenAPISpecPath = flag.String("openapi_spec_path", "", `file path to an OpenAPI 3.x document in JSON, translated to the endpoint service config.
+					The service name defaults to the host of the first server in the document, unless --service is set,
+					and the config id defaults to the version of the document, unless --service_config_id is set.`)
+
+	ServiceManagementTransport = flag.String("service_management_transport", "rest", `transport used to call servicemanagement, must be either "rest" or "grpc".
+					When "grpc" is used and the gRPC call fails, the call falls back to "rest".`)
+
+	// Retry policy for transient errors returned by servicemanagement.
+	ServiceManagementFetchRetries    = flag.Int("service_management_fetch_retries", 3, `max number of retries when servicemanagement returns a retriable status code. 0 disables retries.`)
+	ServiceManagementFetchBackoff    = flag.Duration("service_management_fetch_backoff", time.Second, `initial backoff between retries to servicemanagement, doubled on each retry with jitter.`)
+	ServiceManagementFetchMaxBackoff = flag.Duration("service_management_fetch_max_backoff", 16*time.Second, `max backoff between retries to servicemanagement.`)
+	ServiceManagementRetriableCodes  = flag.String("service_management_fetch_retriable_status_codes", "429,500,502,503,504", `comma separated HTTP status codes from servicemanagement that should be retried.`)
+
+	// Last-known-good service config, used when servicemanagement is unreachable on startup.
+	ServiceConfigCachePath = flag.String("service_config_cache_path", "", `file path to cache the last successfully fetched service config.
+					When set and the service config cannot be fetched from servicemanagement on startup,
+					the cached service config is used instead. With multiple services, services after the first one are
+					cached in {path}.{service}.`)
+	ServiceConfigCacheMaxStaleness = flag.Duration("service_config_cache_max_staleness", 0, `max age of the cached service config to be used on startup. 0 means no limit.`)
+
+	// Push-based rollout updates through Cloud Pub/Sub.
+	RolloutNotificationSubscription = flag.String("rollout_notification_subscription", "", `Cloud Pub/Sub subscription receiving rollout notifications, in the form of
+					projects/{project}/subscriptions/{subscription}. When set with "managed" rollout_strategy, new rollouts are
+					checked as soon as a notification is received, in addition to the periodic check.`)
+	PubsubURL = flag.String("pubsub_url", "https://pubsub.googleapis.com", "url of Cloud Pub/Sub server")
+
+	// Canary rollouts served with their traffic percentages.
+	RolloutTrafficSplit = flag.Bool("rollout_traffic_split", false, `with "managed" rollout_strategy, serve all configs of a rollout with their traffic percentages,
+					instead of only the config with max percentage. Each config is served by an internal listener on 127.0.0.1
+					and requests are split between them by weight. Not supported with multiple services.`)
+	RolloutTrafficSplitBasePort = flag.Int("rollout_traffic_split_base_port", 8090, `first port of the internal listeners on 127.0.0.1 used by --rollout_traffic_split,
+					one port for each config of a rollout.`)
+
+	// Polls for new rollouts.
+	RolloutPollInterval = flag.Duration("rollout_poll_interval", 0, `interval of the polls for new rollouts with "managed" rollout_strategy or --detect_rollout_drift,
+					overriding --check_rollout_interval when set.`)
+	RolloutFetchTimeout = flag.Duration("rollout_fetch_timeout", 0, `timeout of a fetch of the rollouts, whatever the service_config_source, counted as a failed poll.
+					0 only applies the timeout of each request, --http_request_timeout_s, to the fetch and its retries.`)
+	RolloutFailureThreshold = flag.Int("rollout_failure_threshold", 0, `number of consecutive failed polls for new rollouts, failing to fetch or apply a rollout, after which
+					a warning is logged and the espv2_config_manager_rollout_poll_failing metric is set to 1 until a poll succeeds. 0 disables it.`)
+	RolloutFailureFailReadiness = flag.Bool("rollout_failure_fail_readiness", false, `fail /readyz and the gRPC health check while --rollout_failure_threshold
+					is reached, so that the proxy serving a stale service config receives no new traffic.`)
+	StopServingDeletedService = flag.Bool("stop_serving_deleted_service", false, `with "managed" rollout_strategy, answer all requests with 410 Gone once
+					the service is deleted in Service Management, instead of serving its last config. Not supported with multiple services
+					or --rollout_traffic_split.`)
+	DetectRolloutDrift = flag.Bool("detect_rollout_drift", false, `with "fixed" rollout_strategy, still poll the rollouts every --rollout_poll_interval
+					and warn when the pinned service config id is not the one of the latest rollout, in the logs, on /status and with
+					the espv2_config_manager_pinned_config_drift metric. The pinned config is still served.`)
+
+	// Alternative sources of service configs and rollouts.
+	ServiceConfigSource = flag.String("service_config_source", "servicemanagement", `source of service configs and rollouts, must be one of "servicemanagement", "gcs", "configmap" or "https".
+					Alternative sources serve the service config in JSON and the rollouts in the JSON form of ListServiceRolloutsResponse.`)
+	ServiceConfigGCSPath = flag.String("service_config_gcs_path", "", `GCS path in the form of gs://bucket/path, used with "gcs" service_config_source.
+					The objects are {path}/{service}/{config_id}.json and {path}/{service}/rollouts.json.`)
+	GCSURL                 = flag.String("gcs_url", "https://storage.googleapis.com", "url of Cloud Storage server")
+	ServiceConfigConfigMap = flag.String("service_config_configmap", "", `Kubernetes ConfigMap in the form of namespace/name, used with "configmap" service_config_source.
+					The keys are {service}.{config_id}.json and {service}.rollouts.json.`)
+	ServiceConfigURL         = flag.String("service_config_url", "", `url of the service config with $serviceName and $configId placeholders, used with "https" service_config_source.`)
+	ServiceConfigRolloutsURL = flag.String("service_config_rollouts_url", "", `url of the rollouts with $serviceName placeholder, used with "https" service_config_source
+					and "managed" rollout_strategy.`)
+	ServiceConfigHeaders = flag.String("service_config_headers", "", `comma separated name=value headers sent to --service_config_url and --service_config_rollouts_url,
+					like an Authorization header.`)
+
+	// Kubernetes pods deploying the proxy as a sidecar.
+	PodAnnotationsPath = flag.String("pod_annotations_path", "", `path of the pod annotations mounted with the downward API. The service name and config id are read
+					from the annotations endpoints.cloud.google.com/service-name and endpoints.cloud.google.com/service-config-id
+					when --service and --service_config_id are not set. With "fixed" rollout_strategy, the annotations are
+					checked for changes every --pod_annotations_check_interval.`)
+	podAnnotationsCheckInterval = flag.Duration("pod_annotations_check_interval", 5*time.Second, `the interval to check --pod_annotations_path
+					for changes with "fixed" rollout_strategy, 0 to disable.`)
+
+	MultiServiceBasePort = flag.Int("multi_service_base_port", 8090, `first port of the internal listeners on 127.0.0.1 used when multiple services
+					are served, one port for each service.`)
+
+	StatusPort = flag.Int("status_port", 0, `port of the debug server on 127.0.0.1, serving the config manager status on /status, the
+					generated Envoy config on /config_dump, the last snapshots on /snapshots and their diff on /snapshot_diff,
+					the generated routes with the operations they were generated for on /routes, and how a request would be
+					routed and authenticated on /explain?method={method}&path={path}. 0 disables the server.`)
+	SnapshotHistorySize = flag.Int("snapshot_history_size", 10, `number of the last generated snapshots kept by the debug server, so that
+					/snapshot_diff can compare any two of them.`)
+	MetricsPort = flag.Int("metrics_port", 0, `port serving the config manager metrics on /metrics in the Prometheus text format, and the health
+					checks on /healthz and /readyz, on all interfaces so that they can be scraped and probed. 0 disables the server.`)
+	healthADSDownThreshold = flag.Duration("health_ads_down_threshold", 0, `/healthz fails when Envoy has had no ADS stream for longer than this duration.
+					0 disables the check.`)
+	healthConfigStalenessThreshold = flag.Duration("health_config_staleness_threshold", 0, `/healthz fails when the service config has not been fetched successfully
+					for longer than this duration, in "managed" rollout_strategy. 0 disables the check.`)
+
+	LogFormat = flag.String("log_format", "text", `format of the config manager logs, must be either "text" for glog or "json" for JSON records
+					with severity, labels and structured fields, which are ingested by Cloud Logging.`)
+	LogLevel = flag.String("log_level", "info", `min level of the config manager logs, must be one of "debug", "info", "warning" and "error".`)
+
+	ValidateOnly = flag.Bool("validate_only", false, `generate and validate the Envoy config of the service config, then exit with a non-zero status
+					if it is invalid, without serving it. Used to validate service configs in CI pipelines before deploying them.`)
+	EnvoyValidateBinary = flag.String("envoy_validate_binary", "", `path to the Envoy binary. If set with --validate_only, the generated config is also run through
+					"envoy --mode validate", in addition to the validation of its resources.`)
+	RenderFormat = flag.String("render_format", "json", `format of the Envoy config printed by "configmanager render", must be either "json" or "yaml".`)
 
 	// secured HTTP client calling service management service.
 	serviceConfigFetcherClient *http.Client
+	// gRPC channel calling service management service, only set when
+	// --service_management_transport=grpc.
+	serviceManagementConn *grpc.ClientConn
 )
 
 // Config Manager handles service configuration fetching and updating.
-// TODO(jilinxia): handles multi service name.
 type ConfigManager struct {
 	serviceName        string
 	serviceInfo        *configinfo.ServiceInfo
@@ -59,10 +179,47 @@ type ConfigManager struct {
 	curRolloutID       string
 	curConfigID        string
 
+	// Rollout strategy of the services, only set when they are fetched.
+	rolloutStrategy string
+	// Set with --stop_serving_deleted_service once the service is deleted.
+	serviceDeleted bool
+
+	// Path prefix of the first service, only set in the descriptor file.
+	servicePathPrefix string
+	// Services listed after the first one in --service.
+	additionalServices []*additionalService
+
 	cache               cache.SnapshotCache
 	checkRolloutsTicker *time.Ticker
 
 	metadataFetcher *metadata.MetadataFetcher
+	fetcher         ServiceConfigFetcher
+
+	status statusRecorder
+	health healthRecorder
+
+	// Hash of the watched --service_json_path, only set in managed rollout.
+	serviceConfigFileHash []byte
+	// Hash of the local JWKS files, only set once they have changed.
+	localJwksHash []byte
+	// Hash of --transcoding_descriptor_path, only set once it has changed.
+	transcodingDescriptorHash []byte
+
+	// SDS secret of the server certificate and the hash of its files, only
+	// set with --ssl_server_cert_sds once the files are valid.
+	serverCertMu     sync.Mutex
+	serverCertSecret *authpb.Secret
+	serverCertHash   []byte
+	// Issues and renews the server certificate, only set with
+	// --ssl_server_acme_directory_url.
+	acme *acmeManager
+
+	// Configs of the current rollout, only set with --rollout_traffic_split
+	// when the rollout has more than one config.
+	trafficSplitConfigs []*trafficSplitConfig
+	// Rollout applied with --rollout_traffic_split, which is not the same as
+	// curRolloutID when the config is loaded from the cache.
+	trafficSplitRolloutID string
 }
 
 // NewConfigManager creates new instance of Config Manager.
@@ -73,31 +230,87 @@ func NewConfigManager(mf *metadata.MetadataFetcher, opts options.ConfigGenerator
 		envoyConfigOptions: opts,
 	}
 	m.cache = cache.NewSnapshotCache(true, m, m)
+	m.initHealth()
+
+	if opts.SslServerCertSds {
+		if _, err := m.loadServerCert(); err != nil {
+			if opts.SslServerAcmeDirectoryUrl == "" {
+				return nil, err
+			}
+			// The listener is warming until the certificate is issued.
+			logging.Warningf("server certificate not loaded, waiting for the ACME server: %v", err)
+		}
+	}
+	if opts.SslServerAcmeDirectoryUrl != "" {
+		acme, err := newAcmeManager(opts)
+		if err != nil {
+			return nil, err
+		}
+		m.acme = acme
+	}
 
-	// If service config is provided as a file, just use it and disable managed rollout
+	// If service config is provided as a file, just use it and watch it for changes in managed rollout
 	if *ServicePath != "" {
+		m.fetcher = &instrumentedFetcher{fetcher: &fileFetcher{path: *ServicePath}}
 		// Following flags will not be used
 		if *ServiceName != "" {
-			glog.Infof("flag --service is ignored when --service_json_path is specified.")
+			logging.Infof("flag --service is ignored when --service_json_path is specified.")
 		}
 		if *ServiceConfigID != "" {
-			glog.Infof("flag --service_config_id is ignored when --service_json_path is specified.")
+			logging.Infof("flag --service_config_id is ignored when --service_json_path is specified.")
 		}
-		if *RolloutStrategy != "fixed" {
-			glog.Infof("flag --rollout_strategy will be fixed when --service_json_path is specified.")
+		if *RolloutStrategy == util.ManagedRolloutStrategy {
+			m.enableStalenessCheck()
+			if err := m.checkServiceConfigFile(); err != nil {
+				return nil, err
+			}
+			go m.watchServiceConfigFile(*ServicePath)
+			logging.Infof("create new Config Manager from service config json file at %v, checking changes every %v", *ServicePath, *servicePathCheckInterval)
+			return m, nil
 		}
 
-		if err := m.readAndApplyServiceConfig(*ServicePath); err != nil {
+		if err := m.readAndApplyServiceConfig(); err != nil {
 			return nil, err
 		}
 
-		glog.Infof("create new Config Manager from static service config json file at %v", *ServicePath)
+		logging.Infof("create new Config Manager from static service config json file at %v", *ServicePath)
+		return m, nil
+	}
+
+	if *OpenAPISpecPath != "" {
+		if err := m.readAndApplyOpenAPISpec(*OpenAPISpecPath); err != nil {
+			return nil, err
+		}
+		logging.Infof("create new Config Manager from OpenAPI document at %v", *OpenAPISpecPath)
 		return m, nil
 	}
 
-	m.serviceName = *ServiceName
+	services, err := parseServiceFlag(*ServiceName, *ServiceConfigID)
+	if err != nil {
+		return nil, err
+	}
+	if len(services) > 0 {
+		m.serviceName = services[0].Name
+		m.servicePathPrefix = services[0].PathPrefix
+		for _, s := range services[1:] {
+			m.additionalServices = append(m.additionalServices, &additionalService{
+				serviceName: s.Name,
+				configID:    s.ConfigID,
+				pathPrefix:  s.PathPrefix,
+			})
+		}
+	}
 	checkMetadata := *CheckMetadata
-	var err error
+
+	var annotations map[string]string
+	if *PodAnnotationsPath != "" {
+		if annotations, err = readPodAnnotations(*PodAnnotationsPath); err != nil {
+			return nil, err
+		}
+		if m.serviceName == "" {
+			m.serviceName = annotations[serviceNameAnnotation]
+		}
+	}
 
 	if m.serviceName == "" && checkMetadata && mf != nil {
 		m.serviceName, err = mf.FetchServiceName()
@@ -120,28 +333,80 @@ func NewConfigManager(mf *metadata.MetadataFetcher, opts options.ConfigGenerator
 	if !(rolloutStrategy == util.FixedRolloutStrategy || rolloutStrategy == util.ManagedRolloutStrategy) {
 		return nil, fmt.Errorf(`failed to set rollout strategy. It must be either "managed" or "fixed"`)
 	}
+	m.rolloutStrategy = rolloutStrategy
 
-	// Create secured http client with rootCertsPath.
+	// Create secured http client with rootCertsPath, or the TLS settings of
+	// Service Management.
+	serviceManagementTls = opts.OutboundTlsFor(options.ServiceManagementDependency)
+	if err := setJwksTLSConfig(&opts); err != nil {
+		return nil, err
+	}
 	if serviceConfigFetcherClient, err = newServiceConfigFetcherClient(time.Duration(*commonflags.HttpRequestTimeoutS) * time.Second); err != nil {
 		return nil, fmt.Errorf(`failed to create https client to call ServiceManagement service, got error: %v`, err)
 	}
 
-	if rolloutStrategy == util.ManagedRolloutStrategy {
-		// try to fetch rollouts and get newest config, if failed, NewConfigManager exits with failure
-		newRolloutID, newConfigID, err := loadConfigFromRollouts(m.serviceName, m.curRolloutID, m.curConfigID, mf)
-		if err != nil {
+	if retriableStatusCodes, err = parseRetriableStatusCodes(*ServiceManagementRetriableCodes); err != nil {
+		return nil, fmt.Errorf("failed to parse --service_management_fetch_retriable_status_codes: %v", err)
+	}
+
+	if m.fetcher, err = newServiceConfigFetcher(*ServiceConfigSource, mf); err != nil {
+		return nil, err
+	}
+	if *RolloutFetchTimeout > 0 {
+		m.fetcher = &timeoutFetcher{fetcher: m.fetcher, timeout: *RolloutFetchTimeout}
+	}
+	m.fetcher = &instrumentedFetcher{fetcher: m.fetcher}
+
+	switch *ServiceManagementTransport {
+	case restTransport:
+		serviceManagementConn = nil
+	case grpcTransport:
+		// REST is still available as the fallback, so only log the error here.
+		if serviceManagementConn, err = newServiceManagementConn(); err != nil {
+			logging.Warningf("failed to create gRPC connection to ServiceManagement service, using REST instead: %v", err)
+		}
+	default:
+		return nil, fmt.Errorf(`failed to set service management transport. It must be either "rest" or "grpc"`)
+	}
+
+	if *StopServingDeletedService && *RolloutTrafficSplit {
+		return nil, fmt.Errorf("--stop_serving_deleted_service is not supported with --rollout_traffic_split")
+	}
+	if len(m.additionalServices) > 0 {
+		if *RolloutTrafficSplit {
+			return nil, fmt.Errorf("--rollout_traffic_split is not supported with multiple services")
+		}
+		if *StopServingDeletedService {
+			return nil, fmt.Errorf("--stop_serving_deleted_service is not supported with multiple services")
+		}
+		if err := m.initAdditionalServices(rolloutStrategy); err != nil {
 			return nil, err
 		}
-		if m.curRolloutID != newRolloutID && m.curConfigID != newConfigID {
-			m.curRolloutID = newRolloutID
-			m.curConfigID = newConfigID
-			if err := m.updateSnapshot(); err != nil {
-				return nil, err
+	}
+
+	// configID is the config id required in fixed mode, it is empty in managed mode.
+	var configID string
+	if rolloutStrategy == util.ManagedRolloutStrategy {
+		// try to fetch rollouts and get newest config, if failed, fall back to the cached config
+		if *RolloutTrafficSplit {
+			err = m.checkTrafficSplitRollouts()
+		} else {
+			var newRolloutID, newConfigID string
+			newRolloutID, newConfigID, err = loadConfigFromRollouts(m.serviceName, m.curRolloutID, m.curConfigID, m.fetcher)
+			if err == nil && m.curRolloutID != newRolloutID && m.curConfigID != newConfigID {
+				m.curRolloutID = newRolloutID
+				m.curConfigID = newConfigID
+				err = m.updateSnapshot()
 			}
 		}
 	} else {
 		// rollout strategy is fixed mode
-		configID := *ServiceConfigID
+		if len(services) > 0 {
+			configID = services[0].ConfigID
+		}
+		if configID == "" {
+			configID = annotations[serviceConfigIDAnnotation]
+		}
 		if configID == "" {
 			if checkMetadata && mf != nil {
 				configID, err = mf.FetchConfigId()
@@ -155,53 +420,185 @@ func NewConfigManager(mf *metadata.MetadataFetcher, opts options.ConfigGenerator
 			}
 		}
 		m.curConfigID = configID
-		if err := m.updateSnapshot(); err != nil {
+		err = m.updateSnapshot()
+	}
+	if err != nil {
+		// If the config cannot be fetched and there is no usable cache, NewConfigManager exits with failure.
+		if cacheErr := m.applyConfigCache(configID); cacheErr != nil {
+			logging.Infof("service config cache is not used: %v", cacheErr)
 			return nil, err
 		}
+		logging.WithFields(m.logFields()).Warningf("fail to load service config, using the cached service config instead: %v", err)
 	}
-	glog.Infof("create new Config Manager for service (%v) with configuration id (%v), %v rollout strategy",
-		m.serviceName, m.curConfigID, rolloutStrategy)
+	logging.WithFields(m.logFields()).Infof("create new Config Manager with %v rollout strategy", rolloutStrategy)
 
 	if rolloutStrategy == util.ManagedRolloutStrategy {
+		m.enableStalenessCheck()
+		// Rollout notifications only make new rollouts picked up sooner,
+		// the periodic check keeps running in case notifications are lost.
+		notifications := make(chan struct{}, 1)
+		if *RolloutNotificationSubscription != "" {
+			go pullRolloutNotifications(*RolloutNotificationSubscription, mf, notifications)
+		}
 		go func() {
-			glog.Infof("start checking new rollouts every %v seconds", *checkNewRolloutInterval)
-			m.checkRolloutsTicker = time.NewTicker(*checkNewRolloutInterval)
-			for range m.checkRolloutsTicker.C {
-				m.Infof("check new rollouts for service %v", m.serviceName)
-				// only log error and keep checking when fetching rollouts and getting newest config fail
-				newRolloutID, newConfigID, err := loadConfigFromRollouts(m.serviceName, m.curRolloutID, m.curConfigID, mf)
-				if err != nil {
-					glog.Errorf("error occurred when checking new rollouts, %v", err)
-				}
-				if m.curRolloutID != newRolloutID && m.curConfigID != newConfigID {
-					m.curRolloutID = newRolloutID
-					m.curConfigID = newConfigID
-					if err := m.updateSnapshot(); err != nil {
-						glog.Errorf("error occurred when checking new rollouts, %v", err)
-					}
+			logging.Infof("start checking new rollouts every %v", rolloutPollInterval())
+			m.checkRolloutsTicker = time.NewTicker(rolloutPollInterval())
+			for {
+				select {
+				case <-m.checkRolloutsTicker.C:
+				case <-notifications:
+					m.Infof("received rollout notification for service %v", m.serviceName)
 				}
+				m.checkNewRollouts()
 			}
 		}()
 	}
+	if rolloutStrategy == util.FixedRolloutStrategy && *DetectRolloutDrift {
+		go m.watchRolloutDrift()
+	}
 	return m, nil
 }
 
+// checkNewRollouts updates the snapshot if there is a new rollout, and
+// records whether the poll failed.
+func (m *ConfigManager) checkNewRollouts() {
+	m.Infof("check new rollouts for service %v", m.serviceName)
+	rolloutChecks.Inc()
+	if *RolloutTrafficSplit {
+		err := m.checkTrafficSplitRollouts()
+		if err != nil {
+			logging.WithFields(m.logFields()).Errorf("error occurred when checking new rollouts, %v", err)
+		}
+		m.recordRolloutPoll(err)
+		return
+	}
+	err := m.checkServiceRollouts()
+	if additionalErr := m.checkAdditionalServiceRollouts(); err == nil {
+		err = additionalErr
+	}
+	m.recordRolloutPoll(err)
+}
+
+// checkServiceRollouts updates the snapshot if the first service has a new
+// rollout. The error is logged and returned to count the failed polls.
+func (m *ConfigManager) checkServiceRollouts() error {
+	// only log error and keep checking when fetching rollouts and getting newest config fail
+	newRolloutID, newConfigID, err := loadConfigFromRollouts(m.serviceName, m.curRolloutID, m.curConfigID, m.fetcher)
+	if deletedErr, ok := err.(*serviceDeletedError); ok {
+		m.recordFetch(nil)
+		return m.applyServiceDeleted(deletedErr.rolloutID)
+	}
+	m.recordFetch(err)
+	if err != nil {
+		logging.WithFields(m.logFields()).Errorf("error occurred when checking new rollouts, %v", err)
+		return err
+	}
+	// The config of a deleted service is served again when it is recreated,
+	// even if its config ID is unchanged.
+	if m.curRolloutID == newRolloutID || (m.curConfigID == newConfigID && !m.serviceDeleted) {
+		return nil
+	}
+	prevRolloutID, prevConfigID, prevServiceDeleted := m.curRolloutID, m.curConfigID, m.serviceDeleted
+	m.curRolloutID = newRolloutID
+	m.curConfigID = newConfigID
+	m.serviceDeleted = false
+	if err := m.updateSnapshot(); err != nil {
+		// The previous snapshot is still served, roll back to its ids so
+		// that the new rollout is checked again next time.
+		logging.WithFields(m.logFields()).Errorf("error occurred when applying the rollout, rolled back to configuration id %v: %v", prevConfigID, err)
+		m.curRolloutID = prevRolloutID
+		m.curConfigID = prevConfigID
+		m.serviceDeleted = prevServiceDeleted
+		return err
+	}
+	return nil
+}
+
+// applyServiceDeleted handles the rollout deleting the service. The last
+// config is still served, unless --stop_serving_deleted_service is set and
+// all requests are answered with 410 Gone.
+func (m *ConfigManager) applyServiceDeleted(rolloutID string) error {
+	if m.curRolloutID == rolloutID {
+		return nil
+	}
+	prevRolloutID := m.curRolloutID
+	m.curRolloutID = rolloutID
+	if !*StopServingDeletedService {
+		logging.WithFields(m.logFields()).Warningf("service is deleted in Service Management, still serving configuration id %v", m.curConfigID)
+		return nil
+	}
+	logging.WithFields(m.logFields()).Warningf("service is deleted in Service Management, answering all requests with 410 Gone")
+	m.serviceDeleted = true
+	if err := m.setSnapshot(); err != nil {
+		logging.WithFields(m.logFields()).Errorf("error occurred when applying the deletion of the service: %v", err)
+		m.curRolloutID = prevRolloutID
+		m.serviceDeleted = false
+		return err
+	}
+	return nil
+}
+
 // updateSnapshot should be called when starting up the server.
 // It calls ServiceManager Server to fetch the service configuration in order
 // to dynamically configure Envoy.
 func (m *ConfigManager) updateSnapshot() error {
-	serviceConfig, err := fetchConfig(m.serviceName, m.curConfigID, m.metadataFetcher)
+	serviceConfig, err := m.fetchServiceConfig(m.serviceName, m.curConfigID)
 	if err != nil {
 		return fmt.Errorf("fail to fetch service config, %s", err)
 	}
 
+	if err := m.applyServiceConfig(serviceConfig); err != nil {
+		return err
+	}
+	m.saveConfigCache(m.serviceName, m.curRolloutID, serviceConfig)
+	return nil
+}
+
+// fetchServiceConfig fetches a service config, recording the fetch for the
+// status server and logging how long it took.
+func (m *ConfigManager) fetchServiceConfig(serviceName, configID string) (*confpb.Service, error) {
+	start := time.Now()
+	serviceConfig, err := m.fetcher.FetchConfig(serviceName, configID)
+	m.recordFetch(err)
+	entry := logging.WithFields(logging.Fields{
+		"service_name":   serviceName,
+		"config_id":      configID,
+		"fetch_duration": time.Since(start),
+	})
+	if err != nil {
+		// The error is returned to be logged by the caller.
+		entry.Debugf("fail to fetch service config: %v", err)
+		return nil, err
+	}
+	entry.Infof("fetched service config")
+	return serviceConfig, nil
+}
+
+// logFields returns the fields identifying the current config of the first
+// service in the logs.
+func (m *ConfigManager) logFields() logging.Fields {
+	return logging.Fields{
+		"service_name": m.serviceName,
+		"rollout_id":   m.curRolloutID,
+		"config_id":    m.curConfigID,
+	}
+}
+
+func (m *ConfigManager) readAndApplyServiceConfig() error {
+	serviceConfig, err := m.fetcher.FetchConfig(m.serviceName, m.curConfigID)
+	if err != nil {
+		return err
+	}
+	m.serviceName = serviceConfig.GetName()
+	m.curConfigID = serviceConfig.GetId()
+
 	return m.applyServiceConfig(serviceConfig)
 }
 
-func (m *ConfigManager) readAndApplyServiceConfig(servicePath string) error {
-	serviceConfig, err := readConfig(servicePath)
+func (m *ConfigManager) readAndApplyOpenAPISpec(specPath string) error {
+	serviceConfig, err := readOpenAPISpec(specPath, &m.envoyConfigOptions)
 	if err != nil {
-		return fmt.Errorf("fail to read service config file: %s, error: %s", servicePath, err)
+		return err
 	}
 	m.serviceName = serviceConfig.GetName()
 	m.curConfigID = serviceConfig.GetId()
@@ -209,11 +606,70 @@ func (m *ConfigManager) readAndApplyServiceConfig(servicePath string) error {
 	return m.applyServiceConfig(serviceConfig)
 }
 
+// setJwksTLSConfig sets the TLS config of the JWKS fetches of the config
+// manager, with the TLS settings of the JWKS in --outbound_tls_config.
+func setJwksTLSConfig(opts *options.ConfigGeneratorOptions) error {
+	o := opts.OutboundTlsFor(options.JwksDependency)
+	if o == nil {
+		util.SetJwksTLSConfig(nil)
+		return nil
+	}
+	caPath := o.CaPath
+	if caPath == "" {
+		caPath = opts.RootCertsPath
+	}
+	cfg, err := util.NewClientTLSConfig(caPath, o.ClientCertPath, o.ClientKeyPath, o.MinVersion, o.MaxVersion)
+	if err != nil {
+		return fmt.Errorf("fail to load the TLS settings of the JWKS fetches: %v", err)
+	}
+	util.SetJwksTLSConfig(cfg)
+	return nil
+}
+
+// readOpenAPISpec translates the OpenAPI document to the service config, and
+// applies its extensions to opts.
+func readOpenAPISpec(specPath string, opts *options.ConfigGeneratorOptions) (*confpb.Service, error) {
+	content, err := ioutil.ReadFile(specPath)
+	if err != nil {
+		return nil, fmt.Errorf("fail to read OpenAPI document: %s, error: %s", specPath, err)
+	}
+	var serviceName, configID string
+	if serviceNames := splitServiceFlag(*ServiceName); len(serviceNames) > 0 {
+		serviceName = serviceNames[0]
+	}
+	if configIDs := splitServiceFlag(*ServiceConfigID); len(configIDs) > 0 {
+		configID = configIDs[0]
+	}
+	serviceConfig, err := openapi.ToServiceConfig(content, serviceName, configID)
+	if err != nil {
+		return nil, fmt.Errorf("fail to translate OpenAPI document: %s, error: %s", specPath, err)
+	}
+	if err := openapi.ApplyOptions(content, serviceConfig.GetName(), opts); err != nil {
+		return nil, fmt.Errorf("fail to translate OpenAPI document: %s, error: %s", specPath, err)
+	}
+	return serviceConfig, nil
+}
+
 func (m *ConfigManager) applyServiceConfig(serviceConfig *confpb.Service) error {
-	var err error
-	m.serviceInfo, err = configinfo.NewServiceInfoFromServiceConfig(serviceConfig, m.curConfigID, m.envoyConfigOptions)
+	serviceInfo, err := m.newServiceInfo(serviceConfig, m.curConfigID)
 	if err != nil {
-		return fmt.Errorf("fail to initialize ServiceInfo, %s", err)
+		return err
+	}
+
+	prevServiceInfo := m.serviceInfo
+	m.serviceInfo = serviceInfo
+	if err := m.setSnapshot(); err != nil {
+		// The snapshot in the cache is unchanged, keep the ServiceInfo in sync with it.
+		m.serviceInfo = prevServiceInfo
+		return err
+	}
+	return nil
+}
+
+func (m *ConfigManager) newServiceInfo(serviceConfig *confpb.Service, configID string) (*configinfo.ServiceInfo, error) {
+	serviceInfo, err := configinfo.NewServiceInfoFromServiceConfig(serviceConfig, configID, m.envoyConfigOptions)
+	if err != nil {
+		return nil, fmt.Errorf("fail to initialize ServiceInfo, %s", err)
 	}
 
 	if m.metadataFetcher != nil {
@@ -221,20 +677,108 @@ func (m *ConfigManager) applyServiceConfig(serviceConfig *confpb.Service) error
 		if err != nil {
 			m.Infof("metadata server was not reached, skipping GCP Attributes")
 		} else {
-			m.serviceInfo.GcpAttributes = attrs
+			serviceInfo.GcpAttributes = attrs
 		}
 	}
+	return serviceInfo, nil
+}
 
+func (m *ConfigManager) setSnapshot() error {
 	snapshot, err := m.makeSnapshot()
 	if err != nil {
 		return fmt.Errorf("fail to make a snapshot, %s", err)
 	}
-	return m.cache.SetSnapshot(m.envoyConfigOptions.Node, *snapshot)
+	m.serverCertMu.Lock()
+	snapshot.Secrets = m.secretResources()
+	m.serverCertMu.Unlock()
+	// Envoy rejects the whole update on any invalid resource, so do not let an
+	// invalid snapshot replace the one currently served.
+	if err := validateSnapshot(snapshot); err != nil {
+		return fmt.Errorf("fail to validate the snapshot, %s", err)
+	}
+	if err := m.cache.SetSnapshot(m.envoyConfigOptions.Node, *snapshot); err != nil {
+		return err
+	}
+	m.recordSnapshot(snapshot)
+	snapshotUpdates.Inc()
+	lastSnapshotUpdate.Set(float64(time.Now().Unix()))
+	return nil
+}
+
+// validateSnapshot checks the snapshot is consistent and all resources in it,
+// including the typed configs of listener filters, pass proto validation.
+func validateSnapshot(snapshot *cache.Snapshot) error {
+	if err := snapshot.Consistent(); err != nil {
+		return err
+	}
+	for _, resources := range []cache.Resources{snapshot.Endpoints, snapshot.Clusters, snapshot.Routes, snapshot.Listeners, snapshot.Secrets, snapshot.Runtimes} {
+		for name, resource := range resources.Items {
+			if err := validateMessage(resource); err != nil {
+				return fmt.Errorf("invalid resource %v: %v", name, err)
+			}
+		}
+		for _, resource := range resources.Items {
+			listener, ok := resource.(*v2pb.Listener)
+			if !ok {
+				continue
+			}
+			for _, filterChain := range listener.GetFilterChains() {
+				for _, filter := range filterChain.GetFilters() {
+					if err := validateTypedConfig(filter.GetName(), filter.GetTypedConfig()); err != nil {
+						return fmt.Errorf("invalid listener %v: %v", listener.GetName(), err)
+					}
+					if filter.GetName() != util.HTTPConnectionManager {
+						continue
+					}
+					httpConMgr := &hcmpb.HttpConnectionManager{}
+					if err := ptypes.UnmarshalAny(filter.GetTypedConfig(), httpConMgr); err != nil {
+						return fmt.Errorf("invalid listener %v: %v", listener.GetName(), err)
+					}
+					for _, httpFilter := range httpConMgr.GetHttpFilters() {
+						if err := validateTypedConfig(httpFilter.GetName(), httpFilter.GetTypedConfig()); err != nil {
+							return fmt.Errorf("invalid listener %v: %v", listener.GetName(), err)
+						}
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func validateTypedConfig(name string, typedConfig *anypb.Any) error {
+	if typedConfig == nil {
+		return nil
+	}
+	config := &ptypes.DynamicAny{}
+	if err := ptypes.UnmarshalAny(typedConfig, config); err != nil {
+		return fmt.Errorf("fail to unmarshal config of filter %v: %v", name, err)
+	}
+	if err := validateMessage(config.Message); err != nil {
+		return fmt.Errorf("invalid config of filter %v: %v", name, err)
+	}
+	return nil
+}
+
+// validateMessage calls the Validate method generated by protoc-gen-validate,
+// if the message has one.
+func validateMessage(msg interface{}) error {
+	if v, ok := msg.(interface{ Validate() error }); ok {
+		return v.Validate()
+	}
+	return nil
 }
 
 func (m *ConfigManager) makeSnapshot() (*cache.Snapshot, error) {
 	m.Infof("making configuration for api: %v", m.serviceInfo.Name)
 
+	if len(m.additionalServices) > 0 {
+		return m.makeMultiServiceSnapshot()
+	}
+	if len(m.trafficSplitConfigs) > 0 {
+		return m.makeTrafficSplitSnapshot()
+	}
+
 	var clusterResources, endpoints, runtimes, routes, listenerResources []cache.Resource
 	clusters, err := gen.MakeClusters(m.serviceInfo)
 	if err != nil {
@@ -245,7 +789,11 @@ func (m *ConfigManager) makeSnapshot() (*cache.Snapshot, error) {
 	}
 
 	m.Infof("adding Listeners configuration for api: %v", m.serviceInfo.Name)
-	listeners, err := gen.MakeListeners(m.serviceInfo)
+	makeListeners := gen.MakeListeners
+	if m.serviceDeleted {
+		makeListeners = gen.MakeServiceDeletedListeners
+	}
+	listeners, err := makeListeners(m.serviceInfo)
 	if err != nil {
 		return nil, err
 	}
@@ -253,7 +801,7 @@ func (m *ConfigManager) makeSnapshot() (*cache.Snapshot, error) {
 		listenerResources = append(listenerResources, lis)
 	}
 
-	snapshot := cache.NewSnapshot(m.curConfigID, endpoints, clusterResources, routes, listenerResources, runtimes)
+	snapshot := cache.NewSnapshot(m.snapshotVersion(), endpoints, clusterResources, routes, listenerResources, runtimes)
 	m.Infof("Envoy Dynamic Configuration is cached for service: %v", m.serviceName)
 	return &snapshot, nil
 }
@@ -264,11 +812,11 @@ func (m *ConfigManager) ID(node *corepb.Node) string {
 
 // Infof implements the Infof method for Log interface.
 func (m *ConfigManager) Infof(format string, args ...interface{}) {
-	glog.Infof(format, args...)
+	logging.Infof(format, args...)
 }
 
 // Errorf implements the Errorf method for Log interface.
-func (m *ConfigManager) Errorf(format string, args ...interface{}) { glog.Errorf(format, args...) }
+func (m *ConfigManager) Errorf(format string, args ...interface{}) { logging.Errorf(format, args...) }
 
 // Cache returns snapshot cache.
 func (m *ConfigManager) Cache() cache.Cache { return m.cache }
@@ -32,21 +32,25 @@ import (
 	"github.com/envoyproxy/go-control-plane/pkg/cache"
 	"github.com/golang/protobuf/jsonpb"
 	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
 
 	pmpb "github.com/GoogleCloudPlatform/esp-v2/src/go/proto/api/envoy/http/path_matcher"
 	scpb "github.com/GoogleCloudPlatform/esp-v2/src/go/proto/api/envoy/http/service_control"
 	v2pb "github.com/envoyproxy/go-control-plane/envoy/api/v2"
 	authpb "github.com/envoyproxy/go-control-plane/envoy/api/v2/auth"
 	corepb "github.com/envoyproxy/go-control-plane/envoy/api/v2/core"
+	listenerpb "github.com/envoyproxy/go-control-plane/envoy/api/v2/listener"
 	grpcstatspb "github.com/envoyproxy/go-control-plane/envoy/config/filter/http/grpc_stats/v2alpha"
 	jwtauthnpb "github.com/envoyproxy/go-control-plane/envoy/config/filter/http/jwt_authn/v2alpha"
 	routerpb "github.com/envoyproxy/go-control-plane/envoy/config/filter/http/router/v2"
 	transcoderpb "github.com/envoyproxy/go-control-plane/envoy/config/filter/http/transcoder/v2"
 	hcmpb "github.com/envoyproxy/go-control-plane/envoy/config/filter/network/http_connection_manager/v2"
+	durationpb "github.com/golang/protobuf/ptypes/duration"
 	wrapperspb "github.com/golang/protobuf/ptypes/wrappers"
 	annotationspb "google.golang.org/genproto/googleapis/api/annotations"
 	confpb "google.golang.org/genproto/googleapis/api/serviceconfig"
 	smpb "google.golang.org/genproto/googleapis/api/servicemanagement/v1"
+	apipb "google.golang.org/genproto/protobuf/api"
 )
 
 const (
@@ -1843,3 +1847,250 @@ var Resolver = FuncResolver(func(url string) (proto.Message, error) {
 		return nil, fmt.Errorf("unexpected protobuf.Any with url: %s", url)
 	}
 })
+
+func TestValidateSnapshot(t *testing.T) {
+	validCluster := &v2pb.Cluster{
+		Name:                 "backend",
+		ClusterDiscoveryType: &v2pb.Cluster_Type{Type: v2pb.Cluster_STRICT_DNS},
+	}
+	invalidRouter, _ := ptypes.MarshalAny(&routerpb.Router{})
+	invalidHttpConMgr, _ := ptypes.MarshalAny(&hcmpb.HttpConnectionManager{
+		StatPrefix: "ingress_http",
+		RouteSpecifier: &hcmpb.HttpConnectionManager_RouteConfig{
+			RouteConfig: &v2pb.RouteConfiguration{},
+		},
+		HttpFilters: []*hcmpb.HttpFilter{
+			{
+				// HttpFilter name must not be empty.
+				ConfigType: &hcmpb.HttpFilter_TypedConfig{TypedConfig: invalidRouter},
+			},
+		},
+	})
+
+	testData := []struct {
+		desc      string
+		clusters  []cache.Resource
+		listeners []cache.Resource
+		wantError string
+	}{
+		{
+			desc:     "Success with valid resources",
+			clusters: []cache.Resource{validCluster},
+		},
+		{
+			desc: "Failure with invalid cluster",
+			clusters: []cache.Resource{
+				&v2pb.Cluster{
+					Name:           "backend",
+					ConnectTimeout: &durationpb.Duration{Seconds: -1},
+				},
+			},
+			wantError: "invalid resource backend",
+		},
+		{
+			desc:     "Failure with invalid HTTP filter in listener",
+			clusters: []cache.Resource{validCluster},
+			listeners: []cache.Resource{
+				&v2pb.Listener{
+					Name: "http_listener",
+					Address: &corepb.Address{
+						Address: &corepb.Address_SocketAddress{
+							SocketAddress: &corepb.SocketAddress{
+								Address:       "0.0.0.0",
+								PortSpecifier: &corepb.SocketAddress_PortValue{PortValue: 8080},
+							},
+						},
+					},
+					FilterChains: []*listenerpb.FilterChain{
+						{
+							Filters: []*listenerpb.Filter{
+								{
+									Name:       util.HTTPConnectionManager,
+									ConfigType: &listenerpb.Filter_TypedConfig{TypedConfig: invalidHttpConMgr},
+								},
+							},
+						},
+					},
+				},
+			},
+			wantError: "invalid listener http_listener",
+		},
+	}
+
+	for _, tc := range testData {
+		snapshot := cache.NewSnapshot("1", nil, tc.clusters, nil, tc.listeners, nil)
+		err := validateSnapshot(&snapshot)
+		if tc.wantError == "" {
+			if err != nil {
+				t.Errorf("Test Desc(%s): validateSnapshot got error: %v", tc.desc, err)
+			}
+		} else if err == nil || !strings.Contains(err.Error(), tc.wantError) {
+			t.Errorf("Test Desc(%s): validateSnapshot got error %v, want error containing %q", tc.desc, err, tc.wantError)
+		}
+	}
+}
+
+// fakeRolloutsFetcher fails to fetch rollouts, and records the fetched configs.
+type fakeRolloutsFetcher struct {
+	fetchedConfigIDs []string
+}
+
+func (f *fakeRolloutsFetcher) FetchConfig(serviceName, configID string) (*confpb.Service, error) {
+	f.fetchedConfigIDs = append(f.fetchedConfigIDs, configID)
+	return nil, fmt.Errorf("unexpected fetch of config %v", configID)
+}
+
+func (f *fakeRolloutsFetcher) FetchRollouts(serviceName string) (*smpb.ListServiceRolloutsResponse, error) {
+	return nil, fmt.Errorf("service management is unavailable")
+}
+
+func TestCheckNewRolloutsWithFetchFailure(t *testing.T) {
+	const rolloutID = "2017-05-01r0-rollout"
+	fetcher := &fakeRolloutsFetcher{}
+	m := &ConfigManager{
+		serviceName:  testProjectName,
+		curRolloutID: rolloutID,
+		curConfigID:  testConfigID,
+		fetcher:      fetcher,
+	}
+
+	m.checkNewRollouts()
+	if m.curRolloutID != rolloutID || m.curConfigID != testConfigID {
+		t.Errorf("got rollout id %v and config id %v, want %v and %v", m.curRolloutID, m.curConfigID, rolloutID, testConfigID)
+	}
+	if len(fetcher.fetchedConfigIDs) != 0 {
+		t.Errorf("got fetched configs %v, want none", fetcher.fetchedConfigIDs)
+	}
+	if m.status.status.LastFetchError == "" {
+		t.Errorf("got no fetch error in status, want the rollouts fetch error")
+	}
+}
+
+// fakeServiceFetcher serves the same service config for all config ids, and
+// the rollouts set by the test.
+type fakeServiceFetcher struct {
+	rollouts *smpb.ListServiceRolloutsResponse
+}
+
+func (f *fakeServiceFetcher) FetchConfig(serviceName, configID string) (*confpb.Service, error) {
+	return &confpb.Service{
+		Name: serviceName,
+		Id:   configID,
+		Apis: []*apipb.Api{
+			{
+				Name: testEndpointName,
+			},
+		},
+	}, nil
+}
+
+func (f *fakeServiceFetcher) FetchRollouts(serviceName string) (*smpb.ListServiceRolloutsResponse, error) {
+	return f.rollouts, nil
+}
+
+func makeTrafficPercentRollout(rolloutID, configID string) *smpb.Rollout {
+	return &smpb.Rollout{
+		RolloutId: rolloutID,
+		Strategy: &smpb.Rollout_TrafficPercentStrategy_{
+			TrafficPercentStrategy: &smpb.Rollout_TrafficPercentStrategy{
+				Percentages: map[string]float64{configID: 100},
+			},
+		},
+	}
+}
+
+func makeDeleteServiceRollout(rolloutID string) *smpb.Rollout {
+	return &smpb.Rollout{
+		RolloutId: rolloutID,
+		Strategy: &smpb.Rollout_DeleteServiceStrategy_{
+			DeleteServiceStrategy: &smpb.Rollout_DeleteServiceStrategy{},
+		},
+	}
+}
+
+func TestCheckNewRolloutsWithDeletedService(t *testing.T) {
+	testData := []struct {
+		desc               string
+		stopServing        bool
+		rollouts           [][]*smpb.Rollout
+		wantRolloutID      string
+		wantConfigID       string
+		wantServiceDeleted bool
+	}{
+		{
+			desc: "Deleted service still served by default",
+			rollouts: [][]*smpb.Rollout{
+				{makeDeleteServiceRollout("2017-05-02r0")},
+			},
+			wantRolloutID: "2017-05-02r0",
+			wantConfigID:  testConfigID,
+		},
+		{
+			desc:        "Deleted service answered with 410 Gone",
+			stopServing: true,
+			rollouts: [][]*smpb.Rollout{
+				{makeDeleteServiceRollout("2017-05-02r0")},
+			},
+			wantRolloutID:      "2017-05-02r0",
+			wantConfigID:       testConfigID,
+			wantServiceDeleted: true,
+		},
+		{
+			desc:        "Recreated service served again with the same config",
+			stopServing: true,
+			rollouts: [][]*smpb.Rollout{
+				{makeDeleteServiceRollout("2017-05-02r0")},
+				{makeTrafficPercentRollout("2017-05-03r0", testConfigID)},
+			},
+			wantRolloutID: "2017-05-03r0",
+			wantConfigID:  testConfigID,
+		},
+	}
+
+	defer func() {
+		*StopServingDeletedService = false
+	}()
+	for _, tc := range testData {
+		*StopServingDeletedService = tc.stopServing
+		opts := options.DefaultConfigGeneratorOptions()
+		opts.BackendAddress = "http://127.0.0.1:8082"
+		fetcher := &fakeServiceFetcher{}
+		m := &ConfigManager{
+			serviceName:        testProjectName,
+			envoyConfigOptions: opts,
+			curRolloutID:       "2017-05-01r0-rollout",
+			curConfigID:        testConfigID,
+			fetcher:            fetcher,
+		}
+		m.cache = cache.NewSnapshotCache(true, m, m)
+		if err := m.updateSnapshot(); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, rollouts := range tc.rollouts {
+			fetcher.rollouts = &smpb.ListServiceRolloutsResponse{Rollouts: rollouts}
+			m.checkNewRollouts()
+		}
+		if m.curRolloutID != tc.wantRolloutID || m.curConfigID != tc.wantConfigID || m.serviceDeleted != tc.wantServiceDeleted {
+			t.Errorf("Test Desc(%s): got rollout id %v, config id %v and deleted %v, want %v, %v and %v", tc.desc,
+				m.curRolloutID, m.curConfigID, m.serviceDeleted, tc.wantRolloutID, tc.wantConfigID, tc.wantServiceDeleted)
+		}
+		if got := m.status.status.Services[0].Deleted; got != tc.wantServiceDeleted {
+			t.Errorf("Test Desc(%s): got deleted %v in status, want %v", tc.desc, got, tc.wantServiceDeleted)
+		}
+
+		snapshot, err := m.cache.GetSnapshot(opts.Node)
+		if err != nil {
+			t.Fatal(err)
+		}
+		httpConMgr := &hcmpb.HttpConnectionManager{}
+		listener := snapshot.Listeners.Items["http_listener"].(*v2pb.Listener)
+		if err := ptypes.UnmarshalAny(listener.GetFilterChains()[0].GetFilters()[0].GetTypedConfig(), httpConMgr); err != nil {
+			t.Fatal(err)
+		}
+		route := httpConMgr.GetRouteConfig().GetVirtualHosts()[0].GetRoutes()[0]
+		if got := route.GetDirectResponse().GetStatus() == http.StatusGone; got != tc.wantServiceDeleted {
+			t.Errorf("Test Desc(%s): got first route %v, want 410 Gone direct response: %v", tc.desc, route, tc.wantServiceDeleted)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package configmanager
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/bootstrap/ads"
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/logging"
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/options"
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/util"
+	"github.com/envoyproxy/go-control-plane/pkg/cache"
+	"github.com/golang/protobuf/jsonpb"
+
+	v2pb "github.com/envoyproxy/go-control-plane/envoy/api/v2"
+	authpb "github.com/envoyproxy/go-control-plane/envoy/api/v2/auth"
+	bootstrappb "github.com/envoyproxy/go-control-plane/envoy/config/bootstrap/v2"
+)
+
+// ValidateWithEnvoy runs the current snapshot through `envoy --mode validate`,
+// in a bootstrap config with its clusters, listeners and secrets as static
+// resources, and returns the errors reported by Envoy.
+func (m *ConfigManager) ValidateWithEnvoy(envoyBinary string) error {
+	snapshot, err := m.cache.GetSnapshot(m.envoyConfigOptions.Node)
+	if err != nil {
+		return fmt.Errorf("fail to get the snapshot: %v", err)
+	}
+	bt, err := makeValidationBootstrap(m.envoyConfigOptions, &snapshot)
+	if err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile("", "envoy-validate-*.json")
+	if err != nil {
+		return fmt.Errorf("fail to create the bootstrap config file: %v", err)
+	}
+	defer os.Remove(f.Name())
+	_, err = f.WriteString(bt)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("fail to write the bootstrap config file: %v", err)
+	}
+
+	logging.Infof("validating the config with %s", envoyBinary)
+	out, err := exec.Command(envoyBinary, "--mode", "validate", "-c", f.Name()).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("Envoy rejects the config: %v\n%s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// makeValidationBootstrap returns the bootstrap config of the ADS
+// bootstrapper with the resources of the snapshot added as static resources.
+func makeValidationBootstrap(opts options.ConfigGeneratorOptions, snapshot *cache.Snapshot) (string, error) {
+	adsOpts := options.DefaultAdsBootstrapperOptions()
+	adsOpts.CommonOptions = opts.CommonOptions
+	adsOpts.DiscoveryAddress = fmt.Sprintf("127.0.0.1:%d", opts.DiscoveryPort)
+	// Tracing is not part of the snapshot, and needs the metadata server.
+	adsOpts.DisableTracing = true
+	adsBootstrap, err := ads.CreateBootstrapConfig(adsOpts)
+	if err != nil {
+		return "", fmt.Errorf("fail to create the bootstrap config: %v", err)
+	}
+	bt := &bootstrappb.Bootstrap{}
+	if err := jsonpb.UnmarshalString(adsBootstrap, bt); err != nil {
+		return "", fmt.Errorf("fail to unmarshal the bootstrap config: %v", err)
+	}
+
+	for _, name := range sortedResourceNames(snapshot.GetResources(cache.ClusterType)) {
+		bt.StaticResources.Clusters = append(bt.StaticResources.Clusters, snapshot.GetResources(cache.ClusterType)[name].(*v2pb.Cluster))
+	}
+	for _, name := range sortedResourceNames(snapshot.GetResources(cache.ListenerType)) {
+		bt.StaticResources.Listeners = append(bt.StaticResources.Listeners, snapshot.GetResources(cache.ListenerType)[name].(*v2pb.Listener))
+	}
+	for _, name := range sortedResourceNames(snapshot.GetResources(cache.SecretType)) {
+		bt.StaticResources.Secrets = append(bt.StaticResources.Secrets, snapshot.GetResources(cache.SecretType)[name].(*authpb.Secret))
+	}
+
+	str, err := util.ProtoToJson(bt)
+	if err != nil {
+		return "", fmt.Errorf("fail to marshal the bootstrap config: %v", err)
+	}
+	return str, nil
+}
+
+func sortedResourceNames(resources map[string]cache.Resource) []string {
+	var names []string
+	for name := range resources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,109 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package configmanager
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/options"
+	"github.com/golang/protobuf/jsonpb"
+
+	bootstrappb "github.com/envoyproxy/go-control-plane/envoy/config/bootstrap/v2"
+)
+
+func TestValidateWithEnvoy(t *testing.T) {
+	flag.Set("service", testProjectName)
+	flag.Set("service_config_id", testConfigID)
+	flag.Set("rollout_strategy", "fixed")
+
+	var err error
+	fakeConfig, err = genFakeConfig(fmt.Sprintf(`{"name":"%s","id":"%s","apis":[{"name":"%s"}]}`, testProjectName, testConfigID, testEndpointName))
+	if err != nil {
+		t.Fatalf("fail to generate fake config: %v", err)
+	}
+	opts := options.DefaultConfigGeneratorOptions()
+	opts.BackendAddress = "http://127.0.0.1:8082"
+
+	dir, err := ioutil.TempDir("", "envoy-validate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// The fake Envoy copies the bootstrap config it validates, and fails with
+	// the given message if it is not empty.
+	gotBootstrapPath := filepath.Join(dir, "bootstrap.json")
+	fakeEnvoy := func(errorMessage string) string {
+		path := filepath.Join(dir, "envoy")
+		script := fmt.Sprintf(`#!/bin/sh
+[ "$1 $2 $3" = "--mode validate -c" ] || exit 2
+cp "$4" %s
+[ -z "%s" ] && exit 0
+echo "%s"
+exit 1
+`, gotBootstrapPath, errorMessage, errorMessage)
+		if err := ioutil.WriteFile(path, []byte(script), 0755); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	testData := []struct {
+		desc         string
+		errorMessage string
+		wantError    string
+	}{
+		{
+			desc: "Envoy accepts the config",
+		},
+		{
+			desc:         "Envoy rejects the config",
+			errorMessage: "Unable to parse JSON as proto",
+			wantError:    "Envoy rejects the config: exit status 1\nUnable to parse JSON as proto",
+		},
+	}
+
+	runTest(t, opts, func(env *testEnv) {
+		for _, tc := range testData {
+			err := env.configManager.ValidateWithEnvoy(fakeEnvoy(tc.errorMessage))
+			if tc.wantError == "" && err != nil || tc.wantError != "" && (err == nil || err.Error() != tc.wantError) {
+				t.Errorf("Test Desc(%s), got error %v, want %q", tc.desc, err, tc.wantError)
+				continue
+			}
+
+			b, err := ioutil.ReadFile(gotBootstrapPath)
+			if err != nil {
+				t.Fatalf("Test Desc(%s), Envoy is not run: %v", tc.desc, err)
+			}
+			bt := &bootstrappb.Bootstrap{}
+			if err := jsonpb.UnmarshalString(string(b), bt); err != nil {
+				t.Fatalf("Test Desc(%s), fail to unmarshal the bootstrap config: %v", tc.desc, err)
+			}
+			// The ADS cluster and the clusters of the snapshot.
+			if len(bt.GetStaticResources().GetClusters()) < 2 || len(bt.GetStaticResources().GetListeners()) == 0 {
+				t.Errorf("Test Desc(%s), got static resources %v, want the resources of the snapshot", tc.desc, bt.GetStaticResources())
+			}
+			if !strings.Contains(string(b), `"adsConfig"`) {
+				t.Errorf("Test Desc(%s), got bootstrap config without ADS config, want the one of the ADS bootstrapper", tc.desc)
+			}
+			os.Remove(gotBootstrapPath)
+		}
+	})
+}
@@ -0,0 +1,277 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package configmanager
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/logging"
+	"github.com/envoyproxy/go-control-plane/pkg/cache"
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+
+	v2pb "github.com/envoyproxy/go-control-plane/envoy/api/v2"
+	corepb "github.com/envoyproxy/go-control-plane/envoy/api/v2/core"
+	discoverygrpc "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v2"
+	xds "github.com/envoyproxy/go-control-plane/pkg/server"
+)
+
+// adsServer serves the state-of-the-world ADS of go-control-plane, and the
+// Delta xDS protocol of the ADS from the same snapshots. On a new snapshot,
+// only the resources of a type which changed since the last response on a
+// delta stream are sent, with the names of the removed ones.
+type adsServer struct {
+	discoverygrpc.AggregatedDiscoveryServiceServer
+	m        *ConfigManager
+	ctx      context.Context
+	streamID int64
+}
+
+// NewAdsServer returns the ADS server of Envoy, with the Delta xDS protocol
+// used by Envoy with --ads_delta in the bootstrap.
+func (m *ConfigManager) NewAdsServer(ctx context.Context) discoverygrpc.AggregatedDiscoveryServiceServer {
+	return &adsServer{
+		AggregatedDiscoveryServiceServer: xds.NewServer(ctx, m.cache, m),
+		m:                                m,
+		ctx:                              ctx,
+		// Stream ids of the state-of-the-world streams are counted from 1.
+		streamID: 1 << 32,
+	}
+}
+
+// deltaWatch stores the state of a resource type on a delta stream.
+type deltaWatch struct {
+	// Cancels the open watch of the cache.
+	cancel func()
+	stop   chan struct{}
+	// Cache version of the last response, the watch waits for a newer one.
+	version string
+	// If true, all the resources of the type are subscribed, otherwise only
+	// the subscribed ones.
+	wildcard   bool
+	subscribed map[string]bool
+	// Versions of the resources sent to Envoy, by name.
+	sent map[string]string
+	// If true, a response is sent even if no resource has changed.
+	initial bool
+}
+
+func (w *deltaWatch) close() {
+	if w.stop != nil {
+		close(w.stop)
+		w.stop = nil
+	}
+	if w.cancel != nil {
+		w.cancel()
+		w.cancel = nil
+	}
+}
+
+// deltaResponse is a response of the cache for a resource type.
+type deltaResponse struct {
+	typeURL  string
+	response cache.Response
+}
+
+// DeltaAggregatedResources implements the Delta xDS protocol of the ADS.
+func (s *adsServer) DeltaAggregatedResources(stream discoverygrpc.AggregatedDiscoveryService_DeltaAggregatedResourcesServer) error {
+	streamID := atomic.AddInt64(&s.streamID, 1)
+	if err := s.m.OnStreamOpen(stream.Context(), streamID, ""); err != nil {
+		return err
+	}
+	defer s.m.OnStreamClosed(streamID)
+
+	requests := make(chan *v2pb.DeltaDiscoveryRequest)
+	recvErr := make(chan error, 1)
+	go func() {
+		for {
+			req, err := stream.Recv()
+			if err != nil {
+				recvErr <- err
+				return
+			}
+			select {
+			case requests <- req:
+			case <-stream.Context().Done():
+				return
+			}
+		}
+	}()
+
+	responses := make(chan deltaResponse)
+	watches := make(map[string]*deltaWatch)
+	defer func() {
+		for _, w := range watches {
+			w.close()
+		}
+	}()
+	var node *corepb.Node
+	var nonce int64
+
+	// watch opens a watch of the cache for the resources of a type newer than
+	// the last response.
+	watch := func(typeURL string, w *deltaWatch) {
+		w.close()
+		response, cancel := s.m.cache.CreateWatch(cache.Request{
+			Node:        node,
+			TypeUrl:     typeURL,
+			VersionInfo: w.version,
+		})
+		stop := make(chan struct{})
+		w.cancel, w.stop = cancel, stop
+		go func() {
+			select {
+			case r, ok := <-response:
+				if !ok {
+					return
+				}
+				select {
+				case responses <- deltaResponse{typeURL: typeURL, response: r}:
+				case <-stop:
+				}
+			case <-stop:
+			}
+		}()
+	}
+
+	for {
+		select {
+		case <-s.ctx.Done():
+			return nil
+		case err := <-recvErr:
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		case req := <-requests:
+			// Only the first request of a stream is required to set the node.
+			if req.GetNode() != nil {
+				node = req.GetNode()
+			}
+			if req.GetErrorDetail() != nil {
+				logging.Warningf("Envoy rejected the %s resources of nonce %s: %s", req.GetTypeUrl(), req.GetResponseNonce(), req.GetErrorDetail().GetMessage())
+			}
+			w, ok := watches[req.GetTypeUrl()]
+			if !ok {
+				w = &deltaWatch{
+					// Envoy subscribes to all the listeners and clusters.
+					wildcard:   len(req.GetResourceNamesSubscribe()) == 0 && (req.GetTypeUrl() == cache.ListenerType || req.GetTypeUrl() == cache.ClusterType),
+					subscribed: make(map[string]bool),
+					sent:       make(map[string]string),
+					initial:    true,
+				}
+				// The resources Envoy already has after a reconnection.
+				for name, version := range req.GetInitialResourceVersions() {
+					w.sent[name] = version
+				}
+				watches[req.GetTypeUrl()] = w
+			} else if len(req.GetResourceNamesSubscribe()) == 0 && len(req.GetResourceNamesUnsubscribe()) == 0 {
+				// ACK or NACK of a response, the watch is still open.
+				continue
+			}
+			for _, name := range req.GetResourceNamesSubscribe() {
+				w.subscribed[name] = true
+			}
+			for _, name := range req.GetResourceNamesUnsubscribe() {
+				delete(w.subscribed, name)
+				delete(w.sent, name)
+			}
+			// The new subscriptions are sent from the current snapshot.
+			w.version = ""
+			watch(req.GetTypeUrl(), w)
+		case r := <-responses:
+			w, ok := watches[r.typeURL]
+			if !ok {
+				continue
+			}
+			resp, err := w.diff(r.typeURL, r.response)
+			if err != nil {
+				return err
+			}
+			w.version = r.response.Version
+			if len(resp.Resources) != 0 || len(resp.RemovedResources) != 0 || w.initial {
+				nonce++
+				resp.Nonce = strconv.FormatInt(nonce, 10)
+				if err := stream.Send(resp); err != nil {
+					return err
+				}
+				s.m.recordPush()
+				w.initial = false
+			}
+			watch(r.typeURL, w)
+		}
+	}
+}
+
+// diff returns the delta response of the resources in the response of the
+// cache which changed since the last response, and the names of the removed
+// ones, and records them as sent.
+func (w *deltaWatch) diff(typeURL string, response cache.Response) (*v2pb.DeltaDiscoveryResponse, error) {
+	resp := &v2pb.DeltaDiscoveryResponse{
+		SystemVersionInfo: response.Version,
+		TypeUrl:           typeURL,
+	}
+	current := make(map[string]bool)
+	for _, resource := range response.Resources {
+		name := cache.GetResourceName(resource)
+		if !w.wildcard && !w.subscribed[name] {
+			continue
+		}
+		current[name] = true
+		version, err := resourceVersion(resource)
+		if err != nil {
+			return nil, err
+		}
+		if w.sent[name] == version {
+			continue
+		}
+		a, err := ptypes.MarshalAny(resource)
+		if err != nil {
+			return nil, fmt.Errorf("fail to marshal resource %s: %v", name, err)
+		}
+		resp.Resources = append(resp.Resources, &v2pb.Resource{
+			Name:     name,
+			Version:  version,
+			Resource: a,
+		})
+		w.sent[name] = version
+	}
+	for name := range w.sent {
+		if !current[name] {
+			resp.RemovedResources = append(resp.RemovedResources, name)
+			delete(w.sent, name)
+		}
+	}
+	return resp, nil
+}
+
+// resourceVersion returns the version of a resource, the hash of its
+// deterministic serialization, so that unchanged resources keep their version
+// across snapshots.
+func resourceVersion(resource cache.Resource) (string, error) {
+	b := proto.NewBuffer(nil)
+	b.SetDeterministic(true)
+	if err := b.Marshal(resource); err != nil {
+		return "", fmt.Errorf("fail to marshal resource %s: %v", cache.GetResourceName(resource), err)
+	}
+	h := sha256.Sum256(b.Bytes())
+	return hex.EncodeToString(h[:8]), nil
+}
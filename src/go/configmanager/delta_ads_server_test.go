@@ -0,0 +1,151 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package configmanager
+
+import (
+	"context"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/envoyproxy/go-control-plane/pkg/cache"
+	"github.com/golang/protobuf/ptypes"
+	"google.golang.org/grpc"
+
+	v2pb "github.com/envoyproxy/go-control-plane/envoy/api/v2"
+	corepb "github.com/envoyproxy/go-control-plane/envoy/api/v2/core"
+)
+
+// fakeDeltaStream is a Delta ADS stream of Envoy.
+type fakeDeltaStream struct {
+	grpc.ServerStream
+	ctx       context.Context
+	requests  chan *v2pb.DeltaDiscoveryRequest
+	responses chan *v2pb.DeltaDiscoveryResponse
+}
+
+func (s *fakeDeltaStream) Context() context.Context { return s.ctx }
+
+func (s *fakeDeltaStream) Send(resp *v2pb.DeltaDiscoveryResponse) error {
+	s.responses <- resp
+	return nil
+}
+
+func (s *fakeDeltaStream) Recv() (*v2pb.DeltaDiscoveryRequest, error) {
+	req, ok := <-s.requests
+	if !ok {
+		return nil, io.EOF
+	}
+	return req, nil
+}
+
+func TestDeltaAggregatedResources(t *testing.T) {
+	node := &corepb.Node{Id: "test-node"}
+	cluster := func(name, connectTimeout string) cache.Resource {
+		d, _ := time.ParseDuration(connectTimeout)
+		return &v2pb.Cluster{Name: name, ConnectTimeout: ptypes.DurationProto(d)}
+	}
+
+	testData := []struct {
+		desc         string
+		clusters     []cache.Resource
+		wantNames    []string
+		wantRemoved  []string
+		wantResponse bool
+	}{
+		{
+			desc:         "all the clusters are sent on the initial request",
+			clusters:     []cache.Resource{cluster("a", "1s"), cluster("b", "1s")},
+			wantNames:    []string{"a", "b"},
+			wantResponse: true,
+		},
+		{
+			desc:         "only the added clusters and the names of the removed ones are sent",
+			clusters:     []cache.Resource{cluster("a", "1s"), cluster("c", "1s")},
+			wantNames:    []string{"c"},
+			wantRemoved:  []string{"b"},
+			wantResponse: true,
+		},
+		{
+			desc:         "nothing is sent if no cluster has changed",
+			clusters:     []cache.Resource{cluster("a", "1s"), cluster("c", "1s")},
+			wantResponse: false,
+		},
+		{
+			desc:         "only the changed clusters are sent",
+			clusters:     []cache.Resource{cluster("a", "2s"), cluster("c", "1s")},
+			wantNames:    []string{"a"},
+			wantResponse: true,
+		},
+	}
+
+	m := &ConfigManager{}
+	m.cache = cache.NewSnapshotCache(true, m, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream := &fakeDeltaStream{
+		ctx:       ctx,
+		requests:  make(chan *v2pb.DeltaDiscoveryRequest, 1),
+		responses: make(chan *v2pb.DeltaDiscoveryResponse, 1),
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- m.NewAdsServer(ctx).(*adsServer).DeltaAggregatedResources(stream)
+	}()
+
+	for i, tc := range testData {
+		snapshot := cache.NewSnapshot(strconv.Itoa(i+1), nil, tc.clusters, nil, nil, nil)
+		if err := m.cache.SetSnapshot(node.Id, snapshot); err != nil {
+			t.Fatal(err)
+		}
+		if i == 0 {
+			stream.requests <- &v2pb.DeltaDiscoveryRequest{Node: node, TypeUrl: cache.ClusterType}
+		}
+
+		select {
+		case resp := <-stream.responses:
+			if !tc.wantResponse {
+				t.Errorf("Test Desc(%d): %s, got unexpected response %v", i, tc.desc, resp)
+				continue
+			}
+			var gotNames []string
+			for _, r := range resp.Resources {
+				gotNames = append(gotNames, r.Name)
+			}
+			sort.Strings(gotNames)
+			sort.Strings(resp.RemovedResources)
+			if !reflect.DeepEqual(gotNames, tc.wantNames) {
+				t.Errorf("Test Desc(%d): %s, got resources %v, want %v", i, tc.desc, gotNames, tc.wantNames)
+			}
+			if !reflect.DeepEqual(resp.RemovedResources, tc.wantRemoved) {
+				t.Errorf("Test Desc(%d): %s, got removed resources %v, want %v", i, tc.desc, resp.RemovedResources, tc.wantRemoved)
+			}
+			// ACK the response.
+			stream.requests <- &v2pb.DeltaDiscoveryRequest{TypeUrl: cache.ClusterType, ResponseNonce: resp.Nonce}
+		case <-time.After(200 * time.Millisecond):
+			if tc.wantResponse {
+				t.Errorf("Test Desc(%d): %s, got no response", i, tc.desc)
+			}
+		}
+	}
+
+	close(stream.requests)
+	if err := <-done; err != nil {
+		t.Errorf("the stream is closed with error: %v", err)
+	}
+}
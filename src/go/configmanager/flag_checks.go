@@ -0,0 +1,92 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package configmanager
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/configmanager/flags"
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/options"
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/util"
+)
+
+// CheckFlags adds the problems of the Config Manager flags to errs, which
+// would otherwise only be found one at a time when the service config is
+// fetched.
+func CheckFlags(opts options.ConfigGeneratorOptions, errs *flags.Errors) {
+	switch *RolloutStrategy {
+	case "", util.FixedRolloutStrategy, util.ManagedRolloutStrategy:
+	default:
+		errs.Addf(`Use "fixed" to serve --service_config_id, or "managed" to follow the rollouts of the service.`, "invalid --rollout_strategy %q", *RolloutStrategy)
+	}
+	if *RolloutTrafficSplit && *RolloutStrategy != util.ManagedRolloutStrategy {
+		errs.Addf(`Set --rollout_strategy=managed.`, "--rollout_traffic_split requires the managed rollout strategy")
+	}
+	switch *ServiceManagementTransport {
+	case restTransport, grpcTransport:
+	default:
+		errs.Addf(`Use either "rest" or "grpc".`, "invalid --service_management_transport %q", *ServiceManagementTransport)
+	}
+
+	if *ServicePath != "" && *OpenAPISpecPath != "" {
+		errs.Addf("Keep only one of them.", "--service_json_path and --openapi_spec_path cannot both be set")
+	}
+	errs.CheckReadable("service_json_path", *ServicePath)
+	errs.CheckReadable("openapi_spec_path", *OpenAPISpecPath)
+	// The service config is not fetched from the source with a local file.
+	if *ServicePath != "" || *OpenAPISpecPath != "" {
+		return
+	}
+
+	switch *ServiceConfigSource {
+	case serviceManagementSource:
+	case gcsSource:
+		if u, err := url.Parse(*ServiceConfigGCSPath); err != nil || u.Scheme != "gs" || u.Host == "" {
+			errs.Addf(`Set it in the form of "gs://bucket/path".`, "invalid --service_config_gcs_path %q with --service_config_source=%s", *ServiceConfigGCSPath, gcsSource)
+		}
+		errs.CheckURL("gcs_url", *GCSURL, "https", "http")
+	case configMapSource:
+		if parts := strings.Split(*ServiceConfigConfigMap, "/"); len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			errs.Addf(`Set it in the form of "namespace/name".`, "invalid --service_config_configmap %q with --service_config_source=%s", *ServiceConfigConfigMap, configMapSource)
+		}
+	case httpsSource:
+		if *ServiceConfigURL == "" {
+			errs.Addf("Set it to the URL of the service config, with $serviceName and $configId placeholders.", "--service_config_url is required with --service_config_source=%s", httpsSource)
+		}
+		errs.CheckURL("service_config_url", *ServiceConfigURL, "https", "http")
+		errs.CheckURL("service_config_rollouts_url", *ServiceConfigRolloutsURL, "https", "http")
+		if _, err := util.ParseHeaders(*ServiceConfigHeaders); err != nil {
+			errs.Addf("Set it to comma separated name=value headers.", "invalid --service_config_headers: %v", err)
+		}
+	default:
+		errs.Addf(fmt.Sprintf(`Use one of "%s", "%s", "%s" or "%s".`, serviceManagementSource, gcsSource, configMapSource, httpsSource), "invalid --service_config_source %q", *ServiceConfigSource)
+	}
+
+	// Service Management and Cloud Storage are called with the access tokens
+	// of the metadata server, which does not exist outside of GCP.
+	if opts.Standalone && (*ServiceConfigSource == serviceManagementSource || *ServiceConfigSource == gcsSource) {
+		errs.Addf(fmt.Sprintf(`Read the service config from --service_json_path or --openapi_spec_path, or use --service_config_source=%s or %s.`, configMapSource, httpsSource), "--standalone can not fetch the service config from %s", *ServiceConfigSource)
+	} else if opts.NonGCP && opts.ServiceAccountKey == "" && (*ServiceConfigSource == serviceManagementSource || *ServiceConfigSource == gcsSource) {
+		errs.Addf("Set --service_account_key to a service account key JSON file, or read the service config from --service_json_path.", "--non_gcp requires --service_account_key to fetch the service config from %s", *ServiceConfigSource)
+	}
+	if *RolloutNotificationSubscription != "" {
+		if opts.Standalone {
+			errs.Addf("Remove it, the rollouts can not be notified without Pub/Sub.", "--rollout_notification_subscription can not be used with --standalone")
+		}
+		errs.CheckURL("pubsub_url", *PubsubURL, "https", "http")
+	}
+}
@@ -0,0 +1,138 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package configmanager
+
+import (
+	"flag"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/configmanager/flags"
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/options"
+)
+
+func TestCheckFlags(t *testing.T) {
+	testData := []struct {
+		desc       string
+		flags      map[string]string
+		nonGCP     bool
+		standalone bool
+		wantError  string
+	}{
+		{
+			desc: "valid managed rollout from Service Management",
+			flags: map[string]string{
+				"rollout_strategy": "managed",
+			},
+		},
+		{
+			desc: "all the problems are reported",
+			flags: map[string]string{
+				"rollout_strategy":             "latest",
+				"service_management_transport": "http2",
+				"service_config_source":        "s3",
+			},
+			wantError: `found 3 problem(s) in the flags:
+  - invalid --rollout_strategy "latest". Use "fixed" to serve --service_config_id, or "managed" to follow the rollouts of the service.
+  - invalid --service_management_transport "http2". Use either "rest" or "grpc".
+  - invalid --service_config_source "s3". Use one of "servicemanagement", "gcs", "configmap" or "https".`,
+		},
+		{
+			desc: "missing and invalid https source flags",
+			flags: map[string]string{
+				"service_config_source":       "https",
+				"service_config_rollouts_url": "configs/rollouts",
+			},
+			wantError: `found 2 problem(s) in the flags:
+  - --service_config_url is required with --service_config_source=https. Set it to the URL of the service config, with $serviceName and $configId placeholders.
+  - --service_config_rollouts_url is not a valid URL: "configs/rollouts". Set --service_config_rollouts_url to an absolute URL like https://host:port/path.`,
+		},
+		{
+			desc: "invalid gcs path",
+			flags: map[string]string{
+				"service_config_source":   "gcs",
+				"service_config_gcs_path": "bucket/path",
+			},
+			wantError: `found 1 problem(s) in the flags:
+  - invalid --service_config_gcs_path "bucket/path" with --service_config_source=gcs. Set it in the form of "gs://bucket/path".`,
+		},
+		{
+			desc:   "non GCP without service account key",
+			nonGCP: true,
+			wantError: `found 1 problem(s) in the flags:
+  - --non_gcp requires --service_account_key to fetch the service config from servicemanagement. Set --service_account_key to a service account key JSON file, or read the service config from --service_json_path.`,
+		},
+		{
+			desc:   "non GCP with a local service config",
+			nonGCP: true,
+			flags: map[string]string{
+				"service_json_path": "testdata/service_config_for_dynamic_routing.json",
+			},
+		},
+		{
+			desc:       "standalone from Service Management",
+			standalone: true,
+			wantError: `found 1 problem(s) in the flags:
+  - --standalone can not fetch the service config from servicemanagement. Read the service config from --service_json_path or --openapi_spec_path, or use --service_config_source=configmap or https.`,
+		},
+		{
+			desc:       "standalone with a local service config",
+			standalone: true,
+			flags: map[string]string{
+				"service_json_path": "testdata/service_config_for_dynamic_routing.json",
+			},
+		},
+		{
+			desc:       "standalone with a rollout notification subscription",
+			standalone: true,
+			flags: map[string]string{
+				"service_config_source":             "configmap",
+				"service_config_configmap":          "default/endpoints",
+				"rollout_notification_subscription": "projects/p/subscriptions/s",
+			},
+			wantError: `found 1 problem(s) in the flags:
+  - --rollout_notification_subscription can not be used with --standalone. Remove it, the rollouts can not be notified without Pub/Sub.`,
+		},
+		{
+			desc: "both local service configs",
+			flags: map[string]string{
+				"service_json_path": "testdata/service_config_for_dynamic_routing.json",
+				"openapi_spec_path": "testdata/missing.yaml",
+			},
+			wantError: `found 2 problem(s) in the flags:
+  - --service_json_path and --openapi_spec_path cannot both be set. Keep only one of them.
+  - --openapi_spec_path: cannot read testdata/missing.yaml: no such file or directory. Check the path and that the file is mounted and readable by the proxy.`,
+		},
+	}
+
+	for _, tc := range testData {
+		for name, value := range tc.flags {
+			flag.Set(name, value)
+		}
+		opts := options.DefaultConfigGeneratorOptions()
+		opts.NonGCP = tc.nonGCP
+		opts.Standalone = tc.standalone
+
+		errs := &flags.Errors{}
+		CheckFlags(opts, errs)
+		err := errs.Err()
+		if tc.wantError == "" && err != nil || tc.wantError != "" && (err == nil || err.Error() != tc.wantError) {
+			t.Errorf("Test Desc(%s), got error %v, want %q", tc.desc, err, tc.wantError)
+		}
+
+		for name := range tc.flags {
+			flag.Set(name, flag.Lookup(name).DefValue)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flags
+
+import (
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Errors collects the problems found in the flags at startup, so that all of
+// them are reported at once instead of failing on the first one.
+type Errors struct {
+	problems []string
+}
+
+// Addf adds a problem, followed by the hint to fix it if not empty.
+func (e *Errors) Addf(hint, format string, args ...interface{}) {
+	problem := fmt.Sprintf(format, args...)
+	if hint != "" {
+		problem = fmt.Sprintf("%s. %s", problem, hint)
+	}
+	e.problems = append(e.problems, problem)
+}
+
+// Err returns an error listing all the problems, or nil if there is none.
+func (e *Errors) Err() error {
+	if len(e.problems) == 0 {
+		return nil
+	}
+	return fmt.Errorf("found %d problem(s) in the flags:\n  - %s", len(e.problems), strings.Join(e.problems, "\n  - "))
+}
+
+// CheckURL adds a problem if the value of the flag is set and is not an
+// absolute URL with one of the schemes.
+func (e *Errors) CheckURL(name, value string, schemes ...string) {
+	if value == "" {
+		return
+	}
+	u, err := url.Parse(value)
+	if err != nil || u.Host == "" {
+		e.Addf(fmt.Sprintf("Set --%s to an absolute URL like %s://host:port/path.", name, schemes[0]), "--%s is not a valid URL: %q", name, value)
+		return
+	}
+	for _, scheme := range schemes {
+		if u.Scheme == scheme {
+			return
+		}
+	}
+	e.Addf(fmt.Sprintf("Use one of the schemes %s.", strings.Join(schemes, ", ")), "--%s has the unsupported scheme %q", name, u.Scheme)
+}
+
+// CheckReadable adds a problem if the files of the flag cannot be read. The
+// files are the path of the flag joined with names, or the path itself if
+// there is no name. The default value of the flag is not checked, as it may
+// not be used.
+func (e *Errors) CheckReadable(name, path string, names ...string) {
+	if path == "" || (flag.Lookup(name) != nil && flag.Lookup(name).DefValue == path) {
+		return
+	}
+	if len(names) == 0 {
+		names = []string{""}
+	}
+	for _, n := range names {
+		file := filepath.Join(path, n)
+		f, err := os.Open(file)
+		if err != nil {
+			e.Addf("Check the path and that the file is mounted and readable by the proxy.", "--%s: cannot read %s: %v", name, file, unwrapPathError(err))
+			continue
+		}
+		f.Close()
+	}
+}
+
+func unwrapPathError(err error) error {
+	if pathErr, ok := err.(*os.PathError); ok {
+		return pathErr.Err
+	}
+	return err
+}
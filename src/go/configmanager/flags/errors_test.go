@@ -0,0 +1,104 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flags
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/util"
+)
+
+func TestErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "flags")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "server.crt"), []byte("cert"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	testData := []struct {
+		desc      string
+		check     func(errs *Errors)
+		wantError string
+	}{
+		{
+			desc:  "no problem",
+			check: func(errs *Errors) {},
+		},
+		{
+			desc: "problems with and without hints",
+			check: func(errs *Errors) {
+				errs.Addf("", "--a is invalid")
+				errs.Addf("Set it to 1.", "--b is %d", 2)
+			},
+			wantError: "found 2 problem(s) in the flags:\n  - --a is invalid\n  - --b is 2. Set it to 1.",
+		},
+		{
+			desc: "valid and empty URLs",
+			check: func(errs *Errors) {
+				errs.CheckURL("metadata_url", "http://169.254.169.254/computeMetadata", "http", "https")
+				errs.CheckURL("iam_url", "", "https")
+			},
+		},
+		{
+			desc: "relative URL",
+			check: func(errs *Errors) {
+				errs.CheckURL("iam_url", "iamcredentials.googleapis.com", "https")
+			},
+			wantError: "found 1 problem(s) in the flags:\n  - --iam_url is not a valid URL: \"iamcredentials.googleapis.com\". Set --iam_url to an absolute URL like https://host:port/path.",
+		},
+		{
+			desc: "URL with an unsupported scheme",
+			check: func(errs *Errors) {
+				errs.CheckURL("iam_url", "ftp://iamcredentials.googleapis.com", "https", "http")
+			},
+			wantError: "found 1 problem(s) in the flags:\n  - --iam_url has the unsupported scheme \"ftp\". Use one of the schemes https, http.",
+		},
+		{
+			desc: "readable and empty paths",
+			check: func(errs *Errors) {
+				errs.CheckReadable("backend_ca_path", filepath.Join(dir, "server.crt"))
+				errs.CheckReadable("ssl_server_client_ca_path", "")
+			},
+		},
+		{
+			desc: "missing file in the directory",
+			check: func(errs *Errors) {
+				errs.CheckReadable("ssl_server_cert_path", dir, "server.crt", "server.key")
+			},
+			wantError: "found 1 problem(s) in the flags:\n  - --ssl_server_cert_path: cannot read " + filepath.Join(dir, "server.key") + ": no such file or directory. Check the path and that the file is mounted and readable by the proxy.",
+		},
+		{
+			desc: "default path is not checked",
+			check: func(errs *Errors) {
+				errs.CheckReadable("root_certs_path", util.DefaultRootCAPaths)
+			},
+		},
+	}
+
+	for _, tc := range testData {
+		errs := &Errors{}
+		tc.check(errs)
+		err := errs.Err()
+		if tc.wantError == "" && err != nil || tc.wantError != "" && (err == nil || err.Error() != tc.wantError) {
+			t.Errorf("Test Desc(%s), got error %v, want %q", tc.desc, err, tc.wantError)
+		}
+	}
+}
@@ -17,15 +17,27 @@
 package flags
 
 import (
+	"encoding/json"
 	"flag"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net"
+	"strings"
 	"time"
 
 	"github.com/GoogleCloudPlatform/esp-v2/src/go/commonflags"
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/logging"
 	"github.com/GoogleCloudPlatform/esp-v2/src/go/options"
 	"github.com/GoogleCloudPlatform/esp-v2/src/go/util"
-	"github.com/golang/glog"
 )
 
+// Value of --kubernetes_namespace for the namespace of the pod.
+const autoKubernetesNamespace = "auto"
+
+// File of the namespace of the pod, mounted with its service account token.
+var kubernetesNamespacePath = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
 var (
 	// These flags are kept in sync with options.ConfigGeneratorOptions.
 	// When adding or changing default values, update options.DefaultConfigGeneratorOptions.
@@ -38,33 +50,311 @@ var (
 	CorsAllowOriginRegex = flag.String("cors_allow_origin_regex", "", "set Access-Control-Allow-Origin to a regular expression")
 	CorsExposeHeaders    = flag.String("cors_expose_headers", "", "set Access-Control-Expose-Headers to the specified headers")
 	CorsPreset           = flag.String("cors_preset", "", `enable CORS support, must be either "basic" or "cors_with_regex"`)
+	CorsPoliciesConfig   = flag.String("cors_policies_config", "", `Path to a JSON file with a list of CORS policies, each with the "selector" of an
+	operation, or "*" for all operations without their own, "allow_origin_regexes" in RE2 syntax, and optional "allow_methods", "allow_headers",
+	"expose_headers", "max_age" in seconds and "allow_credentials", applied to the routes of the operations and to the preflight requests to their
+	paths. The empty fields fall back to the ones of --cors_preset, if set. The policies override the x-google-cors extension of the same selectors.`)
+
+	CorsPreflightOperations = flag.Bool("cors_preflight_operations", false, `If true, an OPTIONS operation is generated for the paths of all the operations when CORS is
+	enabled by --cors_preset or --cors_policies_config, like with allow_cors in the endpoints of the service config, so the preflight requests
+	not answered by the CORS policy are not rejected as unknown operations.`)
+	CorsPreflightRequireAuth = flag.Bool("cors_preflight_require_auth", false, `If true, the generated OPTIONS operations require the JWT and API key of the first operation
+	of their path, instead of being exempt from authentication. Browsers do not send credentials in preflight requests.`)
+	CorsPreflightSkipServiceControl = flag.Bool("cors_preflight_skip_service_control", false, `If true, the requests of the generated OPTIONS operations are not checked nor reported
+	to service control.`)
 
 	// Backend routing configurations.
 	BackendDnsLookupFamily = flag.String("backend_dns_lookup_family", "auto", `Define the dns lookup family for all backends. The options are "auto", "v4only" and "v6only". The default is "auto".`)
+	BackendDnsRefreshRateS = flag.Int("backend_dns_refresh_rate_s", 0, `The rate in seconds the backends are resolved with DNS again. 0 uses the default of Envoy, 5 seconds.`)
+	BackendDnsRespectTtl   = flag.Bool("backend_dns_respect_ttl", false, `If true, the backends are resolved again when their DNS records expire, instead of every --backend_dns_refresh_rate_s.`)
+	BackendStrictDns       = flag.Bool("backend_strict_dns", false, `If true, the requests to a backend are balanced between all the addresses its hostname resolves to (STRICT_DNS), instead of
+	connecting to one address at a time (LOGICAL_DNS), e.g. for on-prem backends behind DNS round robin.`)
+	BackendEdsServerUri = flag.String("backend_eds_server_uri", "", `Uri of an xDS server, "grpc://" or "grpcs://", like grpcs://trafficdirector.googleapis.com:443, the endpoints of the backend in --backend_address
+	are discovered from with EDS, instead of DNS, so they are balanced with the localities and health status of the external control plane. The Envoy node in --node must be accepted by the server.`)
+	BackendEdsServiceName       = flag.String("backend_eds_service_name", "", `The service name of the endpoints of the backend asked to --backend_eds_server_uri.`)
+	BackendEdsGoogleCredentials = flag.Bool("backend_eds_google_credentials", false, `If true, --backend_eds_server_uri is called with the access tokens of the metadata server, as required by Traffic Director.`)
+	KubernetesNamespace         = flag.String("kubernetes_namespace", "", `If set, the backends with a single-label hostname, like http://bookstore:8080, are the Kubernetes services of this namespace,
+	resolved as {hostname}.{namespace}.svc.{--kubernetes_cluster_domain}. Set to "auto" for the namespace of the pod, read from its service account.`)
+	KubernetesClusterDomain = flag.String("kubernetes_cluster_domain", "cluster.local", `DNS domain of the Kubernetes cluster, used with --kubernetes_namespace.`)
+
+	BackendMaxConnections     = flag.Uint("backend_max_connections", 0, `Maximum number of connections to each backend, 0 for the default of Envoy, 1024.`)
+	BackendMaxPendingRequests = flag.Uint("backend_max_pending_requests", 0, `Maximum number of requests to each backend waiting for a connection, 0 for the default of Envoy, 1024.
+	The requests over the limit fail with 503.`)
+	BackendMaxRequests = flag.Uint("backend_max_requests", 0, `Maximum number of active requests to each HTTP/2 or gRPC backend, 0 for the default of Envoy, 1024.
+	The requests over the limit fail with 503.`)
+	BackendMaxRetries                = flag.Uint("backend_max_retries", 0, `Maximum number of active retries to each backend, 0 for the default of Envoy, 3.`)
+	BackendOutlierConsecutive5xx     = flag.Uint("backend_outlier_consecutive_5xx", 0, `Number of consecutive 5xx responses after which an instance of a backend is ejected from the load balancing, 0 to disable the outlier detection.`)
+	BackendOutlierIntervalS          = flag.Int("backend_outlier_interval_s", 0, `Time in seconds between the ejection sweeps of the outlier detection, 0 for the default of Envoy, 10 seconds.`)
+	BackendOutlierBaseEjectionTimeS  = flag.Int("backend_outlier_base_ejection_time_s", 0, `Base time in seconds an instance is ejected for, multiplied by the number of times it was ejected, 0 for the default of Envoy, 30 seconds.`)
+	BackendOutlierMaxEjectionPercent = flag.Uint("backend_outlier_max_ejection_percent", 0, `Maximum percent of the instances of a backend which can be ejected, 0 for the default of Envoy, 10%.`)
+
+	BackendHealthCheckPath = flag.String("backend_health_check_path", "", `If set, the instances of each HTTP backend are checked with GET requests to this path, and the failing
+	instances stop receiving requests.`)
+	BackendHealthCheckGrpc = flag.Bool("backend_health_check_grpc", false, `If true, the instances of each gRPC backend are checked with the grpc.health.v1.Health service, and the failing
+	instances stop receiving requests.`)
+	BackendHealthCheckGrpcService        = flag.String("backend_health_check_grpc_service", "", `Service checked by --backend_health_check_grpc, empty for the whole server.`)
+	BackendHealthCheckIntervalS          = flag.Int("backend_health_check_interval_s", 5, `Time in seconds between the health checks of the backends.`)
+	BackendHealthCheckTimeoutS           = flag.Int("backend_health_check_timeout_s", 1, `Time in seconds after which a health check of a backend fails.`)
+	BackendHealthCheckHealthyThreshold   = flag.Uint("backend_health_check_healthy_threshold", 2, `Number of health checks in a row an unhealthy instance must pass to receive requests again.`)
+	BackendHealthCheckUnhealthyThreshold = flag.Uint("backend_health_check_unhealthy_threshold", 3, `Number of health checks in a row an instance must fail to stop receiving requests.`)
+
+	BackendMaxConcurrentStreams        = flag.Uint("backend_max_concurrent_streams", 0, `Maximum number of concurrent streams on each connection to the gRPC and HTTP/2 backends, 0 for the default of Envoy, 2147483647.`)
+	BackendInitialStreamWindowSize     = flag.Uint("backend_initial_stream_window_size", 0, `Initial HTTP/2 window size in bytes of the streams to the gRPC and HTTP/2 backends, from 65535 to 2147483647, 0 for the default of Envoy, 256MiB.`)
+	BackendInitialConnectionWindowSize = flag.Uint("backend_initial_connection_window_size", 0, `Initial HTTP/2 window size in bytes of the connections to the gRPC and HTTP/2 backends, from 65535 to 2147483647, 0 for the default of Envoy, 256MiB.`)
+	BackendIdleTimeoutS                = flag.Int("backend_idle_timeout_s", 0, `Time in seconds after which the connections to the backends without requests are closed, 0 for the default of Envoy, 1 hour.`)
+	BackendMaxRequestsPerConnection    = flag.Uint("backend_max_requests_per_connection", 0, `Maximum number of requests sent on each connection to the backends, 0 if unlimited.`)
+	BackendTcpKeepaliveTimeS           = flag.Uint("backend_tcp_keepalive_time_s", 0, `If set, TCP keepalive is enabled on the connections to the backends, and the probes are sent after this idle time in seconds.`)
+	BackendTcpKeepaliveIntervalS       = flag.Uint("backend_tcp_keepalive_interval_s", 0, `If set, TCP keepalive is enabled on the connections to the backends, with this interval in seconds between the probes.`)
+	BackendTcpKeepaliveProbes          = flag.Uint("backend_tcp_keepalive_probes", 0, `If set, TCP keepalive is enabled on the connections to the backends, which are closed after this number of unanswered probes.`)
+
+	BackendLbPolicy       = flag.String("backend_lb_policy", "", `Load balancing policy of the backends: "round_robin", "least_request", "ring_hash" or "maglev". Empty for round robin, or least request for the backends with region addresses.`)
+	BackendHashHeader     = flag.String("backend_hash_header", "", `With the ring_hash or maglev policy, the requests with the same value of this header are sent to the same instance of a backend.`)
+	BackendHashCookie     = flag.String("backend_hash_cookie", "", `With the ring_hash or maglev policy, the requests with the same value of this cookie are sent to the same instance of a backend.`)
+	BackendHashCookieTtlS = flag.Int("backend_hash_cookie_ttl_s", 0, `If set, the --backend_hash_cookie is generated with this TTL in seconds for the requests without it.`)
+	BackendHashSourceIp   = flag.Bool("backend_hash_source_ip", false, `With the ring_hash or maglev policy, the requests from the same IP address are sent to the same instance of a backend.`)
 
 	// Envoy specific configurations.
 	ClusterConnectTimeout = flag.Duration("cluster_connect_timeout", 20*time.Second, "cluster connect timeout in seconds")
 
 	// Network related configurations.
-	BackendAddress       = flag.String("backend_address", "http://127.0.0.1:8082", `The application server URI to which ESPv2 proxies requests.`)
-	ListenerAddress      = flag.String("listener_address", "0.0.0.0", "listener socket ip address")
+	BackendAddress       = flag.String("backend_address", "http://127.0.0.1:8082", `The application server URI to which ESPv2 proxies requests. It can be a Unix domain socket like unix:/var/run/backend.sock, or grpc+unix:/var/run/backend.sock for a gRPC backend.`)
+	ListenerAddress      = flag.String("listener_address", "0.0.0.0", `listener socket ip address. Use :: for both IPv6 and IPv4 connections in dual-stack, or a Unix domain socket like unix:/var/run/esp.sock, e.g. behind another proxy in a sidecar.`)
 	ServiceManagementURL = flag.String("service_management_url", "https://servicemanagement.googleapis.com", "url of service management server")
+	ServiceControlURL    = flag.String("service_control_url", "", `url of service control server, overriding the control environment of the service config, e.g. for a private endpoint.`)
 
 	ListenerPort = flag.Int("listener_port", 8080, "listener port")
 	Healthz      = flag.String("healthz", "", "path for health check of ESPv2 proxy itself")
 
+	HealthGrpcService = flag.String("health_grpc_service", "", `If set, the proxy serves the grpc.health.v1.Health Check method, which is SERVING only when
+	the config manager has sent the service config to Envoy and the backend in --backend_address, which must use grpc or grpcs, reports this
+	service as SERVING through its own grpc.health.v1.Health service. A request for another service is passed through to the backend.`)
+
 	SslServerCertPath = flag.String("ssl_server_cert_path", "", "Path to the certificate and key that ESPv2 uses to act as a HTTPS server")
 	SslClientCertPath = flag.String("ssl_client_cert_path", "", "Path to the certificate and key that ESPv2 uses to enable TLS mutual authentication for HTTPS backend")
 	RootCertsPath     = flag.String("root_certs_path", util.DefaultRootCAPaths, "Path to the root certificates to make TLS connection.")
 
+	// TLS configurations of the backend in --backend_address, which must use https or grpcs.
+	BackendMtlsCertPath          = flag.String("backend_mtls_cert_path", "", "Path to the client certificate that ESPv2 presents to the backend in --backend_address. Must be set with --backend_mtls_key_path.")
+	BackendMtlsKeyPath           = flag.String("backend_mtls_key_path", "", "Path to the private key of --backend_mtls_cert_path.")
+	BackendCaPath                = flag.String("backend_ca_path", "", "Path to the CA certificates to validate the server certificate of the backend in --backend_address. The default is --root_certs_path.")
+	BackendVerifySubjectAltNames = flag.String("backend_verify_san", "", "Comma separated subject alternative names, one of which must be in the server certificate of the backend in --backend_address.")
+
+	BackendOAuth2Config = flag.String("backend_oauth2_config", "", `Path to a JSON file with a list of OAuth2 client credentials grants, each with
+	"backend_address", "token_url", "client_id", "client_secret", and optional "scopes" and "endpoint_params". Requests to a backend in the x-google-backend
+	extension with the same host and port, even with disable_auth, are sent with an access token fetched from "token_url" by the config manager, instead
+	of an identity token.`)
+	BackendClusterConfig = flag.String("backend_cluster_config", "", `Path to a JSON file with a list of cluster settings of the backends in the
+	x-google-backend extension, each with the "backend_address" matched by host and port, and optional "connect_timeout" in seconds, and TLS settings
+	"ca_path", "mtls_cert_path", "mtls_key_path", "verify_subject_alt_names" and "sni" for backends using https or grpcs, and "region_addresses" of
+	the same backend in other regions, like the URLs of a Cloud Run service in each region, balanced by their active requests. The ID tokens sent to all
+	the regions have the audience of "backend_address", which must be accepted by the other regions, e.g. as a custom audience of Cloud Run.
+	"failover_addresses" are other regions too, which only receive the requests, in order, when the backend and its "region_addresses" are unhealthy.
+	"endpoints" are other "host" or "host:port" endpoints of the backend, balanced round robin, e.g. the servers of an on-prem backend, and
+	"dns_lookup_family", "dns_refresh_rate" in seconds, "respect_dns_ttl" and "strict_dns" override the backend DNS flags. "iam_delegates" override
+	--backend_auth_iam_delegates for the ID tokens of the backend, cached per audience and delegates.`)
+	OutboundTlsConfig = flag.String("outbound_tls_config", "", `Path to a JSON file with a list of TLS settings of the calls to the dependencies
+	of the proxy, each with the "dependency" (servicemanagement|servicecontrol|iam|jwks), and optional "ca_path" used instead of --root_certs_path,
+	"client_cert_path" and "client_key_path" of the client certificate presented to the dependency, and "min_version" and "max_version" from
+	"TLSv1_0" to "TLSv1_3". The settings of servicemanagement apply to the service config and rollouts fetches of the config manager, and the ones
+	of jwks to both Envoy and the config manager.`)
+	BackendRetryConfig = flag.String("backend_retry_config", "", `Path to a JSON file with a list of retry policies, each with the "selector" of
+	an operation, or "*" for all operations, "num_retries", "retry_on" with comma separated Envoy retry conditions like "5xx,reset", and optional
+	"per_try_timeout" in seconds, applied to the routes of the operations to their backends. With "hedge" set to true, the tries exceeding
+	"per_try_timeout" are not canceled on the routes of the idempotent HTTP methods, and the first response is used.`)
+	HeaderRulesConfig = flag.String("header_rules_config", "", `Path to a JSON file with a list of header rules, each with the "selector" of an
+	operation, or "*" for all operations, "request_headers_to_add" and "response_headers_to_add", each with a "name", a "value" and optional
+	"overwrite" replacing the existing values instead of appending to them, and "request_headers_to_remove" and "response_headers_to_remove" with
+	header names. The headers are modified on the routes of the operations to their backends, e.g. to strip internal headers or add environment tags.
+	The rules override the x-google-headers extension of the same selectors.`)
+	PathRewritesConfig = flag.String("path_rewrites_config", "", `Path to a JSON file with a list of path rewrites, each with the "selector" of an
+	operation, and a "prefix" of the paths replaced with "prefix_rewrite", or a "regex", in RE2 syntax, whose matches are replaced with a
+	"substitution", which may contain \1 to \9 for the capture groups. With a regex, the routes of the operation match the whole paths with it
+	instead of the path template. The paths are rewritten instead of the path translation of the backend rule of the operation. The rewrites override
+	the x-google-path-rewrite extension of the same operations.`)
+	BackendSplitsConfig = flag.String("backend_splits_config", "", `Path to a JSON file with a list of splits of the requests of an operation, each with
+	the "selector" of the operation and "backends", each with a "name", an "address" without path like in the x-google-backend extension, and a "weight".
+	The splits override the x-google-backend-split extension of the same operations.`)
+	BackendSplitHeader = flag.String("backend_split_header", "", `Name of a request header whose value is the name of a backend in the split of an
+	operation, forcing the requests to it, e.g. for testing a new version. Disabled if empty.`)
+	FaultInjectionsConfig = flag.String("fault_injections_config", "", `Path to a JSON file with a list of faults injected in the requests of an operation for
+	chaos experiments, each with the "selector" of the operation, and a "delay" with the "percent" of the requests delayed by "duration" seconds, or
+	an "abort" with the "percent" of the requests aborted with the HTTP "status". The faults override the x-google-fault-injection extension of the
+	same operations.`)
+	FaultInjectionHeader = flag.String("fault_injection_header", "", `Name of the request header opting in to the fault injections. Only the requests with this header are
+	injected faults. Required with fault injections.`)
+	DirectResponsesConfig = flag.String("direct_responses_config", "", `Path to a JSON file with a list of canned responses of an operation, returned without sending
+	its requests to a backend, e.g. to stub unimplemented operations, each with the "selector" of the operation, the HTTP "status", and optionally
+	the "headers" like in the x-google-headers extension, the "content_type", text/plain by default, and the "body", at most 4096 bytes. The
+	responses override the x-google-direct-response extension of the same operations.`)
+	BackendMirrorsConfig = flag.String("backend_mirrors_config", "", `Path to a JSON file with a list of mirrors of the requests of an operation, each with
+	the "selector" of the operation, the "address" of a secondary backend without path like in the x-google-backend extension, and the "fraction" of
+	the requests mirrored to it, all of them if 0. The responses of the secondary backend are ignored. The mirrors override the x-google-backend-mirror
+	extension of the same operations.`)
+
+	EnableWebsocket    = flag.Bool("enable_websocket", false, `Allow WebSocket upgrades of the requests to all operations. API keys in the upgrade request are checked, and the streamed bytes of the connections are reported to service control.`)
+	WebsocketSelectors = flag.String("websocket_selectors", "", `Comma separated selectors of the operations allowing WebSocket upgrades, without response timeout. Unknown selectors are ignored.`)
+
+	EnableGrpcWeb = flag.Bool("enable_grpc_web", false, `Allow browsers to call the gRPC backends with gRPC-Web. The requests are converted to gRPC before they are authenticated, checked
+	by service control and transcoded, and the gRPC-Web headers are allowed by the CORS policy of --cors_preset.`)
+
+	EnableResponseCompression           = flag.Bool("enable_response_compression", false, `Compress the responses with gzip for the clients sending "Accept-Encoding: gzip".`)
+	ResponseCompressionMinContentLength = flag.Uint("response_compression_min_content_length", 30, `Minimum size in bytes of the compressed responses.`)
+	ResponseCompressionContentTypes     = flag.String("response_compression_content_types", "", `Comma separated content types of the compressed responses, like
+	"application/json,text/html". The default types of Envoy, including JSON, JavaScript, HTML, CSS and plain text, if empty.`)
+	ResponseCompressionLevel = flag.String("response_compression_level", "default", `Compression level of the responses: "default", "best" for the smallest responses or "speed" for the fastest compression.`)
+
+	ResponseCacheConfig = flag.String("response_cache_config", "", `Path to a JSON file with a list of response caches, each with the "selector" of an
+	operation, or "*" for all operations, and optional "ttl" in seconds overriding the max-age of the Cache-Control header of the responses, "key_headers"
+	with the request headers keying the responses along with their path and query parameters, and "include_api_key" to key them by the API key headers.
+	The responses of the GET requests are cached unless prevented by their Cache-Control header or they have a Vary header. The responses of the operations
+	with a JWT requirement are shared by all the users, so they are only cached if their Cache-Control header has "public" or "s-maxage".`)
+	ResponseCacheMaxEntries   = flag.Uint("response_cache_max_entries", 1000, `Maximum number of responses cached per worker thread, the least recently used ones are evicted.`)
+	ResponseCacheMaxBodyBytes = flag.Uint("response_cache_max_body_bytes", 1048576, `Maximum size in bytes of the body of a cached response.`)
+
+	UnmatchedPathBody        = flag.String("unmatched_path_body", "", `Body of the 404 responses to the requests whose path does not match any operation.`)
+	UnmatchedPathContentType = flag.String("unmatched_path_content_type", "", `Content type of --unmatched_path_body, "text/plain" if empty.`)
+	UnmatchedPathRedirectUrl = flag.String("unmatched_path_redirect_url", "", `URL the requests whose path does not match any operation are redirected to, like a docs portal.`)
+	UnmatchedPathToBackend   = flag.Bool("unmatched_path_to_backend", false, `Route the requests whose path does not match any operation to the backend of --backend_address,
+	without authentication and service control. At most one of --unmatched_path_body, --unmatched_path_redirect_url and --unmatched_path_to_backend is set.`)
+
+	ErrorResponseFormat = flag.String("error_response_format", "", `Format of the bodies of the 4xx and 5xx responses of the proxy: "json" for {"code": ..., "message": ..., "details": ...},
+	or "problem_json" for the RFC 7807 problem details. The plain text of Envoy if empty. The responses of the backends are not changed.`)
+	ErrorResponseTemplatesConfig = flag.String("error_response_templates_config", "", `Path to a JSON file with a list of templates of the bodies of the error responses of the
+	proxy, each with a "status" code, a "body" in which %CODE%, %MESSAGE% and %DETAILS% are replaced with the status code, the error message and the
+	response code details, escaped as JSON strings, and an optional "content_type", "application/json" by default. The templates override
+	--error_response_format for their status codes.`)
+
+	TranscodingDescriptorPath = flag.String("transcoding_descriptor_path", "", `File path to the proto descriptor set of the gRPC-JSON transcoder, used instead of the one in the service config.
+	It must define all the APIs of the service config, and is reloaded when it changes.`)
+	TranscodingAlwaysPrintPrimitiveFields   = flag.Bool("transcoding_always_print_primitive_fields", false, `Print the primitive fields with default values in the JSON responses transcoded from gRPC.`)
+	TranscodingAlwaysPrintEnumsAsInts       = flag.Bool("transcoding_always_print_enums_as_ints", false, `Print the enums as ints instead of names in the JSON responses transcoded from gRPC.`)
+	TranscodingPreserveProtoFieldNames      = flag.Bool("transcoding_preserve_proto_field_names", false, `Print the proto field names instead of their lowerCamelCase JSON names in the JSON responses transcoded from gRPC.`)
+	TranscodingIgnoreUnknownQueryParameters = flag.Bool("transcoding_ignore_unknown_query_parameters", false, `Ignore the query parameters which are not fields of the gRPC request messages, instead of rejecting the requests.`)
+	TranscodingMatchIncomingRequestRoute    = flag.Bool("transcoding_match_incoming_request_route", false, `Transcode the requests matching the HTTP rules with their incoming path, instead of the path rewritten by the route.`)
+	TranscodingDisableAutoMapping           = flag.Bool("transcoding_disable_auto_mapping", false, `Only transcode the requests matching the HTTP rules of the gRPC methods, not the POST requests to their gRPC paths.`)
+
+	Http3Port              = flag.Int("http3_port", 0, `UDP port of the HTTP/3 listener over QUIC, served alongside the listener on --listener_port. 0 disables HTTP/3.`)
+	Http3SslServerCertPath = flag.String("http3_ssl_server_cert_path", "", `Path to the certificate and key of the HTTP/3 listener, --ssl_server_cert_path if empty.`)
+	Http3AltSvcMaxAge      = flag.Duration("http3_alt_svc_max_age", 24*time.Hour, `How long the clients cache the HTTP/3 listener advertised in the alt-svc response headers.
+	0 disables the advertisement.`)
+
+	SslServerCertSds = flag.Bool("ssl_server_cert_sds", false, `Send the certificate in --ssl_server_cert_path to Envoy over SDS, and rotate it without draining
+	the listener when its files change.`)
+	SslServerAcmeDirectoryUrl = flag.String("ssl_server_acme_directory_url", "", `Directory url of an ACME server, like https://acme-v02.api.letsencrypt.org/directory,
+	issuing and renewing the certificate in --ssl_server_cert_path for --ssl_server_acme_domains with HTTP-01 challenges. Implies --ssl_server_cert_sds.`)
+	SslServerAcmeDomains       = flag.String("ssl_server_acme_domains", "", `Comma separated domains of the certificate issued by --ssl_server_acme_directory_url.`)
+	SslServerAcmeEmail         = flag.String("ssl_server_acme_email", "", `Contact email of the ACME account, optional.`)
+	SslServerAcmeHttpPort      = flag.Int("ssl_server_acme_http_port", 80, `Port of the plain HTTP listener serving the ACME HTTP-01 challenges, and redirecting the other requests to HTTPS.`)
+	SslServerAcmeChallengePort = flag.Int("ssl_server_acme_challenge_port", 8793, `Port of the config manager on 127.0.0.1 serving the ACME HTTP-01 challenges to Envoy.`)
+
+	SslServerClientCaPath = flag.String("ssl_server_client_ca_path", "", `Path to the CA certificates validating the client certificates, which are requested from the
+	downstream clients if set.`)
+	SslServerRequireClientCert       = flag.Bool("ssl_server_require_client_cert", false, `Reject the downstream connections without a client certificate valid for --ssl_server_client_ca_path.`)
+	SslServerClientSpiffeTrustDomain = flag.String("ssl_server_client_spiffe_trust_domain", "", `SPIFFE trust domain, like example.org, of the client certificates. Their URI SAN must be a
+	SPIFFE ID in this trust domain.`)
+	SslServerForwardClientCert = flag.Bool("ssl_server_forward_client_cert", false, `Send the subject and SANs of the client certificates to the backend in the x-forwarded-client-cert
+	header, and log it in the Service Control reports.`)
+	SslServerSniConfig = flag.String("ssl_server_sni_config", "", `Path to a JSON file with a list of certificates served to the clients requesting their server names
+	with SNI, each with the "server_names", like "api.example.com" or "*.example.com", the "cert_path" and the "key_path". With multiple
+	services in --service, the requests with these server names are all routed to the "service", if set. Other clients are served the
+	certificate in --ssl_server_cert_path.`)
+
+	TracingOperationSampleRates = flag.String("tracing_operation_sample_rates", "", `Comma separated "<selector>=<rate>" overrides, from 0.0 to 1.0, of the fraction of the requests
+	to an operation that Envoy decides to trace, e.g. 1.0 for a new operation or 0.0 for a health check. The --tracing_sampler of the tracer still applies.`)
+	TracingClientSampleRate = flag.Float64("tracing_client_sample_rate", 1.0, `Fraction, from 0.0 to 1.0, of the requests with the x-client-trace-id header that are forced to be
+	traced, e.g. when debugging a request. Set it to 0.0 to ignore the header.`)
+	TracingCustomTags = flag.String("tracing_custom_tags", "", `Comma separated attributes of the requests set as tags on their spans (api_name|api_version|operation_name|api_key_project|consumer_id).
+	The API key project and the consumer ID, like "project:123456", are only set for the requests checked with an API key.`)
+
+	AccessLogPath       = flag.String("access_log_path", "", `Path of the file the access logs of the requests are written to, like /dev/stdout. Disabled if empty.`)
+	AccessLogFormat     = flag.String("access_log_format", "", `Envoy format string of the access logs in --access_log_path, like "%START_TIME% %REQ(:PATH)% %RESPONSE_CODE%\n". Envoy's default format if empty.`)
+	AccessLogJsonFormat = flag.String("access_log_json_format", "", `JSON object mapping the fields of the access logs in --access_log_path, written as JSON, to Envoy format strings,
+	like {"path":"%REQ(:PATH)%","status":"%RESPONSE_CODE%"}. Conflicts with --access_log_format.`)
+	AccessLogServiceUri        = flag.String("access_log_service_uri", "", `URI of an Envoy gRPC Access Log Service the access logs of the requests are sent to, like grpc://als.example.com:9001. Disabled if empty.`)
+	AccessLogServiceLogName    = flag.String("access_log_service_log_name", "esp-v2", `Name identifying the access logs sent to --access_log_service_uri.`)
+	AccessLogMaxBytes          = flag.Int64("access_log_max_bytes", 0, `Rotate the file in --access_log_path once it is larger than this size, copying it to <path>.1 and truncating it. Not rotated if 0.`)
+	AccessLogMaxFiles          = flag.Int("access_log_max_files", 5, `Number of rotated files of --access_log_path kept, from <path>.1 to <path>.<max files>.`)
+	AccessLogDisabledSelectors = flag.String("access_log_disabled_selectors", "", `Comma separated selectors of the operations whose requests are not logged. Unknown selectors are ignored.`)
+
+	MaxRequestBodyBytes = flag.Uint("max_request_body_bytes", 0, `Maximum size of the request bodies, 0 if unlimited. The requests are buffered, and larger
+	ones are rejected with 413 before they are checked by service control or sent to the backend. Streaming methods are not limited.`)
+	MaxResponseBodyBytes = flag.Uint("max_response_body_bytes", 0, `Maximum size of the response bodies, 0 if unlimited. The responses are buffered, and larger
+	ones are replaced by a 500 error. Streaming methods are not limited.`)
+	BodySizeLimitsConfig = flag.String("body_size_limits_config", "", `Path to a JSON file with a list of overrides of --max_request_body_bytes and
+	--max_response_body_bytes, each with the "selector" of an operation, and "max_request_body_bytes" or "max_response_body_bytes", 0 if unlimited.`)
+
+	ApiKeyLocations = flag.String("api_key_locations", "", `Comma separated locations of the API keys, each "query:<name>", "header:<name>" or
+	"cookie:<name>", tried in order. They replace the default query parameters "key" and "api_key" and header "x-api-key" of the operations without
+	API key locations in the service config.`)
+	ApiKeyLocationsConfig = flag.String("api_key_locations_config", "", `Path to a JSON file with a list of overrides of the API key locations, each with the
+	"selector" of an operation and its "locations", like in --api_key_locations.`)
+
+	JwtClaimHeaders = flag.String("jwt_claim_headers", "", `Comma separated "<claim>=<header>" pairs, forwarding the claims of the verified JWTs
+	to the backend in request headers for the operations requiring JWTs, like "sub=x-user-id,email=x-user-email". Nested claims are
+	separated by dots, like "google.project_id=x-project-id". The headers sent by the clients are removed.`)
+	JwtClaimHeadersConfig = flag.String("jwt_claim_headers_config", "", `Path to a JSON file with a list of overrides of --jwt_claim_headers, each with the
+	"selector" of an operation and its "claim_headers", like {"sub": "x-user-id"}.`)
+
+	AuthorizationRulesConfig = flag.String("authorization_rules_config", "", `Path to a JSON file with a list of authorization rules, each with the "selector"
+	of an operation requiring JWTs and the "allow" policies of its requests. Each policy has the "claims" required in the JWTs with their
+	accepted values, like {"roles": ["admin"]}. The requests not allowed by any policy are rejected with 403. The rules override the
+	x-google-authorization extension of the OpenAPI document.`)
+
+	ExtAuthzUri = flag.String("ext_authz_uri", "", `Uri of an external authorization server, "grpc://", "grpcs://", "http://" or "https://",
+	checking the requests with Envoy's ext_authz filter before Service Control. The path of an HTTP server prefixes the checked paths.`)
+	ExtAuthzTimeout          = flag.Duration("ext_authz_timeout", time.Second, "Timeout of the calls to the external authorization server.")
+	ExtAuthzFailureModeAllow = flag.Bool("ext_authz_failure_mode_allow", false, `If true, the requests are allowed when the external authorization
+	server fails or is unreachable.`)
+	ExtAuthzDisabledSelectors = flag.String("ext_authz_disabled_selectors", "", `Comma separated selectors of the operations not checked by the external
+	authorization server, along with the ones with the x-google-ext-authz-disabled extension in the OpenAPI document. Unknown selectors are ignored.`)
+
+	IpAclConfig = flag.String("ip_acl_config", "", `Path to a JSON file with a list of IP ACLs, each with the "selector" of an operation,
+	or "*" for all operations without their own, and the CIDR ranges of the client addresses it "allow"s and "deny"s, like
+	{"selector": "*", "allow": ["10.0.0.0/8"], "deny": ["10.1.0.0/16"]}. The requests from a denied address, or from an address in no
+	allowed range if any, are rejected with 403. The client address is taken from the x-forwarded-for header, skipping the
+	--envoy_xff_num_trusted_hops proxies, see --envoy_use_remote_address. The ACLs override the x-google-ip-acl extension of the same selectors.`)
+
+	RateLimitRequestsPerSecond = flag.Uint("rate_limit_requests_per_second", 0, `Maximum rate of the requests to all the operations, 0 if unlimited. The requests
+	over the limit are rejected with 429 by the Service Control filter.`)
+	RateLimitBurst   = flag.Uint("rate_limit_burst", 0, `Maximum burst of requests over --rate_limit_requests_per_second, which defaults to it.`)
+	RateLimitsConfig = flag.String("rate_limits_config", "", `Path to a JSON file with a list of limits of the requests to an operation, each with the
+	"selector" of an operation, its "requests_per_second" and "burst". The limits override the x-google-rate-limit extension of the
+	OpenAPI document.`)
+	RateLimitServiceUri = flag.String("rate_limit_service_uri", "", `Uri of an Envoy rate limit service, "grpc://" or "grpcs://", asked for the requests
+	of each operation, with the service name as the domain and the operation as the "generic_key" descriptor.`)
+	RateLimitServiceTimeout         = flag.Duration("rate_limit_service_timeout", 20*time.Millisecond, "Timeout of the calls to the rate limit service.")
+	RateLimitServiceFailureModeDeny = flag.Bool("rate_limit_service_failure_mode_deny", false, `If true, the requests are rejected when the rate limit service
+	fails or is unreachable.`)
+
+	LocalJwks = flag.String("local_jwks", "", `Comma separated "<provider_id>=<path>" pairs, replacing the jwks_uri of the JWT providers with local
+	JWKS files, for deployments without outbound internet access. The files are checked for changes every --local_jwks_check_interval.`)
+
 	// Flags for non_gcp deployment.
 	ServiceAccountKey = flag.String("service_account_key", "", `Use the service account key JSON file to access the service control and the
 	service management.  You can also set {creds_key} environment variable to the location of the service account credentials JSON file. If the option is
-  omitted, the proxy contacts the metadata service to fetch an access token`)
+  omitted, the proxy contacts the metadata service to fetch an access token. An external account credentials JSON file of Workload Identity
+  Federation can also be used, to run on AWS, Azure or on-premises without long-lived keys`)
+	TokenAgentPort = flag.Int("token_agent_port", 8791, `Port of the token agent on 127.0.0.1, serving access tokens to Envoy when --service_account_key is an
+	external account (Workload Identity Federation) credential, which Envoy cannot exchange by itself.`)
 
 	// Envoy configurations.
 	EnvoyUseRemoteAddress  = flag.Bool("envoy_use_remote_address", false, "Envoy HttpConnectionManager configuration, please refer to envoy documentation for detailed information.")
 	EnvoyXffNumTrustedHops = flag.Int("envoy_xff_num_trusted_hops", 2, "Envoy HttpConnectionManager configuration, please refer to envoy documentation for detailed information.")
+	TrustedProxyCidrs      = flag.String("trusted_proxy_cidrs", "", `Comma separated CIDR ranges of the trusted proxies in front of the proxy, like
+	"35.191.0.0/16,130.211.0.0/22" for Google Cloud load balancers. If set, the client address is the rightmost address of the x-forwarded-for header
+	not appended by one of them, instead of the one set by --envoy_use_remote_address and --envoy_xff_num_trusted_hops. It is used by the IP ACLs,
+	reported to Service Control and logged.`)
+	EnableProxyProtocol = flag.Bool("enable_proxy_protocol", false, `If true, the listener accepts connections with the PROXY protocol header of a load
+	balancer, like AWS NLB or HAProxy, and the client address is taken from it. The connections without the header are rejected.`)
+
+	RequestIdMode = flag.String("request_id_mode", "generate", `How the request IDs in the x-request-id header are set (generate|preserve|none). With "generate", they are generated
+	for the requests without one, and replace the ones of the external requests if --envoy_use_remote_address is set. With "preserve", the ones of the
+	requests are always kept, and generated for the requests without one. With "none", they are never generated.`)
+	RequestIdHeader = flag.String("request_id_header", "", `Additional header with a copy of the request ID, like x-correlation-id, set in the requests to the backends and in the
+	responses. Disabled if empty.`)
+	LogRequestId = flag.Bool("log_request_id", false, `If true, the request IDs are reported to service control in the "request_id" label, unless
+	--service_control_report_labels sets it, and logged in the "request_id" field of --access_log_json_format, unless it sets it. The default format of
+	--access_log_path and the gRPC Access Log Service always include them.`)
 
 	LogJwtPayloads = flag.String("log_jwt_payloads", "", `Log corresponding JWT JSON payload primitive fields through service control, separated by comma. Example, when --log_jwt_payload=sub,project_id, log
 	will have jwt_payload: sub=[SUBJECT];project_id=[PROJECT_ID] if the fields are available. The value must be a primitive field, JSON objects and arrays will not be logged.`)
@@ -72,7 +362,14 @@ var (
 	foo,bar, endpoint log will have request_headers: foo=foo_value;bar=bar_value if values are available;`)
 	LogResponseHeaders = flag.String("log_response_headers", "", `Log corresponding response headers through service control, separated by comma. Example, when --log_response_headers=
 	foo,bar,endpoint log will have response_headers: foo=foo_value;bar=bar_value if values are available.`)
-	MinStreamReportIntervalMs = flag.Uint64("min_stream_report_interval_ms", 0, `Minimum amount of time (milliseconds) between sending intermediate reports on a stream and the default is 10000 if not set.`)
+	MinStreamReportIntervalMs = flag.Uint64("min_stream_report_interval_ms", 0, `Minimum amount of time (milliseconds) between sending intermediate reports on a gRPC stream or WebSocket connection and the default is 10000 if not set.
+	The long-lived streams are reported at this interval even if no data is streamed.`)
+	ServiceControlReportLabels = flag.String("service_control_report_labels", "", `Custom labels of the operations reported to service control, separated by comma, each one "<label>=header:<header>" or "<label>=jwt:<claim path>".
+	Example, --service_control_report_labels=tenant_id=header:x-tenant-id,client_version=jwt:client.version. The labels are not set if the request has no such header or claim.`)
+	ConsumerProjectHeader = flag.String("consumer_project_header", "", `Request header set to the consumer project number of the requests checked with an API key, before they are sent to the backend.
+	The default is x-endpoint-api-project-id if not set. The header sent by the client is always removed.`)
+	ConsumerIdHeader = flag.String("consumer_id_header", "", `Request header set to the consumer ID ("project:<number>") of the requests checked with an API key, before they are sent to the backend.
+	Not set by default. The header sent by the client is always removed.`)
 
 	SuppressEnvoyHeaders = flag.Bool("suppress_envoy_headers", false, `Do not add any additional x-envoy- headers to requests or responses. This only affects the router filter
 	generated *x-envoy-* headers, other Envoy filters and the HTTP connection manager may continue to set x-envoy- headers.`)
@@ -81,6 +378,12 @@ var (
         the requests will be allowed if this flag is on. The default is on.`)
 
 	JwksCacheDurationInS = flag.Int("jwks_cache_duration_in_s", 300, "Specify JWT public key cache duration in seconds. The default is 5 minutes.")
+	JwksAsyncFetch       = flag.Bool("jwks_async_fetch", false, `If true, the JWKS of the remote JWT providers are fetched in advance by the config manager,
+	and refreshed every cache duration, so that no request waits for a JWKS fetch. Envoy fetches them if they cannot be fetched.`)
+	JwksFetchRetries   = flag.Int("jwks_fetch_retries", 3, "Number of retries of the failed JWKS fetches of --jwks_async_fetch.")
+	JwksProviderConfig = flag.String("jwks_provider_config", "", `Path to a JSON file with a list of overrides of the JWKS fetching by JWT provider, each with
+	the "provider_id", and "cache_duration_in_s", "async_fetch" or "fetch_retries", like --jwks_cache_duration_in_s, --jwks_async_fetch and
+	--jwks_fetch_retries.`)
 
 	ScCheckTimeoutMs  = flag.Int("service_control_check_timeout_ms", 0, `Set the timeout in millisecond for service control Check request. Must be > 0 and the default is 1000 if not set.`)
 	ScQuotaTimeoutMs  = flag.Int("service_control_quota_timeout_ms", 0, `Set the timeout in millisecond for service control Quota request. Must be > 0 and the default is 1000 if not set.`)
@@ -90,6 +393,33 @@ var (
 	ScQuotaRetries  = flag.Int("service_control_quota_retries", -1, `Set the retry times for service control Quota request. Must be >= 0 and the default is 1 if not set.`)
 	ScReportRetries = flag.Int("service_control_report_retries", -1, `Set the retry times for service control Report request. Must be >= 0 and the default is 5 if not set.`)
 
+	ScReportBatchMaxSize    = flag.Int("service_control_report_batch_max_size", 0, `Set the maximum number of operations in a batch of service control Report requests. Must be > 0 and the default is 1000 if not set.`)
+	ScReportBatchMaxDelayMs = flag.Int("service_control_report_batch_max_delay_ms", 0, `Set the maximum delay in millisecond to send a batch of service control Report requests. Must be > 0 and the default is 1000 if not set.`)
+	ScReportBatchMaxBytes   = flag.Int("service_control_report_batch_max_bytes", 0, `Set the maximum size in bytes of a batch of service control Report requests, the reports over it are dropped. Must be > 0 and the default is 10485760 if not set.`)
+
+	ScCircuitBreakerFailureThreshold = flag.Int("service_control_circuit_breaker_failure_threshold", 0, `Open the circuit breaker for service control outages after this number of consecutive failed Check or Quota requests. While it is open, the requests are handled with --service_control_circuit_breaker_fail_open and the Report requests are queued until service control recovers. The circuit breaker is disabled if not set.`)
+	ScCircuitBreakerOpenDurationMs   = flag.Int("service_control_circuit_breaker_open_duration_ms", 0, `Set the time in millisecond between the requests probing service control while the circuit breaker is open. Must be > 0 and the default is 30000 if not set.`)
+	ScCircuitBreakerFailOpen         = flag.Bool("service_control_circuit_breaker_fail_open", true, `Allow the requests while the circuit breaker for service control outages is open, or reject them with 503 if false.`)
+	ScReportQueueMaxBytes            = flag.Int("service_control_report_queue_max_bytes", 0, `Set the maximum size in bytes of the Report requests queued in memory while the circuit breaker is open. Must be > 0 and the default is 10485760 if not set.`)
+	ScReportSpillDir                 = flag.String("service_control_report_spill_dir", "", `The directory to spill the queued Report requests to when the queue in memory is full. If not set, these requests are dropped.`)
+	ScReportSpillMaxBytes            = flag.Int("service_control_report_spill_max_bytes", 0, `Set the maximum size in bytes of the Report requests spilled to disk. Must be > 0 and the default is 104857600 if not set.`)
+
+	ScCheckCacheTtlMs         = flag.Int("service_control_check_cache_ttl_ms", 0, `Set the time in millisecond to cache the allowed service control Check results. Must be > 0 and the default is 60000 if not set.`)
+	ScCheckCacheMaxEntries    = flag.Int("service_control_check_cache_max_entries", -1, `Set the maximum number of cached service control Check results. Must be >= 0, 0 disables the cache, and the default is 10000 if not set.`)
+	ScCheckCacheNegativeTtlMs = flag.Int("service_control_check_cache_negative_ttl_ms", -1, `Set the time in millisecond to cache the denied service control Check results, such as an invalid API key. Must be >= 0, 0 disables their caching, and the default is --service_control_check_cache_ttl_ms if not set.`)
+	ScCheckCacheServeStale    = flag.Bool("service_control_check_cache_serve_stale", false, `Use the expired service control Check results when service control is unavailable, instead of --service_control_network_fail_open.`)
+
+	ScQuotaBucketBurst            = flag.Int("service_control_quota_bucket_burst", 0, `Keep the quota of each consumer in local token buckets of this size, refilled by asynchronous service control Quota requests, instead of allocating the quota of each request. The buckets are disabled if not set.`)
+	ScQuotaBucketRefillIntervalMs = flag.Int("service_control_quota_bucket_refill_interval_ms", 0, `Set the time in millisecond between the service control Quota requests refilling the local token buckets of --service_control_quota_bucket_burst. Must be > 0 and the default is 1000 if not set.`)
+
+	ServiceControlProtocol            = flag.String("service_control_protocol", "http", `The protocol of the service control calls: "http" for the REST API of Google Service Control, or "grpc" for the google.api.servicecontrol.v1.ServiceController and QuotaController gRPC services, e.g. implemented by a user-hosted metering server at --service_control_url.`)
+	ServiceControlCheckPath           = flag.String("service_control_check_path", "", `The path of the service control Check calls, overriding the one of --service_control_protocol. "{service}" is replaced by the service name.`)
+	ServiceControlQuotaPath           = flag.String("service_control_quota_path", "", `The path of the service control AllocateQuota calls, overriding the one of --service_control_protocol. "{service}" is replaced by the service name.`)
+	ServiceControlReportPath          = flag.String("service_control_report_path", "", `The path of the service control Report calls, overriding the one of --service_control_protocol. "{service}" is replaced by the service name.`)
+	ServiceControlAuth                = flag.String("service_control_auth", "access_token", `The credentials of the service control calls: "access_token" for the Google access token, "none", or "header" for the header of --service_control_auth_header_name.`)
+	ServiceControlAuthHeaderName      = flag.String("service_control_auth_header_name", "", `The header sent with the service control calls with --service_control_auth=header, like an API key of the server.`)
+	ServiceControlAuthHeaderValuePath = flag.String("service_control_auth_header_value_path", "", `The file of the value of --service_control_auth_header_name. Envoy reads it, and reads it again periodically to pick up a rotated value, so the value is not in the generated config.`)
+
 	ComputePlatformOverride = flag.String("compute_platform_override", "", "the overridden platform where the proxy is running at")
 
 	// Flags for testing purpose.
@@ -97,7 +427,20 @@ var (
 	SkipServiceControlFilter = flag.Bool("skip_service_control_filter", false, "skip service control filter, for test purpose")
 )
 
+// EnvoyConfigOptionsFromFlags returns the options of the flags, and exits on
+// any invalid flag.
 func EnvoyConfigOptionsFromFlags() options.ConfigGeneratorOptions {
+	opts, errs := ParseEnvoyConfigOptionsFromFlags()
+	if err := errs.Err(); err != nil {
+		logging.Exitf("%v", err)
+	}
+	return opts
+}
+
+// ParseEnvoyConfigOptionsFromFlags returns the options of the flags, and the
+// problems found in them.
+func ParseEnvoyConfigOptionsFromFlags() (options.ConfigGeneratorOptions, *Errors) {
+	errs := &Errors{}
 	opts := options.ConfigGeneratorOptions{
 		CommonOptions:                 commonflags.DefaultCommonOptionsFromFlags(),
 		BackendAddress:                *BackendAddress,
@@ -110,34 +453,1131 @@ func EnvoyConfigOptionsFromFlags() options.ConfigGeneratorOptions {
 		CorsExposeHeaders:             *CorsExposeHeaders,
 		CorsPreset:                    *CorsPreset,
 		BackendDnsLookupFamily:        *BackendDnsLookupFamily,
+		BackendDnsRefreshRate:         time.Duration(*BackendDnsRefreshRateS) * time.Second,
+		BackendDnsRespectTtl:          *BackendDnsRespectTtl,
+		BackendStrictDns:              *BackendStrictDns,
+		BackendEdsServerUri:           *BackendEdsServerUri,
+		BackendEdsServiceName:         *BackendEdsServiceName,
+		BackendEdsGoogleCredentials:   *BackendEdsGoogleCredentials,
 		ClusterConnectTimeout:         *ClusterConnectTimeout,
 		ListenerAddress:               *ListenerAddress,
 		ServiceManagementURL:          *ServiceManagementURL,
+		ServiceControlURL:             *ServiceControlURL,
 		ListenerPort:                  *ListenerPort,
 		Healthz:                       *Healthz,
+		HealthGrpcService:             *HealthGrpcService,
 		RootCertsPath:                 *RootCertsPath,
 		SslServerCertPath:             *SslServerCertPath,
 		SslClientCertPath:             *SslClientCertPath,
+		BackendMtlsCertPath:           *BackendMtlsCertPath,
+		BackendMtlsKeyPath:            *BackendMtlsKeyPath,
+		BackendCaPath:                 *BackendCaPath,
+		BackendVerifySubjectAltNames:  *BackendVerifySubjectAltNames,
 		ServiceAccountKey:             *ServiceAccountKey,
+		TokenAgentPort:                *TokenAgentPort,
 		SkipJwtAuthnFilter:            *SkipJwtAuthnFilter,
 		SkipServiceControlFilter:      *SkipServiceControlFilter,
+		EnableWebsocket:               *EnableWebsocket,
+		EnableGrpcWeb:                 *EnableGrpcWeb,
+		WebsocketSelectors:            *WebsocketSelectors,
+		ApiKeyLocations:               *ApiKeyLocations,
+		JwtClaimHeaders:               *JwtClaimHeaders,
+		LocalJwks:                     *LocalJwks,
+		BackendSplitHeader:            *BackendSplitHeader,
+		FaultInjectionHeader:          *FaultInjectionHeader,
+		ExtAuthzUri:                   *ExtAuthzUri,
+		ExtAuthzTimeout:               *ExtAuthzTimeout,
+		ExtAuthzFailureModeAllow:      *ExtAuthzFailureModeAllow,
+		ExtAuthzDisabledSelectors:     *ExtAuthzDisabledSelectors,
 		EnvoyUseRemoteAddress:         *EnvoyUseRemoteAddress,
 		EnvoyXffNumTrustedHops:        *EnvoyXffNumTrustedHops,
+		TrustedProxyCidrs:             *TrustedProxyCidrs,
+		EnableProxyProtocol:           *EnableProxyProtocol,
+		RequestIdMode:                 *RequestIdMode,
+		RequestIdHeader:               *RequestIdHeader,
+		LogRequestId:                  *LogRequestId,
 		LogJwtPayloads:                *LogJwtPayloads,
 		LogRequestHeaders:             *LogRequestHeaders,
 		LogResponseHeaders:            *LogResponseHeaders,
 		MinStreamReportIntervalMs:     *MinStreamReportIntervalMs,
+		ServiceControlReportLabels:    *ServiceControlReportLabels,
+		ConsumerProjectHeader:         *ConsumerProjectHeader,
+		ConsumerIdHeader:              *ConsumerIdHeader,
 		SuppressEnvoyHeaders:          *SuppressEnvoyHeaders,
 		ServiceControlNetworkFailOpen: *ServiceControlNetworkFailOpen,
 		JwksCacheDurationInS:          *JwksCacheDurationInS,
+		JwksAsyncFetch:                *JwksAsyncFetch,
+		JwksFetchRetries:              *JwksFetchRetries,
 		ScCheckTimeoutMs:              *ScCheckTimeoutMs,
 		ScQuotaTimeoutMs:              *ScQuotaTimeoutMs,
 		ScReportTimeoutMs:             *ScReportTimeoutMs,
 		ScCheckRetries:                *ScCheckRetries,
 		ScQuotaRetries:                *ScQuotaRetries,
 		ScReportRetries:               *ScReportRetries,
+		ScReportBatchMaxSize:          *ScReportBatchMaxSize,
+		ScReportBatchMaxDelayMs:       *ScReportBatchMaxDelayMs,
+		ScReportBatchMaxBytes:         *ScReportBatchMaxBytes,
+
+		ScCircuitBreakerFailureThreshold: *ScCircuitBreakerFailureThreshold,
+		ScCircuitBreakerOpenDurationMs:   *ScCircuitBreakerOpenDurationMs,
+		ScCircuitBreakerFailOpen:         *ScCircuitBreakerFailOpen,
+		ScReportQueueMaxBytes:            *ScReportQueueMaxBytes,
+		ScReportSpillDir:                 *ScReportSpillDir,
+		ScReportSpillMaxBytes:            *ScReportSpillMaxBytes,
+
+		ScCheckCacheTtlMs:         *ScCheckCacheTtlMs,
+		ScCheckCacheMaxEntries:    *ScCheckCacheMaxEntries,
+		ScCheckCacheNegativeTtlMs: *ScCheckCacheNegativeTtlMs,
+		ScCheckCacheServeStale:    *ScCheckCacheServeStale,
+
+		ServiceControlProtocol:            *ServiceControlProtocol,
+		ServiceControlCheckPath:           *ServiceControlCheckPath,
+		ServiceControlQuotaPath:           *ServiceControlQuotaPath,
+		ServiceControlReportPath:          *ServiceControlReportPath,
+		ServiceControlAuth:                *ServiceControlAuth,
+		ServiceControlAuthHeaderName:      *ServiceControlAuthHeaderName,
+		ServiceControlAuthHeaderValuePath: *ServiceControlAuthHeaderValuePath,
+
+		ScQuotaBucketBurst:            *ScQuotaBucketBurst,
+		ScQuotaBucketRefillIntervalMs: *ScQuotaBucketRefillIntervalMs,
+
+		RateLimitServiceUri:             *RateLimitServiceUri,
+		RateLimitServiceTimeout:         *RateLimitServiceTimeout,
+		RateLimitServiceFailureModeDeny: *RateLimitServiceFailureModeDeny,
+
+		TranscodingDescriptorPath:               *TranscodingDescriptorPath,
+		TranscodingAlwaysPrintPrimitiveFields:   *TranscodingAlwaysPrintPrimitiveFields,
+		TranscodingAlwaysPrintEnumsAsInts:       *TranscodingAlwaysPrintEnumsAsInts,
+		TranscodingPreserveProtoFieldNames:      *TranscodingPreserveProtoFieldNames,
+		TranscodingIgnoreUnknownQueryParameters: *TranscodingIgnoreUnknownQueryParameters,
+		TranscodingMatchIncomingRequestRoute:    *TranscodingMatchIncomingRequestRoute,
+		TranscodingDisableAutoMapping:           *TranscodingDisableAutoMapping,
+
+		Http3Port:              *Http3Port,
+		Http3SslServerCertPath: *Http3SslServerCertPath,
+		Http3AltSvcMaxAge:      *Http3AltSvcMaxAge,
+
+		EnableResponseCompression:           *EnableResponseCompression,
+		ResponseCompressionMinContentLength: uint32(*ResponseCompressionMinContentLength),
+		ResponseCompressionContentTypes:     *ResponseCompressionContentTypes,
+		ResponseCompressionLevel:            *ResponseCompressionLevel,
+
+		ResponseCacheMaxEntries:   uint32(*ResponseCacheMaxEntries),
+		ResponseCacheMaxBodyBytes: uint32(*ResponseCacheMaxBodyBytes),
+
+		UnmatchedPathBody:        *UnmatchedPathBody,
+		UnmatchedPathContentType: *UnmatchedPathContentType,
+		UnmatchedPathRedirectUrl: *UnmatchedPathRedirectUrl,
+		UnmatchedPathToBackend:   *UnmatchedPathToBackend,
+
+		ErrorResponseFormat: *ErrorResponseFormat,
+
+		BackendCircuitBreaker: options.CircuitBreakerOptions{
+			MaxConnections:     uint32(*BackendMaxConnections),
+			MaxPendingRequests: uint32(*BackendMaxPendingRequests),
+			MaxRequests:        uint32(*BackendMaxRequests),
+			MaxRetries:         uint32(*BackendMaxRetries),
+		},
+		BackendOutlierDetection: options.OutlierDetectionOptions{
+			Consecutive5xx:     uint32(*BackendOutlierConsecutive5xx),
+			Interval:           float64(*BackendOutlierIntervalS),
+			BaseEjectionTime:   float64(*BackendOutlierBaseEjectionTimeS),
+			MaxEjectionPercent: uint32(*BackendOutlierMaxEjectionPercent),
+		},
+		BackendHealthCheck: options.HealthCheckOptions{
+			Path:               *BackendHealthCheckPath,
+			Grpc:               *BackendHealthCheckGrpc,
+			GrpcService:        *BackendHealthCheckGrpcService,
+			Interval:           float64(*BackendHealthCheckIntervalS),
+			Timeout:            float64(*BackendHealthCheckTimeoutS),
+			HealthyThreshold:   uint32(*BackendHealthCheckHealthyThreshold),
+			UnhealthyThreshold: uint32(*BackendHealthCheckUnhealthyThreshold),
+		},
+		BackendConnectionPool: options.ConnectionPoolOptions{
+			MaxConcurrentStreams:        uint32(*BackendMaxConcurrentStreams),
+			InitialStreamWindowSize:     uint32(*BackendInitialStreamWindowSize),
+			InitialConnectionWindowSize: uint32(*BackendInitialConnectionWindowSize),
+			IdleTimeout:                 float64(*BackendIdleTimeoutS),
+			MaxRequestsPerConnection:    uint32(*BackendMaxRequestsPerConnection),
+			TcpKeepaliveTime:            uint32(*BackendTcpKeepaliveTimeS),
+			TcpKeepaliveInterval:        uint32(*BackendTcpKeepaliveIntervalS),
+			TcpKeepaliveProbes:          uint32(*BackendTcpKeepaliveProbes),
+		},
+		BackendLoadBalancing: options.LoadBalancingOptions{
+			Policy:        *BackendLbPolicy,
+			HashHeader:    *BackendHashHeader,
+			HashCookie:    *BackendHashCookie,
+			HashCookieTtl: float64(*BackendHashCookieTtlS),
+			HashSourceIp:  *BackendHashSourceIp,
+		},
+
+		SslServerCertSds:           *SslServerCertSds,
+		SslServerAcmeDirectoryUrl:  *SslServerAcmeDirectoryUrl,
+		SslServerAcmeDomains:       *SslServerAcmeDomains,
+		SslServerAcmeEmail:         *SslServerAcmeEmail,
+		SslServerAcmeHttpPort:      *SslServerAcmeHttpPort,
+		SslServerAcmeChallengePort: *SslServerAcmeChallengePort,
+
+		SslServerClientCaPath:            *SslServerClientCaPath,
+		SslServerRequireClientCert:       *SslServerRequireClientCert,
+		SslServerClientSpiffeTrustDomain: *SslServerClientSpiffeTrustDomain,
+		SslServerForwardClientCert:       *SslServerForwardClientCert,
+
+		TracingOperationSampleRates: *TracingOperationSampleRates,
+		TracingClientSampleRate:     *TracingClientSampleRate,
+		TracingCustomTags:           *TracingCustomTags,
+
+		AccessLogPath:              *AccessLogPath,
+		AccessLogFormat:            *AccessLogFormat,
+		AccessLogJsonFormat:        *AccessLogJsonFormat,
+		AccessLogServiceUri:        *AccessLogServiceUri,
+		AccessLogServiceLogName:    *AccessLogServiceLogName,
+		AccessLogMaxBytes:          *AccessLogMaxBytes,
+		AccessLogMaxFiles:          *AccessLogMaxFiles,
+		AccessLogDisabledSelectors: *AccessLogDisabledSelectors,
+
+		CorsPreflightOperations:         *CorsPreflightOperations,
+		CorsPreflightRequireAuth:        *CorsPreflightRequireAuth,
+		CorsPreflightSkipServiceControl: *CorsPreflightSkipServiceControl,
+
+		KubernetesNamespace:     *KubernetesNamespace,
+		KubernetesClusterDomain: *KubernetesClusterDomain,
 	}
 
-	glog.Infof("Config Generator options: %+v", opts)
-	return opts
+	// There is no Google Service Control to check and report the requests to
+	// in the standalone profile, only a user-hosted server called without the
+	// Google access token.
+	if opts.Standalone && opts.ServiceControlAuth == "access_token" {
+		opts.SkipServiceControlFilter = true
+	}
+	if opts.KubernetesNamespace == autoKubernetesNamespace {
+		namespace, err := ioutil.ReadFile(kubernetesNamespacePath)
+		if err != nil {
+			errs.Addf("Set --kubernetes_namespace to the namespace of the pod, or mount its service account token.", "fail to read the namespace of the pod: %v", err)
+		}
+		opts.KubernetesNamespace = strings.TrimSpace(string(namespace))
+	}
+
+	if opts.SslServerAcmeDirectoryUrl != "" {
+		if opts.SslServerCertPath == "" || opts.SslServerAcmeDomains == "" {
+			errs.Addf("", "--ssl_server_acme_directory_url requires --ssl_server_cert_path and --ssl_server_acme_domains")
+		}
+		opts.SslServerCertSds = true
+	}
+	if opts.SslServerCertSds && opts.SslServerCertPath == "" {
+		errs.Addf("", "--ssl_server_cert_sds requires --ssl_server_cert_path")
+	}
+	if opts.SslServerClientCaPath == "" && (opts.SslServerRequireClientCert || opts.SslServerClientSpiffeTrustDomain != "" || opts.SslServerForwardClientCert) {
+		errs.Addf("", "--ssl_server_require_client_cert, --ssl_server_client_spiffe_trust_domain and --ssl_server_forward_client_cert require --ssl_server_client_ca_path")
+	}
+	if opts.SslServerClientCaPath != "" && opts.SslServerCertPath == "" {
+		errs.Addf("", "--ssl_server_client_ca_path requires --ssl_server_cert_path")
+	}
+
+	if opts.TracingClientSampleRate < 0.0 || opts.TracingClientSampleRate > 1.0 {
+		errs.Addf("", "--tracing_client_sample_rate must be >= 0.0 and <= 1.0")
+	}
+	if opts.TracingCustomTags != "" {
+		for _, tag := range strings.Split(opts.TracingCustomTags, ",") {
+			if !util.TracingCustomTags[strings.TrimSpace(tag)] {
+				errs.Addf("", "unknown tag %q in --tracing_custom_tags, it must be one of (api_name|api_version|operation_name|api_key_project|consumer_id)", tag)
+			}
+		}
+	}
+
+	if opts.AccessLogFormat != "" && opts.AccessLogJsonFormat != "" {
+		errs.Addf("", "--access_log_format and --access_log_json_format cannot both be set")
+	}
+	if (opts.AccessLogFormat != "" || opts.AccessLogJsonFormat != "" || opts.AccessLogMaxBytes != 0) && opts.AccessLogPath == "" {
+		errs.Addf("", "--access_log_format, --access_log_json_format and --access_log_max_bytes require --access_log_path")
+	}
+	if opts.AccessLogJsonFormat != "" {
+		fields := make(map[string]string)
+		if err := json.Unmarshal([]byte(opts.AccessLogJsonFormat), &fields); err != nil {
+			errs.Addf("", "fail to parse --access_log_json_format, it must be a JSON object of strings: %v", err)
+		}
+	}
+	if opts.AccessLogMaxBytes < 0 || opts.AccessLogMaxFiles < 1 {
+		errs.Addf("", "--access_log_max_bytes must be >= 0 and --access_log_max_files must be > 0")
+	}
+
+	if *MaxRequestBodyBytes > math.MaxUint32 || *MaxResponseBodyBytes > math.MaxUint32 {
+		errs.Addf("", "--max_request_body_bytes and --max_response_body_bytes must be at most %v", uint32(math.MaxUint32))
+	}
+	opts.MaxRequestBodyBytes = uint32(*MaxRequestBodyBytes)
+	opts.MaxResponseBodyBytes = uint32(*MaxResponseBodyBytes)
+
+	if *RateLimitRequestsPerSecond > math.MaxUint32 || *RateLimitBurst > math.MaxUint32 {
+		errs.Addf("", "--rate_limit_requests_per_second and --rate_limit_burst must be at most %v", uint32(math.MaxUint32))
+	}
+	opts.RateLimitRequestsPerSecond = uint32(*RateLimitRequestsPerSecond)
+	opts.RateLimitBurst = uint32(*RateLimitBurst)
+
+	if *BackendOAuth2Config != "" {
+		backendOAuth2, err := loadBackendOAuth2Options(*BackendOAuth2Config)
+		if err != nil {
+			errs.Addf("", "fail to load --backend_oauth2_config: %v", err)
+		}
+		opts.BackendOAuth2 = backendOAuth2
+	}
+
+	if *BackendClusterConfig != "" {
+		backendClusters, err := loadBackendClusterOptions(*BackendClusterConfig)
+		if err != nil {
+			errs.Addf("", "fail to load --backend_cluster_config: %v", err)
+		}
+		opts.BackendClusters = backendClusters
+	}
+
+	if *OutboundTlsConfig != "" {
+		outboundTls, err := loadOutboundTlsOptions(*OutboundTlsConfig)
+		if err != nil {
+			errs.Addf("", "fail to load --outbound_tls_config: %v", err)
+		}
+		opts.OutboundTls = outboundTls
+	}
+
+	if *BackendRetryConfig != "" {
+		backendRetry, err := loadBackendRetryOptions(*BackendRetryConfig)
+		if err != nil {
+			errs.Addf("", "fail to load --backend_retry_config: %v", err)
+		}
+		opts.BackendRetry = backendRetry
+	}
+
+	if *HeaderRulesConfig != "" {
+		headerRules, err := loadHeaderRuleOptions(*HeaderRulesConfig)
+		if err != nil {
+			errs.Addf("", "fail to load --header_rules_config: %v", err)
+		}
+		opts.HeaderRules = headerRules
+	}
+
+	if *PathRewritesConfig != "" {
+		pathRewrites, err := loadPathRewriteOptions(*PathRewritesConfig)
+		if err != nil {
+			errs.Addf("", "fail to load --path_rewrites_config: %v", err)
+		}
+		opts.PathRewrites = pathRewrites
+	}
+
+	if *ResponseCacheConfig != "" {
+		responseCaches, err := loadResponseCacheOptions(*ResponseCacheConfig)
+		if err != nil {
+			errs.Addf("", "fail to load --response_cache_config: %v", err)
+		}
+		opts.ResponseCaches = responseCaches
+	}
+
+	if *CorsPoliciesConfig != "" {
+		corsPolicies, err := loadCorsPolicyOptions(*CorsPoliciesConfig)
+		if err != nil {
+			errs.Addf("", "fail to load --cors_policies_config: %v", err)
+		}
+		opts.CorsPolicies = corsPolicies
+	}
+
+	if *ErrorResponseTemplatesConfig != "" {
+		errorResponseTemplates, err := loadErrorResponseTemplateOptions(*ErrorResponseTemplatesConfig)
+		if err != nil {
+			errs.Addf("", "fail to load --error_response_templates_config: %v", err)
+		}
+		opts.ErrorResponseTemplates = errorResponseTemplates
+	}
+
+	if *BackendSplitsConfig != "" {
+		backendSplits, err := loadBackendSplitOptions(*BackendSplitsConfig)
+		if err != nil {
+			errs.Addf("", "fail to load --backend_splits_config: %v", err)
+		}
+		opts.BackendSplits = backendSplits
+	}
+
+	if *BackendMirrorsConfig != "" {
+		backendMirrors, err := loadBackendMirrorOptions(*BackendMirrorsConfig)
+		if err != nil {
+			errs.Addf("", "fail to load --backend_mirrors_config: %v", err)
+		}
+		opts.BackendMirrors = backendMirrors
+	}
+
+	if *FaultInjectionsConfig != "" {
+		faultInjections, err := loadFaultInjectionOptions(*FaultInjectionsConfig)
+		if err != nil {
+			errs.Addf("", "fail to load --fault_injections_config: %v", err)
+		}
+		opts.FaultInjections = faultInjections
+	}
+
+	if *DirectResponsesConfig != "" {
+		directResponses, err := loadDirectResponseOptions(*DirectResponsesConfig)
+		if err != nil {
+			errs.Addf("", "fail to load --direct_responses_config: %v", err)
+		}
+		opts.DirectResponses = directResponses
+	}
+
+	if *BodySizeLimitsConfig != "" {
+		bodySizeLimits, err := loadBodySizeLimitOptions(*BodySizeLimitsConfig)
+		if err != nil {
+			errs.Addf("", "fail to load --body_size_limits_config: %v", err)
+		}
+		opts.BodySizeLimits = bodySizeLimits
+	}
+
+	if *ApiKeyLocationsConfig != "" {
+		apiKeyLocations, err := loadApiKeyLocationOptions(*ApiKeyLocationsConfig)
+		if err != nil {
+			errs.Addf("", "fail to load --api_key_locations_config: %v", err)
+		}
+		opts.ApiKeyLocationsConfig = apiKeyLocations
+	}
+
+	if *JwtClaimHeadersConfig != "" {
+		jwtClaimHeaders, err := loadJwtClaimHeaderOptions(*JwtClaimHeadersConfig)
+		if err != nil {
+			errs.Addf("", "fail to load --jwt_claim_headers_config: %v", err)
+		}
+		opts.JwtClaimHeadersConfig = jwtClaimHeaders
+	}
+
+	if *AuthorizationRulesConfig != "" {
+		authorizationRules, err := loadAuthorizationRuleOptions(*AuthorizationRulesConfig)
+		if err != nil {
+			errs.Addf("", "fail to load --authorization_rules_config: %v", err)
+		}
+		opts.AuthorizationRules = authorizationRules
+	}
+
+	if *RateLimitsConfig != "" {
+		rateLimits, err := loadRateLimitOptions(*RateLimitsConfig)
+		if err != nil {
+			errs.Addf("", "fail to load --rate_limits_config: %v", err)
+		}
+		opts.RateLimits = rateLimits
+	}
+
+	if *IpAclConfig != "" {
+		ipAcls, err := loadIpAclOptions(*IpAclConfig)
+		if err != nil {
+			errs.Addf("", "fail to load --ip_acl_config: %v", err)
+		}
+		opts.IpAcls = ipAcls
+	}
+
+	if *JwksProviderConfig != "" {
+		jwksProviders, err := loadJwksProviderOptions(*JwksProviderConfig)
+		if err != nil {
+			errs.Addf("", "fail to load --jwks_provider_config: %v", err)
+		}
+		opts.JwksProviders = jwksProviders
+	}
+
+	if *SslServerSniConfig != "" {
+		if opts.SslServerCertPath == "" {
+			errs.Addf("", "--ssl_server_sni_config requires --ssl_server_cert_path")
+		}
+		sniCertificates, err := loadSniCertificateOptions(*SslServerSniConfig)
+		if err != nil {
+			errs.Addf("", "fail to load --ssl_server_sni_config: %v", err)
+		}
+		opts.SslServerSniCertificates = sniCertificates
+	}
+
+	checkEnvoyConfigOptions(opts, errs)
+
+	logging.Infof("Config Generator options: %+v", opts)
+	return opts, errs
+}
+
+// checkEnvoyConfigOptions checks the flags which would otherwise only fail
+// when the config is generated or loaded by Envoy.
+func checkEnvoyConfigOptions(opts options.ConfigGeneratorOptions, errs *Errors) {
+	if _, _, _, _, err := util.ParseURI(opts.BackendAddress); err != nil {
+		errs.Addf("Set it to the URL of the backend like http://127.0.0.1:8082 or grpc://127.0.0.1:8082.", "invalid --backend_address %q: %v", opts.BackendAddress, err)
+	}
+	if err := opts.BackendOutlierDetection.Validate(); err != nil {
+		errs.Addf("", "invalid --backend_outlier_* flags: %v", err)
+	}
+	if err := opts.BackendHealthCheck.Validate(); err != nil {
+		errs.Addf("", "invalid --backend_health_check_* flags: %v", err)
+	} else if opts.BackendHealthCheck.Interval == 0 || opts.BackendHealthCheck.Timeout == 0 || opts.BackendHealthCheck.HealthyThreshold == 0 || opts.BackendHealthCheck.UnhealthyThreshold == 0 {
+		errs.Addf("", "--backend_health_check_interval_s, --backend_health_check_timeout_s, --backend_health_check_healthy_threshold and --backend_health_check_unhealthy_threshold must be > 0")
+	}
+	if err := opts.BackendConnectionPool.Validate(); err != nil {
+		errs.Addf("", "invalid backend connection pool flags: %v", err)
+	}
+	if err := opts.BackendLoadBalancing.Validate(); err != nil {
+		errs.Addf("", "invalid --backend_lb_policy and --backend_hash_* flags: %v", err)
+	}
+	switch opts.ResponseCompressionLevel {
+	case "default", "best", "speed":
+	default:
+		errs.Addf(`Set it to "default", "best" or "speed".`, "invalid --response_compression_level %q", opts.ResponseCompressionLevel)
+	}
+	if opts.ResponseCacheMaxEntries == 0 || opts.ResponseCacheMaxBodyBytes == 0 {
+		errs.Addf("", "--response_cache_max_entries and --response_cache_max_body_bytes must be > 0")
+	}
+	unmatchedPathActions := 0
+	for _, set := range []bool{opts.UnmatchedPathBody != "", opts.UnmatchedPathRedirectUrl != "", opts.UnmatchedPathToBackend} {
+		if set {
+			unmatchedPathActions++
+		}
+	}
+	if unmatchedPathActions > 1 {
+		errs.Addf("", "at most one of --unmatched_path_body, --unmatched_path_redirect_url and --unmatched_path_to_backend can be set")
+	}
+	if opts.UnmatchedPathContentType != "" && opts.UnmatchedPathBody == "" {
+		errs.Addf("", "--unmatched_path_content_type requires --unmatched_path_body")
+	}
+	switch opts.ServiceControlProtocol {
+	case "http", "grpc":
+	default:
+		errs.Addf(`Set it to "http" or "grpc".`, "invalid --service_control_protocol %q", opts.ServiceControlProtocol)
+	}
+	for name, path := range map[string]string{
+		"service_control_check_path":  opts.ServiceControlCheckPath,
+		"service_control_quota_path":  opts.ServiceControlQuotaPath,
+		"service_control_report_path": opts.ServiceControlReportPath,
+	} {
+		if path != "" && !strings.HasPrefix(path, "/") {
+			errs.Addf(`Set it to an absolute path like "/v1/services/{service}:check".`, "invalid --%s %q", name, path)
+		}
+	}
+	switch opts.ServiceControlAuth {
+	case "access_token", "none":
+	case "header":
+		if opts.ServiceControlAuthHeaderName == "" || opts.ServiceControlAuthHeaderValuePath == "" {
+			errs.Addf("Set the header name and the file of its value.", "--service_control_auth=header requires --service_control_auth_header_name and --service_control_auth_header_value_path")
+		}
+		errs.CheckReadable("service_control_auth_header_value_path", opts.ServiceControlAuthHeaderValuePath)
+	default:
+		errs.Addf(`Set it to "access_token", "none" or "header".`, "invalid --service_control_auth %q", opts.ServiceControlAuth)
+	}
+	// The tokens calling IAM are fetched from the metadata server without a
+	// service account key.
+	if opts.Standalone && opts.BackendAuthCredentials != nil && opts.ServiceAccountKey == "" {
+		errs.Addf("Set --service_account_key to a service account key JSON file.", "--standalone requires --service_account_key with --backend_auth_iam_service_account")
+	}
+	switch opts.ErrorResponseFormat {
+	case "", "json", "problem_json":
+	default:
+		errs.Addf(`Set it to "json" or "problem_json".`, "invalid --error_response_format %q", opts.ErrorResponseFormat)
+	}
+	switch opts.RequestIdMode {
+	case "generate", "preserve", "none":
+	default:
+		errs.Addf(`Set it to "generate", "preserve" or "none".`, "invalid --request_id_mode %q", opts.RequestIdMode)
+	}
+	if strings.HasPrefix(opts.RequestIdHeader, ":") || strings.EqualFold(opts.RequestIdHeader, util.RequestIdHeader) {
+		errs.Addf("", "invalid --request_id_header %q", opts.RequestIdHeader)
+	}
+	if strings.HasPrefix(opts.ListenerAddress, util.UnixSocketScheme) {
+		if _, _, ok := util.ParseUnixSocketURI(opts.ListenerAddress); !ok {
+			errs.Addf("Set it to the path of a Unix domain socket like unix:/var/run/esp.sock.", "invalid --listener_address %q", opts.ListenerAddress)
+		}
+		if opts.Http3Port != 0 || opts.SslServerAcmeDirectoryUrl != "" {
+			errs.Addf("", "--http3_port and --ssl_server_acme_directory_url cannot be used with a Unix domain socket in --listener_address")
+		}
+	}
+	if opts.TrustedProxyCidrs != "" {
+		for _, cidr := range strings.Split(opts.TrustedProxyCidrs, ",") {
+			if _, _, err := net.ParseCIDR(strings.TrimSpace(cidr)); err != nil {
+				errs.Addf("Set it to CIDR ranges like 10.0.0.0/8.", "invalid --trusted_proxy_cidrs %q", cidr)
+			}
+		}
+	}
+	errs.CheckURL("service_management_url", opts.ServiceManagementURL, "https", "http")
+	errs.CheckURL("service_control_url", opts.ServiceControlURL, "https", "http")
+	errs.CheckURL("metadata_url", opts.MetadataURL, "http", "https")
+	errs.CheckURL("iam_url", opts.IamURL, "https", "http")
+	errs.CheckURL("http_proxy", opts.HttpProxy, "http", "https")
+	errs.CheckURL("unmatched_path_redirect_url", opts.UnmatchedPathRedirectUrl, "https", "http")
+	errs.CheckURL("https_proxy", opts.HttpsProxy, "http", "https")
+
+	errs.CheckReadable("service_account_key", opts.ServiceAccountKey)
+	errs.CheckReadable("root_certs_path", opts.RootCertsPath)
+	errs.CheckReadable("backend_ca_path", opts.BackendCaPath)
+	errs.CheckReadable("ssl_server_client_ca_path", opts.SslServerClientCaPath)
+	// The certificate is issued later by the ACME server.
+	if opts.SslServerAcmeDirectoryUrl == "" {
+		errs.CheckReadable("ssl_server_cert_path", opts.SslServerCertPath, util.ServerSslFileName(opts.SslServerCertPath)+".crt", util.ServerSslFileName(opts.SslServerCertPath)+".key")
+	}
+	errs.CheckReadable("http3_ssl_server_cert_path", opts.Http3SslServerCertPath, util.ServerSslFileName(opts.Http3SslServerCertPath)+".crt", util.ServerSslFileName(opts.Http3SslServerCertPath)+".key")
+}
+
+// loadBackendOAuth2Options reads the OAuth2 client credentials grants of the
+// backends from the JSON file in --backend_oauth2_config.
+func loadBackendOAuth2Options(path string) ([]*options.BackendOAuth2Options, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var backendOAuth2 []*options.BackendOAuth2Options
+	if err := json.Unmarshal(data, &backendOAuth2); err != nil {
+		return nil, fmt.Errorf("fail to unmarshal %s: %v", path, err)
+	}
+	for i, o := range backendOAuth2 {
+		if o.BackendAddress == "" || o.TokenURL == "" || o.ClientID == "" {
+			return nil, fmt.Errorf("backend_address, token_url and client_id are required, missing in entry %d", i)
+		}
+		if _, _, _, _, err := util.ParseURI(o.BackendAddress); err != nil {
+			return nil, fmt.Errorf("invalid backend_address %s: %v", o.BackendAddress, err)
+		}
+	}
+	return backendOAuth2, nil
+}
+
+// loadBackendClusterOptions reads the cluster settings of the backends from
+// the JSON file in --backend_cluster_config.
+func loadBackendClusterOptions(path string) ([]*options.BackendClusterOptions, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var backendClusters []*options.BackendClusterOptions
+	if err := json.Unmarshal(data, &backendClusters); err != nil {
+		return nil, fmt.Errorf("fail to unmarshal %s: %v", path, err)
+	}
+	addresses := make(map[string]bool)
+	for i, o := range backendClusters {
+		if o.BackendAddress == "" {
+			return nil, fmt.Errorf("backend_address is required, missing in entry %d", i)
+		}
+		_, hostname, port, _, err := util.ParseURI(o.BackendAddress)
+		if err != nil {
+			return nil, fmt.Errorf("invalid backend_address %s: %v", o.BackendAddress, err)
+		}
+		address := fmt.Sprintf("%v:%v", hostname, port)
+		if addresses[address] {
+			return nil, fmt.Errorf("duplicate cluster settings for backend %s", address)
+		}
+		addresses[address] = true
+		if o.ConnectTimeout < 0 {
+			return nil, fmt.Errorf("connect_timeout of backend %s must be >= 0, got %v", address, o.ConnectTimeout)
+		}
+		switch o.DnsLookupFamily {
+		case "", "auto", "v4only", "v6only":
+		default:
+			return nil, fmt.Errorf(`dns_lookup_family of backend %s must be "auto", "v4only" or "v6only", got %q`, address, o.DnsLookupFamily)
+		}
+		if o.DnsRefreshRate < 0 {
+			return nil, fmt.Errorf("dns_refresh_rate of backend %s must be >= 0, got %v", address, o.DnsRefreshRate)
+		}
+		if len(o.Endpoints) > 0 && len(o.RegionAddresses) > 0 {
+			return nil, fmt.Errorf("endpoints and region_addresses of backend %s cannot be set together", address)
+		}
+		if o.OutlierDetection != nil {
+			if err := o.OutlierDetection.Validate(); err != nil {
+				return nil, fmt.Errorf("backend %s: %v", address, err)
+			}
+		}
+		if o.HealthCheck != nil {
+			if err := o.HealthCheck.Validate(); err != nil {
+				return nil, fmt.Errorf("backend %s: %v", address, err)
+			}
+		}
+		if o.ConnectionPool != nil {
+			if err := o.ConnectionPool.Validate(); err != nil {
+				return nil, fmt.Errorf("connection_pool of backend %s: %v", address, err)
+			}
+		}
+		if o.LoadBalancing != nil {
+			if err := o.LoadBalancing.Validate(); err != nil {
+				return nil, fmt.Errorf("backend %s: %v", address, err)
+			}
+		}
+	}
+	return backendClusters, nil
+}
+
+// loadOutboundTlsOptions reads the TLS settings of the dependencies from the
+// JSON file in --outbound_tls_config.
+func loadOutboundTlsOptions(path string) ([]*options.OutboundTlsOptions, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var outboundTls []*options.OutboundTlsOptions
+	if err := json.Unmarshal(data, &outboundTls); err != nil {
+		return nil, fmt.Errorf("fail to unmarshal %s: %v", path, err)
+	}
+	dependencies := make(map[string]bool)
+	for i, o := range outboundTls {
+		switch o.Dependency {
+		case options.ServiceManagementDependency, options.ServiceControlDependency, options.IamDependency, options.JwksDependency:
+		default:
+			return nil, fmt.Errorf(`invalid dependency %q in entry %d, must be one of "%s", "%s", "%s" or "%s"`, o.Dependency, i,
+				options.ServiceManagementDependency, options.ServiceControlDependency, options.IamDependency, options.JwksDependency)
+		}
+		if dependencies[o.Dependency] {
+			return nil, fmt.Errorf("duplicate TLS settings for dependency %s", o.Dependency)
+		}
+		dependencies[o.Dependency] = true
+		if (o.ClientCertPath == "") != (o.ClientKeyPath == "") {
+			return nil, fmt.Errorf("client_cert_path and client_key_path of dependency %s must be set together", o.Dependency)
+		}
+		if err := util.ValidateTlsVersions(o.MinVersion, o.MaxVersion); err != nil {
+			return nil, fmt.Errorf("dependency %s: %v", o.Dependency, err)
+		}
+	}
+	return outboundTls, nil
+}
+
+// loadBackendRetryOptions reads the retry policies of the operations from the
+// JSON file in --backend_retry_config.
+func loadBackendRetryOptions(path string) ([]*options.BackendRetryOptions, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var backendRetry []*options.BackendRetryOptions
+	if err := json.Unmarshal(data, &backendRetry); err != nil {
+		return nil, fmt.Errorf("fail to unmarshal %s: %v", path, err)
+	}
+	selectors := make(map[string]bool)
+	for i, o := range backendRetry {
+		if o.Selector == "" || o.RetryOn == "" {
+			return nil, fmt.Errorf("selector and retry_on are required, missing in entry %d", i)
+		}
+		if selectors[o.Selector] {
+			return nil, fmt.Errorf("duplicate retry policy for selector %s", o.Selector)
+		}
+		selectors[o.Selector] = true
+		if o.PerTryTimeout < 0 {
+			return nil, fmt.Errorf("negative per_try_timeout %v for selector %s", o.PerTryTimeout, o.Selector)
+		}
+		if o.Hedge && o.PerTryTimeout == 0 {
+			return nil, fmt.Errorf("hedge requires per_try_timeout for selector %s", o.Selector)
+		}
+	}
+	return backendRetry, nil
+}
+
+// loadHeaderRuleOptions reads the header rules by operation from the JSON
+// file in --header_rules_config.
+func loadHeaderRuleOptions(path string) ([]*options.HeaderRuleOptions, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var headerRules []*options.HeaderRuleOptions
+	if err := json.Unmarshal(data, &headerRules); err != nil {
+		return nil, fmt.Errorf("fail to unmarshal %s: %v", path, err)
+	}
+	selectors := make(map[string]bool)
+	for i, o := range headerRules {
+		if err := o.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid entry %d: %v", i, err)
+		}
+		if selectors[o.Selector] {
+			return nil, fmt.Errorf("duplicate header rule for selector %s", o.Selector)
+		}
+		selectors[o.Selector] = true
+	}
+	return headerRules, nil
+}
+
+// loadPathRewriteOptions reads the rewrites of the paths by operation from the
+// JSON file in --path_rewrites_config.
+func loadPathRewriteOptions(path string) ([]*options.PathRewriteOptions, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var pathRewrites []*options.PathRewriteOptions
+	if err := json.Unmarshal(data, &pathRewrites); err != nil {
+		return nil, fmt.Errorf("fail to unmarshal %s: %v", path, err)
+	}
+	selectors := make(map[string]bool)
+	for i, o := range pathRewrites {
+		if err := o.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid entry %d: %v", i, err)
+		}
+		if selectors[o.Selector] {
+			return nil, fmt.Errorf("duplicate path rewrite for selector %s", o.Selector)
+		}
+		selectors[o.Selector] = true
+	}
+	return pathRewrites, nil
+}
+
+// loadResponseCacheOptions reads the caches of the responses by operation from
+// the JSON file in --response_cache_config.
+func loadResponseCacheOptions(path string) ([]*options.ResponseCacheOptions, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var responseCaches []*options.ResponseCacheOptions
+	if err := json.Unmarshal(data, &responseCaches); err != nil {
+		return nil, fmt.Errorf("fail to unmarshal %s: %v", path, err)
+	}
+	selectors := make(map[string]bool)
+	for i, o := range responseCaches {
+		if err := o.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid entry %d: %v", i, err)
+		}
+		if selectors[o.Selector] {
+			return nil, fmt.Errorf("duplicate response cache for selector %s", o.Selector)
+		}
+		selectors[o.Selector] = true
+	}
+	return responseCaches, nil
+}
+
+// loadErrorResponseTemplateOptions reads the templates of the error responses
+// by status code from the JSON file in --error_response_templates_config.
+func loadErrorResponseTemplateOptions(path string) ([]*options.ErrorResponseTemplateOptions, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var templates []*options.ErrorResponseTemplateOptions
+	if err := json.Unmarshal(data, &templates); err != nil {
+		return nil, fmt.Errorf("fail to unmarshal %s: %v", path, err)
+	}
+	statuses := make(map[uint32]bool)
+	for i, o := range templates {
+		if err := o.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid entry %d: %v", i, err)
+		}
+		if statuses[o.Status] {
+			return nil, fmt.Errorf("duplicate error response template for status %d", o.Status)
+		}
+		statuses[o.Status] = true
+	}
+	return templates, nil
+}
+
+// loadCorsPolicyOptions reads the CORS policies by operation from the JSON
+// file in --cors_policies_config.
+func loadCorsPolicyOptions(path string) ([]*options.CorsPolicyOptions, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var corsPolicies []*options.CorsPolicyOptions
+	if err := json.Unmarshal(data, &corsPolicies); err != nil {
+		return nil, fmt.Errorf("fail to unmarshal %s: %v", path, err)
+	}
+	selectors := make(map[string]bool)
+	for i, o := range corsPolicies {
+		if err := o.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid entry %d: %v", i, err)
+		}
+		if selectors[o.Selector] {
+			return nil, fmt.Errorf("duplicate CORS policy for selector %s", o.Selector)
+		}
+		selectors[o.Selector] = true
+	}
+	return corsPolicies, nil
+}
+
+// loadIpAclOptions reads the IP ACLs by operation from the JSON file in
+// --ip_acl_config.
+func loadIpAclOptions(path string) ([]*options.IpAclOptions, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var ipAcls []*options.IpAclOptions
+	if err := json.Unmarshal(data, &ipAcls); err != nil {
+		return nil, fmt.Errorf("fail to unmarshal %s: %v", path, err)
+	}
+	selectors := make(map[string]bool)
+	for i, o := range ipAcls {
+		if err := o.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid entry %d: %v", i, err)
+		}
+		if selectors[o.Selector] {
+			return nil, fmt.Errorf("duplicate IP ACL for selector %s", o.Selector)
+		}
+		selectors[o.Selector] = true
+	}
+	return ipAcls, nil
+}
+
+// loadBackendSplitOptions reads the splits of the requests of the operations
+// between weighted backends from the JSON file in --backend_splits_config.
+func loadBackendSplitOptions(path string) ([]*options.BackendSplitOptions, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var backendSplits []*options.BackendSplitOptions
+	if err := json.Unmarshal(data, &backendSplits); err != nil {
+		return nil, fmt.Errorf("fail to unmarshal %s: %v", path, err)
+	}
+	selectors := make(map[string]bool)
+	for i, o := range backendSplits {
+		if o.Selector == "" || len(o.Backends) == 0 {
+			return nil, fmt.Errorf("selector and backends are required, missing in entry %d", i)
+		}
+		if selectors[o.Selector] {
+			return nil, fmt.Errorf("duplicate backend split for selector %s", o.Selector)
+		}
+		selectors[o.Selector] = true
+	}
+	return backendSplits, nil
+}
+
+// loadBackendMirrorOptions reads the mirrors of the requests of the operations
+// to secondary backends from the JSON file in --backend_mirrors_config.
+func loadBackendMirrorOptions(path string) ([]*options.BackendMirrorOptions, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var backendMirrors []*options.BackendMirrorOptions
+	if err := json.Unmarshal(data, &backendMirrors); err != nil {
+		return nil, fmt.Errorf("fail to unmarshal %s: %v", path, err)
+	}
+	selectors := make(map[string]bool)
+	for i, o := range backendMirrors {
+		if o.Selector == "" || o.Address == "" {
+			return nil, fmt.Errorf("selector and address are required, missing in entry %d", i)
+		}
+		if selectors[o.Selector] {
+			return nil, fmt.Errorf("duplicate backend mirror for selector %s", o.Selector)
+		}
+		selectors[o.Selector] = true
+		if o.Fraction < 0 || o.Fraction > 1 {
+			return nil, fmt.Errorf("fraction of the backend mirror for selector %s must be between 0 and 1, got %v", o.Selector, o.Fraction)
+		}
+	}
+	return backendMirrors, nil
+}
+
+// loadFaultInjectionOptions reads the faults injected in the requests of the
+// operations from the JSON file in --fault_injections_config.
+func loadFaultInjectionOptions(path string) ([]*options.FaultInjectionOptions, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var faultInjections []*options.FaultInjectionOptions
+	if err := json.Unmarshal(data, &faultInjections); err != nil {
+		return nil, fmt.Errorf("fail to unmarshal %s: %v", path, err)
+	}
+	selectors := make(map[string]bool)
+	for i, o := range faultInjections {
+		if o.Selector == "" {
+			return nil, fmt.Errorf("selector is required, missing in entry %d", i)
+		}
+		if selectors[o.Selector] {
+			return nil, fmt.Errorf("duplicate fault injection for selector %s", o.Selector)
+		}
+		selectors[o.Selector] = true
+		if err := o.Validate(); err != nil {
+			return nil, fmt.Errorf("selector %s: %v", o.Selector, err)
+		}
+	}
+	return faultInjections, nil
+}
+
+// loadDirectResponseOptions reads the canned responses of the operations from
+// the JSON file in --direct_responses_config.
+func loadDirectResponseOptions(path string) ([]*options.DirectResponseOptions, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var directResponses []*options.DirectResponseOptions
+	if err := json.Unmarshal(data, &directResponses); err != nil {
+		return nil, fmt.Errorf("fail to unmarshal %s: %v", path, err)
+	}
+	selectors := make(map[string]bool)
+	for i, o := range directResponses {
+		if o.Selector == "" {
+			return nil, fmt.Errorf("selector is required, missing in entry %d", i)
+		}
+		if selectors[o.Selector] {
+			return nil, fmt.Errorf("duplicate direct response for selector %s", o.Selector)
+		}
+		selectors[o.Selector] = true
+		if err := o.Validate(); err != nil {
+			return nil, fmt.Errorf("selector %s: %v", o.Selector, err)
+		}
+	}
+	return directResponses, nil
+}
+
+// loadBodySizeLimitOptions reads the maximum sizes of the request and response
+// bodies by operation from the JSON file in --body_size_limits_config.
+func loadBodySizeLimitOptions(path string) ([]*options.BodySizeLimitOptions, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var bodySizeLimits []*options.BodySizeLimitOptions
+	if err := json.Unmarshal(data, &bodySizeLimits); err != nil {
+		return nil, fmt.Errorf("fail to unmarshal %s: %v", path, err)
+	}
+	selectors := make(map[string]bool)
+	for i, o := range bodySizeLimits {
+		if o.Selector == "" {
+			return nil, fmt.Errorf("selector is required, missing in entry %d", i)
+		}
+		if selectors[o.Selector] {
+			return nil, fmt.Errorf("duplicate body size limits for selector %s", o.Selector)
+		}
+		selectors[o.Selector] = true
+		if o.MaxRequestBodyBytes == nil && o.MaxResponseBodyBytes == nil {
+			return nil, fmt.Errorf("max_request_body_bytes or max_response_body_bytes is required for selector %s", o.Selector)
+		}
+	}
+	return bodySizeLimits, nil
+}
+
+// loadApiKeyLocationOptions reads the API key locations by operation from the
+// JSON file in --api_key_locations_config.
+func loadApiKeyLocationOptions(path string) ([]*options.ApiKeyLocationOptions, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var apiKeyLocations []*options.ApiKeyLocationOptions
+	if err := json.Unmarshal(data, &apiKeyLocations); err != nil {
+		return nil, fmt.Errorf("fail to unmarshal %s: %v", path, err)
+	}
+	selectors := make(map[string]bool)
+	for i, o := range apiKeyLocations {
+		if o.Selector == "" || len(o.Locations) == 0 {
+			return nil, fmt.Errorf("selector and locations are required, missing in entry %d", i)
+		}
+		if selectors[o.Selector] {
+			return nil, fmt.Errorf("duplicate API key locations for selector %s", o.Selector)
+		}
+		selectors[o.Selector] = true
+	}
+	return apiKeyLocations, nil
+}
+
+// loadJwtClaimHeaderOptions reads the claims forwarded in request headers by
+// operation from the JSON file in --jwt_claim_headers_config.
+func loadJwtClaimHeaderOptions(path string) ([]*options.JwtClaimHeaderOptions, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var jwtClaimHeaders []*options.JwtClaimHeaderOptions
+	if err := json.Unmarshal(data, &jwtClaimHeaders); err != nil {
+		return nil, fmt.Errorf("fail to unmarshal %s: %v", path, err)
+	}
+	selectors := make(map[string]bool)
+	for i, o := range jwtClaimHeaders {
+		if o.Selector == "" || len(o.ClaimHeaders) == 0 {
+			return nil, fmt.Errorf("selector and claim_headers are required, missing in entry %d", i)
+		}
+		if selectors[o.Selector] {
+			return nil, fmt.Errorf("duplicate JWT claim headers for selector %s", o.Selector)
+		}
+		selectors[o.Selector] = true
+	}
+	return jwtClaimHeaders, nil
+}
+
+// loadAuthorizationRuleOptions reads the authorization rules by operation from
+// the JSON file in --authorization_rules_config.
+func loadAuthorizationRuleOptions(path string) ([]*options.AuthorizationRuleOptions, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var authorizationRules []*options.AuthorizationRuleOptions
+	if err := json.Unmarshal(data, &authorizationRules); err != nil {
+		return nil, fmt.Errorf("fail to unmarshal %s: %v", path, err)
+	}
+	selectors := make(map[string]bool)
+	for i, o := range authorizationRules {
+		if o.Selector == "" || len(o.Allow) == 0 {
+			return nil, fmt.Errorf("selector and allow are required, missing in entry %d", i)
+		}
+		if selectors[o.Selector] {
+			return nil, fmt.Errorf("duplicate authorization rules for selector %s", o.Selector)
+		}
+		selectors[o.Selector] = true
+	}
+	return authorizationRules, nil
+}
+
+// loadRateLimitOptions reads the limits of the requests by operation from the
+// JSON file in --rate_limits_config.
+func loadRateLimitOptions(path string) ([]*options.RateLimitOptions, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var rateLimits []*options.RateLimitOptions
+	if err := json.Unmarshal(data, &rateLimits); err != nil {
+		return nil, fmt.Errorf("fail to unmarshal %s: %v", path, err)
+	}
+	selectors := make(map[string]bool)
+	for i, o := range rateLimits {
+		if o.Selector == "" {
+			return nil, fmt.Errorf("selector is required, missing in entry %d", i)
+		}
+		if selectors[o.Selector] {
+			return nil, fmt.Errorf("duplicate rate limits for selector %s", o.Selector)
+		}
+		selectors[o.Selector] = true
+	}
+	return rateLimits, nil
+}
+
+// loadJwksProviderOptions reads the overrides of the JWKS fetching by JWT
+// provider from the JSON file in --jwks_provider_config.
+func loadJwksProviderOptions(path string) ([]*options.JwksProviderOptions, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var jwksProviders []*options.JwksProviderOptions
+	if err := json.Unmarshal(data, &jwksProviders); err != nil {
+		return nil, fmt.Errorf("fail to unmarshal %s: %v", path, err)
+	}
+	providerIds := make(map[string]bool)
+	for i, o := range jwksProviders {
+		if o.ProviderId == "" {
+			return nil, fmt.Errorf("provider_id is required, missing in entry %d", i)
+		}
+		if providerIds[o.ProviderId] {
+			return nil, fmt.Errorf("duplicate JWKS options for provider %s", o.ProviderId)
+		}
+		providerIds[o.ProviderId] = true
+		if o.CacheDurationInS != nil && *o.CacheDurationInS <= 0 {
+			return nil, fmt.Errorf("cache_duration_in_s of provider %s must be positive", o.ProviderId)
+		}
+		if o.FetchRetries != nil && *o.FetchRetries < 0 {
+			return nil, fmt.Errorf("fetch_retries of provider %s must not be negative", o.ProviderId)
+		}
+	}
+	return jwksProviders, nil
+}
+
+// loadSniCertificateOptions reads the certificates served by server name from
+// the JSON file in --ssl_server_sni_config.
+func loadSniCertificateOptions(path string) ([]*options.SniCertificateOptions, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var sniCertificates []*options.SniCertificateOptions
+	if err := json.Unmarshal(data, &sniCertificates); err != nil {
+		return nil, fmt.Errorf("fail to unmarshal %s: %v", path, err)
+	}
+	serverNames := make(map[string]bool)
+	for i, o := range sniCertificates {
+		if len(o.ServerNames) == 0 || o.CertPath == "" || o.KeyPath == "" {
+			return nil, fmt.Errorf("server_names, cert_path and key_path are required, missing in entry %d", i)
+		}
+		for _, serverName := range o.ServerNames {
+			if serverNames[serverName] {
+				return nil, fmt.Errorf("duplicate certificates for server name %s", serverName)
+			}
+			serverNames[serverName] = true
+		}
+	}
+	return sniCertificates, nil
 }
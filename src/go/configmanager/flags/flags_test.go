@@ -15,7 +15,10 @@
 package flags
 
 import (
+	"io/ioutil"
+	"os"
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/GoogleCloudPlatform/esp-v2/src/go/options"
@@ -30,3 +33,1256 @@ func TestDefaultEnvoyConfigOptions(t *testing.T) {
 			defaultOptions, actualOptions)
 	}
 }
+
+func TestLoadBackendOAuth2Options(t *testing.T) {
+	testData := []struct {
+		desc        string
+		config      string
+		wantOptions []*options.BackendOAuth2Options
+		wantError   string
+	}{
+		{
+			desc: "Success, load the OAuth2 client credentials of the backends",
+			config: `[{"backend_address": "https://api.example.com", "token_url": "https://auth.example.com/token",
+				"client_id": "client-id", "client_secret": "client-secret", "scopes": ["read"],
+				"endpoint_params": {"audience": "https://api.example.com"}}]`,
+			wantOptions: []*options.BackendOAuth2Options{
+				{
+					BackendAddress: "https://api.example.com",
+					TokenURL:       "https://auth.example.com/token",
+					ClientID:       "client-id",
+					ClientSecret:   "client-secret",
+					Scopes:         []string{"read"},
+					EndpointParams: map[string]string{"audience": "https://api.example.com"},
+				},
+			},
+		},
+		{
+			desc:      "Failure, invalid JSON",
+			config:    `{"backend_address": "https://api.example.com"}`,
+			wantError: "fail to unmarshal",
+		},
+		{
+			desc:      "Failure, missing token_url",
+			config:    `[{"backend_address": "https://api.example.com", "client_id": "client-id"}]`,
+			wantError: "backend_address, token_url and client_id are required, missing in entry 0",
+		},
+		{
+			desc:      "Failure, invalid backend_address",
+			config:    `[{"backend_address": "api.example.com:abc", "token_url": "https://auth.example.com/token", "client_id": "client-id"}]`,
+			wantError: "invalid backend_address api.example.com:abc",
+		},
+	}
+
+	for _, tc := range testData {
+		configFile, err := ioutil.TempFile("", "backend_oauth2")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(configFile.Name())
+		configFile.WriteString(tc.config)
+		configFile.Close()
+
+		gotOptions, err := loadBackendOAuth2Options(configFile.Name())
+		if tc.wantError != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.wantError) {
+				t.Errorf("Test Desc(%s): got error %v, want error containing %q", tc.desc, err, tc.wantError)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Test Desc(%s): unexpected error: %v", tc.desc, err)
+			continue
+		}
+		if !reflect.DeepEqual(gotOptions, tc.wantOptions) {
+			t.Errorf("Test Desc(%s): got options %v, want %v", tc.desc, gotOptions, tc.wantOptions)
+		}
+	}
+}
+
+func TestLoadBackendClusterOptions(t *testing.T) {
+	testData := []struct {
+		desc        string
+		config      string
+		wantOptions []*options.BackendClusterOptions
+		wantError   string
+	}{
+		{
+			desc: "Success, load the cluster settings of the backends",
+			config: `[{"backend_address": "https://api.example.com", "connect_timeout": 2.5, "ca_path": "/etc/backend/ca.crt",
+				"mtls_cert_path": "/etc/backend/client.crt", "mtls_key_path": "/etc/backend/client.key",
+				"verify_subject_alt_names": ["api.example.com"], "sni": "api.internal"},
+				{"backend_address": "http://10.0.0.1:8080", "connect_timeout": 1}]`,
+			wantOptions: []*options.BackendClusterOptions{
+				{
+					BackendAddress:        "https://api.example.com",
+					ConnectTimeout:        2.5,
+					CaPath:                "/etc/backend/ca.crt",
+					MtlsCertPath:          "/etc/backend/client.crt",
+					MtlsKeyPath:           "/etc/backend/client.key",
+					VerifySubjectAltNames: []string{"api.example.com"},
+					Sni:                   "api.internal",
+				},
+				{
+					BackendAddress: "http://10.0.0.1:8080",
+					ConnectTimeout: 1,
+				},
+			},
+		},
+		{
+			desc:      "Failure, missing backend_address",
+			config:    `[{"connect_timeout": 1}]`,
+			wantError: "backend_address is required, missing in entry 0",
+		},
+		{
+			desc:      "Failure, duplicate backends with the same host and port",
+			config:    `[{"backend_address": "https://api.example.com"}, {"backend_address": "https://api.example.com:443/v1"}]`,
+			wantError: "duplicate cluster settings for backend api.example.com:443",
+		},
+		{
+			desc:      "Failure, negative connect_timeout",
+			config:    `[{"backend_address": "https://api.example.com", "connect_timeout": -1}]`,
+			wantError: "connect_timeout of backend api.example.com:443 must be >= 0",
+		},
+		{
+			desc:      "Failure, invalid dns_lookup_family",
+			config:    `[{"backend_address": "http://onprem.example.com:8080", "dns_lookup_family": "v5only"}]`,
+			wantError: `dns_lookup_family of backend onprem.example.com:8080 must be "auto", "v4only" or "v6only", got "v5only"`,
+		},
+		{
+			desc:      "Failure, both endpoints and region_addresses",
+			config:    `[{"backend_address": "https://api.example.com", "endpoints": ["api-2.example.com"], "region_addresses": ["https://api-eu.example.com"]}]`,
+			wantError: "endpoints and region_addresses of backend api.example.com:443 cannot be set together",
+		},
+		{
+			desc: "Success, load the circuit breaker and outlier detection of a backend",
+			config: `[{"backend_address": "http://onprem.example.com:8080", "circuit_breaker": {"max_connections": 100, "max_pending_requests": 10},
+				"outlier_detection": {"consecutive_5xx": 5, "base_ejection_time": 60}}]`,
+			wantOptions: []*options.BackendClusterOptions{
+				{
+					BackendAddress: "http://onprem.example.com:8080",
+					CircuitBreaker: &options.CircuitBreakerOptions{
+						MaxConnections:     100,
+						MaxPendingRequests: 10,
+					},
+					OutlierDetection: &options.OutlierDetectionOptions{
+						Consecutive5xx:   5,
+						BaseEjectionTime: 60,
+					},
+				},
+			},
+		},
+		{
+			desc:      "Failure, max_ejection_percent over 100",
+			config:    `[{"backend_address": "http://onprem.example.com:8080", "outlier_detection": {"consecutive_5xx": 5, "max_ejection_percent": 150}}]`,
+			wantError: "backend onprem.example.com:8080: outlier detection max_ejection_percent must be <= 100, got 150",
+		},
+		{
+			desc:      "Failure, health check path not starting with /",
+			config:    `[{"backend_address": "http://onprem.example.com:8080", "health_check": {"path": "healthz"}}]`,
+			wantError: `backend onprem.example.com:8080: health check path must start with /, got "healthz"`,
+		},
+		{
+			desc:      "Failure, HTTP/2 window size under the minimum",
+			config:    `[{"backend_address": "grpc://onprem.example.com:8080", "connection_pool": {"initial_stream_window_size": 1024}}]`,
+			wantError: "connection_pool of backend onprem.example.com:8080: initial_stream_window_size must be between 65535 and 2147483647, got 1024",
+		},
+	}
+
+	for _, tc := range testData {
+		configFile, err := ioutil.TempFile("", "backend_cluster")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(configFile.Name())
+		configFile.WriteString(tc.config)
+		configFile.Close()
+
+		gotOptions, err := loadBackendClusterOptions(configFile.Name())
+		if tc.wantError != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.wantError) {
+				t.Errorf("Test Desc(%s): got error %v, want error containing %q", tc.desc, err, tc.wantError)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Test Desc(%s): unexpected error: %v", tc.desc, err)
+			continue
+		}
+		if !reflect.DeepEqual(gotOptions, tc.wantOptions) {
+			t.Errorf("Test Desc(%s): got options %v, want %v", tc.desc, gotOptions, tc.wantOptions)
+		}
+	}
+}
+
+func TestLoadOutboundTlsOptions(t *testing.T) {
+	testData := []struct {
+		desc        string
+		config      string
+		wantOptions []*options.OutboundTlsOptions
+		wantError   string
+	}{
+		{
+			desc: "Success, load the TLS settings of the dependencies",
+			config: `[{"dependency": "servicemanagement", "ca_path": "/etc/pki/ca.crt", "min_version": "TLSv1_2"},
+				{"dependency": "iam", "client_cert_path": "/etc/pki/client.crt", "client_key_path": "/etc/pki/client.key",
+				"min_version": "TLSv1_2", "max_version": "TLSv1_3"}]`,
+			wantOptions: []*options.OutboundTlsOptions{
+				{
+					Dependency: "servicemanagement",
+					CaPath:     "/etc/pki/ca.crt",
+					MinVersion: "TLSv1_2",
+				},
+				{
+					Dependency:     "iam",
+					ClientCertPath: "/etc/pki/client.crt",
+					ClientKeyPath:  "/etc/pki/client.key",
+					MinVersion:     "TLSv1_2",
+					MaxVersion:     "TLSv1_3",
+				},
+			},
+		},
+		{
+			desc:      "Failure, unknown dependency",
+			config:    `[{"dependency": "metadata"}]`,
+			wantError: `invalid dependency "metadata" in entry 0`,
+		},
+		{
+			desc:      "Failure, duplicate dependency",
+			config:    `[{"dependency": "jwks"}, {"dependency": "jwks"}]`,
+			wantError: "duplicate TLS settings for dependency jwks",
+		},
+		{
+			desc:      "Failure, client certificate without key",
+			config:    `[{"dependency": "servicecontrol", "client_cert_path": "/etc/pki/client.crt"}]`,
+			wantError: "client_cert_path and client_key_path of dependency servicecontrol must be set together",
+		},
+		{
+			desc:      "Failure, minimum version above maximum version",
+			config:    `[{"dependency": "servicecontrol", "min_version": "TLSv1_3", "max_version": "TLSv1_2"}]`,
+			wantError: "minimum TLS version TLSv1_3 is above the maximum TLS version TLSv1_2",
+		},
+		{
+			desc:      "Failure, invalid version",
+			config:    `[{"dependency": "servicecontrol", "min_version": "1.2"}]`,
+			wantError: `invalid TLS version "1.2"`,
+		},
+	}
+
+	for _, tc := range testData {
+		configFile, err := ioutil.TempFile("", "outbound_tls")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(configFile.Name())
+		configFile.WriteString(tc.config)
+		configFile.Close()
+
+		gotOptions, err := loadOutboundTlsOptions(configFile.Name())
+		if tc.wantError != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.wantError) {
+				t.Errorf("Test Desc(%s): got error %v, want error containing %q", tc.desc, err, tc.wantError)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Test Desc(%s): unexpected error: %v", tc.desc, err)
+			continue
+		}
+		if !reflect.DeepEqual(gotOptions, tc.wantOptions) {
+			t.Errorf("Test Desc(%s): got options %v, want %v", tc.desc, gotOptions, tc.wantOptions)
+		}
+	}
+}
+
+func TestLoadBackendRetryOptions(t *testing.T) {
+	testData := []struct {
+		desc        string
+		config      string
+		wantOptions []*options.BackendRetryOptions
+		wantError   string
+	}{
+		{
+			desc: "Success, load the retry policies of the operations",
+			config: `[{"selector": "*", "num_retries": 2, "retry_on": "5xx"},
+				{"selector": "bookstore.ListShelves", "num_retries": 3, "retry_on": "reset,connect-failure", "per_try_timeout": 1.5}]`,
+			wantOptions: []*options.BackendRetryOptions{
+				{
+					Selector:   "*",
+					NumRetries: 2,
+					RetryOn:    "5xx",
+				},
+				{
+					Selector:      "bookstore.ListShelves",
+					NumRetries:    3,
+					RetryOn:       "reset,connect-failure",
+					PerTryTimeout: 1.5,
+				},
+			},
+		},
+		{
+			desc:      "Failure, missing retry_on",
+			config:    `[{"selector": "*", "num_retries": 2}]`,
+			wantError: "selector and retry_on are required, missing in entry 0",
+		},
+		{
+			desc:      "Failure, duplicate selector",
+			config:    `[{"selector": "*", "retry_on": "5xx"}, {"selector": "*", "retry_on": "reset"}]`,
+			wantError: "duplicate retry policy for selector *",
+		},
+		{
+			desc:      "Failure, negative per_try_timeout",
+			config:    `[{"selector": "*", "retry_on": "5xx", "per_try_timeout": -1}]`,
+			wantError: "negative per_try_timeout -1 for selector *",
+		},
+		{
+			desc:      "Failure, hedge without per_try_timeout",
+			config:    `[{"selector": "bookstore.ListShelves", "retry_on": "5xx", "hedge": true}]`,
+			wantError: "hedge requires per_try_timeout for selector bookstore.ListShelves",
+		},
+	}
+
+	for _, tc := range testData {
+		configFile, err := ioutil.TempFile("", "backend_retry")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(configFile.Name())
+		configFile.WriteString(tc.config)
+		configFile.Close()
+
+		gotOptions, err := loadBackendRetryOptions(configFile.Name())
+		if tc.wantError != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.wantError) {
+				t.Errorf("Test Desc(%s): got error %v, want error containing %q", tc.desc, err, tc.wantError)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Test Desc(%s): unexpected error: %v", tc.desc, err)
+			continue
+		}
+		if !reflect.DeepEqual(gotOptions, tc.wantOptions) {
+			t.Errorf("Test Desc(%s): got options %v, want %v", tc.desc, gotOptions, tc.wantOptions)
+		}
+	}
+}
+
+func TestLoadHeaderRuleOptions(t *testing.T) {
+	testData := []struct {
+		desc        string
+		config      string
+		wantOptions []*options.HeaderRuleOptions
+		wantError   string
+	}{
+		{
+			desc: "Success, load the header rules",
+			config: `[{"selector": "*", "response_headers_to_remove": ["server"]},
+				{"selector": "bookstore.ListShelves", "request_headers_to_add": [{"name": "x-env", "value": "prod", "overwrite": true}]}]`,
+			wantOptions: []*options.HeaderRuleOptions{
+				{
+					Selector:                "*",
+					ResponseHeadersToRemove: []string{"server"},
+				},
+				{
+					Selector: "bookstore.ListShelves",
+					RequestHeadersToAdd: []*options.HeaderValueOptions{
+						{
+							Name:      "x-env",
+							Value:     "prod",
+							Overwrite: true,
+						},
+					},
+				},
+			},
+		},
+		{
+			desc:      "Failure, missing selector",
+			config:    `[{"request_headers_to_remove": ["x-debug"]}]`,
+			wantError: "invalid entry 0: selector is required",
+		},
+		{
+			desc:      "Failure, missing header name",
+			config:    `[{"selector": "*", "response_headers_to_add": [{"value": "v"}]}]`,
+			wantError: "header name is required for selector *",
+		},
+		{
+			desc:      "Failure, pseudo-header",
+			config:    `[{"selector": "*", "request_headers_to_remove": [":path"]}]`,
+			wantError: "pseudo-header :path cannot be modified for selector *",
+		},
+		{
+			desc:      "Failure, duplicate selector",
+			config:    `[{"selector": "*"}, {"selector": "*"}]`,
+			wantError: "duplicate header rule for selector *",
+		},
+	}
+
+	for _, tc := range testData {
+		configFile, err := ioutil.TempFile("", "header_rules")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(configFile.Name())
+		configFile.WriteString(tc.config)
+		configFile.Close()
+
+		gotOptions, err := loadHeaderRuleOptions(configFile.Name())
+		if tc.wantError != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.wantError) {
+				t.Errorf("Test Desc(%s): got error %v, want error containing %q", tc.desc, err, tc.wantError)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Test Desc(%s): unexpected error: %v", tc.desc, err)
+			continue
+		}
+		if !reflect.DeepEqual(gotOptions, tc.wantOptions) {
+			t.Errorf("Test Desc(%s): got options %v, want %v", tc.desc, gotOptions, tc.wantOptions)
+		}
+	}
+}
+
+func TestLoadPathRewriteOptions(t *testing.T) {
+	testData := []struct {
+		desc        string
+		config      string
+		wantOptions []*options.PathRewriteOptions
+		wantError   string
+	}{
+		{
+			desc: "Success, load the path rewrites",
+			config: `[{"selector": "bookstore.ListShelves", "prefix": "/v1/", "prefix_rewrite": "/api/"},
+				{"selector": "bookstore.GetShelf", "regex": "^/v1/shelves/([0-9]+)$", "substitution": "/shelf/\\1"}]`,
+			wantOptions: []*options.PathRewriteOptions{
+				{
+					Selector:      "bookstore.ListShelves",
+					Prefix:        "/v1/",
+					PrefixRewrite: "/api/",
+				},
+				{
+					Selector:     "bookstore.GetShelf",
+					Regex:        "^/v1/shelves/([0-9]+)$",
+					Substitution: `/shelf/\1`,
+				},
+			},
+		},
+		{
+			desc:      "Failure, both prefix and regex",
+			config:    `[{"selector": "bookstore.ListShelves", "prefix": "/v1/", "regex": "^/v1/"}]`,
+			wantError: "exactly one of prefix and regex is required for selector bookstore.ListShelves",
+		},
+		{
+			desc:      "Failure, relative prefix",
+			config:    `[{"selector": "bookstore.ListShelves", "prefix": "v1/"}]`,
+			wantError: "prefix v1/ must start with / for selector bookstore.ListShelves",
+		},
+		{
+			desc:      "Failure, invalid regex",
+			config:    `[{"selector": "bookstore.GetShelf", "regex": "^/v1/shelves/([0-9]+$"}]`,
+			wantError: "invalid regex for selector bookstore.GetShelf",
+		},
+		{
+			desc:      "Failure, duplicate selector",
+			config:    `[{"selector": "bookstore.ListShelves", "prefix": "/v1/"}, {"selector": "bookstore.ListShelves", "prefix": "/v2/"}]`,
+			wantError: "duplicate path rewrite for selector bookstore.ListShelves",
+		},
+	}
+
+	for _, tc := range testData {
+		configFile, err := ioutil.TempFile("", "path_rewrites")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(configFile.Name())
+		configFile.WriteString(tc.config)
+		configFile.Close()
+
+		gotOptions, err := loadPathRewriteOptions(configFile.Name())
+		if tc.wantError != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.wantError) {
+				t.Errorf("Test Desc(%s): got error %v, want error containing %q", tc.desc, err, tc.wantError)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Test Desc(%s): unexpected error: %v", tc.desc, err)
+			continue
+		}
+		if !reflect.DeepEqual(gotOptions, tc.wantOptions) {
+			t.Errorf("Test Desc(%s): got options %v, want %v", tc.desc, gotOptions, tc.wantOptions)
+		}
+	}
+}
+
+func TestLoadResponseCacheOptions(t *testing.T) {
+	testData := []struct {
+		desc        string
+		config      string
+		wantOptions []*options.ResponseCacheOptions
+		wantError   string
+	}{
+		{
+			desc: "Success, load the response caches",
+			config: `[{"selector": "*", "ttl": 60},
+				{"selector": "bookstore.ListShelves", "key_headers": ["accept-language"], "include_api_key": true}]`,
+			wantOptions: []*options.ResponseCacheOptions{
+				{
+					Selector: "*",
+					Ttl:      60,
+				},
+				{
+					Selector:      "bookstore.ListShelves",
+					KeyHeaders:    []string{"accept-language"},
+					IncludeApiKey: true,
+				},
+			},
+		},
+		{
+			desc:      "Failure, negative ttl",
+			config:    `[{"selector": "bookstore.ListShelves", "ttl": -1}]`,
+			wantError: "ttl must be >= 0 for selector bookstore.ListShelves",
+		},
+		{
+			desc:      "Failure, pseudo key header",
+			config:    `[{"selector": "bookstore.ListShelves", "key_headers": [":authority"]}]`,
+			wantError: `invalid key header ":authority" for selector bookstore.ListShelves`,
+		},
+		{
+			desc:      "Failure, duplicate selector",
+			config:    `[{"selector": "*"}, {"selector": "*", "ttl": 10}]`,
+			wantError: "duplicate response cache for selector *",
+		},
+	}
+
+	for _, tc := range testData {
+		configFile, err := ioutil.TempFile("", "response_cache")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(configFile.Name())
+		configFile.WriteString(tc.config)
+		configFile.Close()
+
+		gotOptions, err := loadResponseCacheOptions(configFile.Name())
+		if tc.wantError != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.wantError) {
+				t.Errorf("Test Desc(%s): got error %v, want error containing %q", tc.desc, err, tc.wantError)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Test Desc(%s): unexpected error: %v", tc.desc, err)
+			continue
+		}
+		if !reflect.DeepEqual(gotOptions, tc.wantOptions) {
+			t.Errorf("Test Desc(%s): got options %v, want %v", tc.desc, gotOptions, tc.wantOptions)
+		}
+	}
+}
+
+func TestLoadCorsPolicyOptions(t *testing.T) {
+	testData := []struct {
+		desc        string
+		config      string
+		wantOptions []*options.CorsPolicyOptions
+		wantError   string
+	}{
+		{
+			desc: "Success, load the CORS policies",
+			config: `[{"selector": "*", "allow_origin_regexes": ["https://.*\\.example\\.com"]},
+				{"selector": "bookstore.ListShelves", "allow_origin_regexes": [".*"], "allow_methods": ["GET"], "allow_headers": ["authorization"],
+				"expose_headers": ["x-request-id"], "max_age": 600, "allow_credentials": true}]`,
+			wantOptions: []*options.CorsPolicyOptions{
+				{
+					Selector:           "*",
+					AllowOriginRegexes: []string{`https://.*\.example\.com`},
+				},
+				{
+					Selector:           "bookstore.ListShelves",
+					AllowOriginRegexes: []string{".*"},
+					AllowMethods:       []string{"GET"},
+					AllowHeaders:       []string{"authorization"},
+					ExposeHeaders:      []string{"x-request-id"},
+					MaxAge:             600,
+					AllowCredentials:   true,
+				},
+			},
+		},
+		{
+			desc:      "Failure, no origin regexes",
+			config:    `[{"selector": "bookstore.ListShelves", "allow_methods": ["GET"]}]`,
+			wantError: "allow_origin_regexes is required for selector bookstore.ListShelves",
+		},
+		{
+			desc:      "Failure, invalid origin regex",
+			config:    `[{"selector": "bookstore.ListShelves", "allow_origin_regexes": ["https://(.*"]}]`,
+			wantError: `invalid origin regex "https://(.*" for selector bookstore.ListShelves`,
+		},
+		{
+			desc:      "Failure, duplicate selector",
+			config:    `[{"selector": "*", "allow_origin_regexes": [".*"]}, {"selector": "*", "allow_origin_regexes": ["https://.*"]}]`,
+			wantError: "duplicate CORS policy for selector *",
+		},
+	}
+
+	for _, tc := range testData {
+		configFile, err := ioutil.TempFile("", "cors_policies")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(configFile.Name())
+		configFile.WriteString(tc.config)
+		configFile.Close()
+
+		gotOptions, err := loadCorsPolicyOptions(configFile.Name())
+		if tc.wantError != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.wantError) {
+				t.Errorf("Test Desc(%s): got error %v, want error containing %q", tc.desc, err, tc.wantError)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Test Desc(%s): unexpected error: %v", tc.desc, err)
+			continue
+		}
+		if !reflect.DeepEqual(gotOptions, tc.wantOptions) {
+			t.Errorf("Test Desc(%s): got options %v, want %v", tc.desc, gotOptions, tc.wantOptions)
+		}
+	}
+}
+
+func TestLoadIpAclOptions(t *testing.T) {
+	testData := []struct {
+		desc        string
+		config      string
+		wantOptions []*options.IpAclOptions
+		wantError   string
+	}{
+		{
+			desc: "Success, load the IP ACLs",
+			config: `[{"selector": "*", "deny": ["10.1.0.0/16"]},
+				{"selector": "bookstore.ListShelves", "allow": ["10.0.0.0/8", "2001:db8::/32"], "deny": ["10.2.0.0/16"]}]`,
+			wantOptions: []*options.IpAclOptions{
+				{
+					Selector: "*",
+					Deny:     []string{"10.1.0.0/16"},
+				},
+				{
+					Selector: "bookstore.ListShelves",
+					Allow:    []string{"10.0.0.0/8", "2001:db8::/32"},
+					Deny:     []string{"10.2.0.0/16"},
+				},
+			},
+		},
+		{
+			desc:      "Failure, no ranges",
+			config:    `[{"selector": "bookstore.ListShelves"}]`,
+			wantError: "allow or deny is required for selector bookstore.ListShelves",
+		},
+		{
+			desc:      "Failure, IP address instead of CIDR range",
+			config:    `[{"selector": "bookstore.ListShelves", "deny": ["10.0.0.1"]}]`,
+			wantError: `invalid CIDR range "10.0.0.1" for selector bookstore.ListShelves`,
+		},
+		{
+			desc:      "Failure, duplicate selector",
+			config:    `[{"selector": "*", "allow": ["10.0.0.0/8"]}, {"selector": "*", "deny": ["10.1.0.0/16"]}]`,
+			wantError: "duplicate IP ACL for selector *",
+		},
+	}
+
+	for _, tc := range testData {
+		configFile, err := ioutil.TempFile("", "ip_acl")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(configFile.Name())
+		configFile.WriteString(tc.config)
+		configFile.Close()
+
+		gotOptions, err := loadIpAclOptions(configFile.Name())
+		if tc.wantError != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.wantError) {
+				t.Errorf("Test Desc(%s): got error %v, want error containing %q", tc.desc, err, tc.wantError)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Test Desc(%s): unexpected error: %v", tc.desc, err)
+			continue
+		}
+		if !reflect.DeepEqual(gotOptions, tc.wantOptions) {
+			t.Errorf("Test Desc(%s): got options %v, want %v", tc.desc, gotOptions, tc.wantOptions)
+		}
+	}
+}
+
+func TestLoadErrorResponseTemplateOptions(t *testing.T) {
+	testData := []struct {
+		desc        string
+		config      string
+		wantOptions []*options.ErrorResponseTemplateOptions
+		wantError   string
+	}{
+		{
+			desc: "Success, load the error response templates",
+			config: `[{"status": 404, "body": "{\"error\": \"%MESSAGE%\"}"},
+				{"status": 503, "body": "<error>%CODE%</error>", "content_type": "application/xml"}]`,
+			wantOptions: []*options.ErrorResponseTemplateOptions{
+				{
+					Status: 404,
+					Body:   `{"error": "%MESSAGE%"}`,
+				},
+				{
+					Status:      503,
+					Body:        "<error>%CODE%</error>",
+					ContentType: "application/xml",
+				},
+			},
+		},
+		{
+			desc:      "Failure, status not an error",
+			config:    `[{"status": 200, "body": "ok"}]`,
+			wantError: "status 200 must be a 4xx or 5xx",
+		},
+		{
+			desc:      "Failure, duplicate status",
+			config:    `[{"status": 404, "body": "a"}, {"status": 404, "body": "b"}]`,
+			wantError: "duplicate error response template for status 404",
+		},
+	}
+
+	for _, tc := range testData {
+		configFile, err := ioutil.TempFile("", "error_response_templates")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(configFile.Name())
+		configFile.WriteString(tc.config)
+		configFile.Close()
+
+		gotOptions, err := loadErrorResponseTemplateOptions(configFile.Name())
+		if tc.wantError != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.wantError) {
+				t.Errorf("Test Desc(%s): got error %v, want error containing %q", tc.desc, err, tc.wantError)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Test Desc(%s): unexpected error: %v", tc.desc, err)
+			continue
+		}
+		if !reflect.DeepEqual(gotOptions, tc.wantOptions) {
+			t.Errorf("Test Desc(%s): got options %v, want %v", tc.desc, gotOptions, tc.wantOptions)
+		}
+	}
+}
+
+func TestLoadBackendSplitOptions(t *testing.T) {
+	testData := []struct {
+		desc        string
+		config      string
+		wantOptions []*options.BackendSplitOptions
+		wantError   string
+	}{
+		{
+			desc: "Success, load the splits of the operations",
+			config: `[{"selector": "bookstore.ListShelves", "backends": [
+				{"name": "v1", "address": "https://v1.bookstore.com", "weight": 90},
+				{"name": "v2", "address": "https://v2.bookstore.com", "weight": 10}]}]`,
+			wantOptions: []*options.BackendSplitOptions{
+				{
+					Selector: "bookstore.ListShelves",
+					Backends: []*options.WeightedBackendOptions{
+						{
+							Name:    "v1",
+							Address: "https://v1.bookstore.com",
+							Weight:  90,
+						},
+						{
+							Name:    "v2",
+							Address: "https://v2.bookstore.com",
+							Weight:  10,
+						},
+					},
+				},
+			},
+		},
+		{
+			desc:      "Failure, missing backends",
+			config:    `[{"selector": "bookstore.ListShelves"}]`,
+			wantError: "selector and backends are required, missing in entry 0",
+		},
+		{
+			desc: "Failure, duplicate selector",
+			config: `[{"selector": "bookstore.ListShelves", "backends": [{"name": "v1", "address": "https://v1.bookstore.com", "weight": 1}]},
+				{"selector": "bookstore.ListShelves", "backends": [{"name": "v2", "address": "https://v2.bookstore.com", "weight": 1}]}]`,
+			wantError: "duplicate backend split for selector bookstore.ListShelves",
+		},
+	}
+
+	for _, tc := range testData {
+		configFile, err := ioutil.TempFile("", "backend_splits")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(configFile.Name())
+		configFile.WriteString(tc.config)
+		configFile.Close()
+
+		gotOptions, err := loadBackendSplitOptions(configFile.Name())
+		if tc.wantError != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.wantError) {
+				t.Errorf("Test Desc(%s): got error %v, want error containing %q", tc.desc, err, tc.wantError)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Test Desc(%s): unexpected error: %v", tc.desc, err)
+			continue
+		}
+		if !reflect.DeepEqual(gotOptions, tc.wantOptions) {
+			t.Errorf("Test Desc(%s): got options %v, want %v", tc.desc, gotOptions, tc.wantOptions)
+		}
+	}
+}
+
+func TestLoadBodySizeLimitOptions(t *testing.T) {
+	uint32Ptr := func(v uint32) *uint32 { return &v }
+	testData := []struct {
+		desc        string
+		config      string
+		wantOptions []*options.BodySizeLimitOptions
+		wantError   string
+	}{
+		{
+			desc: "Success, load the body size limits of the operations",
+			config: `[{"selector": "bookstore.CreateBook", "max_request_body_bytes": 1048576},
+				{"selector": "bookstore.GetBook", "max_request_body_bytes": 0, "max_response_body_bytes": 4096}]`,
+			wantOptions: []*options.BodySizeLimitOptions{
+				{
+					Selector:            "bookstore.CreateBook",
+					MaxRequestBodyBytes: uint32Ptr(1048576),
+				},
+				{
+					Selector:             "bookstore.GetBook",
+					MaxRequestBodyBytes:  uint32Ptr(0),
+					MaxResponseBodyBytes: uint32Ptr(4096),
+				},
+			},
+		},
+		{
+			desc:      "Failure, missing selector",
+			config:    `[{"max_request_body_bytes": 1024}]`,
+			wantError: "selector is required, missing in entry 0",
+		},
+		{
+			desc:      "Failure, duplicate selector",
+			config:    `[{"selector": "bookstore.GetBook", "max_request_body_bytes": 1024}, {"selector": "bookstore.GetBook", "max_response_body_bytes": 1024}]`,
+			wantError: "duplicate body size limits for selector bookstore.GetBook",
+		},
+		{
+			desc:      "Failure, no limit",
+			config:    `[{"selector": "bookstore.GetBook"}]`,
+			wantError: "max_request_body_bytes or max_response_body_bytes is required for selector bookstore.GetBook",
+		},
+		{
+			desc:      "Failure, negative limit",
+			config:    `[{"selector": "bookstore.GetBook", "max_request_body_bytes": -1}]`,
+			wantError: "fail to unmarshal",
+		},
+	}
+
+	for _, tc := range testData {
+		configFile, err := ioutil.TempFile("", "body_size_limits")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(configFile.Name())
+		configFile.WriteString(tc.config)
+		configFile.Close()
+
+		gotOptions, err := loadBodySizeLimitOptions(configFile.Name())
+		if tc.wantError != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.wantError) {
+				t.Errorf("Test Desc(%s): got error %v, want error containing %q", tc.desc, err, tc.wantError)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Test Desc(%s): unexpected error: %v", tc.desc, err)
+			continue
+		}
+		if !reflect.DeepEqual(gotOptions, tc.wantOptions) {
+			t.Errorf("Test Desc(%s): got options %v, want %v", tc.desc, gotOptions, tc.wantOptions)
+		}
+	}
+}
+
+func TestLoadApiKeyLocationOptions(t *testing.T) {
+	testData := []struct {
+		desc        string
+		config      string
+		wantOptions []*options.ApiKeyLocationOptions
+		wantError   string
+	}{
+		{
+			desc:   "Success, load the API key locations of the operations",
+			config: `[{"selector": "bookstore.GetBook", "locations": ["header:x-my-key", "cookie:apikey"]}]`,
+			wantOptions: []*options.ApiKeyLocationOptions{
+				{
+					Selector:  "bookstore.GetBook",
+					Locations: []string{"header:x-my-key", "cookie:apikey"},
+				},
+			},
+		},
+		{
+			desc:      "Failure, missing locations",
+			config:    `[{"selector": "bookstore.GetBook", "locations": []}]`,
+			wantError: "selector and locations are required, missing in entry 0",
+		},
+		{
+			desc:      "Failure, duplicate selector",
+			config:    `[{"selector": "bookstore.GetBook", "locations": ["query:key"]}, {"selector": "bookstore.GetBook", "locations": ["header:x-api-key"]}]`,
+			wantError: "duplicate API key locations for selector bookstore.GetBook",
+		},
+	}
+
+	for _, tc := range testData {
+		configFile, err := ioutil.TempFile("", "api_key_locations")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(configFile.Name())
+		configFile.WriteString(tc.config)
+		configFile.Close()
+
+		gotOptions, err := loadApiKeyLocationOptions(configFile.Name())
+		if tc.wantError != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.wantError) {
+				t.Errorf("Test Desc(%s): got error %v, want error containing %q", tc.desc, err, tc.wantError)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Test Desc(%s): unexpected error: %v", tc.desc, err)
+			continue
+		}
+		if !reflect.DeepEqual(gotOptions, tc.wantOptions) {
+			t.Errorf("Test Desc(%s): got options %v, want %v", tc.desc, gotOptions, tc.wantOptions)
+		}
+	}
+}
+
+func TestLoadJwtClaimHeaderOptions(t *testing.T) {
+	testData := []struct {
+		desc        string
+		config      string
+		wantOptions []*options.JwtClaimHeaderOptions
+		wantError   string
+	}{
+		{
+			desc:   "Success, load the claim headers of the operations",
+			config: `[{"selector": "bookstore.GetBook", "claim_headers": {"sub": "x-user-id", "email": "x-user-email"}}]`,
+			wantOptions: []*options.JwtClaimHeaderOptions{
+				{
+					Selector:     "bookstore.GetBook",
+					ClaimHeaders: map[string]string{"sub": "x-user-id", "email": "x-user-email"},
+				},
+			},
+		},
+		{
+			desc:      "Failure, missing claim_headers",
+			config:    `[{"selector": "bookstore.GetBook"}]`,
+			wantError: "selector and claim_headers are required, missing in entry 0",
+		},
+		{
+			desc:      "Failure, duplicate selector",
+			config:    `[{"selector": "bookstore.GetBook", "claim_headers": {"sub": "x-user-id"}}, {"selector": "bookstore.GetBook", "claim_headers": {"email": "x-user-email"}}]`,
+			wantError: "duplicate JWT claim headers for selector bookstore.GetBook",
+		},
+	}
+
+	for _, tc := range testData {
+		configFile, err := ioutil.TempFile("", "jwt_claim_headers")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(configFile.Name())
+		configFile.WriteString(tc.config)
+		configFile.Close()
+
+		gotOptions, err := loadJwtClaimHeaderOptions(configFile.Name())
+		if tc.wantError != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.wantError) {
+				t.Errorf("Test Desc(%s): got error %v, want error containing %q", tc.desc, err, tc.wantError)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Test Desc(%s): unexpected error: %v", tc.desc, err)
+			continue
+		}
+		if !reflect.DeepEqual(gotOptions, tc.wantOptions) {
+			t.Errorf("Test Desc(%s): got options %v, want %v", tc.desc, gotOptions, tc.wantOptions)
+		}
+	}
+}
+
+func TestLoadAuthorizationRuleOptions(t *testing.T) {
+	testData := []struct {
+		desc        string
+		config      string
+		wantOptions []*options.AuthorizationRuleOptions
+		wantError   string
+	}{
+		{
+			desc:   "Success, load the authorization rules of the operations",
+			config: `[{"selector": "bookstore.DeleteBook", "allow": [{"claims": {"roles": ["admin"]}}]}]`,
+			wantOptions: []*options.AuthorizationRuleOptions{
+				{
+					Selector: "bookstore.DeleteBook",
+					Allow: []*options.AuthorizationPolicyOptions{
+						{
+							Claims: map[string][]string{"roles": {"admin"}},
+						},
+					},
+				},
+			},
+		},
+		{
+			desc:      "Failure, missing allow",
+			config:    `[{"selector": "bookstore.DeleteBook"}]`,
+			wantError: "selector and allow are required, missing in entry 0",
+		},
+		{
+			desc:      "Failure, duplicate selector",
+			config:    `[{"selector": "bookstore.DeleteBook", "allow": [{"claims": {"roles": ["admin"]}}]}, {"selector": "bookstore.DeleteBook", "allow": [{"claims": {"sub": ["user-1"]}}]}]`,
+			wantError: "duplicate authorization rules for selector bookstore.DeleteBook",
+		},
+	}
+
+	for _, tc := range testData {
+		configFile, err := ioutil.TempFile("", "authorization_rules")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(configFile.Name())
+		configFile.WriteString(tc.config)
+		configFile.Close()
+
+		gotOptions, err := loadAuthorizationRuleOptions(configFile.Name())
+		if tc.wantError != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.wantError) {
+				t.Errorf("Test Desc(%s): got error %v, want error containing %q", tc.desc, err, tc.wantError)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Test Desc(%s): unexpected error: %v", tc.desc, err)
+			continue
+		}
+		if !reflect.DeepEqual(gotOptions, tc.wantOptions) {
+			t.Errorf("Test Desc(%s): got options %v, want %v", tc.desc, gotOptions, tc.wantOptions)
+		}
+	}
+}
+
+func TestLoadRateLimitOptions(t *testing.T) {
+	testData := []struct {
+		desc        string
+		config      string
+		wantOptions []*options.RateLimitOptions
+		wantError   string
+	}{
+		{
+			desc:   "Success, load the rate limits of the operations",
+			config: `[{"selector": "bookstore.ListShelves", "requests_per_second": 10, "burst": 20}, {"selector": "bookstore.GetShelf"}]`,
+			wantOptions: []*options.RateLimitOptions{
+				{
+					Selector:          "bookstore.ListShelves",
+					RequestsPerSecond: 10,
+					Burst:             20,
+				},
+				{
+					Selector: "bookstore.GetShelf",
+				},
+			},
+		},
+		{
+			desc:      "Failure, missing selector",
+			config:    `[{"requests_per_second": 10}]`,
+			wantError: "selector is required, missing in entry 0",
+		},
+		{
+			desc:      "Failure, duplicate selector",
+			config:    `[{"selector": "bookstore.ListShelves", "requests_per_second": 10}, {"selector": "bookstore.ListShelves", "requests_per_second": 20}]`,
+			wantError: "duplicate rate limits for selector bookstore.ListShelves",
+		},
+	}
+
+	for _, tc := range testData {
+		configFile, err := ioutil.TempFile("", "rate_limits")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(configFile.Name())
+		configFile.WriteString(tc.config)
+		configFile.Close()
+
+		gotOptions, err := loadRateLimitOptions(configFile.Name())
+		if tc.wantError != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.wantError) {
+				t.Errorf("Test Desc(%s): got error %v, want error containing %q", tc.desc, err, tc.wantError)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Test Desc(%s): unexpected error: %v", tc.desc, err)
+			continue
+		}
+		if !reflect.DeepEqual(gotOptions, tc.wantOptions) {
+			t.Errorf("Test Desc(%s): got options %v, want %v", tc.desc, gotOptions, tc.wantOptions)
+		}
+	}
+}
+
+func TestLoadJwksProviderOptions(t *testing.T) {
+	cacheDuration := 600
+	asyncFetch := true
+	fetchRetries := 0
+	testData := []struct {
+		desc        string
+		config      string
+		wantOptions []*options.JwksProviderOptions
+		wantError   string
+	}{
+		{
+			desc:   "Success, load the JWKS options of the providers",
+			config: `[{"provider_id": "firebase", "cache_duration_in_s": 600, "async_fetch": true}, {"provider_id": "auth0", "fetch_retries": 0}]`,
+			wantOptions: []*options.JwksProviderOptions{
+				{
+					ProviderId:       "firebase",
+					CacheDurationInS: &cacheDuration,
+					AsyncFetch:       &asyncFetch,
+				},
+				{
+					ProviderId:   "auth0",
+					FetchRetries: &fetchRetries,
+				},
+			},
+		},
+		{
+			desc:      "Failure, missing provider_id",
+			config:    `[{"cache_duration_in_s": 600}]`,
+			wantError: "provider_id is required, missing in entry 0",
+		},
+		{
+			desc:      "Failure, duplicate provider_id",
+			config:    `[{"provider_id": "firebase", "async_fetch": true}, {"provider_id": "firebase", "fetch_retries": 1}]`,
+			wantError: "duplicate JWKS options for provider firebase",
+		},
+		{
+			desc:      "Failure, zero cache duration",
+			config:    `[{"provider_id": "firebase", "cache_duration_in_s": 0}]`,
+			wantError: "cache_duration_in_s of provider firebase must be positive",
+		},
+		{
+			desc:      "Failure, negative fetch retries",
+			config:    `[{"provider_id": "firebase", "fetch_retries": -1}]`,
+			wantError: "fetch_retries of provider firebase must not be negative",
+		},
+	}
+
+	for _, tc := range testData {
+		configFile, err := ioutil.TempFile("", "jwks_providers")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(configFile.Name())
+		configFile.WriteString(tc.config)
+		configFile.Close()
+
+		gotOptions, err := loadJwksProviderOptions(configFile.Name())
+		if tc.wantError != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.wantError) {
+				t.Errorf("Test Desc(%s): got error %v, want error containing %q", tc.desc, err, tc.wantError)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Test Desc(%s): unexpected error: %v", tc.desc, err)
+			continue
+		}
+		if !reflect.DeepEqual(gotOptions, tc.wantOptions) {
+			t.Errorf("Test Desc(%s): got options %v, want %v", tc.desc, gotOptions, tc.wantOptions)
+		}
+	}
+}
+
+func TestLoadSniCertificateOptions(t *testing.T) {
+	testData := []struct {
+		desc        string
+		config      string
+		wantOptions []*options.SniCertificateOptions
+		wantError   string
+	}{
+		{
+			desc: "Success, load the certificates by server name",
+			config: `[{"server_names": ["api.example.com"], "cert_path": "/etc/ssl/api.crt", "key_path": "/etc/ssl/api.key",
+				"service": "api.endpoints.project123.cloud.goog"},
+				{"server_names": ["*.example.org"], "cert_path": "/etc/ssl/org.crt", "key_path": "/etc/ssl/org.key"}]`,
+			wantOptions: []*options.SniCertificateOptions{
+				{
+					ServerNames: []string{"api.example.com"},
+					CertPath:    "/etc/ssl/api.crt",
+					KeyPath:     "/etc/ssl/api.key",
+					Service:     "api.endpoints.project123.cloud.goog",
+				},
+				{
+					ServerNames: []string{"*.example.org"},
+					CertPath:    "/etc/ssl/org.crt",
+					KeyPath:     "/etc/ssl/org.key",
+				},
+			},
+		},
+		{
+			desc:      "Failure, missing key_path",
+			config:    `[{"server_names": ["api.example.com"], "cert_path": "/etc/ssl/api.crt"}]`,
+			wantError: "server_names, cert_path and key_path are required, missing in entry 0",
+		},
+		{
+			desc: "Failure, duplicate server names",
+			config: `[{"server_names": ["api.example.com"], "cert_path": "/etc/ssl/a.crt", "key_path": "/etc/ssl/a.key"},
+				{"server_names": ["api.example.com"], "cert_path": "/etc/ssl/b.crt", "key_path": "/etc/ssl/b.key"}]`,
+			wantError: "duplicate certificates for server name api.example.com",
+		},
+	}
+
+	for _, tc := range testData {
+		configFile, err := ioutil.TempFile("", "sni_certificates")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(configFile.Name())
+		configFile.WriteString(tc.config)
+		configFile.Close()
+
+		gotOptions, err := loadSniCertificateOptions(configFile.Name())
+		if tc.wantError != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.wantError) {
+				t.Errorf("Test Desc(%s): got error %v, want error containing %q", tc.desc, err, tc.wantError)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Test Desc(%s): unexpected error: %v", tc.desc, err)
+			continue
+		}
+		if !reflect.DeepEqual(gotOptions, tc.wantOptions) {
+			t.Errorf("Test Desc(%s): got options %v, want %v", tc.desc, gotOptions, tc.wantOptions)
+		}
+	}
+}
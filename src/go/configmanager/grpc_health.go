@@ -0,0 +1,139 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package configmanager
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/logging"
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/options"
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/util"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// grpcHealthServer implements the grpc.health.v1.Health service of the proxy,
+// routed by Envoy to the ADS cluster. A service is SERVING only when the
+// Config Manager is ready and the backend reports the service as SERVING.
+type grpcHealthServer struct {
+	m       *ConfigManager
+	backend healthpb.HealthClient
+	// Service checked on the backend when the request has no service.
+	service string
+}
+
+// NewGrpcHealthServer creates the grpc.health.v1.Health service checking
+// opts.HealthGrpcService on the backend in opts.BackendAddress.
+func (m *ConfigManager) NewGrpcHealthServer(opts options.ConfigGeneratorOptions) (healthpb.HealthServer, error) {
+	conn, err := newBackendConn(opts)
+	if err != nil {
+		return nil, fmt.Errorf("fail to connect to the backend for --health_grpc_service: %v", err)
+	}
+	return &grpcHealthServer{
+		m:       m,
+		backend: healthpb.NewHealthClient(conn),
+		service: opts.HealthGrpcService,
+	}, nil
+}
+
+// newBackendConn creates a gRPC channel to the backend in opts.BackendAddress,
+// with the same TLS configuration as Envoy.
+func newBackendConn(opts options.ConfigGeneratorOptions) (*grpc.ClientConn, error) {
+	var address string
+	var dialOpts []grpc.DialOption
+	scheme, socketPath, isUnixSocket := util.ParseUnixSocketURI(opts.BackendAddress)
+	if isUnixSocket {
+		address = socketPath
+		dialOpts = append(dialOpts, grpc.WithContextDialer(func(ctx context.Context, path string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", path)
+		}))
+	} else {
+		var hostname string
+		var port uint32
+		var err error
+		if scheme, hostname, port, _, err = util.ParseURI(opts.BackendAddress); err != nil {
+			return nil, err
+		}
+		address = fmt.Sprintf("%s:%d", hostname, port)
+	}
+	protocol, useTLS, err := util.ParseBackendProtocol(scheme, "")
+	if err != nil {
+		return nil, err
+	}
+	if protocol != util.GRPC {
+		return nil, fmt.Errorf("--backend_address must use grpc or grpcs, got %v", scheme)
+	}
+	if !useTLS {
+		return grpc.Dial(address, append(dialOpts, grpc.WithInsecure())...)
+	}
+
+	caPath := opts.BackendCaPath
+	if caPath == "" {
+		caPath = opts.RootCertsPath
+	}
+	caCert, err := ioutil.ReadFile(caPath)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{RootCAs: x509.NewCertPool()}
+	tlsConfig.RootCAs.AppendCertsFromPEM(caCert)
+	if opts.BackendMtlsCertPath != "" {
+		cert, err := tls.LoadX509KeyPair(opts.BackendMtlsCertPath, opts.BackendMtlsKeyPath)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return grpc.Dial(address, append(dialOpts, grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)))...)
+}
+
+// Check implements the Check method of grpc.health.v1.Health.
+func (s *grpcHealthServer) Check(ctx context.Context, req *healthpb.HealthCheckRequest) (*healthpb.HealthCheckResponse, error) {
+	if err := s.m.checkReady(); err != nil {
+		logging.Debugf("gRPC health check is NOT_SERVING, the config manager is not ready: %v", err)
+		return &healthpb.HealthCheckResponse{Status: healthpb.HealthCheckResponse_NOT_SERVING}, nil
+	}
+
+	service := req.GetService()
+	if service == "" {
+		service = s.service
+	}
+	resp, err := s.backend.Check(ctx, &healthpb.HealthCheckRequest{Service: service})
+	if err != nil {
+		// An unknown service is reported as is, like by the backend.
+		if status.Code(err) == codes.NotFound {
+			return nil, err
+		}
+		logging.Debugf("gRPC health check is NOT_SERVING, fail to check the backend: %v", err)
+		return &healthpb.HealthCheckResponse{Status: healthpb.HealthCheckResponse_NOT_SERVING}, nil
+	}
+	return resp, nil
+}
+
+// Watch implements the Watch method of grpc.health.v1.Health, which is not
+// routed by Envoy.
+func (s *grpcHealthServer) Watch(req *healthpb.HealthCheckRequest, stream healthpb.Health_WatchServer) error {
+	return status.Error(codes.Unimplemented, "Watch is not supported, use Check instead")
+}
@@ -0,0 +1,159 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package configmanager
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/options"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/status"
+
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func TestGrpcHealthServer(t *testing.T) {
+	backendHealth := health.NewServer()
+	backendHealth.SetServingStatus("", healthpb.HealthCheckResponse_SERVING)
+	backendHealth.SetServingStatus("bookstore.Bookstore", healthpb.HealthCheckResponse_SERVING)
+	backendHealth.SetServingStatus("shelves.Shelves", healthpb.HealthCheckResponse_NOT_SERVING)
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend := grpc.NewServer()
+	healthpb.RegisterHealthServer(backend, backendHealth)
+	go backend.Serve(lis)
+	defer backend.Stop()
+	backendAddress := fmt.Sprintf("grpc://%s", lis.Addr().String())
+
+	socketDir, err := ioutil.TempDir("", "grpc_health")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(socketDir)
+	socketPath := filepath.Join(socketDir, "backend.sock")
+	unixLis, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go backend.Serve(unixLis)
+
+	testData := []struct {
+		desc           string
+		backendAddress string
+		ready          bool
+		service        string
+		wantStatus     healthpb.HealthCheckResponse_ServingStatus
+		wantCode       codes.Code
+		wantNewError   string
+	}{
+		{
+			desc:           "NOT_SERVING before the first service config is sent to Envoy",
+			backendAddress: backendAddress,
+			service:        "bookstore.Bookstore",
+			wantStatus:     healthpb.HealthCheckResponse_NOT_SERVING,
+		},
+		{
+			desc:           "SERVING when ready and the backend is serving",
+			backendAddress: backendAddress,
+			ready:          true,
+			service:        "bookstore.Bookstore",
+			wantStatus:     healthpb.HealthCheckResponse_SERVING,
+		},
+		{
+			desc:           "The configured service is checked when the request has none",
+			backendAddress: backendAddress,
+			ready:          true,
+			wantStatus:     healthpb.HealthCheckResponse_SERVING,
+		},
+		{
+			desc:           "NOT_SERVING when the backend is not serving",
+			backendAddress: backendAddress,
+			ready:          true,
+			service:        "shelves.Shelves",
+			wantStatus:     healthpb.HealthCheckResponse_NOT_SERVING,
+		},
+		{
+			desc:           "Unknown service is NotFound, like by the backend",
+			backendAddress: backendAddress,
+			ready:          true,
+			service:        "unknown.Unknown",
+			wantCode:       codes.NotFound,
+		},
+		{
+			desc:           "SERVING when the backend on a Unix domain socket is serving",
+			backendAddress: "grpc+unix:" + socketPath,
+			ready:          true,
+			service:        "bookstore.Bookstore",
+			wantStatus:     healthpb.HealthCheckResponse_SERVING,
+		},
+		{
+			desc:           "NOT_SERVING when the backend is unreachable",
+			backendAddress: "grpc://127.0.0.1:1",
+			ready:          true,
+			service:        "bookstore.Bookstore",
+			wantStatus:     healthpb.HealthCheckResponse_NOT_SERVING,
+		},
+		{
+			desc:           "Fail with an HTTP backend",
+			backendAddress: "http://127.0.0.1:8082",
+			wantNewError:   "--backend_address must use grpc or grpcs",
+		},
+	}
+
+	for _, tc := range testData {
+		opts := options.DefaultConfigGeneratorOptions()
+		opts.BackendAddress = tc.backendAddress
+		opts.HealthGrpcService = "bookstore.Bookstore"
+
+		m := &ConfigManager{}
+		m.initHealth()
+		if tc.ready {
+			m.OnStreamOpen(context.Background(), 1, "")
+			m.OnStreamResponse(1, nil, nil)
+		}
+
+		s, err := m.NewGrpcHealthServer(opts)
+		if tc.wantNewError != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.wantNewError) {
+				t.Errorf("Test Desc(%s): got error %v, want error containing %q", tc.desc, err, tc.wantNewError)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Test Desc(%s): fail to create the gRPC health server: %v", tc.desc, err)
+		}
+
+		resp, err := s.Check(context.Background(), &healthpb.HealthCheckRequest{Service: tc.service})
+		if status.Code(err) != tc.wantCode {
+			t.Errorf("Test Desc(%s): got code %v, want %v", tc.desc, status.Code(err), tc.wantCode)
+			continue
+		}
+		if err == nil && resp.GetStatus() != tc.wantStatus {
+			t.Errorf("Test Desc(%s): got status %v, want %v", tc.desc, resp.GetStatus(), tc.wantStatus)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package configmanager
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	healthzPath = "/healthz"
+	readyzPath  = "/readyz"
+)
+
+// healthRecorder keeps the state checked by /healthz and /readyz. It is
+// written by the ADS server and the goroutine checking rollouts, so all
+// accesses are guarded by mu.
+type healthRecorder struct {
+	mu sync.Mutex
+	// Set when new service configs are checked periodically, in managed
+	// rollout strategy, so that the config can become stale.
+	managed bool
+	// Number of open ADS streams, and since when there is none.
+	adsStreams   int
+	adsDownSince time.Time
+	// When the first snapshot was sent to Envoy.
+	firstPushTime time.Time
+	// When a service config or rollouts were last fetched successfully.
+	lastFetchSuccessTime time.Time
+	// Set on shutdown, while the connections are drained.
+	draining bool
+	// Number of consecutive failed polls for new rollouts.
+	rolloutPollFailures int
+}
+
+// Allows for unit tests to control the time of the health checks.
+var healthTimeNow = time.Now
+
+// initHealth starts counting the time without ADS stream and since the last
+// successful fetch, as Envoy has not connected and nothing is fetched yet.
+func (m *ConfigManager) initHealth() {
+	m.health.mu.Lock()
+	defer m.health.mu.Unlock()
+	now := healthTimeNow()
+	m.health.adsDownSince = now
+	m.health.lastFetchSuccessTime = now
+}
+
+// enableStalenessCheck is called in managed rollout strategy, where new
+// service configs are checked periodically.
+func (m *ConfigManager) enableStalenessCheck() {
+	m.health.mu.Lock()
+	defer m.health.mu.Unlock()
+	m.health.managed = true
+}
+
+func (m *ConfigManager) recordFetchSuccess() {
+	m.health.mu.Lock()
+	defer m.health.mu.Unlock()
+	m.health.lastFetchSuccessTime = healthTimeNow()
+}
+
+func (m *ConfigManager) recordStreamOpen() {
+	m.health.mu.Lock()
+	defer m.health.mu.Unlock()
+	m.health.adsStreams++
+}
+
+func (m *ConfigManager) recordStreamClosed() {
+	m.health.mu.Lock()
+	defer m.health.mu.Unlock()
+	if m.health.adsStreams--; m.health.adsStreams == 0 {
+		m.health.adsDownSince = healthTimeNow()
+	}
+}
+
+func (m *ConfigManager) recordPush() {
+	m.health.mu.Lock()
+	defer m.health.mu.Unlock()
+	if m.health.firstPushTime.IsZero() {
+		m.health.firstPushTime = healthTimeNow()
+	}
+}
+
+// StartDraining fails the readiness and gRPC health checks on shutdown, so
+// that no new traffic is sent to the proxy while its connections are drained.
+func (m *ConfigManager) StartDraining() {
+	m.health.mu.Lock()
+	defer m.health.mu.Unlock()
+	m.health.draining = true
+}
+
+// checkHealth returns an error if the ADS stream has been down for more than
+// --health_ads_down_threshold, or the service config has not been fetched for
+// more than --health_config_staleness_threshold in managed rollout strategy.
+func (m *ConfigManager) checkHealth() error {
+	m.health.mu.Lock()
+	defer m.health.mu.Unlock()
+	now := healthTimeNow()
+	if *healthADSDownThreshold > 0 && m.health.adsStreams == 0 && !m.health.adsDownSince.IsZero() {
+		if down := now.Sub(m.health.adsDownSince); down > *healthADSDownThreshold {
+			return fmt.Errorf("no ADS stream from Envoy for %v", down)
+		}
+	}
+	if *healthConfigStalenessThreshold > 0 && m.health.managed && !m.health.lastFetchSuccessTime.IsZero() {
+		if stale := now.Sub(m.health.lastFetchSuccessTime); stale > *healthConfigStalenessThreshold {
+			return fmt.Errorf("service config is not fetched successfully for %v", stale)
+		}
+	}
+	return nil
+}
+
+// checkReady returns an error until the first service config is translated
+// and sent to Envoy, on shutdown, after --rollout_failure_threshold failed
+// polls with --rollout_failure_fail_readiness, or if the Config Manager is not
+// healthy.
+func (m *ConfigManager) checkReady() error {
+	m.health.mu.Lock()
+	pushed := !m.health.firstPushTime.IsZero()
+	draining := m.health.draining
+	pollFailures := m.health.rolloutPollFailures
+	m.health.mu.Unlock()
+	if !pushed {
+		return fmt.Errorf("no service config is sent to Envoy yet")
+	}
+	if draining {
+		return fmt.Errorf("shutting down, draining the connections")
+	}
+	if *RolloutFailureFailReadiness && *RolloutFailureThreshold > 0 && pollFailures >= *RolloutFailureThreshold {
+		return fmt.Errorf("%v consecutive rollout polls failed", pollFailures)
+	}
+	return m.checkHealth()
+}
+
+func serveCheck(w http.ResponseWriter, check func() error) {
+	if err := check(); err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	w.Write([]byte("ok\n"))
+}
+
+func (m *ConfigManager) serveHealthz(w http.ResponseWriter, r *http.Request) {
+	serveCheck(w, m.checkHealth)
+}
+
+func (m *ConfigManager) serveReadyz(w http.ResponseWriter, r *http.Request) {
+	serveCheck(w, m.checkReady)
+}
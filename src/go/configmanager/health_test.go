@@ -0,0 +1,153 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package configmanager
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHealthChecks(t *testing.T) {
+	start := time.Date(2019, 12, 16, 0, 0, 0, 0, time.UTC)
+	testData := []struct {
+		desc               string
+		adsDownThreshold   time.Duration
+		stalenessThreshold time.Duration
+		managed            bool
+		events             func(m *ConfigManager, now *time.Time)
+		wantHealthz        int
+		wantReadyz         int
+	}{
+		{
+			desc:        "Not ready before the first service config is sent to Envoy",
+			wantHealthz: http.StatusOK,
+			wantReadyz:  http.StatusServiceUnavailable,
+		},
+		{
+			desc: "Ready after the first service config is sent to Envoy",
+			events: func(m *ConfigManager, now *time.Time) {
+				m.OnStreamOpen(context.Background(), 1, "")
+				m.OnStreamResponse(1, nil, nil)
+			},
+			wantHealthz: http.StatusOK,
+			wantReadyz:  http.StatusOK,
+		},
+		{
+			desc:             "Unhealthy when Envoy has not connected for longer than the threshold",
+			adsDownThreshold: time.Minute,
+			events: func(m *ConfigManager, now *time.Time) {
+				*now = now.Add(2 * time.Minute)
+			},
+			wantHealthz: http.StatusServiceUnavailable,
+			wantReadyz:  http.StatusServiceUnavailable,
+		},
+		{
+			desc:             "Unhealthy when the ADS stream is down for longer than the threshold",
+			adsDownThreshold: time.Minute,
+			events: func(m *ConfigManager, now *time.Time) {
+				m.OnStreamOpen(context.Background(), 1, "")
+				m.OnStreamResponse(1, nil, nil)
+				*now = now.Add(time.Hour)
+				m.OnStreamClosed(1)
+				*now = now.Add(2 * time.Minute)
+			},
+			wantHealthz: http.StatusServiceUnavailable,
+			wantReadyz:  http.StatusServiceUnavailable,
+		},
+		{
+			desc:             "Healthy when the ADS stream is reconnected within the threshold",
+			adsDownThreshold: time.Minute,
+			events: func(m *ConfigManager, now *time.Time) {
+				m.OnStreamOpen(context.Background(), 1, "")
+				m.OnStreamResponse(1, nil, nil)
+				m.OnStreamClosed(1)
+				*now = now.Add(30 * time.Second)
+				m.OnStreamOpen(context.Background(), 2, "")
+				*now = now.Add(time.Hour)
+			},
+			wantHealthz: http.StatusOK,
+			wantReadyz:  http.StatusOK,
+		},
+		{
+			desc:               "Unhealthy when the service config is staler than the threshold in managed rollout",
+			stalenessThreshold: 10 * time.Minute,
+			managed:            true,
+			events: func(m *ConfigManager, now *time.Time) {
+				m.recordFetch(nil)
+				m.OnStreamOpen(context.Background(), 1, "")
+				m.OnStreamResponse(1, nil, nil)
+				*now = now.Add(5 * time.Minute)
+				m.recordFetch(fmt.Errorf("service management is unavailable"))
+				*now = now.Add(6 * time.Minute)
+			},
+			wantHealthz: http.StatusServiceUnavailable,
+			wantReadyz:  http.StatusServiceUnavailable,
+		},
+		{
+			desc:               "Staleness is not checked in fixed rollout",
+			stalenessThreshold: 10 * time.Minute,
+			events: func(m *ConfigManager, now *time.Time) {
+				m.OnStreamOpen(context.Background(), 1, "")
+				m.OnStreamResponse(1, nil, nil)
+				*now = now.Add(time.Hour)
+			},
+			wantHealthz: http.StatusOK,
+			wantReadyz:  http.StatusOK,
+		},
+		{
+			desc: "Not ready but healthy while draining the connections on shutdown",
+			events: func(m *ConfigManager, now *time.Time) {
+				m.OnStreamOpen(context.Background(), 1, "")
+				m.OnStreamResponse(1, nil, nil)
+				m.StartDraining()
+			},
+			wantHealthz: http.StatusOK,
+			wantReadyz:  http.StatusServiceUnavailable,
+		},
+	}
+
+	defer func() {
+		healthTimeNow = time.Now
+		*healthADSDownThreshold = 0
+		*healthConfigStalenessThreshold = 0
+	}()
+	for _, tc := range testData {
+		now := start
+		healthTimeNow = func() time.Time { return now }
+		*healthADSDownThreshold = tc.adsDownThreshold
+		*healthConfigStalenessThreshold = tc.stalenessThreshold
+
+		m := &ConfigManager{}
+		m.initHealth()
+		if tc.managed {
+			m.enableStalenessCheck()
+		}
+		if tc.events != nil {
+			tc.events(m, &now)
+		}
+
+		for path, want := range map[string]int{healthzPath: tc.wantHealthz, readyzPath: tc.wantReadyz} {
+			w := httptest.NewRecorder()
+			m.MetricsHandler().ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+			if w.Code != want {
+				t.Errorf("Test Desc(%s): got %v code %v, want %v, body: %s", tc.desc, path, w.Code, want, w.Body.String())
+			}
+		}
+	}
+}
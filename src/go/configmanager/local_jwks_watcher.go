@@ -0,0 +1,160 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package configmanager
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"time"
+
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/logging"
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/util"
+)
+
+// WatchLocalJwks starts checking the local JWKS files of the JWT providers for
+// changes every --local_jwks_check_interval, and refreshing the JWKS fetched
+// in advance every their shortest cache duration. The JWKS are inlined in the
+// Envoy config, so the service config is applied again when they change.
+//
+// Only the JWKS of a single service are watched, without traffic split.
+func (m *ConfigManager) WatchLocalJwks() {
+	if m.serviceInfo == nil {
+		return
+	}
+	var filesInterval, fetchInterval time.Duration
+	if len(m.serviceInfo.LocalJwksFiles) > 0 {
+		filesInterval = *localJwksCheckInterval
+	}
+	for _, info := range m.serviceInfo.RemoteJwks {
+		if info.AsyncFetch && (fetchInterval == 0 || info.CacheDuration < fetchInterval) {
+			fetchInterval = info.CacheDuration
+		}
+	}
+	if filesInterval == 0 && fetchInterval == 0 {
+		return
+	}
+	if len(m.additionalServices) > 0 || len(m.trafficSplitConfigs) > 0 {
+		logging.Warningf("local JWKS are not checked for changes with multiple services or traffic split")
+		return
+	}
+
+	// A nil channel never fires.
+	var filesTick, fetchTick <-chan time.Time
+	if filesInterval != 0 {
+		logging.Infof("start checking local JWKS files every %v", filesInterval)
+		filesTick = time.Tick(filesInterval)
+	}
+	if fetchInterval != 0 {
+		logging.Infof("start refreshing the JWKS fetched in advance every %v", fetchInterval)
+		fetchTick = time.Tick(fetchInterval)
+	}
+	go func() {
+		for {
+			// only log error and keep serving the current JWKS when the new ones are invalid
+			select {
+			case <-filesTick:
+				if err := m.checkLocalJwksFiles(); err != nil {
+					logging.Errorf("error occurred when checking local JWKS files, %v", err)
+				}
+			case <-fetchTick:
+				if err := m.refreshAsyncFetchedJwks(); err != nil {
+					logging.Errorf("error occurred when refreshing the JWKS fetched in advance, %v", err)
+				}
+			}
+		}
+	}()
+}
+
+// checkLocalJwksFiles applies the service config again if the local JWKS
+// files have changed since they were last read.
+func (m *ConfigManager) checkLocalJwksFiles() error {
+	jwks := make(map[string]string)
+	for id, path := range m.serviceInfo.LocalJwksFiles {
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("fail to read the local JWKS of JWT provider %s: %v", id, err)
+		}
+		jwks[id] = string(content)
+	}
+	return m.applyLocalJwks(jwks)
+}
+
+// refreshAsyncFetchedJwks fetches the JWKS fetched in advance again, and
+// applies the service config again if they have changed.
+func (m *ConfigManager) refreshAsyncFetchedJwks() error {
+	jwks := make(map[string]string)
+	for id, info := range m.serviceInfo.RemoteJwks {
+		if !info.AsyncFetch {
+			continue
+		}
+		content, err := util.FetchJwks(info.Uri, info.FetchRetries)
+		if err != nil {
+			// The current JWKS are kept until they can be fetched.
+			logging.Warningf("fail to refresh the JWKS of JWT provider %s: %v", id, err)
+			continue
+		}
+		jwks[id] = string(content)
+	}
+	return m.applyLocalJwks(jwks)
+}
+
+// applyLocalJwks applies the service config again if some of the JWKS have
+// changed since they were inlined in the Envoy config.
+func (m *ConfigManager) applyLocalJwks(jwks map[string]string) error {
+	merged := make(map[string]string)
+	for id, content := range m.serviceInfo.LocalJwks {
+		merged[id] = content
+	}
+	changed := false
+	for id, content := range jwks {
+		if cur, ok := merged[id]; !ok || cur != content {
+			changed = true
+		}
+		merged[id] = content
+	}
+	if !changed {
+		return nil
+	}
+
+	hash := hashLocalJwks(merged)
+	if bytes.Equal(hash, m.localJwksHash) {
+		return nil
+	}
+	prevHash := m.localJwksHash
+	m.localJwksHash = hash
+	if err := m.applyServiceConfig(m.serviceInfo.ServiceConfig()); err != nil {
+		m.localJwksHash = prevHash
+		return err
+	}
+	logging.WithFields(m.logFields()).Infof("applied the changed local JWKS")
+	return nil
+}
+
+func hashLocalJwks(jwks map[string]string) []byte {
+	var ids []string
+	for id := range jwks {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	h := sha256.New()
+	for _, id := range ids {
+		fmt.Fprintf(h, "%s\x00%s\x00", id, jwks[id])
+	}
+	return h.Sum(nil)
+}
@@ -0,0 +1,190 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package configmanager
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/options"
+	"github.com/envoyproxy/go-control-plane/pkg/cache"
+)
+
+func TestCheckLocalJwksFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "local_jwks_watcher")
+	if err != nil {
+		t.Fatalf("fail to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	jwksPath := filepath.Join(dir, "jwks.json")
+	servicePath := filepath.Join(dir, "service.json")
+
+	writeJwks := func(content string) {
+		if err := ioutil.WriteFile(jwksPath, []byte(content), 0644); err != nil {
+			t.Fatalf("fail to write JWKS file: %v", err)
+		}
+	}
+	writeJwks(`{"keys": []}`)
+	serviceConfig := fmt.Sprintf(`{"name":"%s","id":"%s","apis":[{"name":"%s"}],
+		"authentication":{"providers":[{"id":"auth_provider","issuer":"issuer-0","jwks_uri":"file://%s"}]}}`,
+		testProjectName, testConfigID, testEndpointName, jwksPath)
+	if err := ioutil.WriteFile(servicePath, []byte(serviceConfig), 0644); err != nil {
+		t.Fatalf("fail to write service config file: %v", err)
+	}
+
+	flag.Set("service_json_path", servicePath)
+	defer flag.Set("service_json_path", "")
+
+	opts := options.DefaultConfigGeneratorOptions()
+	opts.BackendAddress = "http://127.0.0.1:8082"
+	manager, err := NewConfigManager(nil, opts)
+	if err != nil {
+		t.Fatalf("fail to initialize Config Manager: %v", err)
+	}
+
+	testCases := []struct {
+		desc           string
+		content        string
+		wantErr        bool
+		wantJwks       string
+		wantNewVersion bool
+	}{
+		{
+			desc:     "Unchanged file keeps the snapshot",
+			content:  `{"keys": []}`,
+			wantJwks: `{"keys": []}`,
+		},
+		{
+			desc:           "Changed file updates the snapshot",
+			content:        `{"keys": [{"kty": "RSA"}]}`,
+			wantJwks:       `{"keys": [{"kty": "RSA"}]}`,
+			wantNewVersion: true,
+		},
+		{
+			desc:     "Invalid file keeps the previous snapshot",
+			content:  `{"keys": [`,
+			wantErr:  true,
+			wantJwks: `{"keys": [{"kty": "RSA"}]}`,
+		},
+	}
+
+	for _, tc := range testCases {
+		prevVersion := manager.snapshotVersion()
+		writeJwks(tc.content)
+
+		err := manager.checkLocalJwksFiles()
+		if (err != nil) != tc.wantErr {
+			t.Errorf("Test Desc(%s): got error %v, want error %v", tc.desc, err, tc.wantErr)
+		}
+		if got := manager.serviceInfo.LocalJwks["auth_provider"]; got != tc.wantJwks {
+			t.Errorf("Test Desc(%s): got JWKS %v, want %v", tc.desc, got, tc.wantJwks)
+		}
+
+		version := manager.snapshotVersion()
+		if (version != prevVersion) != tc.wantNewVersion {
+			t.Errorf("Test Desc(%s): got snapshot version %v, previous version %v, want new version %v", tc.desc, version, prevVersion, tc.wantNewVersion)
+		}
+		snapshot, err := manager.cache.GetSnapshot(opts.Node)
+		if err != nil {
+			t.Fatalf("Test Desc(%s): fail to get snapshot: %v", tc.desc, err)
+		}
+		if got := snapshot.GetVersion(cache.ListenerType); got != version {
+			t.Errorf("Test Desc(%s): got cached snapshot version %v, want %v", tc.desc, got, version)
+		}
+	}
+}
+
+func TestRefreshAsyncFetchedJwks(t *testing.T) {
+	var jwks atomic.Value
+	jwks.Store(`{"keys": []}`)
+	jwksServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(jwks.Load().(string)))
+	}))
+	defer jwksServer.Close()
+
+	dir, err := ioutil.TempDir("", "local_jwks_watcher")
+	if err != nil {
+		t.Fatalf("fail to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	servicePath := filepath.Join(dir, "service.json")
+	serviceConfig := fmt.Sprintf(`{"name":"%s","id":"%s","apis":[{"name":"%s"}],
+		"authentication":{"providers":[{"id":"auth_provider","issuer":"issuer-0","jwks_uri":"%s"}]}}`,
+		testProjectName, testConfigID, testEndpointName, jwksServer.URL)
+	if err := ioutil.WriteFile(servicePath, []byte(serviceConfig), 0644); err != nil {
+		t.Fatalf("fail to write service config file: %v", err)
+	}
+
+	flag.Set("service_json_path", servicePath)
+	defer flag.Set("service_json_path", "")
+
+	opts := options.DefaultConfigGeneratorOptions()
+	opts.BackendAddress = "http://127.0.0.1:8082"
+	opts.JwksAsyncFetch = true
+	opts.JwksFetchRetries = 0
+	manager, err := NewConfigManager(nil, opts)
+	if err != nil {
+		t.Fatalf("fail to initialize Config Manager: %v", err)
+	}
+	if got := manager.serviceInfo.LocalJwks["auth_provider"]; got != `{"keys": []}` {
+		t.Fatalf("got JWKS fetched in advance %v, want %v", got, `{"keys": []}`)
+	}
+
+	testCases := []struct {
+		desc           string
+		content        string
+		wantJwks       string
+		wantNewVersion bool
+	}{
+		{
+			desc:     "Unchanged JWKS keeps the snapshot",
+			content:  `{"keys": []}`,
+			wantJwks: `{"keys": []}`,
+		},
+		{
+			desc:           "Changed JWKS updates the snapshot",
+			content:        `{"keys": [{"kty": "RSA"}]}`,
+			wantJwks:       `{"keys": [{"kty": "RSA"}]}`,
+			wantNewVersion: true,
+		},
+		{
+			desc:     "Invalid JWKS keeps the previous snapshot",
+			content:  `{"keys": [`,
+			wantJwks: `{"keys": [{"kty": "RSA"}]}`,
+		},
+	}
+
+	for _, tc := range testCases {
+		prevVersion := manager.snapshotVersion()
+		jwks.Store(tc.content)
+
+		if err := manager.refreshAsyncFetchedJwks(); err != nil {
+			t.Errorf("Test Desc(%s): unexpected error: %v", tc.desc, err)
+		}
+		if got := manager.serviceInfo.LocalJwks["auth_provider"]; got != tc.wantJwks {
+			t.Errorf("Test Desc(%s): got JWKS %v, want %v", tc.desc, got, tc.wantJwks)
+		}
+		if version := manager.snapshotVersion(); (version != prevVersion) != tc.wantNewVersion {
+			t.Errorf("Test Desc(%s): got snapshot version %v, previous version %v, want new version %v", tc.desc, version, prevVersion, tc.wantNewVersion)
+		}
+	}
+}
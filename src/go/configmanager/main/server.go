@@ -19,23 +19,52 @@ import (
 	"flag"
 	"fmt"
 	"net"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/commonflags"
 	"github.com/GoogleCloudPlatform/esp-v2/src/go/configmanager"
 	"github.com/GoogleCloudPlatform/esp-v2/src/go/configmanager/flags"
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/logging"
 	"github.com/GoogleCloudPlatform/esp-v2/src/go/metadata"
-	"github.com/golang/glog"
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/util"
 	"google.golang.org/grpc"
 
 	discoverygrpc "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v2"
-	xds "github.com/envoyproxy/go-control-plane/pkg/server"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 )
 
 func main() {
-	flag.Parse()
-	opts := flags.EnvoyConfigOptionsFromFlags()
+	// "configmanager render [flags]" prints the static Envoy config of the
+	// service config file instead of serving it.
+	render := len(os.Args) > 1 && os.Args[1] == "render"
+	if render {
+		flag.CommandLine.Parse(os.Args[2:])
+	} else {
+		flag.Parse()
+	}
+	if err := commonflags.ApplyEnvAndConfigFile(flag.CommandLine); err != nil {
+		logging.Exitf("%v", err)
+	}
+	if err := logging.Init(*configmanager.LogFormat, *configmanager.LogLevel); err != nil {
+		logging.Exitf("fail to initialize logging: %v", err)
+	}
+	opts, errs := flags.ParseEnvoyConfigOptionsFromFlags()
+	configmanager.CheckFlags(opts, errs)
+	if err := errs.Err(); err != nil {
+		logging.Exitf("%v", err)
+	}
+	util.SetProxy(opts.HttpProxy, opts.HttpsProxy, opts.NoProxy)
+	if render {
+		out, err := configmanager.Render(opts, *configmanager.RenderFormat)
+		if err != nil {
+			logging.Exitf("fail to render the Envoy config: %v", err)
+		}
+		fmt.Print(out)
+		return
+	}
 
 	// Create context that allows cancellation.
 	// Allows shutting down downstream servers gracefully.
@@ -43,23 +72,89 @@ func main() {
 
 	var mf *metadata.MetadataFetcher
 	if !opts.NonGCP {
-		glog.Info("running on GCP, initializing metadata fetcher")
+		logging.Infof("running on GCP, initializing metadata fetcher")
 		mf = metadata.NewMetadataFetcher(opts.CommonOptions)
 	}
 
 	m, err := configmanager.NewConfigManager(mf, opts)
 	if err != nil {
-		glog.Exitf("fail to initialize config manager: %v", err)
+		logging.Exitf("fail to initialize config manager: %v", err)
+	}
+	if *configmanager.ValidateOnly {
+		if *configmanager.EnvoyValidateBinary != "" {
+			if err := m.ValidateWithEnvoy(*configmanager.EnvoyValidateBinary); err != nil {
+				logging.Exitf("invalid config: %v", err)
+			}
+		}
+		logging.Infof("the config is valid")
+		cancel()
+		return
+	}
+	m.WatchLocalJwks()
+	m.WatchTranscodingDescriptor()
+	m.WatchServerCert()
+	m.WatchAccessLog()
+	m.WatchPodAnnotations()
+	if *configmanager.StatusPort != 0 {
+		statusAddress := fmt.Sprintf("127.0.0.1:%d", *configmanager.StatusPort)
+		go func() {
+			logging.Infof("config manager status server is running at %s", statusAddress)
+			if err := http.ListenAndServe(statusAddress, m.StatusHandler()); err != nil {
+				logging.Errorf("status server fail to serve: %v", err)
+			}
+		}()
 	}
-	server := xds.NewServer(ctx, m.Cache(), nil)
+
+	if *configmanager.MetricsPort != 0 {
+		metricsAddress := fmt.Sprintf(":%d", *configmanager.MetricsPort)
+		go func() {
+			logging.Infof("config manager metrics server is running at %s", metricsAddress)
+			if err := http.ListenAndServe(metricsAddress, m.MetricsHandler()); err != nil {
+				logging.Errorf("metrics server fail to serve: %v", err)
+			}
+		}()
+	}
+
+	if configmanager.NeedTokenAgent(opts) {
+		tokenAgentAddress := fmt.Sprintf("127.0.0.1:%d", opts.TokenAgentPort)
+		tokenAgentHandler, err := configmanager.TokenAgentHandler(opts)
+		if err != nil {
+			logging.Exitf("fail to create token agent: %v", err)
+		}
+		go func() {
+			logging.Infof("token agent is running at %s", tokenAgentAddress)
+			if err := http.ListenAndServe(tokenAgentAddress, tokenAgentHandler); err != nil {
+				logging.Errorf("token agent fail to serve: %v", err)
+			}
+		}()
+	}
+
+	if acmeChallengeHandler := m.AcmeChallengeHandler(); acmeChallengeHandler != nil {
+		acmeChallengeAddress := fmt.Sprintf("127.0.0.1:%d", opts.SslServerAcmeChallengePort)
+		go func() {
+			logging.Infof("ACME challenge server is running at %s", acmeChallengeAddress)
+			if err := http.ListenAndServe(acmeChallengeAddress, acmeChallengeHandler); err != nil {
+				logging.Errorf("ACME challenge server fail to serve: %v", err)
+			}
+		}()
+	}
+
+	server := m.NewAdsServer(ctx)
 	grpcServer := grpc.NewServer()
 	lis, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", opts.DiscoveryPort))
 	if err != nil {
-		glog.Exitf("Server failed to listen: %v", err)
+		logging.Exitf("Server failed to listen: %v", err)
 	}
 
 	// Register Envoy discovery services.
 	discoverygrpc.RegisterAggregatedDiscoveryServiceServer(grpcServer, server)
+	if opts.HealthGrpcService != "" {
+		healthServer, err := m.NewGrpcHealthServer(opts)
+		if err != nil {
+			logging.Exitf("fail to create gRPC health service: %v", err)
+		}
+		healthpb.RegisterHealthServer(grpcServer, healthServer)
+	}
 
 	fmt.Printf("config manager server is running at %s .......\n", lis.Addr())
 
@@ -69,12 +164,19 @@ func main() {
 
 	go func() {
 		sig := <-signalChan
-		glog.Warningf("Server got signal %v, stopping", sig)
+		// Envoy keeps being served while its connections are drained, until
+		// it is stopped and the start-up script sends another signal.
+		if opts.ShutdownDrainTime > 0 {
+			logging.Warningf("Server got signal %v, draining the connections", sig)
+			m.StartDraining()
+			sig = <-signalChan
+		}
+		logging.Warningf("Server got signal %v, stopping", sig)
 		cancel()
 		grpcServer.Stop()
 	}()
 
 	if err := grpcServer.Serve(lis); err != nil {
-		glog.Exitf("Server fail to serve: %v", err)
+		logging.Exitf("Server fail to serve: %v", err)
 	}
 }
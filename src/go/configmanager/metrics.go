@@ -0,0 +1,140 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package configmanager
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/metrics"
+
+	v2pb "github.com/envoyproxy/go-control-plane/envoy/api/v2"
+	confpb "google.golang.org/genproto/googleapis/api/serviceconfig"
+	smpb "google.golang.org/genproto/googleapis/api/servicemanagement/v1"
+)
+
+const (
+	metricsPath = "/metrics"
+
+	fetchTypeConfig   = "config"
+	fetchTypeRollouts = "rollouts"
+)
+
+var (
+	metricsRegistry = metrics.NewRegistry()
+
+	configFetches = metricsRegistry.NewCounter("espv2_config_manager_config_fetches_total",
+		"Number of fetches of service configs and rollouts, by type and result.", "type", "result")
+	configFetchDuration = metricsRegistry.NewHistogram("espv2_config_manager_config_fetch_duration_seconds",
+		"Latency of fetches of service configs and rollouts.", metrics.DefaultLatencyBuckets)
+	lastConfigFetchSuccess = metricsRegistry.NewGauge("espv2_config_manager_last_config_fetch_success_timestamp_seconds",
+		"Unix time of the last successful fetch of a service config or rollouts, by type.", "type")
+	rolloutChecks = metricsRegistry.NewCounter("espv2_config_manager_rollout_checks_total",
+		"Number of checks for new rollouts, periodic or triggered by a rollout notification.")
+	rolloutRollbacks = metricsRegistry.NewCounter("espv2_config_manager_rollout_rollbacks_total",
+		"Number of new rollouts serving the config of an older rollout.")
+	pinnedConfigDrift = metricsRegistry.NewGauge("espv2_config_manager_pinned_config_drift",
+		"1 when a service config pinned with --detect_rollout_drift is not the one of the latest rollout.")
+	rolloutPollFailures = metricsRegistry.NewCounter("espv2_config_manager_rollout_poll_failures_total",
+		"Number of checks for new rollouts failing to fetch or apply a rollout.")
+	rolloutPollConsecutiveFailures = metricsRegistry.NewGauge("espv2_config_manager_rollout_poll_consecutive_failures",
+		"Number of consecutive failed checks for new rollouts.")
+	rolloutPollFailing = metricsRegistry.NewGauge("espv2_config_manager_rollout_poll_failing",
+		"1 when --rollout_failure_threshold consecutive checks for new rollouts failed, so the service config may be stale.")
+	snapshotUpdates = metricsRegistry.NewCounter("espv2_config_manager_snapshot_updates_total",
+		"Number of snapshots of Envoy resources pushed to Envoy.")
+	lastSnapshotUpdate = metricsRegistry.NewGauge("espv2_config_manager_last_snapshot_update_timestamp_seconds",
+		"Unix time of the last snapshot pushed to Envoy.")
+	tokenRefreshFailures = metricsRegistry.NewCounter("espv2_config_manager_token_refresh_failures_total",
+		"Number of failures to get an access token, to call Google APIs or to serve in the token agent.")
+	adsStreamConnects = metricsRegistry.NewCounter("espv2_config_manager_ads_stream_connects_total",
+		"Number of ADS streams opened by Envoy.")
+	adsStreamDisconnects = metricsRegistry.NewCounter("espv2_config_manager_ads_stream_disconnects_total",
+		"Number of ADS streams closed.")
+	adsStreams = metricsRegistry.NewGauge("espv2_config_manager_ads_streams",
+		"Number of open ADS streams.")
+)
+
+// MetricsHandler returns the handler serving the metrics of the Config Manager
+// on /metrics in the Prometheus text exposition format, and its health checks
+// on /healthz and /readyz.
+func (m *ConfigManager) MetricsHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle(metricsPath, metricsRegistry)
+	mux.HandleFunc(healthzPath, m.serveHealthz)
+	mux.HandleFunc(readyzPath, m.serveReadyz)
+	return mux
+}
+
+func recordFetchMetrics(fetchType string, start time.Time, err error) {
+	configFetchDuration.Observe(time.Since(start).Seconds())
+	if err != nil {
+		configFetches.Inc(fetchType, "failure")
+		return
+	}
+	configFetches.Inc(fetchType, "success")
+	lastConfigFetchSuccess.Set(float64(time.Now().Unix()), fetchType)
+}
+
+// instrumentedFetcher records the metrics of the fetches of a
+// ServiceConfigFetcher.
+type instrumentedFetcher struct {
+	fetcher ServiceConfigFetcher
+}
+
+func (f *instrumentedFetcher) FetchConfig(serviceName, configID string) (*confpb.Service, error) {
+	start := time.Now()
+	serviceConfig, err := f.fetcher.FetchConfig(serviceName, configID)
+	recordFetchMetrics(fetchTypeConfig, start, err)
+	return serviceConfig, err
+}
+
+func (f *instrumentedFetcher) FetchRollouts(serviceName string) (*smpb.ListServiceRolloutsResponse, error) {
+	start := time.Now()
+	rollouts, err := f.fetcher.FetchRollouts(serviceName)
+	recordFetchMetrics(fetchTypeRollouts, start, err)
+	return rollouts, err
+}
+
+// OnStreamOpen implements the OnStreamOpen method for xds.Callbacks.
+func (m *ConfigManager) OnStreamOpen(ctx context.Context, streamID int64, typeURL string) error {
+	adsStreamConnects.Inc()
+	adsStreams.Add(1)
+	m.recordStreamOpen()
+	return nil
+}
+
+// OnStreamClosed implements the OnStreamClosed method for xds.Callbacks.
+func (m *ConfigManager) OnStreamClosed(streamID int64) {
+	adsStreamDisconnects.Inc()
+	adsStreams.Add(-1)
+	m.recordStreamClosed()
+}
+
+// OnStreamRequest implements the OnStreamRequest method for xds.Callbacks.
+func (m *ConfigManager) OnStreamRequest(int64, *v2pb.DiscoveryRequest) error { return nil }
+
+// OnStreamResponse implements the OnStreamResponse method for xds.Callbacks.
+// Responses are only sent from the snapshot, so Envoy has a service config.
+func (m *ConfigManager) OnStreamResponse(int64, *v2pb.DiscoveryRequest, *v2pb.DiscoveryResponse) {
+	m.recordPush()
+}
+
+// OnFetchRequest implements the OnFetchRequest method for xds.Callbacks.
+func (m *ConfigManager) OnFetchRequest(context.Context, *v2pb.DiscoveryRequest) error { return nil }
+
+// OnFetchResponse implements the OnFetchResponse method for xds.Callbacks.
+func (m *ConfigManager) OnFetchResponse(*v2pb.DiscoveryRequest, *v2pb.DiscoveryResponse) {}
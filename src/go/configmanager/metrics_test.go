@@ -0,0 +1,72 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package configmanager
+
+import (
+	"context"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// scrapeMetrics returns the values of the metrics served by MetricsHandler.
+func scrapeMetrics() map[string]float64 {
+	w := httptest.NewRecorder()
+	(&ConfigManager{}).MetricsHandler().ServeHTTP(w, httptest.NewRequest("GET", metricsPath, nil))
+	values := make(map[string]float64)
+	for _, line := range strings.Split(w.Body.String(), "\n") {
+		i := strings.LastIndex(line, " ")
+		if strings.HasPrefix(line, "#") || i < 0 {
+			continue
+		}
+		values[line[:i]], _ = strconv.ParseFloat(line[i+1:], 64)
+	}
+	return values
+}
+
+func TestMetricsHandler(t *testing.T) {
+	m := &ConfigManager{
+		serviceName: testProjectName,
+		curConfigID: testConfigID,
+		fetcher:     &instrumentedFetcher{fetcher: &fakeRolloutsFetcher{}},
+	}
+
+	// Tickers started by other tests may also update the metrics, so only
+	// the min increase is checked for them.
+	before := scrapeMetrics()
+	m.checkNewRollouts()
+	m.OnStreamOpen(context.Background(), 1, "")
+	m.OnStreamOpen(context.Background(), 2, "")
+	m.OnStreamClosed(1)
+	after := scrapeMetrics()
+
+	for metric, wantIncrease := range map[string]float64{
+		`espv2_config_manager_config_fetches_total{type="rollouts",result="failure"}`: 1,
+		"espv2_config_manager_config_fetch_duration_seconds_count":                    1,
+		"espv2_config_manager_rollout_checks_total":                                   1,
+		"espv2_config_manager_ads_stream_connects_total":                              2,
+		"espv2_config_manager_ads_stream_disconnects_total":                           1,
+		"espv2_config_manager_ads_streams":                                            1,
+	} {
+		if _, ok := after[metric]; !ok {
+			t.Errorf("metric %s is not served", metric)
+			continue
+		}
+		if got := after[metric] - before[metric]; got < wantIncrease {
+			t.Errorf("metric %s increased by %v, want at least %v", metric, got, wantIncrease)
+		}
+	}
+}
@@ -0,0 +1,292 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package configmanager
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/configinfo"
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/logging"
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/util"
+	"github.com/envoyproxy/go-control-plane/pkg/cache"
+
+	gen "github.com/GoogleCloudPlatform/esp-v2/src/go/configgenerator"
+)
+
+// additionalService tracks a service listed after the first one in --service.
+// Its service config is fetched and rolled out independently of the first
+// service, but all services are served by the same Envoy listener.
+type additionalService struct {
+	serviceName string
+	// Config id from --service_config_id or the descriptor file, only used in
+	// fixed rollout strategy.
+	configID     string
+	pathPrefix   string
+	curRolloutID string
+	curConfigID  string
+	serviceInfo  *configinfo.ServiceInfo
+}
+
+// logFields returns the fields identifying the current config of s in the logs.
+func (s *additionalService) logFields() logging.Fields {
+	return logging.Fields{
+		"service_name": s.serviceName,
+		"rollout_id":   s.curRolloutID,
+		"config_id":    s.curConfigID,
+	}
+}
+
+// serviceDescriptor is a service listed in --service.
+type serviceDescriptor struct {
+	Name     string `json:"name"`
+	ConfigID string `json:"configId,omitempty"`
+	// Requests with this path prefix are routed to the service, instead of
+	// the requests with the host of the service.
+	PathPrefix string `json:"pathPrefix,omitempty"`
+}
+
+// serviceDescriptorFile is the format of the descriptor file in --service.
+type serviceDescriptorFile struct {
+	Services []serviceDescriptor `json:"services"`
+}
+
+func splitServiceFlag(value string) []string {
+	var ret []string
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			ret = append(ret, v)
+		}
+	}
+	return ret
+}
+
+// isServiceDescriptorFile returns true if --service is the path of a
+// descriptor file. Service names never contain "/".
+func isServiceDescriptorFile(value string) bool {
+	return strings.Contains(value, "/") || strings.HasSuffix(value, ".json")
+}
+
+// parseServiceFlag returns the services of --service, which is either a comma
+// separated list of services with their config ids in --service_config_id, or
+// the path of a descriptor file.
+func parseServiceFlag(service, serviceConfigID string) ([]serviceDescriptor, error) {
+	if !isServiceDescriptorFile(service) {
+		var services []serviceDescriptor
+		configIDs := splitServiceFlag(serviceConfigID)
+		for i, name := range splitServiceFlag(service) {
+			s := serviceDescriptor{Name: name}
+			if i < len(configIDs) {
+				s.ConfigID = configIDs[i]
+			}
+			services = append(services, s)
+		}
+		return services, nil
+	}
+
+	content, err := ioutil.ReadFile(service)
+	if err != nil {
+		return nil, fmt.Errorf("fail to read service descriptor file: %s, error: %s", service, err)
+	}
+	descriptor := &serviceDescriptorFile{}
+	if err := json.Unmarshal(content, descriptor); err != nil {
+		return nil, fmt.Errorf("fail to unmarshal service descriptor file: %s, error: %s", service, err)
+	}
+	if len(descriptor.Services) == 0 {
+		return nil, fmt.Errorf("service descriptor file %s has no service", service)
+	}
+	if serviceConfigID != "" {
+		logging.Infof("flag --service_config_id is ignored when --service is a descriptor file.")
+	}
+	names := make(map[string]bool)
+	for _, s := range descriptor.Services {
+		if s.Name == "" {
+			return nil, fmt.Errorf("service descriptor file %s has a service without name", service)
+		}
+		if names[s.Name] {
+			return nil, fmt.Errorf("service descriptor file %s has service %v more than once", service, s.Name)
+		}
+		names[s.Name] = true
+		if s.PathPrefix != "" && !strings.HasPrefix(s.PathPrefix, "/") {
+			return nil, fmt.Errorf("path prefix %q of service %v must start with /", s.PathPrefix, s.Name)
+		}
+	}
+	return descriptor.Services, nil
+}
+
+// initAdditionalServices fetches the service configs of the additional
+// services. It must be called before the first service config is applied, so
+// that the first snapshot includes all services.
+//
+// As for the first service, the cached service config of a service is used if
+// its service config cannot be fetched.
+func (m *ConfigManager) initAdditionalServices(rolloutStrategy string) error {
+	if rolloutStrategy == util.FixedRolloutStrategy {
+		for _, s := range m.additionalServices {
+			if s.configID == "" {
+				return fmt.Errorf("service config id of service %v is not specified, one config id for each service is required when multiple services are specified", s.serviceName)
+			}
+		}
+	}
+
+	for _, s := range m.additionalServices {
+		if err := m.loadAdditionalService(s, rolloutStrategy); err != nil {
+			if cacheErr := m.applyAdditionalServiceCache(s, rolloutStrategy); cacheErr != nil {
+				logging.WithFields(s.logFields()).Infof("service config cache is not used: %v", cacheErr)
+				return err
+			}
+			logging.WithFields(s.logFields()).Warningf("fail to load service config, using the cached service config instead: %v", err)
+		} else {
+			m.saveConfigCache(s.serviceName, s.curRolloutID, s.serviceInfo.ServiceConfig())
+		}
+		logging.WithFields(s.logFields()).Infof("add service")
+	}
+	return nil
+}
+
+// loadAdditionalService fetches the service config of s, with the newest
+// rollout in managed rollout strategy.
+func (m *ConfigManager) loadAdditionalService(s *additionalService, rolloutStrategy string) error {
+	if rolloutStrategy == util.FixedRolloutStrategy {
+		s.curConfigID = s.configID
+		return m.updateAdditionalService(s)
+	}
+	newRolloutID, newConfigID, err := loadConfigFromRollouts(s.serviceName, s.curRolloutID, s.curConfigID, m.fetcher)
+	if err != nil {
+		return fmt.Errorf("fail to load rollouts for service %v, %v", s.serviceName, err)
+	}
+	s.curRolloutID = newRolloutID
+	s.curConfigID = newConfigID
+	return m.updateAdditionalService(s)
+}
+
+// applyAdditionalServiceCache sets the service config of s from its cache file.
+func (m *ConfigManager) applyAdditionalServiceCache(s *additionalService, rolloutStrategy string) error {
+	var configID string
+	if rolloutStrategy == util.FixedRolloutStrategy {
+		configID = s.configID
+	}
+	cached, serviceConfig, err := m.loadConfigCache(s.serviceName, configID)
+	if err != nil {
+		return err
+	}
+	serviceInfo, err := m.newServiceInfo(serviceConfig, cached.ConfigID)
+	if err != nil {
+		return err
+	}
+	s.curRolloutID, s.curConfigID, s.serviceInfo = cached.RolloutID, cached.ConfigID, serviceInfo
+	return nil
+}
+
+// updateAdditionalService fetches the service config of s.curConfigID.
+func (m *ConfigManager) updateAdditionalService(s *additionalService) error {
+	serviceConfig, err := m.fetchServiceConfig(s.serviceName, s.curConfigID)
+	if err != nil {
+		return fmt.Errorf("fail to fetch service config for service %v, %s", s.serviceName, err)
+	}
+	serviceInfo, err := m.newServiceInfo(serviceConfig, s.curConfigID)
+	if err != nil {
+		return err
+	}
+	s.serviceInfo = serviceInfo
+	return nil
+}
+
+// checkAdditionalServiceRollouts updates the snapshot if any additional
+// service has a new rollout. Errors are logged, like for the first service, and
+// the first one is returned once all services are checked.
+func (m *ConfigManager) checkAdditionalServiceRollouts() error {
+	var firstErr error
+	for _, s := range m.additionalServices {
+		m.Infof("check new rollouts for service %v", s.serviceName)
+		newRolloutID, newConfigID, err := loadConfigFromRollouts(s.serviceName, s.curRolloutID, s.curConfigID, m.fetcher)
+		m.recordFetch(err)
+		if err != nil {
+			logging.WithFields(s.logFields()).Errorf("error occurred when checking new rollouts, %v", err)
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		if s.curRolloutID == newRolloutID || s.curConfigID == newConfigID {
+			continue
+		}
+		prevRolloutID, prevConfigID, prevServiceInfo := s.curRolloutID, s.curConfigID, s.serviceInfo
+		s.curRolloutID = newRolloutID
+		s.curConfigID = newConfigID
+		err = m.updateAdditionalService(s)
+		if err == nil {
+			err = m.setSnapshot()
+		}
+		if err != nil {
+			logging.WithFields(s.logFields()).Errorf("error occurred when applying the rollout, rolled back to configuration id %v: %v", prevConfigID, err)
+			s.curRolloutID, s.curConfigID, s.serviceInfo = prevRolloutID, prevConfigID, prevServiceInfo
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		m.saveConfigCache(s.serviceName, s.curRolloutID, s.serviceInfo.ServiceConfig())
+	}
+	return firstErr
+}
+
+func (m *ConfigManager) makeMultiServiceSnapshot() (*cache.Snapshot, error) {
+	configs := []gen.MultiServiceConfig{{
+		ServiceInfo: m.serviceInfo,
+		PathPrefix:  m.servicePathPrefix,
+	}}
+	configIDs := []string{m.curConfigID}
+	for _, s := range m.additionalServices {
+		configs = append(configs, gen.MultiServiceConfig{
+			ServiceInfo: s.serviceInfo,
+			PathPrefix:  s.pathPrefix,
+		})
+		configIDs = append(configIDs, s.curConfigID)
+	}
+	m.Infof("making configuration for services: %v", m.serviceNames())
+
+	var clusterResources, endpoints, runtimes, routes, listenerResources []cache.Resource
+	clusters, err := gen.MakeClustersForMultiService(configs, *MultiServiceBasePort)
+	if err != nil {
+		return nil, err
+	}
+	for i := range clusters {
+		clusterResources = append(clusterResources, clusters[i])
+	}
+
+	listeners, err := gen.MakeListenersForMultiService(configs, *MultiServiceBasePort)
+	if err != nil {
+		return nil, err
+	}
+	for _, lis := range listeners {
+		listenerResources = append(listenerResources, lis)
+	}
+
+	// The snapshot version changes when the config of any service changes.
+	snapshot := cache.NewSnapshot(strings.Join(configIDs, ","), endpoints, clusterResources, routes, listenerResources, runtimes)
+	m.Infof("Envoy Dynamic Configuration is cached for services: %v", m.serviceNames())
+	return &snapshot, nil
+}
+
+func (m *ConfigManager) serviceNames() []string {
+	names := []string{m.serviceName}
+	for _, s := range m.additionalServices {
+		names = append(names, s.serviceName)
+	}
+	return names
+}
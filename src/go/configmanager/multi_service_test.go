@@ -0,0 +1,207 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package configmanager
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/metadata"
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/options"
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/util"
+	"github.com/envoyproxy/go-control-plane/pkg/cache"
+
+	v2pb "github.com/envoyproxy/go-control-plane/envoy/api/v2"
+	corepb "github.com/envoyproxy/go-control-plane/envoy/api/v2/core"
+)
+
+func TestNewConfigManagerWithMultipleServices(t *testing.T) {
+	const secondService = "second.endpoints.project123.cloud.goog"
+	configs := make(map[string][]byte)
+	for _, input := range []struct{ serviceName, apiName, configID string }{
+		{testProjectName, testEndpointName, testConfigID},
+		{secondService, "endpoints.examples.second.Second", "2017-05-01r1"},
+	} {
+		config, err := genFakeConfig(fmt.Sprintf(`{"name":"%s","id":"%s","apis":[{"name":"%s"}]}`, input.serviceName, input.configID, input.apiName))
+		if err != nil {
+			t.Fatalf("fail to generate fake config: %v", err)
+		}
+		configs["/"+input.serviceName+"/"+input.configID] = config
+	}
+	configServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		config, ok := configs[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write(config)
+	}))
+	defer configServer.Close()
+	fetchConfigURL = func(serviceName, configID string) string {
+		return configServer.URL + "/" + serviceName + "/" + configID
+	}
+
+	mockMetadataServer := util.InitMockServerFromPathResp(map[string]string{
+		util.AccessTokenSuffix: fakeToken,
+	})
+	defer mockMetadataServer.Close()
+
+	flag.Set("service", testProjectName+", "+secondService)
+	flag.Set("rollout_strategy", "fixed")
+	defer flag.Set("service", testProjectName)
+	defer flag.Set("service_config_id", testConfigID)
+
+	opts := options.DefaultConfigGeneratorOptions()
+	opts.BackendAddress = "http://127.0.0.1:8082"
+
+	// Each service must have a config id in fixed mode.
+	flag.Set("service_config_id", testConfigID)
+	if _, err := NewConfigManager(metadata.NewMockMetadataFetcher(mockMetadataServer.URL, time.Now()), opts); err == nil || !strings.Contains(err.Error(), "one config id for each service") {
+		t.Errorf("NewConfigManager got error %v, want error about missing config ids", err)
+	}
+
+	cacheDir, err := ioutil.TempDir("", "service_config_cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(cacheDir)
+	flag.Set("service_config_cache_path", filepath.Join(cacheDir, "service_config.json"))
+	defer flag.Set("service_config_cache_path", "")
+
+	flag.Set("service_config_id", testConfigID+",2017-05-01r1")
+	manager, err := NewConfigManager(metadata.NewMockMetadataFetcher(mockMetadataServer.URL, time.Now()), opts)
+	if err != nil {
+		t.Fatalf("NewConfigManager got error: %v", err)
+	}
+
+	ctx := context.Background()
+	resp, err := manager.cache.Fetch(ctx, v2pb.DiscoveryRequest{
+		Node:    &corepb.Node{Id: opts.Node},
+		TypeUrl: cache.ClusterType,
+	})
+	if err != nil {
+		t.Fatalf("fail to fetch clusters: %v", err)
+	}
+	if resp.Version != testConfigID+",2017-05-01r1" {
+		t.Errorf("got snapshot version %v, want %v", resp.Version, testConfigID+",2017-05-01r1")
+	}
+
+	var gotClusters []string
+	for _, r := range sortResources(resp) {
+		gotClusters = append(gotClusters, cache.GetResourceName(r))
+	}
+	for _, want := range []string{testBackendClusterName, secondService + "_local"} {
+		found := false
+		for _, got := range gotClusters {
+			found = found || got == want
+		}
+		if !found {
+			t.Errorf("got clusters %v, want cluster %v", gotClusters, want)
+		}
+	}
+
+	// The config of each service is cached, and used if it cannot be fetched.
+	delete(configs, "/"+secondService+"/2017-05-01r1")
+	manager, err = NewConfigManager(metadata.NewMockMetadataFetcher(mockMetadataServer.URL, time.Now()), opts)
+	if err != nil {
+		t.Fatalf("NewConfigManager with cached service config got error: %v", err)
+	}
+	if manager.additionalServices[0].curConfigID != "2017-05-01r1" {
+		t.Errorf("got config id %v from cache, want 2017-05-01r1", manager.additionalServices[0].curConfigID)
+	}
+}
+
+func TestParseServiceFlag(t *testing.T) {
+	descriptorFile, err := ioutil.TempFile("", "services.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(descriptorFile.Name())
+
+	testData := []struct {
+		desc            string
+		service         string
+		serviceConfigID string
+		descriptor      string
+		wantServices    []serviceDescriptor
+		wantError       string
+	}{
+		{
+			desc:            "Success, comma separated services with config ids",
+			service:         "foo.endpoints.project123.cloud.goog, bar.endpoints.project123.cloud.goog",
+			serviceConfigID: "2017-05-01r0,2017-05-01r1",
+			wantServices: []serviceDescriptor{
+				{Name: "foo.endpoints.project123.cloud.goog", ConfigID: "2017-05-01r0"},
+				{Name: "bar.endpoints.project123.cloud.goog", ConfigID: "2017-05-01r1"},
+			},
+		},
+		{
+			desc:       "Success, descriptor file with path prefix",
+			service:    descriptorFile.Name(),
+			descriptor: `{"services": [{"name": "foo.endpoints.project123.cloud.goog"}, {"name": "bar.endpoints.project123.cloud.goog", "configId": "2017-05-01r1", "pathPrefix": "/bar/"}]}`,
+			wantServices: []serviceDescriptor{
+				{Name: "foo.endpoints.project123.cloud.goog"},
+				{Name: "bar.endpoints.project123.cloud.goog", ConfigID: "2017-05-01r1", PathPrefix: "/bar/"},
+			},
+		},
+		{
+			desc:       "Failure, path prefix not starting with /",
+			service:    descriptorFile.Name(),
+			descriptor: `{"services": [{"name": "foo.endpoints.project123.cloud.goog", "pathPrefix": "foo"}]}`,
+			wantError:  `path prefix "foo" of service foo.endpoints.project123.cloud.goog must start with /`,
+		},
+		{
+			desc:       "Failure, service listed twice",
+			service:    descriptorFile.Name(),
+			descriptor: `{"services": [{"name": "foo.endpoints.project123.cloud.goog"}, {"name": "foo.endpoints.project123.cloud.goog"}]}`,
+			wantError:  "has service foo.endpoints.project123.cloud.goog more than once",
+		},
+		{
+			desc:      "Failure, descriptor file not found",
+			service:   "/tmp/not_found/services.json",
+			wantError: "fail to read service descriptor file",
+		},
+	}
+
+	for _, tc := range testData {
+		if err := ioutil.WriteFile(descriptorFile.Name(), []byte(tc.descriptor), 0644); err != nil {
+			t.Fatal(err)
+		}
+		services, err := parseServiceFlag(tc.service, tc.serviceConfigID)
+		if tc.wantError != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.wantError) {
+				t.Errorf("Test Desc(%s): got error %v, want error containing %q", tc.desc, err, tc.wantError)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Test Desc(%s): got error: %v", tc.desc, err)
+			continue
+		}
+		if !reflect.DeepEqual(services, tc.wantServices) {
+			t.Errorf("Test Desc(%s): got services %+v, want %+v", tc.desc, services, tc.wantServices)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package configmanager
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/logging"
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/util"
+)
+
+// Pod annotations of the service, like the metadata keys of the metadata
+// server.
+const (
+	serviceNameAnnotation     = "endpoints.cloud.google.com/service-name"
+	serviceConfigIDAnnotation = "endpoints.cloud.google.com/service-config-id"
+)
+
+// WatchPodAnnotations starts checking the pod annotations in
+// --pod_annotations_path for changes every --pod_annotations_check_interval,
+// and applying the service config of the changed service name and config ID.
+//
+// Only the annotations of a single service are watched, in "fixed" rollout
+// strategy, as the config ID follows the rollouts in "managed" one.
+func (m *ConfigManager) WatchPodAnnotations() {
+	if *PodAnnotationsPath == "" || *podAnnotationsCheckInterval == 0 || m.rolloutStrategy != util.FixedRolloutStrategy {
+		return
+	}
+	if len(m.additionalServices) > 0 {
+		logging.Warningf("pod annotations are not checked for changes with multiple services")
+		return
+	}
+
+	logging.Infof("start checking pod annotations in %v every %v", *PodAnnotationsPath, *podAnnotationsCheckInterval)
+	go func() {
+		for range time.Tick(*podAnnotationsCheckInterval) {
+			// only log error and keep serving the current config when the new one is invalid
+			if err := m.checkPodAnnotations(); err != nil {
+				logging.Errorf("error occurred when checking pod annotations, %v", err)
+			}
+		}
+	}()
+}
+
+// checkPodAnnotations applies the service config of the service name and
+// config ID in the pod annotations if they have changed, unless they are set
+// by --service and --service_config_id.
+func (m *ConfigManager) checkPodAnnotations() error {
+	annotations, err := readPodAnnotations(*PodAnnotationsPath)
+	if err != nil {
+		return err
+	}
+	serviceName, configID := m.serviceName, m.curConfigID
+	if name := annotations[serviceNameAnnotation]; *ServiceName == "" && name != "" {
+		serviceName = name
+	}
+	if id := annotations[serviceConfigIDAnnotation]; *ServiceConfigID == "" && id != "" {
+		configID = id
+	}
+	if serviceName == m.serviceName && configID == m.curConfigID {
+		return nil
+	}
+
+	prevServiceName, prevConfigID := m.serviceName, m.curConfigID
+	m.serviceName, m.curConfigID = serviceName, configID
+	if err := m.updateSnapshot(); err != nil {
+		m.serviceName, m.curConfigID = prevServiceName, prevConfigID
+		return err
+	}
+	logging.WithFields(m.logFields()).Infof("applied the service config of the changed pod annotations")
+	return nil
+}
+
+// readPodAnnotations reads the pod annotations in the file written by the
+// downward API, with one annotation per line like key="value".
+func readPodAnnotations(path string) (map[string]string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("fail to read pod annotations: %v", err)
+	}
+	annotations := make(map[string]string)
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("fail to parse pod annotation %q, it must be like key=\"value\"", line)
+		}
+		// The values are quoted with the escapes of Go.
+		value, err := strconv.Unquote(kv[1])
+		if err != nil {
+			return nil, fmt.Errorf("fail to parse pod annotation %q: %v", line, err)
+		}
+		annotations[kv[0]] = value
+	}
+	return annotations, nil
+}
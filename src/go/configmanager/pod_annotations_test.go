@@ -0,0 +1,159 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package configmanager
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/options"
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/util"
+	"github.com/envoyproxy/go-control-plane/pkg/cache"
+)
+
+func TestReadPodAnnotations(t *testing.T) {
+	testData := []struct {
+		desc            string
+		content         string
+		wantAnnotations map[string]string
+		wantError       string
+	}{
+		{
+			desc: "Success, annotations of the downward API",
+			content: `endpoints.cloud.google.com/service-name="bookstore.endpoints.project123.cloud.goog"
+endpoints.cloud.google.com/service-config-id="2017-05-01r0"
+kubernetes.io/config.seen="{\"a\": \"b\"}"
+`,
+			wantAnnotations: map[string]string{
+				serviceNameAnnotation:       testProjectName,
+				serviceConfigIDAnnotation:   testConfigID,
+				"kubernetes.io/config.seen": `{"a": "b"}`,
+			},
+		},
+		{
+			desc:      "Fail, annotation without value",
+			content:   `endpoints.cloud.google.com/service-name`,
+			wantError: `it must be like key="value"`,
+		},
+		{
+			desc:      "Fail, annotation with unquoted value",
+			content:   `endpoints.cloud.google.com/service-name=bookstore`,
+			wantError: "fail to parse pod annotation",
+		},
+	}
+
+	dir, err := ioutil.TempDir("", "pod_annotations")
+	if err != nil {
+		t.Fatalf("fail to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "annotations")
+
+	for _, tc := range testData {
+		if err := ioutil.WriteFile(path, []byte(tc.content), 0644); err != nil {
+			t.Fatalf("fail to write pod annotations: %v", err)
+		}
+		annotations, err := readPodAnnotations(path)
+		if tc.wantError != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.wantError) {
+				t.Errorf("Test Desc(%s): got error %v, want error containing %v", tc.desc, err, tc.wantError)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Test Desc(%s): error not expected, got: %v", tc.desc, err)
+			continue
+		}
+		if !reflect.DeepEqual(annotations, tc.wantAnnotations) {
+			t.Errorf("Test Desc(%s): got annotations %v, want %v", tc.desc, annotations, tc.wantAnnotations)
+		}
+	}
+}
+
+func TestCheckPodAnnotations(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pod_annotations")
+	if err != nil {
+		t.Fatalf("fail to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "annotations")
+
+	writeAnnotations := func(configID string) {
+		content := fmt.Sprintf("%s=%q\n%s=%q\n", serviceNameAnnotation, testProjectName, serviceConfigIDAnnotation, configID)
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("fail to write pod annotations: %v", err)
+		}
+	}
+	writeAnnotations(testConfigID)
+
+	flag.Set("service", "")
+	flag.Set("service_config_id", "")
+	flag.Set("rollout_strategy", util.FixedRolloutStrategy)
+	flag.Set("pod_annotations_path", path)
+	defer flag.Set("pod_annotations_path", "")
+
+	fakeConfig, err = genFakeConfig(fmt.Sprintf(`{"name":"%s","id":"%s","apis":[{"name":"%s"}]}`, testProjectName, testConfigID, testEndpointName))
+	if err != nil {
+		t.Fatalf("fail to generate fake config: %v", err)
+	}
+	opts := options.DefaultConfigGeneratorOptions()
+	opts.BackendAddress = "http://127.0.0.1:8082"
+
+	runTest(t, opts, func(env *testEnv) {
+		manager := env.configManager
+		if manager.serviceName != testProjectName || manager.curConfigID != testConfigID {
+			t.Fatalf("got service %v and config id %v, want the ones of the annotations, %v and %v", manager.serviceName, manager.curConfigID, testProjectName, testConfigID)
+		}
+
+		testCases := []struct {
+			desc         string
+			configID     string
+			wantConfigID string
+		}{
+			{
+				desc:         "Unchanged annotations keep the snapshot",
+				configID:     testConfigID,
+				wantConfigID: testConfigID,
+			},
+			{
+				desc:         "Changed config id updates the snapshot",
+				configID:     "2017-05-02r0",
+				wantConfigID: "2017-05-02r0",
+			},
+		}
+		for _, tc := range testCases {
+			writeAnnotations(tc.configID)
+			if err := manager.checkPodAnnotations(); err != nil {
+				t.Errorf("Test Desc(%s): error not expected, got: %v", tc.desc, err)
+			}
+			if manager.curConfigID != tc.wantConfigID {
+				t.Errorf("Test Desc(%s): got config id %v, want %v", tc.desc, manager.curConfigID, tc.wantConfigID)
+			}
+			snapshot, err := manager.cache.GetSnapshot(opts.Node)
+			if err != nil {
+				t.Fatalf("Test Desc(%s): fail to get snapshot: %v", tc.desc, err)
+			}
+			if got := snapshot.GetVersion(cache.ListenerType); got != tc.wantConfigID {
+				t.Errorf("Test Desc(%s): got snapshot version %v, want %v", tc.desc, got, tc.wantConfigID)
+			}
+		}
+	})
+}
@@ -0,0 +1,195 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package configmanager
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/bootstrap/static"
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/options"
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/util"
+
+	confpb "google.golang.org/genproto/googleapis/api/serviceconfig"
+)
+
+// Render returns the static Envoy bootstrap config of the service config in
+// --service_json_path or the OpenAPI document in --openapi_spec_path, in the
+// "json" or "yaml" format. Neither Service Management nor the metadata server
+// is called.
+func Render(opts options.ConfigGeneratorOptions, format string) (string, error) {
+	if format != "json" && format != "yaml" {
+		return "", fmt.Errorf(`invalid --render_format %q, must be either "json" or "yaml"`, format)
+	}
+	opts.NonGCP = true
+	if err := setJwksTLSConfig(&opts); err != nil {
+		return "", err
+	}
+
+	var serviceConfig *confpb.Service
+	var err error
+	switch {
+	case *ServicePath != "":
+		serviceConfig, err = (&fileFetcher{path: *ServicePath}).FetchConfig("", "")
+	case *OpenAPISpecPath != "":
+		serviceConfig, err = readOpenAPISpec(*OpenAPISpecPath, &opts)
+	default:
+		err = fmt.Errorf("render requires --service_json_path or --openapi_spec_path")
+	}
+	if err != nil {
+		return "", err
+	}
+
+	bt, err := static.ServiceToBootstrapConfig(serviceConfig, serviceConfig.GetId(), opts)
+	if err != nil {
+		return "", err
+	}
+	str, err := util.ProtoToJson(bt)
+	if err != nil {
+		return "", fmt.Errorf("fail to marshal the bootstrap config: %v", err)
+	}
+	if format == "yaml" {
+		return jsonToYaml(str)
+	}
+	var out bytes.Buffer
+	if err := json.Indent(&out, []byte(str), "", "  "); err != nil {
+		return "", fmt.Errorf("fail to indent the bootstrap config: %v", err)
+	}
+	return out.String() + "\n", nil
+}
+
+// yamlNode is a decoded JSON value keeping the order of the object keys, so
+// that the YAML follows the field order of the protos.
+type yamlNode struct {
+	// Set for objects.
+	keys   []string
+	values []*yamlNode
+	// Set for arrays.
+	items []*yamlNode
+	// The YAML of scalars, and empty objects and arrays.
+	scalar string
+}
+
+var plainYamlKey = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.\-]*$`)
+
+// jsonToYaml converts JSON to block-style YAML. Strings are kept double-quoted
+// so that they never become numbers or booleans.
+func jsonToYaml(str string) (string, error) {
+	dec := json.NewDecoder(strings.NewReader(str))
+	dec.UseNumber()
+	node, err := decodeYamlNode(dec)
+	if err != nil {
+		return "", fmt.Errorf("fail to convert the bootstrap config to YAML: %v", err)
+	}
+	var out strings.Builder
+	switch {
+	case node.keys != nil:
+		writeYamlObject(&out, node, "")
+	case node.items != nil:
+		writeYamlArray(&out, node, "")
+	default:
+		out.WriteString(node.scalar + "\n")
+	}
+	return out.String(), nil
+}
+
+func decodeYamlNode(dec *json.Decoder) (*yamlNode, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch v := tok.(type) {
+	case json.Delim:
+		node := &yamlNode{}
+		for dec.More() {
+			if v == '{' {
+				key, err := dec.Token()
+				if err != nil {
+					return nil, err
+				}
+				node.keys = append(node.keys, key.(string))
+			}
+			child, err := decodeYamlNode(dec)
+			if err != nil {
+				return nil, err
+			}
+			if v == '{' {
+				node.values = append(node.values, child)
+			} else {
+				node.items = append(node.items, child)
+			}
+		}
+		// The closing delimiter.
+		if _, err := dec.Token(); err != nil {
+			return nil, err
+		}
+		if v == '{' && node.keys == nil {
+			node.scalar = "{}"
+		} else if v == '[' && node.items == nil {
+			node.scalar = "[]"
+		}
+		return node, nil
+	case string:
+		b, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		return &yamlNode{scalar: string(b)}, nil
+	case nil:
+		return &yamlNode{scalar: "null"}, nil
+	default:
+		return &yamlNode{scalar: fmt.Sprint(v)}, nil
+	}
+}
+
+func writeYamlObject(out *strings.Builder, node *yamlNode, indent string) {
+	for i, key := range node.keys {
+		if !plainYamlKey.MatchString(key) {
+			b, _ := json.Marshal(key)
+			key = string(b)
+		}
+		value := node.values[i]
+		switch {
+		case value.keys != nil:
+			out.WriteString(indent + key + ":\n")
+			writeYamlObject(out, value, indent+"  ")
+		case value.items != nil:
+			out.WriteString(indent + key + ":\n")
+			writeYamlArray(out, value, indent+"  ")
+		default:
+			out.WriteString(indent + key + ": " + value.scalar + "\n")
+		}
+	}
+}
+
+func writeYamlArray(out *strings.Builder, node *yamlNode, indent string) {
+	for _, item := range node.items {
+		switch {
+		case item.keys != nil:
+			// The first key is on the line of the dash.
+			var itemOut strings.Builder
+			writeYamlObject(&itemOut, item, indent+"  ")
+			out.WriteString(indent + "- " + strings.TrimPrefix(itemOut.String(), indent+"  "))
+		case item.items != nil:
+			out.WriteString(indent + "-\n")
+			writeYamlArray(out, item, indent+"  ")
+		default:
+			out.WriteString(indent + "- " + item.scalar + "\n")
+		}
+	}
+}
@@ -0,0 +1,141 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package configmanager
+
+import (
+	"flag"
+	"strings"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/options"
+	"github.com/golang/protobuf/jsonpb"
+
+	bootstrappb "github.com/envoyproxy/go-control-plane/envoy/config/bootstrap/v2"
+)
+
+func TestRender(t *testing.T) {
+	testData := []struct {
+		desc        string
+		servicePath string
+		format      string
+		wantPrefix  string
+		wantError   string
+	}{
+		{
+			desc:        "json",
+			servicePath: "testdata/service_config_for_dynamic_routing.json",
+			format:      "json",
+			wantPrefix:  "{\n  \"node\": {\n",
+		},
+		{
+			desc:        "yaml",
+			servicePath: "testdata/service_config_for_dynamic_routing.json",
+			format:      "yaml",
+			wantPrefix:  "node:\n  id: \"ESPv2\"\n",
+		},
+		{
+			desc:        "invalid format",
+			servicePath: "testdata/service_config_for_dynamic_routing.json",
+			format:      "xml",
+			wantError:   `invalid --render_format "xml", must be either "json" or "yaml"`,
+		},
+		{
+			desc:      "no service config file",
+			format:    "json",
+			wantError: "render requires --service_json_path or --openapi_spec_path",
+		},
+	}
+
+	defer flag.Set("service_json_path", "")
+	for _, tc := range testData {
+		flag.Set("service_json_path", tc.servicePath)
+		opts := options.DefaultConfigGeneratorOptions()
+		opts.DisableTracing = true
+
+		got, err := Render(opts, tc.format)
+		if tc.wantError != "" {
+			if err == nil || err.Error() != tc.wantError {
+				t.Errorf("Test Desc(%s), got error %v, want %q", tc.desc, err, tc.wantError)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Test Desc(%s), got error %v", tc.desc, err)
+			continue
+		}
+		if !strings.HasPrefix(got, tc.wantPrefix) {
+			t.Errorf("Test Desc(%s), got %q, want the prefix %q", tc.desc, got, tc.wantPrefix)
+		}
+		if tc.format != "json" {
+			continue
+		}
+		bt := &bootstrappb.Bootstrap{}
+		if err := jsonpb.UnmarshalString(got, bt); err != nil {
+			t.Errorf("Test Desc(%s), fail to unmarshal the bootstrap config: %v", tc.desc, err)
+			continue
+		}
+		if len(bt.GetStaticResources().GetListeners()) == 0 || len(bt.GetStaticResources().GetClusters()) == 0 {
+			t.Errorf("Test Desc(%s), got static resources %v, want the listeners and clusters of the service config", tc.desc, bt.GetStaticResources())
+		}
+	}
+}
+
+func TestJsonToYaml(t *testing.T) {
+	testData := []struct {
+		desc     string
+		json     string
+		wantYaml string
+	}{
+		{
+			desc: "objects keep the order of their keys",
+			json: `{"name":"a","@type":"t","port":8080,"enabled":true,"empty":{},"none":[]}`,
+			wantYaml: `name: "a"
+"@type": "t"
+port: 8080
+enabled: true
+empty: {}
+none: []
+`,
+		},
+		{
+			desc: "arrays of objects, scalars and arrays",
+			json: `{"filters":[{"name":"a","config":{"x":"1"}},{"name":"b"}],"domains":["*","true"],"matrix":[[1,2]]}`,
+			wantYaml: `filters:
+  - name: "a"
+    config:
+      x: "1"
+  - name: "b"
+domains:
+  - "*"
+  - "true"
+matrix:
+  -
+    - 1
+    - 2
+`,
+		},
+	}
+
+	for _, tc := range testData {
+		got, err := jsonToYaml(tc.json)
+		if err != nil {
+			t.Errorf("Test Desc(%s), got error %v", tc.desc, err)
+			continue
+		}
+		if got != tc.wantYaml {
+			t.Errorf("Test Desc(%s), got:\n%s\nwant:\n%s", tc.desc, got, tc.wantYaml)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package configmanager
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/logging"
+)
+
+// rolloutDrift compares the config id pinned for a service in fixed rollout
+// strategy with the config of its latest rollout, served on /status.
+type rolloutDrift struct {
+	ServiceName    string `json:"serviceName"`
+	PinnedConfigID string `json:"pinnedConfigId"`
+	// The latest rollout and its config with the max traffic percentage,
+	// empty if the service is deleted.
+	LiveRolloutID string `json:"liveRolloutId,omitempty"`
+	LiveConfigID  string `json:"liveConfigId,omitempty"`
+	Drift         bool   `json:"drift"`
+}
+
+// watchRolloutDrift checks the drift of the pinned config ids every
+// --rollout_poll_interval, with --detect_rollout_drift.
+func (m *ConfigManager) watchRolloutDrift() {
+	logging.Infof("start checking the drift of the pinned service configs every %v", rolloutPollInterval())
+	m.checkRolloutDrift()
+	for range time.Tick(rolloutPollInterval()) {
+		m.checkRolloutDrift()
+	}
+}
+
+// checkRolloutDrift fetches the latest rollout of each service and warns when
+// it does not serve the pinned config id, so that operators know their pin is
+// stale. The pinned config is still served.
+func (m *ConfigManager) checkRolloutDrift() {
+	var drifts []rolloutDrift
+	anyDrift := false
+	check := func(serviceName, configID string) {
+		fields := logging.Fields{"service_name": serviceName, "config_id": configID}
+		rollouts, err := m.fetcher.FetchRollouts(serviceName)
+		if err == nil && len(rollouts.GetRollouts()) == 0 {
+			err = fmt.Errorf("no active rollouts")
+		}
+		if err != nil {
+			logging.WithFields(fields).Warningf("fail to check the drift of the pinned service config: %v", err)
+			return
+		}
+		latest := rollouts.GetRollouts()[0]
+		drift := rolloutDrift{
+			ServiceName:    serviceName,
+			PinnedConfigID: configID,
+			LiveRolloutID:  latest.GetRolloutId(),
+		}
+		if latest.GetDeleteServiceStrategy() == nil {
+			drift.LiveConfigID, _ = maxPercentConfigID(latest)
+		}
+		drift.Drift = drift.LiveConfigID != configID
+		if drift.Drift && !m.hasRolloutDrift(drift) {
+			// Only logged when the drift changes, not on every check.
+			logging.WithFields(fields).Warningf("pinned service config is stale, the latest rollout %v serves configuration id %q", drift.LiveRolloutID, drift.LiveConfigID)
+		}
+		anyDrift = anyDrift || drift.Drift
+		drifts = append(drifts, drift)
+	}
+	check(m.serviceName, m.curConfigID)
+	for _, s := range m.additionalServices {
+		check(s.serviceName, s.curConfigID)
+	}
+
+	if anyDrift {
+		pinnedConfigDrift.Set(1)
+	} else {
+		pinnedConfigDrift.Set(0)
+	}
+	m.status.mu.Lock()
+	defer m.status.mu.Unlock()
+	m.status.status.RolloutDrifts = drifts
+}
+
+// hasRolloutDrift returns whether the drift was already found by the previous
+// check.
+func (m *ConfigManager) hasRolloutDrift(drift rolloutDrift) bool {
+	m.status.mu.Lock()
+	defer m.status.mu.Unlock()
+	for _, d := range m.status.status.RolloutDrifts {
+		if d == drift {
+			return true
+		}
+	}
+	return false
+}
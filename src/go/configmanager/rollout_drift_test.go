@@ -0,0 +1,101 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package configmanager
+
+import (
+	"reflect"
+	"testing"
+
+	smpb "google.golang.org/genproto/googleapis/api/servicemanagement/v1"
+)
+
+func TestCheckRolloutDrift(t *testing.T) {
+	testData := []struct {
+		desc       string
+		rollouts   []*smpb.Rollout
+		wantDrifts []rolloutDrift
+		wantMetric float64
+	}{
+		{
+			desc: "Pinned config served by the latest rollout",
+			rollouts: []*smpb.Rollout{
+				makeTrafficPercentRollout("2017-05-01r0-rollout", testConfigID),
+			},
+			wantDrifts: []rolloutDrift{
+				{
+					ServiceName:    testProjectName,
+					PinnedConfigID: testConfigID,
+					LiveRolloutID:  "2017-05-01r0-rollout",
+					LiveConfigID:   testConfigID,
+				},
+			},
+		},
+		{
+			desc: "Pinned config diverging from the latest rollout",
+			rollouts: []*smpb.Rollout{
+				makeTrafficPercentRollout("2017-05-02r0", "2017-05-02r1"),
+				makeTrafficPercentRollout("2017-05-01r0-rollout", testConfigID),
+			},
+			wantDrifts: []rolloutDrift{
+				{
+					ServiceName:    testProjectName,
+					PinnedConfigID: testConfigID,
+					LiveRolloutID:  "2017-05-02r0",
+					LiveConfigID:   "2017-05-02r1",
+					Drift:          true,
+				},
+			},
+			wantMetric: 1,
+		},
+		{
+			desc: "Pinned config of a deleted service",
+			rollouts: []*smpb.Rollout{
+				makeDeleteServiceRollout("2017-05-02r0"),
+			},
+			wantDrifts: []rolloutDrift{
+				{
+					ServiceName:    testProjectName,
+					PinnedConfigID: testConfigID,
+					LiveRolloutID:  "2017-05-02r0",
+					Drift:          true,
+				},
+			},
+			wantMetric: 1,
+		},
+		{
+			desc: "Rollouts not fetched",
+		},
+	}
+
+	for _, tc := range testData {
+		m := &ConfigManager{
+			serviceName: testProjectName,
+			curConfigID: testConfigID,
+			fetcher: &fakeServiceFetcher{
+				rollouts: &smpb.ListServiceRolloutsResponse{Rollouts: tc.rollouts},
+			},
+		}
+		// Checked twice, as the same drift is only logged once.
+		m.checkRolloutDrift()
+		m.checkRolloutDrift()
+
+		if !reflect.DeepEqual(m.status.status.RolloutDrifts, tc.wantDrifts) {
+			t.Errorf("Test Desc(%s): got drifts %+v, want %+v", tc.desc, m.status.status.RolloutDrifts, tc.wantDrifts)
+		}
+		if got := scrapeMetrics()["espv2_config_manager_pinned_config_drift"]; got != tc.wantMetric {
+			t.Errorf("Test Desc(%s): got pinned_config_drift %v, want %v", tc.desc, got, tc.wantMetric)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package configmanager
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/logging"
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/metadata"
+)
+
+const (
+	pubsubPullSuffix        = ":pull"
+	pubsubAcknowledgeSuffix = ":acknowledge"
+	pubsubMaxMessages       = 10
+	// A pull is held by Cloud Pub/Sub until messages are available, or up to
+	// about 90 seconds, so the client timeout must be longer than that.
+	pubsubPullTimeout = 2 * time.Minute
+	// Delay before pulling again after a pull returned no message, in case
+	// Cloud Pub/Sub returns empty responses right away.
+	pubsubEmptyPullBackoff = time.Second
+)
+
+// HTTP client calling Cloud Pub/Sub, with a timeout longer than a pull.
+var pubsubClient *http.Client
+
+// pubsubPullResponse is the response of the Cloud Pub/Sub pull REST API. Only
+// the fields used to acknowledge the messages are kept, the message content
+// is not needed since any notification triggers a rollout check.
+type pubsubPullResponse struct {
+	ReceivedMessages []struct {
+		AckID   string `json:"ackId"`
+		Message struct {
+			MessageID string `json:"messageId"`
+		} `json:"message"`
+	} `json:"receivedMessages"`
+}
+
+// pullRolloutNotifications keeps pulling messages from the Cloud Pub/Sub
+// subscription, and signals notifications once for each batch of messages.
+// When pulling fails, it waits for --check_rollout_interval before retrying,
+// so that the periodic rollout check is used in the meantime.
+func pullRolloutNotifications(subscription string, mf *metadata.MetadataFetcher, notifications chan<- struct{}) {
+	var err error
+	if pubsubClient, err = newServiceConfigFetcherClient(pubsubPullTimeout); err != nil {
+		logging.Errorf("fail to create https client to call Cloud Pub/Sub, rollout notifications are not used: %v", err)
+		return
+	}
+	logging.Infof("start pulling rollout notifications from %v", subscription)
+	for {
+		received, err := pullAndAckRolloutNotifications(subscription, mf)
+		if err != nil {
+			logging.Warningf("fail to pull rollout notifications from %v, retrying in %v: %v", subscription, rolloutPollInterval(), err)
+			time.Sleep(rolloutPollInterval())
+			continue
+		}
+		if received == 0 {
+			time.Sleep(pubsubEmptyPullBackoff)
+			continue
+		}
+		logging.Infof("received %v rollout notifications from %v", received, subscription)
+		// The channel is buffered, so multiple notifications arriving during a
+		// rollout check only trigger one more check.
+		select {
+		case notifications <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func pullAndAckRolloutNotifications(subscription string, mf *metadata.MetadataFetcher) (int, error) {
+	token, _, err := accessToken(mf)
+	if err != nil {
+		return 0, fmt.Errorf("fail to get access token: %v", err)
+	}
+
+	body, err := callPubsub(subscription+pubsubPullSuffix, token, map[string]interface{}{
+		"maxMessages":       pubsubMaxMessages,
+		"returnImmediately": false,
+	})
+	if err != nil {
+		return 0, err
+	}
+	pullResponse := &pubsubPullResponse{}
+	if err := json.Unmarshal(body, pullResponse); err != nil {
+		return 0, fmt.Errorf("fail to unmarshal pull response: %v", err)
+	}
+	if len(pullResponse.ReceivedMessages) == 0 {
+		return 0, nil
+	}
+
+	var ackIDs []string
+	for _, receivedMessage := range pullResponse.ReceivedMessages {
+		logging.Debugf("received rollout notification %v", receivedMessage.Message.MessageID)
+		ackIDs = append(ackIDs, receivedMessage.AckID)
+	}
+	// Messages which are not acknowledged are delivered again, which only
+	// triggers an extra rollout check, so failures are only logged.
+	if _, err := callPubsub(subscription+pubsubAcknowledgeSuffix, token, map[string]interface{}{
+		"ackIds": ackIDs,
+	}); err != nil {
+		logging.Warningf("fail to acknowledge rollout notifications: %v", err)
+	}
+	return len(ackIDs), nil
+}
+
+func callPubsub(method, token string, request interface{}) ([]byte, error) {
+	reqBody, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+	path := fmt.Sprintf("%s/v1/%s", *PubsubURL, method)
+	req, _ := http.NewRequest("POST", path, bytes.NewReader(reqBody))
+	req.Header.Add("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := pubsubClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("http call to %s returns not 200 OK: %v", path, resp.Status)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("fail to read response body: %s", err)
+	}
+	return body, nil
+}
@@ -0,0 +1,116 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package configmanager
+
+import (
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/metadata"
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/util"
+)
+
+func TestPullAndAckRolloutNotifications(t *testing.T) {
+	const subscription = "projects/project123/subscriptions/rollouts"
+	testCases := []struct {
+		desc         string
+		pullResponse string
+		pullStatus   int
+		wantReceived int
+		wantAckIDs   []string
+		wantErr      bool
+	}{
+		{
+			desc:         "Success, acknowledge received messages",
+			pullResponse: `{"receivedMessages":[{"ackId":"ack-1","message":{"messageId":"1"}},{"ackId":"ack-2","message":{"messageId":"2"}}]}`,
+			pullStatus:   http.StatusOK,
+			wantReceived: 2,
+			wantAckIDs:   []string{"ack-1", "ack-2"},
+		},
+		{
+			desc:         "Success, no message received",
+			pullResponse: `{}`,
+			pullStatus:   http.StatusOK,
+		},
+		{
+			desc:       "Failure, pull returns error",
+			pullStatus: http.StatusForbidden,
+			wantErr:    true,
+		},
+	}
+
+	var err error
+	if pubsubClient, err = newServiceConfigFetcherClient(time.Second); err != nil {
+		t.Fatalf("newServiceConfigFetcherClient failed: %v", err)
+	}
+	mockMetadataServer := util.InitMockServerFromPathResp(map[string]string{
+		util.AccessTokenSuffix: fakeToken,
+	})
+	defer mockMetadataServer.Close()
+	mf := metadata.NewMockMetadataFetcher(mockMetadataServer.URL, time.Now())
+	defer flag.Set("pubsub_url", "https://pubsub.googleapis.com")
+
+	for _, tc := range testCases {
+		var gotAckIDs []string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") != "Bearer ya29.new" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			switch r.URL.Path {
+			case "/v1/" + subscription + pubsubPullSuffix:
+				body, _ := ioutil.ReadAll(r.Body)
+				req := struct {
+					ReturnImmediately *bool `json:"returnImmediately"`
+				}{}
+				json.Unmarshal(body, &req)
+				if req.ReturnImmediately == nil || *req.ReturnImmediately {
+					t.Errorf("Test Desc(%s): pull request %s does not wait for messages", tc.desc, body)
+				}
+				w.WriteHeader(tc.pullStatus)
+				w.Write([]byte(tc.pullResponse))
+			case "/v1/" + subscription + pubsubAcknowledgeSuffix:
+				body, _ := ioutil.ReadAll(r.Body)
+				req := struct {
+					AckIDs []string `json:"ackIds"`
+				}{}
+				json.Unmarshal(body, &req)
+				gotAckIDs = append(gotAckIDs, req.AckIDs...)
+				w.Write([]byte(`{}`))
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+		flag.Set("pubsub_url", server.URL)
+
+		received, err := pullAndAckRolloutNotifications(subscription, mf)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("Test Desc(%s): got error %v, want error %v", tc.desc, err, tc.wantErr)
+		}
+		if received != tc.wantReceived {
+			t.Errorf("Test Desc(%s): got %v received messages, want %v", tc.desc, received, tc.wantReceived)
+		}
+		if !reflect.DeepEqual(gotAckIDs, tc.wantAckIDs) {
+			t.Errorf("Test Desc(%s): got ack ids %v, want %v", tc.desc, gotAckIDs, tc.wantAckIDs)
+		}
+		server.Close()
+	}
+}
@@ -0,0 +1,92 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package configmanager
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/logging"
+
+	confpb "google.golang.org/genproto/googleapis/api/serviceconfig"
+	smpb "google.golang.org/genproto/googleapis/api/servicemanagement/v1"
+)
+
+// rolloutPollInterval returns the interval of the polls for new rollouts,
+// --rollout_poll_interval if set, else --check_rollout_interval.
+func rolloutPollInterval() time.Duration {
+	if *RolloutPollInterval > 0 {
+		return *RolloutPollInterval
+	}
+	return *checkNewRolloutInterval
+}
+
+// timeoutFetcher fails the fetches of rollouts taking longer than timeout,
+// whatever the source of the rollouts. The fetches of service configs are not
+// limited, so that large configs can still be fetched.
+type timeoutFetcher struct {
+	fetcher ServiceConfigFetcher
+	timeout time.Duration
+}
+
+func (f *timeoutFetcher) FetchConfig(serviceName, configID string) (*confpb.Service, error) {
+	return f.fetcher.FetchConfig(serviceName, configID)
+}
+
+func (f *timeoutFetcher) FetchRollouts(serviceName string) (*smpb.ListServiceRolloutsResponse, error) {
+	type result struct {
+		rollouts *smpb.ListServiceRolloutsResponse
+		err      error
+	}
+	// The channel is buffered so that a fetch timing out does not block,
+	// it still ends with the timeout of its requests.
+	done := make(chan result, 1)
+	go func() {
+		rollouts, err := f.fetcher.FetchRollouts(serviceName)
+		done <- result{rollouts: rollouts, err: err}
+	}()
+	select {
+	case r := <-done:
+		return r.rollouts, r.err
+	case <-time.After(f.timeout):
+		return nil, fmt.Errorf("fail to fetch rollouts of service %v in %v", serviceName, f.timeout)
+	}
+}
+
+// recordRolloutPoll records the result of a poll for new rollouts. After
+// --rollout_failure_threshold consecutive failed polls, the config served to
+// Envoy may be stale, so a warning is logged and the
+// espv2_config_manager_rollout_poll_failing metric is set until a poll
+// succeeds.
+func (m *ConfigManager) recordRolloutPoll(err error) {
+	m.health.mu.Lock()
+	defer m.health.mu.Unlock()
+	if err == nil {
+		if m.health.rolloutPollFailures >= *RolloutFailureThreshold && *RolloutFailureThreshold > 0 {
+			logging.WithFields(m.logFields()).Infof("rollout poll succeeded after %v consecutive failures", m.health.rolloutPollFailures)
+		}
+		m.health.rolloutPollFailures = 0
+		rolloutPollConsecutiveFailures.Set(0)
+		rolloutPollFailing.Set(0)
+		return
+	}
+	rolloutPollFailures.Inc()
+	m.health.rolloutPollFailures++
+	rolloutPollConsecutiveFailures.Set(float64(m.health.rolloutPollFailures))
+	if *RolloutFailureThreshold > 0 && m.health.rolloutPollFailures >= *RolloutFailureThreshold {
+		rolloutPollFailing.Set(1)
+		logging.WithFields(m.logFields()).Warningf("%v consecutive rollout polls failed, the served service config may be stale: %v", m.health.rolloutPollFailures, err)
+	}
+}
@@ -0,0 +1,135 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package configmanager
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	smpb "google.golang.org/genproto/googleapis/api/servicemanagement/v1"
+)
+
+// slowRolloutsFetcher fetches the rollouts after a delay.
+type slowRolloutsFetcher struct {
+	fakeRolloutsFetcher
+	delay time.Duration
+}
+
+func (f *slowRolloutsFetcher) FetchRollouts(serviceName string) (*smpb.ListServiceRolloutsResponse, error) {
+	time.Sleep(f.delay)
+	return &smpb.ListServiceRolloutsResponse{}, nil
+}
+
+func TestTimeoutFetcher(t *testing.T) {
+	testData := []struct {
+		desc      string
+		delay     time.Duration
+		wantError string
+	}{
+		{
+			desc:  "Rollouts fetched before the timeout",
+			delay: 0,
+		},
+		{
+			desc:      "Rollouts fetch exceeding the timeout",
+			delay:     time.Second,
+			wantError: "fail to fetch rollouts of service bookstore.endpoints.project123.cloud.goog in 50ms",
+		},
+	}
+
+	for _, tc := range testData {
+		f := &timeoutFetcher{
+			fetcher: &slowRolloutsFetcher{delay: tc.delay},
+			timeout: 50 * time.Millisecond,
+		}
+		_, err := f.FetchRollouts(testProjectName)
+		if tc.wantError == "" && err != nil {
+			t.Errorf("Test Desc(%s): got error %v, want no error", tc.desc, err)
+		}
+		if tc.wantError != "" && (err == nil || !strings.Contains(err.Error(), tc.wantError)) {
+			t.Errorf("Test Desc(%s): got error %v, want error containing %q", tc.desc, err, tc.wantError)
+		}
+	}
+}
+
+func TestRolloutFailureThreshold(t *testing.T) {
+	testData := []struct {
+		desc           string
+		failReadiness  bool
+		failedPolls    int
+		recover        bool
+		wantFailing    float64
+		wantReadyError string
+	}{
+		{
+			desc:        "Polls failing less than the threshold",
+			failedPolls: 2,
+		},
+		{
+			desc:        "Polls failing up to the threshold, without readiness check",
+			failedPolls: 3,
+			wantFailing: 1,
+		},
+		{
+			desc:           "Polls failing up to the threshold fail the readiness",
+			failReadiness:  true,
+			failedPolls:    4,
+			wantFailing:    1,
+			wantReadyError: "4 consecutive rollout polls failed",
+		},
+		{
+			desc:          "Successful poll after the threshold",
+			failReadiness: true,
+			failedPolls:   3,
+			recover:       true,
+		},
+	}
+
+	defer func() {
+		*RolloutFailureThreshold = 0
+		*RolloutFailureFailReadiness = false
+	}()
+	for _, tc := range testData {
+		*RolloutFailureThreshold = 3
+		*RolloutFailureFailReadiness = tc.failReadiness
+
+		m := &ConfigManager{
+			serviceName: testProjectName,
+			curConfigID: testConfigID,
+			fetcher:     &fakeRolloutsFetcher{},
+		}
+		m.initHealth()
+		m.OnStreamResponse(1, nil, nil)
+		for i := 0; i < tc.failedPolls; i++ {
+			m.checkNewRollouts()
+		}
+		if tc.recover {
+			m.recordRolloutPoll(nil)
+		}
+
+		if got := scrapeMetrics()["espv2_config_manager_rollout_poll_failing"]; got != tc.wantFailing {
+			t.Errorf("Test Desc(%s): got rollout_poll_failing %v, want %v", tc.desc, got, tc.wantFailing)
+		}
+		err := m.checkReady()
+		if tc.wantReadyError == "" && err != nil {
+			t.Errorf("Test Desc(%s): got readiness error %v, want ready", tc.desc, err)
+		}
+		if tc.wantReadyError != "" && (err == nil || err.Error() != tc.wantReadyError) {
+			t.Errorf("Test Desc(%s): got readiness error %v, want %q", tc.desc, err, tc.wantReadyError)
+		}
+		m.recordRolloutPoll(nil)
+	}
+}
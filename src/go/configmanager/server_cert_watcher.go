@@ -0,0 +1,135 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package configmanager
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/logging"
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/util"
+	"github.com/envoyproxy/go-control-plane/pkg/cache"
+
+	authpb "github.com/envoyproxy/go-control-plane/envoy/api/v2/auth"
+	corepb "github.com/envoyproxy/go-control-plane/envoy/api/v2/core"
+)
+
+// WatchServerCert starts checking the certificate in --ssl_server_cert_path
+// for changes every --ssl_server_cert_check_interval, with
+// --ssl_server_cert_sds, and renewing it with --ssl_server_acme_directory_url.
+func (m *ConfigManager) WatchServerCert() {
+	if !m.envoyConfigOptions.SslServerCertSds {
+		return
+	}
+	if m.acme != nil {
+		go m.acme.renewLoop(m.checkServerCert)
+	}
+	if *sslServerCertCheckInterval == 0 {
+		return
+	}
+
+	logging.Infof("start checking the server certificate every %v", *sslServerCertCheckInterval)
+	go func() {
+		for range time.Tick(*sslServerCertCheckInterval) {
+			// only log error and keep serving the current certificate when the new one is invalid
+			if err := m.checkServerCert(); err != nil {
+				logging.Errorf("error occurred when checking the server certificate, %v", err)
+			}
+		}
+	}()
+}
+
+// checkServerCert sends the server certificate to Envoy over SDS if its files
+// have changed. Only the secrets of the snapshot are updated, so the listener
+// is not drained.
+func (m *ConfigManager) checkServerCert() error {
+	m.serverCertMu.Lock()
+	defer m.serverCertMu.Unlock()
+	changed, err := m.loadServerCert()
+	if err != nil || !changed {
+		return err
+	}
+
+	snapshot, err := m.cache.GetSnapshot(m.envoyConfigOptions.Node)
+	if err != nil {
+		// The certificate is sent with the first snapshot.
+		return nil
+	}
+	snapshot.Secrets = m.secretResources()
+	if err := m.cache.SetSnapshot(m.envoyConfigOptions.Node, snapshot); err != nil {
+		return err
+	}
+	logging.Infof("applied the changed server certificate")
+	return nil
+}
+
+// loadServerCert reads the certificate and key in --ssl_server_cert_path into
+// the SDS secret of the server certificate, if they have changed and match.
+// It returns true if the secret has changed.
+func (m *ConfigManager) loadServerCert() (bool, error) {
+	certPath, keyPath := util.ServerSslFiles(m.envoyConfigOptions.SslServerCertPath)
+	cert, err := ioutil.ReadFile(certPath)
+	if err != nil {
+		return false, fmt.Errorf("fail to read the server certificate: %v", err)
+	}
+	key, err := ioutil.ReadFile(keyPath)
+	if err != nil {
+		return false, fmt.Errorf("fail to read the server key: %v", err)
+	}
+
+	h := sha256.New()
+	h.Write(cert)
+	h.Write([]byte{0})
+	h.Write(key)
+	hash := h.Sum(nil)
+	if bytes.Equal(hash, m.serverCertHash) {
+		return false, nil
+	}
+	// The files may be replaced one by one, the secret is only updated once
+	// they match.
+	if _, err := tls.X509KeyPair(cert, key); err != nil {
+		return false, fmt.Errorf("invalid server certificate: %v", err)
+	}
+
+	m.serverCertSecret = &authpb.Secret{
+		Name: util.ServerCertSecretName,
+		Type: &authpb.Secret_TlsCertificate{
+			TlsCertificate: &authpb.TlsCertificate{
+				CertificateChain: &corepb.DataSource{
+					Specifier: &corepb.DataSource_InlineBytes{InlineBytes: cert},
+				},
+				PrivateKey: &corepb.DataSource{
+					Specifier: &corepb.DataSource_InlineBytes{InlineBytes: key},
+				},
+			},
+		},
+	}
+	m.serverCertHash = hash
+	return true, nil
+}
+
+// secretResources returns the SDS secrets of the snapshots, versioned by the
+// hash of the server certificate instead of the snapshot version.
+func (m *ConfigManager) secretResources() cache.Resources {
+	if m.serverCertSecret == nil {
+		return cache.Resources{}
+	}
+	return cache.NewResources(hex.EncodeToString(m.serverCertHash[:4]), []cache.Resource{m.serverCertSecret})
+}
@@ -0,0 +1,165 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package configmanager
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/options"
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/util"
+	"github.com/envoyproxy/go-control-plane/pkg/cache"
+
+	authpb "github.com/envoyproxy/go-control-plane/envoy/api/v2/auth"
+)
+
+// makeServerCert returns a self-signed certificate for the domain and its
+// key in PEM.
+func makeServerCert(t *testing.T, domain string, notAfter time.Time) ([]byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("fail to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: domain},
+		DNSNames:     []string{domain},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("fail to create certificate: %v", err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("fail to marshal key: %v", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+}
+
+func TestCheckServerCert(t *testing.T) {
+	dir, err := ioutil.TempDir("", "server_cert_watcher")
+	if err != nil {
+		t.Fatalf("fail to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	certPath, keyPath := util.ServerSslFiles(dir)
+	writeServerCert := func(cert, key []byte) {
+		if err := ioutil.WriteFile(certPath, cert, 0644); err != nil {
+			t.Fatalf("fail to write certificate: %v", err)
+		}
+		if err := ioutil.WriteFile(keyPath, key, 0600); err != nil {
+			t.Fatalf("fail to write key: %v", err)
+		}
+	}
+
+	firstCert, firstKey := makeServerCert(t, "example.com", time.Now().Add(time.Hour))
+	secondCert, secondKey := makeServerCert(t, "example.com", time.Now().Add(2*time.Hour))
+	writeServerCert(firstCert, firstKey)
+
+	servicePath := filepath.Join(dir, "service.json")
+	serviceConfig := fmt.Sprintf(`{"name":"%s","id":"%s","apis":[{"name":"endpoints.examples.bookstore.Bookstore"}]}`,
+		testProjectName, testConfigID)
+	if err := ioutil.WriteFile(servicePath, []byte(serviceConfig), 0644); err != nil {
+		t.Fatalf("fail to write service config file: %v", err)
+	}
+	flag.Set("service_json_path", servicePath)
+	defer flag.Set("service_json_path", "")
+
+	opts := options.DefaultConfigGeneratorOptions()
+	opts.BackendAddress = "http://127.0.0.1:8082"
+	opts.SslServerCertPath = dir
+	opts.SslServerCertSds = true
+	manager, err := NewConfigManager(nil, opts)
+	if err != nil {
+		t.Fatalf("fail to initialize Config Manager: %v", err)
+	}
+
+	testCases := []struct {
+		desc            string
+		cert            []byte
+		key             []byte
+		wantErr         bool
+		wantCert        []byte
+		wantNewSecret   bool
+		wantNewListener bool
+	}{
+		{
+			desc:     "Unchanged certificate keeps the secret",
+			cert:     firstCert,
+			key:      firstKey,
+			wantCert: firstCert,
+		},
+		{
+			desc:          "Changed certificate updates the secret only",
+			cert:          secondCert,
+			key:           secondKey,
+			wantCert:      secondCert,
+			wantNewSecret: true,
+		},
+		{
+			desc:     "Certificate not matching its key keeps the previous secret",
+			cert:     firstCert,
+			key:      secondKey,
+			wantErr:  true,
+			wantCert: secondCert,
+		},
+	}
+
+	for _, tc := range testCases {
+		prevSnapshot, err := manager.cache.GetSnapshot(opts.Node)
+		if err != nil {
+			t.Fatalf("fail to get snapshot: %v", err)
+		}
+		writeServerCert(tc.cert, tc.key)
+
+		err = manager.checkServerCert()
+		if (err != nil) != tc.wantErr {
+			t.Errorf("Test Desc(%s): got error %v, want error %v", tc.desc, err, tc.wantErr)
+		}
+
+		snapshot, err := manager.cache.GetSnapshot(opts.Node)
+		if err != nil {
+			t.Fatalf("fail to get snapshot: %v", err)
+		}
+		secret, ok := snapshot.GetResources(cache.SecretType)[util.ServerCertSecretName].(*authpb.Secret)
+		if !ok {
+			t.Fatalf("Test Desc(%s): snapshot has no secret %s", tc.desc, util.ServerCertSecretName)
+		}
+		if got := secret.GetTlsCertificate().GetCertificateChain().GetInlineBytes(); string(got) != string(tc.wantCert) {
+			t.Errorf("Test Desc(%s): got certificate %s, want %s", tc.desc, got, tc.wantCert)
+		}
+		if newSecret := snapshot.GetVersion(cache.SecretType) != prevSnapshot.GetVersion(cache.SecretType); newSecret != tc.wantNewSecret {
+			t.Errorf("Test Desc(%s): got new secret version %v, want %v", tc.desc, newSecret, tc.wantNewSecret)
+		}
+		if newListener := snapshot.GetVersion(cache.ListenerType) != prevSnapshot.GetVersion(cache.ListenerType); newListener != tc.wantNewListener {
+			t.Errorf("Test Desc(%s): got new listener version %v, want %v", tc.desc, newListener, tc.wantNewListener)
+		}
+	}
+}
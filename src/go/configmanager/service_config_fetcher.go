@@ -15,32 +15,58 @@
 package configmanager
 
 import (
-	"bytes"
+	"context"
 	"crypto/tls"
-	"crypto/x509"
 	"fmt"
 	"io/ioutil"
 	"math"
+	"math/rand"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/commonflags"
 	"github.com/GoogleCloudPlatform/esp-v2/src/go/configmanager/flags"
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/logging"
 	"github.com/GoogleCloudPlatform/esp-v2/src/go/metadata"
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/options"
 	"github.com/GoogleCloudPlatform/esp-v2/src/go/util"
-	"github.com/golang/glog"
 	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/keepalive"
 
 	confpb "google.golang.org/genproto/googleapis/api/serviceconfig"
 	smpb "google.golang.org/genproto/googleapis/api/servicemanagement/v1"
+	grpcmd "google.golang.org/grpc/metadata"
 )
 
 const (
 	fetchConfigSuffix   = "/v1/services/$serviceName/configs/$configId?view=FULL"
 	fetchRolloutsSuffix = "/v1/services/$serviceName/rollouts?filter=status=SUCCESS"
+
+	// Transports used to call Service Management.
+	restTransport = "rest"
+	grpcTransport = "grpc"
+
+	// Keep the gRPC channel warm between rollout polls, so that periodic polling
+	// does not need a new TLS handshake every time.
+	grpcKeepaliveTime    = 60 * time.Second
+	grpcKeepaliveTimeout = 10 * time.Second
 )
 
 var (
+	// HTTP status codes from Service Management which are retried, set by
+	// --service_management_fetch_retriable_status_codes.
+	retriableStatusCodes = map[int]bool{
+		http.StatusTooManyRequests:     true,
+		http.StatusInternalServerError: true,
+		http.StatusBadGateway:          true,
+		http.StatusServiceUnavailable:  true,
+		http.StatusGatewayTimeout:      true,
+	}
+
 	fetchConfigURL = func(serviceName, configID string) string {
 		path := *flags.ServiceManagementURL + fetchConfigSuffix
 		path = strings.Replace(path, "$serviceName", serviceName, 1)
@@ -52,29 +78,101 @@ var (
 		path = strings.Replace(path, "$serviceName", serviceName, 1)
 		return path
 	}
+
+	// TLS settings of the calls to Service Management in
+	// --outbound_tls_config, nil to only use --root_certs_path.
+	serviceManagementTls *options.OutboundTlsOptions
 )
 
+// newServiceManagementTLSConfig returns the TLS config of the calls to
+// Service Management.
+func newServiceManagementTLSConfig() (*tls.Config, error) {
+	o := serviceManagementTls
+	if o == nil {
+		return util.NewClientTLSConfig(*flags.RootCertsPath, "", "", "", "")
+	}
+	caPath := o.CaPath
+	if caPath == "" {
+		caPath = *flags.RootCertsPath
+	}
+	return util.NewClientTLSConfig(caPath, o.ClientCertPath, o.ClientKeyPath, o.MinVersion, o.MaxVersion)
+}
+
 func newServiceConfigFetcherClient(timeout time.Duration) (*http.Client, error) {
-	caCert, err := ioutil.ReadFile(*flags.RootCertsPath)
+	tlsConfig, err := newServiceManagementTLSConfig()
 	if err != nil {
 		return nil, err
 	}
-	caCertPool := x509.NewCertPool()
-	caCertPool.AppendCertsFromPEM(caCert)
 	return &http.Client{
 		Transport: &http.Transport{
-			TLSClientConfig: &tls.Config{
-				RootCAs: caCertPool,
-			},
+			Proxy:           util.Proxy,
+			TLSClientConfig: tlsConfig,
 		},
 		Timeout: timeout,
 	}, nil
 }
 
-func loadConfigFromRollouts(serviceName, curRolloutID, curConfigID string, mf *metadata.MetadataFetcher) (string, string, error) {
+// newServiceManagementConn creates a gRPC channel to the Service Management
+// address in --service_management_url. The channel is shared by all calls.
+func newServiceManagementConn() (*grpc.ClientConn, error) {
+	scheme, hostname, port, _, err := util.ParseURI(*flags.ServiceManagementURL)
+	if err != nil {
+		return nil, fmt.Errorf("fail to parse service management url: %v", err)
+	}
+
+	opts := []grpc.DialOption{
+		grpc.WithKeepaliveParams(keepalive.ClientParameters{
+			Time:                grpcKeepaliveTime,
+			Timeout:             grpcKeepaliveTimeout,
+			PermitWithoutStream: true,
+		}),
+		grpc.WithContextDialer(util.DialThroughProxy),
+	}
+	if scheme == "https" {
+		tlsConfig, err := newServiceManagementTLSConfig()
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)))
+	} else {
+		opts = append(opts, grpc.WithInsecure())
+	}
+	return grpc.Dial(fmt.Sprintf("%s:%d", hostname, port), opts...)
+}
+
+// serviceDeletedError is returned when the latest rollout of a service deletes
+// it in Service Management.
+type serviceDeletedError struct {
+	rolloutID string
+}
+
+func (e *serviceDeletedError) Error() string {
+	return fmt.Sprintf("service is deleted by rollout %v", e.rolloutID)
+}
+
+// maxPercentConfigID returns the config ID with the max traffic percentage of
+// a rollout, and its percentage.
+func maxPercentConfigID(rollout *smpb.Rollout) (string, float64) {
+	var configID string
+	maxPercent := 0.0
+	for k, v := range rollout.GetTrafficPercentStrategy().GetPercentages() {
+		if v > maxPercent {
+			configID = k
+			maxPercent = v
+		}
+	}
+	return configID, maxPercent
+}
+
+// loadConfigFromRollouts returns the latest rollout ID of a service and the
+// config ID with the max traffic percentage in it. A serviceDeletedError is
+// returned if the latest rollout deletes the service. The rollouts are listed
+// from the latest, so a config ID already served by an older rollout is
+// logged as a rollback.
+func loadConfigFromRollouts(serviceName, curRolloutID, curConfigID string, fetcher ServiceConfigFetcher) (string, string, error) {
 	var err error
 	var listServiceRolloutsResponse *smpb.ListServiceRolloutsResponse
-	listServiceRolloutsResponse, err = fetchRollouts(serviceName, mf)
+	listServiceRolloutsResponse, err = fetcher.FetchRollouts(serviceName)
 	if err != nil {
 		return "", "", fmt.Errorf("fail to get rollouts, %s", err)
 	}
@@ -86,42 +184,80 @@ func loadConfigFromRollouts(serviceName, curRolloutID, curConfigID string, mf *m
 	if newRolloutID == curRolloutID {
 		return curRolloutID, curConfigID, nil
 	}
-	glog.Infof("found new rollout id %v for service %v", newRolloutID, serviceName)
-	glog.Infof("new rollout: %v", listServiceRolloutsResponse.Rollouts[0])
+	logging.WithFields(logging.Fields{"service_name": serviceName, "rollout_id": newRolloutID}).Infof("found new rollout: %v", listServiceRolloutsResponse.Rollouts[0])
 
-	trafficPercentStrategy := listServiceRolloutsResponse.Rollouts[0].GetTrafficPercentStrategy()
-	trafficPercentMap := trafficPercentStrategy.GetPercentages()
-	if len(trafficPercentMap) == 0 {
+	if listServiceRolloutsResponse.Rollouts[0].GetDeleteServiceStrategy() != nil {
+		return "", "", &serviceDeletedError{rolloutID: newRolloutID}
+	}
+	if len(listServiceRolloutsResponse.Rollouts[0].GetTrafficPercentStrategy().GetPercentages()) == 0 {
 		return "", "", fmt.Errorf("no active rollouts")
 	}
-	var newConfigID string
-	currentMaxPercent := 0.0
 	// take config ID with max traffic percent as new config ID
-	for k, v := range trafficPercentMap {
-		if v > currentMaxPercent {
-			newConfigID = k
-			currentMaxPercent = v
-		}
-	}
+	newConfigID, currentMaxPercent := maxPercentConfigID(listServiceRolloutsResponse.Rollouts[0])
 	if newConfigID == curConfigID {
-		glog.Infof("no new configuration to load for service %v, current configuration id %v", serviceName, curConfigID)
+		logging.WithFields(logging.Fields{"service_name": serviceName, "rollout_id": newRolloutID, "config_id": curConfigID}).Infof("no new configuration to load")
 		return newRolloutID, curConfigID, nil
 	}
 	if !(math.Abs(100.0-currentMaxPercent) < 1e-9) {
-		glog.Warningf("though traffic percentage of configuration %v is %v%%, set it to 100%%", newConfigID, currentMaxPercent)
+		logging.WithFields(logging.Fields{"service_name": serviceName, "rollout_id": newRolloutID, "config_id": newConfigID}).Warningf("though traffic percentage of the configuration is %v%%, set it to 100%%", currentMaxPercent)
+	}
+	for _, rollout := range listServiceRolloutsResponse.Rollouts[1:] {
+		if configID, _ := maxPercentConfigID(rollout); configID == newConfigID {
+			rolloutRollbacks.Inc()
+			logging.WithFields(logging.Fields{"service_name": serviceName, "rollout_id": newRolloutID, "config_id": newConfigID}).Warningf("rolling back to the configuration of rollout %v", rollout.GetRolloutId())
+			return newRolloutID, newConfigID, nil
+		}
 	}
-	glog.Infof("found new configuration id %v for service %v", curConfigID, serviceName)
+	logging.WithFields(logging.Fields{"service_name": serviceName, "rollout_id": newRolloutID, "config_id": newConfigID}).Infof("found new configuration")
 	return newRolloutID, newConfigID, nil
 }
 
+// loadTrafficPercentagesFromRollouts returns the latest rollout id with the
+// traffic percentages of all its configs. The percentages are nil if the
+// rollout id is unchanged.
+func loadTrafficPercentagesFromRollouts(serviceName, curRolloutID string, fetcher ServiceConfigFetcher) (string, map[string]float64, error) {
+	listServiceRolloutsResponse, err := fetcher.FetchRollouts(serviceName)
+	if err != nil {
+		return "", nil, fmt.Errorf("fail to get rollouts, %s", err)
+	}
+	if len(listServiceRolloutsResponse.Rollouts) == 0 {
+		return "", nil, fmt.Errorf("no active rollouts")
+	}
+	newRolloutID := listServiceRolloutsResponse.Rollouts[0].RolloutId
+	if newRolloutID == curRolloutID {
+		return curRolloutID, nil, nil
+	}
+	logging.WithFields(logging.Fields{"service_name": serviceName, "rollout_id": newRolloutID}).Infof("found new rollout: %v", listServiceRolloutsResponse.Rollouts[0])
+
+	if listServiceRolloutsResponse.Rollouts[0].GetDeleteServiceStrategy() != nil {
+		return "", nil, &serviceDeletedError{rolloutID: newRolloutID}
+	}
+	trafficPercentMap := listServiceRolloutsResponse.Rollouts[0].GetTrafficPercentStrategy().GetPercentages()
+	if len(trafficPercentMap) == 0 {
+		return "", nil, fmt.Errorf("no active rollouts")
+	}
+	return newRolloutID, trafficPercentMap, nil
+}
+
+// accessToken returns the access token to call Google APIs. Tokens are cached
+// by the metadata fetcher and by util.GenerateAccessTokenFromFile, which
+// refresh them 5 minutes before they expire.
 func accessToken(mf *metadata.MetadataFetcher) (string, time.Duration, error) {
 	if mf == nil && *flags.ServiceAccountKey == "" {
 		return "", 0, fmt.Errorf("If --non_gcp is specified, --service_account_key has to be specified.")
 	}
+	var token string
+	var expiresIn time.Duration
+	var err error
 	if *flags.ServiceAccountKey != "" {
-		return util.GenerateAccessTokenFromFile(*flags.ServiceAccountKey)
+		token, expiresIn, err = util.GenerateAccessTokenFromFile(*flags.ServiceAccountKey)
+	} else {
+		token, expiresIn, err = mf.FetchAccessToken()
 	}
-	return mf.FetchAccessToken()
+	if err != nil {
+		tokenRefreshFailures.Inc()
+	}
+	return token, expiresIn, err
 }
 
 // TODO(jcwang) cleanup here. This function is redundant.
@@ -131,6 +267,13 @@ func fetchRollouts(serviceName string, mf *metadata.MetadataFetcher) (*smpb.List
 		return nil, fmt.Errorf("fail to get access token: %v", err)
 	}
 
+	if serviceManagementConn != nil {
+		rolloutsResponse, err := callServiceManagementRolloutsGrpc(serviceName, token)
+		if err == nil {
+			return rolloutsResponse, nil
+		}
+		logging.Warningf("fail to get rollouts through gRPC, falling back to REST: %v", err)
+	}
 	return callServiceManagementRollouts(fetchRolloutsURL(serviceName), token)
 }
 
@@ -139,15 +282,15 @@ func fetchConfig(serviceName, configId string, mf *metadata.MetadataFetcher) (*c
 	if err != nil {
 		return nil, fmt.Errorf("fail to get access token: %v", err)
 	}
-	return callServiceManagement(fetchConfigURL(serviceName, configId), token)
-}
 
-func readConfig(configPath string) (*confpb.Service, error) {
-	config, err := ioutil.ReadFile(configPath)
-	if err != nil {
-		return nil, err
+	if serviceManagementConn != nil {
+		serviceConfig, err := callServiceManagementGrpc(serviceName, configId, token)
+		if err == nil {
+			return serviceConfig, nil
+		}
+		logging.Warningf("fail to get service config through gRPC, falling back to REST: %v", err)
 	}
-	return util.UnmarshalServiceConfig(bytes.NewReader(config))
+	return callServiceManagement(fetchConfigURL(serviceName, configId), token)
 }
 
 var callServiceManagementRollouts = func(path, token string) (*smpb.ListServiceRolloutsResponse, error) {
@@ -188,17 +331,89 @@ var callServiceManagement = func(path, token string) (*confpb.Service, error) {
 	return service, nil
 }
 
+var callServiceManagementRolloutsGrpc = func(serviceName, token string) (*smpb.ListServiceRolloutsResponse, error) {
+	ctx, cancel := grpcContextWithAccessToken(token)
+	defer cancel()
+	return smpb.NewServiceManagerClient(serviceManagementConn).ListServiceRollouts(ctx, &smpb.ListServiceRolloutsRequest{
+		ServiceName: serviceName,
+		Filter:      "status=SUCCESS",
+	})
+}
+
+var callServiceManagementGrpc = func(serviceName, configId, token string) (*confpb.Service, error) {
+	ctx, cancel := grpcContextWithAccessToken(token)
+	defer cancel()
+	return smpb.NewServiceManagerClient(serviceManagementConn).GetServiceConfig(ctx, &smpb.GetServiceConfigRequest{
+		ServiceName: serviceName,
+		ConfigId:    configId,
+		View:        smpb.GetServiceConfigRequest_FULL,
+	})
+}
+
+func grpcContextWithAccessToken(token string) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(*commonflags.HttpRequestTimeoutS)*time.Second)
+	return grpcmd.AppendToOutgoingContext(ctx, "authorization", "Bearer "+token), cancel
+}
+
 var callWithAccessToken = func(path, token string) (*http.Response, error) {
-	req, _ := http.NewRequest("GET", path, nil)
-	req.Header.Add("Authorization", "Bearer "+token)
-	req.Header.Set("Content-Type", "application/x-protobuf")
-	resp, err := serviceConfigFetcherClient.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	if resp.StatusCode != http.StatusOK {
+	return callWithHeaders(path, map[string]string{
+		"Authorization": "Bearer " + token,
+		"Content-Type":  "application/x-protobuf",
+	})
+}
+
+// callWithHeaders sends a GET request to path, retrying on the status codes in
+// --service_management_fetch_retriable_status_codes.
+func callWithHeaders(path string, headers map[string]string) (*http.Response, error) {
+	backoff := *ServiceManagementFetchBackoff
+	for attempt := 0; ; attempt++ {
+		req, _ := http.NewRequest("GET", path, nil)
+		for key, value := range headers {
+			req.Header.Set(key, value)
+		}
+		resp, err := serviceConfigFetcherClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode == http.StatusOK {
+			return resp, nil
+		}
 		resp.Body.Close()
-		return nil, fmt.Errorf("http call to %s returns not 200 OK: %v", path, resp.Status)
+		if attempt >= *ServiceManagementFetchRetries || !retriableStatusCodes[resp.StatusCode] {
+			return nil, fmt.Errorf("http call to %s returns not 200 OK: %v", path, resp.Status)
+		}
+
+		delay := jitter(backoff)
+		logging.Warningf("http call to %s returns %v, retrying in %v (retry %d of %d)", path, resp.Status, delay, attempt+1, *ServiceManagementFetchRetries)
+		time.Sleep(delay)
+		if backoff *= 2; backoff > *ServiceManagementFetchMaxBackoff {
+			backoff = *ServiceManagementFetchMaxBackoff
+		}
+	}
+}
+
+// jitter returns a random duration in [d/2, d), so that multiple proxies
+// retrying at the same time do not hit Service Management in lockstep.
+func jitter(d time.Duration) time.Duration {
+	if d <= 1 {
+		return d
+	}
+	half := d / 2
+	return half + time.Duration(rand.Int63n(int64(d-half)))
+}
+
+func parseRetriableStatusCodes(codes string) (map[int]bool, error) {
+	ret := make(map[int]bool)
+	for _, c := range strings.Split(codes, ",") {
+		c = strings.TrimSpace(c)
+		if c == "" {
+			continue
+		}
+		code, err := strconv.Atoi(c)
+		if err != nil || code < 100 || code > 599 {
+			return nil, fmt.Errorf("invalid HTTP status code %q", c)
+		}
+		ret[code] = true
 	}
-	return resp, nil
+	return ret, nil
 }
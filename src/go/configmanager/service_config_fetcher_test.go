@@ -15,11 +15,26 @@
 package configmanager
 
 import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/metadata"
 	"github.com/GoogleCloudPlatform/esp-v2/src/go/util"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	confpb "google.golang.org/genproto/googleapis/api/serviceconfig"
+	smpb "google.golang.org/genproto/googleapis/api/servicemanagement/v1"
+	grpcmd "google.golang.org/grpc/metadata"
 )
 
 func TestServiceConfigFetcherTimeout(t *testing.T) {
@@ -39,3 +54,259 @@ func TestServiceConfigFetcherTimeout(t *testing.T) {
 		t.Errorf("TestServiceConfigFetcherTimeout: the service config fetcher get the config but should get timeout error")
 	}
 }
+
+func TestCallWithAccessTokenRetries(t *testing.T) {
+	testCases := []struct {
+		desc          string
+		retries       int
+		statusCodes   []int
+		wantCalls     int
+		wantErrSubstr string
+	}{
+		{
+			desc:        "Success after retrying on 503 and 429",
+			retries:     3,
+			statusCodes: []int{http.StatusServiceUnavailable, http.StatusTooManyRequests, http.StatusOK},
+			wantCalls:   3,
+		},
+		{
+			desc:          "Failure after exhausting all retries",
+			retries:       2,
+			statusCodes:   []int{http.StatusInternalServerError, http.StatusInternalServerError, http.StatusInternalServerError, http.StatusOK},
+			wantCalls:     3,
+			wantErrSubstr: "500 Internal Server Error",
+		},
+		{
+			desc:          "No retry on non-retriable status code",
+			retries:       3,
+			statusCodes:   []int{http.StatusForbidden, http.StatusOK},
+			wantCalls:     1,
+			wantErrSubstr: "403 Forbidden",
+		},
+		{
+			desc:          "No retry when retries are disabled",
+			retries:       0,
+			statusCodes:   []int{http.StatusServiceUnavailable, http.StatusOK},
+			wantCalls:     1,
+			wantErrSubstr: "503 Service Unavailable",
+		},
+	}
+
+	var err error
+	if serviceConfigFetcherClient, err = newServiceConfigFetcherClient(time.Second); err != nil {
+		t.Fatalf("newServiceConfigFetcherClient failed: %v", err)
+	}
+	flag.Set("service_management_fetch_backoff", "1ms")
+	defer flag.Set("service_management_fetch_backoff", "1s")
+	defer flag.Set("service_management_fetch_retries", "3")
+
+	for _, tc := range testCases {
+		calls := 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(tc.statusCodes[calls])
+			calls += 1
+		}))
+		flag.Set("service_management_fetch_retries", fmt.Sprintf("%d", tc.retries))
+
+		resp, err := callWithAccessToken(server.URL, "this-is-token")
+		if tc.wantErrSubstr == "" {
+			if err != nil {
+				t.Errorf("Test Desc(%s): callWithAccessToken got error: %v", tc.desc, err)
+			} else {
+				resp.Body.Close()
+			}
+		} else if err == nil || !strings.Contains(err.Error(), tc.wantErrSubstr) {
+			t.Errorf("Test Desc(%s): callWithAccessToken got error %v, want error containing %q", tc.desc, err, tc.wantErrSubstr)
+		}
+		if calls != tc.wantCalls {
+			t.Errorf("Test Desc(%s): got %v calls, want %v", tc.desc, calls, tc.wantCalls)
+		}
+		server.Close()
+	}
+}
+
+func TestParseRetriableStatusCodes(t *testing.T) {
+	testCases := []struct {
+		desc      string
+		codes     string
+		wantCodes map[int]bool
+		wantErr   bool
+	}{
+		{
+			desc:      "Success with spaces",
+			codes:     "429, 503",
+			wantCodes: map[int]bool{429: true, 503: true},
+		},
+		{
+			desc:      "Empty disables all retries",
+			codes:     "",
+			wantCodes: map[int]bool{},
+		},
+		{
+			desc:    "Failure with invalid status code",
+			codes:   "429,abc",
+			wantErr: true,
+		},
+		{
+			desc:    "Failure with out of range status code",
+			codes:   "42",
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		got, err := parseRetriableStatusCodes(tc.codes)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("Test Desc(%s): got error %v, want error %v", tc.desc, err, tc.wantErr)
+			continue
+		}
+		if !tc.wantErr && !reflect.DeepEqual(got, tc.wantCodes) {
+			t.Errorf("Test Desc(%s): got codes %v, want %v", tc.desc, got, tc.wantCodes)
+		}
+	}
+}
+
+type fakeServiceManager struct {
+	smpb.UnimplementedServiceManagerServer
+	err error
+}
+
+func (f *fakeServiceManager) GetServiceConfig(ctx context.Context, req *smpb.GetServiceConfigRequest) (*confpb.Service, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	md, _ := grpcmd.FromIncomingContext(ctx)
+	if auth := md.Get("authorization"); len(auth) != 1 || auth[0] != "Bearer ya29.new" {
+		return nil, status.Errorf(codes.Unauthenticated, "unexpected authorization: %v", auth)
+	}
+	return &confpb.Service{
+		Name: req.GetServiceName(),
+		Id:   req.GetConfigId(),
+	}, nil
+}
+
+func TestFetchConfigWithGrpcTransport(t *testing.T) {
+	testCases := []struct {
+		desc         string
+		grpcErr      error
+		wantConfigId string
+	}{
+		{
+			desc:         "Success fetching service config through gRPC",
+			wantConfigId: testConfigID,
+		},
+		{
+			desc:         "Fall back to REST when the gRPC call fails",
+			grpcErr:      status.Error(codes.Unavailable, "unavailable"),
+			wantConfigId: "rest-config-id",
+		},
+	}
+
+	for _, tc := range testCases {
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("fail to listen: %v", err)
+		}
+		grpcServer := grpc.NewServer()
+		smpb.RegisterServiceManagerServer(grpcServer, &fakeServiceManager{err: tc.grpcErr})
+		go grpcServer.Serve(lis)
+
+		restConfig, err := genFakeConfig(fmt.Sprintf(`{"name":"%s","id":"rest-config-id"}`, testProjectName))
+		if err != nil {
+			t.Fatalf("fail to generate fake config: %v", err)
+		}
+		restServer := util.InitMockServer(string(restConfig))
+		fetchConfigURL = func(serviceName, configID string) string {
+			return restServer.GetURL()
+		}
+		mockMetadataServer := util.InitMockServerFromPathResp(map[string]string{
+			util.AccessTokenSuffix: fakeToken,
+		})
+
+		flag.Set("service_management_url", "http://"+lis.Addr().String())
+		if serviceConfigFetcherClient, err = newServiceConfigFetcherClient(time.Second); err != nil {
+			t.Fatalf("newServiceConfigFetcherClient failed: %v", err)
+		}
+		if serviceManagementConn, err = newServiceManagementConn(); err != nil {
+			t.Fatalf("newServiceManagementConn failed: %v", err)
+		}
+
+		serviceConfig, err := fetchConfig(testProjectName, testConfigID, metadata.NewMockMetadataFetcher(mockMetadataServer.URL, time.Now()))
+		if err != nil {
+			t.Errorf("Test Desc(%s): fetchConfig got error: %v", tc.desc, err)
+		} else if serviceConfig.GetId() != tc.wantConfigId {
+			t.Errorf("Test Desc(%s): fetchConfig got config id %v, want %v", tc.desc, serviceConfig.GetId(), tc.wantConfigId)
+		}
+
+		serviceManagementConn.Close()
+		serviceManagementConn = nil
+		flag.Set("service_management_url", "https://servicemanagement.googleapis.com")
+		mockMetadataServer.Close()
+		restServer.Close()
+		grpcServer.Stop()
+	}
+}
+
+func TestLoadConfigFromRollouts(t *testing.T) {
+	testCases := []struct {
+		desc          string
+		rollouts      []*smpb.Rollout
+		wantRolloutID string
+		wantConfigID  string
+		wantRollback  bool
+		wantError     string
+	}{
+		{
+			desc: "New rollout with a new config",
+			rollouts: []*smpb.Rollout{
+				makeTrafficPercentRollout("2017-05-02r0", "2017-05-02r1"),
+				makeTrafficPercentRollout("2017-05-01r0", testConfigID),
+			},
+			wantRolloutID: "2017-05-02r0",
+			wantConfigID:  "2017-05-02r1",
+		},
+		{
+			desc: "Rollback to the config of an older rollout",
+			rollouts: []*smpb.Rollout{
+				makeTrafficPercentRollout("2017-05-03r0", "2017-04-30r0"),
+				makeTrafficPercentRollout("2017-05-01r0", testConfigID),
+				makeTrafficPercentRollout("2017-04-30r0", "2017-04-30r0"),
+			},
+			wantRolloutID: "2017-05-03r0",
+			wantConfigID:  "2017-04-30r0",
+			wantRollback:  true,
+		},
+		{
+			desc: "Service deleted by the latest rollout",
+			rollouts: []*smpb.Rollout{
+				makeDeleteServiceRollout("2017-05-02r0"),
+				makeTrafficPercentRollout("2017-05-01r0", testConfigID),
+			},
+			wantError: "service is deleted by rollout 2017-05-02r0",
+		},
+	}
+
+	for _, tc := range testCases {
+		fetcher := &fakeServiceFetcher{
+			rollouts: &smpb.ListServiceRolloutsResponse{Rollouts: tc.rollouts},
+		}
+		before := scrapeMetrics()["espv2_config_manager_rollout_rollbacks_total"]
+		rolloutID, configID, err := loadConfigFromRollouts(testProjectName, "2017-05-01r0", testConfigID, fetcher)
+		if tc.wantError != "" {
+			if _, ok := err.(*serviceDeletedError); !ok || err.Error() != tc.wantError {
+				t.Errorf("Test Desc(%s): got error %v, want serviceDeletedError %q", tc.desc, err, tc.wantError)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Test Desc(%s): got error %v", tc.desc, err)
+			continue
+		}
+		if rolloutID != tc.wantRolloutID || configID != tc.wantConfigID {
+			t.Errorf("Test Desc(%s): got rollout %v with config id %v, want %v with %v", tc.desc, rolloutID, configID, tc.wantRolloutID, tc.wantConfigID)
+		}
+		if rollback := scrapeMetrics()["espv2_config_manager_rollout_rollbacks_total"] > before; rollback != tc.wantRollback {
+			t.Errorf("Test Desc(%s): got rollback %v, want %v", tc.desc, rollback, tc.wantRollback)
+		}
+	}
+}
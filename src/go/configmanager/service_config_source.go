@@ -0,0 +1,320 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package configmanager
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/commonflags"
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/metadata"
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/util"
+	"github.com/golang/protobuf/jsonpb"
+
+	confpb "google.golang.org/genproto/googleapis/api/serviceconfig"
+	smpb "google.golang.org/genproto/googleapis/api/servicemanagement/v1"
+)
+
+// Sources of service configs, set by --service_config_source.
+const (
+	serviceManagementSource = "servicemanagement"
+	gcsSource               = "gcs"
+	configMapSource         = "configmap"
+	httpsSource             = "https"
+
+	// Name of the GCS object or ConfigMap key holding the rollouts.
+	rolloutsObjectName = "rollouts.json"
+)
+
+var (
+	// Directory of the Kubernetes service account token and CA certificate,
+	// mounted in every pod.
+	kubernetesServiceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+	kubernetesAPIURL            = func() string {
+		return fmt.Sprintf("https://%s:%s", os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT"))
+	}
+)
+
+// ServiceConfigFetcher fetches the service configs and rollouts of services.
+//
+// Alternative sources store the service config in JSON, like the file in
+// --service_json_path, and the rollouts in the JSON form of
+// ListServiceRolloutsResponse, so that managed rollout works the same way.
+type ServiceConfigFetcher interface {
+	FetchConfig(serviceName, configID string) (*confpb.Service, error)
+	FetchRollouts(serviceName string) (*smpb.ListServiceRolloutsResponse, error)
+}
+
+// newServiceConfigFetcher creates the fetcher of --service_config_source.
+func newServiceConfigFetcher(source string, mf *metadata.MetadataFetcher) (ServiceConfigFetcher, error) {
+	switch source {
+	case serviceManagementSource:
+		return &serviceManagementFetcher{mf: mf}, nil
+	case gcsSource:
+		u, err := url.Parse(*ServiceConfigGCSPath)
+		if err != nil || u.Scheme != "gs" || u.Host == "" {
+			return nil, fmt.Errorf(`--service_config_gcs_path must be in the form of "gs://bucket/path", got %q`, *ServiceConfigGCSPath)
+		}
+		return &gcsFetcher{
+			bucket: u.Host,
+			prefix: strings.Trim(u.Path, "/"),
+			mf:     mf,
+		}, nil
+	case configMapSource:
+		parts := strings.Split(*ServiceConfigConfigMap, "/")
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf(`--service_config_configmap must be in the form of "namespace/name", got %q`, *ServiceConfigConfigMap)
+		}
+		client, err := newKubernetesClient(time.Duration(*commonflags.HttpRequestTimeoutS) * time.Second)
+		if err != nil {
+			return nil, fmt.Errorf("fail to create Kubernetes client: %v", err)
+		}
+		return &configMapFetcher{
+			namespace: parts[0],
+			name:      parts[1],
+			client:    client,
+		}, nil
+	case httpsSource:
+		if *ServiceConfigURL == "" {
+			return nil, fmt.Errorf("--service_config_url must be set when --service_config_source is %q", httpsSource)
+		}
+		headers, err := util.ParseHeaders(*ServiceConfigHeaders)
+		if err != nil {
+			return nil, fmt.Errorf("fail to parse --service_config_headers: %v", err)
+		}
+		return &httpsFetcher{
+			configURL:   *ServiceConfigURL,
+			rolloutsURL: *ServiceConfigRolloutsURL,
+			headers:     headers,
+		}, nil
+	default:
+		return nil, fmt.Errorf(`failed to set service config source. It must be one of "%s", "%s", "%s" or "%s"`, serviceManagementSource, gcsSource, configMapSource, httpsSource)
+	}
+}
+
+// fileFetcher reads the service config in --service_json_path. The file has
+// one service config, returned for any service name and config id.
+type fileFetcher struct {
+	path string
+}
+
+func (f *fileFetcher) FetchConfig(serviceName, configID string) (*confpb.Service, error) {
+	config, err := ioutil.ReadFile(f.path)
+	if err != nil {
+		return nil, fmt.Errorf("fail to read service config file: %s, error: %s", f.path, err)
+	}
+	serviceConfig, err := util.UnmarshalServiceConfig(bytes.NewReader(config))
+	if err != nil {
+		return nil, fmt.Errorf("fail to unmarshal service config file: %s, error: %s", f.path, err)
+	}
+	return serviceConfig, nil
+}
+
+// FetchRollouts fails, as the file is watched for changes instead.
+func (f *fileFetcher) FetchRollouts(serviceName string) (*smpb.ListServiceRolloutsResponse, error) {
+	return nil, fmt.Errorf("service config file %s has no rollouts", f.path)
+}
+
+// serviceManagementFetcher fetches from Service Management, the default source.
+type serviceManagementFetcher struct {
+	mf *metadata.MetadataFetcher
+}
+
+func (f *serviceManagementFetcher) FetchConfig(serviceName, configID string) (*confpb.Service, error) {
+	return fetchConfig(serviceName, configID, f.mf)
+}
+
+func (f *serviceManagementFetcher) FetchRollouts(serviceName string) (*smpb.ListServiceRolloutsResponse, error) {
+	return fetchRollouts(serviceName, f.mf)
+}
+
+// gcsFetcher reads the objects {prefix}/{serviceName}/{configID}.json and
+// {prefix}/{serviceName}/rollouts.json from a GCS bucket.
+type gcsFetcher struct {
+	bucket string
+	prefix string
+	mf     *metadata.MetadataFetcher
+}
+
+func (f *gcsFetcher) FetchConfig(serviceName, configID string) (*confpb.Service, error) {
+	body, err := f.readObject(serviceName, configID+".json")
+	if err != nil {
+		return nil, err
+	}
+	return util.UnmarshalServiceConfig(bytes.NewReader(body))
+}
+
+func (f *gcsFetcher) FetchRollouts(serviceName string) (*smpb.ListServiceRolloutsResponse, error) {
+	body, err := f.readObject(serviceName, rolloutsObjectName)
+	if err != nil {
+		return nil, err
+	}
+	return unmarshalRollouts(body)
+}
+
+func (f *gcsFetcher) readObject(serviceName, name string) ([]byte, error) {
+	token, _, err := accessToken(f.mf)
+	if err != nil {
+		return nil, fmt.Errorf("fail to get access token: %v", err)
+	}
+	object := strings.TrimPrefix(f.prefix+"/"+serviceName+"/"+name, "/")
+	path := fmt.Sprintf("%s/storage/v1/b/%s/o/%s?alt=media", *GCSURL, f.bucket, url.PathEscape(object))
+	resp, err := callWithHeaders(path, map[string]string{
+		"Authorization": "Bearer " + token,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("fail to read GCS object gs://%s/%s: %v", f.bucket, object, err)
+	}
+	return body, nil
+}
+
+// configMapFetcher reads the keys {serviceName}.{configID}.json and
+// {serviceName}.rollouts.json of a Kubernetes ConfigMap. ConfigMap keys cannot
+// have "/", so the service name is a prefix of the key instead of a directory.
+type configMapFetcher struct {
+	namespace string
+	name      string
+	client    *http.Client
+}
+
+func (f *configMapFetcher) FetchConfig(serviceName, configID string) (*confpb.Service, error) {
+	data, err := f.readKey(serviceName + "." + configID + ".json")
+	if err != nil {
+		return nil, err
+	}
+	return util.UnmarshalServiceConfig(strings.NewReader(data))
+}
+
+func (f *configMapFetcher) FetchRollouts(serviceName string) (*smpb.ListServiceRolloutsResponse, error) {
+	data, err := f.readKey(serviceName + "." + rolloutsObjectName)
+	if err != nil {
+		return nil, err
+	}
+	return unmarshalRollouts([]byte(data))
+}
+
+func (f *configMapFetcher) readKey(key string) (string, error) {
+	// The token is read on every call, as it is rotated by the kubelet.
+	token, err := ioutil.ReadFile(filepath.Join(kubernetesServiceAccountDir, "token"))
+	if err != nil {
+		return "", fmt.Errorf("fail to read Kubernetes service account token: %v", err)
+	}
+	path := fmt.Sprintf("%s/api/v1/namespaces/%s/configmaps/%s", kubernetesAPIURL(), f.namespace, f.name)
+	req, _ := http.NewRequest("GET", path, nil)
+	req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("fail to get ConfigMap %s/%s: %v", f.namespace, f.name, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("http call to %s returns not 200 OK: %v", path, resp.Status)
+	}
+
+	configMap := struct {
+		Data map[string]string `json:"data"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&configMap); err != nil {
+		return "", fmt.Errorf("fail to unmarshal ConfigMap %s/%s: %v", f.namespace, f.name, err)
+	}
+	data, ok := configMap.Data[key]
+	if !ok {
+		return "", fmt.Errorf("ConfigMap %s/%s has no key %s", f.namespace, f.name, key)
+	}
+	return data, nil
+}
+
+func newKubernetesClient(timeout time.Duration) (*http.Client, error) {
+	caCert, err := ioutil.ReadFile(filepath.Join(kubernetesServiceAccountDir, "ca.crt"))
+	if err != nil {
+		return nil, err
+	}
+	caCertPool := x509.NewCertPool()
+	caCertPool.AppendCertsFromPEM(caCert)
+	return &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{
+				RootCAs: caCertPool,
+			},
+		},
+		Timeout: timeout,
+	}, nil
+}
+
+// httpsFetcher fetches from URLs with $serviceName and $configId placeholders,
+// sending the headers in --service_config_headers.
+type httpsFetcher struct {
+	configURL   string
+	rolloutsURL string
+	headers     map[string]string
+}
+
+func (f *httpsFetcher) FetchConfig(serviceName, configID string) (*confpb.Service, error) {
+	path := strings.Replace(f.configURL, "$serviceName", serviceName, -1)
+	path = strings.Replace(path, "$configId", configID, -1)
+	body, err := f.get(path)
+	if err != nil {
+		return nil, err
+	}
+	return util.UnmarshalServiceConfig(bytes.NewReader(body))
+}
+
+func (f *httpsFetcher) FetchRollouts(serviceName string) (*smpb.ListServiceRolloutsResponse, error) {
+	if f.rolloutsURL == "" {
+		return nil, fmt.Errorf("--service_config_rollouts_url must be set for managed rollout")
+	}
+	body, err := f.get(strings.Replace(f.rolloutsURL, "$serviceName", serviceName, -1))
+	if err != nil {
+		return nil, err
+	}
+	return unmarshalRollouts(body)
+}
+
+func (f *httpsFetcher) get(path string) ([]byte, error) {
+	resp, err := callWithHeaders(path, f.headers)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("fail to read response body: %s", err)
+	}
+	return body, nil
+}
+
+func unmarshalRollouts(body []byte) (*smpb.ListServiceRolloutsResponse, error) {
+	rolloutsResponse := new(smpb.ListServiceRolloutsResponse)
+	unmarshaler := &jsonpb.Unmarshaler{AllowUnknownFields: true}
+	if err := unmarshaler.Unmarshal(bytes.NewReader(body), rolloutsResponse); err != nil {
+		return nil, fmt.Errorf("fail to unmarshal ListServiceRolloutsResponse: %s", err)
+	}
+	return rolloutsResponse, nil
+}
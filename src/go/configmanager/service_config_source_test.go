@@ -0,0 +1,216 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package configmanager
+
+import (
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/metadata"
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/util"
+)
+
+const (
+	fakeSourceConfig   = `{"name":"` + testProjectName + `","id":"` + testConfigID + `"}`
+	fakeSourceRollouts = `{"rollouts":[{"rolloutId":"rollout-1","trafficPercentStrategy":{"percentages":{"` + testConfigID + `":100}}}]}`
+)
+
+func TestServiceConfigFetchers(t *testing.T) {
+	var err error
+	if serviceConfigFetcherClient, err = newServiceConfigFetcherClient(time.Second); err != nil {
+		t.Fatalf("newServiceConfigFetcherClient failed: %v", err)
+	}
+	mockMetadataServer := util.InitMockServerFromPathResp(map[string]string{
+		util.AccessTokenSuffix: fakeToken,
+	})
+	defer mockMetadataServer.Close()
+	mf := metadata.NewMockMetadataFetcher(mockMetadataServer.URL, time.Now())
+
+	dir, err := ioutil.TempDir("", "service_config_source")
+	if err != nil {
+		t.Fatalf("fail to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "token"), []byte("k8s-token\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	defer func(d string) { kubernetesServiceAccountDir = d }(kubernetesServiceAccountDir)
+	kubernetesServiceAccountDir = dir
+
+	configMap, _ := json.Marshal(map[string]interface{}{
+		"data": map[string]string{
+			testProjectName + "." + testConfigID + ".json": fakeSourceConfig,
+			testProjectName + "." + rolloutsObjectName:     fakeSourceRollouts,
+		},
+	})
+	objects := map[string]string{
+		// GCS objects are requested with escaped names.
+		"/storage/v1/b/bucket/o/configs%2F" + testProjectName + "%2F" + testConfigID + ".json": fakeSourceConfig,
+		"/storage/v1/b/bucket/o/configs%2F" + testProjectName + "%2F" + rolloutsObjectName:     fakeSourceRollouts,
+		"/api/v1/namespaces/espv2/configmaps/service-configs":                                  string(configMap),
+		"/configs/" + testProjectName + "/" + testConfigID:                                     fakeSourceConfig,
+		"/rollouts/" + testProjectName:                                                         fakeSourceRollouts,
+	}
+	wantAuthorization := map[string]string{
+		"/storage/": "Bearer ya29.new",
+		"/api/":     "Bearer k8s-token",
+		"/configs/": "Basic dXNlcjpwYXNz",
+		"/rollouts": "Basic dXNlcjpwYXNz",
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for prefix, want := range wantAuthorization {
+			if strings.HasPrefix(r.URL.Path, prefix) && r.Header.Get("Authorization") != want {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+		}
+		body, ok := objects[r.URL.EscapedPath()]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(body))
+	}))
+	defer server.Close()
+
+	defer flag.Set("gcs_url", "https://storage.googleapis.com")
+	flag.Set("gcs_url", server.URL)
+	defer func(f func() string) { kubernetesAPIURL = f }(kubernetesAPIURL)
+	kubernetesAPIURL = func() string { return server.URL }
+
+	testCases := []struct {
+		desc    string
+		fetcher ServiceConfigFetcher
+	}{
+		{
+			desc:    "Fetch from GCS",
+			fetcher: &gcsFetcher{bucket: "bucket", prefix: "configs", mf: mf},
+		},
+		{
+			desc:    "Fetch from Kubernetes ConfigMap",
+			fetcher: &configMapFetcher{namespace: "espv2", name: "service-configs", client: http.DefaultClient},
+		},
+		{
+			desc: "Fetch from HTTPS URL with custom headers",
+			fetcher: &httpsFetcher{
+				configURL:   server.URL + "/configs/$serviceName/$configId",
+				rolloutsURL: server.URL + "/rollouts/$serviceName",
+				headers:     map[string]string{"Authorization": "Basic dXNlcjpwYXNz"},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		serviceConfig, err := tc.fetcher.FetchConfig(testProjectName, testConfigID)
+		if err != nil {
+			t.Errorf("Test Desc(%s): FetchConfig got error: %v", tc.desc, err)
+		} else if serviceConfig.GetName() != testProjectName || serviceConfig.GetId() != testConfigID {
+			t.Errorf("Test Desc(%s): FetchConfig got service %v with id %v, want %v with id %v", tc.desc, serviceConfig.GetName(), serviceConfig.GetId(), testProjectName, testConfigID)
+		}
+
+		rolloutID, configID, err := loadConfigFromRollouts(testProjectName, "", "", tc.fetcher)
+		if err != nil {
+			t.Errorf("Test Desc(%s): loadConfigFromRollouts got error: %v", tc.desc, err)
+		} else if rolloutID != "rollout-1" || configID != testConfigID {
+			t.Errorf("Test Desc(%s): got rollout %v with config id %v, want rollout-1 with config id %v", tc.desc, rolloutID, configID, testConfigID)
+		}
+
+		if _, err := tc.fetcher.FetchConfig(testProjectName, "unknown"); err == nil {
+			t.Errorf("Test Desc(%s): FetchConfig of unknown config id got no error", tc.desc)
+		}
+	}
+}
+
+func TestNewServiceConfigFetcher(t *testing.T) {
+	testCases := []struct {
+		desc      string
+		source    string
+		flags     map[string]string
+		wantError string
+	}{
+		{
+			desc:   "Success, Service Management",
+			source: "servicemanagement",
+		},
+		{
+			desc:   "Success, GCS",
+			source: "gcs",
+			flags:  map[string]string{"service_config_gcs_path": "gs://bucket/configs"},
+		},
+		{
+			desc:      "Failure, GCS path without gs scheme",
+			source:    "gcs",
+			flags:     map[string]string{"service_config_gcs_path": "https://bucket/configs"},
+			wantError: "--service_config_gcs_path must be in the form of",
+		},
+		{
+			desc:      "Failure, ConfigMap without namespace",
+			source:    "configmap",
+			flags:     map[string]string{"service_config_configmap": "service-configs"},
+			wantError: "--service_config_configmap must be in the form of",
+		},
+		{
+			desc:   "Success, HTTPS",
+			source: "https",
+			flags: map[string]string{
+				"service_config_url":     "https://example.com/$serviceName/$configId",
+				"service_config_headers": "Authorization=Bearer token, X-Api-Key=key",
+			},
+		},
+		{
+			desc:      "Failure, HTTPS without url",
+			source:    "https",
+			wantError: "--service_config_url must be set",
+		},
+		{
+			desc:   "Failure, HTTPS with invalid headers",
+			source: "https",
+			flags: map[string]string{
+				"service_config_url":     "https://example.com/$serviceName/$configId",
+				"service_config_headers": "Authorization",
+			},
+			wantError: "invalid header",
+		},
+		{
+			desc:      "Failure, unknown source",
+			source:    "ftp",
+			wantError: "failed to set service config source",
+		},
+	}
+
+	for _, tc := range testCases {
+		for name, value := range tc.flags {
+			flag.Set(name, value)
+		}
+		_, err := newServiceConfigFetcher(tc.source, nil)
+		if tc.wantError == "" && err != nil {
+			t.Errorf("Test Desc(%s): got error %v", tc.desc, err)
+		}
+		if tc.wantError != "" && (err == nil || !strings.Contains(err.Error(), tc.wantError)) {
+			t.Errorf("Test Desc(%s): got error %v, want error containing %q", tc.desc, err, tc.wantError)
+		}
+		for name := range tc.flags {
+			flag.Set(name, "")
+		}
+	}
+}
@@ -0,0 +1,90 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package configmanager
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/logging"
+	"github.com/golang/protobuf/proto"
+)
+
+// watchServiceConfigFile checks the service config file for changes every
+// --service_json_path_check_interval. The service config in the file is
+// compared instead of its modification time, so that files replaced through
+// symlinks, like mounted Kubernetes ConfigMaps, are also detected.
+func (m *ConfigManager) watchServiceConfigFile(servicePath string) {
+	logging.Infof("start checking service config file %v every %v", servicePath, *servicePathCheckInterval)
+	m.checkRolloutsTicker = time.NewTicker(*servicePathCheckInterval)
+	for range m.checkRolloutsTicker.C {
+		// only log error and keep serving the current config when the new file is invalid
+		if err := m.checkServiceConfigFile(); err != nil {
+			logging.Errorf("error occurred when checking service config file, %v", err)
+		}
+	}
+}
+
+// checkServiceConfigFile applies the service config file if it has changed
+// since it was last applied.
+func (m *ConfigManager) checkServiceConfigFile() error {
+	serviceConfig, err := m.fetcher.FetchConfig(m.serviceName, m.curConfigID)
+	m.recordFetch(err)
+	if err != nil {
+		return err
+	}
+	// The deterministic serialization is stable for the same file content.
+	buf := proto.NewBuffer(nil)
+	buf.SetDeterministic(true)
+	if err := buf.Marshal(serviceConfig); err != nil {
+		return fmt.Errorf("fail to marshal service config: %v", err)
+	}
+	hash := sha256.Sum256(buf.Bytes())
+	if bytes.Equal(hash[:], m.serviceConfigFileHash) {
+		return nil
+	}
+
+	prevServiceName, prevConfigID, prevHash := m.serviceName, m.curConfigID, m.serviceConfigFileHash
+	m.serviceName = serviceConfig.GetName()
+	m.curConfigID = serviceConfig.GetId()
+	m.serviceConfigFileHash = hash[:]
+	if err := m.applyServiceConfig(serviceConfig); err != nil {
+		m.serviceName, m.curConfigID, m.serviceConfigFileHash = prevServiceName, prevConfigID, prevHash
+		return err
+	}
+	logging.WithFields(m.logFields()).Infof("applied service config file %v", *ServicePath)
+	return nil
+}
+
+// snapshotVersion returns the version of the snapshot for the current config.
+// The config id of a watched file may not change when the file is edited, so
+// the file hash is added to let Envoy pick up the new snapshot. So is the hash
+// of the local JWKS files once they have changed.
+func (m *ConfigManager) snapshotVersion() string {
+	version := m.curConfigID
+	if len(m.serviceConfigFileHash) != 0 {
+		version = fmt.Sprintf("%s-%s", version, hex.EncodeToString(m.serviceConfigFileHash[:4]))
+	}
+	if len(m.localJwksHash) != 0 {
+		version = fmt.Sprintf("%s-jwks-%s", version, hex.EncodeToString(m.localJwksHash[:4]))
+	}
+	if len(m.transcodingDescriptorHash) != 0 {
+		version = fmt.Sprintf("%s-descriptor-%s", version, hex.EncodeToString(m.transcodingDescriptorHash[:4]))
+	}
+	return version
+}
@@ -0,0 +1,130 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package configmanager
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/options"
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/util"
+	"github.com/envoyproxy/go-control-plane/pkg/cache"
+)
+
+func TestCheckServiceConfigFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "service_config_watcher")
+	if err != nil {
+		t.Fatalf("fail to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	servicePath := filepath.Join(dir, "service.json")
+
+	writeConfig := func(content string) {
+		if err := ioutil.WriteFile(servicePath, []byte(content), 0644); err != nil {
+			t.Fatalf("fail to write service config file: %v", err)
+		}
+	}
+	configWithAPI := func(configID, api string) string {
+		return fmt.Sprintf(`{"name":"%s","id":"%s","apis":[{"name":"%s"}]}`, testProjectName, configID, api)
+	}
+	writeConfig(configWithAPI(testConfigID, testEndpointName))
+
+	flag.Set("service_json_path", servicePath)
+	flag.Set("rollout_strategy", util.ManagedRolloutStrategy)
+	flag.Set("service_json_path_check_interval", "1h")
+	defer func() {
+		flag.Set("service_json_path", "")
+		flag.Set("rollout_strategy", util.FixedRolloutStrategy)
+		flag.Set("service_json_path_check_interval", "5s")
+	}()
+
+	opts := options.DefaultConfigGeneratorOptions()
+	opts.BackendAddress = "http://127.0.0.1:8082"
+	manager, err := NewConfigManager(nil, opts)
+	if err != nil {
+		t.Fatalf("fail to initialize Config Manager: %v", err)
+	}
+
+	testCases := []struct {
+		desc           string
+		content        string
+		wantErr        bool
+		wantConfigID   string
+		wantNewVersion bool
+	}{
+		{
+			desc:         "Unchanged file keeps the snapshot",
+			content:      configWithAPI(testConfigID, testEndpointName),
+			wantConfigID: testConfigID,
+		},
+		{
+			desc:           "Changed file with the same config id updates the snapshot",
+			content:        configWithAPI(testConfigID, "endpoints.examples.bookstore.v2.Bookstore"),
+			wantConfigID:   testConfigID,
+			wantNewVersion: true,
+		},
+		{
+			desc:           "Changed file with a new config id updates the snapshot",
+			content:        configWithAPI("2019-03-02r1", testEndpointName),
+			wantConfigID:   "2019-03-02r1",
+			wantNewVersion: true,
+		},
+		{
+			desc:         "Invalid file keeps the previous snapshot",
+			content:      `{"name":"` + testProjectName + `","id":"2019-03-03r0"}`,
+			wantErr:      true,
+			wantConfigID: "2019-03-02r1",
+		},
+		{
+			desc:         "Malformed file keeps the previous snapshot",
+			content:      `{"name":`,
+			wantErr:      true,
+			wantConfigID: "2019-03-02r1",
+		},
+	}
+
+	for _, tc := range testCases {
+		prevVersion := manager.snapshotVersion()
+		writeConfig(tc.content)
+
+		err := manager.checkServiceConfigFile()
+		if (err != nil) != tc.wantErr {
+			t.Errorf("Test Desc(%s): got error %v, want error %v", tc.desc, err, tc.wantErr)
+		}
+		if manager.curConfigID != tc.wantConfigID {
+			t.Errorf("Test Desc(%s): got config id %v, want %v", tc.desc, manager.curConfigID, tc.wantConfigID)
+		}
+
+		version := manager.snapshotVersion()
+		if (version != prevVersion) != tc.wantNewVersion {
+			t.Errorf("Test Desc(%s): got snapshot version %v, previous version %v, want new version %v", tc.desc, version, prevVersion, tc.wantNewVersion)
+		}
+		if !strings.HasPrefix(version, tc.wantConfigID+"-") {
+			t.Errorf("Test Desc(%s): got snapshot version %v, want prefix %v", tc.desc, version, tc.wantConfigID)
+		}
+		snapshot, err := manager.cache.GetSnapshot(opts.Node)
+		if err != nil {
+			t.Fatalf("Test Desc(%s): fail to get snapshot: %v", tc.desc, err)
+		}
+		if got := snapshot.GetVersion(cache.ListenerType); got != version {
+			t.Errorf("Test Desc(%s): got cached snapshot version %v, want %v", tc.desc, got, version)
+		}
+	}
+}
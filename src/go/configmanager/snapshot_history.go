@@ -0,0 +1,343 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package configmanager
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/envoyproxy/go-control-plane/pkg/cache"
+)
+
+const (
+	snapshotsPath    = "/snapshots"
+	snapshotDiffPath = "/snapshot_diff"
+)
+
+// recordedSnapshot is a snapshot kept in the history of the status server.
+type recordedSnapshot struct {
+	Version  string    `json:"version"`
+	Time     time.Time `json:"time"`
+	snapshot *cache.Snapshot
+}
+
+// snapshotDiff is the diff between two snapshots served on /snapshot_diff.
+type snapshotDiff struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	// The diffs of the resources by type url, only for the changed types.
+	Resources map[string]*resourcesDiff `json:"resources,omitempty"`
+}
+
+// resourcesDiff is the diff between the resources of a type.
+type resourcesDiff struct {
+	Added   []string `json:"added,omitempty"`
+	Removed []string `json:"removed,omitempty"`
+	// The changed fields of the resources by name.
+	Changed map[string][]fieldChange `json:"changed,omitempty"`
+}
+
+// fieldChange is a changed field of a resource. From is not set for an added
+// field, and To for a removed one.
+type fieldChange struct {
+	Path string      `json:"path"`
+	From interface{} `json:"from,omitempty"`
+	To   interface{} `json:"to,omitempty"`
+}
+
+// recordSnapshot adds the snapshot to the history, keeping the last
+// --snapshot_history_size ones. A snapshot replaces the one of the same
+// version. It must be called with mu held. The history is replaced rather than
+// modified, so that it can be read once mu is released.
+func (s *statusRecorder) recordSnapshot(version string, snapshot *cache.Snapshot) {
+	snapshots := make([]recordedSnapshot, 0, len(s.snapshots)+1)
+	for _, r := range s.snapshots {
+		if r.Version != version {
+			snapshots = append(snapshots, r)
+		}
+	}
+	snapshots = append(snapshots, recordedSnapshot{
+		Version:  version,
+		Time:     time.Now(),
+		snapshot: snapshot,
+	})
+	if n := len(snapshots) - *SnapshotHistorySize; n > 0 {
+		snapshots = snapshots[n:]
+	}
+	s.snapshots = snapshots
+}
+
+func (m *ConfigManager) serveSnapshots(w http.ResponseWriter, r *http.Request) {
+	m.status.mu.Lock()
+	body, err := json.MarshalIndent(m.status.snapshots, "", "  ")
+	m.status.mu.Unlock()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
+
+// serveSnapshotDiff serves the diff between the snapshots of the "from" and
+// "to" versions. "to" defaults to the current snapshot, and "from" to the one
+// before "to".
+func (m *ConfigManager) serveSnapshotDiff(w http.ResponseWriter, r *http.Request) {
+	fromVersion, toVersion := r.URL.Query().Get("from"), r.URL.Query().Get("to")
+
+	m.status.mu.Lock()
+	snapshots := m.status.snapshots
+	m.status.mu.Unlock()
+
+	if len(snapshots) == 0 {
+		http.Error(w, "no snapshot has been generated", http.StatusNotFound)
+		return
+	}
+	to := len(snapshots) - 1
+	if toVersion != "" {
+		if to = findSnapshot(snapshots, toVersion); to < 0 {
+			http.Error(w, fmt.Sprintf("snapshot %q is not in the history", toVersion), http.StatusNotFound)
+			return
+		}
+	}
+	from := to - 1
+	if fromVersion != "" {
+		if from = findSnapshot(snapshots, fromVersion); from < 0 {
+			http.Error(w, fmt.Sprintf("snapshot %q is not in the history", fromVersion), http.StatusNotFound)
+			return
+		}
+	}
+	if from < 0 {
+		http.Error(w, fmt.Sprintf("no snapshot is before %q in the history", snapshots[to].Version), http.StatusNotFound)
+		return
+	}
+
+	diff := &snapshotDiff{
+		From: snapshots[from].Version,
+		To:   snapshots[to].Version,
+	}
+	var err error
+	if diff.Resources, err = diffSnapshots(snapshots[from].snapshot, snapshots[to].snapshot); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	body, err := json.MarshalIndent(diff, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
+
+func findSnapshot(snapshots []recordedSnapshot, version string) int {
+	for i, r := range snapshots {
+		if r.Version == version {
+			return i
+		}
+	}
+	return -1
+}
+
+// diffSnapshots returns the diffs of the resources served on /config_dump
+// between two snapshots, by type url.
+func diffSnapshots(from, to *cache.Snapshot) (map[string]*resourcesDiff, error) {
+	diffs := make(map[string]*resourcesDiff)
+	for _, typ := range []string{cache.ClusterType, cache.ListenerType, cache.RouteType, cache.EndpointType} {
+		fromResources, err := resourcesToJSON(from.GetResources(typ))
+		if err != nil {
+			return nil, err
+		}
+		toResources, err := resourcesToJSON(to.GetResources(typ))
+		if err != nil {
+			return nil, err
+		}
+
+		diff := &resourcesDiff{}
+		for _, name := range sortedKeys(fromResources) {
+			if _, ok := toResources[name]; !ok {
+				diff.Removed = append(diff.Removed, name)
+			}
+		}
+		for _, name := range sortedKeys(toResources) {
+			fromResource, ok := fromResources[name]
+			if !ok {
+				diff.Added = append(diff.Added, name)
+				continue
+			}
+			if changes := diffJSON("", fromResource, toResources[name], nil); len(changes) != 0 {
+				if diff.Changed == nil {
+					diff.Changed = make(map[string][]fieldChange)
+				}
+				diff.Changed[name] = changes
+			}
+		}
+		if len(diff.Added) != 0 || len(diff.Removed) != 0 || len(diff.Changed) != 0 {
+			diffs[typ] = diff
+		}
+	}
+	return diffs, nil
+}
+
+// resourcesToJSON returns the resources as decoded JSON values, so that they
+// can be compared field by field.
+func resourcesToJSON(resources map[string]cache.Resource) (map[string]interface{}, error) {
+	values := make(map[string]interface{})
+	for name, resource := range resources {
+		raw, err := marshalResource(resource)
+		if err != nil {
+			return nil, fmt.Errorf("fail to marshal resource %v: %v", name, err)
+		}
+		var value interface{}
+		if err := json.Unmarshal(raw, &value); err != nil {
+			return nil, fmt.Errorf("fail to unmarshal resource %v: %v", name, err)
+		}
+		values[name] = value
+	}
+	return values, nil
+}
+
+// diffJSON appends the changed fields between two JSON values to changes.
+// The elements of lists are matched by their name or their route match if
+// they all have one, like HTTP filters and routes, and by index otherwise.
+func diffJSON(path string, from, to interface{}, changes []fieldChange) []fieldChange {
+	switch fromValue := from.(type) {
+	case map[string]interface{}:
+		if toValue, ok := to.(map[string]interface{}); ok {
+			keys := sortedKeys(fromValue)
+			for _, key := range sortedKeys(toValue) {
+				if _, ok := fromValue[key]; !ok {
+					keys = append(keys, key)
+				}
+			}
+			for _, key := range keys {
+				changes = diffField(joinPath(path, key), fromValue, toValue, key, changes)
+			}
+			return changes
+		}
+	case []interface{}:
+		if toValue, ok := to.([]interface{}); ok {
+			fromKeyed, fromOk := keyListElements(fromValue)
+			toKeyed, toOk := keyListElements(toValue)
+			if fromOk && toOk {
+				keys := listElementKeys(fromValue)
+				for _, key := range listElementKeys(toValue) {
+					if _, ok := fromKeyed[key]; !ok {
+						keys = append(keys, key)
+					}
+				}
+				for _, key := range keys {
+					changes = diffField(fmt.Sprintf("%s[%s]", path, key), fromKeyed, toKeyed, key, changes)
+				}
+				return changes
+			}
+			for i := 0; i < len(fromValue) || i < len(toValue); i++ {
+				elementPath := fmt.Sprintf("%s[%d]", path, i)
+				switch {
+				case i >= len(toValue):
+					changes = append(changes, fieldChange{Path: elementPath, From: fromValue[i]})
+				case i >= len(fromValue):
+					changes = append(changes, fieldChange{Path: elementPath, To: toValue[i]})
+				default:
+					changes = diffJSON(elementPath, fromValue[i], toValue[i], changes)
+				}
+			}
+			return changes
+		}
+	}
+	if !reflect.DeepEqual(from, to) {
+		changes = append(changes, fieldChange{Path: path, From: from, To: to})
+	}
+	return changes
+}
+
+// diffField appends the changes of the field of the key, which may only be
+// in one of the values.
+func diffField(path string, from, to map[string]interface{}, key string, changes []fieldChange) []fieldChange {
+	fromField, fromOk := from[key]
+	toField, toOk := to[key]
+	switch {
+	case !toOk:
+		return append(changes, fieldChange{Path: path, From: fromField})
+	case !fromOk:
+		return append(changes, fieldChange{Path: path, To: toField})
+	default:
+		return diffJSON(path, fromField, toField, changes)
+	}
+}
+
+// keyListElements returns the elements of a list by their key, and false if
+// they cannot all be keyed uniquely.
+func keyListElements(list []interface{}) (map[string]interface{}, bool) {
+	keys := listElementKeys(list)
+	if keys == nil {
+		return nil, false
+	}
+	keyed := make(map[string]interface{})
+	for i, key := range keys {
+		if _, ok := keyed[key]; ok {
+			return nil, false
+		}
+		keyed[key] = list[i]
+	}
+	return keyed, true
+}
+
+// listElementKeys returns the keys of the elements of a list in order, or nil
+// if an element has no key.
+func listElementKeys(list []interface{}) []string {
+	var keys []string
+	for _, element := range list {
+		object, ok := element.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		if name, ok := object["name"].(string); ok {
+			keys = append(keys, name)
+			continue
+		}
+		match, ok := object["match"]
+		if !ok {
+			return nil
+		}
+		// The keys of maps are sorted by json.Marshal.
+		b, err := json.Marshal(match)
+		if err != nil {
+			return nil
+		}
+		keys = append(keys, string(b))
+	}
+	return keys
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	var keys []string
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,193 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package configmanager
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/envoyproxy/go-control-plane/pkg/cache"
+	"github.com/golang/protobuf/ptypes"
+
+	v2pb "github.com/envoyproxy/go-control-plane/envoy/api/v2"
+	routepb "github.com/envoyproxy/go-control-plane/envoy/api/v2/route"
+)
+
+func TestServeSnapshotDiff(t *testing.T) {
+	route := func(path, cluster string) *routepb.Route {
+		return &routepb.Route{
+			Match: &routepb.RouteMatch{
+				PathSpecifier: &routepb.RouteMatch_Path{Path: path},
+			},
+			Action: &routepb.Route_Route{
+				Route: &routepb.RouteAction{
+					ClusterSpecifier: &routepb.RouteAction_Cluster{Cluster: cluster},
+				},
+			},
+		}
+	}
+	snapshot := func(version string, clusters []string, routes ...*routepb.Route) *cache.Snapshot {
+		var clusterResources []cache.Resource
+		for _, name := range clusters {
+			clusterResources = append(clusterResources, &v2pb.Cluster{Name: name, ConnectTimeout: ptypes.DurationProto(0)})
+		}
+		s := cache.NewSnapshot(version, nil, clusterResources, []cache.Resource{
+			&v2pb.RouteConfiguration{
+				Name: "local_route",
+				VirtualHosts: []*routepb.VirtualHost{{
+					Name:    "backend",
+					Domains: []string{"*"},
+					Routes:  routes,
+				}},
+			},
+		}, nil, nil)
+		return &s
+	}
+
+	m := &ConfigManager{}
+	for _, s := range []*cache.Snapshot{
+		snapshot("v1", []string{"a", "b"}, route("/a", "a"), route("/b", "b")),
+		snapshot("v2", []string{"a", "c"}, route("/a", "a"), route("/c", "c")),
+		snapshot("v3", []string{"a", "c"}, route("/a", "c"), route("/c", "c")),
+	} {
+		m.status.recordSnapshot(s.GetVersion(cache.ListenerType), s)
+	}
+
+	testData := []struct {
+		desc     string
+		query    string
+		wantCode int
+		wantDiff *snapshotDiff
+	}{
+		{
+			desc:     "the current snapshot is compared with the previous one by default",
+			query:    "",
+			wantCode: http.StatusOK,
+			wantDiff: &snapshotDiff{
+				From: "v2",
+				To:   "v3",
+				Resources: map[string]*resourcesDiff{
+					cache.RouteType: {
+						Changed: map[string][]fieldChange{
+							"local_route": {{
+								Path: `virtualHosts[backend].routes[{"path":"/a"}].route.cluster`,
+								From: "a",
+								To:   "c",
+							}},
+						},
+					},
+				},
+			},
+		},
+		{
+			desc:     "added and removed clusters and routes",
+			query:    "?from=v1&to=v2",
+			wantCode: http.StatusOK,
+			wantDiff: &snapshotDiff{
+				From: "v1",
+				To:   "v2",
+				Resources: map[string]*resourcesDiff{
+					cache.ClusterType: {
+						Added:   []string{"c"},
+						Removed: []string{"b"},
+					},
+					cache.RouteType: {
+						Changed: map[string][]fieldChange{
+							"local_route": {
+								{
+									Path: `virtualHosts[backend].routes[{"path":"/b"}]`,
+									From: map[string]interface{}{
+										"match": map[string]interface{}{"path": "/b"},
+										"route": map[string]interface{}{"cluster": "b"},
+									},
+								},
+								{
+									Path: `virtualHosts[backend].routes[{"path":"/c"}]`,
+									To: map[string]interface{}{
+										"match": map[string]interface{}{"path": "/c"},
+										"route": map[string]interface{}{"cluster": "c"},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			desc:     "unchanged snapshots",
+			query:    "?from=v3&to=v3",
+			wantCode: http.StatusOK,
+			wantDiff: &snapshotDiff{
+				From:      "v3",
+				To:        "v3",
+				Resources: map[string]*resourcesDiff{},
+			},
+		},
+		{
+			desc:     "unknown version",
+			query:    "?from=v0",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			desc:     "no snapshot before the oldest one",
+			query:    "?to=v1",
+			wantCode: http.StatusNotFound,
+		},
+	}
+
+	for _, tc := range testData {
+		w := httptest.NewRecorder()
+		m.StatusHandler().ServeHTTP(w, httptest.NewRequest("GET", snapshotDiffPath+tc.query, nil))
+		if w.Code != tc.wantCode {
+			t.Errorf("Test Desc(%s), got code %v, want %v: %s", tc.desc, w.Code, tc.wantCode, w.Body.String())
+			continue
+		}
+		if tc.wantDiff == nil {
+			continue
+		}
+		gotDiff := &snapshotDiff{}
+		if err := json.Unmarshal(w.Body.Bytes(), gotDiff); err != nil {
+			t.Fatalf("Test Desc(%s), fail to unmarshal diff %v: %v", tc.desc, w.Body.String(), err)
+		}
+		if gotDiff.Resources == nil {
+			gotDiff.Resources = map[string]*resourcesDiff{}
+		}
+		if !reflect.DeepEqual(gotDiff, tc.wantDiff) {
+			got, _ := json.Marshal(gotDiff)
+			want, _ := json.Marshal(tc.wantDiff)
+			t.Errorf("Test Desc(%s), got diff %s, want %s", tc.desc, got, want)
+		}
+	}
+}
+
+func TestRecordSnapshotHistory(t *testing.T) {
+	s := &statusRecorder{}
+	for _, version := range []string{"v1", "v2", "v1", "v3", "v4", "v5", "v6", "v7", "v8", "v9", "v10", "v11"} {
+		s.recordSnapshot(version, &cache.Snapshot{})
+	}
+	var got []string
+	for _, r := range s.snapshots {
+		got = append(got, r.Version)
+	}
+	// The default --snapshot_history_size is 10, and v1 is only kept once.
+	want := []string{"v1", "v3", "v4", "v5", "v6", "v7", "v8", "v9", "v10", "v11"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got history %v, want %v", got, want)
+	}
+}
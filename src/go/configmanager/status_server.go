@@ -0,0 +1,307 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package configmanager
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/configinfo"
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/logging"
+	"github.com/envoyproxy/go-control-plane/pkg/cache"
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
+
+	gen "github.com/GoogleCloudPlatform/esp-v2/src/go/configgenerator"
+)
+
+const (
+	statusPath     = "/status"
+	configDumpPath = "/config_dump"
+	routesPath     = "/routes"
+	explainPath    = "/explain"
+)
+
+// serviceStatus is the status of one service served by the proxy.
+type serviceStatus struct {
+	ServiceName string `json:"serviceName"`
+	RolloutID   string `json:"rolloutId,omitempty"`
+	ConfigID    string `json:"configId"`
+	// Set when the service is deleted and answered with 410 Gone.
+	Deleted bool `json:"deleted,omitempty"`
+}
+
+// managerStatus is the status of the Config Manager served on /status.
+type managerStatus struct {
+	Services        []serviceStatus `json:"services"`
+	SnapshotVersion string          `json:"snapshotVersion,omitempty"`
+	LastFetchTime   *time.Time      `json:"lastFetchTime,omitempty"`
+	LastFetchError  string          `json:"lastFetchError,omitempty"`
+	// Set with --detect_rollout_drift.
+	RolloutDrifts []rolloutDrift `json:"rolloutDrifts,omitempty"`
+}
+
+// serviceRoutes is the route table of one config of a service served on
+// /routes.
+type serviceRoutes struct {
+	ServiceName string            `json:"serviceName"`
+	ConfigID    string            `json:"configId"`
+	Routes      []*gen.RouteEntry `json:"routes"`
+
+	serviceInfo *configinfo.ServiceInfo
+}
+
+// statusRecorder keeps the status of the Config Manager. It is written by the
+// goroutine checking rollouts and read by the status server, so all accesses
+// are guarded by mu.
+type statusRecorder struct {
+	mu     sync.Mutex
+	status managerStatus
+	// The last --snapshot_history_size snapshots, the oldest first.
+	snapshots []recordedSnapshot
+	// The route tables of the services in the current snapshot.
+	routes []serviceRoutes
+}
+
+// recordFetch records the result of a call to Service Management.
+func (m *ConfigManager) recordFetch(err error) {
+	m.status.mu.Lock()
+	defer m.status.mu.Unlock()
+	now := time.Now()
+	m.status.status.LastFetchTime = &now
+	m.status.status.LastFetchError = ""
+	if err != nil {
+		m.status.status.LastFetchError = err.Error()
+		return
+	}
+	m.recordFetchSuccess()
+}
+
+// recordSnapshot records the services of the snapshot set in the cache, and
+// their route tables, and keeps the snapshot in the history.
+func (m *ConfigManager) recordSnapshot(snapshot *cache.Snapshot) {
+	routes := m.routeTables()
+
+	version := snapshot.GetVersion(cache.ListenerType)
+	services := []serviceStatus{{
+		ServiceName: m.serviceName,
+		RolloutID:   m.curRolloutID,
+		ConfigID:    m.curConfigID,
+		Deleted:     m.serviceDeleted,
+	}}
+	for _, s := range m.additionalServices {
+		services = append(services, serviceStatus{
+			ServiceName: s.serviceName,
+			RolloutID:   s.curRolloutID,
+			ConfigID:    s.curConfigID,
+		})
+	}
+
+	m.status.mu.Lock()
+	defer m.status.mu.Unlock()
+	m.status.status.Services = services
+	m.status.status.SnapshotVersion = version
+	m.status.routes = routes
+	m.status.recordSnapshot(version, snapshot)
+}
+
+// routeTables returns the route tables of the configs of the services in the
+// snapshot: the configs of the current rollout with --rollout_traffic_split,
+// or the current config of each service.
+func (m *ConfigManager) routeTables() []serviceRoutes {
+	var routes []serviceRoutes
+	add := func(serviceName, configID string, serviceInfo *configinfo.ServiceInfo) {
+		table, err := gen.MakeRouteTable(serviceInfo)
+		if err != nil {
+			logging.Errorf("fail to make the route table of service %v, config %v: %v", serviceName, configID, err)
+			return
+		}
+		routes = append(routes, serviceRoutes{
+			ServiceName: serviceName,
+			ConfigID:    configID,
+			Routes:      table,
+			serviceInfo: serviceInfo,
+		})
+	}
+	if len(m.trafficSplitConfigs) > 0 {
+		for _, config := range m.trafficSplitConfigs {
+			add(m.serviceName, config.configID, config.serviceInfo)
+		}
+		return routes
+	}
+	add(m.serviceName, m.curConfigID, m.serviceInfo)
+	for _, s := range m.additionalServices {
+		add(s.serviceName, s.curConfigID, s.serviceInfo)
+	}
+	return routes
+}
+
+// StatusHandler returns the handler of the debug endpoints. /status serves the
+// services with their rollout and config ids, and the last fetch from Service
+// Management. /config_dump serves the Envoy resources in the current snapshot.
+// /snapshots serves the versions of the last snapshots, and /snapshot_diff the
+// changes of the resources between two of them. /routes serves the routes of
+// the services, with the operations and HTTP rules they were generated for,
+// and /explain how a request would be handled, without sending it.
+func (m *ConfigManager) StatusHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(statusPath, m.serveStatus)
+	mux.HandleFunc(configDumpPath, m.serveConfigDump)
+	mux.HandleFunc(routesPath, m.serveRoutes)
+	mux.HandleFunc(explainPath, m.serveExplain)
+	mux.HandleFunc(snapshotsPath, m.serveSnapshots)
+	mux.HandleFunc(snapshotDiffPath, m.serveSnapshotDiff)
+	return mux
+}
+
+func (m *ConfigManager) serveStatus(w http.ResponseWriter, r *http.Request) {
+	m.status.mu.Lock()
+	body, err := json.MarshalIndent(m.status.status, "", "  ")
+	m.status.mu.Unlock()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
+
+func (m *ConfigManager) serveRoutes(w http.ResponseWriter, r *http.Request) {
+	m.status.mu.Lock()
+	body, err := json.MarshalIndent(m.status.routes, "", "  ")
+	m.status.mu.Unlock()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
+
+// serveExplain explains how the request in the query parameters would be
+// handled by the current config of a service: the "path" of the request, its
+// HTTP "method", GET by default, its headers as "header" parameters like
+// "name: value", and the "service", the main service by default. With
+// --rollout_traffic_split, the config with the most traffic is used.
+func (m *ConfigManager) serveExplain(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	path := query.Get("path")
+	if path == "" {
+		http.Error(w, "path is required", http.StatusBadRequest)
+		return
+	}
+	httpMethod := strings.ToUpper(query.Get("method"))
+	if httpMethod == "" {
+		httpMethod = http.MethodGet
+	}
+	headers := make(map[string]string)
+	for _, header := range query["header"] {
+		parts := strings.SplitN(header, ":", 2)
+		if len(parts) != 2 {
+			http.Error(w, fmt.Sprintf("invalid header %q, must be name: value", header), http.StatusBadRequest)
+			return
+		}
+		headers[strings.ToLower(strings.TrimSpace(parts[0]))] = strings.TrimSpace(parts[1])
+	}
+	serviceName := query.Get("service")
+	if serviceName == "" {
+		serviceName = m.serviceName
+	}
+
+	var serviceInfo *configinfo.ServiceInfo
+	m.status.mu.Lock()
+	for _, s := range m.status.routes {
+		if s.ServiceName == serviceName {
+			serviceInfo = s.serviceInfo
+			break
+		}
+	}
+	m.status.mu.Unlock()
+	if serviceInfo == nil {
+		http.Error(w, fmt.Sprintf("unknown service %q", serviceName), http.StatusNotFound)
+		return
+	}
+
+	explanation, err := gen.ExplainRequest(serviceInfo, httpMethod, path, headers)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	body, err := json.MarshalIndent(explanation, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
+
+func (m *ConfigManager) serveConfigDump(w http.ResponseWriter, r *http.Request) {
+	snapshot, err := m.cache.GetSnapshot(m.envoyConfigOptions.Node)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	dump := make(map[string]interface{})
+	for _, typ := range []string{cache.ClusterType, cache.ListenerType, cache.RouteType, cache.EndpointType} {
+		resources := snapshot.GetResources(typ)
+		if len(resources) == 0 {
+			continue
+		}
+		var names []string
+		for name := range resources {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		var items []json.RawMessage
+		for _, name := range names {
+			item, err := marshalResource(resources[name])
+			if err != nil {
+				logging.Errorf("fail to marshal resource %v: %v", name, err)
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			items = append(items, item)
+		}
+		dump[typ] = map[string]interface{}{
+			"version":   snapshot.GetVersion(typ),
+			"resources": items,
+		}
+	}
+
+	body, err := json.MarshalIndent(dump, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
+
+func marshalResource(resource proto.Message) (json.RawMessage, error) {
+	marshaler := &jsonpb.Marshaler{}
+	str, err := marshaler.MarshalToString(resource)
+	if err != nil {
+		return nil, err
+	}
+	return json.RawMessage(str), nil
+}
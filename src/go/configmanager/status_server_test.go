@@ -0,0 +1,124 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package configmanager
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/options"
+	"github.com/envoyproxy/go-control-plane/pkg/cache"
+
+	gen "github.com/GoogleCloudPlatform/esp-v2/src/go/configgenerator"
+)
+
+func TestStatusHandler(t *testing.T) {
+	flag.Set("service", testProjectName)
+	flag.Set("service_config_id", testConfigID)
+	flag.Set("rollout_strategy", "fixed")
+
+	var err error
+	fakeConfig, err = genFakeConfig(fmt.Sprintf(`{"name":"%s","id":"%s","apis":[{"name":"%s"}]}`, testProjectName, testConfigID, testEndpointName))
+	if err != nil {
+		t.Fatalf("fail to generate fake config: %v", err)
+	}
+	opts := options.DefaultConfigGeneratorOptions()
+	opts.BackendAddress = "http://127.0.0.1:8082"
+
+	runTest(t, opts, func(env *testEnv) {
+		handler := env.configManager.StatusHandler()
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", statusPath, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("%v returns %v", statusPath, w.Code)
+		}
+		status := managerStatus{}
+		if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil {
+			t.Fatalf("fail to unmarshal status %v: %v", w.Body.String(), err)
+		}
+		wantServices := []serviceStatus{{ServiceName: testProjectName, ConfigID: testConfigID}}
+		if len(status.Services) != 1 || status.Services[0] != wantServices[0] {
+			t.Errorf("got services %v, want %v", status.Services, wantServices)
+		}
+		if status.SnapshotVersion != testConfigID {
+			t.Errorf("got snapshot version %v, want %v", status.SnapshotVersion, testConfigID)
+		}
+		if status.LastFetchTime == nil || status.LastFetchError != "" {
+			t.Errorf("got last fetch time %v and error %q, want a successful fetch", status.LastFetchTime, status.LastFetchError)
+		}
+
+		w = httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", configDumpPath, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("%v returns %v", configDumpPath, w.Code)
+		}
+		dump := make(map[string]struct {
+			Version   string            `json:"version"`
+			Resources []json.RawMessage `json:"resources"`
+		})
+		if err := json.Unmarshal(w.Body.Bytes(), &dump); err != nil {
+			t.Fatalf("fail to unmarshal config dump %v: %v", w.Body.String(), err)
+		}
+		for _, typ := range []string{cache.ClusterType, cache.ListenerType} {
+			if dump[typ].Version != testConfigID || len(dump[typ].Resources) == 0 {
+				t.Errorf("got config dump of %v with version %q and %d resources, want version %v with resources", typ, dump[typ].Version, len(dump[typ].Resources), testConfigID)
+			}
+		}
+
+		w = httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", routesPath, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("%v returns %v", routesPath, w.Code)
+		}
+		var routes []serviceRoutes
+		if err := json.Unmarshal(w.Body.Bytes(), &routes); err != nil {
+			t.Fatalf("fail to unmarshal routes %v: %v", w.Body.String(), err)
+		}
+		if len(routes) != 1 || routes[0].ServiceName != testProjectName || routes[0].ConfigID != testConfigID {
+			t.Fatalf("got routes %v, want the routes of service %v, config %v", w.Body.String(), testProjectName, testConfigID)
+		}
+		if len(routes[0].Routes) != 1 || routes[0].Routes[0].Origin != gen.RouteOriginCatchAll {
+			t.Errorf("got routes %v, want the catch-all route", w.Body.String())
+		}
+
+		w = httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", explainPath, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%v without path returns %v, want %v", explainPath, w.Code, http.StatusBadRequest)
+		}
+		w = httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", explainPath+"?path=/v1/shelves&service=unknown", nil))
+		if w.Code != http.StatusNotFound {
+			t.Errorf("%v of an unknown service returns %v, want %v", explainPath, w.Code, http.StatusNotFound)
+		}
+		w = httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", explainPath+"?method=POST&path=/v1/shelves&header=x-foo:%20bar", nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("%v returns %v", explainPath, w.Code)
+		}
+		var explanation gen.RequestExplanation
+		if err := json.Unmarshal(w.Body.Bytes(), &explanation); err != nil {
+			t.Fatalf("fail to unmarshal explanation %v: %v", w.Body.String(), err)
+		}
+		if explanation.Operation != "" || explanation.Route == nil || explanation.Route.Origin != gen.RouteOriginCatchAll {
+			t.Errorf("got explanation %v, want no operation on the catch-all route", w.Body.String())
+		}
+	})
+}
@@ -0,0 +1,111 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package configmanager
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/logging"
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/options"
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/util"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+)
+
+var generateAccessTokenFromFile = util.GenerateAccessTokenFromFile
+
+// NeedTokenAgent returns true if Envoy fetches the access tokens from the token
+// agent, which is when the service account key is an external account, or the
+// backends are authenticated with OAuth2 client credentials.
+func NeedTokenAgent(opts options.ConfigGeneratorOptions) bool {
+	if len(opts.BackendOAuth2) > 0 {
+		return true
+	}
+	if opts.ServiceAccountKey == "" {
+		return false
+	}
+	data, err := ioutil.ReadFile(opts.ServiceAccountKey)
+	return err == nil && util.IsExternalAccount(data)
+}
+
+// TokenAgentHandler returns the handler of the token agent, serving the access
+// tokens of the service account key, and the OAuth2 access tokens of the
+// backends, in the same format as the metadata server.
+func TokenAgentHandler(opts options.ConfigGeneratorOptions) (http.Handler, error) {
+	mux := http.NewServeMux()
+	if opts.ServiceAccountKey != "" {
+		mux.HandleFunc(util.AccessTokenSuffix, func(w http.ResponseWriter, r *http.Request) {
+			token, expiresIn, err := generateAccessTokenFromFile(opts.ServiceAccountKey)
+			if err != nil {
+				logging.Errorf("token agent fail to generate access token: %v", err)
+				tokenRefreshFailures.Inc()
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			writeAccessToken(w, token, expiresIn)
+		})
+	}
+
+	for _, o := range opts.BackendOAuth2 {
+		_, hostname, port, _, err := util.ParseURI(o.BackendAddress)
+		if err != nil {
+			return nil, fmt.Errorf("fail to parse backend address %s: %v", o.BackendAddress, err)
+		}
+		address := fmt.Sprintf("%v:%v", hostname, port)
+		tokenSource := newBackendOAuth2TokenSource(o)
+		mux.HandleFunc(util.BackendOAuth2TokenSuffix(address), func(w http.ResponseWriter, r *http.Request) {
+			token, err := tokenSource.Token()
+			if err != nil {
+				logging.WithFields(logging.Fields{"backend": address}).Errorf("token agent fail to get OAuth2 access token: %v", err)
+				tokenRefreshFailures.Inc()
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			writeAccessToken(w, token.AccessToken, time.Until(token.Expiry))
+		})
+	}
+	return mux, nil
+}
+
+// newBackendOAuth2TokenSource returns the source of the OAuth2 access tokens of
+// the client credentials grant, which are cached until they expire.
+func newBackendOAuth2TokenSource(o *options.BackendOAuth2Options) oauth2.TokenSource {
+	config := &clientcredentials.Config{
+		ClientID:       o.ClientID,
+		ClientSecret:   o.ClientSecret,
+		TokenURL:       o.TokenURL,
+		Scopes:         o.Scopes,
+		EndpointParams: url.Values{},
+	}
+	for k, v := range o.EndpointParams {
+		config.EndpointParams.Set(k, v)
+	}
+	return config.TokenSource(context.Background())
+}
+
+func writeAccessToken(w http.ResponseWriter, token string, expiresIn time.Duration) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"access_token": token,
+		"expires_in":   int64(expiresIn.Seconds()),
+		"token_type":   "Bearer",
+	})
+}
@@ -0,0 +1,161 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package configmanager
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/options"
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/util"
+)
+
+func TestTokenAgentHandler(t *testing.T) {
+	testData := []struct {
+		desc     string
+		tokenErr error
+		wantCode int
+		wantBody string
+	}{
+		{
+			desc:     "Success, serve the token in the format of the metadata server",
+			wantCode: http.StatusOK,
+			wantBody: `{"access_token": "ya29.token", "expires_in": 3599, "token_type": "Bearer"}`,
+		},
+		{
+			desc:     "Failure, token exchange fails",
+			tokenErr: fmt.Errorf("token exchange fails"),
+			wantCode: http.StatusInternalServerError,
+		},
+	}
+
+	defer func() { generateAccessTokenFromFile = util.GenerateAccessTokenFromFile }()
+	for _, tc := range testData {
+		generateAccessTokenFromFile = func(serviceAccountKey string) (string, time.Duration, error) {
+			if serviceAccountKey != "/tmp/external_account.json" {
+				t.Errorf("Test Desc(%s): got service account key %v", tc.desc, serviceAccountKey)
+			}
+			if tc.tokenErr != nil {
+				return "", 0, tc.tokenErr
+			}
+			return "ya29.token", 3599 * time.Second, nil
+		}
+
+		opts := options.DefaultConfigGeneratorOptions()
+		opts.ServiceAccountKey = "/tmp/external_account.json"
+		handler, err := TokenAgentHandler(opts)
+		if err != nil {
+			t.Fatalf("Test Desc(%s): fail to create token agent: %v", tc.desc, err)
+		}
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", util.AccessTokenSuffix, nil))
+		if w.Code != tc.wantCode {
+			t.Errorf("Test Desc(%s): got code %v, want %v", tc.desc, w.Code, tc.wantCode)
+			continue
+		}
+		if tc.wantBody == "" {
+			continue
+		}
+		if err := util.JsonEqual(tc.wantBody, w.Body.String()); err != nil {
+			t.Errorf("Test Desc(%s): %v", tc.desc, err)
+		}
+	}
+}
+
+func TestTokenAgentHandlerForBackendOAuth2(t *testing.T) {
+	var tokenRequests int
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tokenRequests++
+		body, _ := ioutil.ReadAll(r.Body)
+		if got, want := string(body), "audience=https%3A%2F%2Fapi.example.com&grant_type=client_credentials&scope=read+write"; got != want {
+			t.Errorf("got token request %v, want %v", got, want)
+		}
+		if id, secret, _ := r.BasicAuth(); id != "client-id" || secret != "client-secret" {
+			t.Errorf("got client credentials %v:%v", id, secret)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token": "oauth2-token", "expires_in": 3600, "token_type": "Bearer"}`))
+	}))
+	defer tokenServer.Close()
+
+	testData := []struct {
+		desc     string
+		path     string
+		wantCode int
+		wantBody string
+	}{
+		{
+			desc:     "Success, serve the OAuth2 access token of the backend",
+			path:     util.BackendOAuth2TokenSuffix("api.example.com:443"),
+			wantCode: http.StatusOK,
+			wantBody: `{"access_token": "oauth2-token", "expires_in": 3599, "token_type": "Bearer"}`,
+		},
+		{
+			desc:     "Success, serve the cached OAuth2 access token of the backend",
+			path:     util.BackendOAuth2TokenSuffix("api.example.com:443"),
+			wantCode: http.StatusOK,
+			wantBody: `{"access_token": "oauth2-token", "expires_in": 3599, "token_type": "Bearer"}`,
+		},
+		{
+			desc:     "Failure, no OAuth2 client credentials for the backend",
+			path:     util.BackendOAuth2TokenSuffix("other.example.com:443"),
+			wantCode: http.StatusNotFound,
+		},
+		{
+			desc:     "Failure, the access token of the service account key is not served without it",
+			path:     util.AccessTokenSuffix,
+			wantCode: http.StatusNotFound,
+		},
+	}
+
+	opts := options.DefaultConfigGeneratorOptions()
+	opts.BackendOAuth2 = []*options.BackendOAuth2Options{
+		{
+			BackendAddress: "https://api.example.com/v1",
+			TokenURL:       tokenServer.URL,
+			ClientID:       "client-id",
+			ClientSecret:   "client-secret",
+			Scopes:         []string{"read", "write"},
+			EndpointParams: map[string]string{"audience": "https://api.example.com"},
+		},
+	}
+	handler, err := TokenAgentHandler(opts)
+	if err != nil {
+		t.Fatalf("fail to create token agent: %v", err)
+	}
+
+	for _, tc := range testData {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", tc.path, nil))
+		if w.Code != tc.wantCode {
+			t.Errorf("Test Desc(%s): got code %v, want %v", tc.desc, w.Code, tc.wantCode)
+			continue
+		}
+		if tc.wantBody == "" {
+			continue
+		}
+		if err := util.JsonEqual(tc.wantBody, w.Body.String()); err != nil {
+			t.Errorf("Test Desc(%s): %v", tc.desc, err)
+		}
+	}
+	if tokenRequests != 1 {
+		t.Errorf("got %v token requests, want 1", tokenRequests)
+	}
+}
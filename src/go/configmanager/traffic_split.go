@@ -0,0 +1,152 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package configmanager
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/configinfo"
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/logging"
+	"github.com/envoyproxy/go-control-plane/pkg/cache"
+
+	gen "github.com/GoogleCloudPlatform/esp-v2/src/go/configgenerator"
+)
+
+// trafficSplitConfig is a config of the current rollout served with its
+// traffic percentage, only used with --rollout_traffic_split.
+type trafficSplitConfig struct {
+	configID    string
+	percentage  float64
+	serviceInfo *configinfo.ServiceInfo
+}
+
+// sortTrafficPercentages returns the configs of a rollout ordered by traffic
+// percentage, the config with max percentage first.
+func sortTrafficPercentages(percentages map[string]float64) []*trafficSplitConfig {
+	var configs []*trafficSplitConfig
+	for configID, percentage := range percentages {
+		configs = append(configs, &trafficSplitConfig{
+			configID:   configID,
+			percentage: percentage,
+		})
+	}
+	sort.Slice(configs, func(i, j int) bool {
+		if configs[i].percentage != configs[j].percentage {
+			return configs[i].percentage > configs[j].percentage
+		}
+		return configs[i].configID < configs[j].configID
+	})
+	return configs
+}
+
+// checkTrafficSplitRollouts updates the snapshot if there is a new rollout,
+// serving all its configs with their traffic percentages. The config with max
+// percentage is kept as the current config, like without traffic split.
+func (m *ConfigManager) checkTrafficSplitRollouts() error {
+	newRolloutID, percentages, err := loadTrafficPercentagesFromRollouts(m.serviceName, m.trafficSplitRolloutID, m.fetcher)
+	m.recordFetch(err)
+	if err != nil {
+		return err
+	}
+	if newRolloutID == m.trafficSplitRolloutID {
+		return nil
+	}
+
+	configs := sortTrafficPercentages(percentages)
+	for _, config := range configs {
+		if config.serviceInfo = m.trafficSplitServiceInfo(config.configID); config.serviceInfo != nil {
+			continue
+		}
+		serviceConfig, err := m.fetchServiceConfig(m.serviceName, config.configID)
+		if err != nil {
+			return fmt.Errorf("fail to fetch service config %v, %s", config.configID, err)
+		}
+		if config.serviceInfo, err = m.newServiceInfo(serviceConfig, config.configID); err != nil {
+			return err
+		}
+	}
+
+	prevRolloutID, prevConfigID, prevServiceInfo, prevConfigs := m.curRolloutID, m.curConfigID, m.serviceInfo, m.trafficSplitConfigs
+	m.curRolloutID = newRolloutID
+	m.curConfigID = configs[0].configID
+	m.serviceInfo = configs[0].serviceInfo
+	m.trafficSplitConfigs = nil
+	if len(configs) > 1 {
+		m.trafficSplitConfigs = configs
+	}
+	if err := m.setSnapshot(); err != nil {
+		m.curRolloutID, m.curConfigID, m.serviceInfo, m.trafficSplitConfigs = prevRolloutID, prevConfigID, prevServiceInfo, prevConfigs
+		return fmt.Errorf("fail to apply rollout %v: %v", newRolloutID, err)
+	}
+	m.trafficSplitRolloutID = newRolloutID
+	m.saveConfigCache(m.serviceName, m.curRolloutID, m.serviceInfo.ServiceConfig())
+	for _, config := range configs {
+		logging.WithFields(logging.Fields{
+			"service_name":    m.serviceName,
+			"rollout_id":      newRolloutID,
+			"config_id":       config.configID,
+			"traffic_percent": config.percentage,
+		}).Infof("serving configuration with %v%% of traffic", config.percentage)
+	}
+	return nil
+}
+
+// trafficSplitServiceInfo returns the ServiceInfo of configID if it is
+// already served, so that it is not fetched again.
+func (m *ConfigManager) trafficSplitServiceInfo(configID string) *configinfo.ServiceInfo {
+	for _, config := range m.trafficSplitConfigs {
+		if config.configID == configID {
+			return config.serviceInfo
+		}
+	}
+	if m.serviceInfo != nil && m.curConfigID == configID {
+		return m.serviceInfo
+	}
+	return nil
+}
+
+func (m *ConfigManager) makeTrafficSplitSnapshot() (*cache.Snapshot, error) {
+	var configs []gen.TrafficSplitConfig
+	for _, config := range m.trafficSplitConfigs {
+		configs = append(configs, gen.TrafficSplitConfig{
+			ServiceInfo: config.serviceInfo,
+			Percentage:  config.percentage,
+		})
+	}
+	m.Infof("making configuration for rollout %v of service %v", m.curRolloutID, m.serviceName)
+
+	var clusterResources, endpoints, runtimes, routes, listenerResources []cache.Resource
+	clusters, err := gen.MakeClustersForTrafficSplit(configs, *RolloutTrafficSplitBasePort)
+	if err != nil {
+		return nil, err
+	}
+	for i := range clusters {
+		clusterResources = append(clusterResources, clusters[i])
+	}
+
+	listeners, err := gen.MakeListenersForTrafficSplit(configs, *RolloutTrafficSplitBasePort)
+	if err != nil {
+		return nil, err
+	}
+	for _, lis := range listeners {
+		listenerResources = append(listenerResources, lis)
+	}
+
+	// Rollout ids are unique, unlike the config ids which may be split differently.
+	snapshot := cache.NewSnapshot(m.curRolloutID, endpoints, clusterResources, routes, listenerResources, runtimes)
+	m.Infof("Envoy Dynamic Configuration is cached for rollout %v of service %v", m.curRolloutID, m.serviceName)
+	return &snapshot, nil
+}
@@ -0,0 +1,155 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package configmanager
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/metadata"
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/options"
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/util"
+	"github.com/envoyproxy/go-control-plane/pkg/cache"
+
+	v2pb "github.com/envoyproxy/go-control-plane/envoy/api/v2"
+	corepb "github.com/envoyproxy/go-control-plane/envoy/api/v2/core"
+)
+
+func TestCheckTrafficSplitRollouts(t *testing.T) {
+	const oldConfigID, newConfigID = "2019-01-01r0", "2019-01-02r0"
+	configs := make(map[string][]byte)
+	for _, configID := range []string{oldConfigID, newConfigID} {
+		config, err := genFakeConfig(fmt.Sprintf(`{"name":"%s","id":"%s","apis":[{"name":"%s"}]}`, testProjectName, configID, testEndpointName))
+		if err != nil {
+			t.Fatalf("fail to generate fake config: %v", err)
+		}
+		configs["/"+configID] = config
+	}
+	configServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		config, ok := configs[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write(config)
+	}))
+	defer configServer.Close()
+	fetchConfigURL = func(serviceName, configID string) string {
+		return configServer.URL + "/" + configID
+	}
+
+	rolloutWithPercentages := func(rolloutID, percentages string) []byte {
+		rollout, err := genFakeRollout(fmt.Sprintf(`{"rollouts":[{"rolloutId":"%s","status":"SUCCESS","trafficPercentStrategy":{"percentages":{%s}},"serviceName":"%s"}]}`, rolloutID, percentages, testProjectName))
+		if err != nil {
+			t.Fatalf("genFakeRollout failed: %v", err)
+		}
+		return rollout
+	}
+	rollout := rolloutWithPercentages("rollout-1", fmt.Sprintf(`"%s":100`, oldConfigID))
+	rolloutServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(rollout)
+	}))
+	defer rolloutServer.Close()
+	fetchRolloutsURL = func(serviceName string) string {
+		return rolloutServer.URL
+	}
+
+	mockMetadataServer := util.InitMockServerFromPathResp(map[string]string{
+		util.AccessTokenSuffix: fakeToken,
+	})
+	defer mockMetadataServer.Close()
+
+	flag.Set("service", testProjectName)
+	flag.Set("rollout_strategy", util.ManagedRolloutStrategy)
+	flag.Set("rollout_traffic_split", "true")
+	flag.Set("check_rollout_interval", "1h")
+	defer func() {
+		flag.Set("rollout_strategy", util.FixedRolloutStrategy)
+		flag.Set("rollout_traffic_split", "false")
+		flag.Set("check_rollout_interval", "60s")
+	}()
+
+	opts := options.DefaultConfigGeneratorOptions()
+	opts.BackendAddress = "http://127.0.0.1:8082"
+	manager, err := NewConfigManager(metadata.NewMockMetadataFetcher(mockMetadataServer.URL, time.Now()), opts)
+	if err != nil {
+		t.Fatalf("NewConfigManager got error: %v", err)
+	}
+
+	testCases := []struct {
+		desc          string
+		rollout       []byte
+		wantConfigID  string
+		wantVersion   string
+		wantListeners []string
+	}{
+		{
+			desc:          "Rollout with one config is served without traffic split",
+			wantConfigID:  oldConfigID,
+			wantVersion:   oldConfigID,
+			wantListeners: []string{"http_listener"},
+		},
+		{
+			desc:          "Rollout with two configs is served with traffic split",
+			rollout:       rolloutWithPercentages("rollout-2", fmt.Sprintf(`"%s":90,"%s":10`, oldConfigID, newConfigID)),
+			wantConfigID:  oldConfigID,
+			wantVersion:   "rollout-2",
+			wantListeners: []string{"http_listener", "http_listener_" + oldConfigID, "http_listener_" + newConfigID},
+		},
+		{
+			desc:          "Rollout with the canary config fully rolled out",
+			rollout:       rolloutWithPercentages("rollout-3", fmt.Sprintf(`"%s":100`, newConfigID)),
+			wantConfigID:  newConfigID,
+			wantVersion:   newConfigID,
+			wantListeners: []string{"http_listener"},
+		},
+	}
+
+	for _, tc := range testCases {
+		if tc.rollout != nil {
+			rollout = tc.rollout
+			if err := manager.checkTrafficSplitRollouts(); err != nil {
+				t.Fatalf("Test Desc(%s): checkTrafficSplitRollouts got error: %v", tc.desc, err)
+			}
+		}
+		if manager.curConfigID != tc.wantConfigID {
+			t.Errorf("Test Desc(%s): got config id %v, want %v", tc.desc, manager.curConfigID, tc.wantConfigID)
+		}
+
+		resp, err := manager.cache.Fetch(context.Background(), v2pb.DiscoveryRequest{
+			Node:    &corepb.Node{Id: opts.Node},
+			TypeUrl: cache.ListenerType,
+		})
+		if err != nil {
+			t.Fatalf("Test Desc(%s): fail to fetch listeners: %v", tc.desc, err)
+		}
+		if resp.Version != tc.wantVersion {
+			t.Errorf("Test Desc(%s): got snapshot version %v, want %v", tc.desc, resp.Version, tc.wantVersion)
+		}
+		var gotListeners []string
+		for _, r := range sortResources(resp) {
+			gotListeners = append(gotListeners, cache.GetResourceName(r))
+		}
+		if !reflect.DeepEqual(gotListeners, tc.wantListeners) {
+			t.Errorf("Test Desc(%s): got listeners %v, want %v", tc.desc, gotListeners, tc.wantListeners)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package configmanager
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/logging"
+)
+
+// WatchTranscodingDescriptor starts checking --transcoding_descriptor_path for
+// changes every --transcoding_descriptor_check_interval. The descriptor set is
+// inlined in the Envoy config, so the service config is applied again when it
+// changes, and the current one keeps being served if the new one is invalid.
+//
+// Only the descriptor set of a single service is watched, without traffic
+// split.
+func (m *ConfigManager) WatchTranscodingDescriptor() {
+	if m.serviceInfo == nil || m.envoyConfigOptions.TranscodingDescriptorPath == "" || *transcodingDescriptorCheckInterval == 0 {
+		return
+	}
+	if len(m.additionalServices) > 0 || len(m.trafficSplitConfigs) > 0 {
+		logging.Warningf("the transcoding descriptor is not checked for changes with multiple services or traffic split")
+		return
+	}
+
+	logging.Infof("start checking the transcoding descriptor every %v", *transcodingDescriptorCheckInterval)
+	go func() {
+		for range time.Tick(*transcodingDescriptorCheckInterval) {
+			if err := m.checkTranscodingDescriptor(); err != nil {
+				logging.Errorf("error occurred when checking the transcoding descriptor, %v", err)
+			}
+		}
+	}()
+}
+
+// checkTranscodingDescriptor applies the service config again if the
+// transcoding descriptor file has changed since it was last applied.
+func (m *ConfigManager) checkTranscodingDescriptor() error {
+	content, err := ioutil.ReadFile(m.envoyConfigOptions.TranscodingDescriptorPath)
+	if err != nil {
+		return fmt.Errorf("fail to read the transcoding descriptor: %v", err)
+	}
+	if bytes.Equal(content, m.serviceInfo.TranscodingDescriptor) {
+		return nil
+	}
+	hash := sha256.Sum256(content)
+	prevHash := m.transcodingDescriptorHash
+	m.transcodingDescriptorHash = hash[:]
+	if err := m.applyServiceConfig(m.serviceInfo.ServiceConfig()); err != nil {
+		m.transcodingDescriptorHash = prevHash
+		return err
+	}
+	logging.WithFields(m.logFields()).Infof("applied the changed transcoding descriptor")
+	return nil
+}
@@ -0,0 +1,128 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package configmanager
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/options"
+	"github.com/golang/protobuf/proto"
+
+	descpb "github.com/golang/protobuf/protoc-gen-go/descriptor"
+)
+
+func TestCheckTranscodingDescriptor(t *testing.T) {
+	dir, err := ioutil.TempDir("", "transcoding_descriptor_watcher")
+	if err != nil {
+		t.Fatalf("fail to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	descriptorPath := filepath.Join(dir, "api_descriptor.pb")
+	servicePath := filepath.Join(dir, "service.json")
+
+	makeDescriptor := func(services ...string) []byte {
+		file := &descpb.FileDescriptorProto{
+			Name:    proto.String("bookstore.proto"),
+			Package: proto.String("endpoints.examples.bookstore"),
+		}
+		for _, service := range services {
+			file.Service = append(file.Service, &descpb.ServiceDescriptorProto{
+				Name: proto.String(service),
+			})
+		}
+		content, err := proto.Marshal(&descpb.FileDescriptorSet{
+			File: []*descpb.FileDescriptorProto{file},
+		})
+		if err != nil {
+			t.Fatalf("fail to marshal descriptor set: %v", err)
+		}
+		return content
+	}
+	writeDescriptor := func(content []byte) {
+		if err := ioutil.WriteFile(descriptorPath, content, 0644); err != nil {
+			t.Fatalf("fail to write descriptor file: %v", err)
+		}
+	}
+	writeDescriptor(makeDescriptor("Bookstore"))
+	serviceConfig := fmt.Sprintf(`{"name":"%s","id":"%s","apis":[{"name":"endpoints.examples.bookstore.Bookstore"}]}`,
+		testProjectName, testConfigID)
+	if err := ioutil.WriteFile(servicePath, []byte(serviceConfig), 0644); err != nil {
+		t.Fatalf("fail to write service config file: %v", err)
+	}
+
+	flag.Set("service_json_path", servicePath)
+	defer flag.Set("service_json_path", "")
+
+	opts := options.DefaultConfigGeneratorOptions()
+	opts.BackendAddress = "grpc://127.0.0.1:8082"
+	opts.TranscodingDescriptorPath = descriptorPath
+	manager, err := NewConfigManager(nil, opts)
+	if err != nil {
+		t.Fatalf("fail to initialize Config Manager: %v", err)
+	}
+
+	testCases := []struct {
+		desc           string
+		content        []byte
+		wantErr        bool
+		wantDescriptor []byte
+		wantNewVersion bool
+	}{
+		{
+			desc:           "Unchanged descriptor keeps the snapshot",
+			content:        makeDescriptor("Bookstore"),
+			wantDescriptor: makeDescriptor("Bookstore"),
+		},
+		{
+			desc:           "Changed descriptor updates the snapshot",
+			content:        makeDescriptor("Bookstore", "Shelves"),
+			wantDescriptor: makeDescriptor("Bookstore", "Shelves"),
+			wantNewVersion: true,
+		},
+		{
+			desc:           "Descriptor missing an API keeps the previous snapshot",
+			content:        makeDescriptor("Shelves"),
+			wantErr:        true,
+			wantDescriptor: makeDescriptor("Bookstore", "Shelves"),
+		},
+		{
+			desc:           "Invalid descriptor keeps the previous snapshot",
+			content:        []byte("rawDescriptor"),
+			wantErr:        true,
+			wantDescriptor: makeDescriptor("Bookstore", "Shelves"),
+		},
+	}
+
+	for _, tc := range testCases {
+		prevVersion := manager.snapshotVersion()
+		writeDescriptor(tc.content)
+
+		err := manager.checkTranscodingDescriptor()
+		if (err != nil) != tc.wantErr {
+			t.Errorf("Test Desc(%s): got error %v, want error %v", tc.desc, err, tc.wantErr)
+		}
+		if got := manager.serviceInfo.TranscodingDescriptor; string(got) != string(tc.wantDescriptor) {
+			t.Errorf("Test Desc(%s): got descriptor %q, want %q", tc.desc, got, tc.wantDescriptor)
+		}
+		if version := manager.snapshotVersion(); (version != prevVersion) != tc.wantNewVersion {
+			t.Errorf("Test Desc(%s): got snapshot version %v, previous version %v, want new version %v", tc.desc, version, prevVersion, tc.wantNewVersion)
+		}
+	}
+}
@@ -0,0 +1,206 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package logging logs with severity and structured fields, either as glog
+// text or as JSON records that Cloud Logging ingests with their severity,
+// source location and labels.
+package logging
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+const (
+	TextFormat = "text"
+	JSONFormat = "json"
+)
+
+type level int
+
+const (
+	debugLevel level = iota
+	infoLevel
+	warningLevel
+	errorLevel
+)
+
+var levels = map[string]level{
+	"debug":   debugLevel,
+	"info":    infoLevel,
+	"warning": warningLevel,
+	"error":   errorLevel,
+}
+
+var severities = map[level]string{
+	debugLevel:   "DEBUG",
+	infoLevel:    "INFO",
+	warningLevel: "WARNING",
+	errorLevel:   "ERROR",
+}
+
+// labelFields are also sent as Cloud Logging labels, so logs can be filtered
+// by service, rollout and configuration.
+var labelFields = []string{"service_name", "rollout_id", "config_id"}
+
+var (
+	mu       sync.Mutex
+	format   = TextFormat
+	minLevel = infoLevel
+
+	// Allows for unit tests to capture the JSON records.
+	output  io.Writer = os.Stderr
+	timeNow           = time.Now
+	exit              = func() { os.Exit(1) }
+)
+
+// Fields are the structured fields of a log record.
+type Fields map[string]interface{}
+
+// Entry logs records with a set of fields.
+type Entry struct {
+	fields Fields
+}
+
+// Init sets the log format, "text" or "json", and the min log level, one of
+// "debug", "info", "warning" and "error".
+func Init(logFormat, logLevel string) error {
+	if logFormat != TextFormat && logFormat != JSONFormat {
+		return fmt.Errorf(`log format must be either "%s" or "%s", got %q`, TextFormat, JSONFormat, logFormat)
+	}
+	l, ok := levels[strings.ToLower(logLevel)]
+	if !ok {
+		return fmt.Errorf(`log level must be one of "debug", "info", "warning" and "error", got %q`, logLevel)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	format, minLevel = logFormat, l
+	return nil
+}
+
+// WithFields returns an Entry logging records with fields.
+func WithFields(fields Fields) *Entry {
+	return &Entry{fields: fields}
+}
+
+func (e *Entry) Debugf(f string, args ...interface{})   { e.log(debugLevel, f, args...) }
+func (e *Entry) Infof(f string, args ...interface{})    { e.log(infoLevel, f, args...) }
+func (e *Entry) Warningf(f string, args ...interface{}) { e.log(warningLevel, f, args...) }
+func (e *Entry) Errorf(f string, args ...interface{})   { e.log(errorLevel, f, args...) }
+
+func Debugf(f string, args ...interface{})   { (&Entry{}).log(debugLevel, f, args...) }
+func Infof(f string, args ...interface{})    { (&Entry{}).log(infoLevel, f, args...) }
+func Warningf(f string, args ...interface{}) { (&Entry{}).log(warningLevel, f, args...) }
+func Errorf(f string, args ...interface{})   { (&Entry{}).log(errorLevel, f, args...) }
+
+// Exitf logs an error and exits with status 1.
+func Exitf(f string, args ...interface{}) {
+	(&Entry{}).log(errorLevel, f, args...)
+	glog.Flush()
+	exit()
+}
+
+// log is called by the exported functions, so the caller to report is 2
+// frames up.
+func (e *Entry) log(l level, f string, args ...interface{}) {
+	mu.Lock()
+	defer mu.Unlock()
+	if l < minLevel {
+		return
+	}
+	msg := fmt.Sprintf(f, args...)
+
+	if format == TextFormat {
+		msg += textFields(e.fields)
+		switch l {
+		case warningLevel:
+			glog.WarningDepth(2, msg)
+		case errorLevel:
+			glog.ErrorDepth(2, msg)
+		default:
+			glog.InfoDepth(2, msg)
+		}
+		return
+	}
+
+	record := make(map[string]interface{}, len(e.fields)+4)
+	labels := make(map[string]string)
+	for name, value := range e.fields {
+		if d, ok := value.(time.Duration); ok {
+			value = formatDuration(d)
+		}
+		record[name] = value
+	}
+	for _, name := range labelFields {
+		if value, ok := e.fields[name].(string); ok && value != "" {
+			labels[name] = value
+		}
+	}
+	if len(labels) > 0 {
+		record["logging.googleapis.com/labels"] = labels
+	}
+	if _, file, line, ok := runtime.Caller(2); ok {
+		record["logging.googleapis.com/sourceLocation"] = map[string]string{
+			"file": filepath.Base(file),
+			"line": strconv.Itoa(line),
+		}
+	}
+	record["time"] = timeNow().UTC().Format(time.RFC3339Nano)
+	record["severity"] = severities[l]
+	record["message"] = msg
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		data, _ = json.Marshal(map[string]string{
+			"severity": severities[l],
+			"message":  fmt.Sprintf("%s (fail to marshal log fields: %v)", msg, err),
+		})
+	}
+	output.Write(append(data, '\n'))
+}
+
+// textFields formats fields as sorted " name=value" pairs.
+func textFields(fields Fields) string {
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	for _, name := range names {
+		value := fields[name]
+		if d, ok := value.(time.Duration); ok {
+			value = formatDuration(d)
+		}
+		fmt.Fprintf(&b, " %s=%v", name, value)
+	}
+	return b.String()
+}
+
+// formatDuration formats durations in seconds, as google.protobuf.Duration in
+// JSON.
+func formatDuration(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', -1, 64) + "s"
+}
@@ -0,0 +1,161 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logging
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/util"
+)
+
+func TestJSONFormat(t *testing.T) {
+	testData := []struct {
+		desc     string
+		logLevel string
+		log      func()
+		want     string
+	}{
+		{
+			desc:     "Info record with fields and labels",
+			logLevel: "info",
+			log: func() {
+				WithFields(Fields{
+					"service_name":   "bookstore.endpoints.cloudesf-testing.cloud.goog",
+					"config_id":      "2019-12-16r0",
+					"fetch_duration": 1500 * time.Millisecond,
+				}).Infof("fetched service config")
+			},
+			want: `{
+  "time": "2019-12-16T01:02:03Z",
+  "severity": "INFO",
+  "message": "fetched service config",
+  "service_name": "bookstore.endpoints.cloudesf-testing.cloud.goog",
+  "config_id": "2019-12-16r0",
+  "fetch_duration": "1.5s",
+  "logging.googleapis.com/labels": {
+    "service_name": "bookstore.endpoints.cloudesf-testing.cloud.goog",
+    "config_id": "2019-12-16r0"
+  },
+  "logging.googleapis.com/sourceLocation": {
+    "file": "logging_test.go",
+    "line": "42"
+  }
+}`,
+		},
+		{
+			desc:     "Warning record without fields",
+			logLevel: "info",
+			log: func() {
+				Warningf("fail to pull rollout notifications: %v", "timeout")
+			},
+			want: `{
+  "time": "2019-12-16T01:02:03Z",
+  "severity": "WARNING",
+  "message": "fail to pull rollout notifications: timeout",
+  "logging.googleapis.com/sourceLocation": {
+    "file": "logging_test.go",
+    "line": "65"
+  }
+}`,
+		},
+		{
+			desc:     "Records below the log level are dropped",
+			logLevel: "warning",
+			log: func() {
+				Infof("dropped")
+				Debugf("dropped")
+			},
+		},
+	}
+
+	defer func() {
+		output, timeNow = os.Stderr, time.Now
+		Init(TextFormat, "info")
+	}()
+	timeNow = func() time.Time {
+		return time.Date(2019, 12, 16, 1, 2, 3, 0, time.UTC)
+	}
+	for _, tc := range testData {
+		var buf bytes.Buffer
+		output = &buf
+		if err := Init(JSONFormat, tc.logLevel); err != nil {
+			t.Fatal(err)
+		}
+		tc.log()
+
+		if tc.want == "" {
+			if buf.Len() != 0 {
+				t.Errorf("Test Desc(%s): got records %s, want none", tc.desc, buf.String())
+			}
+			continue
+		}
+		if err := util.JsonEqual(tc.want, buf.String()); err != nil {
+			t.Errorf("Test Desc(%s): %v", tc.desc, err)
+		}
+	}
+}
+
+func TestInit(t *testing.T) {
+	testData := []struct {
+		desc      string
+		logFormat string
+		logLevel  string
+		wantError string
+	}{
+		{
+			desc:      "Success, level is case insensitive",
+			logFormat: "json",
+			logLevel:  "DEBUG",
+		},
+		{
+			desc:      "Failure, unknown format",
+			logFormat: "xml",
+			logLevel:  "info",
+			wantError: `log format must be either "text" or "json", got "xml"`,
+		},
+		{
+			desc:      "Failure, unknown level",
+			logFormat: "text",
+			logLevel:  "fatal",
+			wantError: `log level must be one of "debug", "info", "warning" and "error", got "fatal"`,
+		},
+	}
+
+	defer Init(TextFormat, "info")
+	for _, tc := range testData {
+		err := Init(tc.logFormat, tc.logLevel)
+		if tc.wantError == "" {
+			if err != nil {
+				t.Errorf("Test Desc(%s): got error %v", tc.desc, err)
+			}
+			continue
+		}
+		if err == nil || err.Error() != tc.wantError {
+			t.Errorf("Test Desc(%s): got error %v, want %v", tc.desc, err, tc.wantError)
+		}
+	}
+}
+
+func TestTextFields(t *testing.T) {
+	got := textFields(Fields{"service_name": "bookstore", "fetch_duration": 250 * time.Millisecond})
+	want := " fetch_duration=0.25s service_name=bookstore"
+	if !strings.EqualFold(got, want) {
+		t.Errorf("textFields got %q, want %q", got, want)
+	}
+}
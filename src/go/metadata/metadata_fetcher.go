@@ -19,6 +19,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -47,11 +48,18 @@ type metadataTokenResponse struct {
 type MetadataFetcher struct {
 	client  http.Client
 	baseUrl string
+	headers map[string]string
 	timeNow func() time.Time
 
-	mux sync.Mutex
-	// metadata updates and stores Metadata from GCE.
-	tokenInfo tokenInfo
+	tokenURL       string
+	tokenHeader    string
+	tokenTTLHeader string
+	tokenTTL       time.Duration
+	// Session token from tokenURL.
+	sessionToken util.TokenCache
+
+	// Access token of the default service account.
+	accessToken util.TokenCache
 	// audience -> tokenInfo.
 	audToToken sync.Map
 }
@@ -61,10 +69,16 @@ var (
 	NewMetadataFetcher = func(opts options.CommonOptions) *MetadataFetcher {
 		return &MetadataFetcher{
 			client: http.Client{
-				Timeout: opts.HttpRequestTimeout,
+				Transport: &http.Transport{Proxy: util.Proxy},
+				Timeout:   opts.HttpRequestTimeout,
 			},
-			baseUrl: opts.MetadataURL,
-			timeNow: time.Now,
+			baseUrl:        opts.MetadataURL,
+			headers:        opts.MetadataHeaders,
+			timeNow:        time.Now,
+			tokenURL:       opts.MetadataTokenURL,
+			tokenHeader:    opts.MetadataTokenHeader,
+			tokenTTLHeader: opts.MetadataTokenTTLHeader,
+			tokenTTL:       opts.MetadataTokenTTL,
 		}
 	}
 )
@@ -76,6 +90,16 @@ func (mf *MetadataFetcher) createUrl(suffix string) string {
 func (mf *MetadataFetcher) getMetadata(path string) ([]byte, error) {
 	req, _ := http.NewRequest("GET", path, nil)
 	req.Header.Add("Metadata-Flavor", "Google")
+	for name, value := range mf.headers {
+		req.Header.Set(name, value)
+	}
+	if mf.tokenURL != "" {
+		token, _, err := mf.sessionToken.Token(mf.timeNow(), mf.fetchSessionToken)
+		if err != nil {
+			return nil, fmt.Errorf("fail to fetch metadata session token: %v", err)
+		}
+		req.Header.Set(mf.tokenHeader, token)
+	}
 	resp, err := mf.client.Do(req)
 	if err != nil {
 		return nil, err
@@ -87,16 +111,36 @@ func (mf *MetadataFetcher) getMetadata(path string) ([]byte, error) {
 	return ioutil.ReadAll(resp.Body)
 }
 
-func (mf *MetadataFetcher) FetchAccessToken() (string, time.Duration, error) {
-	now := mf.timeNow()
-	// Follow the similar logic as GCE metadata server, where returned token will be valid for at
-	// least 60s.
-	mf.mux.Lock()
-	defer mf.mux.Unlock()
-	if mf.tokenInfo.accessToken != "" && !now.After(mf.tokenInfo.tokenTimeout.Add(-time.Second*60)) {
-		return mf.tokenInfo.accessToken, mf.tokenInfo.tokenTimeout.Sub(now), nil
+// fetchSessionToken fetches a session token from tokenURL, which is required
+// on requests to metadata servers such as AWS IMDSv2.
+func (mf *MetadataFetcher) fetchSessionToken() (string, time.Duration, error) {
+	req, _ := http.NewRequest("PUT", mf.tokenURL, nil)
+	for name, value := range mf.headers {
+		req.Header.Set(name, value)
+	}
+	req.Header.Set(mf.tokenTTLHeader, strconv.Itoa(int(mf.tokenTTL.Seconds())))
+	resp, err := mf.client.Do(req)
+	if err != nil {
+		return "", 0, err
 	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", 0, fmt.Errorf("failed fetching session token: %v, status code %v", mf.tokenURL, resp.StatusCode)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", 0, err
+	}
+	return strings.TrimSpace(string(body)), mf.tokenTTL, nil
+}
 
+// FetchAccessToken returns the access token of the default service account,
+// refreshed proactively before it expires.
+func (mf *MetadataFetcher) FetchAccessToken() (string, time.Duration, error) {
+	return mf.accessToken.Token(mf.timeNow(), mf.fetchAccessToken)
+}
+
+func (mf *MetadataFetcher) fetchAccessToken() (string, time.Duration, error) {
 	tokenBody, err := mf.getMetadata(mf.createUrl(util.AccessTokenSuffix))
 	if err != nil {
 		return "", 0, err
@@ -106,11 +150,7 @@ func (mf *MetadataFetcher) FetchAccessToken() (string, time.Duration, error) {
 	if err = json.Unmarshal(tokenBody, &resp); err != nil {
 		return "", 0, err
 	}
-
-	expires := time.Duration(resp.ExpiresIn) * time.Second
-	mf.tokenInfo.accessToken = resp.AccessToken
-	mf.tokenInfo.tokenTimeout = now.Add(expires)
-	return mf.tokenInfo.accessToken, expires, nil
+	return resp.AccessToken, time.Duration(resp.ExpiresIn) * time.Second, nil
 }
 
 // TODO(kyuc): perhaps we need some retry logic and timeout?
@@ -15,6 +15,8 @@
 package metadata
 
 import (
+	"net/http"
+	"net/http/httptest"
 	"strings"
 	"testing"
 	"time"
@@ -54,7 +56,7 @@ func TestFetchAccountTokenExpired(t *testing.T) {
 	mf := NewMockMetadataFetcher(ts.GetURL(), fakeNow)
 
 	// Make sure the accessToken is empty before the test.
-	mf.tokenInfo.accessToken = ""
+	mf.accessToken.Set("", time.Time{})
 
 	testData := []testToken{
 		{
@@ -72,9 +74,16 @@ func TestFetchAccountTokenExpired(t *testing.T) {
 		{
 			desc:               "token is not expired in metadata",
 			curToken:           "ya29.nonexpired",
-			curTokenTimeout:    fakeNow.Add(61 * time.Second),
+			curTokenTimeout:    fakeNow.Add(6 * time.Minute),
 			expectedToken:      "ya29.nonexpired",
-			expectedExpiration: 61 * time.Second,
+			expectedExpiration: 6 * time.Minute,
+		},
+		{
+			desc:               "token expiring within 5 minutes is refreshed proactively",
+			curToken:           "ya29.nonexpired",
+			curTokenTimeout:    fakeNow.Add(4 * time.Minute),
+			expectedToken:      "ya29.new",
+			expectedExpiration: 3599 * time.Second,
 		},
 		{
 			desc:               "token valid time is below 60 seconds in metadata",
@@ -86,8 +95,7 @@ func TestFetchAccountTokenExpired(t *testing.T) {
 	}
 	for i, tc := range testData {
 		if tc.curToken != "" {
-			mf.tokenInfo.accessToken = tc.curToken
-			mf.tokenInfo.tokenTimeout = tc.curTokenTimeout
+			mf.accessToken.Set(tc.curToken, tc.curTokenTimeout)
 		}
 		token, expires, err := mf.FetchAccessToken()
 		if err != nil {
@@ -332,3 +340,52 @@ func TestMetadataFetcherTimeout(t *testing.T) {
 		t.Errorf("TestMetadataFetcherTimeout: the metadata fetcher get the config but should get timeout error")
 	}
 }
+
+func TestFetchWithSessionToken(t *testing.T) {
+	tokenFetches := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Proxy-Auth") != "secret" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		if r.URL.Path == "/latest/api/token" {
+			if r.Method != "PUT" || r.Header.Get("X-aws-ec2-metadata-token-ttl-seconds") != "21600" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			tokenFetches++
+			w.Write([]byte("session-token\n"))
+			return
+		}
+		if r.Header.Get("X-aws-ec2-metadata-token") != "session-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(fakeServiceName))
+	}))
+	defer ts.Close()
+
+	opts := options.DefaultCommonOptions()
+	opts.MetadataURL = ts.URL
+	opts.MetadataHeaders = map[string]string{"X-Proxy-Auth": "secret"}
+	opts.MetadataTokenURL = ts.URL + "/latest/api/token"
+	mf := NewMetadataFetcher(opts)
+
+	for i := 0; i < 2; i++ {
+		name, err := mf.FetchServiceName()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if name != fakeServiceName {
+			t.Errorf("fetchServiceName = %s, want %s", name, fakeServiceName)
+		}
+	}
+	if tokenFetches != 1 {
+		t.Errorf("got %d session token fetches, want 1", tokenFetches)
+	}
+
+	opts.MetadataHeaders = nil
+	if _, err := NewMetadataFetcher(opts).FetchServiceName(); err == nil || !strings.Contains(err.Error(), "fail to fetch metadata session token") {
+		t.Errorf("expected session token error, got %v", err)
+	}
+}
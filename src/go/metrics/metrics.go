@@ -0,0 +1,223 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package metrics keeps counters, gauges and histograms, and serves them in
+// the Prometheus text exposition format.
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultLatencyBuckets are the upper bounds in seconds of the histogram
+// buckets for the latency of remote calls.
+var DefaultLatencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+type metric interface {
+	write(b *bytes.Buffer)
+}
+
+// Registry serves the metrics registered to it on ServeHTTP.
+type Registry struct {
+	mu      sync.Mutex
+	metrics []metric
+}
+
+// NewRegistry creates an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) register(m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.metrics = append(r.metrics, m)
+}
+
+// ServeHTTP writes all metrics in the Prometheus text exposition format.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	var b bytes.Buffer
+	r.mu.Lock()
+	for _, m := range r.metrics {
+		m.write(&b)
+	}
+	r.mu.Unlock()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write(b.Bytes())
+}
+
+// vec keeps the values of a metric by label values.
+type vec struct {
+	name       string
+	help       string
+	typ        string
+	labelNames []string
+
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+func newVec(r *Registry, name, help, typ string, labelNames []string) *vec {
+	v := &vec{
+		name:       name,
+		help:       help,
+		typ:        typ,
+		labelNames: labelNames,
+		values:     make(map[string]float64),
+	}
+	r.register(v)
+	return v
+}
+
+func (v *vec) update(labelValues []string, f func(cur float64) float64) {
+	if len(labelValues) != len(v.labelNames) {
+		panic(fmt.Sprintf("metric %s has labels %v, got values %v", v.name, v.labelNames, labelValues))
+	}
+	key := labels(v.labelNames, labelValues)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.values[key] = f(v.values[key])
+}
+
+func (v *vec) write(b *bytes.Buffer) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	writeHeader(b, v.name, v.help, v.typ)
+	keys := make([]string, 0, len(v.values))
+	for key := range v.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(b, "%s%s %s\n", v.name, key, formatFloat(v.values[key]))
+	}
+}
+
+// Counter is a metric that only increases, with optional labels.
+type Counter struct {
+	v *vec
+}
+
+// NewCounter creates a Counter in r.
+func (r *Registry) NewCounter(name, help string, labelNames ...string) *Counter {
+	return &Counter{v: newVec(r, name, help, "counter", labelNames)}
+}
+
+// Inc increments the counter with the label values, in the order of the label
+// names.
+func (c *Counter) Inc(labelValues ...string) {
+	c.v.update(labelValues, func(cur float64) float64 { return cur + 1 })
+}
+
+// Gauge is a metric that is set to any value, with optional labels.
+type Gauge struct {
+	v *vec
+}
+
+// NewGauge creates a Gauge in r.
+func (r *Registry) NewGauge(name, help string, labelNames ...string) *Gauge {
+	return &Gauge{v: newVec(r, name, help, "gauge", labelNames)}
+}
+
+// Set sets the gauge with the label values to value.
+func (g *Gauge) Set(value float64, labelValues ...string) {
+	g.v.update(labelValues, func(float64) float64 { return value })
+}
+
+// Add adds delta to the gauge with the label values.
+func (g *Gauge) Add(delta float64, labelValues ...string) {
+	g.v.update(labelValues, func(cur float64) float64 { return cur + delta })
+}
+
+// Histogram counts observations in buckets, without labels.
+type Histogram struct {
+	name    string
+	help    string
+	buckets []float64
+
+	mu     sync.Mutex
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// NewHistogram creates a Histogram in r, with the upper bounds of the buckets
+// in increasing order.
+func (r *Registry) NewHistogram(name, help string, buckets []float64) *Histogram {
+	h := &Histogram{
+		name:    name,
+		help:    help,
+		buckets: buckets,
+		counts:  make([]uint64, len(buckets)),
+	}
+	r.register(h)
+	return h
+}
+
+// Observe adds an observation to the histogram.
+func (h *Histogram) Observe(value float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, bound := range h.buckets {
+		if value <= bound {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += value
+}
+
+func (h *Histogram) write(b *bytes.Buffer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	writeHeader(b, h.name, h.help, "histogram")
+	for i, bound := range h.buckets {
+		fmt.Fprintf(b, "%s_bucket{le=\"%s\"} %d\n", h.name, formatFloat(bound), h.counts[i])
+	}
+	fmt.Fprintf(b, "%s_bucket{le=\"+Inf\"} %d\n", h.name, h.count)
+	fmt.Fprintf(b, "%s_sum %s\n", h.name, formatFloat(h.sum))
+	fmt.Fprintf(b, "%s_count %d\n", h.name, h.count)
+}
+
+func writeHeader(b *bytes.Buffer, name, help, typ string) {
+	fmt.Fprintf(b, "# HELP %s %s\n", name, strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help))
+	fmt.Fprintf(b, "# TYPE %s %s\n", name, typ)
+}
+
+// labels formats the labels as {name="value",...}, or "" without labels.
+func labels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	escaper := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = fmt.Sprintf(`%s="%s"`, name, escaper.Replace(values[i]))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatFloat(f float64) string {
+	if math.IsInf(f, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
@@ -0,0 +1,75 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry()
+	fetches := r.NewCounter("config_fetches_total", "Number of service config fetches.", "result")
+	snapshots := r.NewCounter("snapshot_updates_total", "Number of snapshot updates.")
+	streams := r.NewGauge("ads_streams", "Number of open ADS streams.")
+	latency := r.NewHistogram("config_fetch_duration_seconds", "Latency of service config fetches.", []float64{0.1, 1})
+
+	fetches.Inc("success")
+	fetches.Inc("success")
+	fetches.Inc(`fail"ure`)
+	snapshots.Inc()
+	streams.Add(2)
+	streams.Add(-1)
+	latency.Observe(0.05)
+	latency.Observe(0.5)
+	latency.Observe(3)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+
+	want := `# HELP config_fetches_total Number of service config fetches.
+# TYPE config_fetches_total counter
+config_fetches_total{result="fail\"ure"} 1
+config_fetches_total{result="success"} 2
+# HELP snapshot_updates_total Number of snapshot updates.
+# TYPE snapshot_updates_total counter
+snapshot_updates_total 1
+# HELP ads_streams Number of open ADS streams.
+# TYPE ads_streams gauge
+ads_streams 1
+# HELP config_fetch_duration_seconds Latency of service config fetches.
+# TYPE config_fetch_duration_seconds histogram
+config_fetch_duration_seconds_bucket{le="0.1"} 1
+config_fetch_duration_seconds_bucket{le="1"} 2
+config_fetch_duration_seconds_bucket{le="+Inf"} 3
+config_fetch_duration_seconds_sum 3.55
+config_fetch_duration_seconds_count 3
+`
+	if got := w.Body.String(); got != want {
+		t.Errorf("got metrics:\n%s\nwant:\n%s", got, want)
+	}
+	if got := w.Header().Get("Content-Type"); got != "text/plain; version=0.0.4" {
+		t.Errorf("got Content-Type %q", got)
+	}
+}
+
+func TestCounterWithWrongLabels(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Errorf("expected panic for missing label values")
+		}
+	}()
+	NewRegistry().NewCounter("config_fetches_total", "Number of service config fetches.", "result").Inc()
+}
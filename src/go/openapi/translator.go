@@ -0,0 +1,1196 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package openapi translates OpenAPI 3.x documents in JSON to the service
+// config, the same way gcloud translates OpenAPI 2.0 documents, so that the
+// config generator can be used without Service Management.
+//
+// Paths, security schemes with the x-google-issuer, x-google-jwks_uri and
+// x-google-audiences extensions, and the x-google-backend,
+// x-google-endpoints, x-google-jwt-requires, x-google-management and
+// x-google-quota extensions are supported.
+// Other parts of the document, like schemas, are ignored. The
+// x-google-authorization, x-google-ext-authz-disabled, x-google-rate-limit,
+// x-google-backend-split, x-google-backend-mirror, x-google-fault-injection,
+// x-google-direct-response, x-google-backend-retry, x-google-headers, x-google-path-rewrite,
+// x-google-cors and x-google-ip-acl extensions, and the circuit_breaker, outlier_detection, health_check,
+// load_balancing and failover_addresses of the x-google-backend extensions,
+// are not part of the service config, and are applied to the config
+// generator options instead.
+//
+// Like gcloud, any of the JWT security schemes of an operation is accepted by
+// default. With x-google-jwt-requires set to "all", on the document or an
+// operation, all the JWT security schemes of a security requirement are
+// required, as specified by OpenAPI.
+package openapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/options"
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/util"
+
+	annotationspb "google.golang.org/genproto/googleapis/api/annotations"
+	metricpb "google.golang.org/genproto/googleapis/api/metric"
+	confpb "google.golang.org/genproto/googleapis/api/serviceconfig"
+	apipb "google.golang.org/genproto/protobuf/api"
+)
+
+const (
+	serviceControlEnvironment = "servicecontrol.googleapis.com"
+)
+
+var (
+	// Methods of a path item in the order of the generated operations.
+	httpMethods = []string{"get", "put", "post", "delete", "options", "head", "patch", "trace"}
+
+	invalidNameChars = regexp.MustCompile(`[^A-Za-z0-9_]`)
+)
+
+type document struct {
+	OpenAPI       string                                `json:"openapi"`
+	Info          info                                  `json:"info"`
+	Servers       []server                              `json:"servers"`
+	Paths         map[string]map[string]json.RawMessage `json:"paths"`
+	Components    components                            `json:"components"`
+	Security      []map[string][]string                 `json:"security"`
+	Backend       *backend                              `json:"x-google-backend"`
+	Endpoints     []endpoint                            `json:"x-google-endpoints"`
+	JwtRequires   string                                `json:"x-google-jwt-requires"`
+	Authorization *authorization                        `json:"x-google-authorization"`
+	// Quota metrics and limits of the service.
+	Management *management `json:"x-google-management"`
+	// Limit of the requests to all the operations.
+	RateLimit *rateLimit `json:"x-google-rate-limit"`
+	// Retry policy of the operations without their own.
+	BackendRetry *backendRetry `json:"x-google-backend-retry"`
+	// Header rule of all the requests.
+	Headers *headerRule `json:"x-google-headers"`
+	// CORS policy of the operations without their own.
+	Cors *corsPolicy `json:"x-google-cors"`
+	// IP ACL of the operations without their own.
+	IpAcl *ipAcl `json:"x-google-ip-acl"`
+	// Custom labels of the reported operations, by label name, like
+	// {"tenant_id": "header:x-tenant-id"}.
+	ReportLabels map[string]string `json:"x-google-report-labels"`
+}
+
+type info struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+type server struct {
+	URL string `json:"url"`
+}
+
+type components struct {
+	SecuritySchemes map[string]securityScheme `json:"securitySchemes"`
+}
+
+type securityScheme struct {
+	Type      string `json:"type"`
+	Name      string `json:"name"`
+	In        string `json:"in"`
+	Scheme    string `json:"scheme"`
+	Issuer    string `json:"x-google-issuer"`
+	JwksURI   string `json:"x-google-jwks_uri"`
+	Audiences string `json:"x-google-audiences"`
+}
+
+type operation struct {
+	OperationID   string                 `json:"operationId"`
+	Security      *[]map[string][]string `json:"security"`
+	Backend       *backend               `json:"x-google-backend"`
+	JwtRequires   string                 `json:"x-google-jwt-requires"`
+	Authorization *authorization         `json:"x-google-authorization"`
+	// If true, the operation is not checked by the external authorization
+	// server.
+	ExtAuthzDisabled bool          `json:"x-google-ext-authz-disabled"`
+	RateLimit        *rateLimit    `json:"x-google-rate-limit"`
+	BackendSplit     *backendSplit `json:"x-google-backend-split"`
+	BackendRetry     *backendRetry `json:"x-google-backend-retry"`
+	Headers          *headerRule   `json:"x-google-headers"`
+	PathRewrite      *pathRewrite  `json:"x-google-path-rewrite"`
+	Cors             *corsPolicy   `json:"x-google-cors"`
+	IpAcl            *ipAcl        `json:"x-google-ip-acl"`
+	// Quota metric costs of the operation.
+	Quota *quota `json:"x-google-quota"`
+
+	BackendMirror  *backendMirror  `json:"x-google-backend-mirror"`
+	FaultInjection *faultInjection `json:"x-google-fault-injection"`
+	DirectResponse *directResponse `json:"x-google-direct-response"`
+}
+
+type backend struct {
+	Address         string  `json:"address"`
+	PathTranslation string  `json:"path_translation"`
+	JwtAudience     string  `json:"jwt_audience"`
+	DisableAuth     bool    `json:"disable_auth"`
+	Deadline        float64 `json:"deadline"`
+	Protocol        string  `json:"protocol"`
+	// Circuit breaker thresholds, outlier detection, health check and load
+	// balancing policy of the backend, like
+	// {"circuit_breaker": {"max_pending_requests": 100},
+	// "outlier_detection": {"consecutive_5xx": 5},
+	// "health_check": {"path": "/healthz"},
+	// "load_balancing": {"policy": "ring_hash", "hash_cookie": "session"}},
+	// the same for all the x-google-backend extensions with the same host and
+	// port.
+	CircuitBreaker   *options.CircuitBreakerOptions   `json:"circuit_breaker"`
+	OutlierDetection *options.OutlierDetectionOptions `json:"outlier_detection"`
+	HealthCheck      *options.HealthCheckOptions      `json:"health_check"`
+	LoadBalancing    *options.LoadBalancingOptions    `json:"load_balancing"`
+	// Addresses of the same backend in other regions, like
+	// ["https://foo-12345-ew.a.run.app"], which receive the requests in order
+	// when the backend is unhealthy.
+	FailoverAddresses []string `json:"failover_addresses"`
+	// Delegation chain of the service accounts used to fetch the ID tokens
+	// of the backend from IAM, like ["sa@project.iam.gserviceaccount.com"].
+	IamDelegates []string `json:"iam_delegates"`
+}
+
+// authorization only allows the requests whose verified JWTs match one of the
+// policies, like {"allow": [{"claims": {"roles": ["admin"]}}]}.
+type authorization struct {
+	Allow []*options.AuthorizationPolicyOptions `json:"allow"`
+}
+
+// rateLimit is a token-bucket limit of the requests, like
+// {"requests_per_second": 10, "burst": 20}.
+type rateLimit struct {
+	RequestsPerSecond uint32 `json:"requests_per_second"`
+	Burst             uint32 `json:"burst"`
+}
+
+// backendSplit splits the requests of an operation between weighted backends,
+// like {"backends": [{"name": "v1", "address": "https://v1.example.com",
+// "weight": 90}, {"name": "v2", "address": "https://v2.example.com",
+// "weight": 10}]}.
+type backendSplit struct {
+	Backends []*options.WeightedBackendOptions `json:"backends"`
+}
+
+// backendMirror mirrors a fraction of the requests of an operation to a
+// secondary backend, like {"address": "https://v2.example.com",
+// "fraction": 0.1}, all of them if the fraction is not set.
+type backendMirror struct {
+	Address  string  `json:"address"`
+	Fraction float64 `json:"fraction"`
+}
+
+// faultInjection injects faults in a percentage of the requests of an
+// operation with the --fault_injection_header, like
+// {"delay": {"percent": 10, "duration": 2}, "abort": {"percent": 5, "status": 503}}.
+type faultInjection struct {
+	Delay *options.FaultDelayOptions `json:"delay"`
+	Abort *options.FaultAbortOptions `json:"abort"`
+}
+
+// directResponse is the canned response of an operation, returned without a
+// backend, like {"status": 200, "content_type": "text/plain",
+// "body": "User-agent: *\nDisallow: /\n"}.
+type directResponse struct {
+	Status      uint32                        `json:"status"`
+	Headers     []*options.HeaderValueOptions `json:"headers"`
+	ContentType string                        `json:"content_type"`
+	Body        string                        `json:"body"`
+}
+
+// backendRetry is the retry policy of the requests of an operation to its
+// backend, like {"num_retries": 2, "retry_on": "5xx,reset",
+// "per_try_timeout": 0.5, "hedge": true}. With hedge, the tries exceeding
+// per_try_timeout are not canceled, for the idempotent HTTP methods.
+type backendRetry struct {
+	NumRetries    uint32  `json:"num_retries"`
+	RetryOn       string  `json:"retry_on"`
+	PerTryTimeout float64 `json:"per_try_timeout"`
+	Hedge         bool    `json:"hedge"`
+}
+
+// headerRule adds or removes the headers of the requests sent to the backend
+// and of their responses, like {"request_headers_to_add": [{"name": "x-env",
+// "value": "prod", "overwrite": true}], "response_headers_to_remove":
+// ["x-internal"]}.
+type headerRule struct {
+	RequestHeadersToAdd     []*options.HeaderValueOptions `json:"request_headers_to_add"`
+	RequestHeadersToRemove  []string                      `json:"request_headers_to_remove"`
+	ResponseHeadersToAdd    []*options.HeaderValueOptions `json:"response_headers_to_add"`
+	ResponseHeadersToRemove []string                      `json:"response_headers_to_remove"`
+}
+
+// toOptions returns the header rule of selector.
+func (h *headerRule) toOptions(selector string) *options.HeaderRuleOptions {
+	return &options.HeaderRuleOptions{
+		Selector:                selector,
+		RequestHeadersToAdd:     h.RequestHeadersToAdd,
+		RequestHeadersToRemove:  h.RequestHeadersToRemove,
+		ResponseHeadersToAdd:    h.ResponseHeadersToAdd,
+		ResponseHeadersToRemove: h.ResponseHeadersToRemove,
+	}
+}
+
+// pathRewrite rewrites the paths of the requests of an operation sent to its
+// backend, like {"prefix": "/v1/", "prefix_rewrite": "/api/"} or {"regex":
+// "^/v1/shelves/([^/]+)$", "substitution": "/shelf/\\1"}.
+type pathRewrite struct {
+	Prefix        string `json:"prefix"`
+	PrefixRewrite string `json:"prefix_rewrite"`
+	Regex         string `json:"regex"`
+	Substitution  string `json:"substitution"`
+}
+
+// corsPolicy is the CORS policy of the routes of an operation and of the
+// preflight requests to its path, like {"allow_origin_regexes":
+// ["https://.*\\.example\\.com"], "allow_methods": ["GET", "POST"],
+// "allow_headers": ["authorization"], "max_age": 3600,
+// "allow_credentials": true}.
+type corsPolicy struct {
+	AllowOriginRegexes []string `json:"allow_origin_regexes"`
+	AllowMethods       []string `json:"allow_methods"`
+	AllowHeaders       []string `json:"allow_headers"`
+	ExposeHeaders      []string `json:"expose_headers"`
+	MaxAge             uint32   `json:"max_age"`
+	AllowCredentials   bool     `json:"allow_credentials"`
+}
+
+// toOptions returns the CORS policy of selector.
+func (c *corsPolicy) toOptions(selector string) *options.CorsPolicyOptions {
+	return &options.CorsPolicyOptions{
+		Selector:           selector,
+		AllowOriginRegexes: c.AllowOriginRegexes,
+		AllowMethods:       c.AllowMethods,
+		AllowHeaders:       c.AllowHeaders,
+		ExposeHeaders:      c.ExposeHeaders,
+		MaxAge:             c.MaxAge,
+		AllowCredentials:   c.AllowCredentials,
+	}
+}
+
+// ipAcl is the CIDR ranges of the client addresses allowed and denied to call
+// an operation, like {"allow": ["10.0.0.0/8"], "deny": ["10.1.0.0/16"]}.
+type ipAcl struct {
+	Allow []string `json:"allow"`
+	Deny  []string `json:"deny"`
+}
+
+// toOptions returns the IP ACL of selector.
+func (a *ipAcl) toOptions(selector string) *options.IpAclOptions {
+	return &options.IpAclOptions{
+		Selector: selector,
+		Allow:    a.Allow,
+		Deny:     a.Deny,
+	}
+}
+
+// management is the quota metrics and limits of the service, like
+// {"metrics": [{"name": "read-requests", "displayName": "Read requests",
+// "valueType": "INT64", "metricKind": "DELTA"}], "quota": {"limits":
+// [{"name": "read-limit", "metric": "read-requests", "unit":
+// "1/min/{project}", "values": {"STANDARD": 1000}}]}}, as in OpenAPI 2.0.
+type management struct {
+	Metrics []*quotaMetric `json:"metrics"`
+	Quota   struct {
+		Limits []*quotaLimit `json:"limits"`
+	} `json:"quota"`
+}
+
+type quotaMetric struct {
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+	ValueType   string `json:"valueType"`
+	MetricKind  string `json:"metricKind"`
+}
+
+type quotaLimit struct {
+	Name        string           `json:"name"`
+	DisplayName string           `json:"displayName"`
+	Metric      string           `json:"metric"`
+	Unit        string           `json:"unit"`
+	Values      map[string]int64 `json:"values"`
+}
+
+// quota is the costs of an operation in the quota metrics, like
+// {"metricCosts": {"read-requests": 1}}.
+type quota struct {
+	MetricCosts map[string]int64 `json:"metricCosts"`
+}
+
+type endpoint struct {
+	Name      string `json:"name"`
+	AllowCors bool   `json:"allowCors"`
+}
+
+// ToServiceConfig translates an OpenAPI 3.x document to the service config.
+// serviceName defaults to the host of the first server in the document, and
+// configID defaults to the version of the document.
+func ToServiceConfig(content []byte, serviceName, configID string) (*confpb.Service, error) {
+	serviceConfig, _, err := translate(content, serviceName, configID)
+	return serviceConfig, err
+}
+
+// ApplyOptions applies the extensions of an OpenAPI 3.x document which are
+// not part of the service config to the config generator options: the
+// x-google-authorization extensions, on the document or its operations, as
+// authorization rules, the x-google-ext-authz-disabled extensions of the
+// operations as ExtAuthzDisabledSelectors, the x-google-rate-limit
+// extensions, on the document for all the operations or on an operation, as
+// rate limits, the x-google-backend-split extensions of the operations as
+// backend splits, the x-google-backend-mirror extensions of the operations as
+// BackendMirrors, the x-google-fault-injection extensions of the operations as
+// FaultInjections, the x-google-direct-response extensions of the operations
+// as DirectResponses, the x-google-backend-retry extensions, on the document or
+// the operations, as BackendRetry, the x-google-headers extensions, on the
+// document for all the requests or on an operation, as HeaderRules, the
+// x-google-path-rewrite extensions of the operations as PathRewrites, the
+// x-google-cors extensions, on the document for the operations without their
+// own or on an operation, as CorsPolicies, the x-google-ip-acl extensions, on
+// the document or the operations, as IpAcls, the circuit_breaker, outlier_detection, health_check, load_balancing and failover_addresses of the x-google-backend
+// extensions as BackendClusters, and the
+// x-google-report-labels extension of the document as
+// ServiceControlReportLabels. The options set by the flags take precedence.
+func ApplyOptions(content []byte, serviceName string, opts *options.ConfigGeneratorOptions) error {
+	_, ext, err := translate(content, serviceName, "")
+	if err != nil {
+		return err
+	}
+
+	overridden := make(map[string]bool)
+	for _, r := range opts.AuthorizationRules {
+		overridden[r.Selector] = true
+	}
+	for _, r := range ext.authorizationRules {
+		if !overridden[r.Selector] {
+			opts.AuthorizationRules = append(opts.AuthorizationRules, r)
+		}
+	}
+
+	if len(ext.extAuthzDisabledSelectors) != 0 {
+		selectors := ext.extAuthzDisabledSelectors
+		if opts.ExtAuthzDisabledSelectors != "" {
+			selectors = append([]string{opts.ExtAuthzDisabledSelectors}, selectors...)
+		}
+		opts.ExtAuthzDisabledSelectors = strings.Join(selectors, ",")
+	}
+
+	if ext.globalRateLimit != nil && opts.RateLimitRequestsPerSecond == 0 && opts.RateLimitBurst == 0 {
+		opts.RateLimitRequestsPerSecond = ext.globalRateLimit.RequestsPerSecond
+		opts.RateLimitBurst = ext.globalRateLimit.Burst
+	}
+	overridden = make(map[string]bool)
+	for _, r := range opts.RateLimits {
+		overridden[r.Selector] = true
+	}
+	for _, r := range ext.rateLimits {
+		if !overridden[r.Selector] {
+			opts.RateLimits = append(opts.RateLimits, r)
+		}
+	}
+
+	overridden = make(map[string]bool)
+	for _, s := range opts.BackendSplits {
+		overridden[s.Selector] = true
+	}
+	for _, s := range ext.backendSplits {
+		if !overridden[s.Selector] {
+			opts.BackendSplits = append(opts.BackendSplits, s)
+		}
+	}
+
+	overridden = make(map[string]bool)
+	for _, m := range opts.BackendMirrors {
+		overridden[m.Selector] = true
+	}
+	for _, m := range ext.backendMirrors {
+		if !overridden[m.Selector] {
+			opts.BackendMirrors = append(opts.BackendMirrors, m)
+		}
+	}
+
+	overridden = make(map[string]bool)
+	for _, f := range opts.FaultInjections {
+		overridden[f.Selector] = true
+	}
+	for _, f := range ext.faultInjections {
+		if !overridden[f.Selector] {
+			opts.FaultInjections = append(opts.FaultInjections, f)
+		}
+	}
+
+	overridden = make(map[string]bool)
+	for _, d := range opts.DirectResponses {
+		overridden[d.Selector] = true
+	}
+	for _, d := range ext.directResponses {
+		if !overridden[d.Selector] {
+			opts.DirectResponses = append(opts.DirectResponses, d)
+		}
+	}
+
+	overridden = make(map[string]bool)
+	for _, r := range opts.BackendRetry {
+		overridden[r.Selector] = true
+	}
+	for _, r := range ext.backendRetries {
+		if !overridden[r.Selector] {
+			opts.BackendRetry = append(opts.BackendRetry, r)
+		}
+	}
+
+	overridden = make(map[string]bool)
+	for _, r := range opts.HeaderRules {
+		overridden[r.Selector] = true
+	}
+	for _, r := range ext.headerRules {
+		if !overridden[r.Selector] {
+			opts.HeaderRules = append(opts.HeaderRules, r)
+		}
+	}
+
+	overridden = make(map[string]bool)
+	for _, r := range opts.PathRewrites {
+		overridden[r.Selector] = true
+	}
+	for _, r := range ext.pathRewrites {
+		if !overridden[r.Selector] {
+			opts.PathRewrites = append(opts.PathRewrites, r)
+		}
+	}
+
+	overridden = make(map[string]bool)
+	for _, p := range opts.CorsPolicies {
+		overridden[p.Selector] = true
+	}
+	for _, p := range ext.corsPolicies {
+		if !overridden[p.Selector] {
+			opts.CorsPolicies = append(opts.CorsPolicies, p)
+		}
+	}
+
+	overridden = make(map[string]bool)
+	for _, a := range opts.IpAcls {
+		overridden[a.Selector] = true
+	}
+	for _, a := range ext.ipAcls {
+		if !overridden[a.Selector] {
+			opts.IpAcls = append(opts.IpAcls, a)
+		}
+	}
+
+	for _, b := range ext.backendClusters {
+		o := findBackendCluster(opts.BackendClusters, b.BackendAddress)
+		if o == nil {
+			opts.BackendClusters = append(opts.BackendClusters, b)
+			continue
+		}
+		if o.CircuitBreaker == nil {
+			o.CircuitBreaker = b.CircuitBreaker
+		}
+		if o.OutlierDetection == nil {
+			o.OutlierDetection = b.OutlierDetection
+		}
+		if o.HealthCheck == nil {
+			o.HealthCheck = b.HealthCheck
+		}
+		if o.LoadBalancing == nil {
+			o.LoadBalancing = b.LoadBalancing
+		}
+		if len(o.FailoverAddresses) == 0 {
+			o.FailoverAddresses = b.FailoverAddresses
+		}
+		if len(o.IamDelegates) == 0 {
+			o.IamDelegates = b.IamDelegates
+		}
+	}
+
+	var labels []string
+	overridden = make(map[string]bool)
+	if opts.ServiceControlReportLabels != "" {
+		labels = append(labels, opts.ServiceControlReportLabels)
+		for _, pair := range strings.Split(opts.ServiceControlReportLabels, ",") {
+			overridden[strings.TrimSpace(strings.SplitN(pair, "=", 2)[0])] = true
+		}
+	}
+	var names []string
+	for name := range ext.reportLabels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if !overridden[name] {
+			labels = append(labels, name+"="+ext.reportLabels[name])
+		}
+	}
+	opts.ServiceControlReportLabels = strings.Join(labels, ",")
+	return nil
+}
+
+// findBackendCluster returns the cluster settings of the backend with the
+// host and port of address, or nil if there is none.
+func findBackendCluster(backendClusters []*options.BackendClusterOptions, address string) *options.BackendClusterOptions {
+	_, hostname, port, _, err := util.ParseURI(address)
+	if err != nil {
+		return nil
+	}
+	for _, o := range backendClusters {
+		if _, h, p, _, err := util.ParseURI(o.BackendAddress); err == nil && h == hostname && p == port {
+			return o
+		}
+	}
+	return nil
+}
+
+// extensions stores the extensions of the operations which are not part of
+// the service config.
+type extensions struct {
+	authorizationRules        []*options.AuthorizationRuleOptions
+	extAuthzDisabledSelectors []string
+	globalRateLimit           *rateLimit
+	rateLimits                []*options.RateLimitOptions
+	backendSplits             []*options.BackendSplitOptions
+	backendMirrors            []*options.BackendMirrorOptions
+	faultInjections           []*options.FaultInjectionOptions
+	directResponses           []*options.DirectResponseOptions
+	backendRetries            []*options.BackendRetryOptions
+	headerRules               []*options.HeaderRuleOptions
+	pathRewrites              []*options.PathRewriteOptions
+	corsPolicies              []*options.CorsPolicyOptions
+	ipAcls                    []*options.IpAclOptions
+	backendClusters           []*options.BackendClusterOptions
+	reportLabels              map[string]string
+}
+
+// addBackendCluster adds the circuit breaker, outlier detection, health check,
+// load balancing policy, failover addresses and IAM delegates of the
+// x-google-backend b, if set, to the cluster settings of its backend.
+func (ext *extensions) addBackendCluster(b *backend) error {
+	if b == nil || (b.CircuitBreaker == nil && b.OutlierDetection == nil && b.HealthCheck == nil && b.LoadBalancing == nil && len(b.FailoverAddresses) == 0 && len(b.IamDelegates) == 0) {
+		return nil
+	}
+	if _, _, _, _, err := util.ParseURI(b.Address); err != nil {
+		return fmt.Errorf("invalid address %q in x-google-backend: %v", b.Address, err)
+	}
+	if b.OutlierDetection != nil {
+		if err := b.OutlierDetection.Validate(); err != nil {
+			return fmt.Errorf("x-google-backend: %v", err)
+		}
+	}
+	if b.HealthCheck != nil {
+		if err := b.HealthCheck.Validate(); err != nil {
+			return fmt.Errorf("x-google-backend: %v", err)
+		}
+	}
+	if b.LoadBalancing != nil {
+		if err := b.LoadBalancing.Validate(); err != nil {
+			return fmt.Errorf("x-google-backend: %v", err)
+		}
+	}
+	if o := findBackendCluster(ext.backendClusters, b.Address); o != nil {
+		if !reflect.DeepEqual(o.CircuitBreaker, b.CircuitBreaker) || !reflect.DeepEqual(o.OutlierDetection, b.OutlierDetection) || !reflect.DeepEqual(o.HealthCheck, b.HealthCheck) || !reflect.DeepEqual(o.LoadBalancing, b.LoadBalancing) || !reflect.DeepEqual(o.FailoverAddresses, b.FailoverAddresses) || !reflect.DeepEqual(o.IamDelegates, b.IamDelegates) {
+			return fmt.Errorf("x-google-backend extensions of backend %s have different circuit_breaker, outlier_detection, health_check, load_balancing, failover_addresses or iam_delegates", b.Address)
+		}
+		return nil
+	}
+	ext.backendClusters = append(ext.backendClusters, &options.BackendClusterOptions{
+		BackendAddress:   b.Address,
+		CircuitBreaker:   b.CircuitBreaker,
+		OutlierDetection: b.OutlierDetection,
+		HealthCheck:      b.HealthCheck,
+		LoadBalancing:    b.LoadBalancing,
+
+		FailoverAddresses: b.FailoverAddresses,
+		IamDelegates:      b.IamDelegates,
+	})
+	return nil
+}
+
+func translate(content []byte, serviceName, configID string) (*confpb.Service, *extensions, error) {
+	doc := &document{}
+	if err := json.Unmarshal(content, doc); err != nil {
+		return nil, nil, fmt.Errorf("fail to unmarshal OpenAPI document, only JSON is supported: %v", err)
+	}
+	if !strings.HasPrefix(doc.OpenAPI, "3.") {
+		return nil, nil, fmt.Errorf("unsupported OpenAPI version %q, must be 3.x", doc.OpenAPI)
+	}
+
+	if serviceName == "" {
+		if len(doc.Servers) == 0 {
+			return nil, nil, fmt.Errorf("service name is not specified and the OpenAPI document has no servers")
+		}
+		u, err := url.Parse(doc.Servers[0].URL)
+		if err != nil || u.Hostname() == "" {
+			return nil, nil, fmt.Errorf("fail to get service name from server url %q", doc.Servers[0].URL)
+		}
+		serviceName = u.Hostname()
+	}
+	if configID == "" {
+		configID = doc.Info.Version
+	}
+
+	apiName := "1." + strings.Replace(serviceName, ".", "_", -1)
+	serviceConfig := &confpb.Service{
+		Name:  serviceName,
+		Id:    configID,
+		Title: doc.Info.Title,
+		Control: &confpb.Control{
+			Environment: serviceControlEnvironment,
+		},
+		Http:             &annotationspb.Http{},
+		Usage:            &confpb.Usage{},
+		Backend:          &confpb.Backend{},
+		Authentication:   &confpb.Authentication{},
+		SystemParameters: &confpb.SystemParameters{},
+	}
+
+	if err := addEndpoints(serviceConfig, doc); err != nil {
+		return nil, nil, err
+	}
+	if err := addProviders(serviceConfig, doc); err != nil {
+		return nil, nil, err
+	}
+	if err := addQuotaLimits(serviceConfig, doc); err != nil {
+		return nil, nil, err
+	}
+
+	api := &apipb.Api{
+		Name:    apiName,
+		Version: doc.Info.Version,
+	}
+	serviceConfig.Apis = []*apipb.Api{api}
+
+	var paths []string
+	for path := range doc.Paths {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	ext := &extensions{
+		reportLabels: doc.ReportLabels,
+	}
+	if doc.RateLimit != nil {
+		if doc.RateLimit.RequestsPerSecond == 0 {
+			return nil, nil, fmt.Errorf("x-google-rate-limit must have requests_per_second")
+		}
+		ext.globalRateLimit = doc.RateLimit
+	}
+	if err := ext.addBackendCluster(doc.Backend); err != nil {
+		return nil, nil, err
+	}
+	if doc.Headers != nil {
+		rule := doc.Headers.toOptions("*")
+		if err := rule.Validate(); err != nil {
+			return nil, nil, fmt.Errorf("x-google-headers: %v", err)
+		}
+		ext.headerRules = append(ext.headerRules, rule)
+	}
+	if doc.Cors != nil {
+		policy := doc.Cors.toOptions("*")
+		if err := policy.Validate(); err != nil {
+			return nil, nil, fmt.Errorf("x-google-cors: %v", err)
+		}
+		ext.corsPolicies = append(ext.corsPolicies, policy)
+	}
+	if doc.IpAcl != nil {
+		acl := doc.IpAcl.toOptions("*")
+		if err := acl.Validate(); err != nil {
+			return nil, nil, fmt.Errorf("x-google-ip-acl: %v", err)
+		}
+		ext.ipAcls = append(ext.ipAcls, acl)
+	}
+	methodNames := make(map[string]string)
+	for _, path := range paths {
+		for _, httpMethod := range httpMethods {
+			raw, ok := doc.Paths[path][httpMethod]
+			if !ok {
+				continue
+			}
+			op := &operation{}
+			if err := json.Unmarshal(raw, op); err != nil {
+				return nil, nil, fmt.Errorf("fail to unmarshal operation %s %s: %v", strings.ToUpper(httpMethod), path, err)
+			}
+
+			methodName := methodName(op.OperationID, httpMethod, path)
+			if prev, ok := methodNames[methodName]; ok {
+				return nil, nil, fmt.Errorf("operation %s %s has the same name %s as operation %s", strings.ToUpper(httpMethod), path, methodName, prev)
+			}
+			methodNames[methodName] = fmt.Sprintf("%s %s", strings.ToUpper(httpMethod), path)
+			api.Methods = append(api.Methods, &apipb.Method{
+				Name:            methodName,
+				RequestTypeUrl:  "type.googleapis.com/google.protobuf.Empty",
+				ResponseTypeUrl: "type.googleapis.com/google.protobuf.Value",
+			})
+
+			selector := fmt.Sprintf("%s.%s", apiName, methodName)
+			serviceConfig.Http.Rules = append(serviceConfig.Http.Rules, httpRule(selector, httpMethod, path))
+
+			security := doc.Security
+			if op.Security != nil {
+				security = *op.Security
+			}
+			jwtRequires := doc.JwtRequires
+			if op.JwtRequires != "" {
+				jwtRequires = op.JwtRequires
+			}
+			if err := addSecurityRules(serviceConfig, doc, selector, security, jwtRequires); err != nil {
+				return nil, nil, fmt.Errorf("operation %s %s: %v", strings.ToUpper(httpMethod), path, err)
+			}
+
+			if err := addMetricRule(serviceConfig, selector, op.Quota); err != nil {
+				return nil, nil, fmt.Errorf("operation %s %s: %v", strings.ToUpper(httpMethod), path, err)
+			}
+
+			rule, err := backendRule(selector, doc.Backend, op.Backend)
+			if err != nil {
+				return nil, nil, fmt.Errorf("operation %s %s: %v", strings.ToUpper(httpMethod), path, err)
+			}
+			if rule != nil {
+				serviceConfig.Backend.Rules = append(serviceConfig.Backend.Rules, rule)
+			}
+			if err := ext.addBackendCluster(op.Backend); err != nil {
+				return nil, nil, fmt.Errorf("operation %s %s: %v", strings.ToUpper(httpMethod), path, err)
+			}
+
+			authz := doc.Authorization
+			if op.Authorization != nil {
+				authz = op.Authorization
+			}
+			if authz != nil {
+				if len(authz.Allow) == 0 {
+					return nil, nil, fmt.Errorf("operation %s %s: x-google-authorization must have allow policies", strings.ToUpper(httpMethod), path)
+				}
+				ext.authorizationRules = append(ext.authorizationRules, &options.AuthorizationRuleOptions{
+					Selector: selector,
+					Allow:    authz.Allow,
+				})
+			}
+			if op.ExtAuthzDisabled {
+				ext.extAuthzDisabledSelectors = append(ext.extAuthzDisabledSelectors, selector)
+			}
+			if op.RateLimit != nil {
+				if op.RateLimit.RequestsPerSecond == 0 {
+					return nil, nil, fmt.Errorf("operation %s %s: x-google-rate-limit must have requests_per_second", strings.ToUpper(httpMethod), path)
+				}
+				ext.rateLimits = append(ext.rateLimits, &options.RateLimitOptions{
+					Selector:          selector,
+					RequestsPerSecond: op.RateLimit.RequestsPerSecond,
+					Burst:             op.RateLimit.Burst,
+				})
+			}
+			if op.BackendSplit != nil {
+				if len(op.BackendSplit.Backends) == 0 {
+					return nil, nil, fmt.Errorf("operation %s %s: x-google-backend-split must have backends", strings.ToUpper(httpMethod), path)
+				}
+				ext.backendSplits = append(ext.backendSplits, &options.BackendSplitOptions{
+					Selector: selector,
+					Backends: op.BackendSplit.Backends,
+				})
+			}
+			if op.BackendMirror != nil {
+				if op.BackendMirror.Address == "" {
+					return nil, nil, fmt.Errorf("operation %s %s: x-google-backend-mirror must have an address", strings.ToUpper(httpMethod), path)
+				}
+				if op.BackendMirror.Fraction < 0 || op.BackendMirror.Fraction > 1 {
+					return nil, nil, fmt.Errorf("operation %s %s: x-google-backend-mirror fraction must be between 0 and 1, got %v", strings.ToUpper(httpMethod), path, op.BackendMirror.Fraction)
+				}
+				ext.backendMirrors = append(ext.backendMirrors, &options.BackendMirrorOptions{
+					Selector: selector,
+					Address:  op.BackendMirror.Address,
+					Fraction: op.BackendMirror.Fraction,
+				})
+			}
+			if op.FaultInjection != nil {
+				f := &options.FaultInjectionOptions{
+					Selector: selector,
+					Delay:    op.FaultInjection.Delay,
+					Abort:    op.FaultInjection.Abort,
+				}
+				if err := f.Validate(); err != nil {
+					return nil, nil, fmt.Errorf("operation %s %s: x-google-fault-injection: %v", strings.ToUpper(httpMethod), path, err)
+				}
+				ext.faultInjections = append(ext.faultInjections, f)
+			}
+			if op.DirectResponse != nil {
+				d := &options.DirectResponseOptions{
+					Selector:    selector,
+					Status:      op.DirectResponse.Status,
+					Headers:     op.DirectResponse.Headers,
+					ContentType: op.DirectResponse.ContentType,
+					Body:        op.DirectResponse.Body,
+				}
+				if err := d.Validate(); err != nil {
+					return nil, nil, fmt.Errorf("operation %s %s: x-google-direct-response: %v", strings.ToUpper(httpMethod), path, err)
+				}
+				ext.directResponses = append(ext.directResponses, d)
+			}
+			retry := doc.BackendRetry
+			if op.BackendRetry != nil {
+				retry = op.BackendRetry
+			}
+			if retry != nil {
+				if retry.RetryOn == "" {
+					return nil, nil, fmt.Errorf("operation %s %s: x-google-backend-retry must have retry_on", strings.ToUpper(httpMethod), path)
+				}
+				if retry.PerTryTimeout < 0 {
+					return nil, nil, fmt.Errorf("operation %s %s: negative per_try_timeout %v in x-google-backend-retry", strings.ToUpper(httpMethod), path, retry.PerTryTimeout)
+				}
+				if retry.Hedge && retry.PerTryTimeout == 0 {
+					return nil, nil, fmt.Errorf("operation %s %s: x-google-backend-retry with hedge must have per_try_timeout", strings.ToUpper(httpMethod), path)
+				}
+				ext.backendRetries = append(ext.backendRetries, &options.BackendRetryOptions{
+					Selector:      selector,
+					NumRetries:    retry.NumRetries,
+					RetryOn:       retry.RetryOn,
+					PerTryTimeout: retry.PerTryTimeout,
+					Hedge:         retry.Hedge,
+				})
+			}
+			if op.Headers != nil {
+				rule := op.Headers.toOptions(selector)
+				if err := rule.Validate(); err != nil {
+					return nil, nil, fmt.Errorf("operation %s %s: x-google-headers: %v", strings.ToUpper(httpMethod), path, err)
+				}
+				ext.headerRules = append(ext.headerRules, rule)
+			}
+			if op.PathRewrite != nil {
+				rewrite := &options.PathRewriteOptions{
+					Selector:      selector,
+					Prefix:        op.PathRewrite.Prefix,
+					PrefixRewrite: op.PathRewrite.PrefixRewrite,
+					Regex:         op.PathRewrite.Regex,
+					Substitution:  op.PathRewrite.Substitution,
+				}
+				if err := rewrite.Validate(); err != nil {
+					return nil, nil, fmt.Errorf("operation %s %s: x-google-path-rewrite: %v", strings.ToUpper(httpMethod), path, err)
+				}
+				ext.pathRewrites = append(ext.pathRewrites, rewrite)
+			}
+			if op.Cors != nil {
+				policy := op.Cors.toOptions(selector)
+				if err := policy.Validate(); err != nil {
+					return nil, nil, fmt.Errorf("operation %s %s: x-google-cors: %v", strings.ToUpper(httpMethod), path, err)
+				}
+				ext.corsPolicies = append(ext.corsPolicies, policy)
+			}
+			if op.IpAcl != nil {
+				acl := op.IpAcl.toOptions(selector)
+				if err := acl.Validate(); err != nil {
+					return nil, nil, fmt.Errorf("operation %s %s: x-google-ip-acl: %v", strings.ToUpper(httpMethod), path, err)
+				}
+				ext.ipAcls = append(ext.ipAcls, acl)
+			}
+		}
+	}
+	if len(api.Methods) == 0 {
+		return nil, nil, fmt.Errorf("OpenAPI document has no operations")
+	}
+	return serviceConfig, ext, nil
+}
+
+// methodName returns the operation id with characters not allowed in method
+// names replaced, or a name generated from the HTTP method and path.
+func methodName(operationID, httpMethod, path string) string {
+	if operationID == "" {
+		operationID = httpMethod + strings.Replace(path, "/", "_", -1)
+	}
+	return invalidNameChars.ReplaceAllString(operationID, "_")
+}
+
+func httpRule(selector, httpMethod, path string) *annotationspb.HttpRule {
+	rule := &annotationspb.HttpRule{
+		Selector: selector,
+	}
+	switch httpMethod {
+	case "get":
+		rule.Pattern = &annotationspb.HttpRule_Get{Get: path}
+	case "put":
+		rule.Pattern = &annotationspb.HttpRule_Put{Put: path}
+	case "post":
+		rule.Pattern = &annotationspb.HttpRule_Post{Post: path}
+	case "delete":
+		rule.Pattern = &annotationspb.HttpRule_Delete{Delete: path}
+	case "patch":
+		rule.Pattern = &annotationspb.HttpRule_Patch{Patch: path}
+	default:
+		rule.Pattern = &annotationspb.HttpRule_Custom{
+			Custom: &annotationspb.CustomHttpPattern{
+				Kind: strings.ToUpper(httpMethod),
+				Path: path,
+			},
+		}
+	}
+	return rule
+}
+
+func addEndpoints(serviceConfig *confpb.Service, doc *document) error {
+	for _, e := range doc.Endpoints {
+		if e.Name == "" {
+			return fmt.Errorf("x-google-endpoints must have a name")
+		}
+		serviceConfig.Endpoints = append(serviceConfig.Endpoints, &confpb.Endpoint{
+			Name:      e.Name,
+			AllowCors: e.AllowCors,
+		})
+	}
+	if len(serviceConfig.Endpoints) == 0 {
+		serviceConfig.Endpoints = []*confpb.Endpoint{{Name: serviceConfig.Name}}
+	}
+	return nil
+}
+
+// addProviders adds an authentication provider for each security scheme with
+// the x-google-issuer extension.
+func addProviders(serviceConfig *confpb.Service, doc *document) error {
+	var names []string
+	for name := range doc.Components.SecuritySchemes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		scheme := doc.Components.SecuritySchemes[name]
+		switch scheme.Type {
+		case "apiKey":
+			if scheme.In != "header" && scheme.In != "query" {
+				return fmt.Errorf("security scheme %s: API key must be in header or query, got %q", name, scheme.In)
+			}
+		case "http", "oauth2", "openIdConnect":
+			if scheme.Issuer == "" {
+				return fmt.Errorf("security scheme %s: x-google-issuer is required for type %s", name, scheme.Type)
+			}
+			serviceConfig.Authentication.Providers = append(serviceConfig.Authentication.Providers, &confpb.AuthProvider{
+				Id:        name,
+				Issuer:    scheme.Issuer,
+				JwksUri:   scheme.JwksURI,
+				Audiences: scheme.Audiences,
+			})
+		default:
+			return fmt.Errorf("security scheme %s: unsupported type %q", name, scheme.Type)
+		}
+	}
+	return nil
+}
+
+// addQuotaLimits adds the quota metrics and limits of the x-google-management
+// extension. The metrics are INT64 DELTA metrics by default.
+func addQuotaLimits(serviceConfig *confpb.Service, doc *document) error {
+	if doc.Management == nil {
+		return nil
+	}
+	metrics := make(map[string]bool)
+	for _, m := range doc.Management.Metrics {
+		if m.Name == "" {
+			return fmt.Errorf("x-google-management metrics must have a name")
+		}
+		if metrics[m.Name] {
+			return fmt.Errorf("duplicated metric %s in x-google-management", m.Name)
+		}
+		metrics[m.Name] = true
+		valueType, kind := m.ValueType, m.MetricKind
+		if valueType == "" {
+			valueType = metricpb.MetricDescriptor_INT64.String()
+		}
+		if kind == "" {
+			kind = metricpb.MetricDescriptor_DELTA.String()
+		}
+		if _, ok := metricpb.MetricDescriptor_ValueType_value[valueType]; !ok {
+			return fmt.Errorf("metric %s: unsupported valueType %q in x-google-management", m.Name, valueType)
+		}
+		if _, ok := metricpb.MetricDescriptor_MetricKind_value[kind]; !ok {
+			return fmt.Errorf("metric %s: unsupported metricKind %q in x-google-management", m.Name, kind)
+		}
+		serviceConfig.Metrics = append(serviceConfig.Metrics, &metricpb.MetricDescriptor{
+			Name:        m.Name,
+			DisplayName: m.DisplayName,
+			ValueType:   metricpb.MetricDescriptor_ValueType(metricpb.MetricDescriptor_ValueType_value[valueType]),
+			MetricKind:  metricpb.MetricDescriptor_MetricKind(metricpb.MetricDescriptor_MetricKind_value[kind]),
+		})
+	}
+	for _, l := range doc.Management.Quota.Limits {
+		if l.Name == "" {
+			return fmt.Errorf("x-google-management quota limits must have a name")
+		}
+		if !metrics[l.Metric] {
+			return fmt.Errorf("quota limit %s: metric %q is not defined in x-google-management", l.Name, l.Metric)
+		}
+		if serviceConfig.Quota == nil {
+			serviceConfig.Quota = &confpb.Quota{}
+		}
+		serviceConfig.Quota.Limits = append(serviceConfig.Quota.Limits, &confpb.QuotaLimit{
+			Name:        l.Name,
+			DisplayName: l.DisplayName,
+			Metric:      l.Metric,
+			Unit:        l.Unit,
+			Values:      l.Values,
+		})
+	}
+	return nil
+}
+
+// addMetricRule adds the metric rule of the x-google-quota extension of an
+// operation. Its metrics must be defined in the x-google-management
+// extension.
+func addMetricRule(serviceConfig *confpb.Service, selector string, q *quota) error {
+	if q == nil {
+		return nil
+	}
+	if len(q.MetricCosts) == 0 {
+		return fmt.Errorf("x-google-quota must have metricCosts")
+	}
+	for name, cost := range q.MetricCosts {
+		defined := false
+		for _, m := range serviceConfig.Metrics {
+			defined = defined || m.GetName() == name
+		}
+		if !defined {
+			return fmt.Errorf("metric %q in x-google-quota is not defined in x-google-management", name)
+		}
+		if cost <= 0 {
+			return fmt.Errorf("cost of metric %q in x-google-quota must be positive, got %d", name, cost)
+		}
+	}
+	if serviceConfig.Quota == nil {
+		serviceConfig.Quota = &confpb.Quota{}
+	}
+	serviceConfig.Quota.MetricRules = append(serviceConfig.Quota.MetricRules, &confpb.MetricRule{
+		Selector:    selector,
+		MetricCosts: q.MetricCosts,
+	})
+	return nil
+}
+
+// addSecurityRules adds the authentication, usage and API key location rules
+// of an operation. Each item of security is an alternative, and all schemes of
+// an alternative are required. The JWT schemes of all the alternatives are
+// accepted alike, unless jwtRequires is "all".
+func addSecurityRules(serviceConfig *confpb.Service, doc *document, selector string, security []map[string][]string, jwtRequires string) error {
+	if jwtRequires != "" && jwtRequires != "any" && jwtRequires != "all" {
+		return fmt.Errorf(`x-google-jwt-requires must be "any" or "all", got %q`, jwtRequires)
+	}
+	apiKeyRequired := len(security) > 0
+	allowWithoutJwt := len(security) == 0
+	var requirements []*confpb.AuthRequirement
+	var apiKeyParameters []*confpb.SystemParameter
+	for _, alternative := range security {
+		hasAPIKey := false
+		var jwtNames []string
+		var names []string
+		for name := range alternative {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			scheme, ok := doc.Components.SecuritySchemes[name]
+			if !ok {
+				return fmt.Errorf("security scheme %s is not defined", name)
+			}
+			if scheme.Type == "apiKey" {
+				hasAPIKey = true
+				parameter := &confpb.SystemParameter{Name: util.ApiKeyParameterName}
+				if scheme.In == "header" {
+					parameter.HttpHeader = scheme.Name
+				} else {
+					parameter.UrlQueryParameter = scheme.Name
+				}
+				apiKeyParameters = append(apiKeyParameters, parameter)
+				continue
+			}
+			jwtNames = append(jwtNames, name)
+			if jwtRequires != "all" {
+				requirements = append(requirements, &confpb.AuthRequirement{
+					ProviderId: name,
+					Audiences:  scheme.Audiences,
+				})
+			}
+		}
+		if jwtRequires == "all" && len(jwtNames) > 0 {
+			// The providers use the audiences of their schemes.
+			requirements = append(requirements, &confpb.AuthRequirement{
+				ProviderId: strings.Join(jwtNames, ","),
+			})
+		}
+		apiKeyRequired = apiKeyRequired && hasAPIKey
+		allowWithoutJwt = allowWithoutJwt || len(jwtNames) == 0
+	}
+
+	serviceConfig.Usage.Rules = append(serviceConfig.Usage.Rules, &confpb.UsageRule{
+		Selector:               selector,
+		AllowUnregisteredCalls: !apiKeyRequired,
+	})
+	if len(requirements) > 0 {
+		serviceConfig.Authentication.Rules = append(serviceConfig.Authentication.Rules, &confpb.AuthenticationRule{
+			Selector:               selector,
+			Requirements:           requirements,
+			AllowWithoutCredential: allowWithoutJwt,
+		})
+	}
+	if len(apiKeyParameters) > 0 {
+		serviceConfig.SystemParameters.Rules = append(serviceConfig.SystemParameters.Rules, &confpb.SystemParameterRule{
+			Selector:   selector,
+			Parameters: apiKeyParameters,
+		})
+	}
+	return nil
+}
+
+// backendRule translates the x-google-backend of an operation, or of the
+// document if the operation has none. Like in OpenAPI 2.0, the path is
+// appended to the address of the document backend by default, and the
+// address of an operation backend is used as is by default.
+func backendRule(selector string, docBackend, opBackend *backend) (*confpb.BackendRule, error) {
+	b, pathTranslation := opBackend, confpb.BackendRule_CONSTANT_ADDRESS
+	if b == nil {
+		b, pathTranslation = docBackend, confpb.BackendRule_APPEND_PATH_TO_ADDRESS
+	}
+	if b == nil {
+		return nil, nil
+	}
+	if b.Address == "" {
+		return nil, fmt.Errorf("x-google-backend must have an address")
+	}
+
+	switch b.PathTranslation {
+	case "":
+	case "CONSTANT_ADDRESS":
+		pathTranslation = confpb.BackendRule_CONSTANT_ADDRESS
+	case "APPEND_PATH_TO_ADDRESS":
+		pathTranslation = confpb.BackendRule_APPEND_PATH_TO_ADDRESS
+	default:
+		return nil, fmt.Errorf("unsupported path_translation %q in x-google-backend", b.PathTranslation)
+	}
+
+	rule := &confpb.BackendRule{
+		Selector:        selector,
+		Address:         b.Address,
+		PathTranslation: pathTranslation,
+		Deadline:        b.Deadline,
+		Protocol:        b.Protocol,
+	}
+	if b.JwtAudience != "" && b.DisableAuth {
+		return nil, fmt.Errorf("jwt_audience and disable_auth cannot be both set in x-google-backend")
+	}
+	if b.JwtAudience != "" {
+		rule.Authentication = &confpb.BackendRule_JwtAudience{JwtAudience: b.JwtAudience}
+	}
+	if b.DisableAuth {
+		rule.Authentication = &confpb.BackendRule_DisableAuth{DisableAuth: true}
+	}
+	return rule, nil
+}
@@ -0,0 +1,1164 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openapi
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/options"
+	"github.com/GoogleCloudPlatform/esp-v2/src/go/util"
+)
+
+func TestToServiceConfig(t *testing.T) {
+	testData := []struct {
+		desc              string
+		doc               string
+		serviceName       string
+		configID          string
+		wantServiceConfig string
+		wantError         string
+	}{
+		{
+			desc: "Paths with API key and JWT security, and backends",
+			doc: `{
+  "openapi": "3.0.0",
+  "info": {"title": "Bookstore", "version": "1.0.0"},
+  "servers": [{"url": "https://bookstore.endpoints.project.cloud.goog"}],
+  "x-google-backend": {"address": "https://backend.run.app", "jwt_audience": "https://backend"},
+  "x-google-endpoints": [{"name": "bookstore.endpoints.project.cloud.goog", "allowCors": true}],
+  "components": {
+    "securitySchemes": {
+      "api_key": {"type": "apiKey", "name": "x-api-key", "in": "header"},
+      "google_id_token": {
+        "type": "http",
+        "scheme": "bearer",
+        "x-google-issuer": "https://accounts.google.com",
+        "x-google-jwks_uri": "https://www.googleapis.com/oauth2/v3/certs",
+        "x-google-audiences": "bookstore"
+      }
+    }
+  },
+  "security": [{"api_key": []}],
+  "paths": {
+    "/shelves": {
+      "get": {"operationId": "ListShelves"},
+      "post": {
+        "operationId": "CreateShelf",
+        "security": [{"google_id_token": []}, {"api_key": []}]
+      }
+    },
+    "/shelves/{shelf}": {
+      "delete": {
+        "security": [],
+        "x-google-backend": {"address": "https://admin.run.app/delete", "disable_auth": true, "deadline": 10}
+      }
+    }
+  }
+}`,
+			wantServiceConfig: `{
+  "name": "bookstore.endpoints.project.cloud.goog",
+  "title": "Bookstore",
+  "id": "1.0.0",
+  "apis": [
+    {
+      "name": "1.bookstore_endpoints_project_cloud_goog",
+      "version": "1.0.0",
+      "methods": [
+        {
+          "name": "ListShelves",
+          "requestTypeUrl": "type.googleapis.com/google.protobuf.Empty",
+          "responseTypeUrl": "type.googleapis.com/google.protobuf.Value"
+        },
+        {
+          "name": "CreateShelf",
+          "requestTypeUrl": "type.googleapis.com/google.protobuf.Empty",
+          "responseTypeUrl": "type.googleapis.com/google.protobuf.Value"
+        },
+        {
+          "name": "delete_shelves__shelf_",
+          "requestTypeUrl": "type.googleapis.com/google.protobuf.Empty",
+          "responseTypeUrl": "type.googleapis.com/google.protobuf.Value"
+        }
+      ]
+    }
+  ],
+  "http": {
+    "rules": [
+      {"selector": "1.bookstore_endpoints_project_cloud_goog.ListShelves", "get": "/shelves"},
+      {"selector": "1.bookstore_endpoints_project_cloud_goog.CreateShelf", "post": "/shelves"},
+      {"selector": "1.bookstore_endpoints_project_cloud_goog.delete_shelves__shelf_", "delete": "/shelves/{shelf}"}
+    ]
+  },
+  "backend": {
+    "rules": [
+      {
+        "selector": "1.bookstore_endpoints_project_cloud_goog.ListShelves",
+        "address": "https://backend.run.app",
+        "jwtAudience": "https://backend",
+        "pathTranslation": "APPEND_PATH_TO_ADDRESS"
+      },
+      {
+        "selector": "1.bookstore_endpoints_project_cloud_goog.CreateShelf",
+        "address": "https://backend.run.app",
+        "jwtAudience": "https://backend",
+        "pathTranslation": "APPEND_PATH_TO_ADDRESS"
+      },
+      {
+        "selector": "1.bookstore_endpoints_project_cloud_goog.delete_shelves__shelf_",
+        "address": "https://admin.run.app/delete",
+        "deadline": 10,
+        "disableAuth": true,
+        "pathTranslation": "CONSTANT_ADDRESS"
+      }
+    ]
+  },
+  "authentication": {
+    "rules": [
+      {
+        "selector": "1.bookstore_endpoints_project_cloud_goog.CreateShelf",
+        "requirements": [{"providerId": "google_id_token", "audiences": "bookstore"}],
+        "allowWithoutCredential": true
+      }
+    ],
+    "providers": [
+      {
+        "id": "google_id_token",
+        "issuer": "https://accounts.google.com",
+        "jwksUri": "https://www.googleapis.com/oauth2/v3/certs",
+        "audiences": "bookstore"
+      }
+    ]
+  },
+  "usage": {
+    "rules": [
+      {"selector": "1.bookstore_endpoints_project_cloud_goog.ListShelves"},
+      {"selector": "1.bookstore_endpoints_project_cloud_goog.CreateShelf", "allowUnregisteredCalls": true},
+      {"selector": "1.bookstore_endpoints_project_cloud_goog.delete_shelves__shelf_", "allowUnregisteredCalls": true}
+    ]
+  },
+  "control": {"environment": "servicecontrol.googleapis.com"},
+  "systemParameters": {
+    "rules": [
+      {
+        "selector": "1.bookstore_endpoints_project_cloud_goog.ListShelves",
+        "parameters": [{"name": "api_key", "httpHeader": "x-api-key"}]
+      },
+      {
+        "selector": "1.bookstore_endpoints_project_cloud_goog.CreateShelf",
+        "parameters": [{"name": "api_key", "httpHeader": "x-api-key"}]
+      }
+    ]
+  },
+  "endpoints": [{"name": "bookstore.endpoints.project.cloud.goog", "allowCors": true}]
+}`,
+		},
+		{
+			desc: "Service name and config id from flags, custom methods",
+			doc: `{
+  "openapi": "3.0.3",
+  "info": {"title": "Echo", "version": "v1"},
+  "paths": {
+    "/echo": {"head": {"operationId": "echo.head"}}
+  }
+}`,
+			serviceName: "echo.example.com",
+			configID:    "2019-12-01r0",
+			wantServiceConfig: `{
+  "name": "echo.example.com",
+  "title": "Echo",
+  "id": "2019-12-01r0",
+  "apis": [
+    {
+      "name": "1.echo_example_com",
+      "version": "v1",
+      "methods": [
+        {
+          "name": "echo_head",
+          "requestTypeUrl": "type.googleapis.com/google.protobuf.Empty",
+          "responseTypeUrl": "type.googleapis.com/google.protobuf.Value"
+        }
+      ]
+    }
+  ],
+  "http": {
+    "rules": [
+      {"selector": "1.echo_example_com.echo_head", "custom": {"kind": "HEAD", "path": "/echo"}}
+    ]
+  },
+  "backend": {},
+  "authentication": {},
+  "usage": {
+    "rules": [
+      {"selector": "1.echo_example_com.echo_head", "allowUnregisteredCalls": true}
+    ]
+  },
+  "control": {"environment": "servicecontrol.googleapis.com"},
+  "systemParameters": {},
+  "endpoints": [{"name": "echo.example.com"}]
+}`,
+		},
+		{
+			desc:      "YAML is not supported",
+			doc:       "openapi: 3.0.0\n",
+			wantError: "only JSON is supported",
+		},
+		{
+			desc:      "OpenAPI 2.0 is not supported",
+			doc:       `{"swagger": "2.0"}`,
+			wantError: `unsupported OpenAPI version ""`,
+		},
+		{
+			desc:      "No service name",
+			doc:       `{"openapi": "3.0.0", "paths": {"/a": {"get": {}}}}`,
+			wantError: "service name is not specified",
+		},
+		{
+			desc: "Duplicate method names",
+			doc: `{"openapi": "3.0.0", "paths": {
+  "/a": {"get": {"operationId": "Get"}},
+  "/b": {"get": {"operationId": "Get"}}
+}}`,
+			serviceName: "a.example.com",
+			wantError:   "operation GET /b has the same name Get as operation GET /a",
+		},
+		{
+			desc: "JWT security schemes required all together",
+			doc: `{"openapi": "3.0.0",
+  "components": {"securitySchemes": {
+    "jwt1": {"type": "http", "scheme": "bearer", "x-google-issuer": "issuer1", "x-google-jwks_uri": "https://issuer1/certs"},
+    "jwt2": {"type": "http", "scheme": "bearer", "x-google-issuer": "issuer2", "x-google-jwks_uri": "https://issuer2/certs"}
+  }},
+  "security": [{"jwt1": [], "jwt2": []}],
+  "paths": {
+    "/a": {"get": {"operationId": "GetA", "x-google-jwt-requires": "all"}},
+    "/b": {"get": {"operationId": "GetB"}}
+  }
+}`,
+			serviceName: "a.example.com",
+			configID:    "1",
+			wantServiceConfig: `{
+  "name": "a.example.com",
+  "id": "1",
+  "apis": [
+    {
+      "name": "1.a_example_com",
+      "methods": [
+        {
+          "name": "GetA",
+          "requestTypeUrl": "type.googleapis.com/google.protobuf.Empty",
+          "responseTypeUrl": "type.googleapis.com/google.protobuf.Value"
+        },
+        {
+          "name": "GetB",
+          "requestTypeUrl": "type.googleapis.com/google.protobuf.Empty",
+          "responseTypeUrl": "type.googleapis.com/google.protobuf.Value"
+        }
+      ]
+    }
+  ],
+  "backend": {},
+  "http": {
+    "rules": [
+      {"selector": "1.a_example_com.GetA", "get": "/a"},
+      {"selector": "1.a_example_com.GetB", "get": "/b"}
+    ]
+  },
+  "authentication": {
+    "rules": [
+      {
+        "selector": "1.a_example_com.GetA",
+        "requirements": [{"providerId": "jwt1,jwt2"}]
+      },
+      {
+        "selector": "1.a_example_com.GetB",
+        "requirements": [{"providerId": "jwt1"}, {"providerId": "jwt2"}]
+      }
+    ],
+    "providers": [
+      {"id": "jwt1", "issuer": "issuer1", "jwksUri": "https://issuer1/certs"},
+      {"id": "jwt2", "issuer": "issuer2", "jwksUri": "https://issuer2/certs"}
+    ]
+  },
+  "usage": {
+    "rules": [
+      {"selector": "1.a_example_com.GetA", "allowUnregisteredCalls": true},
+      {"selector": "1.a_example_com.GetB", "allowUnregisteredCalls": true}
+    ]
+  },
+  "endpoints": [{"name": "a.example.com"}],
+  "control": {"environment": "servicecontrol.googleapis.com"},
+  "systemParameters": {}
+}`,
+		},
+		{
+			desc: "Quota metrics, limits and metric costs",
+			doc: `{"openapi": "3.0.0",
+  "x-google-management": {
+    "metrics": [
+      {"name": "read-requests", "displayName": "Read requests"},
+      {"name": "expensive-requests", "valueType": "INT64", "metricKind": "DELTA"}
+    ],
+    "quota": {
+      "limits": [
+        {"name": "read-limit", "metric": "read-requests", "unit": "1/min/{project}", "values": {"STANDARD": 1000}},
+        {"name": "expensive-limit", "metric": "expensive-requests", "unit": "1/min/{project}", "values": {"STANDARD": 10}}
+      ]
+    }
+  },
+  "paths": {
+    "/a": {"get": {"operationId": "GetA", "x-google-quota": {"metricCosts": {"read-requests": 1, "expensive-requests": 5}}}}
+  }
+}`,
+			serviceName: "a.example.com",
+			configID:    "1",
+			wantServiceConfig: `{
+  "name": "a.example.com",
+  "id": "1",
+  "apis": [
+    {
+      "name": "1.a_example_com",
+      "methods": [
+        {
+          "name": "GetA",
+          "requestTypeUrl": "type.googleapis.com/google.protobuf.Empty",
+          "responseTypeUrl": "type.googleapis.com/google.protobuf.Value"
+        }
+      ]
+    }
+  ],
+  "backend": {},
+  "http": {
+    "rules": [
+      {"selector": "1.a_example_com.GetA", "get": "/a"}
+    ]
+  },
+  "authentication": {},
+  "usage": {
+    "rules": [
+      {"selector": "1.a_example_com.GetA", "allowUnregisteredCalls": true}
+    ]
+  },
+  "metrics": [
+    {"name": "read-requests", "displayName": "Read requests", "valueType": "INT64", "metricKind": "DELTA"},
+    {"name": "expensive-requests", "valueType": "INT64", "metricKind": "DELTA"}
+  ],
+  "quota": {
+    "limits": [
+      {"name": "read-limit", "metric": "read-requests", "unit": "1/min/{project}", "values": {"STANDARD": "1000"}},
+      {"name": "expensive-limit", "metric": "expensive-requests", "unit": "1/min/{project}", "values": {"STANDARD": "10"}}
+    ],
+    "metricRules": [
+      {
+        "selector": "1.a_example_com.GetA",
+        "metricCosts": {"read-requests": "1", "expensive-requests": "5"}
+      }
+    ]
+  },
+  "endpoints": [{"name": "a.example.com"}],
+  "control": {"environment": "servicecontrol.googleapis.com"},
+  "systemParameters": {}
+}`,
+		},
+		{
+			desc: "Metric cost of undefined metric",
+			doc: `{"openapi": "3.0.0",
+  "x-google-management": {"metrics": [{"name": "read-requests"}]},
+  "paths": {"/a": {"get": {"x-google-quota": {"metricCosts": {"write-requests": 1}}}}}
+}`,
+			serviceName: "a.example.com",
+			wantError:   `operation GET /a: metric "write-requests" in x-google-quota is not defined in x-google-management`,
+		},
+		{
+			desc: "Quota limit of undefined metric",
+			doc: `{"openapi": "3.0.0",
+  "x-google-management": {"quota": {"limits": [{"name": "read-limit", "metric": "read-requests"}]}},
+  "paths": {"/a": {"get": {}}}
+}`,
+			serviceName: "a.example.com",
+			wantError:   `quota limit read-limit: metric "read-requests" is not defined in x-google-management`,
+		},
+		{
+			desc: "Invalid x-google-jwt-requires",
+			doc: `{"openapi": "3.0.0", "paths": {
+  "/a": {"get": {"x-google-jwt-requires": "one"}}
+}}`,
+			serviceName: "a.example.com",
+			wantError:   `operation GET /a: x-google-jwt-requires must be "any" or "all", got "one"`,
+		},
+		{
+			desc: "Undefined security scheme",
+			doc: `{"openapi": "3.0.0", "paths": {
+  "/a": {"get": {"security": [{"jwt": []}]}}
+}}`,
+			serviceName: "a.example.com",
+			wantError:   "operation GET /a: security scheme jwt is not defined",
+		},
+		{
+			desc: "JWT security scheme without issuer",
+			doc: `{"openapi": "3.0.0",
+  "components": {"securitySchemes": {"jwt": {"type": "http", "scheme": "bearer"}}},
+  "paths": {"/a": {"get": {}}}
+}`,
+			serviceName: "a.example.com",
+			wantError:   "security scheme jwt: x-google-issuer is required for type http",
+		},
+		{
+			desc: "Invalid path translation",
+			doc: `{"openapi": "3.0.0", "paths": {
+  "/a": {"get": {"x-google-backend": {"address": "https://backend", "path_translation": "NONE"}}}
+}}`,
+			serviceName: "a.example.com",
+			wantError:   `operation GET /a: unsupported path_translation "NONE" in x-google-backend`,
+		},
+	}
+
+	for _, tc := range testData {
+		serviceConfig, err := ToServiceConfig([]byte(tc.doc), tc.serviceName, tc.configID)
+		if tc.wantError != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.wantError) {
+				t.Errorf("Test Desc(%s): expected error containing %q, got: %v", tc.desc, tc.wantError, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Test Desc(%s): ToServiceConfig got error: %v", tc.desc, err)
+		}
+
+		gotServiceConfig, err := util.ProtoToJson(serviceConfig)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := util.JsonEqual(tc.wantServiceConfig, gotServiceConfig); err != nil {
+			t.Errorf("Test Desc(%s): %v", tc.desc, err)
+		}
+	}
+}
+
+func TestApplyOptions(t *testing.T) {
+	flagRule := &options.AuthorizationRuleOptions{
+		Selector: "1.a_example_com.DeleteA",
+		Allow: []*options.AuthorizationPolicyOptions{
+			{
+				Claims: map[string][]string{"sub": {"user-1"}},
+			},
+		},
+	}
+	testData := []struct {
+		desc        string
+		doc         string
+		flagOptions options.ConfigGeneratorOptions
+		wantOptions options.ConfigGeneratorOptions
+		wantError   string
+	}{
+		{
+			desc: "No extensions",
+			doc: `{"openapi": "3.0.0", "paths": {
+  "/a": {"get": {}}
+}}`,
+		},
+		{
+			desc: "Authorization rules of the document and the operations",
+			doc: `{"openapi": "3.0.0",
+  "x-google-authorization": {"allow": [{"claims": {"roles": ["reader", "admin"]}}]},
+  "paths": {
+    "/a": {
+      "get": {"operationId": "GetA"},
+      "delete": {"operationId": "DeleteA", "x-google-authorization": {"allow": [{"claims": {"roles": ["admin"], "google.org": ["example.com"]}}]}}
+    }
+  }
+}`,
+			wantOptions: options.ConfigGeneratorOptions{
+				AuthorizationRules: []*options.AuthorizationRuleOptions{
+					{
+						Selector: "1.a_example_com.GetA",
+						Allow: []*options.AuthorizationPolicyOptions{
+							{
+								Claims: map[string][]string{"roles": {"reader", "admin"}},
+							},
+						},
+					},
+					{
+						Selector: "1.a_example_com.DeleteA",
+						Allow: []*options.AuthorizationPolicyOptions{
+							{
+								Claims: map[string][]string{"roles": {"admin"}, "google.org": {"example.com"}},
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			desc: "Authorization rules of the flags take precedence",
+			doc: `{"openapi": "3.0.0",
+  "paths": {
+    "/a": {
+      "delete": {"operationId": "DeleteA", "x-google-authorization": {"allow": [{"claims": {"roles": ["admin"]}}]}}
+    }
+  }
+}`,
+			flagOptions: options.ConfigGeneratorOptions{
+				AuthorizationRules: []*options.AuthorizationRuleOptions{flagRule},
+			},
+			wantOptions: options.ConfigGeneratorOptions{
+				AuthorizationRules: []*options.AuthorizationRuleOptions{flagRule},
+			},
+		},
+		{
+			desc: "Operations not checked by the external authorization server",
+			doc: `{"openapi": "3.0.0",
+  "paths": {
+    "/a": {
+      "get": {"operationId": "GetA", "x-google-ext-authz-disabled": true},
+      "delete": {"operationId": "DeleteA"}
+    },
+    "/b": {
+      "get": {"operationId": "GetB", "x-google-ext-authz-disabled": true}
+    }
+  }
+}`,
+			flagOptions: options.ConfigGeneratorOptions{
+				ExtAuthzDisabledSelectors: "1.a_example_com.DeleteA",
+			},
+			wantOptions: options.ConfigGeneratorOptions{
+				ExtAuthzDisabledSelectors: "1.a_example_com.DeleteA,1.a_example_com.GetA,1.a_example_com.GetB",
+			},
+		},
+		{
+			desc: "Rate limits of the document and the operations",
+			doc: `{"openapi": "3.0.0",
+  "x-google-rate-limit": {"requests_per_second": 100, "burst": 200},
+  "paths": {
+    "/a": {
+      "get": {"operationId": "GetA", "x-google-rate-limit": {"requests_per_second": 10}},
+      "delete": {"operationId": "DeleteA", "x-google-rate-limit": {"requests_per_second": 1, "burst": 5}}
+    }
+  }
+}`,
+			flagOptions: options.ConfigGeneratorOptions{
+				RateLimits: []*options.RateLimitOptions{
+					{
+						Selector:          "1.a_example_com.DeleteA",
+						RequestsPerSecond: 2,
+					},
+				},
+			},
+			wantOptions: options.ConfigGeneratorOptions{
+				RateLimitRequestsPerSecond: 100,
+				RateLimitBurst:             200,
+				RateLimits: []*options.RateLimitOptions{
+					{
+						Selector:          "1.a_example_com.DeleteA",
+						RequestsPerSecond: 2,
+					},
+					{
+						Selector:          "1.a_example_com.GetA",
+						RequestsPerSecond: 10,
+					},
+				},
+			},
+		},
+		{
+			desc: "Rate limit of the flags takes precedence over the document",
+			doc: `{"openapi": "3.0.0",
+  "x-google-rate-limit": {"requests_per_second": 100},
+  "paths": {
+    "/a": {"get": {}}
+  }
+}`,
+			flagOptions: options.ConfigGeneratorOptions{
+				RateLimitRequestsPerSecond: 50,
+			},
+			wantOptions: options.ConfigGeneratorOptions{
+				RateLimitRequestsPerSecond: 50,
+			},
+		},
+		{
+			desc: "Report labels of the document, the flags take precedence",
+			doc: `{"openapi": "3.0.0",
+  "x-google-report-labels": {"tenant_id": "header:x-tenant-id", "client_version": "jwt:client.version"},
+  "paths": {
+    "/a": {"get": {}}
+  }
+}`,
+			flagOptions: options.ConfigGeneratorOptions{
+				ServiceControlReportLabels: "tenant_id=header:x-tenant",
+			},
+			wantOptions: options.ConfigGeneratorOptions{
+				ServiceControlReportLabels: "tenant_id=header:x-tenant,client_version=jwt:client.version",
+			},
+		},
+		{
+			desc: "Rate limit without requests per second",
+			doc: `{"openapi": "3.0.0", "paths": {
+  "/a": {"get": {"x-google-rate-limit": {"burst": 10}}}
+}}`,
+			wantError: "operation GET /a: x-google-rate-limit must have requests_per_second",
+		},
+		{
+			desc: "Backend splits of the operations",
+			doc: `{"openapi": "3.0.0", "paths": {
+  "/a": {
+    "get": {"operationId": "GetA", "x-google-backend-split": {"backends": [
+      {"name": "v1", "address": "https://v1.example.com", "weight": 90},
+      {"name": "v2", "address": "https://v2.example.com", "weight": 10}
+    ]}},
+    "delete": {"operationId": "DeleteA", "x-google-backend-split": {"backends": [
+      {"name": "v1", "address": "https://v1.example.com", "weight": 1}
+    ]}}
+  }
+}}`,
+			flagOptions: options.ConfigGeneratorOptions{
+				BackendSplits: []*options.BackendSplitOptions{
+					{
+						Selector: "1.a_example_com.DeleteA",
+						Backends: []*options.WeightedBackendOptions{
+							{
+								Name:    "v2",
+								Address: "https://v2.example.com",
+								Weight:  1,
+							},
+						},
+					},
+				},
+			},
+			wantOptions: options.ConfigGeneratorOptions{
+				BackendSplits: []*options.BackendSplitOptions{
+					{
+						Selector: "1.a_example_com.DeleteA",
+						Backends: []*options.WeightedBackendOptions{
+							{
+								Name:    "v2",
+								Address: "https://v2.example.com",
+								Weight:  1,
+							},
+						},
+					},
+					{
+						Selector: "1.a_example_com.GetA",
+						Backends: []*options.WeightedBackendOptions{
+							{
+								Name:    "v1",
+								Address: "https://v1.example.com",
+								Weight:  90,
+							},
+							{
+								Name:    "v2",
+								Address: "https://v2.example.com",
+								Weight:  10,
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			desc: "Retry policies of the document and the operations, the flags take precedence",
+			doc: `{"openapi": "3.0.0",
+  "x-google-backend-retry": {"num_retries": 2, "retry_on": "5xx"},
+  "paths": {
+    "/a": {
+      "get": {"operationId": "GetA", "x-google-backend-retry": {"num_retries": 1, "retry_on": "5xx,reset", "per_try_timeout": 0.5, "hedge": true}},
+      "put": {"operationId": "PutA"},
+      "delete": {"operationId": "DeleteA"}
+    }
+  }
+}`,
+			flagOptions: options.ConfigGeneratorOptions{
+				BackendRetry: []*options.BackendRetryOptions{
+					{
+						Selector:   "1.a_example_com.DeleteA",
+						NumRetries: 3,
+						RetryOn:    "connect-failure",
+					},
+				},
+			},
+			wantOptions: options.ConfigGeneratorOptions{
+				BackendRetry: []*options.BackendRetryOptions{
+					{
+						Selector:   "1.a_example_com.DeleteA",
+						NumRetries: 3,
+						RetryOn:    "connect-failure",
+					},
+					{
+						Selector:      "1.a_example_com.GetA",
+						NumRetries:    1,
+						RetryOn:       "5xx,reset",
+						PerTryTimeout: 0.5,
+						Hedge:         true,
+					},
+					{
+						Selector:   "1.a_example_com.PutA",
+						NumRetries: 2,
+						RetryOn:    "5xx",
+					},
+				},
+			},
+		},
+		{
+			desc: "Hedged retry policy without per try timeout",
+			doc: `{"openapi": "3.0.0", "paths": {
+  "/a": {"get": {"x-google-backend-retry": {"retry_on": "5xx", "hedge": true}}}
+}}`,
+			wantError: "operation GET /a: x-google-backend-retry with hedge must have per_try_timeout",
+		},
+		{
+			desc: "Header rules of the document and the operations, the flags take precedence",
+			doc: `{"openapi": "3.0.0",
+  "x-google-headers": {"response_headers_to_remove": ["x-internal"]},
+  "paths": {
+    "/a": {
+      "get": {"operationId": "GetA", "x-google-headers": {"request_headers_to_add": [{"name": "x-env", "value": "prod", "overwrite": true}]}},
+      "put": {"operationId": "PutA", "x-google-headers": {"request_headers_to_remove": ["x-debug"]}}
+    }
+  }
+}`,
+			flagOptions: options.ConfigGeneratorOptions{
+				HeaderRules: []*options.HeaderRuleOptions{
+					{
+						Selector:               "1.a_example_com.PutA",
+						RequestHeadersToRemove: []string{"x-trace"},
+					},
+				},
+			},
+			wantOptions: options.ConfigGeneratorOptions{
+				HeaderRules: []*options.HeaderRuleOptions{
+					{
+						Selector:               "1.a_example_com.PutA",
+						RequestHeadersToRemove: []string{"x-trace"},
+					},
+					{
+						Selector:                "*",
+						ResponseHeadersToRemove: []string{"x-internal"},
+					},
+					{
+						Selector: "1.a_example_com.GetA",
+						RequestHeadersToAdd: []*options.HeaderValueOptions{
+							{
+								Name:      "x-env",
+								Value:     "prod",
+								Overwrite: true,
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			desc: "Header rule modifying a pseudo-header",
+			doc: `{"openapi": "3.0.0", "paths": {
+  "/a": {"get": {"x-google-headers": {"request_headers_to_remove": [":authority"]}}}
+}}`,
+			wantError: "operation GET /a: x-google-headers: pseudo-header :authority cannot be modified",
+		},
+		{
+			desc: "Path rewrites of the operations, the flags take precedence",
+			doc: `{"openapi": "3.0.0",
+  "paths": {
+    "/a": {
+      "get": {"operationId": "GetA", "x-google-path-rewrite": {"prefix": "/a", "prefix_rewrite": "/api/a"}},
+      "put": {"operationId": "PutA", "x-google-path-rewrite": {"regex": "^/a$", "substitution": "/b"}}
+    },
+    "/a/{id}": {
+      "get": {"operationId": "GetAId", "x-google-path-rewrite": {"regex": "^/a/([^/]+)$", "substitution": "/item/\\1"}}
+    }
+  }
+}`,
+			flagOptions: options.ConfigGeneratorOptions{
+				PathRewrites: []*options.PathRewriteOptions{
+					{
+						Selector:      "1.a_example_com.PutA",
+						Prefix:        "/a",
+						PrefixRewrite: "/c",
+					},
+				},
+			},
+			wantOptions: options.ConfigGeneratorOptions{
+				PathRewrites: []*options.PathRewriteOptions{
+					{
+						Selector:      "1.a_example_com.PutA",
+						Prefix:        "/a",
+						PrefixRewrite: "/c",
+					},
+					{
+						Selector:      "1.a_example_com.GetA",
+						Prefix:        "/a",
+						PrefixRewrite: "/api/a",
+					},
+					{
+						Selector:     "1.a_example_com.GetAId",
+						Regex:        "^/a/([^/]+)$",
+						Substitution: `/item/\1`,
+					},
+				},
+			},
+		},
+		{
+			desc: "Path rewrite with prefix and regex",
+			doc: `{"openapi": "3.0.0", "paths": {
+  "/a": {"get": {"x-google-path-rewrite": {"prefix": "/a", "regex": "^/a$"}}}
+}}`,
+			wantError: "operation GET /a: x-google-path-rewrite: exactly one of prefix and regex is required",
+		},
+		{
+			desc: "CORS policies of the document and the operations, the flags take precedence",
+			doc: `{"openapi": "3.0.0",
+  "x-google-cors": {"allow_origin_regexes": ["https://.*\\.example\\.com"], "max_age": 600},
+  "paths": {
+    "/a": {
+      "get": {"operationId": "GetA", "x-google-cors": {"allow_origin_regexes": ["https://app\\.example\\.com"], "allow_methods": ["GET"], "allow_credentials": true}},
+      "put": {"operationId": "PutA", "x-google-cors": {"allow_origin_regexes": [".*"]}}
+    }
+  }
+}`,
+			flagOptions: options.ConfigGeneratorOptions{
+				CorsPolicies: []*options.CorsPolicyOptions{
+					{
+						Selector:           "1.a_example_com.PutA",
+						AllowOriginRegexes: []string{"https://admin\\.example\\.com"},
+					},
+				},
+			},
+			wantOptions: options.ConfigGeneratorOptions{
+				CorsPolicies: []*options.CorsPolicyOptions{
+					{
+						Selector:           "1.a_example_com.PutA",
+						AllowOriginRegexes: []string{"https://admin\\.example\\.com"},
+					},
+					{
+						Selector:           "*",
+						AllowOriginRegexes: []string{"https://.*\\.example\\.com"},
+						MaxAge:             600,
+					},
+					{
+						Selector:           "1.a_example_com.GetA",
+						AllowOriginRegexes: []string{"https://app\\.example\\.com"},
+						AllowMethods:       []string{"GET"},
+						AllowCredentials:   true,
+					},
+				},
+			},
+		},
+		{
+			desc: "CORS policy without origin regexes",
+			doc: `{"openapi": "3.0.0", "paths": {
+  "/a": {"get": {"x-google-cors": {"allow_methods": ["GET"]}}}
+}}`,
+			wantError: "operation GET /a: x-google-cors: allow_origin_regexes is required for selector 1.a_example_com.get_a",
+		},
+		{
+			desc: "IP ACLs of the document and the operations, the flags take precedence",
+			doc: `{"openapi": "3.0.0",
+  "x-google-ip-acl": {"deny": ["10.1.0.0/16"]},
+  "paths": {
+    "/a": {
+      "get": {"operationId": "GetA", "x-google-ip-acl": {"allow": ["10.0.0.0/8"]}},
+      "put": {"operationId": "PutA", "x-google-ip-acl": {"allow": ["192.168.0.0/16"]}}
+    }
+  }
+}`,
+			flagOptions: options.ConfigGeneratorOptions{
+				IpAcls: []*options.IpAclOptions{
+					{
+						Selector: "1.a_example_com.PutA",
+						Allow:    []string{"172.16.0.0/12"},
+					},
+				},
+			},
+			wantOptions: options.ConfigGeneratorOptions{
+				IpAcls: []*options.IpAclOptions{
+					{
+						Selector: "1.a_example_com.PutA",
+						Allow:    []string{"172.16.0.0/12"},
+					},
+					{
+						Selector: "*",
+						Deny:     []string{"10.1.0.0/16"},
+					},
+					{
+						Selector: "1.a_example_com.GetA",
+						Allow:    []string{"10.0.0.0/8"},
+					},
+				},
+			},
+		},
+		{
+			desc: "IP ACL with an invalid range",
+			doc: `{"openapi": "3.0.0", "paths": {
+  "/a": {"get": {"x-google-ip-acl": {"allow": ["10.0.0.0/33"]}}}
+}}`,
+			wantError: `operation GET /a: x-google-ip-acl: invalid CIDR range "10.0.0.0/33" for selector 1.a_example_com.get_a`,
+		},
+		{
+			desc: "Backend mirrors of the operations, the flags take precedence",
+			doc: `{"openapi": "3.0.0", "paths": {
+  "/a": {
+    "get": {"operationId": "GetA", "x-google-backend-mirror": {"address": "https://v2.example.com", "fraction": 0.1}},
+    "delete": {"operationId": "DeleteA", "x-google-backend-mirror": {"address": "https://v2.example.com"}}
+  }
+}}`,
+			flagOptions: options.ConfigGeneratorOptions{
+				BackendMirrors: []*options.BackendMirrorOptions{
+					{
+						Selector: "1.a_example_com.DeleteA",
+						Address:  "https://v3.example.com",
+					},
+				},
+			},
+			wantOptions: options.ConfigGeneratorOptions{
+				BackendMirrors: []*options.BackendMirrorOptions{
+					{
+						Selector: "1.a_example_com.DeleteA",
+						Address:  "https://v3.example.com",
+					},
+					{
+						Selector: "1.a_example_com.GetA",
+						Address:  "https://v2.example.com",
+						Fraction: 0.1,
+					},
+				},
+			},
+		},
+		{
+			desc: "Fault injection of an operation",
+			doc: `{"openapi": "3.0.0", "paths": {
+  "/a": {"get": {"operationId": "GetA", "x-google-fault-injection": {"abort": {"percent": 5, "status": 503}}}}
+}}`,
+			wantOptions: options.ConfigGeneratorOptions{
+				FaultInjections: []*options.FaultInjectionOptions{
+					{
+						Selector: "1.a_example_com.GetA",
+						Abort: &options.FaultAbortOptions{
+							Percent: 5,
+							Status:  503,
+						},
+					},
+				},
+			},
+		},
+		{
+			desc: "Fault injection with an invalid delay",
+			doc: `{"openapi": "3.0.0", "paths": {
+  "/a": {"get": {"x-google-fault-injection": {"delay": {"percent": 150, "duration": 1}}}}
+}}`,
+			wantError: "operation GET /a: x-google-fault-injection: fault injection delay percent must be in (0, 100], got 150",
+		},
+		{
+			desc: "Direct response of an operation",
+			doc: `{"openapi": "3.0.0", "paths": {
+  "/robots.txt": {"get": {"operationId": "GetRobots", "x-google-direct-response": {"status": 200, "body": "User-agent: *\nDisallow: /\n"}}}
+}}`,
+			wantOptions: options.ConfigGeneratorOptions{
+				DirectResponses: []*options.DirectResponseOptions{
+					{
+						Selector: "1.a_example_com.GetRobots",
+						Status:   200,
+						Body:     "User-agent: *\nDisallow: /\n",
+					},
+				},
+			},
+		},
+		{
+			desc: "Direct response with an invalid status",
+			doc: `{"openapi": "3.0.0", "paths": {
+  "/a": {"get": {"x-google-direct-response": {"status": 42}}}
+}}`,
+			wantError: "operation GET /a: x-google-direct-response: direct response status must be between 200 and 599, got 42",
+		},
+		{
+			desc: "Backend mirror without address",
+			doc: `{"openapi": "3.0.0", "paths": {
+  "/a": {"get": {"x-google-backend-mirror": {"fraction": 0.5}}}
+}}`,
+			wantError: "operation GET /a: x-google-backend-mirror must have an address",
+		},
+		{
+			desc: "Backend split without backends",
+			doc: `{"openapi": "3.0.0", "paths": {
+  "/a": {"get": {"x-google-backend-split": {"backends": []}}}
+}}`,
+			wantError: "operation GET /a: x-google-backend-split must have backends",
+		},
+		{
+			desc: "Circuit breakers and outlier detection of the backends, the flags take precedence",
+			doc: `{"openapi": "3.0.0",
+  "x-google-backend": {"address": "https://api.example.com", "circuit_breaker": {"max_pending_requests": 100}},
+  "paths": {
+    "/a": {
+      "get": {"x-google-backend": {"address": "https://b.example.com/v1", "outlier_detection": {"consecutive_5xx": 5}}},
+      "delete": {"x-google-backend": {"address": "https://b.example.com:443/v2", "outlier_detection": {"consecutive_5xx": 5}}}
+    }
+  }
+}`,
+			flagOptions: options.ConfigGeneratorOptions{
+				BackendClusters: []*options.BackendClusterOptions{
+					{
+						BackendAddress: "https://api.example.com:443",
+						CircuitBreaker: &options.CircuitBreakerOptions{
+							MaxPendingRequests: 10,
+						},
+					},
+				},
+			},
+			wantOptions: options.ConfigGeneratorOptions{
+				BackendClusters: []*options.BackendClusterOptions{
+					{
+						BackendAddress: "https://api.example.com:443",
+						CircuitBreaker: &options.CircuitBreakerOptions{
+							MaxPendingRequests: 10,
+						},
+					},
+					{
+						BackendAddress: "https://b.example.com/v1",
+						OutlierDetection: &options.OutlierDetectionOptions{
+							Consecutive5xx: 5,
+						},
+					},
+				},
+			},
+		},
+		{
+			desc: "Different outlier detection of the same backend",
+			doc: `{"openapi": "3.0.0", "paths": {
+  "/a": {
+    "get": {"x-google-backend": {"address": "https://b.example.com/v1", "outlier_detection": {"consecutive_5xx": 5}}},
+    "delete": {"x-google-backend": {"address": "https://b.example.com/v2", "outlier_detection": {"consecutive_5xx": 3}}}
+  }
+}}`,
+			wantError: "operation DELETE /a: x-google-backend extensions of backend https://b.example.com/v2 have different circuit_breaker, outlier_detection, health_check, load_balancing, failover_addresses or iam_delegates",
+		},
+		{
+			desc: "Invalid outlier detection of the backend",
+			doc: `{"openapi": "3.0.0", "paths": {
+  "/a": {"get": {"x-google-backend": {"address": "https://b.example.com", "outlier_detection": {"consecutive_5xx": 5, "interval": -1}}}}
+}}`,
+			wantError: "operation GET /a: x-google-backend: outlier detection interval must be >= 0, got -1",
+		},
+		{
+			desc: "Load balancing policy of the backend",
+			doc: `{"openapi": "3.0.0", "paths": {
+  "/a": {"get": {"x-google-backend": {"address": "https://b.example.com", "load_balancing": {"policy": "ring_hash", "hash_cookie": "session"}}}}
+}}`,
+			wantOptions: options.ConfigGeneratorOptions{
+				BackendClusters: []*options.BackendClusterOptions{
+					{
+						BackendAddress: "https://b.example.com",
+						LoadBalancing: &options.LoadBalancingOptions{
+							Policy:     "ring_hash",
+							HashCookie: "session",
+						},
+					},
+				},
+			},
+		},
+		{
+			desc: "Failover addresses of the backend",
+			doc: `{"openapi": "3.0.0", "paths": {
+  "/a": {"get": {"x-google-backend": {"address": "https://foo-12345-uc.a.run.app", "failover_addresses": ["https://foo-12345-ew.a.run.app"]}}}
+}}`,
+			wantOptions: options.ConfigGeneratorOptions{
+				BackendClusters: []*options.BackendClusterOptions{
+					{
+						BackendAddress:    "https://foo-12345-uc.a.run.app",
+						FailoverAddresses: []string{"https://foo-12345-ew.a.run.app"},
+					},
+				},
+			},
+		},
+		{
+			desc: "IAM delegates of the backend",
+			doc: `{"openapi": "3.0.0", "paths": {
+  "/a": {"get": {"x-google-backend": {"address": "https://foo-12345-uc.a.run.app", "iam_delegates": ["sa@p.iam.gserviceaccount.com"]}}}
+}}`,
+			wantOptions: options.ConfigGeneratorOptions{
+				BackendClusters: []*options.BackendClusterOptions{
+					{
+						BackendAddress: "https://foo-12345-uc.a.run.app",
+						IamDelegates:   []string{"sa@p.iam.gserviceaccount.com"},
+					},
+				},
+			},
+		},
+		{
+			desc: "Consistent hash load balancing policy without hash key",
+			doc: `{"openapi": "3.0.0", "paths": {
+  "/a": {"get": {"x-google-backend": {"address": "https://b.example.com", "load_balancing": {"policy": "maglev"}}}}
+}}`,
+			wantError: "operation GET /a: x-google-backend: load balancing policy maglev requires hash_header, hash_cookie or hash_source_ip",
+		},
+		{
+			desc: "Health checks of the backends",
+			doc: `{"openapi": "3.0.0",
+  "x-google-backend": {"address": "https://api.example.com", "health_check": {"path": "/healthz", "interval": 10}},
+  "paths": {
+    "/a": {
+      "get": {"x-google-backend": {"address": "grpcs://b.example.com", "protocol": "h2", "health_check": {"grpc": true, "grpc_service": "b.v1.B"}}}
+    }
+  }
+}`,
+			wantOptions: options.ConfigGeneratorOptions{
+				BackendClusters: []*options.BackendClusterOptions{
+					{
+						BackendAddress: "https://api.example.com",
+						HealthCheck: &options.HealthCheckOptions{
+							Path:     "/healthz",
+							Interval: 10,
+						},
+					},
+					{
+						BackendAddress: "grpcs://b.example.com",
+						HealthCheck: &options.HealthCheckOptions{
+							Grpc:        true,
+							GrpcService: "b.v1.B",
+						},
+					},
+				},
+			},
+		},
+		{
+			desc: "Health check with both path and grpc",
+			doc: `{"openapi": "3.0.0", "paths": {
+  "/a": {"get": {"x-google-backend": {"address": "https://b.example.com", "health_check": {"path": "/healthz", "grpc": true}}}}
+}}`,
+			wantError: "operation GET /a: x-google-backend: health check path and grpc cannot be set together",
+		},
+		{
+			desc: "Authorization rule without policies",
+			doc: `{"openapi": "3.0.0", "paths": {
+  "/a": {"get": {"x-google-authorization": {"allow": []}}}
+}}`,
+			wantError: "operation GET /a: x-google-authorization must have allow policies",
+		},
+	}
+
+	for _, tc := range testData {
+		opts := tc.flagOptions
+		err := ApplyOptions([]byte(tc.doc), "a.example.com", &opts)
+		if tc.wantError != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.wantError) {
+				t.Errorf("Test Desc(%s): expected error containing %q, got: %v", tc.desc, tc.wantError, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Test Desc(%s): ApplyOptions got error: %v", tc.desc, err)
+		}
+		if !reflect.DeepEqual(opts, tc.wantOptions) {
+			t.Errorf("Test Desc(%s): got options %+v, want %+v", tc.desc, opts, tc.wantOptions)
+		}
+	}
+}
@@ -24,6 +24,7 @@ type AdsBootstrapperOptions struct {
 
 	// Flags for ADS
 	AdsConnectTimeout time.Duration
+	AdsDelta          bool
 	DiscoveryAddress  string
 }
 
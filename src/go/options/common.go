@@ -27,24 +27,71 @@ type CommonOptions struct {
 	DiscoveryPort int
 	EnableAdmin   bool
 	Node          string
+	// How long Envoy drains the connections on shutdown, failing its health
+	// checks, before it is stopped. The admin interface is served on the
+	// loopback address to start the drain even if EnableAdmin is false.
+	ShutdownDrainTime time.Duration
 
 	// Flags for tracing
 	DisableTracing             bool
 	TracingProjectId           string
 	TracingStackdriverAddress  string
 	TracingSamplingRate        float64
+	TracingSampler             string
 	TracingIncomingContext     string
 	TracingOutgoingContext     string
+	TracingContextFormat       string
 	TracingMaxNumAttributes    int64
 	TracingMaxNumAnnotations   int64
 	TracingMaxNumMessageEvents int64
 	TracingMaxNumLinks         int64
+	// The exporter traces are sent to: "stackdriver", or "ocagent" and
+	// "zipkin" to send them to an OpenCensus agent or a Zipkin collector,
+	// e.g. an OpenTelemetry Collector in front of Jaeger, Tempo or Honeycomb.
+	TracingExporter       string
+	TracingOcagentAddress string
+	TracingZipkinUrl      string
+
+	// Run without any Google dependency, for the deployments outside of GCP.
+	// It implies NonGCP, skips the metadata server and the Stackdriver tracing
+	// without a TracingProjectId. The Service Control filter is skipped unless
+	// it calls a user-hosted server without the access token. The service
+	// config must not be fetched from Service Management or Cloud Storage.
+	Standalone bool
 
 	// Flags for metadata
 	NonGCP             bool
 	HttpRequestTimeout time.Duration
 	MetadataURL        string
-	IamURL             string
+	// Headers sent on all requests to the metadata server, e.g. when it is
+	// fronted by a proxy.
+	MetadataHeaders map[string]string
+	// If set, a session token is fetched with a PUT request to MetadataTokenURL,
+	// asking for MetadataTokenTTL in MetadataTokenTTLHeader, and sent in
+	// MetadataTokenHeader on requests to the metadata server, as in AWS IMDSv2.
+	MetadataTokenURL       string
+	MetadataTokenHeader    string
+	MetadataTokenTTLHeader string
+	MetadataTokenTTL       time.Duration
+	IamURL                 string
+	// Proxies of the outbound calls of the config manager and the
+	// bootstrapper. Empty values are taken from the HTTP_PROXY, HTTPS_PROXY
+	// and NO_PROXY environment variables.
+	HttpProxy  string
+	HttpsProxy string
+	NoProxy    string
+	// Flags for overload protection
+	// The heap size monitored by the Envoy overload manager, disabled if 0.
+	// When the heap usage reaches OverloadShrinkHeapThreshold of it, the free
+	// memory is released to the system, and when it reaches
+	// OverloadStopAcceptingRequestsThreshold, new requests are rejected.
+	OverloadMaxHeapSizeBytes               uint64
+	OverloadShrinkHeapThreshold            float64
+	OverloadStopAcceptingRequestsThreshold float64
+	// The maximum number of connections accepted on each downstream listener,
+	// unlimited if 0.
+	MaxDownstreamConnections uint64
+
 	// Configures the identity used when making requests to Service Control.
 	ServiceControlCredentials *IAMCredentialsOptions
 	// Configures the identity used when making requests to backends.
@@ -85,18 +132,39 @@ func DefaultCommonOptions() CommonOptions {
 		HttpRequestTimeout:         5 * time.Second,
 		Node:                       "ESPv2",
 		NonGCP:                     false,
+		Standalone:                 false,
 		TracingProjectId:           "",
 		TracingStackdriverAddress:  "",
 		TracingSamplingRate:        0.001,
+		TracingSampler:             "probability",
 		TracingIncomingContext:     "",
 		TracingOutgoingContext:     "",
+		TracingContextFormat:       "",
 		TracingMaxNumAttributes:    32,
 		TracingMaxNumAnnotations:   32,
 		TracingMaxNumMessageEvents: 128,
 		TracingMaxNumLinks:         128,
+		TracingExporter:            "stackdriver",
+		TracingOcagentAddress:      "",
+		TracingZipkinUrl:           "",
 		MetadataURL:                "http://169.254.169.254/computeMetadata",
+		MetadataHeaders:            nil,
+		MetadataTokenURL:           "",
+		MetadataTokenHeader:        "X-aws-ec2-metadata-token",
+		MetadataTokenTTLHeader:     "X-aws-ec2-metadata-token-ttl-seconds",
+		MetadataTokenTTL:           6 * time.Hour,
 		IamURL:                     "https://iamcredentials.googleapis.com",
+		HttpProxy:                  "",
+		HttpsProxy:                 "",
+		NoProxy:                    "",
 		ServiceControlCredentials:  nil,
 		BackendAuthCredentials:     nil,
+
+		OverloadMaxHeapSizeBytes:               0,
+		OverloadShrinkHeapThreshold:            0.95,
+		OverloadStopAcceptingRequestsThreshold: 0.98,
+		MaxDownstreamConnections:               0,
+
+		ShutdownDrainTime: 0,
 	}
 }
@@ -15,6 +15,10 @@
 package options
 
 import (
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
 	"time"
 
 	"github.com/GoogleCloudPlatform/esp-v2/src/go/util"
@@ -33,9 +37,55 @@ type ConfigGeneratorOptions struct {
 	CorsAllowOriginRegex string
 	CorsExposeHeaders    string
 	CorsPreset           string
+	// CORS policies of the routes of operations, which override the policy of
+	// the preset on them.
+	CorsPolicies []*CorsPolicyOptions
+	// If true, an OPTIONS operation is generated for the paths of all the
+	// operations when CORS is enabled by the preset or the policies, like
+	// with allow_cors in the endpoints of the service config. The generated
+	// OPTIONS operations are exempt from authentication and reported to
+	// service control, unless CorsPreflightRequireAuth or
+	// CorsPreflightSkipServiceControl are set.
+	CorsPreflightOperations         bool
+	CorsPreflightRequireAuth        bool
+	CorsPreflightSkipServiceControl bool
 
 	// Backend routing configurations.
 	BackendDnsLookupFamily string
+	// DNS refresh rate of the backends, 0 for the default of Envoy.
+	BackendDnsRefreshRate time.Duration
+	// If set, the DNS TTLs override BackendDnsRefreshRate.
+	BackendDnsRespectTtl bool
+	// If set, the backends are resolved with STRICT_DNS, balancing the
+	// requests between all the addresses of their hostname, instead of
+	// LOGICAL_DNS connecting to one address at a time.
+	BackendStrictDns bool
+	// If set, the endpoints of the backend in BackendAddress are discovered
+	// with EDS from the xDS server in BackendEdsServerUri, like Traffic
+	// Director, for BackendEdsServiceName, instead of resolved with DNS. With
+	// BackendEdsGoogleCredentials, the xDS server is called over TLS with the
+	// access tokens of the metadata server, as required by Traffic Director.
+	BackendEdsServerUri         string
+	BackendEdsServiceName       string
+	BackendEdsGoogleCredentials bool
+	// If set, the single-label hostnames of the backends, like bookstore in
+	// http://bookstore:8080, are the Kubernetes services of this namespace,
+	// resolved as {hostname}.{namespace}.svc.{KubernetesClusterDomain}.
+	KubernetesNamespace     string
+	KubernetesClusterDomain string
+	// Circuit breaker thresholds and outlier detection of all the backends,
+	// overridden by the ones of each backend.
+	BackendCircuitBreaker   CircuitBreakerOptions
+	BackendOutlierDetection OutlierDetectionOptions
+	// Active health check of all the backends, overridden by the one of each
+	// backend.
+	BackendHealthCheck HealthCheckOptions
+	// Connection pool of all the backends, overridden by the one of each
+	// backend.
+	BackendConnectionPool ConnectionPoolOptions
+	// Load balancing policy of all the backends, replaced by the one of each
+	// backend.
+	BackendLoadBalancing LoadBalancingOptions
 
 	// Envoy specific configurations.
 	ClusterConnectTimeout time.Duration
@@ -44,16 +94,232 @@ type ConfigGeneratorOptions struct {
 	BackendAddress string
 
 	// Network related configurations.
-	ListenerAddress      string
-	Healthz              string
+	ListenerAddress string
+	Healthz         string
+	// Name of the backend's gRPC health service checked by the
+	// grpc.health.v1.Health service of the proxy, empty if disabled.
+	HealthGrpcService    string
 	ServiceManagementURL string
+	ServiceControlURL    string
 	ListenerPort         int
 	SslServerCertPath    string
 	SslClientCertPath    string
 	RootCertsPath        string
 
+	// TLS configurations of the backend in BackendAddress.
+	BackendMtlsCertPath          string
+	BackendMtlsKeyPath           string
+	BackendCaPath                string
+	BackendVerifySubjectAltNames string
+
+	// TLS settings of the calls to the dependencies of the proxy, instead of
+	// RootCertsPath, e.g. in private PKI environments.
+	OutboundTls []*OutboundTlsOptions
+
+	// OAuth2 client credentials grants of the backends in BackendRules, used
+	// instead of identity tokens to authenticate to them.
+	BackendOAuth2 []*BackendOAuth2Options
+
+	// Connection and TLS settings of the clusters of the backends in
+	// BackendRules.
+	BackendClusters []*BackendClusterOptions
+
+	// Retry policies of the routes to the backends, by operation.
+	BackendRetry []*BackendRetryOptions
+
+	// Headers added to or removed from the requests to the backends and their
+	// responses, by operation.
+	HeaderRules []*HeaderRuleOptions
+
+	// Rewrites of the paths of the requests to the backends, by operation.
+	PathRewrites []*PathRewriteOptions
+
+	// Caches of the responses of the GET requests, by operation. At most
+	// ResponseCacheMaxEntries responses are cached per worker thread, with
+	// bodies of at most ResponseCacheMaxBodyBytes.
+	ResponseCaches            []*ResponseCacheOptions
+	ResponseCacheMaxEntries   uint32
+	ResponseCacheMaxBodyBytes uint32
+
+	// Splits of the requests of operations between weighted backends, and the
+	// request header forcing one of them by name, disabled if empty.
+	BackendSplits      []*BackendSplitOptions
+	BackendSplitHeader string
+
+	// Mirrors of the requests of operations to secondary backends, whose
+	// responses are ignored.
+	BackendMirrors []*BackendMirrorOptions
+
+	// Faults injected in the requests of operations for chaos experiments,
+	// only in the requests with the FaultInjectionHeader.
+	FaultInjections      []*FaultInjectionOptions
+	FaultInjectionHeader string
+
+	// Canned responses of operations, returned without a backend.
+	DirectResponses []*DirectResponseOptions
+
+	// WebSocket upgrades, allowed on all routes or only on the routes of the
+	// comma separated operations.
+	EnableWebsocket    bool
+	WebsocketSelectors string
+
+	// If true, the gRPC-Web requests of browsers are converted to gRPC before
+	// the other filters, and CORS allows the gRPC-Web headers.
+	EnableGrpcWeb bool
+
+	// If true, the responses are compressed with gzip for the clients
+	// accepting it, if they have at least ResponseCompressionMinContentLength
+	// bytes and one of the comma separated ResponseCompressionContentTypes, or
+	// of the default types of Envoy if empty. ResponseCompressionLevel is
+	// "default", "best" or "speed".
+	EnableResponseCompression           bool
+	ResponseCompressionMinContentLength uint32
+	ResponseCompressionContentTypes     string
+	ResponseCompressionLevel            string
+
+	// The requests whose path does not match any operation are rejected with
+	// a 404 with UnmatchedPathBody of type UnmatchedPathContentType if set,
+	// redirected to UnmatchedPathRedirectUrl if set, or routed to the backend
+	// of BackendAddress with UnmatchedPathToBackend. At most one of them is
+	// set, the 404 of the Path Matcher filter is used otherwise.
+	UnmatchedPathBody        string
+	UnmatchedPathContentType string
+	UnmatchedPathRedirectUrl string
+	UnmatchedPathToBackend   bool
+
+	// The bodies of the error responses of the proxy are rewritten with the
+	// template of their status code in ErrorResponseTemplates, or in the
+	// ErrorResponseFormat, "json" or "problem_json", if set.
+	ErrorResponseFormat    string
+	ErrorResponseTemplates []*ErrorResponseTemplateOptions
+
+	// Options of the gRPC-JSON transcoder. The gRPC methods are transcoded
+	// from POST requests to their gRPC paths unless auto mapping is disabled.
+	// The descriptor set is read from TranscodingDescriptorPath instead of the
+	// service config if set, and reloaded when the file changes.
+	TranscodingDescriptorPath               string
+	TranscodingAlwaysPrintPrimitiveFields   bool
+	TranscodingAlwaysPrintEnumsAsInts       bool
+	TranscodingPreserveProtoFieldNames      bool
+	TranscodingIgnoreUnknownQueryParameters bool
+	TranscodingMatchIncomingRequestRoute    bool
+	TranscodingDisableAutoMapping           bool
+
+	// UDP port of the HTTP/3 listener, 0 if disabled, and the path to its
+	// certificate and key, SslServerCertPath if empty. The listener is
+	// advertised in alt-svc response headers, cached by the clients for
+	// Http3AltSvcMaxAge, 0 if not advertised.
+	Http3Port              int
+	Http3SslServerCertPath string
+	Http3AltSvcMaxAge      time.Duration
+
+	// If true, the certificate in SslServerCertPath is sent to Envoy over SDS
+	// and rotated without draining the listener when its files change.
+	SslServerCertSds bool
+	// ACME server issuing and renewing the certificate in SslServerCertPath
+	// for the comma separated domains, empty if disabled. The HTTP-01
+	// challenges are served by the config manager on
+	// SslServerAcmeChallengePort, behind a plain HTTP listener on
+	// SslServerAcmeHttpPort redirecting the other requests to HTTPS.
+	SslServerAcmeDirectoryUrl  string
+	SslServerAcmeDomains       string
+	SslServerAcmeEmail         string
+	SslServerAcmeHttpPort      int
+	SslServerAcmeChallengePort int
+
+	// CA certificates validating the client certificates of the listener,
+	// which are requested if set, and required with SslServerRequireClientCert.
+	// With SslServerClientSpiffeTrustDomain, the client certificates must have
+	// a SPIFFE ID in the trust domain. With SslServerForwardClientCert, the
+	// subject and SANs of the client certificates are sent to the backend and
+	// reported to Service Control in the x-forwarded-client-cert header.
+	SslServerClientCaPath            string
+	SslServerRequireClientCert       bool
+	SslServerClientSpiffeTrustDomain string
+	SslServerForwardClientCert       bool
+	// Certificates served to the clients requesting their server names with
+	// SNI, instead of the certificate in SslServerCertPath.
+	SslServerSniCertificates []*SniCertificateOptions
+
+	// Comma separated "<selector>=<rate>" overrides of the percentage of the
+	// requests traced by Envoy on the routes of the operations, and the
+	// percentage of the requests with the x-client-trace-id header whose
+	// tracing is forced.
+	TracingOperationSampleRates string
+	TracingClientSampleRate     float64
+	// Comma separated attributes of the requests set as tags on their spans
+	// by the Service Control filter.
+	TracingCustomTags string
+
+	// Access logs of the requests, written to AccessLogPath, like
+	// /dev/stdout, with AccessLogFormat or the JSON fields of
+	// AccessLogJsonFormat, and sent to the gRPC Access Log Service at
+	// AccessLogServiceUri. Files larger than AccessLogMaxBytes are rotated,
+	// keeping AccessLogMaxFiles of them. The requests to the comma separated
+	// operations in AccessLogDisabledSelectors are not logged.
+	AccessLogPath              string
+	AccessLogFormat            string
+	AccessLogJsonFormat        string
+	AccessLogServiceUri        string
+	AccessLogServiceLogName    string
+	AccessLogMaxBytes          int64
+	AccessLogMaxFiles          int
+	AccessLogDisabledSelectors string
+
+	// Maximum sizes of the request and response bodies, 0 if unlimited, and
+	// their overrides by operation.
+	MaxRequestBodyBytes  uint32
+	MaxResponseBodyBytes uint32
+	BodySizeLimits       []*BodySizeLimitOptions
+
+	// Comma separated API key locations, like "header:x-api-key" or
+	// "cookie:api_key", replacing the default ones of the operations without
+	// locations in the service config, and their overrides by operation.
+	ApiKeyLocations       string
+	ApiKeyLocationsConfig []*ApiKeyLocationOptions
+
+	// Comma separated "<claim>=<header>" pairs, forwarding the claims of the
+	// verified JWTs to the backend in request headers for the operations
+	// requiring JWTs, and their overrides by operation.
+	JwtClaimHeaders       string
+	JwtClaimHeadersConfig []*JwtClaimHeaderOptions
+
+	// Rules allowing the requests of the operations by the claims of their
+	// verified JWTs.
+	AuthorizationRules []*AuthorizationRuleOptions
+
+	// Uri of the external authorization server, "grpc://", "grpcs://",
+	// "http://" or "https://", checking the requests before Service Control,
+	// except for the operations in ExtAuthzDisabledSelectors.
+	ExtAuthzUri               string
+	ExtAuthzTimeout           time.Duration
+	ExtAuthzFailureModeAllow  bool
+	ExtAuthzDisabledSelectors string
+
+	// CIDR ranges of the client addresses allowed or denied to call the
+	// operations, by operation.
+	IpAcls []*IpAclOptions
+
+	// Token-bucket limits of the requests to all the operations, 0 if
+	// unlimited, and overrides by selector. The burst defaults to the
+	// requests per second.
+	RateLimitRequestsPerSecond uint32
+	RateLimitBurst             uint32
+	RateLimits                 []*RateLimitOptions
+
+	// Uri of an Envoy rate limit service, "grpc://" or "grpcs://", asked for
+	// the requests of each operation in addition to the local limits.
+	RateLimitServiceUri             string
+	RateLimitServiceTimeout         time.Duration
+	RateLimitServiceFailureModeDeny bool
+
+	// Comma separated "<provider_id>=<path>" pairs, replacing the jwks_uri of
+	// the JWT providers with local JWKS files.
+	LocalJwks string
+
 	// Flags for non_gcp deployment.
 	ServiceAccountKey string
+	TokenAgentPort    int
 
 	// Flags for testing purpose.
 	SkipJwtAuthnFilter       bool
@@ -62,17 +328,52 @@ type ConfigGeneratorOptions struct {
 	// Envoy configurations.
 	EnvoyUseRemoteAddress  bool
 	EnvoyXffNumTrustedHops int
+	// Comma separated CIDR ranges of the trusted proxies in front of the
+	// proxy, overriding EnvoyUseRemoteAddress and EnvoyXffNumTrustedHops if
+	// set. The client address is the rightmost address of x-forwarded-for
+	// not appended by one of them, as seen by the filters after IP ACL
+	// filter, reported to Service Control and logged.
+	TrustedProxyCidrs string
+	// If true, the listener accepts the PROXY protocol, taking the client
+	// address of the connections from their PROXY header.
+	EnableProxyProtocol bool
+
+	// The request IDs in the x-request-id header are generated for the
+	// requests without one, and for the external requests with
+	// EnvoyUseRemoteAddress, if RequestIdMode is "generate", only for the
+	// requests without one if "preserve", and never if "none". If set, they
+	// are copied to RequestIdHeader in the requests to the backends and in the
+	// responses. With LogRequestId, they are reported to Service Control and
+	// logged in the JSON access logs.
+	RequestIdMode   string
+	RequestIdHeader string
+	LogRequestId    bool
 
 	LogJwtPayloads            string
 	LogRequestHeaders         string
 	LogResponseHeaders        string
 	MinStreamReportIntervalMs uint64
+	// Custom labels of the operations reported to Service Control, comma
+	// separated "<label>=header:<header>" or "<label>=jwt:<claim path>".
+	ServiceControlReportLabels string
+	// Request headers set to the consumer project number and to the consumer
+	// ID of the requests checked with an API key, before they are sent to the
+	// backend. The project header is "x-endpoint-api-project-id" if empty,
+	// the consumer ID header is not set if empty.
+	ConsumerProjectHeader string
+	ConsumerIdHeader      string
 
 	SuppressEnvoyHeaders bool
 
 	ServiceControlNetworkFailOpen bool
 
 	JwksCacheDurationInS int
+	// If true, the config manager fetches the JWKS of the remote JWT providers
+	// in advance and inlines them, refreshing them every cache duration.
+	JwksAsyncFetch   bool
+	JwksFetchRetries int
+	// Overrides of the JWKS cache duration and fetching by provider.
+	JwksProviders []*JwksProviderOptions
 
 	ScCheckTimeoutMs  int
 	ScQuotaTimeoutMs  int
@@ -82,9 +383,717 @@ type ConfigGeneratorOptions struct {
 	ScQuotaRetries  int
 	ScReportRetries int
 
+	ScReportBatchMaxSize    int
+	ScReportBatchMaxDelayMs int
+	ScReportBatchMaxBytes   int
+
+	ScCircuitBreakerFailureThreshold int
+	ScCircuitBreakerOpenDurationMs   int
+	ScCircuitBreakerFailOpen         bool
+	ScReportQueueMaxBytes            int
+	ScReportSpillDir                 string
+	ScReportSpillMaxBytes            int
+
+	ScCheckCacheTtlMs         int
+	ScCheckCacheMaxEntries    int
+	ScCheckCacheNegativeTtlMs int
+	ScCheckCacheServeStale    bool
+
+	// How the service control calls are sent to the service control server,
+	// e.g. to a user-hosted server implementing the Service Control API: the
+	// protocol, "http" or "grpc", the paths of the Check, AllocateQuota and
+	// Report calls overriding the ones of the protocol, and the credentials of
+	// the calls, "access_token", "none", or "header" with the header name and
+	// the file of its value.
+	ServiceControlProtocol            string
+	ServiceControlCheckPath           string
+	ServiceControlQuotaPath           string
+	ServiceControlReportPath          string
+	ServiceControlAuth                string
+	ServiceControlAuthHeaderName      string
+	ServiceControlAuthHeaderValuePath string
+
+	ScQuotaBucketBurst            int
+	ScQuotaBucketRefillIntervalMs int
+
 	ComputePlatformOverride string
 }
 
+// BackendClusterOptions configures the cluster of a backend in the
+// x-google-backend extension, instead of the defaults of the dynamic routing.
+type BackendClusterOptions struct {
+	// Address of the backend, as in the x-google-backend extension. Only its
+	// host and port are matched with the address of the BackendRules.
+	BackendAddress string `json:"backend_address"`
+	// Timeout in seconds to connect to the backend. 0 uses
+	// --cluster_connect_timeout.
+	ConnectTimeout float64 `json:"connect_timeout"`
+	// TLS settings of the backend, which must use https or grpcs. The CA
+	// certificates default to --root_certs_path, and the SNI to the host of
+	// the backend if it is not an IP address.
+	CaPath                string   `json:"ca_path"`
+	MtlsCertPath          string   `json:"mtls_cert_path"`
+	MtlsKeyPath           string   `json:"mtls_key_path"`
+	VerifySubjectAltNames []string `json:"verify_subject_alt_names"`
+	Sni                   string   `json:"sni"`
+	// Addresses of the same backend deployed in other regions, like the URLs
+	// of a Cloud Run service in each region, with the scheme and port of
+	// BackendAddress and without path. The requests are balanced between the
+	// regions, favoring the ones with the least active requests. The ID tokens
+	// sent to all the regions have the audience of BackendAddress.
+	RegionAddresses []string `json:"region_addresses"`
+	// Addresses of the same backend deployed in other regions, like
+	// RegionAddresses, in the order of failover. The requests are only sent
+	// to the first of them with healthy instances when BackendAddress and its
+	// RegionAddresses are unhealthy, as detected by the outlier detection or
+	// the health check.
+	FailoverAddresses []string `json:"failover_addresses"`
+	// Other endpoints of the same backend, as "host" or "host:port" with the
+	// port of BackendAddress by default, e.g. the servers of an on-prem
+	// backend. The requests are balanced round robin between BackendAddress
+	// and the endpoints, all resolved with STRICT_DNS.
+	Endpoints []string `json:"endpoints"`
+	// DNS settings of the backend, overriding --backend_dns_lookup_family,
+	// --backend_dns_refresh_rate_s, --backend_dns_respect_ttl and
+	// --backend_strict_dns. The refresh rate is in seconds, 0 for the flag.
+	DnsLookupFamily string  `json:"dns_lookup_family"`
+	DnsRefreshRate  float64 `json:"dns_refresh_rate"`
+	RespectDnsTtl   *bool   `json:"respect_dns_ttl,omitempty"`
+	StrictDns       *bool   `json:"strict_dns,omitempty"`
+	// Circuit breaker thresholds and outlier detection of the backend. Their
+	// fields left to 0 use the flags.
+	CircuitBreaker   *CircuitBreakerOptions   `json:"circuit_breaker,omitempty"`
+	OutlierDetection *OutlierDetectionOptions `json:"outlier_detection,omitempty"`
+	// Active health check of the backend. If it sets path or grpc, they
+	// replace the ones of the flags. Its other fields left to 0 use the flags.
+	HealthCheck *HealthCheckOptions `json:"health_check,omitempty"`
+	// Connection pool of the backend. Its fields left to 0 use the flags.
+	ConnectionPool *ConnectionPoolOptions `json:"connection_pool,omitempty"`
+	// Load balancing policy of the backend, replacing the one of the flags.
+	LoadBalancing *LoadBalancingOptions `json:"load_balancing,omitempty"`
+	// Delegation chain of service accounts used to fetch the ID tokens of the
+	// backend from IAM, replacing --backend_auth_iam_delegates. Requires
+	// --backend_auth_iam_service_account.
+	IamDelegates []string `json:"iam_delegates"`
+}
+
+// LoadBalancingOptions selects how the requests are balanced between the
+// instances of a backend: "round_robin", "least_request", or "ring_hash" and
+// "maglev", which consistently send the requests with the same hash to the
+// same instance, for the backends requiring sticky sessions. The hash is the
+// one of the HashHeader header, of the HashCookie cookie, or of the source IP
+// with HashSourceIp, in this order. If HashCookieTtl is set, the cookie is
+// generated with this TTL in seconds if the request does not have it. The
+// requests without any of them are sent to a random instance. An empty policy
+// uses round robin, or least request for the backends with region addresses.
+type LoadBalancingOptions struct {
+	Policy        string  `json:"policy"`
+	HashHeader    string  `json:"hash_header"`
+	HashCookie    string  `json:"hash_cookie"`
+	HashCookieTtl float64 `json:"hash_cookie_ttl"`
+	HashSourceIp  bool    `json:"hash_source_ip"`
+}
+
+// IsConsistentHash returns true if the policy hashes the requests.
+func (o *LoadBalancingOptions) IsConsistentHash() bool {
+	return o.Policy == "ring_hash" || o.Policy == "maglev"
+}
+
+// Validate returns an error if the policy is unknown, or if the hash keys are
+// not set with a consistent hash policy.
+func (o *LoadBalancingOptions) Validate() error {
+	switch o.Policy {
+	case "", "round_robin", "least_request", "ring_hash", "maglev":
+	default:
+		return fmt.Errorf(`load balancing policy must be "round_robin", "least_request", "ring_hash" or "maglev", got %q`, o.Policy)
+	}
+	hasHashKey := o.HashHeader != "" || o.HashCookie != "" || o.HashSourceIp
+	if o.IsConsistentHash() && !hasHashKey {
+		return fmt.Errorf("load balancing policy %s requires hash_header, hash_cookie or hash_source_ip", o.Policy)
+	}
+	if !o.IsConsistentHash() && hasHashKey {
+		return fmt.Errorf("hash_header, hash_cookie and hash_source_ip require the ring_hash or maglev load balancing policy")
+	}
+	if o.HashCookieTtl < 0 {
+		return fmt.Errorf("hash_cookie_ttl must be >= 0, got %v", o.HashCookieTtl)
+	}
+	if o.HashCookieTtl > 0 && o.HashCookie == "" {
+		return fmt.Errorf("hash_cookie_ttl requires hash_cookie")
+	}
+	return nil
+}
+
+// ConnectionPoolOptions tunes the connections to a backend. The HTTP/2
+// settings, MaxConcurrentStreams and the initial window sizes in bytes, only
+// apply to the gRPC and HTTP/2 backends. The connections are closed after
+// IdleTimeout seconds without requests, or after MaxRequestsPerConnection
+// requests. TCP keepalive is enabled if any of its settings is set, with the
+// defaults of the OS for the others. 0 uses the defaults of Envoy.
+type ConnectionPoolOptions struct {
+	MaxConcurrentStreams        uint32  `json:"max_concurrent_streams"`
+	InitialStreamWindowSize     uint32  `json:"initial_stream_window_size"`
+	InitialConnectionWindowSize uint32  `json:"initial_connection_window_size"`
+	IdleTimeout                 float64 `json:"idle_timeout"`
+	MaxRequestsPerConnection    uint32  `json:"max_requests_per_connection"`
+	TcpKeepaliveTime            uint32  `json:"tcp_keepalive_time"`
+	TcpKeepaliveInterval        uint32  `json:"tcp_keepalive_interval"`
+	TcpKeepaliveProbes          uint32  `json:"tcp_keepalive_probes"`
+}
+
+// Validate returns an error if the window sizes are out of the range allowed
+// by HTTP/2 or the idle timeout is negative.
+func (o *ConnectionPoolOptions) Validate() error {
+	const minWindowSize, maxWindowSize = 65535, 2147483647
+	if o.MaxConcurrentStreams > maxWindowSize {
+		return fmt.Errorf("max_concurrent_streams must be <= %d, got %d", maxWindowSize, o.MaxConcurrentStreams)
+	}
+	if o.InitialStreamWindowSize != 0 && (o.InitialStreamWindowSize < minWindowSize || o.InitialStreamWindowSize > maxWindowSize) {
+		return fmt.Errorf("initial_stream_window_size must be between %d and %d, got %d", minWindowSize, maxWindowSize, o.InitialStreamWindowSize)
+	}
+	if o.InitialConnectionWindowSize != 0 && (o.InitialConnectionWindowSize < minWindowSize || o.InitialConnectionWindowSize > maxWindowSize) {
+		return fmt.Errorf("initial_connection_window_size must be between %d and %d, got %d", minWindowSize, maxWindowSize, o.InitialConnectionWindowSize)
+	}
+	if o.IdleTimeout < 0 {
+		return fmt.Errorf("idle_timeout must be >= 0, got %v", o.IdleTimeout)
+	}
+	return nil
+}
+
+// CircuitBreakerOptions limits the connections and requests to a backend. The
+// requests over the limits fail with 503 immediately, instead of piling up on
+// a slow backend. 0 uses the default of Envoy, 1024, or 3 for the retries.
+type CircuitBreakerOptions struct {
+	MaxConnections     uint32 `json:"max_connections"`
+	MaxPendingRequests uint32 `json:"max_pending_requests"`
+	MaxRequests        uint32 `json:"max_requests"`
+	MaxRetries         uint32 `json:"max_retries"`
+}
+
+// OutlierDetectionOptions ejects the instances of a backend responding with
+// Consecutive5xx consecutive 5xx errors from the load balancing, for
+// BaseEjectionTime seconds multiplied by the number of times they were
+// ejected. Outlier detection is disabled if Consecutive5xx is 0. The other
+// fields left to 0 use the defaults of Envoy: an interval of 10 seconds
+// between the ejection sweeps, a base ejection time of 30 seconds, and at
+// most 10% of the instances ejected.
+type OutlierDetectionOptions struct {
+	Consecutive5xx     uint32  `json:"consecutive_5xx"`
+	Interval           float64 `json:"interval"`
+	BaseEjectionTime   float64 `json:"base_ejection_time"`
+	MaxEjectionPercent uint32  `json:"max_ejection_percent"`
+}
+
+// HealthCheckOptions actively checks the health of the instances of a
+// backend, with HTTP GET requests to Path, or with the grpc.health.v1.Health
+// service if Grpc is set, for GrpcService or the whole server if empty. The
+// instances failing UnhealthyThreshold checks in a row stop receiving
+// requests, until they pass HealthyThreshold checks in a row. The checks are
+// sent every Interval seconds, and fail after Timeout seconds. The health
+// check is disabled if neither Path nor Grpc is set.
+type HealthCheckOptions struct {
+	Path               string  `json:"path"`
+	Grpc               bool    `json:"grpc"`
+	GrpcService        string  `json:"grpc_service"`
+	Interval           float64 `json:"interval"`
+	Timeout            float64 `json:"timeout"`
+	HealthyThreshold   uint32  `json:"healthy_threshold"`
+	UnhealthyThreshold uint32  `json:"unhealthy_threshold"`
+}
+
+// Enabled returns true if the health check is enabled.
+func (o *HealthCheckOptions) Enabled() bool {
+	return o.Path != "" || o.Grpc
+}
+
+// Validate returns an error if both Path and Grpc are set, if Path is not
+// absolute, or if the times are negative.
+func (o *HealthCheckOptions) Validate() error {
+	if o.Path != "" && o.Grpc {
+		return fmt.Errorf("health check path and grpc cannot be set together")
+	}
+	if o.Path != "" && !strings.HasPrefix(o.Path, "/") {
+		return fmt.Errorf("health check path must start with /, got %q", o.Path)
+	}
+	if o.GrpcService != "" && !o.Grpc {
+		return fmt.Errorf("health check grpc_service requires grpc")
+	}
+	if o.Interval < 0 {
+		return fmt.Errorf("health check interval must be >= 0, got %v", o.Interval)
+	}
+	if o.Timeout < 0 {
+		return fmt.Errorf("health check timeout must be >= 0, got %v", o.Timeout)
+	}
+	return nil
+}
+
+// Validate returns an error if the times are negative or the percent is over
+// 100.
+func (o *OutlierDetectionOptions) Validate() error {
+	if o.Interval < 0 {
+		return fmt.Errorf("outlier detection interval must be >= 0, got %v", o.Interval)
+	}
+	if o.BaseEjectionTime < 0 {
+		return fmt.Errorf("outlier detection base_ejection_time must be >= 0, got %v", o.BaseEjectionTime)
+	}
+	if o.MaxEjectionPercent > 100 {
+		return fmt.Errorf("outlier detection max_ejection_percent must be <= 100, got %v", o.MaxEjectionPercent)
+	}
+	return nil
+}
+
+// HasTLS returns true if any of the TLS settings is set.
+func (o *BackendClusterOptions) HasTLS() bool {
+	return o.CaPath != "" || o.MtlsCertPath != "" || o.MtlsKeyPath != "" || len(o.VerifySubjectAltNames) > 0 || o.Sni != ""
+}
+
+// BackendOAuth2Options configures the OAuth2 client credentials grant used to
+// fetch the access tokens sent to a backend, which is not a Google service.
+type BackendOAuth2Options struct {
+	// Address of the backend, as in the x-google-backend extension. Only its
+	// host and port are matched with the address of the BackendRules.
+	BackendAddress string `json:"backend_address"`
+	TokenURL       string `json:"token_url"`
+	ClientID       string `json:"client_id"`
+	ClientSecret   string `json:"client_secret"`
+	// Optional scopes and extra parameters of the token request, e.g.
+	// "audience".
+	Scopes         []string          `json:"scopes"`
+	EndpointParams map[string]string `json:"endpoint_params"`
+}
+
+// String omits the client secret, so that it is not logged with the options.
+func (o *BackendOAuth2Options) String() string {
+	return fmt.Sprintf("{BackendAddress:%s TokenURL:%s ClientID:%s Scopes:%v EndpointParams:%v}",
+		o.BackendAddress, o.TokenURL, o.ClientID, o.Scopes, o.EndpointParams)
+}
+
+// BackendSplitOptions splits the requests of an operation between weighted
+// backends, e.g. versions of a backend being rolled out.
+type BackendSplitOptions struct {
+	Selector string                    `json:"selector"`
+	Backends []*WeightedBackendOptions `json:"backends"`
+}
+
+// WeightedBackendOptions is a backend receiving a share of the requests of an
+// operation, in proportion to its weight.
+type WeightedBackendOptions struct {
+	// Name of the backend, like "v2", matched with the value of the
+	// BackendSplitHeader.
+	Name string `json:"name"`
+	// Address of the backend, like in the x-google-backend extension, without
+	// path. The path of the requests is translated like without the split.
+	Address string `json:"address"`
+	Weight  uint32 `json:"weight"`
+}
+
+// BackendMirrorOptions mirrors a fraction of the requests of an operation to
+// a secondary backend, e.g. a new version of the backend tested with the
+// production traffic. The responses of the secondary backend are ignored.
+type BackendMirrorOptions struct {
+	Selector string `json:"selector"`
+	// Address of the secondary backend, like in the x-google-backend
+	// extension, without path. The path of the requests is translated like
+	// for the backend of the operation.
+	Address string `json:"address"`
+	// Fraction of the requests mirrored, between 0 and 1, all of them if 0.
+	Fraction float64 `json:"fraction"`
+}
+
+// FaultInjectionOptions injects faults in a percentage of the requests of an
+// operation: a fixed delay before they are sent to the backend, or an abort
+// with an HTTP status instead.
+type FaultInjectionOptions struct {
+	Selector string             `json:"selector"`
+	Delay    *FaultDelayOptions `json:"delay,omitempty"`
+	Abort    *FaultAbortOptions `json:"abort,omitempty"`
+}
+
+// FaultDelayOptions delays Percent of the requests by Duration seconds.
+type FaultDelayOptions struct {
+	Percent  float64 `json:"percent"`
+	Duration float64 `json:"duration"`
+}
+
+// FaultAbortOptions aborts Percent of the requests with the HTTP Status.
+type FaultAbortOptions struct {
+	Percent float64 `json:"percent"`
+	Status  uint32  `json:"status"`
+}
+
+// Validate returns an error if neither the delay nor the abort is set, if
+// their percents are not in (0, 100], if the delay is not positive, or if the
+// status is not a valid HTTP status.
+func (o *FaultInjectionOptions) Validate() error {
+	if o.Delay == nil && o.Abort == nil {
+		return fmt.Errorf("fault injection must have a delay or an abort")
+	}
+	if d := o.Delay; d != nil {
+		if d.Percent <= 0 || d.Percent > 100 {
+			return fmt.Errorf("fault injection delay percent must be in (0, 100], got %v", d.Percent)
+		}
+		if d.Duration <= 0 {
+			return fmt.Errorf("fault injection delay duration must be > 0, got %v", d.Duration)
+		}
+	}
+	if a := o.Abort; a != nil {
+		if a.Percent <= 0 || a.Percent > 100 {
+			return fmt.Errorf("fault injection abort percent must be in (0, 100], got %v", a.Percent)
+		}
+		if a.Status < 200 || a.Status > 599 {
+			return fmt.Errorf("fault injection abort status must be between 200 and 599, got %v", a.Status)
+		}
+	}
+	return nil
+}
+
+// DirectResponseOptions is the canned response of an operation, returned by
+// the proxy instead of sending its requests to a backend. The Body is sent as
+// is, with the Headers and the ContentType, text/plain if empty.
+type DirectResponseOptions struct {
+	Selector    string                `json:"selector"`
+	Status      uint32                `json:"status"`
+	Headers     []*HeaderValueOptions `json:"headers,omitempty"`
+	ContentType string                `json:"content_type,omitempty"`
+	Body        string                `json:"body,omitempty"`
+}
+
+// MaxDirectResponseBodyBytes is the maximum size of the body of a direct
+// response allowed by Envoy.
+const MaxDirectResponseBodyBytes = 4096
+
+// Validate returns an error if the status is not a valid HTTP status, if the
+// body is larger than MaxDirectResponseBodyBytes, or if a header has no name
+// or a pseudo-header name.
+func (o *DirectResponseOptions) Validate() error {
+	if o.Status < 200 || o.Status > 599 {
+		return fmt.Errorf("direct response status must be between 200 and 599, got %v", o.Status)
+	}
+	if len(o.Body) > MaxDirectResponseBodyBytes {
+		return fmt.Errorf("direct response body must be at most %v bytes, got %v", MaxDirectResponseBodyBytes, len(o.Body))
+	}
+	for _, h := range o.Headers {
+		if h.Name == "" {
+			return fmt.Errorf("direct response header name is required")
+		}
+		if strings.HasPrefix(h.Name, ":") {
+			return fmt.Errorf("direct response pseudo-header %s cannot be set", h.Name)
+		}
+	}
+	return nil
+}
+
+// BackendRetryOptions configures the retry policy of the routes of an
+// operation to its backend.
+type BackendRetryOptions struct {
+	// Selector of the operation, or "*" for all the operations without their
+	// own retry policy.
+	Selector   string `json:"selector"`
+	NumRetries uint32 `json:"num_retries"`
+	// Comma separated Envoy retry conditions, e.g. "5xx,reset".
+	RetryOn string `json:"retry_on"`
+	// Timeout of each try in seconds, like the deadline of the BackendRule.
+	// 0 uses the timeout of the route.
+	PerTryTimeout float64 `json:"per_try_timeout"`
+	// If set, a try exceeding PerTryTimeout is not canceled, and the response
+	// of whichever try finishes first is used. Only applies to the routes of
+	// the idempotent HTTP methods: GET, HEAD, OPTIONS, PUT and DELETE.
+	Hedge bool `json:"hedge"`
+}
+
+// HeaderRuleOptions adds or removes the headers of the requests of an
+// operation sent to its backend, and of their responses. The rule of the
+// selector "*" applies to all the requests, including the ones of the
+// operations with their own rule, which is applied after it.
+type HeaderRuleOptions struct {
+	Selector                string                `json:"selector"`
+	RequestHeadersToAdd     []*HeaderValueOptions `json:"request_headers_to_add"`
+	RequestHeadersToRemove  []string              `json:"request_headers_to_remove"`
+	ResponseHeadersToAdd    []*HeaderValueOptions `json:"response_headers_to_add"`
+	ResponseHeadersToRemove []string              `json:"response_headers_to_remove"`
+}
+
+// HeaderValueOptions is a header added by a HeaderRuleOptions. The value is
+// appended to the existing values of the header, unless Overwrite is set.
+type HeaderValueOptions struct {
+	Name      string `json:"name"`
+	Value     string `json:"value"`
+	Overwrite bool   `json:"overwrite"`
+}
+
+// Validate returns an error if the rule has no selector, or a header without
+// name or with a pseudo-header name, which Envoy does not allow to modify.
+func (o *HeaderRuleOptions) Validate() error {
+	if o.Selector == "" {
+		return fmt.Errorf("selector is required")
+	}
+	var names []string
+	for _, h := range append(o.RequestHeadersToAdd, o.ResponseHeadersToAdd...) {
+		names = append(names, h.Name)
+	}
+	names = append(names, o.RequestHeadersToRemove...)
+	names = append(names, o.ResponseHeadersToRemove...)
+	for _, name := range names {
+		if name == "" {
+			return fmt.Errorf("header name is required for selector %s", o.Selector)
+		}
+		if strings.HasPrefix(name, ":") {
+			return fmt.Errorf("pseudo-header %s cannot be modified for selector %s", name, o.Selector)
+		}
+	}
+	return nil
+}
+
+// PathRewriteOptions rewrites the paths of the requests of an operation sent
+// to its backend, instead of the path translation of its backend rule, by
+// replacing Prefix with PrefixRewrite, or the matches of Regex, in RE2
+// syntax, with Substitution, which may contain \1 to \9 for the capture
+// groups. With Regex, the routes of the operation match the whole paths with
+// it instead of the path templates. The query parameters are kept as is.
+type PathRewriteOptions struct {
+	Selector      string `json:"selector"`
+	Prefix        string `json:"prefix"`
+	PrefixRewrite string `json:"prefix_rewrite"`
+	Regex         string `json:"regex"`
+	Substitution  string `json:"substitution"`
+}
+
+// Validate returns an error if the rewrite has no selector, not exactly one
+// of Prefix and Regex, a Prefix not starting with "/" or an invalid Regex.
+func (o *PathRewriteOptions) Validate() error {
+	if o.Selector == "" {
+		return fmt.Errorf("selector is required")
+	}
+	if (o.Prefix == "") == (o.Regex == "") {
+		return fmt.Errorf("exactly one of prefix and regex is required for selector %s", o.Selector)
+	}
+	if o.Prefix != "" && !strings.HasPrefix(o.Prefix, "/") {
+		return fmt.Errorf("prefix %s must start with / for selector %s", o.Prefix, o.Selector)
+	}
+	if o.Regex != "" {
+		if _, err := regexp.Compile(o.Regex); err != nil {
+			return fmt.Errorf("invalid regex for selector %s: %v", o.Selector, err)
+		}
+	}
+	return nil
+}
+
+// ResponseCacheOptions caches the responses of the GET requests of an
+// operation, or of all operations without their own for the selector "*",
+// for Ttl seconds, or the max-age of their Cache-Control header if 0. The
+// responses are keyed by their path with its query parameters, the values of
+// the KeyHeaders and, if IncludeApiKey is set, of the API key headers of the
+// operation.
+type ResponseCacheOptions struct {
+	Selector      string   `json:"selector"`
+	Ttl           float64  `json:"ttl"`
+	KeyHeaders    []string `json:"key_headers"`
+	IncludeApiKey bool     `json:"include_api_key"`
+}
+
+// Validate returns an error if the cache has no selector, a negative Ttl or
+// an empty or pseudo key header.
+func (o *ResponseCacheOptions) Validate() error {
+	if o.Selector == "" {
+		return fmt.Errorf("selector is required")
+	}
+	if o.Ttl < 0 {
+		return fmt.Errorf("ttl must be >= 0 for selector %s", o.Selector)
+	}
+	for _, h := range o.KeyHeaders {
+		if h == "" || strings.HasPrefix(h, ":") {
+			return fmt.Errorf("invalid key header %q for selector %s", h, o.Selector)
+		}
+	}
+	return nil
+}
+
+// CorsPolicyOptions is the CORS policy of the routes of an operation, or of
+// all operations without their own for the selector "*". The origins are
+// allowed if they match one of the AllowOriginRegexes, in RE2 syntax. The
+// preflight responses allow AllowMethods and AllowHeaders, and are cached by
+// the browsers for MaxAge seconds if set. The empty fields fall back to the
+// ones of the policy of --cors_preset, if any.
+type CorsPolicyOptions struct {
+	Selector           string   `json:"selector"`
+	AllowOriginRegexes []string `json:"allow_origin_regexes"`
+	AllowMethods       []string `json:"allow_methods"`
+	AllowHeaders       []string `json:"allow_headers"`
+	ExposeHeaders      []string `json:"expose_headers"`
+	MaxAge             uint32   `json:"max_age"`
+	AllowCredentials   bool     `json:"allow_credentials"`
+}
+
+// Validate returns an error if the policy has no selector, no origin regex or
+// an invalid one.
+func (o *CorsPolicyOptions) Validate() error {
+	if o.Selector == "" {
+		return fmt.Errorf("selector is required")
+	}
+	if len(o.AllowOriginRegexes) == 0 {
+		return fmt.Errorf("allow_origin_regexes is required for selector %s", o.Selector)
+	}
+	for _, r := range o.AllowOriginRegexes {
+		if _, err := regexp.Compile(r); err != nil {
+			return fmt.Errorf("invalid origin regex %q for selector %s: %v", r, o.Selector, err)
+		}
+	}
+	return nil
+}
+
+// IpAclOptions rejects the requests to an operation, or to all operations
+// without their own for the selector "*", from the client addresses in one of
+// the Deny CIDR ranges or, if Allow is not empty, in none of its ranges. The
+// client addresses are taken from the x-forwarded-for header as configured by
+// EnvoyUseRemoteAddress and EnvoyXffNumTrustedHops.
+type IpAclOptions struct {
+	Selector string   `json:"selector"`
+	Allow    []string `json:"allow"`
+	Deny     []string `json:"deny"`
+}
+
+// Validate returns an error if the ACL has no selector, no ranges or an
+// invalid one.
+func (o *IpAclOptions) Validate() error {
+	if o.Selector == "" {
+		return fmt.Errorf("selector is required")
+	}
+	if len(o.Allow) == 0 && len(o.Deny) == 0 {
+		return fmt.Errorf("allow or deny is required for selector %s", o.Selector)
+	}
+	for _, r := range append(append([]string{}, o.Allow...), o.Deny...) {
+		if _, _, err := net.ParseCIDR(r); err != nil {
+			return fmt.Errorf("invalid CIDR range %q for selector %s", r, o.Selector)
+		}
+	}
+	return nil
+}
+
+// ErrorResponseTemplateOptions is the template of the body of the error
+// responses of the proxy with a status code, in which %CODE%, %MESSAGE% and
+// %DETAILS% are replaced with the status code, the error message and the
+// response code details, escaped as JSON strings. The content type is
+// "application/json" if empty.
+type ErrorResponseTemplateOptions struct {
+	Status      uint32 `json:"status"`
+	Body        string `json:"body"`
+	ContentType string `json:"content_type"`
+}
+
+// Validate returns an error if the status code of the template is not a 4xx
+// or 5xx.
+func (o *ErrorResponseTemplateOptions) Validate() error {
+	if o.Status < 400 || o.Status > 599 {
+		return fmt.Errorf("status %d must be a 4xx or 5xx", o.Status)
+	}
+	return nil
+}
+
+// BodySizeLimitOptions overrides the maximum sizes of the request and response
+// bodies of an operation.
+type BodySizeLimitOptions struct {
+	Selector string `json:"selector"`
+	// nil keeps the maximum size of all operations, 0 is unlimited.
+	MaxRequestBodyBytes  *uint32 `json:"max_request_body_bytes"`
+	MaxResponseBodyBytes *uint32 `json:"max_response_body_bytes"`
+}
+
+// ApiKeyLocationOptions overrides the locations of the API key of an
+// operation, tried in order.
+type ApiKeyLocationOptions struct {
+	Selector string `json:"selector"`
+	// Each location is "query:<name>", "header:<name>" or "cookie:<name>".
+	Locations []string `json:"locations"`
+}
+
+// JwtClaimHeaderOptions overrides the claims forwarded to the backend in
+// request headers for an operation.
+type JwtClaimHeaderOptions struct {
+	Selector string `json:"selector"`
+	// The request header of each claim, like {"sub": "x-user-id"}.
+	ClaimHeaders map[string]string `json:"claim_headers"`
+}
+
+// AuthorizationRuleOptions only allows the requests of an operation whose
+// verified JWTs match one of the policies.
+type AuthorizationRuleOptions struct {
+	Selector string                        `json:"selector"`
+	Allow    []*AuthorizationPolicyOptions `json:"allow"`
+}
+
+// AuthorizationPolicyOptions matches the JWTs whose claims all have one of
+// their values, or contain one of them if they are lists, like
+// {"roles": ["admin"]}. Nested claims are separated by dots.
+type AuthorizationPolicyOptions struct {
+	Claims map[string][]string `json:"claims"`
+}
+
+// RateLimitOptions overrides the token-bucket limit of the requests to an
+// operation. 0 requests per second is unlimited.
+type RateLimitOptions struct {
+	Selector          string `json:"selector"`
+	RequestsPerSecond uint32 `json:"requests_per_second"`
+	Burst             uint32 `json:"burst"`
+}
+
+// JwksProviderOptions overrides how the JWKS of a JWT provider are cached and
+// fetched.
+type JwksProviderOptions struct {
+	ProviderId       string `json:"provider_id"`
+	CacheDurationInS *int   `json:"cache_duration_in_s,omitempty"`
+	AsyncFetch       *bool  `json:"async_fetch,omitempty"`
+	FetchRetries     *int   `json:"fetch_retries,omitempty"`
+}
+
+// Dependencies of the proxy with their own TLS settings in
+// OutboundTlsOptions.
+const (
+	ServiceManagementDependency = "servicemanagement"
+	ServiceControlDependency    = "servicecontrol"
+	IamDependency               = "iam"
+	JwksDependency              = "jwks"
+)
+
+// OutboundTlsOptions configures the TLS of the calls to a dependency of the
+// proxy.
+type OutboundTlsOptions struct {
+	// One of "servicemanagement", "servicecontrol", "iam" or "jwks".
+	Dependency string `json:"dependency"`
+	// CA certificates validating the dependency, defaulting to
+	// --root_certs_path.
+	CaPath string `json:"ca_path"`
+	// Client certificate presented to the dependency, if set.
+	ClientCertPath string `json:"client_cert_path"`
+	ClientKeyPath  string `json:"client_key_path"`
+	// Range of TLS versions, from "TLSv1_0" to "TLSv1_3". Empty values use
+	// the defaults of Envoy and Go.
+	MinVersion string `json:"min_version"`
+	MaxVersion string `json:"max_version"`
+}
+
+// OutboundTlsFor returns the TLS settings of the dependency, or nil if it
+// uses the default ones.
+func (o *ConfigGeneratorOptions) OutboundTlsFor(dependency string) *OutboundTlsOptions {
+	for _, t := range o.OutboundTls {
+		if t.Dependency == dependency {
+			return t
+		}
+	}
+	return nil
+}
+
+// SniCertificateOptions is a certificate of the listener, served to the
+// clients requesting one of its server names with SNI.
+type SniCertificateOptions struct {
+	// Server names of the certificate, like "api.example.com", or
+	// "*.example.com" for the subdomains.
+	ServerNames []string `json:"server_names"`
+	CertPath    string   `json:"cert_path"`
+	KeyPath     string   `json:"key_path"`
+	// With multiple services, the requests with these server names are all
+	// routed to this service, instead of by host or path prefix.
+	Service string `json:"service,omitempty"`
+}
+
 // DefaultConfigGeneratorOptions returns ConfigGeneratorOptions with default values.
 //
 // The default values are expected to match the default values from the flags.
@@ -93,6 +1102,12 @@ func DefaultConfigGeneratorOptions() ConfigGeneratorOptions {
 	return ConfigGeneratorOptions{
 		CommonOptions:                 DefaultCommonOptions(),
 		BackendDnsLookupFamily:        "auto",
+		BackendDnsRefreshRate:         0,
+		BackendDnsRespectTtl:          false,
+		BackendStrictDns:              false,
+		BackendEdsServerUri:           "",
+		BackendEdsServiceName:         "",
+		BackendEdsGoogleCredentials:   false,
 		BackendAddress:                "http://127.0.0.1:8082",
 		ClusterConnectTimeout:         20 * time.Second,
 		CorsAllowCredentials:          false,
@@ -102,26 +1117,146 @@ func DefaultConfigGeneratorOptions() ConfigGeneratorOptions {
 		CorsAllowOriginRegex:          "",
 		CorsExposeHeaders:             "",
 		CorsPreset:                    "",
+		EnableWebsocket:               false,
+		EnableGrpcWeb:                 false,
 		EnvoyUseRemoteAddress:         false,
 		EnvoyXffNumTrustedHops:        2,
+		TrustedProxyCidrs:             "",
+		EnableProxyProtocol:           false,
+		RequestIdMode:                 "generate",
+		RequestIdHeader:               "",
+		LogRequestId:                  false,
 		JwksCacheDurationInS:          300,
+		JwksAsyncFetch:                false,
+		JwksFetchRetries:              3,
 		ListenerAddress:               "0.0.0.0",
 		ListenerPort:                  8080,
+		MaxRequestBodyBytes:           0,
+		MaxResponseBodyBytes:          0,
+		HealthGrpcService:             "",
 		RootCertsPath:                 util.DefaultRootCAPaths,
 		LogJwtPayloads:                "",
 		LogRequestHeaders:             "",
 		LogResponseHeaders:            "",
+		ServiceControlReportLabels:    "",
+		ConsumerProjectHeader:         "",
+		ConsumerIdHeader:              "",
 		ServiceAccountKey:             "",
+		TokenAgentPort:                8791,
+		WebsocketSelectors:            "",
+		ApiKeyLocations:               "",
+		JwtClaimHeaders:               "",
+		LocalJwks:                     "",
+		BackendSplitHeader:            "",
+		ExtAuthzUri:                   "",
+		ExtAuthzTimeout:               time.Second,
+		ExtAuthzFailureModeAllow:      false,
+		ExtAuthzDisabledSelectors:     "",
 		ServiceControlNetworkFailOpen: true,
 		ServiceManagementURL:          "https://servicemanagement.googleapis.com",
+		ServiceControlURL:             "",
 		ScCheckRetries:                -1,
 		ScCheckTimeoutMs:              0,
 		ScQuotaRetries:                -1,
 		ScQuotaTimeoutMs:              0,
 		ScReportRetries:               -1,
+		ScReportBatchMaxSize:          0,
+		ScReportBatchMaxDelayMs:       0,
+		ScReportBatchMaxBytes:         0,
 		ScReportTimeoutMs:             0,
 		SkipJwtAuthnFilter:            false,
 		SkipServiceControlFilter:      false,
 		SuppressEnvoyHeaders:          false,
+
+		ScCircuitBreakerFailureThreshold: 0,
+		ScCircuitBreakerOpenDurationMs:   0,
+		ScCircuitBreakerFailOpen:         true,
+		ScReportQueueMaxBytes:            0,
+		ScReportSpillDir:                 "",
+		ScReportSpillMaxBytes:            0,
+
+		ScCheckCacheTtlMs:         0,
+		ScCheckCacheMaxEntries:    -1,
+		ScCheckCacheNegativeTtlMs: -1,
+		ScCheckCacheServeStale:    false,
+
+		ServiceControlProtocol:            "http",
+		ServiceControlCheckPath:           "",
+		ServiceControlQuotaPath:           "",
+		ServiceControlReportPath:          "",
+		ServiceControlAuth:                "access_token",
+		ServiceControlAuthHeaderName:      "",
+		ServiceControlAuthHeaderValuePath: "",
+
+		ScQuotaBucketBurst:            0,
+		ScQuotaBucketRefillIntervalMs: 0,
+
+		RateLimitRequestsPerSecond:      0,
+		RateLimitBurst:                  0,
+		RateLimitServiceUri:             "",
+		RateLimitServiceTimeout:         20 * time.Millisecond,
+		RateLimitServiceFailureModeDeny: false,
+
+		TranscodingDescriptorPath:               "",
+		TranscodingAlwaysPrintPrimitiveFields:   false,
+		TranscodingAlwaysPrintEnumsAsInts:       false,
+		TranscodingPreserveProtoFieldNames:      false,
+		TranscodingIgnoreUnknownQueryParameters: false,
+		TranscodingMatchIncomingRequestRoute:    false,
+		TranscodingDisableAutoMapping:           false,
+
+		Http3Port:              0,
+		Http3SslServerCertPath: "",
+		Http3AltSvcMaxAge:      24 * time.Hour,
+
+		EnableResponseCompression:           false,
+		ResponseCompressionMinContentLength: 30,
+		ResponseCompressionContentTypes:     "",
+		ResponseCompressionLevel:            "default",
+
+		ResponseCacheMaxEntries:   1000,
+		ResponseCacheMaxBodyBytes: 1048576,
+
+		UnmatchedPathBody:        "",
+		UnmatchedPathContentType: "",
+		UnmatchedPathRedirectUrl: "",
+		UnmatchedPathToBackend:   false,
+
+		ErrorResponseFormat: "",
+
+		BackendHealthCheck: HealthCheckOptions{
+			Interval:           5,
+			Timeout:            1,
+			HealthyThreshold:   2,
+			UnhealthyThreshold: 3,
+		},
+
+		SslServerCertSds:           false,
+		SslServerAcmeDirectoryUrl:  "",
+		SslServerAcmeDomains:       "",
+		SslServerAcmeEmail:         "",
+		SslServerAcmeHttpPort:      80,
+		SslServerAcmeChallengePort: 8793,
+
+		SslServerClientCaPath:            "",
+		SslServerRequireClientCert:       false,
+		SslServerClientSpiffeTrustDomain: "",
+		SslServerForwardClientCert:       false,
+
+		TracingOperationSampleRates: "",
+		TracingClientSampleRate:     1.0,
+		TracingCustomTags:           "",
+
+		AccessLogPath:              "",
+		AccessLogFormat:            "",
+		AccessLogJsonFormat:        "",
+		AccessLogServiceUri:        "",
+		AccessLogServiceLogName:    "esp-v2",
+		AccessLogMaxBytes:          0,
+		AccessLogMaxFiles:          5,
+		AccessLogDisabledSelectors: "",
+
+		KubernetesNamespace:     "",
+		KubernetesClusterDomain: "cluster.local",
 	}
 }
@@ -0,0 +1,456 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+const (
+	// Type of the Workload Identity Federation credential JSON.
+	ExternalAccountCredentialType = "external_account"
+
+	tokenExchangeGrantType    = "urn:ietf:params:oauth:grant-type:token-exchange"
+	accessTokenType           = "urn:ietf:params:oauth:token-type:access_token"
+	externalAccountScope      = "https://www.googleapis.com/auth/cloud-platform"
+	awsEnvironmentIDPrefix    = "aws"
+	awsSigningAlgorithm       = "AWS4-HMAC-SHA256"
+	awsRequestType            = "aws4_request"
+	impersonatedTokenLifetime = "3600s"
+)
+
+var (
+	// Federated tokens are exchanged with the cloud-platform scope, as the
+	// token is used for both Service Management and Service Control.
+	externalAccountScopes = []string{externalAccountScope}
+
+	externalAccountClient = &http.Client{Transport: &http.Transport{Proxy: Proxy}, Timeout: 30 * time.Second}
+	awsNow                = time.Now
+)
+
+// ExternalAccountConfig is the Workload Identity Federation credential JSON,
+// generated by `gcloud iam workload-identity-pools create-cred-config`.
+type ExternalAccountConfig struct {
+	Type                           string           `json:"type"`
+	Audience                       string           `json:"audience"`
+	SubjectTokenType               string           `json:"subject_token_type"`
+	TokenURL                       string           `json:"token_url"`
+	ServiceAccountImpersonationURL string           `json:"service_account_impersonation_url"`
+	CredentialSource               credentialSource `json:"credential_source"`
+}
+
+type credentialSource struct {
+	File    string            `json:"file"`
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers"`
+	Format  struct {
+		Type                  string `json:"type"`
+		SubjectTokenFieldName string `json:"subject_token_field_name"`
+	} `json:"format"`
+
+	// Only used for AWS.
+	EnvironmentID               string `json:"environment_id"`
+	RegionURL                   string `json:"region_url"`
+	RegionalCredVerificationURL string `json:"regional_cred_verification_url"`
+	IMDSv2SessionTokenURL       string `json:"imdsv2_session_token_url"`
+}
+
+// IsExternalAccount returns true if the credential JSON is an external account.
+func IsExternalAccount(keyData []byte) bool {
+	key := struct {
+		Type string `json:"type"`
+	}{}
+	return json.Unmarshal(keyData, &key) == nil && key.Type == ExternalAccountCredentialType
+}
+
+// ExternalAccountTokenSource returns a TokenSource which exchanges the subject
+// token of the credential source for a federated token through the Security
+// Token Service, and then for the access token of the impersonated service
+// account if service_account_impersonation_url is set.
+func ExternalAccountTokenSource(keyData []byte) (oauth2.TokenSource, error) {
+	config := &ExternalAccountConfig{}
+	if err := json.Unmarshal(keyData, config); err != nil {
+		return nil, fmt.Errorf("fail to unmarshal external account credential: %v", err)
+	}
+	if config.Type != ExternalAccountCredentialType {
+		return nil, fmt.Errorf("credential type must be %q, got %q", ExternalAccountCredentialType, config.Type)
+	}
+	if config.Audience == "" || config.SubjectTokenType == "" || config.TokenURL == "" {
+		return nil, fmt.Errorf("external account credential must have audience, subject_token_type and token_url")
+	}
+	source := config.CredentialSource
+	switch {
+	case strings.HasPrefix(source.EnvironmentID, awsEnvironmentIDPrefix):
+		if source.EnvironmentID != "aws1" {
+			return nil, fmt.Errorf("unsupported AWS environment_id %q", source.EnvironmentID)
+		}
+		if source.RegionalCredVerificationURL == "" {
+			return nil, fmt.Errorf("AWS credential source must have regional_cred_verification_url")
+		}
+	case source.File != "" && source.URL != "":
+		return nil, fmt.Errorf("credential source must have only one of file and url")
+	case source.File == "" && source.URL == "":
+		return nil, fmt.Errorf("credential source must have one of file, url and environment_id")
+	}
+	switch source.Format.Type {
+	case "", "text":
+	case "json":
+		if source.Format.SubjectTokenFieldName == "" {
+			return nil, fmt.Errorf("credential source format must have subject_token_field_name for type json")
+		}
+	default:
+		return nil, fmt.Errorf("unsupported credential source format type %q", source.Format.Type)
+	}
+	return &externalAccountTokenSource{config: config}, nil
+}
+
+type externalAccountTokenSource struct {
+	config *ExternalAccountConfig
+}
+
+func (ts *externalAccountTokenSource) Token() (*oauth2.Token, error) {
+	subjectToken, err := ts.subjectToken()
+	if err != nil {
+		return nil, fmt.Errorf("fail to get subject token: %v", err)
+	}
+	token, err := ts.exchangeToken(subjectToken)
+	if err != nil {
+		return nil, fmt.Errorf("fail to exchange token: %v", err)
+	}
+	if ts.config.ServiceAccountImpersonationURL == "" {
+		return token, nil
+	}
+	if token, err = ts.impersonate(token.AccessToken); err != nil {
+		return nil, fmt.Errorf("fail to impersonate service account: %v", err)
+	}
+	return token, nil
+}
+
+func (ts *externalAccountTokenSource) subjectToken() (string, error) {
+	source := ts.config.CredentialSource
+	if source.EnvironmentID != "" {
+		return awsSubjectToken(source, ts.config.Audience)
+	}
+
+	var content []byte
+	var err error
+	if source.File != "" {
+		if content, err = ioutil.ReadFile(source.File); err != nil {
+			return "", err
+		}
+	} else if content, err = doExternalAccountRequest("GET", source.URL, source.Headers, nil); err != nil {
+		return "", err
+	}
+
+	if source.Format.Type != "json" {
+		return strings.TrimSpace(string(content)), nil
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(content, &fields); err != nil {
+		return "", fmt.Errorf("fail to unmarshal subject token: %v", err)
+	}
+	token, ok := fields[source.Format.SubjectTokenFieldName].(string)
+	if !ok || token == "" {
+		return "", fmt.Errorf("subject token has no field %s", source.Format.SubjectTokenFieldName)
+	}
+	return token, nil
+}
+
+// exchangeToken calls the Security Token Service, following RFC 8693.
+func (ts *externalAccountTokenSource) exchangeToken(subjectToken string) (*oauth2.Token, error) {
+	form := url.Values{
+		"grant_type":           {tokenExchangeGrantType},
+		"audience":             {ts.config.Audience},
+		"scope":                {strings.Join(externalAccountScopes, " ")},
+		"requested_token_type": {accessTokenType},
+		"subject_token_type":   {ts.config.SubjectTokenType},
+		"subject_token":        {subjectToken},
+	}
+	body, err := doExternalAccountRequest("POST", ts.config.TokenURL, map[string]string{
+		"Content-Type": "application/x-www-form-urlencoded",
+	}, []byte(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+
+	resp := struct {
+		AccessToken string `json:"access_token"`
+		TokenType   string `json:"token_type"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}{}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, fmt.Errorf("fail to unmarshal token exchange response: %v", err)
+	}
+	if resp.AccessToken == "" {
+		return nil, fmt.Errorf("token exchange response has no access_token")
+	}
+	return &oauth2.Token{
+		AccessToken: resp.AccessToken,
+		TokenType:   resp.TokenType,
+		Expiry:      time.Now().Add(time.Duration(resp.ExpiresIn) * time.Second),
+	}, nil
+}
+
+// impersonate calls the generateAccessToken method of IAM credentials with
+// the federated token.
+func (ts *externalAccountTokenSource) impersonate(federatedToken string) (*oauth2.Token, error) {
+	reqBody, _ := json.Marshal(map[string]interface{}{
+		"scope":    externalAccountScopes,
+		"lifetime": impersonatedTokenLifetime,
+	})
+	body, err := doExternalAccountRequest("POST", ts.config.ServiceAccountImpersonationURL, map[string]string{
+		"Authorization": "Bearer " + federatedToken,
+		"Content-Type":  "application/json",
+	}, reqBody)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := struct {
+		AccessToken string `json:"accessToken"`
+		ExpireTime  string `json:"expireTime"`
+	}{}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, fmt.Errorf("fail to unmarshal impersonation response: %v", err)
+	}
+	expiry, err := time.Parse(time.RFC3339, resp.ExpireTime)
+	if err != nil {
+		return nil, fmt.Errorf("fail to parse expireTime %q: %v", resp.ExpireTime, err)
+	}
+	return &oauth2.Token{
+		AccessToken: resp.AccessToken,
+		TokenType:   "Bearer",
+		Expiry:      expiry,
+	}, nil
+}
+
+func doExternalAccountRequest(method, path string, headers map[string]string, body []byte) ([]byte, error) {
+	req, err := http.NewRequest(method, path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	resp, err := externalAccountClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("http call to %s %s returns error: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("fail to read response body: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("http call to %s %s returns not 200 OK: %v, body: %s", method, path, resp.Status, respBody)
+	}
+	return respBody, nil
+}
+
+type awsCredentials struct {
+	AccessKeyID     string `json:"AccessKeyId"`
+	SecretAccessKey string `json:"SecretAccessKey"`
+	Token           string `json:"Token"`
+}
+
+// awsSubjectToken returns the serialized GetCallerIdentity request signed with
+// the AWS credentials of the environment, which the Security Token Service
+// sends to AWS to verify the identity.
+func awsSubjectToken(source credentialSource, audience string) (string, error) {
+	var headers map[string]string
+	if source.IMDSv2SessionTokenURL != "" {
+		sessionToken, err := doExternalAccountRequest("PUT", source.IMDSv2SessionTokenURL, map[string]string{
+			"X-aws-ec2-metadata-token-ttl-seconds": "300",
+		}, nil)
+		if err != nil {
+			return "", fmt.Errorf("fail to get AWS session token: %v", err)
+		}
+		headers = map[string]string{"X-aws-ec2-metadata-token": string(sessionToken)}
+	}
+
+	region := os.Getenv("AWS_REGION")
+	if region == "" {
+		region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	if region == "" {
+		if source.RegionURL == "" {
+			return "", fmt.Errorf("AWS region is not set and credential source has no region_url")
+		}
+		zone, err := doExternalAccountRequest("GET", source.RegionURL, headers, nil)
+		if err != nil {
+			return "", fmt.Errorf("fail to get AWS region: %v", err)
+		}
+		// The region is the zone without the letter suffix, e.g. us-east-1 of us-east-1b.
+		if len(zone) < 2 {
+			return "", fmt.Errorf("invalid AWS zone %q", zone)
+		}
+		region = string(zone[:len(zone)-1])
+	}
+
+	creds := awsCredentials{
+		AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		Token:           os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	if creds.AccessKeyID == "" || creds.SecretAccessKey == "" {
+		if source.URL == "" {
+			return "", fmt.Errorf("AWS credentials are not set and credential source has no url")
+		}
+		role, err := doExternalAccountRequest("GET", source.URL, headers, nil)
+		if err != nil {
+			return "", fmt.Errorf("fail to get AWS role name: %v", err)
+		}
+		body, err := doExternalAccountRequest("GET", source.URL+"/"+string(role), headers, nil)
+		if err != nil {
+			return "", fmt.Errorf("fail to get AWS credentials: %v", err)
+		}
+		if err := json.Unmarshal(body, &creds); err != nil {
+			return "", fmt.Errorf("fail to unmarshal AWS credentials: %v", err)
+		}
+	}
+
+	verificationURL := strings.Replace(source.RegionalCredVerificationURL, "{region}", region, -1)
+	signedHeaders, err := signAwsRequest(creds, region, "POST", verificationURL, audience)
+	if err != nil {
+		return "", err
+	}
+
+	var names []string
+	for name := range signedHeaders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	type header struct {
+		Key   string `json:"key"`
+		Value string `json:"value"`
+	}
+	subjectToken := struct {
+		URL     string   `json:"url"`
+		Method  string   `json:"method"`
+		Headers []header `json:"headers"`
+	}{
+		URL:    verificationURL,
+		Method: "POST",
+	}
+	for _, name := range names {
+		subjectToken.Headers = append(subjectToken.Headers, header{Key: name, Value: signedHeaders[name]})
+	}
+	content, err := json.Marshal(subjectToken)
+	if err != nil {
+		return "", err
+	}
+	return url.QueryEscape(string(content)), nil
+}
+
+// signAwsRequest returns the headers of a request without body signed with
+// AWS Signature Version 4.
+func signAwsRequest(creds awsCredentials, region, method, path, audience string) (map[string]string, error) {
+	u, err := url.Parse(path)
+	if err != nil {
+		return nil, fmt.Errorf("fail to parse AWS request url %q: %v", path, err)
+	}
+	now := awsNow().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	service := strings.Split(u.Host, ".")[0]
+
+	headers := map[string]string{
+		"host":                         u.Host,
+		"x-amz-date":                   amzDate,
+		"x-goog-cloud-target-resource": audience,
+	}
+	if creds.Token != "" {
+		headers["x-amz-security-token"] = creds.Token
+	}
+
+	var names []string
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalPath := u.EscapedPath()
+	if canonicalPath == "" {
+		canonicalPath = "/"
+	}
+	canonicalRequest := strings.Join([]string{
+		method,
+		canonicalPath,
+		canonicalQuery(u.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		hexSha256(nil),
+	}, "\n")
+
+	scope := strings.Join([]string{date, region, service, awsRequestType}, "/")
+	stringToSign := strings.Join([]string{awsSigningAlgorithm, amzDate, scope, hexSha256([]byte(canonicalRequest))}, "\n")
+
+	key := hmacSha256([]byte("AWS4"+creds.SecretAccessKey), date)
+	key = hmacSha256(key, region)
+	key = hmacSha256(key, service)
+	key = hmacSha256(key, awsRequestType)
+	signature := hex.EncodeToString(hmacSha256(key, stringToSign))
+
+	headers["Authorization"] = fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		awsSigningAlgorithm, creds.AccessKeyID, scope, signedHeaders, signature)
+	return headers, nil
+}
+
+func canonicalQuery(query url.Values) string {
+	var keys []string
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var params []string
+	for _, key := range keys {
+		values := query[key]
+		sort.Strings(values)
+		for _, value := range values {
+			params = append(params, url.QueryEscape(key)+"="+strings.Replace(url.QueryEscape(value), "+", "%20", -1))
+		}
+	}
+	return strings.Join(params, "&")
+}
+
+func hexSha256(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSha256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,251 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testAudience = "//iam.googleapis.com/projects/123/locations/global/workloadIdentityPools/pool/providers/provider"
+
+// initFakeGoogleServer serves the Security Token Service on /token and IAM
+// credentials on /impersonate, checking the subject token of the exchange.
+func initFakeGoogleServer(t *testing.T, wantSubjectToken string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/token":
+			r.ParseForm()
+			if got := r.PostForm.Get("subject_token"); got != wantSubjectToken {
+				t.Errorf("got subject_token %q, want %q", got, wantSubjectToken)
+			}
+			if got := r.PostForm.Get("grant_type"); got != tokenExchangeGrantType {
+				t.Errorf("got grant_type %q, want %q", got, tokenExchangeGrantType)
+			}
+			if got := r.PostForm.Get("audience"); got != testAudience {
+				t.Errorf("got audience %q, want %q", got, testAudience)
+			}
+			w.Write([]byte(`{"access_token": "federated-token", "token_type": "Bearer", "expires_in": 3600}`))
+		case "/impersonate":
+			if got := r.Header.Get("Authorization"); got != "Bearer federated-token" {
+				t.Errorf("got Authorization %q, want federated token", got)
+			}
+			fmt.Fprintf(w, `{"accessToken": "impersonated-token", "expireTime": "%s"}`, time.Now().Add(time.Hour).UTC().Format(time.RFC3339))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func TestExternalAccountTokenSource(t *testing.T) {
+	subjectTokenFile, err := ioutil.TempFile("", "subject_token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(subjectTokenFile.Name())
+	subjectTokenFile.WriteString(`{"id_token": "oidc-token"}`)
+	subjectTokenFile.Close()
+
+	subjectTokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Metadata") != "True" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.Write([]byte("azure-token\n"))
+	}))
+	defer subjectTokenServer.Close()
+
+	testData := []struct {
+		desc             string
+		credentialSource string
+		impersonate      bool
+		wantSubjectToken string
+		wantToken        string
+		wantError        string
+	}{
+		{
+			desc:             "Subject token from a file in JSON, with impersonation",
+			credentialSource: fmt.Sprintf(`{"file": "%s", "format": {"type": "json", "subject_token_field_name": "id_token"}}`, subjectTokenFile.Name()),
+			impersonate:      true,
+			wantSubjectToken: "oidc-token",
+			wantToken:        "impersonated-token",
+		},
+		{
+			desc:             "Subject token from a url in text, without impersonation",
+			credentialSource: fmt.Sprintf(`{"url": "%s", "headers": {"Metadata": "True"}}`, subjectTokenServer.URL),
+			wantSubjectToken: "azure-token",
+			wantToken:        "federated-token",
+		},
+		{
+			desc:             "Subject token field not found",
+			credentialSource: fmt.Sprintf(`{"file": "%s", "format": {"type": "json", "subject_token_field_name": "token"}}`, subjectTokenFile.Name()),
+			wantError:        "subject token has no field token",
+		},
+		{
+			desc:             "No file or url",
+			credentialSource: `{}`,
+			wantError:        "credential source must have one of file, url and environment_id",
+		},
+		{
+			desc:             "Unsupported format",
+			credentialSource: fmt.Sprintf(`{"file": "%s", "format": {"type": "xml"}}`, subjectTokenFile.Name()),
+			wantError:        `unsupported credential source format type "xml"`,
+		},
+	}
+
+	for _, tc := range testData {
+		server := initFakeGoogleServer(t, tc.wantSubjectToken)
+		impersonationURL := ""
+		if tc.impersonate {
+			impersonationURL = server.URL + "/impersonate"
+		}
+		keyData := fmt.Sprintf(`{
+  "type": "external_account",
+  "audience": "%s",
+  "subject_token_type": "urn:ietf:params:oauth:token-type:jwt",
+  "token_url": "%s/token",
+  "service_account_impersonation_url": "%s",
+  "credential_source": %s
+}`, testAudience, server.URL, impersonationURL, tc.credentialSource)
+
+		if !IsExternalAccount([]byte(keyData)) {
+			t.Errorf("Test Desc(%s): expected external account", tc.desc)
+		}
+		token, err := func() (string, error) {
+			ts, err := ExternalAccountTokenSource([]byte(keyData))
+			if err != nil {
+				return "", err
+			}
+			token, err := ts.Token()
+			if err != nil {
+				return "", err
+			}
+			if token.Expiry.Before(time.Now().Add(59 * time.Minute)) {
+				return "", fmt.Errorf("token expires too early: %v", token.Expiry)
+			}
+			return token.AccessToken, nil
+		}()
+		server.Close()
+
+		if tc.wantError != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.wantError) {
+				t.Errorf("Test Desc(%s): expected error containing %q, got: %v", tc.desc, tc.wantError, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Test Desc(%s): got error: %v", tc.desc, err)
+		} else if token != tc.wantToken {
+			t.Errorf("Test Desc(%s): got token %q, want %q", tc.desc, token, tc.wantToken)
+		}
+	}
+}
+
+func TestAwsSubjectToken(t *testing.T) {
+	awsNow = func() time.Time {
+		return time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	}
+	defer func() { awsNow = time.Now }()
+	for _, env := range []string{"AWS_REGION", "AWS_DEFAULT_REGION", "AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY", "AWS_SESSION_TOKEN"} {
+		defer os.Setenv(env, os.Getenv(env))
+		os.Unsetenv(env)
+	}
+
+	metadataServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/latest/api/token":
+			if r.Method != "PUT" {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				return
+			}
+			w.Write([]byte("session-token"))
+			return
+		}
+		if r.Header.Get("X-aws-ec2-metadata-token") != "session-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/latest/meta-data/placement/availability-zone":
+			w.Write([]byte("us-east-1b"))
+		case "/latest/meta-data/iam/security-credentials":
+			w.Write([]byte("role"))
+		case "/latest/meta-data/iam/security-credentials/role":
+			w.Write([]byte(`{"AccessKeyId": "AKID", "SecretAccessKey": "SECRET", "Token": "TOKEN"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer metadataServer.Close()
+
+	subjectToken, err := awsSubjectToken(credentialSource{
+		EnvironmentID:               "aws1",
+		RegionURL:                   metadataServer.URL + "/latest/meta-data/placement/availability-zone",
+		URL:                         metadataServer.URL + "/latest/meta-data/iam/security-credentials",
+		RegionalCredVerificationURL: "https://sts.{region}.amazonaws.com?Action=GetCallerIdentity&Version=2011-06-15",
+		IMDSv2SessionTokenURL:       metadataServer.URL + "/latest/api/token",
+	}, testAudience)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := url.QueryUnescape(subjectToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := struct {
+		URL     string `json:"url"`
+		Method  string `json:"method"`
+		Headers []struct {
+			Key   string `json:"key"`
+			Value string `json:"value"`
+		} `json:"headers"`
+	}{}
+	if err := json.Unmarshal([]byte(content), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.URL != "https://sts.us-east-1.amazonaws.com?Action=GetCallerIdentity&Version=2011-06-15" || got.Method != "POST" {
+		t.Errorf("got url %q and method %q", got.URL, got.Method)
+	}
+
+	headers := make(map[string]string)
+	for _, h := range got.Headers {
+		headers[h.Key] = h.Value
+	}
+	wantHeaders := map[string]string{
+		"host":                         "sts.us-east-1.amazonaws.com",
+		"x-amz-date":                   "20200102T030405Z",
+		"x-amz-security-token":         "TOKEN",
+		"x-goog-cloud-target-resource": testAudience,
+	}
+	for name, want := range wantHeaders {
+		if headers[name] != want {
+			t.Errorf("got header %s: %q, want %q", name, headers[name], want)
+		}
+	}
+	wantAuthPrefix := "AWS4-HMAC-SHA256 Credential=AKID/20200102/us-east-1/sts/aws4_request, SignedHeaders=host;x-amz-date;x-amz-security-token;x-goog-cloud-target-resource, Signature="
+	if !strings.HasPrefix(headers["Authorization"], wantAuthPrefix) {
+		t.Errorf("got Authorization %q, want prefix %q", headers["Authorization"], wantAuthPrefix)
+	}
+}
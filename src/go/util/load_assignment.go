@@ -22,8 +22,36 @@ import (
 
 // CreateLoadAssignment creates a ClusterLoadAssignment
 func CreateLoadAssignment(hostname string, port uint32) *v2pb.ClusterLoadAssignment {
+	return CreateMultiHostLoadAssignment([]string{hostname}, port)
+}
+
+// CreateMultiHostLoadAssignment creates a ClusterLoadAssignment with an
+// endpoint per hostname, all with the same port.
+func CreateMultiHostLoadAssignment(hostnames []string, port uint32) *v2pb.ClusterLoadAssignment {
+	var endpoints []Endpoint
+	for _, hostname := range hostnames {
+		endpoints = append(endpoints, Endpoint{Hostname: hostname, Port: port})
+	}
+	return CreateEndpointsLoadAssignment(endpoints)
+}
+
+// AddFailoverEndpoints adds an endpoint per hostname, all with the same port,
+// to the load assignment la, each with a lower priority than the previous
+// one, so that the requests fail over to them in order when the endpoints
+// with a higher priority are unhealthy.
+func AddFailoverEndpoints(la *v2pb.ClusterLoadAssignment, hostnames []string, port uint32) {
+	for i, hostname := range hostnames {
+		failover := CreateLoadAssignment(hostname, port).Endpoints[0]
+		failover.Priority = uint32(i + 1)
+		la.Endpoints = append(la.Endpoints, failover)
+	}
+}
+
+// CreatePipeLoadAssignment creates a ClusterLoadAssignment with an endpoint
+// on the Unix domain socket at path.
+func CreatePipeLoadAssignment(path string) *v2pb.ClusterLoadAssignment {
 	return &v2pb.ClusterLoadAssignment{
-		ClusterName: hostname,
+		ClusterName: path,
 		Endpoints: []*endpointpb.LocalityLbEndpoints{
 			{
 				LbEndpoints: []*endpointpb.LbEndpoint{
@@ -31,12 +59,9 @@ func CreateLoadAssignment(hostname string, port uint32) *v2pb.ClusterLoadAssignm
 						HostIdentifier: &endpointpb.LbEndpoint_Endpoint{
 							Endpoint: &endpointpb.Endpoint{
 								Address: &corepb.Address{
-									Address: &corepb.Address_SocketAddress{
-										SocketAddress: &corepb.SocketAddress{
-											Address: hostname,
-											PortSpecifier: &corepb.SocketAddress_PortValue{
-												PortValue: port,
-											},
+									Address: &corepb.Address_Pipe{
+										Pipe: &corepb.Pipe{
+											Path: path,
 										},
 									},
 								},
@@ -48,3 +73,41 @@ func CreateLoadAssignment(hostname string, port uint32) *v2pb.ClusterLoadAssignm
 		},
 	}
 }
+
+// Endpoint is the hostname or IP address, and the port of an endpoint.
+type Endpoint struct {
+	Hostname string
+	Port     uint32
+}
+
+// CreateEndpointsLoadAssignment creates a ClusterLoadAssignment with the
+// endpoints, named after the first one.
+func CreateEndpointsLoadAssignment(endpoints []Endpoint) *v2pb.ClusterLoadAssignment {
+	var lbEndpoints []*endpointpb.LbEndpoint
+	for _, e := range endpoints {
+		lbEndpoints = append(lbEndpoints, &endpointpb.LbEndpoint{
+			HostIdentifier: &endpointpb.LbEndpoint_Endpoint{
+				Endpoint: &endpointpb.Endpoint{
+					Address: &corepb.Address{
+						Address: &corepb.Address_SocketAddress{
+							SocketAddress: &corepb.SocketAddress{
+								Address: e.Hostname,
+								PortSpecifier: &corepb.SocketAddress_PortValue{
+									PortValue: e.Port,
+								},
+							},
+						},
+					},
+				},
+			},
+		})
+	}
+	return &v2pb.ClusterLoadAssignment{
+		ClusterName: endpoints[0].Hostname,
+		Endpoints: []*endpointpb.LocalityLbEndpoints{
+			{
+				LbEndpoints: lbEndpoints,
+			},
+		},
+	}
+}
@@ -25,7 +25,10 @@ import (
 
 	bapb "github.com/GoogleCloudPlatform/esp-v2/src/go/proto/api/envoy/http/backend_auth"
 	drpb "github.com/GoogleCloudPlatform/esp-v2/src/go/proto/api/envoy/http/backend_routing"
+	erpb "github.com/GoogleCloudPlatform/esp-v2/src/go/proto/api/envoy/http/error_response"
+	iapb "github.com/GoogleCloudPlatform/esp-v2/src/go/proto/api/envoy/http/ip_acl"
 	pmpb "github.com/GoogleCloudPlatform/esp-v2/src/go/proto/api/envoy/http/path_matcher"
+	rcpb "github.com/GoogleCloudPlatform/esp-v2/src/go/proto/api/envoy/http/response_cache"
 	scpb "github.com/GoogleCloudPlatform/esp-v2/src/go/proto/api/envoy/http/service_control"
 	authpb "github.com/envoyproxy/go-control-plane/envoy/api/v2/auth"
 	gspb "github.com/envoyproxy/go-control-plane/envoy/config/filter/http/grpc_stats/v2alpha"
@@ -89,6 +92,12 @@ var Resolver = FuncResolver(func(url string) (proto.Message, error) {
 		return new(bapb.FilterConfig), nil
 	case "type.googleapis.com/google.api.envoy.http.backend_routing.FilterConfig":
 		return new(drpb.FilterConfig), nil
+	case "type.googleapis.com/google.api.envoy.http.response_cache.FilterConfig":
+		return new(rcpb.FilterConfig), nil
+	case "type.googleapis.com/google.api.envoy.http.error_response.FilterConfig":
+		return new(erpb.FilterConfig), nil
+	case "type.googleapis.com/google.api.envoy.http.ip_acl.FilterConfig":
+		return new(iapb.FilterConfig), nil
 	case "type.googleapis.com/envoy.config.filter.http.router.v2.Router":
 		return new(routerpb.Router), nil
 	case "type.googleapis.com/envoy.api.v2.auth.UpstreamTlsContext":
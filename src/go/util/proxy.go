@@ -0,0 +1,119 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"golang.org/x/net/http/httpproxy"
+)
+
+// The proxy of the outbound control plane calls: Service Management, the
+// metadata server, IAM, Cloud Pub/Sub, ACME and the OpenID discovery of the
+// JWKS. It is taken from the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment
+// variables until SetProxy is called.
+var proxyFunc = httpproxy.FromEnvironment().ProxyFunc()
+
+// SetProxy sets the proxy used by Proxy and DialThroughProxy. An empty value
+// keeps the one of the environment variable. The proxy URLs may carry the
+// user and password to authenticate to the proxy.
+func SetProxy(httpProxy, httpsProxy, noProxy string) {
+	cfg := httpproxy.FromEnvironment()
+	if httpProxy != "" {
+		cfg.HTTPProxy = httpProxy
+	}
+	if httpsProxy != "" {
+		cfg.HTTPSProxy = httpsProxy
+	}
+	if noProxy != "" {
+		cfg.NoProxy = noProxy
+	}
+	proxyFunc = cfg.ProxyFunc()
+}
+
+// Proxy returns the proxy URL of the request, or nil if it is sent directly.
+// It is meant for the Proxy field of http.Transport, which authenticates to
+// the proxy with the user and password of the URL.
+func Proxy(req *http.Request) (*url.URL, error) {
+	return proxyFunc(req.URL)
+}
+
+// DialThroughProxy dials the address of a TLS connection, through an HTTP
+// CONNECT tunnel if the address goes through the HTTPS proxy. It is meant for
+// the gRPC connections, with grpc.WithContextDialer.
+func DialThroughProxy(ctx context.Context, addr string) (net.Conn, error) {
+	proxyURL, err := proxyFunc(&url.URL{Scheme: "https", Host: addr})
+	if err != nil {
+		return nil, fmt.Errorf("fail to get the proxy of %s: %v", addr, err)
+	}
+	var d net.Dialer
+	if proxyURL == nil {
+		return d.DialContext(ctx, "tcp", addr)
+	}
+
+	if proxyURL.Scheme != "http" && proxyURL.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported scheme %q of the proxy %s for gRPC", proxyURL.Scheme, proxyURL.Host)
+	}
+	proxyAddr := proxyURL.Host
+	if proxyURL.Port() == "" {
+		proxyAddr = net.JoinHostPort(proxyURL.Hostname(), map[string]string{"http": "80", "https": "443"}[proxyURL.Scheme])
+	}
+	conn, err := d.DialContext(ctx, "tcp", proxyAddr)
+	if err != nil {
+		return nil, fmt.Errorf("fail to connect to the proxy %s: %v", proxyURL.Host, err)
+	}
+	if proxyURL.Scheme == "https" {
+		conn = tls.Client(conn, &tls.Config{ServerName: proxyURL.Hostname()})
+	}
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Host: addr},
+		Host:   addr,
+		Header: http.Header{},
+	}
+	if u := proxyURL.User; u != nil {
+		password, _ := u.Password()
+		req.Header.Set("Proxy-Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(u.Username()+":"+password)))
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+		defer conn.SetDeadline(time.Time{})
+	}
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("fail to send CONNECT to the proxy %s: %v", proxyURL.Host, err)
+	}
+	// The server does not send anything before the TLS handshake, so nothing
+	// past the response is buffered.
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("fail to read the CONNECT response of the proxy %s: %v", proxyURL.Host, err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("proxy %s rejects CONNECT to %s: %s", proxyURL.Host, addr, resp.Status)
+	}
+	return conn, nil
+}
@@ -0,0 +1,111 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+
+	authpb "github.com/envoyproxy/go-control-plane/envoy/api/v2/auth"
+)
+
+// The TLS versions of the outbound TLS settings, named as in Envoy.
+var tlsVersions = map[string]struct {
+	envoy  authpb.TlsParameters_TlsProtocol
+	golang uint16
+}{
+	"TLSv1_0": {authpb.TlsParameters_TLSv1_0, tls.VersionTLS10},
+	"TLSv1_1": {authpb.TlsParameters_TLSv1_1, tls.VersionTLS11},
+	"TLSv1_2": {authpb.TlsParameters_TLSv1_2, tls.VersionTLS12},
+	"TLSv1_3": {authpb.TlsParameters_TLSv1_3, tls.VersionTLS13},
+}
+
+// ValidateTlsVersions returns an error if minVersion or maxVersion is not a
+// TLS version, or if minVersion is above maxVersion. Empty values are valid.
+func ValidateTlsVersions(minVersion, maxVersion string) error {
+	min, err := goTlsVersion(minVersion)
+	if err != nil {
+		return err
+	}
+	max, err := goTlsVersion(maxVersion)
+	if err != nil {
+		return err
+	}
+	if min != 0 && max != 0 && min > max {
+		return fmt.Errorf("minimum TLS version %s is above the maximum TLS version %s", minVersion, maxVersion)
+	}
+	return nil
+}
+
+func envoyTlsVersion(version string) (authpb.TlsParameters_TlsProtocol, error) {
+	if version == "" {
+		return authpb.TlsParameters_TLS_AUTO, nil
+	}
+	v, ok := tlsVersions[version]
+	if !ok {
+		return authpb.TlsParameters_TLS_AUTO, fmt.Errorf(`invalid TLS version %q, must be one of "TLSv1_0", "TLSv1_1", "TLSv1_2" or "TLSv1_3"`, version)
+	}
+	return v.envoy, nil
+}
+
+func goTlsVersion(version string) (uint16, error) {
+	if version == "" {
+		return 0, nil
+	}
+	v, ok := tlsVersions[version]
+	if !ok {
+		return 0, fmt.Errorf(`invalid TLS version %q, must be one of "TLSv1_0", "TLSv1_1", "TLSv1_2" or "TLSv1_3"`, version)
+	}
+	return v.golang, nil
+}
+
+// NewClientTLSConfig returns the TLS config of the outbound calls of the
+// config manager, validating the servers with the CA certificates in caPath,
+// presenting the client certificate in certPath and keyPath if set, and
+// limited to the TLS versions between minVersion and maxVersion if set.
+func NewClientTLSConfig(caPath, certPath, keyPath, minVersion, maxVersion string) (*tls.Config, error) {
+	caCert, err := ioutil.ReadFile(caPath)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &tls.Config{RootCAs: x509.NewCertPool()}
+	cfg.RootCAs.AppendCertsFromPEM(caCert)
+	if certPath != "" {
+		cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	if cfg.MinVersion, err = goTlsVersion(minVersion); err != nil {
+		return nil, err
+	}
+	if cfg.MaxVersion, err = goTlsVersion(maxVersion); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// The TLS config of the JWKS fetches of the config manager, or nil to use
+// the system CA certificates.
+var jwksTLSConfig *tls.Config
+
+// SetJwksTLSConfig sets the TLS config of the JWKS and OpenID discovery
+// fetches of the config manager.
+func SetJwksTLSConfig(cfg *tls.Config) {
+	jwksTLSConfig = cfg
+}
@@ -0,0 +1,86 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+const (
+	// Follow the similar logic as GCE metadata server, where returned token
+	// will be valid for at least 60s.
+	tokenMinValidity = 60 * time.Second
+	// A token expiring within tokenRefreshMargin is refreshed proactively, and
+	// still used if the refresh fails.
+	tokenRefreshMargin = 5 * time.Minute
+	// Min interval between proactive refreshes, as the token source may return
+	// the same token until it is closer to expiration.
+	tokenRefreshInterval = 30 * time.Second
+)
+
+// TokenCache caches an access token until it is about to expire. The zero
+// value is an empty cache.
+//
+// Without a valid token, the token is fetched holding the lock, so concurrent
+// callers wait for a single fetch. When the token expires within 5 minutes,
+// one caller refreshes it while the others keep using the cached token.
+type TokenCache struct {
+	mu          sync.Mutex
+	token       string
+	expiry      time.Time
+	refreshing  bool
+	lastRefresh time.Time
+}
+
+// Token returns the cached token and its remaining lifetime, calling fetch
+// when the token needs to be refreshed.
+func (c *TokenCache) Token(now time.Time, fetch func() (string, time.Duration, error)) (string, time.Duration, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.token == "" || now.After(c.expiry.Add(-tokenMinValidity)) {
+		token, expiresIn, err := fetch()
+		if err != nil {
+			return "", 0, err
+		}
+		c.token, c.expiry = token, now.Add(expiresIn)
+		return token, expiresIn, nil
+	}
+
+	if now.After(c.expiry.Add(-tokenRefreshMargin)) && !c.refreshing && now.Sub(c.lastRefresh) >= tokenRefreshInterval {
+		c.refreshing = true
+		c.mu.Unlock()
+		token, expiresIn, err := fetch()
+		c.mu.Lock()
+		c.refreshing = false
+		c.lastRefresh = now
+		if err == nil {
+			c.token, c.expiry = token, now.Add(expiresIn)
+			return token, expiresIn, nil
+		}
+		glog.Warningf("fail to refresh access token, using the cached token expiring at %v: %v", c.expiry, err)
+	}
+	return c.token, c.expiry.Sub(now), nil
+}
+
+// Set sets the cached token.
+func (c *TokenCache) Set(token string, expiry time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.token, c.expiry = token, expiry
+}
@@ -0,0 +1,130 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTokenCache(t *testing.T) {
+	now := time.Now()
+	testData := []struct {
+		desc          string
+		curToken      string
+		curExpiry     time.Time
+		lastRefresh   time.Time
+		fetchErr      error
+		wantToken     string
+		wantExpiresIn time.Duration
+		wantFetches   int
+		wantError     bool
+	}{
+		{
+			desc:          "empty cache is fetched",
+			wantToken:     "ya29.new",
+			wantExpiresIn: time.Hour,
+			wantFetches:   1,
+		},
+		{
+			desc:          "valid token is cached",
+			curToken:      "ya29.cached",
+			curExpiry:     now.Add(10 * time.Minute),
+			wantToken:     "ya29.cached",
+			wantExpiresIn: 10 * time.Minute,
+		},
+		{
+			desc:          "token expiring within 5 minutes is refreshed",
+			curToken:      "ya29.cached",
+			curExpiry:     now.Add(4 * time.Minute),
+			wantToken:     "ya29.new",
+			wantExpiresIn: time.Hour,
+			wantFetches:   1,
+		},
+		{
+			desc:          "cached token is used when the refresh fails",
+			curToken:      "ya29.cached",
+			curExpiry:     now.Add(4 * time.Minute),
+			fetchErr:      fmt.Errorf("metadata server is unavailable"),
+			wantToken:     "ya29.cached",
+			wantExpiresIn: 4 * time.Minute,
+			wantFetches:   1,
+		},
+		{
+			desc:          "token is not refreshed again within 30 seconds",
+			curToken:      "ya29.cached",
+			curExpiry:     now.Add(4 * time.Minute),
+			lastRefresh:   now.Add(-10 * time.Second),
+			wantToken:     "ya29.cached",
+			wantExpiresIn: 4 * time.Minute,
+		},
+		{
+			desc:        "token valid for less than 60 seconds is not used when the fetch fails",
+			curToken:    "ya29.cached",
+			curExpiry:   now.Add(59 * time.Second),
+			fetchErr:    fmt.Errorf("metadata server is unavailable"),
+			wantFetches: 1,
+			wantError:   true,
+		},
+	}
+
+	for _, tc := range testData {
+		c := &TokenCache{token: tc.curToken, expiry: tc.curExpiry, lastRefresh: tc.lastRefresh}
+		fetches := 0
+		token, expiresIn, err := c.Token(now, func() (string, time.Duration, error) {
+			fetches++
+			if tc.fetchErr != nil {
+				return "", 0, tc.fetchErr
+			}
+			return "ya29.new", time.Hour, nil
+		})
+		if (err != nil) != tc.wantError {
+			t.Errorf("Test Desc(%s): got error %v, want error: %v", tc.desc, err, tc.wantError)
+		}
+		if token != tc.wantToken || expiresIn != tc.wantExpiresIn {
+			t.Errorf("Test Desc(%s): got token %q expiring in %v, want %q expiring in %v", tc.desc, token, expiresIn, tc.wantToken, tc.wantExpiresIn)
+		}
+		if fetches != tc.wantFetches {
+			t.Errorf("Test Desc(%s): got %d fetches, want %d", tc.desc, fetches, tc.wantFetches)
+		}
+	}
+}
+
+func TestTokenCacheConcurrentFetches(t *testing.T) {
+	c := &TokenCache{}
+	var fetches int32
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			token, _, err := c.Token(time.Now(), func() (string, time.Duration, error) {
+				atomic.AddInt32(&fetches, 1)
+				time.Sleep(10 * time.Millisecond)
+				return "ya29.new", time.Hour, nil
+			})
+			if token != "ya29.new" || err != nil {
+				t.Errorf("got token %q, error %v", token, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if fetches != 1 {
+		t.Errorf("got %d concurrent fetches, want 1", fetches)
+	}
+}
@@ -16,6 +16,7 @@ package util
 
 import (
 	"io/ioutil"
+	"sync"
 	"time"
 
 	"golang.org/x/oauth2"
@@ -26,7 +27,9 @@ var (
 	_GOOGLE_API_SCOPE = []string{
 		"https://www.googleapis.com/auth/service.management.readonly",
 	}
-	tokenCache = &oauth2.Token{}
+
+	// Key data -> *TokenCache.
+	tokenCaches sync.Map
 )
 
 func GenerateAccessTokenFromFile(serviceAccountKey string) (string, time.Duration, error) {
@@ -39,21 +42,31 @@ func GenerateAccessTokenFromFile(serviceAccountKey string) (string, time.Duratio
 }
 
 func generateAccessToken(keyData []byte) (string, time.Duration, error) {
-	now := time.Now()
-	// Follow the similar logic as GCE metadata server, where returned token will be valid for at
-	// least 60s.
-	if tokenCache.AccessToken != "" && !now.After(tokenCache.Expiry.Add(-time.Second*60)) {
-		return tokenCache.AccessToken, tokenCache.Expiry.Sub(now), nil
-	}
-	creds, err := google.CredentialsFromJSON(oauth2.NoContext, keyData, _GOOGLE_API_SCOPE...)
-	if err != nil {
-		return "", 0, err
+	cache, _ := tokenCaches.LoadOrStore(string(keyData), &TokenCache{})
+	return cache.(*TokenCache).Token(time.Now(), func() (string, time.Duration, error) {
+		return fetchAccessToken(keyData)
+	})
+}
+
+func fetchAccessToken(keyData []byte) (string, time.Duration, error) {
+	var tokenSource oauth2.TokenSource
+	if IsExternalAccount(keyData) {
+		ts, err := ExternalAccountTokenSource(keyData)
+		if err != nil {
+			return "", 0, err
+		}
+		tokenSource = ts
+	} else {
+		creds, err := google.CredentialsFromJSON(oauth2.NoContext, keyData, _GOOGLE_API_SCOPE...)
+		if err != nil {
+			return "", 0, err
+		}
+		tokenSource = creds.TokenSource
 	}
 
-	token, err := creds.TokenSource.Token()
+	token, err := tokenSource.Token()
 	if err != nil {
 		return "", 0, err
 	}
-	tokenCache = token
 	return token.AccessToken, token.Expiry.Sub(time.Now()), nil
 }
@@ -22,6 +22,7 @@ import (
 
 	authpb "github.com/envoyproxy/go-control-plane/envoy/api/v2/auth"
 	corepb "github.com/envoyproxy/go-control-plane/envoy/api/v2/core"
+	wrapperspb "github.com/golang/protobuf/ptypes/wrappers"
 )
 
 const (
@@ -65,24 +66,73 @@ func CreateUpstreamTransportSocket(hostname, rootCertsPath, sslClientPath string
 	}, nil
 }
 
-// CreateDownstreamTransportSocket creates a TransportSocket for Downstream
-func CreateDownstreamTransportSocket(sslServerPath string) (*corepb.TransportSocket, error) {
-	if sslServerPath == "" {
-		return nil, fmt.Errorf("SSL path cannot be empty.")
+// CreateUpstreamMtlsTransportSocket creates a TransportSocket for Upstream
+// with the client certificate in certPath and keyPath. The server certificate
+// is validated with the CA certificates in caPath and, if set, must have one
+// of subjectAltNames.
+func CreateUpstreamMtlsTransportSocket(hostname, caPath, certPath, keyPath string, subjectAltNames, alpnProtocols []string) (*corepb.TransportSocket, error) {
+	return createUpstreamMtlsTransportSocket(hostname, caPath, certPath, keyPath, subjectAltNames, alpnProtocols, nil)
+}
+
+// CreateOutboundTransportSocket creates a TransportSocket for Upstream to a
+// dependency of the proxy, like CreateUpstreamMtlsTransportSocket, limited
+// to the TLS versions between minVersion and maxVersion if set.
+func CreateOutboundTransportSocket(hostname, caPath, certPath, keyPath, minVersion, maxVersion string) (*corepb.TransportSocket, error) {
+	var tlsParams *authpb.TlsParameters
+	if minVersion != "" || maxVersion != "" {
+		tlsParams = &authpb.TlsParameters{}
+		var err error
+		if tlsParams.TlsMinimumProtocolVersion, err = envoyTlsVersion(minVersion); err != nil {
+			return nil, err
+		}
+		if tlsParams.TlsMaximumProtocolVersion, err = envoyTlsVersion(maxVersion); err != nil {
+			return nil, err
+		}
 	}
+	return createUpstreamMtlsTransportSocket(hostname, caPath, certPath, keyPath, nil, nil, tlsParams)
+}
 
-	sslFileName := defaultServerSslFilename
-	// Backward compatible for ESPv1
-	if strings.Contains(sslServerPath, "/etc/nginx/ssl") {
-		sslFileName = "nginx"
+func createUpstreamMtlsTransportSocket(hostname, caPath, certPath, keyPath string, subjectAltNames, alpnProtocols []string, tlsParams *authpb.TlsParameters) (*corepb.TransportSocket, error) {
+	if caPath == "" {
+		return nil, fmt.Errorf("CA path cannot be empty.")
+	}
+	if (certPath == "") != (keyPath == "") {
+		return nil, fmt.Errorf("certificate path and key path must be set together.")
 	}
 
-	common_tls, err := createCommonTlsContext("", sslServerPath, sslFileName)
-	if err != nil {
-		return nil, err
+	common_tls := &authpb.CommonTlsContext{
+		AlpnProtocols: alpnProtocols,
+		TlsParams:     tlsParams,
+		ValidationContextType: &authpb.CommonTlsContext_ValidationContext{
+			ValidationContext: &authpb.CertificateValidationContext{
+				TrustedCa: &corepb.DataSource{
+					Specifier: &corepb.DataSource_Filename{
+						Filename: caPath,
+					},
+				},
+				VerifySubjectAltName: subjectAltNames,
+			},
+		},
 	}
-	common_tls.AlpnProtocols = []string{"h2", "http/1.1"}
-	tlsContext, err := ptypes.MarshalAny(&authpb.DownstreamTlsContext{
+	if certPath != "" {
+		common_tls.TlsCertificates = []*authpb.TlsCertificate{
+			{
+				CertificateChain: &corepb.DataSource{
+					Specifier: &corepb.DataSource_Filename{
+						Filename: certPath,
+					},
+				},
+				PrivateKey: &corepb.DataSource{
+					Specifier: &corepb.DataSource_Filename{
+						Filename: keyPath,
+					},
+				},
+			},
+		}
+	}
+
+	tlsContext, err := ptypes.MarshalAny(&authpb.UpstreamTlsContext{
+		Sni:              hostname,
 		CommonTlsContext: common_tls,
 	},
 	)
@@ -97,6 +147,124 @@ func CreateDownstreamTransportSocket(sslServerPath string) (*corepb.TransportSoc
 	}, nil
 }
 
+// CreateDownstreamTransportSocket creates a TransportSocket for Downstream.
+// If clientCaPath is set, the client certificates are requested and validated
+// with the CA certificates in it, and required with requireClientCert.
+func CreateDownstreamTransportSocket(sslServerPath, clientCaPath string, requireClientCert bool) (*corepb.TransportSocket, error) {
+	return createDownstreamTransportSocket(TLSTransportSocket, sslServerPath, clientCaPath, requireClientCert, []string{"h2", "http/1.1"})
+}
+
+// CreateSniDownstreamTransportSocket creates a TransportSocket for Downstream
+// with the certificate in certPath and keyPath, served by server name. The
+// client certificates are validated as in CreateDownstreamTransportSocket.
+func CreateSniDownstreamTransportSocket(certPath, keyPath, clientCaPath string, requireClientCert bool) (*corepb.TransportSocket, error) {
+	if certPath == "" || keyPath == "" {
+		return nil, fmt.Errorf("certificate path and key path cannot be empty.")
+	}
+
+	common_tls, err := createCommonTlsContext(clientCaPath, "", "")
+	if err != nil {
+		return nil, err
+	}
+	common_tls.TlsCertificates = []*authpb.TlsCertificate{
+		{
+			CertificateChain: &corepb.DataSource{
+				Specifier: &corepb.DataSource_Filename{
+					Filename: certPath,
+				},
+			},
+			PrivateKey: &corepb.DataSource{
+				Specifier: &corepb.DataSource_Filename{
+					Filename: keyPath,
+				},
+			},
+		},
+	}
+	common_tls.AlpnProtocols = []string{"h2", "http/1.1"}
+	return makeDownstreamTransportSocket(TLSTransportSocket, common_tls, requireClientCert)
+}
+
+// CreateQuicDownstreamTransportSocket creates a TransportSocket for Downstream
+// HTTP/3 over QUIC, which requires TLS. The client certificates are not
+// requested, as QUIC does not support them in Envoy.
+func CreateQuicDownstreamTransportSocket(sslServerPath string) (*corepb.TransportSocket, error) {
+	return createDownstreamTransportSocket(QUICTransportSocket, sslServerPath, "", false, []string{Http3AlpnProtocol})
+}
+
+// CreateSdsDownstreamTransportSocket creates a TransportSocket for Downstream
+// with the server certificate sent by the config manager over SDS, so it is
+// rotated without draining the listener. The client certificates are
+// validated as in CreateDownstreamTransportSocket.
+func CreateSdsDownstreamTransportSocket(clientCaPath string, requireClientCert bool) (*corepb.TransportSocket, error) {
+	common_tls, err := createCommonTlsContext(clientCaPath, "", "")
+	if err != nil {
+		return nil, err
+	}
+	common_tls.TlsCertificateSdsSecretConfigs = []*authpb.SdsSecretConfig{
+		{
+			Name: ServerCertSecretName,
+			SdsConfig: &corepb.ConfigSource{
+				ConfigSourceSpecifier: &corepb.ConfigSource_Ads{
+					Ads: &corepb.AggregatedConfigSource{},
+				},
+			},
+		},
+	}
+	common_tls.AlpnProtocols = []string{"h2", "http/1.1"}
+	return makeDownstreamTransportSocket(TLSTransportSocket, common_tls, requireClientCert)
+}
+
+// ServerSslFiles returns the paths to the certificate and key in sslServerPath.
+func ServerSslFiles(sslServerPath string) (string, string) {
+	sslFileName := ServerSslFileName(sslServerPath)
+	if !strings.HasSuffix(sslServerPath, "/") {
+		sslServerPath = fmt.Sprintf("%s/", sslServerPath)
+	}
+	return fmt.Sprintf("%s%s.crt", sslServerPath, sslFileName), fmt.Sprintf("%s%s.key", sslServerPath, sslFileName)
+}
+
+func createDownstreamTransportSocket(name, sslServerPath, clientCaPath string, requireClientCert bool, alpnProtocols []string) (*corepb.TransportSocket, error) {
+	if sslServerPath == "" {
+		return nil, fmt.Errorf("SSL path cannot be empty.")
+	}
+
+	common_tls, err := createCommonTlsContext(clientCaPath, sslServerPath, ServerSslFileName(sslServerPath))
+	if err != nil {
+		return nil, err
+	}
+	common_tls.AlpnProtocols = alpnProtocols
+	return makeDownstreamTransportSocket(name, common_tls, requireClientCert)
+}
+
+// ServerSslFileName returns the name of the certificate and key files in the
+// directory of the server certificate, without their extension.
+func ServerSslFileName(sslServerPath string) string {
+	// Backward compatible for ESPv1
+	if strings.Contains(sslServerPath, "/etc/nginx/ssl") {
+		return "nginx"
+	}
+	return defaultServerSslFilename
+}
+
+func makeDownstreamTransportSocket(name string, common_tls *authpb.CommonTlsContext, requireClientCert bool) (*corepb.TransportSocket, error) {
+	downstreamTlsContext := &authpb.DownstreamTlsContext{
+		CommonTlsContext: common_tls,
+	}
+	if requireClientCert {
+		downstreamTlsContext.RequireClientCertificate = &wrapperspb.BoolValue{Value: true}
+	}
+	tlsContext, err := ptypes.MarshalAny(downstreamTlsContext)
+	if err != nil {
+		return nil, err
+	}
+	return &corepb.TransportSocket{
+		Name: name,
+		ConfigType: &corepb.TransportSocket_TypedConfig{
+			TypedConfig: tlsContext,
+		},
+	}, nil
+}
+
 func createCommonTlsContext(rootCertsPath, sslPath, sslFileName string) (*authpb.CommonTlsContext, error) {
 	common_tls := &authpb.CommonTlsContext{}
 	// Add TLS certificate
@@ -15,6 +15,7 @@
 package util
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/golang/protobuf/jsonpb"
@@ -128,10 +129,207 @@ func TestCreateUpstreamTransportSocket(t *testing.T) {
 	}
 }
 
+func TestCreateUpstreamMtlsTransportSocket(t *testing.T) {
+	testData := []struct {
+		desc                string
+		caPath              string
+		certPath            string
+		keyPath             string
+		subjectAltNames     []string
+		wantTransportSocket string
+		wantError           string
+	}{
+		{
+			desc:            "Upstream Transport Socket with client certificate and SAN verification",
+			caPath:          "/etc/backend/ca.crt",
+			certPath:        "/etc/backend/client.crt",
+			keyPath:         "/etc/backend/client.key",
+			subjectAltNames: []string{"backend.example.com"},
+			wantTransportSocket: `{
+				"name":"envoy.transport_sockets.tls",
+				"typedConfig":{
+					"@type":"type.googleapis.com/envoy.api.v2.auth.UpstreamTlsContext",
+					"commonTlsContext":{
+						"tlsCertificates":[
+							{
+								"certificateChain":{
+									"filename":"/etc/backend/client.crt"
+								},
+								"privateKey":{
+									"filename":"/etc/backend/client.key"
+								}
+							}
+						],
+						"validationContext":{
+							"trustedCa":{
+								"filename":"/etc/backend/ca.crt"
+							},
+							"verifySubjectAltName":["backend.example.com"]
+						}
+					},
+					"sni":"backend.example.com"
+				}
+			}`,
+		},
+		{
+			desc:   "Upstream Transport Socket with custom CA only",
+			caPath: "/etc/backend/ca.crt",
+			wantTransportSocket: `{
+				"name":"envoy.transport_sockets.tls",
+				"typedConfig":{
+					"@type":"type.googleapis.com/envoy.api.v2.auth.UpstreamTlsContext",
+					"commonTlsContext":{
+						"validationContext":{
+							"trustedCa":{
+								"filename":"/etc/backend/ca.crt"
+							}
+						}
+					},
+					"sni":"backend.example.com"
+				}
+			}`,
+		},
+		{
+			desc:      "Failure, certificate without key",
+			caPath:    "/etc/backend/ca.crt",
+			certPath:  "/etc/backend/client.crt",
+			wantError: "must be set together",
+		},
+		{
+			desc:      "Failure, empty CA path",
+			wantError: "CA path cannot be empty",
+		},
+	}
+
+	for i, tc := range testData {
+		gotTransportSocket, err := CreateUpstreamMtlsTransportSocket("backend.example.com", tc.caPath, tc.certPath, tc.keyPath, tc.subjectAltNames, nil)
+		if tc.wantError != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.wantError) {
+				t.Errorf("Test Desc(%d): %s, got error %v, want error containing %q", i, tc.desc, err, tc.wantError)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		marshaler := &jsonpb.Marshaler{}
+		gotConfig, err := marshaler.MarshalToString(gotTransportSocket)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := JsonEqual(tc.wantTransportSocket, gotConfig); err != nil {
+			t.Errorf("Test Desc(%d): %s, CreateUpstreamMtlsTransportSocket failed,\n %v", i, tc.desc, err)
+		}
+	}
+}
+
+func TestCreateOutboundTransportSocket(t *testing.T) {
+	testData := []struct {
+		desc                string
+		minVersion          string
+		maxVersion          string
+		wantTransportSocket string
+		wantError           string
+	}{
+		{
+			desc:       "Outbound Transport Socket with TLS versions",
+			minVersion: "TLSv1_2",
+			maxVersion: "TLSv1_3",
+			wantTransportSocket: `{
+				"name":"envoy.transport_sockets.tls",
+				"typedConfig":{
+					"@type":"type.googleapis.com/envoy.api.v2.auth.UpstreamTlsContext",
+					"commonTlsContext":{
+						"tlsParams":{
+							"tlsMinimumProtocolVersion":"TLSv1_2",
+							"tlsMaximumProtocolVersion":"TLSv1_3"
+						},
+						"tlsCertificates":[
+							{
+								"certificateChain":{
+									"filename":"/etc/iam/client.crt"
+								},
+								"privateKey":{
+									"filename":"/etc/iam/client.key"
+								}
+							}
+						],
+						"validationContext":{
+							"trustedCa":{
+								"filename":"/etc/iam/ca.crt"
+							}
+						}
+					},
+					"sni":"iam.example.com"
+				}
+			}`,
+		},
+		{
+			desc:       "Outbound Transport Socket with the minimum TLS version only",
+			minVersion: "TLSv1_2",
+			wantTransportSocket: `{
+				"name":"envoy.transport_sockets.tls",
+				"typedConfig":{
+					"@type":"type.googleapis.com/envoy.api.v2.auth.UpstreamTlsContext",
+					"commonTlsContext":{
+						"tlsParams":{
+							"tlsMinimumProtocolVersion":"TLSv1_2"
+						},
+						"tlsCertificates":[
+							{
+								"certificateChain":{
+									"filename":"/etc/iam/client.crt"
+								},
+								"privateKey":{
+									"filename":"/etc/iam/client.key"
+								}
+							}
+						],
+						"validationContext":{
+							"trustedCa":{
+								"filename":"/etc/iam/ca.crt"
+							}
+						}
+					},
+					"sni":"iam.example.com"
+				}
+			}`,
+		},
+		{
+			desc:       "Failure, invalid TLS version",
+			minVersion: "TLSv2",
+			wantError:  `invalid TLS version "TLSv2"`,
+		},
+	}
+
+	for i, tc := range testData {
+		gotTransportSocket, err := CreateOutboundTransportSocket("iam.example.com", "/etc/iam/ca.crt", "/etc/iam/client.crt", "/etc/iam/client.key", tc.minVersion, tc.maxVersion)
+		if tc.wantError != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.wantError) {
+				t.Errorf("Test Desc(%d): %s, got error %v, want error containing %q", i, tc.desc, err, tc.wantError)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		marshaler := &jsonpb.Marshaler{}
+		gotConfig, err := marshaler.MarshalToString(gotTransportSocket)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := JsonEqual(tc.wantTransportSocket, gotConfig); err != nil {
+			t.Errorf("Test Desc(%d): %s, CreateOutboundTransportSocket failed,\n %v", i, tc.desc, err)
+		}
+	}
+}
+
 func TestCreateDownstreamTransportSocket(t *testing.T) {
 	testData := []struct {
 		desc                string
 		sslPath             string
+		clientCaPath        string
+		requireClientCert   bool
 		wantTransportSocket string
 	}{
 		{
@@ -180,10 +378,41 @@ func TestCreateDownstreamTransportSocket(t *testing.T) {
 				}
 			}`,
 		},
+		{
+			desc:              "Downstream Transport Socket for TLS, requiring client certificates",
+			sslPath:           "/etc/ssl/endpoints/",
+			clientCaPath:      "/etc/ssl/clients/ca.crt",
+			requireClientCert: true,
+			wantTransportSocket: `{
+				"name":"envoy.transport_sockets.tls",
+				"typedConfig":{
+					"@type":"type.googleapis.com/envoy.api.v2.auth.DownstreamTlsContext",
+					"commonTlsContext":{
+						"alpnProtocols":["h2","http/1.1"],
+						"tlsCertificates":[
+							{
+								"certificateChain":{
+									"filename":"/etc/ssl/endpoints/server.crt"
+								},
+								"privateKey":{
+									"filename":"/etc/ssl/endpoints/server.key"
+								}
+							}
+						],
+						"validationContext":{
+							"trustedCa":{
+								"filename":"/etc/ssl/clients/ca.crt"
+							}
+						}
+					},
+					"requireClientCertificate":true
+				}
+			}`,
+		},
 	}
 
 	for i, tc := range testData {
-		gotTransportSocket, err := CreateDownstreamTransportSocket(tc.sslPath)
+		gotTransportSocket, err := CreateDownstreamTransportSocket(tc.sslPath, tc.clientCaPath, tc.requireClientCert)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -21,8 +21,10 @@ import (
 	"net"
 	"net/http"
 	"net/url"
+	"regexp"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // ParseURI parses uri into scheme, hostname, port, path with err(if exist).
@@ -56,6 +58,33 @@ func ParseURI(uri string) (string, string, uint32, string, error) {
 	return u.Scheme, u.Hostname(), uint32(portVal), strings.TrimSuffix(u.RequestURI(), "/"), nil
 }
 
+// ParseUnixSocketURI parses a URI of a Unix domain socket, like
+// unix:/var/run/backend.sock, or grpc+unix:///var/run/backend.sock with the
+// scheme of the protocol, into the scheme, http if not set, and the path of
+// the socket. It returns false if uri is not a Unix domain socket.
+func ParseUnixSocketURI(uri string) (string, string, bool) {
+	i := strings.Index(uri, UnixSocketScheme)
+	if i < 0 {
+		return "", "", false
+	}
+	scheme := "http"
+	if i > 0 {
+		if !strings.HasSuffix(uri[:i], "+") {
+			return "", "", false
+		}
+		scheme = uri[:i-1]
+	}
+	path := uri[i+len(UnixSocketScheme):]
+	// Both unix:/path and unix:///path are accepted.
+	if strings.HasPrefix(path, "//") {
+		path = strings.TrimPrefix(path, "//")
+	}
+	if path == "" {
+		return "", "", false
+	}
+	return scheme, path, true
+}
+
 // ParseBackendProtocol parses a scheme string and http protocol string into BackendProtocol and UseTLS bool.
 func ParseBackendProtocol(scheme string, httpProtocol string) (BackendProtocol, bool, error) {
 	scheme = strings.ToLower(scheme)
@@ -86,10 +115,40 @@ func ParseBackendProtocol(scheme string, httpProtocol string) (BackendProtocol,
 	}
 }
 
+// IsServerlessHost returns true if the hostname is on the Google-managed
+// domain of a Cloud Run service or of Cloud Functions, which only serves TLS.
+func IsServerlessHost(hostname string) bool {
+	hostname = strings.ToLower(hostname)
+	return strings.HasSuffix(hostname, CloudRunDomainSuffix) || strings.HasSuffix(hostname, CloudFunctionsDomainSuffix)
+}
+
+// KubernetesServiceHostname returns the DNS name of the Kubernetes service
+// named by a single-label hostname, like bookstore, in the namespace:
+// {hostname}.{namespace}.svc.{clusterDomain}. Other hostnames, IP addresses,
+// localhost and all hostnames without namespace are returned as is.
+func KubernetesServiceHostname(hostname, namespace, clusterDomain string) string {
+	if namespace == "" || strings.Contains(hostname, ".") || net.ParseIP(hostname) != nil || strings.EqualFold(hostname, "localhost") {
+		return hostname
+	}
+	return fmt.Sprintf("%s.%s.svc.%s", hostname, namespace, clusterDomain)
+}
+
+// ServerlessJwtAudience returns the audience of the ID tokens of the
+// serverless backend at hostname and path: the URL of the Cloud Run service,
+// or of the Cloud Function named by the first segment of the path.
+func ServerlessJwtAudience(hostname, path string) string {
+	if strings.HasSuffix(strings.ToLower(hostname), CloudFunctionsDomainSuffix) {
+		if function := strings.SplitN(strings.TrimPrefix(path, "/"), "/", 2)[0]; function != "" {
+			return fmt.Sprintf("https://%s/%s", hostname, function)
+		}
+	}
+	return fmt.Sprintf("https://%s", hostname)
+}
+
 // Note: the path of openID discovery may be https
 var getRemoteContent = func(path string) ([]byte, error) {
 	req, _ := http.NewRequest("GET", path, nil)
-	client := &http.Client{}
+	client := &http.Client{Transport: &http.Transport{Proxy: Proxy, TLSClientConfig: jwksTLSConfig}}
 	resp, err := client.Do(req)
 
 	if err != nil {
@@ -127,6 +186,29 @@ func ResolveJwksUriUsingOpenID(uri string) (string, error) {
 	return jwksURI, nil
 }
 
+// jwksFetchBackoff is the backoff before the first retry of a JWKS fetch,
+// doubled on each retry.
+var jwksFetchBackoff = time.Second
+
+// FetchJwks fetches the JWKS at jwksUri, retrying the failed fetches.
+func FetchJwks(jwksUri string, retries int) ([]byte, error) {
+	backoff := jwksFetchBackoff
+	for attempt := 0; ; attempt++ {
+		body, err := getRemoteContent(jwksUri)
+		if err == nil && !json.Valid(body) {
+			err = fmt.Errorf("JWKS is not JSON")
+		}
+		if err == nil {
+			return body, nil
+		}
+		if attempt >= retries {
+			return nil, fmt.Errorf("fail to fetch JWKS from %s: %v", jwksUri, err)
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
 func IamIdentityTokenSuffix(IamServiceAccount string) string {
 	return fmt.Sprintf("/v1/projects/-/serviceAccounts/%s:generateIdToken", IamServiceAccount)
 }
@@ -135,6 +217,12 @@ func IamAccessTokenSuffix(IamServiceAccount string) string {
 	return fmt.Sprintf("/v1/projects/-/serviceAccounts/%s:generateAccessToken", IamServiceAccount)
 }
 
+// BackendOAuth2TokenSuffix returns the path of the token agent serving the
+// OAuth2 access tokens of the backend at address, in the format of host:port.
+func BackendOAuth2TokenSuffix(address string) string {
+	return BackendOAuth2TokenPrefix + address
+}
+
 func ExtraAddressFromURI(jwksUri string) (string, error) {
 	_, hostname, port, _, err := ParseURI(jwksUri)
 	if err != nil {
@@ -142,3 +230,38 @@ func ExtraAddressFromURI(jwksUri string) (string, error) {
 	}
 	return fmt.Sprintf("%s:%v", hostname, port), nil
 }
+
+// ParseHeaders parses comma separated "name=value" pairs.
+func ParseHeaders(value string) (map[string]string, error) {
+	headers := make(map[string]string)
+	for _, header := range strings.Split(value, ",") {
+		if header = strings.TrimSpace(header); header == "" {
+			continue
+		}
+		parts := strings.SplitN(header, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return nil, fmt.Errorf("invalid header %q, must be in the form of name=value", header)
+		}
+		headers[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+	return headers, nil
+}
+
+// IsJwtAudiencePattern returns true if the JWT audience is a wildcard pattern,
+// such as `https://*.example.com`, or a regex prefixed by `regex:`.
+func IsJwtAudiencePattern(audience string) bool {
+	return strings.HasPrefix(audience, JwtAudienceRegexPrefix) || strings.Contains(audience, "*")
+}
+
+// JwtAudienceRegex returns the RE2 regex fully matching the JWT audience.
+// A `*` in a wildcard pattern matches any characters but `/`.
+func JwtAudienceRegex(audience string) string {
+	if strings.HasPrefix(audience, JwtAudienceRegexPrefix) {
+		return strings.TrimPrefix(audience, JwtAudienceRegexPrefix)
+	}
+	parts := strings.Split(audience, "*")
+	for i, part := range parts {
+		parts[i] = regexp.QuoteMeta(part)
+	}
+	return strings.Join(parts, "[^/]*")
+}
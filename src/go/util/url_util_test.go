@@ -18,8 +18,10 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/gorilla/mux"
 )
@@ -325,6 +327,57 @@ func TestParseBackendProtocol(t *testing.T) {
 	}
 }
 
+func TestParseUnixSocketURI(t *testing.T) {
+	testData := []struct {
+		desc       string
+		uri        string
+		wantScheme string
+		wantPath   string
+		wantOk     bool
+	}{
+		{
+			desc:       "Unix domain socket defaults to http",
+			uri:        "unix:/var/run/backend.sock",
+			wantScheme: "http",
+			wantPath:   "/var/run/backend.sock",
+			wantOk:     true,
+		},
+		{
+			desc:       "Unix domain socket with the scheme of the protocol",
+			uri:        "grpc+unix:///var/run/backend.sock",
+			wantScheme: "grpc",
+			wantPath:   "/var/run/backend.sock",
+			wantOk:     true,
+		},
+		{
+			desc:       "Abstract Unix domain socket",
+			uri:        "unix:@backend",
+			wantScheme: "http",
+			wantPath:   "@backend",
+			wantOk:     true,
+		},
+		{
+			desc: "Unix domain socket without path",
+			uri:  "unix:",
+		},
+		{
+			desc: "Host named unix",
+			uri:  "http://unix:8080",
+		},
+		{
+			desc: "IP address",
+			uri:  "http://127.0.0.1:8082",
+		},
+	}
+
+	for _, tc := range testData {
+		scheme, path, ok := ParseUnixSocketURI(tc.uri)
+		if scheme != tc.wantScheme || path != tc.wantPath || ok != tc.wantOk {
+			t.Errorf("Test Desc(%s): got (%q, %q, %v), want (%q, %q, %v)", tc.desc, scheme, path, ok, tc.wantScheme, tc.wantPath, tc.wantOk)
+		}
+	}
+}
+
 func TestResolveJwksUriUsingOpenID(t *testing.T) {
 	r := mux.NewRouter()
 	jwksUriEntry, _ := json.Marshal(map[string]string{"jwks_uri": "this-is-jwksUri"})
@@ -401,3 +454,213 @@ func TestExtraAddressFromURI(t *testing.T) {
 		}
 	}
 }
+
+func TestParseHeaders(t *testing.T) {
+	got, err := ParseHeaders("Authorization=Basic a=b, X-Api-Key = key ,")
+	if err != nil {
+		t.Fatalf("ParseHeaders got error: %v", err)
+	}
+	want := map[string]string{"Authorization": "Basic a=b", "X-Api-Key": "key"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseHeaders got %v, want %v", got, want)
+	}
+	if _, err := ParseHeaders("=value"); err == nil {
+		t.Errorf("ParseHeaders got no error for a header without name")
+	}
+}
+
+func TestJwtAudienceRegex(t *testing.T) {
+	testData := []struct {
+		desc        string
+		audience    string
+		wantPattern bool
+		wantRegex   string
+	}{
+		{
+			desc:      "exact audience",
+			audience:  "https://api.example.com",
+			wantRegex: `https://api\.example\.com`,
+		},
+		{
+			desc:        "wildcard audience",
+			audience:    "https://*.example.com",
+			wantPattern: true,
+			wantRegex:   `https://[^/]*\.example\.com`,
+		},
+		{
+			desc:        "regex audience",
+			audience:    "regex:https://(dev|prod)\\.example\\.com",
+			wantPattern: true,
+			wantRegex:   `https://(dev|prod)\.example\.com`,
+		},
+	}
+
+	for i, tc := range testData {
+		if got := IsJwtAudiencePattern(tc.audience); got != tc.wantPattern {
+			t.Errorf("Test Desc(%d): %s, IsJwtAudiencePattern got: %v, want: %v", i, tc.desc, got, tc.wantPattern)
+		}
+		if got := JwtAudienceRegex(tc.audience); got != tc.wantRegex {
+			t.Errorf("Test Desc(%d): %s, JwtAudienceRegex got: %v, want: %v", i, tc.desc, got, tc.wantRegex)
+		}
+	}
+}
+
+func TestServerlessJwtAudience(t *testing.T) {
+	testData := []struct {
+		desc           string
+		hostname       string
+		path           string
+		wantServerless bool
+		wantAudience   string
+	}{
+		{
+			desc:           "Cloud Run service",
+			hostname:       "bookstore-12345-uc.a.run.app",
+			path:           "/v1/shelves",
+			wantServerless: true,
+			wantAudience:   "https://bookstore-12345-uc.a.run.app",
+		},
+		{
+			desc:           "Cloud Function named by the first path segment",
+			hostname:       "us-central1-project.cloudfunctions.net",
+			path:           "/hello/world",
+			wantServerless: true,
+			wantAudience:   "https://us-central1-project.cloudfunctions.net/hello",
+		},
+		{
+			desc:           "Cloud Functions without function name",
+			hostname:       "us-central1-project.cloudfunctions.net",
+			wantServerless: true,
+			wantAudience:   "https://us-central1-project.cloudfunctions.net",
+		},
+		{
+			desc:         "Other host",
+			hostname:     "api.example.com",
+			path:         "/hello",
+			wantAudience: "https://api.example.com",
+		},
+	}
+
+	for i, tc := range testData {
+		if got := IsServerlessHost(tc.hostname); got != tc.wantServerless {
+			t.Errorf("Test Desc(%d): %s, IsServerlessHost got: %v, want: %v", i, tc.desc, got, tc.wantServerless)
+		}
+		if got := ServerlessJwtAudience(tc.hostname, tc.path); got != tc.wantAudience {
+			t.Errorf("Test Desc(%d): %s, ServerlessJwtAudience got: %v, want: %v", i, tc.desc, got, tc.wantAudience)
+		}
+	}
+}
+
+func TestKubernetesServiceHostname(t *testing.T) {
+	testData := []struct {
+		desc         string
+		hostname     string
+		namespace    string
+		wantHostname string
+	}{
+		{
+			desc:         "Service in the namespace",
+			hostname:     "bookstore",
+			namespace:    "prod",
+			wantHostname: "bookstore.prod.svc.cluster.local",
+		},
+		{
+			desc:         "Fully qualified hostname",
+			hostname:     "bookstore.staging.svc.cluster.local",
+			namespace:    "prod",
+			wantHostname: "bookstore.staging.svc.cluster.local",
+		},
+		{
+			desc:         "Service in another namespace",
+			hostname:     "bookstore.staging",
+			namespace:    "prod",
+			wantHostname: "bookstore.staging",
+		},
+		{
+			desc:         "Localhost",
+			hostname:     "localhost",
+			namespace:    "prod",
+			wantHostname: "localhost",
+		},
+		{
+			desc:         "IPv6 address",
+			hostname:     "::1",
+			namespace:    "prod",
+			wantHostname: "::1",
+		},
+		{
+			desc:         "No namespace",
+			hostname:     "bookstore",
+			wantHostname: "bookstore",
+		},
+	}
+
+	for i, tc := range testData {
+		if got := KubernetesServiceHostname(tc.hostname, tc.namespace, "cluster.local"); got != tc.wantHostname {
+			t.Errorf("Test Desc(%d): %s, KubernetesServiceHostname got: %v, want: %v", i, tc.desc, got, tc.wantHostname)
+		}
+	}
+}
+
+func TestFetchJwks(t *testing.T) {
+	jwksFetchBackoff = 0
+	defer func() { jwksFetchBackoff = time.Second }()
+
+	testData := []struct {
+		desc         string
+		failures     int
+		body         string
+		retries      int
+		wantJwks     string
+		wantError    string
+		wantRequests int
+	}{
+		{
+			desc:         "Fetched after a retry",
+			failures:     1,
+			body:         `{"keys": []}`,
+			retries:      1,
+			wantJwks:     `{"keys": []}`,
+			wantRequests: 2,
+		},
+		{
+			desc:         "Failed after all retries",
+			failures:     3,
+			body:         `{"keys": []}`,
+			retries:      2,
+			wantError:    "fail to fetch JWKS from",
+			wantRequests: 3,
+		},
+		{
+			desc:         "Failed with JWKS not in JSON",
+			body:         "not json",
+			wantError:    "JWKS is not JSON",
+			wantRequests: 1,
+		},
+	}
+
+	for i, tc := range testData {
+		requests := 0
+		s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests++
+			if requests <= tc.failures {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			w.Write([]byte(tc.body))
+		}))
+
+		jwks, err := FetchJwks(s.URL, tc.retries)
+		s.Close()
+		if tc.wantError != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.wantError) {
+				t.Errorf("Test Desc(%d): %s, FetchJwks got error: %v, want: %v", i, tc.desc, err, tc.wantError)
+			}
+		} else if string(jwks) != tc.wantJwks {
+			t.Errorf("Test Desc(%d): %s, FetchJwks got: %s, want: %s", i, tc.desc, jwks, tc.wantJwks)
+		}
+		if requests != tc.wantRequests {
+			t.Errorf("Test Desc(%d): %s, FetchJwks sent %d requests, want: %d", i, tc.desc, requests, tc.wantRequests)
+		}
+	}
+}
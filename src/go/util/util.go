@@ -27,6 +27,8 @@ const (
 	GRPCJSONTranscoder = "envoy.grpc_json_transcoder"
 	// GRPCWeb HTTP filter
 	GRPCWeb = "envoy.grpc_web"
+	// Gzip HTTP filter
+	Gzip = "envoy.gzip"
 	// Router HTTP filter
 	Router = "envoy.router"
 	// Health checking HTTP filter
@@ -45,16 +47,85 @@ const (
 	BackendAuth = "envoy.filters.http.backend_auth"
 	// BackendRouting filter.
 	BackendRouting = "envoy.filters.http.backend_routing"
+	// ResponseCache filter.
+	ResponseCache = "envoy.filters.http.response_cache"
+	// ErrorResponse filter.
+	ErrorResponse = "envoy.filters.http.error_response"
+	// IpAcl filter.
+	IpAcl = "envoy.filters.http.ip_acl"
 	// GrpcStats filter name
 	GrpcStatsFilterName = "envoy.filters.http.grpc_stats"
+	// RBAC HTTP filter
+	RBAC = "envoy.filters.http.rbac"
+	// ExtAuthz HTTP filter
+	ExtAuthz = "envoy.filters.http.ext_authz"
+	// RateLimit HTTP filter, calling the rate limit service.
+	RateLimit = "envoy.filters.http.ratelimit"
+	// Fault HTTP filter, injecting delays and aborts.
+	Fault = "envoy.filters.http.fault"
+	// NetworkRBAC network filter
+	NetworkRBAC = "envoy.filters.network.rbac"
+	// TLSInspector listener filter, detecting the server name requested with
+	// SNI.
+	TLSInspector = "envoy.listener.tls_inspector"
+	// ProxyProtocol listener filter.
+	ProxyProtocol = "envoy.listener.proxy_protocol"
 	// TLSTransportSocket is Envoy TLS Transport Socket name.
 	TLSTransportSocket = "envoy.transport_sockets.tls"
+	// ServerCertSecretName is the name of the SDS secret of the server
+	// certificate, sent by the config manager with --ssl_server_cert_sds.
+	ServerCertSecretName = "server_cert"
+	// QUICTransportSocket is Envoy QUIC Transport Socket name.
+	QUICTransportSocket = "envoy.transport_sockets.quic"
+	// QuicListenerName is the UDP listener name of the Envoy QUIC listeners.
+	QuicListenerName = "quiche_quic_listener"
+	// Http3AlpnProtocol is the ALPN protocol of HTTP/3 negotiated by QUIC,
+	// the IETF draft supported by Envoy.
+	Http3AlpnProtocol = "h3-27"
 	// DefaultRootCAPaths is the default certs path.
 	DefaultRootCAPaths = "/etc/ssl/certs/ca-certificates.crt"
 
 	// JwtPayloadMetadataName is the field name passed into metadata
 	JwtPayloadMetadataName = "jwt_payloads"
 
+	// JwtAudienceRegexPrefix prefixes the JWT audiences which are RE2 regexes.
+	JwtAudienceRegexPrefix = "regex:"
+
+	// Suffixes of the Google-managed domains of the serverless backends, Cloud
+	// Run services and Cloud Functions.
+	CloudRunDomainSuffix       = ".run.app"
+	CloudFunctionsDomainSuffix = ".cloudfunctions.net"
+
+	// UnixSocketScheme prefixes the paths of the Unix domain sockets, in the
+	// listener and backend addresses.
+	UnixSocketScheme = "unix:"
+
+	// ForwardedClientCertHeader carries the details of the client certificates
+	// to the backend.
+	ForwardedClientCertHeader = "x-forwarded-client-cert"
+
+	// RequestIdHeader carries the ID of the requests, generated by Envoy.
+	RequestIdHeader = "x-request-id"
+	// RequestIdField is the label of the reported operations and the field of
+	// the JSON access logs with the request ID, with --log_request_id.
+	RequestIdField = "request_id"
+
+	// AccessLogDisabledHeader is added to the requests of the operations not
+	// access logged, skipped by the filter of the access logs.
+	AccessLogDisabledHeader = "x-esp-v2-access-log-disabled"
+
+	// Access loggers of Envoy, writing to a file or sending to a gRPC Access
+	// Log Service.
+	FileAccessLog     = "envoy.file_access_log"
+	HttpGrpcAccessLog = "envoy.http_grpc_access_log"
+
+	// SpiffeIdPrefix prefixes the SPIFFE IDs, followed by their trust domain.
+	SpiffeIdPrefix = "spiffe://"
+
+	// LocalJwksPrefix prefixes the jwks_uri of the JWT providers with local
+	// JWKS files.
+	LocalJwksPrefix = "file://"
+
 	// Supported Http Methods.
 
 	GET     = "GET"
@@ -63,6 +134,7 @@ const (
 	DELETE  = "DELETE"
 	PATCH   = "PATCH"
 	OPTIONS = "OPTIONS"
+	HEAD    = "HEAD"
 	CUSTOM  = "CUSTOM"
 
 	// Rollout strategy
@@ -83,6 +155,10 @@ const (
 	ProjectIDSuffix     = "/v1/project/project-id"
 	ZoneSuffix          = "/v1/instance/zone"
 
+	// Prefix of the paths of the token agent serving the OAuth2 access tokens
+	// of the backends, followed by their host and port.
+	BackendOAuth2TokenPrefix = "/v1/backendOAuth2Token/"
+
 	// b/147591854: This string must NOT have a trailing slash
 	OpenIDDiscoveryCfgURLSuffix = "/.well-known/openid-configuration"
 
@@ -92,9 +168,41 @@ const (
 	// The iam server cluster name.
 	IamServerClusterName = "iam-cluster"
 
+	// The token agent cluster name, serving access tokens of external accounts.
+	TokenAgentClusterName = "token-agent-cluster"
+
 	// The service control server cluster name.
 	ServiceControlClusterName = "service-control-cluster"
 
+	// The path prefix of the REST API of Google Service Control.
+	ServiceControlRestPathPrefix = "/v1/services/"
+
+	// The external authorization server cluster name.
+	ExtAuthzClusterName = "ext-authz-cluster"
+
+	// The rate limit service cluster name.
+	RateLimitServiceClusterName = "rate-limit-service-cluster"
+
+	// The gRPC access log service cluster name.
+	AccessLogServiceClusterName = "access-log-service-cluster"
+
+	// The cluster name of the xDS server of the backend endpoints.
+	BackendEdsServerClusterName = "backend-eds-server-cluster"
+
+	// The ACME challenge cluster name, the config manager serving the ACME
+	// HTTP-01 challenges under AcmeChallengePathPrefix.
+	AcmeChallengeClusterName = "acme-challenge-cluster"
+	AcmeChallengePathPrefix  = "/.well-known/acme-challenge/"
+
+	// The ADS cluster name in the bootstrap, connecting to the config manager.
+	AdsClusterName = "ads_cluster"
+
+	// Path of the Check method of the gRPC health checking protocol.
+	GrpcHealthCheckPath = "/grpc.health.v1.Health/Check"
+
+	// Upgrade type of the WebSocket connections in Envoy.
+	WebsocketUpgradeType = "websocket"
+
 	// Platforms
 
 	GAEFlex = "GAE_FLEX(ESPv2)"
@@ -132,3 +240,13 @@ const (
 	HTTP2
 	GRPC
 )
+
+// TracingCustomTags are the attributes of the requests which the Service
+// Control filter can set as tags on their spans.
+var TracingCustomTags = map[string]bool{
+	"api_name":        true,
+	"api_version":     true,
+	"operation_name":  true,
+	"api_key_project": true,
+	"consumer_id":     true,
+}